@@ -0,0 +1,109 @@
+// Command migrate applies and rolls back the database migrations that are embedded in it.
+//
+// Usage:
+//
+//	migrate up             Apply all pending migrations.
+//	migrate down [N]       Roll back the last N migrations, 1 by default.
+//	migrate status         Show the version of the database and the pending migrations.
+//	migrate force VERSION  Set the version without running migrations, for databases fixed by hand. 0 means none.
+//
+// It reads the database settings like the server does, see the config package.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/vdavid/vmail/backend/internal/config"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/logging"
+	"github.com/vdavid/vmail/backend/internal/migrate"
+	"github.com/vdavid/vmail/backend/migrations"
+)
+
+const usage = "usage: migrate up | down [N] | status | force VERSION"
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal(usage)
+	}
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if err := logging.Setup(os.Stderr, cfg.LogLevel, cfg.LogFormat); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	all, err := migrate.Load(migrations.FS)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	ctx := context.Background()
+	pool, err := db.NewConnection(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.CloseConnection(pool)
+
+	switch os.Args[1] {
+	case "up":
+		applied, err := migrate.Up(ctx, pool, all)
+		if err != nil {
+			log.Fatalf("Failed to migrate up: %v", err)
+		}
+		slog.Info("Applied migrations", "count", applied)
+
+	case "down":
+		steps := 1
+		if len(os.Args) > 2 {
+			steps, err = strconv.Atoi(os.Args[2])
+			if err != nil || steps < 1 {
+				log.Fatalf("N must be a positive number, got %q", os.Args[2])
+			}
+		}
+		rolledBack, err := migrate.Down(ctx, pool, all, steps)
+		if err != nil {
+			log.Fatalf("Failed to migrate down: %v", err)
+		}
+		slog.Info("Rolled back migrations", "count", rolledBack)
+
+	case "status":
+		version, dirty, err := migrate.Version(ctx, pool)
+		if err != nil {
+			log.Fatalf("Failed to get the version: %v", err)
+		}
+		fmt.Printf("Version: %d", version)
+		if dirty {
+			fmt.Print(" (dirty)")
+		}
+		fmt.Println()
+		pending := migrate.Pending(all, version)
+		fmt.Printf("Pending: %d\n", len(pending))
+		for _, m := range pending {
+			fmt.Printf("  %06d_%s\n", m.Version, m.Name)
+		}
+
+	case "force":
+		if len(os.Args) < 3 {
+			log.Fatal(usage)
+		}
+		version, err := strconv.ParseUint(os.Args[2], 10, 64)
+		if err != nil {
+			log.Fatalf("VERSION must be a number, got %q", os.Args[2])
+		}
+		if err := migrate.Force(ctx, pool, version); err != nil {
+			log.Fatalf("Failed to force the version: %v", err)
+		}
+		slog.Info("Set the version", "version", version)
+
+	default:
+		log.Fatal(usage)
+	}
+}
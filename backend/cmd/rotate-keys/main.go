@@ -0,0 +1,46 @@
+// Command rotate-keys re-encrypts all stored credentials with the primary encryption key.
+//
+// To rotate the key, put the new key first in VMAIL_ENCRYPTION_KEYS_BASE64 and keep the old one after it,
+// like "new,old". Deploy that, so that new credentials get the new key and old ones still work. Then run this
+// command with the same configuration, and once it's done, drop the old key.
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"os"
+
+	"github.com/vdavid/vmail/backend/internal/config"
+	"github.com/vdavid/vmail/backend/internal/crypto"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/logging"
+)
+
+func main() {
+	cfg, err := config.NewConfig()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if err := logging.Setup(os.Stderr, cfg.LogLevel, cfg.LogFormat); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	encryptor, err := crypto.NewEncryptorWithKeys(cfg.GetEncryptionKeys())
+	if err != nil {
+		log.Fatalf("Failed to create encryptor: %v", err)
+	}
+
+	ctx := context.Background()
+	pool, err := db.NewConnection(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.CloseConnection(pool)
+
+	changed, err := db.ReencryptCredentials(ctx, pool, encryptor.Reencrypt)
+	if err != nil {
+		log.Fatalf("Failed to re-encrypt credentials, nothing changed: %v", err)
+	}
+	slog.Info("Re-encrypted credentials with the primary key", "count", changed, "keys", len(cfg.GetEncryptionKeys()))
+}
@@ -2,19 +2,39 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/activity"
 	"github.com/vdavid/vmail/backend/internal/api"
 	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/autoconfig"
 	"github.com/vdavid/vmail/backend/internal/config"
 	"github.com/vdavid/vmail/backend/internal/crypto"
 	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imageproxy"
 	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/logging"
+	"github.com/vdavid/vmail/backend/internal/migrate"
+	"github.com/vdavid/vmail/backend/internal/oauth"
+	"github.com/vdavid/vmail/backend/internal/outbox"
+	"github.com/vdavid/vmail/backend/internal/push"
+	"github.com/vdavid/vmail/backend/internal/ratelimit"
+	"github.com/vdavid/vmail/backend/internal/scheduler"
+	"github.com/vdavid/vmail/backend/internal/smtp"
+	"github.com/vdavid/vmail/backend/internal/snooze"
+	"github.com/vdavid/vmail/backend/internal/vacation"
 	ws "github.com/vdavid/vmail/backend/internal/websocket"
+	"github.com/vdavid/vmail/backend/migrations"
 )
 
 func main() {
@@ -22,74 +42,676 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	if err := logging.Setup(os.Stderr, cfg.LogLevel, cfg.LogFormat); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	// Cancelled on SIGINT and SIGTERM, which stops the background jobs and shuts the server down
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	ctx := context.Background()
 	pool, err := db.NewConnection(ctx, cfg)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer db.CloseConnection(pool)
 
-	log.Printf("Successfully connected to database")
+	slog.Info("Successfully connected to database")
+
+	if cfg.RunMigrations {
+		all, err := migrate.Load(migrations.FS)
+		if err != nil {
+			log.Fatalf("Failed to load migrations: %v", err)
+		}
+		applied, err := migrate.Up(ctx, pool, all)
+		if err != nil {
+			log.Fatalf("Failed to run migrations: %v", err)
+		}
+		slog.Info("Database is up to date", "applied_migrations", applied)
+	}
+
+	// Keep materialized folder thread counts fresh after message mutations
+	go db.RunThreadCountUpdater(ctx, pool, db.ThreadCountUpdateInterval)
+
+	// Delete attachment uploads that were never sent or saved in a draft
+	go db.RunAttachmentUploadCleaner(ctx, pool, db.AttachmentUploadCleanupInterval)
 
-	server := NewServer(cfg, pool)
+	// Delete data exports a week after they finished
+	go db.RunDataExportCleaner(ctx, pool, db.DataExportCleanupInterval)
 
-	address := ":" + cfg.Port
-	log.Printf("V-Mail backend server starting on %s (environment: %s)", address, cfg.Environment)
+	// Keep the sync change log behind GET /api/v1/sync/delta from growing forever, in the maintenance window
+	maintenanceWindow, err := cfg.GetMaintenanceWindow()
+	if err != nil {
+		log.Fatalf("Failed to create maintenance window: %v", err)
+	}
+	slog.Info("Heavy jobs run in the maintenance window", "window", maintenanceWindow.String())
+	go db.RunSyncChangePruner(ctx, pool, db.SyncChangePruneInterval, maintenanceWindow)
+
+	// Compress the HTML bodies that we cached before we compressed them on write, in the maintenance window
+	go db.RunBodyCompressor(ctx, pool, db.BodyCompressionInterval, maintenanceWindow)
+
+	// Merge the threads that incremental syncs split, in the maintenance window
+	go db.RunThreadRepairer(ctx, pool, db.ThreadRepairInterval, maintenanceWindow)
 
-	if err := http.ListenAndServe(address, server); err != nil {
+	// Delete the cached messages beyond the sync depth of their folders, in the maintenance window
+	go db.RunRetentionCleaner(ctx, pool, db.RetentionInterval, maintenanceWindow)
+
+	// Evict the least recently used message bodies of users over the body cache quota, in the maintenance window
+	go db.RunBodyCacheEvictor(ctx, pool, db.BodyCacheEvictionInterval, int64(cfg.BodyCacheQuotaBytes), maintenanceWindow)
+
+	server := &http.Server{Addr: ":" + cfg.Port, Handler: NewServer(ctx, cfg, pool)}
+
+	// Lets the requests in progress finish before main returns
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		slog.Info("V-Mail backend server shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Failed to shut down the server gracefully", "error", err)
+		}
+	}()
+
+	slog.Info("V-Mail backend server starting", "address", server.Addr, "environment", cfg.Environment)
+
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Server failed to start: %v", err)
 	}
+	<-shutdownDone
 }
 
+// shutdownTimeout is how long the server waits for the requests in progress when it shuts down.
+const shutdownTimeout = 30 * time.Second
+
 // NewServer creates and returns a new HTTP handler for the V-Mail API server.
-func NewServer(cfg *config.Config, dbPool *pgxpool.Pool) http.Handler {
-	encryptor, err := crypto.NewEncryptor(cfg.EncryptionKeyBase64)
+// Its background jobs, like the outbox dispatcher and the sync scheduler, run until ctx is done.
+func NewServer(ctx context.Context, cfg *config.Config, dbPool *pgxpool.Pool) http.Handler {
+	encryptor, err := crypto.NewEncryptorWithKeys(cfg.GetEncryptionKeys())
 	if err != nil {
 		log.Fatalf("Failed to create encryptor: %v", err)
 	}
 
 	imapPool := imap.NewPoolWithMaxWorkers(cfg.IMAPMaxWorkers)
-	imapService := imap.NewService(dbPool, imapPool, encryptor)
+	imapPool.SetOperationTimeout(time.Duration(cfg.IMAPOperationTimeoutSeconds) * time.Second)
 	wsHub := ws.NewHub(10)
+	imapService := imap.NewService(dbPool, imapPool, encryptor, wsHub)
+	imapService.SetFetchLimits(imap.FetchLimits{MaxMessageBytes: cfg.IMAPMaxMessageBytes, MaxPartBytes: cfg.IMAPMaxPartBytes})
+	imapService.SetFetchBatching(imap.FetchBatching{BatchSize: cfg.IMAPFetchBatchSize, Connections: cfg.IMAPFetchConnections})
+	imapService.SetTrustedAuthServIDs(cfg.GetTrustedAuthServIDs())
+
+	// Sends Web Push notifications about new mail to users who don't have the app open
+	var vapid *push.VAPID
+	if cfg.VAPIDPrivateKey != "" {
+		vapid, err = push.NewVAPID(cfg.VAPIDPrivateKey, cfg.VAPIDSubject)
+		if err != nil {
+			log.Fatalf("Failed to load the VAPID key: %v", err)
+		}
+		imapService.SetNewMailNotifier(push.NewNotifier(dbPool, push.NewSender(vapid), wsHub))
+	}
 
 	authHandler := api.NewAuthHandler(dbPool)
-	settingsHandler := api.NewSettingsHandler(dbPool, encryptor)
+	settingsHandler := api.NewSettingsHandler(dbPool, encryptor, imapPool)
+	preferencesHandler := api.NewPreferencesHandler(dbPool)
 	foldersHandler := api.NewFoldersHandler(dbPool, encryptor, imapPool)
-	threadsHandler := api.NewThreadsHandler(dbPool, encryptor, imapService)
-	threadHandler := api.NewThreadHandler(dbPool, encryptor, imapService)
+	folderSyncHandler := api.NewFolderSyncHandler(dbPool)
+	folderRoleHandler := api.NewFolderRoleHandler(dbPool)
+	folderManagementHandler := api.NewFolderManagementHandler(dbPool, imapService, wsHub)
+	threadsHandler := api.NewThreadsHandler(dbPool, encryptor, imapService, wsHub, time.Duration(cfg.ThreadsSyncBudgetMs)*time.Millisecond)
+	threadHandler := api.NewThreadHandler(dbPool, encryptor, imapService, wsHub)
 	searchHandler := api.NewSearchHandler(dbPool, encryptor, imapService)
-	wsHandler := api.NewWebSocketHandler(dbPool, imapService, wsHub)
-	testHandler := api.NewTestHandler(dbPool, encryptor, imapService, wsHub)
+	smtpService := smtp.NewService(dbPool, encryptor)
+	if cfg.SMTPMaxConnections > 0 {
+		smtpService.SetPool(smtp.NewPoolWithMaxConnections(cfg.SMTPMaxConnections))
+	}
+	identitiesHandler := api.NewIdentitiesHandler(dbPool, encryptor, smtpService)
+	aliasesHandler := api.NewAliasesHandler(dbPool)
+	blockedSendersHandler := api.NewBlockedSendersHandler(dbPool)
+	trustedSendersHandler := api.NewTrustedSendersHandler(dbPool)
+	contactsHandler := api.NewContactsHandler(dbPool)
+	syncHandler := api.NewSyncHandler(dbPool)
+	devicesHandler := api.NewDevicesHandler(dbPool)
+	pushHandler := api.NewPushHandler(vapid)
+	notificationRulesHandler := api.NewNotificationRulesHandler(dbPool)
+	filtersHandler := api.NewFiltersHandler(dbPool, encryptor)
+	sieveHandler := api.NewSieveHandler(dbPool, encryptor)
+	vacationHandler := api.NewVacationHandler(dbPool, encryptor)
+	apiKeysHandler := api.NewAPIKeysHandler(dbPool)
+	adminHandler := api.NewAdminHandler(dbPool, imapPool)
+	exportHandler := api.NewExportHandler(dbPool)
+	rawMessageHandler := api.NewRawMessageHandler(dbPool, imapService)
+	inlinePartHandler := api.NewInlinePartHandler(dbPool, imapService)
+	threadExportHandler := api.NewThreadExportHandler(dbPool, imapService)
+	rsvpHandler := api.NewRSVPHandler(dbPool, smtpService)
+	accountHandler := api.NewAccountHandler(dbPool, encryptor, imapPool, wsHub)
+	oauthProviders := oauth.NewProviders(cfg)
+	oauthHandler := api.NewOAuthHandler(dbPool, encryptor, imapPool, oauthProviders)
+	autodiscoverHandler := api.NewAutodiscoverHandler(autoconfig.NewDiscoverer())
+	imageProxyHandler := api.NewImageProxyHandler(imageproxy.NewProxy(cfg.ImageProxyMaxImageBytes, cfg.ImageProxyCacheBytes))
+	sendHandler := api.NewSendHandler(dbPool, smtpService, imapService)
+	draftsHandler := api.NewDraftsHandler(dbPool, imapService)
+	attachmentUploadsHandler := api.NewAttachmentUploadsHandler(dbPool, int64(cfg.MaxAttachmentUploadBytes), int64(cfg.AttachmentUploadQuotaBytes))
+	wsHandler := api.NewWebSocketHandler(dbPool, encryptor, imapService, wsHub)
+	// Tracks who has the app open, so that the scheduler syncs active users more often than dormant ones
+	activityTracker := activity.NewTracker()
+	wsHandler.SetActivityTracker(activityTracker)
+	testHandler := api.NewTestHandler(dbPool, encryptor, imapService)
+
+	// Sends queued messages once their undo send window ends
+	go outbox.NewDispatcher(dbPool, smtpService, imapService, wsHub).Run(ctx)
+
+	// Replies to new mail while users are away, unless their mail server does it with Sieve
+	imapService.SetAutoResponder(vacation.NewResponder(dbPool, smtpService))
+
+	// Brings snoozed threads back to their folders when their snooze ends
+	go snooze.NewWaker(dbPool, wsHub).Run(ctx)
+
+	// Refreshes OAuth access tokens before they expire
+	if len(oauthProviders) > 0 {
+		go oauth.NewRefresher(dbPool, encryptor, oauthProviders).Run(ctx)
+	}
+
+	// Keeps the cache of INBOX and the other synced folders fresh, even when nobody is looking
+	if cfg.SyncIntervalSeconds > 0 {
+		syncScheduler := scheduler.NewScheduler(dbPool, imapService, time.Duration(cfg.SyncIntervalSeconds)*time.Second, cfg.SyncMaxConcurrentUsers)
+		syncScheduler.SetActivity(activityTracker, time.Duration(cfg.SyncActiveIntervalSeconds)*time.Second,
+			time.Duration(cfg.SyncDormantIntervalSeconds)*time.Second)
+		go syncScheduler.Run(ctx)
+	}
+
+	// Limits concurrent requests per user on the endpoints that use IMAP connections
+	imapLimiter := api.NewInFlightLimiter(cfg.IMAPMaxInFlightRequests, time.Duration(cfg.IMAPQueueTimeoutMs)*time.Millisecond)
+
+	// Limits the request rate per user on every authenticated endpoint
+	var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimitStore == config.RateLimitStorePostgres {
+		rateLimitStore = db.NewRateLimitStore(dbPool)
+	}
+	rateLimiter := api.NewRateLimiter(rateLimitStore, cfg.RateLimitPerMinute, cfg.RateLimitBurst)
+
+	// Limits the size of request bodies, with more room for messages, drafts, and attachment uploads
+	bodyLimiter := api.NewBodyLimiter(int64(cfg.MaxRequestBodyBytes), map[string]int64{
+		"/api/v1/messages/send":      int64(cfg.MaxSendRequestBodyBytes),
+		"/api/v1/drafts":             int64(cfg.MaxDraftRequestBodyBytes),
+		"/api/v1/attachments/upload": api.AttachmentUploadBodyLimit(int64(cfg.MaxAttachmentUploadBytes)),
+	})
+	activityRecorder := api.NewActivityRecorder(activityTracker)
+	requireAuth := func(next http.Handler) http.Handler {
+		return auth.RequireAuthOrAPIKey(apiKeysHandler.ValidateAPIKey)(rateLimiter.Limit(activityRecorder.Record(bodyLimiter.Limit(next))))
+	}
+	requireAdmin := func(next http.Handler) http.Handler {
+		return requireAuth(auth.RequireGroup(cfg.AdminGroup)(next))
+	}
 
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/", handleRoot)
 
-	mux.Handle("/api/v1/auth/status", auth.RequireAuth(http.HandlerFunc(authHandler.GetAuthStatus)))
-	mux.Handle("/api/v1/settings", auth.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/api/v1/auth/status", requireAuth(http.HandlerFunc(authHandler.GetAuthStatus)))
+	mux.Handle("/api/v1/settings", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
 			settingsHandler.GetSettings(w, r)
 		case http.MethodPost:
 			settingsHandler.PostSettings(w, r)
+		case http.MethodPatch:
+			settingsHandler.PatchSettings(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+	mux.Handle("/api/v1/settings/test", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		settingsHandler.TestSettings(w, r)
+	})))
+	mux.Handle("/api/v1/settings/autodiscover", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		autodiscoverHandler.Autodiscover(w, r)
+	})))
+	mux.Handle("/api/v1/image-proxy", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		imageProxyHandler.GetImage(w, r)
+	})))
+	mux.Handle("/api/v1/settings/identities", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			identitiesHandler.GetIdentities(w, r)
+		case http.MethodPost:
+			identitiesHandler.CreateIdentity(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+	// Handle /api/v1/settings/identities/{id} pattern
+	mux.Handle("/api/v1/settings/identities/", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			identitiesHandler.UpdateIdentity(w, r)
+		case http.MethodDelete:
+			identitiesHandler.DeleteIdentity(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+	mux.Handle("/api/v1/settings/oauth", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			oauthHandler.GetProviders(w, r)
+		case http.MethodPost:
+			oauthHandler.Connect(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+	mux.Handle("/api/v1/aliases", requireAuth(http.HandlerFunc(aliasesHandler.GetAliases)))
+	mux.Handle("/api/v1/aliases/generate", requireAuth(http.HandlerFunc(aliasesHandler.GenerateAlias)))
+	mux.Handle("/api/v1/blocked-senders", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			blockedSendersHandler.GetBlockedSenders(w, r)
+		case http.MethodPost:
+			blockedSendersHandler.CreateBlockedSender(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+	// Handle /api/v1/blocked-senders/{id} pattern
+	mux.Handle("/api/v1/blocked-senders/", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			blockedSendersHandler.UpdateBlockedSender(w, r)
+		case http.MethodDelete:
+			blockedSendersHandler.DeleteBlockedSender(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+	mux.Handle("/api/v1/trusted-senders", requireAuth(http.HandlerFunc(trustedSendersHandler.GetTrustedSenders)))
+	mux.Handle("/api/v1/contacts", requireAuth(http.HandlerFunc(contactsHandler.GetContacts)))
+	// Handle /api/v1/trusted-senders/{id} pattern
+	mux.Handle("/api/v1/trusted-senders/", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		trustedSendersHandler.DeleteTrustedSender(w, r)
+	})))
+	mux.Handle("/api/v1/devices", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			devicesHandler.GetDevices(w, r)
+		case http.MethodPost:
+			devicesHandler.RegisterDevice(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+	mux.Handle("/api/v1/push/vapid-public-key", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		pushHandler.GetVAPIDPublicKey(w, r)
+	})))
+	mux.Handle("/api/v1/notification-rules", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			notificationRulesHandler.GetRules(w, r)
+		case http.MethodPost:
+			notificationRulesHandler.CreateRule(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+	// Handle /api/v1/notification-rules/{id} pattern
+	mux.Handle("/api/v1/notification-rules/", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			notificationRulesHandler.UpdateRule(w, r)
+		case http.MethodDelete:
+			notificationRulesHandler.DeleteRule(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+	mux.Handle("/api/v1/filters", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			filtersHandler.GetFilters(w, r)
+		case http.MethodPost:
+			filtersHandler.CreateFilter(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+	mux.Handle("/api/v1/filters/test", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		filtersHandler.TestFilter(w, r)
+	})))
+	// Handle /api/v1/filters/{id} pattern
+	mux.Handle("/api/v1/filters/", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			filtersHandler.UpdateFilter(w, r)
+		case http.MethodDelete:
+			filtersHandler.DeleteFilter(w, r)
 		default:
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		}
 	})))
-	mux.Handle("/api/v1/folders", auth.RequireAuth(http.HandlerFunc(foldersHandler.GetFolders)))
-	mux.Handle("/api/v1/threads", auth.RequireAuth(http.HandlerFunc(threadsHandler.GetThreads)))
-	mux.Handle("/api/v1/search", auth.RequireAuth(http.HandlerFunc(searchHandler.Search)))
-	// WebSocket handler handles its own authentication via query parameter
+	mux.Handle("/api/v1/sieve/scripts", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		sieveHandler.GetScripts(w, r)
+	})))
+	// Handle /api/v1/sieve/scripts/{name} and /api/v1/sieve/scripts/{name}/activate patterns
+	mux.Handle("/api/v1/sieve/scripts/", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/activate"):
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			sieveHandler.ActivateScript(w, r)
+		case r.Method == http.MethodGet:
+			sieveHandler.GetScript(w, r)
+		case r.Method == http.MethodPut:
+			sieveHandler.PutScript(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+	mux.Handle("/api/v1/sieve/filters", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		sieveHandler.SyncFilters(w, r)
+	})))
+	mux.Handle("/api/v1/vacation", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			vacationHandler.GetVacation(w, r)
+		case http.MethodPut:
+			vacationHandler.UpdateVacation(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+	// Handle /api/v1/devices/{id} pattern
+	mux.Handle("/api/v1/devices/", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPatch:
+			devicesHandler.UpdateDevice(w, r)
+		case http.MethodDelete:
+			devicesHandler.RevokeDevice(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+	mux.Handle("/api/v1/api-keys", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			apiKeysHandler.GetAPIKeys(w, r)
+		case http.MethodPost:
+			apiKeysHandler.CreateAPIKey(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+	// Handle /api/v1/api-keys/{id} pattern
+	mux.Handle("/api/v1/api-keys/", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		apiKeysHandler.RevokeAPIKey(w, r)
+	})))
+	mux.Handle("/api/v1/export", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			exportHandler.DownloadExport(w, r)
+		case http.MethodPost:
+			exportHandler.StartExport(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+	mux.Handle("/api/v1/export/status", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		exportHandler.GetExportStatus(w, r)
+	})))
+	mux.Handle("/api/v1/account", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		accountHandler.DeleteAccount(w, r)
+	})))
+	mux.Handle("/api/v1/account/deletion-token", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		accountHandler.IssueDeletionToken(w, r)
+	})))
+	mux.Handle("/api/v1/admin/users", requireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		adminHandler.GetUsers(w, r)
+	})))
+	// Handle /api/v1/admin/users/{id} and /api/v1/admin/users/{id}/disconnect patterns
+	mux.Handle("/api/v1/admin/users/", requireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/disconnect"):
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			adminHandler.DisconnectUser(w, r)
+		case r.Method == http.MethodGet:
+			adminHandler.GetUser(w, r)
+		case r.Method == http.MethodDelete:
+			adminHandler.DeleteUser(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+	mux.Handle("/api/v1/preferences", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			preferencesHandler.GetPreferences(w, r)
+		case http.MethodPatch:
+			preferencesHandler.PatchPreferences(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+	mux.Handle("/api/v1/folders", requireAuth(imapLimiter.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			foldersHandler.GetFolders(w, r)
+		case http.MethodPost:
+			folderManagementHandler.CreateFolder(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))))
+	// Handle /api/v1/folders/subscriptions, and the /api/v1/folders/{name}, /api/v1/folders/{name}/sync,
+	// /api/v1/folders/{name}/role, and /api/v1/folders/{name}/subscription patterns
+	mux.Handle("/api/v1/folders/", requireAuth(imapLimiter.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/folders/subscriptions":
+			if r.Method != http.MethodGet {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			folderManagementHandler.ListSubscriptions(w, r)
+		case strings.HasSuffix(r.URL.Path, "/subscription"):
+			switch r.Method {
+			case http.MethodPut, http.MethodDelete:
+				folderManagementHandler.SetSubscribed(w, r)
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		case strings.HasSuffix(r.URL.Path, "/sync"):
+			switch r.Method {
+			case http.MethodGet:
+				folderSyncHandler.GetFolderSync(w, r)
+			case http.MethodPatch:
+				folderSyncHandler.PatchFolderSync(w, r)
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		case strings.HasSuffix(r.URL.Path, "/role"):
+			if r.Method != http.MethodPatch {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			folderRoleHandler.PatchFolderRole(w, r)
+		default:
+			switch r.Method {
+			case http.MethodPatch:
+				folderManagementHandler.RenameFolder(w, r)
+			case http.MethodDelete:
+				folderManagementHandler.DeleteFolder(w, r)
+			default:
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			}
+		}
+	}))))
+	mux.Handle("/api/v1/threads", requireAuth(imapLimiter.Limit(http.HandlerFunc(threadsHandler.GetThreads))))
+	mux.Handle("/api/v1/search", requireAuth(imapLimiter.Limit(http.HandlerFunc(searchHandler.Search))))
+	mux.Handle("/api/v1/search/suggestions", requireAuth(http.HandlerFunc(searchHandler.Suggest)))
+	mux.Handle("/api/v1/sync", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		threadsHandler.StartSync(w, r)
+	})))
+	mux.Handle("/api/v1/sync/delta", requireAuth(http.HandlerFunc(syncHandler.GetDelta)))
+	mux.Handle("/api/v1/sync/status", requireAuth(http.HandlerFunc(syncHandler.GetStatus)))
+	mux.Handle("/api/v1/messages/send", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		sendHandler.SendMessage(w, r)
+	})))
+	mux.Handle("/api/v1/messages/scheduled", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		sendHandler.GetScheduledMessages(w, r)
+	})))
+	// Handle /api/v1/messages/{id}/cancel and /api/v1/messages/{id}/raw patterns
+	mux.Handle("/api/v1/messages/", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		// First, since Content-IDs can end with anything
+		case strings.Contains(r.URL.Path, "/inline/"):
+			if r.Method != http.MethodGet {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			inlinePartHandler.GetInlinePart(w, r)
+		case strings.HasSuffix(r.URL.Path, "/cancel"):
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			sendHandler.CancelMessage(w, r)
+		case strings.HasSuffix(r.URL.Path, "/raw"):
+			if r.Method != http.MethodGet {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			rawMessageHandler.GetRawMessage(w, r)
+		case strings.HasSuffix(r.URL.Path, "/rsvp"):
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			rsvpHandler.RespondToInvite(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})))
+	mux.Handle("/api/v1/drafts", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			draftsHandler.GetDrafts(w, r)
+		case http.MethodPost:
+			draftsHandler.CreateDraft(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+	mux.Handle("/api/v1/attachments/upload", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		attachmentUploadsHandler.UploadAttachment(w, r)
+	})))
+	// Handle /api/v1/drafts/{id} pattern
+	mux.Handle("/api/v1/drafts/", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			draftsHandler.GetDraft(w, r)
+		case http.MethodPut:
+			draftsHandler.UpdateDraft(w, r)
+		case http.MethodDelete:
+			draftsHandler.DeleteDraft(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+	mux.Handle("/api/v1/ws/token", requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			wsHandler.IssueToken(w, r)
+		case http.MethodDelete:
+			wsHandler.RevokeTokens(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+	// WebSocket handler handles its own authentication via a token from /api/v1/ws/token in a query parameter
 	// (since browsers can't set headers on WebSocket connections).
 	mux.Handle("/api/v1/ws", http.HandlerFunc(wsHandler.Handle))
 	// Add test endpoints
 	if cfg.Environment == "test" {
-		mux.Handle("/test/add-imap-message", auth.RequireAuth(http.HandlerFunc(testHandler.AddIMAPMessage)))
+		mux.Handle("/test/add-imap-message", requireAuth(http.HandlerFunc(testHandler.AddIMAPMessage)))
+		mux.Handle("/test/set-imap-flags", requireAuth(http.HandlerFunc(testHandler.SetIMAPFlags)))
+		mux.Handle("/test/move-imap-message", requireAuth(http.HandlerFunc(testHandler.MoveIMAPMessage)))
+		mux.Handle("/test/delete-imap-message", requireAuth(http.HandlerFunc(testHandler.DeleteIMAPMessage)))
+		mux.Handle("/test/sync", requireAuth(http.HandlerFunc(testHandler.Sync)))
 	}
 
 	// Handle /api/v1/thread/{thread_id} pattern
-	mux.Handle("/api/v1/thread/", auth.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/api/v1/thread/", requireAuth(imapLimiter.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Extract thread_id from the path
 		path := strings.TrimPrefix(r.URL.Path, "/api/v1/thread/")
 		if path == "" || path == r.URL.Path {
@@ -98,10 +720,55 @@ func NewServer(cfg *config.Config, dbPool *pgxpool.Pool) http.Handler {
 		}
 		// Set the thread_id in the URL path for the handler to use
 		r.URL.Path = "/api/v1/thread/" + path
+		if r.Method == http.MethodPost {
+			// Handle /api/v1/thread/{thread_id}/{action} pattern
+			switch {
+			case strings.HasSuffix(path, "/move"):
+				threadHandler.MoveThread(w, r)
+			case strings.HasSuffix(path, "/archive"):
+				threadHandler.ArchiveThread(w, r)
+			case strings.HasSuffix(path, "/trash"):
+				threadHandler.TrashThread(w, r)
+			case strings.HasSuffix(path, "/report-spam"):
+				threadHandler.ReportSpam(w, r)
+			case strings.HasSuffix(path, "/not-spam"):
+				threadHandler.ReportNotSpam(w, r)
+			case strings.HasSuffix(path, "/trust-sender"):
+				threadHandler.TrustSender(w, r)
+			case strings.HasSuffix(path, "/labels"):
+				threadHandler.AddThreadLabel(w, r)
+			case strings.HasSuffix(path, "/snooze"):
+				threadHandler.SnoozeThread(w, r)
+			default:
+				http.NotFound(w, r)
+			}
+			return
+		}
+		if r.Method == http.MethodDelete {
+			// Handle /api/v1/thread/{thread_id}/labels/{label} and /api/v1/thread/{thread_id}/snooze patterns
+			switch {
+			case strings.Contains(path, "/labels/"):
+				threadHandler.RemoveThreadLabel(w, r)
+			case strings.HasSuffix(path, "/snooze"):
+				threadHandler.UnsnoozeThread(w, r)
+			default:
+				http.NotFound(w, r)
+			}
+			return
+		}
+		if strings.HasSuffix(path, "/export") {
+			threadExportHandler.ExportThread(w, r)
+			return
+		}
+		if strings.HasSuffix(path, "/reply-template") {
+			threadHandler.GetReplyTemplate(w, r)
+			return
+		}
 		threadHandler.GetThread(w, r)
-	})))
+	}))))
 
-	return mux
+	// Tag each request with an ID, so that its log lines can be found together
+	return logging.RequestID(mux)
 }
 
 func handleRoot(w http.ResponseWriter, _ *http.Request) {
@@ -102,7 +102,7 @@ func TestNewServer(t *testing.T) {
 		t.Fatalf("Failed to ping database: %v", err)
 	}
 
-	server := NewServer(cfg, pool)
+	server := NewServer(t.Context(), cfg, pool)
 
 	if server == nil {
 		t.Fatal("NewServer() returned nil")
@@ -148,7 +148,7 @@ func TestMainWithConfig(t *testing.T) {
 		t.Fatalf("Failed to ping database: %v", err)
 	}
 
-	server := NewServer(cfg, pool)
+	server := NewServer(t.Context(), cfg, pool)
 	if server == nil {
 		t.Fatal("NewServer() returned nil with valid config")
 	}
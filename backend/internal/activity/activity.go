@@ -0,0 +1,206 @@
+package activity
+
+import (
+	"sync"
+	"time"
+)
+
+// Level tells how active a user is, which decides how often we sync their folders in the background.
+type Level int
+
+const (
+	// LevelIdle is for users who used the app in the last day, but not just now. It's the level of users we haven't
+	// seen since the process started, too, so that a restart doesn't make everyone dormant.
+	LevelIdle Level = iota
+	// LevelActive is for users who have the app open.
+	LevelActive
+	// LevelDormant is for users who haven't used the app for a day.
+	LevelDormant
+)
+
+// String returns the name of the level, for logs.
+func (l Level) String() string {
+	switch l {
+	case LevelActive:
+		return "active"
+	case LevelDormant:
+		return "dormant"
+	default:
+		return "idle"
+	}
+}
+
+const (
+	// activeWindow is how close two requests have to be to make a user active.
+	// A single request, like a stray one from a background tab, doesn't.
+	activeWindow = 5 * time.Minute
+	// activeHold is how long a user stays active after their last request.
+	// It's longer than activeWindow, so that users who pause for a bit don't flap between the levels.
+	activeHold = 15 * time.Minute
+	// dormantAfter is how long after their last request a user becomes dormant.
+	dormantAfter = 24 * time.Hour
+)
+
+// Tracker tracks the activity of users: their API requests and open WebSocket connections.
+// It identifies users by email, like the auth middleware does. It's safe for concurrent use.
+// The activity is per process, so with more than one backend instance, each of them sees only its own requests.
+type Tracker struct {
+	mu      sync.Mutex
+	users   map[string]*userActivity
+	started time.Time
+	now     func() time.Time
+
+	promotions int
+	demotions  int
+}
+
+// userActivity is what we know about the activity of one user.
+type userActivity struct {
+	// lastSeen and previousSeen are the times of the user's last two requests.
+	lastSeen     time.Time
+	previousSeen time.Time
+	connections  int
+	level        Level
+}
+
+// Snapshot is the state of the tracker at one point in time.
+type Snapshot struct {
+	// Active, Idle, and Dormant are the number of users at each level, as of their last sync check.
+	Active  int
+	Idle    int
+	Dormant int
+	// Promotions and Demotions count the level changes up and down since the process started.
+	Promotions int
+	Demotions  int
+}
+
+// NewTracker creates a tracker that hasn't seen anyone yet.
+func NewTracker() *Tracker {
+	return &Tracker{
+		users:   make(map[string]*userActivity),
+		started: time.Now(),
+		now:     time.Now,
+	}
+}
+
+// Touch records an API request of the user.
+func (t *Tracker) Touch(email string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	user := t.user(email)
+	user.previousSeen, user.lastSeen = user.lastSeen, now
+	t.update(user, now)
+}
+
+// Connect records that the user opened a WebSocket connection. Users with an open connection are active.
+func (t *Tracker) Connect(email string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	user := t.user(email)
+	user.connections++
+	user.lastSeen = now
+	t.update(user, now)
+}
+
+// Disconnect records that the user closed a WebSocket connection.
+// The user stays active for a while after closing their last one, like after their last request.
+func (t *Tracker) Disconnect(email string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	user := t.user(email)
+	user.connections = max(user.connections-1, 0)
+	user.lastSeen = now
+	t.update(user, now)
+}
+
+// Level returns the user's current activity level.
+func (t *Tracker) Level(email string) Level {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	user := t.user(email)
+	t.update(user, t.now())
+	return user.level
+}
+
+// Snapshot returns the current level counts and level changes.
+func (t *Tracker) Snapshot() Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	snapshot := Snapshot{Promotions: t.promotions, Demotions: t.demotions}
+	for _, user := range t.users {
+		switch user.level {
+		case LevelActive:
+			snapshot.Active++
+		case LevelDormant:
+			snapshot.Dormant++
+		default:
+			snapshot.Idle++
+		}
+	}
+	return snapshot
+}
+
+// user returns the user's activity, and starts tracking new users. The caller must hold the lock.
+func (t *Tracker) user(email string) *userActivity {
+	user, ok := t.users[email]
+	if !ok {
+		user = &userActivity{level: LevelIdle}
+		t.users[email] = user
+	}
+	return user
+}
+
+// update moves the user to their level at now, and counts the change. The caller must hold the lock.
+// Becoming active takes an open connection or two requests close together, but staying active only takes
+// one request every activeHold.
+func (t *Tracker) update(user *userActivity, now time.Time) {
+	lastSeen := user.lastSeen
+	if lastSeen.IsZero() {
+		lastSeen = t.started
+	}
+	inactiveFor := now.Sub(lastSeen)
+
+	var level Level
+	switch {
+	case user.connections > 0:
+		level = LevelActive
+	case !user.previousSeen.IsZero() && user.lastSeen.Sub(user.previousSeen) <= activeWindow && inactiveFor < activeWindow:
+		level = LevelActive
+	case user.level == LevelActive && inactiveFor < activeHold:
+		level = LevelActive
+	case inactiveFor < dormantAfter:
+		level = LevelIdle
+	default:
+		level = LevelDormant
+	}
+
+	if level == user.level {
+		return
+	}
+	if rank(level) > rank(user.level) {
+		t.promotions++
+	} else {
+		t.demotions++
+	}
+	user.level = level
+}
+
+// rank orders the levels from the least to the most active.
+func rank(level Level) int {
+	switch level {
+	case LevelDormant:
+		return 0
+	case LevelActive:
+		return 2
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,95 @@
+package activity
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// newTracker returns a tracker whose clock the test moves with the returned function
+	newTracker := func() (*Tracker, func(time.Duration)) {
+		tracker := NewTracker()
+		now := start
+		tracker.started = start
+		tracker.now = func() time.Time { return now }
+		return tracker, func(d time.Duration) { now = now.Add(d) }
+	}
+
+	t.Run("users we haven't seen are idle until they become dormant", func(t *testing.T) {
+		tracker, advance := newTracker()
+		if level := tracker.Level("a@example.com"); level != LevelIdle {
+			t.Errorf("Expected idle, got %s", level)
+		}
+		advance(dormantAfter)
+		if level := tracker.Level("a@example.com"); level != LevelDormant {
+			t.Errorf("Expected dormant, got %s", level)
+		}
+	})
+
+	t.Run("a single request doesn't make a user active", func(t *testing.T) {
+		tracker, advance := newTracker()
+		advance(dormantAfter)
+		tracker.Touch("a@example.com")
+		if level := tracker.Level("a@example.com"); level != LevelIdle {
+			t.Errorf("Expected idle, got %s", level)
+		}
+	})
+
+	t.Run("two close requests make a user active until they pause", func(t *testing.T) {
+		tracker, advance := newTracker()
+		tracker.Touch("a@example.com")
+		advance(time.Minute)
+		tracker.Touch("a@example.com")
+		if level := tracker.Level("a@example.com"); level != LevelActive {
+			t.Errorf("Expected active, got %s", level)
+		}
+
+		// A request after a pause shorter than activeHold keeps them active
+		advance(activeHold - time.Minute)
+		tracker.Touch("a@example.com")
+		advance(activeHold - time.Minute)
+		if level := tracker.Level("a@example.com"); level != LevelActive {
+			t.Errorf("Expected active after a short pause, got %s", level)
+		}
+
+		advance(time.Minute)
+		if level := tracker.Level("a@example.com"); level != LevelIdle {
+			t.Errorf("Expected idle after activeHold, got %s", level)
+		}
+	})
+
+	t.Run("users with an open connection stay active", func(t *testing.T) {
+		tracker, advance := newTracker()
+		tracker.Connect("a@example.com")
+		advance(2 * dormantAfter)
+		if level := tracker.Level("a@example.com"); level != LevelActive {
+			t.Errorf("Expected active, got %s", level)
+		}
+
+		tracker.Disconnect("a@example.com")
+		advance(activeHold)
+		if level := tracker.Level("a@example.com"); level != LevelIdle {
+			t.Errorf("Expected idle after disconnecting, got %s", level)
+		}
+	})
+
+	t.Run("counts users and level changes", func(t *testing.T) {
+		tracker, advance := newTracker()
+		tracker.Connect("a@example.com")
+		tracker.Level("b@example.com")
+		advance(dormantAfter)
+		tracker.Level("b@example.com")
+		tracker.Disconnect("a@example.com")
+		tracker.Level("c@example.com")
+
+		snapshot := tracker.Snapshot()
+		if snapshot.Active != 1 || snapshot.Idle != 0 || snapshot.Dormant != 2 {
+			t.Errorf("Expected 1 active and 2 dormant users, got %+v", snapshot)
+		}
+		if snapshot.Promotions != 1 || snapshot.Demotions != 2 {
+			t.Errorf("Expected 1 promotion and 2 demotions, got %+v", snapshot)
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/crypto"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/models"
+	ws "github.com/vdavid/vmail/backend/internal/websocket"
+)
+
+// accountDeletionTokenTTL is how long a deletion token works. It's enough to read the warning and click the button.
+const accountDeletionTokenTTL = 5 * time.Minute
+
+// accountDeletionTokenPurpose is the first part of deletion tokens, so that no other signed token passes as one.
+const accountDeletionTokenPurpose = "account-deletion"
+
+// errInvalidAccountDeletionToken is returned by parseAccountDeletionToken for tokens that are malformed, forged,
+// or expired.
+var errInvalidAccountDeletionToken = errors.New("invalid account deletion token")
+
+// AccountHandler handles /api/v1/account, where users delete their own account.
+type AccountHandler struct {
+	pool      *pgxpool.Pool
+	encryptor *crypto.Encryptor
+	imapPool  imap.IMAPPool
+	hub       *ws.Hub
+}
+
+// NewAccountHandler creates a new AccountHandler instance.
+func NewAccountHandler(pool *pgxpool.Pool, encryptor *crypto.Encryptor, imapPool imap.IMAPPool, hub *ws.Hub) *AccountHandler {
+	return &AccountHandler{
+		pool:      pool,
+		encryptor: encryptor,
+		imapPool:  imapPool,
+		hub:       hub,
+	}
+}
+
+// IssueDeletionToken issues a short-lived token that confirms that the user wants to delete their account.
+// Deleting takes two requests, so that a stray DELETE request can't wipe an account.
+// Requests authenticated with an API key can't delete the account, so they can't get a token either.
+// The path is /api/v1/account/deletion-token.
+func (h *AccountHandler) IssueDeletionToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if auth.IsAPIKeyAuth(ctx) {
+		http.Error(w, "API keys can't delete the account", http.StatusForbidden)
+		return
+	}
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	expiresAt := time.Now().Add(accountDeletionTokenTTL).Truncate(time.Second)
+	WriteJSONResponse(w, models.AccountDeletionTokenResponse{
+		Token:     signAccountDeletionToken(h.encryptor, userID, expiresAt),
+		ExpiresAt: expiresAt,
+	})
+}
+
+// DeleteAccount deletes the user and all their data: settings, threads, messages, attachments, sync state,
+// and WebSocket tokens, in one transaction. Then it closes the user's IMAP connections and WebSocket connections.
+// The body must have a confirmation token from IssueDeletionToken.
+// Logging in again afterward creates a new, empty account.
+// The path is /api/v1/account.
+func (h *AccountHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if auth.IsAPIKeyAuth(ctx) {
+		http.Error(w, "API keys can't delete the account", http.StatusForbidden)
+		return
+	}
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	var req models.AccountDeletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.InfoContext(ctx, "AccountHandler: Failed to decode request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+	if req.ConfirmationToken == "" {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid request",
+			Fields: map[string]string{"confirmation_token": "Get a confirmation token first"},
+		})
+		return
+	}
+	tokenUserID, err := parseAccountDeletionToken(h.encryptor, req.ConfirmationToken, time.Now())
+	if err != nil || tokenUserID != userID {
+		http.Error(w, "Invalid or expired confirmation token", http.StatusForbidden)
+		return
+	}
+
+	if err := db.DeleteUser(ctx, h.pool, userID); err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			http.Error(w, "Account not found", http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "AccountHandler: Failed to delete account", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.imapPool.RemoveClient(userID)
+	if h.hub != nil {
+		h.hub.CloseUser(userID, "Account deleted")
+	}
+	slog.InfoContext(ctx, "Deleted account")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// signAccountDeletionToken returns a deletion token for the user. The format is
+// "account-deletion.<user_id>.<expiry>.<signature>", where the expiry is a Unix timestamp, and the signature is
+// the base64url-encoded signature of the rest.
+func signAccountDeletionToken(encryptor *crypto.Encryptor, userID string, expiresAt time.Time) string {
+	payload := fmt.Sprintf("%s.%s.%d", accountDeletionTokenPurpose, userID, expiresAt.Unix())
+	return payload + "." + base64.RawURLEncoding.EncodeToString(encryptor.Sign([]byte(payload)))
+}
+
+// parseAccountDeletionToken checks the signature and the expiry of a token from signAccountDeletionToken,
+// and returns its user ID.
+func parseAccountDeletionToken(encryptor *crypto.Encryptor, token string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 || parts[0] != accountDeletionTokenPurpose {
+		return "", errInvalidAccountDeletionToken
+	}
+	payload := strings.Join(parts[:3], ".")
+	signature, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil || !encryptor.Verify([]byte(payload), signature) {
+		return "", errInvalidAccountDeletionToken
+	}
+
+	expiry, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || !now.Before(time.Unix(expiry, 0)) {
+		return "", errInvalidAccountDeletionToken
+	}
+	return parts[1], nil
+}
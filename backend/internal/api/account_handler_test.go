@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestAccountHandler(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	encryptor := getTestEncryptor(t)
+	email := "account-handler-user@example.com"
+	userID := setupTestUserAndSettings(t, pool, encryptor, email)
+	imapPool := &mockIMAPPool{}
+	handler := NewAccountHandler(pool, encryptor, imapPool, nil)
+
+	deleteAccount := func(body string) *httptest.ResponseRecorder {
+		req := createRequestWithUser("DELETE", "/api/v1/account", email)
+		req.Body = io.NopCloser(strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		handler.DeleteAccount(rr, req)
+		return rr
+	}
+
+	t.Run("rejects requests without a confirmation token", func(t *testing.T) {
+		if rr := deleteAccount(`{}`); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("rejects tokens of other users", func(t *testing.T) {
+		token := signAccountDeletionToken(encryptor, "other-user-id", time.Now().Add(time.Minute))
+		if rr := deleteAccount(`{"confirmation_token":"` + token + `"}`); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", rr.Code)
+		}
+	})
+
+	t.Run("deletes the account with a confirmation token", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.IssueDeletionToken(rr, createRequestWithUser("POST", "/api/v1/account/deletion-token", email))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+		var response models.AccountDeletionTokenResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+
+		if rr := deleteAccount(`{"confirmation_token":"` + response.Token + `"}`); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if !imapPool.removeClientCalled[userID] {
+			t.Error("Expected the user's IMAP connections to be closed")
+		}
+		if _, err := db.GetUserSettings(context.Background(), pool, userID); err == nil {
+			t.Error("Expected the user's settings to be gone")
+		}
+	})
+}
+
+func TestParseAccountDeletionToken(t *testing.T) {
+	encryptor := getTestEncryptor(t)
+	now := time.Now()
+	expiresAt := now.Add(accountDeletionTokenTTL)
+	token := signAccountDeletionToken(encryptor, "user-id", expiresAt)
+
+	t.Run("returns the user ID of valid tokens", func(t *testing.T) {
+		userID, err := parseAccountDeletionToken(encryptor, token, now)
+		if err != nil || userID != "user-id" {
+			t.Errorf("Expected user-id, got %q and %v", userID, err)
+		}
+	})
+
+	t.Run("rejects expired tokens", func(t *testing.T) {
+		if _, err := parseAccountDeletionToken(encryptor, token, expiresAt.Add(time.Second)); err == nil {
+			t.Error("Expected an error for an expired token")
+		}
+	})
+
+	t.Run("rejects tampered, malformed, and other signed tokens", func(t *testing.T) {
+		forged := strings.Replace(token, "user-id", "other-user-id", 1)
+		wsToken := signWSToken(encryptor, "token-id", "user-id", expiresAt)
+		for _, bad := range []string{forged, token + "x", wsToken, "token", "a.b.c.d", ""} {
+			if _, err := parseAccountDeletionToken(encryptor, bad, now); err == nil {
+				t.Errorf("Expected an error for %q", bad)
+			}
+		}
+	})
+}
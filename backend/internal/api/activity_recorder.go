@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/vdavid/vmail/backend/internal/activity"
+	"github.com/vdavid/vmail/backend/internal/auth"
+)
+
+// ActivityRecorder records the API requests of users in an activity tracker,
+// so that the scheduler syncs the folders of active users more often.
+type ActivityRecorder struct {
+	tracker *activity.Tracker
+}
+
+// NewActivityRecorder creates a recorder that records requests in tracker.
+func NewActivityRecorder(tracker *activity.Tracker) *ActivityRecorder {
+	return &ActivityRecorder{tracker: tracker}
+}
+
+// Record wraps a handler with the recorder. It must run after auth.RequireAuth, since it identifies users by email.
+func (a *ActivityRecorder) Record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if email, ok := auth.GetUserEmailFromContext(r.Context()); ok {
+			a.tracker.Touch(email)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/activity"
+	"github.com/vdavid/vmail/backend/internal/auth"
+)
+
+func TestActivityRecorder(t *testing.T) {
+	tracker := activity.NewTracker()
+	recorder := NewActivityRecorder(tracker)
+	handler := recorder.Record(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for range 2 {
+		req := httptest.NewRequest("GET", "/api/v1/threads", nil)
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, "activity@example.com"))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+	}
+
+	if level := tracker.Level("activity@example.com"); level != activity.LevelActive {
+		t.Errorf("Expected the user to be active after two requests, got %s", level)
+	}
+	if level := tracker.Level("other@example.com"); level != activity.LevelIdle {
+		t.Errorf("Expected other users to stay idle, got %s", level)
+	}
+}
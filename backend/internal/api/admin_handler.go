@@ -0,0 +1,134 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// AdminHandler handles the admin API at /api/v1/admin, for managing all users of the server.
+// Its routes must be behind auth.RequireGroup, since it doesn't check who's calling.
+type AdminHandler struct {
+	pool     *pgxpool.Pool
+	imapPool imap.IMAPPool
+}
+
+// NewAdminHandler creates a new AdminHandler instance.
+func NewAdminHandler(pool *pgxpool.Pool, imapPool imap.IMAPPool) *AdminHandler {
+	return &AdminHandler{
+		pool:     pool,
+		imapPool: imapPool,
+	}
+}
+
+// GetUsers returns all users with how much of their mail we keep.
+func (h *AdminHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	users, err := db.GetAdminUsers(ctx, h.pool)
+	if err != nil {
+		slog.ErrorContext(ctx, "AdminHandler: Failed to get users", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !WriteJSONResponse(w, users) {
+		return
+	}
+}
+
+// GetUser returns a user with how much of their mail we keep, and the sync state of each of their folders.
+// The path is /api/v1/admin/users/{id}.
+func (h *AdminHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := getUUIDFromPath(r.URL.Path, "/api/v1/admin/users/", "")
+	if !ok {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	user, err := db.GetAdminUser(ctx, h.pool, userID)
+	if errors.Is(err, db.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "AdminHandler: Failed to get user", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	folders, err := db.GetFolderSyncStatuses(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "AdminHandler: Failed to get folder sync statuses", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !WriteJSONResponse(w, models.AdminUserDetail{AdminUser: *user, Folders: folders}) {
+		return
+	}
+}
+
+// DisconnectUser closes the IMAP connections of a user, including the IDLE listener.
+// The next request or sync of the user opens new ones, so it's for unsticking connections, not for locking users out.
+// The path is /api/v1/admin/users/{id}/disconnect.
+func (h *AdminHandler) DisconnectUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := getUUIDFromPath(r.URL.Path, "/api/v1/admin/users/", "/disconnect")
+	if !ok {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if _, err := db.GetAdminUser(ctx, h.pool, userID); errors.Is(err, db.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		slog.ErrorContext(ctx, "AdminHandler: Failed to get user", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.imapPool.RemoveClient(userID)
+	slog.InfoContext(ctx, "AdminHandler: Disconnected user", "user_id", userID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteUser closes the IMAP connections of a user, and deletes them with everything we keep for them.
+// Their mail stays on the IMAP server. If they log in again, they start over with a new, empty account.
+// The path is /api/v1/admin/users/{id}.
+func (h *AdminHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := getUUIDFromPath(r.URL.Path, "/api/v1/admin/users/", "")
+	if !ok {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	err := db.DeleteUser(ctx, h.pool, userID)
+	if errors.Is(err, db.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "AdminHandler: Failed to delete user", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// After deleting, so that a sync that was running can't reconnect with the credentials we just deleted
+	h.imapPool.RemoveClient(userID)
+	slog.InfoContext(ctx, "AdminHandler: Deleted user", "user_id", userID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
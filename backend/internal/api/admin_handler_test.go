@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestAdminHandler(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	userID := setupTestUserAndSettings(t, pool, getTestEncryptor(t), "admin-handler-user@example.com")
+	imapPool := &mockIMAPPool{}
+	handler := NewAdminHandler(pool, imapPool)
+	serve := func(method, path string, fn func(http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		fn(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+
+	t.Run("lists users", func(t *testing.T) {
+		rr := serve("GET", "/api/v1/admin/users", handler.GetUsers)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+		var users []models.AdminUser
+		if err := json.Unmarshal(rr.Body.Bytes(), &users); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		found := false
+		for _, user := range users {
+			found = found || user.ID == userID
+		}
+		if !found {
+			t.Errorf("Expected the user in the list, got %+v", users)
+		}
+	})
+
+	t.Run("gets a user with their folders", func(t *testing.T) {
+		rr := serve("GET", "/api/v1/admin/users/"+userID, handler.GetUser)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+		var user models.AdminUserDetail
+		if err := json.Unmarshal(rr.Body.Bytes(), &user); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if user.Email != "admin-handler-user@example.com" || user.Folders == nil {
+			t.Errorf("Expected the user with their folders, got %+v", user)
+		}
+	})
+
+	t.Run("disconnects a user", func(t *testing.T) {
+		rr := serve("POST", "/api/v1/admin/users/"+userID+"/disconnect", handler.DisconnectUser)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", rr.Code)
+		}
+		if !imapPool.removeClientCalled[userID] {
+			t.Error("Expected the user's IMAP connections to be closed")
+		}
+	})
+
+	t.Run("deletes a user", func(t *testing.T) {
+		imapPool.removeClientCalled = nil
+		rr := serve("DELETE", "/api/v1/admin/users/"+userID, handler.DeleteUser)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", rr.Code)
+		}
+		if !imapPool.removeClientCalled[userID] {
+			t.Error("Expected the user's IMAP connections to be closed")
+		}
+		if rr := serve("GET", "/api/v1/admin/users/"+userID, handler.GetUser); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 after deleting, got %d", rr.Code)
+		}
+	})
+
+	t.Run("returns 404 for unknown and invalid IDs", func(t *testing.T) {
+		if rr := serve("DELETE", "/api/v1/admin/users/"+userID, handler.DeleteUser); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for a deleted user, got %d", rr.Code)
+		}
+		if rr := serve("POST", "/api/v1/admin/users/"+userID+"/disconnect", handler.DisconnectUser); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for a deleted user, got %d", rr.Code)
+		}
+		if rr := serve("GET", "/api/v1/admin/users/not-a-uuid", handler.GetUser); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for an invalid ID, got %d", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/smtp"
+)
+
+const (
+	// maxAliasLabelLength is the longest label we put in a plus alias, in characters.
+	maxAliasLabelLength = 32
+	// aliasSuffixLength is the length of the random suffix that makes aliases hard to guess.
+	aliasSuffixLength = 3
+	// aliasSuffixAlphabet is what the random suffix is made of.
+	aliasSuffixAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	// maxAliasAttempts is how many random suffixes we try before giving up on finding an unused address.
+	maxAliasAttempts = 5
+)
+
+// AliasesHandler handles the plus aliases at /api/v1/aliases.
+type AliasesHandler struct {
+	pool *pgxpool.Pool
+}
+
+// NewAliasesHandler creates a new AliasesHandler instance.
+func NewAliasesHandler(pool *pgxpool.Pool) *AliasesHandler {
+	return &AliasesHandler{
+		pool: pool,
+	}
+}
+
+// GetAliases returns the plus aliases of the current user, newest first.
+func (h *AliasesHandler) GetAliases(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	aliases, err := db.GetPlusAliases(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "AliasesHandler: Failed to get plus aliases", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !WriteJSONResponse(w, aliases) {
+		return
+	}
+}
+
+// GenerateAlias creates and returns a new plus alias of the user's address for the label in the query,
+// for example, "user+shop-x7q@example.com" for /api/v1/aliases/generate?label=shop.
+func (h *AliasesHandler) GenerateAlias(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	label := normalizeAliasLabel(r.URL.Query().Get("label"))
+	if label == "" {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid label",
+			Fields: map[string]string{"label": "must contain at least one letter or digit"},
+		})
+		return
+	}
+
+	settings, err := db.GetUserSettings(ctx, h.pool, userID)
+	if errors.Is(err, db.ErrUserSettingsNotFound) {
+		http.Error(w, "Settings not found for this user", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "AliasesHandler: Failed to get settings", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	loginEmail, _ := auth.GetUserEmailFromContext(ctx)
+	baseAddress := smtp.SenderAddress(settings.SMTPUsername, loginEmail).Address
+	if !strings.Contains(baseAddress, "@") {
+		http.Error(w, "Your address doesn't support plus addressing", http.StatusBadRequest)
+		return
+	}
+
+	for range maxAliasAttempts {
+		suffix, err := randomAliasSuffix()
+		if err != nil {
+			slog.ErrorContext(ctx, "AliasesHandler: Failed to generate alias suffix", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		alias := &models.PlusAlias{Address: buildPlusAddress(baseAddress, label+"-"+suffix), Label: label}
+		err = db.CreatePlusAlias(ctx, h.pool, userID, alias)
+		if errors.Is(err, db.ErrPlusAliasExists) {
+			continue
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "AliasesHandler: Failed to create plus alias", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if !WriteJSONResponse(w, alias) {
+			return
+		}
+		return
+	}
+
+	slog.ErrorContext(ctx, "AliasesHandler: Failed to find an unused alias", "label", label, "attempts", maxAliasAttempts)
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+// normalizeAliasLabel makes the label safe to put in the local part of an address.
+// It lowercases it, turns everything but letters and digits into single dashes, and caps its length.
+// Returns an empty string if nothing is left.
+func normalizeAliasLabel(label string) string {
+	var b strings.Builder
+	pendingDash := false
+	for _, r := range strings.ToLower(label) {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			pendingDash = b.Len() > 0
+			continue
+		}
+		if pendingDash {
+			b.WriteByte('-')
+			pendingDash = false
+		}
+		b.WriteRune(r)
+	}
+	normalized := b.String()
+	if len(normalized) > maxAliasLabelLength {
+		normalized = normalized[:maxAliasLabelLength]
+	}
+	return strings.TrimRight(normalized, "-")
+}
+
+// buildPlusAddress adds the tag to the local part of the address, replacing any tag that's already there.
+// For example, "user@example.com" and "shop-x7q" make "user+shop-x7q@example.com".
+func buildPlusAddress(address, tag string) string {
+	at := strings.LastIndex(address, "@")
+	local, domain := address[:at], address[at+1:]
+	if plus := strings.Index(local, "+"); plus >= 0 {
+		local = local[:plus]
+	}
+	return fmt.Sprintf("%s+%s@%s", local, tag, domain)
+}
+
+// randomAliasSuffix returns a random string of aliasSuffixLength letters and digits.
+func randomAliasSuffix() (string, error) {
+	random := make([]byte, aliasSuffixLength)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	for i, b := range random {
+		random[i] = aliasSuffixAlphabet[int(b)%len(aliasSuffixAlphabet)]
+	}
+	return string(random), nil
+}
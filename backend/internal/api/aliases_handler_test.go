@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestNormalizeAliasLabel(t *testing.T) {
+	testCases := []struct {
+		label    string
+		expected string
+	}{
+		{"shop", "shop"},
+		{"My Shop!", "my-shop"},
+		{"  --news__letter--  ", "news-letter"},
+		{"Café", "caf"},
+		{"!!!", ""},
+		{strings.Repeat("a", 40), strings.Repeat("a", maxAliasLabelLength)},
+		{strings.Repeat("a", 31) + " b", strings.Repeat("a", 31)},
+	}
+
+	for _, tc := range testCases {
+		if got := normalizeAliasLabel(tc.label); got != tc.expected {
+			t.Errorf("normalizeAliasLabel(%q) = %q, expected %q", tc.label, got, tc.expected)
+		}
+	}
+}
+
+func TestBuildPlusAddress(t *testing.T) {
+	if got := buildPlusAddress("user@example.com", "shop-x7q"); got != "user+shop-x7q@example.com" {
+		t.Errorf("Unexpected address: %s", got)
+	}
+	if got := buildPlusAddress("user+old@example.com", "shop-x7q"); got != "user+shop-x7q@example.com" {
+		t.Errorf("Expected the existing tag to be replaced, got %s", got)
+	}
+}
+
+func TestAssignPlusAliasLabels(t *testing.T) {
+	messages := []models.Message{
+		{ToAddresses: []string{"User <User+Shop-x7q@example.com>"}},
+		{ToAddresses: []string{"someone@example.com"}, CCAddresses: []string{"user+news-a1b@example.com"}},
+		{ToAddresses: []string{"user@example.com"}},
+	}
+	assignPlusAliasLabels(messages, map[string]string{
+		"user+shop-x7q@example.com": "shop",
+		"user+news-a1b@example.com": "news",
+	})
+
+	for i, expected := range []string{"shop", "news", ""} {
+		if messages[i].PlusAliasLabel != expected {
+			t.Errorf("Expected message %d to have label %q, got %q", i, expected, messages[i].PlusAliasLabel)
+		}
+	}
+}
+
+func TestAliasesHandler(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	encryptor := getTestEncryptor(t)
+	email := "aliases-user@example.com"
+	setupTestUserAndSettings(t, pool, encryptor, email)
+	handler := NewAliasesHandler(pool)
+
+	var generated models.PlusAlias
+
+	t.Run("generates an alias", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.GenerateAlias(rr, createRequestWithUser("GET", "/api/v1/aliases/generate?label=My+Shop", email))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &generated); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if !regexp.MustCompile(`^aliases-user\+my-shop-[a-z0-9]{3}@example\.com$`).MatchString(generated.Address) {
+			t.Errorf("Unexpected address: %s", generated.Address)
+		}
+		if generated.Label != "my-shop" {
+			t.Errorf("Expected label my-shop, got %s", generated.Label)
+		}
+	})
+
+	t.Run("rejects empty labels", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.GenerateAlias(rr, createRequestWithUser("GET", "/api/v1/aliases/generate?label=!!", email))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("lists aliases", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.GetAliases(rr, createRequestWithUser("GET", "/api/v1/aliases", email))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+		var aliases []models.PlusAlias
+		if err := json.Unmarshal(rr.Body.Bytes(), &aliases); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if len(aliases) != 1 || aliases[0].Address != generated.Address {
+			t.Errorf("Expected the generated alias, got %+v", aliases)
+		}
+	})
+}
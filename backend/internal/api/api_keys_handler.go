@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+const (
+	// maxAPIKeyNameLength is the longest API key name we accept.
+	maxAPIKeyNameLength = 100
+
+	// maxAPIKeyExpiryDays is the longest expiry we accept for API keys that expire.
+	maxAPIKeyExpiryDays = 365
+)
+
+// APIKeysHandler handles the API keys of the user at /api/v1/api-keys, and checks the keys of requests.
+type APIKeysHandler struct {
+	pool *pgxpool.Pool
+}
+
+// NewAPIKeysHandler creates a new APIKeysHandler instance.
+func NewAPIKeysHandler(pool *pgxpool.Pool) *APIKeysHandler {
+	return &APIKeysHandler{
+		pool: pool,
+	}
+}
+
+// ValidateAPIKey returns the email of the user that the key belongs to. It's the auth.APIKeyValidator of the server.
+func (h *APIKeysHandler) ValidateAPIKey(ctx context.Context, key string) (string, error) {
+	return db.UseAPIKey(ctx, h.pool, auth.HashAPIKey(key))
+}
+
+// GetAPIKeys returns the API keys of the current user, without the keys themselves.
+func (h *APIKeysHandler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	keys, err := db.GetAPIKeys(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "APIKeysHandler: Failed to get API keys", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !WriteJSONResponse(w, keys) {
+		return
+	}
+}
+
+// CreateAPIKey creates an API key, and responds with it. The response is the only time the key is shown.
+// Requests authenticated with an API key can't create keys, so that a leaked key can't outlive its revocation.
+func (h *APIKeysHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if auth.IsAPIKeyAuth(ctx) {
+		http.Error(w, "API keys can't create API keys", http.StatusForbidden)
+		return
+	}
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	var req models.APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.InfoContext(ctx, "APIKeysHandler: Failed to decode request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	fieldErrors := map[string]string{}
+	if name == "" {
+		fieldErrors["name"] = "is required"
+	} else if len([]rune(name)) > maxAPIKeyNameLength {
+		fieldErrors["name"] = "must be at most 100 characters"
+	}
+	if req.ExpiresInDays != nil && (*req.ExpiresInDays < 1 || *req.ExpiresInDays > maxAPIKeyExpiryDays) {
+		fieldErrors["expires_in_days"] = "must be between 1 and 365"
+	}
+	if len(fieldErrors) > 0 {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid API key",
+			Fields: fieldErrors,
+		})
+		return
+	}
+
+	secret, err := auth.GenerateAPIKey()
+	if err != nil {
+		slog.ErrorContext(ctx, "APIKeysHandler: Failed to generate API key", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	key := models.CreatedAPIKey{
+		APIKey: models.APIKey{Name: name, Prefix: secret[:auth.APIKeyDisplayLength]},
+		Key:    secret,
+	}
+	if req.ExpiresInDays != nil {
+		expiresAt := time.Now().AddDate(0, 0, *req.ExpiresInDays)
+		key.ExpiresAt = &expiresAt
+	}
+	if err := db.CreateAPIKey(ctx, h.pool, userID, &key.APIKey, auth.HashAPIKey(secret)); err != nil {
+		slog.ErrorContext(ctx, "APIKeysHandler: Failed to create API key", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	WriteJSONResponseWithStatus(w, http.StatusCreated, key)
+}
+
+// RevokeAPIKey deletes an API key, so requests with it fail from now on.
+// The path is /api/v1/api-keys/{id}.
+func (h *APIKeysHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	keyID, ok := getUUIDFromPath(r.URL.Path, "/api/v1/api-keys/", "")
+	if !ok {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+
+	err := db.DeleteAPIKey(ctx, h.pool, userID, keyID)
+	if errors.Is(err, db.ErrAPIKeyNotFound) {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "APIKeysHandler: Failed to delete API key", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestAPIKeysHandler(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	email := "api-keys-user@example.com"
+	setupTestUserAndSettings(t, pool, getTestEncryptor(t), email)
+
+	handler := NewAPIKeysHandler(pool)
+	serve := func(method, path, body string, fn func(http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		fn(rr, req)
+		return rr
+	}
+
+	var created models.CreatedAPIKey
+
+	t.Run("creates a key", func(t *testing.T) {
+		rr := serve("POST", "/api/v1/api-keys", `{"name": " Backup script ", "expires_in_days": 30}`, handler.CreateAPIKey)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if created.ID == "" || created.Name != "Backup script" || created.ExpiresAt == nil ||
+			!strings.HasPrefix(created.Key, created.Prefix) || !strings.HasPrefix(created.Key, auth.APIKeyPrefix) {
+			t.Errorf("Unexpected key: %+v", created)
+		}
+	})
+
+	t.Run("authenticates with the key", func(t *testing.T) {
+		gotEmail, err := handler.ValidateAPIKey(context.Background(), created.Key)
+		if err != nil || gotEmail != email {
+			t.Errorf("Expected the user's email, got %q and %v", gotEmail, err)
+		}
+	})
+
+	t.Run("lists keys without the secret", func(t *testing.T) {
+		rr := serve("GET", "/api/v1/api-keys", "", handler.GetAPIKeys)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+		if strings.Contains(rr.Body.String(), created.Key) || !strings.Contains(rr.Body.String(), created.ID) {
+			t.Errorf("Expected the key without its secret, got %s", rr.Body.String())
+		}
+	})
+
+	t.Run("rejects invalid keys", func(t *testing.T) {
+		rr := serve("POST", "/api/v1/api-keys", `{"name": "", "expires_in_days": 0}`, handler.CreateAPIKey)
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), `"name"`) || !strings.Contains(rr.Body.String(), "expires_in_days") {
+			t.Errorf("Expected a 400 about the name and the expiry, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("doesn't let API keys create keys", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/v1/api-keys", strings.NewReader(`{"name": "Another"}`))
+		req.Header.Set("Authorization", "Bearer "+created.Key)
+		rr := httptest.NewRecorder()
+		auth.RequireAuthOrAPIKey(handler.ValidateAPIKey)(http.HandlerFunc(handler.CreateAPIKey)).ServeHTTP(rr, req)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", rr.Code)
+		}
+	})
+
+	t.Run("revokes a key", func(t *testing.T) {
+		path := "/api/v1/api-keys/" + created.ID
+		if rr := serve("DELETE", path, "", handler.RevokeAPIKey); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", rr.Code)
+		}
+		if rr := serve("DELETE", path, "", handler.RevokeAPIKey); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 the second time, got %d", rr.Code)
+		}
+		if _, err := handler.ValidateAPIKey(context.Background(), created.Key); err == nil {
+			t.Error("Expected the revoked key not to authenticate")
+		}
+	})
+}
@@ -45,15 +45,13 @@ func setupTestUserAndSettings(t *testing.T, pool *pgxpool.Pool, encryptor *crypt
 	encryptedSMTPPassword, _ := encryptor.Encrypt("smtp_pass")
 
 	settings := &models.UserSettings{
-		UserID:                   userID,
-		UndoSendDelaySeconds:     20,
-		PaginationThreadsPerPage: 100,
-		IMAPServerHostname:       "imap.test.com",
-		IMAPUsername:             "user",
-		EncryptedIMAPPassword:    encryptedIMAPPassword,
-		SMTPServerHostname:       "smtp.test.com",
-		SMTPUsername:             "user",
-		EncryptedSMTPPassword:    encryptedSMTPPassword,
+		UserID:                userID,
+		IMAPServerHostname:    "imap.test.com",
+		IMAPUsername:          "user",
+		EncryptedIMAPPassword: encryptedIMAPPassword,
+		SMTPServerHostname:    "smtp.test.com",
+		SMTPUsername:          "user",
+		EncryptedSMTPPassword: encryptedSMTPPassword,
 	}
 	if err := db.SaveUserSettings(ctx, pool, settings); err != nil {
 		t.Fatalf("Failed to save settings: %v", err)
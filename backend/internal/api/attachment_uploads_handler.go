@@ -0,0 +1,222 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+const (
+	// ErrorCodeAttachmentQuotaExceeded is the error code of uploads that don't fit in the user's upload quota.
+	ErrorCodeAttachmentQuotaExceeded = "attachment_quota_exceeded"
+
+	// multipartOverheadBytes is how much room upload requests get on top of the file,
+	// for the multipart boundaries and headers.
+	multipartOverheadBytes = 64 << 10
+)
+
+// AttachmentUploadsHandler handles the attachment upload staging at /api/v1/attachments/upload.
+// The compose form uploads files while the user writes, and send requests and drafts reference them by ID.
+type AttachmentUploadsHandler struct {
+	pool *pgxpool.Pool
+	// maxBytes is the largest file we accept, and quotaBytes is how much all uploads of a user can take up.
+	// Zero means no limit.
+	maxBytes   int64
+	quotaBytes int64
+}
+
+// NewAttachmentUploadsHandler creates a new AttachmentUploadsHandler instance.
+func NewAttachmentUploadsHandler(pool *pgxpool.Pool, maxBytes, quotaBytes int64) *AttachmentUploadsHandler {
+	return &AttachmentUploadsHandler{
+		pool:       pool,
+		maxBytes:   maxBytes,
+		quotaBytes: quotaBytes,
+	}
+}
+
+// UploadAttachment stages the file in the "file" field of a multipart/form-data request, and returns it with 201.
+// The part's Content-Type is the file's type. If it's missing or generic, we detect the type from the content.
+func (h *AttachmentUploadsHandler) UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Expected a multipart/form-data request", http.StatusBadRequest)
+		return
+	}
+	var upload *models.AttachmentUpload
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			writeInvalidBodyError(w, err)
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		upload = &models.AttachmentUpload{Filename: part.FileName(), ContentType: part.Header.Get("Content-Type")}
+		upload.Data, err = readLimited(part, h.maxBytes)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				WriteJSONResponseWithStatus(w, http.StatusRequestEntityTooLarge, models.ErrorResponse{
+					Error: fmt.Sprintf("File is too large, the limit is %d bytes", maxBytesErr.Limit),
+					Code:  ErrorCodeRequestTooLarge,
+				})
+				return
+			}
+			writeInvalidBodyError(w, err)
+			return
+		}
+		break
+	}
+	if upload == nil {
+		http.Error(w, "The file is missing from the \"file\" field", http.StatusBadRequest)
+		return
+	}
+
+	if fieldErrors := validateAttachmentUpload(upload); len(fieldErrors) > 0 {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid attachment",
+			Fields: fieldErrors,
+		})
+		return
+	}
+
+	err = db.CreateAttachmentUpload(ctx, h.pool, userID, upload, h.quotaBytes)
+	if errors.Is(err, db.ErrAttachmentUploadQuotaExceeded) {
+		WriteJSONResponseWithStatus(w, http.StatusRequestEntityTooLarge, models.ErrorResponse{
+			Error: fmt.Sprintf("Uploads can take up at most %d bytes. Send or discard some messages first, or wait for unused uploads to expire", h.quotaBytes),
+			Code:  ErrorCodeAttachmentQuotaExceeded,
+		})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "AttachmentUploadsHandler: Failed to save upload", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	WriteJSONResponseWithStatus(w, http.StatusCreated, upload)
+}
+
+// AttachmentUploadBodyLimit returns the request body limit of uploads for files of at most maxBytes.
+// Zero means no limit.
+func AttachmentUploadBodyLimit(maxBytes int64) int64 {
+	if maxBytes <= 0 {
+		return 0
+	}
+	return maxBytes + multipartOverheadBytes
+}
+
+// readLimited reads r to the end, or returns an *http.MaxBytesError if it's over limit bytes.
+// A limit of 0 or less means no limit.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, &http.MaxBytesError{Limit: limit}
+	}
+	return data, nil
+}
+
+// validateAttachmentUpload checks the filename and content type of the upload, and detects the content type
+// if it's missing or generic. Returns a map of invalid fields to error messages, which is empty if it's valid.
+func validateAttachmentUpload(upload *models.AttachmentUpload) map[string]string {
+	fieldErrors := map[string]string{}
+
+	upload.Filename = strings.TrimSpace(upload.Filename)
+	if upload.Filename == "" {
+		fieldErrors["filename"] = "the file needs a filename"
+	} else if strings.ContainsAny(upload.Filename, "\r\n") {
+		fieldErrors["filename"] = "must not contain line breaks"
+	}
+
+	if upload.ContentType == "" || upload.ContentType == "application/octet-stream" {
+		upload.ContentType = http.DetectContentType(upload.Data)
+	}
+	mediaType, params, err := mime.ParseMediaType(upload.ContentType)
+	if err != nil || !strings.Contains(mediaType, "/") || strings.HasPrefix(mediaType, "multipart/") {
+		fieldErrors["content_type"] = "must be a media type, like \"application/pdf\""
+	} else {
+		upload.ContentType = mime.FormatMediaType(mediaType, params)
+	}
+
+	upload.SizeBytes = int64(len(upload.Data))
+	return fieldErrors
+}
+
+// deleteSentAttachmentUploads deletes the uploads that the attachments of a sent or queued message reference, so
+// they free up the user's quota. The outbox keeps its own copy of the files. Uploads that a draft references stay
+// until the draft is deleted. The message is already on its way, so failing only logs a warning.
+func deleteSentAttachmentUploads(ctx context.Context, pool *pgxpool.Pool, userID string, attachments []models.OutgoingAttachment) {
+	var ids []string
+	for _, attachment := range attachments {
+		if attachment.UploadID != "" {
+			ids = append(ids, attachment.UploadID)
+		}
+	}
+	if _, err := db.DeleteAttachmentUploads(ctx, pool, userID, ids); err != nil {
+		slog.WarnContext(ctx, "Failed to delete sent attachment uploads", "error", err)
+	}
+}
+
+// resolveAttachmentUploads fills in the attachments that reference an upload of the user with the upload's file.
+// Returns an error message for the "attachments" field if an upload doesn't exist, or an error if loading fails.
+func resolveAttachmentUploads(ctx context.Context, pool *pgxpool.Pool, userID string, attachments []models.OutgoingAttachment) (string, error) {
+	var ids []string
+	for _, attachment := range attachments {
+		if attachment.UploadID == "" {
+			continue
+		}
+		if uuid.Validate(attachment.UploadID) != nil {
+			return fmt.Sprintf("unknown upload ID %q", attachment.UploadID), nil
+		}
+		ids = append(ids, attachment.UploadID)
+	}
+	if len(ids) == 0 {
+		return "", nil
+	}
+
+	uploads, err := db.GetAttachmentUploads(ctx, pool, userID, ids)
+	if err != nil {
+		return "", err
+	}
+	for i := range attachments {
+		if attachments[i].UploadID == "" {
+			continue
+		}
+		upload, ok := uploads[attachments[i].UploadID]
+		if !ok {
+			return fmt.Sprintf("unknown upload ID %q, it might have expired", attachments[i].UploadID), nil
+		}
+		attachments[i].Filename = upload.Filename
+		attachments[i].ContentType = upload.ContentType
+		attachments[i].Data = upload.Data
+	}
+	return "", nil
+}
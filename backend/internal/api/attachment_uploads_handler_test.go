@@ -0,0 +1,123 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestAttachmentUploadsHandler(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	encryptor := getTestEncryptor(t)
+	email := "attachment-uploads-test@example.com"
+	userID := setupTestUserAndSettings(t, pool, encryptor, email)
+
+	handler := NewAttachmentUploadsHandler(pool, 10, 15)
+
+	upload := func(t *testing.T, filename, contentType, content string) *httptest.ResponseRecorder {
+		t.Helper()
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="file"; filename="`+filename+`"`)
+		if contentType != "" {
+			header.Set("Content-Type", contentType)
+		}
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			t.Fatalf("Failed to create part: %v", err)
+		}
+		_, _ = part.Write([]byte(content))
+		_ = writer.Close()
+
+		req := httptest.NewRequest("POST", "/api/v1/attachments/upload", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		ctx := context.WithValue(req.Context(), auth.UserEmailKey, email)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		handler.UploadAttachment(rr, req)
+		return rr
+	}
+
+	var uploaded models.AttachmentUpload
+
+	t.Run("stages the file", func(t *testing.T) {
+		rr := upload(t, "notes.txt", "text/plain", "hello")
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &uploaded); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if uploaded.ID == "" || uploaded.Filename != "notes.txt" || uploaded.ContentType != "text/plain" || uploaded.SizeBytes != 5 {
+			t.Errorf("Unexpected upload: %+v", uploaded)
+		}
+	})
+
+	t.Run("detects a missing content type", func(t *testing.T) {
+		rr := upload(t, "page.html", "", "<html>")
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var detected models.AttachmentUpload
+		_ = json.Unmarshal(rr.Body.Bytes(), &detected)
+		if detected.ContentType != "text/html; charset=utf-8" {
+			t.Errorf("Expected the detected type, got %q", detected.ContentType)
+		}
+	})
+
+	t.Run("rejects files over the size limit", func(t *testing.T) {
+		rr := upload(t, "big.txt", "text/plain", "hello world")
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("Expected status 413, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("rejects files over the quota", func(t *testing.T) {
+		rr := upload(t, "more.txt", "text/plain", "hello")
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("Expected status 413, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var response models.ErrorResponse
+		_ = json.Unmarshal(rr.Body.Bytes(), &response)
+		if response.Code != ErrorCodeAttachmentQuotaExceeded {
+			t.Errorf("Expected code %q, got %q", ErrorCodeAttachmentQuotaExceeded, response.Code)
+		}
+	})
+
+	t.Run("rejects files without a filename or a valid type", func(t *testing.T) {
+		if rr := upload(t, "", "text/plain", "a"); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 without a filename, got %d", rr.Code)
+		}
+		if rr := upload(t, "a.txt", "not a type", "a"); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for an invalid type, got %d", rr.Code)
+		}
+	})
+
+	t.Run("resolves uploads for sending", func(t *testing.T) {
+		attachments := []models.OutgoingAttachment{{UploadID: uploaded.ID}}
+		fieldError, err := resolveAttachmentUploads(context.Background(), pool, userID, attachments)
+		if err != nil || fieldError != "" {
+			t.Fatalf("Expected the upload to resolve, got %q, %v", fieldError, err)
+		}
+		if attachments[0].Filename != "notes.txt" || string(attachments[0].Data) != "hello" {
+			t.Errorf("Expected the attachment to get the upload's file, got %+v", attachments[0])
+		}
+
+		fieldError, err = resolveAttachmentUploads(context.Background(), pool, userID, []models.OutgoingAttachment{{UploadID: "00000000-0000-0000-0000-000000000000"}})
+		if err != nil || fieldError == "" {
+			t.Errorf("Expected a field error for an unknown upload, got %q, %v", fieldError, err)
+		}
+	})
+}
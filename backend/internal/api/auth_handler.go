@@ -2,7 +2,7 @@ package api
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -27,14 +27,14 @@ func (h *AuthHandler) GetAuthStatus(w http.ResponseWriter, r *http.Request) {
 
 	email, ok := auth.GetUserEmailFromContext(ctx)
 	if !ok {
-		log.Println("AuthHandler: No user email in context")
+		slog.ErrorContext(ctx, "AuthHandler: No user email in context")
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
 	isSetupComplete, err := h.checkSetupComplete(ctx, email)
 	if err != nil {
-		log.Printf("AuthHandler: Failed to check setup status: %v", err)
+		slog.ErrorContext(ctx, "AuthHandler: Failed to check setup status", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
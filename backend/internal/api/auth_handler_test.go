@@ -53,15 +53,13 @@ func TestAuthHandler_GetAuthStatus(t *testing.T) {
 		}
 
 		settings := &models.UserSettings{
-			UserID:                   userID,
-			UndoSendDelaySeconds:     20,
-			PaginationThreadsPerPage: 100,
-			IMAPServerHostname:       "imap.example.com",
-			IMAPUsername:             "user",
-			EncryptedIMAPPassword:    []byte("encrypted"),
-			SMTPServerHostname:       "smtp.example.com",
-			SMTPUsername:             "user",
-			EncryptedSMTPPassword:    []byte("encrypted"),
+			UserID:                userID,
+			IMAPServerHostname:    "imap.example.com",
+			IMAPUsername:          "user",
+			EncryptedIMAPPassword: []byte("encrypted"),
+			SMTPServerHostname:    "smtp.example.com",
+			SMTPUsername:          "user",
+			EncryptedSMTPPassword: []byte("encrypted"),
 		}
 		if err := db.SaveUserSettings(ctx, pool, settings); err != nil {
 			t.Fatalf("Failed to save settings: %v", err)
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/vdavid/vmail/backend/internal/autoconfig"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// AutodiscoverHandler finds the IMAP and SMTP servers of email addresses, to prefill the settings form.
+type AutodiscoverHandler struct {
+	discoverer *autoconfig.Discoverer
+}
+
+// NewAutodiscoverHandler creates a new AutodiscoverHandler instance.
+func NewAutodiscoverHandler(discoverer *autoconfig.Discoverer) *AutodiscoverHandler {
+	return &AutodiscoverHandler{
+		discoverer: discoverer,
+	}
+}
+
+// Autodiscover returns the servers of the address in the "email" query parameter.
+// Returns 400 for invalid addresses, and 404 if we couldn't find the servers, so the user has to type them.
+// The path is /api/v1/settings/autodiscover.
+func (h *AutodiscoverHandler) Autodiscover(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	email := strings.TrimSpace(r.URL.Query().Get("email"))
+	if email == "" {
+		http.Error(w, "email query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := h.discoverer.Discover(ctx, email)
+	if err != nil {
+		switch {
+		case errors.Is(err, autoconfig.ErrInvalidEmail):
+			http.Error(w, "Invalid email address", http.StatusBadRequest)
+		case errors.Is(err, autoconfig.ErrNotFound):
+			http.Error(w, "No settings found for this email address", http.StatusNotFound)
+		default:
+			slog.ErrorContext(ctx, "AutodiscoverHandler: Failed to discover settings", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	response := models.AutodiscoverResponse{
+		IMAPServerHostname: settings.IMAPServerHostname,
+		IMAPUsername:       settings.IMAPUsername,
+		SMTPServerHostname: settings.SMTPServerHostname,
+		SMTPUsername:       settings.SMTPUsername,
+		Source:             settings.Source,
+	}
+	if !WriteJSONResponse(w, response) {
+		return
+	}
+}
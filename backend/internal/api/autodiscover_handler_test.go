@@ -0,0 +1,45 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/autoconfig"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+func TestAutodiscoverHandler_Autodiscover(t *testing.T) {
+	handler := NewAutodiscoverHandler(autoconfig.NewDiscoverer())
+
+	t.Run("returns the servers of a known provider", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/settings/autodiscover?email=someone@gmail.com", nil)
+		rr := httptest.NewRecorder()
+		handler.Autodiscover(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+		var response models.AutodiscoverResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if response.IMAPServerHostname != "imap.gmail.com:993" || response.SMTPUsername != "someone@gmail.com" ||
+			response.Source != autoconfig.SourceProvider {
+			t.Errorf("Unexpected response: %+v", response)
+		}
+	})
+
+	t.Run("returns 400 without a valid email", func(t *testing.T) {
+		for _, query := range []string{"", "?email=", "?email=not-an-address"} {
+			req := httptest.NewRequest("GET", "/api/v1/settings/autodiscover"+query, nil)
+			rr := httptest.NewRecorder()
+			handler.Autodiscover(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400 for %q, got %d", query, rr.Code)
+			}
+		}
+	})
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/mail"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// BlockedSendersHandler handles the block list at /api/v1/blocked-senders.
+type BlockedSendersHandler struct {
+	pool *pgxpool.Pool
+}
+
+// NewBlockedSendersHandler creates a new BlockedSendersHandler instance.
+func NewBlockedSendersHandler(pool *pgxpool.Pool) *BlockedSendersHandler {
+	return &BlockedSendersHandler{
+		pool: pool,
+	}
+}
+
+// GetBlockedSenders returns the blocked senders of the current user.
+func (h *BlockedSendersHandler) GetBlockedSenders(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	senders, err := db.GetBlockedSenders(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "BlockedSendersHandler: Failed to get blocked senders", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !WriteJSONResponse(w, senders) {
+		return
+	}
+}
+
+// CreateBlockedSender blocks a sender. New INBOX messages from them go to Trash or Spam during sync.
+func (h *BlockedSendersHandler) CreateBlockedSender(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	sender := &models.BlockedSender{}
+	if !h.applyBlockedSenderRequest(w, r, sender) {
+		return
+	}
+
+	err := db.CreateBlockedSender(ctx, h.pool, userID, sender)
+	if !h.handleSaveError(r.Context(), w, err) {
+		return
+	}
+
+	WriteJSONResponseWithStatus(w, http.StatusCreated, sender)
+}
+
+// UpdateBlockedSender replaces the address and action of a blocked sender.
+// The path is /api/v1/blocked-senders/{id}.
+func (h *BlockedSendersHandler) UpdateBlockedSender(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	senderID, ok := getUUIDFromPath(r.URL.Path, "/api/v1/blocked-senders/", "")
+	if !ok {
+		http.Error(w, "Blocked sender not found", http.StatusNotFound)
+		return
+	}
+
+	sender := &models.BlockedSender{ID: senderID}
+	if !h.applyBlockedSenderRequest(w, r, sender) {
+		return
+	}
+
+	err := db.UpdateBlockedSender(ctx, h.pool, userID, sender)
+	if !h.handleSaveError(r.Context(), w, err) {
+		return
+	}
+
+	if !WriteJSONResponse(w, sender) {
+		return
+	}
+}
+
+// DeleteBlockedSender unblocks a sender. The path is /api/v1/blocked-senders/{id}.
+// Messages that we already moved to Trash or Spam stay there.
+func (h *BlockedSendersHandler) DeleteBlockedSender(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	senderID, ok := getUUIDFromPath(r.URL.Path, "/api/v1/blocked-senders/", "")
+	if !ok {
+		http.Error(w, "Blocked sender not found", http.StatusNotFound)
+		return
+	}
+
+	err := db.DeleteBlockedSender(ctx, h.pool, userID, senderID)
+	if errors.Is(err, db.ErrBlockedSenderNotFound) {
+		http.Error(w, "Blocked sender not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "BlockedSendersHandler: Failed to delete blocked sender", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyBlockedSenderRequest decodes and validates the request body, and applies it to the sender.
+// If the request is invalid, it writes an error response and returns false.
+func (h *BlockedSendersHandler) applyBlockedSenderRequest(w http.ResponseWriter, r *http.Request, sender *models.BlockedSender) bool {
+	var req models.BlockedSenderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.InfoContext(r.Context(), "BlockedSendersHandler: Failed to decode request", "error", err)
+		writeInvalidBodyError(w, err)
+		return false
+	}
+
+	if req.Action == "" {
+		req.Action = models.BlockedSenderActionTrash
+	}
+	if fieldErrors := validateBlockedSenderRequest(&req); len(fieldErrors) > 0 {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid blocked sender",
+			Fields: fieldErrors,
+		})
+		return false
+	}
+
+	sender.Email = req.Email
+	sender.Action = req.Action
+	return true
+}
+
+// handleSaveError writes the error response for a failed save, if any. Returns true if there was no error.
+func (h *BlockedSendersHandler) handleSaveError(ctx context.Context, w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, db.ErrBlockedSenderExists):
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid blocked sender",
+			Fields: map[string]string{"email": "is already blocked"},
+		})
+	case errors.Is(err, db.ErrBlockedSenderNotFound):
+		http.Error(w, "Blocked sender not found", http.StatusNotFound)
+	default:
+		slog.ErrorContext(ctx, "BlockedSendersHandler: Failed to save blocked sender", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+	return false
+}
+
+// validateBlockedSenderRequest checks the fields of a blocked sender request.
+// Returns a map of invalid fields to error messages, which is empty if the request is valid.
+func validateBlockedSenderRequest(req *models.BlockedSenderRequest) map[string]string {
+	fieldErrors := map[string]string{}
+
+	if req.Email == "" {
+		fieldErrors["email"] = "is required"
+	} else if address, err := mail.ParseAddress(req.Email); err != nil || address.Address != req.Email {
+		fieldErrors["email"] = "must be an email address, like name@example.com"
+	}
+
+	if req.Action != models.BlockedSenderActionTrash && req.Action != models.BlockedSenderActionSpam {
+		fieldErrors["action"] = `must be "trash" or "spam"`
+	}
+
+	return fieldErrors
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestValidateBlockedSenderRequest(t *testing.T) {
+	testCases := []struct {
+		name          string
+		req           models.BlockedSenderRequest
+		invalidFields []string
+	}{
+		{"accepts trash", models.BlockedSenderRequest{Email: "spammer@example.com", Action: "trash"}, nil},
+		{"accepts spam", models.BlockedSenderRequest{Email: "spammer@example.com", Action: "spam"}, nil},
+		{"requires an email", models.BlockedSenderRequest{Action: "trash"}, []string{"email"}},
+		{"rejects names in the email", models.BlockedSenderRequest{Email: "Spam <spammer@example.com>", Action: "trash"}, []string{"email"}},
+		{"rejects unknown actions", models.BlockedSenderRequest{Email: "spammer@example.com", Action: "archive"}, []string{"action"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fieldErrors := validateBlockedSenderRequest(&tc.req)
+			if len(fieldErrors) != len(tc.invalidFields) {
+				t.Fatalf("Expected invalid fields %v, got %v", tc.invalidFields, fieldErrors)
+			}
+			for _, field := range tc.invalidFields {
+				if _, ok := fieldErrors[field]; !ok {
+					t.Errorf("Expected %s to be invalid, got %v", field, fieldErrors)
+				}
+			}
+		})
+	}
+}
+
+func TestBlockedSendersHandler(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	email := "blocked-senders-user@example.com"
+	setupTestUserAndSettings(t, pool, getTestEncryptor(t), email)
+
+	handler := NewBlockedSendersHandler(pool)
+	serve := func(method, path, body string, fn func(http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		fn(rr, req)
+		return rr
+	}
+
+	var created models.BlockedSender
+
+	t.Run("blocks a sender, moving to Trash by default", func(t *testing.T) {
+		rr := serve("POST", "/api/v1/blocked-senders", `{"email": "Spammer@Example.com"}`, handler.CreateBlockedSender)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if created.ID == "" || created.Email != "spammer@example.com" || created.Action != models.BlockedSenderActionTrash {
+			t.Errorf("Unexpected blocked sender: %+v", created)
+		}
+	})
+
+	t.Run("rejects blocking the same sender twice", func(t *testing.T) {
+		rr := serve("POST", "/api/v1/blocked-senders", `{"email": "spammer@example.com"}`, handler.CreateBlockedSender)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("changes the action", func(t *testing.T) {
+		path := "/api/v1/blocked-senders/" + created.ID
+		rr := serve("PUT", path, `{"email": "spammer@example.com", "action": "spam"}`, handler.UpdateBlockedSender)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		rr = serve("GET", "/api/v1/blocked-senders", "", handler.GetBlockedSenders)
+		var senders []models.BlockedSender
+		if err := json.Unmarshal(rr.Body.Bytes(), &senders); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if len(senders) != 1 || senders[0].Action != models.BlockedSenderActionSpam {
+			t.Errorf("Expected the sender to go to Spam, got %+v", senders)
+		}
+	})
+
+	t.Run("unblocks a sender", func(t *testing.T) {
+		path := "/api/v1/blocked-senders/" + created.ID
+		if rr := serve("DELETE", path, "", handler.DeleteBlockedSender); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", rr.Code)
+		}
+		if rr := serve("DELETE", path, "", handler.DeleteBlockedSender); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 the second time, got %d", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// ErrorCodeRequestTooLarge is the error code of responses to requests whose body is over its limit.
+const ErrorCodeRequestTooLarge = "request_too_large"
+
+// BodyLimiter limits the size of request bodies, so that a misbehaving client can't make us read
+// unbounded data into memory. Most endpoints take small JSON bodies, while a few, like sending a message
+// with attachments, need much more, so the limit can be different for some paths.
+type BodyLimiter struct {
+	defaultLimit int64
+	pathLimits   map[string]int64
+}
+
+// NewBodyLimiter creates a limiter with defaultLimit bytes for all paths, except the ones in pathLimits.
+// pathLimits maps path prefixes to their limits. The longest matching prefix wins. A limit of 0 or less means
+// no limit.
+func NewBodyLimiter(defaultLimit int64, pathLimits map[string]int64) *BodyLimiter {
+	return &BodyLimiter{
+		defaultLimit: defaultLimit,
+		pathLimits:   pathLimits,
+	}
+}
+
+// Limit wraps a handler with the limiter. Requests that say their body is too large get a 413 right away.
+// Other bodies stop at the limit, and handlers write a 413 when they read past it. See writeInvalidBodyError.
+func (l *BodyLimiter) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := l.limitFor(r.URL.Path)
+		if limit <= 0 || r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength > limit {
+			writeRequestTooLarge(w, limit)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitFor returns the limit of the path.
+func (l *BodyLimiter) limitFor(path string) int64 {
+	limit := l.defaultLimit
+	longestPrefix := -1
+	for prefix, prefixLimit := range l.pathLimits {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longestPrefix {
+			limit = prefixLimit
+			longestPrefix = len(prefix)
+		}
+	}
+	return limit
+}
+
+// writeRequestTooLarge writes a 413 with the limit in the message.
+func writeRequestTooLarge(w http.ResponseWriter, limit int64) {
+	WriteJSONResponseWithStatus(w, http.StatusRequestEntityTooLarge, models.ErrorResponse{
+		Error: fmt.Sprintf("Request body is too large, the limit is %d bytes", limit),
+		Code:  ErrorCodeRequestTooLarge,
+	})
+}
+
+// writeInvalidBodyError writes the response for a request body that we couldn't decode:
+// a 413 if it was over its limit, or a 400 otherwise.
+func writeInvalidBodyError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeRequestTooLarge(w, maxBytesErr.Limit)
+		return
+	}
+	http.Error(w, "Invalid request body", http.StatusBadRequest)
+}
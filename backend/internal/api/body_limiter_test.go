@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+func TestBodyLimiter(t *testing.T) {
+	// decodingHandler decodes a JSON body like the API handlers do
+	decodingHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeInvalidBodyError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	limiter := NewBodyLimiter(32, map[string]int64{
+		"/api/v1/drafts":        64,
+		"/api/v1/drafts/bigger": 128,
+		"/api/v1/open":          0,
+	})
+	limited := limiter.Limit(decodingHandler)
+
+	// serve sends a body of about size bytes, without a Content-Length if chunked is true
+	serve := func(path string, size int, chunked bool) *httptest.ResponseRecorder {
+		body := `{"text": "` + strings.Repeat("a", max(size-12, 0)) + `"}`
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		if chunked {
+			req.Body = io.NopCloser(strings.NewReader(body))
+			req.ContentLength = -1
+		}
+		rr := httptest.NewRecorder()
+		limited.ServeHTTP(rr, req)
+		return rr
+	}
+
+	expectTooLarge := func(t *testing.T, rr *httptest.ResponseRecorder) {
+		t.Helper()
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("Expected status 413, got %d", rr.Code)
+		}
+		var response models.ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response.Code != ErrorCodeRequestTooLarge {
+			t.Errorf("Expected code %s, got %s", ErrorCodeRequestTooLarge, response.Code)
+		}
+	}
+
+	t.Run("allows bodies within the limit", func(t *testing.T) {
+		if rr := serve("/api/v1/settings", 30, false); rr.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", rr.Code)
+		}
+	})
+
+	t.Run("rejects large bodies by their Content-Length", func(t *testing.T) {
+		expectTooLarge(t, serve("/api/v1/settings", 40, false))
+	})
+
+	t.Run("rejects large bodies without a Content-Length while reading", func(t *testing.T) {
+		expectTooLarge(t, serve("/api/v1/settings", 40, true))
+	})
+
+	t.Run("uses the limit of the longest matching path", func(t *testing.T) {
+		if rr := serve("/api/v1/drafts/123", 60, false); rr.Code != http.StatusOK {
+			t.Errorf("Expected status 200 for drafts, got %d", rr.Code)
+		}
+		expectTooLarge(t, serve("/api/v1/drafts/123", 100, true))
+		if rr := serve("/api/v1/drafts/bigger", 100, true); rr.Code != http.StatusOK {
+			t.Errorf("Expected status 200 for the bigger limit, got %d", rr.Code)
+		}
+	})
+
+	t.Run("doesn't limit paths with a zero limit", func(t *testing.T) {
+		if rr := serve("/api/v1/open", 1000, true); rr.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", rr.Code)
+		}
+	})
+
+	t.Run("still returns 400 for invalid bodies", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/v1/settings", strings.NewReader("not json"))
+		rr := httptest.NewRecorder()
+		limited.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+}
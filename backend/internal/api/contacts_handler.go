@@ -0,0 +1,55 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// defaultContactsLimit is how many contacts we suggest if the request doesn't say.
+const defaultContactsLimit = 10
+
+// ContactsHandler handles contact autocomplete for the compose form, at /api/v1/contacts.
+// Contacts come from the cached messages, see db.RebuildContacts.
+type ContactsHandler struct {
+	pool *pgxpool.Pool
+}
+
+// NewContactsHandler creates a new ContactsHandler instance.
+func NewContactsHandler(pool *pgxpool.Pool) *ContactsHandler {
+	return &ContactsHandler{
+		pool: pool,
+	}
+}
+
+// GetContacts returns the user's best-ranked contacts that match the "query" parameter, up to "limit" of them.
+func (h *ContactsHandler) GetContacts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("query"))
+	limit := defaultContactsLimit
+	if parsed, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsed > 0 {
+		limit = min(parsed, db.MaxContactsLimit)
+	}
+
+	contacts, err := db.SearchContacts(ctx, h.pool, userID, query, limit)
+	if err != nil {
+		slog.ErrorContext(ctx, "ContactsHandler: Failed to search contacts", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !WriteJSONResponse(w, models.ContactsResponse{Contacts: contacts}) {
+		return
+	}
+}
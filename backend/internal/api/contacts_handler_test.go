@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestContactsHandler(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	email := "contacts-user@example.com"
+	userID := setupTestUserAndSettings(t, pool, getTestEncryptor(t), email)
+
+	thread := &models.Thread{UserID: userID, StableThreadID: "contacts-handler", Subject: "Hi"}
+	if err := db.SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+	for i, from := range []string{"Alice <alice@example.com>", "alan@example.com", "bob@example.com"} {
+		sentAt := time.Now()
+		msg := &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         int64(i + 1),
+			IMAPFolderName:  "INBOX",
+			MessageIDHeader: from,
+			FromAddress:     from,
+			ToAddresses:     []string{email},
+			SentAt:          &sentAt,
+		}
+		if err := db.SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+	}
+	if err := db.RebuildContacts(ctx, pool, userID, nil); err != nil {
+		t.Fatalf("RebuildContacts failed: %v", err)
+	}
+
+	handler := NewContactsHandler(pool)
+	getContacts := func(t *testing.T, query string) models.ContactsResponse {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/v1/contacts?"+query, nil)
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		handler.GetContacts(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var response models.ContactsResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		return response
+	}
+
+	t.Run("finds contacts by prefix", func(t *testing.T) {
+		if response := getContacts(t, "query=al"); len(response.Contacts) != 2 {
+			t.Errorf("Expected alice and alan, got %+v", response.Contacts)
+		}
+	})
+
+	t.Run("limits the results", func(t *testing.T) {
+		if response := getContacts(t, "limit=1"); len(response.Contacts) != 1 {
+			t.Errorf("Expected 1 contact, got %d", len(response.Contacts))
+		}
+	})
+
+	t.Run("returns an empty list without matches", func(t *testing.T) {
+		response := getContacts(t, "query=zed")
+		if response.Contacts == nil || len(response.Contacts) != 0 {
+			t.Errorf("Expected an empty list, got %+v", response.Contacts)
+		}
+	})
+}
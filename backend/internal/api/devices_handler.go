@@ -0,0 +1,275 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// maxDeviceNameLength is the longest device name we accept.
+const maxDeviceNameLength = 100
+
+// DevicesHandler handles the client devices of the user at /api/v1/devices.
+// The notification dispatcher targets these devices, see db.GetNotificationDevices.
+type DevicesHandler struct {
+	pool *pgxpool.Pool
+}
+
+// NewDevicesHandler creates a new DevicesHandler instance.
+func NewDevicesHandler(pool *pgxpool.Pool) *DevicesHandler {
+	return &DevicesHandler{
+		pool: pool,
+	}
+}
+
+// GetDevices returns the devices of the current user.
+func (h *DevicesHandler) GetDevices(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	devices, err := db.GetDevices(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "DevicesHandler: Failed to get devices", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !WriteJSONResponse(w, devices) {
+		return
+	}
+}
+
+// RegisterDevice registers a device. If the user already has a device with the same push subscription or token,
+// it updates that one and returns 200 instead of 201.
+func (h *DevicesHandler) RegisterDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	var req models.DeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.InfoContext(ctx, "DevicesHandler: Failed to decode request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	device := &models.Device{
+		Name:                 strings.TrimSpace(req.Name),
+		Platform:             req.Platform,
+		NotificationsEnabled: req.NotificationsEnabled == nil || *req.NotificationsEnabled,
+		ImportantOnly:        req.ImportantOnly != nil && *req.ImportantOnly,
+	}
+	fieldErrors := validateDeviceName(device.Name)
+	if device.Platform != models.DevicePlatformWeb && device.Platform != models.DevicePlatformAndroid && device.Platform != models.DevicePlatformIOS {
+		fieldErrors["platform"] = `must be "web", "android", or "ios"`
+	} else {
+		applyDevicePush(fieldErrors, device, req.PushSubscription, req.PushToken)
+	}
+	if len(fieldErrors) > 0 {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid device",
+			Fields: fieldErrors,
+		})
+		return
+	}
+
+	created, err := db.RegisterDevice(ctx, h.pool, userID, device)
+	if err != nil {
+		slog.ErrorContext(ctx, "DevicesHandler: Failed to register device", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	WriteJSONResponseWithStatus(w, status, device)
+}
+
+// UpdateDevice updates the name, push subscription or token, and notification preferences of a device.
+// Omitted fields stay untouched. The path is /api/v1/devices/{id}.
+func (h *DevicesHandler) UpdateDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	deviceID, ok := getUUIDFromPath(r.URL.Path, "/api/v1/devices/", "")
+	if !ok {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	var req models.DeviceUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.InfoContext(ctx, "DevicesHandler: Failed to decode request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	device, err := db.GetDevice(ctx, h.pool, userID, deviceID)
+	if errors.Is(err, db.ErrDeviceNotFound) {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "DevicesHandler: Failed to get device", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	fieldErrors := map[string]string{}
+	if req.Name != nil {
+		device.Name = strings.TrimSpace(*req.Name)
+		fieldErrors = validateDeviceName(device.Name)
+	}
+	if req.PushSubscription != nil || req.PushToken != nil {
+		pushToken := ""
+		if req.PushToken != nil {
+			pushToken = *req.PushToken
+		}
+		applyDevicePush(fieldErrors, device, req.PushSubscription, pushToken)
+	}
+	if req.NotificationsEnabled != nil {
+		device.NotificationsEnabled = *req.NotificationsEnabled
+	}
+	if req.ImportantOnly != nil {
+		device.ImportantOnly = *req.ImportantOnly
+	}
+	if len(fieldErrors) > 0 {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid device",
+			Fields: fieldErrors,
+		})
+		return
+	}
+
+	err = db.UpdateDevice(ctx, h.pool, userID, device)
+	switch {
+	case errors.Is(err, db.ErrDeviceNotFound):
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	case errors.Is(err, db.ErrDeviceExists):
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid device",
+			Fields: map[string]string{"push_subscription": "belongs to another device, so revoke that one first"},
+		})
+		return
+	case err != nil:
+		slog.ErrorContext(ctx, "DevicesHandler: Failed to update device", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !WriteJSONResponse(w, device) {
+		return
+	}
+}
+
+// RevokeDevice deletes a device, for example, a lost phone, so it gets no more notifications.
+// The path is /api/v1/devices/{id}.
+func (h *DevicesHandler) RevokeDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	deviceID, ok := getUUIDFromPath(r.URL.Path, "/api/v1/devices/", "")
+	if !ok {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	err := db.DeleteDevice(ctx, h.pool, userID, deviceID)
+	if errors.Is(err, db.ErrDeviceNotFound) {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "DevicesHandler: Failed to delete device", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateDeviceName checks the name of a device.
+// Returns a map of invalid fields to error messages, which is empty if the name is valid.
+func validateDeviceName(name string) map[string]string {
+	fieldErrors := map[string]string{}
+	if name == "" {
+		fieldErrors["name"] = "is required"
+	} else if len([]rune(name)) > maxDeviceNameLength {
+		fieldErrors["name"] = "must be at most 100 characters"
+	}
+	return fieldErrors
+}
+
+// applyDevicePush validates the push subscription or token for the platform of the device, and applies it.
+// Web devices can only have a subscription, Android and iOS devices only a token. Sending neither turns push off.
+// Adds the invalid fields to fieldErrors.
+func applyDevicePush(fieldErrors map[string]string, device *models.Device, subscription *models.PushSubscription, token string) {
+	device.PushEndpoint = ""
+	device.PushP256dh = nil
+	device.PushAuth = nil
+
+	if device.Platform != models.DevicePlatformWeb {
+		if subscription != nil {
+			fieldErrors["push_subscription"] = "is only for web devices, so send push_token instead"
+			return
+		}
+		device.PushEndpoint = strings.TrimSpace(token)
+		return
+	}
+
+	if token != "" {
+		fieldErrors["push_token"] = "is only for Android and iOS devices, so send push_subscription instead"
+		return
+	}
+	if subscription == nil {
+		return
+	}
+
+	endpoint, err := url.Parse(subscription.Endpoint)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+		fieldErrors["push_subscription"] = "must have an HTTPS endpoint"
+		return
+	}
+	p256dh, p256dhErr := decodePushKey(subscription.Keys.P256dh)
+	auth, authErr := decodePushKey(subscription.Keys.Auth)
+	if p256dhErr != nil || authErr != nil || len(p256dh) != 65 || len(auth) != 16 {
+		fieldErrors["push_subscription"] = "must have a 65-byte p256dh key and a 16-byte auth secret"
+		return
+	}
+
+	device.PushEndpoint = subscription.Endpoint
+	device.PushP256dh = p256dh
+	device.PushAuth = auth
+}
+
+// decodePushKey decodes a key of a push subscription. Browsers send them base64url-encoded without padding,
+// but some libraries add the padding.
+func decodePushKey(key string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(key, "="))
+}
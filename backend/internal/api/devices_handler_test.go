@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+// testPushSubscription returns a web push subscription with keys of the right size, as JSON.
+func testPushSubscription(endpoint string) string {
+	p256dh := base64.RawURLEncoding.EncodeToString(append([]byte{0x04}, make([]byte, 64)...))
+	auth := base64.RawURLEncoding.EncodeToString(make([]byte, 16))
+	return `{"endpoint": "` + endpoint + `", "keys": {"p256dh": "` + p256dh + `", "auth": "` + auth + `"}}`
+}
+
+func TestApplyDevicePush(t *testing.T) {
+	subscription := func(t *testing.T, endpoint string) *models.PushSubscription {
+		t.Helper()
+		var s models.PushSubscription
+		if err := json.Unmarshal([]byte(testPushSubscription(endpoint)), &s); err != nil {
+			t.Fatalf("Failed to unmarshal subscription: %v", err)
+		}
+		return &s
+	}
+
+	t.Run("accepts a web push subscription", func(t *testing.T) {
+		device := &models.Device{Platform: models.DevicePlatformWeb}
+		fieldErrors := map[string]string{}
+		applyDevicePush(fieldErrors, device, subscription(t, "https://push.example.com/abc"), "")
+		if len(fieldErrors) > 0 {
+			t.Fatalf("Expected no errors, got %v", fieldErrors)
+		}
+		if device.PushEndpoint != "https://push.example.com/abc" || len(device.PushP256dh) != 65 || len(device.PushAuth) != 16 {
+			t.Errorf("Unexpected device: %+v", device)
+		}
+	})
+
+	t.Run("accepts padded keys", func(t *testing.T) {
+		s := subscription(t, "https://push.example.com/abc")
+		s.Keys.Auth += "=="
+		fieldErrors := map[string]string{}
+		applyDevicePush(fieldErrors, &models.Device{Platform: models.DevicePlatformWeb}, s, "")
+		if len(fieldErrors) > 0 {
+			t.Errorf("Expected no errors, got %v", fieldErrors)
+		}
+	})
+
+	testCases := []struct {
+		name         string
+		platform     string
+		subscription *models.PushSubscription
+		token        string
+		invalidField string
+	}{
+		{"rejects HTTP endpoints", models.DevicePlatformWeb, subscription(t, "http://push.example.com/abc"), "", "push_subscription"},
+		{"rejects short keys", models.DevicePlatformWeb, &models.PushSubscription{Endpoint: "https://push.example.com/abc"}, "", "push_subscription"},
+		{"rejects tokens for web", models.DevicePlatformWeb, nil, "token", "push_token"},
+		{"rejects subscriptions for phones", models.DevicePlatformIOS, subscription(t, "https://push.example.com/abc"), "", "push_subscription"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fieldErrors := map[string]string{}
+			applyDevicePush(fieldErrors, &models.Device{Platform: tc.platform}, tc.subscription, tc.token)
+			if _, ok := fieldErrors[tc.invalidField]; !ok || len(fieldErrors) != 1 {
+				t.Errorf("Expected %s to be invalid, got %v", tc.invalidField, fieldErrors)
+			}
+		})
+	}
+
+	t.Run("turns push off without a subscription or token", func(t *testing.T) {
+		device := &models.Device{Platform: models.DevicePlatformAndroid, PushEndpoint: "old-token"}
+		applyDevicePush(map[string]string{}, device, nil, "")
+		if device.PushEndpoint != "" {
+			t.Errorf("Expected no push endpoint, got %q", device.PushEndpoint)
+		}
+	})
+}
+
+func TestDevicesHandler(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	email := "devices-user@example.com"
+	setupTestUserAndSettings(t, pool, getTestEncryptor(t), email)
+
+	handler := NewDevicesHandler(pool)
+	serve := func(method, path, body string, fn func(http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		fn(rr, req)
+		return rr
+	}
+
+	var created models.Device
+	body := `{"name": "Firefox", "platform": "web", "push_subscription": ` + testPushSubscription("https://push.example.com/abc") + `}`
+
+	t.Run("registers a device", func(t *testing.T) {
+		rr := serve("POST", "/api/v1/devices", body, handler.RegisterDevice)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if created.ID == "" || !created.HasPush || !created.NotificationsEnabled || created.ImportantOnly {
+			t.Errorf("Unexpected device: %+v", created)
+		}
+		if strings.Contains(rr.Body.String(), "push.example.com") {
+			t.Error("Expected the response not to contain the push endpoint")
+		}
+	})
+
+	t.Run("returns the same device when registering again", func(t *testing.T) {
+		rr := serve("POST", "/api/v1/devices", body, handler.RegisterDevice)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var device models.Device
+		if err := json.Unmarshal(rr.Body.Bytes(), &device); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if device.ID != created.ID {
+			t.Errorf("Expected ID %s, got %s", created.ID, device.ID)
+		}
+	})
+
+	t.Run("rejects unknown platforms", func(t *testing.T) {
+		rr := serve("POST", "/api/v1/devices", `{"name": "Fridge", "platform": "fridge"}`, handler.RegisterDevice)
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "platform") {
+			t.Errorf("Expected a 400 about the platform, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("updates notification preferences", func(t *testing.T) {
+		rr := serve("PATCH", "/api/v1/devices/"+created.ID, `{"important_only": true}`, handler.UpdateDevice)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var device models.Device
+		if err := json.Unmarshal(rr.Body.Bytes(), &device); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if !device.ImportantOnly || device.Name != "Firefox" || !device.HasPush {
+			t.Errorf("Expected only important_only to change, got %+v", device)
+		}
+	})
+
+	t.Run("revokes a device", func(t *testing.T) {
+		path := "/api/v1/devices/" + created.ID
+		if rr := serve("DELETE", path, "", handler.RevokeDevice); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", rr.Code)
+		}
+		if rr := serve("DELETE", path, "", handler.RevokeDevice); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 the second time, got %d", rr.Code)
+		}
+		if rr := serve("PATCH", path, `{"name": "Gone"}`, handler.UpdateDevice); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 when updating, got %d", rr.Code)
+		}
+	})
+}
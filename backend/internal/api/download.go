@@ -0,0 +1,98 @@
+package api
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/vdavid/vmail/backend/internal/imageproxy"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxDownloadFilenameBytes keeps suggested filenames within the limit of common file systems.
+const maxDownloadFilenameBytes = 255
+
+// fallbackDownloadFilename is the filename we suggest if the sender's one is empty after sanitizing.
+const fallbackDownloadFilename = "attachment"
+
+// riskyExtensions are the extensions of files that browsers can run scripts in, or that are executables.
+// Files with these never show inline, even with an image type.
+var riskyExtensions = map[string]bool{
+	".htm": true, ".html": true, ".xhtml": true, ".shtml": true, ".svg": true, ".svgz": true, ".xml": true,
+	".js": true, ".mjs": true, ".hta": true, ".exe": true, ".msi": true, ".bat": true, ".cmd": true, ".com": true,
+	".scr": true, ".ps1": true, ".vbs": true, ".jar": true,
+}
+
+// writeDownloadHeaders sets the headers for serving a file that came in an email.
+// It sanitizes the filename, and forces a download for everything but raster images even if inline is true, since
+// senders choose the type, and any XML type, like "application/foo+xml", can be a document that runs scripts.
+// It always sets nosniff, so browsers don't guess a more dangerous type than the one we send, and a CSP that blocks
+// scripts, like the image proxy does, in case a browser renders the file anyway.
+func writeDownloadHeaders(w http.ResponseWriter, filename, contentType string, inline bool) {
+	filename = sanitizeDownloadFilename(filename)
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "application/octet-stream"
+		contentType = mediaType
+	}
+
+	disposition := "attachment"
+	if inline && isInlineSafe(mediaType, filename) {
+		disposition = "inline"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
+
+// isInlineSafe tells whether a file may be shown rather than downloaded: only raster images, by their media type and
+// extension.
+func isInlineSafe(mediaType, filename string) bool {
+	return imageproxy.IsImageType(mediaType) && !riskyExtensions[strings.ToLower(path.Ext(filename))]
+}
+
+// sanitizeDownloadFilename makes a filename from an email safe to suggest to browsers.
+// It keeps only the last path element, removes control and bidi characters (which can disguise extensions,
+// for example, "invoice‮fdp.exe"), normalizes the Unicode, and trims leading and trailing dots and spaces.
+func sanitizeDownloadFilename(filename string) string {
+	filename = norm.NFC.String(filename)
+	if i := strings.LastIndexAny(filename, `/\`); i >= 0 {
+		filename = filename[i+1:]
+	}
+
+	filename = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, filename)
+	filename = strings.Trim(filename, ". ")
+
+	if len(filename) > maxDownloadFilenameBytes {
+		filename = truncateFilename(filename, maxDownloadFilenameBytes)
+	}
+	if filename == "" {
+		return fallbackDownloadFilename
+	}
+	return filename
+}
+
+// truncateFilename shortens a filename to at most maxBytes, keeping the extension, and not splitting characters.
+func truncateFilename(filename string, maxBytes int) string {
+	ext := path.Ext(filename)
+	if len(ext) >= maxBytes/2 {
+		ext = ""
+	}
+	base := strings.TrimSuffix(filename, ext)
+	limit := maxBytes - len(ext)
+	for limit > 0 && !utf8.RuneStart(base[limit]) {
+		limit--
+	}
+	return base[:limit] + ext
+}
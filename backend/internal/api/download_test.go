@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeDownloadFilename(t *testing.T) {
+	testCases := []struct {
+		name     string
+		filename string
+		want     string
+	}{
+		{"keeps normal filenames", "Quarterly report.pdf", "Quarterly report.pdf"},
+		{"strips Unix paths", "../../etc/passwd", "passwd"},
+		{"strips Windows paths", `C:\Users\me\notes.txt`, "notes.txt"},
+		{"removes control characters", "notes\r\n.txt", "notes.txt"},
+		{"removes bidi overrides", "invoice\u202Efdp.exe", "invoicefdp.exe"},
+		{"normalizes Unicode", "cafe\u0301.txt", "caf\u00e9.txt"},
+		{"trims dots and spaces", " ..hidden. ", "hidden"},
+		{"falls back for empty names", "../", "attachment"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := sanitizeDownloadFilename(tc.filename); got != tc.want {
+				t.Errorf("sanitizeDownloadFilename(%q) = %q, want %q", tc.filename, got, tc.want)
+			}
+		})
+	}
+
+	t.Run("truncates long names and keeps the extension", func(t *testing.T) {
+		got := sanitizeDownloadFilename(strings.Repeat("é", 200) + ".pdf")
+		if len(got) > maxDownloadFilenameBytes || !strings.HasSuffix(got, ".pdf") || !utf8.ValidString(got) {
+			t.Errorf("Expected a valid name of at most %d bytes ending in .pdf, got %d bytes: %q",
+				maxDownloadFilenameBytes, len(got), got)
+		}
+	})
+}
+
+func TestWriteDownloadHeaders(t *testing.T) {
+	testCases := []struct {
+		name            string
+		filename        string
+		contentType     string
+		inline          bool
+		wantType        string
+		wantDisposition string
+	}{
+		{"shows safe types inline", "photo.jpg", "image/jpeg", true, "image/jpeg", `inline; filename=photo.jpg`},
+		{"downloads when not inline", "photo.jpg", "image/jpeg", false, "image/jpeg", `attachment; filename=photo.jpg`},
+		{"downloads HTML", "page.html", "text/html; charset=utf-8", true, "text/html; charset=utf-8", `attachment; filename=page.html`},
+		{"downloads SVG", "logo.svg", "image/svg+xml", true, "image/svg+xml", `attachment; filename=logo.svg`},
+		{"downloads risky extensions with generic types", "setup.exe", "application/octet-stream", true, "application/octet-stream", `attachment; filename=setup.exe`},
+		{"downloads PDFs", "report.pdf", "application/pdf", true, "application/pdf", `attachment; filename=report.pdf`},
+		{"downloads XML documents", "page", "application/foo+xml", true, "application/foo+xml", `attachment; filename=page`},
+		{"downloads XSL", "style.xsl", "text/xsl", true, "text/xsl", `attachment; filename=style.xsl`},
+		{"downloads risky extensions with image types", "page.html", "image/png", true, "image/png", `attachment; filename=page.html`},
+		{"replaces invalid content types", "file.bin", "not a type", true, "application/octet-stream", `attachment; filename=file.bin`},
+		{"encodes non-ASCII filenames", "café.txt", "text/plain", false, "text/plain", `attachment; filename*=utf-8''caf%C3%A9.txt`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			writeDownloadHeaders(rr, tc.filename, tc.contentType, tc.inline)
+
+			if got := rr.Header().Get("Content-Type"); got != tc.wantType {
+				t.Errorf("Expected Content-Type %q, got %q", tc.wantType, got)
+			}
+			if got := rr.Header().Get("Content-Disposition"); got != tc.wantDisposition {
+				t.Errorf("Expected Content-Disposition %q, got %q", tc.wantDisposition, got)
+			}
+			if got := rr.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("Expected nosniff, got %q", got)
+			}
+			if got := rr.Header().Get("Content-Security-Policy"); got != "default-src 'none'; sandbox" {
+				t.Errorf("Expected a sandboxing CSP, got %q", got)
+			}
+		})
+	}
+}
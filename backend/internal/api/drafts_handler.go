@@ -0,0 +1,334 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/smtp"
+)
+
+// draftSyncTimeout limits how long saving a draft to the IMAP Drafts folder can take.
+const draftSyncTimeout = 2 * time.Minute
+
+// DraftsHandler handles draft-related API requests.
+// Drafts are saved to Postgres first, so that autosave is fast, and then to the IMAP Drafts folder in the background.
+type DraftsHandler struct {
+	pool        *pgxpool.Pool
+	imapService imap.IMAPService
+	// draftLocks maps draft IDs to a *sync.Mutex, so that the IMAP syncs of a draft don't overlap.
+	draftLocks sync.Map
+}
+
+// NewDraftsHandler creates a new DraftsHandler instance.
+func NewDraftsHandler(pool *pgxpool.Pool, imapService imap.IMAPService) *DraftsHandler {
+	return &DraftsHandler{
+		pool:        pool,
+		imapService: imapService,
+	}
+}
+
+// GetDrafts returns all drafts of the user, most recently saved first.
+func (h *DraftsHandler) GetDrafts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	drafts, err := db.GetDrafts(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "DraftsHandler: Failed to get drafts", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	WriteJSONResponse(w, models.DraftsResponse{Drafts: drafts})
+}
+
+// GetDraft returns one draft. The path is /api/v1/drafts/{id}.
+func (h *DraftsHandler) GetDraft(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+	draftID, ok := getDraftIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	draft, err := db.GetDraft(ctx, h.pool, userID, draftID)
+	if err != nil {
+		writeDraftError(r.Context(), w, "get", err)
+		return
+	}
+
+	WriteJSONResponse(w, draft)
+}
+
+// CreateDraft saves a new draft, and returns it with 201.
+func (h *DraftsHandler) CreateDraft(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+	loginEmail, _ := auth.GetUserEmailFromContext(ctx)
+
+	draft, ok := decodeDraft(w, r)
+	if !ok {
+		return
+	}
+
+	// The Message-ID identifies the IMAP copy, so it stays the same for the life of the draft
+	from, err := h.senderAddress(ctx, userID, loginEmail)
+	if err != nil {
+		slog.ErrorContext(ctx, "DraftsHandler: Failed to get sender address", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	draft.MessageIDHeader, err = smtp.NewMessageID(from.Address)
+	if err != nil {
+		slog.ErrorContext(ctx, "DraftsHandler: Failed to create a Message-ID", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.CreateDraft(ctx, h.pool, userID, draft); err != nil {
+		slog.ErrorContext(ctx, "DraftsHandler: Failed to create draft", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.saveDraftToIMAPInBackground(userID, loginEmail, draft.ID)
+	WriteJSONResponseWithStatus(w, http.StatusCreated, draft)
+}
+
+// UpdateDraft replaces the content of a draft, for example, on autosave. The path is /api/v1/drafts/{id}.
+func (h *DraftsHandler) UpdateDraft(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+	loginEmail, _ := auth.GetUserEmailFromContext(ctx)
+	draftID, ok := getDraftIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	draft, ok := decodeDraft(w, r)
+	if !ok {
+		return
+	}
+	draft.ID = draftID
+
+	if err := db.UpdateDraft(ctx, h.pool, userID, draft); err != nil {
+		writeDraftError(r.Context(), w, "update", err)
+		return
+	}
+
+	h.saveDraftToIMAPInBackground(userID, loginEmail, draft.ID)
+	WriteJSONResponse(w, draft)
+}
+
+// DeleteDraft deletes a draft, for example, when the user discards it. The path is /api/v1/drafts/{id}.
+func (h *DraftsHandler) DeleteDraft(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+	draftID, ok := getDraftIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	draft, err := db.DeleteDraft(ctx, h.pool, userID, draftID)
+	if err != nil {
+		writeDraftError(r.Context(), w, "delete", err)
+		return
+	}
+
+	h.deleteDraftFromIMAPInBackground(userID, draft)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// saveDraftToIMAPInBackground saves the latest version of the draft to the IMAP Drafts folder.
+// It reads the draft from the database when it gets its turn, so quick autosaves are coalesced,
+// and drafts deleted in the meantime are skipped.
+func (h *DraftsHandler) saveDraftToIMAPInBackground(userID, loginEmail, draftID string) {
+	go func() {
+		unlock := h.lockDraft(draftID)
+		defer unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), draftSyncTimeout)
+		defer cancel()
+
+		draft, err := db.GetDraft(ctx, h.pool, userID, draftID)
+		if errors.Is(err, db.ErrDraftNotFound) {
+			return
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "DraftsHandler: Failed to get draft to save it to IMAP", "draft_id", draftID, "error", err)
+			return
+		}
+
+		from, err := h.senderAddress(ctx, userID, loginEmail)
+		if err != nil {
+			slog.ErrorContext(ctx, "DraftsHandler: Failed to get sender address", "error", err)
+			return
+		}
+		email := draftToOutgoingEmail(draft)
+		uploadError, err := resolveAttachmentUploads(ctx, h.pool, userID, email.Attachments)
+		if err != nil || uploadError != "" {
+			// Save the rest of the draft, so that the text isn't lost because of an expired file
+			slog.WarnContext(ctx, "DraftsHandler: Failed to attach uploads to draft", "draft_id", draftID, "error", err, "reason", uploadError)
+			email.Attachments = slices.DeleteFunc(email.Attachments, func(a models.OutgoingAttachment) bool { return a.Filename == "" })
+		}
+		msg, err := smtp.BuildDraft(from, email, draft.LastSavedAt, draft.MessageIDHeader)
+		if err != nil {
+			slog.WarnContext(ctx, "DraftsHandler: Failed to build draft", "draft_id", draftID, "error", err)
+			return
+		}
+		if err := h.imapService.SaveDraft(ctx, userID, msg.Raw, msg.MessageID); err != nil {
+			slog.WarnContext(ctx, "DraftsHandler: Failed to save draft to IMAP", "draft_id", draftID, "error", err)
+		}
+	}()
+}
+
+// deleteDraftFromIMAPInBackground deletes the IMAP copies of a deleted draft.
+func (h *DraftsHandler) deleteDraftFromIMAPInBackground(userID string, draft *models.Draft) {
+	if draft.MessageIDHeader == "" {
+		return
+	}
+
+	go func() {
+		unlock := h.lockDraft(draft.ID)
+		defer unlock()
+		defer h.draftLocks.Delete(draft.ID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), draftSyncTimeout)
+		defer cancel()
+
+		if err := h.imapService.DeleteDraft(ctx, userID, draft.MessageIDHeader); err != nil {
+			slog.WarnContext(ctx, "DraftsHandler: Failed to delete draft from IMAP", "draft_id", draft.ID, "error", err)
+		}
+	}()
+}
+
+// lockDraft locks the draft for an IMAP sync, and returns the function that unlocks it.
+func (h *DraftsHandler) lockDraft(draftID string) func() {
+	value, _ := h.draftLocks.LoadOrStore(draftID, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// senderAddress returns the address the user sends from. See smtp.SenderAddress.
+func (h *DraftsHandler) senderAddress(ctx context.Context, userID, loginEmail string) (mail.Address, error) {
+	settings, err := db.GetUserSettings(ctx, h.pool, userID)
+	if err != nil {
+		return mail.Address{}, err
+	}
+	return smtp.SenderAddress(settings.SMTPUsername, loginEmail), nil
+}
+
+// decodeDraft reads and validates the draft in the request body.
+// If it's invalid, it writes an error response and returns false as the second value.
+func decodeDraft(w http.ResponseWriter, r *http.Request) (*models.Draft, bool) {
+	var draft models.Draft
+	if err := json.NewDecoder(r.Body).Decode(&draft); err != nil {
+		writeInvalidBodyError(w, err)
+		return nil, false
+	}
+
+	fieldErrors := map[string]string{}
+	for field, addresses := range map[string][]string{"to": draft.To, "cc": draft.Cc, "bcc": draft.Bcc} {
+		if _, err := smtp.ParseAddressList(addresses); err != nil {
+			fieldErrors[field] = err.Error()
+		}
+	}
+	if draft.AttachmentUploadIDs == nil {
+		draft.AttachmentUploadIDs = []string{}
+	}
+	for _, id := range draft.AttachmentUploadIDs {
+		if uuid.Validate(id) != nil {
+			fieldErrors["attachment_upload_ids"] = fmt.Sprintf("invalid upload ID %q", id)
+			break
+		}
+	}
+	if len(fieldErrors) > 0 {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid draft",
+			Fields: fieldErrors,
+		})
+		return nil, false
+	}
+
+	return &draft, true
+}
+
+// getDraftIDFromPath extracts the draft ID from a /api/v1/drafts/{id} path.
+// If it's not a valid ID, it writes a 400 response and returns false as the second value.
+func getDraftIDFromPath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	draftID := strings.TrimPrefix(r.URL.Path, "/api/v1/drafts/")
+	if uuid.Validate(draftID) != nil {
+		http.Error(w, "Invalid draft ID", http.StatusBadRequest)
+		return "", false
+	}
+	return draftID, true
+}
+
+// writeDraftError writes 404 for missing drafts, and 500 for other errors.
+func writeDraftError(ctx context.Context, w http.ResponseWriter, operation string, err error) {
+	if errors.Is(err, db.ErrDraftNotFound) {
+		http.Error(w, "Draft not found", http.StatusNotFound)
+		return
+	}
+	slog.ErrorContext(ctx, "DraftsHandler: Failed to "+operation+" draft", "error", err)
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+// draftToOutgoingEmail converts a draft to the message that we'd send.
+func draftToOutgoingEmail(draft *models.Draft) *models.OutgoingEmail {
+	return &models.OutgoingEmail{
+		To:          draft.To,
+		Cc:          draft.Cc,
+		Bcc:         draft.Bcc,
+		Subject:     draft.Subject,
+		TextBody:    draft.BodyText,
+		HTMLBody:    draft.BodyHTML,
+		InReplyTo:   draft.InReplyToMessageID,
+		Attachments: uploadAttachments(draft.AttachmentUploadIDs),
+	}
+}
+
+// uploadAttachments returns attachments that reference the given uploads. See resolveAttachmentUploads.
+func uploadAttachments(uploadIDs []string) []models.OutgoingAttachment {
+	attachments := make([]models.OutgoingAttachment, 0, len(uploadIDs))
+	for _, id := range uploadIDs {
+		attachments = append(attachments, models.OutgoingAttachment{UploadID: id})
+	}
+	return attachments
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+// mockIMAPServiceForDrafts reports the draft syncs, which happen in the background.
+type mockIMAPServiceForDrafts struct {
+	mockIMAPService
+	saved   chan []byte
+	deleted chan string
+}
+
+func (m *mockIMAPServiceForDrafts) SaveDraft(_ context.Context, _ string, raw []byte, _ string) error {
+	m.saved <- raw
+	return nil
+}
+
+func (m *mockIMAPServiceForDrafts) DeleteDraft(_ context.Context, _ string, messageID string) error {
+	m.deleted <- messageID
+	return nil
+}
+
+func TestDraftsHandler(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	encryptor := getTestEncryptor(t)
+	email := "drafts-test@example.com"
+	setupTestUserAndSettings(t, pool, encryptor, email)
+
+	mockIMAP := &mockIMAPServiceForDrafts{saved: make(chan []byte, 10), deleted: make(chan string, 10)}
+	handler := NewDraftsHandler(pool, mockIMAP)
+
+	request := func(handlerFunc http.HandlerFunc, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		ctx := context.WithValue(req.Context(), auth.UserEmailKey, email)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		handlerFunc(rr, req)
+		return rr
+	}
+	waitForSave := func(t *testing.T, wantContent string) {
+		t.Helper()
+		select {
+		case raw := <-mockIMAP.saved:
+			if !strings.Contains(string(raw), wantContent) {
+				t.Errorf("Expected the IMAP copy to contain %q, got:\n%s", wantContent, raw)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the draft to be saved to IMAP")
+		}
+	}
+
+	var draft models.Draft
+
+	t.Run("creates a draft and saves it to IMAP", func(t *testing.T) {
+		rr := request(handler.CreateDraft, "POST", "/api/v1/drafts", `{"to": ["alice@example.com"], "subject": "Plans", "body_text": "Hi"}`)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &draft); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if draft.ID == "" || draft.MessageIDHeader == "" {
+			t.Errorf("Expected an ID and a Message-ID, got %+v", draft)
+		}
+
+		waitForSave(t, "Subject: Plans")
+	})
+
+	t.Run("updates the draft", func(t *testing.T) {
+		rr := request(handler.UpdateDraft, "PUT", "/api/v1/drafts/"+draft.ID, `{"to": ["alice@example.com"], "subject": "New plans"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		var updated models.Draft
+		if err := json.Unmarshal(rr.Body.Bytes(), &updated); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if updated.MessageIDHeader != draft.MessageIDHeader {
+			t.Errorf("Expected the Message-ID to stay %s, got %s", draft.MessageIDHeader, updated.MessageIDHeader)
+		}
+
+		waitForSave(t, "Subject: New plans")
+	})
+
+	t.Run("lists the drafts", func(t *testing.T) {
+		rr := request(handler.GetDrafts, "GET", "/api/v1/drafts", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+
+		var response models.DraftsResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if len(response.Drafts) != 1 || response.Drafts[0].Subject != "New plans" {
+			t.Errorf("Expected the updated draft, got %+v", response.Drafts)
+		}
+	})
+
+	t.Run("rejects invalid addresses", func(t *testing.T) {
+		rr := request(handler.CreateDraft, "POST", "/api/v1/drafts", `{"to": ["not an address"]}`)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("rejects invalid draft IDs", func(t *testing.T) {
+		rr := request(handler.GetDraft, "GET", "/api/v1/drafts/not-a-uuid", "")
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("deletes the draft from IMAP too", func(t *testing.T) {
+		rr := request(handler.DeleteDraft, "DELETE", "/api/v1/drafts/"+draft.ID, "")
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		select {
+		case messageID := <-mockIMAP.deleted:
+			if messageID != draft.MessageIDHeader {
+				t.Errorf("Expected Message-ID %s to be deleted, got %s", draft.MessageIDHeader, messageID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the draft to be deleted from IMAP")
+		}
+
+		rr = request(handler.GetDraft, "GET", "/api/v1/drafts/"+draft.ID, "")
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rr.Code)
+		}
+	})
+}
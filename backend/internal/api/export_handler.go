@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/export"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// ExportHandler handles the data exports that users take their data out of V-Mail with, at /api/v1/export.
+type ExportHandler struct {
+	pool *pgxpool.Pool
+	// runExport builds an export. It's export.Run, except in tests.
+	runExport func(ctx context.Context, pool *pgxpool.Pool, userID, exportID string)
+}
+
+// NewExportHandler creates a new ExportHandler instance.
+func NewExportHandler(pool *pgxpool.Pool) *ExportHandler {
+	return &ExportHandler{
+		pool:      pool,
+		runExport: export.Run,
+	}
+}
+
+// StartExport starts building an export of the user's data in the background, replacing their last export,
+// and responds with its state. If an export is already being built, it responds with that one instead.
+func (h *ExportHandler) StartExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	dataExport, started, err := db.StartDataExport(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "ExportHandler: Failed to start data export", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if started {
+		go h.runExport(context.WithoutCancel(ctx), h.pool, userID, dataExport.ID)
+	}
+
+	WriteJSONResponseWithStatus(w, http.StatusAccepted, dataExport)
+}
+
+// GetExportStatus responds with the state of the user's last export.
+// The path is /api/v1/export/status.
+func (h *ExportHandler) GetExportStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	dataExport, err := db.GetDataExport(ctx, h.pool, userID)
+	if errors.Is(err, db.ErrDataExportNotFound) {
+		http.Error(w, "No export found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "ExportHandler: Failed to get data export", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !WriteJSONResponse(w, dataExport) {
+		return
+	}
+}
+
+// DownloadExport responds with the zip file of the user's last export.
+// It responds with 409 Conflict while the export is being built, and 404 Not Found if there's none, or it failed.
+func (h *ExportHandler) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	dataExport, file, err := db.OpenDataExportFile(ctx, h.pool, userID)
+	if errors.Is(err, db.ErrDataExportNotFound) {
+		status, statusErr := db.GetDataExport(ctx, h.pool, userID)
+		if statusErr == nil && status.Status == models.DataExportStatusRunning {
+			http.Error(w, "The export is still being built", http.StatusConflict)
+			return
+		}
+		http.Error(w, "No export found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "ExportHandler: Failed to get data export file", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = file.Close() }()
+
+	filename := "vmail-export.zip"
+	if dataExport.FinishedAt != nil {
+		filename = "vmail-export-" + dataExport.FinishedAt.Format("2006-01-02") + ".zip"
+	}
+	writeDownloadHeaders(w, filename, "application/zip", false)
+	w.Header().Set("Content-Length", strconv.FormatInt(dataExport.SizeBytes, 10))
+	if _, err := io.Copy(w, file); err != nil {
+		slog.WarnContext(ctx, "ExportHandler: Failed to write data export", "error", err)
+	}
+}
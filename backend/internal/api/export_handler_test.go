@@ -0,0 +1,92 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/export"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestExportHandler(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	email := "export-user@example.com"
+	userID := setupTestUserAndSettings(t, pool, getTestEncryptor(t), email)
+
+	started := make(chan string, 1)
+	handler := NewExportHandler(pool)
+	handler.runExport = func(_ context.Context, _ *pgxpool.Pool, _ string, exportID string) {
+		started <- exportID
+	}
+	serve := func(method, path string, fn func(http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		fn(rr, createRequestWithUser(method, path, email))
+		return rr
+	}
+
+	t.Run("returns 404 before the first export", func(t *testing.T) {
+		if rr := serve("GET", "/api/v1/export/status", handler.GetExportStatus); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rr.Code)
+		}
+		if rr := serve("GET", "/api/v1/export", handler.DownloadExport); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rr.Code)
+		}
+	})
+
+	var dataExport models.DataExport
+	t.Run("starts an export, and returns it while it's being built", func(t *testing.T) {
+		for range 2 {
+			rr := serve("POST", "/api/v1/export", handler.StartExport)
+			if rr.Code != http.StatusAccepted {
+				t.Fatalf("Expected status 202, got %d: %s", rr.Code, rr.Body.String())
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &dataExport); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+		}
+		if len(started) != 1 || <-started != dataExport.ID || dataExport.Status != models.DataExportStatusRunning {
+			t.Errorf("Expected one running export, got %+v", dataExport)
+		}
+		if rr := serve("GET", "/api/v1/export", handler.DownloadExport); rr.Code != http.StatusConflict {
+			t.Errorf("Expected status 409 while building, got %d", rr.Code)
+		}
+	})
+
+	t.Run("downloads the export once it's built", func(t *testing.T) {
+		export.Run(context.Background(), pool, userID, dataExport.ID)
+
+		rr := serve("GET", "/api/v1/export/status", handler.GetExportStatus)
+		var status models.DataExport
+		if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if status.Status != models.DataExportStatusDone || status.SizeBytes == 0 || status.ExpiresAt == nil {
+			t.Fatalf("Expected a done export, got %+v", status)
+		}
+
+		rr = serve("GET", "/api/v1/export", handler.DownloadExport)
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/zip" {
+			t.Fatalf("Expected a zip file, got status %d and %q", rr.Code, rr.Header().Get("Content-Type"))
+		}
+		archive, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+		if err != nil {
+			t.Fatalf("Failed to read zip file: %v", err)
+		}
+		files := make(map[string]bool)
+		for _, f := range archive.File {
+			files[f.Name] = true
+		}
+		if !files["settings.json"] || !files["contacts.json"] || !files["labels.json"] {
+			t.Errorf("Expected the JSON files in the export, got %v", files)
+		}
+	})
+}
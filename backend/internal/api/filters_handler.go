@@ -0,0 +1,323 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/crypto"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// maxFilterFieldLength is the longest name, condition, or folder of a filter we accept, in characters.
+const maxFilterFieldLength = 200
+
+// filterTestLimit is how many matches testing a filter returns at most.
+const filterTestLimit = 50
+
+// sieveSyncTimeout limits how long writing changed filters to the user's Sieve script can take.
+const sieveSyncTimeout = time.Minute
+
+// FiltersHandler handles the mail filters of the user at /api/v1/filters.
+// The sync applies them to new INBOX messages, see imap.Service. Users who synced them with their Sieve script get
+// the changes there too, see SieveHandler.SyncFilters.
+type FiltersHandler struct {
+	pool      *pgxpool.Pool
+	encryptor *crypto.Encryptor
+}
+
+// NewFiltersHandler creates a new FiltersHandler instance.
+func NewFiltersHandler(pool *pgxpool.Pool, encryptor *crypto.Encryptor) *FiltersHandler {
+	return &FiltersHandler{
+		pool:      pool,
+		encryptor: encryptor,
+	}
+}
+
+// GetFilters returns the filters of the current user, the oldest first, which is the order the sync applies them in.
+func (h *FiltersHandler) GetFilters(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	filters, err := db.GetFilters(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "FiltersHandler: Failed to get filters", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !WriteJSONResponse(w, filters) {
+		return
+	}
+}
+
+// CreateFilter saves a new filter.
+func (h *FiltersHandler) CreateFilter(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	var req models.FilterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.InfoContext(ctx, "FiltersHandler: Failed to decode request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	filter := &models.Filter{}
+	if !applyFilterRequest(w, filter, &req, validateFilterRequest(&req)) {
+		return
+	}
+
+	if err := db.CreateFilter(ctx, h.pool, userID, filter); err != nil {
+		slog.ErrorContext(ctx, "FiltersHandler: Failed to create filter", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.syncSieveInBackground(userID)
+
+	WriteJSONResponseWithStatus(w, http.StatusCreated, filter)
+}
+
+// UpdateFilter replaces a filter. The path is /api/v1/filters/{id}.
+func (h *FiltersHandler) UpdateFilter(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	filterID, ok := getUUIDFromPath(r.URL.Path, "/api/v1/filters/", "")
+	if !ok {
+		http.Error(w, "Filter not found", http.StatusNotFound)
+		return
+	}
+
+	var req models.FilterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.InfoContext(ctx, "FiltersHandler: Failed to decode request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	filter := &models.Filter{ID: filterID}
+	if !applyFilterRequest(w, filter, &req, validateFilterRequest(&req)) {
+		return
+	}
+
+	err := db.UpdateFilter(ctx, h.pool, userID, filter)
+	if errors.Is(err, db.ErrFilterNotFound) {
+		http.Error(w, "Filter not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "FiltersHandler: Failed to update filter", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.syncSieveInBackground(userID)
+
+	if !WriteJSONResponse(w, filter) {
+		return
+	}
+}
+
+// DeleteFilter deletes a filter. The path is /api/v1/filters/{id}.
+func (h *FiltersHandler) DeleteFilter(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	filterID, ok := getUUIDFromPath(r.URL.Path, "/api/v1/filters/", "")
+	if !ok {
+		http.Error(w, "Filter not found", http.StatusNotFound)
+		return
+	}
+
+	err := db.DeleteFilter(ctx, h.pool, userID, filterID)
+	if errors.Is(err, db.ErrFilterNotFound) {
+		http.Error(w, "Filter not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "FiltersHandler: Failed to delete filter", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.syncSieveInBackground(userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// syncSieveInBackground writes the user's filters to the V-Mail block of their Sieve script, if they synced it
+// before, so that the server runs the changed filters too. Edits of the block on the server since the last sync get
+// overwritten, since the user just changed the filters in V-Mail. Users whose active script changed since are skipped.
+func (h *FiltersHandler) syncSieveInBackground(userID string) {
+	go func() {
+		unlock := lockSieveSync(userID)
+		defer unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), sieveSyncTimeout)
+		defer cancel()
+
+		last, err := db.GetSieveFilterBlock(ctx, h.pool, userID)
+		if errors.Is(err, db.ErrSieveFilterBlockNotFound) {
+			return
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "FiltersHandler: Failed to get the Sieve filter block", "error", err)
+			return
+		}
+		vacation, err := getSieveVacation(ctx, h.pool, userID)
+		if err != nil {
+			slog.WarnContext(ctx, "FiltersHandler: Failed to get the auto-responder", "error", err)
+			return
+		}
+
+		c, err := dialSieve(ctx, h.pool, h.encryptor, userID)
+		if err != nil {
+			slog.WarnContext(ctx, "FiltersHandler: Failed to connect to the ManageSieve server", "error", err)
+			return
+		}
+		defer func() {
+			if err := c.Logout(); err != nil {
+				slog.WarnContext(ctx, "FiltersHandler: Failed to log out of the ManageSieve server", "error", err)
+			}
+		}()
+
+		script, err := getActiveScript(c)
+		if err != nil {
+			slog.WarnContext(ctx, "FiltersHandler: Failed to get the active Sieve script", "error", err)
+			return
+		}
+		if !script.Active || script.Name != last.ScriptName {
+			return
+		}
+		if _, err := syncFilterBlock(ctx, h.pool, c, userID, script, vacation, false); err != nil {
+			slog.WarnContext(ctx, "FiltersHandler: Failed to write the filters to the Sieve script", "error", err)
+		}
+	}()
+}
+
+// TestFilter returns the cached INBOX messages that the conditions of a filter match, the newest first, without
+// saving the filter or doing anything to the messages. The actions are ignored, so they can be left out.
+// The path is /api/v1/filters/test.
+func (h *FiltersHandler) TestFilter(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	var req models.FilterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.InfoContext(ctx, "FiltersHandler: Failed to decode request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	filter := &models.Filter{}
+	if !applyFilterRequest(w, filter, &req, validateFilterConditions(&req)) {
+		return
+	}
+
+	matches, truncated, err := db.GetFilterMatches(ctx, h.pool, userID, filter, filterTestLimit)
+	if err != nil {
+		slog.ErrorContext(ctx, "FiltersHandler: Failed to test filter", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !WriteJSONResponse(w, models.FilterTestResponse{Matches: matches, Truncated: truncated}) {
+		return
+	}
+}
+
+// applyFilterRequest applies the request to the filter if there are no field errors.
+// Otherwise, it writes an error response and returns false.
+func applyFilterRequest(w http.ResponseWriter, filter *models.Filter, req *models.FilterRequest, fieldErrors map[string]string) bool {
+	if len(fieldErrors) > 0 {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid filter",
+			Fields: fieldErrors,
+		})
+		return false
+	}
+
+	filter.Name = strings.TrimSpace(req.Name)
+	filter.From = strings.TrimSpace(req.From)
+	filter.To = strings.TrimSpace(req.To)
+	filter.Subject = strings.TrimSpace(req.Subject)
+	filter.HasAttachment = req.HasAttachment
+	filter.MarkRead = req.MarkRead
+	if label := strings.TrimSpace(req.AddLabel); label != "" {
+		filter.AddLabel = imap.CanonicalLabel(label)
+	}
+	filter.MoveTo = strings.TrimSpace(req.MoveTo)
+	filter.Delete = req.Delete
+	return true
+}
+
+// validateFilterRequest checks the fields of a filter request.
+// Returns a map of invalid fields to error messages, which is empty if the request is valid.
+func validateFilterRequest(req *models.FilterRequest) map[string]string {
+	fieldErrors := validateFilterConditions(req)
+
+	label := strings.TrimSpace(req.AddLabel)
+	moveTo := strings.TrimSpace(req.MoveTo)
+	if !req.MarkRead && label == "" && moveTo == "" && !req.Delete {
+		fieldErrors["actions"] = "at least one action is required"
+	}
+	if label != "" && !imap.IsValidLabel(label) {
+		fieldErrors["add_label"] = "must be a valid label"
+	}
+	if len([]rune(moveTo)) > maxFilterFieldLength {
+		fieldErrors["move_to"] = fmt.Sprintf("must be at most %d characters", maxFilterFieldLength)
+	} else if strings.EqualFold(moveTo, "INBOX") {
+		fieldErrors["move_to"] = "must be a folder other than INBOX"
+	} else if moveTo != "" && req.Delete {
+		fieldErrors["move_to"] = "can't be set when the filter deletes messages"
+	}
+
+	return fieldErrors
+}
+
+// validateFilterConditions checks the name and conditions of a filter request, which is all that testing a filter
+// needs. Returns a map of invalid fields to error messages, which is empty if they're valid.
+func validateFilterConditions(req *models.FilterRequest) map[string]string {
+	fieldErrors := map[string]string{}
+
+	for field, value := range map[string]string{"name": req.Name, "from": req.From, "to": req.To, "subject": req.Subject} {
+		if len([]rune(strings.TrimSpace(value))) > maxFilterFieldLength {
+			fieldErrors[field] = fmt.Sprintf("must be at most %d characters", maxFilterFieldLength)
+		}
+	}
+
+	if strings.TrimSpace(req.From) == "" && strings.TrimSpace(req.To) == "" && strings.TrimSpace(req.Subject) == "" && req.HasAttachment == nil {
+		fieldErrors["conditions"] = "at least one condition is required"
+	}
+
+	return fieldErrors
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestValidateFilterRequest(t *testing.T) {
+	hasAttachment := true
+	testCases := []struct {
+		name          string
+		req           models.FilterRequest
+		invalidFields []string
+	}{
+		{"accepts a moving filter", models.FilterRequest{From: "news@example.com", MoveTo: "Newsletters"}, nil},
+		{"accepts an attachment condition", models.FilterRequest{HasAttachment: &hasAttachment, AddLabel: "Files"}, nil},
+		{"requires a condition", models.FilterRequest{MarkRead: true}, []string{"conditions"}},
+		{"doesn't count blank conditions", models.FilterRequest{Subject: "  ", MarkRead: true}, []string{"conditions"}},
+		{"requires an action", models.FilterRequest{Subject: "invoice"}, []string{"actions"}},
+		{"rejects invalid labels", models.FilterRequest{Subject: "invoice", AddLabel: "two words"}, []string{"add_label"}},
+		{"rejects moving to INBOX", models.FilterRequest{Subject: "invoice", MoveTo: "inbox"}, []string{"move_to"}},
+		{"rejects moving and deleting", models.FilterRequest{Subject: "invoice", MoveTo: "Archive", Delete: true}, []string{"move_to"}},
+		{
+			"rejects long conditions",
+			models.FilterRequest{To: strings.Repeat("x", maxFilterFieldLength+1), Delete: true},
+			[]string{"to"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fieldErrors := validateFilterRequest(&tc.req)
+			if len(fieldErrors) != len(tc.invalidFields) {
+				t.Fatalf("Expected invalid fields %v, got %v", tc.invalidFields, fieldErrors)
+			}
+			for _, field := range tc.invalidFields {
+				if _, ok := fieldErrors[field]; !ok {
+					t.Errorf("Expected %s to be invalid, got %v", field, fieldErrors)
+				}
+			}
+		})
+	}
+}
+
+func TestFiltersHandler(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	email := "filters-user@example.com"
+	handler := NewFiltersHandler(pool, getTestEncryptor(t))
+	serve := func(method, path, body string, fn func(http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		fn(rr, req)
+		return rr
+	}
+
+	var created models.Filter
+
+	t.Run("creates a filter", func(t *testing.T) {
+		body := `{"name": "Invoices", "subject": "invoice", "add_label": "Invoices", "mark_read": true}`
+		rr := serve("POST", "/api/v1/filters", body, handler.CreateFilter)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if created.ID == "" || created.AddLabel != "invoices" || !created.MarkRead {
+			t.Errorf("Expected the filter with a canonical label, got %+v", created)
+		}
+	})
+
+	t.Run("rejects invalid filters", func(t *testing.T) {
+		rr := serve("POST", "/api/v1/filters", `{"subject": "invoice"}`, handler.CreateFilter)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("lists filters", func(t *testing.T) {
+		rr := serve("GET", "/api/v1/filters", "", handler.GetFilters)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+		var filters []models.Filter
+		if err := json.Unmarshal(rr.Body.Bytes(), &filters); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if len(filters) != 1 || filters[0].ID != created.ID {
+			t.Errorf("Expected the created filter, got %+v", filters)
+		}
+	})
+
+	t.Run("tests a filter", func(t *testing.T) {
+		ctx := context.Background()
+		userID, err := db.GetOrCreateUser(ctx, pool, email)
+		if err != nil {
+			t.Fatalf("GetOrCreateUser failed: %v", err)
+		}
+		thread := &models.Thread{UserID: userID, StableThreadID: "<invoice@example.com>", Subject: "Your invoice"}
+		if err := db.SaveThread(ctx, pool, thread); err != nil {
+			t.Fatalf("SaveThread failed: %v", err)
+		}
+		msg := &models.Message{ThreadID: thread.ID, UserID: userID, IMAPUID: 1, IMAPFolderName: "INBOX", FromAddress: "billing@example.com", Subject: "Your invoice"}
+		if err := db.SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+
+		rr := serve("POST", "/api/v1/filters/test", `{"subject": "INVOICE"}`, handler.TestFilter)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var response models.FilterTestResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if len(response.Matches) != 1 || response.Matches[0].MessageID != msg.ID || response.Truncated {
+			t.Errorf("Expected the invoice, got %+v", response)
+		}
+
+		if rr := serve("POST", "/api/v1/filters/test", `{"mark_read": true}`, handler.TestFilter); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 without conditions, got %d", rr.Code)
+		}
+	})
+
+	t.Run("replaces a filter", func(t *testing.T) {
+		path := "/api/v1/filters/" + created.ID
+		rr := serve("PUT", path, `{"from": " news@example.com ", "move_to": "Newsletters"}`, handler.UpdateFilter)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var updated models.Filter
+		if err := json.Unmarshal(rr.Body.Bytes(), &updated); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if updated.From != "news@example.com" || updated.MoveTo != "Newsletters" || updated.Subject != "" || updated.AddLabel != "" {
+			t.Errorf("Unexpected filter: %+v", updated)
+		}
+	})
+
+	t.Run("deletes a filter", func(t *testing.T) {
+		path := "/api/v1/filters/" + created.ID
+		if rr := serve("DELETE", path, "", handler.DeleteFilter); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", rr.Code)
+		}
+		if rr := serve("DELETE", path, "", handler.DeleteFilter); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 the second time, got %d", rr.Code)
+		}
+		if rr := serve("PUT", path, `{"subject": "x", "delete": true}`, handler.UpdateFilter); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for updating it, got %d", rr.Code)
+		}
+	})
+}
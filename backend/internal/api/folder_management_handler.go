@@ -0,0 +1,238 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/models"
+	ws "github.com/vdavid/vmail/backend/internal/websocket"
+)
+
+// FolderManagementHandler handles the folders on the IMAP server: listing them with their subscriptions,
+// subscribing to them, and creating, renaming, and deleting them.
+type FolderManagementHandler struct {
+	pool    *pgxpool.Pool
+	folders imap.FolderManager
+	hub     *ws.Hub
+}
+
+// NewFolderManagementHandler creates a new FolderManagementHandler instance.
+func NewFolderManagementHandler(pool *pgxpool.Pool, folders imap.FolderManager, hub *ws.Hub) *FolderManagementHandler {
+	return &FolderManagementHandler{
+		pool:    pool,
+		folders: folders,
+		hub:     hub,
+	}
+}
+
+// ListSubscriptions lists all folders on the server, and tells which ones the user is subscribed to.
+// The path is /api/v1/folders/subscriptions.
+func (h *FolderManagementHandler) ListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	folders, err := h.folders.ListFolderSubscriptions(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "FolderManagementHandler: Failed to list folders", "error", err)
+		http.Error(w, "Failed to list folders on the mail server", http.StatusBadGateway)
+		return
+	}
+
+	if !WriteJSONResponse(w, models.FolderSubscriptionsResponse{Folders: folders}) {
+		return
+	}
+}
+
+// SetSubscribed subscribes the user to a folder with PUT, or unsubscribes them with DELETE.
+// The path is /api/v1/folders/{name}/subscription.
+func (h *FolderManagementHandler) SetSubscribed(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	folderName, err := getFolderNameFromPath(r.URL, "/subscription")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.folders.SetFolderSubscribed(ctx, userID, folderName, r.Method == http.MethodPut); err != nil {
+		h.writeFolderError(w, r, err, "change the subscription")
+		return
+	}
+
+	h.hub.Publish(userID, ws.Event{Type: ws.EventFoldersChanged, Folder: folderName})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateFolder creates a folder on the server, for example, {"name": "Projects/2026"}.
+// The user is subscribed to the new folder. The path is /api/v1/folders.
+func (h *FolderManagementHandler) CreateFolder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	var req models.FolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.InfoContext(ctx, "FolderManagementHandler: Failed to decode create request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+	if msg := validateFolderName(req.Name); msg != "" {
+		writeFolderValidationError(w, msg)
+		return
+	}
+
+	folder, err := h.folders.CreateFolder(ctx, userID, req.Name)
+	if err != nil {
+		h.writeFolderError(w, r, err, "create the folder")
+		return
+	}
+
+	h.hub.Publish(userID, ws.Event{Type: ws.EventFoldersChanged, Folder: folder.Name})
+	WriteJSONResponseWithStatus(w, http.StatusCreated, folder)
+}
+
+// RenameFolder renames a folder and its subfolders on the server and in the cache, for example, {"name": "Work"}.
+// The path is /api/v1/folders/{name}.
+func (h *FolderManagementHandler) RenameFolder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	folderName, err := getFolderNameFromPath(r.URL, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req models.FolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.InfoContext(ctx, "FolderManagementHandler: Failed to decode rename request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+	if strings.EqualFold(folderName, "INBOX") {
+		writeFolderValidationError(w, "can't be changed for INBOX")
+		return
+	}
+	if msg := validateFolderName(req.Name); msg != "" {
+		writeFolderValidationError(w, msg)
+		return
+	}
+
+	folder, err := h.folders.RenameFolder(ctx, userID, folderName, req.Name)
+	if err != nil {
+		h.writeFolderError(w, r, err, "rename the folder")
+		return
+	}
+
+	// The folder is already renamed on the server, so a failure here only costs a sync of the new name
+	if err := db.RenameCachedFolder(ctx, h.pool, userID, folderName, folder.Name, folder.Delimiter); err != nil {
+		slog.ErrorContext(ctx, "FolderManagementHandler: Failed to rename cached folder", "folder", folderName, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.hub.Publish(userID, ws.Event{Type: ws.EventFoldersChanged, Folder: folder.Name})
+	if !WriteJSONResponse(w, folder) {
+		return
+	}
+}
+
+// DeleteFolder deletes a folder and its messages on the server and in the cache. Its subfolders stay.
+// The path is /api/v1/folders/{name}.
+func (h *FolderManagementHandler) DeleteFolder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	folderName, err := getFolderNameFromPath(r.URL, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.EqualFold(folderName, "INBOX") {
+		writeFolderValidationError(w, "can't be deleted")
+		return
+	}
+
+	if err := h.folders.DeleteFolder(ctx, userID, folderName); err != nil {
+		h.writeFolderError(w, r, err, "delete the folder")
+		return
+	}
+
+	if err := db.DeleteCachedFolder(ctx, h.pool, userID, folderName); err != nil {
+		slog.ErrorContext(ctx, "FolderManagementHandler: Failed to delete cached folder", "folder", folderName, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.hub.Publish(userID, ws.Event{Type: ws.EventFoldersChanged, Folder: folderName})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeFolderError writes the response for an error of a folder operation on the server.
+// action completes "Failed to ...", for example, "delete the folder".
+func (h *FolderManagementHandler) writeFolderError(w http.ResponseWriter, r *http.Request, err error, action string) {
+	switch {
+	case errors.Is(err, imap.ErrFolderNotFound):
+		http.Error(w, "Folder not found", http.StatusNotFound)
+	case errors.Is(err, imap.ErrFolderExists):
+		http.Error(w, "A folder with this name already exists", http.StatusConflict)
+	default:
+		slog.ErrorContext(r.Context(), "FolderManagementHandler: Failed to "+action, "error", err)
+		http.Error(w, "Failed to "+action+" on the mail server", http.StatusBadGateway)
+	}
+}
+
+// writeFolderValidationError writes a validation error for the folder name.
+func writeFolderValidationError(w http.ResponseWriter, msg string) {
+	WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+		Error:  "Invalid folder",
+		Fields: map[string]string{"name": msg},
+	})
+}
+
+// validateFolderName returns an error message if users can't create or rename a folder to name, or "" if they can.
+func validateFolderName(name string) string {
+	switch {
+	case strings.TrimSpace(name) == "":
+		return "is required"
+	case strings.ContainsFunc(name, unicode.IsControl):
+		return "can't have control characters"
+	case strings.ContainsAny(name, "*%"):
+		return "can't have * or %"
+	case strings.EqualFold(name, "INBOX"):
+		return "is reserved"
+	}
+	for _, folder := range virtualFolders {
+		if name == folder.Name {
+			return "is reserved"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,28 @@
+package api
+
+import "testing"
+
+func TestValidateFolderName(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"valid name", "Projects", ""},
+		{"valid nested name", "Projects/2026", ""},
+		{"empty", "", "is required"},
+		{"only spaces", "   ", "is required"},
+		{"control character", "Pro\njects", "can't have control characters"},
+		{"LIST wildcard", "Projects*", "can't have * or %"},
+		{"INBOX", "inbox", "is reserved"},
+		{"virtual folder", "starred", "is reserved"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := validateFolderName(tc.input); got != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
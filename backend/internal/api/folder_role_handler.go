@@ -0,0 +1,110 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// overridableFolderRoles are the roles that users can give to folders by hand.
+// INBOX is always identified by name, so "inbox" isn't one of them.
+var overridableFolderRoles = []string{"sent", "drafts", "spam", "trash", "archive", "other"}
+
+// FolderRoleHandler handles the per-folder role overrides at /api/v1/folders/{name}/role.
+type FolderRoleHandler struct {
+	pool *pgxpool.Pool
+}
+
+// NewFolderRoleHandler creates a new FolderRoleHandler instance.
+func NewFolderRoleHandler(pool *pgxpool.Pool) *FolderRoleHandler {
+	return &FolderRoleHandler{
+		pool: pool,
+	}
+}
+
+// PatchFolderRole sets the role of a folder, for example, {"role": "spam"}.
+// {"role": null} removes the override, so the folder goes back to the role we detect.
+func (h *FolderRoleHandler) PatchFolderRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	folderName, err := getFolderNameFromPath(r.URL, "/role")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Decode into raw fields so that we can tell an omitted role from an explicit null
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch == nil {
+		slog.InfoContext(ctx, "FolderRoleHandler: Failed to decode patch request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	role, fieldErrors := parseFolderRolePatch(folderName, patch)
+	if len(fieldErrors) > 0 {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid folder role",
+			Fields: fieldErrors,
+		})
+		return
+	}
+
+	if role == nil {
+		err = db.DeleteFolderRoleOverride(ctx, h.pool, userID, folderName)
+	} else {
+		err = db.SaveFolderRoleOverride(ctx, h.pool, userID, folderName, *role)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "FolderRoleHandler: Failed to save folder role override", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !WriteJSONResponse(w, models.FolderRoleOverride{FolderName: folderName, Role: role}) {
+		return
+	}
+}
+
+// parseFolderRolePatch returns the role from the patch, or nil to remove the override.
+// Returns a map from field name to error message for invalid fields.
+func parseFolderRolePatch(folderName string, patch map[string]json.RawMessage) (*string, map[string]string) {
+	fieldErrors := make(map[string]string)
+	var role *string
+
+	for field, raw := range patch {
+		if field != "role" {
+			fieldErrors[field] = "unknown field"
+			continue
+		}
+		if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil || !slices.Contains(overridableFolderRoles, value) {
+			fieldErrors[field] = "must be one of " + strings.Join(overridableFolderRoles, ", ") + ", or null"
+			continue
+		}
+		role = &value
+	}
+
+	if _, exists := patch["role"]; !exists {
+		fieldErrors["role"] = "is required"
+	} else if strings.EqualFold(folderName, "INBOX") {
+		fieldErrors["role"] = "can't be changed for INBOX"
+	}
+
+	return role, fieldErrors
+}
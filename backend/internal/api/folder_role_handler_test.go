@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestParseFolderRolePatch(t *testing.T) {
+	t.Run("returns the role", func(t *testing.T) {
+		role, fieldErrors := parseFolderRolePatch("Spam", map[string]json.RawMessage{"role": json.RawMessage(`"spam"`)})
+		if len(fieldErrors) != 0 {
+			t.Fatalf("Expected no errors, got %v", fieldErrors)
+		}
+		if role == nil || *role != "spam" {
+			t.Errorf("Expected role spam, got %v", role)
+		}
+	})
+
+	t.Run("null removes the override", func(t *testing.T) {
+		role, fieldErrors := parseFolderRolePatch("Spam", map[string]json.RawMessage{"role": json.RawMessage(`null`)})
+		if len(fieldErrors) != 0 {
+			t.Fatalf("Expected no errors, got %v", fieldErrors)
+		}
+		if role != nil {
+			t.Errorf("Expected no role, got %s", *role)
+		}
+	})
+
+	testCases := []struct {
+		name       string
+		folderName string
+		patch      map[string]json.RawMessage
+		wantFields []string
+	}{
+		{"missing role", "Spam", map[string]json.RawMessage{}, []string{"role"}},
+		{"unknown role", "Spam", map[string]json.RawMessage{"role": json.RawMessage(`"junk"`)}, []string{"role"}},
+		{"inbox role", "Spam", map[string]json.RawMessage{"role": json.RawMessage(`"inbox"`)}, []string{"role"}},
+		{"INBOX folder", "inbox", map[string]json.RawMessage{"role": json.RawMessage(`"archive"`)}, []string{"role"}},
+		{"unknown field", "Spam", map[string]json.RawMessage{"role": json.RawMessage(`"spam"`), "color": json.RawMessage(`"red"`)}, []string{"color"}},
+	}
+	for _, tc := range testCases {
+		t.Run("returns errors for "+tc.name, func(t *testing.T) {
+			_, fieldErrors := parseFolderRolePatch(tc.folderName, tc.patch)
+			for _, field := range tc.wantFields {
+				if fieldErrors[field] == "" {
+					t.Errorf("Expected an error for field %s, got %v", field, fieldErrors)
+				}
+			}
+		})
+	}
+}
+
+func TestFolderRoleHandler(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	handler := NewFolderRoleHandler(pool)
+
+	patch := func(email, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", path, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		handler.PatchFolderRole(rr, req)
+		return rr
+	}
+
+	t.Run("sets and removes a role", func(t *testing.T) {
+		email := "folder-role-set@example.com"
+
+		rr := patch(email, "/api/v1/folders/Junk%20E-mail/role", `{"role": "spam"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var response models.FolderRoleOverride
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response.FolderName != "Junk E-mail" || response.Role == nil || *response.Role != "spam" {
+			t.Errorf("Unexpected response: %+v", response)
+		}
+
+		userID, err := db.GetOrCreateUser(context.Background(), pool, email)
+		if err != nil {
+			t.Fatalf("GetOrCreateUser failed: %v", err)
+		}
+		overrides, err := db.GetFolderRoleOverrides(context.Background(), pool, userID)
+		if err != nil {
+			t.Fatalf("GetFolderRoleOverrides failed: %v", err)
+		}
+		if overrides["Junk E-mail"] != "spam" {
+			t.Errorf("Expected the override to be saved, got %v", overrides)
+		}
+
+		rr = patch(email, "/api/v1/folders/Junk%20E-mail/role", `{"role": null}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		overrides, err = db.GetFolderRoleOverrides(context.Background(), pool, userID)
+		if err != nil {
+			t.Fatalf("GetFolderRoleOverrides failed: %v", err)
+		}
+		if len(overrides) != 0 {
+			t.Errorf("Expected the override to be removed, got %v", overrides)
+		}
+	})
+
+	t.Run("returns 400 for invalid roles", func(t *testing.T) {
+		rr := patch("folder-role-invalid@example.com", "/api/v1/folders/Spam/role", `{"role": "junk"}`)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("returns 400 for invalid request body", func(t *testing.T) {
+		rr := patch("folder-role-bad-body@example.com", "/api/v1/folders/Spam/role", "not json")
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+}
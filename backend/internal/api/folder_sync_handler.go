@@ -0,0 +1,206 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// FolderSyncHandler handles the per-folder sync preferences at /api/v1/folders/{name}/sync.
+type FolderSyncHandler struct {
+	pool *pgxpool.Pool
+}
+
+// NewFolderSyncHandler creates a new FolderSyncHandler instance.
+func NewFolderSyncHandler(pool *pgxpool.Pool) *FolderSyncHandler {
+	return &FolderSyncHandler{
+		pool: pool,
+	}
+}
+
+// getFolderNameFromPath extracts the folder name from a /api/v1/folders/{name}{suffix} path,
+// for example, with suffix "/sync". The name must be URL-encoded. We use the escaped path so that encoded slashes,
+// like in "[Gmail]%2FAll Mail", stay part of the name.
+func getFolderNameFromPath(u *url.URL, suffix string) (string, error) {
+	escaped, ok := strings.CutPrefix(u.EscapedPath(), "/api/v1/folders/")
+	if !ok {
+		return "", fmt.Errorf("folder name is required")
+	}
+	escaped, ok = strings.CutSuffix(escaped, suffix)
+	if !ok || escaped == "" || strings.Contains(escaped, "/") {
+		return "", fmt.Errorf("folder name is required")
+	}
+
+	name, err := url.PathUnescape(escaped)
+	if err != nil {
+		return "", fmt.Errorf("invalid folder name encoding: %w", err)
+	}
+	return name, nil
+}
+
+// GetFolderSync returns the sync preference of a folder.
+func (h *FolderSyncHandler) GetFolderSync(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	folderName, err := getFolderNameFromPath(r.URL, "/sync")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pref, err := db.GetFolderSyncPreference(ctx, h.pool, userID, folderName)
+	if err != nil {
+		slog.ErrorContext(ctx, "FolderSyncHandler: Failed to get folder sync preference", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !WriteJSONResponse(w, pref) {
+		return
+	}
+}
+
+// PatchFolderSync updates the sync preference of a folder.
+// Omitted fields are left untouched, and explicit nulls reset fields to their defaults.
+// Disabling a folder also deletes its cached messages, since the user doesn't want it cached.
+// Syncing further back makes the next sync a full sync, so that it fetches the older messages.
+// Syncing less far back leaves the older messages to the retention cleaner. See db.PruneFolderCaches.
+func (h *FolderSyncHandler) PatchFolderSync(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	folderName, err := getFolderNameFromPath(r.URL, "/sync")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Decode into raw fields so that we can tell omitted fields from explicit nulls
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch == nil {
+		slog.InfoContext(ctx, "FolderSyncHandler: Failed to decode patch request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	pref, err := db.GetFolderSyncPreference(ctx, h.pool, userID, folderName)
+	if err != nil {
+		slog.ErrorContext(ctx, "FolderSyncHandler: Failed to get folder sync preference", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	wasEnabled := pref.Enabled
+	before := *pref
+
+	if fieldErrors := applyFolderSyncPatch(pref, patch); len(fieldErrors) > 0 {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid folder sync preference",
+			Fields: fieldErrors,
+		})
+		return
+	}
+
+	// Save first, so that syncs that start from now on already skip the folder
+	if err := db.SaveFolderSyncPreference(ctx, h.pool, userID, pref); err != nil {
+		slog.ErrorContext(ctx, "FolderSyncHandler: Failed to save folder sync preference", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if wasEnabled && !pref.Enabled {
+		if err := db.ClearFolderCache(ctx, h.pool, userID, folderName); err != nil {
+			slog.ErrorContext(ctx, "FolderSyncHandler: Failed to clear cache of folder", "folder", folderName, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if pref.Enabled && syncDepthWidened(&before, pref) {
+		if err := db.ResetFolderLastSyncedUID(ctx, h.pool, userID, folderName); err != nil {
+			slog.ErrorContext(ctx, "FolderSyncHandler: Failed to reset sync state of folder", "folder", folderName, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if !WriteJSONResponse(w, pref) {
+		return
+	}
+}
+
+// syncDepthWidened returns true if the new preference syncs further back than the old one, by either limit.
+func syncDepthWidened(before, after *models.FolderSyncPreference) bool {
+	widened := func(before, after *int) bool {
+		return before != nil && (after == nil || *after > *before)
+	}
+	return widened(before.SyncDays, after.SyncDays) || widened(before.SyncMaxMessages, after.SyncMaxMessages)
+}
+
+// applyFolderSyncPatch applies the patch fields to the folder sync preference.
+// Returns a map from field name to error message for invalid fields.
+func applyFolderSyncPatch(pref *models.FolderSyncPreference, patch map[string]json.RawMessage) map[string]string {
+	fieldErrors := make(map[string]string)
+
+	for field, raw := range patch {
+		isNull := bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+
+		switch field {
+		case "enabled":
+			if isNull {
+				pref.Enabled = true
+				continue
+			}
+			if err := json.Unmarshal(raw, &pref.Enabled); err != nil {
+				fieldErrors[field] = "must be a boolean"
+			}
+		case "mode":
+			if isNull {
+				pref.Mode = models.FolderSyncModeHeadersOnly
+				continue
+			}
+			var mode string
+			if err := json.Unmarshal(raw, &mode); err != nil ||
+				(mode != models.FolderSyncModeHeadersOnly && mode != models.FolderSyncModeFull) {
+				fieldErrors[field] = fmt.Sprintf("must be %q or %q", models.FolderSyncModeHeadersOnly, models.FolderSyncModeFull)
+				continue
+			}
+			pref.Mode = mode
+		case "sync_days", "sync_max_messages":
+			limit := &pref.SyncDays
+			if field == "sync_max_messages" {
+				limit = &pref.SyncMaxMessages
+			}
+			if isNull {
+				*limit = nil
+				continue
+			}
+			var value int
+			if err := json.Unmarshal(raw, &value); err != nil || value < 1 {
+				fieldErrors[field] = "must be a positive integer or null"
+				continue
+			}
+			*limit = &value
+		default:
+			fieldErrors[field] = "unknown field"
+		}
+	}
+
+	return fieldErrors
+}
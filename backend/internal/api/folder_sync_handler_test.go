@@ -0,0 +1,222 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestGetFolderNameFromPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"simple name", "/api/v1/folders/Archive/sync", "Archive", false},
+		{"encoded slash stays in the name", "/api/v1/folders/%5BGmail%5D%2FAll%20Mail/sync", "[Gmail]/All Mail", false},
+		{"missing name", "/api/v1/folders//sync", "", true},
+		{"unencoded slash", "/api/v1/folders/a/b/sync", "", true},
+		{"wrong suffix", "/api/v1/folders/Archive", "", true},
+		{"other suffix", "/api/v1/folders/Archive/role", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.path)
+			if err != nil {
+				t.Fatalf("Failed to parse URL: %v", err)
+			}
+			got, err := getFolderNameFromPath(u, "/sync")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestApplyFolderSyncPatch(t *testing.T) {
+	t.Run("applies valid fields", func(t *testing.T) {
+		pref := &models.FolderSyncPreference{Enabled: true, Mode: models.FolderSyncModeHeadersOnly}
+		fieldErrors := applyFolderSyncPatch(pref, map[string]json.RawMessage{
+			"enabled": json.RawMessage(`false`),
+			"mode":    json.RawMessage(`"full"`),
+		})
+		if len(fieldErrors) != 0 {
+			t.Fatalf("Expected no errors, got %v", fieldErrors)
+		}
+		if pref.Enabled || pref.Mode != models.FolderSyncModeFull {
+			t.Errorf("Expected disabled full sync, got enabled=%v mode=%s", pref.Enabled, pref.Mode)
+		}
+	})
+
+	t.Run("null resets to defaults", func(t *testing.T) {
+		pref := &models.FolderSyncPreference{Enabled: false, Mode: models.FolderSyncModeFull}
+		fieldErrors := applyFolderSyncPatch(pref, map[string]json.RawMessage{
+			"enabled": json.RawMessage(`null`),
+			"mode":    json.RawMessage(`null`),
+		})
+		if len(fieldErrors) != 0 {
+			t.Fatalf("Expected no errors, got %v", fieldErrors)
+		}
+		if !pref.Enabled || pref.Mode != models.FolderSyncModeHeadersOnly {
+			t.Errorf("Expected defaults, got enabled=%v mode=%s", pref.Enabled, pref.Mode)
+		}
+	})
+
+	t.Run("sets and resets the sync depth", func(t *testing.T) {
+		pref := &models.FolderSyncPreference{Enabled: true, Mode: models.FolderSyncModeHeadersOnly}
+		fieldErrors := applyFolderSyncPatch(pref, map[string]json.RawMessage{
+			"sync_days":         json.RawMessage(`90`),
+			"sync_max_messages": json.RawMessage(`1000`),
+		})
+		if len(fieldErrors) != 0 {
+			t.Fatalf("Expected no errors, got %v", fieldErrors)
+		}
+		if pref.SyncDays == nil || *pref.SyncDays != 90 || pref.SyncMaxMessages == nil || *pref.SyncMaxMessages != 1000 {
+			t.Errorf("Expected 90 days and 1000 messages, got %v and %v", pref.SyncDays, pref.SyncMaxMessages)
+		}
+
+		fieldErrors = applyFolderSyncPatch(pref, map[string]json.RawMessage{"sync_days": json.RawMessage(`null`)})
+		if len(fieldErrors) != 0 {
+			t.Fatalf("Expected no errors, got %v", fieldErrors)
+		}
+		if pref.SyncDays != nil || pref.SyncMaxMessages == nil {
+			t.Errorf("Expected only the day limit to be reset, got %v and %v", pref.SyncDays, pref.SyncMaxMessages)
+		}
+	})
+
+	t.Run("returns errors for invalid fields", func(t *testing.T) {
+		pref := &models.FolderSyncPreference{Enabled: true, Mode: models.FolderSyncModeHeadersOnly}
+		fieldErrors := applyFolderSyncPatch(pref, map[string]json.RawMessage{
+			"enabled":           json.RawMessage(`"yes"`),
+			"mode":              json.RawMessage(`"everything"`),
+			"sync_days":         json.RawMessage(`0`),
+			"sync_max_messages": json.RawMessage(`"all"`),
+			"color":             json.RawMessage(`"red"`),
+		})
+		for _, field := range []string{"enabled", "mode", "sync_days", "sync_max_messages", "color"} {
+			if fieldErrors[field] == "" {
+				t.Errorf("Expected an error for field %s", field)
+			}
+		}
+	})
+}
+
+func TestSyncDepthWidened(t *testing.T) {
+	days := func(d int) *int { return &d }
+	tests := []struct {
+		name          string
+		before, after *models.FolderSyncPreference
+		expectWidened bool
+	}{
+		{"no limits", &models.FolderSyncPreference{}, &models.FolderSyncPreference{}, false},
+		{"adds a limit", &models.FolderSyncPreference{}, &models.FolderSyncPreference{SyncDays: days(30)}, false},
+		{"lowers a limit", &models.FolderSyncPreference{SyncDays: days(90)}, &models.FolderSyncPreference{SyncDays: days(30)}, false},
+		{"raises a limit", &models.FolderSyncPreference{SyncDays: days(30)}, &models.FolderSyncPreference{SyncDays: days(90)}, true},
+		{"removes a limit", &models.FolderSyncPreference{SyncMaxMessages: days(100)}, &models.FolderSyncPreference{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if widened := syncDepthWidened(tt.before, tt.after); widened != tt.expectWidened {
+				t.Errorf("Expected %v, got %v", tt.expectWidened, widened)
+			}
+		})
+	}
+}
+
+func TestFolderSyncHandler(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	encryptor := getTestEncryptor(t)
+	handler := NewFolderSyncHandler(pool)
+
+	patch := func(email, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", path, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		handler.PatchFolderSync(rr, req)
+		return rr
+	}
+
+	t.Run("returns defaults for folder without preference", func(t *testing.T) {
+		req := createRequestWithUser("GET", "/api/v1/folders/Archive/sync", "folder-sync-get@example.com")
+		rr := httptest.NewRecorder()
+		handler.GetFolderSync(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+		var response models.FolderSyncPreference
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if !response.Enabled || response.Mode != models.FolderSyncModeHeadersOnly || response.FolderName != "Archive" {
+			t.Errorf("Unexpected defaults: %+v", response)
+		}
+	})
+
+	t.Run("disabling a folder clears its cache", func(t *testing.T) {
+		email := "folder-sync-disable@example.com"
+		userID := setupTestUserAndSettings(t, pool, encryptor, email)
+		seedMailCache(t, pool, userID)
+
+		rr := patch(email, "/api/v1/folders/INBOX/sync", `{"enabled": false}`)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		pref, err := db.GetFolderSyncPreference(context.Background(), pool, userID, "INBOX")
+		if err != nil {
+			t.Fatalf("GetFolderSyncPreference failed: %v", err)
+		}
+		if pref.Enabled {
+			t.Error("Expected INBOX sync to be disabled")
+		}
+		if count := countCachedThreads(t, pool, userID); count != 0 {
+			t.Errorf("Expected the INBOX cache to be cleared, got %d threads", count)
+		}
+	})
+
+	t.Run("changing the mode keeps the cache", func(t *testing.T) {
+		email := "folder-sync-mode@example.com"
+		userID := setupTestUserAndSettings(t, pool, encryptor, email)
+		seedMailCache(t, pool, userID)
+
+		rr := patch(email, "/api/v1/folders/INBOX/sync", `{"mode": "full"}`)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if countCachedThreads(t, pool, userID) == 0 {
+			t.Error("Expected the cache to be kept")
+		}
+	})
+
+	t.Run("returns 400 for invalid fields", func(t *testing.T) {
+		rr := patch("folder-sync-invalid@example.com", "/api/v1/folders/INBOX/sync", `{"mode": "everything"}`)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("returns 400 for invalid request body", func(t *testing.T) {
+		rr := patch("folder-sync-bad-body@example.com", "/api/v1/folders/INBOX/sync", "not json")
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+}
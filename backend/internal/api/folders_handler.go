@@ -3,7 +3,7 @@ package api
 import (
 	"context"
 	"errors"
-	"log"
+	"log/slog"
 	"net/http"
 	"sort"
 	"strings"
@@ -45,21 +45,32 @@ func (h *FoldersHandler) GetFolders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Use WithClient to ensure the client is always released
-	err := h.imapPool.WithClient(userID, settings.IMAPServerHostname, settings.IMAPUsername, imapPassword, func(client imap.IMAPClient) error {
-		folders, err := client.ListFolders()
-		if err != nil {
-			return h.handleListFoldersError(w, userID, err, settings, imapPassword)
-		}
-
-		h.writeFoldersResponse(w, folders)
-		return nil
+	var folders []*models.Folder
+	var listErr error // The error of ListFolders in the last attempt, to tell it apart from connection errors
+	err := imap.Retry(ctx, imap.DefaultRetryPolicy, func() error {
+		listErr = nil
+		// Use WithClient to ensure the client is always released
+		return h.imapPool.WithClient(ctx, userID, imap.ServerFromSettings(settings), settings.IMAPUsername, imapPassword, func(client imap.IMAPClient) error {
+			folders, listErr = client.ListFolders()
+			return listErr
+		})
+	}, func(err error) {
+		slog.WarnContext(ctx, "FoldersHandler: Failed to list folders, retrying with new connections", "error", err)
+		// The user's other connections likely broke with this one
+		h.imapPool.RemoveClient(userID)
 	})
 
+	if listErr != nil {
+		slog.ErrorContext(ctx, "FoldersHandler: Failed to list folders", "error", listErr)
+		http.Error(w, "Failed to list folders", http.StatusInternalServerError)
+		return
+	}
 	if err != nil {
-		// Error handling is done inside the callback, so if we get here it's a connection error
-		h.handleConnectionError(w, err)
+		h.handleConnectionError(ctx, w, err)
+		return
 	}
+
+	h.writeFoldersResponse(ctx, w, userID, folders)
 }
 
 // getUserSettingsAndPassword retrieves user settings and decrypts the IMAP password.
@@ -70,14 +81,14 @@ func (h *FoldersHandler) getUserSettingsAndPassword(ctx context.Context, w http.
 			http.Error(w, "User settings not found", http.StatusNotFound)
 			return nil, "", false
 		}
-		log.Printf("FoldersHandler: Failed to get user settings: %v", err)
+		slog.ErrorContext(ctx, "FoldersHandler: Failed to get user settings", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return nil, "", false
 	}
 
 	imapPassword, err := h.encryptor.Decrypt(settings.EncryptedIMAPPassword)
 	if err != nil {
-		log.Printf("FoldersHandler: Failed to decrypt IMAP password: %v", err)
+		slog.ErrorContext(ctx, "FoldersHandler: Failed to decrypt IMAP password", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return nil, "", false
 	}
@@ -86,8 +97,8 @@ func (h *FoldersHandler) getUserSettingsAndPassword(ctx context.Context, w http.
 }
 
 // handleConnectionError handles errors when getting a client from the pool.
-func (h *FoldersHandler) handleConnectionError(w http.ResponseWriter, err error) {
-	log.Printf("FoldersHandler: Failed to get IMAP client: %v", err)
+func (h *FoldersHandler) handleConnectionError(ctx context.Context, w http.ResponseWriter, err error) {
+	slog.ErrorContext(ctx, "FoldersHandler: Failed to get IMAP client", "error", err)
 	errMsg := err.Error()
 	if strings.Contains(errMsg, "i/o timeout") {
 		http.Error(w, "Connection to IMAP server timed out. Please double-check your server hostname in your Settings and try again.", http.StatusServiceUnavailable)
@@ -96,60 +107,17 @@ func (h *FoldersHandler) handleConnectionError(w http.ResponseWriter, err error)
 	}
 }
 
-// handleListFoldersError handles errors from ListFolders, including retry logic.
-// Returns an error to propagate to the WithClient callback.
-func (h *FoldersHandler) handleListFoldersError(w http.ResponseWriter, userID string, err error, settings *models.UserSettings, imapPassword string) error {
-	log.Printf("FoldersHandler: Failed to list folders: %v", err)
-	errMsg := err.Error()
-
-	if strings.Contains(errMsg, "SPECIAL-USE") {
-		http.Error(w, "Your IMAP server doesn't support the SPECIAL-USE extension (RFC 6154), which is required for V-Mail to identify folder types. Please contact your email provider or use a different IMAP server.", http.StatusBadRequest)
-		return err // Return error to stop processing
-	}
-
-	if h.isBrokenConnectionError(errMsg) {
-		return h.retryListFolders(w, userID, settings, imapPassword)
-	}
-
-	http.Error(w, "Failed to list folders", http.StatusInternalServerError)
-	return err // Return error to stop processing
-}
-
-// isBrokenConnectionError checks if the error message indicates a broken connection
-// that can be recovered by retrying with a fresh IMAP client.
-func (h *FoldersHandler) isBrokenConnectionError(errMsg string) bool {
-	return strings.Contains(errMsg, "broken pipe") ||
-		strings.Contains(errMsg, "connection reset") ||
-		strings.Contains(errMsg, "EOF")
-}
-
-// retryListFolders retries listing folders after removing the broken connection from the pool.
-// This handles transient connection issues by getting a fresh IMAP client and retrying the operation.
-// Returns an error to propagate to the WithClient callback.
-func (h *FoldersHandler) retryListFolders(w http.ResponseWriter, userID string, settings *models.UserSettings, imapPassword string) error {
-	h.imapPool.RemoveClient(userID)
-
-	// Use WithClient for the retry to ensure release happens
-	return h.imapPool.WithClient(userID, settings.IMAPServerHostname, settings.IMAPUsername, imapPassword, func(client imap.IMAPClient) error {
-		folders, err := client.ListFolders()
-		if err != nil {
-			log.Printf("FoldersHandler: Failed to list folders on retry: %v", err)
-			if strings.Contains(err.Error(), "SPECIAL-USE") {
-				http.Error(w, "Your IMAP server doesn't support the SPECIAL-USE extension (RFC 6154), which is required for V-Mail to identify folder types. Please contact your email provider or use a different IMAP server.", http.StatusBadRequest)
-				return err
-			}
-			http.Error(w, "Failed to list folders", http.StatusInternalServerError)
-			return err
-		}
-
-		h.writeFoldersResponse(w, folders)
-		return nil
-	})
-}
-
-// writeFoldersResponse writes the folders response as JSON.
+// writeFoldersResponse applies the user's folder role overrides, and writes the folders response as JSON.
 // Uses a buffered approach to prevent partial writes if JSON encoding fails.
-func (h *FoldersHandler) writeFoldersResponse(w http.ResponseWriter, folders []*models.Folder) {
+func (h *FoldersHandler) writeFoldersResponse(ctx context.Context, w http.ResponseWriter, userID string, folders []*models.Folder) {
+	overrides, err := db.GetFolderRoleOverrides(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "FoldersHandler: Failed to get folder role overrides", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	imap.ApplyFolderRoleOverrides(folders, overrides)
+	folders = addVirtualFolders(folders)
 	sortFoldersByRole(folders)
 
 	folderValues := make([]models.Folder, len(folders))
@@ -162,17 +130,43 @@ func (h *FoldersHandler) writeFoldersResponse(w http.ResponseWriter, folders []*
 	}
 }
 
+// virtualFolders are the folders that we list from the cache, for servers that don't have them.
+var virtualFolders = []models.Folder{
+	{Name: models.StarredFolderName, Role: "starred", Virtual: true},
+	{Name: models.SnoozedFolderName, Role: "snoozed", Virtual: true},
+	{Name: models.AllMailFolderName, Role: "all", Virtual: true},
+}
+
+// addVirtualFolders adds the virtual folders whose roles no folder on the server has.
+// For example, Gmail has both "[Gmail]/Starred" and "[Gmail]/All Mail", so it gets none.
+func addVirtualFolders(folders []*models.Folder) []*models.Folder {
+	roles := make(map[string]bool, len(folders))
+	for _, folder := range folders {
+		roles[folder.Role] = true
+	}
+	for _, virtual := range virtualFolders {
+		if !roles[virtual.Role] {
+			folder := virtual
+			folders = append(folders, &folder)
+		}
+	}
+	return folders
+}
+
 // sortFoldersByRole sorts folders by role priority, then alphabetically for "other" folders.
-// Priority order: inbox, sent, drafts, spam, trash, archive, other (alphabetically).
+// Priority order: inbox, starred, snoozed, sent, drafts, spam, trash, archive, all, other (alphabetically).
 func sortFoldersByRole(folders []*models.Folder) {
 	rolePriority := map[string]int{
 		"inbox":   1,
-		"sent":    2,
-		"drafts":  3,
-		"spam":    4,
-		"trash":   5,
-		"archive": 6,
-		"other":   7,
+		"starred": 2,
+		"snoozed": 3,
+		"sent":    4,
+		"drafts":  5,
+		"spam":    6,
+		"trash":   7,
+		"archive": 8,
+		"all":     9,
+		"other":   10,
 	}
 
 	sort.Slice(folders, func(i, j int) bool {
@@ -105,11 +105,11 @@ type mockIMAPPool struct {
 	removeListenerCalled map[string]bool
 }
 
-func (m *mockIMAPPool) WithClient(userID, server, username, password string, fn func(imap.IMAPClient) error) error {
+func (m *mockIMAPPool) WithClient(_ context.Context, userID string, server imap.ServerConfig, username, password string, fn func(imap.IMAPClient) error) error {
 	m.getClientCalled = true
 	m.getClientCallCount++
 	m.getClientUserID = userID
-	m.getClientServer = server
+	m.getClientServer = server.Address
 	m.getClientUser = username
 	m.getClientPass = password
 
@@ -141,7 +141,7 @@ func (m *mockIMAPPool) RemoveClient(userID string) {
 
 func (m *mockIMAPPool) Close() {}
 
-func (m *mockIMAPPool) GetListenerConnection(string, string, string, string) (imap.ListenerClient, error) {
+func (m *mockIMAPPool) GetListenerConnection(string, imap.ServerConfig, string, string) (imap.ListenerClient, error) {
 	if m.listenerClientErr != nil {
 		return nil, m.listenerClientErr
 	}
@@ -234,8 +234,9 @@ func TestFoldersHandler_WithMocks(t *testing.T) {
 		if mockPool.getClientUserID != userID {
 			t.Errorf("Expected userID %s, got %s", userID, mockPool.getClientUserID)
 		}
-		if mockPool.getClientServer != "imap.test.com" {
-			t.Errorf("Expected server 'imap.test.com', got %s", mockPool.getClientServer)
+		// The settings have no port, so it's the default port of TLS
+		if mockPool.getClientServer != "imap.test.com:993" {
+			t.Errorf("Expected server 'imap.test.com:993', got %s", mockPool.getClientServer)
 		}
 
 		// Verify response contains folders (response is an array, not an object)
@@ -244,8 +245,8 @@ func TestFoldersHandler_WithMocks(t *testing.T) {
 			t.Fatalf("Failed to decode response: %v", err)
 		}
 
-		if len(response) != 4 {
-			t.Errorf("Expected 4 folders, got %d", len(response))
+		if len(response) != 6 {
+			t.Fatalf("Expected 4 folders and the virtual folders, got %d", len(response))
 		}
 
 		expectedFolders := []struct {
@@ -253,9 +254,11 @@ func TestFoldersHandler_WithMocks(t *testing.T) {
 			role string
 		}{
 			{"INBOX", "inbox"},
+			{models.StarredFolderName, "starred"},
 			{"Sent", "sent"},
 			{"Drafts", "drafts"},
 			{"Archive", "archive"},
+			{models.AllMailFolderName, "all"},
 		}
 		for i, expected := range expectedFolders {
 			if response[i].Name != expected.name {
@@ -328,8 +331,8 @@ func TestFoldersHandler_WithMocks(t *testing.T) {
 			t.Fatalf("Failed to decode response: %v", err)
 		}
 
-		if len(response) != 2 {
-			t.Errorf("Expected 2 folders, got %d", len(response))
+		if len(response) != 4 {
+			t.Errorf("Expected 2 folders and the virtual folders, got %d", len(response))
 		}
 	})
 
@@ -418,31 +421,6 @@ func TestFoldersHandler_WithMocks(t *testing.T) {
 		}
 	})
 
-	t.Run("returns 400 when SPECIAL-USE not supported", func(t *testing.T) {
-		mockClient := &mockIMAPClient{
-			listFoldersResult: nil,
-			listFoldersErr:    fmt.Errorf("IMAP server does not support SPECIAL-USE extension (RFC 6154), which is required for V-Mail to identify folder types"),
-		}
-
-		mockPool := &mockIMAPPool{
-			getClientResult: mockClient,
-			getClientErr:    nil,
-		}
-
-		handler := NewFoldersHandler(pool, encryptor, mockPool)
-		rr := callGetFolders(t, handler, email)
-
-		if rr.Code != http.StatusBadRequest {
-			t.Errorf("Expected status 400, got %d", rr.Code)
-		}
-
-		// Verify error message
-		body := rr.Body.String()
-		if !strings.Contains(body, "SPECIAL-USE") {
-			t.Errorf("Expected error message to mention SPECIAL-USE, got: %s", body)
-		}
-	})
-
 	t.Run("returns 500 when decrypting IMAP password fails", func(t *testing.T) {
 		email := "decrypt-error@example.com"
 		ctx := context.Background()
@@ -458,15 +436,13 @@ func TestFoldersHandler_WithMocks(t *testing.T) {
 		encryptedSMTPPassword, _ := encryptor.Encrypt("smtp_pass")
 
 		settings := &models.UserSettings{
-			UserID:                   userID,
-			UndoSendDelaySeconds:     20,
-			PaginationThreadsPerPage: 100,
-			IMAPServerHostname:       "imap.test.com",
-			IMAPUsername:             "user",
-			EncryptedIMAPPassword:    corruptedPassword,
-			SMTPServerHostname:       "smtp.test.com",
-			SMTPUsername:             "user",
-			EncryptedSMTPPassword:    encryptedSMTPPassword,
+			UserID:                userID,
+			IMAPServerHostname:    "imap.test.com",
+			IMAPUsername:          "user",
+			EncryptedIMAPPassword: corruptedPassword,
+			SMTPServerHostname:    "smtp.test.com",
+			SMTPUsername:          "user",
+			EncryptedSMTPPassword: encryptedSMTPPassword,
 		}
 		if err := db.SaveUserSettings(ctx, pool, settings); err != nil {
 			t.Fatalf("Failed to save settings: %v", err)
@@ -561,6 +537,35 @@ func TestFoldersHandler_WriteResponseErrors(t *testing.T) {
 	})
 }
 
+func TestAddVirtualFolders(t *testing.T) {
+	t.Run("adds the virtual folders", func(t *testing.T) {
+		folders := addVirtualFolders([]*models.Folder{{Name: "INBOX", Role: "inbox"}})
+		if len(folders) != 4 {
+			t.Fatalf("Expected 4 folders, got %d", len(folders))
+		}
+		if starred := folders[1]; starred.Name != models.StarredFolderName || starred.Role != "starred" || !starred.Virtual {
+			t.Errorf("Unexpected starred folder: %+v", starred)
+		}
+		if snoozed := folders[2]; snoozed.Name != models.SnoozedFolderName || snoozed.Role != "snoozed" || !snoozed.Virtual {
+			t.Errorf("Unexpected snoozed folder: %+v", snoozed)
+		}
+		if all := folders[3]; all.Name != models.AllMailFolderName || all.Role != "all" || !all.Virtual {
+			t.Errorf("Unexpected All Mail folder: %+v", all)
+		}
+	})
+
+	t.Run("keeps the server's own folders", func(t *testing.T) {
+		folders := addVirtualFolders([]*models.Folder{
+			{Name: "INBOX", Role: "inbox"},
+			{Name: "[Gmail]/Starred", Role: "starred"},
+			{Name: "[Gmail]/All Mail", Role: "all"},
+		})
+		if len(folders) != 4 {
+			t.Errorf("Expected only the snoozed folder, got %d folders", len(folders))
+		}
+	})
+}
+
 func TestSortFoldersByRole(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -609,6 +614,15 @@ func TestSortFoldersByRole(t *testing.T) {
 			},
 			expected: []string{"INBOX", "Sent", "Drafts", "Spam", "Trash", "Archive"},
 		},
+		{
+			name: "puts starred right after inbox",
+			folders: []*models.Folder{
+				{Name: "Sent", Role: "sent"},
+				{Name: "[Gmail]/Starred", Role: "starred"},
+				{Name: "INBOX", Role: "inbox"},
+			},
+			expected: []string{"INBOX", "[Gmail]/Starred", "Sent"},
+		},
 		{
 			name:     "handles empty list",
 			folders:  []*models.Folder{},
@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
-	"strconv"
+	"strings"
 
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/vdavid/vmail/backend/internal/auth"
 	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/logging"
+	"github.com/vdavid/vmail/backend/internal/pagination"
 )
 
 // GetUserIDFromContext extracts the user's email from context, resolves/creates the DB user,
@@ -20,74 +23,81 @@ import (
 func GetUserIDFromContext(ctx context.Context, w http.ResponseWriter, pool *pgxpool.Pool) (string, bool) {
 	email, ok := auth.GetUserEmailFromContext(ctx)
 	if !ok {
-		log.Println("API: No user email in context")
+		slog.ErrorContext(ctx, "API: No user email in context")
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return "", false
 	}
 
 	userID, err := db.GetOrCreateUser(ctx, pool, email)
 	if err != nil {
-		log.Printf("API: Failed to get/create user: %v", err)
+		slog.ErrorContext(ctx, "API: Failed to get/create user", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return "", false
 	}
 
+	logging.WithUserID(ctx, userID)
 	return userID, true
 }
 
-// ParsePaginationParams parses page and limit from query parameters.
-// Returns default values (page=1, limit=defaultLimit) if parameters are missing or invalid.
-// This is a shared helper function used by multiple handlers for consistent pagination parsing.
-func ParsePaginationParams(r *http.Request, defaultLimit int) (page, limit int) {
-	page = 1
-	limit = defaultLimit
-
-	if pageStr := r.URL.Query().Get("page"); pageStr != "" {
-		if parsed, err := strconv.Atoi(pageStr); err == nil && parsed > 0 {
-			page = parsed
-		}
-	}
-
-	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-		if parsed, err := strconv.Atoi(limitStr); err == nil && parsed > 0 {
-			limit = parsed
-		}
-	}
-
-	return page, limit
-}
-
 // WriteJSONResponse writes a JSON response using a buffered approach to prevent partial writes.
 // If encoding fails, it writes an error response and returns false. Otherwise returns true.
 // This ensures atomic responses and consistent error handling across all handlers.
 func WriteJSONResponse(w http.ResponseWriter, data interface{}) bool {
+	return WriteJSONResponseWithStatus(w, http.StatusOK, data)
+}
+
+// WriteJSONResponseWithStatus works like WriteJSONResponse but with a custom status code,
+// for example, to send structured error responses.
+func WriteJSONResponseWithStatus(w http.ResponseWriter, status int, data interface{}) bool {
 	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(data); err != nil {
-		log.Printf("API: Failed to encode JSON response: %v", err)
+		slog.Error("API: Failed to encode JSON response", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return false
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	if _, err := w.Write(buf.Bytes()); err != nil {
-		log.Printf("API: Failed to write JSON response: %v", err)
+		slog.Error("API: Failed to write JSON response", "error", err)
 	}
 	return true
 }
 
-// GetPaginationLimit gets the pagination limit, using user settings if available.
-// If limitFromQuery is provided (> 0), it takes precedence.
-// Otherwise, it uses the user's setting from the database, or defaults to 100.
-// This is a shared helper function used by multiple handlers for consistent pagination limit handling.
-func GetPaginationLimit(ctx context.Context, pool *pgxpool.Pool, userID string, limitFromQuery int) int {
-	if limitFromQuery > 0 {
-		return limitFromQuery
+// GetPaginationParams parses the pagination params of a list request.
+// The default limit comes from the user's preferences, and all limits are clamped to pagination.MaxLimit.
+// Writes a 400 error and returns false if the cursor is invalid.
+// This is a shared helper function used by all list handlers for consistent pagination handling.
+func GetPaginationParams(ctx context.Context, w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool, userID string) (pagination.Params, bool) {
+	params, err := pagination.Parse(r.URL.Query(), GetPaginationLimit(ctx, pool, userID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return params, false
 	}
+	return params, true
+}
 
-	settings, err := db.GetUserSettings(ctx, pool, userID)
+// GetPaginationLimit gets the user's default pagination limit from their preferences.
+// Defaults to pagination.DefaultLimit if the preferences can't be loaded.
+func GetPaginationLimit(ctx context.Context, pool *pgxpool.Pool, userID string) int {
+	prefs, err := db.GetUserPreferences(ctx, pool, userID)
 	if err == nil {
-		return settings.PaginationThreadsPerPage
+		return pagination.ClampLimit(prefs.PaginationThreadsPerPage)
 	}
 
-	return 100
+	return pagination.DefaultLimit
+}
+
+// getUUIDFromPath extracts the ID from a {prefix}{id}{suffix} path, like /api/v1/filters/{id}, or
+// /api/v1/messages/{id}/raw. Our IDs are UUIDs, so it returns false for anything else, and for other paths.
+func getUUIDFromPath(path, prefix, suffix string) (string, bool) {
+	id, found := strings.CutPrefix(path, prefix)
+	if !found {
+		return "", false
+	}
+	id, found = strings.CutSuffix(id, suffix)
+	if !found || uuid.Validate(id) != nil {
+		return "", false
+	}
+	return id, true
 }
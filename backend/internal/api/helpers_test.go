@@ -0,0 +1,33 @@
+package api
+
+import "testing"
+
+func TestGetUUIDFromPath(t *testing.T) {
+	id := "0b8f5a4e-2d6c-4a47-9f4e-3c1f9a0e7b21"
+	tests := []struct {
+		path   string
+		prefix string
+		suffix string
+		wantID string
+		wantOK bool
+	}{
+		{"/api/v1/filters/" + id, "/api/v1/filters/", "", id, true},
+		{"/api/v1/messages/" + id + "/cancel", "/api/v1/messages/", "/cancel", id, true},
+		{"/api/v1/filters/", "/api/v1/filters/", "", "", false},
+		{"/api/v1/filters/nope", "/api/v1/filters/", "", "", false},
+		{"/other/" + id, "/api/v1/filters/", "", "", false},
+		{"/api/v1/messages/" + id, "/api/v1/messages/", "/cancel", "", false},
+		{"/api/v1/messages/" + id + "/cancel", "/api/v1/messages/", "", "", false},
+		{"/api/v1/messages/123/cancel", "/api/v1/messages/", "/cancel", "", false},
+		{"/api/v1/messages//cancel", "/api/v1/messages/", "/cancel", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok := getUUIDFromPath(tt.path, tt.prefix, tt.suffix)
+			if got != tt.wantID || ok != tt.wantOK {
+				t.Errorf("getUUIDFromPath(%q, %q, %q) = %q, %v, want %q, %v", tt.path, tt.prefix, tt.suffix, got, ok, tt.wantID, tt.wantOK)
+			}
+		})
+	}
+}
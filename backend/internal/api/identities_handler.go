@@ -0,0 +1,305 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/crypto"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/smtp"
+)
+
+// maxSignatureLength is the longest signature we accept, in characters.
+const maxSignatureLength = 10000
+
+// IdentitiesHandler handles the send identities at /api/v1/settings/identities.
+type IdentitiesHandler struct {
+	pool        *pgxpool.Pool
+	encryptor   *crypto.Encryptor
+	smtpService *smtp.Service
+}
+
+// NewIdentitiesHandler creates a new IdentitiesHandler instance.
+func NewIdentitiesHandler(pool *pgxpool.Pool, encryptor *crypto.Encryptor, smtpService *smtp.Service) *IdentitiesHandler {
+	return &IdentitiesHandler{
+		pool:        pool,
+		encryptor:   encryptor,
+		smtpService: smtpService,
+	}
+}
+
+// GetIdentities returns the send identities of the current user.
+func (h *IdentitiesHandler) GetIdentities(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	identities, err := db.GetSendIdentities(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "IdentitiesHandler: Failed to get send identities", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]models.SendIdentityResponse, len(identities))
+	for i, identity := range identities {
+		response[i] = buildSendIdentityResponse(identity)
+	}
+	if !WriteJSONResponse(w, response) {
+		return
+	}
+}
+
+// CreateIdentity saves a new send identity, after checking with the SMTP server that it can send as it.
+func (h *IdentitiesHandler) CreateIdentity(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	var req models.SendIdentityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.InfoContext(ctx, "IdentitiesHandler: Failed to decode request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	identity := &models.SendIdentity{UserID: userID}
+	if !h.applyIdentityRequest(r.Context(), w, identity, &req) {
+		return
+	}
+	if !h.verifyIdentity(ctx, w, userID, identity) {
+		return
+	}
+
+	err := db.CreateSendIdentity(ctx, h.pool, userID, identity)
+	if !h.handleSaveError(r.Context(), w, err) {
+		return
+	}
+
+	WriteJSONResponseWithStatus(w, http.StatusCreated, buildSendIdentityResponse(identity))
+}
+
+// UpdateIdentity replaces a send identity, after checking with the SMTP server that it can send as it.
+// The path is /api/v1/settings/identities/{id}. An empty SMTP password keeps the existing one.
+func (h *IdentitiesHandler) UpdateIdentity(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	identity, ok := h.getIdentityFromPath(w, r, userID)
+	if !ok {
+		return
+	}
+
+	var req models.SendIdentityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.InfoContext(ctx, "IdentitiesHandler: Failed to decode request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	if !h.applyIdentityRequest(r.Context(), w, identity, &req) {
+		return
+	}
+	if !h.verifyIdentity(ctx, w, userID, identity) {
+		return
+	}
+
+	err := db.UpdateSendIdentity(ctx, h.pool, userID, identity)
+	if !h.handleSaveError(r.Context(), w, err) {
+		return
+	}
+
+	if !WriteJSONResponse(w, buildSendIdentityResponse(identity)) {
+		return
+	}
+}
+
+// DeleteIdentity deletes a send identity. The path is /api/v1/settings/identities/{id}.
+// Queued messages that use it fail to send.
+func (h *IdentitiesHandler) DeleteIdentity(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	identityID, ok := getUUIDFromPath(r.URL.Path, "/api/v1/settings/identities/", "")
+	if !ok {
+		http.Error(w, "Identity not found", http.StatusNotFound)
+		return
+	}
+
+	err := db.DeleteSendIdentity(ctx, h.pool, userID, identityID)
+	if errors.Is(err, db.ErrSendIdentityNotFound) {
+		http.Error(w, "Identity not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "IdentitiesHandler: Failed to delete send identity", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getIdentityFromPath returns the user's send identity with the ID in the path.
+// If there's none, it writes an error response and returns false as the second value.
+func (h *IdentitiesHandler) getIdentityFromPath(w http.ResponseWriter, r *http.Request, userID string) (*models.SendIdentity, bool) {
+	identityID, ok := getUUIDFromPath(r.URL.Path, "/api/v1/settings/identities/", "")
+	if !ok {
+		http.Error(w, "Identity not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	identity, err := db.GetSendIdentity(r.Context(), h.pool, userID, identityID)
+	if errors.Is(err, db.ErrSendIdentityNotFound) {
+		http.Error(w, "Identity not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "IdentitiesHandler: Failed to get send identity", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return identity, true
+}
+
+// applyIdentityRequest validates the request and applies it to the identity, encrypting the SMTP password.
+// If the request is invalid, it writes an error response and returns false.
+func (h *IdentitiesHandler) applyIdentityRequest(ctx context.Context, w http.ResponseWriter, identity *models.SendIdentity, req *models.SendIdentityRequest) bool {
+	keepsPassword := req.SMTPServerHostname != "" && len(identity.EncryptedSMTPPassword) > 0
+	if fieldErrors := validateSendIdentityRequest(req, keepsPassword); len(fieldErrors) > 0 {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid identity",
+			Fields: fieldErrors,
+		})
+		return false
+	}
+
+	identity.Name = strings.TrimSpace(req.Name)
+	identity.Email = req.Email
+	identity.SMTPServerHostname = req.SMTPServerHostname
+	identity.SMTPUsername = req.SMTPUsername
+	identity.Signature = req.Signature
+
+	switch {
+	case req.SMTPServerHostname == "":
+		identity.EncryptedSMTPPassword = nil
+	case req.SMTPPassword != "":
+		encryptedPassword, err := h.encryptor.Encrypt(req.SMTPPassword)
+		if err != nil {
+			slog.ErrorContext(ctx, "IdentitiesHandler: Failed to encrypt SMTP password", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return false
+		}
+		identity.EncryptedSMTPPassword = encryptedPassword
+	}
+	return true
+}
+
+// verifyIdentity checks with the SMTP server that we can send as the identity.
+// If we can't, it writes an error response and returns false.
+func (h *IdentitiesHandler) verifyIdentity(ctx context.Context, w http.ResponseWriter, userID string, identity *models.SendIdentity) bool {
+	err := h.smtpService.VerifySendIdentity(ctx, userID, identity)
+	if errors.Is(err, smtp.ErrVerificationFailed) {
+		field := "email"
+		if identity.SMTPServerHostname != "" {
+			field = "smtp_server_hostname"
+		}
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "The SMTP server didn't accept this identity",
+			Fields: map[string]string{field: err.Error()},
+		})
+		return false
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "IdentitiesHandler: Failed to verify send identity", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// handleSaveError writes the error response for a failed save, if any. Returns true if there was no error.
+func (h *IdentitiesHandler) handleSaveError(ctx context.Context, w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, db.ErrSendIdentityExists):
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid identity",
+			Fields: map[string]string{"email": "you already have an identity with this address"},
+		})
+	case errors.Is(err, db.ErrSendIdentityNotFound):
+		http.Error(w, "Identity not found", http.StatusNotFound)
+	default:
+		slog.ErrorContext(ctx, "IdentitiesHandler: Failed to save send identity", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+	return false
+}
+
+// validateSendIdentityRequest checks the fields of a send identity request.
+// keepsPassword is true if the identity already has an SMTP password that an empty one keeps.
+// Returns a map of invalid fields to error messages, which is empty if the request is valid.
+func validateSendIdentityRequest(req *models.SendIdentityRequest, keepsPassword bool) map[string]string {
+	fieldErrors := map[string]string{}
+
+	if req.Email == "" {
+		fieldErrors["email"] = "is required"
+	} else if address, err := mail.ParseAddress(req.Email); err != nil || address.Address != req.Email {
+		fieldErrors["email"] = "must be an email address, like name@example.com"
+	}
+
+	if req.SMTPServerHostname == "" {
+		if req.SMTPUsername != "" || req.SMTPPassword != "" {
+			fieldErrors["smtp_server_hostname"] = "is required with an SMTP username or password"
+		}
+	} else {
+		if req.SMTPUsername == "" {
+			fieldErrors["smtp_username"] = "is required with an SMTP server"
+		}
+		if req.SMTPPassword == "" && !keepsPassword {
+			fieldErrors["smtp_password"] = "is required with an SMTP server"
+		}
+	}
+
+	if len([]rune(req.Signature)) > maxSignatureLength {
+		fieldErrors["signature"] = fmt.Sprintf("must be at most %d characters", maxSignatureLength)
+	}
+
+	return fieldErrors
+}
+
+// buildSendIdentityResponse converts a stored identity to the API response, leaving out the password.
+func buildSendIdentityResponse(identity *models.SendIdentity) models.SendIdentityResponse {
+	return models.SendIdentityResponse{
+		ID:                 identity.ID,
+		Name:               identity.Name,
+		Email:              identity.Email,
+		SMTPServerHostname: identity.SMTPServerHostname,
+		SMTPUsername:       identity.SMTPUsername,
+		SMTPPasswordSet:    len(identity.EncryptedSMTPPassword) > 0,
+		Signature:          identity.Signature,
+	}
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/smtp"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestValidateSendIdentityRequest(t *testing.T) {
+	testCases := []struct {
+		name          string
+		req           models.SendIdentityRequest
+		keepsPassword bool
+		invalidFields []string
+	}{
+		{"accepts an alias on the main SMTP server", models.SendIdentityRequest{Email: "alias@example.com"}, false, nil},
+		{
+			"accepts an identity with its own SMTP server",
+			models.SendIdentityRequest{Email: "me@work.com", SMTPServerHostname: "smtp.work.com:465", SMTPUsername: "me", SMTPPassword: "secret"},
+			false,
+			nil,
+		},
+		{
+			"keeps the existing password",
+			models.SendIdentityRequest{Email: "me@work.com", SMTPServerHostname: "smtp.work.com:465", SMTPUsername: "me"},
+			true,
+			nil,
+		},
+		{"requires an email", models.SendIdentityRequest{}, false, []string{"email"}},
+		{"rejects names in the email", models.SendIdentityRequest{Email: "Me <me@example.com>"}, false, []string{"email"}},
+		{
+			"requires the SMTP username and password with a server",
+			models.SendIdentityRequest{Email: "me@work.com", SMTPServerHostname: "smtp.work.com:465"},
+			false,
+			[]string{"smtp_username", "smtp_password"},
+		},
+		{
+			"requires a server with SMTP credentials",
+			models.SendIdentityRequest{Email: "me@work.com", SMTPUsername: "me"},
+			false,
+			[]string{"smtp_server_hostname"},
+		},
+		{
+			"rejects long signatures",
+			models.SendIdentityRequest{Email: "me@example.com", Signature: strings.Repeat("x", maxSignatureLength+1)},
+			false,
+			[]string{"signature"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fieldErrors := validateSendIdentityRequest(&tc.req, tc.keepsPassword)
+			if len(fieldErrors) != len(tc.invalidFields) {
+				t.Fatalf("Expected invalid fields %v, got %v", tc.invalidFields, fieldErrors)
+			}
+			for _, field := range tc.invalidFields {
+				if _, ok := fieldErrors[field]; !ok {
+					t.Errorf("Expected %s to be invalid, got %v", field, fieldErrors)
+				}
+			}
+		})
+	}
+}
+
+func TestIdentitiesHandler(t *testing.T) {
+	t.Setenv("VMAIL_TEST_MODE", "true")
+
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	encryptor := getTestEncryptor(t)
+	smtpServer := testutil.NewTestSMTPServer(t)
+	defer smtpServer.Close()
+
+	email := "identities-user@example.com"
+	userID := setupTestUserAndSettings(t, pool, encryptor, email)
+	settings, err := db.GetUserSettings(context.Background(), pool, userID)
+	if err != nil {
+		t.Fatalf("Failed to get settings: %v", err)
+	}
+	settings.SMTPServerHostname = smtpServer.Address
+	if err := db.SaveUserSettings(context.Background(), pool, settings); err != nil {
+		t.Fatalf("Failed to save settings: %v", err)
+	}
+
+	handler := NewIdentitiesHandler(pool, encryptor, smtp.NewService(pool, encryptor))
+	serve := func(method, path, body string, fn func(http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		fn(rr, req)
+		return rr
+	}
+
+	var created models.SendIdentityResponse
+
+	t.Run("creates an identity", func(t *testing.T) {
+		rr := serve("POST", "/api/v1/settings/identities", `{"name": "Support", "email": "support@example.com"}`, handler.CreateIdentity)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if created.ID == "" || created.Email != "support@example.com" || created.SMTPPasswordSet {
+			t.Errorf("Unexpected identity: %+v", created)
+		}
+		if len(smtpServer.GetMessages()) != 0 {
+			t.Error("Expected verifying the identity not to send anything")
+		}
+	})
+
+	t.Run("lists identities", func(t *testing.T) {
+		rr := serve("GET", "/api/v1/settings/identities", "", handler.GetIdentities)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+		var identities []models.SendIdentityResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &identities); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if len(identities) != 1 || identities[0].ID != created.ID {
+			t.Errorf("Expected the created identity, got %+v", identities)
+		}
+	})
+
+	t.Run("rejects identities that the SMTP server doesn't accept", func(t *testing.T) {
+		body := `{"email": "me@work.com", "smtp_server_hostname": "127.0.0.1:1", "smtp_username": "me", "smtp_password": "secret"}`
+		rr := serve("POST", "/api/v1/settings/identities", body, handler.CreateIdentity)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status 400, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var response models.ValidationErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if _, ok := response.Fields["smtp_server_hostname"]; !ok {
+			t.Errorf("Expected smtp_server_hostname to be invalid, got %v", response.Fields)
+		}
+	})
+
+	t.Run("rejects duplicate addresses", func(t *testing.T) {
+		rr := serve("POST", "/api/v1/settings/identities", `{"email": "support@example.com"}`, handler.CreateIdentity)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("updates an identity", func(t *testing.T) {
+		path := "/api/v1/settings/identities/" + created.ID
+		rr := serve("PUT", path, `{"name": "Help desk", "email": "help@example.com", "signature": "Cheers"}`, handler.UpdateIdentity)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var updated models.SendIdentityResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &updated); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if updated.Name != "Help desk" || updated.Email != "help@example.com" || updated.Signature != "Cheers" {
+			t.Errorf("Unexpected identity: %+v", updated)
+		}
+	})
+
+	t.Run("deletes an identity", func(t *testing.T) {
+		path := "/api/v1/settings/identities/" + created.ID
+		if rr := serve("DELETE", path, "", handler.DeleteIdentity); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", rr.Code)
+		}
+		if rr := serve("DELETE", path, "", handler.DeleteIdentity); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 the second time, got %d", rr.Code)
+		}
+	})
+}
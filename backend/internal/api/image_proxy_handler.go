@@ -0,0 +1,63 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/vdavid/vmail/backend/internal/imageproxy"
+)
+
+// ImageProxyHandler serves the remote images of messages through our server, so that loading them doesn't tell
+// trackers the user's IP address.
+type ImageProxyHandler struct {
+	proxy *imageproxy.Proxy
+}
+
+// NewImageProxyHandler creates a new ImageProxyHandler instance.
+func NewImageProxyHandler(proxy *imageproxy.Proxy) *ImageProxyHandler {
+	return &ImageProxyHandler{
+		proxy: proxy,
+	}
+}
+
+// GetImage fetches the image at the URL in the "url" query parameter, and responds with it.
+// Returns 400 for URLs that aren't HTTP, 415 for responses that aren't raster images, 413 for images over the size
+// limit, and 502 if the fetch fails, for example, because the host isn't public.
+// The path is /api/v1/image-proxy.
+func (h *ImageProxyHandler) GetImage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	rawURL := r.URL.Query().Get("url")
+	if rawURL == "" {
+		http.Error(w, "url query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	image, err := h.proxy.Fetch(ctx, rawURL)
+	if err != nil {
+		switch {
+		case errors.Is(err, imageproxy.ErrInvalidURL):
+			http.Error(w, "Invalid image URL", http.StatusBadRequest)
+		case errors.Is(err, imageproxy.ErrNotImage):
+			http.Error(w, "Not an image", http.StatusUnsupportedMediaType)
+		case errors.Is(err, imageproxy.ErrTooLarge):
+			http.Error(w, "Image too large", http.StatusRequestEntityTooLarge)
+		default:
+			// Broken images are common in old mail, so this isn't an error on our side
+			slog.DebugContext(ctx, "ImageProxyHandler: Failed to fetch image", "error", err)
+			http.Error(w, "Failed to fetch the image", http.StatusBadGateway)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", image.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(image.Data)))
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := w.Write(image.Data); err != nil {
+		slog.WarnContext(ctx, "ImageProxyHandler: Failed to write image", "error", err)
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/imageproxy"
+)
+
+func TestImageProxyHandler_GetImage(t *testing.T) {
+	handler := NewImageProxyHandler(imageproxy.NewProxy(1<<20, 1<<20))
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.GetImage(rr, httptest.NewRequest("GET", "/api/v1/image-proxy"+query, nil))
+		return rr
+	}
+
+	t.Run("returns 400 without a URL", func(t *testing.T) {
+		if rr := get(""); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("returns 400 for URLs that aren't HTTP", func(t *testing.T) {
+		if rr := get("?url=" + url.QueryEscape("file:///etc/passwd")); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("returns 502 for local addresses", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("Expected no request to reach the local server")
+		}))
+		defer server.Close()
+
+		if rr := get("?url=" + url.QueryEscape(server.URL+"/pixel.gif")); rr.Code != http.StatusBadGateway {
+			t.Errorf("Expected status 502, got %d", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// ErrorCodeTooManyRequests is the error code of responses from InFlightLimiter and RateLimiter.
+const ErrorCodeTooManyRequests = "too_many_requests"
+
+// InFlightLimiter limits how many requests each user can have in flight on IMAP-heavy endpoints.
+// Each user only has a few IMAP worker connections, so without a limit, a user with many tabs open could
+// queue up requests that wait for a connection until they time out. Instead, requests over the limit wait
+// briefly for a slot, and then get a 429, which the front end can retry.
+type InFlightLimiter struct {
+	limit        int
+	queueTimeout time.Duration
+
+	mu    sync.Mutex
+	users map[string]*userSlots
+}
+
+// userSlots is a semaphore for one user. refs counts the requests that hold or wait for a slot,
+// so that we can forget users without requests.
+type userSlots struct {
+	slots chan struct{}
+	refs  int
+}
+
+// NewInFlightLimiter creates a limiter that allows limit concurrent requests per user,
+// and lets other requests wait up to queueTimeout. A limit of 0 or less means no limit.
+func NewInFlightLimiter(limit int, queueTimeout time.Duration) *InFlightLimiter {
+	return &InFlightLimiter{
+		limit:        limit,
+		queueTimeout: queueTimeout,
+		users:        make(map[string]*userSlots),
+	}
+}
+
+// Limit wraps a handler with the limiter. It must run after auth.RequireAuth, since it identifies users by email.
+func (l *InFlightLimiter) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		email, ok := auth.GetUserEmailFromContext(r.Context())
+		if l.limit <= 0 || !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user := l.acquireRef(email)
+		defer l.releaseRef(email, user)
+
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+
+		select {
+		case user.slots <- struct{}{}:
+			defer func() { <-user.slots }()
+			next.ServeHTTP(w, r)
+		case <-timer.C:
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(l.queueTimeout)))
+			WriteJSONResponseWithStatus(w, http.StatusTooManyRequests, models.ErrorResponse{
+				Error: "Too many requests in progress, please try again",
+				Code:  ErrorCodeTooManyRequests,
+			})
+		case <-r.Context().Done():
+			// The client went away while waiting
+		}
+	})
+}
+
+func (l *InFlightLimiter) acquireRef(email string) *userSlots {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	user, exists := l.users[email]
+	if !exists {
+		user = &userSlots{slots: make(chan struct{}, l.limit)}
+		l.users[email] = user
+	}
+	user.refs++
+	return user
+}
+
+func (l *InFlightLimiter) releaseRef(email string, user *userSlots) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	user.refs--
+	if user.refs == 0 {
+		delete(l.users, email)
+	}
+}
+
+// retryAfterSeconds rounds a wait up to whole seconds, with at least 1 second.
+func retryAfterSeconds(wait time.Duration) int {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	return max(seconds, 1)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+func TestInFlightLimiter(t *testing.T) {
+	// blockingHandler holds each request until release is closed
+	newBlockingHandler := func() (http.Handler, chan struct{}, chan struct{}) {
+		started := make(chan struct{}, 10)
+		release := make(chan struct{})
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started <- struct{}{}
+			<-release
+			w.WriteHeader(http.StatusOK)
+		})
+		return handler, started, release
+	}
+
+	serve := func(handler http.Handler, email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/threads", nil)
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("returns 429 with a code when the user is over the limit", func(t *testing.T) {
+		limiter := NewInFlightLimiter(1, 20*time.Millisecond)
+		handler, started, release := newBlockingHandler()
+		limited := limiter.Limit(handler)
+
+		done := make(chan *httptest.ResponseRecorder)
+		go func() { done <- serve(limited, "user@example.com") }()
+		<-started
+
+		rr := serve(limited, "user@example.com")
+		if rr.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected status 429, got %d", rr.Code)
+		}
+		if rr.Header().Get("Retry-After") != "1" {
+			t.Errorf("Expected Retry-After 1, got %q", rr.Header().Get("Retry-After"))
+		}
+		var response models.ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response.Code != ErrorCodeTooManyRequests {
+			t.Errorf("Expected code %s, got %s", ErrorCodeTooManyRequests, response.Code)
+		}
+
+		// Other users have their own limit
+		otherDone := make(chan *httptest.ResponseRecorder)
+		go func() { otherDone <- serve(limited, "other@example.com") }()
+		<-started
+
+		close(release)
+		if rr := <-done; rr.Code != http.StatusOK {
+			t.Errorf("Expected the first request to succeed, got %d", rr.Code)
+		}
+		if rr := <-otherDone; rr.Code != http.StatusOK {
+			t.Errorf("Expected the other user's request to succeed, got %d", rr.Code)
+		}
+	})
+
+	t.Run("queued requests run when a slot frees up", func(t *testing.T) {
+		limiter := NewInFlightLimiter(1, 5*time.Second)
+		handler, started, release := newBlockingHandler()
+		limited := limiter.Limit(handler)
+
+		first := make(chan *httptest.ResponseRecorder)
+		go func() { first <- serve(limited, "user@example.com") }()
+		<-started
+
+		second := make(chan *httptest.ResponseRecorder)
+		go func() { second <- serve(limited, "user@example.com") }()
+
+		close(release)
+		for _, done := range []chan *httptest.ResponseRecorder{first, second} {
+			if rr := <-done; rr.Code != http.StatusOK {
+				t.Errorf("Expected status 200, got %d", rr.Code)
+			}
+		}
+	})
+
+	t.Run("forgets users without requests", func(t *testing.T) {
+		limiter := NewInFlightLimiter(2, time.Second)
+		limited := limiter.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		serve(limited, "user@example.com")
+
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+		if len(limiter.users) != 0 {
+			t.Errorf("Expected no tracked users, got %d", len(limiter.users))
+		}
+	})
+
+	t.Run("a limit of 0 means no limit", func(t *testing.T) {
+		limiter := NewInFlightLimiter(0, time.Millisecond)
+		handler, started, release := newBlockingHandler()
+		limited := limiter.Limit(handler)
+
+		done := make(chan *httptest.ResponseRecorder, 3)
+		for range 3 {
+			go func() { done <- serve(limited, "user@example.com") }()
+		}
+		for range 3 {
+			<-started
+		}
+		close(release)
+		for range 3 {
+			if rr := <-done; rr.Code != http.StatusOK {
+				t.Errorf("Expected status 200, got %d", rr.Code)
+			}
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imap"
+)
+
+// InlinePartHandler serves the inline parts of messages, like the images that HTML bodies show with "cid:" URLs.
+type InlinePartHandler struct {
+	pool    *pgxpool.Pool
+	fetcher imap.RawMessageFetcher
+}
+
+// NewInlinePartHandler creates a new InlinePartHandler instance.
+func NewInlinePartHandler(pool *pgxpool.Pool, fetcher imap.RawMessageFetcher) *InlinePartHandler {
+	return &InlinePartHandler{
+		pool:    pool,
+		fetcher: fetcher,
+	}
+}
+
+// GetInlinePart fetches a message from the IMAP server, and responds with its part with the Content-ID, so that the
+// frontend can point the "cid:" URLs of the HTML body here. The Content-ID is URL-encoded, without angle brackets.
+// Parts never change, so browsers may cache them. Only raster images are served inline, and everything else as a
+// download, see writeDownloadHeaders.
+// The path is /api/v1/messages/{id}/inline/{content_id}.
+func (h *InlinePartHandler) GetInlinePart(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	id, contentID, ok := getInlinePartFromPath(r.URL.EscapedPath())
+	if !ok {
+		http.Error(w, "Invalid message or content ID", http.StatusBadRequest)
+		return
+	}
+
+	folderName, imapUID, err := db.GetMessageLocation(ctx, h.pool, userID, id)
+	if err != nil {
+		if errors.Is(err, db.ErrMessageNotFound) {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "InlinePartHandler: Failed to get message", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	raw, err := h.fetcher.FetchRawMessage(ctx, userID, folderName, imapUID)
+	if err != nil {
+		if errors.Is(err, imap.ErrMessageNotFound) {
+			http.Error(w, "Message not found on the mail server", http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "InlinePartHandler: Failed to fetch message", "folder", folderName, "uid", imapUID, "error", err)
+		http.Error(w, "Failed to fetch the message from the mail server", http.StatusBadGateway)
+		return
+	}
+
+	// Parts of truncated messages can be missing, which is a 404 like any other missing part
+	part, err := imap.FindInlinePart(raw.Body, contentID)
+	if err != nil {
+		if errors.Is(err, imap.ErrInlinePartNotFound) {
+			http.Error(w, "Inline part not found", http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "InlinePartHandler: Failed to parse message", "error", err)
+		http.Error(w, "Failed to parse the message", http.StatusBadGateway)
+		return
+	}
+
+	filename := part.Filename
+	if filename == "" {
+		filename = "inline"
+	}
+	writeDownloadHeaders(w, filename, part.ContentType, true)
+	w.Header().Set("Content-Length", strconv.Itoa(len(part.Content)))
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	if _, err := w.Write(part.Content); err != nil {
+		slog.WarnContext(ctx, "InlinePartHandler: Failed to write part", "error", err)
+	}
+}
+
+// getInlinePartFromPath extracts the message ID and the unescaped Content-ID from an escaped
+// /api/v1/messages/{id}/inline/{content_id} path. Angle brackets around the Content-ID are removed.
+func getInlinePartFromPath(path string) (string, string, bool) {
+	id, escapedContentID, found := strings.Cut(strings.TrimPrefix(path, "/api/v1/messages/"), "/inline/")
+	if !found || uuid.Validate(id) != nil {
+		return "", "", false
+	}
+	contentID, err := url.PathUnescape(escapedContentID)
+	if err != nil {
+		return "", "", false
+	}
+	contentID = strings.TrimSuffix(strings.TrimPrefix(contentID, "<"), ">")
+	if contentID == "" {
+		return "", "", false
+	}
+	return id, contentID, true
+}
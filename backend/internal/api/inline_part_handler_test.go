@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestInlinePartHandler_GetInlinePart(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	email := "inline-part-test@example.com"
+	userID := setupTestUserAndSettings(t, pool, getTestEncryptor(t), email)
+
+	thread := &models.Thread{UserID: userID, StableThreadID: "<inline-thread@example.com>", Subject: "Inline"}
+	if err := db.SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+	msg := &models.Message{ThreadID: thread.ID, UserID: userID, IMAPUID: 1, IMAPFolderName: "INBOX"}
+	if err := db.SaveMessage(ctx, pool, msg); err != nil {
+		t.Fatalf("SaveMessage failed: %v", err)
+	}
+
+	source := "Subject: Inline\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/related; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<img src=\"cid:logo@example.com\">\r\n" +
+		"--b\r\n" +
+		"Content-Type: image/png\r\n" +
+		"Content-ID: <logo@example.com>\r\n" +
+		"Content-Disposition: inline; filename=logo.png\r\n" +
+		"\r\n" +
+		"PNGDATA\r\n" +
+		"--b\r\n" +
+		"Content-Type: application/foo+xml\r\n" +
+		"Content-ID: <page@example.com>\r\n" +
+		"Content-Disposition: inline\r\n" +
+		"\r\n" +
+		"<html xmlns=\"http://www.w3.org/1999/xhtml\"><script>alert(1)</script></html>\r\n" +
+		"--b--\r\n"
+	handler := NewInlinePartHandler(pool, &mockRawMessageFetcher{sources: map[int64]string{1: source}})
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.GetInlinePart(rr, createRequestWithUser("GET", path, email))
+		return rr
+	}
+
+	t.Run("responds with the part", func(t *testing.T) {
+		rr := get("/api/v1/messages/" + msg.ID + "/inline/logo%40example.com")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if rr.Body.String() != "PNGDATA" || rr.Header().Get("Content-Type") != "image/png" {
+			t.Errorf("Expected the image as image/png, got %q as %q", rr.Body.String(), rr.Header().Get("Content-Type"))
+		}
+	})
+
+	t.Run("serves XML parts as downloads with a sandboxing CSP", func(t *testing.T) {
+		rr := get("/api/v1/messages/" + msg.ID + "/inline/page%40example.com")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if got := rr.Header().Get("Content-Disposition"); !strings.HasPrefix(got, "attachment") {
+			t.Errorf("Expected an attachment, got %q", got)
+		}
+		if got := rr.Header().Get("Content-Security-Policy"); got != "default-src 'none'; sandbox" {
+			t.Errorf("Expected a sandboxing CSP, got %q", got)
+		}
+	})
+
+	t.Run("returns 404 for unknown Content-IDs", func(t *testing.T) {
+		if rr := get("/api/v1/messages/" + msg.ID + "/inline/other%40example.com"); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rr.Code)
+		}
+	})
+
+	t.Run("returns 400 for invalid IDs", func(t *testing.T) {
+		if rr := get("/api/v1/messages/not-a-uuid/inline/logo%40example.com"); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+}
+
+func TestGetInlinePartFromPath(t *testing.T) {
+	tests := []struct {
+		path          string
+		wantID        string
+		wantContentID string
+		wantOK        bool
+	}{
+		{"/api/v1/messages/0b8f5a4e-2d6c-4a47-9f4e-3c1f9a0e7b21/inline/logo@example.com", "0b8f5a4e-2d6c-4a47-9f4e-3c1f9a0e7b21", "logo@example.com", true},
+		{"/api/v1/messages/0b8f5a4e-2d6c-4a47-9f4e-3c1f9a0e7b21/inline/a%2Fb%20c", "0b8f5a4e-2d6c-4a47-9f4e-3c1f9a0e7b21", "a/b c", true},
+		{"/api/v1/messages/0b8f5a4e-2d6c-4a47-9f4e-3c1f9a0e7b21/inline/%3Clogo%3E", "0b8f5a4e-2d6c-4a47-9f4e-3c1f9a0e7b21", "logo", true},
+		{"/api/v1/messages/0b8f5a4e-2d6c-4a47-9f4e-3c1f9a0e7b21/inline/", "", "", false},
+		{"/api/v1/messages/0b8f5a4e-2d6c-4a47-9f4e-3c1f9a0e7b21/inline/%zz", "", "", false},
+		{"/api/v1/messages/123/inline/logo", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			id, contentID, ok := getInlinePartFromPath(tt.path)
+			if id != tt.wantID || contentID != tt.wantContentID || ok != tt.wantOK {
+				t.Errorf("getInlinePartFromPath(%q) = %q, %q, %v, want %q, %q, %v",
+					tt.path, id, contentID, ok, tt.wantID, tt.wantContentID, tt.wantOK)
+			}
+		})
+	}
+}
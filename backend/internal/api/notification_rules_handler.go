@@ -0,0 +1,206 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// maxNotificationRuleFieldLength is the longest name or condition of a notification rule we accept, in characters.
+const maxNotificationRuleFieldLength = 200
+
+// NotificationRulesHandler handles the notification rules of the user at /api/v1/notification-rules.
+// The push notifier applies them, see push.Notifier.
+type NotificationRulesHandler struct {
+	pool *pgxpool.Pool
+}
+
+// NewNotificationRulesHandler creates a new NotificationRulesHandler instance.
+func NewNotificationRulesHandler(pool *pgxpool.Pool) *NotificationRulesHandler {
+	return &NotificationRulesHandler{
+		pool: pool,
+	}
+}
+
+// GetRules returns the notification rules of the current user, the oldest first.
+func (h *NotificationRulesHandler) GetRules(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	rules, err := db.GetNotificationRules(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "NotificationRulesHandler: Failed to get notification rules", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !WriteJSONResponse(w, rules) {
+		return
+	}
+}
+
+// CreateRule saves a new notification rule.
+func (h *NotificationRulesHandler) CreateRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	var req models.NotificationRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.InfoContext(ctx, "NotificationRulesHandler: Failed to decode request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	rule := &models.NotificationRule{}
+	if !applyNotificationRuleRequest(w, rule, &req) {
+		return
+	}
+
+	if err := db.CreateNotificationRule(ctx, h.pool, userID, rule); err != nil {
+		slog.ErrorContext(ctx, "NotificationRulesHandler: Failed to create notification rule", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	WriteJSONResponseWithStatus(w, http.StatusCreated, rule)
+}
+
+// UpdateRule replaces a notification rule. The path is /api/v1/notification-rules/{id}.
+func (h *NotificationRulesHandler) UpdateRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	ruleID, ok := getUUIDFromPath(r.URL.Path, "/api/v1/notification-rules/", "")
+	if !ok {
+		http.Error(w, "Notification rule not found", http.StatusNotFound)
+		return
+	}
+
+	var req models.NotificationRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.InfoContext(ctx, "NotificationRulesHandler: Failed to decode request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	rule := &models.NotificationRule{ID: ruleID}
+	if !applyNotificationRuleRequest(w, rule, &req) {
+		return
+	}
+
+	err := db.UpdateNotificationRule(ctx, h.pool, userID, rule)
+	if errors.Is(err, db.ErrNotificationRuleNotFound) {
+		http.Error(w, "Notification rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "NotificationRulesHandler: Failed to update notification rule", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !WriteJSONResponse(w, rule) {
+		return
+	}
+}
+
+// DeleteRule deletes a notification rule. The path is /api/v1/notification-rules/{id}.
+func (h *NotificationRulesHandler) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	ruleID, ok := getUUIDFromPath(r.URL.Path, "/api/v1/notification-rules/", "")
+	if !ok {
+		http.Error(w, "Notification rule not found", http.StatusNotFound)
+		return
+	}
+
+	err := db.DeleteNotificationRule(ctx, h.pool, userID, ruleID)
+	if errors.Is(err, db.ErrNotificationRuleNotFound) {
+		http.Error(w, "Notification rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "NotificationRulesHandler: Failed to delete notification rule", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyNotificationRuleRequest validates the request and applies it to the rule.
+// If the request is invalid, it writes an error response and returns false.
+func applyNotificationRuleRequest(w http.ResponseWriter, rule *models.NotificationRule, req *models.NotificationRuleRequest) bool {
+	if fieldErrors := validateNotificationRuleRequest(req); len(fieldErrors) > 0 {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid notification rule",
+			Fields: fieldErrors,
+		})
+		return false
+	}
+
+	rule.Name = strings.TrimSpace(req.Name)
+	rule.Action = req.Action
+	rule.From = strings.TrimSpace(req.From)
+	rule.Subject = strings.TrimSpace(req.Subject)
+	rule.Folder = strings.TrimSpace(req.Folder)
+	rule.Hours = req.Hours
+	return true
+}
+
+// validateNotificationRuleRequest checks the fields of a notification rule request.
+// Returns a map of invalid fields to error messages, which is empty if the request is valid.
+func validateNotificationRuleRequest(req *models.NotificationRuleRequest) map[string]string {
+	fieldErrors := map[string]string{}
+
+	if req.Action != models.NotificationRuleNotify && req.Action != models.NotificationRuleMute {
+		fieldErrors["action"] = `must be "notify" or "mute"`
+	}
+
+	for field, value := range map[string]string{"name": req.Name, "from": req.From, "subject": req.Subject, "folder": req.Folder} {
+		if len([]rune(strings.TrimSpace(value))) > maxNotificationRuleFieldLength {
+			fieldErrors[field] = fmt.Sprintf("must be at most %d characters", maxNotificationRuleFieldLength)
+		}
+	}
+
+	if req.Hours != nil {
+		if !isTimeOfDay(req.Hours.Start) || !isTimeOfDay(req.Hours.End) {
+			fieldErrors["hours"] = `must have a start and an end like "22:00"`
+		} else if _, err := time.LoadLocation(req.Hours.TimeZone); err != nil || req.Hours.TimeZone == "" {
+			fieldErrors["hours"] = `must have an IANA time zone, like "Europe/Berlin"`
+		}
+	}
+
+	return fieldErrors
+}
+
+// isTimeOfDay tells whether the value is a time of day like "22:00", with two-digit hours and minutes.
+func isTimeOfDay(value string) bool {
+	_, err := time.Parse("15:04", value)
+	return err == nil && len(value) == len("15:04")
+}
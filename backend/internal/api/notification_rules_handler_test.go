@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestValidateNotificationRuleRequest(t *testing.T) {
+	testCases := []struct {
+		name          string
+		req           models.NotificationRuleRequest
+		invalidFields []string
+	}{
+		{"accepts a notify rule", models.NotificationRuleRequest{Action: "notify", From: "boss@example.com"}, nil},
+		{
+			"accepts quiet hours",
+			models.NotificationRuleRequest{Action: "mute", Hours: &models.NotificationRuleHours{Start: "22:00", End: "07:00", TimeZone: "Europe/Berlin"}},
+			nil,
+		},
+		{"requires an action", models.NotificationRuleRequest{}, []string{"action"}},
+		{"rejects unknown actions", models.NotificationRuleRequest{Action: "snooze"}, []string{"action"}},
+		{
+			"rejects long conditions",
+			models.NotificationRuleRequest{Action: "notify", Subject: strings.Repeat("x", maxNotificationRuleFieldLength+1)},
+			[]string{"subject"},
+		},
+		{
+			"rejects invalid times",
+			models.NotificationRuleRequest{Action: "mute", Hours: &models.NotificationRuleHours{Start: "7:00", End: "25:00", TimeZone: "UTC"}},
+			[]string{"hours"},
+		},
+		{
+			"requires a time zone",
+			models.NotificationRuleRequest{Action: "mute", Hours: &models.NotificationRuleHours{Start: "22:00", End: "07:00"}},
+			[]string{"hours"},
+		},
+		{
+			"rejects unknown time zones",
+			models.NotificationRuleRequest{Action: "mute", Hours: &models.NotificationRuleHours{Start: "22:00", End: "07:00", TimeZone: "Mars/Olympus"}},
+			[]string{"hours"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fieldErrors := validateNotificationRuleRequest(&tc.req)
+			if len(fieldErrors) != len(tc.invalidFields) {
+				t.Fatalf("Expected invalid fields %v, got %v", tc.invalidFields, fieldErrors)
+			}
+			for _, field := range tc.invalidFields {
+				if _, ok := fieldErrors[field]; !ok {
+					t.Errorf("Expected %s to be invalid, got %v", field, fieldErrors)
+				}
+			}
+		})
+	}
+}
+
+func TestNotificationRulesHandler(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	email := "notification-rules-user@example.com"
+	handler := NewNotificationRulesHandler(pool)
+	serve := func(method, path, body string, fn func(http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		fn(rr, req)
+		return rr
+	}
+
+	var created models.NotificationRule
+
+	t.Run("creates a rule", func(t *testing.T) {
+		body := `{"name": "Quiet hours", "action": "mute", "hours": {"start": "22:00", "end": "07:00", "time_zone": "Europe/Berlin"}}`
+		rr := serve("POST", "/api/v1/notification-rules", body, handler.CreateRule)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if created.ID == "" || created.Action != models.NotificationRuleMute || created.Hours == nil || created.Hours.Start != "22:00" {
+			t.Errorf("Unexpected rule: %+v", created)
+		}
+	})
+
+	t.Run("rejects invalid rules", func(t *testing.T) {
+		rr := serve("POST", "/api/v1/notification-rules", `{"action": "maybe"}`, handler.CreateRule)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("lists rules", func(t *testing.T) {
+		rr := serve("GET", "/api/v1/notification-rules", "", handler.GetRules)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+		var rules []models.NotificationRule
+		if err := json.Unmarshal(rr.Body.Bytes(), &rules); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if len(rules) != 1 || rules[0].ID != created.ID {
+			t.Errorf("Expected the created rule, got %+v", rules)
+		}
+	})
+
+	t.Run("replaces a rule", func(t *testing.T) {
+		path := "/api/v1/notification-rules/" + created.ID
+		rr := serve("PUT", path, `{"name": "Boss", "action": "notify", "from": " boss@example.com "}`, handler.UpdateRule)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var updated models.NotificationRule
+		if err := json.Unmarshal(rr.Body.Bytes(), &updated); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if updated.Action != models.NotificationRuleNotify || updated.From != "boss@example.com" || updated.Hours != nil {
+			t.Errorf("Unexpected rule: %+v", updated)
+		}
+	})
+
+	t.Run("deletes a rule", func(t *testing.T) {
+		path := "/api/v1/notification-rules/" + created.ID
+		if rr := serve("DELETE", path, "", handler.DeleteRule); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", rr.Code)
+		}
+		if rr := serve("DELETE", path, "", handler.DeleteRule); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 the second time, got %d", rr.Code)
+		}
+		if rr := serve("PUT", path, `{"action": "mute"}`, handler.UpdateRule); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for updating it, got %d", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"sort"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/crypto"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/oauth"
+)
+
+// OAuthHandler handles connecting mail accounts with OAuth at /api/v1/settings/oauth.
+type OAuthHandler struct {
+	pool      *pgxpool.Pool
+	encryptor *crypto.Encryptor
+	imapPool  imap.IMAPPool
+	providers map[string]*oauth.Provider
+}
+
+// NewOAuthHandler creates a new OAuthHandler instance.
+func NewOAuthHandler(pool *pgxpool.Pool, encryptor *crypto.Encryptor, imapPool imap.IMAPPool, providers map[string]*oauth.Provider) *OAuthHandler {
+	return &OAuthHandler{
+		pool:      pool,
+		encryptor: encryptor,
+		imapPool:  imapPool,
+		providers: providers,
+	}
+}
+
+// GetProviders returns the OAuth providers that are configured on this server.
+// It's an empty list if there are none, and then users can only connect with passwords.
+func (h *OAuthHandler) GetProviders(w http.ResponseWriter, r *http.Request) {
+	providers := make([]models.OAuthProviderResponse, 0, len(h.providers))
+	for _, provider := range h.providers {
+		providers = append(providers, models.OAuthProviderResponse{
+			Name:     provider.Name,
+			AuthURL:  provider.AuthURL,
+			ClientID: provider.ClientID,
+			Scope:    provider.Scope,
+		})
+	}
+	sort.Slice(providers, func(i, j int) bool {
+		return providers[i].Name < providers[j].Name
+	})
+
+	if !WriteJSONResponse(w, providers) {
+		return
+	}
+}
+
+// Connect exchanges the authorization code from the provider for tokens, and saves them in place of the passwords.
+// Users who already have settings keep their servers and usernames. New users get the provider's servers,
+// with their email address as the username.
+func (h *OAuthHandler) Connect(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	var req models.OAuthConnectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.InfoContext(ctx, "OAuthHandler: Failed to decode request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	settings, err := db.GetUserSettings(ctx, h.pool, userID)
+	if err != nil && !errors.Is(err, db.ErrUserSettingsNotFound) {
+		slog.ErrorContext(ctx, "OAuthHandler: Failed to get existing settings", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	provider, fieldErrors := h.validateConnectRequest(&req, settings == nil)
+	if len(fieldErrors) > 0 {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid OAuth connection",
+			Fields: fieldErrors,
+		})
+		return
+	}
+
+	token, err := provider.Exchange(ctx, req.Code, req.RedirectURI, req.CodeVerifier)
+	if errors.Is(err, oauth.ErrTokenRejected) {
+		slog.InfoContext(ctx, "OAuthHandler: Provider rejected the code", "error", err)
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid OAuth connection",
+			Fields: map[string]string{"code": "was rejected by the provider, please try connecting again"},
+		})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "OAuthHandler: Failed to exchange code", "error", err)
+		http.Error(w, "Failed to reach the OAuth provider", http.StatusBadGateway)
+		return
+	}
+
+	if settings == nil {
+		settings = &models.UserSettings{
+			UserID:             userID,
+			IMAPServerHostname: provider.IMAPServerHostname,
+			IMAPUsername:       req.Email,
+			SMTPServerHostname: provider.SMTPServerHostname,
+			SMTPUsername:       req.Email,
+		}
+	}
+	settings.OAuthProvider = provider.Name
+	// Users who connect with OAuth usually can't log in to SMTP with a password either.
+	// They can still set an SMTP password afterward.
+	settings.EncryptedSMTPPassword = nil
+	if err := oauth.ApplyToken(h.encryptor, settings, token); err != nil {
+		slog.ErrorContext(ctx, "OAuthHandler: Failed to apply token", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.SaveUserSettings(ctx, h.pool, settings); err != nil {
+		slog.ErrorContext(ctx, "OAuthHandler: Failed to save settings", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Pooled connections are still logged in with the old password
+	h.imapPool.RemoveClient(userID)
+
+	if !WriteJSONResponse(w, buildSettingsResponse(settings)) {
+		return
+	}
+}
+
+// validateConnectRequest checks the fields of an OAuth connect request, and returns the provider.
+// Returns a map of invalid fields to error messages, which is empty if the request is valid.
+func (h *OAuthHandler) validateConnectRequest(req *models.OAuthConnectRequest, needsEmail bool) (*oauth.Provider, map[string]string) {
+	fieldErrors := map[string]string{}
+
+	provider, ok := h.providers[req.Provider]
+	if !ok {
+		fieldErrors["provider"] = "isn't configured on this server"
+	}
+	if req.Code == "" {
+		fieldErrors["code"] = "is required"
+	}
+	if req.RedirectURI == "" {
+		fieldErrors["redirect_uri"] = "is required"
+	}
+	if needsEmail {
+		if req.Email == "" {
+			fieldErrors["email"] = "is required for initial setup"
+		} else if address, err := mail.ParseAddress(req.Email); err != nil || address.Address != req.Email {
+			fieldErrors["email"] = "must be an email address, like name@example.com"
+		}
+	}
+
+	return provider, fieldErrors
+}
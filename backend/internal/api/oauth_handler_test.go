@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/oauth"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+// newTestOAuthProvider returns a provider whose token endpoint gives out "access-token" for "good-code", and rejects
+// any other code.
+func newTestOAuthProvider(t *testing.T) *oauth.Provider {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "good-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "invalid_grant", "error_description": "Bad code."}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token": "access-token", "refresh_token": "refresh-token", "expires_in": 3600}`))
+	}))
+	t.Cleanup(server.Close)
+	return &oauth.Provider{
+		Name:               "test",
+		TokenURL:           server.URL,
+		ClientID:           "client-id",
+		IMAPServerHostname: "imap.provider.example.com:993",
+		SMTPServerHostname: "smtp.provider.example.com:465",
+	}
+}
+
+func TestValidateConnectRequest(t *testing.T) {
+	handler := NewOAuthHandler(nil, nil, nil, map[string]*oauth.Provider{"test": {Name: "test"}})
+	valid := models.OAuthConnectRequest{Provider: "test", Code: "code", RedirectURI: "https://vmail.example.com/oauth"}
+
+	testCases := []struct {
+		name          string
+		modify        func(req *models.OAuthConnectRequest)
+		needsEmail    bool
+		invalidFields []string
+	}{
+		{"accepts existing users without an email", func(*models.OAuthConnectRequest) {}, false, nil},
+		{"accepts new users with an email", func(req *models.OAuthConnectRequest) { req.Email = "me@example.com" }, true, nil},
+		{"rejects unknown providers", func(req *models.OAuthConnectRequest) { req.Provider = "other" }, false, []string{"provider"}},
+		{"requires a code and a redirect URI", func(req *models.OAuthConnectRequest) { req.Code, req.RedirectURI = "", "" }, false, []string{"code", "redirect_uri"}},
+		{"requires an email from new users", func(*models.OAuthConnectRequest) {}, true, []string{"email"}},
+		{"rejects names in the email", func(req *models.OAuthConnectRequest) { req.Email = "Me <me@example.com>" }, true, []string{"email"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := valid
+			tc.modify(&req)
+			provider, fieldErrors := handler.validateConnectRequest(&req, tc.needsEmail)
+			if len(fieldErrors) != len(tc.invalidFields) {
+				t.Fatalf("Expected invalid fields %v, got %v", tc.invalidFields, fieldErrors)
+			}
+			for _, field := range tc.invalidFields {
+				if _, ok := fieldErrors[field]; !ok {
+					t.Errorf("Expected %s to be invalid, got %v", field, fieldErrors)
+				}
+			}
+			if len(fieldErrors) == 0 && (provider == nil || provider.Name != "test") {
+				t.Errorf("Expected the test provider, got %+v", provider)
+			}
+		})
+	}
+}
+
+func TestOAuthHandlerConnect(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	encryptor := getTestEncryptor(t)
+	imapPool := &mockIMAPPool{}
+	handler := NewOAuthHandler(pool, encryptor, imapPool, map[string]*oauth.Provider{"test": newTestOAuthProvider(t)})
+
+	connect := func(email, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/settings/oauth/connect", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		handler.Connect(rr, req)
+		return rr
+	}
+
+	t.Run("rejects a code that the provider rejects", func(t *testing.T) {
+		email := "oauth-rejected@example.com"
+		rr := connect(email, `{"provider": "test", "code": "bad-code", "redirect_uri": "https://vmail.example.com/oauth", "email": "`+email+`"}`)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status 400, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var response models.ValidationErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if _, ok := response.Fields["code"]; !ok {
+			t.Errorf("Expected the code to be invalid, got %v", response.Fields)
+		}
+
+		userID, err := db.GetOrCreateUser(context.Background(), pool, email)
+		if err != nil {
+			t.Fatalf("GetOrCreateUser failed: %v", err)
+		}
+		if _, err := db.GetUserSettings(context.Background(), pool, userID); !errors.Is(err, db.ErrUserSettingsNotFound) {
+			t.Errorf("Expected no settings, got %v", err)
+		}
+		if imapPool.removeClientCalled[userID] {
+			t.Error("Expected RemoveClient not to be called")
+		}
+	})
+
+	t.Run("sets up a new user with the provider's servers", func(t *testing.T) {
+		email := "oauth-new@example.com"
+		rr := connect(email, `{"provider": "test", "code": "good-code", "redirect_uri": "https://vmail.example.com/oauth", "email": "`+email+`"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		userID, err := db.GetOrCreateUser(context.Background(), pool, email)
+		if err != nil {
+			t.Fatalf("GetOrCreateUser failed: %v", err)
+		}
+		settings, err := db.GetUserSettings(context.Background(), pool, userID)
+		if err != nil {
+			t.Fatalf("GetUserSettings failed: %v", err)
+		}
+		if settings.IMAPServerHostname != "imap.provider.example.com:993" || settings.IMAPUsername != email ||
+			settings.SMTPServerHostname != "smtp.provider.example.com:465" || settings.SMTPUsername != email ||
+			settings.OAuthProvider != "test" {
+			t.Errorf("Unexpected settings: %+v", settings)
+		}
+		assertPasswordsAreToken(t, encryptor.Decrypt, settings, "access-token")
+		if !imapPool.removeClientCalled[userID] {
+			t.Error("Expected RemoveClient to be called")
+		}
+	})
+
+	t.Run("keeps the servers of existing settings", func(t *testing.T) {
+		email := "oauth-existing@example.com"
+		userID := setupTestUserAndSettings(t, pool, encryptor, email)
+
+		rr := connect(email, `{"provider": "test", "code": "good-code", "redirect_uri": "https://vmail.example.com/oauth"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		settings, err := db.GetUserSettings(context.Background(), pool, userID)
+		if err != nil {
+			t.Fatalf("GetUserSettings failed: %v", err)
+		}
+		if settings.IMAPServerHostname != "imap.test.com" || settings.IMAPUsername != "user" ||
+			settings.SMTPServerHostname != "smtp.test.com" || settings.OAuthProvider != "test" {
+			t.Errorf("Expected the existing servers, got %+v", settings)
+		}
+		assertPasswordsAreToken(t, encryptor.Decrypt, settings, "access-token")
+		if !imapPool.removeClientCalled[userID] {
+			t.Error("Expected RemoveClient to be called")
+		}
+	})
+}
+
+// assertPasswordsAreToken checks that both encrypted passwords of the settings hold the access token.
+func assertPasswordsAreToken(t *testing.T, decrypt func([]byte) (string, error), settings *models.UserSettings, accessToken string) {
+	t.Helper()
+	for name, encrypted := range map[string][]byte{"IMAP": settings.EncryptedIMAPPassword, "SMTP": settings.EncryptedSMTPPassword} {
+		password, err := decrypt(encrypted)
+		if err != nil {
+			t.Fatalf("Failed to decrypt the %s password: %v", name, err)
+		}
+		if token, ok := oauth.TokenFromPassword(password); !ok || token != accessToken {
+			t.Errorf("Expected the %s password to be the access token, got %q", name, password)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/pagination"
+)
+
+// maxUndoSendDelaySeconds caps how long sent emails can wait in the outbox.
+const maxUndoSendDelaySeconds = 300
+
+// maxUIPreferencesBytes caps the size of the free-form UI preferences bag.
+const maxUIPreferencesBytes = 64 * 1024
+
+// PreferencesHandler handles user preferences-related API requests.
+// Preferences are separate from settings, so it doesn't need the encryptor.
+type PreferencesHandler struct {
+	pool *pgxpool.Pool
+}
+
+// NewPreferencesHandler creates a new PreferencesHandler instance.
+func NewPreferencesHandler(pool *pgxpool.Pool) *PreferencesHandler {
+	return &PreferencesHandler{
+		pool: pool,
+	}
+}
+
+// GetPreferences returns the preferences for the current user.
+// Users who never saved preferences get the defaults.
+func (h *PreferencesHandler) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	prefs, err := db.GetUserPreferences(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "PreferencesHandler: Failed to get preferences", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !WriteJSONResponse(w, prefs) {
+		return
+	}
+}
+
+// PatchPreferences updates only the preferences present in the request body.
+// Omitted fields are left untouched, and explicit nulls reset fields to their defaults.
+// The "ui" object is merged key by key, and keys set to null are removed.
+// Responds with the updated preferences, or with per-field errors if any field is invalid.
+func (h *PreferencesHandler) PatchPreferences(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	// Decode into raw fields so that we can tell omitted fields from explicit nulls
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch == nil {
+		slog.InfoContext(ctx, "PreferencesHandler: Failed to decode patch request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	prefs, err := db.GetUserPreferences(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "PreferencesHandler: Failed to get preferences", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if fieldErrors := applyPreferencesPatch(prefs, patch); len(fieldErrors) > 0 {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid preferences",
+			Fields: fieldErrors,
+		})
+		return
+	}
+
+	if err := db.SaveUserPreferences(ctx, h.pool, prefs); err != nil {
+		slog.ErrorContext(ctx, "PreferencesHandler: Failed to save preferences", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !WriteJSONResponse(w, prefs) {
+		return
+	}
+}
+
+// applyPreferencesPatch applies the patch fields to the preferences.
+// Returns a map from field name to error message for invalid fields.
+func applyPreferencesPatch(prefs *models.UserPreferences, patch map[string]json.RawMessage) map[string]string {
+	fieldErrors := make(map[string]string)
+
+	for field, raw := range patch {
+		isNull := bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+
+		switch field {
+		case "undo_send_delay_seconds":
+			applyIntPreference(fieldErrors, field, raw, isNull, db.DefaultUndoSendDelaySeconds, 0, maxUndoSendDelaySeconds, &prefs.UndoSendDelaySeconds)
+		case "pagination_threads_per_page":
+			applyIntPreference(fieldErrors, field, raw, isNull, db.DefaultPaginationThreadsPerPage, 1, pagination.MaxLimit, &prefs.PaginationThreadsPerPage)
+		case "ui":
+			applyUIPreferences(fieldErrors, field, raw, isNull, prefs)
+		default:
+			fieldErrors[field] = "unknown field"
+		}
+	}
+
+	return fieldErrors
+}
+
+// applyIntPreference sets a whole-number preference within [minValue, maxValue], or resets it for null.
+func applyIntPreference(fieldErrors map[string]string, field string, raw json.RawMessage, isNull bool, defaultValue, minValue, maxValue int, target *int) {
+	if isNull {
+		*target = defaultValue
+		return
+	}
+	var value int
+	if err := json.Unmarshal(raw, &value); err != nil {
+		fieldErrors[field] = "must be a whole number"
+		return
+	}
+	if value < minValue || value > maxValue {
+		fieldErrors[field] = fmt.Sprintf("must be between %d and %d", minValue, maxValue)
+		return
+	}
+	*target = value
+}
+
+// applyUIPreferences merges the given object into the UI preferences bag.
+// Keys set to null are removed, and a null bag clears all UI preferences.
+func applyUIPreferences(fieldErrors map[string]string, field string, raw json.RawMessage, isNull bool, prefs *models.UserPreferences) {
+	if isNull {
+		prefs.UI = map[string]json.RawMessage{}
+		return
+	}
+	var uiPatch map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &uiPatch); err != nil || uiPatch == nil {
+		fieldErrors[field] = "must be an object"
+		return
+	}
+
+	merged := make(map[string]json.RawMessage, len(prefs.UI)+len(uiPatch))
+	for key, value := range prefs.UI {
+		merged[key] = value
+	}
+	for key, value := range uiPatch {
+		if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+			delete(merged, key)
+		} else {
+			merged[key] = value
+		}
+	}
+
+	encoded, err := json.Marshal(merged)
+	if err != nil || len(encoded) > maxUIPreferencesBytes {
+		fieldErrors[field] = fmt.Sprintf("must be at most %d bytes", maxUIPreferencesBytes)
+		return
+	}
+	prefs.UI = merged
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestPreferencesHandler(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	handler := NewPreferencesHandler(pool)
+
+	patch := func(email, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", "/api/v1/preferences", strings.NewReader(body))
+		ctx := context.WithValue(req.Context(), auth.UserEmailKey, email)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		handler.PatchPreferences(rr, req)
+		return rr
+	}
+
+	t.Run("returns defaults for new user", func(t *testing.T) {
+		req := createRequestWithUser("GET", "/api/v1/preferences", "prefs-new@example.com")
+		rr := httptest.NewRecorder()
+		handler.GetPreferences(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+
+		var response models.UserPreferences
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response.PaginationThreadsPerPage != db.DefaultPaginationThreadsPerPage {
+			t.Errorf("Expected PaginationThreadsPerPage %d, got %d", db.DefaultPaginationThreadsPerPage, response.PaginationThreadsPerPage)
+		}
+		if response.UI == nil {
+			t.Error("Expected ui to be an empty object, got null")
+		}
+	})
+
+	t.Run("patches fields and merges the ui bag", func(t *testing.T) {
+		email := "prefs-patch@example.com"
+
+		rr := patch(email, `{"pagination_threads_per_page": 30, "ui": {"theme": "dark", "sidebar": "wide"}}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		rr = patch(email, `{"undo_send_delay_seconds": 5, "ui": {"sidebar": null, "font": "serif"}}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		var response models.UserPreferences
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response.PaginationThreadsPerPage != 30 {
+			t.Errorf("Expected PaginationThreadsPerPage to stay 30, got %d", response.PaginationThreadsPerPage)
+		}
+		if response.UndoSendDelaySeconds != 5 {
+			t.Errorf("Expected UndoSendDelaySeconds 5, got %d", response.UndoSendDelaySeconds)
+		}
+		if string(response.UI["theme"]) != `"dark"` || string(response.UI["font"]) != `"serif"` {
+			t.Errorf("Expected theme and font to be set, got %v", response.UI)
+		}
+		if _, ok := response.UI["sidebar"]; ok {
+			t.Error("Expected sidebar to be removed")
+		}
+	})
+
+	t.Run("null resets fields to defaults", func(t *testing.T) {
+		email := "prefs-reset@example.com"
+		patch(email, `{"pagination_threads_per_page": 30, "ui": {"theme": "dark"}}`)
+
+		rr := patch(email, `{"pagination_threads_per_page": null, "ui": null}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+
+		var response models.UserPreferences
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response.PaginationThreadsPerPage != db.DefaultPaginationThreadsPerPage {
+			t.Errorf("Expected default PaginationThreadsPerPage, got %d", response.PaginationThreadsPerPage)
+		}
+		if len(response.UI) != 0 {
+			t.Errorf("Expected empty ui, got %v", response.UI)
+		}
+	})
+
+	t.Run("returns field errors for invalid fields", func(t *testing.T) {
+		rr := patch("prefs-invalid@example.com", `{
+			"pagination_threads_per_page": 0,
+			"undo_send_delay_seconds": "soon",
+			"ui": [1, 2],
+			"imap_password": "secret"
+		}`)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status 400, got %d", rr.Code)
+		}
+
+		var response models.ValidationErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		for _, field := range []string{"pagination_threads_per_page", "undo_send_delay_seconds", "ui", "imap_password"} {
+			if response.Fields[field] == "" {
+				t.Errorf("Expected an error for field %s", field)
+			}
+		}
+	})
+
+	t.Run("returns 400 for invalid request body", func(t *testing.T) {
+		rr := patch("prefs-bad-body@example.com", "not json")
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/push"
+)
+
+// PushHandler tells browsers how to subscribe to Web Push. Subscriptions are saved as devices, see DevicesHandler.
+type PushHandler struct {
+	vapid *push.VAPID
+}
+
+// NewPushHandler creates a new PushHandler instance. vapid is nil if Web Push is off.
+func NewPushHandler(vapid *push.VAPID) *PushHandler {
+	return &PushHandler{
+		vapid: vapid,
+	}
+}
+
+// GetVAPIDPublicKey responds with the public key that browsers subscribe with.
+// Returns 404 if Web Push is off.
+// The path is /api/v1/push/vapid-public-key.
+func (h *PushHandler) GetVAPIDPublicKey(w http.ResponseWriter, _ *http.Request) {
+	if h.vapid == nil {
+		http.Error(w, "Web Push is not configured", http.StatusNotFound)
+		return
+	}
+	WriteJSONResponse(w, models.VAPIDPublicKeyResponse{PublicKey: h.vapid.PublicKey()})
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/push"
+)
+
+func TestPushHandler_GetVAPIDPublicKey(t *testing.T) {
+	t.Run("returns the public key", func(t *testing.T) {
+		privateKey, err := ecdh.P256().GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+		vapid, err := push.NewVAPID(base64.RawURLEncoding.EncodeToString(privateKey.Bytes()), "mailto:admin@example.com")
+		if err != nil {
+			t.Fatalf("NewVAPID failed: %v", err)
+		}
+
+		rr := httptest.NewRecorder()
+		NewPushHandler(vapid).GetVAPIDPublicKey(rr, httptest.NewRequest("GET", "/api/v1/push/vapid-public-key", nil))
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+		var response models.VAPIDPublicKeyResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if response.PublicKey != vapid.PublicKey() {
+			t.Errorf("Expected public key %s, got %s", vapid.PublicKey(), response.PublicKey)
+		}
+	})
+
+	t.Run("returns 404 if Web Push is off", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		NewPushHandler(nil).GetVAPIDPublicKey(rr, httptest.NewRequest("GET", "/api/v1/push/vapid-public-key", nil))
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rr.Code)
+		}
+	})
+}
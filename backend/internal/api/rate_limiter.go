@@ -0,0 +1,63 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/ratelimit"
+)
+
+// RateLimiter limits how many API requests each user can make over time, with a token bucket per user.
+// Users can make burst requests at once, and then requestsPerMinute requests per minute on average.
+// Requests over the limit get a 429 right away, with a Retry-After header for when the next request is allowed.
+// Unlike InFlightLimiter, it limits the rate of requests, not how many are running at the same time.
+type RateLimiter struct {
+	store ratelimit.Store
+	rate  float64
+	burst int
+	now   func() time.Time
+}
+
+// NewRateLimiter creates a limiter that keeps its buckets in store.
+// A requestsPerMinute of 0 or less means no limit.
+func NewRateLimiter(store ratelimit.Store, requestsPerMinute, burst int) *RateLimiter {
+	return &RateLimiter{
+		store: store,
+		rate:  float64(requestsPerMinute) / 60,
+		burst: max(burst, 1),
+		now:   time.Now,
+	}
+}
+
+// Limit wraps a handler with the limiter. It must run after auth.RequireAuth, since it identifies users by email.
+// If the store fails, it lets the request through, since a broken limiter shouldn't take the API down.
+func (l *RateLimiter) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		email, ok := auth.GetUserEmailFromContext(r.Context())
+		if l.rate <= 0 || !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, retryAfter, err := l.store.Take(r.Context(), "user:"+email, l.rate, l.burst, l.now())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "RateLimiter: Failed to check the rate limit", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(retryAfter)))
+			WriteJSONResponseWithStatus(w, http.StatusTooManyRequests, models.ErrorResponse{
+				Error: "Too many requests, please slow down",
+				Code:  ErrorCodeTooManyRequests,
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/ratelimit"
+)
+
+// failingRateLimitStore is a rate limit store that always fails.
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Take(context.Context, string, float64, int, time.Time) (bool, time.Duration, error) {
+	return false, 0, errors.New("database is down")
+}
+
+func TestRateLimiter(t *testing.T) {
+	okHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	serve := func(handler http.Handler, email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/threads", nil)
+		if email != "" {
+			req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("returns 429 with Retry-After when the user is over the limit", func(t *testing.T) {
+		now := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+		limiter := NewRateLimiter(ratelimit.NewMemoryStore(), 6, 2)
+		limiter.now = func() time.Time { return now }
+		limited := limiter.Limit(okHandler)
+
+		for i := 0; i < 2; i++ {
+			if rr := serve(limited, "user@example.com"); rr.Code != http.StatusOK {
+				t.Fatalf("Expected status 200 for request %d, got %d", i+1, rr.Code)
+			}
+		}
+
+		rr := serve(limited, "user@example.com")
+		if rr.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected status 429, got %d", rr.Code)
+		}
+		// 6 requests per minute is a request every 10 seconds
+		if rr.Header().Get("Retry-After") != "10" {
+			t.Errorf("Expected Retry-After 10, got %q", rr.Header().Get("Retry-After"))
+		}
+		var response models.ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response.Code != ErrorCodeTooManyRequests {
+			t.Errorf("Expected code %s, got %s", ErrorCodeTooManyRequests, response.Code)
+		}
+
+		// Other users have their own limit
+		if rr := serve(limited, "other@example.com"); rr.Code != http.StatusOK {
+			t.Errorf("Expected status 200 for another user, got %d", rr.Code)
+		}
+
+		now = now.Add(10 * time.Second)
+		if rr := serve(limited, "user@example.com"); rr.Code != http.StatusOK {
+			t.Errorf("Expected status 200 after the wait, got %d", rr.Code)
+		}
+	})
+
+	t.Run("doesn't limit with a zero rate", func(t *testing.T) {
+		limited := NewRateLimiter(ratelimit.NewMemoryStore(), 0, 1).Limit(okHandler)
+		for i := 0; i < 5; i++ {
+			if rr := serve(limited, "user@example.com"); rr.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", rr.Code)
+			}
+		}
+	})
+
+	t.Run("lets requests through when the store fails", func(t *testing.T) {
+		limited := NewRateLimiter(failingRateLimitStore{}, 60, 1).Limit(okHandler)
+		if rr := serve(limited, "user@example.com"); rr.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", rr.Code)
+		}
+	})
+
+	t.Run("lets requests without a user through", func(t *testing.T) {
+		limited := NewRateLimiter(ratelimit.NewMemoryStore(), 60, 1).Limit(okHandler)
+		for i := 0; i < 3; i++ {
+			if rr := serve(limited, ""); rr.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", rr.Code)
+			}
+		}
+	})
+}
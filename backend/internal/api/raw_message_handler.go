@@ -0,0 +1,77 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imap"
+)
+
+// RawMessageHandler serves the original source of messages, for "show original" and for debugging.
+type RawMessageHandler struct {
+	pool    *pgxpool.Pool
+	fetcher imap.RawMessageFetcher
+}
+
+// NewRawMessageHandler creates a new RawMessageHandler instance.
+func NewRawMessageHandler(pool *pgxpool.Pool, fetcher imap.RawMessageFetcher) *RawMessageHandler {
+	return &RawMessageHandler{
+		pool:    pool,
+		fetcher: fetcher,
+	}
+}
+
+// GetRawMessage fetches the RFC 822 source of a cached message from the IMAP server, and responds with it as
+// message/rfc822. We don't cache sources, so each request goes to the server. Messages over the fetch size limit
+// only come with their start, and the X-Message-Truncated header.
+// The path is /api/v1/messages/{id}/raw.
+func (h *RawMessageHandler) GetRawMessage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	id, ok := getUUIDFromPath(r.URL.Path, "/api/v1/messages/", "/raw")
+	if !ok {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	folderName, imapUID, err := db.GetMessageLocation(ctx, h.pool, userID, id)
+	if err != nil {
+		if errors.Is(err, db.ErrMessageNotFound) {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "RawMessageHandler: Failed to get message", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	raw, err := h.fetcher.FetchRawMessage(ctx, userID, folderName, imapUID)
+	if err != nil {
+		if errors.Is(err, imap.ErrMessageNotFound) {
+			http.Error(w, "Message not found on the mail server", http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "RawMessageHandler: Failed to fetch message", "folder", folderName, "uid", imapUID, "error", err)
+		http.Error(w, "Failed to fetch the message from the mail server", http.StatusBadGateway)
+		return
+	}
+
+	writeDownloadHeaders(w, "message.eml", "message/rfc822", false)
+	w.Header().Set("Content-Length", strconv.Itoa(raw.Length))
+	if raw.Truncated {
+		w.Header().Set("X-Message-Truncated", "true")
+	}
+	if _, err := io.Copy(w, raw.Body); err != nil {
+		slog.WarnContext(ctx, "RawMessageHandler: Failed to write message", "error", err)
+	}
+}
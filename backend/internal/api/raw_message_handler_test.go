@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+// mockRawMessageFetcher returns the sources of the messages in sources, by UID, and ErrMessageNotFound for the rest.
+type mockRawMessageFetcher struct {
+	sources   map[int64]string
+	truncated bool
+}
+
+func (m *mockRawMessageFetcher) FetchRawMessage(_ context.Context, _, _ string, imapUID int64) (*imap.RawMessage, error) {
+	source, ok := m.sources[imapUID]
+	if !ok {
+		return nil, imap.ErrMessageNotFound
+	}
+	return &imap.RawMessage{Body: strings.NewReader(source), Length: len(source), Truncated: m.truncated}, nil
+}
+
+func TestRawMessageHandler_GetRawMessage(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	email := "raw-message-test@example.com"
+	userID := setupTestUserAndSettings(t, pool, getTestEncryptor(t), email)
+
+	thread := &models.Thread{UserID: userID, StableThreadID: "<raw-thread@example.com>", Subject: "Raw"}
+	if err := db.SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+	saveMessage := func(uid int64) string {
+		msg := &models.Message{ThreadID: thread.ID, UserID: userID, IMAPUID: uid, IMAPFolderName: "INBOX"}
+		if err := db.SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+		return msg.ID
+	}
+	onServer, gone := saveMessage(1), saveMessage(2)
+
+	source := "Subject: Raw\r\n\r\nHello\r\n"
+	handler := NewRawMessageHandler(pool, &mockRawMessageFetcher{sources: map[int64]string{1: source}})
+	get := func(id, email string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.GetRawMessage(rr, createRequestWithUser("GET", "/api/v1/messages/"+id+"/raw", email))
+		return rr
+	}
+
+	t.Run("responds with the source", func(t *testing.T) {
+		rr := get(onServer, email)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if rr.Body.String() != source || rr.Header().Get("Content-Type") != "message/rfc822" {
+			t.Errorf("Expected the source as message/rfc822, got %q as %q", rr.Body.String(), rr.Header().Get("Content-Type"))
+		}
+		if rr.Header().Get("X-Message-Truncated") != "" {
+			t.Error("Expected no truncation header")
+		}
+	})
+
+	t.Run("returns 404 for messages that are gone from the server", func(t *testing.T) {
+		if rr := get(gone, email); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rr.Code)
+		}
+	})
+
+	t.Run("returns 404 for messages of other users", func(t *testing.T) {
+		otherEmail := "raw-message-other@example.com"
+		setupTestUserAndSettings(t, pool, getTestEncryptor(t), otherEmail)
+		if rr := get(onServer, otherEmail); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rr.Code)
+		}
+	})
+
+	t.Run("returns 400 for invalid IDs", func(t *testing.T) {
+		if rr := get("not-a-uuid", email); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/smtp"
+)
+
+// rsvpStatuses maps the answers of RSVP requests to the statuses of calendar attendees.
+var rsvpStatuses = map[string]string{
+	"accept":    models.CalendarStatusAccepted,
+	"tentative": models.CalendarStatusTentative,
+	"decline":   models.CalendarStatusDeclined,
+}
+
+// rsvpSubjectPrefixes are the subject prefixes of RSVPs, like the ones other mail clients use, by status.
+var rsvpSubjectPrefixes = map[string]string{
+	models.CalendarStatusAccepted:  "Accepted",
+	models.CalendarStatusTentative: "Tentatively accepted",
+	models.CalendarStatusDeclined:  "Declined",
+}
+
+// rsvpVerbs describe the answers in the text body of RSVPs, by status.
+var rsvpVerbs = map[string]string{
+	models.CalendarStatusAccepted:  "accepted",
+	models.CalendarStatusTentative: "tentatively accepted",
+	models.CalendarStatusDeclined:  "declined",
+}
+
+// RSVPHandler answers calendar invites.
+type RSVPHandler struct {
+	pool        *pgxpool.Pool
+	smtpService *smtp.Service
+}
+
+// NewRSVPHandler creates a new RSVPHandler instance.
+func NewRSVPHandler(pool *pgxpool.Pool, smtpService *smtp.Service) *RSVPHandler {
+	return &RSVPHandler{
+		pool:        pool,
+		smtpService: smtpService,
+	}
+}
+
+// rsvpAttendee is who the user answers an invite as.
+type rsvpAttendee struct {
+	address mail.Address
+	// identityID is the send identity to send the answer as, or empty for the user's main address.
+	identityID string
+}
+
+// RespondToInvite answers the invite of a message: it sends an iTIP REPLY with the answer to the organizer through
+// the user's SMTP server, and saves the answer in the event. Responds with the updated event.
+// The user answers as the attendee that is one of their addresses, or as their main address if none is.
+// The path is /api/v1/messages/{id}/rsvp.
+func (h *RSVPHandler) RespondToInvite(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+	loginEmail, _ := auth.GetUserEmailFromContext(ctx)
+
+	id, ok := getUUIDFromPath(r.URL.Path, "/api/v1/messages/", "/rsvp")
+	if !ok {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.RSVPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBodyError(w, err)
+		return
+	}
+	status, ok := rsvpStatuses[req.Response]
+	if !ok {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid RSVP",
+			Fields: map[string]string{"response": "must be accept, tentative, or decline"},
+		})
+		return
+	}
+
+	event, err := db.GetCalendarEvent(ctx, h.pool, userID, id)
+	if err != nil {
+		if errors.Is(err, db.ErrCalendarEventNotFound) {
+			http.Error(w, "Message has no invite", http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "RSVPHandler: Failed to get calendar event", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if event.Method != "REQUEST" || event.Organizer == nil {
+		http.Error(w, "Only invites with an organizer can be answered", http.StatusConflict)
+		return
+	}
+
+	attendee, err := h.findAttendee(ctx, userID, loginEmail, event)
+	if err != nil {
+		slog.ErrorContext(ctx, "RSVPHandler: Failed to get the user's addresses", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	email := &models.OutgoingEmail{
+		To:             []string{event.Organizer.Email},
+		Subject:        rsvpSubjectPrefixes[status] + ": " + event.Summary,
+		TextBody:       fmt.Sprintf("%s has %s the invite.", attendeeDisplayName(attendee.address), rsvpVerbs[status]),
+		IdentityID:     attendee.identityID,
+		Calendar:       smtp.BuildCalendarReply(event, attendee.address, status, time.Now()),
+		CalendarMethod: "REPLY",
+	}
+	if _, err := h.smtpService.SendEmail(ctx, userID, loginEmail, email); err != nil {
+		slog.ErrorContext(ctx, "RSVPHandler: Failed to send RSVP", "error", err)
+		http.Error(w, "Failed to send the RSVP", http.StatusBadGateway)
+		return
+	}
+
+	setAttendeeStatus(event, attendee.address, status)
+	// The RSVP is already sent, so failing to save the answer shouldn't fail the request
+	if err := db.SaveCalendarEvent(ctx, h.pool, id, event); err != nil {
+		slog.WarnContext(ctx, "RSVPHandler: Failed to save the answer", "error", err)
+	}
+
+	WriteJSONResponse(w, event)
+}
+
+// findAttendee returns the attendee of the event that is one of the user's addresses: a send identity, the login
+// email, the IMAP and SMTP usernames, or a plus alias. Identities are sent as. If none of the attendees is the user,
+// for example, because they were invited through a mailing list, it returns the user's main address.
+func (h *RSVPHandler) findAttendee(ctx context.Context, userID, loginEmail string, event *models.CalendarEvent) (*rsvpAttendee, error) {
+	identities, err := db.GetSendIdentities(ctx, h.pool, userID)
+	if err != nil {
+		return nil, err
+	}
+	ownAddresses, err := db.GetOwnAddresses(ctx, h.pool, userID)
+	if err != nil {
+		return nil, err
+	}
+	aliasLabels, err := db.GetPlusAliasLabels(ctx, h.pool, userID)
+	if err != nil {
+		return nil, err
+	}
+	own := map[string]bool{strings.ToLower(loginEmail): true}
+	for _, address := range ownAddresses {
+		own[strings.ToLower(address)] = true
+	}
+	for address := range aliasLabels {
+		own[address] = true
+	}
+
+	for _, attendee := range event.Attendees {
+		address := mail.Address{Name: attendee.Name, Address: attendee.Email}
+		for _, identity := range identities {
+			if strings.EqualFold(identity.Email, attendee.Email) {
+				return &rsvpAttendee{address: address, identityID: identity.ID}, nil
+			}
+		}
+		if own[strings.ToLower(attendee.Email)] {
+			return &rsvpAttendee{address: address}, nil
+		}
+	}
+
+	settings, err := db.GetUserSettings(ctx, h.pool, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &rsvpAttendee{address: smtp.SenderAddress(settings.SMTPUsername, loginEmail)}, nil
+}
+
+// setAttendeeStatus sets the status of the attendee with the address, and adds them if they're not an attendee yet.
+func setAttendeeStatus(event *models.CalendarEvent, address mail.Address, status string) {
+	for i := range event.Attendees {
+		if strings.EqualFold(event.Attendees[i].Email, address.Address) {
+			event.Attendees[i].Status = status
+			return
+		}
+	}
+	event.Attendees = append(event.Attendees, models.CalendarParticipant{
+		Email:  address.Address,
+		Name:   address.Name,
+		Status: status,
+	})
+}
+
+// attendeeDisplayName returns the name of the address, or the address itself if it has no name.
+func attendeeDisplayName(address mail.Address) string {
+	if address.Name != "" {
+		return address.Name
+	}
+	return address.Address
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/smtp"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestRSVPHandler_RespondToInvite(t *testing.T) {
+	t.Setenv("VMAIL_TEST_MODE", "true")
+
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	encryptor := getTestEncryptor(t)
+	smtpServer := testutil.NewTestSMTPServer(t)
+	defer smtpServer.Close()
+
+	email := "rsvp-test@example.com"
+	userID := setupTestUserAndSettings(t, pool, encryptor, email)
+	settings, err := db.GetUserSettings(ctx, pool, userID)
+	if err != nil {
+		t.Fatalf("Failed to get settings: %v", err)
+	}
+	settings.SMTPServerHostname = smtpServer.Address
+	if err := db.SaveUserSettings(ctx, pool, settings); err != nil {
+		t.Fatalf("Failed to save settings: %v", err)
+	}
+
+	thread := &models.Thread{UserID: userID, StableThreadID: "<rsvp-thread@example.com>", Subject: "Plans"}
+	if err := db.SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+	saveInvite := func(uid int64, method string) string {
+		msg := &models.Message{ThreadID: thread.ID, UserID: userID, IMAPUID: uid, IMAPFolderName: "INBOX"}
+		if err := db.SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+		event := &models.CalendarEvent{
+			UID:       "plans@example.com",
+			Method:    method,
+			Summary:   "Plans",
+			StartsAt:  time.Date(2025, 3, 4, 9, 0, 0, 0, time.UTC),
+			Organizer: &models.CalendarParticipant{Email: "alice@example.com", Name: "Alice"},
+			Attendees: []models.CalendarParticipant{
+				{Email: "bob@example.com", Status: "needs-action"},
+				{Email: strings.ToUpper(email), Name: "Me", Status: "needs-action"},
+			},
+		}
+		if err := db.SaveCalendarEvent(ctx, pool, msg.ID, event); err != nil {
+			t.Fatalf("SaveCalendarEvent failed: %v", err)
+		}
+		return msg.ID
+	}
+	invite, cancellation := saveInvite(1, "REQUEST"), saveInvite(2, "CANCEL")
+
+	handler := NewRSVPHandler(pool, smtp.NewService(pool, encryptor))
+	respond := func(id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/messages/"+id+"/rsvp", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		handler.RespondToInvite(rr, req)
+		return rr
+	}
+
+	t.Run("sends a reply to the organizer and saves the answer", func(t *testing.T) {
+		smtpServer.ClearMessages()
+		rr := respond(invite, `{"response": "tentative"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		messages := smtpServer.GetMessages()
+		if len(messages) != 1 || len(messages[0].To) != 1 || messages[0].To[0] != "alice@example.com" {
+			t.Fatalf("Expected one message to the organizer, got %+v", messages)
+		}
+		raw := string(messages[0].Data)
+		for _, part := range []string{"Subject: Tentatively accepted: Plans", "method=REPLY", "METHOD:REPLY",
+			`ATTENDEE;PARTSTAT=TENTATIVE;CN="Me":mailto:` + strings.ToUpper(email)} {
+			if !strings.Contains(raw, part) {
+				t.Errorf("Expected %q in the reply, got:\n%s", part, raw)
+			}
+		}
+
+		var event models.CalendarEvent
+		if err := json.Unmarshal(rr.Body.Bytes(), &event); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if event.Attendees[0].Status != "needs-action" || event.Attendees[1].Status != models.CalendarStatusTentative {
+			t.Errorf("Expected only the user's answer to change, got %+v", event.Attendees)
+		}
+		saved, err := db.GetCalendarEvent(ctx, pool, userID, invite)
+		if err != nil {
+			t.Fatalf("GetCalendarEvent failed: %v", err)
+		}
+		if saved.Attendees[1].Status != models.CalendarStatusTentative {
+			t.Errorf("Expected the answer to be saved, got %+v", saved.Attendees)
+		}
+	})
+
+	t.Run("returns 400 for unknown answers", func(t *testing.T) {
+		if rr := respond(invite, `{"response": "maybe"}`); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("returns 409 for messages that aren't invites", func(t *testing.T) {
+		if rr := respond(cancellation, `{"response": "accept"}`); rr.Code != http.StatusConflict {
+			t.Errorf("Expected status 409, got %d", rr.Code)
+		}
+	})
+
+	t.Run("returns 404 for messages without an invite", func(t *testing.T) {
+		if rr := respond("0b8f5a4e-2d6c-4a47-9f4e-3c1f9a0e7b21", `{"response": "accept"}`); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rr.Code)
+		}
+	})
+}
+
+func TestSetAttendeeStatus(t *testing.T) {
+	event := &models.CalendarEvent{Attendees: []models.CalendarParticipant{{Email: "Bob@example.com", Status: "needs-action"}}}
+
+	setAttendeeStatus(event, mail.Address{Address: "bob@example.com"}, models.CalendarStatusDeclined)
+	if len(event.Attendees) != 1 || event.Attendees[0].Status != models.CalendarStatusDeclined {
+		t.Errorf("Expected Bob to decline, got %+v", event.Attendees)
+	}
+
+	setAttendeeStatus(event, mail.Address{Name: "Carol", Address: "carol@example.com"}, models.CalendarStatusAccepted)
+	if len(event.Attendees) != 2 || event.Attendees[1] != (models.CalendarParticipant{Email: "carol@example.com", Name: "Carol", Status: "accepted"}) {
+		t.Errorf("Expected Carol to be added, got %+v", event.Attendees)
+	}
+}
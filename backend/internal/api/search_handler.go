@@ -3,12 +3,13 @@ package api
 import (
 	"context"
 	"errors"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/vdavid/vmail/backend/internal/crypto"
 	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/models"
 )
 
 // SearchHandler handles search-related API requests.
@@ -41,11 +42,13 @@ func (h *SearchHandler) Search(w http.ResponseWriter, r *http.Request) {
 	// Empty query means return all emails
 
 	// Get pagination params
-	page, limitFromQuery := ParsePaginationParams(r, 100)
-	limit := GetPaginationLimit(ctx, h.pool, userID, limitFromQuery)
+	params, ok := GetPaginationParams(ctx, w, r, h.pool, userID)
+	if !ok {
+		return
+	}
 
 	// Call IMAP service search
-	threads, totalCount, err := h.imapService.Search(ctx, userID, query, page, limit)
+	threads, totalCount, err := h.imapService.Search(ctx, userID, query, params.Page, params.Limit)
 	if err != nil {
 		// Treat client cancellations as non-errors
 		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
@@ -54,18 +57,31 @@ func (h *SearchHandler) Search(w http.ResponseWriter, r *http.Request) {
 
 		// Check if it's a query parsing error (should return 400)
 		if errors.Is(err, imap.ErrInvalidSearchQuery) {
-			log.Printf("SearchHandler: Invalid query: %v", err)
+			slog.InfoContext(ctx, "SearchHandler: Invalid query", "error", err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		log.Printf("SearchHandler: Failed to search: %v", err)
+		slog.ErrorContext(ctx, "SearchHandler: Failed to search", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
 	// Build and send the response
-	response := BuildPaginationResponse(threads, totalCount, page, limit)
+	response := BuildPaginationResponse(threads, totalCount, params)
 	if !WriteJSONResponse(w, response) {
 		return
 	}
 }
+
+// Suggest returns the search operators that the last word of the "q" query parameter could be the start of,
+// so that the search box can suggest them while the user types.
+func (h *SearchHandler) Suggest(w http.ResponseWriter, r *http.Request) {
+	if _, ok := GetUserIDFromContext(r.Context(), w, h.pool); !ok {
+		return
+	}
+
+	suggestions := imap.SuggestSearchOperators(r.URL.Query().Get("q"))
+	if !WriteJSONResponse(w, models.SearchSuggestionsResponse{Suggestions: suggestions}) {
+		return
+	}
+}
@@ -7,10 +7,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/vdavid/vmail/backend/internal/auth"
 	"github.com/vdavid/vmail/backend/internal/imap"
 	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/pagination"
 	"github.com/vdavid/vmail/backend/internal/testutil"
 	ws "github.com/vdavid/vmail/backend/internal/websocket"
 )
@@ -242,7 +244,7 @@ func TestSearchHandler_Search(t *testing.T) {
 			{"both invalid", "q=test&page=0&limit=0", 1, 100},
 			{"non-numeric page", "q=test&page=abc&limit=50", 1, 50},
 			{"non-numeric limit", "q=test&page=1&limit=xyz", 1, 100},
-			{"very large limit", "q=test&page=1&limit=999999", 1, 999999},
+			{"very large limit is clamped", "q=test&page=1&limit=999999", 1, pagination.MaxLimit},
 		}
 
 		for _, tc := range testCases {
@@ -305,6 +307,26 @@ func (m *mockIMAPServiceForSearch) SyncThreadsForFolder(context.Context, string,
 	return nil
 }
 
+func (m *mockIMAPServiceForSearch) PreviewFolder(context.Context, string, string, int) ([]*models.Thread, int, error) {
+	return nil, 0, nil
+}
+
+func (m *mockIMAPServiceForSearch) AppendToSent(context.Context, string, []byte, time.Time) error {
+	return nil
+}
+
+func (m *mockIMAPServiceForSearch) SaveDraft(context.Context, string, []byte, string) error {
+	return nil
+}
+
+func (m *mockIMAPServiceForSearch) DeleteDraft(context.Context, string, string) error {
+	return nil
+}
+
+func (m *mockIMAPServiceForSearch) MoveMessages(_ context.Context, _ string, messages []imap.MessageToMove, destination imap.MoveDestination) (string, []int64, error) {
+	return destination.FolderName, make([]int64, len(messages)), nil
+}
+
 func (m *mockIMAPServiceForSearch) SyncFullMessage(context.Context, string, string, int64) error {
 	return nil
 }
@@ -313,6 +335,18 @@ func (m *mockIMAPServiceForSearch) SyncFullMessages(context.Context, string, []i
 	return nil
 }
 
+func (m *mockIMAPServiceForSearch) StoreLabel(_ context.Context, _ string, messages []imap.MessageToSync, _ string, _ bool) ([]bool, error) {
+	return make([]bool, len(messages)), nil
+}
+
+func (m *mockIMAPServiceForSearch) MarkJunk(context.Context, string, []imap.MessageToSync, bool) error {
+	return nil
+}
+
+func (m *mockIMAPServiceForSearch) GetFolders(context.Context, string) ([]*models.Folder, error) {
+	return nil, nil
+}
+
 func (m *mockIMAPServiceForSearch) Search(_ context.Context, _ string, query string, page, limit int) ([]*models.Thread, int, error) {
 	m.searchQuery = query
 	m.searchPage = page
@@ -0,0 +1,241 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/outbox"
+	"github.com/vdavid/vmail/backend/internal/smtp"
+)
+
+// SendHandler handles sending messages.
+type SendHandler struct {
+	pool        *pgxpool.Pool
+	smtpService *smtp.Service
+	imapService imap.IMAPService
+}
+
+// NewSendHandler creates a new SendHandler instance.
+func NewSendHandler(pool *pgxpool.Pool, smtpService *smtp.Service, imapService imap.IMAPService) *SendHandler {
+	return &SendHandler{
+		pool:        pool,
+		smtpService: smtpService,
+		imapService: imapService,
+	}
+}
+
+// SendMessage queues a message in the outbox for the user's undo send delay, or until its send_at time if it has one,
+// and returns 202 with its ID. If the user has no delay and the message has no send_at, it sends the message right
+// away through their SMTP server, saves a copy to their Sent folder, and returns 200.
+func (h *SendHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+	loginEmail, _ := auth.GetUserEmailFromContext(ctx)
+
+	var email models.OutgoingEmail
+	if err := json.NewDecoder(r.Body).Decode(&email); err != nil {
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	uploadError, err := resolveAttachmentUploads(ctx, h.pool, userID, email.Attachments)
+	if err != nil {
+		slog.ErrorContext(ctx, "SendHandler: Failed to get attachment uploads", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	fieldErrors := validateOutgoingEmail(&email)
+	if uploadError != "" {
+		fieldErrors["attachments"] = uploadError
+	}
+	if email.IdentityID != "" {
+		found, err := h.sendIdentityExists(r, userID, email.IdentityID)
+		if err != nil {
+			slog.ErrorContext(ctx, "SendHandler: Failed to get send identity", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			fieldErrors["identity_id"] = "unknown identity"
+		}
+	}
+	if len(fieldErrors) > 0 {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid message",
+			Fields: fieldErrors,
+		})
+		return
+	}
+
+	if email.SendAt != nil {
+		// The outbox keeps the time, and it moves it on retries, so the payload doesn't need it
+		sendAt := *email.SendAt
+		email.SendAt = nil
+		h.queueMessage(w, r, userID, loginEmail, &email, sendAt)
+		return
+	}
+
+	prefs, err := db.GetUserPreferences(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "SendHandler: Failed to get preferences", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if prefs.UndoSendDelaySeconds > 0 {
+		h.queueMessage(w, r, userID, loginEmail, &email, time.Now().Add(time.Duration(prefs.UndoSendDelaySeconds)*time.Second))
+		return
+	}
+
+	msg, err := h.smtpService.SendEmail(ctx, userID, loginEmail, &email)
+	if err != nil {
+		slog.ErrorContext(ctx, "SendHandler: Failed to send message", "error", err)
+		http.Error(w, "Failed to send message", http.StatusBadGateway)
+		return
+	}
+	deleteSentAttachmentUploads(ctx, h.pool, userID, email.Attachments)
+
+	// The message is already sent, so failing to save a copy shouldn't fail the request
+	savedToSent := true
+	if err := h.imapService.AppendToSent(ctx, userID, msg.Raw, msg.Date); err != nil {
+		slog.WarnContext(ctx, "SendHandler: Failed to save sent message to the Sent folder", "message_id", msg.MessageID, "error", err)
+		savedToSent = false
+	}
+
+	WriteJSONResponse(w, models.SendEmailResponse{
+		MessageID:   msg.MessageID,
+		SavedToSent: savedToSent,
+	})
+}
+
+// sendIdentityExists returns true if the user has a send identity with the given ID.
+func (h *SendHandler) sendIdentityExists(r *http.Request, userID, identityID string) (bool, error) {
+	if uuid.Validate(identityID) != nil {
+		return false, nil
+	}
+	_, err := db.GetSendIdentity(r.Context(), h.pool, userID, identityID)
+	if errors.Is(err, db.ErrSendIdentityNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// queueMessage adds the message to the outbox, so that the outbox dispatcher sends it at sendAt.
+func (h *SendHandler) queueMessage(w http.ResponseWriter, r *http.Request, userID, loginEmail string, email *models.OutgoingEmail, sendAt time.Time) {
+	id, err := outbox.Queue(r.Context(), h.pool, userID, loginEmail, email, sendAt)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "SendHandler: Failed to queue message", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	deleteSentAttachmentUploads(r.Context(), h.pool, userID, email.Attachments)
+
+	WriteJSONResponseWithStatus(w, http.StatusAccepted, models.QueuedEmailResponse{
+		ID:     id,
+		SendAt: sendAt,
+	})
+}
+
+// GetScheduledMessages lists the user's messages in the outbox, the soonest first.
+// The path is /api/v1/messages/scheduled. The user can cancel them with CancelMessage.
+func (h *SendHandler) GetScheduledMessages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	messages, err := outbox.List(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "SendHandler: Failed to list scheduled messages", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	WriteJSONResponse(w, models.ScheduledEmailsResponse{Messages: messages})
+}
+
+// CancelMessage removes a queued message from the outbox, so that it's never sent.
+// The path is /api/v1/messages/{id}/cancel. Returns 404 if the message is already sent or doesn't exist.
+func (h *SendHandler) CancelMessage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	id, ok := getUUIDFromPath(r.URL.Path, "/api/v1/messages/", "/cancel")
+	if !ok {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	cancelled, err := outbox.Cancel(ctx, h.pool, userID, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "SendHandler: Failed to cancel message", "action_id", id, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !cancelled {
+		http.Error(w, "Message not found or already sent", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateOutgoingEmail checks the addresses, attachments, and send time of a message.
+// Returns a map of invalid fields to error messages, which is empty if the message is valid.
+func validateOutgoingEmail(email *models.OutgoingEmail) map[string]string {
+	fieldErrors := map[string]string{}
+
+	for field, addresses := range map[string][]string{"to": email.To, "cc": email.Cc, "bcc": email.Bcc} {
+		if _, err := smtp.ParseAddressList(addresses); err != nil {
+			fieldErrors[field] = err.Error()
+		}
+	}
+	if len(email.To)+len(email.Cc)+len(email.Bcc) == 0 {
+		fieldErrors["to"] = smtp.ErrNoRecipients.Error()
+	}
+
+	for _, attachment := range email.Attachments {
+		if attachment.Filename == "" {
+			fieldErrors["attachments"] = "every attachment needs a filename"
+			break
+		}
+		if attachment.ContentType != "" {
+			if _, _, err := mime.ParseMediaType(attachment.ContentType); err != nil {
+				fieldErrors["attachments"] = fmt.Sprintf("invalid content type %q", attachment.ContentType)
+				break
+			}
+		}
+		if strings.ContainsAny(attachment.ContentID, "<> \t\r\n") {
+			fieldErrors["attachments"] = "content IDs go without angle brackets and spaces"
+			break
+		}
+	}
+
+	if email.SendAt != nil && !email.SendAt.After(time.Now()) {
+		fieldErrors["send_at"] = "must be in the future"
+	}
+
+	return fieldErrors
+}
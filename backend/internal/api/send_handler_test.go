@@ -0,0 +1,378 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/smtp"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestValidateOutgoingEmail(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Minute)
+	testCases := []struct {
+		name          string
+		email         models.OutgoingEmail
+		invalidFields []string
+	}{
+		{"accepts a valid message", models.OutgoingEmail{To: []string{"Alice <alice@example.com>"}}, nil},
+		{"accepts Bcc-only messages", models.OutgoingEmail{Bcc: []string{"alice@example.com"}}, nil},
+		{"requires a recipient", models.OutgoingEmail{Subject: "Hi"}, []string{"to"}},
+		{"rejects invalid addresses", models.OutgoingEmail{To: []string{"alice@example.com"}, Cc: []string{"nope"}}, []string{"cc"}},
+		{
+			"requires attachment filenames",
+			models.OutgoingEmail{To: []string{"alice@example.com"}, Attachments: []models.OutgoingAttachment{{Data: []byte("x")}}},
+			[]string{"attachments"},
+		},
+		{
+			"rejects invalid attachment content types",
+			models.OutgoingEmail{To: []string{"alice@example.com"}, Attachments: []models.OutgoingAttachment{{Filename: "a", ContentType: "not a type"}}},
+			[]string{"attachments"},
+		},
+		{
+			"rejects content IDs with angle brackets",
+			models.OutgoingEmail{To: []string{"alice@example.com"}, Attachments: []models.OutgoingAttachment{{Filename: "a.png", ContentID: "<a@example.com>"}}},
+			[]string{"attachments"},
+		},
+		{"accepts a send time in the future", models.OutgoingEmail{To: []string{"alice@example.com"}, SendAt: &future}, nil},
+		{"rejects a send time in the past", models.OutgoingEmail{To: []string{"alice@example.com"}, SendAt: &past}, []string{"send_at"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fieldErrors := validateOutgoingEmail(&tc.email)
+			if len(fieldErrors) != len(tc.invalidFields) {
+				t.Fatalf("Expected invalid fields %v, got %v", tc.invalidFields, fieldErrors)
+			}
+			for _, field := range tc.invalidFields {
+				if _, ok := fieldErrors[field]; !ok {
+					t.Errorf("Expected %s to be invalid, got %v", field, fieldErrors)
+				}
+			}
+		})
+	}
+}
+
+func TestSendHandler_SendMessage(t *testing.T) {
+	t.Setenv("VMAIL_TEST_MODE", "true")
+
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	encryptor := getTestEncryptor(t)
+	smtpServer := testutil.NewTestSMTPServer(t)
+	defer smtpServer.Close()
+
+	email := "sender@example.com"
+	userID := setupTestUserAndSettings(t, pool, encryptor, email)
+	settings, err := db.GetUserSettings(context.Background(), pool, userID)
+	if err != nil {
+		t.Fatalf("Failed to get settings: %v", err)
+	}
+	settings.SMTPServerHostname = smtpServer.Address
+	if err := db.SaveUserSettings(context.Background(), pool, settings); err != nil {
+		t.Fatalf("Failed to save settings: %v", err)
+	}
+	setUndoSendDelay(t, pool, userID, 0)
+
+	send := func(handler *SendHandler, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/messages/send", strings.NewReader(body))
+		ctx := context.WithValue(req.Context(), auth.UserEmailKey, email)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		handler.SendMessage(rr, req)
+		return rr
+	}
+
+	t.Run("sends the message and saves it to Sent", func(t *testing.T) {
+		smtpServer.ClearMessages()
+		mockIMAP := &mockIMAPService{}
+		handler := NewSendHandler(pool, smtp.NewService(pool, encryptor), mockIMAP)
+
+		rr := send(handler, `{"to": ["alice@example.com"], "subject": "Hello", "text_body": "Hi there"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		var response models.SendEmailResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response.MessageID == "" || !response.SavedToSent {
+			t.Errorf("Unexpected response: %+v", response)
+		}
+
+		messages := smtpServer.GetMessages()
+		if len(messages) != 1 {
+			t.Fatalf("Expected 1 sent message, got %d", len(messages))
+		}
+		// The SMTP username isn't an email address, so we send from the login email
+		if messages[0].From != email {
+			t.Errorf("Expected sender %s, got %s", email, messages[0].From)
+		}
+		if !strings.Contains(string(mockIMAP.appendToSentRaw), response.MessageID) {
+			t.Error("Expected the sent message to be saved to Sent")
+		}
+	})
+
+	t.Run("succeeds when saving to Sent fails", func(t *testing.T) {
+		mockIMAP := &mockIMAPService{appendToSentErr: fmt.Errorf("IMAP is down")}
+		handler := NewSendHandler(pool, smtp.NewService(pool, encryptor), mockIMAP)
+
+		rr := send(handler, `{"to": ["alice@example.com"], "subject": "Hello"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+		var response models.SendEmailResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response.SavedToSent {
+			t.Error("Expected saved_to_sent to be false")
+		}
+	})
+
+	t.Run("returns 400 for invalid messages", func(t *testing.T) {
+		handler := NewSendHandler(pool, smtp.NewService(pool, encryptor), &mockIMAPService{})
+
+		rr := send(handler, `{"to": [], "subject": "Hello"}`)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status 400, got %d", rr.Code)
+		}
+		var response models.ValidationErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if _, ok := response.Fields["to"]; !ok {
+			t.Errorf("Expected to to be invalid, got %v", response.Fields)
+		}
+	})
+
+	t.Run("sends as the chosen identity", func(t *testing.T) {
+		smtpServer.ClearMessages()
+		identity := &models.SendIdentity{Name: "Support", Email: "support@example.com", Signature: "The support team"}
+		if err := db.CreateSendIdentity(context.Background(), pool, userID, identity); err != nil {
+			t.Fatalf("Failed to create identity: %v", err)
+		}
+		handler := NewSendHandler(pool, smtp.NewService(pool, encryptor), &mockIMAPService{})
+
+		rr := send(handler, `{"to": ["alice@example.com"], "text_body": "Hi", "identity_id": "`+identity.ID+`"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		messages := smtpServer.GetMessages()
+		if len(messages) != 1 {
+			t.Fatalf("Expected 1 sent message, got %d", len(messages))
+		}
+		if messages[0].From != "support@example.com" {
+			t.Errorf("Expected sender support@example.com, got %s", messages[0].From)
+		}
+		if !strings.Contains(string(messages[0].Data), "The support team") {
+			t.Error("Expected the message to have the identity's signature")
+		}
+	})
+
+	t.Run("returns 400 for unknown identities", func(t *testing.T) {
+		handler := NewSendHandler(pool, smtp.NewService(pool, encryptor), &mockIMAPService{})
+
+		for _, identityID := range []string{"not-a-uuid", "00000000-0000-0000-0000-000000000000"} {
+			rr := send(handler, `{"to": ["alice@example.com"], "identity_id": "`+identityID+`"}`)
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status 400 for %s, got %d", identityID, rr.Code)
+			}
+			var response models.ValidationErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if _, ok := response.Fields["identity_id"]; !ok {
+				t.Errorf("Expected identity_id to be invalid, got %v", response.Fields)
+			}
+		}
+	})
+
+	t.Run("returns 502 when the SMTP server fails", func(t *testing.T) {
+		otherEmail := "unreachable-smtp@example.com"
+		otherUserID := setupTestUserAndSettings(t, pool, encryptor, otherEmail)
+		otherSettings, err := db.GetUserSettings(context.Background(), pool, otherUserID)
+		if err != nil {
+			t.Fatalf("Failed to get settings: %v", err)
+		}
+		otherSettings.SMTPServerHostname = "127.0.0.1:1"
+		if err := db.SaveUserSettings(context.Background(), pool, otherSettings); err != nil {
+			t.Fatalf("Failed to save settings: %v", err)
+		}
+		setUndoSendDelay(t, pool, otherUserID, 0)
+
+		mockIMAP := &mockIMAPService{}
+		handler := NewSendHandler(pool, smtp.NewService(pool, encryptor), mockIMAP)
+		req := httptest.NewRequest("POST", "/api/v1/messages/send", strings.NewReader(`{"to": ["alice@example.com"]}`))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, otherEmail))
+		rr := httptest.NewRecorder()
+		handler.SendMessage(rr, req)
+
+		if rr.Code != http.StatusBadGateway {
+			t.Errorf("Expected status 502, got %d", rr.Code)
+		}
+		if mockIMAP.appendToSentRaw != nil {
+			t.Error("Expected no copy in Sent when sending fails")
+		}
+	})
+}
+
+func TestSendHandler_UndoSend(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	encryptor := getTestEncryptor(t)
+	email := "undo-sender@example.com"
+	userID := setupTestUserAndSettings(t, pool, encryptor, email)
+	setUndoSendDelay(t, pool, userID, 20)
+
+	handler := NewSendHandler(pool, smtp.NewService(pool, encryptor), &mockIMAPService{})
+
+	queue := func(t *testing.T) models.QueuedEmailResponse {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/v1/messages/send", strings.NewReader(`{"to": ["alice@example.com"], "subject": "Hello"}`))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		handler.SendMessage(rr, req)
+
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("Expected status 202, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var response models.QueuedEmailResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		return response
+	}
+
+	cancel := func(id, userEmail string) int {
+		req := createRequestWithUser("POST", "/api/v1/messages/"+id+"/cancel", userEmail)
+		rr := httptest.NewRecorder()
+		handler.CancelMessage(rr, req)
+		return rr.Code
+	}
+
+	t.Run("queues the message for the undo send delay", func(t *testing.T) {
+		before := time.Now()
+		response := queue(t)
+
+		if response.ID == "" {
+			t.Error("Expected an outbox ID")
+		}
+		if response.SendAt.Before(before.Add(19*time.Second)) || response.SendAt.After(time.Now().Add(21*time.Second)) {
+			t.Errorf("Expected the message to be sent in 20 seconds, got %v", response.SendAt)
+		}
+	})
+
+	t.Run("cancels a queued message once", func(t *testing.T) {
+		response := queue(t)
+
+		if code := cancel(response.ID, email); code != http.StatusNoContent {
+			t.Errorf("Expected status 204, got %d", code)
+		}
+		if code := cancel(response.ID, email); code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for a cancelled message, got %d", code)
+		}
+	})
+
+	t.Run("deletes the queued message's uploads", func(t *testing.T) {
+		upload := &models.AttachmentUpload{Filename: "notes.txt", ContentType: "text/plain", SizeBytes: 5, Data: []byte("hello")}
+		if err := db.CreateAttachmentUpload(context.Background(), pool, userID, upload, 0); err != nil {
+			t.Fatalf("CreateAttachmentUpload failed: %v", err)
+		}
+
+		body := `{"to": ["alice@example.com"], "subject": "Notes", "attachments": [{"upload_id": "` + upload.ID + `"}]}`
+		req := httptest.NewRequest("POST", "/api/v1/messages/send", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		handler.SendMessage(rr, req)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("Expected status 202, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		uploads, err := db.GetAttachmentUploads(context.Background(), pool, userID, []string{upload.ID})
+		if err != nil {
+			t.Fatalf("GetAttachmentUploads failed: %v", err)
+		}
+		if len(uploads) != 0 {
+			t.Error("Expected the upload to be deleted once the message is queued")
+		}
+	})
+
+	t.Run("doesn't cancel other users' messages", func(t *testing.T) {
+		response := queue(t)
+		otherEmail := "undo-other@example.com"
+		setupTestUserAndSettings(t, pool, encryptor, otherEmail)
+
+		if code := cancel(response.ID, otherEmail); code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", code)
+		}
+	})
+
+	t.Run("schedules messages for later and lists them", func(t *testing.T) {
+		sendAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+		body := fmt.Sprintf(`{"to": ["alice@example.com"], "subject": "Later", "send_at": %q}`, sendAt.Format(time.RFC3339))
+		req := httptest.NewRequest("POST", "/api/v1/messages/send", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		handler.SendMessage(rr, req)
+
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("Expected status 202, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var queued models.QueuedEmailResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &queued); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if !queued.SendAt.Equal(sendAt) {
+			t.Errorf("Expected the message to be sent at %v, got %v", sendAt, queued.SendAt)
+		}
+
+		rr = httptest.NewRecorder()
+		handler.GetScheduledMessages(rr, createRequestWithUser("GET", "/api/v1/messages/scheduled", email))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+		var response models.ScheduledEmailsResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		last := response.Messages[len(response.Messages)-1]
+		if last.ID != queued.ID || last.Subject != "Later" || !last.SendAt.Equal(sendAt) {
+			t.Errorf("Expected the scheduled message last, got %+v", last)
+		}
+	})
+
+	t.Run("returns 400 for invalid IDs", func(t *testing.T) {
+		if code := cancel("not-a-uuid", email); code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", code)
+		}
+	})
+}
+
+// setUndoSendDelay saves the user's undo send delay, keeping the other preferences at their defaults.
+func setUndoSendDelay(t *testing.T, pool *pgxpool.Pool, userID string, seconds int) {
+	t.Helper()
+	prefs, err := db.GetUserPreferences(context.Background(), pool, userID)
+	if err != nil {
+		t.Fatalf("Failed to get preferences: %v", err)
+	}
+	prefs.UndoSendDelaySeconds = seconds
+	if err := db.SaveUserPreferences(context.Background(), pool, prefs); err != nil {
+		t.Fatalf("Failed to save preferences: %v", err)
+	}
+}
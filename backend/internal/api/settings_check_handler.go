@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/smtp"
+)
+
+// settingsCheckTimeout is how long checking the IMAP server can take.
+const settingsCheckTimeout = 30 * time.Second
+
+// noSpecialUseWarning is the warning for IMAP servers whose folders have no SPECIAL-USE attributes.
+const noSpecialUseWarning = "The server doesn't mark folders like Sent and Trash (SPECIAL-USE), so we guess them by their names."
+
+// TestSettings checks that the IMAP and SMTP servers in the request accept the credentials, without saving anything,
+// so that users can fix their settings before saving them. The request is like for PostSettings.
+// Empty passwords use the saved ones, but only for the saved server and username, so that a typo in a hostname
+// can't send a saved password to another server. It responds with a check of each server, even if they failed.
+// The path is /api/v1/settings/test.
+func (h *SettingsHandler) TestSettings(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	var req models.UserSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.InfoContext(ctx, "SettingsHandler: Failed to decode test request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	if err := h.validateSettingsRequest(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	existingSettings, err := db.GetUserSettings(ctx, h.pool, userID)
+	if err != nil && !errors.Is(err, db.ErrUserSettingsNotFound) {
+		slog.ErrorContext(ctx, "SettingsHandler: Failed to get existing settings", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var savedIMAPPassword, savedSMTPPassword []byte
+	if existingSettings != nil {
+		if req.IMAPServerHostname == existingSettings.IMAPServerHostname && req.IMAPUsername == existingSettings.IMAPUsername {
+			savedIMAPPassword = existingSettings.EncryptedIMAPPassword
+		}
+		if req.SMTPServerHostname == existingSettings.SMTPServerHostname && req.SMTPUsername == existingSettings.SMTPUsername {
+			savedSMTPPassword = existingSettings.EncryptedSMTPPassword
+		}
+	}
+	imapPassword, err := h.passwordToTest(req.IMAPPassword, savedIMAPPassword)
+	if err != nil {
+		slog.ErrorContext(ctx, "SettingsHandler: Failed to decrypt IMAP password", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	smtpPassword, err := h.passwordToTest(req.SMTPPassword, savedSMTPPassword)
+	if err != nil {
+		slog.ErrorContext(ctx, "SettingsHandler: Failed to decrypt SMTP password", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if imapPassword == "" {
+		http.Error(w, "IMAP password is required to test a new server or username", http.StatusBadRequest)
+		return
+	}
+	if smtpPassword == "" {
+		http.Error(w, "SMTP password is required to test a new server or username", http.StatusBadRequest)
+		return
+	}
+
+	imapServer := imap.ServerFromSettings(&models.UserSettings{
+		IMAPServerHostname: req.IMAPServerHostname,
+		IMAPPort:           req.IMAPPort,
+		IMAPSecurity:       req.IMAPSecurity,
+		IMAPSkipTLSVerify:  req.IMAPSkipTLSVerify,
+	})
+
+	var response models.SettingsTestResponse
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		imapCtx, cancel := context.WithTimeout(ctx, settingsCheckTimeout)
+		defer cancel()
+		specialUse, err := imap.CheckLogin(imapCtx, imapServer, req.IMAPUsername, imapPassword)
+		response.IMAP = connectionCheck(err, imap.ErrAuthFailed)
+		if err == nil && !specialUse {
+			response.IMAP.Warnings = append(response.IMAP.Warnings, noSpecialUseWarning)
+		}
+	})
+	wg.Go(func() {
+		err := smtp.CheckLogin(req.SMTPServerHostname, req.SMTPUsername, smtpPassword)
+		response.SMTP = connectionCheck(err, smtp.ErrAuthFailed)
+	})
+	wg.Wait()
+
+	if !WriteJSONResponse(w, response) {
+		return
+	}
+}
+
+// passwordToTest returns password, or if it's empty, the saved one decrypted. saved can be nil.
+func (h *SettingsHandler) passwordToTest(password string, saved []byte) (string, error) {
+	if password != "" || len(saved) == 0 {
+		return password, nil
+	}
+	return h.encryptor.Decrypt(saved)
+}
+
+// connectionCheck converts the error of checking a server to a models.ConnectionCheck.
+// errAuthFailed is what the protocol's login errors wrap.
+func connectionCheck(err, errAuthFailed error) models.ConnectionCheck {
+	if err == nil {
+		return models.ConnectionCheck{OK: true}
+	}
+	return models.ConnectionCheck{Problem: connectionProblem(err, errAuthFailed), Error: err.Error()}
+}
+
+// connectionProblem tells at which step of connecting err happened, as a models.ConnectionProblem constant.
+func connectionProblem(err, errAuthFailed error) string {
+	var (
+		dnsErr         *net.DNSError
+		certErr        *tls.CertificateVerificationError
+		hostnameErr    x509.HostnameError
+		authorityErr   x509.UnknownAuthorityError
+		invalidCertErr x509.CertificateInvalidError
+		recordErr      tls.RecordHeaderError
+		alertErr       tls.AlertError
+		netErr         net.Error
+	)
+	switch {
+	case errors.Is(err, errAuthFailed):
+		return models.ConnectionProblemAuth
+	case errors.As(err, &dnsErr):
+		return models.ConnectionProblemDNS
+	case errors.As(err, &certErr), errors.As(err, &hostnameErr), errors.As(err, &authorityErr),
+		errors.As(err, &invalidCertErr), errors.As(err, &recordErr), errors.As(err, &alertErr):
+		return models.ConnectionProblemTLS
+	case errors.As(err, &netErr):
+		// Refused connections and timeouts
+		return models.ConnectionProblemConnect
+	default:
+		return models.ConnectionProblemServer
+	}
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+func TestConnectionProblem(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"auth", fmt.Errorf("%w: bad password", imap.ErrAuthFailed), models.ConnectionProblemAuth},
+		{"dns", fmt.Errorf("failed to dial: %w", &net.DNSError{Err: "no such host", Name: "imap.example.invalid", IsNotFound: true}), models.ConnectionProblemDNS},
+		{"tls", fmt.Errorf("failed to dial: %w", x509.UnknownAuthorityError{}), models.ConnectionProblemTLS},
+		{"connect", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, models.ConnectionProblemConnect},
+		{"server", errors.New("LIST failed"), models.ConnectionProblemServer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := connectionProblem(tt.err, imap.ErrAuthFailed); got != tt.want {
+				t.Errorf("connectionProblem() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("connectionCheck is OK without an error", func(t *testing.T) {
+		if check := connectionCheck(nil, imap.ErrAuthFailed); !check.OK || check.Problem != "" {
+			t.Errorf("Unexpected check: %+v", check)
+		}
+	})
+}
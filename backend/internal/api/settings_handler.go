@@ -1,28 +1,40 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/vdavid/vmail/backend/internal/crypto"
 	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imap"
 	"github.com/vdavid/vmail/backend/internal/models"
 )
 
+// confirmIdentityChangeParam is the query parameter that confirms clearing the mail cache
+// when the user switches to a different IMAP account.
+const confirmIdentityChangeParam = "confirm_identity_change"
+
 // SettingsHandler handles user settings-related API requests.
 type SettingsHandler struct {
 	pool      *pgxpool.Pool
 	encryptor *crypto.Encryptor
+	imapPool  imap.IMAPPool
 }
 
 // NewSettingsHandler creates a new SettingsHandler instance.
-func NewSettingsHandler(pool *pgxpool.Pool, encryptor *crypto.Encryptor) *SettingsHandler {
+func NewSettingsHandler(pool *pgxpool.Pool, encryptor *crypto.Encryptor, imapPool imap.IMAPPool) *SettingsHandler {
 	return &SettingsHandler{
 		pool:      pool,
 		encryptor: encryptor,
+		imapPool:  imapPool,
 	}
 }
 
@@ -41,28 +53,34 @@ func (h *SettingsHandler) GetSettings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err != nil {
-		log.Printf("SettingsHandler: Failed to get settings: %v", err)
+		slog.ErrorContext(ctx, "SettingsHandler: Failed to get settings", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	response := models.UserSettingsResponse{
-		UndoSendDelaySeconds:     settings.UndoSendDelaySeconds,
-		PaginationThreadsPerPage: settings.PaginationThreadsPerPage,
-		IMAPServerHostname:       settings.IMAPServerHostname,
-		IMAPUsername:             settings.IMAPUsername,
-		IMAPPasswordSet:          len(settings.EncryptedIMAPPassword) > 0,
-		SMTPServerHostname:       settings.SMTPServerHostname,
-		SMTPUsername:             settings.SMTPUsername,
-		SMTPPasswordSet:          len(settings.EncryptedSMTPPassword) > 0,
+	if !WriteJSONResponse(w, buildSettingsResponse(settings)) {
+		return
 	}
+}
 
-	if !WriteJSONResponse(w, response) {
-		return
+// buildSettingsResponse converts stored settings to the API response, leaving out the passwords.
+func buildSettingsResponse(settings *models.UserSettings) models.UserSettingsResponse {
+	return models.UserSettingsResponse{
+		IMAPServerHostname: settings.IMAPServerHostname,
+		IMAPPort:           settings.IMAPPort,
+		IMAPSecurity:       settings.IMAPSecurity,
+		IMAPSkipTLSVerify:  settings.IMAPSkipTLSVerify,
+		IMAPUsername:       settings.IMAPUsername,
+		IMAPPasswordSet:    len(settings.EncryptedIMAPPassword) > 0,
+		SMTPServerHostname: settings.SMTPServerHostname,
+		SMTPUsername:       settings.SMTPUsername,
+		SMTPPasswordSet:    len(settings.EncryptedSMTPPassword) > 0,
+		OAuthProvider:      settings.OAuthProvider,
 	}
 }
 
 // PostSettings saves or updates the user settings for the current user.
+// Changing the IMAP server or username needs confirmation, see clearCacheOnIdentityChange.
 func (h *SettingsHandler) PostSettings(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -73,13 +91,13 @@ func (h *SettingsHandler) PostSettings(w http.ResponseWriter, r *http.Request) {
 
 	var req models.UserSettingsRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("SettingsHandler: Failed to decode request: %v", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		slog.InfoContext(ctx, "SettingsHandler: Failed to decode request", "error", err)
+		writeInvalidBodyError(w, err)
 		return
 	}
 
 	if err := h.validateSettingsRequest(&req); err != nil {
-		log.Printf("SettingsHandler: Validation failed: %v", err)
+		slog.InfoContext(ctx, "SettingsHandler: Validation failed", "error", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -91,7 +109,7 @@ func (h *SettingsHandler) PostSettings(w http.ResponseWriter, r *http.Request) {
 	var encryptedSMTPPassword []byte
 
 	if err != nil && !errors.Is(err, db.ErrUserSettingsNotFound) {
-		log.Printf("SettingsHandler: Failed to get existing settings: %v", err)
+		slog.ErrorContext(ctx, "SettingsHandler: Failed to get existing settings", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
@@ -110,7 +128,7 @@ func (h *SettingsHandler) PostSettings(w http.ResponseWriter, r *http.Request) {
 		var err error
 		encryptedIMAPPassword, err = h.encryptor.Encrypt(req.IMAPPassword)
 		if err != nil {
-			log.Printf("SettingsHandler: Failed to encrypt IMAP password: %v", err)
+			slog.ErrorContext(ctx, "SettingsHandler: Failed to encrypt IMAP password", "error", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
@@ -130,35 +148,51 @@ func (h *SettingsHandler) PostSettings(w http.ResponseWriter, r *http.Request) {
 		var err error
 		encryptedSMTPPassword, err = h.encryptor.Encrypt(req.SMTPPassword)
 		if err != nil {
-			log.Printf("SettingsHandler: Failed to encrypt SMTP password: %v", err)
+			slog.ErrorContext(ctx, "SettingsHandler: Failed to encrypt SMTP password", "error", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
 	}
 
 	settings := &models.UserSettings{
-		UserID:                   userID,
-		UndoSendDelaySeconds:     req.UndoSendDelaySeconds,
-		PaginationThreadsPerPage: req.PaginationThreadsPerPage,
-		IMAPServerHostname:       req.IMAPServerHostname,
-		IMAPUsername:             req.IMAPUsername,
-		EncryptedIMAPPassword:    encryptedIMAPPassword,
-		SMTPServerHostname:       req.SMTPServerHostname,
-		SMTPUsername:             req.SMTPUsername,
-		EncryptedSMTPPassword:    encryptedSMTPPassword,
+		UserID:                userID,
+		IMAPServerHostname:    req.IMAPServerHostname,
+		IMAPPort:              req.IMAPPort,
+		IMAPSecurity:          req.IMAPSecurity,
+		IMAPSkipTLSVerify:     req.IMAPSkipTLSVerify,
+		IMAPUsername:          req.IMAPUsername,
+		EncryptedIMAPPassword: encryptedIMAPPassword,
+		SMTPServerHostname:    req.SMTPServerHostname,
+		SMTPUsername:          req.SMTPUsername,
+		EncryptedSMTPPassword: encryptedSMTPPassword,
+	}
+	// Keep OAuth going unless the user switched to an IMAP password
+	if req.IMAPPassword == "" && existingSettings != nil {
+		settings.OAuthProvider = existingSettings.OAuthProvider
+		settings.EncryptedOAuthRefreshToken = existingSettings.EncryptedOAuthRefreshToken
+		settings.OAuthTokenExpiresAt = existingSettings.OAuthTokenExpiresAt
+	}
+
+	identityChanged, ok := h.clearCacheOnIdentityChange(w, r, userID, existingSettings, settings)
+	if !ok {
+		return
 	}
 
 	if err := db.SaveUserSettings(ctx, h.pool, settings); err != nil {
-		log.Printf("SettingsHandler: Failed to save settings: %v", err)
+		slog.ErrorContext(ctx, "SettingsHandler: Failed to save settings", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
+	if identityChanged || (existingSettings != nil && imapConnectionChanged(existingSettings, settings)) {
+		// Pooled connections are still logged in to the old account, or connected the old way
+		h.imapPool.RemoveClient(userID)
+	}
+
 	successResponse := struct {
 		Success bool `json:"success"`
 	}{Success: true}
 
-	w.WriteHeader(http.StatusOK)
 	if !WriteJSONResponse(w, successResponse) {
 		return
 	}
@@ -174,6 +208,9 @@ func (h *SettingsHandler) validateSettingsRequest(req *models.UserSettingsReques
 	if req.IMAPUsername == "" {
 		return errors.New("IMAP username is required")
 	}
+	if field, message := validateIMAPConnection(req.IMAPServerHostname, req.IMAPPort, req.IMAPSecurity, req.IMAPSkipTLSVerify); field != "" {
+		return fmt.Errorf("%s %s", field, message)
+	}
 	// Password validation removed - passwords are optional on update
 	if req.SMTPServerHostname == "" {
 		return errors.New("SMTP server hostname is required")
@@ -184,3 +221,249 @@ func (h *SettingsHandler) validateSettingsRequest(req *models.UserSettingsReques
 	// Password validation removed - passwords are optional on update
 	return nil
 }
+
+// PatchSettings updates only the fields present in the request body.
+// Omitted fields are left untouched, and explicit nulls clear the field.
+// Responds with the updated settings, or with per-field errors if any field is invalid.
+func (h *SettingsHandler) PatchSettings(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	// Decode into raw fields so that we can tell omitted fields from explicit nulls
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch == nil {
+		slog.InfoContext(ctx, "SettingsHandler: Failed to decode patch request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	settings, err := db.GetUserSettings(ctx, h.pool, userID)
+	if errors.Is(err, db.ErrUserSettingsNotFound) {
+		http.Error(w, "Settings not found for this user", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "SettingsHandler: Failed to get existing settings", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	previousSettings := *settings
+	fieldErrors, err := h.applySettingsPatch(settings, patch)
+	if err != nil {
+		slog.ErrorContext(ctx, "SettingsHandler: Failed to apply patch", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(fieldErrors) > 0 {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid settings",
+			Fields: fieldErrors,
+		})
+		return
+	}
+
+	identityChanged, ok := h.clearCacheOnIdentityChange(w, r, userID, &previousSettings, settings)
+	if !ok {
+		return
+	}
+
+	if err := db.SaveUserSettings(ctx, h.pool, settings); err != nil {
+		slog.ErrorContext(ctx, "SettingsHandler: Failed to save settings", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if identityChanged || imapConnectionChanged(&previousSettings, settings) {
+		// Pooled connections are still logged in to the old account, or connected the old way
+		h.imapPool.RemoveClient(userID)
+	}
+
+	if !WriteJSONResponse(w, buildSettingsResponse(settings)) {
+		return
+	}
+}
+
+// clearCacheOnIdentityChange clears the user's mail cache if the new settings point to a different
+// IMAP account than the existing ones, so that we never mix two mailboxes in the cache.
+// Since this throws away the whole cache, the client must confirm it with the
+// confirm_identity_change=true query parameter. Without it, we respond with 409.
+// Returns whether the identity changed, and false as the second value if it wrote an error response.
+func (h *SettingsHandler) clearCacheOnIdentityChange(w http.ResponseWriter, r *http.Request, userID string, existing, updated *models.UserSettings) (bool, bool) {
+	if existing == nil || !imapIdentityChanged(existing, updated) {
+		return false, true
+	}
+
+	if r.URL.Query().Get(confirmIdentityChangeParam) != "true" {
+		http.Error(w, "Changing the IMAP server or username clears the cached mail of the old account. "+
+			"Repeat the request with "+confirmIdentityChangeParam+"=true to confirm.", http.StatusConflict)
+		return false, false
+	}
+
+	if err := db.ClearMailCache(r.Context(), h.pool, userID); err != nil {
+		slog.ErrorContext(r.Context(), "SettingsHandler: Failed to clear mail cache", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false, false
+	}
+
+	slog.InfoContext(r.Context(), "SettingsHandler: Cleared mail cache after IMAP account change")
+	return true, true
+}
+
+// imapIdentityChanged tells whether two settings point to different IMAP accounts.
+// Hostnames are case-insensitive, but usernames might not be, so we compare them exactly.
+func imapIdentityChanged(a, b *models.UserSettings) bool {
+	return !strings.EqualFold(strings.TrimSpace(a.IMAPServerHostname), strings.TrimSpace(b.IMAPServerHostname)) ||
+		a.IMAPUsername != b.IMAPUsername
+}
+
+// imapConnectionChanged tells whether two settings connect to the IMAP server in different ways.
+func imapConnectionChanged(a, b *models.UserSettings) bool {
+	return a.IMAPPort != b.IMAPPort || a.IMAPSecurity != b.IMAPSecurity || a.IMAPSkipTLSVerify != b.IMAPSkipTLSVerify
+}
+
+// validateIMAPConnection checks the IMAP port and security options, also together with the hostname.
+// Returns the JSON name of the invalid field and what's wrong with it, or empty strings if they're valid.
+// Plain text is only for development, since it sends the password unencrypted.
+func validateIMAPConnection(hostname string, port int, security string, skipTLSVerify bool) (string, string) {
+	if port < 0 || port > 65535 {
+		return "imap_port", "must be between 1 and 65535"
+	}
+	if port > 0 {
+		if _, hostnamePort, err := net.SplitHostPort(strings.TrimSpace(hostname)); err == nil && hostnamePort != "" {
+			return "imap_port", "can't be set if imap_server_hostname has a port"
+		}
+	}
+	switch security {
+	case "", models.IMAPSecurityTLS, models.IMAPSecuritySTARTTLS:
+	case models.IMAPSecurityNone:
+		if os.Getenv("VMAIL_ENV") == "production" {
+			return "imap_security", "can't be none in production"
+		}
+		if skipTLSVerify {
+			return "imap_skip_tls_verify", "needs TLS or STARTTLS"
+		}
+	default:
+		return "imap_security", "must be tls, starttls, or none"
+	}
+	return "", ""
+}
+
+// applySettingsPatch applies the patch fields to the settings.
+// Returns a map from field name to error message for invalid fields.
+// The returned error is only set for internal errors, like encryption failures.
+func (h *SettingsHandler) applySettingsPatch(settings *models.UserSettings, patch map[string]json.RawMessage) (map[string]string, error) {
+	fieldErrors := make(map[string]string)
+
+	for field, raw := range patch {
+		isNull := bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+
+		switch field {
+		case "imap_server_hostname":
+			applyRequiredStringField(fieldErrors, field, raw, isNull, &settings.IMAPServerHostname)
+		case "imap_username":
+			applyRequiredStringField(fieldErrors, field, raw, isNull, &settings.IMAPUsername)
+		case "smtp_server_hostname":
+			applyRequiredStringField(fieldErrors, field, raw, isNull, &settings.SMTPServerHostname)
+		case "smtp_username":
+			applyRequiredStringField(fieldErrors, field, raw, isNull, &settings.SMTPUsername)
+
+		case "imap_port":
+			applyOptionalField(fieldErrors, field, raw, isNull, "must be a number", &settings.IMAPPort)
+		case "imap_security":
+			applyOptionalField(fieldErrors, field, raw, isNull, "must be a string", &settings.IMAPSecurity)
+		case "imap_skip_tls_verify":
+			applyOptionalField(fieldErrors, field, raw, isNull, "must be a boolean", &settings.IMAPSkipTLSVerify)
+
+		case "imap_password":
+			if err := h.applyPasswordField(fieldErrors, field, raw, isNull, &settings.EncryptedIMAPPassword); err != nil {
+				return nil, err
+			}
+			// A password replaces the OAuth token, so the refresher must not overwrite it
+			clearOAuth(settings)
+		case "smtp_password":
+			if err := h.applyPasswordField(fieldErrors, field, raw, isNull, &settings.EncryptedSMTPPassword); err != nil {
+				return nil, err
+			}
+
+		default:
+			fieldErrors[field] = "unknown field"
+		}
+	}
+
+	if len(fieldErrors) == 0 {
+		// The options depend on each other and on the hostname, so we check them after applying all fields
+		field, message := validateIMAPConnection(settings.IMAPServerHostname, settings.IMAPPort, settings.IMAPSecurity, settings.IMAPSkipTLSVerify)
+		if field != "" {
+			fieldErrors[field] = message
+		}
+	}
+
+	return fieldErrors, nil
+}
+
+// clearOAuth disconnects the settings from their OAuth provider.
+// If the SMTP password is still an access token, it stops working when the token expires.
+func clearOAuth(settings *models.UserSettings) {
+	settings.OAuthProvider = ""
+	settings.EncryptedOAuthRefreshToken = nil
+	settings.OAuthTokenExpiresAt = nil
+}
+
+// applyRequiredStringField sets a string setting that can't be empty, so it can't be cleared either.
+func applyRequiredStringField(fieldErrors map[string]string, field string, raw json.RawMessage, isNull bool, target *string) {
+	if isNull {
+		fieldErrors[field] = "is required and can't be cleared"
+		return
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		fieldErrors[field] = "must be a string"
+		return
+	}
+	if strings.TrimSpace(value) == "" {
+		fieldErrors[field] = "must not be empty"
+		return
+	}
+	*target = value
+}
+
+// applyOptionalField sets a setting that has a default, or resets it to the zero value for null.
+func applyOptionalField[T any](fieldErrors map[string]string, field string, raw json.RawMessage, isNull bool, typeError string, target *T) {
+	var value T
+	if !isNull {
+		if err := json.Unmarshal(raw, &value); err != nil {
+			fieldErrors[field] = typeError
+			return
+		}
+	}
+	*target = value
+}
+
+// applyPasswordField encrypts and sets a password, or clears it for null.
+// Empty strings are rejected so that a blank form field can't clear a password by accident.
+func (h *SettingsHandler) applyPasswordField(fieldErrors map[string]string, field string, raw json.RawMessage, isNull bool, target *[]byte) error {
+	if isNull {
+		*target = []byte{}
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		fieldErrors[field] = "must be a string"
+		return nil
+	}
+	if value == "" {
+		fieldErrors[field] = "must not be empty, use null to clear it"
+		return nil
+	}
+	encrypted, err := h.encryptor.Encrypt(value)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", field, err)
+	}
+	*target = encrypted
+	return nil
+}
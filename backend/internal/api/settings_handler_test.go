@@ -10,8 +10,10 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/vdavid/vmail/backend/internal/auth"
 	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imap"
 	"github.com/vdavid/vmail/backend/internal/models"
 	"github.com/vdavid/vmail/backend/internal/testutil"
 )
@@ -21,7 +23,9 @@ func TestSettingsHandler_GetSettings(t *testing.T) {
 	defer pool.Close()
 
 	encryptor := getTestEncryptor(t)
-	handler := NewSettingsHandler(pool, encryptor)
+	imapPool := imap.NewPool()
+	defer imapPool.Close()
+	handler := NewSettingsHandler(pool, encryptor, imapPool)
 
 	t.Run("returns 404 for user without settings", func(t *testing.T) {
 		email := "new-user@example.com"
@@ -51,15 +55,13 @@ func TestSettingsHandler_GetSettings(t *testing.T) {
 		encryptedSMTPPassword, _ := encryptor.Encrypt("smtp_pass_456")
 
 		settings := &models.UserSettings{
-			UserID:                   userID,
-			UndoSendDelaySeconds:     30,
-			PaginationThreadsPerPage: 50,
-			IMAPServerHostname:       "imap.test.com",
-			IMAPUsername:             "test_user",
-			EncryptedIMAPPassword:    encryptedIMAPPassword,
-			SMTPServerHostname:       "smtp.test.com",
-			SMTPUsername:             "test_user",
-			EncryptedSMTPPassword:    encryptedSMTPPassword,
+			UserID:                userID,
+			IMAPServerHostname:    "imap.test.com",
+			IMAPUsername:          "test_user",
+			EncryptedIMAPPassword: encryptedIMAPPassword,
+			SMTPServerHostname:    "smtp.test.com",
+			SMTPUsername:          "test_user",
+			EncryptedSMTPPassword: encryptedSMTPPassword,
 		}
 		if err := db.SaveUserSettings(ctx, pool, settings); err != nil {
 			t.Fatalf("Failed to save settings: %v", err)
@@ -84,9 +86,6 @@ func TestSettingsHandler_GetSettings(t *testing.T) {
 		if response.IMAPServerHostname != "imap.test.com" {
 			t.Errorf("Expected IMAPServerHostname 'imap.test.com', got %s", response.IMAPServerHostname)
 		}
-		if response.UndoSendDelaySeconds != 30 {
-			t.Errorf("Expected UndoSendDelaySeconds 30, got %d", response.UndoSendDelaySeconds)
-		}
 		if !response.IMAPPasswordSet {
 			t.Error("Expected IMAPPasswordSet to be true")
 		}
@@ -112,20 +111,20 @@ func TestSettingsHandler_PostSettings(t *testing.T) {
 	defer pool.Close()
 
 	encryptor := getTestEncryptor(t)
-	handler := NewSettingsHandler(pool, encryptor)
+	imapPool := imap.NewPool()
+	defer imapPool.Close()
+	handler := NewSettingsHandler(pool, encryptor, imapPool)
 
 	t.Run("saves new settings successfully", func(t *testing.T) {
 		email := "new-user@example.com"
 
 		reqBody := models.UserSettingsRequest{
-			UndoSendDelaySeconds:     25,
-			PaginationThreadsPerPage: 75,
-			IMAPServerHostname:       "imap.new.com",
-			IMAPUsername:             "new-user",
-			IMAPPassword:             "imap_password_123",
-			SMTPServerHostname:       "smtp.new.com",
-			SMTPUsername:             "new-user",
-			SMTPPassword:             "smtp_password_456",
+			IMAPServerHostname: "imap.new.com",
+			IMAPUsername:       "new-user",
+			IMAPPassword:       "imap_password_123",
+			SMTPServerHostname: "smtp.new.com",
+			SMTPUsername:       "new-user",
+			SMTPPassword:       "smtp_password_456",
 		}
 
 		body, _ := json.Marshal(reqBody)
@@ -168,15 +167,13 @@ func TestSettingsHandler_PostSettings(t *testing.T) {
 		userID, _ := db.GetOrCreateUser(ctx, pool, email)
 
 		initialSettings := &models.UserSettings{
-			UserID:                   userID,
-			UndoSendDelaySeconds:     20,
-			PaginationThreadsPerPage: 100,
-			IMAPServerHostname:       "old.imap.com",
-			IMAPUsername:             "old_user",
-			EncryptedIMAPPassword:    []byte("old_encrypted"),
-			SMTPServerHostname:       "old.smtp.com",
-			SMTPUsername:             "old_user",
-			EncryptedSMTPPassword:    []byte("old_encrypted"),
+			UserID:                userID,
+			IMAPServerHostname:    "old.imap.com",
+			IMAPUsername:          "old_user",
+			EncryptedIMAPPassword: []byte("old_encrypted"),
+			SMTPServerHostname:    "old.smtp.com",
+			SMTPUsername:          "old_user",
+			EncryptedSMTPPassword: []byte("old_encrypted"),
 		}
 		err := db.SaveUserSettings(ctx, pool, initialSettings)
 		if err != nil {
@@ -184,18 +181,16 @@ func TestSettingsHandler_PostSettings(t *testing.T) {
 		}
 
 		reqBody := models.UserSettingsRequest{
-			UndoSendDelaySeconds:     40,
-			PaginationThreadsPerPage: 200,
-			IMAPServerHostname:       "new.imap.com",
-			IMAPUsername:             "new_user",
-			IMAPPassword:             "new_imap_password",
-			SMTPServerHostname:       "new.smtp.com",
-			SMTPUsername:             "new_user",
-			SMTPPassword:             "new_smtp_password",
+			IMAPServerHostname: "new.imap.com",
+			IMAPUsername:       "new_user",
+			IMAPPassword:       "new_imap_password",
+			SMTPServerHostname: "new.smtp.com",
+			SMTPUsername:       "new_user",
+			SMTPPassword:       "new_smtp_password",
 		}
 
 		body, _ := json.Marshal(reqBody)
-		req := httptest.NewRequest("POST", "/api/v1/settings", bytes.NewReader(body))
+		req := httptest.NewRequest("POST", "/api/v1/settings?confirm_identity_change=true", bytes.NewReader(body))
 		reqCtx := context.WithValue(req.Context(), auth.UserEmailKey, email)
 		req = req.WithContext(reqCtx)
 
@@ -248,15 +243,13 @@ func TestSettingsHandler_PostSettings(t *testing.T) {
 		encryptedSMTPPassword, _ := encryptor.Encrypt("original_smtp_pass")
 
 		initialSettings := &models.UserSettings{
-			UserID:                   userID,
-			UndoSendDelaySeconds:     20,
-			PaginationThreadsPerPage: 100,
-			IMAPServerHostname:       "old.imap.com",
-			IMAPUsername:             "old_user",
-			EncryptedIMAPPassword:    encryptedIMAPPassword,
-			SMTPServerHostname:       "old.smtp.com",
-			SMTPUsername:             "old_user",
-			EncryptedSMTPPassword:    encryptedSMTPPassword,
+			UserID:                userID,
+			IMAPServerHostname:    "old.imap.com",
+			IMAPUsername:          "old_user",
+			EncryptedIMAPPassword: encryptedIMAPPassword,
+			SMTPServerHostname:    "old.smtp.com",
+			SMTPUsername:          "old_user",
+			EncryptedSMTPPassword: encryptedSMTPPassword,
 		}
 		err := db.SaveUserSettings(ctx, pool, initialSettings)
 		if err != nil {
@@ -265,18 +258,16 @@ func TestSettingsHandler_PostSettings(t *testing.T) {
 
 		// Update settings without providing passwords
 		reqBody := models.UserSettingsRequest{
-			UndoSendDelaySeconds:     40,
-			PaginationThreadsPerPage: 200,
-			IMAPServerHostname:       "new.imap.com",
-			IMAPUsername:             "new_user",
-			IMAPPassword:             "", // Empty password
-			SMTPServerHostname:       "new.smtp.com",
-			SMTPUsername:             "new_user",
-			SMTPPassword:             "", // Empty password
+			IMAPServerHostname: "new.imap.com",
+			IMAPUsername:       "new_user",
+			IMAPPassword:       "", // Empty password
+			SMTPServerHostname: "new.smtp.com",
+			SMTPUsername:       "new_user",
+			SMTPPassword:       "", // Empty password
 		}
 
 		body, _ := json.Marshal(reqBody)
-		req := httptest.NewRequest("POST", "/api/v1/settings", bytes.NewReader(body))
+		req := httptest.NewRequest("POST", "/api/v1/settings?confirm_identity_change=true", bytes.NewReader(body))
 		reqCtx := context.WithValue(req.Context(), auth.UserEmailKey, email)
 		req = req.WithContext(reqCtx)
 
@@ -304,18 +295,47 @@ func TestSettingsHandler_PostSettings(t *testing.T) {
 		}
 	})
 
+	t.Run("returns 409 when switching IMAP accounts without confirmation", func(t *testing.T) {
+		email := "post-identity-unconfirmed@example.com"
+		userID := setupTestUserAndSettings(t, pool, encryptor, email)
+		seedMailCache(t, pool, userID)
+
+		reqBody := models.UserSettingsRequest{
+			IMAPServerHostname: "imap.other.com",
+			IMAPUsername:       "user",
+			SMTPServerHostname: "smtp.test.com",
+			SMTPUsername:       "user",
+		}
+
+		body, _ := json.Marshal(reqBody)
+		req := httptest.NewRequest("POST", "/api/v1/settings", bytes.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+
+		rr := httptest.NewRecorder()
+		handler.PostSettings(rr, req)
+
+		if rr.Code != http.StatusConflict {
+			t.Fatalf("Expected status 409, got %d", rr.Code)
+		}
+		saved, _ := db.GetUserSettings(context.Background(), pool, userID)
+		if saved.IMAPServerHostname != "imap.test.com" {
+			t.Errorf("Expected IMAPServerHostname to be unchanged, got %s", saved.IMAPServerHostname)
+		}
+		if countCachedThreads(t, pool, userID) == 0 {
+			t.Error("Expected the cache to be kept")
+		}
+	})
+
 	t.Run("returns 400 when passwords are empty for new user", func(t *testing.T) {
 		email := "newuser@example.com"
 
 		reqBody := models.UserSettingsRequest{
-			UndoSendDelaySeconds:     25,
-			PaginationThreadsPerPage: 75,
-			IMAPServerHostname:       "imap.new.com",
-			IMAPUsername:             "new-user",
-			IMAPPassword:             "", // Empty password for new user
-			SMTPServerHostname:       "smtp.new.com",
-			SMTPUsername:             "new-user",
-			SMTPPassword:             "", // Empty password for new user
+			IMAPServerHostname: "imap.new.com",
+			IMAPUsername:       "new-user",
+			IMAPPassword:       "", // Empty password for new user
+			SMTPServerHostname: "smtp.new.com",
+			SMTPUsername:       "new-user",
+			SMTPPassword:       "", // Empty password for new user
 		}
 
 		body, _ := json.Marshal(reqBody)
@@ -339,14 +359,12 @@ func TestSettingsHandler_PostSettings(t *testing.T) {
 		cancel()
 
 		reqBody := models.UserSettingsRequest{
-			UndoSendDelaySeconds:     25,
-			PaginationThreadsPerPage: 75,
-			IMAPServerHostname:       "imap.new.com",
-			IMAPUsername:             "new-user",
-			IMAPPassword:             "imap_password_123",
-			SMTPServerHostname:       "smtp.new.com",
-			SMTPUsername:             "new-user",
-			SMTPPassword:             "smtp_password_456",
+			IMAPServerHostname: "imap.new.com",
+			IMAPUsername:       "new-user",
+			IMAPPassword:       "imap_password_123",
+			SMTPServerHostname: "smtp.new.com",
+			SMTPUsername:       "new-user",
+			SMTPPassword:       "smtp_password_456",
 		}
 
 		body, _ := json.Marshal(reqBody)
@@ -391,14 +409,12 @@ func TestSettingsHandler_PostSettings(t *testing.T) {
 		email := "validation-test@example.com"
 
 		reqBody := models.UserSettingsRequest{
-			UndoSendDelaySeconds:     25,
-			PaginationThreadsPerPage: 75,
-			IMAPServerHostname:       "", // Missing
-			IMAPUsername:             "user",
-			IMAPPassword:             "password",
-			SMTPServerHostname:       "smtp.test.com",
-			SMTPUsername:             "user",
-			SMTPPassword:             "password",
+			IMAPServerHostname: "", // Missing
+			IMAPUsername:       "user",
+			IMAPPassword:       "password",
+			SMTPServerHostname: "smtp.test.com",
+			SMTPUsername:       "user",
+			SMTPPassword:       "password",
 		}
 
 		body, _ := json.Marshal(reqBody)
@@ -423,14 +439,12 @@ func TestSettingsHandler_PostSettings(t *testing.T) {
 		email := "validation-test2@example.com"
 
 		reqBody := models.UserSettingsRequest{
-			UndoSendDelaySeconds:     25,
-			PaginationThreadsPerPage: 75,
-			IMAPServerHostname:       "imap.test.com",
-			IMAPUsername:             "", // Missing
-			IMAPPassword:             "password",
-			SMTPServerHostname:       "smtp.test.com",
-			SMTPUsername:             "user",
-			SMTPPassword:             "password",
+			IMAPServerHostname: "imap.test.com",
+			IMAPUsername:       "", // Missing
+			IMAPPassword:       "password",
+			SMTPServerHostname: "smtp.test.com",
+			SMTPUsername:       "user",
+			SMTPPassword:       "password",
 		}
 
 		body, _ := json.Marshal(reqBody)
@@ -455,14 +469,12 @@ func TestSettingsHandler_PostSettings(t *testing.T) {
 		email := "validation-test3@example.com"
 
 		reqBody := models.UserSettingsRequest{
-			UndoSendDelaySeconds:     25,
-			PaginationThreadsPerPage: 75,
-			IMAPServerHostname:       "imap.test.com",
-			IMAPUsername:             "user",
-			IMAPPassword:             "password",
-			SMTPServerHostname:       "", // Missing
-			SMTPUsername:             "user",
-			SMTPPassword:             "password",
+			IMAPServerHostname: "imap.test.com",
+			IMAPUsername:       "user",
+			IMAPPassword:       "password",
+			SMTPServerHostname: "", // Missing
+			SMTPUsername:       "user",
+			SMTPPassword:       "password",
 		}
 
 		body, _ := json.Marshal(reqBody)
@@ -487,14 +499,12 @@ func TestSettingsHandler_PostSettings(t *testing.T) {
 		email := "validation-test4@example.com"
 
 		reqBody := models.UserSettingsRequest{
-			UndoSendDelaySeconds:     25,
-			PaginationThreadsPerPage: 75,
-			IMAPServerHostname:       "imap.test.com",
-			IMAPUsername:             "user",
-			IMAPPassword:             "password",
-			SMTPServerHostname:       "smtp.test.com",
-			SMTPUsername:             "", // Missing
-			SMTPPassword:             "password",
+			IMAPServerHostname: "imap.test.com",
+			IMAPUsername:       "user",
+			IMAPPassword:       "password",
+			SMTPServerHostname: "smtp.test.com",
+			SMTPUsername:       "", // Missing
+			SMTPPassword:       "password",
 		}
 
 		body, _ := json.Marshal(reqBody)
@@ -516,7 +526,335 @@ func TestSettingsHandler_PostSettings(t *testing.T) {
 	})
 }
 
-// failingResponseWriter is a ResponseWriter that fails on Write to test error handling.
+func TestSettingsHandler_PatchSettings(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	encryptor := getTestEncryptor(t)
+	imapPool := imap.NewPool()
+	defer imapPool.Close()
+	handler := NewSettingsHandler(pool, encryptor, imapPool)
+
+	patch := func(email, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", "/api/v1/settings", strings.NewReader(body))
+		ctx := context.WithValue(req.Context(), auth.UserEmailKey, email)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		handler.PatchSettings(rr, req)
+		return rr
+	}
+
+	t.Run("updates only the given fields", func(t *testing.T) {
+		email := "patch-partial@example.com"
+		userID := setupTestUserAndSettings(t, pool, encryptor, email)
+
+		rr := patch(email, `{"smtp_username": "patched-user", "smtp_server_hostname": "smtp.patched.com"}`)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		var response models.UserSettingsResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response.SMTPUsername != "patched-user" {
+			t.Errorf("Expected SMTPUsername 'patched-user', got %s", response.SMTPUsername)
+		}
+
+		saved, err := db.GetUserSettings(context.Background(), pool, userID)
+		if err != nil {
+			t.Fatalf("Failed to get saved settings: %v", err)
+		}
+		if saved.SMTPServerHostname != "smtp.patched.com" {
+			t.Errorf("Expected SMTPServerHostname 'smtp.patched.com', got %s", saved.SMTPServerHostname)
+		}
+		if saved.IMAPServerHostname != "imap.test.com" {
+			t.Errorf("Expected IMAPServerHostname to stay 'imap.test.com', got %s", saved.IMAPServerHostname)
+		}
+		if saved.IMAPUsername != "user" {
+			t.Errorf("Expected IMAPUsername to stay 'user', got %s", saved.IMAPUsername)
+		}
+		decryptedIMAPPassword, _ := encryptor.Decrypt(saved.EncryptedIMAPPassword)
+		if decryptedIMAPPassword != "imap_pass" {
+			t.Error("Expected IMAP password to be preserved")
+		}
+	})
+
+	t.Run("explicit nulls clear passwords", func(t *testing.T) {
+		email := "patch-null@example.com"
+		userID := setupTestUserAndSettings(t, pool, encryptor, email)
+
+		rr := patch(email, `{"smtp_password": null}`)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		saved, err := db.GetUserSettings(context.Background(), pool, userID)
+		if err != nil {
+			t.Fatalf("Failed to get saved settings: %v", err)
+		}
+		if len(saved.EncryptedSMTPPassword) != 0 {
+			t.Error("Expected SMTP password to be cleared")
+		}
+		if len(saved.EncryptedIMAPPassword) == 0 {
+			t.Error("Expected IMAP password to be preserved")
+		}
+	})
+
+	t.Run("sets a new password", func(t *testing.T) {
+		email := "patch-password@example.com"
+		userID := setupTestUserAndSettings(t, pool, encryptor, email)
+
+		rr := patch(email, `{"imap_password": "new_imap_pass"}`)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+		saved, _ := db.GetUserSettings(context.Background(), pool, userID)
+		decrypted, _ := encryptor.Decrypt(saved.EncryptedIMAPPassword)
+		if decrypted != "new_imap_pass" {
+			t.Errorf("Expected new IMAP password, got %q", decrypted)
+		}
+	})
+
+	t.Run("returns field errors for invalid fields", func(t *testing.T) {
+		email := "patch-invalid@example.com"
+		userID := setupTestUserAndSettings(t, pool, encryptor, email)
+
+		rr := patch(email, `{
+			"imap_server_hostname": null,
+			"smtp_username": "",
+			"smtp_server_hostname": 42,
+			"imap_password": "",
+			"pagination_threads_per_page": 50
+		}`)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status 400, got %d", rr.Code)
+		}
+
+		var response models.ValidationErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		// Preferences have their own endpoint, so they're unknown fields here
+		for _, field := range []string{"imap_server_hostname", "smtp_username", "smtp_server_hostname", "imap_password", "pagination_threads_per_page"} {
+			if response.Fields[field] == "" {
+				t.Errorf("Expected an error for field %s", field)
+			}
+		}
+
+		// Nothing should be saved if any field is invalid
+		saved, _ := db.GetUserSettings(context.Background(), pool, userID)
+		if saved.IMAPServerHostname != "imap.test.com" {
+			t.Errorf("Expected IMAPServerHostname to be unchanged, got %s", saved.IMAPServerHostname)
+		}
+	})
+
+	t.Run("returns 409 when switching IMAP accounts without confirmation", func(t *testing.T) {
+		email := "patch-identity-unconfirmed@example.com"
+		userID := setupTestUserAndSettings(t, pool, encryptor, email)
+		seedMailCache(t, pool, userID)
+
+		rr := patch(email, `{"imap_username": "someone-else"}`)
+
+		if rr.Code != http.StatusConflict {
+			t.Fatalf("Expected status 409, got %d", rr.Code)
+		}
+		saved, _ := db.GetUserSettings(context.Background(), pool, userID)
+		if saved.IMAPUsername != "user" {
+			t.Errorf("Expected IMAPUsername to be unchanged, got %s", saved.IMAPUsername)
+		}
+		if countCachedThreads(t, pool, userID) == 0 {
+			t.Error("Expected the cache to be kept")
+		}
+	})
+
+	t.Run("clears the cache when switching IMAP accounts with confirmation", func(t *testing.T) {
+		email := "patch-identity-confirmed@example.com"
+		userID := setupTestUserAndSettings(t, pool, encryptor, email)
+		seedMailCache(t, pool, userID)
+
+		req := httptest.NewRequest("PATCH", "/api/v1/settings?confirm_identity_change=true",
+			strings.NewReader(`{"imap_server_hostname": "imap.other.com"}`))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		handler.PatchSettings(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if count := countCachedThreads(t, pool, userID); count != 0 {
+			t.Errorf("Expected the cache to be cleared, got %d threads", count)
+		}
+		info, err := db.GetFolderSyncInfo(context.Background(), pool, userID, "INBOX")
+		if err != nil {
+			t.Fatalf("GetFolderSyncInfo failed: %v", err)
+		}
+		if info != nil {
+			t.Error("Expected the folder sync state to be reset")
+		}
+	})
+
+	t.Run("keeps the cache when only the hostname case changes", func(t *testing.T) {
+		email := "patch-identity-case@example.com"
+		userID := setupTestUserAndSettings(t, pool, encryptor, email)
+		seedMailCache(t, pool, userID)
+
+		rr := patch(email, `{"imap_server_hostname": "IMAP.test.com"}`)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if countCachedThreads(t, pool, userID) == 0 {
+			t.Error("Expected the cache to be kept")
+		}
+	})
+
+	t.Run("sets and resets the IMAP connection options", func(t *testing.T) {
+		email := "patch-imap-connection@example.com"
+		userID := setupTestUserAndSettings(t, pool, encryptor, email)
+
+		rr := patch(email, `{"imap_port": 143, "imap_security": "starttls", "imap_skip_tls_verify": true}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		saved, err := db.GetUserSettings(context.Background(), pool, userID)
+		if err != nil {
+			t.Fatalf("Failed to get saved settings: %v", err)
+		}
+		if saved.IMAPPort != 143 || saved.IMAPSecurity != models.IMAPSecuritySTARTTLS || !saved.IMAPSkipTLSVerify {
+			t.Errorf("Unexpected IMAP connection options: %d, %q, %v", saved.IMAPPort, saved.IMAPSecurity, saved.IMAPSkipTLSVerify)
+		}
+
+		rr = patch(email, `{"imap_port": null, "imap_security": null, "imap_skip_tls_verify": null}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		saved, err = db.GetUserSettings(context.Background(), pool, userID)
+		if err != nil {
+			t.Fatalf("Failed to get saved settings: %v", err)
+		}
+		if saved.IMAPPort != 0 || saved.IMAPSecurity != "" || saved.IMAPSkipTLSVerify {
+			t.Errorf("Expected the defaults, got %d, %q, %v", saved.IMAPPort, saved.IMAPSecurity, saved.IMAPSkipTLSVerify)
+		}
+	})
+
+	t.Run("returns 400 for invalid IMAP connection options", func(t *testing.T) {
+		email := "patch-imap-connection-invalid@example.com"
+		setupTestUserAndSettings(t, pool, encryptor, email)
+
+		rr := patch(email, `{"imap_security": "ssl"}`)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status 400, got %d", rr.Code)
+		}
+		var response models.ValidationErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response.Fields["imap_security"] == "" {
+			t.Errorf("Expected an error for imap_security, got %v", response.Fields)
+		}
+	})
+
+	t.Run("returns 404 for user without settings", func(t *testing.T) {
+		rr := patch("patch-no-settings@example.com", `{"imap_username": "someone"}`)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rr.Code)
+		}
+	})
+
+	t.Run("returns 400 for invalid request body", func(t *testing.T) {
+		email := "patch-bad-body@example.com"
+		setupTestUserAndSettings(t, pool, encryptor, email)
+
+		for _, body := range []string{"not json", "null", "[1, 2]"} {
+			rr := patch(email, body)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400 for body %q, got %d", body, rr.Code)
+			}
+		}
+	})
+}
+
+// seedMailCache saves a synced INBOX with one thread for the user.
+func seedMailCache(t *testing.T, pool *pgxpool.Pool, userID string) {
+	t.Helper()
+	ctx := context.Background()
+
+	thread := &models.Thread{UserID: userID, StableThreadID: "<cached@example.com>", Subject: "Cached"}
+	if err := db.SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+	msg := &models.Message{
+		ThreadID:        thread.ID,
+		UserID:          userID,
+		IMAPUID:         1,
+		IMAPFolderName:  "INBOX",
+		MessageIDHeader: "<cached@example.com>",
+		Subject:         "Cached",
+	}
+	if err := db.SaveMessage(ctx, pool, msg); err != nil {
+		t.Fatalf("SaveMessage failed: %v", err)
+	}
+	if err := db.SetFolderSyncInfo(ctx, pool, userID, "INBOX", nil); err != nil {
+		t.Fatalf("SetFolderSyncInfo failed: %v", err)
+	}
+}
+
+func TestValidateIMAPConnection(t *testing.T) {
+	t.Setenv("VMAIL_ENV", "production")
+
+	tests := []struct {
+		name          string
+		hostname      string
+		port          int
+		security      string
+		skipTLSVerify bool
+		wantField     string
+	}{
+		{"defaults", "imap.example.com:993", 0, "", false, ""},
+		{"port and STARTTLS", "imap.example.com", 143, models.IMAPSecuritySTARTTLS, true, ""},
+		{"port out of range", "imap.example.com", 70000, "", false, "imap_port"},
+		{"port twice", "imap.example.com:993", 993, "", false, "imap_port"},
+		{"unknown security", "imap.example.com", 0, "ssl", false, "imap_security"},
+		{"plain text in production", "imap.example.com", 0, models.IMAPSecurityNone, false, "imap_security"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field, _ := validateIMAPConnection(tt.hostname, tt.port, tt.security, tt.skipTLSVerify)
+			if field != tt.wantField {
+				t.Errorf("Expected field %q, got %q", tt.wantField, field)
+			}
+		})
+	}
+
+	t.Run("plain text without TLS options in development", func(t *testing.T) {
+		t.Setenv("VMAIL_ENV", "development")
+		if field, _ := validateIMAPConnection("localhost:143", 0, models.IMAPSecurityNone, false); field != "" {
+			t.Errorf("Expected no error, got one for %q", field)
+		}
+		if field, _ := validateIMAPConnection("localhost:143", 0, models.IMAPSecurityNone, true); field != "imap_skip_tls_verify" {
+			t.Errorf("Expected an error for imap_skip_tls_verify, got %q", field)
+		}
+	})
+}
+
+// countCachedThreads returns the number of cached threads of the user.
+func countCachedThreads(t *testing.T, pool *pgxpool.Pool, userID string) int {
+	t.Helper()
+	var count int
+	if err := pool.QueryRow(context.Background(), `SELECT COUNT(*) FROM threads WHERE user_id = $1`, userID).Scan(&count); err != nil {
+		t.Fatalf("Failed to count threads: %v", err)
+	}
+	return count
+}
+
+// failingResponseWriterSettings is a ResponseWriter that fails on Write to test error handling.
 type failingResponseWriterSettings struct {
 	http.ResponseWriter
 	writeShouldFail bool
@@ -534,7 +872,9 @@ func TestSettingsHandler_WriteResponseErrors(t *testing.T) {
 	defer pool.Close()
 
 	encryptor := getTestEncryptor(t)
-	handler := NewSettingsHandler(pool, encryptor)
+	imapPool := imap.NewPool()
+	defer imapPool.Close()
+	handler := NewSettingsHandler(pool, encryptor, imapPool)
 
 	t.Run("handles write failure gracefully in GetSettings", func(t *testing.T) {
 		email := "write-error-get@example.com"
@@ -0,0 +1,466 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/crypto"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/sieve"
+)
+
+// maxSieveScriptNameLength is the longest script name we accept, in characters. Servers have limits too, for
+// example, Dovecot allows 256 characters.
+const maxSieveScriptNameLength = 128
+
+// Errors of dialSieve, for which the mail server is to blame.
+var (
+	errSieveConnectFailed = errors.New("failed to connect to the ManageSieve server")
+	errSieveLoginFailed   = errors.New("the ManageSieve server rejected the login")
+)
+
+// SieveHandler manages the user's Sieve scripts on their ManageSieve server at /api/v1/sieve.
+// The server is the user's IMAP server, see sieve.ServerFromSettings.
+type SieveHandler struct {
+	pool      *pgxpool.Pool
+	encryptor *crypto.Encryptor
+}
+
+// NewSieveHandler creates a new SieveHandler instance.
+func NewSieveHandler(pool *pgxpool.Pool, encryptor *crypto.Encryptor) *SieveHandler {
+	return &SieveHandler{
+		pool:      pool,
+		encryptor: encryptor,
+	}
+}
+
+// GetScripts returns the user's scripts, and which one is active.
+func (h *SieveHandler) GetScripts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	h.withClient(ctx, w, userID, func(c *sieve.Client) error {
+		scripts, err := c.ListScripts()
+		if err != nil {
+			return err
+		}
+		WriteJSONResponse(w, scripts)
+		return nil
+	})
+}
+
+// GetScript returns a script with its content. The path is /api/v1/sieve/scripts/{name}.
+func (h *SieveHandler) GetScript(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	name, err := getSieveScriptNameFromPath(r.URL, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.withClient(ctx, w, userID, func(c *sieve.Client) error {
+		return writeSieveScript(w, c, name)
+	})
+}
+
+// PutScript creates or replaces a script, and responds with it. The server checks the script first, so invalid
+// scripts get a 400 with the server's error. The path is /api/v1/sieve/scripts/{name}.
+func (h *SieveHandler) PutScript(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	name, err := getSieveScriptNameFromPath(r.URL, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req models.SieveScriptRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.InfoContext(ctx, "SieveHandler: Failed to decode request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	h.withClient(ctx, w, userID, func(c *sieve.Client) error {
+		if err := c.PutScript(name, req.Content); err != nil {
+			var serverErr *sieve.Error
+			if errors.As(err, &serverErr) {
+				writeInvalidSieveScriptError(w, serverErr)
+				return nil
+			}
+			return err
+		}
+		return writeSieveScript(w, c, name)
+	})
+}
+
+// ActivateScript makes a script the active one, which the server runs on delivery.
+// The path is /api/v1/sieve/scripts/{name}/activate.
+func (h *SieveHandler) ActivateScript(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	name, err := getSieveScriptNameFromPath(r.URL, "/activate")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.withClient(ctx, w, userID, func(c *sieve.Client) error {
+		if err := c.SetActive(name); err != nil {
+			return err
+		}
+		// Without the filters' block, the server stops running the auto-responder, so the backend takes it over
+		content, err := c.GetScript(name)
+		if err != nil {
+			return err
+		}
+		if _, ok := sieve.FindBlock(content); !ok && name != sieve.FiltersScriptName {
+			if err := db.SetVacationResponderMode(ctx, h.pool, userID, models.VacationModeBackend); err != nil {
+				slog.ErrorContext(ctx, "SieveHandler: Failed to move the auto-responder to the backend", "error", err)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	})
+}
+
+// SyncFilters syncs the user's filters with the V-Mail block of their active Sieve script, see syncFilterBlock, so
+// that the server runs the filters on delivery, and edits made on the server come back. The block keeps the
+// auto-responder if the server runs it, see VacationHandler. Responds with the script, and whether the block's edits
+// replaced the filters. Blocks with rules that filters can't express, scripts that the server rejects, and servers
+// without the extensions that the filters need get a 409. The path is /api/v1/sieve/filters.
+func (h *SieveHandler) SyncFilters(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	vacation, err := getSieveVacation(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "SieveHandler: Failed to get the auto-responder", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	unlock := lockSieveSync(userID)
+	defer unlock()
+	h.withClient(ctx, w, userID, func(c *sieve.Client) error {
+		script, err := getActiveScript(c)
+		if err != nil {
+			return err
+		}
+		result, err := syncFilterBlock(ctx, h.pool, c, userID, script, vacation, true)
+		var serverErr *sieve.Error
+		switch {
+		case errors.Is(err, sieve.ErrUnsupportedExtension):
+			http.Error(w, "The mail server can't run these filters: "+err.Error(), http.StatusConflict)
+		case errors.Is(err, sieve.ErrUnreadableBlock):
+			http.Error(w, "The filters on the mail server have rules that V-Mail can't read: "+err.Error(), http.StatusConflict)
+		case errors.As(err, &serverErr) && !errors.Is(err, sieve.ErrScriptNotFound):
+			http.Error(w, "The mail server rejected the script with the filters: "+serverErr.Message, http.StatusConflict)
+		case err != nil:
+			return err
+		default:
+			WriteJSONResponse(w, result)
+		}
+		return nil
+	})
+}
+
+// sieveSyncLocks maps user IDs to a *sync.Mutex, so that the syncs of a user's filters with Sieve don't overlap.
+var sieveSyncLocks sync.Map
+
+// lockSieveSync locks the user's filters for a sync with Sieve, and returns the function that unlocks them.
+func lockSieveSync(userID string) func() {
+	value, _ := sieveSyncLocks.LoadOrStore(userID, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// getSieveVacation returns the user's auto-responder if the mail server runs it, or nil if the backend does.
+func getSieveVacation(ctx context.Context, pool *pgxpool.Pool, userID string) (*models.VacationResponder, error) {
+	vacation, err := db.GetVacationResponder(ctx, pool, userID)
+	if err != nil {
+		return nil, err
+	}
+	if vacation.Mode != models.VacationModeSieve {
+		return nil, nil
+	}
+	return vacation, nil
+}
+
+// getActiveScript returns the active script with its content. If no script is active, it returns the
+// sieve.FiltersScriptName one, which may not exist yet.
+func getActiveScript(c *sieve.Client) (models.SieveScript, error) {
+	scripts, err := c.ListScripts()
+	if err != nil {
+		return models.SieveScript{}, err
+	}
+	script := models.SieveScript{Name: sieve.FiltersScriptName}
+	exists := false
+	for _, s := range scripts {
+		if s.Active {
+			script, exists = s, true
+			break
+		}
+		exists = exists || s.Name == sieve.FiltersScriptName
+	}
+	if exists {
+		if script.Content, err = c.GetScript(script.Name); err != nil {
+			return models.SieveScript{}, err
+		}
+	}
+	return script, nil
+}
+
+// syncFilterBlock syncs the user's filters with the V-Mail block of the script, which getActiveScript returns.
+// If importEdits is set, and the block changed since V-Mail last wrote it to this script, the block's rules replace
+// the filters first, see sieve.BlockToFilters. Then it writes the filters to the block, and leaves the rest of the
+// script as it is. The script gets activated if it's not active yet. vacation can be nil to leave out the
+// auto-responder. Returns sieve.ErrUnreadableBlock if the block has rules that filters can't express,
+// sieve.ErrUnsupportedExtension if the server can't run the filters, and a *sieve.Error if it rejects the script.
+func syncFilterBlock(ctx context.Context, pool *pgxpool.Pool, c *sieve.Client, userID string, script models.SieveScript, vacation *models.VacationResponder, importEdits bool) (*models.SieveFiltersSync, error) {
+	trashFolder, err := getTrashFolderName(ctx, pool, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.SieveFiltersSync{}
+	if block, ok := sieve.FindBlock(script.Content); ok && importEdits {
+		last, err := db.GetSieveFilterBlock(ctx, pool, userID)
+		if err != nil && !errors.Is(err, db.ErrSieveFilterBlockNotFound) {
+			return nil, err
+		}
+		// Blocks that V-Mail never wrote to this script, like ones that users copied, aren't edits
+		if last != nil && last.ScriptName == script.Name && last.Hash != sieve.BlockHash(block) {
+			filters, err := sieve.BlockToFilters(block, trashFolder)
+			if err != nil {
+				return nil, err
+			}
+			if err := validateImportedFilters(filters); err != nil {
+				return nil, err
+			}
+			if err := db.ReplaceFilters(ctx, pool, userID, filters); err != nil {
+				return nil, err
+			}
+			result.Imported = true
+		}
+	}
+
+	filters, err := db.GetFilters(ctx, pool, userID)
+	if err != nil {
+		return nil, err
+	}
+	block, err := sieve.FiltersToBlock(filters, sieve.ScriptOptions{TrashFolder: trashFolder, Extensions: c.Extensions(), Vacation: vacation})
+	if err != nil {
+		return nil, err
+	}
+	script.Content = sieve.SetBlock(script.Content, block)
+	if err := c.PutScript(script.Name, script.Content); err != nil {
+		return nil, err
+	}
+	if !script.Active {
+		if err := c.SetActive(script.Name); err != nil {
+			return nil, err
+		}
+		script.Active = true
+	}
+	err = db.SaveSieveFilterBlock(ctx, pool, &models.SieveFilterBlock{UserID: userID, ScriptName: script.Name, Hash: sieve.BlockHash(block)})
+	if err != nil {
+		return nil, err
+	}
+	result.Script = script
+	return result, nil
+}
+
+// validateImportedFilters checks the filters of a block like the ones that users save, see validateFilterRequest.
+// Returns sieve.ErrUnreadableBlock with the first problem.
+func validateImportedFilters(filters []*models.Filter) error {
+	for _, filter := range filters {
+		req := models.FilterRequest{
+			Name:          filter.Name,
+			From:          filter.From,
+			To:            filter.To,
+			Subject:       filter.Subject,
+			HasAttachment: filter.HasAttachment,
+			MarkRead:      filter.MarkRead,
+			AddLabel:      filter.AddLabel,
+			MoveTo:        filter.MoveTo,
+			Delete:        filter.Delete,
+		}
+		fieldErrors := validateFilterRequest(&req)
+		if len(fieldErrors) > 0 {
+			fields := slices.Sorted(maps.Keys(fieldErrors))
+			return fmt.Errorf("%w: %s %s", sieve.ErrUnreadableBlock, fields[0], fieldErrors[fields[0]])
+		}
+	}
+	return nil
+}
+
+// getTrashFolderName returns the folder that the user set as Trash by hand, or "Trash".
+// Servers with the "special-use" extension find their Trash folder themselves, and only fall back to this.
+func getTrashFolderName(ctx context.Context, pool *pgxpool.Pool, userID string) (string, error) {
+	overrides, err := db.GetFolderRoleOverrides(ctx, pool, userID)
+	if err != nil {
+		return "", err
+	}
+	for folderName, role := range overrides {
+		if role == "trash" {
+			return folderName, nil
+		}
+	}
+	return "Trash", nil
+}
+
+// dialSieve connects and logs in to the user's ManageSieve server. The caller must log out.
+// Returns db.ErrUserSettingsNotFound without settings, and errSieveConnectFailed or errSieveLoginFailed if the
+// server fails.
+func dialSieve(ctx context.Context, pool *pgxpool.Pool, encryptor *crypto.Encryptor, userID string) (*sieve.Client, error) {
+	settings, err := db.GetUserSettings(ctx, pool, userID)
+	if err != nil {
+		return nil, err
+	}
+	imapPassword, err := encryptor.Decrypt(settings.EncryptedIMAPPassword)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt IMAP password: %w", err)
+	}
+
+	c, err := sieve.Dial(ctx, sieve.ServerFromSettings(settings))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errSieveConnectFailed, err)
+	}
+	if err := c.Authenticate(settings.IMAPUsername, imapPassword); err != nil {
+		_ = c.Close()
+		return nil, fmt.Errorf("%w: %w", errSieveLoginFailed, err)
+	}
+	return c, nil
+}
+
+// withClient connects and logs in to the user's ManageSieve server, runs fn, and logs out.
+// It writes the error responses for failing to connect, and for the errors of fn.
+func (h *SieveHandler) withClient(ctx context.Context, w http.ResponseWriter, userID string, fn func(*sieve.Client) error) {
+	c, err := dialSieve(ctx, h.pool, h.encryptor, userID)
+	switch {
+	case errors.Is(err, db.ErrUserSettingsNotFound):
+		http.Error(w, "User settings not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errSieveConnectFailed):
+		slog.WarnContext(ctx, "SieveHandler: Failed to connect to the ManageSieve server", "error", err)
+		http.Error(w, "Failed to connect to the ManageSieve server. The mail server may not support it.", http.StatusBadGateway)
+		return
+	case errors.Is(err, errSieveLoginFailed):
+		slog.WarnContext(ctx, "SieveHandler: Failed to log in to the ManageSieve server", "error", err)
+		http.Error(w, "The ManageSieve server rejected the login", http.StatusBadGateway)
+		return
+	case err != nil:
+		slog.ErrorContext(ctx, "SieveHandler: Failed to connect to the ManageSieve server", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		if err := c.Logout(); err != nil {
+			slog.WarnContext(ctx, "SieveHandler: Failed to log out", "error", err)
+		}
+	}()
+
+	err = fn(c)
+	if errors.Is(err, sieve.ErrScriptNotFound) {
+		http.Error(w, "Script not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "SieveHandler: ManageSieve command failed", "error", err)
+		http.Error(w, "The ManageSieve server failed the request", http.StatusBadGateway)
+	}
+}
+
+// writeSieveScript writes the script with its content, and whether it's active.
+// Returns sieve.ErrScriptNotFound if there's no such script.
+func writeSieveScript(w http.ResponseWriter, c *sieve.Client, name string) error {
+	content, err := c.GetScript(name)
+	if err != nil {
+		return err
+	}
+	scripts, err := c.ListScripts()
+	if err != nil {
+		return err
+	}
+	script := models.SieveScript{Name: name, Content: content}
+	for _, s := range scripts {
+		if s.Name == name {
+			script.Active = s.Active
+		}
+	}
+	WriteJSONResponse(w, script)
+	return nil
+}
+
+// writeInvalidSieveScriptError writes a 400 with the server's reason for rejecting a script, like a syntax error.
+func writeInvalidSieveScriptError(w http.ResponseWriter, serverErr *sieve.Error) {
+	message := serverErr.Message
+	if message == "" {
+		message = "the mail server rejected the script"
+	}
+	WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+		Error:  "Invalid script",
+		Fields: map[string]string{"content": message},
+	})
+}
+
+// getSieveScriptNameFromPath extracts the script name from a /api/v1/sieve/scripts/{name}{suffix} path,
+// for example, with suffix "/activate". The name must be URL-encoded, like folder names, see getFolderNameFromPath.
+// Names can't have control characters, and are at most maxSieveScriptNameLength characters. See RFC 5804, section 1.6.
+func getSieveScriptNameFromPath(u *url.URL, suffix string) (string, error) {
+	escaped, ok := strings.CutPrefix(u.EscapedPath(), "/api/v1/sieve/scripts/")
+	if !ok {
+		return "", errors.New("script name is required")
+	}
+	escaped, ok = strings.CutSuffix(escaped, suffix)
+	if !ok || escaped == "" || strings.Contains(escaped, "/") {
+		return "", errors.New("script name is required")
+	}
+
+	name, err := url.PathUnescape(escaped)
+	if err != nil {
+		return "", fmt.Errorf("invalid script name encoding: %w", err)
+	}
+	if len([]rune(name)) > maxSieveScriptNameLength || strings.ContainsFunc(name, unicode.IsControl) {
+		return "", fmt.Errorf("script name must be at most %d characters, without control characters", maxSieveScriptNameLength)
+	}
+	return name, nil
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/sieve"
+)
+
+func TestGetSieveScriptNameFromPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		suffix  string
+		want    string
+		wantErr bool
+	}{
+		{"simple name", "/api/v1/sieve/scripts/vmail-filters", "", "vmail-filters", false},
+		{"encoded name", "/api/v1/sieve/scripts/My%20rules%2Fold", "", "My rules/old", false},
+		{"with suffix", "/api/v1/sieve/scripts/vacation/activate", "/activate", "vacation", false},
+		{"missing name", "/api/v1/sieve/scripts/", "", "", true},
+		{"missing name with suffix", "/api/v1/sieve/scripts//activate", "/activate", "", true},
+		{"unencoded slash", "/api/v1/sieve/scripts/a/b", "", "", true},
+		{"control character", "/api/v1/sieve/scripts/a%0Ab", "", "", true},
+		{"too long", "/api/v1/sieve/scripts/" + strings.Repeat("x", maxSieveScriptNameLength+1), "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.path)
+			if err != nil {
+				t.Fatalf("Failed to parse URL: %v", err)
+			}
+			got, err := getSieveScriptNameFromPath(u, tt.suffix)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestValidateImportedFilters(t *testing.T) {
+	valid := []*models.Filter{{From: "news@example.com", MoveTo: "Newsletters"}, {Subject: "invoice", AddLabel: "invoices"}}
+	if err := validateImportedFilters(valid); err != nil {
+		t.Errorf("Expected valid filters, got %v", err)
+	}
+
+	invalid := [][]*models.Filter{
+		{{From: "news@example.com", MoveTo: "INBOX"}},
+		{{From: strings.Repeat("x", maxFilterFieldLength+1), MarkRead: true}},
+		{{Subject: "invoice", AddLabel: "two words"}},
+	}
+	for _, filters := range invalid {
+		if err := validateImportedFilters(filters); !errors.Is(err, sieve.ErrUnreadableBlock) {
+			t.Errorf("Expected ErrUnreadableBlock for %+v, got %v", filters[0], err)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// maxSyncDeltaThreads is the most changed threads a delta lists. If more changed, clients get a reset instead,
+// since refetching the thread lists is cheaper at that point.
+const maxSyncDeltaThreads = 500
+
+// syncCursorPrefix versions the sync cursor format so we can change it later without misreading old cursors.
+const syncCursorPrefix = "s1:"
+
+// errInvalidSyncCursor is returned when the "since" query parameter can't be decoded.
+var errInvalidSyncCursor = errors.New("invalid cursor")
+
+// SyncHandler lets clients that were offline catch up with what changed in the cache, at /api/v1/sync/delta,
+// and tells them how syncing goes, at /api/v1/sync/status.
+type SyncHandler struct {
+	pool *pgxpool.Pool
+}
+
+// NewSyncHandler creates a new SyncHandler instance.
+func NewSyncHandler(pool *pgxpool.Pool) *SyncHandler {
+	return &SyncHandler{
+		pool: pool,
+	}
+}
+
+// GetDelta returns the threads and folders that changed since the "since" cursor.
+// Without "since", or with a cursor older than db.SyncChangeRetention, it returns an empty delta
+// with Reset set and a cursor to start from.
+// It only reads the cache, so it doesn't sync from IMAP.
+func (h *SyncHandler) GetDelta(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	// Changes older than the retention might be pruned already, so old cursors start over
+	since := r.URL.Query().Get("since")
+	var position uint64
+	var issuedAt time.Time
+	if since != "" {
+		var err error
+		position, issuedAt, err = decodeSyncCursor(since)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if since == "" || time.Since(issuedAt) > db.SyncChangeRetention {
+		h.writeReset(w, r)
+		return
+	}
+
+	delta, next, err := db.GetSyncDelta(ctx, h.pool, userID, position, maxSyncDeltaThreads)
+	if err != nil {
+		slog.ErrorContext(ctx, "SyncHandler: Failed to get sync delta", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	delta.Cursor = encodeSyncCursor(next, time.Now())
+
+	WriteJSONResponse(w, delta)
+}
+
+// GetStatus returns whether we hold the user's syncs back, because their IMAP server throttled us
+// or kept dropping the connection, so that clients can tell the user why new mail is slow to show up.
+// It also returns the state of each folder's sync, so that clients can show when we last synced it,
+// whether it's syncing now, and why its last sync failed.
+func (h *SyncHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	throttle, err := db.GetSyncThrottle(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "SyncHandler: Failed to get sync throttle", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	folders, err := db.GetFolderSyncStatuses(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "SyncHandler: Failed to get folder sync statuses", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	status := models.SyncStatus{Folders: folders}
+	if throttle.ThrottledUntil != nil && time.Now().Before(*throttle.ThrottledUntil) {
+		status.Throttled = true
+		status.ThrottledUntil = throttle.ThrottledUntil
+		status.Reason = throttle.Reason
+	}
+
+	WriteJSONResponse(w, status)
+}
+
+// writeReset writes an empty delta with Reset set, and a cursor that points to the current position.
+func (h *SyncHandler) writeReset(w http.ResponseWriter, r *http.Request) {
+	position, err := db.GetSyncPosition(r.Context(), h.pool)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "SyncHandler: Failed to get sync position", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	WriteJSONResponse(w, &models.SyncDelta{
+		Cursor:           encodeSyncCursor(position, time.Now()),
+		Reset:            true,
+		Threads:          []*models.ThreadDelta{},
+		DeletedThreadIDs: []string{},
+		Folders:          []*models.FolderCounts{},
+	})
+}
+
+// encodeSyncCursor creates an opaque cursor that points to a position in the sync change log.
+// It includes when it was issued, so we can tell if the changes after it might be pruned already.
+func encodeSyncCursor(position uint64, issuedAt time.Time) string {
+	cursor := fmt.Sprintf("%s%d:%d", syncCursorPrefix, position, issuedAt.Unix())
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor))
+}
+
+// decodeSyncCursor returns the position a sync cursor points to, and when it was issued.
+func decodeSyncCursor(cursor string) (uint64, time.Time, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("%w: %v", errInvalidSyncCursor, err)
+	}
+
+	rest, ok := strings.CutPrefix(string(decoded), syncCursorPrefix)
+	if !ok {
+		return 0, time.Time{}, fmt.Errorf("%w: unknown format", errInvalidSyncCursor)
+	}
+
+	positionStr, issuedAtStr, ok := strings.Cut(rest, ":")
+	if !ok {
+		return 0, time.Time{}, fmt.Errorf("%w: unknown format", errInvalidSyncCursor)
+	}
+	position, err := strconv.ParseUint(positionStr, 10, 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("%w: bad position", errInvalidSyncCursor)
+	}
+	issuedAt, err := strconv.ParseInt(issuedAtStr, 10, 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("%w: bad time", errInvalidSyncCursor)
+	}
+
+	return position, time.Unix(issuedAt, 0), nil
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestSyncCursor(t *testing.T) {
+	t.Run("round-trips the position and the time", func(t *testing.T) {
+		issuedAt := time.Unix(1700000000, 0)
+		position, decodedAt, err := decodeSyncCursor(encodeSyncCursor(12345, issuedAt))
+		if err != nil {
+			t.Fatalf("decodeSyncCursor failed: %v", err)
+		}
+		if position != 12345 || !decodedAt.Equal(issuedAt) {
+			t.Errorf("Expected 12345 at %v, got %d at %v", issuedAt, position, decodedAt)
+		}
+	})
+
+	invalid := []string{
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("o1:100")),
+		base64.RawURLEncoding.EncodeToString([]byte("s1:100")),
+		base64.RawURLEncoding.EncodeToString([]byte("s1:-1:1700000000")),
+		base64.RawURLEncoding.EncodeToString([]byte("s1:100:yesterday")),
+	}
+	for _, cursor := range invalid {
+		t.Run("rejects "+cursor, func(t *testing.T) {
+			if _, _, err := decodeSyncCursor(cursor); !errors.Is(err, errInvalidSyncCursor) {
+				t.Errorf("Expected errInvalidSyncCursor, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSyncHandler_GetDelta(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	email := "sync-delta-user@example.com"
+	userID := setupTestUserAndSettings(t, pool, getTestEncryptor(t), email)
+
+	handler := NewSyncHandler(pool)
+	getDelta := func(t *testing.T, since string) (*httptest.ResponseRecorder, models.SyncDelta) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/v1/sync/delta?since="+url.QueryEscape(since), nil)
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		handler.GetDelta(rr, req)
+
+		var delta models.SyncDelta
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &delta); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+		}
+		return rr, delta
+	}
+
+	var cursor string
+
+	t.Run("resets without a cursor", func(t *testing.T) {
+		rr, delta := getDelta(t, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if !delta.Reset || delta.Cursor == "" {
+			t.Errorf("Expected a reset with a cursor, got %+v", delta)
+		}
+		cursor = delta.Cursor
+	})
+
+	t.Run("returns the threads that changed since the cursor", func(t *testing.T) {
+		ctx := context.Background()
+		thread := &models.Thread{UserID: userID, StableThreadID: "<delta@example.com>", Subject: "Delta"}
+		if err := db.SaveThread(ctx, pool, thread); err != nil {
+			t.Fatalf("SaveThread failed: %v", err)
+		}
+		now := time.Now()
+		message := &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         1,
+			IMAPFolderName:  "INBOX",
+			MessageIDHeader: "<delta@example.com>",
+			Subject:         "Delta",
+			SentAt:          &now,
+		}
+		if err := db.SaveMessage(ctx, pool, message); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+
+		rr, delta := getDelta(t, cursor)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if delta.Reset || len(delta.Threads) != 1 || delta.Threads[0].StableThreadID != "<delta@example.com>" {
+			t.Errorf("Expected the new thread, got %+v", delta)
+		}
+		if delta.Cursor == "" || delta.Cursor == cursor {
+			t.Errorf("Expected a new cursor, got %q", delta.Cursor)
+		}
+	})
+
+	t.Run("resets for expired cursors", func(t *testing.T) {
+		expired := encodeSyncCursor(1, time.Now().Add(-db.SyncChangeRetention-time.Hour))
+		rr, delta := getDelta(t, expired)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if !delta.Reset {
+			t.Errorf("Expected a reset, got %+v", delta)
+		}
+	})
+
+	t.Run("rejects invalid cursors", func(t *testing.T) {
+		rr, _ := getDelta(t, "invalid!")
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+}
+
+func TestSyncHandler_GetStatus(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	email := "sync-status-user@example.com"
+	userID := setupTestUserAndSettings(t, pool, getTestEncryptor(t), email)
+
+	handler := NewSyncHandler(pool)
+	getStatus := func(t *testing.T) models.SyncStatus {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/v1/sync/status", nil)
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		handler.GetStatus(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var status models.SyncStatus
+		if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		return status
+	}
+
+	t.Run("isn't throttled by default", func(t *testing.T) {
+		if status := getStatus(t); status.Throttled {
+			t.Errorf("Expected no throttling, got %+v", status)
+		}
+	})
+
+	t.Run("returns the state of each folder's sync", func(t *testing.T) {
+		ctx := context.Background()
+		if err := db.MarkFolderSyncStarted(ctx, pool, userID, "Archive"); err != nil {
+			t.Fatalf("MarkFolderSyncStarted failed: %v", err)
+		}
+		if err := db.MarkFolderSyncStarted(ctx, pool, userID, "INBOX"); err != nil {
+			t.Fatalf("MarkFolderSyncStarted failed: %v", err)
+		}
+		if err := db.MarkFolderSyncFinished(ctx, pool, userID, "INBOX", "connection reset by peer"); err != nil {
+			t.Fatalf("MarkFolderSyncFinished failed: %v", err)
+		}
+
+		status := getStatus(t)
+		if len(status.Folders) != 2 {
+			t.Fatalf("Expected 2 folders, got %+v", status.Folders)
+		}
+		archive, inbox := status.Folders[0], status.Folders[1]
+		if archive.FolderName != "Archive" || !archive.Syncing {
+			t.Errorf("Expected Archive to be syncing, got %+v", archive)
+		}
+		if inbox.FolderName != "INBOX" || inbox.Syncing || inbox.LastError != "connection reset by peer" {
+			t.Errorf("Expected INBOX to have failed, got %+v", inbox)
+		}
+	})
+
+	t.Run("returns the throttling state", func(t *testing.T) {
+		until := time.Now().Add(time.Hour)
+		throttle := &models.SyncThrottle{Strikes: 1, ThrottledUntil: &until, Reason: "Account exceeded command or bandwidth limits."}
+		if err := db.SaveSyncThrottle(context.Background(), pool, userID, throttle); err != nil {
+			t.Fatalf("SaveSyncThrottle failed: %v", err)
+		}
+
+		status := getStatus(t)
+		if !status.Throttled || status.ThrottledUntil == nil || status.Reason != throttle.Reason {
+			t.Errorf("Expected the throttling state, got %+v", status)
+		}
+	})
+
+	t.Run("isn't throttled once the backoff is over", func(t *testing.T) {
+		until := time.Now().Add(-time.Minute)
+		throttle := &models.SyncThrottle{Strikes: 1, ThrottledUntil: &until, Reason: "Server Unavailable. 15"}
+		if err := db.SaveSyncThrottle(context.Background(), pool, userID, throttle); err != nil {
+			t.Fatalf("SaveSyncThrottle failed: %v", err)
+		}
+
+		if status := getStatus(t); status.Throttled {
+			t.Errorf("Expected no throttling, got %+v", status)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// StartSync syncs a folder from IMAP right away, regardless of the cache TTL, for example, when the user hits
+// refresh. The sync runs in the background as a job, and the response has the job ID, which the sync_started,
+// sync_progress, and sync_finished WebSocket events of the sync carry.
+// If the folder is already syncing, it responds with the running job instead of starting another.
+// The path is /api/v1/sync?folder=INBOX.
+func (h *ThreadsHandler) StartSync(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	if folder == "" {
+		http.Error(w, "folder query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	pref, err := db.GetFolderSyncPreference(ctx, h.pool, userID, folder)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadsHandler: Failed to get folder sync preference", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !pref.Enabled {
+		http.Error(w, "Syncing is disabled for this folder", http.StatusConflict)
+		return
+	}
+
+	throttle, err := db.GetSyncThrottle(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadsHandler: Failed to get sync throttle", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if throttle.ThrottledUntil != nil && time.Now().Before(*throttle.ThrottledUntil) {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(time.Until(*throttle.ThrottledUntil))))
+		http.Error(w, "The mail server throttles the account, so syncing is paused", http.StatusServiceUnavailable)
+		return
+	}
+
+	bgSync, started := h.startBackgroundSync(userID, folder)
+	WriteJSONResponseWithStatus(w, http.StatusAccepted, models.SyncJobResponse{
+		JobID:   bgSync.jobID,
+		Folder:  folder,
+		Started: started,
+	})
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestThreadsHandler_StartSync(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	encryptor := getTestEncryptor(t)
+	email := "start-sync-test@example.com"
+	userID := setupTestUserAndSettings(t, pool, encryptor, email)
+
+	startSync := func(t *testing.T, handler *ThreadsHandler, url string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := createRequestWithUser("POST", url, email)
+		rr := httptest.NewRecorder()
+		handler.StartSync(rr, req)
+		return rr
+	}
+
+	t.Run("starts a sync job, and joins it while it runs", func(t *testing.T) {
+		mockIMAP := &mockIMAPServiceForSyncBudget{release: make(chan struct{})}
+		handler := NewThreadsHandler(pool, encryptor, mockIMAP, nil, 0)
+
+		var first, second models.SyncJobResponse
+		for _, response := range []*models.SyncJobResponse{&first, &second} {
+			rr := startSync(t, handler, "/api/v1/sync?folder=INBOX")
+			if rr.Code != http.StatusAccepted {
+				t.Fatalf("Expected status 202, got %d: %s", rr.Code, rr.Body.String())
+			}
+			if err := json.NewDecoder(rr.Body).Decode(response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		close(mockIMAP.release)
+
+		if first.JobID == "" || first.Folder != "INBOX" || !first.Started {
+			t.Errorf("Expected a new job, got %+v", first)
+		}
+		if second.JobID != first.JobID || second.Started {
+			t.Errorf("Expected the running job %s, got %+v", first.JobID, second)
+		}
+	})
+
+	t.Run("returns 400 without a folder", func(t *testing.T) {
+		handler := NewThreadsHandler(pool, encryptor, &mockIMAPService{}, nil, 0)
+		if rr := startSync(t, handler, "/api/v1/sync"); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("returns 409 for folders that don't sync", func(t *testing.T) {
+		pref := &models.FolderSyncPreference{FolderName: "Archive", Enabled: false, Mode: models.FolderSyncModeHeadersOnly}
+		if err := db.SaveFolderSyncPreference(context.Background(), pool, userID, pref); err != nil {
+			t.Fatalf("SaveFolderSyncPreference failed: %v", err)
+		}
+
+		mockIMAP := &mockIMAPService{}
+		handler := NewThreadsHandler(pool, encryptor, mockIMAP, nil, 0)
+		if rr := startSync(t, handler, "/api/v1/sync?folder=Archive"); rr.Code != http.StatusConflict {
+			t.Errorf("Expected status 409, got %d", rr.Code)
+		}
+	})
+
+	t.Run("returns 503 while the server throttles the account", func(t *testing.T) {
+		until := time.Now().Add(time.Minute)
+		throttle := &models.SyncThrottle{Strikes: 1, ThrottledUntil: &until, Reason: "Too many connections"}
+		if err := db.SaveSyncThrottle(context.Background(), pool, userID, throttle); err != nil {
+			t.Fatalf("SaveSyncThrottle failed: %v", err)
+		}
+		defer func() { _ = db.ClearSyncThrottle(context.Background(), pool, userID) }()
+
+		handler := NewThreadsHandler(pool, encryptor, &mockIMAPService{}, nil, 0)
+		rr := startSync(t, handler, "/api/v1/sync?folder=INBOX")
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected status 503, got %d", rr.Code)
+		}
+		if rr.Header().Get("Retry-After") == "" {
+			t.Error("Expected a Retry-After header")
+		}
+	})
+}
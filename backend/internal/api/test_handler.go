@@ -1,13 +1,14 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
-	"strings"
+	"net/mail"
 	"time"
 
 	"github.com/emersion/go-imap"
@@ -16,7 +17,8 @@ import (
 	"github.com/vdavid/vmail/backend/internal/crypto"
 	"github.com/vdavid/vmail/backend/internal/db"
 	imapinternal "github.com/vdavid/vmail/backend/internal/imap"
-	ws "github.com/vdavid/vmail/backend/internal/websocket"
+	"github.com/vdavid/vmail/backend/internal/mime"
+	"github.com/vdavid/vmail/backend/internal/models"
 )
 
 // TestHandler provides test-only endpoints used by E2E tests.
@@ -25,28 +27,58 @@ type TestHandler struct {
 	pool        *pgxpool.Pool
 	encryptor   *crypto.Encryptor
 	imapService imapinternal.IMAPService
-	hub         *ws.Hub
 }
 
 // NewTestHandler creates a new TestHandler instance.
-func NewTestHandler(pool *pgxpool.Pool, encryptor *crypto.Encryptor, imapService imapinternal.IMAPService, hub *ws.Hub) *TestHandler {
+func NewTestHandler(pool *pgxpool.Pool, encryptor *crypto.Encryptor, imapService imapinternal.IMAPService) *TestHandler {
 	return &TestHandler{
 		pool:        pool,
 		encryptor:   encryptor,
 		imapService: imapService,
-		hub:         hub,
 	}
 }
 
+// errTestMessageNotFound means that the folder has no message with the Message-ID in the request.
+var errTestMessageNotFound = errors.New("message not found")
+
 type addIMAPMessageRequest struct {
 	Folder  string `json:"folder"`
 	Subject string `json:"subject"`
 	From    string `json:"from"`
 	To      string `json:"to"`
+	// MessageID lets tests find the message later, for example, to set its flags. It's generated if empty.
+	MessageID string `json:"message_id"`
+	// InReplyTo is the Message-ID of the message that this one replies to, so that they're in the same thread.
+	InReplyTo string `json:"in_reply_to"`
+	// Text and HTML are the bodies. If both are empty, the message has a short text body.
+	Text        string                      `json:"text"`
+	HTML        string                      `json:"html"`
+	Attachments []models.OutgoingAttachment `json:"attachments"`
+	// Flags are the flags of the message, like "\\Flagged". Omitted means just \Seen, and [] means unread.
+	Flags []string `json:"flags"`
+}
+
+// imapMessageRequest picks a message on the IMAP server, for the endpoints that change it.
+type imapMessageRequest struct {
+	Folder    string `json:"folder"`
+	MessageID string `json:"message_id"`
+	// AddFlags and RemoveFlags are the flags that /test/set-imap-flags changes.
+	AddFlags    []string `json:"add_flags"`
+	RemoveFlags []string `json:"remove_flags"`
+	// ToFolder is where /test/move-imap-message moves the message.
+	ToFolder string `json:"to_folder"`
+}
+
+// syncRequest is the request body of /test/sync.
+type syncRequest struct {
+	Folder string `json:"folder"`
+	// Full makes it a full sync, which also picks up flag changes and deletions on servers without CONDSTORE.
+	Full bool `json:"full"`
 }
 
 // AddIMAPMessage appends a test message to the user's IMAP folder.
 // It is used by E2E tests to simulate new incoming mail.
+// The message can have text and HTML bodies and attachments, and it's multipart if it has more than one part.
 func (h *TestHandler) AddIMAPMessage(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -65,6 +97,11 @@ func (h *TestHandler) AddIMAPMessage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	raw, err := buildTestMessage(req, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	client, err := h.connectToIMAP(ctx, userID)
 	if err != nil {
@@ -75,16 +112,208 @@ func (h *TestHandler) AddIMAPMessage(w http.ResponseWriter, r *http.Request) {
 		_ = client.Logout()
 	}()
 
-	if err := h.appendMessage(client, req); err != nil {
+	if err := h.appendMessage(client, req, raw); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	h.syncAndNotify(ctx, userID, req.Folder)
+	h.syncFolder(ctx, userID, req.Folder)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// SetIMAPFlags adds and removes flags of a message, for example, {"add_flags": ["\\Flagged"]}.
+func (h *TestHandler) SetIMAPFlags(w http.ResponseWriter, r *http.Request) {
+	userID, req, ok := h.parseMessageRequest(w, r)
+	if !ok {
+		return
+	}
+	h.changeMessage(w, r, userID, req, func(client *imapclient.Client, seqSet *imap.SeqSet) error {
+		for _, change := range []struct {
+			op    imap.FlagsOp
+			flags []string
+		}{{imap.AddFlags, req.AddFlags}, {imap.RemoveFlags, req.RemoveFlags}} {
+			if len(change.flags) == 0 {
+				continue
+			}
+			flags := make([]interface{}, len(change.flags))
+			for i, flag := range change.flags {
+				flags[i] = flag
+			}
+			if err := client.UidStore(seqSet, imap.FormatFlagsOp(change.op, true), flags, nil); err != nil {
+				return fmt.Errorf("failed to store flags: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// MoveIMAPMessage moves a message to to_folder.
+func (h *TestHandler) MoveIMAPMessage(w http.ResponseWriter, r *http.Request) {
+	userID, req, ok := h.parseMessageRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.ToFolder == "" {
+		http.Error(w, "to_folder is required", http.StatusBadRequest)
+		return
+	}
+	h.changeMessage(w, r, userID, req, func(client *imapclient.Client, seqSet *imap.SeqSet) error {
+		if err := client.UidMove(seqSet, req.ToFolder); err != nil {
+			return fmt.Errorf("failed to move message: %w", err)
+		}
+		return nil
+	})
+}
+
+// DeleteIMAPMessage deletes a message and expunges it.
+func (h *TestHandler) DeleteIMAPMessage(w http.ResponseWriter, r *http.Request) {
+	userID, req, ok := h.parseMessageRequest(w, r)
+	if !ok {
+		return
+	}
+	h.changeMessage(w, r, userID, req, func(client *imapclient.Client, seqSet *imap.SeqSet) error {
+		item := imap.FormatFlagsOp(imap.AddFlags, true)
+		if err := client.UidStore(seqSet, item, []interface{}{imap.DeletedFlag}, nil); err != nil {
+			return fmt.Errorf("failed to mark message as deleted: %w", err)
+		}
+		if err := client.Expunge(nil); err != nil {
+			return fmt.Errorf("failed to expunge message: %w", err)
+		}
+		return nil
+	})
+}
+
+// Sync syncs a folder right away, for example, {"folder": "INBOX", "full": true}.
+// Unlike the syncs of the other endpoints, it returns 500 if the sync fails.
+func (h *TestHandler) Sync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	var req syncRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.Folder == "" {
+		req.Folder = "INBOX"
+	}
+
+	if req.Full {
+		if err := db.ResetFolderLastSyncedUID(ctx, h.pool, userID, req.Folder); err != nil {
+			slog.ErrorContext(ctx, "TestHandler: Failed to reset sync state", "folder", req.Folder, "error", err)
+			http.Error(w, "failed to reset sync state", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := h.imapService.SyncThreadsForFolder(ctx, userID, req.Folder); err != nil {
+		slog.ErrorContext(ctx, "TestHandler: Failed to sync folder", "folder", req.Folder, "error", err)
+		http.Error(w, "failed to sync folder", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseMessageRequest checks the method and the user, and parses the request body of the endpoints that change
+// a message. It writes the error response and returns false if any of them is wrong.
+func (h *TestHandler) parseMessageRequest(w http.ResponseWriter, r *http.Request) (string, *imapMessageRequest, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return "", nil, false
+	}
+
+	userID, ok := GetUserIDFromContext(r.Context(), w, h.pool)
+	if !ok {
+		return "", nil, false
+	}
+
+	var req imapMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return "", nil, false
+	}
+	if req.Folder == "" {
+		req.Folder = "INBOX"
+	}
+	if req.MessageID == "" {
+		http.Error(w, "message_id is required", http.StatusBadRequest)
+		return "", nil, false
+	}
+	return userID, &req, true
+}
+
+// changeMessage finds the message of the request on the IMAP server, calls change with its folder selected,
+// and then syncs the folders that the change touched.
+func (h *TestHandler) changeMessage(w http.ResponseWriter, r *http.Request, userID string, req *imapMessageRequest, change func(*imapclient.Client, *imap.SeqSet) error) {
+	ctx := r.Context()
+
+	client, err := h.connectToIMAP(ctx, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = client.Logout()
+	}()
+
+	seqSet, err := findTestMessage(client, req.Folder, req.MessageID)
+	if errors.Is(err, errTestMessageNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "TestHandler: Failed to find message", "folder", req.Folder, "error", err)
+		http.Error(w, "failed to find message", http.StatusInternalServerError)
+		return
+	}
+
+	if err := change(client, seqSet); err != nil {
+		slog.ErrorContext(ctx, "TestHandler: Failed to change message", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// A full sync picks up flag changes and deletions even without CONDSTORE
+	if err := db.ResetFolderLastSyncedUID(ctx, h.pool, userID, req.Folder); err != nil {
+		slog.ErrorContext(ctx, "TestHandler: Failed to reset sync state", "folder", req.Folder, "error", err)
+	}
+	h.syncFolder(ctx, userID, req.Folder)
+	if req.ToFolder != "" {
+		h.syncFolder(ctx, userID, req.ToFolder)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// findTestMessage selects the folder and returns the UIDs of the messages with the Message-ID.
+func findTestMessage(client *imapclient.Client, folder, messageID string) (*imap.SeqSet, error) {
+	if _, err := client.Select(folder, false); err != nil {
+		return nil, fmt.Errorf("failed to select folder %s: %w", folder, err)
+	}
+	criteria := imap.NewSearchCriteria()
+	criteria.Header.Add("Message-ID", messageID)
+	uids, err := client.UidSearch(criteria)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for message: %w", err)
+	}
+	if len(uids) == 0 {
+		return nil, errTestMessageNotFound
+	}
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uids...)
+	return seqSet, nil
+}
+
 // parseRequest parses and validates the request body.
 func (h *TestHandler) parseRequest(r *http.Request) (*addIMAPMessageRequest, error) {
 	var req addIMAPMessageRequest
@@ -100,6 +329,16 @@ func (h *TestHandler) parseRequest(r *http.Request) (*addIMAPMessageRequest, err
 		return nil, fmt.Errorf("subject, from, and to are required")
 	}
 
+	if req.MessageID == "" {
+		req.MessageID = fmt.Sprintf("<e2e-%d@vmail.local>", time.Now().UnixNano())
+	}
+	if req.Text == "" && req.HTML == "" {
+		req.Text = "E2E test message.\n"
+	}
+	if req.Flags == nil {
+		req.Flags = []string{imap.SeenFlag}
+	}
+
 	return &req, nil
 }
 
@@ -107,26 +346,24 @@ func (h *TestHandler) parseRequest(r *http.Request) (*addIMAPMessageRequest, err
 func (h *TestHandler) connectToIMAP(ctx context.Context, userID string) (*imapclient.Client, error) {
 	settings, err := db.GetUserSettings(ctx, h.pool, userID)
 	if err != nil {
-		log.Printf("TestHandler: failed to get user settings: %v", err)
+		slog.ErrorContext(ctx, "TestHandler: Failed to get user settings", "error", err)
 		return nil, fmt.Errorf("failed to get user settings")
 	}
 
 	imapPassword, err := h.encryptor.Decrypt(settings.EncryptedIMAPPassword)
 	if err != nil {
-		log.Printf("TestHandler: failed to decrypt IMAP password: %v", err)
+		slog.ErrorContext(ctx, "TestHandler: Failed to decrypt IMAP password", "error", err)
 		return nil, fmt.Errorf("failed to decrypt IMAP password")
 	}
 
-	useTLS := os.Getenv("VMAIL_TEST_MODE") != "true"
-
-	client, err := imapinternal.ConnectToIMAP(settings.IMAPServerHostname, useTLS)
+	client, err := imapinternal.ConnectToIMAP(imapinternal.ServerFromSettings(settings))
 	if err != nil {
-		log.Printf("TestHandler: failed to connect to IMAP server: %v", err)
+		slog.ErrorContext(ctx, "TestHandler: Failed to connect to IMAP server", "error", err)
 		return nil, fmt.Errorf("failed to connect to IMAP server")
 	}
 
 	if err := imapinternal.Login(client, settings.IMAPUsername, imapPassword); err != nil {
-		log.Printf("TestHandler: failed to login to IMAP server: %v", err)
+		slog.ErrorContext(ctx, "TestHandler: Failed to login to IMAP server", "error", err)
 		_ = client.Logout()
 		return nil, fmt.Errorf("failed to login to IMAP server")
 	}
@@ -134,55 +371,59 @@ func (h *TestHandler) connectToIMAP(ctx context.Context, userID string) (*imapcl
 	return client, nil
 }
 
-// appendMessage appends a message to the specified IMAP folder.
-func (h *TestHandler) appendMessage(client *imapclient.Client, req *addIMAPMessageRequest) error {
-	// Select the folder.
-	if _, err := client.Select(req.Folder, false); err != nil {
-		log.Printf("TestHandler: failed to select folder %s: %v", req.Folder, err)
-		return fmt.Errorf("failed to select IMAP folder")
-	}
-
-	// Construct a simple RFC 822 message.
-	messageID := fmt.Sprintf("<e2e-%d@vmail.local>", time.Now().UnixNano())
-	now := time.Now()
-	messageBody := fmt.Sprintf(`Message-ID: %s
-Date: %s
-From: %s
-To: %s
-Subject: %s
-Content-Type: text/plain; charset=utf-8
-
-E2E test message.
-`, messageID, now.Format(time.RFC1123Z), req.From, req.To, req.Subject)
-
-	flags := []string{imap.SeenFlag}
-	if err := client.Append(req.Folder, flags, now, strings.NewReader(messageBody)); err != nil {
-		log.Printf("TestHandler: failed to append message: %v", err)
+// appendMessage appends the raw message to the folder of the request, with its flags.
+func (h *TestHandler) appendMessage(client *imapclient.Client, req *addIMAPMessageRequest, raw []byte) error {
+	if err := client.Append(req.Folder, req.Flags, time.Now(), bytes.NewReader(raw)); err != nil {
+		slog.Error("TestHandler: Failed to append message", "folder", req.Folder, "error", err)
 		return fmt.Errorf("failed to append message to IMAP folder")
 	}
 
 	return nil
 }
 
-// syncAndNotify syncs the folder and sends a WebSocket notification.
-func (h *TestHandler) syncAndNotify(ctx context.Context, userID, folder string) {
-	if err := h.imapService.SyncThreadsForFolder(ctx, userID, folder); err != nil {
-		log.Printf("TestHandler: failed to sync folder %s for user %s: %v", folder, userID, err)
-		return
+// buildTestMessage builds the RFC 822 message of the request.
+func buildTestMessage(req *addIMAPMessageRequest, date time.Time) ([]byte, error) {
+	from, err := mail.ParseAddress(req.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address")
+	}
+	to, err := mail.ParseAddressList(req.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid to address")
 	}
 
-	msg := struct {
-		Type   string `json:"type"`
-		Folder string `json:"folder"`
-	}{
-		Type:   "new_email",
-		Folder: folder,
+	message := &mime.Message{
+		From:       *from,
+		Subject:    req.Subject,
+		Date:       date,
+		MessageID:  req.MessageID,
+		InReplyTo:  req.InReplyTo,
+		References: req.InReplyTo,
+		TextBody:   req.Text,
+		HTMLBody:   req.HTML,
 	}
-	payload, err := json.Marshal(msg)
+	for _, a := range to {
+		message.To = append(message.To, *a)
+	}
+	for _, attachment := range req.Attachments {
+		message.Attachments = append(message.Attachments, mime.Attachment{
+			Filename:    attachment.Filename,
+			ContentType: attachment.ContentType,
+			ContentID:   attachment.ContentID,
+			Data:        attachment.Data,
+		})
+	}
+
+	raw, err := message.Bytes()
 	if err != nil {
-		log.Printf("TestHandler: failed to marshal new_email message: %v", err)
-		return
+		return nil, fmt.Errorf("failed to build message: %w", err)
 	}
+	return raw, nil
+}
 
-	h.hub.Send(userID, payload)
+// syncFolder syncs the folder, which sends a new_message WebSocket event to the user's clients.
+func (h *TestHandler) syncFolder(ctx context.Context, userID, folder string) {
+	if err := h.imapService.SyncThreadsForFolder(ctx, userID, folder); err != nil {
+		slog.ErrorContext(ctx, "TestHandler: Failed to sync folder", "folder", folder, "error", err)
+	}
 }
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestBuildTestMessage(t *testing.T) {
+	req := &addIMAPMessageRequest{
+		Subject:   "Report",
+		From:      "Alice <alice@example.com>",
+		To:        "me@example.com",
+		MessageID: "<report@example.com>",
+		InReplyTo: "<question@example.com>",
+		Text:      "See attached.",
+		Attachments: []models.OutgoingAttachment{
+			{Filename: "report.txt", ContentType: "text/plain", Data: []byte("numbers")},
+		},
+	}
+
+	raw, err := buildTestMessage(req, time.Now())
+	if err != nil {
+		t.Fatalf("buildTestMessage failed: %v", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+
+	if got := msg.Header.Get("Message-ID"); got != req.MessageID {
+		t.Errorf("Expected Message-ID %s, got %s", req.MessageID, got)
+	}
+	if got := msg.Header.Get("In-Reply-To"); got != req.InReplyTo {
+		t.Errorf("Expected In-Reply-To %s, got %s", req.InReplyTo, got)
+	}
+	if got := msg.Header.Get("Content-Type"); !strings.HasPrefix(got, "multipart/mixed") {
+		t.Errorf("Expected a multipart/mixed message, got %s", got)
+	}
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		t.Fatalf("Failed to read body: %v", err)
+	}
+	if !bytes.Contains(body, []byte("report.txt")) {
+		t.Errorf("Expected the attachment in the body, got %s", body)
+	}
+
+	t.Run("rejects invalid addresses", func(t *testing.T) {
+		if _, err := buildTestMessage(&addIMAPMessageRequest{From: "not an address", To: "me@example.com"}, time.Now()); err == nil {
+			t.Error("Expected an error for the invalid from address")
+		}
+	})
+}
+
+func TestFindTestMessage(t *testing.T) {
+	server := testutil.NewTestIMAPServer(t)
+	defer server.Close()
+	server.EnsureINBOX(t)
+
+	uid := server.AddMessage(t, "INBOX", "<find-me@example.com>", "Find me", "alice@example.com", "me@example.com", time.Now())
+	client, cleanup := server.Connect(t)
+	defer cleanup()
+
+	t.Run("finds the message by Message-ID", func(t *testing.T) {
+		seqSet, err := findTestMessage(client, "INBOX", "<find-me@example.com>")
+		if err != nil {
+			t.Fatalf("findTestMessage failed: %v", err)
+		}
+		if !seqSet.Contains(uid) {
+			t.Errorf("Expected UID %d, got %v", uid, seqSet)
+		}
+	})
+
+	t.Run("returns errTestMessageNotFound for unknown messages", func(t *testing.T) {
+		if _, err := findTestMessage(client, "INBOX", "<missing@example.com>"); !errors.Is(err, errTestMessageNotFound) {
+			t.Errorf("Expected errTestMessageNotFound, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/export"
+	"github.com/vdavid/vmail/backend/internal/imap"
+)
+
+// ThreadExportHandler serves the original sources of a thread's messages as one file, for archiving a conversation
+// or opening it in another mail client.
+type ThreadExportHandler struct {
+	pool    *pgxpool.Pool
+	fetcher imap.RawMessageFetcher
+}
+
+// NewThreadExportHandler creates a new ThreadExportHandler instance.
+func NewThreadExportHandler(pool *pgxpool.Pool, fetcher imap.RawMessageFetcher) *ThreadExportHandler {
+	return &ThreadExportHandler{
+		pool:    pool,
+		fetcher: fetcher,
+	}
+}
+
+// ExportThread fetches the RFC 822 sources of the thread's messages from the IMAP server, and streams them as an
+// mbox file (format=mbox, the default) or a zip file with an .eml file per message (format=eml).
+// Messages that are no longer on the server are left out. Messages over the fetch size limit are cut off, like in
+// GetRawMessage. Once the first message is sent, errors can only cut the download short, so we log them.
+// The path is /api/v1/thread/{thread_id}/export.
+func (h *ThreadExportHandler) ExportThread(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	stableThreadID, err := getStableThreadIDFromPath(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = export.ThreadFormatMbox
+	}
+	var contentType, extension string
+	switch format {
+	case export.ThreadFormatMbox:
+		contentType, extension = "application/mbox", ".mbox"
+	case export.ThreadFormatEML:
+		contentType, extension = "application/zip", ".zip"
+	default:
+		http.Error(w, "format must be mbox or eml", http.StatusBadRequest)
+		return
+	}
+
+	thread, err := db.GetThreadByStableID(ctx, h.pool, userID, stableThreadID)
+	if err != nil {
+		if errors.Is(err, db.ErrThreadNotFound) {
+			http.Error(w, "Thread not found", http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "ThreadExportHandler: Failed to get thread", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	locations, err := db.GetThreadMessageLocations(ctx, h.pool, thread.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadExportHandler: Failed to get messages", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var tw *export.ThreadWriter
+	for _, location := range locations {
+		raw, err := h.fetcher.FetchRawMessage(ctx, userID, location.FolderName, location.IMAPUID)
+		if errors.Is(err, imap.ErrMessageNotFound) {
+			continue
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "ThreadExportHandler: Failed to fetch message", "folder", location.FolderName,
+				"uid", location.IMAPUID, "error", err)
+			if tw == nil {
+				http.Error(w, "Failed to fetch the thread from the mail server", http.StatusBadGateway)
+			}
+			return
+		}
+		source, err := io.ReadAll(raw.Body)
+		if err != nil {
+			slog.ErrorContext(ctx, "ThreadExportHandler: Failed to read message", "error", err)
+			if tw == nil {
+				http.Error(w, "Failed to fetch the thread from the mail server", http.StatusBadGateway)
+			}
+			return
+		}
+
+		if tw == nil {
+			filename := thread.Subject
+			if filename == "" {
+				filename = "thread"
+			}
+			writeDownloadHeaders(w, filename+extension, contentType, false)
+			if tw, err = export.NewThreadWriter(w, format); err != nil {
+				slog.ErrorContext(ctx, "ThreadExportHandler: Failed to start export", "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		date := time.Unix(0, 0).UTC()
+		if location.SentAt != nil {
+			date = *location.SentAt
+		}
+		if err := tw.Add(location.FromAddress, date, source); err != nil {
+			slog.WarnContext(ctx, "ThreadExportHandler: Failed to write message", "error", err)
+			return
+		}
+	}
+
+	if tw == nil {
+		http.Error(w, "No messages of the thread are on the mail server", http.StatusNotFound)
+		return
+	}
+	if err := tw.Close(); err != nil {
+		slog.WarnContext(ctx, "ThreadExportHandler: Failed to finish export", "error", err)
+	}
+}
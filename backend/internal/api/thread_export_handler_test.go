@@ -0,0 +1,96 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestThreadExportHandler_ExportThread(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	email := "thread-export-test@example.com"
+	userID := setupTestUserAndSettings(t, pool, getTestEncryptor(t), email)
+
+	stableThreadID := "<export-thread@example.com>"
+	thread := &models.Thread{UserID: userID, StableThreadID: stableThreadID, Subject: "Plans"}
+	if err := db.SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+	sentAt := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	for uid := int64(1); uid <= 3; uid++ {
+		msg := &models.Message{ThreadID: thread.ID, UserID: userID, IMAPUID: uid, IMAPFolderName: "INBOX",
+			MessageIDHeader: fmt.Sprintf("<export-%d@example.com>", uid), FromAddress: "Alice <alice@example.com>",
+			SentAt: &sentAt}
+		if err := db.SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+	}
+
+	// Message 3 is gone from the server
+	fetcher := &mockRawMessageFetcher{sources: map[int64]string{
+		1: "Subject: Plans\r\n\r\nFrom now on, Mondays.\r\n",
+		2: "Subject: Re: Plans\r\n\r\nSounds good.\r\n",
+	}}
+	handler := NewThreadExportHandler(pool, fetcher)
+	get := func(threadID, query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		path := "/api/v1/thread/" + url.PathEscape(threadID) + "/export" + query
+		handler.ExportThread(rr, createRequestWithUser("GET", path, email))
+		return rr
+	}
+
+	t.Run("exports an mbox file by default", func(t *testing.T) {
+		rr := get(stableThreadID, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if rr.Header().Get("Content-Type") != "application/mbox" ||
+			!strings.Contains(rr.Header().Get("Content-Disposition"), "Plans.mbox") {
+			t.Errorf("Unexpected headers: %v", rr.Header())
+		}
+		mbox := rr.Body.String()
+		if strings.Count(mbox, "From alice@example.com ") != 2 || !strings.Contains(mbox, "\n>From now on") {
+			t.Errorf("Expected both messages on the server in the mbox file, got:\n%s", mbox)
+		}
+	})
+
+	t.Run("exports a zip file of .eml files", func(t *testing.T) {
+		rr := get(stableThreadID, "?format=eml")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+		if err != nil {
+			t.Fatalf("Failed to read zip file: %v", err)
+		}
+		if len(zr.File) != 2 {
+			t.Errorf("Expected 2 .eml files, got %d", len(zr.File))
+		}
+	})
+
+	t.Run("returns 400 for unknown formats", func(t *testing.T) {
+		if rr := get(stableThreadID, "?format=pdf"); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("returns 404 for unknown threads", func(t *testing.T) {
+		if rr := get("<unknown@example.com>", ""); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rr.Code)
+		}
+	})
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/mail"
 	"net/url"
+	"slices"
 	"strings"
 
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -14,6 +16,7 @@ import (
 	"github.com/vdavid/vmail/backend/internal/db"
 	"github.com/vdavid/vmail/backend/internal/imap"
 	"github.com/vdavid/vmail/backend/internal/models"
+	ws "github.com/vdavid/vmail/backend/internal/websocket"
 )
 
 // ThreadHandler handles individual thread-related API requests.
@@ -21,14 +24,17 @@ type ThreadHandler struct {
 	pool        *pgxpool.Pool
 	encryptor   *crypto.Encryptor // Not used directly, but required by imapService
 	imapService imap.IMAPService
+	hub         *ws.Hub
 }
 
 // NewThreadHandler creates a new ThreadHandler instance.
-func NewThreadHandler(pool *pgxpool.Pool, encryptor *crypto.Encryptor, imapService imap.IMAPService) *ThreadHandler {
+// The hub is used to tell clients when a thread changes, for example, when the user moves it. It can be nil.
+func NewThreadHandler(pool *pgxpool.Pool, encryptor *crypto.Encryptor, imapService imap.IMAPService, hub *ws.Hub) *ThreadHandler {
 	return &ThreadHandler{
 		pool:        pool,
 		encryptor:   encryptor,
 		imapService: imapService,
+		hub:         hub,
 	}
 }
 
@@ -74,9 +80,9 @@ func (h *ThreadHandler) syncMissingBodies(ctx context.Context, userID string, me
 		return
 	}
 
-	log.Printf("ThreadHandler: Syncing %d message bodies in batch", len(messagesToSync))
+	slog.InfoContext(ctx, "ThreadHandler: Syncing message bodies in batch", "count", len(messagesToSync))
 	if err := h.imapService.SyncFullMessages(ctx, userID, messagesToSync); err != nil {
-		log.Printf("ThreadHandler: Failed to batch sync message bodies: %v", err)
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to batch sync message bodies", "error", err)
 		return
 	}
 
@@ -94,7 +100,7 @@ func (h *ThreadHandler) syncMissingBodies(ctx context.Context, userID string, me
 		}
 	}
 	if len(failedMessages) > 0 {
-		log.Printf("ThreadHandler: Warning: %d message(s) couldn't be refreshed after sync: %v", len(failedMessages), failedMessages)
+		slog.WarnContext(ctx, "ThreadHandler: Messages couldn't be refreshed after sync", "count", len(failedMessages), "messages", failedMessages)
 	}
 }
 
@@ -118,6 +124,66 @@ func assignAttachments(messages []*models.Message, attachmentsMap map[string][]*
 	}
 }
 
+// assignPlusAliasLabels sets the label of the plus alias that each message was sent to, if any.
+// labels maps lowercase alias addresses to their labels.
+func assignPlusAliasLabels(messages []models.Message, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	for i := range messages {
+		for _, recipient := range slices.Concat(messages[i].ToAddresses, messages[i].CCAddresses) {
+			if label, ok := labels[bareAddress(recipient)]; ok {
+				messages[i].PlusAliasLabel = label
+				break
+			}
+		}
+	}
+}
+
+// assignCalendarEvents sets the invite event of each message that has one. events maps message IDs to their events.
+func assignCalendarEvents(messages []models.Message, events map[string]*models.CalendarEvent) {
+	for i := range messages {
+		messages[i].CalendarEvent = events[messages[i].ID]
+	}
+}
+
+// assignLinkAnalyses sets the link analysis of each message that has one. analyses maps message IDs to them.
+func assignLinkAnalyses(messages []models.Message, analyses map[string]*models.LinkAnalysis) {
+	for i := range messages {
+		messages[i].LinkAnalysis = analyses[messages[i].ID]
+	}
+}
+
+// assignAuthenticity sets the sender checks of each message that has them. authenticity maps message IDs to them.
+func assignAuthenticity(messages []models.Message, authenticity map[string]*models.Authenticity) {
+	for i := range messages {
+		messages[i].Authenticity = authenticity[messages[i].ID]
+	}
+}
+
+// assignMessageLabels sets the labels of each message. labels maps message IDs to their labels.
+func assignMessageLabels(messages []models.Message, labels map[string][]string) {
+	for i := range messages {
+		messages[i].Labels = labels[messages[i].ID]
+	}
+}
+
+// assignRemoteImagesAllowed allows remote images in the messages whose sender is trusted.
+// trusted holds lowercase addresses.
+func assignRemoteImagesAllowed(messages []models.Message, trusted map[string]bool) {
+	for i := range messages {
+		messages[i].RemoteImagesAllowed = trusted[bareAddress(messages[i].FromAddress)]
+	}
+}
+
+// bareAddress returns the lowercase address of "name@example.com" or "Name <name@example.com>".
+func bareAddress(address string) string {
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		address = parsed.Address
+	}
+	return strings.ToLower(address)
+}
+
 // convertMessagesToThreadMessages converts []*Message to []Message for the response.
 // Ensures that Attachments is always an array, never nil, and filters out nil messages.
 func convertMessagesToThreadMessages(messages []*models.Message) []models.Message {
@@ -134,7 +200,27 @@ func convertMessagesToThreadMessages(messages []*models.Message) []models.Messag
 	return threadMessages
 }
 
+// getDraftsForThread returns the user's drafts that reply to messages in the thread.
+// If fetching the drafts fails, it logs the error and returns no drafts rather than failing the request.
+func (h *ThreadHandler) getDraftsForThread(ctx context.Context, userID string, messages []*models.Message) []*models.Draft {
+	messageIDHeaders := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		if msg.MessageIDHeader != "" {
+			messageIDHeaders = append(messageIDHeaders, msg.MessageIDHeader)
+		}
+	}
+
+	drafts, err := db.GetDraftsInReplyTo(ctx, h.pool, userID, messageIDHeaders)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get drafts", "error", err)
+		return nil
+	}
+	return drafts
+}
+
 // GetThread returns a single email thread with all its messages.
+// Mega-threads come in segments: the newest messages first, and the "segment" query parameter asks for the
+// segment before the one with that older_cursor.
 func (h *ThreadHandler) GetThread(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -150,6 +236,45 @@ func (h *ThreadHandler) GetThread(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var before *db.ThreadSegmentPosition
+	if cursor := r.URL.Query().Get("segment"); cursor != "" {
+		position, err := db.DecodeThreadSegmentCursor(cursor)
+		if err != nil {
+			http.Error(w, "Invalid segment cursor", http.StatusBadRequest)
+			return
+		}
+		before = &position
+	}
+
+	h.writeThread(ctx, w, userID, stableThreadID, before)
+}
+
+// getThreadMessages returns the messages of the thread, and sets its message counts.
+// If the thread is a mega-thread, or before is set, it only returns a segment, and sets thread.Segment.
+func (h *ThreadHandler) getThreadMessages(ctx context.Context, thread *models.Thread, before *db.ThreadSegmentPosition) ([]*models.Message, error) {
+	// Count the whole thread, so that the counts are the same in every segment
+	total, unread, err := db.GetThreadMessageCounts(ctx, h.pool, thread.ID)
+	if err != nil {
+		return nil, err
+	}
+	thread.MessageCount = total
+	thread.UnreadCount = unread
+
+	if before == nil && total <= db.MegaThreadThreshold {
+		return db.GetMessagesForThread(ctx, h.pool, thread.ID)
+	}
+
+	messages, hasOlder, err := db.GetThreadSegment(ctx, h.pool, thread.ID, before, db.ThreadSegmentSize)
+	if err != nil {
+		return nil, err
+	}
+	thread.Segment = db.NewThreadSegment(messages, hasOlder)
+	return messages, nil
+}
+
+// writeThread loads the thread with its messages, syncing missing bodies, and writes it as the response.
+// before is the position of the segment to load, or nil for the whole thread, or the newest segment of a mega-thread.
+func (h *ThreadHandler) writeThread(ctx context.Context, w http.ResponseWriter, userID, stableThreadID string, before *db.ThreadSegmentPosition) {
 	// Get thread from the database
 	thread, err := db.GetThreadByStableID(ctx, h.pool, userID, stableThreadID)
 	if err != nil {
@@ -157,15 +282,15 @@ func (h *ThreadHandler) GetThread(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Thread not found", http.StatusNotFound)
 			return
 		}
-		log.Printf("ThreadHandler: Failed to get thread: %v", err)
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get thread", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
 	// Get messages for thread
-	messages, err := db.GetMessagesForThread(ctx, h.pool, thread.ID)
+	messages, err := h.getThreadMessages(ctx, thread, before)
 	if err != nil {
-		log.Printf("ThreadHandler: Failed to get messages: %v", err)
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get messages", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
@@ -185,7 +310,7 @@ func (h *ThreadHandler) GetThread(w http.ResponseWriter, r *http.Request) {
 	// If fetching attachments fails, continue with empty attachments rather than failing the request.
 	attachmentsMap, err := db.GetAttachmentsForMessages(ctx, h.pool, messageIDs)
 	if err != nil {
-		log.Printf("ThreadHandler: Failed to get attachments: %v", err)
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get attachments", "error", err)
 		attachmentsMap = make(map[string][]*models.Attachment)
 	}
 
@@ -193,9 +318,71 @@ func (h *ThreadHandler) GetThread(w http.ResponseWriter, r *http.Request) {
 	messagesToSync, messageUIDToIndex := collectMessagesToSync(messages)
 	h.syncMissingBodies(ctx, userID, messages, messagesToSync, messageUIDToIndex)
 
+	// Keep the bodies of threads that the user reads in the body cache longer.
+	// If it fails, the bodies may be evicted sooner, and opening the thread fetches them again.
+	if err := db.TouchMessageBodies(ctx, h.pool, messageIDs); err != nil {
+		slog.WarnContext(ctx, "ThreadHandler: Failed to record message body access", "error", err)
+	}
+
 	// Assign attachments and convert messages
 	assignAttachments(messages, attachmentsMap)
 	thread.Messages = convertMessagesToThreadMessages(messages)
+	thread.Drafts = h.getDraftsForThread(ctx, userID, messages)
+
+	// Flag messages sent to plus aliases, so that the user sees who leaked the address.
+	// If it fails, the thread is still useful without the labels.
+	aliasLabels, err := db.GetPlusAliasLabels(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get plus alias labels", "error", err)
+	} else {
+		assignPlusAliasLabels(thread.Messages, aliasLabels)
+	}
+
+	// Let the front end show remote images of trusted senders right away.
+	// If it fails, images stay hidden until the user asks for them.
+	trustedSenders, err := db.GetTrustedSenderEmails(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get trusted senders", "error", err)
+	} else {
+		assignRemoteImagesAllowed(thread.Messages, trustedSenders)
+	}
+
+	// The thread's labels cover all its messages, even the ones outside this segment.
+	// If it fails, the thread is still useful without the labels.
+	messageLabels, err := db.GetLabelsForMessages(ctx, h.pool, messageIDs)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get message labels", "error", err)
+	} else {
+		assignMessageLabels(thread.Messages, messageLabels)
+	}
+	thread.Labels, err = db.GetThreadLabels(ctx, h.pool, thread.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get thread labels", "error", err)
+	}
+
+	// Let the front end show RSVP buttons for invites. We get the events after syncing the bodies, since the
+	// events come from them. If it fails, invites show as plain messages.
+	calendarEvents, err := db.GetCalendarEventsForMessages(ctx, h.pool, messageIDs)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get calendar events", "error", err)
+	} else {
+		assignCalendarEvents(thread.Messages, calendarEvents)
+	}
+
+	// Phishing warnings are a hint, so the thread shows without them if this fails
+	linkAnalyses, err := db.GetLinkAnalysesForMessages(ctx, h.pool, messageIDs)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get link analyses", "error", err)
+	} else {
+		assignLinkAnalyses(thread.Messages, linkAnalyses)
+	}
+
+	authenticity, err := db.GetAuthenticityForMessages(ctx, h.pool, messageIDs)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get authenticity", "error", err)
+	} else {
+		assignAuthenticity(thread.Messages, authenticity)
+	}
 
 	if !WriteJSONResponse(w, thread) {
 		return
@@ -23,9 +23,9 @@ func TestThreadHandler_GetThread(t *testing.T) {
 	defer pool.Close()
 
 	encryptor := getTestEncryptor(t)
-	imapService := imap.NewService(pool, imap.NewPool(), encryptor)
+	imapService := imap.NewService(pool, imap.NewPool(), encryptor, nil)
 	defer imapService.Close()
-	handler := NewThreadHandler(pool, encryptor, imapService)
+	handler := NewThreadHandler(pool, encryptor, imapService, nil)
 
 	t.Run("returns 401 when no user email in context", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/api/v1/thread/test-thread-id", nil)
@@ -81,15 +81,13 @@ func TestThreadHandler_GetThread(t *testing.T) {
 		encryptedSMTPPassword, _ := encryptor.Encrypt("smtp_pass")
 
 		settings := &models.UserSettings{
-			UserID:                   userID,
-			UndoSendDelaySeconds:     20,
-			PaginationThreadsPerPage: 100,
-			IMAPServerHostname:       "imap.test.com",
-			IMAPUsername:             "user",
-			EncryptedIMAPPassword:    encryptedIMAPPassword,
-			SMTPServerHostname:       "smtp.test.com",
-			SMTPUsername:             "user",
-			EncryptedSMTPPassword:    encryptedSMTPPassword,
+			UserID:                userID,
+			IMAPServerHostname:    "imap.test.com",
+			IMAPUsername:          "user",
+			EncryptedIMAPPassword: encryptedIMAPPassword,
+			SMTPServerHostname:    "smtp.test.com",
+			SMTPUsername:          "user",
+			EncryptedSMTPPassword: encryptedSMTPPassword,
 		}
 		if err := db.SaveUserSettings(ctx, pool, settings); err != nil {
 			t.Fatalf("Failed to save settings: %v", err)
@@ -173,15 +171,13 @@ func TestThreadHandler_GetThread(t *testing.T) {
 		encryptedSMTPPassword, _ := encryptor.Encrypt("smtp_pass")
 
 		settings := &models.UserSettings{
-			UserID:                   userID,
-			UndoSendDelaySeconds:     20,
-			PaginationThreadsPerPage: 100,
-			IMAPServerHostname:       "imap.test.com",
-			IMAPUsername:             "user",
-			EncryptedIMAPPassword:    encryptedIMAPPassword,
-			SMTPServerHostname:       "smtp.test.com",
-			SMTPUsername:             "user",
-			EncryptedSMTPPassword:    encryptedSMTPPassword,
+			UserID:                userID,
+			IMAPServerHostname:    "imap.test.com",
+			IMAPUsername:          "user",
+			EncryptedIMAPPassword: encryptedIMAPPassword,
+			SMTPServerHostname:    "smtp.test.com",
+			SMTPUsername:          "user",
+			EncryptedSMTPPassword: encryptedSMTPPassword,
 		}
 		if err := db.SaveUserSettings(ctx, pool, settings); err != nil {
 			t.Fatalf("Failed to save settings: %v", err)
@@ -520,6 +516,16 @@ type mockIMAPServiceForThread struct {
 	syncFullMessagesCalled   bool
 	syncFullMessagesMessages []imap.MessageToSync
 	syncFullMessagesErr      error
+	movedMessages            []imap.MessageToMove
+	moveDestination          imap.MoveDestination
+	moveErr                  error
+	labelMessages            []imap.MessageToSync
+	labelAdded               bool
+	labelUnstoredFolder      string
+	labelErr                 error
+	junkMessages             []imap.MessageToSync
+	junk                     bool
+	junkErr                  error
 }
 
 func (m *mockIMAPServiceForThread) ShouldSyncFolder(context.Context, string, string) (bool, error) {
@@ -530,6 +536,40 @@ func (m *mockIMAPServiceForThread) SyncThreadsForFolder(context.Context, string,
 	return nil
 }
 
+func (m *mockIMAPServiceForThread) PreviewFolder(context.Context, string, string, int) ([]*models.Thread, int, error) {
+	return nil, 0, nil
+}
+
+func (m *mockIMAPServiceForThread) AppendToSent(context.Context, string, []byte, time.Time) error {
+	return nil
+}
+
+func (m *mockIMAPServiceForThread) SaveDraft(context.Context, string, []byte, string) error {
+	return nil
+}
+
+func (m *mockIMAPServiceForThread) DeleteDraft(context.Context, string, string) error {
+	return nil
+}
+
+// MoveMessages moves to the fallback folder if there's no folder name, and gives the messages UIDs from 1001.
+func (m *mockIMAPServiceForThread) MoveMessages(_ context.Context, _ string, messages []imap.MessageToMove, destination imap.MoveDestination) (string, []int64, error) {
+	m.movedMessages = messages
+	m.moveDestination = destination
+	if m.moveErr != nil {
+		return "", nil, m.moveErr
+	}
+	folderName := destination.FolderName
+	if folderName == "" {
+		folderName = destination.FallbackFolderName
+	}
+	newUIDs := make([]int64, len(messages))
+	for i := range messages {
+		newUIDs[i] = int64(1001 + i)
+	}
+	return folderName, newUIDs, nil
+}
+
 func (m *mockIMAPServiceForThread) SyncFullMessage(context.Context, string, string, int64) error {
 	return nil
 }
@@ -540,6 +580,30 @@ func (m *mockIMAPServiceForThread) SyncFullMessages(_ context.Context, _ string,
 	return m.syncFullMessagesErr
 }
 
+// StoreLabel stores the label of all messages except the ones in labelUnstoredFolder.
+func (m *mockIMAPServiceForThread) StoreLabel(_ context.Context, _ string, messages []imap.MessageToSync, _ string, add bool) ([]bool, error) {
+	m.labelMessages = messages
+	m.labelAdded = add
+	if m.labelErr != nil {
+		return nil, m.labelErr
+	}
+	stored := make([]bool, len(messages))
+	for i, msg := range messages {
+		stored[i] = msg.FolderName != m.labelUnstoredFolder
+	}
+	return stored, nil
+}
+
+func (m *mockIMAPServiceForThread) MarkJunk(_ context.Context, _ string, messages []imap.MessageToSync, junk bool) error {
+	m.junkMessages = messages
+	m.junk = junk
+	return m.junkErr
+}
+
+func (m *mockIMAPServiceForThread) GetFolders(context.Context, string) ([]*models.Folder, error) {
+	return nil, nil
+}
+
 func (m *mockIMAPServiceForThread) Search(context.Context, string, string, int, int) ([]*models.Thread, int, error) {
 	return nil, 0, nil
 }
@@ -608,7 +672,7 @@ func TestThreadHandler_SyncsMissingBodies(t *testing.T) {
 			syncFullMessagesErr: nil, // Sync succeeds
 		}
 
-		handler := NewThreadHandler(pool, encryptor, mockIMAP)
+		handler := NewThreadHandler(pool, encryptor, mockIMAP, nil)
 
 		req := httptest.NewRequest("GET", "/api/v1/thread/lazy-load-thread", nil)
 		reqCtx := context.WithValue(req.Context(), auth.UserEmailKey, email)
@@ -720,7 +784,7 @@ func TestThreadHandler_SyncsMissingBodies(t *testing.T) {
 			syncFullMessagesErr: nil,
 		}
 
-		handler := NewThreadHandler(pool, encryptor, mockIMAP)
+		handler := NewThreadHandler(pool, encryptor, mockIMAP, nil)
 
 		req := httptest.NewRequest("GET", "/api/v1/thread/thread-with-body", nil)
 		reqCtx := context.WithValue(req.Context(), auth.UserEmailKey, email)
@@ -773,7 +837,7 @@ func TestThreadHandler_SyncsMissingBodies(t *testing.T) {
 			syncFullMessagesErr: fmt.Errorf("IMAP sync failed"),
 		}
 
-		handler := NewThreadHandler(pool, encryptor, mockIMAP)
+		handler := NewThreadHandler(pool, encryptor, mockIMAP, nil)
 
 		req := httptest.NewRequest("GET", "/api/v1/thread/thread-sync-error", nil)
 		reqCtx := context.WithValue(req.Context(), auth.UserEmailKey, email)
@@ -837,7 +901,7 @@ func TestThreadHandler_SyncsMissingBodies(t *testing.T) {
 			syncFullMessagesErr: nil, // Sync succeeds
 		}
 
-		handler := NewThreadHandler(pool, encryptor, mockIMAP)
+		handler := NewThreadHandler(pool, encryptor, mockIMAP, nil)
 
 		req := httptest.NewRequest("GET", "/api/v1/thread/thread-getmessage-error", nil)
 		reqCtx := context.WithValue(req.Context(), auth.UserEmailKey, email)
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/models"
+	ws "github.com/vdavid/vmail/backend/internal/websocket"
+)
+
+// AddThreadLabel adds the label in the request body to all messages of a thread.
+// The path is /api/v1/thread/{thread_id}/labels.
+func (h *ThreadHandler) AddThreadLabel(w http.ResponseWriter, r *http.Request) {
+	var req models.ThreadLabelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBodyError(w, err)
+		return
+	}
+	h.changeThreadLabel(w, r, req.Label, true)
+}
+
+// RemoveThreadLabel removes a label from all messages of a thread.
+// The path is /api/v1/thread/{thread_id}/labels/{label}, with the label URL-encoded.
+func (h *ThreadHandler) RemoveThreadLabel(w http.ResponseWriter, r *http.Request) {
+	label, err := getLabelFromPath(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.changeThreadLabel(w, r, label, false)
+}
+
+// getLabelFromPath extracts the label from a /api/v1/thread/{thread_id}/labels/{label} path.
+func getLabelFromPath(path string) (string, error) {
+	pathParts := strings.SplitN(strings.TrimPrefix(path, "/api/v1/thread/"), "/", 3)
+	if len(pathParts) < 3 || pathParts[1] != "labels" || pathParts[2] == "" {
+		return "", errors.New("label is required")
+	}
+	label, err := url.PathUnescape(pathParts[2])
+	if err != nil {
+		return "", errors.New("invalid label encoding")
+	}
+	return label, nil
+}
+
+// changeThreadLabel stores the label change on the IMAP server, and then updates the cache.
+// Messages in folders that don't allow new keywords keep the label only in the cache.
+func (h *ThreadHandler) changeThreadLabel(w http.ResponseWriter, r *http.Request, label string, add bool) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	stableThreadID, err := getStableThreadIDFromPath(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !imap.IsValidLabel(label) {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid label",
+			Fields: map[string]string{"label": "must be 1 to 64 printable characters without spaces or ( ) { % * \" \\ ]"},
+		})
+		return
+	}
+	label = imap.CanonicalLabel(label)
+
+	thread, err := db.GetThreadByStableID(ctx, h.pool, userID, stableThreadID)
+	if err != nil {
+		if errors.Is(err, db.ErrThreadNotFound) {
+			http.Error(w, "Thread not found", http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get thread", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	messages, err := db.GetMessagesForThread(ctx, h.pool, thread.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get messages", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	messagesToStore := make([]imap.MessageToSync, len(messages))
+	for i, msg := range messages {
+		messagesToStore[i] = imap.MessageToSync{FolderName: msg.IMAPFolderName, IMAPUID: msg.IMAPUID}
+	}
+	stored, err := h.imapService.StoreLabel(ctx, userID, messagesToStore, label, add)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to store label", "error", err)
+		http.Error(w, "Failed to change the label on the mail server", http.StatusBadGateway)
+		return
+	}
+
+	savedToServer, err := h.saveLabelChange(ctx, messages, stored, label, add)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to save label", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	labels, err := db.GetThreadLabels(ctx, h.pool, thread.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get thread labels", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.hub.Publish(userID, ws.Event{Type: ws.EventThreadUpdated, ThreadID: stableThreadID, Count: len(messages)})
+
+	WriteJSONResponse(w, models.ThreadLabelsResponse{Labels: labels, SavedToServer: savedToServer})
+}
+
+// saveLabelChange updates the labels in the cache. stored tells for each message whether the server keeps the label.
+// Removing a label removes it from all messages, since a folder that doesn't keep labels has only local ones.
+// Returns whether the server has the change for all messages.
+func (h *ThreadHandler) saveLabelChange(ctx context.Context, messages []*models.Message, stored []bool, label string, add bool) (bool, error) {
+	var storedIDs, localIDs []string
+	for i, msg := range messages {
+		if stored[i] {
+			storedIDs = append(storedIDs, msg.ID)
+		} else {
+			localIDs = append(localIDs, msg.ID)
+		}
+	}
+
+	if !add {
+		return len(localIDs) == 0, db.RemoveMessageLabel(ctx, h.pool, append(storedIDs, localIDs...), label)
+	}
+	if err := db.AddMessageLabel(ctx, h.pool, storedIDs, label, false); err != nil {
+		return false, err
+	}
+	if err := db.AddMessageLabel(ctx, h.pool, localIDs, label, true); err != nil {
+		return false, err
+	}
+	return len(localIDs) == 0, nil
+}
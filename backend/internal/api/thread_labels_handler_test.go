@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestGetLabelFromPath(t *testing.T) {
+	label, err := getLabelFromPath("/api/v1/thread/%3Cabc%40example.com%3E/labels/%24Label1")
+	if err != nil || label != "$Label1" {
+		t.Errorf("Expected $Label1, got %q, %v", label, err)
+	}
+	label, err = getLabelFromPath("/api/v1/thread/abc/labels/project/alpha")
+	if err != nil || label != "project/alpha" {
+		t.Errorf("Expected project/alpha, got %q, %v", label, err)
+	}
+	for _, path := range []string{"/api/v1/thread/abc/labels/", "/api/v1/thread/abc/labels", "/api/v1/thread/abc/other/x"} {
+		if _, err := getLabelFromPath(path); err == nil {
+			t.Errorf("Expected an error for %s", path)
+		}
+	}
+}
+
+func TestThreadHandler_ThreadLabels(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	encryptor := getTestEncryptor(t)
+	email := "labels-test@example.com"
+	userID := setupTestUserAndSettings(t, pool, encryptor, email)
+	ctx := context.Background()
+
+	// The thread has a message in INBOX and one in Sent
+	thread := &models.Thread{UserID: userID, StableThreadID: "labels-thread", Subject: "Labels"}
+	if err := db.SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("Failed to save thread: %v", err)
+	}
+	now := time.Now()
+	for i, folder := range []string{"INBOX", "Sent"} {
+		msg := &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         int64(i + 1),
+			IMAPFolderName:  folder,
+			MessageIDHeader: fmt.Sprintf("<labels-%d>", i),
+			Subject:         "Labels",
+			SentAt:          &now,
+		}
+		if err := db.SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("Failed to save message: %v", err)
+		}
+	}
+
+	send := func(handlerFunc http.HandlerFunc, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		handlerFunc(rr, req)
+		return rr
+	}
+
+	decode := func(t *testing.T, rr *httptest.ResponseRecorder) models.ThreadLabelsResponse {
+		t.Helper()
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var response models.ThreadLabelsResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		return response
+	}
+
+	t.Run("adds a label to all messages", func(t *testing.T) {
+		mockIMAP := &mockIMAPServiceForThread{}
+		handler := NewThreadHandler(pool, encryptor, mockIMAP, nil)
+
+		response := decode(t, send(handler.AddThreadLabel, "POST", "/api/v1/thread/labels-thread/labels", `{"label": "$Label1"}`))
+		if !slices.Equal(response.Labels, []string{"$label1"}) || !response.SavedToServer {
+			t.Errorf("Expected [$label1] saved to the server, got %+v", response)
+		}
+		if !mockIMAP.labelAdded || len(mockIMAP.labelMessages) != 2 {
+			t.Errorf("Expected the label to be added to 2 messages, got %+v", mockIMAP.labelMessages)
+		}
+	})
+
+	t.Run("keeps the label locally where the server doesn't allow it", func(t *testing.T) {
+		handler := NewThreadHandler(pool, encryptor, &mockIMAPServiceForThread{labelUnstoredFolder: "Sent"}, nil)
+
+		response := decode(t, send(handler.AddThreadLabel, "POST", "/api/v1/thread/labels-thread/labels", `{"label": "work"}`))
+		if !slices.Equal(response.Labels, []string{"$label1", "work"}) || response.SavedToServer {
+			t.Errorf("Expected [$label1 work] not fully saved to the server, got %+v", response)
+		}
+	})
+
+	t.Run("removes a label", func(t *testing.T) {
+		mockIMAP := &mockIMAPServiceForThread{}
+		handler := NewThreadHandler(pool, encryptor, mockIMAP, nil)
+
+		response := decode(t, send(handler.RemoveThreadLabel, "DELETE", "/api/v1/thread/labels-thread/labels/%24Label1", ""))
+		if !slices.Equal(response.Labels, []string{"work"}) {
+			t.Errorf("Expected [work], got %+v", response)
+		}
+		if mockIMAP.labelAdded {
+			t.Error("Expected the label to be removed on the server")
+		}
+	})
+
+	t.Run("rejects invalid labels", func(t *testing.T) {
+		mockIMAP := &mockIMAPServiceForThread{}
+		handler := NewThreadHandler(pool, encryptor, mockIMAP, nil)
+
+		rr := send(handler.AddThreadLabel, "POST", "/api/v1/thread/labels-thread/labels", `{"label": "two words"}`)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+		if mockIMAP.labelMessages != nil {
+			t.Error("Expected no IMAP call")
+		}
+	})
+
+	t.Run("returns 502 when the server fails", func(t *testing.T) {
+		handler := NewThreadHandler(pool, encryptor, &mockIMAPServiceForThread{labelErr: fmt.Errorf("connection reset")}, nil)
+
+		rr := send(handler.AddThreadLabel, "POST", "/api/v1/thread/labels-thread/labels", `{"label": "urgent"}`)
+		if rr.Code != http.StatusBadGateway {
+			t.Errorf("Expected status 502, got %d", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/models"
+	ws "github.com/vdavid/vmail/backend/internal/websocket"
+)
+
+// MoveThread moves the messages of a thread to the folder in the request body.
+// The path is /api/v1/thread/{thread_id}/move.
+func (h *ThreadHandler) MoveThread(w http.ResponseWriter, r *http.Request) {
+	h.moveThread(w, r, nil)
+}
+
+// ArchiveThread moves the messages of a thread to the Archive folder.
+// The path is /api/v1/thread/{thread_id}/archive.
+func (h *ThreadHandler) ArchiveThread(w http.ResponseWriter, r *http.Request) {
+	h.moveThread(w, r, &imap.ArchiveDestination)
+}
+
+// TrashThread moves the messages of a thread to the Trash folder.
+// The path is /api/v1/thread/{thread_id}/trash.
+func (h *ThreadHandler) TrashThread(w http.ResponseWriter, r *http.Request) {
+	h.moveThread(w, r, &imap.TrashDestination)
+}
+
+// moveThread moves the messages of a thread on the IMAP server, and then updates the cache.
+// If destination is nil, it moves them to the folder in the request body.
+func (h *ThreadHandler) moveThread(w http.ResponseWriter, r *http.Request, destination *imap.MoveDestination) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	stableThreadID, err := getStableThreadIDFromPath(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The body is optional for archive and trash
+	var req models.MoveThreadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeInvalidBodyError(w, err)
+		return
+	}
+	if destination == nil {
+		if req.Folder == "" {
+			WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+				Error:  "Invalid move",
+				Fields: map[string]string{"folder": "is required"},
+			})
+			return
+		}
+		destination = &imap.MoveDestination{FolderName: req.Folder}
+	}
+
+	thread, err := db.GetThreadByStableID(ctx, h.pool, userID, stableThreadID)
+	if err != nil {
+		if errors.Is(err, db.ErrThreadNotFound) {
+			http.Error(w, "Thread not found", http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get thread", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	messages, err := db.GetMessagesForThread(ctx, h.pool, thread.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get messages", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	messages = filterMessagesToMove(messages, req.FromFolder, destination.FolderName)
+	if len(messages) == 0 {
+		// Nothing to move, for example, the thread is already archived
+		WriteJSONResponse(w, models.MoveThreadResponse{Folder: destination.FolderName})
+		return
+	}
+
+	h.moveMessages(w, r, userID, stableThreadID, messages, *destination)
+}
+
+// moveMessages moves messages of a thread on the IMAP server, updates the cache, and writes the response.
+func (h *ThreadHandler) moveMessages(w http.ResponseWriter, r *http.Request, userID, stableThreadID string, messages []*models.Message, destination imap.MoveDestination) {
+	ctx := r.Context()
+
+	messagesToMove := make([]imap.MessageToMove, len(messages))
+	for i, msg := range messages {
+		messagesToMove[i] = imap.MessageToMove{
+			FolderName:      msg.IMAPFolderName,
+			IMAPUID:         msg.IMAPUID,
+			MessageIDHeader: msg.MessageIDHeader,
+		}
+	}
+	folderName, newUIDs, err := h.imapService.MoveMessages(ctx, userID, messagesToMove, destination)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to move messages", "error", err)
+		http.Error(w, "Failed to move messages on the mail server", http.StatusBadGateway)
+		return
+	}
+
+	moves := make([]db.MessageMove, 0, len(messages))
+	for i, msg := range messages {
+		if msg.IMAPFolderName == folderName {
+			continue
+		}
+		moves = append(moves, db.MessageMove{
+			MessageID:  msg.ID,
+			FromFolder: msg.IMAPFolderName,
+			ToFolder:   folderName,
+			NewIMAPUID: newUIDs[i],
+		})
+	}
+	if err := db.MoveMessages(ctx, h.pool, userID, moves); err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to update moved messages", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if len(moves) > 0 {
+		h.hub.Publish(userID, ws.Event{Type: ws.EventThreadUpdated, ThreadID: stableThreadID, Folder: folderName, Count: len(moves)})
+	}
+
+	WriteJSONResponse(w, models.MoveThreadResponse{Folder: folderName, MovedCount: len(moves)})
+}
+
+// filterMessagesToMove returns the messages in fromFolder, or all messages if it's empty.
+// It leaves out the messages that are already in the destination, if we know its name.
+func filterMessagesToMove(messages []*models.Message, fromFolder, destinationFolder string) []*models.Message {
+	filtered := make([]*models.Message, 0, len(messages))
+	for _, msg := range messages {
+		if fromFolder != "" && msg.IMAPFolderName != fromFolder {
+			continue
+		}
+		if destinationFolder != "" && msg.IMAPFolderName == destinationFolder {
+			continue
+		}
+		filtered = append(filtered, msg)
+	}
+	return filtered
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestFilterMessagesToMove(t *testing.T) {
+	messages := []*models.Message{
+		{ID: "1", IMAPFolderName: "INBOX"},
+		{ID: "2", IMAPFolderName: "Sent"},
+		{ID: "3", IMAPFolderName: "Archive"},
+	}
+
+	testCases := []struct {
+		name              string
+		fromFolder        string
+		destinationFolder string
+		wantIDs           string
+	}{
+		{"moves all messages by default", "", "", "1,2,3"},
+		{"moves only the messages in the source folder", "INBOX", "", "1"},
+		{"leaves out messages already in the destination", "", "Archive", "1,2"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var ids []string
+			for _, msg := range filterMessagesToMove(messages, tc.fromFolder, tc.destinationFolder) {
+				ids = append(ids, msg.ID)
+			}
+			if got := strings.Join(ids, ","); got != tc.wantIDs {
+				t.Errorf("Expected messages %s, got %s", tc.wantIDs, got)
+			}
+		})
+	}
+}
+
+func TestThreadHandler_MoveThread(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	encryptor := getTestEncryptor(t)
+	email := "move-test@example.com"
+	userID := setupTestUserAndSettings(t, pool, encryptor, email)
+	ctx := context.Background()
+
+	// saveThread saves a thread with a message in INBOX and one in Sent
+	saveThread := func(t *testing.T, stableThreadID string) *models.Thread {
+		t.Helper()
+		thread := &models.Thread{UserID: userID, StableThreadID: stableThreadID, Subject: "Moving"}
+		if err := db.SaveThread(ctx, pool, thread); err != nil {
+			t.Fatalf("Failed to save thread: %v", err)
+		}
+		now := time.Now()
+		for i, folder := range []string{"INBOX", "Sent"} {
+			msg := &models.Message{
+				ThreadID:        thread.ID,
+				UserID:          userID,
+				IMAPUID:         int64(i + 1),
+				IMAPFolderName:  folder,
+				MessageIDHeader: fmt.Sprintf("<%s-%d>", stableThreadID, i),
+				Subject:         "Moving",
+				SentAt:          &now,
+			}
+			if err := db.SaveMessage(ctx, pool, msg); err != nil {
+				t.Fatalf("Failed to save message: %v", err)
+			}
+		}
+		return thread
+	}
+
+	post := func(handlerFunc http.HandlerFunc, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		handlerFunc(rr, req)
+		return rr
+	}
+
+	t.Run("archives the messages in the source folder and updates the cache", func(t *testing.T) {
+		thread := saveThread(t, "move-archive")
+		mockIMAP := &mockIMAPServiceForThread{}
+		handler := NewThreadHandler(pool, encryptor, mockIMAP, nil)
+
+		rr := post(handler.ArchiveThread, "/api/v1/thread/move-archive/archive", `{"from_folder": "INBOX"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		var response models.MoveThreadResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response.Folder != "Archive" || response.MovedCount != 1 {
+			t.Errorf("Expected 1 message moved to Archive, got %+v", response)
+		}
+		if mockIMAP.moveDestination != imap.ArchiveDestination {
+			t.Errorf("Expected the archive destination, got %+v", mockIMAP.moveDestination)
+		}
+		if len(mockIMAP.movedMessages) != 1 || mockIMAP.movedMessages[0].FolderName != "INBOX" {
+			t.Errorf("Expected only the INBOX message to move, got %+v", mockIMAP.movedMessages)
+		}
+
+		messages, err := db.GetMessagesForThread(ctx, pool, thread.ID)
+		if err != nil {
+			t.Fatalf("Failed to get messages: %v", err)
+		}
+		folders := map[string]int64{}
+		for _, msg := range messages {
+			folders[msg.IMAPFolderName] = msg.IMAPUID
+		}
+		if folders["Archive"] != 1001 || folders["Sent"] != 2 || len(folders) != 2 {
+			t.Errorf("Expected the message in Archive with UID 1001 and the Sent one untouched, got %v", folders)
+		}
+	})
+
+	t.Run("moves to the folder in the body", func(t *testing.T) {
+		saveThread(t, "move-folder")
+		mockIMAP := &mockIMAPServiceForThread{}
+		handler := NewThreadHandler(pool, encryptor, mockIMAP, nil)
+
+		rr := post(handler.MoveThread, "/api/v1/thread/move-folder/move", `{"folder": "Projects"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if mockIMAP.moveDestination.FolderName != "Projects" || len(mockIMAP.movedMessages) != 2 {
+			t.Errorf("Expected both messages to move to Projects, got %+v to %+v", mockIMAP.movedMessages, mockIMAP.moveDestination)
+		}
+	})
+
+	t.Run("requires a folder for move", func(t *testing.T) {
+		handler := NewThreadHandler(pool, encryptor, &mockIMAPServiceForThread{}, nil)
+
+		rr := post(handler.MoveThread, "/api/v1/thread/move-folder/move", `{}`)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("returns 404 for unknown threads", func(t *testing.T) {
+		handler := NewThreadHandler(pool, encryptor, &mockIMAPServiceForThread{}, nil)
+
+		rr := post(handler.TrashThread, "/api/v1/thread/no-such-thread/trash", "")
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rr.Code)
+		}
+	})
+
+	t.Run("returns 502 and keeps the cache when the IMAP move fails", func(t *testing.T) {
+		thread := saveThread(t, "move-fails")
+		handler := NewThreadHandler(pool, encryptor, &mockIMAPServiceForThread{moveErr: fmt.Errorf("connection reset")}, nil)
+
+		rr := post(handler.TrashThread, "/api/v1/thread/move-fails/trash", "")
+		if rr.Code != http.StatusBadGateway {
+			t.Errorf("Expected status 502, got %d", rr.Code)
+		}
+
+		messages, err := db.GetMessagesForThread(ctx, pool, thread.ID)
+		if err != nil {
+			t.Fatalf("Failed to get messages: %v", err)
+		}
+		for _, msg := range messages {
+			if msg.IMAPFolderName == "Trash" {
+				t.Error("Expected no message in Trash")
+			}
+		}
+	})
+}
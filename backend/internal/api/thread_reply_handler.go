@@ -0,0 +1,293 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// quoteDateFormat is how quote attributions show the date of the original message.
+const quoteDateFormat = "Mon, Jan 2, 2006 at 15:04 MST"
+
+// GetReplyTemplate returns what the compose window starts with when the user replies to or forwards a message of
+// the thread: the recipients, the subject, the quoted body, and the threading headers.
+// The path is /api/v1/thread/{thread_id}/reply-template. The "mode" query parameter is "reply" (the default),
+// "reply_all", or "forward", and "message_id" is the ID of the message, which defaults to the newest one.
+func (h *ThreadHandler) GetReplyTemplate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	stableThreadID, err := getStableThreadIDFromPath(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = models.ReplyModeReply
+	}
+	if !slices.Contains([]string{models.ReplyModeReply, models.ReplyModeReplyAll, models.ReplyModeForward}, mode) {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid reply template",
+			Fields: map[string]string{"mode": `must be "reply", "reply_all", or "forward"`},
+		})
+		return
+	}
+
+	thread, err := db.GetThreadByStableID(ctx, h.pool, userID, stableThreadID)
+	if err != nil {
+		if errors.Is(err, db.ErrThreadNotFound) {
+			http.Error(w, "Thread not found", http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get thread", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	messages, err := db.GetMessagesForThread(ctx, h.pool, thread.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get messages", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	msg := findReplyMessage(messages, r.URL.Query().Get("message_id"))
+	if msg == nil {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	// Quote the whole message, even if the sync only cached its headers
+	toSync := []*models.Message{msg}
+	messagesToSync, messageUIDToIndex := collectMessagesToSync(toSync)
+	h.syncMissingBodies(ctx, userID, toSync, messagesToSync, messageUIDToIndex)
+	msg = toSync[0]
+
+	ownAddresses, err := h.getOwnAddresses(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get own addresses", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var references []string
+	var attachments []*models.Attachment
+	if mode == models.ReplyModeForward {
+		attachments, err = db.GetAttachmentsForMessage(ctx, h.pool, msg.ID)
+	} else {
+		references, err = db.GetMessageReferences(ctx, h.pool, msg.ID)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get message details for reply template", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	template := buildReplyTemplate(mode, msg, references, ownAddresses)
+	for _, attachment := range attachments {
+		if !attachment.IsInline {
+			template.Attachments = append(template.Attachments, *attachment)
+		}
+	}
+	WriteJSONResponse(w, template)
+}
+
+// findReplyMessage returns the message with the given ID, or the newest message if the ID is empty.
+// Returns nil if there's no such message.
+func findReplyMessage(messages []*models.Message, messageID string) *models.Message {
+	if messageID == "" {
+		if len(messages) == 0 {
+			return nil
+		}
+		// Messages are ordered by date, oldest first
+		return messages[len(messages)-1]
+	}
+	for _, msg := range messages {
+		if msg.ID == messageID {
+			return msg
+		}
+	}
+	return nil
+}
+
+// getOwnAddresses returns the lowercase addresses that the user gets mail at: the login email, the IMAP and SMTP
+// usernames, send identities, and plus aliases. Replies leave these out of the recipients.
+func (h *ThreadHandler) getOwnAddresses(ctx context.Context, userID string) (map[string]bool, error) {
+	own := make(map[string]bool)
+	if loginEmail, ok := auth.GetUserEmailFromContext(ctx); ok {
+		own[strings.ToLower(loginEmail)] = true
+	}
+
+	addresses, err := db.GetOwnAddresses(ctx, h.pool, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, address := range addresses {
+		own[strings.ToLower(address)] = true
+	}
+
+	identities, err := db.GetSendIdentities(ctx, h.pool, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, identity := range identities {
+		own[strings.ToLower(identity.Email)] = true
+	}
+
+	aliasLabels, err := db.GetPlusAliasLabels(ctx, h.pool, userID)
+	if err != nil {
+		return nil, err
+	}
+	for address := range aliasLabels {
+		own[address] = true
+	}
+	return own, nil
+}
+
+// buildReplyTemplate builds the reply, reply-all, or forward of the message.
+// references are the message's own References, and own has the user's lowercase addresses.
+func buildReplyTemplate(mode string, msg *models.Message, references []string, own map[string]bool) models.ReplyTemplate {
+	template := models.ReplyTemplate{
+		Mode:        mode,
+		MessageID:   msg.ID,
+		To:          []string{},
+		Cc:          []string{},
+		References:  []string{},
+		Attachments: []models.Attachment{},
+	}
+
+	if mode == models.ReplyModeForward {
+		template.Subject = prefixSubject("Fwd:", msg.Subject, "Fwd:", "Fw:")
+		template.TextBody, template.UnsafeHTMLBody = forwardBodies(msg)
+		return template
+	}
+
+	template.Subject = prefixSubject("Re:", msg.Subject, "Re:")
+	template.TextBody, template.UnsafeHTMLBody = quoteBodies(msg)
+	if msg.MessageIDHeader != "" {
+		template.InReplyTo = msg.MessageIDHeader
+		template.References = append(slices.DeleteFunc(slices.Clone(references), func(id string) bool {
+			return id == msg.MessageIDHeader
+		}), msg.MessageIDHeader)
+	}
+
+	// Replying to the user's own message continues the conversation with the same people
+	fromSelf := own[bareAddress(msg.FromAddress)]
+	seen := make(map[string]bool)
+	add := func(list []string, addresses ...string) []string {
+		for _, address := range addresses {
+			bare := bareAddress(address)
+			if bare == "" || seen[bare] || own[bare] {
+				continue
+			}
+			seen[bare] = true
+			list = append(list, address)
+		}
+		return list
+	}
+	switch {
+	case fromSelf && mode == models.ReplyModeReplyAll:
+		template.To = add(template.To, msg.ToAddresses...)
+		template.Cc = add(template.Cc, msg.CCAddresses...)
+	case fromSelf:
+		template.To = add(template.To, msg.ToAddresses...)
+	case mode == models.ReplyModeReplyAll:
+		template.To = add(template.To, msg.FromAddress)
+		template.To = add(template.To, msg.ToAddresses...)
+		template.Cc = add(template.Cc, msg.CCAddresses...)
+	default:
+		template.To = add(template.To, msg.FromAddress)
+	}
+	// A reply to a note to self goes to the user again
+	if fromSelf && len(template.To) == 0 && len(template.Cc) == 0 {
+		template.To = append(template.To, msg.ToAddresses...)
+	}
+	return template
+}
+
+// prefixSubject adds the prefix to the subject, unless it already starts with one of the given prefixes,
+// in any case. This way, replies to replies don't pile up prefixes like "Re: Re:".
+func prefixSubject(prefix, subject string, existing ...string) string {
+	subject = strings.TrimSpace(subject)
+	for _, p := range existing {
+		if len(subject) >= len(p) && strings.EqualFold(subject[:len(p)], p) {
+			return subject
+		}
+	}
+	if subject == "" {
+		return prefix
+	}
+	return prefix + " " + subject
+}
+
+// quoteBodies returns the text and HTML bodies of a reply: empty lines for the user to write in, an attribution,
+// and the original message quoted.
+func quoteBodies(msg *models.Message) (string, string) {
+	attribution := msg.FromAddress + " wrote:"
+	if msg.SentAt != nil {
+		attribution = "On " + msg.SentAt.UTC().Format(quoteDateFormat) + ", " + attribution
+	}
+
+	text := strings.TrimRight(strings.ReplaceAll(msg.BodyText, "\r\n", "\n"), "\n")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line == "" || strings.HasPrefix(line, ">") {
+			lines[i] = ">" + line
+		} else {
+			lines[i] = "> " + line
+		}
+	}
+	textBody := "\n\n" + attribution + "\n" + strings.Join(lines, "\n") + "\n"
+
+	htmlBody := "<br><br><div class=\"vmail-quote\">" + html.EscapeString(attribution) + "<br>" +
+		"<blockquote style=\"margin:0 0 0 0.8ex;border-left:1px solid #ccc;padding-left:1ex\">" +
+		originalHTML(msg) + "</blockquote></div>"
+	return textBody, htmlBody
+}
+
+// forwardBodies returns the text and HTML bodies of a forward: empty lines for the user to write in, the headers of
+// the original message, and its body.
+func forwardBodies(msg *models.Message) (string, string) {
+	headers := [][2]string{{"From", msg.FromAddress}}
+	if msg.SentAt != nil {
+		headers = append(headers, [2]string{"Date", msg.SentAt.UTC().Format(quoteDateFormat)})
+	}
+	headers = append(headers, [2]string{"Subject", msg.Subject}, [2]string{"To", strings.Join(msg.ToAddresses, ", ")})
+	if len(msg.CCAddresses) > 0 {
+		headers = append(headers, [2]string{"Cc", strings.Join(msg.CCAddresses, ", ")})
+	}
+
+	const separator = "---------- Forwarded message ---------"
+	var text, htmlHeaders strings.Builder
+	for _, header := range headers {
+		_, _ = fmt.Fprintf(&text, "%s: %s\n", header[0], header[1])
+		_, _ = fmt.Fprintf(&htmlHeaders, "%s: %s<br>", header[0], html.EscapeString(header[1]))
+	}
+
+	textBody := "\n\n" + separator + "\n" + text.String() + "\n" + strings.ReplaceAll(msg.BodyText, "\r\n", "\n")
+	htmlBody := "<br><br><div class=\"vmail-forward\">" + separator + "<br>" + htmlHeaders.String() + "<br>" +
+		originalHTML(msg) + "</div>"
+	return textBody, htmlBody
+}
+
+// originalHTML returns the HTML body of the message, or its text body as HTML if it has no HTML body.
+func originalHTML(msg *models.Message) string {
+	if msg.UnsafeBodyHTML != "" {
+		return msg.UnsafeBodyHTML
+	}
+	return strings.ReplaceAll(html.EscapeString(strings.ReplaceAll(msg.BodyText, "\r\n", "\n")), "\n", "<br>")
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+func TestBuildReplyTemplate(t *testing.T) {
+	sentAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	own := map[string]bool{"me@example.com": true, "me+shop@example.com": true}
+	msg := &models.Message{
+		ID:              "message-1",
+		MessageIDHeader: "<second@example.com>",
+		FromAddress:     "Alice <alice@example.com>",
+		ToAddresses:     []string{"Me <me@example.com>", "bob@example.com"},
+		CCAddresses:     []string{"Carol <carol@example.com>", "ALICE@example.com", "me+shop@example.com"},
+		SentAt:          &sentAt,
+		Subject:         "Plans",
+		BodyText:        "See you at 5.\r\n> Earlier quote\r\n",
+		UnsafeBodyHTML:  "<p>See you at 5.</p>",
+	}
+	references := []string{"<first@example.com>"}
+
+	t.Run("replies to the sender", func(t *testing.T) {
+		template := buildReplyTemplate(models.ReplyModeReply, msg, references, own)
+
+		if !slices.Equal(template.To, []string{"Alice <alice@example.com>"}) || len(template.Cc) != 0 {
+			t.Errorf("Expected a reply to Alice only, got to %v, cc %v", template.To, template.Cc)
+		}
+		if template.Subject != "Re: Plans" {
+			t.Errorf("Expected subject 'Re: Plans', got %q", template.Subject)
+		}
+		if template.InReplyTo != "<second@example.com>" {
+			t.Errorf("Expected In-Reply-To <second@example.com>, got %q", template.InReplyTo)
+		}
+		if !slices.Equal(template.References, []string{"<first@example.com>", "<second@example.com>"}) {
+			t.Errorf("Expected the thread's references and the message, got %v", template.References)
+		}
+		wantText := "\n\nOn Thu, Jan 2, 2025 at 03:04 UTC, Alice <alice@example.com> wrote:\n> See you at 5.\n>> Earlier quote\n"
+		if template.TextBody != wantText {
+			t.Errorf("Expected text body %q, got %q", wantText, template.TextBody)
+		}
+		if !strings.Contains(template.UnsafeHTMLBody, "Alice &lt;alice@example.com&gt; wrote:") ||
+			!strings.Contains(template.UnsafeHTMLBody, "<blockquote") ||
+			!strings.Contains(template.UnsafeHTMLBody, "<p>See you at 5.</p>") {
+			t.Errorf("Expected an escaped attribution and the quoted HTML, got %q", template.UnsafeHTMLBody)
+		}
+	})
+
+	t.Run("replies to everyone but the user", func(t *testing.T) {
+		template := buildReplyTemplate(models.ReplyModeReplyAll, msg, references, own)
+
+		if !slices.Equal(template.To, []string{"Alice <alice@example.com>", "bob@example.com"}) {
+			t.Errorf("Expected Alice and Bob in To, got %v", template.To)
+		}
+		if !slices.Equal(template.Cc, []string{"Carol <carol@example.com>"}) {
+			t.Errorf("Expected Carol in Cc without duplicates or the user's plus alias, got %v", template.Cc)
+		}
+	})
+
+	t.Run("replies to the recipients of the user's own message", func(t *testing.T) {
+		sent := *msg
+		sent.FromAddress = "Me <me@example.com>"
+		sent.ToAddresses = []string{"bob@example.com"}
+
+		template := buildReplyTemplate(models.ReplyModeReply, &sent, nil, own)
+		if !slices.Equal(template.To, []string{"bob@example.com"}) {
+			t.Errorf("Expected the reply to go to Bob again, got %v", template.To)
+		}
+
+		sent.ToAddresses = []string{"me@example.com"}
+		template = buildReplyTemplate(models.ReplyModeReply, &sent, nil, own)
+		if !slices.Equal(template.To, []string{"me@example.com"}) {
+			t.Errorf("Expected a reply to a note to self to go to the user, got %v", template.To)
+		}
+	})
+
+	t.Run("forwards without recipients or threading headers", func(t *testing.T) {
+		template := buildReplyTemplate(models.ReplyModeForward, msg, references, own)
+
+		if len(template.To) != 0 || len(template.Cc) != 0 || template.InReplyTo != "" || len(template.References) != 0 {
+			t.Errorf("Expected no recipients or threading headers, got %+v", template)
+		}
+		if template.Subject != "Fwd: Plans" {
+			t.Errorf("Expected subject 'Fwd: Plans', got %q", template.Subject)
+		}
+		if !strings.Contains(template.TextBody, "---------- Forwarded message ---------\nFrom: Alice <alice@example.com>\n") ||
+			!strings.Contains(template.TextBody, "\nSee you at 5.\n") {
+			t.Errorf("Expected the forwarded headers and body, got %q", template.TextBody)
+		}
+	})
+}
+
+func TestPrefixSubject(t *testing.T) {
+	testCases := []struct {
+		subject  string
+		prefix   string
+		existing []string
+		expected string
+	}{
+		{"Plans", "Re:", []string{"Re:"}, "Re: Plans"},
+		{"RE: Plans", "Re:", []string{"Re:"}, "RE: Plans"},
+		{"", "Re:", []string{"Re:"}, "Re:"},
+		{"Fw: Plans", "Fwd:", []string{"Fwd:", "Fw:"}, "Fw: Plans"},
+		{"Re: Plans", "Fwd:", []string{"Fwd:", "Fw:"}, "Fwd: Re: Plans"},
+	}
+
+	for _, tc := range testCases {
+		if got := prefixSubject(tc.prefix, tc.subject, tc.existing...); got != tc.expected {
+			t.Errorf("prefixSubject(%q, %q) = %q, expected %q", tc.prefix, tc.subject, got, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	ws "github.com/vdavid/vmail/backend/internal/websocket"
+)
+
+// SnoozeThread hides a thread from its folders until the time in the request body. It shows up in the virtual
+// snoozed folder in the meantime. The path is /api/v1/thread/{thread_id}/snooze.
+// The snooze only lives in our cache, so other mail clients still show the thread.
+func (h *ThreadHandler) SnoozeThread(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	stableThreadID, err := getStableThreadIDFromPath(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req models.SnoozeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBodyError(w, err)
+		return
+	}
+	if !req.Until.After(time.Now()) {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid snooze",
+			Fields: map[string]string{"until": "must be a time in the future"},
+		})
+		return
+	}
+
+	thread, err := db.GetThreadByStableID(ctx, h.pool, userID, stableThreadID)
+	if err != nil {
+		if errors.Is(err, db.ErrThreadNotFound) {
+			http.Error(w, "Thread not found", http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get thread", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	until := req.Until.UTC()
+	if err := db.SnoozeThread(ctx, h.pool, userID, thread.ID, until); err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to snooze thread", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.hub.Publish(userID, ws.Event{Type: ws.EventThreadUpdated, ThreadID: stableThreadID})
+
+	WriteJSONResponse(w, models.SnoozeResponse{SnoozedUntil: until})
+}
+
+// UnsnoozeThread brings a snoozed thread back to its folders before its time.
+// The path is /api/v1/thread/{thread_id}/snooze. Returns 404 if the thread isn't snoozed.
+func (h *ThreadHandler) UnsnoozeThread(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	stableThreadID, err := getStableThreadIDFromPath(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	thread, err := db.GetThreadByStableID(ctx, h.pool, userID, stableThreadID)
+	if err != nil {
+		if errors.Is(err, db.ErrThreadNotFound) {
+			http.Error(w, "Thread not found", http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get thread", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	unsnoozed, err := db.UnsnoozeThread(ctx, h.pool, userID, thread.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to unsnooze thread", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !unsnoozed {
+		http.Error(w, "Thread is not snoozed", http.StatusNotFound)
+		return
+	}
+
+	h.hub.Publish(userID, ws.Event{Type: ws.EventThreadUpdated, ThreadID: stableThreadID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
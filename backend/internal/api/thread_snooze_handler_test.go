@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestThreadHandler_Snooze(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	encryptor := getTestEncryptor(t)
+	email := "snooze-user@example.com"
+	userID := setupTestUserAndSettings(t, pool, encryptor, email)
+
+	imapService := imap.NewService(pool, imap.NewPool(), encryptor, nil)
+	defer imapService.Close()
+	handler := NewThreadHandler(pool, encryptor, imapService, nil)
+
+	ctx := context.Background()
+	thread := &models.Thread{UserID: userID, StableThreadID: "snooze-thread", Subject: "Later"}
+	if err := db.SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("Failed to save thread: %v", err)
+	}
+	now := time.Now()
+	if err := db.SaveMessage(ctx, pool, &models.Message{
+		ThreadID:       thread.ID,
+		UserID:         userID,
+		IMAPUID:        1,
+		IMAPFolderName: "INBOX",
+		Subject:        "Later",
+		SentAt:         &now,
+	}); err != nil {
+		t.Fatalf("Failed to save message: %v", err)
+	}
+
+	serve := func(method, body string, handle http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/thread/snooze-thread/snooze", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		handle(rr, req)
+		return rr
+	}
+	isSnoozed := func() bool {
+		t.Helper()
+		threads, err := db.GetSnoozedThreads(ctx, pool, userID, db.ThreadListFilter{}, 10, 0)
+		if err != nil {
+			t.Fatalf("GetSnoozedThreads failed: %v", err)
+		}
+		return len(threads) == 1
+	}
+
+	t.Run("rejects a time in the past", func(t *testing.T) {
+		rr := serve("POST", `{"until": "`+now.Add(-time.Minute).Format(time.RFC3339)+`"}`, handler.SnoozeThread)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status 400, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var response models.ValidationErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if _, ok := response.Fields["until"]; !ok {
+			t.Errorf("Expected until to be invalid, got %v", response.Fields)
+		}
+		if isSnoozed() {
+			t.Error("Expected the thread not to be snoozed")
+		}
+	})
+
+	t.Run("returns 404 when unsnoozing a thread that isn't snoozed", func(t *testing.T) {
+		if rr := serve("DELETE", "", handler.UnsnoozeThread); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rr.Code)
+		}
+	})
+
+	t.Run("snoozes and unsnoozes the thread", func(t *testing.T) {
+		until := now.Add(time.Hour).Truncate(time.Second)
+		rr := serve("POST", `{"until": "`+until.Format(time.RFC3339)+`"}`, handler.SnoozeThread)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var response models.SnoozeResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if !response.SnoozedUntil.Equal(until) {
+			t.Errorf("Expected snoozed_until %v, got %v", until, response.SnoozedUntil)
+		}
+		if !isSnoozed() {
+			t.Fatal("Expected the thread to be snoozed")
+		}
+
+		if rr := serve("DELETE", "", handler.UnsnoozeThread); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if isSnoozed() {
+			t.Error("Expected the thread not to be snoozed anymore")
+		}
+	})
+}
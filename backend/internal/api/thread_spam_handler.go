@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// ReportSpam moves the messages of a thread to the Spam folder, and marks them with the $Junk keyword.
+// The path is /api/v1/thread/{thread_id}/report-spam.
+func (h *ThreadHandler) ReportSpam(w http.ResponseWriter, r *http.Request) {
+	h.reportSpam(w, r, true)
+}
+
+// ReportNotSpam moves the messages of a thread from the Spam folder to INBOX, and marks them with the $NotJunk
+// keyword. The path is /api/v1/thread/{thread_id}/not-spam.
+func (h *ThreadHandler) ReportNotSpam(w http.ResponseWriter, r *http.Request) {
+	h.reportSpam(w, r, false)
+}
+
+// reportSpam stores the junk keywords on the IMAP server first, so that the messages carry them when they move,
+// and then moves the messages like moveThread.
+func (h *ThreadHandler) reportSpam(w http.ResponseWriter, r *http.Request, spam bool) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	stableThreadID, err := getStableThreadIDFromPath(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The body is optional
+	var req models.ReportSpamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	thread, err := db.GetThreadByStableID(ctx, h.pool, userID, stableThreadID)
+	if err != nil {
+		if errors.Is(err, db.ErrThreadNotFound) {
+			http.Error(w, "Thread not found", http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get thread", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	messages, err := db.GetMessagesForThread(ctx, h.pool, thread.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get messages", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Spam takes the whole thread. Not spam only takes the messages in Spam, and leaves, for example, Sent alone.
+	destination := imap.SpamDestination
+	if !spam {
+		destination = imap.MoveDestination{FolderName: "INBOX"}
+		messages = filterMessagesToMove(messages, h.getSpamFolder(ctx, userID), destination.FolderName)
+		if len(messages) == 0 {
+			WriteJSONResponse(w, models.MoveThreadResponse{Folder: destination.FolderName})
+			return
+		}
+	}
+
+	if req.Keywords == nil || *req.Keywords {
+		messagesToMark := make([]imap.MessageToSync, len(messages))
+		for i, msg := range messages {
+			messagesToMark[i] = imap.MessageToSync{FolderName: msg.IMAPFolderName, IMAPUID: msg.IMAPUID}
+		}
+		if err := h.imapService.MarkJunk(ctx, userID, messagesToMark, spam); err != nil {
+			slog.ErrorContext(ctx, "ThreadHandler: Failed to mark messages as junk", "spam", spam, "error", err)
+			http.Error(w, "Failed to report the messages on the mail server", http.StatusBadGateway)
+			return
+		}
+	}
+
+	h.moveMessages(w, r, userID, stableThreadID, messages, destination)
+}
+
+// getSpamFolder returns the name of the user's Spam folder.
+// If we can't list the folders, it falls back to the default name.
+func (h *ThreadHandler) getSpamFolder(ctx context.Context, userID string) string {
+	folders, err := h.imapService.GetFolders(ctx, userID)
+	if err != nil {
+		slog.WarnContext(ctx, "ThreadHandler: Failed to list folders, using the default Spam folder", "error", err)
+		return imap.SpamDestination.FallbackFolderName
+	}
+	for _, folder := range folders {
+		if folder.Role == "spam" {
+			return folder.Name
+		}
+	}
+	return imap.SpamDestination.FallbackFolderName
+}
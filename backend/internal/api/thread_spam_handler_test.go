@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestThreadHandler_ReportSpam(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	encryptor := getTestEncryptor(t)
+	email := "spam-test@example.com"
+	userID := setupTestUserAndSettings(t, pool, encryptor, email)
+	ctx := context.Background()
+
+	// saveThread saves a thread with a message in each folder
+	saveThread := func(t *testing.T, stableThreadID string, folders ...string) {
+		t.Helper()
+		thread := &models.Thread{UserID: userID, StableThreadID: stableThreadID, Subject: "Cheap pills"}
+		if err := db.SaveThread(ctx, pool, thread); err != nil {
+			t.Fatalf("Failed to save thread: %v", err)
+		}
+		now := time.Now()
+		for i, folder := range folders {
+			msg := &models.Message{
+				ThreadID:        thread.ID,
+				UserID:          userID,
+				IMAPUID:         int64(i + 1),
+				IMAPFolderName:  folder,
+				MessageIDHeader: fmt.Sprintf("<%s-%d>", stableThreadID, i),
+				Subject:         "Cheap pills",
+				SentAt:          &now,
+			}
+			if err := db.SaveMessage(ctx, pool, msg); err != nil {
+				t.Fatalf("Failed to save message: %v", err)
+			}
+		}
+	}
+
+	post := func(handlerFunc http.HandlerFunc, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		handlerFunc(rr, req)
+		return rr
+	}
+
+	t.Run("marks the messages as junk and moves them to Spam", func(t *testing.T) {
+		saveThread(t, "spam-report", "INBOX")
+		mockIMAP := &mockIMAPServiceForThread{}
+		handler := NewThreadHandler(pool, encryptor, mockIMAP, nil)
+
+		rr := post(handler.ReportSpam, "/api/v1/thread/spam-report/report-spam", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if len(mockIMAP.junkMessages) != 1 || !mockIMAP.junk {
+			t.Errorf("Expected the message to be marked as junk, got %+v", mockIMAP.junkMessages)
+		}
+		if mockIMAP.moveDestination != imap.SpamDestination || len(mockIMAP.movedMessages) != 1 {
+			t.Errorf("Expected the message to move to Spam, got %+v to %+v", mockIMAP.movedMessages, mockIMAP.moveDestination)
+		}
+	})
+
+	t.Run("skips the keywords if asked", func(t *testing.T) {
+		saveThread(t, "spam-no-keywords", "INBOX")
+		mockIMAP := &mockIMAPServiceForThread{}
+		handler := NewThreadHandler(pool, encryptor, mockIMAP, nil)
+
+		rr := post(handler.ReportSpam, "/api/v1/thread/spam-no-keywords/report-spam", `{"keywords": false}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if mockIMAP.junkMessages != nil {
+			t.Errorf("Expected no keywords, got %+v", mockIMAP.junkMessages)
+		}
+		if len(mockIMAP.movedMessages) != 1 {
+			t.Errorf("Expected the message to move, got %+v", mockIMAP.movedMessages)
+		}
+	})
+
+	t.Run("moves only the messages in Spam back to INBOX", func(t *testing.T) {
+		saveThread(t, "spam-not", "Junk", "Sent")
+		mockIMAP := &mockIMAPServiceForThread{}
+		handler := NewThreadHandler(pool, encryptor, mockIMAP, nil)
+
+		rr := post(handler.ReportNotSpam, "/api/v1/thread/spam-not/not-spam", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if len(mockIMAP.junkMessages) != 1 || mockIMAP.junk {
+			t.Errorf("Expected the Junk message to be marked as not junk, got %+v", mockIMAP.junkMessages)
+		}
+		if mockIMAP.moveDestination.FolderName != "INBOX" || len(mockIMAP.movedMessages) != 1 || mockIMAP.movedMessages[0].FolderName != "Junk" {
+			t.Errorf("Expected the Junk message to move to INBOX, got %+v to %+v", mockIMAP.movedMessages, mockIMAP.moveDestination)
+		}
+	})
+
+	t.Run("returns 502 and doesn't move when marking fails", func(t *testing.T) {
+		saveThread(t, "spam-fails", "INBOX")
+		mockIMAP := &mockIMAPServiceForThread{junkErr: fmt.Errorf("connection reset")}
+		handler := NewThreadHandler(pool, encryptor, mockIMAP, nil)
+
+		rr := post(handler.ReportSpam, "/api/v1/thread/spam-fails/report-spam", "")
+		if rr.Code != http.StatusBadGateway {
+			t.Errorf("Expected status 502, got %d", rr.Code)
+		}
+		if mockIMAP.movedMessages != nil {
+			t.Error("Expected no move")
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// TrustSender adds a sender of the thread to the user's trusted senders, and responds with the thread
+// like GetThread, so the front end can show the remote images right away.
+// The path is /api/v1/thread/{thread_id}/trust-sender. The body is optional, see models.TrustSenderRequest.
+func (h *ThreadHandler) TrustSender(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	stableThreadID, err := getStableThreadIDFromPath(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req models.TrustSenderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	thread, err := db.GetThreadByStableID(ctx, h.pool, userID, stableThreadID)
+	if err != nil {
+		if errors.Is(err, db.ErrThreadNotFound) {
+			http.Error(w, "Thread not found", http.StatusNotFound)
+			return
+		}
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get thread", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	messages, err := db.GetMessagesForThread(ctx, h.pool, thread.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to get messages", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	email, ok := findThreadSender(messages, req.Email)
+	if !ok {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid trusted sender",
+			Fields: map[string]string{"email": "must be the sender of a message in this thread"},
+		})
+		return
+	}
+
+	if _, err := db.TrustSender(ctx, h.pool, userID, email); err != nil {
+		slog.ErrorContext(ctx, "ThreadHandler: Failed to trust sender", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.writeThread(ctx, w, userID, stableThreadID, nil)
+}
+
+// findThreadSender returns the lowercase address of the sender to trust.
+// If email is empty, it's the sender of the first message. Otherwise, some message must be from email.
+// This way, the endpoint can only trust senders that the user actually sees in the thread.
+func findThreadSender(messages []*models.Message, email string) (string, bool) {
+	wanted := bareAddress(email)
+	for _, msg := range messages {
+		sender := bareAddress(msg.FromAddress)
+		if sender != "" && (wanted == "" || sender == wanted) {
+			return sender, true
+		}
+	}
+	return "", false
+}
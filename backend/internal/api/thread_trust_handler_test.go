@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestFindThreadSender(t *testing.T) {
+	messages := []*models.Message{
+		{FromAddress: "Newsletter <News@Example.com>"},
+		{FromAddress: "me@example.com"},
+	}
+
+	testCases := []struct {
+		name     string
+		email    string
+		expected string
+		ok       bool
+	}{
+		{"defaults to the first sender", "", "news@example.com", true},
+		{"finds a later sender", "ME@example.com", "me@example.com", true},
+		{"rejects senders from elsewhere", "stranger@example.com", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sender, ok := findThreadSender(messages, tc.email)
+			if sender != tc.expected || ok != tc.ok {
+				t.Errorf("Expected %q (%v), got %q (%v)", tc.expected, tc.ok, sender, ok)
+			}
+		})
+	}
+}
+
+func TestAssignRemoteImagesAllowed(t *testing.T) {
+	messages := []models.Message{
+		{FromAddress: "Newsletter <News@Example.com>"},
+		{FromAddress: "stranger@example.com"},
+	}
+	assignRemoteImagesAllowed(messages, map[string]bool{"news@example.com": true})
+
+	if !messages[0].RemoteImagesAllowed {
+		t.Error("Expected remote images of the trusted sender to be allowed")
+	}
+	if messages[1].RemoteImagesAllowed {
+		t.Error("Expected remote images of other senders to stay hidden")
+	}
+}
+
+func TestThreadHandler_TrustSender(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	encryptor := getTestEncryptor(t)
+	email := "trust-sender-user@example.com"
+	userID := setupTestUserAndSettings(t, pool, encryptor, email)
+
+	imapService := imap.NewService(pool, imap.NewPool(), encryptor, nil)
+	defer imapService.Close()
+	handler := NewThreadHandler(pool, encryptor, imapService, nil)
+
+	ctx := context.Background()
+	thread := &models.Thread{UserID: userID, StableThreadID: "trust-thread", Subject: "Our news"}
+	if err := db.SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("Failed to save thread: %v", err)
+	}
+	now := time.Now()
+	// The body is cached, so the thread doesn't need the IMAP server
+	if err := db.SaveMessage(ctx, pool, &models.Message{
+		ThreadID:        thread.ID,
+		UserID:          userID,
+		IMAPUID:         1,
+		IMAPFolderName:  "INBOX",
+		MessageIDHeader: "trust-thread",
+		FromAddress:     "News <news@example.com>",
+		ToAddresses:     []string{email},
+		Subject:         "Our news",
+		SentAt:          &now,
+		UnsafeBodyHTML:  `<img src="https://example.com/banner.png">`,
+	}); err != nil {
+		t.Fatalf("Failed to save message: %v", err)
+	}
+
+	serve := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/thread/trust-thread/trust-sender", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		handler.TrustSender(rr, req)
+		return rr
+	}
+
+	t.Run("rejects senders that aren't in the thread", func(t *testing.T) {
+		if rr := serve(`{"email": "stranger@example.com"}`); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("trusts the sender and returns the thread with images allowed", func(t *testing.T) {
+		rr := serve("")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		var response models.Thread
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if len(response.Messages) != 1 || !response.Messages[0].RemoteImagesAllowed {
+			t.Errorf("Expected the message to allow remote images, got %+v", response.Messages)
+		}
+
+		emails, err := db.GetTrustedSenderEmails(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetTrustedSenderEmails failed: %v", err)
+		}
+		if !emails["news@example.com"] {
+			t.Errorf("Expected news@example.com to be trusted, got %v", emails)
+		}
+	})
+}
@@ -2,61 +2,205 @@ package api
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/vdavid/vmail/backend/internal/crypto"
 	"github.com/vdavid/vmail/backend/internal/db"
 	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/importance"
 	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/pagination"
+	ws "github.com/vdavid/vmail/backend/internal/websocket"
 )
 
+// quickPreviewSize is the most messages we fetch directly from IMAP for folders we've never synced.
+const quickPreviewSize = 50
+
+// backgroundSyncTimeout limits how long the background sync after a quick preview can take.
+const backgroundSyncTimeout = 10 * time.Minute
+
 // ThreadsHandler handles thread-list-related API requests.
 type ThreadsHandler struct {
 	pool        *pgxpool.Pool
 	encryptor   *crypto.Encryptor // Not used directly, but required by imapService
 	imapService imap.IMAPService
+	hub         *ws.Hub
+	// syncBudget is how long a request waits for a sync before returning cached data. Zero means no limit.
+	syncBudget time.Duration
+	// backgroundSyncs maps "userID/folder" keys to the *backgroundSync of folders that we're syncing in the background.
+	backgroundSyncs sync.Map
+}
+
+// backgroundSync tracks a folder sync that runs independently of the request that started it.
+type backgroundSync struct {
+	// jobID is in the sync events of the sync. See imap.WithSyncJobID.
+	jobID string
+	// done is closed when the sync finishes.
+	done chan struct{}
+	// notify is set if a client got a response before the sync finished, so it needs to hear about the new data.
+	notify atomic.Bool
+}
+
+// wait waits at most the given time for the sync to finish, and returns true if it did.
+// If the sync is still running after that, it asks for a notification when the sync finishes.
+func (s *backgroundSync) wait(ctx context.Context, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-s.done:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	s.notify.Store(true)
+	// The sync may have finished before it could see the notify flag
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
 }
 
 // NewThreadsHandler creates a new ThreadsHandler instance.
-func NewThreadsHandler(pool *pgxpool.Pool, encryptor *crypto.Encryptor, imapService imap.IMAPService) *ThreadsHandler {
+// The hub is used to tell clients when a sync that outlasted syncBudget finishes.
+func NewThreadsHandler(pool *pgxpool.Pool, encryptor *crypto.Encryptor, imapService imap.IMAPService, hub *ws.Hub, syncBudget time.Duration) *ThreadsHandler {
 	return &ThreadsHandler{
 		pool:        pool,
 		encryptor:   encryptor,
 		imapService: imapService,
+		hub:         hub,
+		syncBudget:  syncBudget,
 	}
 }
 
 // syncFolderIfNeeded checks if the folder needs syncing and syncs if necessary.
+// If the sync takes longer than the sync budget, it lets the sync finish in the background
+// and returns true, so that the caller can return cached data and flag it as syncing.
 // If the sync check fails or sync itself fails, it logs the error but continues
 // to return cached data, ensuring the request doesn't fail due to sync issues.
-func (h *ThreadsHandler) syncFolderIfNeeded(ctx context.Context, userID, folder string) {
+func (h *ThreadsHandler) syncFolderIfNeeded(ctx context.Context, userID, folder string) (syncing bool) {
+	if value, ok := h.backgroundSyncs.Load(userID + "/" + folder); ok {
+		// Don't start a second sync, just give the running one the same budget
+		return !value.(*backgroundSync).wait(ctx, h.syncBudget)
+	}
+
 	shouldSync, err := h.imapService.ShouldSyncFolder(ctx, userID, folder)
 	if err != nil {
-		log.Printf("ThreadsHandler: Failed to check cache: %v", err)
+		slog.ErrorContext(ctx, "ThreadsHandler: Failed to check cache", "error", err)
 		shouldSync = true // Continue anyway - try to sync
 	}
+	if !shouldSync {
+		return false
+	}
 
-	if shouldSync {
-		log.Printf("ThreadsHandler: Syncing folder %s for user %s", folder, userID)
+	if h.syncBudget <= 0 {
+		slog.InfoContext(ctx, "ThreadsHandler: Syncing folder", "folder", folder)
 		if err := h.imapService.SyncThreadsForFolder(ctx, userID, folder); err != nil {
-			log.Printf("ThreadsHandler: Failed to sync folder: %v", err)
+			slog.ErrorContext(ctx, "ThreadsHandler: Failed to sync folder", "error", err)
 			// Continue anyway - return cached data if available
 		}
+		return false
+	}
+
+	// Run the sync in the background so that it survives the request if it takes too long
+	if h.syncFolderInBackground(userID, folder).wait(ctx, h.syncBudget) {
+		return false
+	}
+	slog.InfoContext(ctx, "ThreadsHandler: Sync of folder is over budget, returning cached data", "folder", folder)
+	return true
+}
+
+// previewUnsyncedFolder responds with a quick preview if we've never synced the folder,
+// and starts syncing it in the background. Returns false if it didn't respond, for example,
+// because the folder is already synced or the preview failed.
+func (h *ThreadsHandler) previewUnsyncedFolder(ctx context.Context, w http.ResponseWriter, userID, folder string, params pagination.Params) bool {
+	syncInfo, err := db.GetFolderSyncInfo(ctx, h.pool, userID, folder)
+	if err != nil || syncInfo != nil {
+		return false
+	}
+
+	// This is false if the user disabled syncing for the folder
+	shouldSync, err := h.imapService.ShouldSyncFolder(ctx, userID, folder)
+	if err != nil || !shouldSync {
+		return false
+	}
+
+	threads, messageCount, err := h.imapService.PreviewFolder(ctx, userID, folder, min(params.Limit, quickPreviewSize))
+	if err != nil {
+		slog.WarnContext(ctx, "ThreadsHandler: Failed to preview folder, syncing instead", "folder", folder, "error", err)
+		return false
 	}
+
+	// The preview is incomplete until the sync finishes, so let the client know when it does
+	h.syncFolderInBackground(userID, folder).notify.Store(true)
+
+	// The message count is only an estimate of the thread count
+	response := &models.ThreadsResponse{
+		Threads:    threads,
+		Pagination: pagination.NewInfo(params, len(threads), messageCount, true),
+		Partial:    true,
+		Syncing:    true,
+	}
+	WriteJSONResponse(w, response)
+	return true
+}
+
+// syncFolderInBackground syncs the folder in a goroutine, unless it's already syncing in the background.
+// Either way, it returns the background sync of the folder.
+func (h *ThreadsHandler) syncFolderInBackground(userID, folder string) *backgroundSync {
+	bgSync, _ := h.startBackgroundSync(userID, folder)
+	return bgSync
+}
+
+// startBackgroundSync is syncFolderInBackground, but it also returns whether it started the sync,
+// as opposed to finding one that's already running.
+func (h *ThreadsHandler) startBackgroundSync(userID, folder string) (*backgroundSync, bool) {
+	key := userID + "/" + folder
+	value, syncing := h.backgroundSyncs.LoadOrStore(key, &backgroundSync{jobID: uuid.NewString(), done: make(chan struct{})})
+	bgSync := value.(*backgroundSync)
+	if syncing {
+		return bgSync, false
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), backgroundSyncTimeout)
+		defer cancel()
+		ctx = imap.WithSyncJobID(ctx, bgSync.jobID)
+
+		slog.InfoContext(ctx, "ThreadsHandler: Syncing folder in the background", "folder", folder)
+		if err := h.imapService.SyncThreadsForFolder(ctx, userID, folder); err != nil {
+			slog.ErrorContext(ctx, "ThreadsHandler: Failed to sync folder in the background", "error", err)
+		}
+
+		h.backgroundSyncs.Delete(key)
+		close(bgSync.done)
+		if bgSync.notify.Load() {
+			h.sendSyncCompleteNotification(userID, folder)
+		}
+	}()
+	return bgSync, true
+}
+
+// sendSyncCompleteNotification tells the user's clients that a folder sync finished, so they can refetch the folder.
+// We also send it after failed syncs, so that clients don't wait for the data forever.
+func (h *ThreadsHandler) sendSyncCompleteNotification(userID, folder string) {
+	h.hub.Publish(userID, ws.Event{Type: ws.EventSyncComplete, Folder: folder})
 }
 
 // BuildPaginationResponse builds the pagination response structure.
 // This is a shared helper function used by multiple handlers for consistent response formatting.
-func BuildPaginationResponse(threads []*models.Thread, totalCount, page, limit int) *models.ThreadsResponse {
+func BuildPaginationResponse(threads []*models.Thread, totalCount int, params pagination.Params) *models.ThreadsResponse {
 	return &models.ThreadsResponse{
-		Threads: threads,
-		Pagination: models.PaginationInfo{
-			TotalCount: totalCount,
-			Page:       page,
-			PerPage:    limit,
-		},
+		Threads:    threads,
+		Pagination: pagination.NewInfo(params, len(threads), totalCount, false),
 	}
 }
 
@@ -77,34 +221,209 @@ func (h *ThreadsHandler) GetThreads(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get pagination params
-	page, limitFromQuery := ParsePaginationParams(r, 100)
-	limit := GetPaginationLimit(ctx, h.pool, userID, limitFromQuery)
-	offset := (page - 1) * limit
+	params, ok := GetPaginationParams(ctx, w, r, h.pool, userID)
+	if !ok {
+		return
+	}
+
+	// Get the priority inbox params
+	important, ok := parseBoolQueryParam(w, r, "important")
+	if !ok {
+		return
+	}
+	split, ok := parseBoolQueryParam(w, r, "split")
+	if !ok {
+		return
+	}
+	filter := db.ThreadListFilter{}
+	if important {
+		filter.MinImportance = importance.Threshold
+	}
+	if split {
+		filter.ImportantFirst = importance.Threshold
+	}
+
+	// Keyset cursors continue after the last thread of the previous page, which is faster than OFFSET deep in a list
+	offset := params.Offset()
+	if params.After != "" {
+		after, err := db.DecodeThreadListPosition(params.After)
+		if err != nil {
+			http.Error(w, pagination.ErrInvalidCursor.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.After = after
+		offset = 0
+	}
+
+	// Virtual folders only exist in the cache, so there's nothing to preview or sync
+	source := h.getFolderThreads(ctx, userID, folder)
+
+	// Show new folders quickly, instead of making the user wait for the full sync
+	if !source.virtual && params.Offset() == 0 && filter.After == nil && !important && !split && h.previewUnsyncedFolder(ctx, w, userID, folder, params) {
+		return
+	}
 
-	// Sync folder if needed
-	h.syncFolderIfNeeded(ctx, userID, folder)
+	// Sync folder if needed, but don't make the user wait more than the sync budget
+	syncing := !source.virtual && h.syncFolderIfNeeded(ctx, userID, folder)
 
 	// Get threads from the database
-	threads, err := db.GetThreadsForFolder(ctx, h.pool, userID, folder, limit, offset)
+	threads, err := source.list(filter, params.Limit, offset)
 	if err != nil {
-		log.Printf("ThreadsHandler: Failed to get threads: %v", err)
+		slog.ErrorContext(ctx, "ThreadsHandler: Failed to get threads", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+	for _, thread := range threads {
+		thread.IsImportant = importance.IsImportant(thread.ImportanceScore)
+	}
 
 	// Get total count for pagination
-	totalCount, err := db.GetThreadCountForFolder(ctx, h.pool, userID, folder)
+	totalCount, groups, err := getThreadCounts(source, important, split)
 	if err != nil {
-		log.Printf("ThreadsHandler: Failed to get thread count: %v", err)
+		slog.ErrorContext(ctx, "ThreadsHandler: Failed to get thread count", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
 	// Build and send the response
 	// Use a buffered approach to prevent partial writes if JSON encoding fails
-	response := BuildPaginationResponse(threads, totalCount, page, limit)
+	lastPosition := ""
+	if len(threads) > 0 {
+		lastPosition = db.ThreadListPositionOf(threads[len(threads)-1], filter).Encode()
+	}
+	response := &models.ThreadsResponse{
+		Threads:    threads,
+		Pagination: pagination.NewKeysetInfo(params, len(threads), totalCount, false, lastPosition),
+		Groups:     groups,
+	}
+	response.Syncing = syncing
 
 	if !WriteJSONResponse(w, response) {
 		return
 	}
 }
+
+// folderThreads lists and counts the cached threads of a folder.
+type folderThreads struct {
+	// virtual is true for the folders that only exist in the cache. See models.StarredFolderName.
+	virtual bool
+	list    func(filter db.ThreadListFilter, limit, offset int) ([]*models.Thread, error)
+	// count counts the threads with an importance score of at least minImportance.
+	count func(minImportance int) (int, error)
+}
+
+// getFolderThreads returns how to list the threads of a folder, which can be a real or a virtual one.
+func (h *ThreadsHandler) getFolderThreads(ctx context.Context, userID, folder string) folderThreads {
+	switch folder {
+	case models.StarredFolderName:
+		return folderThreads{
+			virtual: true,
+			list: func(filter db.ThreadListFilter, limit, offset int) ([]*models.Thread, error) {
+				return db.GetStarredThreads(ctx, h.pool, userID, filter, limit, offset)
+			},
+			count: func(minImportance int) (int, error) {
+				return db.GetStarredThreadCount(ctx, h.pool, userID, minImportance)
+			},
+		}
+	case models.SnoozedFolderName:
+		return folderThreads{
+			virtual: true,
+			list: func(filter db.ThreadListFilter, limit, offset int) ([]*models.Thread, error) {
+				return db.GetSnoozedThreads(ctx, h.pool, userID, filter, limit, offset)
+			},
+			count: func(minImportance int) (int, error) {
+				return db.GetSnoozedThreadCount(ctx, h.pool, userID, minImportance)
+			},
+		}
+	case models.AllMailFolderName:
+		excluded := h.getTrashAndSpamFolders(ctx, userID)
+		return folderThreads{
+			virtual: true,
+			list: func(filter db.ThreadListFilter, limit, offset int) ([]*models.Thread, error) {
+				return db.GetAllMailThreads(ctx, h.pool, userID, excluded, filter, limit, offset)
+			},
+			count: func(minImportance int) (int, error) {
+				return db.GetAllMailThreadCount(ctx, h.pool, userID, excluded, minImportance)
+			},
+		}
+	default:
+		return folderThreads{
+			list: func(filter db.ThreadListFilter, limit, offset int) ([]*models.Thread, error) {
+				// Snoozed threads come back to their folders when they wake up
+				filter.ExcludeSnoozed = true
+				return db.GetFilteredThreadsForFolder(ctx, h.pool, userID, folder, filter, limit, offset)
+			},
+			count: func(minImportance int) (int, error) {
+				if minImportance > 0 {
+					return db.GetImportantThreadCountForFolder(ctx, h.pool, userID, folder, minImportance)
+				}
+				// This one uses the materialized count
+				return db.GetThreadCountForFolder(ctx, h.pool, userID, folder)
+			},
+		}
+	}
+}
+
+// getTrashAndSpamFolders returns the names of the user's Trash and Spam folders, which the All Mail folder leaves out.
+// If we can't list the folders, it falls back to the default names, so that All Mail still works.
+func (h *ThreadsHandler) getTrashAndSpamFolders(ctx context.Context, userID string) []string {
+	folders, err := h.imapService.GetFolders(ctx, userID)
+	if err != nil {
+		slog.WarnContext(ctx, "ThreadsHandler: Failed to list folders, leaving out the default Trash and Spam", "error", err)
+		return []string{imap.TrashDestination.FallbackFolderName, imap.SpamDestination.FallbackFolderName}
+	}
+
+	var names []string
+	for _, folder := range folders {
+		if folder.Role == "trash" || folder.Role == "spam" {
+			names = append(names, folder.Name)
+		}
+	}
+	return names
+}
+
+// getThreadCounts returns the total count of threads for the list, and for split-inbox requests, the group sizes.
+// With important=true, the total only counts important threads.
+func getThreadCounts(source folderThreads, important, split bool) (int, *models.ThreadGroups, error) {
+	if !important && !split {
+		count, err := source.count(0)
+		return count, nil, err
+	}
+
+	importantCount, err := source.count(importance.Threshold)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !split {
+		return importantCount, nil, nil
+	}
+
+	folderCount, err := source.count(0)
+	if err != nil {
+		return 0, nil, err
+	}
+	groups := &models.ThreadGroups{
+		ImportantCount: importantCount,
+		// The folder count may be materialized and slightly stale, so don't let this go negative.
+		OtherCount: max(folderCount-importantCount, 0),
+	}
+	if important {
+		return importantCount, groups, nil
+	}
+	return folderCount, groups, nil
+}
+
+// parseBoolQueryParam reads an optional "true" or "false" query param. Missing means false.
+// If the value is invalid, it writes a 400 response and returns false as the second value.
+func parseBoolQueryParam(w http.ResponseWriter, r *http.Request, name string) (bool, bool) {
+	value := r.URL.Query().Get(name)
+	switch value {
+	case "", "false":
+		return false, true
+	case "true":
+		return true, true
+	default:
+		http.Error(w, name+" query parameter must be true or false", http.StatusBadRequest)
+		return false, false
+	}
+}
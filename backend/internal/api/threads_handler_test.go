@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 	"github.com/vdavid/vmail/backend/internal/db"
 	"github.com/vdavid/vmail/backend/internal/imap"
 	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/pagination"
 	"github.com/vdavid/vmail/backend/internal/testutil"
 	ws "github.com/vdavid/vmail/backend/internal/websocket"
 )
@@ -22,9 +24,9 @@ func TestThreadsHandler_GetThreads(t *testing.T) {
 	defer pool.Close()
 
 	encryptor := getTestEncryptor(t)
-	imapService := imap.NewService(pool, imap.NewPool(), encryptor)
+	imapService := imap.NewService(pool, imap.NewPool(), encryptor, nil)
 	defer imapService.Close()
-	handler := NewThreadsHandler(pool, encryptor, imapService)
+	handler := NewThreadsHandler(pool, encryptor, imapService, nil, 0)
 
 	t.Run("returns 401 when no user email in context", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/api/v1/threads?folder=INBOX", nil)
@@ -134,6 +136,99 @@ func TestThreadsHandler_GetThreads(t *testing.T) {
 		}
 	})
 
+	t.Run("returns 400 when important parameter is invalid", func(t *testing.T) {
+		email := "threaduser@example.com"
+		setupTestUserAndSettings(t, pool, encryptor, email)
+
+		req := createRequestWithUser("GET", "/api/v1/threads?folder=INBOX&important=yes", email)
+		rr := httptest.NewRecorder()
+		handler.GetThreads(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+
+	t.Run("filters and splits by importance", func(t *testing.T) {
+		email := "importanceuser@example.com"
+		ctx := context.Background()
+		userID := setupTestUserAndSettings(t, pool, encryptor, email)
+
+		// The important thread is older, so it's only first when splitting
+		now := time.Now()
+		scores := map[string]int{}
+		for i, tc := range []struct {
+			stableID string
+			score    int
+			sentAt   time.Time
+		}{
+			{"important-thread", 80, now.Add(-time.Hour)},
+			{"other-thread", 10, now},
+		} {
+			thread := &models.Thread{UserID: userID, StableThreadID: tc.stableID, Subject: tc.stableID}
+			if err := db.SaveThread(ctx, pool, thread); err != nil {
+				t.Fatalf("Failed to save thread: %v", err)
+			}
+			msg := &models.Message{
+				ThreadID:        thread.ID,
+				UserID:          userID,
+				IMAPUID:         int64(i + 1),
+				IMAPFolderName:  "INBOX",
+				MessageIDHeader: tc.stableID,
+				SentAt:          &tc.sentAt,
+			}
+			if err := db.SaveMessage(ctx, pool, msg); err != nil {
+				t.Fatalf("Failed to save message: %v", err)
+			}
+			scores[thread.ID] = tc.score
+		}
+		if err := db.SaveThreadImportanceScores(ctx, pool, userID, scores); err != nil {
+			t.Fatalf("Failed to save importance scores: %v", err)
+		}
+
+		getThreads := func(query string) models.ThreadsResponse {
+			req := createRequestWithUser("GET", "/api/v1/threads?folder=INBOX&"+query, email)
+			rr := httptest.NewRecorder()
+			handler.GetThreads(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", rr.Code)
+			}
+			var response models.ThreadsResponse
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			return response
+		}
+
+		important := getThreads("important=true")
+		if len(important.Threads) != 1 || important.Threads[0].StableThreadID != "important-thread" {
+			t.Fatalf("Expected only the important thread, got %+v", important.Threads)
+		}
+		if !important.Threads[0].IsImportant {
+			t.Error("Expected the thread to be marked important")
+		}
+		if important.Pagination.TotalCount != 1 {
+			t.Errorf("Expected total_count 1, got %d", important.Pagination.TotalCount)
+		}
+		if important.Groups != nil {
+			t.Error("Expected no groups without split=true")
+		}
+
+		split := getThreads("split=true")
+		if len(split.Threads) != 2 || split.Threads[0].StableThreadID != "important-thread" {
+			t.Fatalf("Expected the important thread first, got %+v", split.Threads)
+		}
+		if split.Threads[1].IsImportant {
+			t.Error("Expected the other thread not to be marked important")
+		}
+		if split.Groups == nil || split.Groups.ImportantCount != 1 || split.Groups.OtherCount != 1 {
+			t.Errorf("Expected groups of 1 and 1, got %+v", split.Groups)
+		}
+		if split.Pagination.TotalCount != 2 {
+			t.Errorf("Expected total_count 2, got %d", split.Pagination.TotalCount)
+		}
+	})
+
 	t.Run("respects pagination parameters", func(t *testing.T) {
 		email := "paginationuser@example.com"
 		ctx := context.Background()
@@ -225,6 +320,38 @@ func TestThreadsHandler_GetThreads(t *testing.T) {
 		if response2.Pagination.TotalCount != 3 {
 			t.Errorf("Expected total_count 3, got %d", response2.Pagination.TotalCount)
 		}
+
+		// Following the cursor continues after the last thread of page 1
+		if response.Pagination.NextCursor == nil {
+			t.Fatal("Expected a next cursor on page 1")
+		}
+		req3 := createRequestWithUser("GET", "/api/v1/threads?folder=INBOX&limit=2&cursor="+*response.Pagination.NextCursor, email)
+		rr3 := httptest.NewRecorder()
+		handler.GetThreads(rr3, req3)
+		if rr3.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr3.Code, rr3.Body.String())
+		}
+
+		var response3 models.ThreadsResponse
+		if err := json.NewDecoder(rr3.Body).Decode(&response3); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(response3.Threads) != 1 || response3.Pagination.Page != 2 || response3.Pagination.NextCursor != nil {
+			t.Errorf("Expected the last thread on page 2 without a next cursor, got %+v", response3)
+		}
+		for _, thread := range response.Threads {
+			if len(response3.Threads) > 0 && thread.ID == response3.Threads[0].ID {
+				t.Errorf("Expected a thread that wasn't on page 1, got %s again", thread.Subject)
+			}
+		}
+
+		// A keyset cursor with a broken position is invalid
+		req4 := createRequestWithUser("GET", "/api/v1/threads?folder=INBOX&cursor="+pagination.EncodeKeysetCursor(2, "broken"), email)
+		rr4 := httptest.NewRecorder()
+		handler.GetThreads(rr4, req4)
+		if rr4.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for a broken cursor, got %d", rr4.Code)
+		}
 	})
 }
 
@@ -237,6 +364,14 @@ type mockIMAPService struct {
 	syncThreadsForFolderCalled bool
 	syncThreadsForFolderUserID string
 	syncThreadsForFolderFolder string
+	previewFolderThreads       []*models.Thread
+	previewFolderCount         int
+	previewFolderErr           error
+	previewFolderCalled        bool
+	appendToSentErr            error
+	appendToSentRaw            []byte
+	getFoldersResult           []*models.Folder
+	getFoldersErr              error
 }
 
 func (m *mockIMAPService) ShouldSyncFolder(context.Context, string, string) (bool, error) {
@@ -251,6 +386,28 @@ func (m *mockIMAPService) SyncThreadsForFolder(_ context.Context, userID, folder
 	return m.syncThreadsForFolderErr
 }
 
+func (m *mockIMAPService) PreviewFolder(context.Context, string, string, int) ([]*models.Thread, int, error) {
+	m.previewFolderCalled = true
+	return m.previewFolderThreads, m.previewFolderCount, m.previewFolderErr
+}
+
+func (m *mockIMAPService) AppendToSent(_ context.Context, _ string, raw []byte, _ time.Time) error {
+	m.appendToSentRaw = raw
+	return m.appendToSentErr
+}
+
+func (m *mockIMAPService) SaveDraft(context.Context, string, []byte, string) error {
+	return nil
+}
+
+func (m *mockIMAPService) DeleteDraft(context.Context, string, string) error {
+	return nil
+}
+
+func (m *mockIMAPService) MoveMessages(_ context.Context, _ string, messages []imap.MessageToMove, destination imap.MoveDestination) (string, []int64, error) {
+	return destination.FolderName, make([]int64, len(messages)), nil
+}
+
 func (m *mockIMAPService) SyncFullMessage(context.Context, string, string, int64) error {
 	return nil
 }
@@ -259,6 +416,18 @@ func (m *mockIMAPService) SyncFullMessages(context.Context, string, []imap.Messa
 	return nil
 }
 
+func (m *mockIMAPService) StoreLabel(_ context.Context, _ string, messages []imap.MessageToSync, _ string, _ bool) ([]bool, error) {
+	return make([]bool, len(messages)), nil
+}
+
+func (m *mockIMAPService) MarkJunk(context.Context, string, []imap.MessageToSync, bool) error {
+	return nil
+}
+
+func (m *mockIMAPService) GetFolders(context.Context, string) ([]*models.Folder, error) {
+	return m.getFoldersResult, m.getFoldersErr
+}
+
 func (m *mockIMAPService) Search(context.Context, string, string, int, int) ([]*models.Thread, int, error) {
 	return nil, 0, nil
 }
@@ -277,6 +446,11 @@ func TestThreadsHandler_SyncsWhenStale(t *testing.T) {
 	email := "sync-test@example.com"
 	userID := setupTestUserAndSettings(t, pool, encryptor, email)
 
+	// Sync the folder once, so that these tests cover stale caches rather than the quick preview
+	if err := db.SetFolderSyncInfo(context.Background(), pool, userID, "INBOX", nil); err != nil {
+		t.Fatalf("Failed to set folder sync info: %v", err)
+	}
+
 	t.Run("calls SyncThreadsForFolder when cache is stale", func(t *testing.T) {
 		mockIMAP := &mockIMAPService{
 			shouldSyncFolderResult:  true, // Cache is stale
@@ -284,7 +458,7 @@ func TestThreadsHandler_SyncsWhenStale(t *testing.T) {
 			syncThreadsForFolderErr: nil, // Sync succeeds
 		}
 
-		handler := NewThreadsHandler(pool, encryptor, mockIMAP)
+		handler := NewThreadsHandler(pool, encryptor, mockIMAP, nil, 0)
 		req := createRequestWithUser("GET", "/api/v1/threads?folder=INBOX", email)
 
 		rr := httptest.NewRecorder()
@@ -313,6 +487,100 @@ func TestThreadsHandler_SyncsWhenStale(t *testing.T) {
 		}
 	})
 
+	t.Run("lists the starred folder from the cache without syncing", func(t *testing.T) {
+		ctx := context.Background()
+		thread := &models.Thread{UserID: userID, StableThreadID: "starred-thread", Subject: "Starred"}
+		if err := db.SaveThread(ctx, pool, thread); err != nil {
+			t.Fatalf("Failed to save thread: %v", err)
+		}
+		for i, starred := range []bool{true, false} {
+			msg := &models.Message{
+				ThreadID:        thread.ID,
+				UserID:          userID,
+				IMAPUID:         int64(100 + i),
+				IMAPFolderName:  "Archive",
+				MessageIDHeader: fmt.Sprintf("starred-%d", i),
+				IsStarred:       starred,
+			}
+			if err := db.SaveMessage(ctx, pool, msg); err != nil {
+				t.Fatalf("Failed to save message: %v", err)
+			}
+		}
+
+		mockIMAP := &mockIMAPService{shouldSyncFolderResult: true}
+		handler := NewThreadsHandler(pool, encryptor, mockIMAP, nil, 0)
+		req := createRequestWithUser("GET", "/api/v1/threads?folder="+models.StarredFolderName, email)
+
+		rr := httptest.NewRecorder()
+		handler.GetThreads(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+		if mockIMAP.shouldSyncFolderCalled || mockIMAP.syncThreadsForFolderCalled {
+			t.Error("Expected no sync for the starred folder")
+		}
+
+		var response models.ThreadsResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(response.Threads) != 1 || response.Threads[0].MessageCount != 2 || response.Pagination.TotalCount != 1 {
+			t.Errorf("Expected the starred thread with both messages, got %+v", response)
+		}
+	})
+
+	t.Run("leaves Trash and Spam out of All Mail", func(t *testing.T) {
+		ctx := context.Background()
+		thread := &models.Thread{UserID: userID, StableThreadID: "trashed-thread", Subject: "Trashed"}
+		if err := db.SaveThread(ctx, pool, thread); err != nil {
+			t.Fatalf("Failed to save thread: %v", err)
+		}
+		msg := &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         200,
+			IMAPFolderName:  "Deleted Items",
+			MessageIDHeader: "trashed",
+		}
+		if err := db.SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("Failed to save message: %v", err)
+		}
+
+		mockIMAP := &mockIMAPService{
+			shouldSyncFolderResult: true,
+			getFoldersResult: []*models.Folder{
+				{Name: "INBOX", Role: "inbox"},
+				{Name: "Deleted Items", Role: "trash"},
+			},
+		}
+		handler := NewThreadsHandler(pool, encryptor, mockIMAP, nil, 0)
+		req := createRequestWithUser("GET", "/api/v1/threads?folder="+models.AllMailFolderName, email)
+
+		rr := httptest.NewRecorder()
+		handler.GetThreads(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+		if mockIMAP.syncThreadsForFolderCalled {
+			t.Error("Expected no sync for the All Mail folder")
+		}
+
+		var response models.ThreadsResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(response.Threads) == 0 || response.Pagination.TotalCount != len(response.Threads) {
+			t.Errorf("Expected the cached threads with a matching count, got %+v", response)
+		}
+		for _, thread := range response.Threads {
+			if thread.StableThreadID == "trashed-thread" {
+				t.Error("Expected the thread in Trash to be left out")
+			}
+		}
+	})
+
 	t.Run("does not call SyncThreadsForFolder when cache is fresh", func(t *testing.T) {
 		mockIMAP := &mockIMAPService{
 			shouldSyncFolderResult:  false, // Cache is fresh
@@ -320,7 +588,7 @@ func TestThreadsHandler_SyncsWhenStale(t *testing.T) {
 			syncThreadsForFolderErr: nil,
 		}
 
-		handler := NewThreadsHandler(pool, encryptor, mockIMAP)
+		handler := NewThreadsHandler(pool, encryptor, mockIMAP, nil, 0)
 		req := createRequestWithUser("GET", "/api/v1/threads?folder=INBOX", email)
 
 		rr := httptest.NewRecorder()
@@ -348,7 +616,7 @@ func TestThreadsHandler_SyncsWhenStale(t *testing.T) {
 			syncThreadsForFolderErr: fmt.Errorf("IMAP connection failed"), // Sync fails
 		}
 
-		handler := NewThreadsHandler(pool, encryptor, mockIMAP)
+		handler := NewThreadsHandler(pool, encryptor, mockIMAP, nil, 0)
 		req := createRequestWithUser("GET", "/api/v1/threads?folder=INBOX", email)
 
 		rr := httptest.NewRecorder()
@@ -392,7 +660,7 @@ func TestThreadsHandler_SyncsWhenStale(t *testing.T) {
 			shouldSyncFolderErr:    nil,
 		}
 
-		handler := NewThreadsHandler(pool, encryptor, mockIMAP)
+		handler := NewThreadsHandler(pool, encryptor, mockIMAP, nil, 0)
 		req := createRequestWithUser("GET", "/api/v1/threads?folder=INBOX", email)
 
 		rr := httptest.NewRecorder()
@@ -427,7 +695,7 @@ func TestThreadsHandler_SyncsWhenStale(t *testing.T) {
 			shouldSyncFolderErr:    nil,
 		}
 
-		handler := NewThreadsHandler(pool, encryptor, mockIMAP)
+		handler := NewThreadsHandler(pool, encryptor, mockIMAP, nil, 0)
 		req := httptest.NewRequest("GET", "/api/v1/threads?folder=INBOX", nil)
 		reqCtx := context.WithValue(canceledCtx, auth.UserEmailKey, email)
 		req = req.WithContext(reqCtx)
@@ -468,7 +736,7 @@ func TestThreadsHandler_SyncsWhenStale(t *testing.T) {
 			shouldSyncFolderErr:    nil,
 		}
 
-		handler := NewThreadsHandler(pool, encryptor, mockIMAP)
+		handler := NewThreadsHandler(pool, encryptor, mockIMAP, nil, 0)
 		rr := httptest.NewRecorder()
 		handler.GetThreads(rr, req)
 
@@ -518,7 +786,7 @@ func TestThreadsHandler_SyncsWhenStale(t *testing.T) {
 					shouldSyncFolderErr:    nil,
 				}
 
-				handler := NewThreadsHandler(pool, encryptor, mockIMAP)
+				handler := NewThreadsHandler(pool, encryptor, mockIMAP, nil, 0)
 				req := createRequestWithUser("GET", fmt.Sprintf("/api/v1/threads?folder=INBOX&%s", tc.query), email)
 
 				rr := httptest.NewRecorder()
@@ -564,7 +832,7 @@ func TestThreadsHandler_SyncsWhenStale(t *testing.T) {
 			syncThreadsForFolderErr: nil, // Sync succeeds
 		}
 
-		handler := NewThreadsHandler(pool, encryptor, mockIMAP)
+		handler := NewThreadsHandler(pool, encryptor, mockIMAP, nil, 0)
 		req := createRequestWithUser("GET", "/api/v1/threads?folder=INBOX", email)
 
 		rr := httptest.NewRecorder()
@@ -606,7 +874,7 @@ func TestThreadsHandler_SyncsWhenStale(t *testing.T) {
 			shouldSyncFolderErr:    nil,
 		}
 
-		handler := NewThreadsHandler(pool, encryptor, mockIMAP)
+		handler := NewThreadsHandler(pool, encryptor, mockIMAP, nil, 0)
 		req := createRequestWithUser("GET", "/api/v1/threads?folder=INBOX", email)
 
 		// Create a ResponseWriter that fails on Write
@@ -638,3 +906,185 @@ func (f *failingResponseWriterThreads) Write(p []byte) (int, error) {
 	}
 	return f.ResponseWriter.Write(p)
 }
+
+// mockIMAPServiceForPreview reports background syncs on a channel, since they run in a goroutine.
+type mockIMAPServiceForPreview struct {
+	mockIMAPService
+	synced chan string
+}
+
+func (m *mockIMAPServiceForPreview) SyncThreadsForFolder(_ context.Context, _, folderName string) error {
+	m.synced <- folderName
+	return nil
+}
+
+func TestThreadsHandler_PreviewsUnsyncedFolder(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	encryptor := getTestEncryptor(t)
+	email := "preview-test@example.com"
+	userID := setupTestUserAndSettings(t, pool, encryptor, email)
+
+	now := time.Now()
+	previewThreads := []*models.Thread{{StableThreadID: "<preview@example.com>", Subject: "Preview", UserID: userID, MessageCount: 1, LastSentAt: &now}}
+
+	t.Run("returns a partial preview and syncs in the background", func(t *testing.T) {
+		mockIMAP := &mockIMAPServiceForPreview{
+			mockIMAPService: mockIMAPService{
+				shouldSyncFolderResult: true,
+				previewFolderThreads:   previewThreads,
+				previewFolderCount:     1000,
+			},
+			synced: make(chan string, 1),
+		}
+
+		handler := NewThreadsHandler(pool, encryptor, mockIMAP, nil, 0)
+		req := createRequestWithUser("GET", "/api/v1/threads?folder=Archive", email)
+
+		rr := httptest.NewRecorder()
+		handler.GetThreads(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+
+		var response models.ThreadsResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if !response.Partial {
+			t.Error("Expected partial to be true")
+		}
+		if len(response.Threads) != 1 || response.Threads[0].StableThreadID != "<preview@example.com>" {
+			t.Errorf("Expected the preview thread, got %+v", response.Threads)
+		}
+		if response.Pagination.TotalCount != 1000 || !response.Pagination.TotalEstimated {
+			t.Errorf("Expected an estimated total of 1000, got %+v", response.Pagination)
+		}
+
+		select {
+		case folder := <-mockIMAP.synced:
+			if folder != "Archive" {
+				t.Errorf("Expected a background sync of Archive, got %s", folder)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("Expected a background sync")
+		}
+	})
+
+	t.Run("doesn't preview folders that we've synced before", func(t *testing.T) {
+		if err := db.SetFolderSyncInfo(context.Background(), pool, userID, "INBOX", nil); err != nil {
+			t.Fatalf("Failed to set folder sync info: %v", err)
+		}
+		mockIMAP := &mockIMAPService{shouldSyncFolderResult: true, previewFolderThreads: previewThreads}
+
+		handler := NewThreadsHandler(pool, encryptor, mockIMAP, nil, 0)
+		req := createRequestWithUser("GET", "/api/v1/threads?folder=INBOX", email)
+
+		rr := httptest.NewRecorder()
+		handler.GetThreads(rr, req)
+
+		if mockIMAP.previewFolderCalled {
+			t.Error("Expected PreviewFolder not to be called")
+		}
+		if !mockIMAP.syncThreadsForFolderCalled {
+			t.Error("Expected a blocking sync")
+		}
+	})
+
+	t.Run("falls back to a blocking sync when the preview fails", func(t *testing.T) {
+		mockIMAP := &mockIMAPService{shouldSyncFolderResult: true, previewFolderErr: fmt.Errorf("preview failed")}
+
+		handler := NewThreadsHandler(pool, encryptor, mockIMAP, nil, 0)
+		req := createRequestWithUser("GET", "/api/v1/threads?folder=Spam", email)
+
+		rr := httptest.NewRecorder()
+		handler.GetThreads(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", rr.Code)
+		}
+		if !mockIMAP.syncThreadsForFolderCalled {
+			t.Error("Expected a blocking sync")
+		}
+		if strings.Contains(rr.Body.String(), `"partial"`) {
+			t.Error("Expected a full response")
+		}
+	})
+}
+
+type mockIMAPServiceForSyncBudget struct {
+	mockIMAPService
+	release chan struct{}
+}
+
+func (m *mockIMAPServiceForSyncBudget) SyncThreadsForFolder(ctx context.Context, _, _ string) error {
+	select {
+	case <-m.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestThreadsHandler_SyncBudget(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	encryptor := getTestEncryptor(t)
+	email := "sync-budget-test@example.com"
+	userID := setupTestUserAndSettings(t, pool, encryptor, email)
+	if err := db.SetFolderSyncInfo(context.Background(), pool, userID, "INBOX", nil); err != nil {
+		t.Fatalf("Failed to set folder sync info: %v", err)
+	}
+
+	getThreads := func(t *testing.T, handler *ThreadsHandler) models.ThreadsResponse {
+		t.Helper()
+		req := createRequestWithUser("GET", "/api/v1/threads?folder=INBOX", email)
+		rr := httptest.NewRecorder()
+		handler.GetThreads(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+		var response models.ThreadsResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return response
+	}
+
+	t.Run("returns cached data while a slow sync continues", func(t *testing.T) {
+		mockIMAP := &mockIMAPServiceForSyncBudget{
+			mockIMAPService: mockIMAPService{shouldSyncFolderResult: true},
+			release:         make(chan struct{}),
+		}
+		handler := NewThreadsHandler(pool, encryptor, mockIMAP, nil, 50*time.Millisecond)
+
+		if response := getThreads(t, handler); !response.Syncing {
+			t.Error("Expected syncing to be true when the sync is over budget")
+		}
+		// A second request joins the running sync instead of starting a new one
+		if response := getThreads(t, handler); !response.Syncing {
+			t.Error("Expected syncing to be true while the sync is still running")
+		}
+
+		close(mockIMAP.release)
+		if response := getThreads(t, handler); response.Syncing {
+			t.Error("Expected syncing to be false after the sync finished")
+		}
+	})
+
+	t.Run("returns fresh data when the sync fits the budget", func(t *testing.T) {
+		mockIMAP := &mockIMAPServiceForSyncBudget{
+			mockIMAPService: mockIMAPService{shouldSyncFolderResult: true},
+			release:         make(chan struct{}),
+		}
+		close(mockIMAP.release)
+		handler := NewThreadsHandler(pool, encryptor, mockIMAP, nil, 5*time.Second)
+
+		if response := getThreads(t, handler); response.Syncing {
+			t.Error("Expected syncing to be false")
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+)
+
+// TrustedSendersHandler handles the list of senders whose remote images we show, at /api/v1/trusted-senders.
+// Senders are added from a thread, see ThreadHandler.TrustSender.
+type TrustedSendersHandler struct {
+	pool *pgxpool.Pool
+}
+
+// NewTrustedSendersHandler creates a new TrustedSendersHandler instance.
+func NewTrustedSendersHandler(pool *pgxpool.Pool) *TrustedSendersHandler {
+	return &TrustedSendersHandler{
+		pool: pool,
+	}
+}
+
+// GetTrustedSenders returns the trusted senders of the current user.
+func (h *TrustedSendersHandler) GetTrustedSenders(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	senders, err := db.GetTrustedSenders(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "TrustedSendersHandler: Failed to get trusted senders", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !WriteJSONResponse(w, senders) {
+		return
+	}
+}
+
+// DeleteTrustedSender stops trusting a sender. The path is /api/v1/trusted-senders/{id}.
+func (h *TrustedSendersHandler) DeleteTrustedSender(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	senderID, ok := getUUIDFromPath(r.URL.Path, "/api/v1/trusted-senders/", "")
+	if !ok {
+		http.Error(w, "Trusted sender not found", http.StatusNotFound)
+		return
+	}
+
+	err := db.DeleteTrustedSender(ctx, h.pool, userID, senderID)
+	if errors.Is(err, db.ErrTrustedSenderNotFound) {
+		http.Error(w, "Trusted sender not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "TrustedSendersHandler: Failed to delete trusted sender", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
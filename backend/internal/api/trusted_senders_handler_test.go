@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestTrustedSendersHandler(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	email := "trusted-senders-user@example.com"
+	userID := setupTestUserAndSettings(t, pool, getTestEncryptor(t), email)
+
+	handler := NewTrustedSendersHandler(pool)
+	serve := func(method, path string, fn func(http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		fn(rr, req)
+		return rr
+	}
+
+	trusted, err := db.TrustSender(context.Background(), pool, userID, "news@example.com")
+	if err != nil {
+		t.Fatalf("TrustSender failed: %v", err)
+	}
+
+	t.Run("lists the trusted senders", func(t *testing.T) {
+		rr := serve("GET", "/api/v1/trusted-senders", handler.GetTrustedSenders)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var senders []models.TrustedSender
+		if err := json.Unmarshal(rr.Body.Bytes(), &senders); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if len(senders) != 1 || senders[0].ID != trusted.ID || senders[0].Email != "news@example.com" {
+			t.Errorf("Expected the trusted sender, got %+v", senders)
+		}
+	})
+
+	t.Run("returns 404 for IDs that aren't UUIDs", func(t *testing.T) {
+		if rr := serve("DELETE", "/api/v1/trusted-senders/not-a-uuid", handler.DeleteTrustedSender); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rr.Code)
+		}
+	})
+
+	t.Run("stops trusting a sender", func(t *testing.T) {
+		path := "/api/v1/trusted-senders/" + trusted.ID
+		if rr := serve("DELETE", path, handler.DeleteTrustedSender); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", rr.Code)
+		}
+		if rr := serve("DELETE", path, handler.DeleteTrustedSender); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 the second time, got %d", rr.Code)
+		}
+
+		emails, err := db.GetTrustedSenderEmails(context.Background(), pool, userID)
+		if err != nil {
+			t.Fatalf("GetTrustedSenderEmails failed: %v", err)
+		}
+		if len(emails) != 0 {
+			t.Errorf("Expected no trusted senders, got %v", emails)
+		}
+	})
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/crypto"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/sieve"
+)
+
+// Limits of the auto-responder. Sieve servers cap :days too, for example, Dovecot at 30 by default, and use their cap
+// for longer intervals.
+const (
+	maxVacationSubjectLength = 200
+	maxVacationBodyLength    = 10000
+	maxVacationIntervalDays  = 365
+)
+
+// VacationHandler manages the user's auto-responder at /api/v1/vacation.
+// The mail server sends the replies if it supports the Sieve "vacation" extension, and the backend does otherwise,
+// see vacation.Responder.
+type VacationHandler struct {
+	pool      *pgxpool.Pool
+	encryptor *crypto.Encryptor
+}
+
+// NewVacationHandler creates a new VacationHandler instance.
+func NewVacationHandler(pool *pgxpool.Pool, encryptor *crypto.Encryptor) *VacationHandler {
+	return &VacationHandler{
+		pool:      pool,
+		encryptor: encryptor,
+	}
+}
+
+// GetVacation returns the auto-responder of the current user. Users who never saved one get a disabled one.
+func (h *VacationHandler) GetVacation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	responder, err := db.GetVacationResponder(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "VacationHandler: Failed to get the auto-responder", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !WriteJSONResponse(w, responder) {
+		return
+	}
+}
+
+// UpdateVacation saves the auto-responder, and responds with it. Its mode tells who sends the replies: the mail
+// server if it could install the responder with Sieve, see installVacationInSieve, and the backend otherwise.
+// If the mail server ran the old responder, but can't be updated now, it responds with a 502, and saves nothing,
+// so that senders don't get both the old and the new reply.
+func (h *VacationHandler) UpdateVacation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	var req models.VacationResponderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.InfoContext(ctx, "VacationHandler: Failed to decode request", "error", err)
+		writeInvalidBodyError(w, err)
+		return
+	}
+
+	if fieldErrors := validateVacationRequest(&req); len(fieldErrors) > 0 {
+		WriteJSONResponseWithStatus(w, http.StatusBadRequest, models.ValidationErrorResponse{
+			Error:  "Invalid auto-responder",
+			Fields: fieldErrors,
+		})
+		return
+	}
+
+	current, err := db.GetVacationResponder(ctx, h.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "VacationHandler: Failed to get the auto-responder", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	responder := &models.VacationResponder{
+		UserID:       userID,
+		Enabled:      req.Enabled,
+		Subject:      strings.TrimSpace(req.Subject),
+		Body:         req.Body,
+		StartsAt:     req.StartsAt,
+		EndsAt:       req.EndsAt,
+		IntervalDays: req.IntervalDays,
+		Mode:         models.VacationModeBackend,
+	}
+	if responder.IntervalDays == 0 {
+		responder.IntervalDays = db.DefaultVacationIntervalDays
+	}
+
+	// Disabled responders only need the server if it runs the old one
+	if responder.Enabled || current.Mode == models.VacationModeSieve {
+		installed, err := h.installVacationInSieve(ctx, userID, responder)
+		if err != nil && current.Mode == models.VacationModeSieve {
+			slog.WarnContext(ctx, "VacationHandler: Failed to update the auto-responder on the mail server", "error", err)
+			http.Error(w, "Failed to update the auto-responder on the mail server", http.StatusBadGateway)
+			return
+		}
+		if err != nil {
+			slog.InfoContext(ctx, "VacationHandler: Can't install the auto-responder with Sieve, so the backend replies", "error", err)
+		}
+		if installed {
+			responder.Mode = models.VacationModeSieve
+		}
+	}
+
+	if err := db.SaveVacationResponder(ctx, h.pool, responder); err != nil {
+		slog.ErrorContext(ctx, "VacationHandler: Failed to save the auto-responder", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !WriteJSONResponse(w, responder) {
+		return
+	}
+}
+
+// installVacationInSieve puts the auto-responder in the V-Mail block of the user's Sieve script with their filters,
+// see SieveHandler.SyncFilters, and returns whether the mail server runs the responder now. Disabled responders are
+// taken out of the block. It doesn't touch the server if the active script has no block, since ManageSieve servers
+// only run one, and that's the user's own. If the server lacks the extensions that the responder needs, it takes out
+// the old responder, and returns false.
+func (h *VacationHandler) installVacationInSieve(ctx context.Context, userID string, responder *models.VacationResponder) (bool, error) {
+	unlock := lockSieveSync(userID)
+	defer unlock()
+
+	c, err := dialSieve(ctx, h.pool, h.encryptor, userID)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := c.Logout(); err != nil {
+			slog.WarnContext(ctx, "VacationHandler: Failed to log out of the ManageSieve server", "error", err)
+		}
+	}()
+
+	script, err := getActiveScript(c)
+	if err != nil {
+		return false, err
+	}
+	// Scripts that V-Mail generated before it used blocks are replaced, see sieve.SetBlock
+	if _, ok := sieve.FindBlock(script.Content); script.Active && !ok && script.Name != sieve.FiltersScriptName {
+		return false, nil
+	}
+
+	_, err = syncFilterBlock(ctx, h.pool, c, userID, script, responder, true)
+	if errors.Is(err, sieve.ErrUnsupportedExtension) && responder.Enabled {
+		// Any edits of the block were imported by the first try
+		_, err = syncFilterBlock(ctx, h.pool, c, userID, script, nil, false)
+		if errors.Is(err, sieve.ErrUnsupportedExtension) {
+			// Then the filters were never installed either
+			return false, nil
+		}
+		return false, err
+	}
+	if err != nil {
+		return false, err
+	}
+	return responder.Enabled, nil
+}
+
+// validateVacationRequest checks an auto-responder request.
+// Returns a map of invalid fields to error messages, which is empty if it's valid.
+func validateVacationRequest(req *models.VacationResponderRequest) map[string]string {
+	fieldErrors := map[string]string{}
+
+	subject := strings.TrimSpace(req.Subject)
+	if len([]rune(subject)) > maxVacationSubjectLength {
+		fieldErrors["subject"] = fmt.Sprintf("must be at most %d characters", maxVacationSubjectLength)
+	} else if strings.ContainsAny(subject, "\r\n") {
+		fieldErrors["subject"] = "must be a single line"
+	}
+
+	if len([]rune(req.Body)) > maxVacationBodyLength {
+		fieldErrors["body"] = fmt.Sprintf("must be at most %d characters", maxVacationBodyLength)
+	} else if req.Enabled && strings.TrimSpace(req.Body) == "" {
+		fieldErrors["body"] = "body is required"
+	}
+
+	if req.IntervalDays < 0 || req.IntervalDays > maxVacationIntervalDays {
+		fieldErrors["interval_days"] = fmt.Sprintf("must be between 1 and %d", maxVacationIntervalDays)
+	}
+
+	if req.StartsAt != nil && req.EndsAt != nil && !req.StartsAt.Before(*req.EndsAt) {
+		fieldErrors["ends_at"] = "must be after starts_at"
+	}
+
+	return fieldErrors
+}
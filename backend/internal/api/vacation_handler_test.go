@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestValidateVacationRequest(t *testing.T) {
+	startsAt := time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC)
+	endsAt := startsAt.Add(14 * 24 * time.Hour)
+	testCases := []struct {
+		name          string
+		req           models.VacationResponderRequest
+		invalidFields []string
+	}{
+		{"accepts a responder", models.VacationResponderRequest{Enabled: true, Subject: "Away", Body: "Back soon", StartsAt: &startsAt, EndsAt: &endsAt}, nil},
+		{"accepts a disabled responder without a body", models.VacationResponderRequest{}, nil},
+		{"requires a body", models.VacationResponderRequest{Enabled: true, Body: " \n"}, []string{"body"}},
+		{"rejects multi-line subjects", models.VacationResponderRequest{Subject: "Away\nfor now"}, []string{"subject"}},
+		{"rejects long subjects", models.VacationResponderRequest{Subject: strings.Repeat("x", maxVacationSubjectLength+1)}, []string{"subject"}},
+		{"rejects long bodies", models.VacationResponderRequest{Body: strings.Repeat("x", maxVacationBodyLength+1)}, []string{"body"}},
+		{"rejects negative intervals", models.VacationResponderRequest{IntervalDays: -1}, []string{"interval_days"}},
+		{"rejects long intervals", models.VacationResponderRequest{IntervalDays: maxVacationIntervalDays + 1}, []string{"interval_days"}},
+		{"rejects ending before starting", models.VacationResponderRequest{StartsAt: &endsAt, EndsAt: &startsAt}, []string{"ends_at"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fieldErrors := validateVacationRequest(&tc.req)
+			if len(fieldErrors) != len(tc.invalidFields) {
+				t.Fatalf("Expected invalid fields %v, got %v", tc.invalidFields, fieldErrors)
+			}
+			for _, field := range tc.invalidFields {
+				if _, ok := fieldErrors[field]; !ok {
+					t.Errorf("Expected %s to be invalid, got %v", field, fieldErrors)
+				}
+			}
+		})
+	}
+}
+
+func TestVacationHandler(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	email := "vacation-user@example.com"
+	handler := NewVacationHandler(pool, getTestEncryptor(t))
+	serve := func(method, body string, fn func(http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/vacation", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserEmailKey, email))
+		rr := httptest.NewRecorder()
+		fn(rr, req)
+		return rr
+	}
+
+	t.Run("returns a disabled responder by default", func(t *testing.T) {
+		rr := serve("GET", "", handler.GetVacation)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+		var responder models.VacationResponder
+		if err := json.Unmarshal(rr.Body.Bytes(), &responder); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if responder.Enabled || responder.IntervalDays != 7 {
+			t.Errorf("Expected a disabled responder with the default interval, got %+v", responder)
+		}
+	})
+
+	t.Run("saves a responder that the backend runs without a ManageSieve server", func(t *testing.T) {
+		rr := serve("PUT", `{"enabled": true, "subject": " Out of office ", "body": "Back on Monday."}`, handler.UpdateVacation)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		rr = serve("GET", "", handler.GetVacation)
+		var responder models.VacationResponder
+		if err := json.Unmarshal(rr.Body.Bytes(), &responder); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if !responder.Enabled || responder.Subject != "Out of office" || responder.IntervalDays != 7 || responder.Mode != models.VacationModeBackend {
+			t.Errorf("Unexpected responder: %+v", responder)
+		}
+	})
+
+	t.Run("rejects invalid responders", func(t *testing.T) {
+		rr := serve("PUT", `{"enabled": true}`, handler.UpdateVacation)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	})
+}
@@ -2,41 +2,56 @@ package api
 
 import (
 	"context"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/activity"
 	"github.com/vdavid/vmail/backend/internal/auth"
+	"github.com/vdavid/vmail/backend/internal/crypto"
 	"github.com/vdavid/vmail/backend/internal/db"
 	"github.com/vdavid/vmail/backend/internal/imap"
+	"github.com/vdavid/vmail/backend/internal/logging"
 	ws "github.com/vdavid/vmail/backend/internal/websocket"
 )
 
 // WebSocketHandler handles the /api/v1/ws endpoint for real-time updates.
 type WebSocketHandler struct {
-	pool *pgxpool.Pool
-	imap interface {
+	pool      *pgxpool.Pool
+	encryptor *crypto.Encryptor
+	imap      interface {
 		imap.IMAPService
 		StartIdleListener(ctx context.Context, userID string, hub *ws.Hub)
 	}
 	hub         *ws.Hub
+	activity    *activity.Tracker
 	mu          sync.Mutex
 	idleCancels map[string]context.CancelFunc
 }
 
 // NewWebSocketHandler creates a new WebSocketHandler instance.
-func NewWebSocketHandler(pool *pgxpool.Pool, imapService imap.IMAPService, hub *ws.Hub) *WebSocketHandler {
+// The encryptor signs the tokens of IssueToken.
+func NewWebSocketHandler(pool *pgxpool.Pool, encryptor *crypto.Encryptor, imapService imap.IMAPService, hub *ws.Hub) *WebSocketHandler {
 	return &WebSocketHandler{
 		pool:        pool,
+		encryptor:   encryptor,
 		imap:        imapService,
 		hub:         hub,
 		idleCancels: make(map[string]context.CancelFunc),
 	}
 }
 
+// SetActivityTracker makes the handler record open connections in tracker, so that users with the app open count
+// as active. Call it before serving.
+func (h *WebSocketHandler) SetActivityTracker(tracker *activity.Tracker) {
+	h.activity = tracker
+}
+
 var wsUpgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		// For now, allow all origins. This server is expected to be used
@@ -48,51 +63,21 @@ var wsUpgrader = websocket.Upgrader{
 
 // Handle upgrades the HTTP connection to a WebSocket and registers it with the Hub.
 // Authentication is handled via query parameter (?token=...) since WebSocket connections
-// cannot set custom headers in browsers.
-// The token is validated using the same ValidateToken function used by the RequireAuth middleware.
+// cannot set custom headers in browsers. The token is a short-lived, single-use one from IssueToken,
+// so that the URL, which proxies tend to log, never carries the long-lived credentials.
 func (h *WebSocketHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	// Extract token from the query parameter
-	token := r.URL.Query().Get("token")
-	if token == "" {
-		// Fallback to Authorization header if query parameter is not provided.
-		// This allows testing with tools that can set headers.
-		authHeader := r.Header.Get("Authorization")
-		if authHeader != "" {
-			fields := strings.Fields(authHeader)
-			if len(fields) >= 2 && strings.EqualFold(fields[0], "Bearer") {
-				token = strings.TrimSpace(strings.Join(fields[1:], " "))
-			}
-		}
-	}
-
-	if token == "" {
-		log.Printf("WebSocketHandler: No token provided (neither query parameter nor Authorization header)")
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	// Validate token using the same function as the RequireAuth middleware.
-	userEmail, err := auth.ValidateToken(token)
-	if err != nil {
-		log.Printf("WebSocketHandler: Token validation failed: %v", err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	// Get or create the user by their email address.
-	userID, err := db.GetOrCreateUser(ctx, h.pool, userEmail)
-	if err != nil {
-		log.Printf("WebSocketHandler: Failed to get/create user: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	userID, userEmail, ok := h.authenticate(w, r)
+	if !ok {
 		return
 	}
+	ctx = logging.WithUserID(ctx, userID)
 
 	// Upgrade the connection to a WebSocket
 	conn, err := wsUpgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocketHandler: failed to upgrade connection for user %s: %v", userID, err)
+		slog.ErrorContext(ctx, "WebSocketHandler: Failed to upgrade connection", "error", err)
 		return
 	}
 
@@ -102,9 +87,12 @@ func (h *WebSocketHandler) Handle(w http.ResponseWriter, r *http.Request) {
 
 	client := h.hub.Register(userID, conn)
 	if client == nil {
-		log.Printf("WebSocketHandler: Connection rejected for user %s (max connections exceeded)", userID)
+		slog.WarnContext(ctx, "WebSocketHandler: Connection rejected, max connections exceeded")
 		return
 	}
+	if h.activity != nil {
+		h.activity.Connect(userEmail)
+	}
 
 	// Ensure an IDLE listener is running for this user.
 	h.ensureIdleListener(userID)
@@ -113,17 +101,72 @@ func (h *WebSocketHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	// This ensures emails that arrived while no WebSocket was connected are synced.
 	if isFirstConnection {
 		go func() {
-			// Use background context to avoid cancellation if the request context is canceled.
+			// Don't let the request's cancellation stop the sync, but keep its request ID for the logs.
 			// The sync should complete even if the WebSocket connection is established.
-			syncCtx := context.Background()
-			if err := h.imap.SyncThreadsForFolder(syncCtx, userID, "INBOX"); err != nil {
-				log.Printf("WebSocketHandler: Failed to sync INBOX for user %s on connection: %v", userID, err)
+			syncCtx := context.WithoutCancel(ctx)
+			err := h.imap.SyncThreadsForFolder(syncCtx, userID, "INBOX")
+			if err != nil && !errors.Is(err, imap.ErrFolderSyncDisabled) && !errors.Is(err, imap.ErrSyncThrottled) {
+				slog.WarnContext(syncCtx, "WebSocketHandler: Failed to sync INBOX on connection", "error", err)
 			}
 		}()
 	}
 
 	// Read loop to keep the connection open and detect disconnects.
-	go h.readLoop(userID, client)
+	go h.readLoop(userID, userEmail, client)
+}
+
+// authenticate returns the ID and the email of the user that the token query parameter belongs to, and uses up
+// the token. Tools that can set headers can use the Authorization header like for the REST API instead.
+// Writes an error response and returns false if the request isn't authenticated.
+func (h *WebSocketHandler) authenticate(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	ctx := r.Context()
+
+	if token := r.URL.Query().Get("token"); token != "" {
+		tokenID, userID, err := parseWSToken(h.encryptor, token, time.Now())
+		if err != nil {
+			slog.WarnContext(ctx, "WebSocketHandler: Token validation failed", "error", err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return "", "", false
+		}
+		userEmail, err := db.UseWSToken(ctx, h.pool, userID, tokenID)
+		if errors.Is(err, db.ErrWSTokenNotFound) {
+			slog.WarnContext(ctx, "WebSocketHandler: Token was used, revoked, or expired")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return "", "", false
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "WebSocketHandler: Failed to use token", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return "", "", false
+		}
+		return userID, userEmail, true
+	}
+
+	// Validate the header using the same function as the RequireAuth middleware.
+	var token string
+	if fields := strings.Fields(r.Header.Get("Authorization")); len(fields) >= 2 && strings.EqualFold(fields[0], "Bearer") {
+		token = strings.TrimSpace(strings.Join(fields[1:], " "))
+	}
+	if token == "" {
+		slog.InfoContext(ctx, "WebSocketHandler: No token provided (neither query parameter nor Authorization header)")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", "", false
+	}
+	userEmail, err := auth.ValidateToken(token)
+	if err != nil {
+		slog.WarnContext(ctx, "WebSocketHandler: Token validation failed", "error", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", "", false
+	}
+
+	// Get or create the user by their email address.
+	userID, err := db.GetOrCreateUser(ctx, h.pool, userEmail)
+	if err != nil {
+		slog.ErrorContext(ctx, "WebSocketHandler: Failed to get/create user", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return "", "", false
+	}
+	return userID, userEmail, true
 }
 
 // ensureIdleListener starts an IMAP IDLE listener for the user if one is not already running.
@@ -152,7 +195,7 @@ func (h *WebSocketHandler) ensureIdleListener(userID string) {
 // readLoop reads messages from the WebSocket until the connection is closed.
 // When the connection closes, it unregisters the client and may stop the IDLE listener
 // if there are no more active connections for the user.
-func (h *WebSocketHandler) readLoop(userID string, client *ws.Client) {
+func (h *WebSocketHandler) readLoop(userID, userEmail string, client *ws.Client) {
 	conn := client.Conn()
 
 	for {
@@ -163,11 +206,14 @@ func (h *WebSocketHandler) readLoop(userID string, client *ws.Client) {
 
 	// Unregister the client and close the connection.
 	h.hub.Unregister(userID, client)
+	if h.activity != nil {
+		h.activity.Disconnect(userEmail)
+	}
 
 	// If there are no active connections left for this user, stop the IDLE listener.
 	if h.hub.ActiveConnections(userID) == 0 {
 		// Temporary logging
-		log.Printf("WebSocketHandler: No active connections remaining for user %s, stopping IDLE listener", userID)
+		slog.Info("WebSocketHandler: No active connections remaining, stopping IDLE listener", "user_id", userID)
 		h.mu.Lock()
 		if cancel, exists := h.idleCancels[userID]; exists {
 			cancel()
@@ -2,8 +2,10 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
@@ -25,14 +27,38 @@ func TestWebSocketHandler_Connection(t *testing.T) {
 	}
 
 	hub := ws.NewHub(10)
-	handler := NewWebSocketHandler(pool, mockIMAP, hub)
+	handler := NewWebSocketHandler(pool, getTestEncryptor(t), mockIMAP, hub)
 
 	// Create test server
 	server := httptest.NewServer(http.HandlerFunc(handler.Handle))
 	defer server.Close()
 
+	// issueToken gets a token like the front end does before connecting
+	issueToken := func(t *testing.T) string {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handler.IssueToken(rr, createRequestWithUser("POST", "/api/v1/ws/token", "ws-test@example.com"))
+		var response models.WSTokenResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil || rr.Code != http.StatusOK {
+			t.Fatalf("Failed to issue token: status %d, %v", rr.Code, err)
+		}
+		return response.Token
+	}
+	// expectRejected dials with the token, and fails the test unless the server responds with 401
+	expectRejected := func(t *testing.T, token string) {
+		t.Helper()
+		_, resp, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"?token="+url.QueryEscape(token), nil)
+		if err == nil {
+			t.Fatal("Expected the connection to fail")
+		}
+		if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected status 401, got %v", resp)
+		}
+	}
+
 	// Convert http:// to ws://
-	wsURL := "ws" + server.URL[4:] + "?token=token"
+	firstToken := issueToken(t)
+	wsURL := "ws" + server.URL[4:] + "?token=" + url.QueryEscape(firstToken)
 
 	t.Run("connects successfully and stays open", func(t *testing.T) {
 		conn, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
@@ -118,9 +144,22 @@ func TestWebSocketHandler_Connection(t *testing.T) {
 	})
 
 	t.Run("rejects invalid token", func(t *testing.T) {
-		// TODO: This test is skipped because ValidateToken is currently a stub that accepts all tokens.
-		// Once proper token validation is implemented, this test should verify rejection of invalid tokens.
-		t.Skip("Token validation is not yet implemented - ValidateToken accepts all non-empty tokens")
+		expectRejected(t, "token")
+		expectRejected(t, issueToken(t)+"x")
+	})
+
+	t.Run("rejects used tokens", func(t *testing.T) {
+		expectRejected(t, firstToken)
+	})
+
+	t.Run("rejects revoked tokens", func(t *testing.T) {
+		token := issueToken(t)
+		rr := httptest.NewRecorder()
+		handler.RevokeTokens(rr, createRequestWithUser("DELETE", "/api/v1/ws/token", "ws-test@example.com"))
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", rr.Code)
+		}
+		expectRejected(t, token)
 	})
 }
 
@@ -149,6 +188,26 @@ func (m *mockIMAPServiceForWS) ShouldSyncFolder(context.Context, string, string)
 	return false, nil
 }
 
+func (m *mockIMAPServiceForWS) PreviewFolder(context.Context, string, string, int) ([]*models.Thread, int, error) {
+	return nil, 0, nil
+}
+
+func (m *mockIMAPServiceForWS) AppendToSent(context.Context, string, []byte, time.Time) error {
+	return nil
+}
+
+func (m *mockIMAPServiceForWS) SaveDraft(context.Context, string, []byte, string) error {
+	return nil
+}
+
+func (m *mockIMAPServiceForWS) DeleteDraft(context.Context, string, string) error {
+	return nil
+}
+
+func (m *mockIMAPServiceForWS) MoveMessages(_ context.Context, _ string, messages []imap.MessageToMove, destination imap.MoveDestination) (string, []int64, error) {
+	return destination.FolderName, make([]int64, len(messages)), nil
+}
+
 func (m *mockIMAPServiceForWS) SyncFullMessage(context.Context, string, string, int64) error {
 	return nil
 }
@@ -157,6 +216,18 @@ func (m *mockIMAPServiceForWS) SyncFullMessages(context.Context, string, []imap.
 	return nil
 }
 
+func (m *mockIMAPServiceForWS) StoreLabel(_ context.Context, _ string, messages []imap.MessageToSync, _ string, _ bool) ([]bool, error) {
+	return make([]bool, len(messages)), nil
+}
+
+func (m *mockIMAPServiceForWS) MarkJunk(context.Context, string, []imap.MessageToSync, bool) error {
+	return nil
+}
+
+func (m *mockIMAPServiceForWS) GetFolders(context.Context, string) ([]*models.Folder, error) {
+	return nil, nil
+}
+
 func (m *mockIMAPServiceForWS) Search(context.Context, string, string, int, int) ([]*models.Thread, int, error) {
 	return nil, 0, nil
 }
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/crypto"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// wsTokenTTL is how long a WebSocket token works. Clients fetch one right before connecting, so it can be short.
+const wsTokenTTL = time.Minute
+
+// errInvalidWSToken is returned by parseWSToken for tokens that are malformed, forged, or expired.
+var errInvalidWSToken = errors.New("invalid websocket token")
+
+// IssueToken issues a short-lived, single-use token for connecting to /api/v1/ws, so that the URL doesn't have to
+// carry the long-lived credentials. Browsers can't set headers on WebSocket connections, so the token goes in
+// the token query parameter.
+// The path is /api/v1/ws/token.
+func (h *WebSocketHandler) IssueToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	expiresAt := time.Now().Add(wsTokenTTL).Truncate(time.Second)
+	tokenID, err := db.CreateWSToken(ctx, h.pool, userID, expiresAt)
+	if err != nil {
+		slog.ErrorContext(ctx, "WebSocketHandler: Failed to create token", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	WriteJSONResponse(w, models.WSTokenResponse{
+		Token:     signWSToken(h.encryptor, tokenID, userID, expiresAt),
+		ExpiresAt: expiresAt,
+	})
+}
+
+// RevokeTokens revokes the user's unused WebSocket tokens, for example, when one may have leaked.
+// Connections that are already open stay open.
+// The path is /api/v1/ws/token.
+func (h *WebSocketHandler) RevokeTokens(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := GetUserIDFromContext(ctx, w, h.pool)
+	if !ok {
+		return
+	}
+
+	if _, err := db.RevokeWSTokens(ctx, h.pool, userID); err != nil {
+		slog.ErrorContext(ctx, "WebSocketHandler: Failed to revoke tokens", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// signWSToken returns the token for the ws_tokens row. The format is "<token_id>.<user_id>.<expiry>.<signature>",
+// where the expiry is a Unix timestamp, and the signature is the base64url-encoded signature of the rest.
+func signWSToken(encryptor *crypto.Encryptor, tokenID, userID string, expiresAt time.Time) string {
+	payload := fmt.Sprintf("%s.%s.%d", tokenID, userID, expiresAt.Unix())
+	return payload + "." + base64.RawURLEncoding.EncodeToString(encryptor.Sign([]byte(payload)))
+}
+
+// parseWSToken checks the signature and the expiry of a token from signWSToken, and returns its token and user IDs.
+// Whether the token is still unused is up to the database.
+func parseWSToken(encryptor *crypto.Encryptor, token string, now time.Time) (string, string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return "", "", errInvalidWSToken
+	}
+	payload := strings.Join(parts[:3], ".")
+	signature, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil || !encryptor.Verify([]byte(payload), signature) {
+		return "", "", errInvalidWSToken
+	}
+
+	expiry, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || !now.Before(time.Unix(expiry, 0)) {
+		return "", "", errInvalidWSToken
+	}
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWSToken(t *testing.T) {
+	encryptor := getTestEncryptor(t)
+	now := time.Now()
+	expiresAt := now.Add(wsTokenTTL)
+	token := signWSToken(encryptor, "token-id", "user-id", expiresAt)
+
+	t.Run("returns the IDs of valid tokens", func(t *testing.T) {
+		tokenID, userID, err := parseWSToken(encryptor, token, now)
+		if err != nil || tokenID != "token-id" || userID != "user-id" {
+			t.Errorf("Expected token-id and user-id, got %q, %q, and %v", tokenID, userID, err)
+		}
+	})
+
+	t.Run("rejects expired tokens", func(t *testing.T) {
+		if _, _, err := parseWSToken(encryptor, token, expiresAt.Add(time.Second)); err == nil {
+			t.Error("Expected an error for an expired token")
+		}
+	})
+
+	t.Run("rejects tampered and malformed tokens", func(t *testing.T) {
+		forged := strings.Replace(token, "user-id", "other-user-id", 1)
+		for _, bad := range []string{forged, token + "x", token[:len(token)-2], "token", "a.b.c.d", ""} {
+			if _, _, err := parseWSToken(encryptor, bad, now); err == nil {
+				t.Errorf("Expected an error for %q", bad)
+			}
+		}
+	})
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// APIKeyPrefix starts every API key, so that RequireAuthOrAPIKey can tell them from session tokens,
+// and secret scanners can spot leaked ones.
+const APIKeyPrefix = "vmk_"
+
+// apiKeySecretSize is how many random bytes an API key has.
+const apiKeySecretSize = 32
+
+// APIKeyDisplayLength is how many characters of a key we keep in plain text, so that users can tell their keys apart.
+const APIKeyDisplayLength = len(APIKeyPrefix) + 8
+
+// GenerateAPIKey returns a new random API key, like "vmk_" and 43 base64url characters.
+func GenerateAPIKey() (string, error) {
+	secret := make([]byte, apiKeySecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	return APIKeyPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// HashAPIKey returns the SHA-256 hash of the key, which is what we store instead of the key.
+// The keys are random, so they need no salt or slow hash.
+func HashAPIKey(key string) []byte {
+	hash := sha256.Sum256([]byte(key))
+	return hash[:]
+}
@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	first, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey failed: %v", err)
+	}
+	second, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey failed: %v", err)
+	}
+
+	if !strings.HasPrefix(first, APIKeyPrefix) || len(first) != len(APIKeyPrefix)+43 {
+		t.Errorf("Expected a key like vmk_ and 43 characters, got %q", first)
+	}
+	if first == second || string(HashAPIKey(first)) == string(HashAPIKey(second)) {
+		t.Error("Expected different keys and hashes")
+	}
+}
@@ -3,9 +3,10 @@ package auth
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 )
 
@@ -14,15 +15,38 @@ type contextKey string
 // UserEmailKey is the context key used to store the authenticated user's email.
 const UserEmailKey contextKey = "user_email"
 
+// apiKeyAuthKey is the context key that marks requests authenticated with an API key.
+const apiKeyAuthKey contextKey = "api_key_auth"
+
+// userGroupsKey is the context key used to store the Authelia groups of the authenticated user.
+const userGroupsKey contextKey = "user_groups"
+
+// APIKeyValidator returns the email of the user that the API key belongs to, or an error if the key is unknown,
+// revoked, or expired.
+type APIKeyValidator func(ctx context.Context, key string) (string, error)
+
 // RequireAuth middleware checks for a valid bearer token in the Authorization header.
 // It extracts the token, validates it, and stores the user's email in the request context
 // for use by downstream handlers. Returns 401 Unauthorized if authentication fails.
 func RequireAuth(next http.Handler) http.Handler {
+	return requireAuth(next, nil)
+}
+
+// RequireAuthOrAPIKey works like RequireAuth, but also accepts API keys in the Authorization header, for clients
+// that have no Authelia session, like CLI tools. Bearer tokens that start with APIKeyPrefix go to validateKey.
+func RequireAuthOrAPIKey(validateKey APIKeyValidator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return requireAuth(next, validateKey)
+	}
+}
+
+// requireAuth is RequireAuth, with API keys if validateKey isn't nil.
+func requireAuth(next http.Handler, validateKey APIKeyValidator) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 
 		if authHeader == "" {
-			log.Println("Auth: No Authorization header present")
+			slog.InfoContext(r.Context(), "Auth: No Authorization header present")
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
@@ -32,14 +56,14 @@ func RequireAuth(next http.Handler) http.Handler {
 		// Bearer scheme is case-insensitive per RFC 7235
 		fields := strings.Fields(authHeader)
 		if len(fields) < 2 {
-			log.Println("Auth: Invalid Authorization header format")
+			slog.InfoContext(r.Context(), "Auth: Invalid Authorization header format")
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
 		// Check if the scheme is "Bearer" (case-insensitive)
 		if !strings.EqualFold(fields[0], "Bearer") {
-			log.Println("Auth: Invalid Authorization header format")
+			slog.InfoContext(r.Context(), "Auth: Invalid Authorization header format")
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
@@ -48,50 +72,102 @@ func RequireAuth(next http.Handler) http.Handler {
 		// (though typically tokens don't, this is more robust)
 		token := strings.TrimSpace(strings.Join(fields[1:], " "))
 		if token == "" {
-			log.Println("Auth: Empty token after Bearer")
+			slog.InfoContext(r.Context(), "Auth: Empty token after Bearer")
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		userEmail, err := ValidateToken(token)
+		ctx := r.Context()
+		var userEmail string
+		var err error
+		if validateKey != nil && strings.HasPrefix(token, APIKeyPrefix) {
+			userEmail, err = validateKey(ctx, token)
+			ctx = context.WithValue(ctx, apiKeyAuthKey, true)
+		} else {
+			var groups []string
+			userEmail, groups, err = validateSessionToken(token)
+			ctx = context.WithValue(ctx, userGroupsKey, groups)
+		}
 		if err != nil {
-			log.Printf("Auth: Token validation failed: %v", err)
+			slog.WarnContext(ctx, "Auth: Token validation failed", "error", err)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), UserEmailKey, userEmail)
+		ctx = context.WithValue(ctx, UserEmailKey, userEmail)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// IsAPIKeyAuth tells whether the request of the context was authenticated with an API key, not with a session.
+func IsAPIKeyAuth(ctx context.Context) bool {
+	apiKey, _ := ctx.Value(apiKeyAuthKey).(bool)
+	return apiKey
+}
+
+// RequireGroup middleware lets through only users in the Authelia group, and returns 403 Forbidden to the rest.
+// It goes after RequireAuth. Requests authenticated with an API key have no groups, so they never get through.
+// An empty group lets no one through.
+func RequireGroup(group string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if group == "" || !slices.Contains(GetUserGroupsFromContext(r.Context()), group) {
+				slog.WarnContext(r.Context(), "Auth: User isn't in the required group", "group", group)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // GetUserEmailFromContext returns the user email from the context.
 func GetUserEmailFromContext(ctx context.Context) (string, bool) {
 	email, ok := ctx.Value(UserEmailKey).(string)
 	return email, ok
 }
 
+// GetUserGroupsFromContext returns the Authelia groups of the user, or nil if they have none,
+// or authenticated with an API key.
+func GetUserGroupsFromContext(ctx context.Context) []string {
+	groups, _ := ctx.Value(userGroupsKey).([]string)
+	return groups
+}
+
 // ValidateToken validates the token and returns the user's email.
 // This is a stub for now.
 // In test mode (VMAIL_TEST_MODE=true), if the token starts with "email:",
 // it extracts the email from the token (e.g., "email:user@example.com" -> "user@example.com").
 // Otherwise, it returns "test@example.com" as the default test user.
 func ValidateToken(token string) (string, error) {
+	email, _, err := validateSessionToken(token)
+	return email, err
+}
+
+// validateSessionToken is ValidateToken that also returns the user's Authelia groups.
+// This is a stub too, so users have no groups, except in test mode, where tokens can list them after the email,
+// like "email:admin@example.com;groups:vmail-admins,dev".
+func validateSessionToken(token string) (string, []string, error) {
 	if strings.TrimSpace(token) == "" || strings.TrimSpace(token) == "email:" {
-		return "", fmt.Errorf("token is empty")
+		return "", nil, fmt.Errorf("token is empty")
 	}
 
 	// In test mode, support extracting email from token format "email:user@example.com"
 	if os.Getenv("VMAIL_TEST_MODE") == "true" {
 		if strings.HasPrefix(token, "email:") {
-			email := strings.TrimPrefix(token, "email:")
-			if email != "" {
-				return email, nil
+			email, groupList, hasGroups := strings.Cut(strings.TrimPrefix(token, "email:"), ";groups:")
+			if strings.TrimSpace(email) == "" {
+				return "", nil, fmt.Errorf("token has no email")
+			}
+			var groups []string
+			if hasGroups && groupList != "" {
+				groups = strings.Split(groupList, ",")
 			}
+			return email, groups, nil
 		}
 	}
 
-	// TODO: Implement token validation
+	// TODO: Implement token validation, and get the groups from Authelia
 
-	return "test@example.com", nil
+	return "test@example.com", nil, nil
 }
@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -213,6 +215,21 @@ func TestValidateToken(t *testing.T) {
 		}
 	})
 
+	t.Run("extracts groups from token when VMAIL_TEST_MODE=true", func(t *testing.T) {
+		t.Setenv("VMAIL_TEST_MODE", "true")
+
+		email, groups, err := validateSessionToken("email:admin@example.com;groups:vmail-admins,dev")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if email != "admin@example.com" || len(groups) != 2 || groups[0] != "vmail-admins" || groups[1] != "dev" {
+			t.Errorf("Expected admin@example.com in two groups, got %s in %v", email, groups)
+		}
+		if _, err := ValidateToken("email:;groups:vmail-admins"); err == nil {
+			t.Error("Expected error for token with groups but no email")
+		}
+	})
+
 	t.Run("returns error for empty token", func(t *testing.T) {
 		testCases := []string{"", "   ", "\t", "\n"}
 		for _, token := range testCases {
@@ -247,3 +264,101 @@ func TestValidateToken(t *testing.T) {
 		}
 	})
 }
+
+func TestRequireAuthOrAPIKey(t *testing.T) {
+	validKey, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey failed: %v", err)
+	}
+	validateKey := func(_ context.Context, key string) (string, error) {
+		if key != validKey {
+			return "", errors.New("unknown key")
+		}
+		return "cli@example.com", nil
+	}
+
+	var gotEmail string
+	var gotAPIKeyAuth bool
+	handler := RequireAuthOrAPIKey(validateKey)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEmail, _ = GetUserEmailFromContext(r.Context())
+		gotAPIKeyAuth = IsAPIKeyAuth(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(token string) int {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	t.Run("accepts API keys", func(t *testing.T) {
+		if code := serve(validKey); code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", code)
+		}
+		if gotEmail != "cli@example.com" || !gotAPIKeyAuth {
+			t.Errorf("Expected the key's user with API key auth, got %q and %v", gotEmail, gotAPIKeyAuth)
+		}
+	})
+
+	t.Run("rejects unknown API keys instead of treating them as session tokens", func(t *testing.T) {
+		if code := serve(APIKeyPrefix + "unknown"); code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401, got %d", code)
+		}
+	})
+
+	t.Run("still accepts session tokens", func(t *testing.T) {
+		if code := serve("valid_token_12345"); code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", code)
+		}
+		if gotAPIKeyAuth {
+			t.Error("Expected session auth")
+		}
+	})
+}
+
+func TestRequireGroup(t *testing.T) {
+	t.Setenv("VMAIL_TEST_MODE", "true")
+
+	handler := RequireAuth(RequireGroup("vmail-admins")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	serve := func(token string) int {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	t.Run("lets through users in the group", func(t *testing.T) {
+		if code := serve("email:admin@example.com;groups:dev,vmail-admins"); code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", code)
+		}
+	})
+
+	t.Run("rejects users in other groups", func(t *testing.T) {
+		if code := serve("email:dev@example.com;groups:dev"); code != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", code)
+		}
+	})
+
+	t.Run("rejects users without groups", func(t *testing.T) {
+		if code := serve("email:user@example.com"); code != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", code)
+		}
+	})
+
+	t.Run("rejects everyone if the group is empty", func(t *testing.T) {
+		handler := RequireAuth(RequireGroup("")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})))
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("Authorization", "Bearer email:admin@example.com;groups:vmail-admins")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,322 @@
+// Package autoconfig finds the IMAP and SMTP servers of an email address, so that users don't have to look them up.
+package autoconfig
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/netguard"
+)
+
+// Sources of Settings.
+const (
+	SourceProvider   = "provider"
+	SourceAutoconfig = "autoconfig"
+	SourceSRV        = "srv"
+)
+
+// discoverTimeout limits how long Discover takes in total.
+const discoverTimeout = 10 * time.Second
+
+// maxConfigSize limits the size of autoconfig files. Real ones are a few kilobytes.
+const maxConfigSize = 64 * 1024
+
+// ErrNotFound is returned when none of the sources know the servers of the domain.
+var ErrNotFound = errors.New("no mail server settings found for the domain")
+
+// ErrInvalidEmail is returned for addresses that aren't valid, or whose domain isn't a hostname.
+var ErrInvalidEmail = errors.New("invalid email address")
+
+// domainPattern matches hostnames with at least two labels, lowercase.
+var domainPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)+$`)
+
+// Settings are the servers of an email address, as "host:port", with the usernames to log in with.
+type Settings struct {
+	IMAPServerHostname string
+	IMAPUsername       string
+	SMTPServerHostname string
+	SMTPUsername       string
+	// Source is where we found the settings, one of the Source constants.
+	Source string
+}
+
+// provider is a mail provider whose servers we know.
+type provider struct {
+	domains            []string
+	imapServerHostname string
+	smtpServerHostname string
+}
+
+// providers are the big providers, so that we don't need the network for most users. They all take the email
+// address as the username.
+var providers = []provider{
+	{[]string{"gmail.com", "googlemail.com"}, "imap.gmail.com:993", "smtp.gmail.com:465"},
+	{[]string{"outlook.com", "hotmail.com", "live.com", "msn.com"}, "outlook.office365.com:993", "smtp.office365.com:587"},
+	{[]string{"yahoo.com", "ymail.com"}, "imap.mail.yahoo.com:993", "smtp.mail.yahoo.com:465"},
+	{[]string{"icloud.com", "me.com", "mac.com"}, "imap.mail.me.com:993", "smtp.mail.me.com:587"},
+	{[]string{"fastmail.com", "fastmail.fm"}, "imap.fastmail.com:993", "smtp.fastmail.com:465"},
+	{[]string{"aol.com"}, "imap.aol.com:993", "smtp.aol.com:465"},
+}
+
+// srvResolver is the part of net.Resolver that Discoverer uses, so that tests can fake DNS.
+type srvResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// Discoverer finds the servers of email addresses.
+type Discoverer struct {
+	httpClient *http.Client
+	resolver   srvResolver
+	// autoconfigURLs returns the URLs to try for autoconfig files, in order.
+	autoconfigURLs func(domain, email string) []string
+}
+
+// NewDiscoverer creates a new Discoverer that uses the network.
+// Its HTTP client only connects to public addresses, since the domains come from users.
+func NewDiscoverer() *Discoverer {
+	return &Discoverer{
+		httpClient: &http.Client{
+			Transport: netguard.NewTransport(),
+			// Plain HTTP could be tampered with to send the user's password to someone else's server
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if req.URL.Scheme != "https" {
+					return fmt.Errorf("refusing to follow a redirect to %s", req.URL.Scheme)
+				}
+				if len(via) >= 5 {
+					return errors.New("too many redirects")
+				}
+				return nil
+			},
+		},
+		resolver:       net.DefaultResolver,
+		autoconfigURLs: defaultAutoconfigURLs,
+	}
+}
+
+// defaultAutoconfigURLs returns the places where Thunderbird looks for autoconfig files, HTTPS only.
+func defaultAutoconfigURLs(domain, email string) []string {
+	query := url.Values{"emailaddress": {email}}.Encode()
+	return []string{
+		"https://autoconfig." + domain + "/mail/config-v1.1.xml?" + query,
+		"https://" + domain + "/.well-known/autoconfig/mail/config-v1.1.xml",
+	}
+}
+
+// Discover finds the servers of the email address. It tries the built-in providers, then Mozilla autoconfig
+// files on the domain, then DNS SRV records (RFC 6186), and returns the first that has both an IMAP server
+// with TLS and an SMTP server with TLS or STARTTLS. Returns ErrNotFound if none has them.
+func (d *Discoverer) Discover(ctx context.Context, email string) (*Settings, error) {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return nil, ErrInvalidEmail
+	}
+	at := strings.LastIndex(email, "@")
+	domain := strings.ToLower(email[at+1:])
+	if !domainPattern.MatchString(domain) {
+		return nil, ErrInvalidEmail
+	}
+
+	if settings := fromProviders(domain, email); settings != nil {
+		return settings, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, discoverTimeout)
+	defer cancel()
+
+	settings, err := d.fromAutoconfig(ctx, domain, email)
+	if err == nil {
+		return settings, nil
+	}
+	slog.DebugContext(ctx, "No autoconfig for the domain", "domain", domain, "error", err)
+
+	settings, err = d.fromSRV(ctx, domain, email)
+	if err == nil {
+		return settings, nil
+	}
+	slog.DebugContext(ctx, "No SRV records for the domain", "domain", domain, "error", err)
+
+	return nil, ErrNotFound
+}
+
+// fromProviders returns the settings of the built-in provider of the domain, or nil if there's none.
+func fromProviders(domain, email string) *Settings {
+	for _, p := range providers {
+		for _, d := range p.domains {
+			if d == domain {
+				return &Settings{
+					IMAPServerHostname: p.imapServerHostname,
+					IMAPUsername:       email,
+					SMTPServerHostname: p.smtpServerHostname,
+					SMTPUsername:       email,
+					Source:             SourceProvider,
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// clientConfig is the part of a Mozilla autoconfig file that we use.
+// See https://wiki.mozilla.org/Thunderbird:Autoconfiguration:ConfigFileFormat
+type clientConfig struct {
+	IncomingServers []configServer `xml:"emailProvider>incomingServer"`
+	OutgoingServers []configServer `xml:"emailProvider>outgoingServer"`
+}
+
+// configServer is a server in an autoconfig file. Username can have %EMAILADDRESS%, %EMAILLOCALPART%,
+// and %EMAILDOMAIN% placeholders.
+type configServer struct {
+	Type       string `xml:"type,attr"`
+	Hostname   string `xml:"hostname"`
+	Port       int    `xml:"port"`
+	SocketType string `xml:"socketType"`
+	Username   string `xml:"username"`
+}
+
+// fromAutoconfig fetches the autoconfig files of the domain, and returns the settings of the first one
+// that has servers we can use.
+func (d *Discoverer) fromAutoconfig(ctx context.Context, domain, email string) (*Settings, error) {
+	var lastErr error
+	for _, configURL := range d.autoconfigURLs(domain, email) {
+		config, err := d.fetchConfig(ctx, configURL)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		settings, err := config.settings(email)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return settings, nil
+	}
+	return nil, lastErr
+}
+
+// fetchConfig downloads and parses the autoconfig file at configURL.
+func (d *Discoverer) fetchConfig(ctx context.Context, configURL string) (*clientConfig, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, configURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", configURL, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: status %d", configURL, resp.StatusCode)
+	}
+
+	var config clientConfig
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, maxConfigSize)).Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", configURL, err)
+	}
+	return &config, nil
+}
+
+// settings returns the first IMAP server with TLS and the first SMTP server with TLS or STARTTLS in the config.
+// Our IMAP client doesn't do STARTTLS.
+func (c *clientConfig) settings(email string) (*Settings, error) {
+	settings := &Settings{Source: SourceAutoconfig}
+	for _, server := range c.IncomingServers {
+		if server.Type == "imap" && server.Hostname != "" && strings.EqualFold(server.SocketType, "SSL") {
+			settings.IMAPServerHostname = serverAddress(server.Hostname, server.Port, 993)
+			settings.IMAPUsername = expandUsername(server.Username, email)
+			break
+		}
+	}
+	for _, server := range c.OutgoingServers {
+		socketType := strings.ToUpper(server.SocketType)
+		if server.Type == "smtp" && server.Hostname != "" && (socketType == "SSL" || socketType == "STARTTLS") {
+			defaultPort := 587
+			if socketType == "SSL" {
+				defaultPort = 465
+			}
+			settings.SMTPServerHostname = serverAddress(server.Hostname, server.Port, defaultPort)
+			settings.SMTPUsername = expandUsername(server.Username, email)
+			break
+		}
+	}
+
+	if settings.IMAPServerHostname == "" || settings.SMTPServerHostname == "" {
+		return nil, errors.New("no IMAP server with TLS or no SMTP server with TLS or STARTTLS in the config")
+	}
+	return settings, nil
+}
+
+// expandUsername replaces the placeholders of autoconfig usernames. An empty username means the email address.
+func expandUsername(username, email string) string {
+	if username == "" {
+		return email
+	}
+	localPart, domain, _ := strings.Cut(email, "@")
+	return strings.NewReplacer(
+		"%EMAILADDRESS%", email,
+		"%EMAILLOCALPART%", localPart,
+		"%EMAILDOMAIN%", domain,
+	).Replace(username)
+}
+
+// fromSRV looks up the RFC 6186 SRV records of the domain. IMAP needs _imaps, and SMTP takes _submissions
+// (RFC 8314) over _submission, which is STARTTLS.
+func (d *Discoverer) fromSRV(ctx context.Context, domain, email string) (*Settings, error) {
+	imapServer, err := d.lookupSRV(ctx, "imaps", domain)
+	if err != nil {
+		return nil, err
+	}
+	smtpServer, err := d.lookupSRV(ctx, "submissions", domain)
+	if err != nil {
+		smtpServer, err = d.lookupSRV(ctx, "submission", domain)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Settings{
+		IMAPServerHostname: imapServer,
+		IMAPUsername:       email,
+		SMTPServerHostname: smtpServer,
+		SMTPUsername:       email,
+		Source:             SourceSRV,
+	}, nil
+}
+
+// lookupSRV returns the "host:port" of the service's SRV record with the highest priority.
+// A target of "." means that the domain doesn't offer the service.
+func (d *Discoverer) lookupSRV(ctx context.Context, service, domain string) (string, error) {
+	_, records, err := d.resolver.LookupSRV(ctx, service, "tcp", domain)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up _%s._tcp.%s: %w", service, domain, err)
+	}
+	// The resolver sorts them by priority and weight
+	for _, record := range records {
+		target := strings.TrimSuffix(record.Target, ".")
+		if target != "" {
+			return serverAddress(target, int(record.Port), 0), nil
+		}
+	}
+	return "", fmt.Errorf("_%s._tcp.%s has no target", service, domain)
+}
+
+// serverAddress joins the host and the port, or the default port if port is 0.
+func serverAddress(host string, port, defaultPort int) string {
+	if port == 0 {
+		port = defaultPort
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
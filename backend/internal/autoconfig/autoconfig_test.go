@@ -0,0 +1,154 @@
+package autoconfig
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/netguard"
+)
+
+// fakeResolver returns the records of each "_service._proto.name", and an error for the others.
+type fakeResolver map[string][]*net.SRV
+
+func (f fakeResolver) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	records, ok := f["_"+service+"._"+proto+"."+name]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return "", records, nil
+}
+
+// newTestDiscoverer returns a Discoverer whose autoconfig files come from the handler, and whose DNS is the resolver.
+func newTestDiscoverer(t *testing.T, handler http.HandlerFunc, resolver fakeResolver) *Discoverer {
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+	return &Discoverer{
+		httpClient: server.Client(),
+		resolver:   resolver,
+		autoconfigURLs: func(domain, email string) []string {
+			return []string{server.URL + "/missing", server.URL + "/mail/config-v1.1.xml?emailaddress=" + email}
+		},
+	}
+}
+
+const testConfig = `<?xml version="1.0"?>
+<clientConfig version="1.1">
+  <emailProvider id="example.com">
+    <domain>example.com</domain>
+    <incomingServer type="pop3">
+      <hostname>pop.example.com</hostname>
+      <port>995</port>
+      <socketType>SSL</socketType>
+    </incomingServer>
+    <incomingServer type="imap">
+      <hostname>imap.example.com</hostname>
+      <port>143</port>
+      <socketType>STARTTLS</socketType>
+    </incomingServer>
+    <incomingServer type="imap">
+      <hostname>imap.example.com</hostname>
+      <port>993</port>
+      <socketType>SSL</socketType>
+      <username>%EMAILLOCALPART%</username>
+    </incomingServer>
+    <outgoingServer type="smtp">
+      <hostname>smtp.example.com</hostname>
+      <port>587</port>
+      <socketType>STARTTLS</socketType>
+      <username>%EMAILADDRESS%</username>
+    </outgoingServer>
+  </emailProvider>
+</clientConfig>`
+
+func TestDiscover(t *testing.T) {
+	t.Run("knows the big providers without the network", func(t *testing.T) {
+		d := &Discoverer{}
+		settings, err := d.Discover(context.Background(), "someone@GMail.com")
+		if err != nil {
+			t.Fatalf("Discover failed: %v", err)
+		}
+		if settings.IMAPServerHostname != "imap.gmail.com:993" || settings.SMTPServerHostname != "smtp.gmail.com:465" ||
+			settings.IMAPUsername != "someone@GMail.com" || settings.Source != SourceProvider {
+			t.Errorf("Unexpected settings: %+v", settings)
+		}
+	})
+
+	t.Run("reads autoconfig files", func(t *testing.T) {
+		d := newTestDiscoverer(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/mail/config-v1.1.xml" {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write([]byte(testConfig))
+		}, nil)
+
+		settings, err := d.Discover(context.Background(), "someone@example.com")
+		if err != nil {
+			t.Fatalf("Discover failed: %v", err)
+		}
+		want := Settings{
+			IMAPServerHostname: "imap.example.com:993",
+			IMAPUsername:       "someone",
+			SMTPServerHostname: "smtp.example.com:587",
+			SMTPUsername:       "someone@example.com",
+			Source:             SourceAutoconfig,
+		}
+		if *settings != want {
+			t.Errorf("Expected %+v, got %+v", want, settings)
+		}
+	})
+
+	t.Run("falls back to SRV records", func(t *testing.T) {
+		d := newTestDiscoverer(t, http.NotFound, fakeResolver{
+			"_imaps._tcp.example.com": {
+				{Target: "imap.example.com.", Port: 993, Priority: 0},
+				{Target: "imap-backup.example.com.", Port: 993, Priority: 10},
+			},
+			"_submissions._tcp.example.com": {{Target: ".", Port: 0}},
+			"_submission._tcp.example.com":  {{Target: "smtp.example.com.", Port: 587}},
+		})
+
+		settings, err := d.Discover(context.Background(), "someone@example.com")
+		if err != nil {
+			t.Fatalf("Discover failed: %v", err)
+		}
+		if settings.IMAPServerHostname != "imap.example.com:993" || settings.SMTPServerHostname != "smtp.example.com:587" ||
+			settings.Source != SourceSRV {
+			t.Errorf("Unexpected settings: %+v", settings)
+		}
+	})
+
+	t.Run("returns ErrNotFound if nothing knows the domain", func(t *testing.T) {
+		d := newTestDiscoverer(t, http.NotFound, fakeResolver{
+			"_imaps._tcp.example.com": {{Target: "imap.example.com.", Port: 993}},
+		})
+		if _, err := d.Discover(context.Background(), "someone@example.com"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("rejects invalid addresses", func(t *testing.T) {
+		d := &Discoverer{}
+		for _, email := range []string{"", "someone", "Someone <someone@example.com>", "someone@localhost", "someone@example.com/path"} {
+			if _, err := d.Discover(context.Background(), email); !errors.Is(err, ErrInvalidEmail) {
+				t.Errorf("Expected ErrInvalidEmail for %q, got %v", email, err)
+			}
+		}
+	})
+}
+
+func TestNewDiscovererOnlyConnectsToPublicAddresses(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected no request to reach the local server")
+	}))
+	defer server.Close()
+
+	d := NewDiscoverer()
+	if _, err := d.fetchConfig(context.Background(), server.URL); !errors.Is(err, netguard.ErrNotPublic) {
+		t.Errorf("Expected ErrNotPublic, got %v", err)
+	}
+}
@@ -3,12 +3,23 @@ package config
 import (
 	"encoding/base64"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/vdavid/vmail/backend/internal/logging"
+	"github.com/vdavid/vmail/backend/internal/maintenance"
+)
+
+// The places where rate limits can be kept.
+const (
+	RateLimitStoreMemory   = "memory"
+	RateLimitStorePostgres = "postgres"
 )
 
 // Config holds the application configuration loaded from environment variables.
@@ -19,6 +30,10 @@ type Config struct {
 	// EncryptionKeyBase64 is the base64-encoded encryption key used for encrypting/decrypting
 	// user credentials. Must be 32 bytes when decoded (44 characters in base64).
 	EncryptionKeyBase64 string
+	// EncryptionKeysBase64 is a comma-separated list of encryption keys like EncryptionKeyBase64, for rotating keys.
+	// The first one is the primary key that we encrypt with, and the others only decrypt what we encrypted before.
+	// If it's set, EncryptionKeyBase64 is ignored. See GetEncryptionKeys.
+	EncryptionKeysBase64 string
 	// AutheliaURL is the base URL of the Authelia authentication server.
 	AutheliaURL string
 	// DBHost is the PostgreSQL database hostname. Defaults to "localhost".
@@ -33,8 +48,15 @@ type Config struct {
 	DBName string
 	// DBSSLMode is the PostgreSQL SSL mode (disable, require, verify-full, etc.). Defaults to "disable".
 	DBSSLMode string
+	// RunMigrations makes the server apply the pending database migrations when it starts. Defaults to false,
+	// for setups that migrate with cmd/migrate or golang-migrate before deploying.
+	RunMigrations bool
 	// Port is the HTTP server port. Defaults to "11764".
 	Port string
+	// LogLevel is the lowest level of log lines we write: "debug", "info", "warn", or "error". Defaults to "info".
+	LogLevel string
+	// LogFormat is "text" for human-readable log lines, or "json" for log collectors. Defaults to "text".
+	LogFormat string
 	// Timezone is the application timezone (e.g., "UTC", "America/New_York"). Defaults to "UTC".
 	Timezone string
 	// IMAPMaxWorkers is the maximum number of IMAP worker connections per user.
@@ -42,6 +64,92 @@ type Config struct {
 	// be kept conservative to respect provider limits. In test environments it can
 	// be higher to speed up E2E tests.
 	IMAPMaxWorkers int
+	// IMAPOperationTimeoutSeconds is how long, in seconds, an IMAP operation can take, unless its caller sets its own
+	// deadline, like background syncs do. We close the connection of operations that take longer.
+	// Zero means no limit.
+	IMAPOperationTimeoutSeconds int
+	// SMTPMaxConnections is the maximum number of SMTP connections per user and account that we keep for reuse.
+	// Zero turns off reuse, so each message gets a new connection.
+	SMTPMaxConnections int
+	// ThreadsSyncBudgetMs is how long, in milliseconds, the thread list waits for a folder sync
+	// before it returns cached data and lets the sync finish in the background. Zero means no limit.
+	ThreadsSyncBudgetMs int
+	// IMAPMaxInFlightRequests is the maximum number of concurrent requests per user on IMAP-heavy endpoints.
+	// Zero means no limit.
+	IMAPMaxInFlightRequests int
+	// IMAPQueueTimeoutMs is how long, in milliseconds, a request over IMAPMaxInFlightRequests waits
+	// for a slot before it gets a 429.
+	IMAPQueueTimeoutMs int
+	// SyncIntervalSeconds is how often, in seconds, we sync INBOX and the folders that users enabled syncing for
+	// in the background. Zero turns off background syncing.
+	SyncIntervalSeconds int
+	// SyncMaxConcurrentUsers is the maximum number of users whose folders we sync in the background at the same time.
+	SyncMaxConcurrentUsers int
+	// SyncActiveIntervalSeconds is how often, in seconds, we sync the folders of users who have the app open.
+	// Zero means SyncIntervalSeconds.
+	SyncActiveIntervalSeconds int
+	// SyncDormantIntervalSeconds is how often, in seconds, we sync the folders of users who haven't used the app
+	// for a day. Zero means SyncIntervalSeconds.
+	SyncDormantIntervalSeconds int
+	// RateLimitPerMinute is how many API requests each user can make per minute, on average. Zero means no limit.
+	RateLimitPerMinute int
+	// RateLimitBurst is how many API requests each user can make at once, above the average rate.
+	RateLimitBurst int
+	// MaxRequestBodyBytes is the largest request body that API endpoints accept, unless they have their own limit below.
+	// Zero means no limit.
+	MaxRequestBodyBytes int
+	// MaxSendRequestBodyBytes is the largest request body for sending a message, including base64-encoded attachments.
+	MaxSendRequestBodyBytes int
+	// MaxDraftRequestBodyBytes is the largest request body for saving a draft.
+	MaxDraftRequestBodyBytes int
+	// MaxAttachmentUploadBytes is the largest file the compose form can upload as an attachment. Zero means no limit.
+	MaxAttachmentUploadBytes int
+	// AttachmentUploadQuotaBytes is how much space the uploaded attachments of a user can take up until they're sent,
+	// their draft is discarded, or they expire. Zero means no limit.
+	AttachmentUploadQuotaBytes int
+	// BodyCacheQuotaBytes is how much space the cached message bodies of a user can take up. Above it, we evict the
+	// least recently used bodies, and keep the headers. Zero means no limit.
+	BodyCacheQuotaBytes int
+	// ImageProxyMaxImageBytes is the largest remote image the image proxy serves. Zero means no limit.
+	ImageProxyMaxImageBytes int
+	// ImageProxyCacheBytes is how much memory the image proxy keeps recently fetched images in. Zero turns the cache off.
+	ImageProxyCacheBytes int
+	// TrustedAuthServIDs is a comma-separated list of the authserv-ids of mail servers whose Authentication-Results
+	// headers we trust, besides the IMAP host of each user, like "mx.google.com". See GetTrustedAuthServIDs.
+	TrustedAuthServIDs string
+	// IMAPMaxMessageBytes is the most we fetch of a message body. Of bigger messages, we only keep the start.
+	// Zero means no limit.
+	IMAPMaxMessageBytes int
+	// IMAPMaxPartBytes is the longest text or HTML body of a message we keep. Longer ones are cut. Zero means no limit.
+	IMAPMaxPartBytes int
+	// IMAPFetchBatchSize is how many messages a full sync fetches the headers of per command. Zero means all at once.
+	IMAPFetchBatchSize int
+	// IMAPFetchConnections is how many connections of the user a full sync fetches headers on at the same time.
+	IMAPFetchConnections int
+	// RateLimitStore is where the rate limits are kept: "memory" for one backend instance,
+	// or "postgres" to share them between instances. Empty means "memory".
+	RateLimitStore string
+	// MaintenanceWindow is a cron expression for when heavy jobs, like pruning, may start running.
+	// It's in the Timezone. Empty means heavy jobs run any time.
+	MaintenanceWindow string
+	// MaintenanceWindowMinutes is how long the maintenance window stays open after each start.
+	MaintenanceWindowMinutes int
+	// MaintenanceForce lets heavy jobs run outside the maintenance window, for emergencies.
+	MaintenanceForce bool
+	// AdminGroup is the Authelia group whose members can use the admin API.
+	AdminGroup string
+	// OAuthGoogleClientID and OAuthGoogleClientSecret are the OAuth client of the Google Cloud project
+	// that users connect their Gmail accounts through. Connecting with Google is off if the ID is empty.
+	OAuthGoogleClientID     string
+	OAuthGoogleClientSecret string
+	// OAuthMicrosoftClientID and OAuthMicrosoftClientSecret are the OAuth client of the Microsoft Entra app
+	// that users connect their Outlook and Office 365 accounts through. Connecting with Microsoft is off if the ID is empty.
+	OAuthMicrosoftClientID     string
+	OAuthMicrosoftClientSecret string
+	// VAPIDPrivateKey is the base64url-encoded P-256 private key that we sign Web Push messages with, and
+	// VAPIDSubject is the "mailto:" or "https:" URL that push services can reach us at. Web Push is off if the key is empty.
+	VAPIDPrivateKey string
+	VAPIDSubject    string
 }
 
 // NewConfig loads and returns a new Config instance from environment variables.
@@ -53,23 +161,72 @@ func NewConfig() (*Config, error) {
 
 	if env == "development" {
 		if err := godotenv.Load(); err != nil {
-			log.Printf("Warning: .env file not found, using environment variables")
+			slog.Warn(".env file not found, using environment variables")
 		}
 	}
 
 	config := &Config{
-		Environment:         env,
-		EncryptionKeyBase64: os.Getenv("VMAIL_ENCRYPTION_KEY_BASE64"),
-		AutheliaURL:         os.Getenv("AUTHELIA_URL"),
-		DBHost:              getEnvOrDefault("VMAIL_DB_HOST", "localhost"),
-		DBPort:              getEnvOrDefault("VMAIL_DB_PORT", "5432"),
-		DBUsername:          getEnvOrDefault("VMAIL_DB_USER", "vmail"),
-		DBPassword:          os.Getenv("VMAIL_DB_PASSWORD"),
-		DBName:              getEnvOrDefault("VMAIL_DB_NAME", "vmail"),
-		DBSSLMode:           getEnvOrDefault("VMAIL_DB_SSLMODE", "disable"),
-		Port:                getEnvOrDefault("PORT", "11764"),
-		Timezone:            getEnvOrDefault("TZ", "UTC"),
-		IMAPMaxWorkers:      getEnvOrDefaultInt("VMAIL_IMAP_MAX_WORKERS", 3),
+		Environment:             env,
+		EncryptionKeyBase64:     os.Getenv("VMAIL_ENCRYPTION_KEY_BASE64"),
+		EncryptionKeysBase64:    os.Getenv("VMAIL_ENCRYPTION_KEYS_BASE64"),
+		AutheliaURL:             os.Getenv("AUTHELIA_URL"),
+		AdminGroup:              getEnvOrDefault("VMAIL_ADMIN_GROUP", "vmail-admins"),
+		DBHost:                  getEnvOrDefault("VMAIL_DB_HOST", "localhost"),
+		DBPort:                  getEnvOrDefault("VMAIL_DB_PORT", "5432"),
+		DBUsername:              getEnvOrDefault("VMAIL_DB_USER", "vmail"),
+		DBPassword:              os.Getenv("VMAIL_DB_PASSWORD"),
+		DBName:                  getEnvOrDefault("VMAIL_DB_NAME", "vmail"),
+		DBSSLMode:               getEnvOrDefault("VMAIL_DB_SSLMODE", "disable"),
+		RunMigrations:           getEnvOrDefaultBool("VMAIL_RUN_MIGRATIONS", false),
+		Port:                    getEnvOrDefault("PORT", "11764"),
+		LogLevel:                getEnvOrDefault("VMAIL_LOG_LEVEL", "info"),
+		LogFormat:               getEnvOrDefault("VMAIL_LOG_FORMAT", "text"),
+		Timezone:                getEnvOrDefault("TZ", "UTC"),
+		IMAPMaxWorkers:          getEnvOrDefaultInt("VMAIL_IMAP_MAX_WORKERS", 3),
+		SMTPMaxConnections:      getEnvOrDefaultInt("VMAIL_SMTP_MAX_CONNECTIONS", 2),
+		ThreadsSyncBudgetMs:     getEnvOrDefaultInt("VMAIL_THREADS_SYNC_BUDGET_MS", 3000),
+		IMAPMaxInFlightRequests: getEnvOrDefaultInt("VMAIL_IMAP_MAX_IN_FLIGHT_REQUESTS", 6),
+		IMAPQueueTimeoutMs:      getEnvOrDefaultInt("VMAIL_IMAP_QUEUE_TIMEOUT_MS", 2000),
+		SyncIntervalSeconds:     getEnvOrDefaultInt("VMAIL_SYNC_INTERVAL_SECONDS", 300),
+		SyncMaxConcurrentUsers:  getEnvOrDefaultInt("VMAIL_SYNC_MAX_CONCURRENT_USERS", 4),
+
+		SyncActiveIntervalSeconds:  getEnvOrDefaultInt("VMAIL_SYNC_ACTIVE_INTERVAL_SECONDS", 60),
+		SyncDormantIntervalSeconds: getEnvOrDefaultInt("VMAIL_SYNC_DORMANT_INTERVAL_SECONDS", 3600),
+
+		IMAPOperationTimeoutSeconds: getEnvOrDefaultInt("VMAIL_IMAP_OPERATION_TIMEOUT_SECONDS", 120),
+
+		IMAPFetchBatchSize:   getEnvOrDefaultInt("VMAIL_IMAP_FETCH_BATCH_SIZE", 1000),
+		IMAPFetchConnections: getEnvOrDefaultInt("VMAIL_IMAP_FETCH_CONNECTIONS", 2),
+
+		RateLimitPerMinute: getEnvOrDefaultInt("VMAIL_RATE_LIMIT_PER_MINUTE", 600),
+		RateLimitBurst:     getEnvOrDefaultInt("VMAIL_RATE_LIMIT_BURST", 100),
+		RateLimitStore:     getEnvOrDefault("VMAIL_RATE_LIMIT_STORE", RateLimitStoreMemory),
+
+		MaxRequestBodyBytes:      getEnvOrDefaultInt("VMAIL_MAX_REQUEST_BODY_BYTES", 1<<20),
+		MaxSendRequestBodyBytes:  getEnvOrDefaultInt("VMAIL_MAX_SEND_REQUEST_BODY_BYTES", 35<<20),
+		MaxDraftRequestBodyBytes: getEnvOrDefaultInt("VMAIL_MAX_DRAFT_REQUEST_BODY_BYTES", 10<<20),
+		IMAPMaxMessageBytes:      getEnvOrDefaultInt("VMAIL_IMAP_MAX_MESSAGE_BYTES", 50<<20),
+		IMAPMaxPartBytes:         getEnvOrDefaultInt("VMAIL_IMAP_MAX_PART_BYTES", 5<<20),
+
+		MaxAttachmentUploadBytes:   getEnvOrDefaultInt("VMAIL_MAX_ATTACHMENT_UPLOAD_BYTES", 25<<20),
+		AttachmentUploadQuotaBytes: getEnvOrDefaultInt("VMAIL_ATTACHMENT_UPLOAD_QUOTA_BYTES", 100<<20),
+		BodyCacheQuotaBytes:        getEnvOrDefaultInt("VMAIL_BODY_CACHE_QUOTA_BYTES", 1<<30),
+		ImageProxyMaxImageBytes:    getEnvOrDefaultInt("VMAIL_IMAGE_PROXY_MAX_IMAGE_BYTES", 10<<20),
+		ImageProxyCacheBytes:       getEnvOrDefaultInt("VMAIL_IMAGE_PROXY_CACHE_BYTES", 100<<20),
+
+		TrustedAuthServIDs: os.Getenv("VMAIL_TRUSTED_AUTHSERV_IDS"),
+
+		MaintenanceWindow:        os.Getenv("VMAIL_MAINTENANCE_WINDOW"),
+		MaintenanceWindowMinutes: getEnvOrDefaultInt("VMAIL_MAINTENANCE_WINDOW_MINUTES", 180),
+		MaintenanceForce:         getEnvOrDefaultBool("VMAIL_MAINTENANCE_FORCE", false),
+
+		OAuthGoogleClientID:        os.Getenv("VMAIL_OAUTH_GOOGLE_CLIENT_ID"),
+		OAuthGoogleClientSecret:    os.Getenv("VMAIL_OAUTH_GOOGLE_CLIENT_SECRET"),
+		OAuthMicrosoftClientID:     os.Getenv("VMAIL_OAUTH_MICROSOFT_CLIENT_ID"),
+		OAuthMicrosoftClientSecret: os.Getenv("VMAIL_OAUTH_MICROSOFT_CLIENT_SECRET"),
+
+		VAPIDPrivateKey: os.Getenv("VMAIL_VAPID_PRIVATE_KEY"),
+		VAPIDSubject:    os.Getenv("VMAIL_VAPID_SUBJECT"),
 	}
 
 	if err := config.Validate(); err != nil {
@@ -81,17 +238,24 @@ func NewConfig() (*Config, error) {
 
 // Validate checks that all required configuration values are set and valid.
 func (c *Config) Validate() error {
-	if c.EncryptionKeyBase64 == "" {
-		return fmt.Errorf("VMAIL_ENCRYPTION_KEY_BASE64 is required")
+	encryptionKeys := c.GetEncryptionKeys()
+	if len(encryptionKeys) == 0 {
+		return fmt.Errorf("VMAIL_ENCRYPTION_KEY_BASE64 or VMAIL_ENCRYPTION_KEYS_BASE64 is required")
 	}
 
-	// Validate EncryptionKeyBase64 format: must be valid base64 and decode to 32 bytes
-	decoded, err := base64.StdEncoding.DecodeString(c.EncryptionKeyBase64)
-	if err != nil {
-		return fmt.Errorf("VMAIL_ENCRYPTION_KEY_BASE64 is not valid base64: %w", err)
+	// Validate the encryption key format: must be valid base64 and decode to 32 bytes
+	keysVar := "VMAIL_ENCRYPTION_KEY_BASE64"
+	if c.EncryptionKeysBase64 != "" {
+		keysVar = "VMAIL_ENCRYPTION_KEYS_BASE64"
 	}
-	if len(decoded) != 32 {
-		return fmt.Errorf("VMAIL_ENCRYPTION_KEY_BASE64 must decode to 32 bytes, got %d bytes", len(decoded))
+	for _, key := range encryptionKeys {
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return fmt.Errorf("%s is not valid base64: %w", keysVar, err)
+		}
+		if len(decoded) != 32 {
+			return fmt.Errorf("%s must decode to 32 bytes, got %d bytes", keysVar, len(decoded))
+		}
 	}
 
 	if c.AutheliaURL == "" {
@@ -121,9 +285,99 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("PORT is not a valid port number: %w", err)
 	}
 
+	switch c.RateLimitStore {
+	case "", RateLimitStoreMemory, RateLimitStorePostgres:
+	default:
+		return fmt.Errorf("VMAIL_RATE_LIMIT_STORE must be %q or %q, got: %s", RateLimitStoreMemory, RateLimitStorePostgres, c.RateLimitStore)
+	}
+	if c.RateLimitPerMinute > 0 && c.RateLimitBurst < 1 {
+		return fmt.Errorf("VMAIL_RATE_LIMIT_BURST must be at least 1, got %d", c.RateLimitBurst)
+	}
+
+	for _, limit := range []struct {
+		name  string
+		value int
+	}{
+		{"VMAIL_MAX_REQUEST_BODY_BYTES", c.MaxRequestBodyBytes},
+		{"VMAIL_MAX_SEND_REQUEST_BODY_BYTES", c.MaxSendRequestBodyBytes},
+		{"VMAIL_MAX_DRAFT_REQUEST_BODY_BYTES", c.MaxDraftRequestBodyBytes},
+		{"VMAIL_IMAP_MAX_MESSAGE_BYTES", c.IMAPMaxMessageBytes},
+		{"VMAIL_IMAP_MAX_PART_BYTES", c.IMAPMaxPartBytes},
+		{"VMAIL_IMAP_FETCH_BATCH_SIZE", c.IMAPFetchBatchSize},
+		{"VMAIL_SYNC_ACTIVE_INTERVAL_SECONDS", c.SyncActiveIntervalSeconds},
+		{"VMAIL_SYNC_DORMANT_INTERVAL_SECONDS", c.SyncDormantIntervalSeconds},
+		{"VMAIL_SMTP_MAX_CONNECTIONS", c.SMTPMaxConnections},
+		{"VMAIL_IMAP_OPERATION_TIMEOUT_SECONDS", c.IMAPOperationTimeoutSeconds},
+		{"VMAIL_MAX_ATTACHMENT_UPLOAD_BYTES", c.MaxAttachmentUploadBytes},
+		{"VMAIL_ATTACHMENT_UPLOAD_QUOTA_BYTES", c.AttachmentUploadQuotaBytes},
+		{"VMAIL_BODY_CACHE_QUOTA_BYTES", c.BodyCacheQuotaBytes},
+		{"VMAIL_IMAGE_PROXY_MAX_IMAGE_BYTES", c.ImageProxyMaxImageBytes},
+		{"VMAIL_IMAGE_PROXY_CACHE_BYTES", c.ImageProxyCacheBytes},
+	} {
+		if limit.value < 0 {
+			return fmt.Errorf("%s must not be negative, got %d", limit.name, limit.value)
+		}
+	}
+
+	if c.VAPIDPrivateKey != "" && c.VAPIDSubject == "" {
+		return fmt.Errorf("VMAIL_VAPID_SUBJECT is required when VMAIL_VAPID_PRIVATE_KEY is set")
+	}
+
+	if _, err := logging.NewHandler(io.Discard, c.LogLevel, c.LogFormat); err != nil {
+		return fmt.Errorf("VMAIL_LOG_LEVEL or VMAIL_LOG_FORMAT is not valid: %w", err)
+	}
+
+	if _, err := c.GetMaintenanceWindow(); err != nil {
+		return fmt.Errorf("VMAIL_MAINTENANCE_WINDOW is not valid: %w", err)
+	}
+
 	return nil
 }
 
+// GetEncryptionKeys returns the base64-encoded encryption keys, the primary one first.
+// They come from VMAIL_ENCRYPTION_KEYS_BASE64 if it's set, and from VMAIL_ENCRYPTION_KEY_BASE64 otherwise.
+// Returns nil if neither is set.
+func (c *Config) GetEncryptionKeys() []string {
+	if c.EncryptionKeysBase64 == "" {
+		if c.EncryptionKeyBase64 == "" {
+			return nil
+		}
+		return []string{c.EncryptionKeyBase64}
+	}
+
+	var keys []string
+	for key := range strings.SplitSeq(c.EncryptionKeysBase64, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// GetTrustedAuthServIDs returns the authserv-ids from VMAIL_TRUSTED_AUTHSERV_IDS. Returns nil if it's not set.
+func (c *Config) GetTrustedAuthServIDs() []string {
+	var ids []string
+	for id := range strings.SplitSeq(c.TrustedAuthServIDs, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// GetMaintenanceWindow returns the window when heavy jobs can run, in the configured timezone.
+// Returns nil if there's no window, so heavy jobs can run any time.
+func (c *Config) GetMaintenanceWindow() (*maintenance.Window, error) {
+	if c.MaintenanceWindow == "" {
+		return nil, nil
+	}
+	location, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q: %w", c.Timezone, err)
+	}
+	return maintenance.NewWindow(c.MaintenanceWindow, time.Duration(c.MaintenanceWindowMinutes)*time.Minute, location, c.MaintenanceForce)
+}
+
 // validatePort checks if a string represents a valid port number (1-65535).
 func validatePort(portStr string) error {
 	port, err := strconv.Atoi(portStr)
@@ -171,7 +425,22 @@ func getEnvOrDefaultInt(key string, defaultValue int) int {
 	}
 	parsed, err := strconv.Atoi(value)
 	if err != nil {
-		log.Printf("Warning: %s is not a valid integer (%q), using default %d", key, value, defaultValue)
+		slog.Warn("Environment variable is not a valid integer, using the default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// getEnvOrDefaultBool retrieves an environment variable as a bool, returning the
+// default value if not set, empty, or invalid.
+func getEnvOrDefaultBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Environment variable is not a valid boolean, using the default", "key", key, "value", value, "default", defaultValue)
 		return defaultValue
 	}
 	return parsed
@@ -3,6 +3,7 @@ package config
 import (
 	"net/url"
 	"os"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -118,6 +119,25 @@ func TestNewConfigWithDefaults(t *testing.T) {
 	if config.Timezone != "UTC" {
 		t.Errorf("expected default Timezone 'UTC', got '%s'", config.Timezone)
 	}
+
+	if config.MaxRequestBodyBytes != 1<<20 || config.MaxSendRequestBodyBytes != 35<<20 || config.MaxDraftRequestBodyBytes != 10<<20 {
+		t.Errorf("expected default body limits of 1 MiB, 35 MiB, and 10 MiB, got %d, %d, and %d",
+			config.MaxRequestBodyBytes, config.MaxSendRequestBodyBytes, config.MaxDraftRequestBodyBytes)
+	}
+
+	if config.IMAPMaxMessageBytes != 50<<20 || config.IMAPMaxPartBytes != 5<<20 {
+		t.Errorf("expected default fetch limits of 50 MiB and 5 MiB, got %d and %d",
+			config.IMAPMaxMessageBytes, config.IMAPMaxPartBytes)
+	}
+
+	if config.IMAPFetchBatchSize != 1000 || config.IMAPFetchConnections != 2 {
+		t.Errorf("expected default header fetches of 1000 messages on 2 connections, got %d and %d",
+			config.IMAPFetchBatchSize, config.IMAPFetchConnections)
+	}
+
+	if config.IMAPOperationTimeoutSeconds != 120 {
+		t.Errorf("expected default IMAP operation timeout of 120 seconds, got %d", config.IMAPOperationTimeoutSeconds)
+	}
 }
 
 func TestValidate(t *testing.T) {
@@ -147,7 +167,7 @@ func TestValidate(t *testing.T) {
 				Port:        "11764",
 			},
 			shouldErr: true,
-			errMsg:    "VMAIL_ENCRYPTION_KEY_BASE64 is required",
+			errMsg:    "VMAIL_ENCRYPTION_KEY_BASE64 or VMAIL_ENCRYPTION_KEYS_BASE64 is required",
 		},
 		{
 			name: "missing authelia URL",
@@ -355,6 +375,60 @@ func TestValidateEncryptionKey(t *testing.T) {
 	}
 }
 
+func TestGetEncryptionKeys(t *testing.T) {
+	const key1 = "dGVzdC1rZXktMTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM="
+	const key2 = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
+	tests := []struct {
+		name      string
+		key       string
+		keys      string
+		expected  []string
+		shouldErr bool
+		errMsg    string
+	}{
+		{name: "single key", key: key1, expected: []string{key1}},
+		{name: "primary and secondary keys", keys: key2 + ", " + key1, expected: []string{key2, key1}},
+		{name: "keys win over the single key", key: key1, keys: key2, expected: []string{key2}},
+		{name: "no keys", keys: " , ", shouldErr: true, errMsg: "VMAIL_ENCRYPTION_KEY_BASE64 or VMAIL_ENCRYPTION_KEYS_BASE64 is required"},
+		{
+			name:      "invalid secondary key",
+			keys:      key2 + ",dGVzdA==",
+			expected:  []string{key2, "dGVzdA=="},
+			shouldErr: true,
+			errMsg:    "VMAIL_ENCRYPTION_KEYS_BASE64 must decode to 32 bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{
+				EncryptionKeyBase64:  tt.key,
+				EncryptionKeysBase64: tt.keys,
+				AutheliaURL:          "http://authelia:9091",
+				DBPassword:           "password",
+				DBPort:               "5432",
+				Port:                 "11764",
+			}
+
+			if got := config.GetEncryptionKeys(); !slices.Equal(got, tt.expected) {
+				t.Errorf("expected keys %v, got %v", tt.expected, got)
+			}
+
+			err := config.Validate()
+			if tt.shouldErr && err == nil {
+				t.Errorf("expected error but got none")
+			}
+			if !tt.shouldErr && err != nil {
+				t.Errorf("expected no error but got: %v", err)
+			}
+			if tt.shouldErr && err != nil && !contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error message to contain '%s', got '%s'", tt.errMsg, err.Error())
+			}
+		})
+	}
+}
+
 func TestValidateAutheliaURL(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -511,3 +585,259 @@ func findSubstring(s, substr string) bool {
 	}
 	return false
 }
+
+func TestValidateMaintenanceWindow(t *testing.T) {
+	tests := []struct {
+		name      string
+		window    string
+		minutes   int
+		timezone  string
+		shouldErr bool
+	}{
+		{
+			name:      "no window",
+			shouldErr: false,
+		},
+		{
+			name:      "valid window",
+			window:    "0 2 * * *",
+			minutes:   180,
+			timezone:  "UTC",
+			shouldErr: false,
+		},
+		{
+			name:      "invalid cron expression",
+			window:    "every night",
+			minutes:   180,
+			timezone:  "UTC",
+			shouldErr: true,
+		},
+		{
+			name:      "zero minutes",
+			window:    "0 2 * * *",
+			timezone:  "UTC",
+			shouldErr: true,
+		},
+		{
+			name:      "unknown timezone",
+			window:    "0 2 * * *",
+			minutes:   180,
+			timezone:  "Mars/Olympus_Mons",
+			shouldErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{
+				EncryptionKeyBase64:      "dGVzdC1rZXktMTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM=",
+				AutheliaURL:              "http://authelia:9091",
+				DBPassword:               "password",
+				DBPort:                   "5432",
+				Port:                     "11764",
+				Timezone:                 tt.timezone,
+				MaintenanceWindow:        tt.window,
+				MaintenanceWindowMinutes: tt.minutes,
+			}
+
+			err := config.Validate()
+			if tt.shouldErr && err == nil {
+				t.Errorf("expected error but got none")
+			}
+			if !tt.shouldErr && err != nil {
+				t.Errorf("expected no error but got: %v", err)
+			}
+			if tt.shouldErr && err != nil && !contains(err.Error(), "VMAIL_MAINTENANCE_WINDOW is not valid") {
+				t.Errorf("expected error message to mention VMAIL_MAINTENANCE_WINDOW, got '%s'", err.Error())
+			}
+		})
+	}
+}
+
+func TestValidateRateLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		store     string
+		perMinute int
+		burst     int
+		shouldErr bool
+		contains  string
+	}{
+		{
+			name:      "default store",
+			perMinute: 600,
+			burst:     100,
+			shouldErr: false,
+		},
+		{
+			name:      "postgres store",
+			store:     "postgres",
+			perMinute: 600,
+			burst:     100,
+			shouldErr: false,
+		},
+		{
+			name:      "unknown store",
+			store:     "redis",
+			perMinute: 600,
+			burst:     100,
+			shouldErr: true,
+			contains:  "VMAIL_RATE_LIMIT_STORE",
+		},
+		{
+			name:      "zero burst",
+			store:     "memory",
+			perMinute: 600,
+			shouldErr: true,
+			contains:  "VMAIL_RATE_LIMIT_BURST",
+		},
+		{
+			name:      "zero burst without a limit",
+			store:     "memory",
+			shouldErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{
+				EncryptionKeyBase64: "dGVzdC1rZXktMTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM=",
+				AutheliaURL:         "http://authelia:9091",
+				DBPassword:          "password",
+				DBPort:              "5432",
+				Port:                "11764",
+				RateLimitPerMinute:  tt.perMinute,
+				RateLimitBurst:      tt.burst,
+				RateLimitStore:      tt.store,
+			}
+
+			err := config.Validate()
+			if tt.shouldErr && err == nil {
+				t.Errorf("expected error but got none")
+			}
+			if !tt.shouldErr && err != nil {
+				t.Errorf("expected no error but got: %v", err)
+			}
+			if tt.shouldErr && err != nil && !contains(err.Error(), tt.contains) {
+				t.Errorf("expected error message to contain '%s', got '%s'", tt.contains, err.Error())
+			}
+		})
+	}
+}
+
+func TestValidateBodyLimits(t *testing.T) {
+	config := &Config{
+		EncryptionKeyBase64: "dGVzdC1rZXktMTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM=",
+		AutheliaURL:         "http://authelia:9091",
+		DBPassword:          "password",
+		DBPort:              "5432",
+		Port:                "11764",
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("expected no error for zero limits but got: %v", err)
+	}
+
+	config.MaxSendRequestBodyBytes = -1
+	err := config.Validate()
+	if err == nil || !contains(err.Error(), "VMAIL_MAX_SEND_REQUEST_BODY_BYTES") {
+		t.Errorf("expected an error about VMAIL_MAX_SEND_REQUEST_BODY_BYTES, got: %v", err)
+	}
+
+	config.MaxSendRequestBodyBytes = 0
+	config.IMAPMaxPartBytes = -1
+	err = config.Validate()
+	if err == nil || !contains(err.Error(), "VMAIL_IMAP_MAX_PART_BYTES") {
+		t.Errorf("expected an error about VMAIL_IMAP_MAX_PART_BYTES, got: %v", err)
+	}
+}
+
+func TestValidateVAPID(t *testing.T) {
+	config := &Config{
+		EncryptionKeyBase64: "dGVzdC1rZXktMTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM=",
+		AutheliaURL:         "http://authelia:9091",
+		DBPassword:          "password",
+		DBPort:              "5432",
+		Port:                "11764",
+		VAPIDPrivateKey:     "key",
+	}
+	err := config.Validate()
+	if err == nil || !contains(err.Error(), "VMAIL_VAPID_SUBJECT") {
+		t.Errorf("expected an error about VMAIL_VAPID_SUBJECT, got: %v", err)
+	}
+
+	config.VAPIDSubject = "mailto:admin@example.com"
+	if err := config.Validate(); err != nil {
+		t.Errorf("expected no error but got: %v", err)
+	}
+}
+
+func TestValidateLogging(t *testing.T) {
+	tests := []struct {
+		name      string
+		level     string
+		format    string
+		shouldErr bool
+		contains  string
+	}{
+		{
+			name:      "defaults",
+			shouldErr: false,
+		},
+		{
+			name:      "debug as JSON",
+			level:     "debug",
+			format:    "json",
+			shouldErr: false,
+		},
+		{
+			name:      "unknown level",
+			level:     "loud",
+			format:    "text",
+			shouldErr: true,
+			contains:  "VMAIL_LOG_LEVEL",
+		},
+		{
+			name:      "unknown format",
+			level:     "info",
+			format:    "xml",
+			shouldErr: true,
+			contains:  "VMAIL_LOG_FORMAT",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{
+				EncryptionKeyBase64: "dGVzdC1rZXktMTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM=",
+				AutheliaURL:         "http://authelia:9091",
+				DBPassword:          "password",
+				DBPort:              "5432",
+				Port:                "11764",
+				LogLevel:            tt.level,
+				LogFormat:           tt.format,
+			}
+
+			err := config.Validate()
+			if tt.shouldErr && err == nil {
+				t.Errorf("expected error but got none")
+			}
+			if !tt.shouldErr && err != nil {
+				t.Errorf("expected no error but got: %v", err)
+			}
+			if tt.shouldErr && err != nil && !contains(err.Error(), tt.contains) {
+				t.Errorf("expected error message to contain '%s', got '%s'", tt.contains, err.Error())
+			}
+		})
+	}
+}
+
+func TestGetTrustedAuthServIDs(t *testing.T) {
+	config := &Config{TrustedAuthServIDs: " mx.google.com, ,mx.example.net "}
+	ids := config.GetTrustedAuthServIDs()
+	if len(ids) != 2 || ids[0] != "mx.google.com" || ids[1] != "mx.example.net" {
+		t.Errorf("Expected the two ids, got %q", ids)
+	}
+	if ids := (&Config{}).GetTrustedAuthServIDs(); ids != nil {
+		t.Errorf("Expected no ids, got %q", ids)
+	}
+}
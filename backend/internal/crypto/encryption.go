@@ -1,41 +1,73 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
 	"io"
 )
 
+// keyIDMarker starts the ciphertexts that name their key. Ciphertexts from before we had key IDs start right
+// with the nonce.
+var keyIDMarker = []byte("vk1")
+
+// keyIDSize is how many bytes of the key's SHA-256 hash identify it in ciphertexts.
+const keyIDSize = 4
+
+// encryptionKey is a key and its ID.
+type encryptionKey struct {
+	id  [keyIDSize]byte
+	key []byte
+}
+
 // Encryptor provides encryption and decryption functionality using AES-GCM (Galois/Counter Mode).
 // AES-GCM provides both confidentiality and authenticity, making it suitable for encrypting
-// sensitive data like user passwords. The key is stored in memory as plain bytes.
+// sensitive data like user passwords. The keys are stored in memory as plain bytes.
+// It encrypts with its primary key, and decrypts with any of its keys, so that keys can be rotated.
 type Encryptor struct {
-	key []byte
+	// keys has the primary key first, then the older keys that we still decrypt with.
+	keys []encryptionKey
 }
 
 // NewEncryptor creates a new Encryptor with the given key.
 func NewEncryptor(base64Key string) (*Encryptor, error) {
-	key, err := base64.StdEncoding.DecodeString(base64Key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode encryption key: %w", err)
+	return NewEncryptorWithKeys([]string{base64Key})
+}
+
+// NewEncryptorWithKeys creates a new Encryptor with the given keys. The first one is the primary key,
+// which Encrypt uses. The others are only for decrypting data that we encrypted before rotating the keys.
+func NewEncryptorWithKeys(base64Keys []string) (*Encryptor, error) {
+	if len(base64Keys) == 0 {
+		return nil, fmt.Errorf("at least one encryption key is required")
 	}
 
-	if len(key) != 32 {
-		return nil, fmt.Errorf("encryption key must be 32 bytes (256 bits), got %d bytes", len(key))
+	keys := make([]encryptionKey, 0, len(base64Keys))
+	for i, base64Key := range base64Keys {
+		key, err := base64.StdEncoding.DecodeString(base64Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode encryption key %d: %w", i+1, err)
+		}
+
+		if len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %d must be 32 bytes (256 bits), got %d bytes", i+1, len(key))
+		}
+
+		hash := sha256.Sum256(key)
+		k := encryptionKey{key: key}
+		copy(k.id[:], hash[:keyIDSize])
+		keys = append(keys, k)
 	}
 
-	return &Encryptor{key: key}, nil
+	return &Encryptor{keys: keys}, nil
 }
 
-// Encrypt encrypts the given plaintext using AES-GCM.
-// The returned ciphertext format is: [nonce][encrypted_data][auth_tag]
-// where the nonce is prepended to the ciphertext for use during decryption.
-// Each encryption uses a random nonce, ensuring the same plaintext produces different ciphertexts.
-func (e *Encryptor) Encrypt(plaintext string) ([]byte, error) {
-	block, err := aes.NewCipher(e.key)
+// newGCM creates an AES-GCM cipher with the key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create cipher: %w", err)
 	}
@@ -44,29 +76,102 @@ func (e *Encryptor) Encrypt(plaintext string) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create GCM: %w", err)
 	}
+	return gcm, nil
+}
+
+// Encrypt encrypts the given plaintext using AES-GCM with the primary key.
+// The returned ciphertext format is: [marker][key_id][nonce][encrypted_data][auth_tag]
+// where the marker and the key ID tell Decrypt which key to use, and the nonce is prepended
+// to the ciphertext for use during decryption.
+// Each encryption uses a random nonce, ensuring the same plaintext produces different ciphertexts.
+func (e *Encryptor) Encrypt(plaintext string) ([]byte, error) {
+	primary := e.keys[0]
+	gcm, err := newGCM(primary.key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, 0, len(keyIDMarker)+keyIDSize+gcm.NonceSize())
+	prefix = append(prefix, keyIDMarker...)
+	prefix = append(prefix, primary.id[:]...)
 
 	nonce := make([]byte, gcm.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, fmt.Errorf("failed to generate nonce: %w", err)
 	}
 
-	ciphertext := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	ciphertext := gcm.Seal(append(prefix, nonce...), nonce, []byte(plaintext), nil)
 	return ciphertext, nil
 }
 
 // Decrypt decrypts the given ciphertext using AES-GCM.
-// The ciphertext format is expected to be: [nonce][encrypted_data][auth_tag]
-// where the nonce is prepended. Returns an error if the ciphertext is invalid,
-// corrupted, or was encrypted with a different key (authentication failure).
+// The ciphertext format is expected to be what Encrypt returns, or [nonce][encrypted_data][auth_tag]
+// from before key IDs, which we try with each key. Returns an error if the ciphertext is invalid,
+// corrupted, or was encrypted with a key that the Encryptor doesn't have (authentication failure).
 func (e *Encryptor) Decrypt(ciphertext []byte) (string, error) {
-	block, err := aes.NewCipher(e.key)
+	if key, rest, ok := e.keyFor(ciphertext); ok {
+		if plaintext, err := open(key.key, rest); err == nil {
+			return plaintext, nil
+		}
+		// The nonce of an old ciphertext can start like a key ID by chance, so we go on
+	}
+
+	var lastErr error
+	for _, key := range e.keys {
+		plaintext, err := open(key.key, ciphertext)
+		if err == nil {
+			return plaintext, nil
+		}
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+// NeedsReencryption tells whether the ciphertext isn't encrypted with the primary key,
+// so that rotating the keys should encrypt it again.
+func (e *Encryptor) NeedsReencryption(ciphertext []byte) bool {
+	key, _, ok := e.keyFor(ciphertext)
+	return !ok || key.id != e.keys[0].id
+}
+
+// Reencrypt decrypts the ciphertext with whichever key it was encrypted with, and encrypts it with the primary key.
+// Returns the ciphertext as it is if it's already encrypted with the primary key, and whether it changed it.
+func (e *Encryptor) Reencrypt(ciphertext []byte) ([]byte, bool, error) {
+	plaintext, err := e.Decrypt(ciphertext)
+	if err != nil {
+		return nil, false, err
+	}
+	if !e.NeedsReencryption(ciphertext) {
+		return ciphertext, false, nil
+	}
+
+	reencrypted, err := e.Encrypt(plaintext)
 	if err != nil {
-		return "", fmt.Errorf("failed to create cipher: %w", err)
+		return nil, false, err
 	}
+	return reencrypted, true, nil
+}
 
-	gcm, err := cipher.NewGCM(block)
+// keyFor returns the key that the ciphertext names, and the rest of the ciphertext after the key ID.
+// ok is false if the ciphertext doesn't name a key, or names one that the Encryptor doesn't have.
+func (e *Encryptor) keyFor(ciphertext []byte) (key encryptionKey, rest []byte, ok bool) {
+	afterMarker, found := bytes.CutPrefix(ciphertext, keyIDMarker)
+	if !found || len(afterMarker) < keyIDSize {
+		return encryptionKey{}, nil, false
+	}
+	for _, k := range e.keys {
+		if bytes.Equal(afterMarker[:keyIDSize], k.id[:]) {
+			return k, afterMarker[keyIDSize:], true
+		}
+	}
+	return encryptionKey{}, nil, false
+}
+
+// open decrypts [nonce][encrypted_data][auth_tag] with the key.
+func open(key, ciphertext []byte) (string, error) {
+	gcm, err := newGCM(key)
 	if err != nil {
-		return "", fmt.Errorf("failed to create GCM: %w", err)
+		return "", err
 	}
 
 	nonceSize := gcm.NonceSize()
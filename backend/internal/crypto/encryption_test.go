@@ -256,3 +256,87 @@ func TestEncryptDecryptLargePlaintext(t *testing.T) {
 		t.Errorf("Expected decrypted length %d, got %d", len(plaintextStr), len(decrypted))
 	}
 }
+
+func TestKeyRotation(t *testing.T) {
+	oldKey := make([]byte, 32)
+	newKey := make([]byte, 32)
+	for i := range newKey {
+		newKey[i] = byte(i + 1)
+	}
+	oldBase64Key := base64.StdEncoding.EncodeToString(oldKey)
+	newBase64Key := base64.StdEncoding.EncodeToString(newKey)
+
+	oldEncryptor, err := NewEncryptor(oldBase64Key)
+	if err != nil {
+		t.Fatalf("Failed to create old encryptor: %v", err)
+	}
+	rotated, err := NewEncryptorWithKeys([]string{newBase64Key, oldBase64Key})
+	if err != nil {
+		t.Fatalf("Failed to create rotated encryptor: %v", err)
+	}
+
+	oldCiphertext, err := oldEncryptor.Encrypt("secret password")
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+
+	t.Run("decrypts with the secondary key", func(t *testing.T) {
+		decrypted, err := rotated.Decrypt(oldCiphertext)
+		if err != nil || decrypted != "secret password" {
+			t.Errorf("Expected the password, got %q and %v", decrypted, err)
+		}
+	})
+
+	t.Run("decrypts ciphertexts without a key ID", func(t *testing.T) {
+		legacy := oldCiphertext[len(keyIDMarker)+keyIDSize:]
+		decrypted, err := rotated.Decrypt(legacy)
+		if err != nil || decrypted != "secret password" {
+			t.Errorf("Expected the password, got %q and %v", decrypted, err)
+		}
+		if !rotated.NeedsReencryption(legacy) {
+			t.Error("Expected a ciphertext without a key ID to need re-encryption")
+		}
+	})
+
+	t.Run("re-encrypts with the primary key", func(t *testing.T) {
+		if !rotated.NeedsReencryption(oldCiphertext) {
+			t.Fatal("Expected a ciphertext of the secondary key to need re-encryption")
+		}
+		reencrypted, changed, err := rotated.Reencrypt(oldCiphertext)
+		if err != nil || !changed {
+			t.Fatalf("Expected to re-encrypt, got changed=%v and %v", changed, err)
+		}
+		if rotated.NeedsReencryption(reencrypted) {
+			t.Error("Expected the re-encrypted ciphertext to use the primary key")
+		}
+
+		// Once the old key is gone, only the re-encrypted one works
+		newEncryptor, err := NewEncryptor(newBase64Key)
+		if err != nil {
+			t.Fatalf("Failed to create new encryptor: %v", err)
+		}
+		if decrypted, err := newEncryptor.Decrypt(reencrypted); err != nil || decrypted != "secret password" {
+			t.Errorf("Expected the password, got %q and %v", decrypted, err)
+		}
+		if _, err := newEncryptor.Decrypt(oldCiphertext); err == nil {
+			t.Error("Expected an error when decrypting without the old key, got nil")
+		}
+
+		again, changed, err := rotated.Reencrypt(reencrypted)
+		if err != nil || changed || string(again) != string(reencrypted) {
+			t.Errorf("Expected to leave the ciphertext alone, got changed=%v and %v", changed, err)
+		}
+	})
+
+	t.Run("doesn't re-encrypt what it can't decrypt", func(t *testing.T) {
+		if _, _, err := rotated.Reencrypt([]byte("short")); err == nil {
+			t.Error("Expected an error for an invalid ciphertext, got nil")
+		}
+	})
+
+	t.Run("needs at least one key", func(t *testing.T) {
+		if _, err := NewEncryptorWithKeys(nil); err == nil {
+			t.Error("Expected an error without keys, got nil")
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// signingKeyLabel derives the signing keys from the encryption keys, so that no key is used for two things.
+var signingKeyLabel = []byte("vmail signing key")
+
+// Sign returns an HMAC-SHA256 signature of the message with the primary key.
+// The signature format is: [key_id][mac], so that Verify knows which key to check it with after rotating the keys.
+func (e *Encryptor) Sign(message []byte) []byte {
+	primary := e.keys[0]
+	signature := make([]byte, 0, keyIDSize+sha256.Size)
+	signature = append(signature, primary.id[:]...)
+	return append(signature, mac(primary.key, message)...)
+}
+
+// Verify tells whether the signature is one that Sign returned for the message, with any of the keys.
+func (e *Encryptor) Verify(message, signature []byte) bool {
+	if len(signature) != keyIDSize+sha256.Size {
+		return false
+	}
+	for _, k := range e.keys {
+		if bytes.Equal(signature[:keyIDSize], k.id[:]) {
+			return hmac.Equal(signature[keyIDSize:], mac(k.key, message))
+		}
+	}
+	return false
+}
+
+// mac returns the HMAC-SHA256 of the message with the signing key that belongs to the encryption key.
+func mac(key, message []byte) []byte {
+	derive := hmac.New(sha256.New, key)
+	derive.Write(signingKeyLabel)
+
+	h := hmac.New(sha256.New, derive.Sum(nil))
+	h.Write(message)
+	return h.Sum(nil)
+}
@@ -0,0 +1,68 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	oldKey := make([]byte, 32)
+	newKey := make([]byte, 32)
+	for i := range newKey {
+		newKey[i] = byte(i + 1)
+	}
+	oldBase64Key := base64.StdEncoding.EncodeToString(oldKey)
+	newBase64Key := base64.StdEncoding.EncodeToString(newKey)
+
+	oldEncryptor, err := NewEncryptor(oldBase64Key)
+	if err != nil {
+		t.Fatalf("Failed to create old encryptor: %v", err)
+	}
+	rotated, err := NewEncryptorWithKeys([]string{newBase64Key, oldBase64Key})
+	if err != nil {
+		t.Fatalf("Failed to create rotated encryptor: %v", err)
+	}
+	newEncryptor, err := NewEncryptor(newBase64Key)
+	if err != nil {
+		t.Fatalf("Failed to create new encryptor: %v", err)
+	}
+
+	message := []byte("user-id|1700000000")
+	signature := oldEncryptor.Sign(message)
+
+	t.Run("verifies its own signatures", func(t *testing.T) {
+		if !oldEncryptor.Verify(message, signature) {
+			t.Error("Expected the signature to verify")
+		}
+	})
+
+	t.Run("verifies signatures of the secondary key", func(t *testing.T) {
+		if !rotated.Verify(message, signature) {
+			t.Error("Expected the signature of the secondary key to verify")
+		}
+		if !newEncryptor.Verify(message, rotated.Sign(message)) {
+			t.Error("Expected to sign with the primary key")
+		}
+	})
+
+	t.Run("rejects signatures of other keys", func(t *testing.T) {
+		if newEncryptor.Verify(message, signature) {
+			t.Error("Expected the signature of a removed key not to verify")
+		}
+	})
+
+	t.Run("rejects tampered messages and signatures", func(t *testing.T) {
+		if oldEncryptor.Verify([]byte("user-id|1800000000"), signature) {
+			t.Error("Expected a changed message not to verify")
+		}
+		tampered := append([]byte{}, signature...)
+		tampered[len(tampered)-1] ^= 1
+		if oldEncryptor.Verify(message, tampered) {
+			t.Error("Expected a changed signature not to verify")
+		}
+		if oldEncryptor.Verify(message, signature[:10]) {
+			t.Error("Expected a short signature not to verify")
+		}
+	})
+
+}
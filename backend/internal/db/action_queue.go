@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// QueueAction adds an action to the action queue that runs at processAt.
+// The payload must be JSON. Returns the ID of the new action.
+func QueueAction(ctx context.Context, pool *pgxpool.Pool, userID, actionType string, payload []byte, processAt time.Time) (string, error) {
+	var id string
+	err := pool.QueryRow(ctx, `
+		INSERT INTO action_queue (user_id, action_type, payload, process_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, userID, actionType, string(payload), processAt).Scan(&id)
+	if err != nil {
+		return "", fmt.Errorf("failed to queue action: %w", err)
+	}
+	return id, nil
+}
+
+// CancelQueuedAction deletes an action of the user that hasn't run yet.
+// Returns false if there's no such action, for example, because it already ran, or a worker is running it right now.
+func CancelQueuedAction(ctx context.Context, pool *pgxpool.Pool, userID, actionType, actionID string) (bool, error) {
+	result, err := pool.Exec(ctx, `
+		DELETE FROM action_queue
+		WHERE id = $1 AND user_id = $2 AND action_type = $3 AND status = 'queued'
+	`, actionID, userID, actionType)
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel queued action: %w", err)
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+// GetQueuedActions returns the user's actions of the given type that haven't run yet, the soonest first.
+// Actions that a worker is running aren't included.
+func GetQueuedActions(ctx context.Context, pool *pgxpool.Pool, userID, actionType string) ([]*models.QueuedAction, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT id, user_id, payload, attempts, COALESCE(last_error, ''), created_at, process_at
+		FROM action_queue
+		WHERE user_id = $1 AND action_type = $2 AND status = 'queued'
+		ORDER BY process_at, created_at
+	`, userID, actionType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get queued actions: %w", err)
+	}
+	defer rows.Close()
+
+	var actions []*models.QueuedAction
+	for rows.Next() {
+		action := models.QueuedAction{ActionType: actionType}
+		var payload []byte
+		if err := rows.Scan(&action.ID, &action.UserID, &payload, &action.Attempts, &action.LastError, &action.CreatedAt, &action.ProcessAt); err != nil {
+			return nil, fmt.Errorf("failed to scan queued action: %w", err)
+		}
+		action.Payload = payload
+		actions = append(actions, &action)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating queued actions: %w", err)
+	}
+	return actions, nil
+}
+
+// ClaimNextDueAction marks the action of the given type that's been due for the longest as running, so that other
+// workers skip it and it can't be cancelled anymore. The worker should delete or reschedule it when it's done.
+// If it doesn't by claimUntil, for example, because the server stopped, the action is due again.
+// Returns nil if there's no due action.
+func ClaimNextDueAction(ctx context.Context, pool *pgxpool.Pool, actionType string, claimUntil time.Time) (*models.QueuedAction, error) {
+	action := models.QueuedAction{ActionType: actionType}
+	var payload []byte
+	err := pool.QueryRow(ctx, `
+		UPDATE action_queue
+		SET status = 'running', process_at = $2
+		WHERE id = (
+			SELECT id
+			FROM action_queue
+			WHERE action_type = $1 AND process_at <= NOW()
+			ORDER BY process_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, payload, attempts, COALESCE(last_error, ''), created_at, process_at
+	`, actionType, claimUntil).Scan(&action.ID, &action.UserID, &payload, &action.Attempts, &action.LastError, &action.CreatedAt, &action.ProcessAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim due action: %w", err)
+	}
+	action.Payload = payload
+	return &action, nil
+}
+
+// DeleteAction deletes a finished action.
+func DeleteAction(ctx context.Context, pool *pgxpool.Pool, actionID string) error {
+	if _, err := pool.Exec(ctx, `DELETE FROM action_queue WHERE id = $1`, actionID); err != nil {
+		return fmt.Errorf("failed to delete action: %w", err)
+	}
+	return nil
+}
+
+// RescheduleFailedAction records a failed attempt of a running action, and queues it again for processAt.
+func RescheduleFailedAction(ctx context.Context, pool *pgxpool.Pool, actionID string, processAt time.Time, lastError string) error {
+	_, err := pool.Exec(ctx, `
+		UPDATE action_queue
+		SET status = 'queued', attempts = attempts + 1, last_error = $2, process_at = $3
+		WHERE id = $1
+	`, actionID, lastError, processAt)
+	if err != nil {
+		return fmt.Errorf("failed to reschedule action: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestActionQueue(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "action-queue-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	t.Run("claims only due actions of the given type", func(t *testing.T) {
+		dueID, err := QueueAction(ctx, pool, userID, "test_due", []byte(`{"n": 1}`), time.Now().Add(-time.Minute))
+		if err != nil {
+			t.Fatalf("QueueAction failed: %v", err)
+		}
+		if _, err := QueueAction(ctx, pool, userID, "test_due", []byte(`{"n": 2}`), time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("QueueAction failed: %v", err)
+		}
+
+		action, err := ClaimNextDueAction(ctx, pool, "test_due", time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatalf("ClaimNextDueAction failed: %v", err)
+		}
+		if action == nil || action.ID != dueID || action.UserID != userID {
+			t.Fatalf("Expected the due action %s, got %+v", dueID, action)
+		}
+
+		// Another worker skips the claimed action, and the other one isn't due yet
+		other, err := ClaimNextDueAction(ctx, pool, "test_due", time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatalf("ClaimNextDueAction failed: %v", err)
+		}
+		if other != nil {
+			t.Errorf("Expected no action for the other worker, got %+v", other)
+		}
+
+		// The claimed action can't be cancelled or listed anymore
+		if cancelled, err := CancelQueuedAction(ctx, pool, userID, "test_due", action.ID); err != nil || cancelled {
+			t.Errorf("Expected the claimed action not to be cancelled, got %v, %v", cancelled, err)
+		}
+		actions, err := GetQueuedActions(ctx, pool, userID, "test_due")
+		if err != nil {
+			t.Fatalf("GetQueuedActions failed: %v", err)
+		}
+		if len(actions) != 1 || actions[0].ID == action.ID {
+			t.Errorf("Expected only the later action, got %+v", actions)
+		}
+
+		if err := DeleteAction(ctx, pool, action.ID); err != nil {
+			t.Fatalf("DeleteAction failed: %v", err)
+		}
+	})
+
+	t.Run("claims actions again after their claim runs out", func(t *testing.T) {
+		id, err := QueueAction(ctx, pool, userID, "test_stale", []byte(`{}`), time.Now().Add(-time.Minute))
+		if err != nil {
+			t.Fatalf("QueueAction failed: %v", err)
+		}
+		if _, err := ClaimNextDueAction(ctx, pool, "test_stale", time.Now().Add(-time.Second)); err != nil {
+			t.Fatalf("ClaimNextDueAction failed: %v", err)
+		}
+
+		action, err := ClaimNextDueAction(ctx, pool, "test_stale", time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatalf("ClaimNextDueAction failed: %v", err)
+		}
+		if action == nil || action.ID != id {
+			t.Errorf("Expected the action %s again, got %+v", id, action)
+		}
+	})
+
+	t.Run("reschedules failed actions", func(t *testing.T) {
+		id, err := QueueAction(ctx, pool, userID, "test_retry", []byte(`{}`), time.Now().Add(-time.Minute))
+		if err != nil {
+			t.Fatalf("QueueAction failed: %v", err)
+		}
+		if _, err := ClaimNextDueAction(ctx, pool, "test_retry", time.Now().Add(time.Minute)); err != nil {
+			t.Fatalf("ClaimNextDueAction failed: %v", err)
+		}
+
+		if err := RescheduleFailedAction(ctx, pool, id, time.Now().Add(time.Hour), "connection refused"); err != nil {
+			t.Fatalf("RescheduleFailedAction failed: %v", err)
+		}
+
+		var attempts int
+		var lastError, status string
+		err = pool.QueryRow(ctx, `SELECT attempts, last_error, status FROM action_queue WHERE id = $1`, id).Scan(&attempts, &lastError, &status)
+		if err != nil {
+			t.Fatalf("Failed to read action: %v", err)
+		}
+		if attempts != 1 || lastError != "connection refused" || status != "queued" {
+			t.Errorf("Expected a queued action with 1 attempt and the error, got %q, %d, and %q", status, attempts, lastError)
+		}
+	})
+
+	t.Run("lists the user's queued actions, the soonest first", func(t *testing.T) {
+		laterID, err := QueueAction(ctx, pool, userID, "test_list", []byte(`{"n": 2}`), time.Now().Add(2*time.Hour))
+		if err != nil {
+			t.Fatalf("QueueAction failed: %v", err)
+		}
+		soonerID, err := QueueAction(ctx, pool, userID, "test_list", []byte(`{"n": 1}`), time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("QueueAction failed: %v", err)
+		}
+
+		actions, err := GetQueuedActions(ctx, pool, userID, "test_list")
+		if err != nil {
+			t.Fatalf("GetQueuedActions failed: %v", err)
+		}
+		if len(actions) != 2 || actions[0].ID != soonerID || actions[1].ID != laterID {
+			t.Errorf("Expected the sooner action first, got %+v", actions)
+		}
+	})
+
+	t.Run("cancels only the user's own actions", func(t *testing.T) {
+		id, err := QueueAction(ctx, pool, userID, "test_cancel", []byte(`{}`), time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("QueueAction failed: %v", err)
+		}
+		otherUserID, err := GetOrCreateUser(ctx, pool, "action-queue-other@example.com")
+		if err != nil {
+			t.Fatalf("GetOrCreateUser failed: %v", err)
+		}
+
+		if cancelled, err := CancelQueuedAction(ctx, pool, otherUserID, "test_cancel", id); err != nil || cancelled {
+			t.Errorf("Expected another user not to cancel the action, got %v, %v", cancelled, err)
+		}
+		if cancelled, err := CancelQueuedAction(ctx, pool, userID, "test_cancel", id); err != nil || !cancelled {
+			t.Errorf("Expected the action to be cancelled, got %v, %v", cancelled, err)
+		}
+		if cancelled, _ := CancelQueuedAction(ctx, pool, userID, "test_cancel", id); cancelled {
+			t.Error("Expected a second cancel to find nothing")
+		}
+	})
+}
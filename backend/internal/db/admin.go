@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// ErrUserNotFound is returned when a user doesn't exist.
+var ErrUserNotFound = errors.New("user not found")
+
+// adminUserQuery selects users with their stats, for scanAdminUser. Callers add the WHERE and ORDER BY clauses.
+const adminUserQuery = `
+	SELECT u.id, u.email, u.created_at, t.thread_count, m.message_count, m.body_bytes, a.upload_bytes, s.last_synced_at
+	FROM users u
+	CROSS JOIN LATERAL (
+		SELECT COUNT(*) AS thread_count FROM threads WHERE user_id = u.id
+	) t
+	CROSS JOIN LATERAL (
+		SELECT COUNT(*) AS message_count,
+			COALESCE(SUM(COALESCE(octet_length(unsafe_body_html), 0) + COALESCE(octet_length(unsafe_body_html_zstd), 0) +
+				COALESCE(octet_length(body_text), 0)), 0)::BIGINT AS body_bytes
+		FROM messages WHERE user_id = u.id
+	) m
+	CROSS JOIN LATERAL (
+		SELECT COALESCE(SUM(size_bytes), 0)::BIGINT AS upload_bytes FROM attachment_uploads WHERE user_id = u.id
+	) a
+	CROSS JOIN LATERAL (
+		SELECT MAX(synced_at) AS last_synced_at FROM folder_sync_timestamps WHERE user_id = u.id
+	) s
+`
+
+func scanAdminUser(row pgx.Row) (*models.AdminUser, error) {
+	var user models.AdminUser
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.CreatedAt,
+		&user.Stats.ThreadCount,
+		&user.Stats.MessageCount,
+		&user.Stats.BodyBytes,
+		&user.Stats.UploadBytes,
+		&user.Stats.LastSyncedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetAdminUsers returns all users with their stats, ordered by email.
+// It counts the cache of every user, so it's meant for the admin API, not for hot paths.
+func GetAdminUsers(ctx context.Context, pool *pgxpool.Pool) ([]*models.AdminUser, error) {
+	rows, err := pool.Query(ctx, adminUserQuery+` ORDER BY u.email`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []*models.AdminUser{}
+	for rows.Next() {
+		user, err := scanAdminUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users: %w", err)
+	}
+
+	return users, nil
+}
+
+// GetAdminUser returns a user with their stats. Returns ErrUserNotFound if there's no such user.
+func GetAdminUser(ctx context.Context, pool *pgxpool.Pool, userID string) (*models.AdminUser, error) {
+	user, err := scanAdminUser(pool.QueryRow(ctx, adminUserQuery+` WHERE u.id = $1`, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, nil
+}
+
+// DeleteUser deletes a user and everything we keep for them: settings, credentials, and the whole mail cache.
+// Their tables cascade, except sync_changes and the user's rate limit bucket, which have no foreign key, so we delete
+// them in the same transaction. Returns ErrUserNotFound if there's no such user.
+func DeleteUser(ctx context.Context, pool *pgxpool.Pool, userID string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var email string
+	err = tx.QueryRow(ctx, `DELETE FROM users WHERE id = $1 RETURNING email`, userID).Scan(&email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	// After the user, because deleting their messages logs changes
+	if _, err := tx.Exec(ctx, `DELETE FROM sync_changes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete sync changes: %w", err)
+	}
+	// Keyed like api.RateLimiter keys them
+	if _, err := tx.Exec(ctx, `DELETE FROM rate_limit_buckets WHERE key = $1`, "user:"+email); err != nil {
+		return fmt.Errorf("failed to delete rate limit bucket: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
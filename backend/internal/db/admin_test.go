@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestAdminUsers(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	userID, err := GetOrCreateUser(ctx, pool, "admin-users-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	emptyUserID, err := GetOrCreateUser(ctx, pool, "admin-users-empty@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	thread := &models.Thread{UserID: userID, StableThreadID: "admin-thread", Subject: "Hello"}
+	if err := SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+	now := time.Now()
+	msg := &models.Message{
+		ThreadID:        thread.ID,
+		UserID:          userID,
+		IMAPUID:         1,
+		IMAPFolderName:  "INBOX",
+		MessageIDHeader: "admin-thread",
+		Subject:         "Hello",
+		SentAt:          &now,
+		BodyText:        "Hello world",
+	}
+	if err := SaveMessage(ctx, pool, msg); err != nil {
+		t.Fatalf("SaveMessage failed: %v", err)
+	}
+	upload := &models.AttachmentUpload{Filename: "notes.txt", ContentType: "text/plain", SizeBytes: 5, Data: []byte("hello")}
+	if err := CreateAttachmentUpload(ctx, pool, userID, upload, 0); err != nil {
+		t.Fatalf("CreateAttachmentUpload failed: %v", err)
+	}
+	if err := SetFolderSyncInfo(ctx, pool, userID, "INBOX", nil); err != nil {
+		t.Fatalf("SetFolderSyncInfo failed: %v", err)
+	}
+
+	t.Run("lists users with their stats", func(t *testing.T) {
+		users, err := GetAdminUsers(ctx, pool)
+		if err != nil {
+			t.Fatalf("GetAdminUsers failed: %v", err)
+		}
+		byID := make(map[string]*models.AdminUser)
+		for _, user := range users {
+			byID[user.ID] = user
+		}
+
+		stats := byID[userID].Stats
+		if stats.ThreadCount != 1 || stats.MessageCount != 1 || stats.BodyBytes != int64(len("Hello world")) ||
+			stats.UploadBytes != 5 || stats.LastSyncedAt == nil {
+			t.Errorf("Expected the stats of the cached mail, got %+v", stats)
+		}
+		empty, ok := byID[emptyUserID]
+		if !ok || empty.Stats.ThreadCount != 0 || empty.Stats.BodyBytes != 0 || empty.Stats.LastSyncedAt != nil {
+			t.Errorf("Expected a user without mail to have empty stats, got %+v", empty)
+		}
+	})
+
+	t.Run("gets a user", func(t *testing.T) {
+		user, err := GetAdminUser(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetAdminUser failed: %v", err)
+		}
+		if user.Email != "admin-users-test@example.com" || user.Stats.MessageCount != 1 {
+			t.Errorf("Expected the user with their stats, got %+v", user)
+		}
+	})
+
+	t.Run("deletes a user and their cached mail", func(t *testing.T) {
+		store := NewRateLimitStore(pool)
+		if _, _, err := store.Take(ctx, "user:admin-users-test@example.com", 1, 2, time.Now()); err != nil {
+			t.Fatalf("Take failed: %v", err)
+		}
+
+		if err := DeleteUser(ctx, pool, userID); err != nil {
+			t.Fatalf("DeleteUser failed: %v", err)
+		}
+
+		if _, err := GetAdminUser(ctx, pool, userID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+		var messages, changes int
+		if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM messages WHERE user_id = $1`, userID).Scan(&messages); err != nil {
+			t.Fatalf("Failed to count messages: %v", err)
+		}
+		if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM sync_changes WHERE user_id = $1`, userID).Scan(&changes); err != nil {
+			t.Fatalf("Failed to count sync changes: %v", err)
+		}
+		if messages != 0 || changes != 0 {
+			t.Errorf("Expected no messages or sync changes left, got %d and %d", messages, changes)
+		}
+		var buckets int
+		if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM rate_limit_buckets WHERE key = 'user:admin-users-test@example.com'`).Scan(&buckets); err != nil {
+			t.Fatalf("Failed to count rate limit buckets: %v", err)
+		}
+		if buckets != 0 {
+			t.Errorf("Expected the rate limit bucket to be deleted, got %d", buckets)
+		}
+	})
+
+	t.Run("returns ErrUserNotFound for unknown users", func(t *testing.T) {
+		if err := DeleteUser(ctx, pool, userID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+	})
+}
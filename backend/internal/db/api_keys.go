@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// ErrAPIKeyNotFound is returned when an API key doesn't exist, belongs to another user, or expired.
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// CreateAPIKey saves a new API key of the user with the hash of the key, and sets its ID and creation time.
+func CreateAPIKey(ctx context.Context, pool *pgxpool.Pool, userID string, key *models.APIKey, keyHash []byte) error {
+	err := pool.QueryRow(ctx, `
+		INSERT INTO api_keys (user_id, name, key_prefix, key_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`, userID, key.Name, key.Prefix, keyHash, key.ExpiresAt).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+	return nil
+}
+
+// GetAPIKeys returns the API keys of the user, the newest first, including the expired ones.
+func GetAPIKeys(ctx context.Context, pool *pgxpool.Pool, userID string) ([]*models.APIKey, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT id, name, key_prefix, expires_at, last_used_at, created_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []*models.APIKey{}
+	for rows.Next() {
+		var key models.APIKey
+		if err := rows.Scan(&key.ID, &key.Name, &key.Prefix, &key.ExpiresAt, &key.LastUsedAt, &key.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		keys = append(keys, &key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating API keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// DeleteAPIKey revokes an API key of the user. Returns ErrAPIKeyNotFound if there's no such key.
+func DeleteAPIKey(ctx context.Context, pool *pgxpool.Pool, userID, keyID string) error {
+	result, err := pool.Exec(ctx, `
+		DELETE FROM api_keys
+		WHERE id = $1 AND user_id = $2
+	`, keyID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete API key: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// UseAPIKey returns the email of the user whose unexpired API key has the hash, and records that the key was used.
+// It only writes last_used_at once a minute, so that busy clients don't write on every request.
+// Returns ErrAPIKeyNotFound if there's no such key.
+func UseAPIKey(ctx context.Context, pool *pgxpool.Pool, keyHash []byte) (string, error) {
+	var email string
+	err := pool.QueryRow(ctx, `
+		WITH key AS (
+			SELECT id, user_id FROM api_keys
+			WHERE key_hash = $1 AND (expires_at IS NULL OR expires_at > now())
+		), touched AS (
+			UPDATE api_keys SET last_used_at = now()
+			WHERE id IN (SELECT id FROM key) AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')
+		)
+		SELECT u.email FROM key JOIN users u ON u.id = key.user_id
+	`, keyHash).Scan(&email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to use API key: %w", err)
+	}
+	return email, nil
+}
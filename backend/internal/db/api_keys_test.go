@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestAPIKeys(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	email := "api-keys-test@example.com"
+	userID, err := GetOrCreateUser(ctx, pool, email)
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	otherUserID, err := GetOrCreateUser(ctx, pool, "api-keys-other@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	key := &models.APIKey{Name: "Backup script", Prefix: "vmk_abcdefgh"}
+	keyHash := []byte("hash of the key")
+
+	t.Run("creates and lists keys", func(t *testing.T) {
+		if err := CreateAPIKey(ctx, pool, userID, key, keyHash); err != nil {
+			t.Fatalf("CreateAPIKey failed: %v", err)
+		}
+		if key.ID == "" || key.CreatedAt.IsZero() {
+			t.Errorf("Expected an ID and a creation time, got %+v", key)
+		}
+
+		keys, err := GetAPIKeys(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetAPIKeys failed: %v", err)
+		}
+		if len(keys) != 1 || keys[0].Name != "Backup script" || keys[0].Prefix != "vmk_abcdefgh" || keys[0].LastUsedAt != nil {
+			t.Errorf("Expected the new key, got %+v", keys)
+		}
+	})
+
+	t.Run("uses keys and records when", func(t *testing.T) {
+		gotEmail, err := UseAPIKey(ctx, pool, keyHash)
+		if err != nil || gotEmail != email {
+			t.Fatalf("Expected the user's email, got %q and %v", gotEmail, err)
+		}
+
+		keys, err := GetAPIKeys(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetAPIKeys failed: %v", err)
+		}
+		if keys[0].LastUsedAt == nil {
+			t.Error("Expected the key to have a last use")
+		}
+
+		if _, err := UseAPIKey(ctx, pool, []byte("another hash")); !errors.Is(err, ErrAPIKeyNotFound) {
+			t.Errorf("Expected ErrAPIKeyNotFound for an unknown key, got %v", err)
+		}
+	})
+
+	t.Run("doesn't use expired keys", func(t *testing.T) {
+		expiresAt := time.Now().Add(-time.Minute)
+		expired := &models.APIKey{Name: "Old", Prefix: "vmk_old", ExpiresAt: &expiresAt}
+		if err := CreateAPIKey(ctx, pool, userID, expired, []byte("hash of the old key")); err != nil {
+			t.Fatalf("CreateAPIKey failed: %v", err)
+		}
+		if _, err := UseAPIKey(ctx, pool, []byte("hash of the old key")); !errors.Is(err, ErrAPIKeyNotFound) {
+			t.Errorf("Expected ErrAPIKeyNotFound for an expired key, got %v", err)
+		}
+	})
+
+	t.Run("revokes keys of the user only", func(t *testing.T) {
+		if err := DeleteAPIKey(ctx, pool, otherUserID, key.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+			t.Errorf("Expected ErrAPIKeyNotFound for another user's key, got %v", err)
+		}
+		if err := DeleteAPIKey(ctx, pool, userID, key.ID); err != nil {
+			t.Fatalf("DeleteAPIKey failed: %v", err)
+		}
+		if _, err := UseAPIKey(ctx, pool, keyHash); !errors.Is(err, ErrAPIKeyNotFound) {
+			t.Errorf("Expected ErrAPIKeyNotFound for a revoked key, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+const (
+	// AttachmentUploadTTL is how long we keep an upload that no draft references.
+	AttachmentUploadTTL = 24 * time.Hour
+
+	// AttachmentUploadCleanupInterval is how often RunAttachmentUploadCleaner deletes expired uploads.
+	AttachmentUploadCleanupInterval = 10 * time.Minute
+)
+
+// ErrAttachmentUploadQuotaExceeded is returned by CreateAttachmentUpload when the upload doesn't fit in the user's quota.
+var ErrAttachmentUploadQuotaExceeded = errors.New("attachment upload quota exceeded")
+
+// CreateAttachmentUpload saves an upload of the user, and sets its ID and timestamps.
+// The user's uploads, including the new one, can take up at most quotaBytes. Zero or less means no quota.
+// Returns ErrAttachmentUploadQuotaExceeded if the upload doesn't fit.
+func CreateAttachmentUpload(ctx context.Context, pool *pgxpool.Pool, userID string, upload *models.AttachmentUpload, quotaBytes int64) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// Lock the user, so that parallel uploads can't overshoot the quota together
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return fmt.Errorf("failed to lock user: %w", err)
+	}
+
+	if quotaBytes > 0 {
+		var usedBytes int64
+		err := tx.QueryRow(ctx, `
+			SELECT COALESCE(SUM(size_bytes), 0) FROM attachment_uploads WHERE user_id = $1
+		`, userID).Scan(&usedBytes)
+		if err != nil {
+			return fmt.Errorf("failed to get attachment upload usage: %w", err)
+		}
+		if usedBytes+upload.SizeBytes > quotaBytes {
+			return ErrAttachmentUploadQuotaExceeded
+		}
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO attachment_uploads (user_id, filename, content_type, size_bytes, data)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`, userID, upload.Filename, upload.ContentType, upload.SizeBytes, upload.Data).Scan(&upload.ID, &upload.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create attachment upload: %w", err)
+	}
+	upload.ExpiresAt = upload.CreatedAt.Add(AttachmentUploadTTL)
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// GetAttachmentUploads returns the user's uploads with the given IDs, with their data, by ID.
+// IDs that don't exist or belong to another user are missing from the map.
+func GetAttachmentUploads(ctx context.Context, pool *pgxpool.Pool, userID string, ids []string) (map[string]*models.AttachmentUpload, error) {
+	uploads := make(map[string]*models.AttachmentUpload, len(ids))
+	if len(ids) == 0 {
+		return uploads, nil
+	}
+
+	rows, err := pool.Query(ctx, `
+		SELECT id, filename, content_type, size_bytes, data, created_at
+		FROM attachment_uploads
+		WHERE user_id = $1 AND id = ANY($2::uuid[])
+	`, userID, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachment uploads: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var upload models.AttachmentUpload
+		if err := rows.Scan(&upload.ID, &upload.Filename, &upload.ContentType, &upload.SizeBytes, &upload.Data, &upload.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan attachment upload: %w", err)
+		}
+		upload.ExpiresAt = upload.CreatedAt.Add(AttachmentUploadTTL)
+		uploads[upload.ID] = &upload
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating attachment uploads: %w", err)
+	}
+	return uploads, nil
+}
+
+// DeleteAttachmentUploads deletes the user's uploads with the given IDs that no draft references, for example, once
+// they're sent. Returns how many it deleted.
+func DeleteAttachmentUploads(ctx context.Context, pool *pgxpool.Pool, userID string, ids []string) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	result, err := pool.Exec(ctx, `
+		DELETE FROM attachment_uploads a
+		WHERE a.user_id = $1 AND a.id = ANY($2::uuid[])
+		  AND NOT EXISTS (SELECT 1 FROM drafts d WHERE d.user_id = a.user_id AND a.id = ANY (d.attachment_upload_ids))
+	`, userID, ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete attachment uploads: %w", err)
+	}
+	return int(result.RowsAffected()), nil
+}
+
+// DeleteExpiredAttachmentUploads deletes the uploads created before the given time that no draft references.
+// Returns how many it deleted.
+func DeleteExpiredAttachmentUploads(ctx context.Context, pool *pgxpool.Pool, before time.Time) (int, error) {
+	result, err := pool.Exec(ctx, `
+		DELETE FROM attachment_uploads a
+		WHERE a.created_at < $1
+		  AND NOT EXISTS (SELECT 1 FROM drafts d WHERE d.user_id = a.user_id AND a.id = ANY (d.attachment_upload_ids))
+	`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired attachment uploads: %w", err)
+	}
+	return int(result.RowsAffected()), nil
+}
+
+// RunAttachmentUploadCleaner deletes expired uploads every interval until the context is canceled.
+// It blocks, so call it in a goroutine.
+func RunAttachmentUploadCleaner(ctx context.Context, pool *pgxpool.Pool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := DeleteExpiredAttachmentUploads(ctx, pool, time.Now().Add(-AttachmentUploadTTL))
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to delete expired attachment uploads", "error", err)
+			} else if deleted > 0 {
+				slog.InfoContext(ctx, "Deleted expired attachment uploads", "count", deleted)
+			}
+		}
+	}
+}
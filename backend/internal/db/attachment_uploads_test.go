@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestAttachmentUploads(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	userID, err := GetOrCreateUser(ctx, pool, "attachment-uploads-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	otherUserID, err := GetOrCreateUser(ctx, pool, "attachment-uploads-other@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	newUpload := func(data string) *models.AttachmentUpload {
+		return &models.AttachmentUpload{Filename: "notes.txt", ContentType: "text/plain", SizeBytes: int64(len(data)), Data: []byte(data)}
+	}
+
+	first := newUpload("hello")
+	t.Run("creates uploads within the quota", func(t *testing.T) {
+		if err := CreateAttachmentUpload(ctx, pool, userID, first, 10); err != nil {
+			t.Fatalf("CreateAttachmentUpload failed: %v", err)
+		}
+		if first.ID == "" || first.CreatedAt.IsZero() {
+			t.Errorf("Expected an ID and a creation time, got %+v", first)
+		}
+		if !first.ExpiresAt.Equal(first.CreatedAt.Add(AttachmentUploadTTL)) {
+			t.Errorf("Expected the upload to expire after %v, got %v", AttachmentUploadTTL, first.ExpiresAt)
+		}
+	})
+
+	t.Run("rejects uploads over the quota", func(t *testing.T) {
+		err := CreateAttachmentUpload(ctx, pool, userID, newUpload("world!"), 10)
+		if !errors.Is(err, ErrAttachmentUploadQuotaExceeded) {
+			t.Errorf("Expected ErrAttachmentUploadQuotaExceeded, got %v", err)
+		}
+		if err := CreateAttachmentUpload(ctx, pool, otherUserID, newUpload("world!"), 10); err != nil {
+			t.Errorf("Expected other users to have their own quota, got %v", err)
+		}
+		if err := CreateAttachmentUpload(ctx, pool, userID, newUpload("world!"), 0); err != nil {
+			t.Errorf("Expected a zero quota to mean no limit, got %v", err)
+		}
+	})
+
+	t.Run("gets only the user's uploads", func(t *testing.T) {
+		uploads, err := GetAttachmentUploads(ctx, pool, otherUserID, []string{first.ID})
+		if err != nil {
+			t.Fatalf("GetAttachmentUploads failed: %v", err)
+		}
+		if len(uploads) != 0 {
+			t.Errorf("Expected no uploads of another user, got %d", len(uploads))
+		}
+
+		uploads, err = GetAttachmentUploads(ctx, pool, userID, []string{first.ID})
+		if err != nil {
+			t.Fatalf("GetAttachmentUploads failed: %v", err)
+		}
+		if upload := uploads[first.ID]; upload == nil || string(upload.Data) != "hello" {
+			t.Errorf("Expected the upload with its data, got %+v", upload)
+		}
+	})
+
+	t.Run("deletes expired uploads unless a draft references them", func(t *testing.T) {
+		kept := newUpload("draft")
+		if err := CreateAttachmentUpload(ctx, pool, userID, kept, 0); err != nil {
+			t.Fatalf("CreateAttachmentUpload failed: %v", err)
+		}
+		draft := &models.Draft{To: []string{}, Cc: []string{}, Bcc: []string{}, AttachmentUploadIDs: []string{kept.ID}}
+		if err := CreateDraft(ctx, pool, userID, draft); err != nil {
+			t.Fatalf("CreateDraft failed: %v", err)
+		}
+
+		if _, err := DeleteExpiredAttachmentUploads(ctx, pool, time.Now().Add(time.Minute)); err != nil {
+			t.Fatalf("DeleteExpiredAttachmentUploads failed: %v", err)
+		}
+
+		uploads, err := GetAttachmentUploads(ctx, pool, userID, []string{first.ID, kept.ID})
+		if err != nil {
+			t.Fatalf("GetAttachmentUploads failed: %v", err)
+		}
+		if uploads[first.ID] != nil {
+			t.Error("Expected the expired upload to be deleted")
+		}
+		if uploads[kept.ID] == nil {
+			t.Error("Expected the upload of the draft to be kept")
+		}
+	})
+
+	t.Run("deletes the user's sent uploads unless a draft references them", func(t *testing.T) {
+		sent := newUpload("sent")
+		drafted := newUpload("drafted")
+		for _, upload := range []*models.AttachmentUpload{sent, drafted} {
+			if err := CreateAttachmentUpload(ctx, pool, userID, upload, 0); err != nil {
+				t.Fatalf("CreateAttachmentUpload failed: %v", err)
+			}
+		}
+		draft := &models.Draft{To: []string{}, Cc: []string{}, Bcc: []string{}, AttachmentUploadIDs: []string{drafted.ID}}
+		if err := CreateDraft(ctx, pool, userID, draft); err != nil {
+			t.Fatalf("CreateDraft failed: %v", err)
+		}
+
+		deleted, err := DeleteAttachmentUploads(ctx, pool, otherUserID, []string{sent.ID})
+		if err != nil {
+			t.Fatalf("DeleteAttachmentUploads failed: %v", err)
+		}
+		if deleted != 0 {
+			t.Errorf("Expected no uploads of another user to be deleted, got %d", deleted)
+		}
+
+		deleted, err = DeleteAttachmentUploads(ctx, pool, userID, []string{sent.ID, drafted.ID})
+		if err != nil {
+			t.Fatalf("DeleteAttachmentUploads failed: %v", err)
+		}
+		if deleted != 1 {
+			t.Errorf("Expected 1 deleted upload, got %d", deleted)
+		}
+
+		t.Run("and deletes the draft's uploads with the draft", func(t *testing.T) {
+			if _, err := DeleteDraft(ctx, pool, userID, draft.ID); err != nil {
+				t.Fatalf("DeleteDraft failed: %v", err)
+			}
+			uploads, err := GetAttachmentUploads(ctx, pool, userID, []string{sent.ID, drafted.ID})
+			if err != nil {
+				t.Fatalf("GetAttachmentUploads failed: %v", err)
+			}
+			if len(uploads) != 0 {
+				t.Errorf("Expected no uploads left, got %d", len(uploads))
+			}
+		})
+	})
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// ErrBlockedSenderNotFound is returned when a blocked sender doesn't exist or belongs to another user.
+var ErrBlockedSenderNotFound = errors.New("blocked sender not found")
+
+// ErrBlockedSenderExists is returned when the user already blocked the same address.
+var ErrBlockedSenderExists = errors.New("sender is already blocked")
+
+// GetBlockedSenders returns all blocked senders of the user, ordered by email address.
+func GetBlockedSenders(ctx context.Context, pool *pgxpool.Pool, userID string) ([]*models.BlockedSender, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT id, user_id, email, action, created_at, updated_at
+		FROM blocked_senders
+		WHERE user_id = $1
+		ORDER BY email
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blocked senders: %w", err)
+	}
+	defer rows.Close()
+
+	senders := []*models.BlockedSender{}
+	for rows.Next() {
+		var sender models.BlockedSender
+		if err := rows.Scan(&sender.ID, &sender.UserID, &sender.Email, &sender.Action, &sender.CreatedAt, &sender.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan blocked sender: %w", err)
+		}
+		senders = append(senders, &sender)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating blocked senders: %w", err)
+	}
+
+	return senders, nil
+}
+
+// GetBlockedSenderActions returns the actions for the user's blocked senders, keyed by their lowercase address.
+func GetBlockedSenderActions(ctx context.Context, pool *pgxpool.Pool, userID string) (map[string]string, error) {
+	senders, err := GetBlockedSenders(ctx, pool, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := make(map[string]string, len(senders))
+	for _, sender := range senders {
+		actions[sender.Email] = sender.Action
+	}
+	return actions, nil
+}
+
+// CreateBlockedSender saves a new blocked sender with a lowercase address, and sets its ID and timestamps.
+// Returns ErrBlockedSenderExists if the user already blocked the address.
+func CreateBlockedSender(ctx context.Context, pool *pgxpool.Pool, userID string, sender *models.BlockedSender) error {
+	sender.Email = strings.ToLower(sender.Email)
+	err := pool.QueryRow(ctx, `
+		INSERT INTO blocked_senders (user_id, email, action)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, updated_at
+	`, userID, sender.Email, sender.Action).Scan(&sender.ID, &sender.CreatedAt, &sender.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrBlockedSenderExists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create blocked sender: %w", err)
+	}
+	sender.UserID = userID
+	return nil
+}
+
+// UpdateBlockedSender replaces the address and action of a blocked sender of the user, and sets its timestamps.
+// Returns ErrBlockedSenderNotFound if there's no such blocked sender,
+// and ErrBlockedSenderExists if the user already blocked the new address.
+func UpdateBlockedSender(ctx context.Context, pool *pgxpool.Pool, userID string, sender *models.BlockedSender) error {
+	sender.Email = strings.ToLower(sender.Email)
+	err := pool.QueryRow(ctx, `
+		UPDATE blocked_senders SET
+			email = $3,
+			action = $4,
+			updated_at = NOW()
+		WHERE id = $1 AND user_id = $2
+		RETURNING created_at, updated_at
+	`, sender.ID, userID, sender.Email, sender.Action).Scan(&sender.CreatedAt, &sender.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrBlockedSenderNotFound
+	}
+	if isUniqueViolation(err) {
+		return ErrBlockedSenderExists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update blocked sender: %w", err)
+	}
+	sender.UserID = userID
+	return nil
+}
+
+// DeleteBlockedSender unblocks a sender of the user. Returns ErrBlockedSenderNotFound if there's no such blocked sender.
+func DeleteBlockedSender(ctx context.Context, pool *pgxpool.Pool, userID, senderID string) error {
+	result, err := pool.Exec(ctx, `
+		DELETE FROM blocked_senders
+		WHERE id = $1 AND user_id = $2
+	`, senderID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete blocked sender: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrBlockedSenderNotFound
+	}
+	return nil
+}
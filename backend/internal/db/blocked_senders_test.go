@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestBlockedSenders(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "blocked-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	otherUserID, err := GetOrCreateUser(ctx, pool, "blocked-other@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	sender := &models.BlockedSender{Email: "Spammer@Example.com", Action: models.BlockedSenderActionTrash}
+
+	t.Run("blocks a sender with a lowercase address", func(t *testing.T) {
+		if err := CreateBlockedSender(ctx, pool, userID, sender); err != nil {
+			t.Fatalf("CreateBlockedSender failed: %v", err)
+		}
+		if sender.ID == "" || sender.Email != "spammer@example.com" {
+			t.Errorf("Unexpected blocked sender: %+v", sender)
+		}
+	})
+
+	t.Run("rejects blocking the same address twice", func(t *testing.T) {
+		duplicate := &models.BlockedSender{Email: "spammer@example.com", Action: models.BlockedSenderActionSpam}
+		if err := CreateBlockedSender(ctx, pool, userID, duplicate); !errors.Is(err, ErrBlockedSenderExists) {
+			t.Errorf("Expected ErrBlockedSenderExists, got %v", err)
+		}
+	})
+
+	t.Run("updates the action", func(t *testing.T) {
+		sender.Action = models.BlockedSenderActionSpam
+		if err := UpdateBlockedSender(ctx, pool, userID, sender); err != nil {
+			t.Fatalf("UpdateBlockedSender failed: %v", err)
+		}
+
+		actions, err := GetBlockedSenderActions(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetBlockedSenderActions failed: %v", err)
+		}
+		if len(actions) != 1 || actions["spammer@example.com"] != models.BlockedSenderActionSpam {
+			t.Errorf("Unexpected actions: %v", actions)
+		}
+	})
+
+	t.Run("doesn't let other users change it", func(t *testing.T) {
+		other := &models.BlockedSender{ID: sender.ID, Email: "x@example.com", Action: models.BlockedSenderActionTrash}
+		if err := UpdateBlockedSender(ctx, pool, otherUserID, other); !errors.Is(err, ErrBlockedSenderNotFound) {
+			t.Errorf("Expected ErrBlockedSenderNotFound, got %v", err)
+		}
+		if err := DeleteBlockedSender(ctx, pool, otherUserID, sender.ID); !errors.Is(err, ErrBlockedSenderNotFound) {
+			t.Errorf("Expected ErrBlockedSenderNotFound, got %v", err)
+		}
+	})
+
+	t.Run("unblocks a sender", func(t *testing.T) {
+		if err := DeleteBlockedSender(ctx, pool, userID, sender.ID); err != nil {
+			t.Fatalf("DeleteBlockedSender failed: %v", err)
+		}
+		senders, err := GetBlockedSenders(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetBlockedSenders failed: %v", err)
+		}
+		if len(senders) != 0 {
+			t.Errorf("Expected no blocked senders, got %+v", senders)
+		}
+	})
+}
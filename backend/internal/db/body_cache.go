@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/maintenance"
+)
+
+const (
+	// BodyCacheEvictionInterval is how often RunBodyCacheEvictor evicts the bodies over the quota.
+	BodyCacheEvictionInterval = time.Hour
+
+	// bodyAccessTouchInterval is how old the last access of a body must be before TouchMessageBodies records a new
+	// one, so that rereading a thread doesn't rewrite its messages each time.
+	bodyAccessTouchInterval = time.Hour
+)
+
+// bodyCachedCondition returns the SQL condition that's true if we have the body of the message with the alias.
+// It matches collectMessagesToSync in the api package, which fetches the bodies of the messages without one.
+func bodyCachedCondition(alias string) string {
+	return fmt.Sprintf(`(COALESCE(%[1]s.unsafe_body_html, '') <> '' OR %[1]s.unsafe_body_html_zstd IS NOT NULL
+		OR COALESCE(%[1]s.body_text, '') <> '')`, alias)
+}
+
+// BodyEvictionStats tells what an EvictMessageBodies run evicted.
+type BodyEvictionStats struct {
+	// Bodies is how many message bodies we evicted.
+	Bodies int
+	// Bytes is how much space the evicted bodies took up, as stored.
+	Bytes int64
+	// Users is how many users we evicted bodies of.
+	Users int
+}
+
+// TouchMessageBodies records that the user read the bodies of the messages, so that the body cache keeps them longer.
+// It only writes the messages whose last access is older than bodyAccessTouchInterval.
+func TouchMessageBodies(ctx context.Context, pool *pgxpool.Pool, messageIDs []string) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+	_, err := pool.Exec(ctx, `
+		UPDATE messages SET body_accessed_at = now()
+		WHERE id = ANY($1) AND (body_accessed_at IS NULL OR body_accessed_at < now() - make_interval(secs => $2))
+	`, messageIDs, bodyAccessTouchInterval.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to record message body access: %w", err)
+	}
+	return nil
+}
+
+// EvictMessageBodies evicts the cached bodies of each user that are over quotaBytes, least recently used first.
+// A body counts as used when the user last opened its thread, or if they never did, when the message was sent.
+// It keeps the headers and the snippets, so the messages stay in thread lists and searches by headers, and clears
+// the content hash, so that opening the thread fetches the body again.
+func EvictMessageBodies(ctx context.Context, pool *pgxpool.Pool, quotaBytes int64) (BodyEvictionStats, error) {
+	var stats BodyEvictionStats
+
+	rows, err := pool.Query(ctx, `
+		WITH sized AS (
+			SELECT m.id, m.user_id,
+			       COALESCE(octet_length(m.unsafe_body_html), 0) + COALESCE(octet_length(m.unsafe_body_html_zstd), 0) +
+			       COALESCE(octet_length(m.body_text), 0) AS body_bytes,
+			       COALESCE(m.body_accessed_at, m.sent_at, '-infinity'::timestamptz) AS used_at
+			FROM messages m
+			WHERE `+bodyCachedCondition("m")+`
+		), ranked AS (
+			SELECT id, user_id, body_bytes,
+			       SUM(body_bytes) OVER (PARTITION BY user_id ORDER BY used_at DESC, id DESC) AS kept_bytes
+			FROM sized
+		), evicted AS (
+			UPDATE messages m SET
+				unsafe_body_html = NULL,
+				unsafe_body_html_zstd = NULL,
+				body_text = '',
+				content_hash = NULL,
+				truncated = FALSE,
+				body_accessed_at = NULL
+			FROM ranked r
+			WHERE m.id = r.id AND r.kept_bytes > $1
+			RETURNING m.user_id, r.body_bytes
+		)
+		SELECT user_id, COUNT(*), SUM(body_bytes)::BIGINT FROM evicted GROUP BY user_id
+	`, quotaBytes)
+	if err != nil {
+		return stats, fmt.Errorf("failed to evict message bodies: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID string
+		var bodies int
+		var bytes int64
+		if err := rows.Scan(&userID, &bodies, &bytes); err != nil {
+			return stats, fmt.Errorf("failed to scan evicted bodies: %w", err)
+		}
+		stats.Bodies += bodies
+		stats.Bytes += bytes
+		stats.Users++
+	}
+	if err := rows.Err(); err != nil {
+		return stats, fmt.Errorf("error iterating evicted bodies: %w", err)
+	}
+	return stats, nil
+}
+
+// RunBodyCacheEvictor evicts the bodies over the quota of each user with EvictMessageBodies every interval until
+// the context is canceled. It's a heavy job, so it skips the runs outside the maintenance window. A nil window allows
+// every run. A quota of zero or less means no limit, so it returns right away.
+// It blocks, so call it in a goroutine.
+func RunBodyCacheEvictor(ctx context.Context, pool *pgxpool.Pool, interval time.Duration, quotaBytes int64, window *maintenance.Window) {
+	if quotaBytes <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !window.Allows(time.Now()) {
+				continue
+			}
+			evictCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+			stats, err := EvictMessageBodies(evictCtx, pool, quotaBytes)
+			cancel()
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to evict message bodies", "error", err)
+			} else if stats.Bodies > 0 {
+				slog.InfoContext(ctx, "Evicted message bodies over the cache quota",
+					"bodies", stats.Bodies, "bytes", stats.Bytes, "users", stats.Users)
+			}
+		}
+	}
+}
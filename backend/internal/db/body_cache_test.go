@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestEvictMessageBodies(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	userID, err := GetOrCreateUser(ctx, pool, "body-cache-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	thread := &models.Thread{UserID: userID, StableThreadID: "<body-cache@example.com>", Subject: "Body cache"}
+	if err := SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+	now := time.Now()
+	saveMessage := func(uid int64, sentAt time.Time) *models.Message {
+		msg := &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         uid,
+			IMAPFolderName:  "INBOX",
+			MessageIDHeader: fmt.Sprintf("<body-cache-%d@example.com>", uid),
+			Subject:         "Body cache",
+			SentAt:          &sentAt,
+			BodyText:        strings.Repeat("x", 100),
+		}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+		return msg
+	}
+	saveMessage(1, now.AddDate(-3, 0, 0))
+	read := saveMessage(2, now.AddDate(-2, 0, 0))
+	saveMessage(3, now.AddDate(0, 0, -1))
+
+	t.Run("marks threads with all bodies as cached", func(t *testing.T) {
+		threads, err := GetThreadsForFolder(ctx, pool, userID, "INBOX", 10, 0)
+		if err != nil {
+			t.Fatalf("GetThreadsForFolder failed: %v", err)
+		}
+		if len(threads) != 1 || !threads[0].BodyCached {
+			t.Errorf("Expected a cached thread, got %+v", threads)
+		}
+	})
+
+	t.Run("evicts the least recently used bodies over the quota", func(t *testing.T) {
+		if err := TouchMessageBodies(ctx, pool, []string{read.ID}); err != nil {
+			t.Fatalf("TouchMessageBodies failed: %v", err)
+		}
+
+		stats, err := EvictMessageBodies(ctx, pool, 250)
+		if err != nil {
+			t.Fatalf("EvictMessageBodies failed: %v", err)
+		}
+		if stats.Bodies < 1 || stats.Bytes < 100 {
+			t.Errorf("Expected at least one evicted body, got %+v", stats)
+		}
+
+		for uid, expectCached := range map[int64]bool{1: false, 2: true, 3: true} {
+			msg, err := GetMessageByUID(ctx, pool, userID, "INBOX", uid)
+			if err != nil {
+				t.Fatalf("GetMessageByUID failed: %v", err)
+			}
+			if msg.BodyCached != expectCached || (msg.BodyText != "") != expectCached {
+				t.Errorf("Expected message %d cached=%v, got %+v", uid, expectCached, msg)
+			}
+			if msg.Subject != "Body cache" {
+				t.Errorf("Expected message %d to keep its headers, got %+v", uid, msg)
+			}
+		}
+	})
+
+	t.Run("marks threads with evicted bodies as not cached", func(t *testing.T) {
+		threads, err := GetThreadsForFolder(ctx, pool, userID, "INBOX", 10, 0)
+		if err != nil {
+			t.Fatalf("GetThreadsForFolder failed: %v", err)
+		}
+		if len(threads) != 1 || threads[0].BodyCached {
+			t.Errorf("Expected a thread that's not cached, got %+v", threads)
+		}
+	})
+
+	t.Run("saves the body again after it's fetched", func(t *testing.T) {
+		sentAt := now.AddDate(-3, 0, 0)
+		msg := &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         1,
+			IMAPFolderName:  "INBOX",
+			MessageIDHeader: "<body-cache-1@example.com>",
+			Subject:         "Body cache",
+			SentAt:          &sentAt,
+			BodyText:        strings.Repeat("x", 100),
+		}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+		saved, err := GetMessageByUID(ctx, pool, userID, "INBOX", 1)
+		if err != nil {
+			t.Fatalf("GetMessageByUID failed: %v", err)
+		}
+		if !saved.BodyCached {
+			t.Errorf("Expected the body to be cached again, got %+v", saved)
+		}
+	})
+}
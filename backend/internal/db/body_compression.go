@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/klauspost/compress/zstd"
+	"github.com/vdavid/vmail/backend/internal/maintenance"
+)
+
+const (
+	// BodyCompressionInterval is how often RunBodyCompressor checks for uncompressed bodies
+	// while it waits for the maintenance window.
+	BodyCompressionInterval = 10 * time.Minute
+
+	// bodyCompressionBatchSize is how many bodies CompressMessageBodies compresses in one transaction.
+	bodyCompressionBatchSize = 200
+)
+
+// The encoder and decoder are safe for concurrent use with EncodeAll and DecodeAll.
+// They only fail to create with invalid options, so we ignore the errors.
+var (
+	bodyEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	bodyDecoder, _ = zstd.NewReader(nil)
+)
+
+// compressBody compresses an HTML body for the unsafe_body_html_zstd column.
+func compressBody(body string) []byte {
+	return bodyEncoder.EncodeAll([]byte(body), nil)
+}
+
+// decompressBody decompresses a body from the unsafe_body_html_zstd column.
+func decompressBody(compressed []byte) (string, error) {
+	body, err := bodyDecoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress message body: %w", err)
+	}
+	return string(body), nil
+}
+
+// splitBodyHTML returns the values of the unsafe_body_html and unsafe_body_html_zstd columns for an HTML body.
+// Non-empty bodies only go to the compressed column.
+func splitBodyHTML(body string) (*string, []byte) {
+	if body == "" {
+		return &body, nil
+	}
+	return nil, compressBody(body)
+}
+
+// joinBodyHTML returns the HTML body from the unsafe_body_html and unsafe_body_html_zstd columns.
+// Bodies that we haven't compressed yet are in the plain column.
+func joinBodyHTML(plain *string, compressed []byte) (string, error) {
+	if compressed != nil {
+		return decompressBody(compressed)
+	}
+	if plain == nil {
+		return "", nil
+	}
+	return *plain, nil
+}
+
+// BodyCompressionStats describes what CompressMessageBodies compressed.
+type BodyCompressionStats struct {
+	Bodies int
+	// BytesBefore and BytesAfter are the sizes of the bodies before and after compressing them.
+	BytesBefore int64
+	BytesAfter  int64
+}
+
+// CompressMessageBodies compresses up to batchSize HTML bodies that are still uncompressed, from before we
+// compressed them on write. Returns zero stats if there are none left.
+func CompressMessageBodies(ctx context.Context, pool *pgxpool.Pool, batchSize int) (BodyCompressionStats, error) {
+	var stats BodyCompressionStats
+
+	rows, err := pool.Query(ctx, `
+		SELECT id, unsafe_body_html FROM messages
+		WHERE unsafe_body_html <> ''
+		LIMIT $1
+	`, batchSize)
+	if err != nil {
+		return stats, fmt.Errorf("failed to get uncompressed message bodies: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	var compressed [][]byte
+	for rows.Next() {
+		var id, body string
+		if err := rows.Scan(&id, &body); err != nil {
+			return stats, fmt.Errorf("failed to scan message body: %w", err)
+		}
+		c := compressBody(body)
+		ids = append(ids, id)
+		compressed = append(compressed, c)
+		stats.BytesBefore += int64(len(body))
+		stats.BytesAfter += int64(len(c))
+	}
+	if err := rows.Err(); err != nil {
+		return stats, fmt.Errorf("error iterating message bodies: %w", err)
+	}
+	if len(ids) == 0 {
+		return stats, nil
+	}
+
+	// A sync might have written a new body in the meantime. New bodies are already compressed,
+	// so the plain column is empty then, and we leave the row alone.
+	result, err := pool.Exec(ctx, `
+		UPDATE messages m SET unsafe_body_html = NULL, unsafe_body_html_zstd = c.body
+		FROM unnest($1::uuid[], $2::bytea[]) AS c(id, body)
+		WHERE m.id = c.id AND m.unsafe_body_html <> ''
+	`, ids, compressed)
+	if err != nil {
+		return stats, fmt.Errorf("failed to save compressed message bodies: %w", err)
+	}
+	stats.Bodies = int(result.RowsAffected())
+
+	return stats, nil
+}
+
+// RunBodyCompressor compresses the HTML bodies that are still uncompressed, in the maintenance window.
+// It logs how much space it saved, and returns when no uncompressed bodies are left, or when the context is canceled.
+// A nil window allows every run. It blocks, so call it in a goroutine.
+func RunBodyCompressor(ctx context.Context, pool *pgxpool.Pool, interval time.Duration, window *maintenance.Window) {
+	var total BodyCompressionStats
+	defer func() {
+		if total.Bodies > 0 {
+			slog.InfoContext(ctx, "Compressed message bodies in total", "count", total.Bodies, "savings", formatCompressionSavings(total))
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Compress batch after batch while the window is open
+		for window.Allows(time.Now()) {
+			batchCtx, cancel := context.WithTimeout(ctx, time.Minute)
+			stats, err := CompressMessageBodies(batchCtx, pool, bodyCompressionBatchSize)
+			cancel()
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				slog.WarnContext(ctx, "Failed to compress message bodies", "error", err)
+				break
+			}
+			if stats.BytesBefore == 0 {
+				return
+			}
+			total.Bodies += stats.Bodies
+			total.BytesBefore += stats.BytesBefore
+			total.BytesAfter += stats.BytesAfter
+			slog.InfoContext(ctx, "Compressed message bodies", "count", stats.Bodies, "savings", formatCompressionSavings(stats))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// formatCompressionSavings describes the space savings of compressing bodies for logs.
+func formatCompressionSavings(stats BodyCompressionStats) string {
+	saved := 0.0
+	if stats.BytesBefore > 0 {
+		saved = 100 * float64(stats.BytesBefore-stats.BytesAfter) / float64(stats.BytesBefore)
+	}
+	return fmt.Sprintf("%.1f MB to %.1f MB (%.0f%% saved)",
+		float64(stats.BytesBefore)/1e6, float64(stats.BytesAfter)/1e6, saved)
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestBodyHTMLColumns(t *testing.T) {
+	t.Run("compresses non-empty bodies", func(t *testing.T) {
+		body := strings.Repeat("<p>Hello, world!</p>", 100)
+		plain, compressed := splitBodyHTML(body)
+		if plain != nil {
+			t.Errorf("Expected no plain body, got %q", *plain)
+		}
+		if len(compressed) >= len(body)/10 {
+			t.Errorf("Expected the body to compress well, got %d bytes from %d", len(compressed), len(body))
+		}
+		joined, err := joinBodyHTML(plain, compressed)
+		if err != nil {
+			t.Fatalf("joinBodyHTML failed: %v", err)
+		}
+		if joined != body {
+			t.Errorf("Expected the body back, got %q", joined)
+		}
+	})
+
+	t.Run("keeps empty bodies plain", func(t *testing.T) {
+		plain, compressed := splitBodyHTML("")
+		if plain == nil || *plain != "" || compressed != nil {
+			t.Errorf("Expected an empty plain body, got %v and %v", plain, compressed)
+		}
+	})
+
+	t.Run("reads uncompressed bodies", func(t *testing.T) {
+		body := "<p>Old</p>"
+		if joined, err := joinBodyHTML(&body, nil); err != nil || joined != body {
+			t.Errorf("Expected %q, got %q, %v", body, joined, err)
+		}
+		if joined, err := joinBodyHTML(nil, nil); err != nil || joined != "" {
+			t.Errorf("Expected an empty body, got %q, %v", joined, err)
+		}
+	})
+
+	t.Run("fails on corrupt data", func(t *testing.T) {
+		if _, err := joinBodyHTML(nil, []byte("not zstd")); err == nil {
+			t.Error("Expected an error")
+		}
+	})
+}
+
+func TestCompressMessageBodies(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "compression-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	thread := &models.Thread{UserID: userID, StableThreadID: "compression-thread", Subject: "Big"}
+	if err := SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+	body := strings.Repeat("<p>Hello, world!</p>", 100)
+	msg := &models.Message{
+		ThreadID:        thread.ID,
+		UserID:          userID,
+		IMAPUID:         1,
+		IMAPFolderName:  "INBOX",
+		MessageIDHeader: "<compression@example.com>",
+		UnsafeBodyHTML:  body,
+	}
+	if err := SaveMessage(ctx, pool, msg); err != nil {
+		t.Fatalf("SaveMessage failed: %v", err)
+	}
+
+	// Make the body look like it's from before we compressed on write
+	if _, err := pool.Exec(ctx, `
+		UPDATE messages SET unsafe_body_html = $2, unsafe_body_html_zstd = NULL WHERE id = $1
+	`, msg.ID, body); err != nil {
+		t.Fatalf("Failed to uncompress body: %v", err)
+	}
+
+	t.Run("compresses old bodies", func(t *testing.T) {
+		stats, err := CompressMessageBodies(ctx, pool, 10)
+		if err != nil {
+			t.Fatalf("CompressMessageBodies failed: %v", err)
+		}
+		if stats.Bodies != 1 || stats.BytesBefore != int64(len(body)) || stats.BytesAfter >= stats.BytesBefore {
+			t.Errorf("Unexpected stats: %+v", stats)
+		}
+
+		retrieved, err := GetMessageByUID(ctx, pool, userID, "INBOX", 1)
+		if err != nil {
+			t.Fatalf("GetMessageByUID failed: %v", err)
+		}
+		if retrieved.UnsafeBodyHTML != body {
+			t.Errorf("Expected the body back, got %q", retrieved.UnsafeBodyHTML)
+		}
+	})
+
+	t.Run("has nothing left to compress", func(t *testing.T) {
+		stats, err := CompressMessageBodies(ctx, pool, 10)
+		if err != nil {
+			t.Fatalf("CompressMessageBodies failed: %v", err)
+		}
+		if stats.Bodies != 0 || stats.BytesBefore != 0 {
+			t.Errorf("Expected nothing to compress, got %+v", stats)
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// ErrCalendarEventNotFound is returned when a message has no invite, or the user has no such message.
+var ErrCalendarEventNotFound = errors.New("calendar event not found")
+
+const calendarEventColumns = `ce.uid, ce.method, ce.summary, ce.location, ce.starts_at, ce.ends_at, ce.all_day,
+	ce.organizer, ce.attendees`
+
+// scanCalendarEvent scans the extra columns of the row into extra, and then the calendarEventColumns.
+func scanCalendarEvent(row pgx.Row, extra ...any) (*models.CalendarEvent, error) {
+	var event models.CalendarEvent
+	var organizerJSON, attendeesJSON []byte
+	dest := append(extra, &event.UID, &event.Method, &event.Summary, &event.Location, &event.StartsAt, &event.EndsAt,
+		&event.AllDay, &organizerJSON, &attendeesJSON)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if organizerJSON != nil {
+		if err := json.Unmarshal(organizerJSON, &event.Organizer); err != nil {
+			return nil, fmt.Errorf("failed to decode organizer: %w", err)
+		}
+	}
+	if err := json.Unmarshal(attendeesJSON, &event.Attendees); err != nil {
+		return nil, fmt.Errorf("failed to decode attendees: %w", err)
+	}
+	if event.Attendees == nil {
+		event.Attendees = []models.CalendarParticipant{}
+	}
+	return &event, nil
+}
+
+// SaveCalendarEvent saves the event of a message's invite, replacing the one it had, for example, after the body
+// was fetched again.
+func SaveCalendarEvent(ctx context.Context, pool *pgxpool.Pool, messageID string, event *models.CalendarEvent) error {
+	var organizerJSON []byte
+	if event.Organizer != nil {
+		var err error
+		if organizerJSON, err = json.Marshal(event.Organizer); err != nil {
+			return fmt.Errorf("failed to encode organizer: %w", err)
+		}
+	}
+	attendees := event.Attendees
+	if attendees == nil {
+		attendees = []models.CalendarParticipant{}
+	}
+	attendeesJSON, err := json.Marshal(attendees)
+	if err != nil {
+		return fmt.Errorf("failed to encode attendees: %w", err)
+	}
+
+	_, err = pool.Exec(ctx, `
+		INSERT INTO calendar_events (message_id, uid, method, summary, location, starts_at, ends_at, all_day,
+		                             organizer, attendees)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9::jsonb, $10::jsonb)
+		ON CONFLICT (message_id) DO UPDATE SET
+			uid = EXCLUDED.uid,
+			method = EXCLUDED.method,
+			summary = EXCLUDED.summary,
+			location = EXCLUDED.location,
+			starts_at = EXCLUDED.starts_at,
+			ends_at = EXCLUDED.ends_at,
+			all_day = EXCLUDED.all_day,
+			organizer = EXCLUDED.organizer,
+			attendees = EXCLUDED.attendees
+	`, messageID, event.UID, event.Method, event.Summary, event.Location, event.StartsAt, event.EndsAt, event.AllDay,
+		nullableJSON(organizerJSON), string(attendeesJSON))
+	if err != nil {
+		return fmt.Errorf("failed to save calendar event: %w", err)
+	}
+	return nil
+}
+
+// GetCalendarEvent returns the event of the user's message.
+// Returns ErrCalendarEventNotFound if the message has no invite, or the user has no such message.
+func GetCalendarEvent(ctx context.Context, pool *pgxpool.Pool, userID, messageID string) (*models.CalendarEvent, error) {
+	event, err := scanCalendarEvent(pool.QueryRow(ctx, `
+		SELECT `+calendarEventColumns+`
+		FROM calendar_events ce
+		JOIN messages m ON m.id = ce.message_id
+		WHERE m.user_id = $1 AND ce.message_id = $2
+	`, userID, messageID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrCalendarEventNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get calendar event: %w", err)
+	}
+	return event, nil
+}
+
+// GetCalendarEventsForMessages returns the events of the messages that have an invite, by message ID.
+func GetCalendarEventsForMessages(ctx context.Context, pool *pgxpool.Pool, messageIDs []string) (map[string]*models.CalendarEvent, error) {
+	events := make(map[string]*models.CalendarEvent)
+	if len(messageIDs) == 0 {
+		return events, nil
+	}
+
+	rows, err := pool.Query(ctx, `
+		SELECT ce.message_id, `+calendarEventColumns+`
+		FROM calendar_events ce
+		WHERE ce.message_id = ANY($1)
+	`, messageIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get calendar events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID string
+		event, err := scanCalendarEvent(rows, &messageID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan calendar event: %w", err)
+		}
+		events[messageID] = event
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating calendar events: %w", err)
+	}
+
+	return events, nil
+}
+
+// nullableJSON returns the JSON as a string, or nil for SQL NULL if there's none.
+func nullableJSON(data []byte) *string {
+	if data == nil {
+		return nil
+	}
+	s := string(data)
+	return &s
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestCalendarEvents(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	userID, err := GetOrCreateUser(ctx, pool, "calendar-events-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	thread := &models.Thread{UserID: userID, StableThreadID: "<invite@example.com>", Subject: "Plans"}
+	if err := SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+	var messageIDs []string
+	for uid := int64(1); uid <= 2; uid++ {
+		msg := &models.Message{ThreadID: thread.ID, UserID: userID, IMAPUID: uid, IMAPFolderName: "INBOX"}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+		messageIDs = append(messageIDs, msg.ID)
+	}
+
+	startsAt := time.Date(2025, 3, 4, 9, 0, 0, 0, time.UTC)
+	event := &models.CalendarEvent{
+		UID:       "meeting@example.com",
+		Method:    "REQUEST",
+		Summary:   "Plans",
+		StartsAt:  startsAt,
+		Organizer: &models.CalendarParticipant{Email: "alice@example.com", Name: "Alice"},
+		Attendees: []models.CalendarParticipant{{Email: "bob@example.com", Status: "needs-action"}},
+	}
+
+	t.Run("saves and gets the events of messages", func(t *testing.T) {
+		if err := SaveCalendarEvent(ctx, pool, messageIDs[0], event); err != nil {
+			t.Fatalf("SaveCalendarEvent failed: %v", err)
+		}
+
+		events, err := GetCalendarEventsForMessages(ctx, pool, messageIDs)
+		if err != nil {
+			t.Fatalf("GetCalendarEventsForMessages failed: %v", err)
+		}
+		if len(events) != 1 {
+			t.Fatalf("Expected 1 event, got %d", len(events))
+		}
+		got := events[messageIDs[0]]
+		if got == nil || got.UID != event.UID || !got.StartsAt.Equal(startsAt) || got.EndsAt != nil ||
+			got.Organizer == nil || *got.Organizer != *event.Organizer ||
+			len(got.Attendees) != 1 || got.Attendees[0] != event.Attendees[0] {
+			t.Errorf("Expected %+v, got %+v", event, got)
+		}
+	})
+
+	t.Run("replaces the event of a message", func(t *testing.T) {
+		cancel := *event
+		cancel.Method = "CANCEL"
+		cancel.Organizer = nil
+		if err := SaveCalendarEvent(ctx, pool, messageIDs[0], &cancel); err != nil {
+			t.Fatalf("SaveCalendarEvent failed: %v", err)
+		}
+
+		events, err := GetCalendarEventsForMessages(ctx, pool, messageIDs[:1])
+		if err != nil {
+			t.Fatalf("GetCalendarEventsForMessages failed: %v", err)
+		}
+		if got := events[messageIDs[0]]; got.Method != "CANCEL" || got.Organizer != nil {
+			t.Errorf("Expected the canceled event without an organizer, got %+v", got)
+		}
+	})
+
+	t.Run("gets the event of one of the user's messages", func(t *testing.T) {
+		got, err := GetCalendarEvent(ctx, pool, userID, messageIDs[0])
+		if err != nil {
+			t.Fatalf("GetCalendarEvent failed: %v", err)
+		}
+		if got.UID != event.UID {
+			t.Errorf("Expected UID %s, got %s", event.UID, got.UID)
+		}
+
+		if _, err := GetCalendarEvent(ctx, pool, userID, messageIDs[1]); !errors.Is(err, ErrCalendarEventNotFound) {
+			t.Errorf("Expected ErrCalendarEventNotFound for a message without an invite, got %v", err)
+		}
+		otherUserID, err := GetOrCreateUser(ctx, pool, "calendar-events-other@example.com")
+		if err != nil {
+			t.Fatalf("GetOrCreateUser failed: %v", err)
+		}
+		if _, err := GetCalendarEvent(ctx, pool, otherUserID, messageIDs[0]); !errors.Is(err, ErrCalendarEventNotFound) {
+			t.Errorf("Expected ErrCalendarEventNotFound for another user, got %v", err)
+		}
+	})
+}
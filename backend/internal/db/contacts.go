@@ -0,0 +1,170 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// MaxContactsLimit is the most contacts SearchContacts returns.
+const MaxContactsLimit = 50
+
+// RebuildContacts replaces the user's contacts with the addresses in the From, To, and Cc headers of their cached
+// messages. ownAddresses are the user's email addresses besides their login email, which we always leave out.
+// Recipients of the messages that the user sent count toward sent_count, which ranks them higher.
+func RebuildContacts(ctx context.Context, pool *pgxpool.Pool, userID string, ownAddresses []string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if _, err := tx.Exec(ctx, `DELETE FROM contacts WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete contacts: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, contactsInsertSQL("TRUE"), userID, ownAddresses); err != nil {
+		return fmt.Errorf("failed to rebuild contacts: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit contacts: %w", err)
+	}
+	return nil
+}
+
+// AddContactsFromMessages adds the addresses of the messages with the given UIDs in the folder to the user's
+// contacts, like RebuildContacts would, without reading their other messages. The messages must be new, or they
+// count twice.
+func AddContactsFromMessages(ctx context.Context, pool *pgxpool.Pool, userID string, ownAddresses []string, folderName string, uids []uint32) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	uidValues := make([]int64, len(uids))
+	for i, uid := range uids {
+		uidValues[i] = int64(uid)
+	}
+
+	_, err := pool.Exec(ctx, contactsInsertSQL("m.imap_folder_name = $3 AND m.imap_uid = ANY($4::bigint[])")+`
+		ON CONFLICT (user_id, email) DO UPDATE SET
+			name = CASE
+				WHEN EXCLUDED.name <> '' AND (contacts.last_seen_at IS NULL OR EXCLUDED.last_seen_at >= contacts.last_seen_at)
+				THEN EXCLUDED.name
+				ELSE contacts.name
+			END,
+			message_count = contacts.message_count + EXCLUDED.message_count,
+			sent_count = contacts.sent_count + EXCLUDED.sent_count,
+			last_seen_at = GREATEST(contacts.last_seen_at, EXCLUDED.last_seen_at)
+	`, userID, ownAddresses, folderName, uidValues)
+	if err != nil {
+		return fmt.Errorf("failed to add contacts: %w", err)
+	}
+	return nil
+}
+
+// contactsInsertSQL returns an INSERT of the contacts in the user's messages that match messageFilter, a condition
+// on "m". The user ID is $1 and the own addresses are $2.
+func contactsInsertSQL(messageFilter string) string {
+	return fmt.Sprintf(`
+		WITH own AS (
+			SELECT coalesce(array_agg(DISTINCT lower(a)), '{}') AS addresses
+			FROM (SELECT unnest($2::text[]) AS a UNION SELECT email FROM users WHERE id = $1) addresses
+		),
+		appearances AS (
+			SELECT
+				m.sent_at,
+				%s AS email,
+				nullif(trim(BOTH ' "' FROM substring(a.raw FROM '^(.*)<')), '') AS name,
+				a.is_recipient AND %s = ANY(own.addresses) AS sent_to
+			FROM messages m
+			CROSS JOIN own
+			CROSS JOIN LATERAL (
+				SELECT m.from_address AS raw, false AS is_recipient
+				UNION ALL
+				SELECT unnest(m.to_addresses || m.cc_addresses), true
+			) a
+			WHERE m.user_id = $1 AND (%s) AND coalesce(a.raw, '') <> ''
+		)
+		INSERT INTO contacts (user_id, email, name, message_count, sent_count, last_seen_at)
+		SELECT
+			$1,
+			email,
+			coalesce((array_agg(name ORDER BY sent_at DESC NULLS LAST) FILTER (WHERE name IS NOT NULL))[1], ''),
+			COUNT(*),
+			COUNT(*) FILTER (WHERE sent_to),
+			MAX(sent_at)
+		FROM appearances, own
+		WHERE email LIKE '%%_@_%%' AND email <> ALL(own.addresses)
+		GROUP BY email`, fmt.Sprintf(bareAddressSQL, "a.raw"), fmt.Sprintf(bareAddressSQL, "m.from_address"), messageFilter)
+}
+
+// SearchContacts returns up to limit of the user's contacts whose address, name, or a word of their name starts
+// with query, or their top contacts if query is empty. It ranks contacts by how often the user has mail with them,
+// counting the messages the user sent them three times, and by how recently, so a contact's rank halves over each
+// month without mail.
+func SearchContacts(ctx context.Context, pool *pgxpool.Pool, userID, query string, limit int) ([]*models.Contact, error) {
+	prefix := likeEscaper.Replace(query) + "%"
+	rows, err := pool.Query(ctx, `
+		SELECT email, name, message_count, sent_count, last_seen_at
+		FROM contacts
+		WHERE user_id = $1
+			AND ($2 = '' OR email ILIKE $3 OR name ILIKE $3 OR name ILIKE '% ' || $3)
+		ORDER BY
+			(sent_count * 3 + message_count)
+				* power(0.5, extract(EPOCH FROM now() - coalesce(last_seen_at, now())) / (30 * 86400)) DESC,
+			email
+		LIMIT $4
+	`, userID, query, prefix, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search contacts: %w", err)
+	}
+	defer rows.Close()
+
+	contacts := []*models.Contact{}
+	for rows.Next() {
+		var contact models.Contact
+		if err := rows.Scan(&contact.Email, &contact.Name, &contact.MessageCount, &contact.SentCount, &contact.LastSeenAt); err != nil {
+			return nil, fmt.Errorf("failed to scan contact: %w", err)
+		}
+		contacts = append(contacts, &contact)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating contacts: %w", err)
+	}
+
+	return contacts, nil
+}
+
+// GetContacts returns all contacts of the user, sorted by address.
+func GetContacts(ctx context.Context, pool *pgxpool.Pool, userID string) ([]*models.Contact, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT email, name, message_count, sent_count, last_seen_at
+		FROM contacts
+		WHERE user_id = $1
+		ORDER BY email
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get contacts: %w", err)
+	}
+	defer rows.Close()
+
+	contacts := []*models.Contact{}
+	for rows.Next() {
+		var contact models.Contact
+		if err := rows.Scan(&contact.Email, &contact.Name, &contact.MessageCount, &contact.SentCount, &contact.LastSeenAt); err != nil {
+			return nil, fmt.Errorf("failed to scan contact: %w", err)
+		}
+		contacts = append(contacts, &contact)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating contacts: %w", err)
+	}
+
+	return contacts, nil
+}
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestContacts(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "me@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	thread := &models.Thread{UserID: userID, StableThreadID: "contacts-thread", Subject: "Hi"}
+	if err := SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+
+	now := time.Now()
+	uid := int64(0)
+	saveMessage := func(t *testing.T, folder, from string, to, cc []string, sentAt time.Time) {
+		t.Helper()
+		uid++
+		msg := &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         uid,
+			IMAPFolderName:  folder,
+			MessageIDHeader: "contacts-" + sentAt.String(),
+			FromAddress:     from,
+			ToAddresses:     to,
+			CCAddresses:     cc,
+			SentAt:          &sentAt,
+		}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+	}
+
+	// Bob writes a lot, but the user wrote to Alice, so Alice ranks higher
+	saveMessage(t, "INBOX", "Bob Builder <bob@example.com>", []string{"me@example.com"}, nil, now.Add(-3*time.Hour))
+	saveMessage(t, "INBOX", "bob@example.com", []string{"Me <me@example.com>"}, []string{"carol@example.com"}, now.Add(-2*time.Hour))
+	saveMessage(t, "Sent", "me@example.com", []string{`"Alice Smith" <Alice@Example.com>`}, nil, now.Add(-time.Hour))
+	saveMessage(t, "Sent", "me@example.com", []string{"alice@example.com"}, []string{"undisclosed-recipients:;"}, now)
+
+	if err := RebuildContacts(ctx, pool, userID, nil); err != nil {
+		t.Fatalf("RebuildContacts failed: %v", err)
+	}
+
+	t.Run("ranks contacts the user wrote to first", func(t *testing.T) {
+		contacts, err := SearchContacts(ctx, pool, userID, "", 10)
+		if err != nil {
+			t.Fatalf("SearchContacts failed: %v", err)
+		}
+		if len(contacts) != 3 {
+			t.Fatalf("Expected alice, bob, and carol, got %d contacts", len(contacts))
+		}
+		alice := contacts[0]
+		if alice.Email != "alice@example.com" || alice.Name != "Alice Smith" || alice.SentCount != 2 || alice.MessageCount != 2 {
+			t.Errorf("Unexpected first contact: %+v", alice)
+		}
+		if contacts[1].Email != "bob@example.com" || contacts[1].Name != "Bob Builder" {
+			t.Errorf("Expected bob second, got %+v", contacts[1])
+		}
+	})
+
+	t.Run("matches the start of the address or a word of the name", func(t *testing.T) {
+		for query, expected := range map[string]string{"car": "carol@example.com", "build": "bob@example.com", "ALI": "alice@example.com"} {
+			contacts, err := SearchContacts(ctx, pool, userID, query, 10)
+			if err != nil {
+				t.Fatalf("SearchContacts failed: %v", err)
+			}
+			if len(contacts) != 1 || contacts[0].Email != expected {
+				t.Errorf("Expected %q to find only %s, got %+v", query, expected, contacts)
+			}
+		}
+	})
+
+	t.Run("lists all contacts by address", func(t *testing.T) {
+		contacts, err := GetContacts(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetContacts failed: %v", err)
+		}
+		var emails []string
+		for _, contact := range contacts {
+			emails = append(emails, contact.Email)
+		}
+		if strings.Join(emails, ",") != "alice@example.com,bob@example.com,carol@example.com" {
+			t.Errorf("Expected alice, bob, and carol, got %v", emails)
+		}
+	})
+
+	t.Run("treats LIKE wildcards literally", func(t *testing.T) {
+		contacts, err := SearchContacts(ctx, pool, userID, "%", 10)
+		if err != nil {
+			t.Fatalf("SearchContacts failed: %v", err)
+		}
+		if len(contacts) != 0 {
+			t.Errorf("Expected no contacts, got %+v", contacts)
+		}
+	})
+
+	t.Run("adds the contacts of new messages like a rebuild", func(t *testing.T) {
+		saveMessage(t, "INBOX", "Alice A. <alice@example.com>", []string{"me@example.com"}, []string{"dave@example.com"}, now.Add(time.Hour))
+		saveMessage(t, "Sent", "me@example.com", []string{"Dave <dave@example.com>"}, nil, now.Add(2*time.Hour))
+
+		if err := AddContactsFromMessages(ctx, pool, userID, nil, "INBOX", []uint32{uint32(uid - 1)}); err != nil {
+			t.Fatalf("AddContactsFromMessages failed: %v", err)
+		}
+		if err := AddContactsFromMessages(ctx, pool, userID, nil, "Sent", []uint32{uint32(uid)}); err != nil {
+			t.Fatalf("AddContactsFromMessages failed: %v", err)
+		}
+		added, err := GetContacts(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetContacts failed: %v", err)
+		}
+
+		if err := RebuildContacts(ctx, pool, userID, nil); err != nil {
+			t.Fatalf("RebuildContacts failed: %v", err)
+		}
+		rebuilt, err := GetContacts(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetContacts failed: %v", err)
+		}
+
+		if len(added) != 4 || len(rebuilt) != 4 {
+			t.Fatalf("Expected alice, bob, carol, and dave, got %d added and %d rebuilt contacts", len(added), len(rebuilt))
+		}
+		for i, contact := range added {
+			expected := rebuilt[i]
+			if contact.Email != expected.Email || contact.Name != expected.Name ||
+				contact.MessageCount != expected.MessageCount || contact.SentCount != expected.SentCount ||
+				!contact.LastSeenAt.Equal(*expected.LastSeenAt) {
+				t.Errorf("Expected added contact %+v to match rebuilt %+v", contact, expected)
+			}
+		}
+		if added[0].Name != "Alice A." || added[0].MessageCount != 3 || added[3].Name != "Dave" || added[3].SentCount != 1 {
+			t.Errorf("Unexpected alice or dave: %+v, %+v", added[0], added[3])
+		}
+	})
+
+	t.Run("adds nothing without UIDs", func(t *testing.T) {
+		if err := AddContactsFromMessages(ctx, pool, userID, nil, "INBOX", nil); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+}
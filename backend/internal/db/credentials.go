@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// encryptedCredentialTables are the tables with encrypted credentials, with their key columns and the columns
+// of the credentials.
+var encryptedCredentialTables = []struct {
+	table   string
+	key     string
+	columns []string
+}{
+	{"user_settings", "user_id", []string{"encrypted_imap_password", "encrypted_smtp_password", "encrypted_oauth_refresh_token"}},
+	{"send_identities", "id", []string{"encrypted_smtp_password"}},
+}
+
+// ReencryptCredentials calls reencrypt for each stored credential of all users: IMAP and SMTP passwords,
+// OAuth refresh tokens, and the SMTP passwords of send identities. reencrypt returns the new ciphertext,
+// and whether it changed it. It's all one transaction, so a credential that fails to re-encrypt leaves
+// everything as it was. Returns how many credentials changed.
+func ReencryptCredentials(ctx context.Context, pool *pgxpool.Pool, reencrypt func([]byte) ([]byte, bool, error)) (int, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	changed := 0
+	for _, t := range encryptedCredentialTables {
+		count, err := reencryptTable(ctx, tx, t.table, t.key, t.columns, reencrypt)
+		if err != nil {
+			return 0, err
+		}
+		changed += count
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return changed, nil
+}
+
+// reencryptTable re-encrypts the credentials in the columns of all rows of the table, and locks the rows
+// until tx ends. Empty and NULL credentials stay as they are. Returns how many credentials changed.
+func reencryptTable(ctx context.Context, tx pgx.Tx, table, key string, columns []string, reencrypt func([]byte) ([]byte, bool, error)) (int, error) {
+	rows, err := tx.Query(ctx, fmt.Sprintf(`SELECT %s::text, %s FROM %s FOR UPDATE`, key, strings.Join(columns, ", "), table))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get credentials from %s: %w", table, err)
+	}
+
+	type credentialRow struct {
+		id     string
+		values [][]byte
+	}
+	var credentialRows []credentialRow
+	for rows.Next() {
+		row := credentialRow{values: make([][]byte, len(columns))}
+		dest := []any{&row.id}
+		for i := range row.values {
+			dest = append(dest, &row.values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan credentials from %s: %w", table, err)
+		}
+		credentialRows = append(credentialRows, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating credentials from %s: %w", table, err)
+	}
+
+	sets := make([]string, len(columns))
+	for i, column := range columns {
+		sets[i] = fmt.Sprintf("%s = $%d", column, i+2)
+	}
+	update := fmt.Sprintf(`UPDATE %s SET %s WHERE %s = $1`, table, strings.Join(sets, ", "), key)
+
+	changed := 0
+	for _, row := range credentialRows {
+		rowChanged := false
+		for i, value := range row.values {
+			if len(value) == 0 {
+				continue
+			}
+			newValue, valueChanged, err := reencrypt(value)
+			if err != nil {
+				return 0, fmt.Errorf("failed to re-encrypt %s of %s %s: %w", columns[i], table, row.id, err)
+			}
+			if valueChanged {
+				row.values[i] = newValue
+				rowChanged = true
+				changed++
+			}
+		}
+		if !rowChanged {
+			continue
+		}
+
+		args := []any{row.id}
+		for _, value := range row.values {
+			args = append(args, value)
+		}
+		if _, err := tx.Exec(ctx, update, args...); err != nil {
+			return 0, fmt.Errorf("failed to save re-encrypted credentials of %s %s: %w", table, row.id, err)
+		}
+	}
+	return changed, nil
+}
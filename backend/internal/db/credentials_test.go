@@ -0,0 +1,111 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestReencryptCredentials(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "rotate-keys@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	settings := &models.UserSettings{
+		UserID:                userID,
+		IMAPServerHostname:    "imap.example.com",
+		IMAPUsername:          "user@example.com",
+		EncryptedIMAPPassword: []byte("old:imap"),
+		SMTPServerHostname:    "smtp.example.com",
+		SMTPUsername:          "user@example.com",
+		EncryptedSMTPPassword: []byte("new:smtp"),
+	}
+	if err := SaveUserSettings(ctx, pool, settings); err != nil {
+		t.Fatalf("SaveUserSettings failed: %v", err)
+	}
+	identity := &models.SendIdentity{
+		Email:                 "support@example.com",
+		SMTPServerHostname:    "smtp.example.com:465",
+		SMTPUsername:          "support",
+		EncryptedSMTPPassword: []byte("old:identity"),
+	}
+	if err := CreateSendIdentity(ctx, pool, userID, identity); err != nil {
+		t.Fatalf("CreateSendIdentity failed: %v", err)
+	}
+
+	// A stand-in for the Encryptor: "old:" values are under the old key, and "new:" ones under the new one
+	rotate := func(ciphertext []byte) ([]byte, bool, error) {
+		if rest, ok := bytes.CutPrefix(ciphertext, []byte("old:")); ok {
+			return append([]byte("new:"), rest...), true, nil
+		}
+		return ciphertext, false, nil
+	}
+
+	t.Run("leaves everything as it was if a credential fails", func(t *testing.T) {
+		failing := func(ciphertext []byte) ([]byte, bool, error) {
+			if bytes.Equal(ciphertext, []byte("old:identity")) {
+				return nil, false, errors.New("unknown key")
+			}
+			return rotate(ciphertext)
+		}
+		if _, err := ReencryptCredentials(ctx, pool, failing); err == nil {
+			t.Fatal("Expected an error, got nil")
+		}
+
+		retrieved, err := GetUserSettings(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetUserSettings failed: %v", err)
+		}
+		if string(retrieved.EncryptedIMAPPassword) != "old:imap" {
+			t.Errorf("Expected the IMAP password to stay, got %q", retrieved.EncryptedIMAPPassword)
+		}
+	})
+
+	t.Run("re-encrypts the credentials under the old key", func(t *testing.T) {
+		changed, err := ReencryptCredentials(ctx, pool, rotate)
+		if err != nil {
+			t.Fatalf("ReencryptCredentials failed: %v", err)
+		}
+		if changed != 2 {
+			t.Errorf("Expected 2 changed credentials, got %d", changed)
+		}
+
+		retrieved, err := GetUserSettings(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetUserSettings failed: %v", err)
+		}
+		if string(retrieved.EncryptedIMAPPassword) != "new:imap" || string(retrieved.EncryptedSMTPPassword) != "new:smtp" {
+			t.Errorf("Unexpected passwords: %q and %q", retrieved.EncryptedIMAPPassword, retrieved.EncryptedSMTPPassword)
+		}
+		if len(retrieved.EncryptedOAuthRefreshToken) != 0 {
+			t.Errorf("Expected no OAuth refresh token, got %q", retrieved.EncryptedOAuthRefreshToken)
+		}
+
+		retrievedIdentity, err := GetSendIdentity(ctx, pool, userID, identity.ID)
+		if err != nil {
+			t.Fatalf("GetSendIdentity failed: %v", err)
+		}
+		if string(retrievedIdentity.EncryptedSMTPPassword) != "new:identity" {
+			t.Errorf("Expected the identity's password to be re-encrypted, got %q", retrievedIdentity.EncryptedSMTPPassword)
+		}
+	})
+
+	t.Run("does nothing the second time", func(t *testing.T) {
+		changed, err := ReencryptCredentials(ctx, pool, rotate)
+		if err != nil {
+			t.Fatalf("ReencryptCredentials failed: %v", err)
+		}
+		if changed != 0 {
+			t.Errorf("Expected no changed credentials, got %d", changed)
+		}
+	})
+}
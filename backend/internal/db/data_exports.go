@@ -0,0 +1,304 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+const (
+	// DataExportTTL is how long we keep a finished export for the user to download.
+	DataExportTTL = 7 * 24 * time.Hour
+
+	// DataExportStaleAfter is how long after it started we stop believing that an export is still being built.
+	// A server that crashed or restarted mid-export never marks the export finished.
+	DataExportStaleAfter = time.Hour
+
+	// DataExportCleanupInterval is how often RunDataExportCleaner deletes expired exports.
+	DataExportCleanupInterval = time.Hour
+)
+
+// ErrDataExportNotFound is returned when the user has no export, or none that's done.
+var ErrDataExportNotFound = errors.New("data export not found")
+
+// dataExportInterruptedError is the error of exports that stopped being built without finishing.
+const dataExportInterruptedError = "The export was interrupted. Start a new one."
+
+const dataExportColumns = `id, status, COALESCE(error, ''), COALESCE(size_bytes, 0), started_at, finished_at`
+
+// scanDataExport scans the dataExportColumns of the row, and then the extra columns into extra.
+func scanDataExport(row pgx.Row, extra ...any) (*models.DataExport, error) {
+	var export models.DataExport
+	dest := append([]any{&export.ID, &export.Status, &export.Error, &export.SizeBytes, &export.StartedAt, &export.FinishedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	switch {
+	case export.Status == models.DataExportStatusRunning && time.Since(export.StartedAt) > DataExportStaleAfter:
+		export.Status = models.DataExportStatusFailed
+		export.Error = dataExportInterruptedError
+	case export.Status == models.DataExportStatusDone && export.FinishedAt != nil:
+		expiresAt := export.FinishedAt.Add(DataExportTTL)
+		export.ExpiresAt = &expiresAt
+	}
+	return &export, nil
+}
+
+// StartDataExport starts a new export of the user's data, replacing the old one, unless one is still being built.
+// It returns the new export and true, or the one being built and false.
+func StartDataExport(ctx context.Context, pool *pgxpool.Pool, userID string) (*models.DataExport, bool, error) {
+	export, err := scanDataExport(pool.QueryRow(ctx, `
+		INSERT INTO data_exports (user_id)
+		VALUES ($1)
+		ON CONFLICT (user_id) DO UPDATE SET
+			id = gen_random_uuid(),
+			status = 'running',
+			error = NULL,
+			file_oid = NULL,
+			size_bytes = NULL,
+			started_at = now(),
+			finished_at = NULL
+		WHERE data_exports.status <> 'running' OR data_exports.started_at < now() - make_interval(secs => $2)
+		RETURNING `+dataExportColumns+`
+	`, userID, DataExportStaleAfter.Seconds()))
+	if err == nil {
+		return export, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, fmt.Errorf("failed to start data export: %w", err)
+	}
+
+	// Another export is being built
+	export, err = GetDataExport(ctx, pool, userID)
+	if err != nil {
+		return nil, false, err
+	}
+	return export, false, nil
+}
+
+// GetDataExport returns the state of the user's export, without the zip file.
+// Returns ErrDataExportNotFound if the user has none.
+func GetDataExport(ctx context.Context, pool *pgxpool.Pool, userID string) (*models.DataExport, error) {
+	export, err := scanDataExport(pool.QueryRow(ctx, `
+		SELECT `+dataExportColumns+`
+		FROM data_exports
+		WHERE user_id = $1
+	`, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrDataExportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data export: %w", err)
+	}
+	return export, nil
+}
+
+// DataExportFile reads the zip file of an export from its large object. Close it when done, which ends the
+// transaction that large objects need.
+type DataExportFile struct {
+	tx     pgx.Tx
+	object *pgx.LargeObject
+}
+
+// Read reads the next bytes of the zip file.
+func (f *DataExportFile) Read(p []byte) (int, error) {
+	return f.object.Read(p)
+}
+
+// Close closes the large object and ends its transaction.
+func (f *DataExportFile) Close() error {
+	ctx := context.Background()
+	err := f.object.Close()
+	if rollbackErr := f.tx.Rollback(ctx); rollbackErr != nil && err == nil {
+		err = rollbackErr
+	}
+	return err
+}
+
+// OpenDataExportFile returns the user's export, and opens its zip file for reading. The caller must close the file.
+// Returns ErrDataExportNotFound if the user has no export that's done.
+func OpenDataExportFile(ctx context.Context, pool *pgxpool.Pool, userID string) (*models.DataExport, *DataExportFile, error) {
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	var oid uint32
+	export, err := scanDataExport(tx.QueryRow(ctx, `
+		SELECT `+dataExportColumns+`, file_oid
+		FROM data_exports
+		WHERE user_id = $1 AND status = 'done' AND file_oid IS NOT NULL
+	`, userID), &oid)
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, ErrDataExportNotFound
+		}
+		return nil, nil, fmt.Errorf("failed to get data export file: %w", err)
+	}
+
+	largeObjects := tx.LargeObjects()
+	object, err := largeObjects.Open(ctx, oid, pgx.LargeObjectModeRead)
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		return nil, nil, fmt.Errorf("failed to open data export file: %w", err)
+	}
+	return export, &DataExportFile{tx: tx, object: object}, nil
+}
+
+// FinishDataExport saves the zip file of the export, which is sizeBytes long, to a large object, and marks the export
+// done. It does nothing if a newer export replaced the export in the meantime.
+func FinishDataExport(ctx context.Context, pool *pgxpool.Pool, userID, exportID string, file io.Reader, sizeBytes int64) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	largeObjects := tx.LargeObjects()
+	oid, err := largeObjects.Create(ctx, 0)
+	if err != nil {
+		return fmt.Errorf("failed to create data export file: %w", err)
+	}
+	object, err := largeObjects.Open(ctx, oid, pgx.LargeObjectModeWrite)
+	if err != nil {
+		return fmt.Errorf("failed to open data export file: %w", err)
+	}
+	if _, err := io.Copy(object, file); err != nil {
+		return fmt.Errorf("failed to write data export file: %w", err)
+	}
+	if err := object.Close(); err != nil {
+		return fmt.Errorf("failed to close data export file: %w", err)
+	}
+
+	result, err := tx.Exec(ctx, `
+		UPDATE data_exports SET
+			status = 'done',
+			file_oid = $3,
+			size_bytes = $4,
+			finished_at = now()
+		WHERE user_id = $1 AND id = $2 AND status = 'running'
+	`, userID, exportID, oid, sizeBytes)
+	if err != nil {
+		return fmt.Errorf("failed to finish data export: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		// A newer export replaced this one. Rolling back deletes the large object.
+		return nil
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// FailDataExport marks the export failed, with why.
+// It does nothing if a newer export replaced the export in the meantime.
+func FailDataExport(ctx context.Context, pool *pgxpool.Pool, userID, exportID, exportErr string) error {
+	_, err := pool.Exec(ctx, `
+		UPDATE data_exports SET
+			status = 'failed',
+			error = $3,
+			finished_at = now()
+		WHERE user_id = $1 AND id = $2 AND status = 'running'
+	`, userID, exportID, exportErr)
+	if err != nil {
+		return fmt.Errorf("failed to mark data export failed: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredDataExports deletes the exports that finished before the given time. Returns how many it deleted.
+func DeleteExpiredDataExports(ctx context.Context, pool *pgxpool.Pool, before time.Time) (int, error) {
+	result, err := pool.Exec(ctx, `DELETE FROM data_exports WHERE finished_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired data exports: %w", err)
+	}
+	return int(result.RowsAffected()), nil
+}
+
+// RunDataExportCleaner deletes expired exports every interval until the context is canceled.
+// It blocks, so call it in a goroutine.
+func RunDataExportCleaner(ctx context.Context, pool *pgxpool.Pool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := DeleteExpiredDataExports(ctx, pool, time.Now().Add(-DataExportTTL))
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to delete expired data exports", "error", err)
+			} else if deleted > 0 {
+				slog.InfoContext(ctx, "Deleted expired data exports", "count", deleted)
+			}
+		}
+	}
+}
+
+// ExportMessages calls fn with each cached message of the user, sorted by folder, then by date, with their labels
+// and references. It stops at the first error of fn, and returns it.
+func ExportMessages(ctx context.Context, pool *pgxpool.Pool, userID string, fn func(*models.Message) error) error {
+	rows, err := pool.Query(ctx, `
+		SELECT`+threadMessageColumns+`,
+			referenced_message_ids,
+			ARRAY(SELECT label FROM message_labels WHERE message_id = messages.id ORDER BY label)
+		FROM messages
+		WHERE user_id = $1
+		ORDER BY imap_folder_name, sent_at NULLS FIRST, imap_uid
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msg models.Message
+		var plainHTML *string
+		var compressedHTML []byte
+		if err := rows.Scan(
+			&msg.ID,
+			&msg.ThreadID,
+			&msg.UserID,
+			&msg.IMAPUID,
+			&msg.IMAPFolderName,
+			&msg.MessageIDHeader,
+			&msg.FromAddress,
+			&msg.ToAddresses,
+			&msg.CCAddresses,
+			&msg.SentAt,
+			&msg.Subject,
+			&plainHTML,
+			&compressedHTML,
+			&msg.BodyText,
+			&msg.IsRead,
+			&msg.IsStarred,
+			&msg.Truncated,
+			&msg.ReferencedMessageIDs,
+			&msg.Labels,
+		); err != nil {
+			return fmt.Errorf("failed to scan message: %w", err)
+		}
+		if msg.UnsafeBodyHTML, err = joinBodyHTML(plainHTML, compressedHTML); err != nil {
+			return err
+		}
+		if err := fn(&msg); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating messages: %w", err)
+	}
+	return nil
+}
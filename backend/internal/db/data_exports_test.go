@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestDataExports(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	userID, err := GetOrCreateUser(ctx, pool, "data-exports-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	t.Run("returns ErrDataExportNotFound before the first export", func(t *testing.T) {
+		if _, err := GetDataExport(ctx, pool, userID); !errors.Is(err, ErrDataExportNotFound) {
+			t.Errorf("Expected ErrDataExportNotFound, got %v", err)
+		}
+	})
+
+	var first *models.DataExport
+	t.Run("starts one export at a time", func(t *testing.T) {
+		var started bool
+		first, started, err = StartDataExport(ctx, pool, userID)
+		if err != nil || !started || first.Status != models.DataExportStatusRunning {
+			t.Fatalf("Expected a new running export, got %+v, %v, %v", first, started, err)
+		}
+
+		again, started, err := StartDataExport(ctx, pool, userID)
+		if err != nil || started || again.ID != first.ID {
+			t.Errorf("Expected the running export, got %+v, %v, %v", again, started, err)
+		}
+		if _, _, err := OpenDataExportFile(ctx, pool, userID); !errors.Is(err, ErrDataExportNotFound) {
+			t.Errorf("Expected no file while running, got %v", err)
+		}
+	})
+
+	t.Run("saves the file of a finished export", func(t *testing.T) {
+		if err := FinishDataExport(ctx, pool, userID, first.ID, strings.NewReader("zip"), 3); err != nil {
+			t.Fatalf("FinishDataExport failed: %v", err)
+		}
+
+		dataExport, file, err := OpenDataExportFile(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("OpenDataExportFile failed: %v", err)
+		}
+		data, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatalf("Failed to read data export file: %v", err)
+		}
+		if err := file.Close(); err != nil {
+			t.Errorf("Failed to close data export file: %v", err)
+		}
+		if string(data) != "zip" || dataExport.SizeBytes != 3 || dataExport.Status != models.DataExportStatusDone ||
+			dataExport.ExpiresAt == nil {
+			t.Errorf("Expected the done export with its file, got %+v and %q", dataExport, data)
+		}
+	})
+
+	t.Run("replaces the old export, and ignores results of replaced ones", func(t *testing.T) {
+		second, started, err := StartDataExport(ctx, pool, userID)
+		if err != nil || !started || second.ID == first.ID {
+			t.Fatalf("Expected a new export, got %+v, %v, %v", second, started, err)
+		}
+		if err := FailDataExport(ctx, pool, userID, first.ID, "late"); err != nil {
+			t.Fatalf("FailDataExport failed: %v", err)
+		}
+		if err := FinishDataExport(ctx, pool, userID, first.ID, strings.NewReader("late"), 4); err != nil {
+			t.Fatalf("FinishDataExport failed: %v", err)
+		}
+		if err := FailDataExport(ctx, pool, userID, second.ID, "Building the export failed."); err != nil {
+			t.Fatalf("FailDataExport failed: %v", err)
+		}
+
+		dataExport, err := GetDataExport(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetDataExport failed: %v", err)
+		}
+		if dataExport.ID != second.ID || dataExport.Status != models.DataExportStatusFailed ||
+			dataExport.Error != "Building the export failed." {
+			t.Errorf("Expected the second export to have failed, got %+v", dataExport)
+		}
+	})
+
+	t.Run("reports exports that stopped being built as failed", func(t *testing.T) {
+		if _, _, err := StartDataExport(ctx, pool, userID); err != nil {
+			t.Fatalf("StartDataExport failed: %v", err)
+		}
+		if _, err := pool.Exec(ctx, `UPDATE data_exports SET started_at = now() - interval '2 hours' WHERE user_id = $1`, userID); err != nil {
+			t.Fatalf("Failed to age the export: %v", err)
+		}
+
+		dataExport, err := GetDataExport(ctx, pool, userID)
+		if err != nil || dataExport.Status != models.DataExportStatusFailed {
+			t.Errorf("Expected a stale export to count as failed, got %+v, %v", dataExport, err)
+		}
+		if _, started, err := StartDataExport(ctx, pool, userID); err != nil || !started {
+			t.Errorf("Expected a stale export to be replaced, got %v, %v", started, err)
+		}
+	})
+
+	t.Run("exports messages with their labels", func(t *testing.T) {
+		thread := &models.Thread{UserID: userID, StableThreadID: "export-thread", Subject: "Hello"}
+		if err := SaveThread(ctx, pool, thread); err != nil {
+			t.Fatalf("SaveThread failed: %v", err)
+		}
+		now := time.Now()
+		msg := &models.Message{
+			ThreadID:             thread.ID,
+			UserID:               userID,
+			IMAPUID:              1,
+			IMAPFolderName:       "INBOX",
+			MessageIDHeader:      "<export@example.com>",
+			Subject:              "Hello",
+			SentAt:               &now,
+			UnsafeBodyHTML:       "<p>Hello</p>",
+			ReferencedMessageIDs: []string{"<parent@example.com>"},
+		}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+		if err := AddMessageLabel(ctx, pool, []string{msg.ID}, "Work", true); err != nil {
+			t.Fatalf("AddMessageLabel failed: %v", err)
+		}
+
+		var exported []*models.Message
+		if err := ExportMessages(ctx, pool, userID, func(m *models.Message) error {
+			exported = append(exported, m)
+			return nil
+		}); err != nil {
+			t.Fatalf("ExportMessages failed: %v", err)
+		}
+		if len(exported) != 1 || exported[0].UnsafeBodyHTML != "<p>Hello</p>" || len(exported[0].Labels) != 1 ||
+			len(exported[0].ReferencedMessageIDs) != 1 {
+			t.Errorf("Expected the message with its body, labels, and references, got %+v", exported)
+		}
+	})
+
+	t.Run("deletes expired exports", func(t *testing.T) {
+		dataExport, err := GetDataExport(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetDataExport failed: %v", err)
+		}
+		if err := FinishDataExport(ctx, pool, userID, dataExport.ID, strings.NewReader("zip"), 3); err != nil {
+			t.Fatalf("FinishDataExport failed: %v", err)
+		}
+		var oid uint32
+		if err := pool.QueryRow(ctx, `SELECT file_oid FROM data_exports WHERE user_id = $1`, userID).Scan(&oid); err != nil {
+			t.Fatalf("Failed to get the file of the export: %v", err)
+		}
+
+		if _, err := DeleteExpiredDataExports(ctx, pool, time.Now().Add(time.Minute)); err != nil {
+			t.Fatalf("DeleteExpiredDataExports failed: %v", err)
+		}
+		if _, err := GetDataExport(ctx, pool, userID); !errors.Is(err, ErrDataExportNotFound) {
+			t.Errorf("Expected ErrDataExportNotFound, got %v", err)
+		}
+		var fileExists bool
+		if err := pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_largeobject_metadata WHERE oid = $1)`, oid).Scan(&fileExists); err != nil {
+			t.Fatalf("Failed to look up the file of the export: %v", err)
+		}
+		if fileExists {
+			t.Error("Expected the file of the deleted export to be deleted")
+		}
+	})
+}
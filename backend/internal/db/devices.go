@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// ErrDeviceNotFound is returned when a device doesn't exist or belongs to another user.
+var ErrDeviceNotFound = errors.New("device not found")
+
+// ErrDeviceExists is returned when another device of the user already has the same push endpoint.
+var ErrDeviceExists = errors.New("another device has the same push endpoint")
+
+const deviceColumns = `
+	id, user_id, name, platform, COALESCE(push_endpoint, ''), push_p256dh, push_auth,
+	notifications_enabled, important_only, last_seen_at, created_at, updated_at
+`
+
+// GetDevices returns all devices of the user, the most recently seen first.
+func GetDevices(ctx context.Context, pool *pgxpool.Pool, userID string) ([]*models.Device, error) {
+	return queryDevices(ctx, pool, `
+		SELECT `+deviceColumns+`
+		FROM devices
+		WHERE user_id = $1
+		ORDER BY last_seen_at DESC, created_at DESC
+	`, userID)
+}
+
+// GetNotificationDevices returns the devices of the user that should get a push notification about a thread.
+// Devices that only want important threads are skipped unless important is true.
+func GetNotificationDevices(ctx context.Context, pool *pgxpool.Pool, userID string, important bool) ([]*models.Device, error) {
+	return queryDevices(ctx, pool, `
+		SELECT `+deviceColumns+`
+		FROM devices
+		WHERE user_id = $1
+			AND push_endpoint IS NOT NULL
+			AND notifications_enabled
+			AND (NOT important_only OR $2)
+		ORDER BY created_at
+	`, userID, important)
+}
+
+// GetDevice returns a device of the user. Returns ErrDeviceNotFound if there's no such device.
+func GetDevice(ctx context.Context, pool *pgxpool.Pool, userID, deviceID string) (*models.Device, error) {
+	devices, err := queryDevices(ctx, pool, `
+		SELECT `+deviceColumns+`
+		FROM devices
+		WHERE id = $1 AND user_id = $2
+	`, deviceID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, ErrDeviceNotFound
+	}
+	return devices[0], nil
+}
+
+// RegisterDevice saves a new device, and sets its ID and timestamps.
+// If another device of the user has the same push endpoint, for example, because the app registered again
+// after a reinstall, it updates that one instead. Returns true if it created a new device.
+func RegisterDevice(ctx context.Context, pool *pgxpool.Pool, userID string, device *models.Device) (bool, error) {
+	var created bool
+	err := pool.QueryRow(ctx, `
+		INSERT INTO devices (user_id, name, platform, push_endpoint, push_p256dh, push_auth, notifications_enabled, important_only)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8)
+		ON CONFLICT (user_id, push_endpoint) DO UPDATE SET
+			name = EXCLUDED.name,
+			platform = EXCLUDED.platform,
+			push_p256dh = EXCLUDED.push_p256dh,
+			push_auth = EXCLUDED.push_auth,
+			notifications_enabled = EXCLUDED.notifications_enabled,
+			important_only = EXCLUDED.important_only,
+			last_seen_at = NOW(),
+			updated_at = NOW()
+		RETURNING id, last_seen_at, created_at, updated_at, (xmax = 0)
+	`, userID, device.Name, device.Platform, device.PushEndpoint, device.PushP256dh, device.PushAuth,
+		device.NotificationsEnabled, device.ImportantOnly).Scan(&device.ID, &device.LastSeenAt, &device.CreatedAt, &device.UpdatedAt, &created)
+	if err != nil {
+		return false, fmt.Errorf("failed to register device: %w", err)
+	}
+	device.UserID = userID
+	device.HasPush = device.PushEndpoint != ""
+	return created, nil
+}
+
+// UpdateDevice saves the name, push endpoint and keys, and notification preferences of a device of the user,
+// and sets its timestamps. Returns ErrDeviceNotFound if there's no such device,
+// and ErrDeviceExists if another device of the user has the new push endpoint.
+func UpdateDevice(ctx context.Context, pool *pgxpool.Pool, userID string, device *models.Device) error {
+	err := pool.QueryRow(ctx, `
+		UPDATE devices SET
+			name = $3,
+			push_endpoint = NULLIF($4, ''),
+			push_p256dh = $5,
+			push_auth = $6,
+			notifications_enabled = $7,
+			important_only = $8,
+			last_seen_at = NOW(),
+			updated_at = NOW()
+		WHERE id = $1 AND user_id = $2
+		RETURNING last_seen_at, created_at, updated_at
+	`, device.ID, userID, device.Name, device.PushEndpoint, device.PushP256dh, device.PushAuth,
+		device.NotificationsEnabled, device.ImportantOnly).Scan(&device.LastSeenAt, &device.CreatedAt, &device.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrDeviceNotFound
+	}
+	if isUniqueViolation(err) {
+		return ErrDeviceExists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update device: %w", err)
+	}
+	device.UserID = userID
+	device.HasPush = device.PushEndpoint != ""
+	return nil
+}
+
+// ClearDevicePush removes the push endpoint and keys of a device, for example, because the push service said the
+// subscription is gone. The device stays in the list, so the user sees that it doesn't get notifications anymore.
+func ClearDevicePush(ctx context.Context, pool *pgxpool.Pool, deviceID string) error {
+	_, err := pool.Exec(ctx, `
+		UPDATE devices SET push_endpoint = NULL, push_p256dh = NULL, push_auth = NULL, updated_at = NOW()
+		WHERE id = $1
+	`, deviceID)
+	if err != nil {
+		return fmt.Errorf("failed to clear device push: %w", err)
+	}
+	return nil
+}
+
+// DeleteDevice revokes a device of the user, so it gets no more notifications.
+// Returns ErrDeviceNotFound if there's no such device.
+func DeleteDevice(ctx context.Context, pool *pgxpool.Pool, userID, deviceID string) error {
+	result, err := pool.Exec(ctx, `
+		DELETE FROM devices
+		WHERE id = $1 AND user_id = $2
+	`, deviceID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete device: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrDeviceNotFound
+	}
+	return nil
+}
+
+// queryDevices runs a query that selects deviceColumns, and scans the devices.
+func queryDevices(ctx context.Context, pool *pgxpool.Pool, query string, args ...any) ([]*models.Device, error) {
+	rows, err := pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get devices: %w", err)
+	}
+	defer rows.Close()
+
+	devices := []*models.Device{}
+	for rows.Next() {
+		var device models.Device
+		if err := rows.Scan(&device.ID, &device.UserID, &device.Name, &device.Platform, &device.PushEndpoint,
+			&device.PushP256dh, &device.PushAuth, &device.NotificationsEnabled, &device.ImportantOnly,
+			&device.LastSeenAt, &device.CreatedAt, &device.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan device: %w", err)
+		}
+		device.HasPush = device.PushEndpoint != ""
+		devices = append(devices, &device)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating devices: %w", err)
+	}
+
+	return devices, nil
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestDevices(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "devices-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	otherUserID, err := GetOrCreateUser(ctx, pool, "devices-other@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	var phone *models.Device
+
+	t.Run("registers a device", func(t *testing.T) {
+		phone = &models.Device{Name: "Phone", Platform: models.DevicePlatformAndroid, PushEndpoint: "token-1", NotificationsEnabled: true}
+		created, err := RegisterDevice(ctx, pool, userID, phone)
+		if err != nil {
+			t.Fatalf("RegisterDevice failed: %v", err)
+		}
+		if !created || phone.ID == "" || !phone.HasPush {
+			t.Errorf("Expected a new device with push, got created=%v, %+v", created, phone)
+		}
+	})
+
+	t.Run("updates the device with the same push endpoint", func(t *testing.T) {
+		again := &models.Device{Name: "Phone again", Platform: models.DevicePlatformAndroid, PushEndpoint: "token-1", NotificationsEnabled: true}
+		created, err := RegisterDevice(ctx, pool, userID, again)
+		if err != nil {
+			t.Fatalf("RegisterDevice failed: %v", err)
+		}
+		if created || again.ID != phone.ID {
+			t.Errorf("Expected device %s to be updated, got created=%v, ID %s", phone.ID, created, again.ID)
+		}
+	})
+
+	t.Run("registers devices without push separately", func(t *testing.T) {
+		for _, name := range []string{"Laptop", "Tablet"} {
+			device := &models.Device{Name: name, Platform: models.DevicePlatformWeb}
+			if created, err := RegisterDevice(ctx, pool, userID, device); err != nil || !created {
+				t.Fatalf("RegisterDevice failed: created=%v, %v", created, err)
+			}
+		}
+		devices, err := GetDevices(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetDevices failed: %v", err)
+		}
+		if len(devices) != 3 {
+			t.Errorf("Expected 3 devices, got %d", len(devices))
+		}
+	})
+
+	t.Run("targets devices by their notification preferences", func(t *testing.T) {
+		important := &models.Device{Name: "Watch", Platform: models.DevicePlatformIOS, PushEndpoint: "token-2", NotificationsEnabled: true, ImportantOnly: true}
+		muted := &models.Device{Name: "Old phone", Platform: models.DevicePlatformIOS, PushEndpoint: "token-3"}
+		for _, device := range []*models.Device{important, muted} {
+			if _, err := RegisterDevice(ctx, pool, userID, device); err != nil {
+				t.Fatalf("RegisterDevice failed: %v", err)
+			}
+		}
+
+		devices, err := GetNotificationDevices(ctx, pool, userID, false)
+		if err != nil {
+			t.Fatalf("GetNotificationDevices failed: %v", err)
+		}
+		if len(devices) != 1 || devices[0].ID != phone.ID {
+			t.Errorf("Expected only the phone for other threads, got %+v", devices)
+		}
+
+		devices, err = GetNotificationDevices(ctx, pool, userID, true)
+		if err != nil {
+			t.Fatalf("GetNotificationDevices failed: %v", err)
+		}
+		if len(devices) != 2 {
+			t.Errorf("Expected the phone and the watch for important threads, got %+v", devices)
+		}
+	})
+
+	t.Run("rejects a push endpoint of another device", func(t *testing.T) {
+		phone.PushEndpoint = "token-2"
+		if err := UpdateDevice(ctx, pool, userID, phone); !errors.Is(err, ErrDeviceExists) {
+			t.Errorf("Expected ErrDeviceExists, got %v", err)
+		}
+	})
+
+	t.Run("doesn't touch devices of other users", func(t *testing.T) {
+		if _, err := GetDevice(ctx, pool, otherUserID, phone.ID); !errors.Is(err, ErrDeviceNotFound) {
+			t.Errorf("Expected ErrDeviceNotFound, got %v", err)
+		}
+		if err := DeleteDevice(ctx, pool, otherUserID, phone.ID); !errors.Is(err, ErrDeviceNotFound) {
+			t.Errorf("Expected ErrDeviceNotFound, got %v", err)
+		}
+	})
+
+	t.Run("clears the push of a device", func(t *testing.T) {
+		if err := ClearDevicePush(ctx, pool, phone.ID); err != nil {
+			t.Fatalf("ClearDevicePush failed: %v", err)
+		}
+		device, err := GetDevice(ctx, pool, userID, phone.ID)
+		if err != nil {
+			t.Fatalf("GetDevice failed: %v", err)
+		}
+		if device.HasPush || device.PushEndpoint != "" {
+			t.Errorf("Expected no push, got %+v", device)
+		}
+	})
+
+	t.Run("deletes a device", func(t *testing.T) {
+		if err := DeleteDevice(ctx, pool, userID, phone.ID); err != nil {
+			t.Fatalf("DeleteDevice failed: %v", err)
+		}
+		if _, err := GetDevice(ctx, pool, userID, phone.ID); !errors.Is(err, ErrDeviceNotFound) {
+			t.Errorf("Expected ErrDeviceNotFound, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,197 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// ErrDraftNotFound is returned when a draft doesn't exist or belongs to another user.
+var ErrDraftNotFound = errors.New("draft not found")
+
+// draftColumns are the columns that scanDraft reads, in order.
+// The original drafts columns are nullable, so we read NULLs as empty values.
+const draftColumns = `
+	id,
+	COALESCE(in_reply_to_message_id, ''),
+	COALESCE(to_addresses, '{}'),
+	COALESCE(cc_addresses, '{}'),
+	COALESCE(bcc_addresses, '{}'),
+	COALESCE(subject, ''),
+	COALESCE(body_html, ''),
+	body_text,
+	attachment_upload_ids::text[],
+	COALESCE(message_id_header, ''),
+	created_at,
+	last_saved_at
+`
+
+func scanDraft(row pgx.Row) (*models.Draft, error) {
+	var draft models.Draft
+	err := row.Scan(
+		&draft.ID,
+		&draft.InReplyToMessageID,
+		&draft.To,
+		&draft.Cc,
+		&draft.Bcc,
+		&draft.Subject,
+		&draft.BodyHTML,
+		&draft.BodyText,
+		&draft.AttachmentUploadIDs,
+		&draft.MessageIDHeader,
+		&draft.CreatedAt,
+		&draft.LastSavedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &draft, nil
+}
+
+// CreateDraft saves a new draft, and sets its ID and timestamps.
+func CreateDraft(ctx context.Context, pool *pgxpool.Pool, userID string, draft *models.Draft) error {
+	err := pool.QueryRow(ctx, `
+		INSERT INTO drafts (
+			user_id, in_reply_to_message_id, to_addresses, cc_addresses, bcc_addresses,
+			subject, body_html, body_text, message_id_header, attachment_upload_ids
+		) VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9, COALESCE($10::uuid[], '{}'))
+		RETURNING id, created_at, last_saved_at
+	`, userID, draft.InReplyToMessageID, draft.To, draft.Cc, draft.Bcc,
+		draft.Subject, draft.BodyHTML, draft.BodyText, draft.MessageIDHeader, draft.AttachmentUploadIDs,
+	).Scan(&draft.ID, &draft.CreatedAt, &draft.LastSavedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create draft: %w", err)
+	}
+	return nil
+}
+
+// UpdateDraft replaces the content of an existing draft of the user, and sets its timestamps.
+// The Message-ID stays the same. Returns ErrDraftNotFound if there's no such draft.
+func UpdateDraft(ctx context.Context, pool *pgxpool.Pool, userID string, draft *models.Draft) error {
+	err := pool.QueryRow(ctx, `
+		UPDATE drafts SET
+			in_reply_to_message_id = NULLIF($3, ''),
+			to_addresses = $4,
+			cc_addresses = $5,
+			bcc_addresses = $6,
+			subject = $7,
+			body_html = $8,
+			body_text = $9,
+			attachment_upload_ids = COALESCE($10::uuid[], '{}'),
+			last_saved_at = NOW()
+		WHERE id = $1 AND user_id = $2
+		RETURNING COALESCE(message_id_header, ''), created_at, last_saved_at
+	`, draft.ID, userID, draft.InReplyToMessageID, draft.To, draft.Cc, draft.Bcc,
+		draft.Subject, draft.BodyHTML, draft.BodyText, draft.AttachmentUploadIDs,
+	).Scan(&draft.MessageIDHeader, &draft.CreatedAt, &draft.LastSavedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrDraftNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update draft: %w", err)
+	}
+	return nil
+}
+
+// GetDraft returns a draft of the user. Returns ErrDraftNotFound if there's no such draft.
+func GetDraft(ctx context.Context, pool *pgxpool.Pool, userID, draftID string) (*models.Draft, error) {
+	draft, err := scanDraft(pool.QueryRow(ctx, `
+		SELECT `+draftColumns+`
+		FROM drafts
+		WHERE id = $1 AND user_id = $2
+	`, draftID, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrDraftNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get draft: %w", err)
+	}
+	return draft, nil
+}
+
+// GetDrafts returns all drafts of the user, most recently saved first.
+func GetDrafts(ctx context.Context, pool *pgxpool.Pool, userID string) ([]*models.Draft, error) {
+	return queryDrafts(ctx, pool, `
+		SELECT `+draftColumns+`
+		FROM drafts
+		WHERE user_id = $1
+		ORDER BY last_saved_at DESC
+	`, userID)
+}
+
+// GetDraftsInReplyTo returns the user's drafts that reply to any of the given Message-IDs, oldest first.
+// The thread view uses it to show drafts under the messages they reply to.
+func GetDraftsInReplyTo(ctx context.Context, pool *pgxpool.Pool, userID string, messageIDs []string) ([]*models.Draft, error) {
+	if len(messageIDs) == 0 {
+		return []*models.Draft{}, nil
+	}
+	return queryDrafts(ctx, pool, `
+		SELECT `+draftColumns+`
+		FROM drafts
+		WHERE user_id = $1 AND in_reply_to_message_id = ANY($2)
+		ORDER BY created_at
+	`, userID, messageIDs)
+}
+
+func queryDrafts(ctx context.Context, pool *pgxpool.Pool, query string, args ...any) ([]*models.Draft, error) {
+	rows, err := pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get drafts: %w", err)
+	}
+	defer rows.Close()
+
+	drafts := make([]*models.Draft, 0)
+	for rows.Next() {
+		draft, err := scanDraft(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan draft: %w", err)
+		}
+		drafts = append(drafts, draft)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating drafts: %w", err)
+	}
+	return drafts, nil
+}
+
+// DeleteDraft deletes a draft of the user, and returns it, so that the caller can clean up the IMAP copy.
+// It also deletes the draft's attachment uploads that no other draft references, so they free up the user's quota.
+// Returns ErrDraftNotFound if there's no such draft.
+func DeleteDraft(ctx context.Context, pool *pgxpool.Pool, userID, draftID string) (*models.Draft, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	draft, err := scanDraft(tx.QueryRow(ctx, `
+		DELETE FROM drafts
+		WHERE id = $1 AND user_id = $2
+		RETURNING `+draftColumns, draftID, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrDraftNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete draft: %w", err)
+	}
+
+	if len(draft.AttachmentUploadIDs) > 0 {
+		_, err = tx.Exec(ctx, `
+			DELETE FROM attachment_uploads a
+			WHERE a.user_id = $1 AND a.id = ANY($2::uuid[])
+			  AND NOT EXISTS (SELECT 1 FROM drafts d WHERE d.user_id = a.user_id AND a.id = ANY (d.attachment_upload_ids))
+		`, userID, draft.AttachmentUploadIDs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete draft attachment uploads: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return draft, nil
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestDrafts(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "drafts-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	otherUserID, err := GetOrCreateUser(ctx, pool, "drafts-other@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	draft := &models.Draft{
+		InReplyToMessageID: "<original@example.com>",
+		To:                 []string{"alice@example.com"},
+		Subject:            "Re: Plans",
+		BodyText:           "Sounds good",
+		MessageIDHeader:    "<draft@example.com>",
+	}
+
+	t.Run("creates and reads a draft", func(t *testing.T) {
+		if err := CreateDraft(ctx, pool, userID, draft); err != nil {
+			t.Fatalf("CreateDraft failed: %v", err)
+		}
+		if draft.ID == "" || draft.LastSavedAt.IsZero() {
+			t.Fatalf("Expected the ID and timestamps to be set, got %+v", draft)
+		}
+
+		saved, err := GetDraft(ctx, pool, userID, draft.ID)
+		if err != nil {
+			t.Fatalf("GetDraft failed: %v", err)
+		}
+		if saved.Subject != draft.Subject || saved.BodyText != draft.BodyText || len(saved.Cc) != 0 {
+			t.Errorf("Expected %+v, got %+v", draft, saved)
+		}
+
+		if _, err := GetDraft(ctx, pool, otherUserID, draft.ID); !errors.Is(err, ErrDraftNotFound) {
+			t.Errorf("Expected ErrDraftNotFound for another user, got %v", err)
+		}
+	})
+
+	t.Run("updates a draft and keeps its Message-ID", func(t *testing.T) {
+		update := &models.Draft{ID: draft.ID, InReplyToMessageID: draft.InReplyToMessageID, Subject: "Re: New plans"}
+		if err := UpdateDraft(ctx, pool, userID, update); err != nil {
+			t.Fatalf("UpdateDraft failed: %v", err)
+		}
+		if update.MessageIDHeader != draft.MessageIDHeader {
+			t.Errorf("Expected Message-ID %s, got %s", draft.MessageIDHeader, update.MessageIDHeader)
+		}
+
+		otherUpdate := &models.Draft{ID: draft.ID}
+		if err := UpdateDraft(ctx, pool, otherUserID, otherUpdate); !errors.Is(err, ErrDraftNotFound) {
+			t.Errorf("Expected ErrDraftNotFound for another user, got %v", err)
+		}
+	})
+
+	t.Run("finds drafts by the message they reply to", func(t *testing.T) {
+		drafts, err := GetDraftsInReplyTo(ctx, pool, userID, []string{"<original@example.com>", "<other@example.com>"})
+		if err != nil {
+			t.Fatalf("GetDraftsInReplyTo failed: %v", err)
+		}
+		if len(drafts) != 1 || drafts[0].Subject != "Re: New plans" {
+			t.Errorf("Expected the updated draft, got %+v", drafts)
+		}
+	})
+
+	t.Run("deletes a draft", func(t *testing.T) {
+		deleted, err := DeleteDraft(ctx, pool, userID, draft.ID)
+		if err != nil {
+			t.Fatalf("DeleteDraft failed: %v", err)
+		}
+		if deleted.MessageIDHeader != draft.MessageIDHeader {
+			t.Errorf("Expected the deleted draft's Message-ID %s, got %s", draft.MessageIDHeader, deleted.MessageIDHeader)
+		}
+
+		drafts, err := GetDrafts(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetDrafts failed: %v", err)
+		}
+		if len(drafts) != 0 {
+			t.Errorf("Expected no drafts, got %d", len(drafts))
+		}
+		if _, err := DeleteDraft(ctx, pool, userID, draft.ID); !errors.Is(err, ErrDraftNotFound) {
+			t.Errorf("Expected ErrDraftNotFound for a second delete, got %v", err)
+		}
+	})
+}
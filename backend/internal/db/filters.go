@@ -0,0 +1,249 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// ErrFilterNotFound is returned when a filter doesn't exist or belongs to another user.
+var ErrFilterNotFound = errors.New("filter not found")
+
+// filterColumns are the columns that scanFilter reads, in order.
+const filterColumns = `
+	id,
+	user_id,
+	name,
+	sender,
+	recipient,
+	subject,
+	has_attachment,
+	mark_read,
+	add_label,
+	move_to,
+	delete,
+	created_at,
+	updated_at
+`
+
+func scanFilter(row pgx.Row) (*models.Filter, error) {
+	var filter models.Filter
+	err := row.Scan(
+		&filter.ID,
+		&filter.UserID,
+		&filter.Name,
+		&filter.From,
+		&filter.To,
+		&filter.Subject,
+		&filter.HasAttachment,
+		&filter.MarkRead,
+		&filter.AddLabel,
+		&filter.MoveTo,
+		&filter.Delete,
+		&filter.CreatedAt,
+		&filter.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &filter, nil
+}
+
+// GetFilters returns all filters of the user, the oldest first, which is the order the sync applies them in.
+func GetFilters(ctx context.Context, pool *pgxpool.Pool, userID string) ([]*models.Filter, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+filterColumns+`
+		FROM filters
+		WHERE user_id = $1
+		ORDER BY created_at, id
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get filters: %w", err)
+	}
+	defer rows.Close()
+
+	filters := []*models.Filter{}
+	for rows.Next() {
+		filter, err := scanFilter(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan filter: %w", err)
+		}
+		filters = append(filters, filter)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating filters: %w", err)
+	}
+
+	return filters, nil
+}
+
+// GetFilter returns a filter of the user.
+// Returns ErrFilterNotFound if there's no such filter.
+func GetFilter(ctx context.Context, pool *pgxpool.Pool, userID, filterID string) (*models.Filter, error) {
+	filter, err := scanFilter(pool.QueryRow(ctx, `
+		SELECT `+filterColumns+`
+		FROM filters
+		WHERE id = $1 AND user_id = $2
+	`, filterID, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrFilterNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get filter: %w", err)
+	}
+	return filter, nil
+}
+
+// CreateFilter saves a new filter, and sets its ID and timestamps.
+func CreateFilter(ctx context.Context, pool *pgxpool.Pool, userID string, filter *models.Filter) error {
+	err := pool.QueryRow(ctx, `
+		INSERT INTO filters (
+			user_id, name, sender, recipient, subject, has_attachment, mark_read, add_label, move_to, delete
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, created_at, updated_at
+	`, userID, filter.Name, filter.From, filter.To, filter.Subject, filter.HasAttachment,
+		filter.MarkRead, filter.AddLabel, filter.MoveTo, filter.Delete,
+	).Scan(&filter.ID, &filter.CreatedAt, &filter.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create filter: %w", err)
+	}
+	filter.UserID = userID
+	return nil
+}
+
+// UpdateFilter replaces an existing filter of the user, and sets its timestamps.
+// Returns ErrFilterNotFound if there's no such filter.
+func UpdateFilter(ctx context.Context, pool *pgxpool.Pool, userID string, filter *models.Filter) error {
+	err := pool.QueryRow(ctx, `
+		UPDATE filters SET
+			name = $3,
+			sender = $4,
+			recipient = $5,
+			subject = $6,
+			has_attachment = $7,
+			mark_read = $8,
+			add_label = $9,
+			move_to = $10,
+			delete = $11,
+			updated_at = NOW()
+		WHERE id = $1 AND user_id = $2
+		RETURNING created_at, updated_at
+	`, filter.ID, userID, filter.Name, filter.From, filter.To, filter.Subject, filter.HasAttachment,
+		filter.MarkRead, filter.AddLabel, filter.MoveTo, filter.Delete,
+	).Scan(&filter.CreatedAt, &filter.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrFilterNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update filter: %w", err)
+	}
+	filter.UserID = userID
+	return nil
+}
+
+// DeleteFilter deletes a filter of the user.
+// Returns ErrFilterNotFound if there's no such filter.
+func DeleteFilter(ctx context.Context, pool *pgxpool.Pool, userID, filterID string) error {
+	result, err := pool.Exec(ctx, `
+		DELETE FROM filters
+		WHERE id = $1 AND user_id = $2
+	`, filterID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete filter: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrFilterNotFound
+	}
+	return nil
+}
+
+// ReplaceFilters replaces all filters of the user with the filters, and sets their IDs and timestamps.
+// They get created in their order, which GetFilters keeps.
+func ReplaceFilters(ctx context.Context, pool *pgxpool.Pool, userID string, filters []*models.Filter) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, `DELETE FROM filters WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete filters: %w", err)
+	}
+
+	// The timestamps of a transaction are all the same, so each filter gets a microsecond later one
+	createdAt := time.Now()
+	for _, filter := range filters {
+		createdAt = createdAt.Add(time.Microsecond)
+		err := tx.QueryRow(ctx, `
+			INSERT INTO filters (
+				user_id, name, sender, recipient, subject, has_attachment, mark_read, add_label, move_to, delete,
+				created_at, updated_at
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $11)
+			RETURNING id, created_at, updated_at
+		`, userID, filter.Name, filter.From, filter.To, filter.Subject, filter.HasAttachment,
+			filter.MarkRead, filter.AddLabel, filter.MoveTo, filter.Delete, createdAt,
+		).Scan(&filter.ID, &filter.CreatedAt, &filter.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to create filter: %w", err)
+		}
+		filter.UserID = userID
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// GetFilterMatches returns up to limit of the user's cached INBOX messages that the filter's conditions match,
+// the newest first, and whether there are more. Messages whose bodies we haven't fetched yet have no attachments in
+// the cache, so for them, has_attachment is only an estimate.
+func GetFilterMatches(ctx context.Context, pool *pgxpool.Pool, userID string, filter *models.Filter, limit int) ([]models.FilterMatch, bool, error) {
+	patterns := containsPatterns([]string{filter.From, filter.To, filter.Subject})
+	rows, err := pool.Query(ctx, `
+		SELECT m.id, t.stable_thread_id, COALESCE(m.from_address, ''), COALESCE(m.subject, ''), m.sent_at
+		FROM messages m
+		JOIN threads t ON t.id = m.thread_id
+		WHERE m.user_id = $1 AND m.imap_folder_name = 'INBOX'
+			AND COALESCE(m.from_address, '') ILIKE $2
+			AND ($3 = '%%' OR EXISTS ( -- Matches messages without recipients, too, if there's no condition
+				SELECT 1
+				FROM unnest(COALESCE(m.to_addresses, '{}') || COALESCE(m.cc_addresses, '{}')) AS r(address)
+				WHERE r.address ILIKE $3
+			))
+			AND COALESCE(m.subject, '') ILIKE $4
+			AND ($5::boolean IS NULL OR $5 = EXISTS (
+				SELECT 1 FROM attachments a WHERE a.message_id = m.id AND NOT a.is_inline
+			))
+		ORDER BY m.sent_at DESC NULLS LAST, m.imap_uid DESC
+		LIMIT $6
+	`, userID, patterns[0], patterns[1], patterns[2], filter.HasAttachment, limit+1)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get filter matches: %w", err)
+	}
+	defer rows.Close()
+
+	matches := []models.FilterMatch{}
+	for rows.Next() {
+		var match models.FilterMatch
+		if err := rows.Scan(&match.MessageID, &match.ThreadID, &match.From, &match.Subject, &match.SentAt); err != nil {
+			return nil, false, fmt.Errorf("failed to scan filter match: %w", err)
+		}
+		matches = append(matches, match)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("error iterating filter matches: %w", err)
+	}
+
+	if len(matches) > limit {
+		return matches[:limit], true, nil
+	}
+	return matches, false, nil
+}
@@ -0,0 +1,213 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestFilters(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "filters-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	otherUserID, err := GetOrCreateUser(ctx, pool, "filters-other@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	filter := &models.Filter{Name: "Newsletters", From: "news@example.com", MoveTo: "Newsletters"}
+
+	t.Run("creates and reads a filter", func(t *testing.T) {
+		if err := CreateFilter(ctx, pool, userID, filter); err != nil {
+			t.Fatalf("CreateFilter failed: %v", err)
+		}
+		if filter.ID == "" || filter.UpdatedAt.IsZero() {
+			t.Fatalf("Expected the ID and timestamps to be set, got %+v", filter)
+		}
+
+		retrieved, err := GetFilter(ctx, pool, userID, filter.ID)
+		if err != nil {
+			t.Fatalf("GetFilter failed: %v", err)
+		}
+		if retrieved.From != "news@example.com" || retrieved.MoveTo != "Newsletters" || retrieved.HasAttachment != nil {
+			t.Errorf("Unexpected filter: %+v", retrieved)
+		}
+	})
+
+	t.Run("lists filters, the oldest first", func(t *testing.T) {
+		hasAttachment := true
+		invoices := &models.Filter{Name: "Invoices", Subject: "invoice", HasAttachment: &hasAttachment, AddLabel: "Invoices"}
+		if err := CreateFilter(ctx, pool, userID, invoices); err != nil {
+			t.Fatalf("CreateFilter failed: %v", err)
+		}
+
+		filters, err := GetFilters(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetFilters failed: %v", err)
+		}
+		if len(filters) != 2 || filters[0].ID != filter.ID || filters[1].HasAttachment == nil || !*filters[1].HasAttachment {
+			t.Errorf("Expected the newsletters filter, then the invoices one, got %+v", filters)
+		}
+	})
+
+	t.Run("replaces a filter", func(t *testing.T) {
+		filter.MoveTo = ""
+		filter.Delete = true
+		filter.MarkRead = true
+		if err := UpdateFilter(ctx, pool, userID, filter); err != nil {
+			t.Fatalf("UpdateFilter failed: %v", err)
+		}
+
+		retrieved, err := GetFilter(ctx, pool, userID, filter.ID)
+		if err != nil {
+			t.Fatalf("GetFilter failed: %v", err)
+		}
+		if retrieved.MoveTo != "" || !retrieved.Delete || !retrieved.MarkRead {
+			t.Errorf("Unexpected filter: %+v", retrieved)
+		}
+	})
+
+	t.Run("doesn't touch filters of other users", func(t *testing.T) {
+		if _, err := GetFilter(ctx, pool, otherUserID, filter.ID); !errors.Is(err, ErrFilterNotFound) {
+			t.Errorf("Expected ErrFilterNotFound, got %v", err)
+		}
+		other := *filter
+		if err := UpdateFilter(ctx, pool, otherUserID, &other); !errors.Is(err, ErrFilterNotFound) {
+			t.Errorf("Expected ErrFilterNotFound, got %v", err)
+		}
+		if err := DeleteFilter(ctx, pool, otherUserID, filter.ID); !errors.Is(err, ErrFilterNotFound) {
+			t.Errorf("Expected ErrFilterNotFound, got %v", err)
+		}
+	})
+
+	t.Run("deletes a filter", func(t *testing.T) {
+		if err := DeleteFilter(ctx, pool, userID, filter.ID); err != nil {
+			t.Fatalf("DeleteFilter failed: %v", err)
+		}
+		if _, err := GetFilter(ctx, pool, userID, filter.ID); !errors.Is(err, ErrFilterNotFound) {
+			t.Errorf("Expected ErrFilterNotFound, got %v", err)
+		}
+	})
+
+	t.Run("replaces all filters, in their order", func(t *testing.T) {
+		otherFilter := &models.Filter{Subject: "other", MarkRead: true}
+		if err := CreateFilter(ctx, pool, otherUserID, otherFilter); err != nil {
+			t.Fatalf("CreateFilter failed: %v", err)
+		}
+
+		replacements := []*models.Filter{
+			{Name: "Zeta", Subject: "z", MoveTo: "Z"},
+			{Name: "Alpha", Subject: "a", MoveTo: "A"},
+			{Name: "Mu", Subject: "m", Delete: true},
+		}
+		if err := ReplaceFilters(ctx, pool, userID, replacements); err != nil {
+			t.Fatalf("ReplaceFilters failed: %v", err)
+		}
+
+		filters, err := GetFilters(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetFilters failed: %v", err)
+		}
+		var names []string
+		for _, f := range filters {
+			names = append(names, f.Name)
+		}
+		if !slices.Equal(names, []string{"Zeta", "Alpha", "Mu"}) || filters[0].ID != replacements[0].ID {
+			t.Errorf("Expected the replacements in their order, got %v", names)
+		}
+		if _, err := GetFilter(ctx, pool, otherUserID, otherFilter.ID); err != nil {
+			t.Errorf("Expected the other user's filter to stay, got %v", err)
+		}
+	})
+}
+
+func TestGetFilterMatches(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	userID, err := GetOrCreateUser(ctx, pool, "filter-matches-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	thread := &models.Thread{UserID: userID, StableThreadID: "<filter-matches@example.com>", Subject: "Invoice"}
+	if err := SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+
+	sentAt := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	messages := []*models.Message{
+		{IMAPUID: 1, IMAPFolderName: "INBOX", FromAddress: "Billing <billing@shop.example.com>", ToAddresses: []string{"me@example.com"}, Subject: "Your invoice"},
+		{IMAPUID: 2, IMAPFolderName: "INBOX", FromAddress: "billing@shop.example.com", CCAddresses: []string{"team@example.com"}, Subject: "Invoice reminder"},
+		{IMAPUID: 3, IMAPFolderName: "INBOX", FromAddress: "alice@example.com", ToAddresses: []string{"me@example.com"}, Subject: "Lunch?"},
+		{IMAPUID: 1, IMAPFolderName: "Archive", FromAddress: "billing@shop.example.com", Subject: "Old invoice"},
+	}
+	for i, msg := range messages {
+		msg.ThreadID = thread.ID
+		msg.UserID = userID
+		msgSentAt := sentAt.Add(time.Duration(i) * time.Hour)
+		msg.SentAt = &msgSentAt
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+	}
+	if err := SaveAttachment(ctx, pool, &models.Attachment{MessageID: messages[0].ID, Filename: "invoice.pdf", MimeType: "application/pdf"}); err != nil {
+		t.Fatalf("SaveAttachment failed: %v", err)
+	}
+
+	hasAttachment := true
+	hasNoAttachment := false
+	testCases := []struct {
+		name   string
+		filter models.Filter
+		want   []string
+	}{
+		{"matches the sender case-insensitively", models.Filter{From: "BILLING@shop"}, []string{messages[1].ID, messages[0].ID}},
+		{"matches To and Cc", models.Filter{To: "team@"}, []string{messages[1].ID}},
+		{"matches the subject", models.Filter{Subject: "invoice"}, []string{messages[1].ID, messages[0].ID}},
+		{"needs all conditions", models.Filter{From: "billing", Subject: "reminder"}, []string{messages[1].ID}},
+		{"matches messages with attachments", models.Filter{From: "billing", HasAttachment: &hasAttachment}, []string{messages[0].ID}},
+		{"matches messages without attachments", models.Filter{From: "billing", HasAttachment: &hasNoAttachment}, []string{messages[1].ID}},
+		{"takes wildcards literally", models.Filter{Subject: "%"}, []string{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			matches, truncated, err := GetFilterMatches(ctx, pool, userID, &tc.filter, 10)
+			if err != nil {
+				t.Fatalf("GetFilterMatches failed: %v", err)
+			}
+			if truncated {
+				t.Error("Expected the matches not to be truncated")
+			}
+			got := make([]string, len(matches))
+			for i, match := range matches {
+				got[i] = match.MessageID
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+
+	t.Run("truncates to the limit", func(t *testing.T) {
+		matches, truncated, err := GetFilterMatches(ctx, pool, userID, &models.Filter{From: "billing"}, 1)
+		if err != nil {
+			t.Fatalf("GetFilterMatches failed: %v", err)
+		}
+		if len(matches) != 1 || matches[0].MessageID != messages[1].ID || matches[0].ThreadID != thread.StableThreadID || !truncated {
+			t.Errorf("Expected the newest match and truncated, got %+v, %v", matches, truncated)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// GetFolderRoleOverrides returns the folder roles that the user set by hand, keyed by folder name.
+func GetFolderRoleOverrides(ctx context.Context, pool *pgxpool.Pool, userID string) (map[string]string, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT folder_name, role
+		FROM folder_role_overrides
+		WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get folder role overrides: %w", err)
+	}
+	defer rows.Close()
+
+	overrides := make(map[string]string)
+	for rows.Next() {
+		var folderName, role string
+		if err := rows.Scan(&folderName, &role); err != nil {
+			return nil, fmt.Errorf("failed to scan folder role override: %w", err)
+		}
+		overrides[folderName] = role
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate folder role overrides: %w", err)
+	}
+
+	return overrides, nil
+}
+
+// SaveFolderRoleOverride sets the role of a folder for the user.
+// Each role belongs to only one folder, so this takes the role away from any other folder that had it by hand.
+// The "other" role can belong to any number of folders.
+func SaveFolderRoleOverride(ctx context.Context, pool *pgxpool.Pool, userID, folderName, role string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if role != "other" {
+		_, err = tx.Exec(ctx, `
+			DELETE FROM folder_role_overrides
+			WHERE user_id = $1 AND role = $2 AND folder_name <> $3
+		`, userID, role, folderName)
+		if err != nil {
+			return fmt.Errorf("failed to clear folder role override: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO folder_role_overrides (user_id, folder_name, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, folder_name) DO UPDATE SET
+			role = EXCLUDED.role,
+			updated_at = NOW()
+	`, userID, folderName, role)
+	if err != nil {
+		return fmt.Errorf("failed to save folder role override: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// DeleteFolderRoleOverride removes the role that the user set for a folder, if any,
+// so that the folder goes back to the role we detect.
+func DeleteFolderRoleOverride(ctx context.Context, pool *pgxpool.Pool, userID, folderName string) error {
+	_, err := pool.Exec(ctx, `
+		DELETE FROM folder_role_overrides
+		WHERE user_id = $1 AND folder_name = $2
+	`, userID, folderName)
+	if err != nil {
+		return fmt.Errorf("failed to delete folder role override: %w", err)
+	}
+	return nil
+}
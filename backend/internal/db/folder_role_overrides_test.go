@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestFolderRoleOverrides(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "folder-role-overrides@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	getOverrides := func(t *testing.T) map[string]string {
+		t.Helper()
+		overrides, err := GetFolderRoleOverrides(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetFolderRoleOverrides failed: %v", err)
+		}
+		return overrides
+	}
+
+	t.Run("returns no overrides by default", func(t *testing.T) {
+		if overrides := getOverrides(t); len(overrides) != 0 {
+			t.Errorf("Expected no overrides, got %v", overrides)
+		}
+	})
+
+	t.Run("saves and updates overrides", func(t *testing.T) {
+		if err := SaveFolderRoleOverride(ctx, pool, userID, "Junk E-mail", "other"); err != nil {
+			t.Fatalf("SaveFolderRoleOverride failed: %v", err)
+		}
+		if err := SaveFolderRoleOverride(ctx, pool, userID, "Spam", "trash"); err != nil {
+			t.Fatalf("SaveFolderRoleOverride failed: %v", err)
+		}
+		if err := SaveFolderRoleOverride(ctx, pool, userID, "Spam", "spam"); err != nil {
+			t.Fatalf("SaveFolderRoleOverride failed: %v", err)
+		}
+
+		overrides := getOverrides(t)
+		if len(overrides) != 2 || overrides["Junk E-mail"] != "other" || overrides["Spam"] != "spam" {
+			t.Errorf("Expected Junk E-mail as other and Spam as spam, got %v", overrides)
+		}
+	})
+
+	t.Run("moves a role from one folder to another", func(t *testing.T) {
+		if err := SaveFolderRoleOverride(ctx, pool, userID, "Old Archive", "archive"); err != nil {
+			t.Fatalf("SaveFolderRoleOverride failed: %v", err)
+		}
+		if err := SaveFolderRoleOverride(ctx, pool, userID, "Archive 2025", "archive"); err != nil {
+			t.Fatalf("SaveFolderRoleOverride failed: %v", err)
+		}
+
+		overrides := getOverrides(t)
+		if _, exists := overrides["Old Archive"]; exists {
+			t.Errorf("Expected Old Archive to lose its override, got %v", overrides)
+		}
+		if overrides["Archive 2025"] != "archive" {
+			t.Errorf("Expected Archive 2025 to be the archive, got %v", overrides)
+		}
+	})
+
+	t.Run("deletes overrides", func(t *testing.T) {
+		if err := DeleteFolderRoleOverride(ctx, pool, userID, "Spam"); err != nil {
+			t.Fatalf("DeleteFolderRoleOverride failed: %v", err)
+		}
+		if _, exists := getOverrides(t)["Spam"]; exists {
+			t.Error("Expected the Spam override to be deleted")
+		}
+
+		// Deleting a missing override is fine
+		if err := DeleteFolderRoleOverride(ctx, pool, userID, "Spam"); err != nil {
+			t.Fatalf("DeleteFolderRoleOverride failed: %v", err)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// GetFolderSyncPreference returns how we sync the given folder for the user.
+// Folders without a saved preference get the defaults, so this never returns a not-found error.
+func GetFolderSyncPreference(ctx context.Context, pool *pgxpool.Pool, userID, folderName string) (*models.FolderSyncPreference, error) {
+	pref := models.FolderSyncPreference{FolderName: folderName}
+
+	err := pool.QueryRow(ctx, `
+		SELECT enabled, mode, sync_days, sync_max_messages, updated_at
+		FROM folder_sync_preferences
+		WHERE user_id = $1 AND folder_name = $2
+	`, userID, folderName).Scan(&pref.Enabled, &pref.Mode, &pref.SyncDays, &pref.SyncMaxMessages, &pref.UpdatedAt)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		pref.Enabled = true
+		pref.Mode = models.FolderSyncModeHeadersOnly
+		return &pref, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get folder sync preference: %w", err)
+	}
+
+	return &pref, nil
+}
+
+// GetBackgroundSyncFolders returns the folders that we sync in the background for the user:
+// INBOX, unless the user disabled it, and the folders that the user explicitly enabled syncing for.
+func GetBackgroundSyncFolders(ctx context.Context, pool *pgxpool.Pool, userID string) ([]string, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT folder_name
+		FROM folder_sync_preferences
+		WHERE user_id = $1 AND enabled
+		UNION
+		SELECT 'INBOX' AS folder_name
+		WHERE NOT EXISTS (
+			SELECT 1 FROM folder_sync_preferences
+			WHERE user_id = $1 AND folder_name = 'INBOX' AND NOT enabled
+		)
+		ORDER BY folder_name
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get background sync folders: %w", err)
+	}
+	defer rows.Close()
+
+	var folderNames []string
+	for rows.Next() {
+		var folderName string
+		if err := rows.Scan(&folderName); err != nil {
+			return nil, fmt.Errorf("failed to scan folder name: %w", err)
+		}
+		folderNames = append(folderNames, folderName)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating background sync folders: %w", err)
+	}
+
+	return folderNames, nil
+}
+
+// SaveFolderSyncPreference saves how we sync the given folder for the user.
+func SaveFolderSyncPreference(ctx context.Context, pool *pgxpool.Pool, userID string, pref *models.FolderSyncPreference) error {
+	err := pool.QueryRow(ctx, `
+		INSERT INTO folder_sync_preferences (user_id, folder_name, enabled, mode, sync_days, sync_max_messages)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, folder_name) DO UPDATE SET
+			enabled = EXCLUDED.enabled,
+			mode = EXCLUDED.mode,
+			sync_days = EXCLUDED.sync_days,
+			sync_max_messages = EXCLUDED.sync_max_messages,
+			updated_at = NOW()
+		RETURNING updated_at
+	`, userID, pref.FolderName, pref.Enabled, pref.Mode, pref.SyncDays, pref.SyncMaxMessages).Scan(&pref.UpdatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to save folder sync preference: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestFolderSyncPreferences(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "folder-sync-prefs@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	t.Run("returns defaults for folder without preference", func(t *testing.T) {
+		pref, err := GetFolderSyncPreference(ctx, pool, userID, "Archive")
+		if err != nil {
+			t.Fatalf("GetFolderSyncPreference failed: %v", err)
+		}
+		if !pref.Enabled {
+			t.Error("Expected folder to be enabled by default")
+		}
+		if pref.Mode != models.FolderSyncModeHeadersOnly {
+			t.Errorf("Expected mode %s, got %s", models.FolderSyncModeHeadersOnly, pref.Mode)
+		}
+	})
+
+	t.Run("saves and retrieves preference", func(t *testing.T) {
+		syncDays := 90
+		pref := &models.FolderSyncPreference{FolderName: "Archive", Enabled: false, Mode: models.FolderSyncModeFull, SyncDays: &syncDays}
+		if err := SaveFolderSyncPreference(ctx, pool, userID, pref); err != nil {
+			t.Fatalf("SaveFolderSyncPreference failed: %v", err)
+		}
+		if pref.UpdatedAt.IsZero() {
+			t.Error("Expected UpdatedAt to be set")
+		}
+
+		retrieved, err := GetFolderSyncPreference(ctx, pool, userID, "Archive")
+		if err != nil {
+			t.Fatalf("GetFolderSyncPreference failed: %v", err)
+		}
+		if retrieved.Enabled || retrieved.Mode != models.FolderSyncModeFull {
+			t.Errorf("Expected disabled full sync, got enabled=%v mode=%s", retrieved.Enabled, retrieved.Mode)
+		}
+		if retrieved.SyncDays == nil || *retrieved.SyncDays != 90 || retrieved.SyncMaxMessages != nil {
+			t.Errorf("Expected a 90-day sync depth, got %v days and %v messages", retrieved.SyncDays, retrieved.SyncMaxMessages)
+		}
+
+		other, err := GetFolderSyncPreference(ctx, pool, userID, "INBOX")
+		if err != nil {
+			t.Fatalf("GetFolderSyncPreference failed: %v", err)
+		}
+		if !other.Enabled {
+			t.Error("Expected other folders to keep the defaults")
+		}
+	})
+}
+
+func TestGetBackgroundSyncFolders(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "background-sync-folders@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	assertFolders := func(t *testing.T, expected []string) {
+		t.Helper()
+		folderNames, err := GetBackgroundSyncFolders(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetBackgroundSyncFolders failed: %v", err)
+		}
+		if strings.Join(folderNames, ",") != strings.Join(expected, ",") {
+			t.Errorf("Expected folders %v, got %v", expected, folderNames)
+		}
+	}
+
+	t.Run("returns INBOX by default", func(t *testing.T) {
+		assertFolders(t, []string{"INBOX"})
+	})
+
+	t.Run("returns explicitly enabled folders", func(t *testing.T) {
+		for _, pref := range []*models.FolderSyncPreference{
+			{FolderName: "Work", Enabled: true, Mode: models.FolderSyncModeHeadersOnly},
+			{FolderName: "Archive", Enabled: false, Mode: models.FolderSyncModeHeadersOnly},
+		} {
+			if err := SaveFolderSyncPreference(ctx, pool, userID, pref); err != nil {
+				t.Fatalf("SaveFolderSyncPreference failed: %v", err)
+			}
+		}
+		assertFolders(t, []string{"INBOX", "Work"})
+	})
+
+	t.Run("leaves out INBOX if the user disabled it", func(t *testing.T) {
+		pref := &models.FolderSyncPreference{FolderName: "INBOX", Enabled: false, Mode: models.FolderSyncModeHeadersOnly}
+		if err := SaveFolderSyncPreference(ctx, pool, userID, pref); err != nil {
+			t.Fatalf("SaveFolderSyncPreference failed: %v", err)
+		}
+		assertFolders(t, []string{"Work"})
+	})
+}
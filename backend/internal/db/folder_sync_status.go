@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// FolderSyncStaleAfter is how long after it started we stop believing that a sync is still running.
+// A server that crashed or restarted mid-sync never marks the sync finished.
+const FolderSyncStaleAfter = 15 * time.Minute
+
+// MarkFolderSyncStarted records that a sync of the folder started.
+// For folders we've never synced, it adds a row without synced_at, and without a thread count,
+// so that GetFolderSyncInfo still returns nil and GetThreadCountForFolder counts the threads on the fly.
+func MarkFolderSyncStarted(ctx context.Context, pool *pgxpool.Pool, userID, folderName string) error {
+	_, err := pool.Exec(ctx, `
+		INSERT INTO folder_sync_timestamps (user_id, folder_name, synced_at, thread_count, sync_started_at)
+		VALUES ($1, $2, NULL, NULL, now())
+		ON CONFLICT (user_id, folder_name) DO UPDATE SET
+			sync_started_at = now()
+	`, userID, folderName)
+
+	if err != nil {
+		return fmt.Errorf("failed to mark folder sync started: %w", err)
+	}
+
+	return nil
+}
+
+// MarkFolderSyncFinished records that the running sync of the folder finished, and why it failed, if it did.
+// An empty syncErr clears the error of an earlier sync.
+func MarkFolderSyncFinished(ctx context.Context, pool *pgxpool.Pool, userID, folderName, syncErr string) error {
+	_, err := pool.Exec(ctx, `
+		UPDATE folder_sync_timestamps SET
+			sync_started_at = NULL,
+			last_error = NULLIF($3, ''),
+			last_error_at = CASE WHEN $3 = '' THEN NULL ELSE now() END
+		WHERE user_id = $1 AND folder_name = $2
+	`, userID, folderName, syncErr)
+
+	if err != nil {
+		return fmt.Errorf("failed to mark folder sync finished: %w", err)
+	}
+
+	return nil
+}
+
+// GetFolderSyncStatuses returns the sync state of each folder we've started syncing for the user, sorted by name.
+func GetFolderSyncStatuses(ctx context.Context, pool *pgxpool.Pool, userID string) ([]*models.FolderSyncStatus, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT folder_name, synced_at, last_synced_uid, COALESCE(thread_count, 0), sync_started_at,
+			COALESCE(last_error, ''), last_error_at
+		FROM folder_sync_timestamps
+		WHERE user_id = $1
+		ORDER BY folder_name
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get folder sync statuses: %w", err)
+	}
+	defer rows.Close()
+
+	statuses := []*models.FolderSyncStatus{}
+	for rows.Next() {
+		var status models.FolderSyncStatus
+		if err := rows.Scan(&status.FolderName, &status.SyncedAt, &status.LastSyncedUID, &status.ThreadCount,
+			&status.SyncStartedAt, &status.LastError, &status.LastErrorAt); err != nil {
+			return nil, fmt.Errorf("failed to scan folder sync status: %w", err)
+		}
+		if status.SyncStartedAt != nil && time.Since(*status.SyncStartedAt) > FolderSyncStaleAfter {
+			status.SyncStartedAt = nil
+		}
+		status.Syncing = status.SyncStartedAt != nil
+		statuses = append(statuses, &status)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating folder sync statuses: %w", err)
+	}
+
+	return statuses, nil
+}
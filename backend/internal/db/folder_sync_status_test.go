@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestFolderSyncStatuses(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	userID, err := GetOrCreateUser(ctx, pool, "sync-status-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	t.Run("returns no folders before the first sync", func(t *testing.T) {
+		statuses, err := GetFolderSyncStatuses(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetFolderSyncStatuses failed: %v", err)
+		}
+		if statuses == nil || len(statuses) != 0 {
+			t.Errorf("Expected an empty list, got %v", statuses)
+		}
+	})
+
+	t.Run("marks the first sync of a folder as running without marking the folder synced", func(t *testing.T) {
+		if err := MarkFolderSyncStarted(ctx, pool, userID, "INBOX"); err != nil {
+			t.Fatalf("MarkFolderSyncStarted failed: %v", err)
+		}
+
+		statuses, err := GetFolderSyncStatuses(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetFolderSyncStatuses failed: %v", err)
+		}
+		if len(statuses) != 1 || !statuses[0].Syncing || statuses[0].SyncedAt != nil {
+			t.Fatalf("Expected INBOX to be syncing for the first time, got %+v", statuses)
+		}
+
+		info, err := GetFolderSyncInfo(ctx, pool, userID, "INBOX")
+		if err != nil {
+			t.Fatalf("GetFolderSyncInfo failed: %v", err)
+		}
+		if info != nil {
+			t.Errorf("Expected no sync info before the first sync finishes, got %+v", info)
+		}
+	})
+
+	t.Run("records the error of a failed sync", func(t *testing.T) {
+		if err := MarkFolderSyncFinished(ctx, pool, userID, "INBOX", "connection refused"); err != nil {
+			t.Fatalf("MarkFolderSyncFinished failed: %v", err)
+		}
+
+		statuses, err := GetFolderSyncStatuses(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetFolderSyncStatuses failed: %v", err)
+		}
+		if len(statuses) != 1 || statuses[0].Syncing || statuses[0].LastError != "connection refused" || statuses[0].LastErrorAt == nil {
+			t.Errorf("Expected the error of the failed sync, got %+v", statuses[0])
+		}
+	})
+
+	t.Run("clears the error after a successful sync", func(t *testing.T) {
+		uid := int64(42)
+		if err := MarkFolderSyncStarted(ctx, pool, userID, "INBOX"); err != nil {
+			t.Fatalf("MarkFolderSyncStarted failed: %v", err)
+		}
+		if err := SetFolderSyncInfo(ctx, pool, userID, "INBOX", &uid); err != nil {
+			t.Fatalf("SetFolderSyncInfo failed: %v", err)
+		}
+		if err := MarkFolderSyncFinished(ctx, pool, userID, "INBOX", ""); err != nil {
+			t.Fatalf("MarkFolderSyncFinished failed: %v", err)
+		}
+
+		statuses, err := GetFolderSyncStatuses(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetFolderSyncStatuses failed: %v", err)
+		}
+		status := statuses[0]
+		if status.Syncing || status.LastError != "" || status.LastErrorAt != nil || status.SyncedAt == nil ||
+			status.LastSyncedUID == nil || *status.LastSyncedUID != uid {
+			t.Errorf("Expected a synced folder without an error, got %+v", status)
+		}
+	})
+
+	t.Run("doesn't report syncs that started long ago as running", func(t *testing.T) {
+		if _, err := pool.Exec(ctx, `
+			UPDATE folder_sync_timestamps SET sync_started_at = now() - interval '1 hour'
+			WHERE user_id = $1 AND folder_name = 'INBOX'
+		`, userID); err != nil {
+			t.Fatalf("Failed to age the sync: %v", err)
+		}
+
+		statuses, err := GetFolderSyncStatuses(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetFolderSyncStatuses failed: %v", err)
+		}
+		if statuses[0].Syncing || statuses[0].SyncStartedAt != nil {
+			t.Errorf("Expected a stale sync not to count as running, got %+v", statuses[0])
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// bareAddressSQL extracts the lowercase bare address from an address column.
+// We store addresses as "Name <mailbox@host>" or just "mailbox@host".
+const bareAddressSQL = `lower(coalesce(substring(%[1]s from '<([^>]+)>'), trim(%[1]s)))`
+
+// GetThreadImportanceSignals gathers the importance signals for each of the user's cached threads.
+// ownAddresses are the user's email addresses besides their login email, which we always include.
+// Threads with only messages that the user sent are left out.
+func GetThreadImportanceSignals(ctx context.Context, pool *pgxpool.Pool, userID string, ownAddresses []string) ([]models.ThreadImportanceSignals, error) {
+	return queryThreadImportanceSignals(ctx, pool, "", "TRUE", "TRUE", userID, ownAddresses)
+}
+
+// GetThreadImportanceSignalsForMessages is like GetThreadImportanceSignals, but only for the threads whose signals
+// the messages with the given UIDs in the folder can change: their own threads, and the threads whose latest message
+// is from one of their senders, or, for the ones the user sent, from one of their recipients.
+func GetThreadImportanceSignalsForMessages(ctx context.Context, pool *pgxpool.Pool, userID string, ownAddresses []string, folderName string, uids []uint32) ([]models.ThreadImportanceSignals, error) {
+	if len(uids) == 0 {
+		return nil, nil
+	}
+	uidValues := make([]int64, len(uids))
+	for i, uid := range uids {
+		uidValues[i] = int64(uid)
+	}
+
+	return queryThreadImportanceSignals(ctx, pool, `
+		new_msgs AS (
+			SELECT thread_id, sender, tos, ccs
+			FROM msgs
+			WHERE imap_folder_name = $3 AND imap_uid = ANY($4::bigint[])
+		),
+		affected_senders AS (
+			SELECT sender AS address FROM new_msgs
+			UNION
+			SELECT unnest(new_msgs.tos || new_msgs.ccs) FROM new_msgs, own WHERE new_msgs.sender = ANY(own.addresses)
+		),
+		affected_threads AS (
+			SELECT thread_id FROM new_msgs
+			UNION
+			SELECT thread_id FROM msgs WHERE sender IN (SELECT address FROM affected_senders)
+		),`,
+		"msgs.thread_id IN (SELECT thread_id FROM affected_threads)",
+		"l.thread_id IN (SELECT thread_id FROM new_msgs) OR l.sender IN (SELECT address FROM affected_senders)",
+		userID, ownAddresses, folderName, uidValues)
+}
+
+// queryThreadImportanceSignals runs the signals query. scopeCTEs are extra CTEs that threadFilter, a condition on the
+// msgs of the threads to consider, and latestFilter, a condition on their latest message "l", can use.
+func queryThreadImportanceSignals(ctx context.Context, pool *pgxpool.Pool, scopeCTEs, threadFilter, latestFilter string, args ...any) ([]models.ThreadImportanceSignals, error) {
+	rows, err := pool.Query(ctx, fmt.Sprintf(`
+		WITH own AS (
+			SELECT coalesce(array_agg(DISTINCT lower(a)), '{}') AS addresses
+			FROM (SELECT unnest($2::text[]) AS a UNION SELECT email FROM users WHERE id = $1) addresses
+		),
+		msgs AS (
+			SELECT
+				m.thread_id,
+				m.imap_folder_name,
+				m.imap_uid,
+				m.sent_at,
+				m.is_starred,
+				%[1]s AS sender,
+				ARRAY(SELECT %[2]s FROM unnest(m.to_addresses) a) AS tos,
+				ARRAY(SELECT %[2]s FROM unnest(m.cc_addresses) a) AS ccs
+			FROM messages m
+			WHERE m.user_id = $1
+		),%[3]s
+		latest AS (
+			SELECT DISTINCT ON (msgs.thread_id) msgs.thread_id, msgs.sender, msgs.tos, msgs.ccs
+			FROM msgs, own
+			WHERE msgs.sender <> ALL(own.addresses) AND (%[4]s)
+			ORDER BY msgs.thread_id, msgs.sent_at DESC NULLS LAST
+		),
+		senders AS (
+			SELECT sender, COUNT(*) AS message_count, bool_or(is_starred) AS starred
+			FROM msgs
+			WHERE sender IN (SELECT sender FROM latest)
+			GROUP BY sender
+		),
+		replied_to AS (
+			SELECT DISTINCT unnest(msgs.tos || msgs.ccs) AS address
+			FROM msgs, own
+			WHERE msgs.sender = ANY(own.addresses)
+		),
+		starred_threads AS (
+			SELECT thread_id, bool_or(is_starred) AS starred
+			FROM msgs
+			WHERE thread_id IN (SELECT thread_id FROM latest)
+			GROUP BY thread_id
+		)
+		SELECT
+			l.thread_id,
+			s.message_count,
+			EXISTS (SELECT 1 FROM replied_to r WHERE r.address = l.sender),
+			s.starred,
+			l.tos && own.addresses,
+			l.ccs && own.addresses,
+			st.starred
+		FROM latest l
+		CROSS JOIN own
+		INNER JOIN senders s ON s.sender = l.sender
+		INNER JOIN starred_threads st ON st.thread_id = l.thread_id
+		WHERE %[5]s
+	`, fmt.Sprintf(bareAddressSQL, "m.from_address"), fmt.Sprintf(bareAddressSQL, "a"), scopeCTEs, threadFilter, latestFilter),
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get thread importance signals: %w", err)
+	}
+	defer rows.Close()
+
+	var signals []models.ThreadImportanceSignals
+	for rows.Next() {
+		var s models.ThreadImportanceSignals
+		if err := rows.Scan(
+			&s.ThreadID,
+			&s.SenderMessageCount,
+			&s.UserRepliedToSender,
+			&s.SenderStarred,
+			&s.DirectToUser,
+			&s.CCToUser,
+			&s.ThreadStarred,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan thread importance signals: %w", err)
+		}
+		signals = append(signals, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating thread importance signals: %w", err)
+	}
+
+	return signals, nil
+}
+
+// SaveThreadImportanceScores saves the importance scores of the given threads.
+// scores maps thread IDs to scores from 0 to 100.
+func SaveThreadImportanceScores(ctx context.Context, pool *pgxpool.Pool, userID string, scores map[string]int) error {
+	if len(scores) == 0 {
+		return nil
+	}
+
+	threadIDs := make([]string, 0, len(scores))
+	values := make([]int32, 0, len(scores))
+	for threadID, score := range scores {
+		threadIDs = append(threadIDs, threadID)
+		values = append(values, int32(score))
+	}
+
+	_, err := pool.Exec(ctx, `
+		UPDATE threads t
+		SET importance_score = s.score
+		FROM (SELECT unnest($2::uuid[]) AS id, unnest($3::int[]) AS score) s
+		WHERE t.id = s.id AND t.user_id = $1 AND t.importance_score <> s.score
+	`, userID, threadIDs, values)
+
+	if err != nil {
+		return fmt.Errorf("failed to save thread importance scores: %w", err)
+	}
+
+	return nil
+}
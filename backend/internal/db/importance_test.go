@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestGetThreadImportanceSignals(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	userID, err := GetOrCreateUser(ctx, pool, "me@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	now := time.Now()
+	uid := int64(0)
+	saveMessage := func(t *testing.T, thread *models.Thread, folder, from string, to, cc []string, starred bool, sentAt time.Time) {
+		t.Helper()
+		uid++
+		msg := &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         uid,
+			IMAPFolderName:  folder,
+			MessageIDHeader: thread.StableThreadID + "-" + sentAt.String(),
+			FromAddress:     from,
+			ToAddresses:     to,
+			CCAddresses:     cc,
+			IsStarred:       starred,
+			SentAt:          &sentAt,
+		}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+	}
+	saveThread := func(t *testing.T, stableID string) *models.Thread {
+		t.Helper()
+		thread := &models.Thread{UserID: userID, StableThreadID: stableID}
+		if err := SaveThread(ctx, pool, thread); err != nil {
+			t.Fatalf("SaveThread failed: %v", err)
+		}
+		return thread
+	}
+
+	// A conversation with a friend: they wrote, the user replied (in Sent), they wrote again
+	friendThread := saveThread(t, "<friend>")
+	saveMessage(t, friendThread, "INBOX", "Friend <friend@example.com>", []string{"Me <me@example.com>"}, nil, false, now.Add(-2*time.Hour))
+	saveMessage(t, friendThread, "Sent", "me@example.com", []string{"Friend <friend@example.com>"}, nil, true, now.Add(-time.Hour))
+	saveMessage(t, friendThread, "INBOX", "Friend <FRIEND@example.com>", nil, []string{"me@example.com"}, false, now)
+
+	// A mailing list message
+	listThread := saveThread(t, "<list>")
+	saveMessage(t, listThread, "INBOX", "list@example.com", []string{"everyone@example.com"}, nil, false, now)
+
+	// A thread the user started without a reply
+	ownThread := saveThread(t, "<own>")
+	saveMessage(t, ownThread, "Sent", "me@example.com", []string{"someone@example.com"}, nil, false, now)
+
+	signals, err := GetThreadImportanceSignals(ctx, pool, userID, nil)
+	if err != nil {
+		t.Fatalf("GetThreadImportanceSignals failed: %v", err)
+	}
+
+	byThread := map[string]models.ThreadImportanceSignals{}
+	for _, s := range signals {
+		byThread[s.ThreadID] = s
+	}
+	if len(byThread) != 2 {
+		t.Fatalf("Expected signals for 2 threads, got %d", len(byThread))
+	}
+	if _, ok := byThread[ownThread.ID]; ok {
+		t.Error("Expected no signals for a thread with only the user's messages")
+	}
+
+	friend := byThread[friendThread.ID]
+	expectedFriend := models.ThreadImportanceSignals{
+		ThreadID:            friendThread.ID,
+		SenderMessageCount:  2,
+		UserRepliedToSender: true,
+		CCToUser:            true,
+		ThreadStarred:       true,
+	}
+	if friend != expectedFriend {
+		t.Errorf("Expected friend signals %+v, got %+v", expectedFriend, friend)
+	}
+
+	list := byThread[listThread.ID]
+	expectedList := models.ThreadImportanceSignals{ThreadID: listThread.ID, SenderMessageCount: 1}
+	if list != expectedList {
+		t.Errorf("Expected list signals %+v, got %+v", expectedList, list)
+	}
+
+	t.Run("gathers only the threads that the messages touch", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			folder   string
+			uids     []uint32
+			expected []string
+		}{
+			{"a mailing list message", "INBOX", []uint32{4}, []string{listThread.ID}},
+			{"a message from a friend", "INBOX", []uint32{3}, []string{friendThread.ID}},
+			{"a message the user sent to a friend", "Sent", []uint32{2}, []string{friendThread.ID}},
+			{"a message the user sent to a stranger", "Sent", []uint32{5}, nil},
+			{"a UID from another folder", "Sent", []uint32{4}, nil},
+			{"no UIDs", "INBOX", nil, nil},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				signals, err := GetThreadImportanceSignalsForMessages(ctx, pool, userID, nil, tt.folder, tt.uids)
+				if err != nil {
+					t.Fatalf("GetThreadImportanceSignalsForMessages failed: %v", err)
+				}
+				var threadIDs []string
+				for _, s := range signals {
+					threadIDs = append(threadIDs, s.ThreadID)
+					if s != byThread[s.ThreadID] {
+						t.Errorf("Expected the same signals as for all threads %+v, got %+v", byThread[s.ThreadID], s)
+					}
+				}
+				if !slices.Equal(threadIDs, tt.expected) {
+					t.Errorf("Expected threads %v, got %v", tt.expected, threadIDs)
+				}
+			})
+		}
+	})
+
+	t.Run("saves scores", func(t *testing.T) {
+		if err := SaveThreadImportanceScores(ctx, pool, userID, map[string]int{friendThread.ID: 70}); err != nil {
+			t.Fatalf("SaveThreadImportanceScores failed: %v", err)
+		}
+		count, err := GetImportantThreadCountForFolder(ctx, pool, userID, "INBOX", 50)
+		if err != nil {
+			t.Fatalf("GetImportantThreadCountForFolder failed: %v", err)
+		}
+		if count != 1 {
+			t.Errorf("Expected 1 important thread, got %d", count)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// SaveLinkAnalysis saves the link analysis of a message body, replacing the one it had, for example, after the body
+// was fetched again.
+func SaveLinkAnalysis(ctx context.Context, pool *pgxpool.Pool, messageID string, analysis *models.LinkAnalysis) error {
+	warnings := analysis.Warnings
+	if warnings == nil {
+		warnings = []models.LinkWarning{}
+	}
+	warningsJSON, err := json.Marshal(warnings)
+	if err != nil {
+		return fmt.Errorf("failed to encode link warnings: %w", err)
+	}
+
+	_, err = pool.Exec(ctx, `
+		INSERT INTO message_link_analyses (message_id, suspicious_score, warnings)
+		VALUES ($1, $2, $3::jsonb)
+		ON CONFLICT (message_id) DO UPDATE SET
+			suspicious_score = EXCLUDED.suspicious_score,
+			warnings = EXCLUDED.warnings
+	`, messageID, analysis.SuspiciousScore, string(warningsJSON))
+	if err != nil {
+		return fmt.Errorf("failed to save link analysis: %w", err)
+	}
+	return nil
+}
+
+// GetLinkAnalysesForMessages returns the link analyses of the messages that have one, by message ID.
+func GetLinkAnalysesForMessages(ctx context.Context, pool *pgxpool.Pool, messageIDs []string) (map[string]*models.LinkAnalysis, error) {
+	analyses := make(map[string]*models.LinkAnalysis)
+	if len(messageIDs) == 0 {
+		return analyses, nil
+	}
+
+	rows, err := pool.Query(ctx, `
+		SELECT message_id, suspicious_score, warnings
+		FROM message_link_analyses
+		WHERE message_id = ANY($1)
+	`, messageIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get link analyses: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID string
+		var analysis models.LinkAnalysis
+		var warningsJSON []byte
+		if err := rows.Scan(&messageID, &analysis.SuspiciousScore, &warningsJSON); err != nil {
+			return nil, fmt.Errorf("failed to scan link analysis: %w", err)
+		}
+		if err := json.Unmarshal(warningsJSON, &analysis.Warnings); err != nil {
+			return nil, fmt.Errorf("failed to decode link warnings: %w", err)
+		}
+		if analysis.Warnings == nil {
+			analysis.Warnings = []models.LinkWarning{}
+		}
+		analyses[messageID] = &analysis
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating link analyses: %w", err)
+	}
+
+	return analyses, nil
+}
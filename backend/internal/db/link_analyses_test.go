@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestLinkAnalyses(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	userID, err := GetOrCreateUser(ctx, pool, "link-analyses-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	thread := &models.Thread{UserID: userID, StableThreadID: "<links@example.com>", Subject: "Links"}
+	if err := SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+	var messageIDs []string
+	for uid := int64(1); uid <= 2; uid++ {
+		msg := &models.Message{ThreadID: thread.ID, UserID: userID, IMAPUID: uid, IMAPFolderName: "INBOX"}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+		messageIDs = append(messageIDs, msg.ID)
+	}
+
+	warning := models.LinkWarning{
+		Kind:   models.LinkWarningMismatchedText,
+		URL:    "https://evil.example/",
+		Domain: "evil.example",
+		Text:   "bank.example",
+	}
+
+	t.Run("saves and gets the analyses of messages", func(t *testing.T) {
+		analysis := &models.LinkAnalysis{SuspiciousScore: 45, Warnings: []models.LinkWarning{warning}}
+		if err := SaveLinkAnalysis(ctx, pool, messageIDs[0], analysis); err != nil {
+			t.Fatalf("SaveLinkAnalysis failed: %v", err)
+		}
+
+		analyses, err := GetLinkAnalysesForMessages(ctx, pool, messageIDs)
+		if err != nil {
+			t.Fatalf("GetLinkAnalysesForMessages failed: %v", err)
+		}
+		if len(analyses) != 1 {
+			t.Fatalf("Expected 1 analysis, got %d", len(analyses))
+		}
+		got := analyses[messageIDs[0]]
+		if got == nil || got.SuspiciousScore != 45 || len(got.Warnings) != 1 || got.Warnings[0] != warning {
+			t.Errorf("Expected %+v, got %+v", analysis, got)
+		}
+	})
+
+	t.Run("replaces the analysis of a message", func(t *testing.T) {
+		if err := SaveLinkAnalysis(ctx, pool, messageIDs[0], &models.LinkAnalysis{}); err != nil {
+			t.Fatalf("SaveLinkAnalysis failed: %v", err)
+		}
+
+		analyses, err := GetLinkAnalysesForMessages(ctx, pool, messageIDs[:1])
+		if err != nil {
+			t.Fatalf("GetLinkAnalysesForMessages failed: %v", err)
+		}
+		if got := analyses[messageIDs[0]]; got.SuspiciousScore != 0 || got.Warnings == nil || len(got.Warnings) != 0 {
+			t.Errorf("Expected an empty analysis, got %+v", got)
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ClearMailCache deletes all cached threads, messages, and attachments of a user,
+// and resets their folder sync state so that the next sync starts from scratch.
+// Use it when the cache no longer matches the mailbox, for example, after the user
+// switches to a different IMAP account. Drafts and queued actions are kept.
+// It also forgets whether the old server throttled us, and replaces the user's sync change log with a reset,
+// so that clients refetch everything instead of getting a delta with every old thread.
+func ClearMailCache(ctx context.Context, pool *pgxpool.Pool, userID string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// Messages and attachments are deleted by ON DELETE CASCADE
+	if _, err := tx.Exec(ctx, `DELETE FROM threads WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete cached threads: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM folder_sync_timestamps WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to reset folder sync state: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM sync_throttles WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to reset sync throttle: %w", err)
+	}
+
+	// After the threads, because deleting them logs changes
+	if _, err := tx.Exec(ctx, `DELETE FROM sync_changes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete sync changes: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO sync_changes (user_id) VALUES ($1)`, userID); err != nil {
+		return fmt.Errorf("failed to log sync reset: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// ClearFolderCache deletes the cached messages of one folder, and the threads that have
+// no messages left in any folder. It also resets the folder's sync state.
+func ClearFolderCache(ctx context.Context, pool *pgxpool.Pool, userID, folderName string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if err := clearFolderCache(ctx, tx, userID, folderName); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// clearFolderCache is ClearFolderCache in a transaction.
+func clearFolderCache(ctx context.Context, tx pgx.Tx, userID, folderName string) error {
+	// Attachments are deleted by ON DELETE CASCADE
+	_, err := tx.Exec(ctx, `
+		WITH deleted AS (
+			DELETE FROM messages
+			WHERE user_id = $1 AND imap_folder_name = $2
+			RETURNING thread_id
+		)
+		DELETE FROM threads t
+		WHERE t.id IN (SELECT thread_id FROM deleted)
+		  AND NOT EXISTS (
+			SELECT 1 FROM messages m WHERE m.thread_id = t.id AND m.imap_folder_name <> $2
+		  )
+	`, userID, folderName)
+	if err != nil {
+		return fmt.Errorf("failed to delete cached messages: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM folder_sync_timestamps WHERE user_id = $1 AND folder_name = $2`, userID, folderName); err != nil {
+		return fmt.Errorf("failed to reset folder sync state: %w", err)
+	}
+	return nil
+}
+
+// DeleteCachedFolder removes a folder from the cache after the server deleted it: its messages, the threads that
+// have no messages left, its sync state, and the user's sync preference and role for it.
+// Its subfolders stay, like on the server.
+func DeleteCachedFolder(ctx context.Context, pool *pgxpool.Pool, userID, folderName string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if err := clearFolderCache(ctx, tx, userID, folderName); err != nil {
+		return err
+	}
+	for _, table := range []string{"folder_sync_preferences", "folder_role_overrides"} {
+		if _, err := tx.Exec(ctx, `DELETE FROM `+table+` WHERE user_id = $1 AND folder_name = $2`, userID, folderName); err != nil {
+			return fmt.Errorf("failed to delete folder settings from %s: %w", table, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// folderNameColumns are the tables that keep something per folder, and their folder name columns.
+var folderNameColumns = []struct {
+	table  string
+	column string
+}{
+	{"messages", "imap_folder_name"},
+	{"folder_sync_timestamps", "folder_name"},
+	{"folder_sync_preferences", "folder_name"},
+	{"folder_role_overrides", "folder_name"},
+}
+
+// RenameCachedFolder renames a folder and its subfolders in the cache, after the server renamed them, so that
+// nothing needs to be synced again. delimiter is the folder's hierarchy delimiter, or empty if the server has no
+// hierarchy. Whatever the cache had under the new names is stale, since the server had no folders there,
+// so it's removed.
+func RenameCachedFolder(ctx context.Context, pool *pgxpool.Pool, userID, oldName, newName, delimiter string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	for _, t := range folderNameColumns {
+		// Matches the folder $2 and its subfolders. $3 is the delimiter. Without one, there are no subfolders.
+		inFolder := fmt.Sprintf(`(%[1]s = $2::text OR ($3::text <> '' AND starts_with(%[1]s, $2::text || $3::text)))`, t.column)
+
+		_, err := tx.Exec(ctx, fmt.Sprintf(`
+			DELETE FROM %s WHERE user_id = $1 AND %s
+		`, t.table, inFolder), userID, newName, delimiter)
+		if err != nil {
+			return fmt.Errorf("failed to delete stale %s of folder: %w", t.table, err)
+		}
+
+		_, err = tx.Exec(ctx, fmt.Sprintf(`
+			UPDATE %[1]s
+			SET %[2]s = $4::text || substr(%[2]s, char_length($2::text) + 1)
+			WHERE user_id = $1 AND %[3]s
+		`, t.table, t.column, inFolder), userID, oldName, delimiter, newName)
+		if err != nil {
+			return fmt.Errorf("failed to rename folder in %s: %w", t.table, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestClearMailCache(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	saveCache := func(t *testing.T, userID string) {
+		t.Helper()
+		thread := &models.Thread{UserID: userID, StableThreadID: "<cache-" + userID + ">", Subject: "Cached"}
+		if err := SaveThread(ctx, pool, thread); err != nil {
+			t.Fatalf("SaveThread failed: %v", err)
+		}
+		msg := &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         1,
+			IMAPFolderName:  "INBOX",
+			MessageIDHeader: thread.StableThreadID,
+			Subject:         "Cached",
+		}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+		if err := SaveAttachment(ctx, pool, &models.Attachment{MessageID: msg.ID, Filename: "a.txt", MimeType: "text/plain", SizeBytes: 1}); err != nil {
+			t.Fatalf("SaveAttachment failed: %v", err)
+		}
+		if err := SetFolderSyncInfo(ctx, pool, userID, "INBOX", nil); err != nil {
+			t.Fatalf("SetFolderSyncInfo failed: %v", err)
+		}
+	}
+
+	countRows := func(t *testing.T, query, userID string) int {
+		t.Helper()
+		var count int
+		if err := pool.QueryRow(ctx, query, userID).Scan(&count); err != nil {
+			t.Fatalf("Failed to count rows: %v", err)
+		}
+		return count
+	}
+
+	userID, err := GetOrCreateUser(ctx, pool, "clear-cache@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	otherUserID, err := GetOrCreateUser(ctx, pool, "clear-cache-other@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	saveCache(t, userID)
+	saveCache(t, otherUserID)
+	if err := SaveSyncThrottle(ctx, pool, userID, &models.SyncThrottle{Strikes: 2, Reason: "Too many connections"}); err != nil {
+		t.Fatalf("SaveSyncThrottle failed: %v", err)
+	}
+	since, err := GetSyncPosition(ctx, pool)
+	if err != nil {
+		t.Fatalf("GetSyncPosition failed: %v", err)
+	}
+
+	if err := ClearMailCache(ctx, pool, userID); err != nil {
+		t.Fatalf("ClearMailCache failed: %v", err)
+	}
+
+	t.Run("deletes threads, messages, and attachments", func(t *testing.T) {
+		if count := countRows(t, `SELECT COUNT(*) FROM threads WHERE user_id = $1`, userID); count != 0 {
+			t.Errorf("Expected 0 threads, got %d", count)
+		}
+		if count := countRows(t, `SELECT COUNT(*) FROM messages WHERE user_id = $1`, userID); count != 0 {
+			t.Errorf("Expected 0 messages, got %d", count)
+		}
+		attachmentQuery := `SELECT COUNT(*) FROM attachments a JOIN messages m ON a.message_id = m.id WHERE m.user_id = $1`
+		if count := countRows(t, attachmentQuery, otherUserID); count != 1 {
+			t.Errorf("Expected other user's attachment to be kept, got %d", count)
+		}
+	})
+
+	t.Run("resets folder sync state", func(t *testing.T) {
+		info, err := GetFolderSyncInfo(ctx, pool, userID, "INBOX")
+		if err != nil {
+			t.Fatalf("GetFolderSyncInfo failed: %v", err)
+		}
+		if info != nil {
+			t.Error("Expected folder sync info to be deleted")
+		}
+	})
+
+	t.Run("resets the sync throttle", func(t *testing.T) {
+		throttle, err := GetSyncThrottle(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetSyncThrottle failed: %v", err)
+		}
+		if throttle.Strikes != 0 {
+			t.Errorf("Expected no strikes, got %d", throttle.Strikes)
+		}
+	})
+
+	t.Run("replaces the sync change log with a reset", func(t *testing.T) {
+		if count := countRows(t, `SELECT COUNT(*) FROM sync_changes WHERE user_id = $1`, userID); count != 1 {
+			t.Errorf("Expected only the reset in the change log, got %d rows", count)
+		}
+		delta, _, err := GetSyncDelta(ctx, pool, userID, since, 500)
+		if err != nil {
+			t.Fatalf("GetSyncDelta failed: %v", err)
+		}
+		if !delta.Reset {
+			t.Errorf("Expected a reset, got %+v", delta)
+		}
+	})
+
+	t.Run("keeps other users' cache", func(t *testing.T) {
+		if count := countRows(t, `SELECT COUNT(*) FROM threads WHERE user_id = $1`, otherUserID); count != 1 {
+			t.Errorf("Expected 1 thread for the other user, got %d", count)
+		}
+	})
+}
+
+func TestClearFolderCache(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "clear-folder-cache@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	saveMessage := func(t *testing.T, stableID, folderName string, uid int64) {
+		t.Helper()
+		thread := &models.Thread{UserID: userID, StableThreadID: stableID, Subject: stableID}
+		if err := SaveThread(ctx, pool, thread); err != nil {
+			t.Fatalf("SaveThread failed: %v", err)
+		}
+		msg := &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         uid,
+			IMAPFolderName:  folderName,
+			MessageIDHeader: stableID + "-" + folderName,
+			Subject:         stableID,
+		}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+	}
+
+	// One thread only in Archive, one thread in both Archive and INBOX
+	saveMessage(t, "<archive-only>", "Archive", 1)
+	saveMessage(t, "<shared>", "Archive", 2)
+	saveMessage(t, "<shared>", "INBOX", 1)
+	if err := SetFolderSyncInfo(ctx, pool, userID, "Archive", nil); err != nil {
+		t.Fatalf("SetFolderSyncInfo failed: %v", err)
+	}
+
+	if err := ClearFolderCache(ctx, pool, userID, "Archive"); err != nil {
+		t.Fatalf("ClearFolderCache failed: %v", err)
+	}
+
+	t.Run("deletes threads that only had messages in the folder", func(t *testing.T) {
+		if _, err := GetThreadByStableID(ctx, pool, userID, "<archive-only>"); err == nil {
+			t.Error("Expected the Archive-only thread to be deleted")
+		}
+	})
+
+	t.Run("keeps threads with messages in other folders", func(t *testing.T) {
+		thread, err := GetThreadByStableID(ctx, pool, userID, "<shared>")
+		if err != nil {
+			t.Fatalf("Expected the shared thread to be kept: %v", err)
+		}
+		messages, err := GetMessagesForThread(ctx, pool, thread.ID)
+		if err != nil {
+			t.Fatalf("GetMessagesForThread failed: %v", err)
+		}
+		if len(messages) != 1 || messages[0].IMAPFolderName != "INBOX" {
+			t.Errorf("Expected only the INBOX message to be kept, got %d messages", len(messages))
+		}
+	})
+
+	t.Run("resets folder sync state", func(t *testing.T) {
+		info, err := GetFolderSyncInfo(ctx, pool, userID, "Archive")
+		if err != nil {
+			t.Fatalf("GetFolderSyncInfo failed: %v", err)
+		}
+		if info != nil {
+			t.Error("Expected folder sync info to be deleted")
+		}
+	})
+}
+
+func TestRenameCachedFolder(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "rename-cached-folder@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	saveMessage := func(t *testing.T, stableID, folderName string) {
+		t.Helper()
+		thread := &models.Thread{UserID: userID, StableThreadID: stableID, Subject: stableID}
+		if err := SaveThread(ctx, pool, thread); err != nil {
+			t.Fatalf("SaveThread failed: %v", err)
+		}
+		msg := &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         1,
+			IMAPFolderName:  folderName,
+			MessageIDHeader: stableID,
+			Subject:         stableID,
+		}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+	}
+
+	saveMessage(t, "<projects>", "Projects")
+	saveMessage(t, "<projects-old>", "Projects/Old")
+	saveMessage(t, "<projects2>", "Projects2")
+	if err := SetFolderSyncInfo(ctx, pool, userID, "Projects/Old", nil); err != nil {
+		t.Fatalf("SetFolderSyncInfo failed: %v", err)
+	}
+	if err := SaveFolderRoleOverride(ctx, pool, userID, "Projects", "archive"); err != nil {
+		t.Fatalf("SaveFolderRoleOverride failed: %v", err)
+	}
+
+	if err := RenameCachedFolder(ctx, pool, userID, "Projects", "Work", "/"); err != nil {
+		t.Fatalf("RenameCachedFolder failed: %v", err)
+	}
+
+	folderOf := func(t *testing.T, stableID string) string {
+		t.Helper()
+		thread, err := GetThreadByStableID(ctx, pool, userID, stableID)
+		if err != nil {
+			t.Fatalf("GetThreadByStableID failed: %v", err)
+		}
+		messages, err := GetMessagesForThread(ctx, pool, thread.ID)
+		if err != nil || len(messages) != 1 {
+			t.Fatalf("Expected one message, got %d (error: %v)", len(messages), err)
+		}
+		return messages[0].IMAPFolderName
+	}
+
+	t.Run("renames the folder and its subfolders", func(t *testing.T) {
+		if got := folderOf(t, "<projects>"); got != "Work" {
+			t.Errorf("Expected folder Work, got %s", got)
+		}
+		if got := folderOf(t, "<projects-old>"); got != "Work/Old" {
+			t.Errorf("Expected folder Work/Old, got %s", got)
+		}
+	})
+
+	t.Run("keeps folders that only share the prefix", func(t *testing.T) {
+		if got := folderOf(t, "<projects2>"); got != "Projects2" {
+			t.Errorf("Expected folder Projects2, got %s", got)
+		}
+	})
+
+	t.Run("moves the sync state and the role", func(t *testing.T) {
+		info, err := GetFolderSyncInfo(ctx, pool, userID, "Work/Old")
+		if err != nil {
+			t.Fatalf("GetFolderSyncInfo failed: %v", err)
+		}
+		if info == nil {
+			t.Error("Expected the sync info to move to Work/Old")
+		}
+		overrides, err := GetFolderRoleOverrides(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetFolderRoleOverrides failed: %v", err)
+		}
+		if overrides["Work"] != "archive" || overrides["Projects"] != "" {
+			t.Errorf("Expected the role to move to Work, got %v", overrides)
+		}
+	})
+}
+
+func TestDeleteCachedFolder(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "delete-cached-folder@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	thread := &models.Thread{UserID: userID, StableThreadID: "<receipts>", Subject: "Receipts"}
+	if err := SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+	msg := &models.Message{ThreadID: thread.ID, UserID: userID, IMAPUID: 1, IMAPFolderName: "Receipts", MessageIDHeader: "<receipts>"}
+	if err := SaveMessage(ctx, pool, msg); err != nil {
+		t.Fatalf("SaveMessage failed: %v", err)
+	}
+	if err := SaveFolderRoleOverride(ctx, pool, userID, "Receipts", "archive"); err != nil {
+		t.Fatalf("SaveFolderRoleOverride failed: %v", err)
+	}
+
+	if err := DeleteCachedFolder(ctx, pool, userID, "Receipts"); err != nil {
+		t.Fatalf("DeleteCachedFolder failed: %v", err)
+	}
+
+	if _, err := GetThreadByStableID(ctx, pool, userID, "<receipts>"); err == nil {
+		t.Error("Expected the folder's thread to be deleted")
+	}
+	overrides, err := GetFolderRoleOverrides(ctx, pool, userID)
+	if err != nil {
+		t.Fatalf("GetFolderRoleOverrides failed: %v", err)
+	}
+	if len(overrides) != 0 {
+		t.Errorf("Expected the folder's role to be deleted, got %v", overrides)
+	}
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// SaveAuthenticity saves the sender checks of a message, replacing the ones it had, for example, after the body was
+// fetched again.
+func SaveAuthenticity(ctx context.Context, pool *pgxpool.Pool, messageID string, authenticity *models.Authenticity) error {
+	_, err := pool.Exec(ctx, `
+		INSERT INTO message_authenticity (message_id, authserv_id, dkim, spf, dmarc, verified)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (message_id) DO UPDATE SET
+			authserv_id = EXCLUDED.authserv_id,
+			dkim = EXCLUDED.dkim,
+			spf = EXCLUDED.spf,
+			dmarc = EXCLUDED.dmarc,
+			verified = EXCLUDED.verified
+	`, messageID, authenticity.AuthServID, authenticity.DKIM, authenticity.SPF, authenticity.DMARC, authenticity.Verified)
+	if err != nil {
+		return fmt.Errorf("failed to save authenticity: %w", err)
+	}
+	return nil
+}
+
+// GetAuthenticityForMessages returns the sender checks of the messages that have them, by message ID.
+func GetAuthenticityForMessages(ctx context.Context, pool *pgxpool.Pool, messageIDs []string) (map[string]*models.Authenticity, error) {
+	authenticity := make(map[string]*models.Authenticity)
+	if len(messageIDs) == 0 {
+		return authenticity, nil
+	}
+
+	rows, err := pool.Query(ctx, `
+		SELECT message_id, authserv_id, dkim, spf, dmarc, verified
+		FROM message_authenticity
+		WHERE message_id = ANY($1)
+	`, messageIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get authenticity: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID string
+		var a models.Authenticity
+		if err := rows.Scan(&messageID, &a.AuthServID, &a.DKIM, &a.SPF, &a.DMARC, &a.Verified); err != nil {
+			return nil, fmt.Errorf("failed to scan authenticity: %w", err)
+		}
+		authenticity[messageID] = &a
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating authenticity: %w", err)
+	}
+
+	return authenticity, nil
+}
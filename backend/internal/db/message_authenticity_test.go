@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestMessageAuthenticity(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	userID, err := GetOrCreateUser(ctx, pool, "authenticity-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	thread := &models.Thread{UserID: userID, StableThreadID: "<authenticity@example.com>", Subject: "Checks"}
+	if err := SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+	var messageIDs []string
+	for uid := int64(1); uid <= 2; uid++ {
+		msg := &models.Message{ThreadID: thread.ID, UserID: userID, IMAPUID: uid, IMAPFolderName: "INBOX"}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+		messageIDs = append(messageIDs, msg.ID)
+	}
+
+	t.Run("saves and gets the checks of messages", func(t *testing.T) {
+		checks := &models.Authenticity{AuthServID: "mx.example.net", DKIM: "pass", SPF: "fail", DMARC: "pass", Verified: true}
+		if err := SaveAuthenticity(ctx, pool, messageIDs[0], checks); err != nil {
+			t.Fatalf("SaveAuthenticity failed: %v", err)
+		}
+
+		authenticity, err := GetAuthenticityForMessages(ctx, pool, messageIDs)
+		if err != nil {
+			t.Fatalf("GetAuthenticityForMessages failed: %v", err)
+		}
+		if len(authenticity) != 1 {
+			t.Fatalf("Expected 1 result, got %d", len(authenticity))
+		}
+		if got := authenticity[messageIDs[0]]; got == nil || *got != *checks {
+			t.Errorf("Expected %+v, got %+v", checks, got)
+		}
+	})
+
+	t.Run("replaces the checks of a message", func(t *testing.T) {
+		checks := &models.Authenticity{AuthServID: "mx.example.net", DMARC: "fail"}
+		if err := SaveAuthenticity(ctx, pool, messageIDs[0], checks); err != nil {
+			t.Fatalf("SaveAuthenticity failed: %v", err)
+		}
+
+		authenticity, err := GetAuthenticityForMessages(ctx, pool, messageIDs[:1])
+		if err != nil {
+			t.Fatalf("GetAuthenticityForMessages failed: %v", err)
+		}
+		if got := authenticity[messageIDs[0]]; *got != *checks {
+			t.Errorf("Expected %+v, got %+v", checks, got)
+		}
+	})
+}
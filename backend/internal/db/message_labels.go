@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// setServerLabels replaces the labels of a message with the keywords it has on the server.
+// Local labels stay, unless the server has them now, in which case they're no longer local.
+// Returns true if anything changed.
+func setServerLabels(ctx context.Context, pool *pgxpool.Pool, messageID string, labels []string) (bool, error) {
+	var changed int
+	err := pool.QueryRow(ctx, `
+		WITH removed AS (
+			DELETE FROM message_labels
+			WHERE message_id = $1 AND NOT local AND label <> ALL(coalesce($2::text[], '{}'))
+			RETURNING 1
+		), added AS (
+			INSERT INTO message_labels (message_id, label)
+			SELECT $1, unnest(coalesce($2::text[], '{}'))
+			ON CONFLICT (message_id, label) DO UPDATE SET local = FALSE WHERE message_labels.local
+			RETURNING 1
+		)
+		SELECT (SELECT COUNT(*) FROM removed) + (SELECT COUNT(*) FROM added)
+	`, messageID, labels).Scan(&changed)
+	if err != nil {
+		return false, fmt.Errorf("failed to save message labels: %w", err)
+	}
+	return changed > 0, nil
+}
+
+// AddMessageLabel adds the label to the messages. local means that the server doesn't keep the label,
+// so the sync must leave it alone.
+func AddMessageLabel(ctx context.Context, pool *pgxpool.Pool, messageIDs []string, label string, local bool) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+
+	_, err := pool.Exec(ctx, `
+		INSERT INTO message_labels (message_id, label, local)
+		SELECT unnest($1::uuid[]), $2, $3
+		ON CONFLICT (message_id, label) DO UPDATE SET local = EXCLUDED.local
+	`, messageIDs, label, local)
+	if err != nil {
+		return fmt.Errorf("failed to add message label: %w", err)
+	}
+	return nil
+}
+
+// RemoveMessageLabel removes the label from the messages.
+func RemoveMessageLabel(ctx context.Context, pool *pgxpool.Pool, messageIDs []string, label string) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+
+	_, err := pool.Exec(ctx, `
+		DELETE FROM message_labels
+		WHERE message_id = ANY($1::uuid[]) AND label = $2
+	`, messageIDs, label)
+	if err != nil {
+		return fmt.Errorf("failed to remove message label: %w", err)
+	}
+	return nil
+}
+
+// GetLabelsForMessages returns the sorted labels of the messages, keyed by message ID.
+// Messages without labels aren't in the map.
+func GetLabelsForMessages(ctx context.Context, pool *pgxpool.Pool, messageIDs []string) (map[string][]string, error) {
+	labels := make(map[string][]string)
+	if len(messageIDs) == 0 {
+		return labels, nil
+	}
+
+	rows, err := pool.Query(ctx, `
+		SELECT message_id, label
+		FROM message_labels
+		WHERE message_id = ANY($1::uuid[])
+		ORDER BY message_id, label
+	`, messageIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message labels: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID, label string
+		if err := rows.Scan(&messageID, &label); err != nil {
+			return nil, fmt.Errorf("failed to scan message label: %w", err)
+		}
+		labels[messageID] = append(labels[messageID], label)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating message labels: %w", err)
+	}
+
+	return labels, nil
+}
+
+// GetThreadLabels returns the sorted labels of all messages in the thread.
+func GetThreadLabels(ctx context.Context, pool *pgxpool.Pool, threadID string) ([]string, error) {
+	labels := []string{}
+	err := pool.QueryRow(ctx, `
+		SELECT coalesce(array_agg(DISTINCT ml.label ORDER BY ml.label), '{}')
+		FROM message_labels ml
+		INNER JOIN messages m ON ml.message_id = m.id
+		WHERE m.thread_id = $1
+	`, threadID).Scan(&labels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get thread labels: %w", err)
+	}
+	return labels, nil
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestMessageLabels(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "labels-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	thread := &models.Thread{UserID: userID, StableThreadID: "labels-thread", Subject: "Labels"}
+	if err := SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+	messages := make([]*models.Message, 2)
+	for i := range messages {
+		messages[i] = &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         int64(i + 1),
+			IMAPFolderName:  "INBOX",
+			MessageIDHeader: fmt.Sprintf("<labels-%d@example.com>", i),
+			Subject:         "Labels",
+		}
+		if i == 0 {
+			messages[i].Labels = []string{"$label1"}
+		}
+		if _, err := SaveMessageIfChanged(ctx, pool, messages[i]); err != nil {
+			t.Fatalf("SaveMessageIfChanged failed: %v", err)
+		}
+	}
+
+	// expectLabels checks the labels of each message
+	expectLabels := func(t *testing.T, expected ...[]string) {
+		t.Helper()
+		labels, err := GetLabelsForMessages(ctx, pool, []string{messages[0].ID, messages[1].ID})
+		if err != nil {
+			t.Fatalf("GetLabelsForMessages failed: %v", err)
+		}
+		for i, msg := range messages {
+			if !slices.Equal(labels[msg.ID], expected[i]) {
+				t.Errorf("Expected message %d to have labels %v, got %v", i, expected[i], labels[msg.ID])
+			}
+		}
+	}
+
+	t.Run("saves the labels of synced messages", func(t *testing.T) {
+		expectLabels(t, []string{"$label1"}, nil)
+	})
+
+	t.Run("keeps local labels through syncs", func(t *testing.T) {
+		if err := AddMessageLabel(ctx, pool, []string{messages[0].ID, messages[1].ID}, "work", true); err != nil {
+			t.Fatalf("AddMessageLabel failed: %v", err)
+		}
+		updated, err := UpdateMessageFlags(ctx, pool, userID, "INBOX", []MessageFlags{
+			{IMAPUID: 1, Labels: []string{"$label2"}},
+			{IMAPUID: 2},
+		})
+		if err != nil {
+			t.Fatalf("UpdateMessageFlags failed: %v", err)
+		}
+		if updated != 1 {
+			t.Errorf("Expected 1 updated message, got %d", updated)
+		}
+		expectLabels(t, []string{"$label2", "work"}, []string{"work"})
+	})
+
+	t.Run("returns the labels of the thread", func(t *testing.T) {
+		labels, err := GetThreadLabels(ctx, pool, thread.ID)
+		if err != nil {
+			t.Fatalf("GetThreadLabels failed: %v", err)
+		}
+		if !slices.Equal(labels, []string{"$label2", "work"}) {
+			t.Errorf("Expected [$label2 work], got %v", labels)
+		}
+
+		threads, err := GetThreadsForFolder(ctx, pool, userID, "INBOX", 10, 0)
+		if err != nil {
+			t.Fatalf("GetThreadsForFolder failed: %v", err)
+		}
+		if len(threads) != 1 || !slices.Equal(threads[0].Labels, []string{"$label2", "work"}) {
+			t.Errorf("Expected the thread list to have the labels, got %+v", threads)
+		}
+	})
+
+	t.Run("removes labels", func(t *testing.T) {
+		if err := RemoveMessageLabel(ctx, pool, []string{messages[0].ID, messages[1].ID}, "work"); err != nil {
+			t.Fatalf("RemoveMessageLabel failed: %v", err)
+		}
+		expectLabels(t, []string{"$label2"}, nil)
+	})
+}
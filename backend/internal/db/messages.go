@@ -2,8 +2,11 @@ package db
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -15,37 +18,91 @@ var ErrMessageNotFound = errors.New("message not found")
 
 // SaveMessage saves or updates a message in the database.
 func SaveMessage(ctx context.Context, pool *pgxpool.Pool, message *models.Message) error {
+	_, err := SaveMessageIfChanged(ctx, pool, message)
+	return err
+}
+
+// SaveMessageIfChanged saves a message, unless it's already saved with the same headers, flags, and body.
+// Returns false if it skipped the write, so that resyncs don't rewrite big bodies for nothing.
+// A message without a body keeps the body that's already saved, since it usually means that we only fetched headers.
+// Likewise, a message without a size keeps the size that's already saved.
+// Labels are only compared if the message has some or something else changed, so that resyncs stay cheap. The
+// flag updates of CONDSTORE syncs catch the labels that were removed on the server. See UpdateMessageFlags.
+func SaveMessageIfChanged(ctx context.Context, pool *pgxpool.Pool, message *models.Message) (bool, error) {
 	var id string
+	var written bool
+	plainHTML, compressedHTML := splitBodyHTML(message.UnsafeBodyHTML)
+	// If the upsert skips the update, it returns no row, so we get the ID of the existing row
 	err := pool.QueryRow(ctx, `
-		INSERT INTO messages (
-			thread_id,
-			user_id,
-			imap_uid,
-			imap_folder_name,
-			message_id_header,
-			from_address,
-			to_addresses,
-			cc_addresses,
-			sent_at,
-			subject,
-			unsafe_body_html,
-			body_text,
-			is_read,
-			is_starred
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
-		ON CONFLICT (user_id, imap_folder_name, imap_uid) DO UPDATE SET
-			thread_id = EXCLUDED.thread_id,
-			message_id_header = EXCLUDED.message_id_header,
-			from_address = EXCLUDED.from_address,
-			to_addresses = EXCLUDED.to_addresses,
-			cc_addresses = EXCLUDED.cc_addresses,
-			sent_at = EXCLUDED.sent_at,
-			subject = EXCLUDED.subject,
-			unsafe_body_html = COALESCE(EXCLUDED.unsafe_body_html, messages.unsafe_body_html),
-			body_text = COALESCE(EXCLUDED.body_text, messages.body_text),
-			is_read = EXCLUDED.is_read,
-			is_starred = EXCLUDED.is_starred
-		RETURNING id
+		WITH upsert AS (
+			INSERT INTO messages (
+				thread_id,
+				user_id,
+				imap_uid,
+				imap_folder_name,
+				message_id_header,
+				from_address,
+				to_addresses,
+				cc_addresses,
+				sent_at,
+				subject,
+				unsafe_body_html,
+				unsafe_body_html_zstd,
+				body_text,
+				snippet,
+				is_read,
+				is_starred,
+				content_hash,
+				size_bytes,
+				truncated,
+				referenced_message_ids
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $17, $12, $16, $13, $14, $15, $18, $19,
+			          COALESCE($20::text[], '{}'))
+			ON CONFLICT (user_id, imap_folder_name, imap_uid) DO UPDATE SET
+				thread_id = EXCLUDED.thread_id,
+				message_id_header = EXCLUDED.message_id_header,
+				from_address = EXCLUDED.from_address,
+				to_addresses = EXCLUDED.to_addresses,
+				cc_addresses = EXCLUDED.cc_addresses,
+				sent_at = EXCLUDED.sent_at,
+				subject = EXCLUDED.subject,
+				unsafe_body_html = CASE
+					WHEN EXCLUDED.content_hash IS NULL OR EXCLUDED.content_hash = messages.content_hash
+					THEN messages.unsafe_body_html ELSE EXCLUDED.unsafe_body_html END,
+				unsafe_body_html_zstd = CASE
+					WHEN EXCLUDED.content_hash IS NULL OR EXCLUDED.content_hash = messages.content_hash
+					THEN messages.unsafe_body_html_zstd ELSE EXCLUDED.unsafe_body_html_zstd END,
+				body_text = CASE
+					WHEN EXCLUDED.content_hash IS NULL OR EXCLUDED.content_hash = messages.content_hash
+					THEN messages.body_text ELSE EXCLUDED.body_text END,
+				snippet = CASE
+					WHEN EXCLUDED.content_hash IS NULL OR EXCLUDED.content_hash = messages.content_hash
+					THEN messages.snippet ELSE EXCLUDED.snippet END,
+				truncated = CASE
+					WHEN EXCLUDED.content_hash IS NULL OR EXCLUDED.content_hash = messages.content_hash
+					THEN messages.truncated ELSE EXCLUDED.truncated END,
+				is_read = EXCLUDED.is_read,
+				is_starred = EXCLUDED.is_starred,
+				content_hash = COALESCE(EXCLUDED.content_hash, messages.content_hash),
+				size_bytes = COALESCE(NULLIF(EXCLUDED.size_bytes, 0), messages.size_bytes),
+				referenced_message_ids = CASE
+					WHEN cardinality(EXCLUDED.referenced_message_ids) = 0
+					THEN messages.referenced_message_ids ELSE EXCLUDED.referenced_message_ids END
+			WHERE (messages.thread_id, messages.message_id_header, messages.from_address, messages.to_addresses,
+				   messages.cc_addresses, messages.sent_at, messages.subject, messages.is_read, messages.is_starred)
+				IS DISTINCT FROM (EXCLUDED.thread_id, EXCLUDED.message_id_header, EXCLUDED.from_address, EXCLUDED.to_addresses,
+				   EXCLUDED.cc_addresses, EXCLUDED.sent_at, EXCLUDED.subject, EXCLUDED.is_read, EXCLUDED.is_starred)
+			   OR (EXCLUDED.content_hash IS NOT NULL AND EXCLUDED.content_hash IS DISTINCT FROM messages.content_hash)
+			   OR (EXCLUDED.size_bytes <> 0 AND EXCLUDED.size_bytes <> messages.size_bytes)
+			   OR (cardinality(EXCLUDED.referenced_message_ids) > 0
+				   AND EXCLUDED.referenced_message_ids <> messages.referenced_message_ids)
+			RETURNING id
+		)
+		SELECT id, true FROM upsert
+		UNION ALL
+		SELECT id, false FROM messages
+		WHERE user_id = $2 AND imap_folder_name = $4 AND imap_uid = $3
+		  AND NOT EXISTS (SELECT 1 FROM upsert)
     `,
 		message.ThreadID,
 		message.UserID,
@@ -57,46 +114,97 @@ func SaveMessage(ctx context.Context, pool *pgxpool.Pool, message *models.Messag
 		message.CCAddresses,
 		message.SentAt,
 		message.Subject,
-		message.UnsafeBodyHTML,
+		plainHTML,
 		message.BodyText,
 		message.IsRead,
 		message.IsStarred,
-	).Scan(&id)
+		messageContentHash(message),
+		messageSnippet(message),
+		compressedHTML,
+		message.SizeBytes,
+		message.Truncated,
+		message.ReferencedMessageIDs,
+	).Scan(&id, &written)
 
 	if err != nil {
-		return fmt.Errorf("failed to save message: %w", err)
+		return false, fmt.Errorf("failed to save message: %w", err)
 	}
 
-	// Populate the ID if we inserted a new row
+	// Populate the ID, which is new if we inserted a row
 	if id != "" {
 		message.ID = id
 	}
 
-	return nil
+	if written || len(message.Labels) > 0 {
+		labelsChanged, err := setServerLabels(ctx, pool, message.ID, message.Labels)
+		if err != nil {
+			return written, err
+		}
+		if !written {
+			return labelsChanged, nil
+		}
+	}
+
+	if !written {
+		return false, nil
+	}
+
+	// The message may be new to the folder or may have moved to another thread
+	if err := MarkThreadCountDirty(ctx, pool, message.UserID, message.IMAPFolderName); err != nil {
+		return true, err
+	}
+
+	return true, nil
+}
+
+// messageSnippet returns the snippet of the message, or nil if it has no body, since then we don't know it yet.
+func messageSnippet(message *models.Message) *string {
+	if message.UnsafeBodyHTML == "" && message.BodyText == "" {
+		return nil
+	}
+	return &message.Snippet
 }
 
+// messageContentHash returns the SHA-256 of the message's body, or nil if it has no body.
+func messageContentHash(message *models.Message) []byte {
+	if message.UnsafeBodyHTML == "" && message.BodyText == "" {
+		return nil
+	}
+	hash := sha256.New()
+	// Prefix the HTML with its length, so that moving text between the two parts changes the hash
+	_ = binary.Write(hash, binary.BigEndian, uint64(len(message.UnsafeBodyHTML)))
+	hash.Write([]byte(message.UnsafeBodyHTML))
+	hash.Write([]byte(message.BodyText))
+	return hash.Sum(nil)
+}
+
+// threadMessageColumns are the columns that scanThreadMessages reads.
+const threadMessageColumns = `
+	id,
+	thread_id,
+	user_id,
+	imap_uid,
+	imap_folder_name,
+	message_id_header,
+	from_address,
+	to_addresses,
+	cc_addresses,
+	sent_at,
+	subject,
+	unsafe_body_html,
+	unsafe_body_html_zstd,
+	body_text,
+	is_read,
+	is_starred,
+	truncated`
+
 // GetMessagesForThread returns all messages for a thread.
 func GetMessagesForThread(ctx context.Context, pool *pgxpool.Pool, threadID string) ([]*models.Message, error) {
 	rows, err := pool.Query(ctx, `
-		SELECT 
-			id,
-			thread_id,
-			user_id,
-			imap_uid,
-			imap_folder_name,
-			message_id_header,
-			from_address,
-			to_addresses,
-			cc_addresses,
-			sent_at,
-			subject,
-			unsafe_body_html,
-			body_text,
-			is_read,
-			is_starred
+		SELECT`+threadMessageColumns+`
 		FROM messages
 		WHERE thread_id = $1
-		ORDER BY sent_at NULLS LAST
+		ORDER BY sent_at NULLS LAST, id
 	`, threadID)
 
 	if err != nil {
@@ -104,9 +212,16 @@ func GetMessagesForThread(ctx context.Context, pool *pgxpool.Pool, threadID stri
 	}
 	defer rows.Close()
 
+	return scanThreadMessages(rows)
+}
+
+// scanThreadMessages reads the messages of a query that selects threadMessageColumns.
+func scanThreadMessages(rows pgx.Rows) ([]*models.Message, error) {
 	var messages []*models.Message
 	for rows.Next() {
 		var msg models.Message
+		var plainHTML *string
+		var compressedHTML []byte
 		if err := rows.Scan(
 			&msg.ID,
 			&msg.ThreadID,
@@ -119,13 +234,20 @@ func GetMessagesForThread(ctx context.Context, pool *pgxpool.Pool, threadID stri
 			&msg.CCAddresses,
 			&msg.SentAt,
 			&msg.Subject,
-			&msg.UnsafeBodyHTML,
+			&plainHTML,
+			&compressedHTML,
 			&msg.BodyText,
 			&msg.IsRead,
 			&msg.IsStarred,
+			&msg.Truncated,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan message: %w", err)
 		}
+		var err error
+		if msg.UnsafeBodyHTML, err = joinBodyHTML(plainHTML, compressedHTML); err != nil {
+			return nil, err
+		}
+		msg.BodyCached = msg.UnsafeBodyHTML != "" || msg.BodyText != ""
 		messages = append(messages, &msg)
 	}
 
@@ -139,6 +261,8 @@ func GetMessagesForThread(ctx context.Context, pool *pgxpool.Pool, threadID stri
 // GetMessageByMessageID returns a message by its Message-ID header.
 func GetMessageByMessageID(ctx context.Context, pool *pgxpool.Pool, userID, messageID string) (*models.Message, error) {
 	var msg models.Message
+	var plainHTML *string
+	var compressedHTML []byte
 	err := pool.QueryRow(ctx, `
 		SELECT 
 			id,
@@ -153,9 +277,11 @@ func GetMessageByMessageID(ctx context.Context, pool *pgxpool.Pool, userID, mess
 			sent_at,
 			subject,
 			unsafe_body_html,
+			unsafe_body_html_zstd,
 			body_text,
 			is_read,
-			is_starred
+			is_starred,
+			truncated
 		FROM messages
 		WHERE user_id = $1 AND message_id_header = $2
 		LIMIT 1
@@ -171,10 +297,12 @@ func GetMessageByMessageID(ctx context.Context, pool *pgxpool.Pool, userID, mess
 		&msg.CCAddresses,
 		&msg.SentAt,
 		&msg.Subject,
-		&msg.UnsafeBodyHTML,
+		&plainHTML,
+		&compressedHTML,
 		&msg.BodyText,
 		&msg.IsRead,
 		&msg.IsStarred,
+		&msg.Truncated,
 	)
 
 	if errors.Is(err, pgx.ErrNoRows) {
@@ -185,12 +313,19 @@ func GetMessageByMessageID(ctx context.Context, pool *pgxpool.Pool, userID, mess
 		return nil, fmt.Errorf("failed to get message by Message-ID: %w", err)
 	}
 
+	if msg.UnsafeBodyHTML, err = joinBodyHTML(plainHTML, compressedHTML); err != nil {
+		return nil, err
+	}
+	msg.BodyCached = msg.UnsafeBodyHTML != "" || msg.BodyText != ""
+
 	return &msg, nil
 }
 
 // GetMessageByUID returns a message by its IMAP UID and folder.
 func GetMessageByUID(ctx context.Context, pool *pgxpool.Pool, userID, folderName string, imapUID int64) (*models.Message, error) {
 	var msg models.Message
+	var plainHTML *string
+	var compressedHTML []byte
 
 	err := pool.QueryRow(ctx, `
 		SELECT 
@@ -206,9 +341,11 @@ func GetMessageByUID(ctx context.Context, pool *pgxpool.Pool, userID, folderName
 			sent_at,
 			subject,
 			unsafe_body_html,
+			unsafe_body_html_zstd,
 			body_text,
 			is_read,
-			is_starred
+			is_starred,
+			truncated
 		FROM messages
 		WHERE user_id = $1 AND imap_folder_name = $2 AND imap_uid = $3
 	`, userID, folderName, imapUID).Scan(
@@ -223,10 +360,12 @@ func GetMessageByUID(ctx context.Context, pool *pgxpool.Pool, userID, folderName
 		&msg.CCAddresses,
 		&msg.SentAt,
 		&msg.Subject,
-		&msg.UnsafeBodyHTML,
+		&plainHTML,
+		&compressedHTML,
 		&msg.BodyText,
 		&msg.IsRead,
 		&msg.IsStarred,
+		&msg.Truncated,
 	)
 
 	if errors.Is(err, pgx.ErrNoRows) {
@@ -237,9 +376,233 @@ func GetMessageByUID(ctx context.Context, pool *pgxpool.Pool, userID, folderName
 		return nil, fmt.Errorf("failed to get message: %w", err)
 	}
 
+	if msg.UnsafeBodyHTML, err = joinBodyHTML(plainHTML, compressedHTML); err != nil {
+		return nil, err
+	}
+	msg.BodyCached = msg.UnsafeBodyHTML != "" || msg.BodyText != ""
+
 	return &msg, nil
 }
 
+// GetMessageLocation returns the IMAP folder and UID of the user's cached message with the ID.
+// Returns ErrMessageNotFound if the user has no such message.
+func GetMessageLocation(ctx context.Context, pool *pgxpool.Pool, userID, messageID string) (string, int64, error) {
+	var folderName string
+	var imapUID int64
+	err := pool.QueryRow(ctx, `
+		SELECT imap_folder_name, imap_uid
+		FROM messages
+		WHERE user_id = $1 AND id = $2
+	`, userID, messageID).Scan(&folderName, &imapUID)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", 0, ErrMessageNotFound
+	}
+
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get message location: %w", err)
+	}
+
+	return folderName, imapUID, nil
+}
+
+// MessageLocation is where a cached message is on the IMAP server, with what an mbox "From " line needs.
+type MessageLocation struct {
+	ID          string
+	FolderName  string
+	IMAPUID     int64
+	FromAddress string
+	SentAt      *time.Time
+}
+
+// GetThreadMessageLocations returns where the messages of a thread are on the IMAP server, sorted by date.
+// A message that's in more than one folder, like a sent reply that's also in the inbox, is only returned once.
+func GetThreadMessageLocations(ctx context.Context, pool *pgxpool.Pool, threadID string) ([]MessageLocation, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT id, imap_folder_name, imap_uid, from_address, sent_at
+		FROM (
+			SELECT DISTINCT ON (COALESCE(NULLIF(message_id_header, ''), id::text))
+				id, imap_folder_name, imap_uid, from_address, sent_at
+			FROM messages
+			WHERE thread_id = $1
+			ORDER BY COALESCE(NULLIF(message_id_header, ''), id::text), imap_folder_name
+		) m
+		ORDER BY sent_at NULLS LAST, id
+	`, threadID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message locations: %w", err)
+	}
+	defer rows.Close()
+
+	locations := []MessageLocation{}
+	for rows.Next() {
+		var location MessageLocation
+		if err := rows.Scan(&location.ID, &location.FolderName, &location.IMAPUID, &location.FromAddress,
+			&location.SentAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message location: %w", err)
+		}
+		locations = append(locations, location)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating message locations: %w", err)
+	}
+
+	return locations, nil
+}
+
+// MessageMove is a cached message that was moved to another IMAP folder.
+type MessageMove struct {
+	MessageID  string // The ID of the message in the messages table
+	FromFolder string
+	ToFolder   string
+	// NewIMAPUID is the UID in the new folder. If it's 0, we remove the message from the cache,
+	// and the next sync of the new folder caches it again.
+	NewIMAPUID int64
+}
+
+// MoveMessages updates the folders and UIDs of cached messages after they were moved on the IMAP server.
+// If a sync already cached a moved message in its new folder, the old row takes its place, so it's not duplicated.
+func MoveMessages(ctx context.Context, pool *pgxpool.Pool, userID string, moves []MessageMove) error {
+	if len(moves) == 0 {
+		return nil
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	folders := make([]string, 0, len(moves)*2)
+	for _, move := range moves {
+		if move.NewIMAPUID == 0 {
+			if _, err := tx.Exec(ctx, `DELETE FROM messages WHERE id = $1 AND user_id = $2`, move.MessageID, userID); err != nil {
+				return fmt.Errorf("failed to delete moved message: %w", err)
+			}
+		} else {
+			_, err := tx.Exec(ctx, `
+				DELETE FROM messages
+				WHERE user_id = $1 AND imap_folder_name = $2 AND imap_uid = $3 AND id <> $4
+			`, userID, move.ToFolder, move.NewIMAPUID, move.MessageID)
+			if err != nil {
+				return fmt.Errorf("failed to delete duplicate of moved message: %w", err)
+			}
+			_, err = tx.Exec(ctx, `
+				UPDATE messages SET imap_folder_name = $3, imap_uid = $4
+				WHERE id = $1 AND user_id = $2
+			`, move.MessageID, userID, move.ToFolder, move.NewIMAPUID)
+			if err != nil {
+				return fmt.Errorf("failed to update moved message: %w", err)
+			}
+		}
+		folders = append(folders, move.FromFolder, move.ToFolder)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return MarkThreadCountDirty(ctx, pool, userID, folders...)
+}
+
+// MessageFlags are the flags of a cached message that we keep in sync with the server.
+type MessageFlags struct {
+	IMAPUID   int64
+	IsRead    bool
+	IsStarred bool
+	// Labels are all custom keywords of the message. They replace its labels, except the local ones.
+	Labels []string
+}
+
+// UpdateMessageFlags updates the read and starred flags and the labels of cached messages in the folder.
+// Messages we haven't cached are skipped, since the sync that caches them saves their flags too.
+// Returns how many messages changed.
+func UpdateMessageFlags(ctx context.Context, pool *pgxpool.Pool, userID, folderName string, flags []MessageFlags) (int, error) {
+	if len(flags) == 0 {
+		return 0, nil
+	}
+
+	uids := make([]int64, len(flags))
+	isRead := make([]bool, len(flags))
+	isStarred := make([]bool, len(flags))
+	// The labels are flattened into pairs, since Postgres arrays can't have rows of different lengths
+	var labelUIDs []int64
+	var labels []string
+	for i, f := range flags {
+		uids[i] = f.IMAPUID
+		isRead[i] = f.IsRead
+		isStarred[i] = f.IsStarred
+		for _, label := range f.Labels {
+			labelUIDs = append(labelUIDs, f.IMAPUID)
+			labels = append(labels, label)
+		}
+	}
+
+	var updated int
+	err := pool.QueryRow(ctx, `
+		WITH flag_updates AS (
+			UPDATE messages m SET is_read = f.is_read, is_starred = f.is_starred
+			FROM unnest($3::bigint[], $4::bool[], $5::bool[]) AS f(imap_uid, is_read, is_starred)
+			WHERE m.user_id = $1 AND m.imap_folder_name = $2 AND m.imap_uid = f.imap_uid
+				AND (m.is_read <> f.is_read OR m.is_starred <> f.is_starred)
+			RETURNING m.id
+		), targets AS (
+			SELECT id, imap_uid FROM messages
+			WHERE user_id = $1 AND imap_folder_name = $2 AND imap_uid = ANY($3::bigint[])
+		), wanted AS (
+			SELECT t.id AS message_id, l.label
+			FROM unnest(coalesce($6::bigint[], '{}'), coalesce($7::text[], '{}')) AS l(imap_uid, label)
+			INNER JOIN targets t ON t.imap_uid = l.imap_uid
+		), removed AS (
+			DELETE FROM message_labels ml
+			USING targets t
+			WHERE ml.message_id = t.id AND NOT ml.local
+				AND NOT EXISTS (SELECT 1 FROM wanted w WHERE w.message_id = ml.message_id AND w.label = ml.label)
+			RETURNING ml.message_id
+		), added AS (
+			INSERT INTO message_labels (message_id, label)
+			SELECT message_id, label FROM wanted
+			ON CONFLICT (message_id, label) DO UPDATE SET local = FALSE WHERE message_labels.local
+			RETURNING message_id
+		)
+		SELECT COUNT(DISTINCT id) FROM (
+			SELECT id FROM flag_updates
+			UNION ALL SELECT message_id FROM removed
+			UNION ALL SELECT message_id FROM added
+		) changed
+	`, userID, folderName, uids, isRead, isStarred, labelUIDs, labels).Scan(&updated)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update message flags: %w", err)
+	}
+
+	return updated, nil
+}
+
+// DeleteMessagesByUID removes messages from the cache after they were expunged on the server.
+// Returns how many messages it removed.
+func DeleteMessagesByUID(ctx context.Context, pool *pgxpool.Pool, userID, folderName string, uids []int64) (int, error) {
+	if len(uids) == 0 {
+		return 0, nil
+	}
+
+	result, err := pool.Exec(ctx, `
+		DELETE FROM messages
+		WHERE user_id = $1 AND imap_folder_name = $2 AND imap_uid = ANY($3)
+	`, userID, folderName, uids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expunged messages: %w", err)
+	}
+
+	deleted := int(result.RowsAffected())
+	if deleted > 0 {
+		if err := MarkThreadCountDirty(ctx, pool, userID, folderName); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
 // SaveAttachment saves an attachment to the database.
 func SaveAttachment(ctx context.Context, pool *pgxpool.Pool, attachment *models.Attachment) error {
 	var attachmentID string
@@ -258,6 +621,22 @@ func SaveAttachment(ctx context.Context, pool *pgxpool.Pool, attachment *models.
 	return nil
 }
 
+// GetMessageReferences returns the Message-IDs from the References and In-Reply-To headers of a message,
+// oldest first. See models.Message.ReferencedMessageIDs.
+func GetMessageReferences(ctx context.Context, pool *pgxpool.Pool, messageID string) ([]string, error) {
+	var references []string
+	err := pool.QueryRow(ctx, `
+		SELECT referenced_message_ids FROM messages WHERE id = $1
+	`, messageID).Scan(&references)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message references: %w", err)
+	}
+	return references, nil
+}
+
 // GetAttachmentsForMessage returns all attachments for a message.
 func GetAttachmentsForMessage(ctx context.Context, pool *pgxpool.Pool, messageID string) ([]*models.Attachment, error) {
 	rows, err := pool.Query(ctx, `
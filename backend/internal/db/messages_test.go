@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
 	"time"
 
@@ -117,6 +118,121 @@ func TestSaveAndGetMessage(t *testing.T) {
 	})
 }
 
+func TestSaveMessageIfChanged(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "content-hash-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	thread := &models.Thread{UserID: userID, StableThreadID: "content-hash-thread", Subject: "Hash"}
+	if err := SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+
+	now := time.Now()
+	newMessage := func() *models.Message {
+		return &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         1,
+			IMAPFolderName:  "INBOX",
+			MessageIDHeader: "<hash@example.com>",
+			FromAddress:     "sender@example.com",
+			ToAddresses:     []string{"recipient@example.com"},
+			Subject:         "Hash",
+			SentAt:          &now,
+			UnsafeBodyHTML:  "<p>Hello</p>",
+			BodyText:        "Hello",
+		}
+	}
+	save := func(t *testing.T, msg *models.Message) bool {
+		t.Helper()
+		written, err := SaveMessageIfChanged(ctx, pool, msg)
+		if err != nil {
+			t.Fatalf("SaveMessageIfChanged failed: %v", err)
+		}
+		if msg.ID == "" {
+			t.Error("Expected the message ID to be set")
+		}
+		return written
+	}
+
+	t.Run("writes new messages and skips unchanged ones", func(t *testing.T) {
+		if !save(t, newMessage()) {
+			t.Error("Expected a new message to be written")
+		}
+		if save(t, newMessage()) {
+			t.Error("Expected an unchanged message to be skipped")
+		}
+	})
+
+	t.Run("writes changed flags", func(t *testing.T) {
+		msg := newMessage()
+		msg.IsRead = true
+		if !save(t, msg) {
+			t.Error("Expected a changed message to be written")
+		}
+	})
+
+	t.Run("keeps the body when saving headers only", func(t *testing.T) {
+		msg := newMessage()
+		msg.IsRead = true
+		msg.UnsafeBodyHTML = ""
+		msg.BodyText = ""
+		if save(t, msg) {
+			t.Error("Expected a headers-only save of an unchanged message to be skipped")
+		}
+
+		msg.IsStarred = true
+		if !save(t, msg) {
+			t.Error("Expected a changed message to be written")
+		}
+		retrieved, err := GetMessageByUID(ctx, pool, userID, "INBOX", 1)
+		if err != nil {
+			t.Fatalf("GetMessageByUID failed: %v", err)
+		}
+		if retrieved.BodyText != "Hello" || retrieved.UnsafeBodyHTML != "<p>Hello</p>" {
+			t.Errorf("Expected the body to be kept, got %q and %q", retrieved.BodyText, retrieved.UnsafeBodyHTML)
+		}
+	})
+
+	t.Run("writes changed bodies", func(t *testing.T) {
+		msg := newMessage()
+		msg.IsRead = true
+		msg.IsStarred = true
+		msg.BodyText = "Hello again"
+		if !save(t, msg) {
+			t.Error("Expected a changed body to be written")
+		}
+		retrieved, err := GetMessageByUID(ctx, pool, userID, "INBOX", 1)
+		if err != nil {
+			t.Fatalf("GetMessageByUID failed: %v", err)
+		}
+		if retrieved.BodyText != "Hello again" {
+			t.Errorf("Expected the new body, got %q", retrieved.BodyText)
+		}
+	})
+}
+
+func TestMessageContentHash(t *testing.T) {
+	if hash := messageContentHash(&models.Message{}); hash != nil {
+		t.Errorf("Expected no hash without a body, got %x", hash)
+	}
+
+	a := messageContentHash(&models.Message{UnsafeBodyHTML: "ab", BodyText: "c"})
+	b := messageContentHash(&models.Message{UnsafeBodyHTML: "a", BodyText: "bc"})
+	if string(a) == string(b) {
+		t.Error("Expected moving text between the HTML and text bodies to change the hash")
+	}
+	if again := messageContentHash(&models.Message{UnsafeBodyHTML: "ab", BodyText: "c"}); string(a) != string(again) {
+		t.Error("Expected the same body to have the same hash")
+	}
+}
+
 func TestGetMessagesForThread(t *testing.T) {
 	pool := testutil.NewTestDB(t)
 	defer pool.Close()
@@ -178,6 +294,175 @@ func TestGetMessagesForThread(t *testing.T) {
 			t.Errorf("Expected 2 messages, got %d", len(messages))
 		}
 	})
+
+	t.Run("returns the locations of the messages once each", func(t *testing.T) {
+		sentCopy := *msg2
+		sentCopy.IMAPFolderName = "Sent"
+		sentCopy.IMAPUID = 7
+		if err := SaveMessage(ctx, pool, &sentCopy); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+
+		locations, err := GetThreadMessageLocations(ctx, pool, thread.ID)
+		if err != nil {
+			t.Fatalf("GetThreadMessageLocations failed: %v", err)
+		}
+		if len(locations) != 2 {
+			t.Fatalf("Expected 2 locations, got %+v", locations)
+		}
+		for _, location := range locations {
+			if location.FolderName != "INBOX" {
+				t.Errorf("Expected the INBOX copy of each message, got %+v", location)
+			}
+		}
+	})
+}
+
+func TestMoveMessages(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "move-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	thread := &models.Thread{UserID: userID, StableThreadID: "move-thread", Subject: "Moving"}
+	if err := SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+
+	saveMessage := func(t *testing.T, folder string, uid int64, messageID string) *models.Message {
+		t.Helper()
+		msg := &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         uid,
+			IMAPFolderName:  folder,
+			MessageIDHeader: messageID,
+			Subject:         "Moving",
+		}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+		return msg
+	}
+
+	t.Run("updates the folder and UID", func(t *testing.T) {
+		msg := saveMessage(t, "INBOX", 1, "<move-1@example.com>")
+
+		err := MoveMessages(ctx, pool, userID, []MessageMove{{MessageID: msg.ID, FromFolder: "INBOX", ToFolder: "Archive", NewIMAPUID: 7}})
+		if err != nil {
+			t.Fatalf("MoveMessages failed: %v", err)
+		}
+
+		moved, err := GetMessageByUID(ctx, pool, userID, "Archive", 7)
+		if err != nil {
+			t.Fatalf("Expected the message in Archive: %v", err)
+		}
+		if moved.ID != msg.ID {
+			t.Errorf("Expected the same message %s, got %s", msg.ID, moved.ID)
+		}
+		if _, err := GetMessageByUID(ctx, pool, userID, "INBOX", 1); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("Expected the message to be gone from INBOX, got %v", err)
+		}
+	})
+
+	t.Run("replaces a copy that a sync already cached", func(t *testing.T) {
+		msg := saveMessage(t, "INBOX", 2, "<move-2@example.com>")
+		saveMessage(t, "Trash", 3, "<move-2@example.com>")
+
+		err := MoveMessages(ctx, pool, userID, []MessageMove{{MessageID: msg.ID, FromFolder: "INBOX", ToFolder: "Trash", NewIMAPUID: 3}})
+		if err != nil {
+			t.Fatalf("MoveMessages failed: %v", err)
+		}
+
+		moved, err := GetMessageByUID(ctx, pool, userID, "Trash", 3)
+		if err != nil || moved.ID != msg.ID {
+			t.Errorf("Expected the moved message %s in Trash, got %+v, %v", msg.ID, moved, err)
+		}
+	})
+
+	t.Run("removes messages with unknown new UIDs", func(t *testing.T) {
+		msg := saveMessage(t, "INBOX", 4, "<move-4@example.com>")
+
+		err := MoveMessages(ctx, pool, userID, []MessageMove{{MessageID: msg.ID, FromFolder: "INBOX", ToFolder: "Archive"}})
+		if err != nil {
+			t.Fatalf("MoveMessages failed: %v", err)
+		}
+
+		if _, err := GetMessageByUID(ctx, pool, userID, "INBOX", 4); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("Expected the message to be removed, got %v", err)
+		}
+	})
+}
+
+func TestUpdateMessageFlagsAndDeleteMessagesByUID(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "flags-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	thread := &models.Thread{UserID: userID, StableThreadID: "flags-thread", Subject: "Flags"}
+	if err := SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+	for uid := int64(1); uid <= 3; uid++ {
+		msg := &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         uid,
+			IMAPFolderName:  "INBOX",
+			MessageIDHeader: fmt.Sprintf("<flags-%d@example.com>", uid),
+			Subject:         "Flags",
+		}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+	}
+
+	t.Run("updates only the changed messages", func(t *testing.T) {
+		updated, err := UpdateMessageFlags(ctx, pool, userID, "INBOX", []MessageFlags{
+			{IMAPUID: 1, IsRead: true},
+			{IMAPUID: 2},
+			{IMAPUID: 99, IsStarred: true},
+		})
+		if err != nil {
+			t.Fatalf("UpdateMessageFlags failed: %v", err)
+		}
+		if updated != 1 {
+			t.Errorf("Expected 1 updated message, got %d", updated)
+		}
+
+		msg, err := GetMessageByUID(ctx, pool, userID, "INBOX", 1)
+		if err != nil {
+			t.Fatalf("GetMessageByUID failed: %v", err)
+		}
+		if !msg.IsRead || msg.IsStarred {
+			t.Errorf("Expected the message to be read and not starred, got read: %v, starred: %v", msg.IsRead, msg.IsStarred)
+		}
+	})
+
+	t.Run("deletes expunged messages", func(t *testing.T) {
+		deleted, err := DeleteMessagesByUID(ctx, pool, userID, "INBOX", []int64{2, 3, 99})
+		if err != nil {
+			t.Fatalf("DeleteMessagesByUID failed: %v", err)
+		}
+		if deleted != 2 {
+			t.Errorf("Expected 2 deleted messages, got %d", deleted)
+		}
+		if _, err := GetMessageByUID(ctx, pool, userID, "INBOX", 2); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("Expected the message to be gone, got %v", err)
+		}
+		if _, err := GetMessageByUID(ctx, pool, userID, "INBOX", 1); err != nil {
+			t.Errorf("Expected the other message to stay, got %v", err)
+		}
+	})
 }
 
 func TestSaveAndGetAttachment(t *testing.T) {
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// GetUnreadNewMail returns the unread ones of the user's cached messages with the UIDs in the folder, with their
+// threads, oldest first. Messages that the sync marked as read, for example, because the user read them in another
+// client, are left out.
+func GetUnreadNewMail(ctx context.Context, pool *pgxpool.Pool, userID, folderName string, uids []int64) ([]models.NewMailMessage, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT t.stable_thread_id, COALESCE(m.from_address, ''), COALESCE(m.subject, ''), t.importance_score
+		FROM messages m
+		JOIN threads t ON t.id = m.thread_id
+		WHERE m.user_id = $1 AND m.imap_folder_name = $2 AND m.imap_uid = ANY($3) AND NOT m.is_read
+		ORDER BY m.sent_at NULLS LAST, m.imap_uid
+	`, userID, folderName, uids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get new mail: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []models.NewMailMessage
+	for rows.Next() {
+		var message models.NewMailMessage
+		if err := rows.Scan(&message.StableThreadID, &message.FromAddress, &message.Subject, &message.ImportanceScore); err != nil {
+			return nil, fmt.Errorf("failed to scan new mail: %w", err)
+		}
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating new mail: %w", err)
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestGetUnreadNewMail(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	userID, err := GetOrCreateUser(ctx, pool, "new-mail-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	thread := &models.Thread{UserID: userID, StableThreadID: "<new-mail@example.com>", Subject: "Lunch"}
+	if err := SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+	for _, msg := range []*models.Message{
+		{IMAPUID: 1, IMAPFolderName: "INBOX", FromAddress: "Alice <alice@example.com>", Subject: "Lunch?"},
+		{IMAPUID: 2, IMAPFolderName: "INBOX", FromAddress: "bob@example.com", Subject: "Re: Lunch?", IsRead: true},
+		{IMAPUID: 3, IMAPFolderName: "INBOX", FromAddress: "carol@example.com", Subject: "Re: Lunch?"},
+		{IMAPUID: 1, IMAPFolderName: "Archive", FromAddress: "dave@example.com", Subject: "Re: Lunch?"},
+	} {
+		msg.ThreadID = thread.ID
+		msg.UserID = userID
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+	}
+
+	messages, err := GetUnreadNewMail(ctx, pool, userID, "INBOX", []int64{1, 2})
+	if err != nil {
+		t.Fatalf("GetUnreadNewMail failed: %v", err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 unread message, got %+v", messages)
+	}
+	if got := messages[0]; got.StableThreadID != thread.StableThreadID || got.FromAddress != "Alice <alice@example.com>" || got.Subject != "Lunch?" {
+		t.Errorf("Unexpected message: %+v", got)
+	}
+}
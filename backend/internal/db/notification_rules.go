@@ -0,0 +1,172 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// ErrNotificationRuleNotFound is returned when a notification rule doesn't exist or belongs to another user.
+var ErrNotificationRuleNotFound = errors.New("notification rule not found")
+
+// notificationRuleColumns are the columns that scanNotificationRule reads, in order.
+const notificationRuleColumns = `
+	id,
+	user_id,
+	name,
+	action,
+	sender,
+	subject,
+	folder,
+	hours_start,
+	hours_end,
+	time_zone,
+	created_at,
+	updated_at
+`
+
+func scanNotificationRule(row pgx.Row) (*models.NotificationRule, error) {
+	var rule models.NotificationRule
+	var hoursStart, hoursEnd, timeZone *string
+	err := row.Scan(
+		&rule.ID,
+		&rule.UserID,
+		&rule.Name,
+		&rule.Action,
+		&rule.From,
+		&rule.Subject,
+		&rule.Folder,
+		&hoursStart,
+		&hoursEnd,
+		&timeZone,
+		&rule.CreatedAt,
+		&rule.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if hoursStart != nil && hoursEnd != nil && timeZone != nil {
+		rule.Hours = &models.NotificationRuleHours{Start: *hoursStart, End: *hoursEnd, TimeZone: *timeZone}
+	}
+	return &rule, nil
+}
+
+// notificationRuleHoursArgs returns the start, end, and time zone of the rule's hours, or NULLs if it has none.
+func notificationRuleHoursArgs(rule *models.NotificationRule) (*string, *string, *string) {
+	if rule.Hours == nil {
+		return nil, nil, nil
+	}
+	return &rule.Hours.Start, &rule.Hours.End, &rule.Hours.TimeZone
+}
+
+// GetNotificationRules returns all notification rules of the user, the oldest first.
+func GetNotificationRules(ctx context.Context, pool *pgxpool.Pool, userID string) ([]*models.NotificationRule, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+notificationRuleColumns+`
+		FROM notification_rules
+		WHERE user_id = $1
+		ORDER BY created_at, id
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notification rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []*models.NotificationRule{}
+	for rows.Next() {
+		rule, err := scanNotificationRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan notification rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating notification rules: %w", err)
+	}
+
+	return rules, nil
+}
+
+// GetNotificationRule returns a notification rule of the user.
+// Returns ErrNotificationRuleNotFound if there's no such rule.
+func GetNotificationRule(ctx context.Context, pool *pgxpool.Pool, userID, ruleID string) (*models.NotificationRule, error) {
+	rule, err := scanNotificationRule(pool.QueryRow(ctx, `
+		SELECT `+notificationRuleColumns+`
+		FROM notification_rules
+		WHERE id = $1 AND user_id = $2
+	`, ruleID, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotificationRuleNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notification rule: %w", err)
+	}
+	return rule, nil
+}
+
+// CreateNotificationRule saves a new notification rule, and sets its ID and timestamps.
+func CreateNotificationRule(ctx context.Context, pool *pgxpool.Pool, userID string, rule *models.NotificationRule) error {
+	hoursStart, hoursEnd, timeZone := notificationRuleHoursArgs(rule)
+	err := pool.QueryRow(ctx, `
+		INSERT INTO notification_rules (
+			user_id, name, action, sender, subject, folder, hours_start, hours_end, time_zone
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at, updated_at
+	`, userID, rule.Name, rule.Action, rule.From, rule.Subject, rule.Folder, hoursStart, hoursEnd, timeZone,
+	).Scan(&rule.ID, &rule.CreatedAt, &rule.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create notification rule: %w", err)
+	}
+	rule.UserID = userID
+	return nil
+}
+
+// UpdateNotificationRule replaces an existing notification rule of the user, and sets its timestamps.
+// Returns ErrNotificationRuleNotFound if there's no such rule.
+func UpdateNotificationRule(ctx context.Context, pool *pgxpool.Pool, userID string, rule *models.NotificationRule) error {
+	hoursStart, hoursEnd, timeZone := notificationRuleHoursArgs(rule)
+	err := pool.QueryRow(ctx, `
+		UPDATE notification_rules SET
+			name = $3,
+			action = $4,
+			sender = $5,
+			subject = $6,
+			folder = $7,
+			hours_start = $8,
+			hours_end = $9,
+			time_zone = $10,
+			updated_at = NOW()
+		WHERE id = $1 AND user_id = $2
+		RETURNING created_at, updated_at
+	`, rule.ID, userID, rule.Name, rule.Action, rule.From, rule.Subject, rule.Folder, hoursStart, hoursEnd, timeZone,
+	).Scan(&rule.CreatedAt, &rule.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotificationRuleNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update notification rule: %w", err)
+	}
+	rule.UserID = userID
+	return nil
+}
+
+// DeleteNotificationRule deletes a notification rule of the user.
+// Returns ErrNotificationRuleNotFound if there's no such rule.
+func DeleteNotificationRule(ctx context.Context, pool *pgxpool.Pool, userID, ruleID string) error {
+	result, err := pool.Exec(ctx, `
+		DELETE FROM notification_rules
+		WHERE id = $1 AND user_id = $2
+	`, ruleID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete notification rule: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotificationRuleNotFound
+	}
+	return nil
+}
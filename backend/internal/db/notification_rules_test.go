@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestNotificationRules(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "notification-rules-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	otherUserID, err := GetOrCreateUser(ctx, pool, "notification-rules-other@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	rule := &models.NotificationRule{Name: "Boss", Action: models.NotificationRuleNotify, From: "boss@example.com"}
+
+	t.Run("creates and reads a rule", func(t *testing.T) {
+		if err := CreateNotificationRule(ctx, pool, userID, rule); err != nil {
+			t.Fatalf("CreateNotificationRule failed: %v", err)
+		}
+		if rule.ID == "" || rule.UpdatedAt.IsZero() {
+			t.Fatalf("Expected the ID and timestamps to be set, got %+v", rule)
+		}
+
+		retrieved, err := GetNotificationRule(ctx, pool, userID, rule.ID)
+		if err != nil {
+			t.Fatalf("GetNotificationRule failed: %v", err)
+		}
+		if retrieved.Action != models.NotificationRuleNotify || retrieved.From != "boss@example.com" || retrieved.Hours != nil {
+			t.Errorf("Unexpected rule: %+v", retrieved)
+		}
+	})
+
+	t.Run("saves the hours of a rule", func(t *testing.T) {
+		quietHours := &models.NotificationRule{
+			Name:   "Quiet hours",
+			Action: models.NotificationRuleMute,
+			Hours:  &models.NotificationRuleHours{Start: "22:00", End: "07:00", TimeZone: "Europe/Berlin"},
+		}
+		if err := CreateNotificationRule(ctx, pool, userID, quietHours); err != nil {
+			t.Fatalf("CreateNotificationRule failed: %v", err)
+		}
+
+		rules, err := GetNotificationRules(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetNotificationRules failed: %v", err)
+		}
+		if len(rules) != 2 || rules[0].ID != rule.ID || rules[1].Hours == nil || *rules[1].Hours != *quietHours.Hours {
+			t.Errorf("Expected the boss rule, then the quiet hours, got %+v", rules)
+		}
+	})
+
+	t.Run("replaces a rule", func(t *testing.T) {
+		rule.Action = models.NotificationRuleMute
+		rule.From = ""
+		rule.Folder = "Newsletters"
+		if err := UpdateNotificationRule(ctx, pool, userID, rule); err != nil {
+			t.Fatalf("UpdateNotificationRule failed: %v", err)
+		}
+
+		retrieved, err := GetNotificationRule(ctx, pool, userID, rule.ID)
+		if err != nil {
+			t.Fatalf("GetNotificationRule failed: %v", err)
+		}
+		if retrieved.Action != models.NotificationRuleMute || retrieved.From != "" || retrieved.Folder != "Newsletters" {
+			t.Errorf("Unexpected rule: %+v", retrieved)
+		}
+	})
+
+	t.Run("doesn't touch rules of other users", func(t *testing.T) {
+		if _, err := GetNotificationRule(ctx, pool, otherUserID, rule.ID); !errors.Is(err, ErrNotificationRuleNotFound) {
+			t.Errorf("Expected ErrNotificationRuleNotFound, got %v", err)
+		}
+		other := *rule
+		if err := UpdateNotificationRule(ctx, pool, otherUserID, &other); !errors.Is(err, ErrNotificationRuleNotFound) {
+			t.Errorf("Expected ErrNotificationRuleNotFound, got %v", err)
+		}
+		if err := DeleteNotificationRule(ctx, pool, otherUserID, rule.ID); !errors.Is(err, ErrNotificationRuleNotFound) {
+			t.Errorf("Expected ErrNotificationRuleNotFound, got %v", err)
+		}
+	})
+
+	t.Run("deletes a rule", func(t *testing.T) {
+		if err := DeleteNotificationRule(ctx, pool, userID, rule.ID); err != nil {
+			t.Fatalf("DeleteNotificationRule failed: %v", err)
+		}
+		if _, err := GetNotificationRule(ctx, pool, userID, rule.ID); !errors.Is(err, ErrNotificationRuleNotFound) {
+			t.Errorf("Expected ErrNotificationRuleNotFound, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// ErrPlusAliasExists is returned when the user already has a plus alias with the same address.
+var ErrPlusAliasExists = errors.New("plus alias with this address already exists")
+
+// CreatePlusAlias saves a new plus alias with a lowercase address, and sets its ID and creation time.
+// Returns ErrPlusAliasExists if the user already has one with the same address.
+func CreatePlusAlias(ctx context.Context, pool *pgxpool.Pool, userID string, alias *models.PlusAlias) error {
+	alias.Address = strings.ToLower(alias.Address)
+	err := pool.QueryRow(ctx, `
+		INSERT INTO plus_aliases (user_id, address, label)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at
+	`, userID, alias.Address, alias.Label).Scan(&alias.ID, &alias.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrPlusAliasExists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create plus alias: %w", err)
+	}
+	alias.UserID = userID
+	return nil
+}
+
+// GetPlusAliases returns all plus aliases of the user, newest first.
+func GetPlusAliases(ctx context.Context, pool *pgxpool.Pool, userID string) ([]*models.PlusAlias, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT id, user_id, address, label, created_at
+		FROM plus_aliases
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get plus aliases: %w", err)
+	}
+	defer rows.Close()
+
+	aliases := []*models.PlusAlias{}
+	for rows.Next() {
+		var alias models.PlusAlias
+		if err := rows.Scan(&alias.ID, &alias.UserID, &alias.Address, &alias.Label, &alias.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan plus alias: %w", err)
+		}
+		aliases = append(aliases, &alias)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating plus aliases: %w", err)
+	}
+
+	return aliases, nil
+}
+
+// GetPlusAliasLabels returns the labels of the user's plus aliases, keyed by their lowercase address.
+func GetPlusAliasLabels(ctx context.Context, pool *pgxpool.Pool, userID string) (map[string]string, error) {
+	aliases, err := GetPlusAliases(ctx, pool, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := make(map[string]string, len(aliases))
+	for _, alias := range aliases {
+		labels[alias.Address] = alias.Label
+	}
+	return labels, nil
+}
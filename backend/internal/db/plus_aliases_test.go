@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestPlusAliases(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "aliases-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	otherUserID, err := GetOrCreateUser(ctx, pool, "aliases-other@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	t.Run("creates an alias with a lowercase address", func(t *testing.T) {
+		alias := &models.PlusAlias{Address: "User+Shop-x7q@Example.com", Label: "shop"}
+		if err := CreatePlusAlias(ctx, pool, userID, alias); err != nil {
+			t.Fatalf("CreatePlusAlias failed: %v", err)
+		}
+		if alias.ID == "" || alias.CreatedAt.IsZero() {
+			t.Fatalf("Expected the ID and creation time to be set, got %+v", alias)
+		}
+		if alias.Address != "user+shop-x7q@example.com" {
+			t.Errorf("Expected a lowercase address, got %s", alias.Address)
+		}
+	})
+
+	t.Run("rejects a second alias with the same address", func(t *testing.T) {
+		duplicate := &models.PlusAlias{Address: "user+shop-x7q@example.com", Label: "other"}
+		if err := CreatePlusAlias(ctx, pool, userID, duplicate); !errors.Is(err, ErrPlusAliasExists) {
+			t.Errorf("Expected ErrPlusAliasExists, got %v", err)
+		}
+	})
+
+	t.Run("returns labels by address for the user only", func(t *testing.T) {
+		labels, err := GetPlusAliasLabels(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetPlusAliasLabels failed: %v", err)
+		}
+		if len(labels) != 1 || labels["user+shop-x7q@example.com"] != "shop" {
+			t.Errorf("Unexpected labels: %v", labels)
+		}
+
+		otherLabels, err := GetPlusAliasLabels(ctx, pool, otherUserID)
+		if err != nil {
+			t.Fatalf("GetPlusAliasLabels failed: %v", err)
+		}
+		if len(otherLabels) != 0 {
+			t.Errorf("Expected no labels for the other user, got %v", otherLabels)
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/ratelimit"
+)
+
+// RateLimitStore keeps the buckets of the API rate limiter in Postgres, so that backend instances share the limits.
+type RateLimitStore struct {
+	pool *pgxpool.Pool
+}
+
+// NewRateLimitStore creates a rate limit store on the pool.
+func NewRateLimitStore(pool *pgxpool.Pool) *RateLimitStore {
+	return &RateLimitStore{pool: pool}
+}
+
+// Take takes a token from the key's bucket, like ratelimit.Bucket.Take.
+// It locks the bucket's row, so that concurrent requests of the same user take turns.
+func (s *RateLimitStore) Take(ctx context.Context, key string, rate float64, burst int, now time.Time) (bool, time.Duration, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	full := ratelimit.NewBucket(burst, now)
+	_, err = tx.Exec(ctx, `
+		INSERT INTO rate_limit_buckets (key, tokens, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO NOTHING
+	`, key, full.Tokens, full.UpdatedAt)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to create rate limit bucket: %w", err)
+	}
+
+	var bucket ratelimit.Bucket
+	err = tx.QueryRow(ctx, `
+		SELECT tokens, updated_at FROM rate_limit_buckets WHERE key = $1 FOR UPDATE
+	`, key).Scan(&bucket.Tokens, &bucket.UpdatedAt)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to get rate limit bucket: %w", err)
+	}
+
+	allowed, retryAfter := bucket.Take(rate, burst, now)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE rate_limit_buckets SET tokens = $2, updated_at = $3 WHERE key = $1
+	`, key, bucket.Tokens, bucket.UpdatedAt)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to update rate limit bucket: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return allowed, retryAfter, nil
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestRateLimitStore(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	store := NewRateLimitStore(pool)
+	now := time.Now().Truncate(time.Microsecond)
+
+	t.Run("allows a burst, then denies", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			allowed, _, err := store.Take(ctx, "user:alice", 1, 2, now)
+			if err != nil {
+				t.Fatalf("Take failed: %v", err)
+			}
+			if !allowed {
+				t.Fatalf("Expected request %d to be allowed", i+1)
+			}
+		}
+		allowed, retryAfter, err := store.Take(ctx, "user:alice", 1, 2, now)
+		if err != nil {
+			t.Fatalf("Take failed: %v", err)
+		}
+		if allowed || retryAfter != time.Second {
+			t.Errorf("Expected a denial with 1s retry, got %v, %v", allowed, retryAfter)
+		}
+	})
+
+	t.Run("refills over time", func(t *testing.T) {
+		allowed, _, err := store.Take(ctx, "user:alice", 1, 2, now.Add(time.Second))
+		if err != nil {
+			t.Fatalf("Take failed: %v", err)
+		}
+		if !allowed {
+			t.Error("Expected a request to be allowed after a refill")
+		}
+	})
+
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/maintenance"
+)
+
+// RetentionInterval is how often RunRetentionCleaner prunes the folders with a sync depth.
+const RetentionInterval = time.Hour
+
+// RetentionStats tells what a PruneFolderCaches run deleted.
+type RetentionStats struct {
+	// DeletedMessages is how many cached messages we deleted, with their bodies and attachments.
+	DeletedMessages int
+	// Folders is how many folders we deleted messages from.
+	Folders int
+}
+
+// PruneFolderCaches deletes the cached messages that are beyond the sync depth of their folder: the ones sent
+// more than sync_days days ago, and the ones older than the newest sync_max_messages. See the
+// folder_sync_preferences table. Messages without a date only count for sync_max_messages.
+// It keeps the threads, even the ones with no messages left, so that messages that sync again later, after the user
+// syncs further back, go into the same threads.
+func PruneFolderCaches(ctx context.Context, pool *pgxpool.Pool) (RetentionStats, error) {
+	var stats RetentionStats
+
+	// Attachments are deleted by ON DELETE CASCADE
+	rows, err := pool.Query(ctx, `
+		WITH ranked AS (
+			SELECT m.id, p.sync_days, p.sync_max_messages, m.sent_at,
+			       row_number() OVER (PARTITION BY m.user_id, m.imap_folder_name ORDER BY m.imap_uid DESC) AS position
+			FROM messages m
+			JOIN folder_sync_preferences p ON p.user_id = m.user_id AND p.folder_name = m.imap_folder_name
+			WHERE p.sync_days IS NOT NULL OR p.sync_max_messages IS NOT NULL
+		), deleted AS (
+			DELETE FROM messages m
+			USING ranked r
+			WHERE m.id = r.id
+			  AND (r.sent_at < now() - make_interval(days => r.sync_days) OR r.position > r.sync_max_messages)
+			RETURNING m.user_id, m.imap_folder_name
+		)
+		SELECT user_id, imap_folder_name, COUNT(*) FROM deleted GROUP BY user_id, imap_folder_name
+	`)
+	if err != nil {
+		return stats, fmt.Errorf("failed to prune cached messages: %w", err)
+	}
+	foldersByUser := make(map[string][]string)
+	for rows.Next() {
+		var userID, folderName string
+		var deleted int
+		if err := rows.Scan(&userID, &folderName, &deleted); err != nil {
+			rows.Close()
+			return stats, fmt.Errorf("failed to scan pruned folder: %w", err)
+		}
+		foldersByUser[userID] = append(foldersByUser[userID], folderName)
+		stats.DeletedMessages += deleted
+		stats.Folders++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return stats, fmt.Errorf("error iterating pruned folders: %w", err)
+	}
+
+	for userID, folderNames := range foldersByUser {
+		if err := MarkThreadCountDirty(ctx, pool, userID, folderNames...); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// RunRetentionCleaner prunes the folders with a sync depth with PruneFolderCaches every interval until the context
+// is canceled. It's a heavy job, so it skips the runs outside the maintenance window. A nil window allows every run.
+// It blocks, so call it in a goroutine.
+func RunRetentionCleaner(ctx context.Context, pool *pgxpool.Pool, interval time.Duration, window *maintenance.Window) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !window.Allows(time.Now()) {
+				continue
+			}
+			pruneCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+			stats, err := PruneFolderCaches(pruneCtx, pool)
+			cancel()
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to prune folder caches", "error", err)
+			} else if stats.DeletedMessages > 0 {
+				slog.InfoContext(ctx, "Pruned messages beyond the sync depth", "messages", stats.DeletedMessages, "folders", stats.Folders)
+			}
+		}
+	}
+}
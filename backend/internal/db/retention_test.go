@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestPruneFolderCaches(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	userID, err := GetOrCreateUser(ctx, pool, "retention-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	thread := &models.Thread{UserID: userID, StableThreadID: "<retention@example.com>", Subject: "Retention"}
+	if err := SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+	now := time.Now()
+	saveMessage := func(folderName string, uid int64, sentAt time.Time) {
+		msg := &models.Message{
+			ThreadID:       thread.ID,
+			UserID:         userID,
+			IMAPUID:        uid,
+			IMAPFolderName: folderName,
+			SentAt:         &sentAt,
+			BodyText:       "Hello",
+		}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+	}
+	// INBOX keeps 30 days, Archive keeps the newest 2 messages, and Sent has no limit
+	saveMessage("INBOX", 1, now.AddDate(0, 0, -60))
+	saveMessage("INBOX", 2, now.AddDate(0, 0, -1))
+	saveMessage("Archive", 1, now)
+	saveMessage("Archive", 2, now)
+	saveMessage("Archive", 3, now)
+	saveMessage("Sent", 1, now.AddDate(-5, 0, 0))
+
+	syncDays, syncMaxMessages := 30, 2
+	for _, pref := range []*models.FolderSyncPreference{
+		{FolderName: "INBOX", Enabled: true, Mode: models.FolderSyncModeHeadersOnly, SyncDays: &syncDays},
+		{FolderName: "Archive", Enabled: true, Mode: models.FolderSyncModeHeadersOnly, SyncMaxMessages: &syncMaxMessages},
+	} {
+		if err := SaveFolderSyncPreference(ctx, pool, userID, pref); err != nil {
+			t.Fatalf("SaveFolderSyncPreference failed: %v", err)
+		}
+	}
+
+	stats, err := PruneFolderCaches(ctx, pool)
+	if err != nil {
+		t.Fatalf("PruneFolderCaches failed: %v", err)
+	}
+	if stats.DeletedMessages != 2 || stats.Folders != 2 {
+		t.Errorf("Expected 2 deleted messages in 2 folders, got %+v", stats)
+	}
+
+	for _, folderName := range []string{"INBOX", "Archive", "Sent"} {
+		for uid := int64(1); uid <= 3; uid++ {
+			_, err := GetMessageByUID(ctx, pool, userID, folderName, uid)
+			kept := err == nil
+			expectKept := (folderName == "INBOX" && uid == 2) || (folderName == "Archive" && uid >= 2) ||
+				(folderName == "Sent" && uid == 1)
+			if kept != expectKept {
+				t.Errorf("Expected %s/%d kept=%v, got %v (error: %v)", folderName, uid, expectKept, kept, err)
+			}
+		}
+	}
+
+	if _, err := GetThreadByID(ctx, pool, thread.ID); err != nil {
+		t.Errorf("Expected the thread to stay, got %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// MessageSearchParams describes what SearchMessages looks for. All conditions must match.
+type MessageSearchParams struct {
+	FolderName string
+	// Text is matched against the full-text search vector of the subject, sender, recipients, and text body.
+	Text string
+	// From, To, and Subject are substrings that must all appear in the field, case-insensitively.
+	From    []string
+	To      []string
+	Subject []string
+	// Since and Before limit the sent_at of the messages, if not nil.
+	Since  *time.Time
+	Before *time.Time
+	// IsRead and IsStarred match the flags of the messages, if not nil.
+	IsRead    *bool
+	IsStarred *bool
+	// LargerThan and SmallerThan limit the size_bytes of the messages, exclusively, if not nil.
+	LargerThan  *int64
+	SmallerThan *int64
+}
+
+// MessageSearchHit is a thread that has messages matching a search.
+type MessageSearchHit struct {
+	Thread *models.Thread
+	// LatestSentAt is the sent_at of the newest matching message in the thread.
+	LatestSentAt *time.Time
+}
+
+// SearchMessages searches the cached messages of a folder, and returns the threads of the matching messages.
+// It only finds what we have cached, so for messages whose bodies we haven't fetched yet,
+// it can only match the headers. See IsFolderFullyCached.
+func SearchMessages(ctx context.Context, pool *pgxpool.Pool, userID string, params MessageSearchParams) ([]MessageSearchHit, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT t.id, t.user_id, t.stable_thread_id, t.subject, MAX(m.sent_at) AS latest_sent_at
+		FROM messages m
+		INNER JOIN threads t ON t.id = m.thread_id
+		WHERE m.user_id = $1 AND m.imap_folder_name = $2
+			AND ($3::text = '' OR m.search_vector @@ plainto_tsquery('simple', $3::text))
+			AND NOT EXISTS (
+				SELECT 1 FROM unnest($4::text[]) AS p(pattern)
+				WHERE COALESCE(m.from_address, '') NOT ILIKE p.pattern
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM unnest($5::text[]) AS p(pattern)
+				WHERE COALESCE(array_to_string(m.to_addresses, ' '), '') NOT ILIKE p.pattern
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM unnest($6::text[]) AS p(pattern)
+				WHERE COALESCE(m.subject, '') NOT ILIKE p.pattern
+			)
+			AND ($7::timestamptz IS NULL OR m.sent_at >= $7::timestamptz)
+			AND ($8::timestamptz IS NULL OR m.sent_at <= $8::timestamptz)
+			AND ($9::boolean IS NULL OR m.is_read = $9::boolean)
+			AND ($10::boolean IS NULL OR m.is_starred = $10::boolean)
+			AND ($11::bigint IS NULL OR m.size_bytes > $11::bigint)
+			AND ($12::bigint IS NULL OR m.size_bytes < $12::bigint)
+		GROUP BY t.id, t.user_id, t.stable_thread_id, t.subject
+	`, userID, params.FolderName, params.Text,
+		containsPatterns(params.From), containsPatterns(params.To), containsPatterns(params.Subject),
+		params.Since, params.Before, params.IsRead, params.IsStarred, params.LargerThan, params.SmallerThan)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer rows.Close()
+
+	var hits []MessageSearchHit
+	for rows.Next() {
+		var thread models.Thread
+		var latestSentAt *time.Time
+		if err := rows.Scan(&thread.ID, &thread.UserID, &thread.StableThreadID, &thread.Subject, &latestSentAt); err != nil {
+			return nil, fmt.Errorf("failed to scan search hit: %w", err)
+		}
+		hits = append(hits, MessageSearchHit{Thread: &thread, LatestSentAt: latestSentAt})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating search hits: %w", err)
+	}
+
+	return hits, nil
+}
+
+// likeEscaper escapes the characters that LIKE and ILIKE treat specially.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// containsPatterns turns substrings into ILIKE patterns, escaping the characters that ILIKE treats specially.
+func containsPatterns(values []string) []string {
+	patterns := make([]string, len(values))
+	for i, value := range values {
+		patterns[i] = "%" + likeEscaper.Replace(value) + "%"
+	}
+	return patterns
+}
+
+// ThreadSizeFilter limits how many messages the threads that a search finds have, and how big they are.
+// Nil bounds don't limit. Bounds are inclusive.
+type ThreadSizeFilter struct {
+	MinMessages *int
+	MaxMessages *int
+	MinBytes    *int64
+	MaxBytes    *int64
+}
+
+// IsZero returns true if the filter doesn't limit anything.
+func (f ThreadSizeFilter) IsZero() bool {
+	return f.MinMessages == nil && f.MaxMessages == nil && f.MinBytes == nil && f.MaxBytes == nil
+}
+
+// FilterThreadsBySize returns the IDs of the threads among threadIDs that pass the filter.
+// It uses the denormalized message_count and size_bytes of the threads, so it doesn't count their messages.
+func FilterThreadsBySize(ctx context.Context, pool *pgxpool.Pool, userID string, threadIDs []string, filter ThreadSizeFilter) (map[string]bool, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT id
+		FROM threads
+		WHERE user_id = $1 AND id = ANY($2::uuid[])
+			AND ($3::int IS NULL OR message_count >= $3::int)
+			AND ($4::int IS NULL OR message_count <= $4::int)
+			AND ($5::bigint IS NULL OR size_bytes >= $5::bigint)
+			AND ($6::bigint IS NULL OR size_bytes <= $6::bigint)
+	`, userID, threadIDs, filter.MinMessages, filter.MaxMessages, filter.MinBytes, filter.MaxBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to filter threads by size: %w", err)
+	}
+	defer rows.Close()
+
+	passed := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan thread ID: %w", err)
+		}
+		passed[id] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating thread IDs: %w", err)
+	}
+
+	return passed, nil
+}
+
+// IsFolderFullyCached returns true if we've synced the folder and have the bodies of all its cached messages,
+// so that SearchMessages finds the same messages as a search on the IMAP server would.
+// Folders with a sync depth are never fully cached, since we don't sync, and PruneFolderCaches deletes, the messages
+// beyond the depth. It doesn't check how fresh the sync is. Callers should check that, too.
+func IsFolderFullyCached(ctx context.Context, pool *pgxpool.Pool, userID, folderName string) (bool, error) {
+	var fullyCached bool
+	err := pool.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM folder_sync_timestamps
+			WHERE user_id = $1 AND folder_name = $2 AND synced_at IS NOT NULL
+		) AND NOT EXISTS (
+			SELECT 1 FROM messages
+			WHERE user_id = $1 AND imap_folder_name = $2 AND content_hash IS NULL
+		) AND NOT EXISTS (
+			SELECT 1 FROM folder_sync_preferences
+			WHERE user_id = $1 AND folder_name = $2 AND (sync_days IS NOT NULL OR sync_max_messages IS NOT NULL)
+		)
+	`, userID, folderName).Scan(&fullyCached)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if folder is fully cached: %w", err)
+	}
+	return fullyCached, nil
+}
+
+// AttachmentFilter limits the threads that a search finds to those with attachments.
+type AttachmentFilter struct {
+	// HasAttachment requires an attachment that isn't inline, like a signature image.
+	HasAttachment bool
+	// Filenames are substrings that must each appear in the filename of an attachment, case-insensitively.
+	Filenames []string
+}
+
+// IsZero returns true if the filter doesn't limit anything.
+func (f AttachmentFilter) IsZero() bool {
+	return !f.HasAttachment && len(f.Filenames) == 0
+}
+
+// FilterThreadsByAttachments returns the IDs of the threads among threadIDs that pass the filter,
+// with the attachments of their cached messages in the folder. Messages whose bodies we haven't fetched yet
+// have no attachments in the cache, so they don't pass. See GetUIDsOfThreadsWithoutBodies for checking them.
+func FilterThreadsByAttachments(ctx context.Context, pool *pgxpool.Pool, userID, folderName string, threadIDs []string, filter AttachmentFilter) (map[string]bool, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT t.id
+		FROM threads t
+		WHERE t.user_id = $1 AND t.id = ANY($2::uuid[])
+			AND (NOT $4::boolean OR EXISTS (
+				SELECT 1
+				FROM messages m
+				INNER JOIN attachments a ON a.message_id = m.id
+				WHERE m.thread_id = t.id AND m.imap_folder_name = $3 AND NOT a.is_inline
+			))
+			AND NOT EXISTS (
+				SELECT 1 FROM unnest($5::text[]) AS p(pattern)
+				WHERE NOT EXISTS (
+					SELECT 1
+					FROM messages m
+					INNER JOIN attachments a ON a.message_id = m.id
+					WHERE m.thread_id = t.id AND m.imap_folder_name = $3 AND a.filename ILIKE p.pattern
+				)
+			)
+	`, userID, threadIDs, folderName, filter.HasAttachment, containsPatterns(filter.Filenames))
+	if err != nil {
+		return nil, fmt.Errorf("failed to filter threads by attachments: %w", err)
+	}
+	defer rows.Close()
+
+	passed := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan thread ID: %w", err)
+		}
+		passed[id] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating thread IDs: %w", err)
+	}
+
+	return passed, nil
+}
+
+// GetUIDsOfThreadsWithoutBodies returns the UIDs of the messages in the folder of those threads among threadIDs that
+// have a message in the folder whose body we haven't cached, mapped to their thread IDs.
+// FilterThreadsByAttachments can't tell whether these threads have attachments, but their BODYSTRUCTUREs can.
+func GetUIDsOfThreadsWithoutBodies(ctx context.Context, pool *pgxpool.Pool, userID, folderName string, threadIDs []string) (map[uint32]string, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT m.imap_uid, m.thread_id
+		FROM messages m
+		WHERE m.user_id = $1 AND m.imap_folder_name = $2 AND m.thread_id = ANY($3::uuid[])
+			AND EXISTS (
+				SELECT 1 FROM messages m2
+				WHERE m2.thread_id = m.thread_id AND m2.imap_folder_name = $2 AND NOT `+bodyCachedCondition("m2")+`
+			)
+	`, userID, folderName, threadIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get UIDs of threads without bodies: %w", err)
+	}
+	defer rows.Close()
+
+	uids := make(map[uint32]string)
+	for rows.Next() {
+		var uid int64
+		var threadID string
+		if err := rows.Scan(&uid, &threadID); err != nil {
+			return nil, fmt.Errorf("failed to scan UID: %w", err)
+		}
+		uids[uint32(uid)] = threadID
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating UIDs: %w", err)
+	}
+
+	return uids, nil
+}
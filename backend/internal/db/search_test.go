@@ -0,0 +1,404 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestContainsPatterns(t *testing.T) {
+	got := containsPatterns([]string{"alice", `100%_sure\`})
+	want := []string{"%alice%", `%100\%\_sure\\%`}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestSearchMessages(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "search-messages@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	day := func(d int) *time.Time {
+		date := time.Date(2025, 3, d, 12, 0, 0, 0, time.UTC)
+		return &date
+	}
+	saveMessage := func(t *testing.T, stableThreadID, from, subject, body string, sentAt *time.Time) {
+		t.Helper()
+		thread, err := GetThreadByStableID(ctx, pool, userID, stableThreadID)
+		if err != nil {
+			thread = &models.Thread{UserID: userID, StableThreadID: stableThreadID, Subject: subject}
+			if err := SaveThread(ctx, pool, thread); err != nil {
+				t.Fatalf("SaveThread failed: %v", err)
+			}
+		}
+		msg := &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         sentAt.Unix(),
+			IMAPFolderName:  "INBOX",
+			MessageIDHeader: fmt.Sprintf("<%s-%d@example.com>", stableThreadID, sentAt.Unix()),
+			FromAddress:     from,
+			ToAddresses:     []string{"me@example.com"},
+			Subject:         subject,
+			BodyText:        body,
+			SentAt:          sentAt,
+		}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+	}
+
+	saveMessage(t, "garden", "george@example.com", "Garden plans", "Let's plant cabbage this year.", day(1))
+	saveMessage(t, "garden", "alice@example.com", "Re: Garden plans", "Sounds good, and some carrots too.", day(3))
+	saveMessage(t, "invoice", "billing@example.com", "Your invoice", "Your invoice for March is ready.", day(2))
+
+	search := func(t *testing.T, params MessageSearchParams) map[string]*time.Time {
+		t.Helper()
+		params.FolderName = "INBOX"
+		hits, err := SearchMessages(ctx, pool, userID, params)
+		if err != nil {
+			t.Fatalf("SearchMessages failed: %v", err)
+		}
+		result := make(map[string]*time.Time, len(hits))
+		for _, hit := range hits {
+			result[hit.Thread.StableThreadID] = hit.LatestSentAt
+		}
+		return result
+	}
+
+	t.Run("matches words in the body", func(t *testing.T) {
+		hits := search(t, MessageSearchParams{Text: "cabbage"})
+		if len(hits) != 1 || hits["garden"] == nil || !hits["garden"].Equal(*day(1)) {
+			t.Errorf("Expected the garden thread with the first message's date, got %v", hits)
+		}
+	})
+
+	t.Run("matches words in the subject", func(t *testing.T) {
+		if hits := search(t, MessageSearchParams{Text: "invoice"}); len(hits) != 1 || hits["invoice"] == nil {
+			t.Errorf("Expected the invoice thread, got %v", hits)
+		}
+	})
+
+	t.Run("applies header filters", func(t *testing.T) {
+		hits := search(t, MessageSearchParams{From: []string{"ALICE"}, Subject: []string{"garden"}})
+		if len(hits) != 1 || !hits["garden"].Equal(*day(3)) {
+			t.Errorf("Expected the garden thread with Alice's reply, got %v", hits)
+		}
+		if hits := search(t, MessageSearchParams{To: []string{"someone-else"}}); len(hits) != 0 {
+			t.Errorf("Expected no hits, got %v", hits)
+		}
+	})
+
+	t.Run("applies date filters", func(t *testing.T) {
+		hits := search(t, MessageSearchParams{Since: day(2), Before: day(2)})
+		if len(hits) != 1 || hits["invoice"] == nil {
+			t.Errorf("Expected only the invoice thread, got %v", hits)
+		}
+	})
+
+	t.Run("returns all threads without conditions", func(t *testing.T) {
+		if hits := search(t, MessageSearchParams{}); len(hits) != 2 {
+			t.Errorf("Expected 2 threads, got %v", hits)
+		}
+	})
+
+	t.Run("applies flag and size filters", func(t *testing.T) {
+		if _, err := pool.Exec(ctx, `
+			UPDATE messages SET is_read = TRUE, is_starred = TRUE, size_bytes = 5000
+			WHERE user_id = $1 AND from_address = 'billing@example.com'
+		`, userID); err != nil {
+			t.Fatalf("Failed to update message: %v", err)
+		}
+		yes, no, size := true, false, int64(4000)
+
+		if hits := search(t, MessageSearchParams{IsRead: &yes}); len(hits) != 1 || hits["invoice"] == nil {
+			t.Errorf("Expected only the read invoice thread, got %v", hits)
+		}
+		if hits := search(t, MessageSearchParams{IsRead: &no}); len(hits) != 1 || hits["garden"] == nil {
+			t.Errorf("Expected only the unread garden thread, got %v", hits)
+		}
+		if hits := search(t, MessageSearchParams{IsStarred: &yes}); len(hits) != 1 || hits["invoice"] == nil {
+			t.Errorf("Expected only the starred invoice thread, got %v", hits)
+		}
+		if hits := search(t, MessageSearchParams{LargerThan: &size}); len(hits) != 1 || hits["invoice"] == nil {
+			t.Errorf("Expected only the big invoice thread, got %v", hits)
+		}
+		if hits := search(t, MessageSearchParams{SmallerThan: &size}); len(hits) != 1 || hits["garden"] == nil {
+			t.Errorf("Expected only the small garden thread, got %v", hits)
+		}
+	})
+}
+
+func TestIsFolderFullyCached(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "fully-cached@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	thread := &models.Thread{UserID: userID, StableThreadID: "fully-cached", Subject: "Cached"}
+	if err := SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+
+	isFullyCached := func(t *testing.T) bool {
+		t.Helper()
+		fullyCached, err := IsFolderFullyCached(ctx, pool, userID, "INBOX")
+		if err != nil {
+			t.Fatalf("IsFolderFullyCached failed: %v", err)
+		}
+		return fullyCached
+	}
+
+	if isFullyCached(t) {
+		t.Error("Expected a folder we've never synced not to be fully cached")
+	}
+
+	if err := SetFolderSyncInfo(ctx, pool, userID, "INBOX", nil); err != nil {
+		t.Fatalf("SetFolderSyncInfo failed: %v", err)
+	}
+	msg := &models.Message{ThreadID: thread.ID, UserID: userID, IMAPUID: 1, IMAPFolderName: "INBOX", MessageIDHeader: "<cached@example.com>"}
+	if err := SaveMessage(ctx, pool, msg); err != nil {
+		t.Fatalf("SaveMessage failed: %v", err)
+	}
+	if isFullyCached(t) {
+		t.Error("Expected a folder with a message without body not to be fully cached")
+	}
+
+	msg.BodyText = "Now with a body"
+	if err := SaveMessage(ctx, pool, msg); err != nil {
+		t.Fatalf("SaveMessage failed: %v", err)
+	}
+	if !isFullyCached(t) {
+		t.Error("Expected the folder to be fully cached")
+	}
+
+	syncDays := 30
+	pref := &models.FolderSyncPreference{FolderName: "INBOX", Enabled: true, Mode: models.FolderSyncModeHeadersOnly, SyncDays: &syncDays}
+	if err := SaveFolderSyncPreference(ctx, pool, userID, pref); err != nil {
+		t.Fatalf("SaveFolderSyncPreference failed: %v", err)
+	}
+	if isFullyCached(t) {
+		t.Error("Expected a folder with a sync depth not to be fully cached")
+	}
+}
+
+func TestFilterThreadsBySize(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "thread-size@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	// A long thread of small messages, and a short thread with a big message
+	threadIDs := map[string]string{}
+	uid := int64(0)
+	for stableThreadID, sizes := range map[string][]int64{"long": {1000, 1000, 1000, 1000}, "heavy": {10 << 20}} {
+		thread := &models.Thread{UserID: userID, StableThreadID: stableThreadID, Subject: stableThreadID}
+		if err := SaveThread(ctx, pool, thread); err != nil {
+			t.Fatalf("SaveThread failed: %v", err)
+		}
+		threadIDs[stableThreadID] = thread.ID
+		for _, size := range sizes {
+			uid++
+			msg := &models.Message{
+				ThreadID:        thread.ID,
+				UserID:          userID,
+				IMAPUID:         uid,
+				IMAPFolderName:  "INBOX",
+				MessageIDHeader: fmt.Sprintf("<size-%d@example.com>", uid),
+				SizeBytes:       size,
+			}
+			if err := SaveMessage(ctx, pool, msg); err != nil {
+				t.Fatalf("SaveMessage failed: %v", err)
+			}
+		}
+	}
+	allIDs := []string{threadIDs["long"], threadIDs["heavy"]}
+
+	minMessages, minBytes := 3, int64(5<<20)
+	tests := []struct {
+		name   string
+		filter ThreadSizeFilter
+		want   string
+	}{
+		{"by message count", ThreadSizeFilter{MinMessages: &minMessages}, "long"},
+		{"by size", ThreadSizeFilter{MinBytes: &minBytes}, "heavy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			passed, err := FilterThreadsBySize(ctx, pool, userID, allIDs, tt.filter)
+			if err != nil {
+				t.Fatalf("FilterThreadsBySize failed: %v", err)
+			}
+			if len(passed) != 1 || !passed[threadIDs[tt.want]] {
+				t.Errorf("Expected only the %s thread, got %v", tt.want, passed)
+			}
+		})
+	}
+
+	t.Run("keeps the counts when messages move between threads", func(t *testing.T) {
+		if _, err := pool.Exec(ctx, `UPDATE messages SET thread_id = $1 WHERE imap_uid = 1 AND user_id = $2`, threadIDs["heavy"], userID); err != nil {
+			t.Fatalf("Failed to move message: %v", err)
+		}
+		passed, err := FilterThreadsBySize(ctx, pool, userID, allIDs, ThreadSizeFilter{MinMessages: &minMessages})
+		if err != nil {
+			t.Fatalf("FilterThreadsBySize failed: %v", err)
+		}
+		if !passed[threadIDs["long"]] || passed[threadIDs["heavy"]] {
+			t.Errorf("Expected the long thread to still have 3 messages, got %v", passed)
+		}
+	})
+}
+
+func TestFilterThreadsByAttachments(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "thread-attachments@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	// A thread with a PDF, one with only an inline signature image, and one without attachments
+	threadIDs := map[string]string{}
+	attachments := map[string][]models.Attachment{
+		"report":    {{Filename: "Q3 Report.PDF", MimeType: "application/pdf", SizeBytes: 1000}},
+		"signature": {{Filename: "logo.png", MimeType: "image/png", SizeBytes: 100, IsInline: true}},
+		"plain":     nil,
+	}
+	uid := int64(0)
+	for stableThreadID, threadAttachments := range attachments {
+		thread := &models.Thread{UserID: userID, StableThreadID: stableThreadID, Subject: stableThreadID}
+		if err := SaveThread(ctx, pool, thread); err != nil {
+			t.Fatalf("SaveThread failed: %v", err)
+		}
+		threadIDs[stableThreadID] = thread.ID
+		uid++
+		msg := &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         uid,
+			IMAPFolderName:  "INBOX",
+			MessageIDHeader: fmt.Sprintf("<attachments-%d@example.com>", uid),
+		}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+		for _, attachment := range threadAttachments {
+			attachment.MessageID = msg.ID
+			if err := SaveAttachment(ctx, pool, &attachment); err != nil {
+				t.Fatalf("SaveAttachment failed: %v", err)
+			}
+		}
+	}
+	allIDs := []string{threadIDs["report"], threadIDs["signature"], threadIDs["plain"]}
+
+	tests := []struct {
+		name   string
+		folder string
+		filter AttachmentFilter
+		want   []string
+	}{
+		{"skips inline attachments", "INBOX", AttachmentFilter{HasAttachment: true}, []string{"report"}},
+		{"matches filenames case-insensitively", "INBOX", AttachmentFilter{Filenames: []string{"pdf"}}, []string{"report"}},
+		{"matches inline attachments by filename", "INBOX", AttachmentFilter{Filenames: []string{"logo"}}, []string{"signature"}},
+		{"needs every filename", "INBOX", AttachmentFilter{Filenames: []string{"report", "logo"}}, nil},
+		{"only counts messages in the folder", "Archive", AttachmentFilter{HasAttachment: true}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			passed, err := FilterThreadsByAttachments(ctx, pool, userID, tt.folder, allIDs, tt.filter)
+			if err != nil {
+				t.Fatalf("FilterThreadsByAttachments failed: %v", err)
+			}
+			if len(passed) != len(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, passed)
+			}
+			for _, stableThreadID := range tt.want {
+				if !passed[threadIDs[stableThreadID]] {
+					t.Errorf("Expected the %s thread to pass, got %v", stableThreadID, passed)
+				}
+			}
+		})
+	}
+}
+
+func TestGetUIDsOfThreadsWithoutBodies(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "threads-without-bodies@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	// A thread with all bodies, and one with a body and a message without one
+	saveThread := func(t *testing.T, stableThreadID string) string {
+		t.Helper()
+		thread := &models.Thread{UserID: userID, StableThreadID: stableThreadID, Subject: stableThreadID}
+		if err := SaveThread(ctx, pool, thread); err != nil {
+			t.Fatalf("SaveThread failed: %v", err)
+		}
+		return thread.ID
+	}
+	saveMessage := func(t *testing.T, threadID string, uid int64, folder, bodyText string) {
+		t.Helper()
+		msg := &models.Message{
+			ThreadID:        threadID,
+			UserID:          userID,
+			IMAPUID:         uid,
+			IMAPFolderName:  folder,
+			MessageIDHeader: fmt.Sprintf("<without-bodies-%d@example.com>", uid),
+			BodyText:        bodyText,
+		}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+	}
+	cachedID := saveThread(t, "cached")
+	saveMessage(t, cachedID, 1, "INBOX", "Body")
+	partialID := saveThread(t, "partial")
+	saveMessage(t, partialID, 2, "INBOX", "Body")
+	saveMessage(t, partialID, 3, "INBOX", "")
+	saveMessage(t, partialID, 1, "Archive", "")
+
+	uids, err := GetUIDsOfThreadsWithoutBodies(ctx, pool, userID, "INBOX", []string{cachedID, partialID})
+	if err != nil {
+		t.Fatalf("GetUIDsOfThreadsWithoutBodies failed: %v", err)
+	}
+	if len(uids) != 2 || uids[2] != partialID || uids[3] != partialID {
+		t.Errorf("Expected UIDs 2 and 3 of the partial thread, got %v", uids)
+	}
+
+	uids, err = GetUIDsOfThreadsWithoutBodies(ctx, pool, userID, "INBOX", []string{cachedID})
+	if err != nil {
+		t.Fatalf("GetUIDsOfThreadsWithoutBodies failed: %v", err)
+	}
+	if len(uids) != 0 {
+		t.Errorf("Expected no UIDs for a thread with all bodies, got %v", uids)
+	}
+}
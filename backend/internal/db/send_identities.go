@@ -0,0 +1,173 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// ErrSendIdentityNotFound is returned when a send identity doesn't exist or belongs to another user.
+var ErrSendIdentityNotFound = errors.New("send identity not found")
+
+// ErrSendIdentityExists is returned when the user already has a send identity with the same email address.
+var ErrSendIdentityExists = errors.New("send identity with this email already exists")
+
+// uniqueViolationCode is the Postgres error code for unique constraint violations.
+const uniqueViolationCode = "23505"
+
+// sendIdentityColumns are the columns that scanSendIdentity reads, in order.
+const sendIdentityColumns = `
+	id,
+	user_id,
+	name,
+	email,
+	COALESCE(smtp_server_hostname, ''),
+	COALESCE(smtp_username, ''),
+	encrypted_smtp_password,
+	signature,
+	created_at,
+	updated_at
+`
+
+func scanSendIdentity(row pgx.Row) (*models.SendIdentity, error) {
+	var identity models.SendIdentity
+	err := row.Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Name,
+		&identity.Email,
+		&identity.SMTPServerHostname,
+		&identity.SMTPUsername,
+		&identity.EncryptedSMTPPassword,
+		&identity.Signature,
+		&identity.CreatedAt,
+		&identity.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// GetSendIdentities returns all send identities of the user, ordered by email address.
+func GetSendIdentities(ctx context.Context, pool *pgxpool.Pool, userID string) ([]*models.SendIdentity, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+sendIdentityColumns+`
+		FROM send_identities
+		WHERE user_id = $1
+		ORDER BY email
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get send identities: %w", err)
+	}
+	defer rows.Close()
+
+	identities := []*models.SendIdentity{}
+	for rows.Next() {
+		identity, err := scanSendIdentity(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan send identity: %w", err)
+		}
+		identities = append(identities, identity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating send identities: %w", err)
+	}
+
+	return identities, nil
+}
+
+// GetSendIdentity returns a send identity of the user. Returns ErrSendIdentityNotFound if there's no such identity.
+func GetSendIdentity(ctx context.Context, pool *pgxpool.Pool, userID, identityID string) (*models.SendIdentity, error) {
+	identity, err := scanSendIdentity(pool.QueryRow(ctx, `
+		SELECT `+sendIdentityColumns+`
+		FROM send_identities
+		WHERE id = $1 AND user_id = $2
+	`, identityID, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrSendIdentityNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get send identity: %w", err)
+	}
+	return identity, nil
+}
+
+// CreateSendIdentity saves a new send identity, and sets its ID and timestamps.
+// Returns ErrSendIdentityExists if the user already has one with the same email address.
+func CreateSendIdentity(ctx context.Context, pool *pgxpool.Pool, userID string, identity *models.SendIdentity) error {
+	err := pool.QueryRow(ctx, `
+		INSERT INTO send_identities (
+			user_id, name, email, smtp_server_hostname, smtp_username, encrypted_smtp_password, signature
+		) VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7)
+		RETURNING id, created_at, updated_at
+	`, userID, identity.Name, identity.Email, identity.SMTPServerHostname, identity.SMTPUsername,
+		identity.EncryptedSMTPPassword, identity.Signature,
+	).Scan(&identity.ID, &identity.CreatedAt, &identity.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrSendIdentityExists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create send identity: %w", err)
+	}
+	identity.UserID = userID
+	return nil
+}
+
+// UpdateSendIdentity replaces an existing send identity of the user, and sets its timestamps.
+// Returns ErrSendIdentityNotFound if there's no such identity,
+// and ErrSendIdentityExists if the user already has another one with the same email address.
+func UpdateSendIdentity(ctx context.Context, pool *pgxpool.Pool, userID string, identity *models.SendIdentity) error {
+	err := pool.QueryRow(ctx, `
+		UPDATE send_identities SET
+			name = $3,
+			email = $4,
+			smtp_server_hostname = NULLIF($5, ''),
+			smtp_username = NULLIF($6, ''),
+			encrypted_smtp_password = $7,
+			signature = $8,
+			updated_at = NOW()
+		WHERE id = $1 AND user_id = $2
+		RETURNING created_at, updated_at
+	`, identity.ID, userID, identity.Name, identity.Email, identity.SMTPServerHostname,
+		identity.SMTPUsername, identity.EncryptedSMTPPassword, identity.Signature,
+	).Scan(&identity.CreatedAt, &identity.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrSendIdentityNotFound
+	}
+	if isUniqueViolation(err) {
+		return ErrSendIdentityExists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update send identity: %w", err)
+	}
+	identity.UserID = userID
+	return nil
+}
+
+// DeleteSendIdentity deletes a send identity of the user. Returns ErrSendIdentityNotFound if there's no such identity.
+func DeleteSendIdentity(ctx context.Context, pool *pgxpool.Pool, userID, identityID string) error {
+	result, err := pool.Exec(ctx, `
+		DELETE FROM send_identities
+		WHERE id = $1 AND user_id = $2
+	`, identityID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete send identity: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrSendIdentityNotFound
+	}
+	return nil
+}
+
+// isUniqueViolation returns true if err is a Postgres unique constraint violation.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestSendIdentities(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "identities-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	otherUserID, err := GetOrCreateUser(ctx, pool, "identities-other@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	identity := &models.SendIdentity{Name: "Support", Email: "support@example.com", Signature: "The support team"}
+
+	t.Run("creates and reads an identity", func(t *testing.T) {
+		if err := CreateSendIdentity(ctx, pool, userID, identity); err != nil {
+			t.Fatalf("CreateSendIdentity failed: %v", err)
+		}
+		if identity.ID == "" || identity.UpdatedAt.IsZero() {
+			t.Fatalf("Expected the ID and timestamps to be set, got %+v", identity)
+		}
+
+		retrieved, err := GetSendIdentity(ctx, pool, userID, identity.ID)
+		if err != nil {
+			t.Fatalf("GetSendIdentity failed: %v", err)
+		}
+		if retrieved.Email != "support@example.com" || retrieved.Signature != "The support team" {
+			t.Errorf("Unexpected identity: %+v", retrieved)
+		}
+		if retrieved.SMTPServerHostname != "" || retrieved.EncryptedSMTPPassword != nil {
+			t.Errorf("Expected no SMTP server, got %+v", retrieved)
+		}
+	})
+
+	t.Run("rejects a second identity with the same email", func(t *testing.T) {
+		duplicate := &models.SendIdentity{Email: "support@example.com"}
+		if err := CreateSendIdentity(ctx, pool, userID, duplicate); !errors.Is(err, ErrSendIdentityExists) {
+			t.Errorf("Expected ErrSendIdentityExists, got %v", err)
+		}
+	})
+
+	t.Run("updates an identity", func(t *testing.T) {
+		identity.SMTPServerHostname = "smtp.example.com:465"
+		identity.SMTPUsername = "support"
+		identity.EncryptedSMTPPassword = []byte("encrypted")
+		if err := UpdateSendIdentity(ctx, pool, userID, identity); err != nil {
+			t.Fatalf("UpdateSendIdentity failed: %v", err)
+		}
+
+		identities, err := GetSendIdentities(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetSendIdentities failed: %v", err)
+		}
+		if len(identities) != 1 || identities[0].SMTPServerHostname != "smtp.example.com:465" {
+			t.Errorf("Expected the updated identity, got %+v", identities)
+		}
+	})
+
+	t.Run("doesn't touch other users' identities", func(t *testing.T) {
+		if _, err := GetSendIdentity(ctx, pool, otherUserID, identity.ID); !errors.Is(err, ErrSendIdentityNotFound) {
+			t.Errorf("Expected ErrSendIdentityNotFound, got %v", err)
+		}
+		if err := UpdateSendIdentity(ctx, pool, otherUserID, identity); !errors.Is(err, ErrSendIdentityNotFound) {
+			t.Errorf("Expected ErrSendIdentityNotFound, got %v", err)
+		}
+		if err := DeleteSendIdentity(ctx, pool, otherUserID, identity.ID); !errors.Is(err, ErrSendIdentityNotFound) {
+			t.Errorf("Expected ErrSendIdentityNotFound, got %v", err)
+		}
+	})
+
+	t.Run("deletes an identity", func(t *testing.T) {
+		if err := DeleteSendIdentity(ctx, pool, userID, identity.ID); err != nil {
+			t.Fatalf("DeleteSendIdentity failed: %v", err)
+		}
+		if _, err := GetSendIdentity(ctx, pool, userID, identity.ID); !errors.Is(err, ErrSendIdentityNotFound) {
+			t.Errorf("Expected ErrSendIdentityNotFound, got %v", err)
+		}
+	})
+}
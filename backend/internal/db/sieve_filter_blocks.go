@@ -0,0 +1,49 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// ErrSieveFilterBlockNotFound is returned when V-Mail never wrote the user's filters to their Sieve scripts.
+var ErrSieveFilterBlockNotFound = errors.New("sieve filter block not found")
+
+// GetSieveFilterBlock returns where V-Mail last wrote the user's filters to their Sieve scripts.
+// Returns ErrSieveFilterBlockNotFound if it never did.
+func GetSieveFilterBlock(ctx context.Context, pool *pgxpool.Pool, userID string) (*models.SieveFilterBlock, error) {
+	block := models.SieveFilterBlock{UserID: userID}
+	err := pool.QueryRow(ctx, `
+		SELECT script_name, block_hash, updated_at
+		FROM sieve_filter_blocks
+		WHERE user_id = $1
+	`, userID).Scan(&block.ScriptName, &block.Hash, &block.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrSieveFilterBlockNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sieve filter block: %w", err)
+	}
+	return &block, nil
+}
+
+// SaveSieveFilterBlock saves where V-Mail wrote the user's filters to their Sieve scripts, and sets its timestamp.
+func SaveSieveFilterBlock(ctx context.Context, pool *pgxpool.Pool, block *models.SieveFilterBlock) error {
+	err := pool.QueryRow(ctx, `
+		INSERT INTO sieve_filter_blocks (user_id, script_name, block_hash)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET
+			script_name = EXCLUDED.script_name,
+			block_hash = EXCLUDED.block_hash,
+			updated_at = NOW()
+		RETURNING updated_at
+	`, block.UserID, block.ScriptName, block.Hash).Scan(&block.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save sieve filter block: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestSieveFilterBlocks(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "sieve-blocks-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	t.Run("returns ErrSieveFilterBlockNotFound before the first sync", func(t *testing.T) {
+		if _, err := GetSieveFilterBlock(ctx, pool, userID); !errors.Is(err, ErrSieveFilterBlockNotFound) {
+			t.Errorf("Expected ErrSieveFilterBlockNotFound, got %v", err)
+		}
+	})
+
+	t.Run("saves and replaces the block", func(t *testing.T) {
+		for _, block := range []*models.SieveFilterBlock{
+			{UserID: userID, ScriptName: "vmail-filters", Hash: "aaa"},
+			{UserID: userID, ScriptName: "my-rules", Hash: "bbb"},
+		} {
+			if err := SaveSieveFilterBlock(ctx, pool, block); err != nil {
+				t.Fatalf("SaveSieveFilterBlock failed: %v", err)
+			}
+			if block.UpdatedAt.IsZero() {
+				t.Error("Expected the timestamp to be set")
+			}
+		}
+
+		block, err := GetSieveFilterBlock(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetSieveFilterBlock failed: %v", err)
+		}
+		if block.ScriptName != "my-rules" || block.Hash != "bbb" {
+			t.Errorf("Expected the last block, got %+v", block)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// snoozedCondition is true for the snoozed threads t, in thread queries.
+const snoozedCondition = "EXISTS (SELECT 1 FROM snoozes s WHERE s.thread_id = t.id)"
+
+// WokenSnooze is a thread whose snooze ended. See WakeDueSnoozes.
+type WokenSnooze struct {
+	UserID         string
+	StableThreadID string
+	// FolderNames are the folders that the thread is back in.
+	FolderNames []string
+}
+
+// SnoozeThread hides the thread from its folders until the given time. Snoozing a snoozed thread again moves its time.
+func SnoozeThread(ctx context.Context, pool *pgxpool.Pool, userID, threadID string, until time.Time) error {
+	_, err := pool.Exec(ctx, `
+		INSERT INTO snoozes (thread_id, user_id, snoozed_until)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (thread_id) DO UPDATE SET snoozed_until = EXCLUDED.snoozed_until
+	`, threadID, userID, until)
+	if err != nil {
+		return fmt.Errorf("failed to snooze thread: %w", err)
+	}
+	return markThreadFoldersDirty(ctx, pool, userID, threadID)
+}
+
+// UnsnoozeThread brings a snoozed thread back to its folders before its time. Returns false if it wasn't snoozed.
+func UnsnoozeThread(ctx context.Context, pool *pgxpool.Pool, userID, threadID string) (bool, error) {
+	result, err := pool.Exec(ctx, `
+		DELETE FROM snoozes WHERE thread_id = $1 AND user_id = $2
+	`, threadID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to unsnooze thread: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return false, nil
+	}
+	return true, markThreadFoldersDirty(ctx, pool, userID, threadID)
+}
+
+// WakeDueSnoozes deletes the snoozes that ended by now, so that their threads show up in their folders again.
+// Returns the woken threads.
+func WakeDueSnoozes(ctx context.Context, pool *pgxpool.Pool, now time.Time) ([]WokenSnooze, error) {
+	rows, err := pool.Query(ctx, `
+		WITH woken AS (
+			DELETE FROM snoozes WHERE snoozed_until <= $1 RETURNING thread_id, user_id
+		)
+		SELECT w.user_id, t.stable_thread_id,
+		       ARRAY(SELECT DISTINCT m.imap_folder_name FROM messages m WHERE m.thread_id = w.thread_id)
+		FROM woken w
+		JOIN threads t ON t.id = w.thread_id
+	`, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wake snoozed threads: %w", err)
+	}
+	var woken []WokenSnooze
+	for rows.Next() {
+		var snooze WokenSnooze
+		if err := rows.Scan(&snooze.UserID, &snooze.StableThreadID, &snooze.FolderNames); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan woken snooze: %w", err)
+		}
+		woken = append(woken, snooze)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating woken snoozes: %w", err)
+	}
+
+	for _, snooze := range woken {
+		if err := MarkThreadCountDirty(ctx, pool, snooze.UserID, snooze.FolderNames...); err != nil {
+			return woken, err
+		}
+	}
+	return woken, nil
+}
+
+// markThreadFoldersDirty marks the thread counts of the folders of the thread's messages as out of date,
+// since they leave out snoozed threads.
+func markThreadFoldersDirty(ctx context.Context, pool *pgxpool.Pool, userID, threadID string) error {
+	var folderNames []string
+	err := pool.QueryRow(ctx, `
+		SELECT ARRAY(SELECT DISTINCT imap_folder_name FROM messages WHERE thread_id = $1)
+	`, threadID).Scan(&folderNames)
+	if err != nil {
+		return fmt.Errorf("failed to get thread folders: %w", err)
+	}
+	return MarkThreadCountDirty(ctx, pool, userID, folderNames...)
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestSnoozes(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	userID, err := GetOrCreateUser(ctx, pool, "snooze-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	now := time.Now()
+	saveThread := func(stableID string, uid int64) *models.Thread {
+		thread := &models.Thread{UserID: userID, StableThreadID: stableID, Subject: "Snooze"}
+		if err := SaveThread(ctx, pool, thread); err != nil {
+			t.Fatalf("SaveThread failed: %v", err)
+		}
+		msg := &models.Message{ThreadID: thread.ID, UserID: userID, IMAPUID: uid, IMAPFolderName: "INBOX", SentAt: &now}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+		return thread
+	}
+	snoozed := saveThread("<snoozed@example.com>", 1)
+	saveThread("<awake@example.com>", 2)
+
+	listInbox := func() []string {
+		threads, err := GetFilteredThreadsForFolder(ctx, pool, userID, "INBOX", ThreadListFilter{ExcludeSnoozed: true}, 10, 0)
+		if err != nil {
+			t.Fatalf("GetFilteredThreadsForFolder failed: %v", err)
+		}
+		var ids []string
+		for _, thread := range threads {
+			ids = append(ids, thread.StableThreadID)
+		}
+		return ids
+	}
+
+	until := now.Add(time.Hour)
+	if err := SnoozeThread(ctx, pool, userID, snoozed.ID, until); err != nil {
+		t.Fatalf("SnoozeThread failed: %v", err)
+	}
+
+	t.Run("hides snoozed threads from their folders", func(t *testing.T) {
+		if ids := listInbox(); !slices.Equal(ids, []string{"<awake@example.com>"}) {
+			t.Errorf("Expected only the awake thread in INBOX, got %v", ids)
+		}
+		threads, err := GetSnoozedThreads(ctx, pool, userID, ThreadListFilter{}, 10, 0)
+		if err != nil {
+			t.Fatalf("GetSnoozedThreads failed: %v", err)
+		}
+		if len(threads) != 1 || threads[0].SnoozedUntil == nil || !threads[0].SnoozedUntil.Equal(until.Truncate(time.Microsecond)) {
+			t.Errorf("Expected the snoozed thread with its time, got %+v", threads)
+		}
+		count, err := GetSnoozedThreadCount(ctx, pool, userID, 0)
+		if err != nil || count != 1 {
+			t.Errorf("Expected 1 snoozed thread, got %d (err: %v)", count, err)
+		}
+	})
+
+	t.Run("doesn't wake threads before their time", func(t *testing.T) {
+		woken, err := WakeDueSnoozes(ctx, pool, now)
+		if err != nil {
+			t.Fatalf("WakeDueSnoozes failed: %v", err)
+		}
+		if len(woken) != 0 {
+			t.Errorf("Expected no woken threads, got %+v", woken)
+		}
+	})
+
+	t.Run("wakes threads whose snooze ended", func(t *testing.T) {
+		woken, err := WakeDueSnoozes(ctx, pool, until.Add(time.Second))
+		if err != nil {
+			t.Fatalf("WakeDueSnoozes failed: %v", err)
+		}
+		if len(woken) != 1 || woken[0].StableThreadID != "<snoozed@example.com>" || !slices.Equal(woken[0].FolderNames, []string{"INBOX"}) {
+			t.Errorf("Expected the snoozed thread to wake in INBOX, got %+v", woken)
+		}
+		if ids := listInbox(); len(ids) != 2 {
+			t.Errorf("Expected both threads in INBOX, got %v", ids)
+		}
+	})
+
+	t.Run("unsnoozes by hand", func(t *testing.T) {
+		if err := SnoozeThread(ctx, pool, userID, snoozed.ID, until); err != nil {
+			t.Fatalf("SnoozeThread failed: %v", err)
+		}
+		unsnoozed, err := UnsnoozeThread(ctx, pool, userID, snoozed.ID)
+		if err != nil || !unsnoozed {
+			t.Errorf("Expected the thread to be unsnoozed, got %v (err: %v)", unsnoozed, err)
+		}
+		unsnoozed, err = UnsnoozeThread(ctx, pool, userID, snoozed.ID)
+		if err != nil || unsnoozed {
+			t.Errorf("Expected nothing to unsnooze the second time, got %v (err: %v)", unsnoozed, err)
+		}
+	})
+}
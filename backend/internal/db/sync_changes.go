@@ -0,0 +1,270 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/maintenance"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+const (
+	// SyncChangeRetention is how long we keep the sync change log. Cursors that are older than this
+	// might point to changes we already pruned.
+	SyncChangeRetention = 30 * 24 * time.Hour
+
+	// SyncChangePruneInterval is how often RunSyncChangePruner prunes the sync change log.
+	SyncChangePruneInterval = time.Hour
+)
+
+// GetSyncPosition returns the current position in the sync change log.
+// A delta from this position contains every change that isn't visible yet.
+func GetSyncPosition(ctx context.Context, pool *pgxpool.Pool) (uint64, error) {
+	var position string
+	if err := pool.QueryRow(ctx, `SELECT pg_snapshot_xmin(pg_current_snapshot())::text`).Scan(&position); err != nil {
+		return 0, fmt.Errorf("failed to get sync position: %w", err)
+	}
+	return strconv.ParseUint(position, 10, 64)
+}
+
+// GetSyncDelta returns the current state of the threads and folders of the user that changed since the position,
+// and the position the next delta should start from.
+// Positions are transaction snapshots, so changes from transactions that are still running go in the next delta.
+// If more than maxThreads threads changed, the user's cache was cleared, or the position is from the future,
+// it returns a delta with Reset set.
+func GetSyncDelta(ctx context.Context, pool *pgxpool.Pool, userID string, since uint64, maxThreads int) (*models.SyncDelta, uint64, error) {
+	// Read everything from one snapshot, so the thread states match the changes we list
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var untilStr string
+	if err := tx.QueryRow(ctx, `SELECT pg_snapshot_xmin(pg_current_snapshot())::text`).Scan(&untilStr); err != nil {
+		return nil, 0, fmt.Errorf("failed to get sync position: %w", err)
+	}
+	until, err := strconv.ParseUint(untilStr, 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to parse sync position: %w", err)
+	}
+
+	delta := &models.SyncDelta{
+		Threads:          []*models.ThreadDelta{},
+		DeletedThreadIDs: []string{},
+		Folders:          []*models.FolderCounts{},
+	}
+	if since > until {
+		// Probably a cursor from before a database restore
+		delta.Reset = true
+		return delta, until, nil
+	}
+
+	reset, err := hasSyncReset(ctx, tx, userID, since, until)
+	if err != nil {
+		return nil, 0, err
+	}
+	if reset {
+		delta.Reset = true
+		return delta, until, nil
+	}
+
+	threadIDs, deletedStableIDs, folderNames, err := getSyncChanges(ctx, tx, userID, since, until)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(threadIDs) > maxThreads {
+		delta.Reset = true
+		return delta, until, nil
+	}
+
+	if len(threadIDs) > 0 {
+		if err := fillThreadDeltas(ctx, tx, userID, threadIDs, deletedStableIDs, delta); err != nil {
+			return nil, 0, err
+		}
+	}
+	if len(folderNames) > 0 {
+		if err := fillFolderCounts(ctx, tx, userID, folderNames, delta); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return delta, until, nil
+}
+
+// hasSyncReset tells whether the user's cache was cleared between the two positions. ClearMailCache logs a change
+// without a thread for that.
+func hasSyncReset(ctx context.Context, tx pgx.Tx, userID string, since, until uint64) (bool, error) {
+	var reset bool
+	err := tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM sync_changes
+			WHERE user_id = $1 AND thread_id IS NULL AND xid >= $2::text::xid8 AND xid < $3::text::xid8
+		)
+	`, userID, strconv.FormatUint(since, 10), strconv.FormatUint(until, 10)).Scan(&reset)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for sync resets: %w", err)
+	}
+	return reset, nil
+}
+
+// getSyncChanges returns the IDs of the changed threads, the stable IDs of the deleted ones,
+// and the names of the folders that had changes, between the two positions.
+func getSyncChanges(ctx context.Context, tx pgx.Tx, userID string, since, until uint64) ([]string, map[string]bool, []string, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT DISTINCT thread_id, COALESCE(stable_thread_id, ''), COALESCE(folder_name, '')
+		FROM sync_changes
+		WHERE user_id = $1 AND thread_id IS NOT NULL AND xid >= $2::text::xid8 AND xid < $3::text::xid8
+	`, userID, strconv.FormatUint(since, 10), strconv.FormatUint(until, 10))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get sync changes: %w", err)
+	}
+	defer rows.Close()
+
+	seenThreads := map[string]bool{}
+	seenFolders := map[string]bool{}
+	deletedStableIDs := map[string]bool{}
+	var threadIDs, folderNames []string
+	for rows.Next() {
+		var threadID, stableThreadID, folderName string
+		if err := rows.Scan(&threadID, &stableThreadID, &folderName); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to scan sync change: %w", err)
+		}
+		if !seenThreads[threadID] {
+			seenThreads[threadID] = true
+			threadIDs = append(threadIDs, threadID)
+		}
+		if stableThreadID != "" {
+			deletedStableIDs[stableThreadID] = true
+		}
+		if folderName != "" && !seenFolders[folderName] {
+			seenFolders[folderName] = true
+			folderNames = append(folderNames, folderName)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read sync changes: %w", err)
+	}
+
+	return threadIDs, deletedStableIDs, folderNames, nil
+}
+
+// fillThreadDeltas adds the current state of the changed threads to the delta.
+// Threads that were deleted, or have no messages left, go in DeletedThreadIDs.
+// deletedStableIDs are the stable IDs of the deleted threads, which we can't look up anymore.
+func fillThreadDeltas(ctx context.Context, tx pgx.Tx, userID string, threadIDs []string, deletedStableIDs map[string]bool, delta *models.SyncDelta) error {
+	rows, err := tx.Query(ctx, `
+		SELECT t.stable_thread_id,
+		       COALESCE(t.subject, ''),
+		       COALESCE(ARRAY_AGG(DISTINCT m.imap_folder_name) FILTER (WHERE m.id IS NOT NULL), '{}'),
+		       COUNT(m.id),
+		       COUNT(m.id) FILTER (WHERE NOT m.is_read),
+		       COALESCE(BOOL_OR(m.is_starred), FALSE),
+		       MAX(m.sent_at)
+		FROM threads t
+		LEFT JOIN messages m ON m.thread_id = t.id
+		WHERE t.user_id = $1 AND t.id = ANY($2)
+		GROUP BY t.id
+		ORDER BY MAX(m.sent_at) DESC NULLS LAST, t.stable_thread_id
+	`, userID, threadIDs)
+	if err != nil {
+		return fmt.Errorf("failed to get changed threads: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		thread := &models.ThreadDelta{}
+		if err := rows.Scan(&thread.StableThreadID, &thread.Subject, &thread.Folders, &thread.MessageCount,
+			&thread.UnreadCount, &thread.IsStarred, &thread.LastSentAt); err != nil {
+			return fmt.Errorf("failed to scan changed thread: %w", err)
+		}
+		if thread.MessageCount == 0 {
+			deletedStableIDs[thread.StableThreadID] = true
+			continue
+		}
+		// A thread can be deleted and recreated, for example, when a folder is resynced
+		delete(deletedStableIDs, thread.StableThreadID)
+		delta.Threads = append(delta.Threads, thread)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read changed threads: %w", err)
+	}
+
+	for stableThreadID := range deletedStableIDs {
+		delta.DeletedThreadIDs = append(delta.DeletedThreadIDs, stableThreadID)
+	}
+	sort.Strings(delta.DeletedThreadIDs)
+	return nil
+}
+
+// fillFolderCounts adds the current thread counts of the changed folders to the delta.
+func fillFolderCounts(ctx context.Context, tx pgx.Tx, userID string, folderNames []string, delta *models.SyncDelta) error {
+	rows, err := tx.Query(ctx, `
+		SELECT f.name,
+		       COUNT(DISTINCT m.thread_id),
+		       COUNT(DISTINCT m.thread_id) FILTER (WHERE NOT m.is_read)
+		FROM unnest($2::text[]) AS f(name)
+		LEFT JOIN messages m ON m.user_id = $1 AND m.imap_folder_name = f.name
+		GROUP BY f.name
+		ORDER BY f.name
+	`, userID, folderNames)
+	if err != nil {
+		return fmt.Errorf("failed to get folder counts: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		folder := &models.FolderCounts{}
+		if err := rows.Scan(&folder.Name, &folder.ThreadCount, &folder.UnreadThreadCount); err != nil {
+			return fmt.Errorf("failed to scan folder counts: %w", err)
+		}
+		delta.Folders = append(delta.Folders, folder)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read folder counts: %w", err)
+	}
+	return nil
+}
+
+// PruneSyncChanges deletes the sync changes logged before the given time. Returns how many it deleted.
+func PruneSyncChanges(ctx context.Context, pool *pgxpool.Pool, before time.Time) (int, error) {
+	tag, err := pool.Exec(ctx, `DELETE FROM sync_changes WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune sync changes: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// RunSyncChangePruner deletes sync changes older than SyncChangeRetention every interval until the context is
+// canceled. Pruning is a heavy job, so it skips the runs outside the maintenance window. A nil window allows every run.
+// It blocks, so call it in a goroutine.
+func RunSyncChangePruner(ctx context.Context, pool *pgxpool.Pool, interval time.Duration, window *maintenance.Window) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !window.Allows(time.Now()) {
+				continue
+			}
+			pruneCtx, cancel := context.WithTimeout(ctx, time.Minute)
+			pruned, err := PruneSyncChanges(pruneCtx, pool, time.Now().Add(-SyncChangeRetention))
+			cancel()
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to prune sync changes", "error", err)
+			} else if pruned > 0 {
+				slog.InfoContext(ctx, "Pruned old sync changes", "count", pruned)
+			}
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestGetSyncDelta(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "sync-delta-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	otherUserID, err := GetOrCreateUser(ctx, pool, "sync-delta-other@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	saveThreadWithMessage := func(t *testing.T, userID, stableID, folderName string, uid int64) *models.Thread {
+		t.Helper()
+		thread := &models.Thread{UserID: userID, StableThreadID: stableID, Subject: stableID}
+		if err := SaveThread(ctx, pool, thread); err != nil {
+			t.Fatalf("SaveThread failed: %v", err)
+		}
+		now := time.Now()
+		msg := &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         uid,
+			IMAPFolderName:  folderName,
+			MessageIDHeader: stableID,
+			Subject:         stableID,
+			SentAt:          &now,
+		}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+		return thread
+	}
+
+	getPosition := func(t *testing.T) uint64 {
+		t.Helper()
+		position, err := GetSyncPosition(ctx, pool)
+		if err != nil {
+			t.Fatalf("GetSyncPosition failed: %v", err)
+		}
+		return position
+	}
+
+	t.Run("lists new threads with their counts", func(t *testing.T) {
+		since := getPosition(t)
+		saveThreadWithMessage(t, userID, "delta-thread-1", "INBOX", 1)
+		saveThreadWithMessage(t, otherUserID, "delta-other-thread", "INBOX", 1)
+
+		delta, next, err := GetSyncDelta(ctx, pool, userID, since, 100)
+		if err != nil {
+			t.Fatalf("GetSyncDelta failed: %v", err)
+		}
+		if delta.Reset {
+			t.Fatal("Expected no reset")
+		}
+		if len(delta.Threads) != 1 || delta.Threads[0].StableThreadID != "delta-thread-1" {
+			t.Fatalf("Expected only delta-thread-1, got %+v", delta.Threads)
+		}
+		thread := delta.Threads[0]
+		if thread.MessageCount != 1 || thread.UnreadCount != 1 || len(thread.Folders) != 1 || thread.Folders[0] != "INBOX" {
+			t.Errorf("Unexpected thread delta: %+v", thread)
+		}
+		if len(delta.Folders) != 1 || delta.Folders[0].Name != "INBOX" || delta.Folders[0].UnreadThreadCount != 1 {
+			t.Errorf("Unexpected folder counts: %+v", delta.Folders)
+		}
+		if next <= since {
+			t.Errorf("Expected the next position to move past %d, got %d", since, next)
+		}
+
+		empty, _, err := GetSyncDelta(ctx, pool, userID, next, 100)
+		if err != nil {
+			t.Fatalf("GetSyncDelta failed: %v", err)
+		}
+		if len(empty.Threads) != 0 || len(empty.Folders) != 0 {
+			t.Errorf("Expected an empty delta from the next position, got %+v", empty)
+		}
+	})
+
+	t.Run("lists flag changes", func(t *testing.T) {
+		since := getPosition(t)
+		count, err := UpdateMessageFlags(ctx, pool, userID, "INBOX", []MessageFlags{{IMAPUID: 1, IsRead: true, IsStarred: true}})
+		if err != nil || count != 1 {
+			t.Fatalf("UpdateMessageFlags failed: %d, %v", count, err)
+		}
+
+		delta, _, err := GetSyncDelta(ctx, pool, userID, since, 100)
+		if err != nil {
+			t.Fatalf("GetSyncDelta failed: %v", err)
+		}
+		if len(delta.Threads) != 1 || delta.Threads[0].UnreadCount != 0 || !delta.Threads[0].IsStarred {
+			t.Errorf("Expected the thread to be read and starred, got %+v", delta.Threads)
+		}
+	})
+
+	t.Run("lists deleted threads", func(t *testing.T) {
+		since := getPosition(t)
+		if _, err := DeleteMessagesByUID(ctx, pool, userID, "INBOX", []int64{1}); err != nil {
+			t.Fatalf("DeleteMessagesByUID failed: %v", err)
+		}
+
+		delta, _, err := GetSyncDelta(ctx, pool, userID, since, 100)
+		if err != nil {
+			t.Fatalf("GetSyncDelta failed: %v", err)
+		}
+		if len(delta.Threads) != 0 {
+			t.Errorf("Expected no changed threads, got %+v", delta.Threads)
+		}
+		if len(delta.DeletedThreadIDs) != 1 || delta.DeletedThreadIDs[0] != "delta-thread-1" {
+			t.Errorf("Expected delta-thread-1 to be deleted, got %v", delta.DeletedThreadIDs)
+		}
+		if len(delta.Folders) != 1 || delta.Folders[0].ThreadCount != 0 {
+			t.Errorf("Expected INBOX to be empty, got %+v", delta.Folders)
+		}
+	})
+
+	t.Run("resets when too many threads changed", func(t *testing.T) {
+		since := getPosition(t)
+		saveThreadWithMessage(t, userID, "delta-thread-2", "INBOX", 2)
+		saveThreadWithMessage(t, userID, "delta-thread-3", "INBOX", 3)
+
+		delta, _, err := GetSyncDelta(ctx, pool, userID, since, 1)
+		if err != nil {
+			t.Fatalf("GetSyncDelta failed: %v", err)
+		}
+		if !delta.Reset || len(delta.Threads) != 0 {
+			t.Errorf("Expected an empty reset delta, got %+v", delta)
+		}
+	})
+
+	t.Run("resets for positions from the future", func(t *testing.T) {
+		delta, _, err := GetSyncDelta(ctx, pool, userID, getPosition(t)+1000, 100)
+		if err != nil {
+			t.Fatalf("GetSyncDelta failed: %v", err)
+		}
+		if !delta.Reset {
+			t.Error("Expected a reset")
+		}
+	})
+
+	t.Run("prunes old changes", func(t *testing.T) {
+		if _, err := PruneSyncChanges(ctx, pool, time.Now().Add(time.Minute)); err != nil {
+			t.Fatalf("PruneSyncChanges failed: %v", err)
+		}
+		var count int
+		if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM sync_changes`).Scan(&count); err != nil {
+			t.Fatalf("Failed to count sync changes: %v", err)
+		}
+		if count != 0 {
+			t.Errorf("Expected no sync changes left, got %d", count)
+		}
+	})
+}
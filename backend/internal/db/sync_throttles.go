@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// GetSyncThrottle returns the throttling state of the user's account.
+// Accounts that aren't throttled get an empty state, so this never returns a not-found error.
+func GetSyncThrottle(ctx context.Context, pool *pgxpool.Pool, userID string) (*models.SyncThrottle, error) {
+	var throttle models.SyncThrottle
+	err := pool.QueryRow(ctx, `
+		SELECT strikes, throttled_until, reason
+		FROM sync_throttles
+		WHERE user_id = $1
+	`, userID).Scan(&throttle.Strikes, &throttle.ThrottledUntil, &throttle.Reason)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &throttle, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sync throttle: %w", err)
+	}
+
+	return &throttle, nil
+}
+
+// SaveSyncThrottle saves the throttling state of the user's account.
+func SaveSyncThrottle(ctx context.Context, pool *pgxpool.Pool, userID string, throttle *models.SyncThrottle) error {
+	_, err := pool.Exec(ctx, `
+		INSERT INTO sync_throttles (user_id, strikes, throttled_until, reason)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE SET
+			strikes = EXCLUDED.strikes,
+			throttled_until = EXCLUDED.throttled_until,
+			reason = EXCLUDED.reason,
+			updated_at = now()
+	`, userID, throttle.Strikes, throttle.ThrottledUntil, throttle.Reason)
+	if err != nil {
+		return fmt.Errorf("failed to save sync throttle: %w", err)
+	}
+	return nil
+}
+
+// ClearSyncThrottle forgets the throttling state of the user's account, after a successful sync.
+func ClearSyncThrottle(ctx context.Context, pool *pgxpool.Pool, userID string) error {
+	_, err := pool.Exec(ctx, `DELETE FROM sync_throttles WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to clear sync throttle: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestSyncThrottles(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	userID, err := GetOrCreateUser(ctx, pool, "throttle-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	t.Run("returns an empty state for accounts that aren't throttled", func(t *testing.T) {
+		throttle, err := GetSyncThrottle(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetSyncThrottle failed: %v", err)
+		}
+		if throttle.Strikes != 0 || throttle.ThrottledUntil != nil {
+			t.Errorf("Expected an empty state, got %+v", throttle)
+		}
+	})
+
+	t.Run("saves and updates the state", func(t *testing.T) {
+		if err := SaveSyncThrottle(ctx, pool, userID, &models.SyncThrottle{Strikes: 1, Reason: "connection closed"}); err != nil {
+			t.Fatalf("SaveSyncThrottle failed: %v", err)
+		}
+		until := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+		if err := SaveSyncThrottle(ctx, pool, userID, &models.SyncThrottle{Strikes: 2, ThrottledUntil: &until, Reason: "throttled"}); err != nil {
+			t.Fatalf("SaveSyncThrottle failed: %v", err)
+		}
+
+		throttle, err := GetSyncThrottle(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetSyncThrottle failed: %v", err)
+		}
+		if throttle.Strikes != 2 || throttle.ThrottledUntil == nil || !throttle.ThrottledUntil.Equal(until) || throttle.Reason != "throttled" {
+			t.Errorf("Expected 2 strikes until %v, got %+v", until, throttle)
+		}
+	})
+
+	t.Run("clears the state", func(t *testing.T) {
+		if err := ClearSyncThrottle(ctx, pool, userID); err != nil {
+			t.Fatalf("ClearSyncThrottle failed: %v", err)
+		}
+		throttle, err := GetSyncThrottle(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetSyncThrottle failed: %v", err)
+		}
+		if throttle.Strikes != 0 {
+			t.Errorf("Expected an empty state, got %+v", throttle)
+		}
+	})
+}
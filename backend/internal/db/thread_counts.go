@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ThreadCountUpdateInterval is how often RunThreadCountUpdater looks for dirty thread counts.
+const ThreadCountUpdateInterval = 2 * time.Second
+
+// MarkThreadCountDirty flags the materialized thread count of a folder as out of date.
+// Every DB operation that adds, removes, or moves messages must call this for each folder it touches.
+// Until the count is recalculated, GetThreadCountForFolder calculates the count on the fly.
+// It's a no-op for folders we haven't synced yet, since they have no materialized count.
+func MarkThreadCountDirty(ctx context.Context, pool *pgxpool.Pool, userID string, folderNames ...string) error {
+	if len(folderNames) == 0 {
+		return nil
+	}
+
+	_, err := pool.Exec(ctx, `
+		UPDATE folder_sync_timestamps
+		SET thread_count_dirty = TRUE
+		WHERE user_id = $1 AND folder_name = ANY($2) AND NOT thread_count_dirty
+	`, userID, folderNames)
+
+	if err != nil {
+		return fmt.Errorf("failed to mark thread count dirty: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateDirtyThreadCounts recalculates the materialized thread count of every dirty folder
+// and clears their dirty flags. Returns the number of folders updated.
+func UpdateDirtyThreadCounts(ctx context.Context, pool *pgxpool.Pool) (int, error) {
+	tag, err := pool.Exec(ctx, `
+		UPDATE folder_sync_timestamps f
+		SET thread_count = (
+			SELECT COUNT(DISTINCT t.id)
+			FROM threads t
+			INNER JOIN messages m ON t.id = m.thread_id
+			WHERE t.user_id = f.user_id AND m.imap_folder_name = f.folder_name AND NOT `+snoozedCondition+`
+		),
+		thread_count_dirty = FALSE
+		WHERE f.thread_count_dirty
+	`)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to update dirty thread counts: %w", err)
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+// RunThreadCountUpdater recalculates dirty thread counts every interval until the context is canceled.
+// This debounces count updates: a burst of mutations in the same folder results in a single recalculation.
+// It blocks, so call it in a goroutine.
+func RunThreadCountUpdater(ctx context.Context, pool *pgxpool.Pool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Use a timeout to avoid a stuck query blocking all future updates
+			updateCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			updated, err := UpdateDirtyThreadCounts(updateCtx, pool)
+			cancel()
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to update dirty thread counts", "error", err)
+			} else if updated > 0 {
+				slog.DebugContext(ctx, "Updated thread counts of dirty folders", "count", updated)
+			}
+		}
+	}
+}
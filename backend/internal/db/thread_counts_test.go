@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestThreadCountInvalidation(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "count-dirty-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	folderName := "INBOX"
+	if err := SetFolderSyncInfo(ctx, pool, userID, folderName, nil); err != nil {
+		t.Fatalf("SetFolderSyncInfo failed: %v", err)
+	}
+	if err := UpdateThreadCount(ctx, pool, userID, folderName); err != nil {
+		t.Fatalf("UpdateThreadCount failed: %v", err)
+	}
+
+	getDirty := func(t *testing.T) bool {
+		t.Helper()
+		var dirty bool
+		err := pool.QueryRow(ctx, `
+			SELECT thread_count_dirty FROM folder_sync_timestamps WHERE user_id = $1 AND folder_name = $2
+		`, userID, folderName).Scan(&dirty)
+		if err != nil {
+			t.Fatalf("Failed to read dirty flag: %v", err)
+		}
+		return dirty
+	}
+
+	saveThreadWithMessage := func(t *testing.T, stableID string, uid int64) {
+		t.Helper()
+		thread := &models.Thread{UserID: userID, StableThreadID: stableID, Subject: stableID}
+		if err := SaveThread(ctx, pool, thread); err != nil {
+			t.Fatalf("SaveThread failed: %v", err)
+		}
+		now := time.Now()
+		msg := &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         uid,
+			IMAPFolderName:  folderName,
+			MessageIDHeader: stableID,
+			Subject:         stableID,
+			SentAt:          &now,
+		}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+	}
+
+	t.Run("saving a message marks the folder dirty", func(t *testing.T) {
+		saveThreadWithMessage(t, "dirty-thread-1", 1)
+
+		if !getDirty(t) {
+			t.Error("Expected thread count to be dirty after saving a message")
+		}
+	})
+
+	t.Run("returns the live count while dirty", func(t *testing.T) {
+		count, err := GetThreadCountForFolder(ctx, pool, userID, folderName)
+		if err != nil {
+			t.Fatalf("GetThreadCountForFolder failed: %v", err)
+		}
+		if count != 1 {
+			t.Errorf("Expected count 1, got %d", count)
+		}
+	})
+
+	t.Run("updater recalculates dirty folders and clears the flag", func(t *testing.T) {
+		saveThreadWithMessage(t, "dirty-thread-2", 2)
+
+		updated, err := UpdateDirtyThreadCounts(ctx, pool)
+		if err != nil {
+			t.Fatalf("UpdateDirtyThreadCounts failed: %v", err)
+		}
+		if updated != 1 {
+			t.Errorf("Expected 1 folder updated, got %d", updated)
+		}
+		if getDirty(t) {
+			t.Error("Expected dirty flag to be cleared")
+		}
+
+		info, err := GetFolderSyncInfo(ctx, pool, userID, folderName)
+		if err != nil {
+			t.Fatalf("GetFolderSyncInfo failed: %v", err)
+		}
+		if info.ThreadCount != 2 {
+			t.Errorf("Expected materialized count 2, got %d", info.ThreadCount)
+		}
+	})
+
+	t.Run("does nothing when no folder is dirty", func(t *testing.T) {
+		updated, err := UpdateDirtyThreadCounts(ctx, pool)
+		if err != nil {
+			t.Fatalf("UpdateDirtyThreadCounts failed: %v", err)
+		}
+		if updated != 0 {
+			t.Errorf("Expected 0 folders updated, got %d", updated)
+		}
+	})
+
+	t.Run("marking an unsynced folder is a no-op", func(t *testing.T) {
+		if err := MarkThreadCountDirty(ctx, pool, userID, "NeverSynced"); err != nil {
+			t.Errorf("MarkThreadCountDirty failed: %v", err)
+		}
+	})
+}
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/maintenance"
+)
+
+// ThreadRepairInterval is how often RunThreadRepairer looks for split threads.
+const ThreadRepairInterval = time.Hour
+
+// ThreadRepairStats tells what a RepairThreads run changed.
+type ThreadRepairStats struct {
+	// MovedMessages is how many messages moved to another thread.
+	MovedMessages int
+	// DeletedThreads is how many threads we merged into others and deleted.
+	DeletedThreads int
+}
+
+// threadLink says that a message in thread From references a message in thread To.
+type threadLink struct {
+	From, To string
+}
+
+// RepairThreads merges threads that incremental syncs split. Incremental syncs put a reply into a new thread
+// if the message it replies to isn't cached yet, for example, because it's in a folder that syncs later.
+// RepairThreads finds messages whose References point to a message in another thread, and merges the two threads
+// into the one with the oldest message. The merged threads are deleted if they have no messages left.
+func RepairThreads(ctx context.Context, pool *pgxpool.Pool) (ThreadRepairStats, error) {
+	var stats ThreadRepairStats
+
+	rows, err := pool.Query(ctx, `
+		SELECT DISTINCT m.thread_id, r.thread_id
+		FROM messages m
+		JOIN messages r ON r.user_id = m.user_id AND r.message_id_header = ANY (m.referenced_message_ids)
+		WHERE cardinality(m.referenced_message_ids) > 0
+		  AND r.thread_id <> m.thread_id
+	`)
+	if err != nil {
+		return stats, fmt.Errorf("failed to find split threads: %w", err)
+	}
+	var links []threadLink
+	for rows.Next() {
+		var link threadLink
+		if err := rows.Scan(&link.From, &link.To); err != nil {
+			rows.Close()
+			return stats, fmt.Errorf("failed to scan split thread: %w", err)
+		}
+		links = append(links, link)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return stats, fmt.Errorf("error iterating split threads: %w", err)
+	}
+	if len(links) == 0 {
+		return stats, nil
+	}
+
+	threadIDs := make([]string, 0, len(links)*2)
+	for _, link := range links {
+		threadIDs = append(threadIDs, link.From, link.To)
+	}
+	firstSentAt, err := getThreadFirstSentAt(ctx, pool, threadIDs)
+	if err != nil {
+		return stats, err
+	}
+
+	merges := planThreadMerges(links, firstSentAt)
+	sources := make([]string, 0, len(merges))
+	targets := make([]string, 0, len(merges))
+	for source, target := range merges {
+		sources = append(sources, source)
+		targets = append(targets, target)
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return stats, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	moved, err := tx.Exec(ctx, `
+		UPDATE messages m SET thread_id = t.target
+		FROM unnest($1::uuid[], $2::uuid[]) AS t(source, target)
+		WHERE m.thread_id = t.source
+	`, sources, targets)
+	if err != nil {
+		return stats, fmt.Errorf("failed to move messages to merged threads: %w", err)
+	}
+	stats.MovedMessages = int(moved.RowsAffected())
+
+	// A sync might have added a message to a merged thread in the meantime. Then we keep it, and the next run merges it.
+	deleted, err := tx.Exec(ctx, `
+		DELETE FROM threads t
+		WHERE t.id = ANY ($1::uuid[])
+		  AND NOT EXISTS (SELECT 1 FROM messages m WHERE m.thread_id = t.id)
+	`, sources)
+	if err != nil {
+		return stats, fmt.Errorf("failed to delete merged threads: %w", err)
+	}
+	stats.DeletedThreads = int(deleted.RowsAffected())
+
+	if err := tx.Commit(ctx); err != nil {
+		return stats, fmt.Errorf("failed to commit thread repair: %w", err)
+	}
+	return stats, nil
+}
+
+// getThreadFirstSentAt returns when the oldest message of each thread was sent.
+// Threads whose messages have no date are missing from the map.
+func getThreadFirstSentAt(ctx context.Context, pool *pgxpool.Pool, threadIDs []string) (map[string]time.Time, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT thread_id, MIN(sent_at)
+		FROM messages
+		WHERE thread_id = ANY ($1::uuid[]) AND sent_at IS NOT NULL
+		GROUP BY thread_id
+	`, threadIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get thread dates: %w", err)
+	}
+	defer rows.Close()
+
+	firstSentAt := make(map[string]time.Time)
+	for rows.Next() {
+		var threadID string
+		var sentAt time.Time
+		if err := rows.Scan(&threadID, &sentAt); err != nil {
+			return nil, fmt.Errorf("failed to scan thread date: %w", err)
+		}
+		firstSentAt[threadID] = sentAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating thread dates: %w", err)
+	}
+	return firstSentAt, nil
+}
+
+// planThreadMerges groups the linked threads, and picks the thread with the oldest message in each group to keep.
+// Threads without a date come last, and ties go to the lowest ID, so that every run picks the same one.
+// Returns the thread to merge each other thread into.
+func planThreadMerges(links []threadLink, firstSentAt map[string]time.Time) map[string]string {
+	// Union-find over thread IDs, with the thread to keep as the root of each group
+	parents := make(map[string]string)
+	var find func(id string) string
+	find = func(id string) string {
+		parent, ok := parents[id]
+		if !ok || parent == id {
+			parents[id] = id
+			return id
+		}
+		root := find(parent)
+		parents[id] = root
+		return root
+	}
+	keeps := func(a, b string) bool {
+		aSentAt, aOK := firstSentAt[a]
+		bSentAt, bOK := firstSentAt[b]
+		switch {
+		case aOK != bOK:
+			return aOK
+		case aOK && !aSentAt.Equal(bSentAt):
+			return aSentAt.Before(bSentAt)
+		default:
+			return a < b
+		}
+	}
+
+	for _, link := range links {
+		from, to := find(link.From), find(link.To)
+		if from == to {
+			continue
+		}
+		if keeps(from, to) {
+			parents[to] = from
+		} else {
+			parents[from] = to
+		}
+	}
+
+	merges := make(map[string]string)
+	for id := range parents {
+		if root := find(id); root != id {
+			merges[id] = root
+		}
+	}
+	return merges
+}
+
+// RunThreadRepairer merges split threads with RepairThreads every interval until the context is canceled.
+// It looks through all messages, so it's a heavy job, and it skips the runs outside the maintenance window.
+// A nil window allows every run. It blocks, so call it in a goroutine.
+func RunThreadRepairer(ctx context.Context, pool *pgxpool.Pool, interval time.Duration, window *maintenance.Window) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !window.Allows(time.Now()) {
+				continue
+			}
+			repairCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+			stats, err := RepairThreads(repairCtx, pool)
+			cancel()
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to repair threads", "error", err)
+			} else if stats.MovedMessages > 0 {
+				slog.InfoContext(ctx, "Merged split threads", "messages", stats.MovedMessages, "deleted_threads", stats.DeletedThreads)
+			}
+		}
+	}
+}
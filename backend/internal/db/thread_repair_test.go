@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestPlanThreadMerges(t *testing.T) {
+	day := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("merges chains into the thread with the oldest message", func(t *testing.T) {
+		links := []threadLink{{From: "c", To: "b"}, {From: "b", To: "a"}, {From: "e", To: "d"}}
+		firstSentAt := map[string]time.Time{
+			"a": day.Add(time.Hour), "b": day, "c": day.Add(2 * time.Hour),
+			"d": day, "e": day.Add(-time.Hour),
+		}
+		merges := planThreadMerges(links, firstSentAt)
+
+		expected := map[string]string{"a": "b", "c": "b", "d": "e"}
+		if len(merges) != len(expected) {
+			t.Fatalf("Expected %v, got %v", expected, merges)
+		}
+		for source, target := range expected {
+			if merges[source] != target {
+				t.Errorf("Expected %s to merge into %s, got %s", source, target, merges[source])
+			}
+		}
+	})
+
+	t.Run("prefers threads with a date, then the lowest ID", func(t *testing.T) {
+		merges := planThreadMerges([]threadLink{{From: "a", To: "b"}, {From: "c", To: "a"}}, map[string]time.Time{"c": day})
+		if merges["a"] != "c" || merges["b"] != "c" {
+			t.Errorf("Expected a and b to merge into c, got %v", merges)
+		}
+
+		merges = planThreadMerges([]threadLink{{From: "b", To: "a"}}, map[string]time.Time{})
+		if merges["b"] != "a" || len(merges) != 1 {
+			t.Errorf("Expected b to merge into a, got %v", merges)
+		}
+	})
+}
+
+func TestRepairThreads(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	userID, err := GetOrCreateUser(ctx, pool, "thread-repair-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	// An incremental sync cached the reply before the root, so they ended up in two threads
+	now := time.Now()
+	saveThreadWithMessage := func(stableID string, uid int64, sentAt time.Time, references []string) *models.Thread {
+		thread := &models.Thread{UserID: userID, StableThreadID: stableID, Subject: "Split"}
+		if err := SaveThread(ctx, pool, thread); err != nil {
+			t.Fatalf("SaveThread failed: %v", err)
+		}
+		msg := &models.Message{
+			ThreadID:             thread.ID,
+			UserID:               userID,
+			IMAPUID:              uid,
+			IMAPFolderName:       "INBOX",
+			MessageIDHeader:      stableID,
+			SentAt:               &sentAt,
+			ReferencedMessageIDs: references,
+		}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+		return thread
+	}
+	root := saveThreadWithMessage("<root@repair>", 1, now.Add(-time.Hour), nil)
+	reply := saveThreadWithMessage("<reply@repair>", 2, now, []string{"<root@repair>"})
+	unrelated := saveThreadWithMessage("<unrelated@repair>", 3, now, []string{"<not-cached@repair>"})
+
+	stats, err := RepairThreads(ctx, pool)
+	if err != nil {
+		t.Fatalf("RepairThreads failed: %v", err)
+	}
+	if stats.MovedMessages != 1 || stats.DeletedThreads != 1 {
+		t.Errorf("Expected 1 moved message and 1 deleted thread, got %+v", stats)
+	}
+
+	msg, err := GetMessageByMessageID(ctx, pool, userID, "<reply@repair>")
+	if err != nil {
+		t.Fatalf("GetMessageByMessageID failed: %v", err)
+	}
+	if msg.ThreadID != root.ID {
+		t.Errorf("Expected the reply in the root's thread %s, got %s", root.ID, msg.ThreadID)
+	}
+	if _, err := GetThreadByID(ctx, pool, reply.ID); !errors.Is(err, ErrThreadNotFound) {
+		t.Errorf("Expected the reply's thread to be deleted, got %v", err)
+	}
+	if _, err := GetThreadByID(ctx, pool, unrelated.ID); err != nil {
+		t.Errorf("Expected the unrelated thread to stay, got %v", err)
+	}
+
+	// Nothing is left to repair
+	stats, err = RepairThreads(ctx, pool)
+	if err != nil {
+		t.Fatalf("RepairThreads failed: %v", err)
+	}
+	if stats.MovedMessages != 0 {
+		t.Errorf("Expected nothing to repair, got %+v", stats)
+	}
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+const (
+	// MegaThreadThreshold is how many messages a thread can have before the thread view serves it in segments.
+	// Some mailing lists make threads with thousands of messages, which are too big to load and render at once.
+	MegaThreadThreshold = 500
+
+	// ThreadSegmentSize is the most messages in a segment of a mega-thread.
+	ThreadSegmentSize = 200
+)
+
+// ErrInvalidSegmentCursor is returned when a thread segment cursor can't be decoded.
+var ErrInvalidSegmentCursor = errors.New("invalid segment cursor")
+
+// segmentCursorPrefix versions the cursor format so we can change it later without misreading old cursors.
+const segmentCursorPrefix = "s1:"
+
+// ThreadSegmentPosition is where a segment of a thread ends: its oldest message.
+// The next older segment has the messages that sort before it.
+type ThreadSegmentPosition struct {
+	// SentAt is nil for messages without a date, which sort after all others.
+	SentAt    *time.Time
+	MessageID string
+}
+
+// EncodeThreadSegmentCursor creates an opaque cursor that points to the messages before the position.
+func EncodeThreadSegmentCursor(position ThreadSegmentPosition) string {
+	sentAt := "-"
+	if position.SentAt != nil {
+		sentAt = strconv.FormatInt(position.SentAt.UnixMicro(), 10)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(segmentCursorPrefix + sentAt + ":" + position.MessageID))
+}
+
+// DecodeThreadSegmentCursor returns the position a cursor points to.
+func DecodeThreadSegmentCursor(cursor string) (ThreadSegmentPosition, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return ThreadSegmentPosition{}, fmt.Errorf("%w: %v", ErrInvalidSegmentCursor, err)
+	}
+
+	rest, ok := strings.CutPrefix(string(decoded), segmentCursorPrefix)
+	if !ok {
+		return ThreadSegmentPosition{}, fmt.Errorf("%w: unknown format", ErrInvalidSegmentCursor)
+	}
+	sentAtStr, messageID, ok := strings.Cut(rest, ":")
+	if !ok || messageID == "" {
+		return ThreadSegmentPosition{}, fmt.Errorf("%w: missing message", ErrInvalidSegmentCursor)
+	}
+
+	position := ThreadSegmentPosition{MessageID: messageID}
+	if sentAtStr != "-" {
+		micros, err := strconv.ParseInt(sentAtStr, 10, 64)
+		if err != nil {
+			return ThreadSegmentPosition{}, fmt.Errorf("%w: bad date", ErrInvalidSegmentCursor)
+		}
+		sentAt := time.UnixMicro(micros).UTC()
+		position.SentAt = &sentAt
+	}
+	return position, nil
+}
+
+// GetThreadMessageCounts returns how many messages a thread has, and how many of them are unread.
+func GetThreadMessageCounts(ctx context.Context, pool *pgxpool.Pool, threadID string) (total, unread int, err error) {
+	err = pool.QueryRow(ctx, `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE NOT is_read)
+		FROM messages
+		WHERE thread_id = $1
+	`, threadID).Scan(&total, &unread)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count thread messages: %w", err)
+	}
+	return total, unread, nil
+}
+
+// GetThreadSegment returns up to limit messages of a thread that sort before the position, or its newest messages
+// if before is nil. The messages are in the same order as in GetMessagesForThread.
+// hasOlder is true if there are messages before the segment.
+func GetThreadSegment(ctx context.Context, pool *pgxpool.Pool, threadID string, before *ThreadSegmentPosition, limit int) (messages []*models.Message, hasOlder bool, err error) {
+	var beforeSentAt *time.Time
+	var beforeID *string
+	if before != nil {
+		beforeSentAt = before.SentAt
+		beforeID = &before.MessageID
+	}
+
+	// Read one more than the limit to know if there are older messages.
+	// Messages without a date sort as "infinity", so they come last, like in GetMessagesForThread.
+	rows, err := pool.Query(ctx, `
+		SELECT`+threadMessageColumns+`
+		FROM messages
+		WHERE thread_id = $1
+		  AND ($3::uuid IS NULL
+		       OR (COALESCE(sent_at, 'infinity'::timestamptz), id) < (COALESCE($2::timestamptz, 'infinity'::timestamptz), $3::uuid))
+		ORDER BY COALESCE(sent_at, 'infinity'::timestamptz) DESC, id DESC
+		LIMIT $4
+	`, threadID, beforeSentAt, beforeID, limit+1)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get thread segment: %w", err)
+	}
+	defer rows.Close()
+
+	messages, err = scanThreadMessages(rows)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(messages) > limit {
+		messages = messages[:limit]
+		hasOlder = true
+	}
+	slices.Reverse(messages)
+	return messages, hasOlder, nil
+}
+
+// NewThreadSegment describes a segment of a thread, with the messages of the segment in order.
+func NewThreadSegment(messages []*models.Message, hasOlder bool) *models.ThreadSegment {
+	segment := &models.ThreadSegment{MessageCount: len(messages)}
+	if len(messages) == 0 {
+		return segment
+	}
+
+	oldest := messages[0]
+	segment.OldestSentAt = oldest.SentAt
+	// Messages without a date are last, so the newest date is the last one we have
+	for _, msg := range slices.Backward(messages) {
+		if msg.SentAt != nil {
+			segment.NewestSentAt = msg.SentAt
+			break
+		}
+	}
+
+	if hasOlder {
+		cursor := EncodeThreadSegmentCursor(ThreadSegmentPosition{SentAt: oldest.SentAt, MessageID: oldest.ID})
+		segment.OlderCursor = &cursor
+	}
+	return segment
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestThreadSegmentCursor(t *testing.T) {
+	sentAt := time.Date(2025, 3, 14, 15, 9, 26, 535000, time.UTC)
+
+	t.Run("round-trips positions", func(t *testing.T) {
+		for _, position := range []ThreadSegmentPosition{
+			{SentAt: &sentAt, MessageID: "6f1c1f7e-5d43-4c1b-9b8e-1c2d3e4f5a6b"},
+			{MessageID: "6f1c1f7e-5d43-4c1b-9b8e-1c2d3e4f5a6b"},
+		} {
+			decoded, err := DecodeThreadSegmentCursor(EncodeThreadSegmentCursor(position))
+			if err != nil {
+				t.Fatalf("DecodeThreadSegmentCursor failed: %v", err)
+			}
+			if decoded.MessageID != position.MessageID || (decoded.SentAt == nil) != (position.SentAt == nil) ||
+				(decoded.SentAt != nil && !decoded.SentAt.Equal(*position.SentAt)) {
+				t.Errorf("Expected %+v, got %+v", position, decoded)
+			}
+		}
+	})
+
+	t.Run("rejects bad cursors", func(t *testing.T) {
+		for _, cursor := range []string{"not base64!", "bzE6MTAw", "czE6MTIz", "czE6YWJjOmlk"} {
+			if _, err := DecodeThreadSegmentCursor(cursor); !errors.Is(err, ErrInvalidSegmentCursor) {
+				t.Errorf("Expected ErrInvalidSegmentCursor for %q, got %v", cursor, err)
+			}
+		}
+	})
+}
+
+func TestGetThreadSegment(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "segments-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	thread := &models.Thread{UserID: userID, StableThreadID: "mega-thread", Subject: "Mega"}
+	if err := SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+
+	// Five dated messages, the last two with the same date, and one without a date
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		msg := &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         int64(i + 1),
+			IMAPFolderName:  "INBOX",
+			MessageIDHeader: fmt.Sprintf("segment-%d", i),
+			Subject:         "Mega",
+			IsRead:          i%2 == 0,
+		}
+		if i < 5 {
+			sentAt := start.Add(time.Duration(min(i, 3)) * time.Hour)
+			msg.SentAt = &sentAt
+		}
+		if err := SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+	}
+
+	t.Run("counts the whole thread", func(t *testing.T) {
+		total, unread, err := GetThreadMessageCounts(ctx, pool, thread.ID)
+		if err != nil {
+			t.Fatalf("GetThreadMessageCounts failed: %v", err)
+		}
+		if total != 6 || unread != 3 {
+			t.Errorf("Expected 6 messages and 3 unread, got %d and %d", total, unread)
+		}
+	})
+
+	t.Run("walks the segments from the newest", func(t *testing.T) {
+		all, err := GetMessagesForThread(ctx, pool, thread.ID)
+		if err != nil {
+			t.Fatalf("GetMessagesForThread failed: %v", err)
+		}
+
+		var walked []*models.Message
+		var before *ThreadSegmentPosition
+		for segments := 0; ; segments++ {
+			if segments > 3 {
+				t.Fatal("Expected at most 3 segments")
+			}
+			messages, hasOlder, err := GetThreadSegment(ctx, pool, thread.ID, before, 2)
+			if err != nil {
+				t.Fatalf("GetThreadSegment failed: %v", err)
+			}
+			walked = append(messages, walked...)
+
+			segment := NewThreadSegment(messages, hasOlder)
+			if !hasOlder {
+				if segment.OlderCursor != nil {
+					t.Error("Expected no older cursor in the first segment")
+				}
+				break
+			}
+			position, err := DecodeThreadSegmentCursor(*segment.OlderCursor)
+			if err != nil {
+				t.Fatalf("DecodeThreadSegmentCursor failed: %v", err)
+			}
+			before = &position
+		}
+
+		if len(walked) != len(all) {
+			t.Fatalf("Expected %d messages, got %d", len(all), len(walked))
+		}
+		for i := range all {
+			if walked[i].ID != all[i].ID {
+				t.Errorf("Expected message %d to be %s, got %s", i, all[i].MessageIDHeader, walked[i].MessageIDHeader)
+			}
+		}
+		if walked[len(walked)-1].SentAt != nil {
+			t.Error("Expected the message without a date to be last")
+		}
+	})
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -40,14 +42,16 @@ func GetThreadByStableID(ctx context.Context, pool *pgxpool.Pool, userID, stable
 	var thread models.Thread
 
 	err := pool.QueryRow(ctx, `
-		SELECT id, user_id, stable_thread_id, subject
-		FROM threads
-		WHERE user_id = $1 AND stable_thread_id = $2
+		SELECT t.id, t.user_id, t.stable_thread_id, t.subject, s.snoozed_until
+		FROM threads t
+		LEFT JOIN snoozes s ON s.thread_id = t.id
+		WHERE t.user_id = $1 AND t.stable_thread_id = $2
 	`, userID, stableThreadID).Scan(
 		&thread.ID,
 		&thread.UserID,
 		&thread.StableThreadID,
 		&thread.Subject,
+		&thread.SnoozedUntil,
 	)
 
 	if errors.Is(err, pgx.ErrNoRows) {
@@ -87,45 +91,112 @@ func GetThreadByID(ctx context.Context, pool *pgxpool.Pool, threadID string) (*m
 	return &thread, nil
 }
 
+// ThreadListFilter narrows down and reorders thread lists. The zero value lists all threads, newest first.
+type ThreadListFilter struct {
+	// MinImportance leaves out threads with a lower importance score.
+	MinImportance int
+	// ImportantFirst, if above zero, lists threads with at least this importance score before the rest.
+	ImportantFirst int
+	// After, if set, lists only the threads that come after this position, for keyset pagination.
+	// Callers should pass an offset of 0 with it.
+	After *ThreadListPosition
+	// ExcludeSnoozed leaves out snoozed threads, like folder lists do. See the snoozes table.
+	ExcludeSnoozed bool
+}
+
+// ErrInvalidThreadListPosition is returned when a thread list position can't be decoded.
+var ErrInvalidThreadListPosition = errors.New("invalid thread list position")
+
+// ThreadListPosition is where a thread is in a thread list. The list is ordered by these fields, descending.
+type ThreadListPosition struct {
+	// Important is true if the list has important threads first, and this thread is one of them.
+	Important bool
+	// LastSentAt is nil for threads without dated messages, which come last.
+	LastSentAt *time.Time
+	ThreadID   string
+}
+
+// ThreadListPositionOf returns the position of a thread in a list with the filter.
+func ThreadListPositionOf(thread *models.Thread, filter ThreadListFilter) ThreadListPosition {
+	return ThreadListPosition{
+		Important:  filter.ImportantFirst > 0 && thread.ImportanceScore >= filter.ImportantFirst,
+		LastSentAt: thread.LastSentAt,
+		ThreadID:   thread.ID,
+	}
+}
+
+// Encode returns the position as a string, for pagination cursors.
+func (p ThreadListPosition) Encode() string {
+	important := "0"
+	if p.Important {
+		important = "1"
+	}
+	lastSentAt := "-"
+	if p.LastSentAt != nil {
+		lastSentAt = strconv.FormatInt(p.LastSentAt.UnixMicro(), 10)
+	}
+	return important + ":" + lastSentAt + ":" + p.ThreadID
+}
+
+// DecodeThreadListPosition returns the position that Encode returned the string for.
+func DecodeThreadListPosition(encoded string) (*ThreadListPosition, error) {
+	parts := strings.SplitN(encoded, ":", 3)
+	if len(parts) != 3 || (parts[0] != "0" && parts[0] != "1") || parts[2] == "" {
+		return nil, fmt.Errorf("%w: unknown format", ErrInvalidThreadListPosition)
+	}
+
+	position := &ThreadListPosition{Important: parts[0] == "1", ThreadID: parts[2]}
+	if parts[1] != "-" {
+		micros, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad date", ErrInvalidThreadListPosition)
+		}
+		lastSentAt := time.UnixMicro(micros).UTC()
+		position.LastSentAt = &lastSentAt
+	}
+	return position, nil
+}
+
 // GetThreadsForFolder returns threads for a specific folder.
 // It returns threads that have at least one message in the specified folder.
-// Each thread includes message_count (number of messages), last_sent_at (most recent message date),
-// preview_snippet, has_attachments, and first_message_from_address for efficient list view rendering.
+// Each thread includes message_count and unread_count (across all folders), last_sent_at (most recent message date),
+// preview_snippet (the snippet of the latest message that has one), has_attachments, and first_message_from_address
+// for efficient list view rendering.
+// For keyset pagination, use GetFilteredThreadsForFolder with ThreadListFilter.After instead of an offset.
 func GetThreadsForFolder(ctx context.Context, pool *pgxpool.Pool, userID, folderName string, limit, offset int) ([]*models.Thread, error) {
-	rows, err := pool.Query(ctx, `
-        SELECT 
-            t.id, 
-            t.user_id, 
-            t.stable_thread_id, 
-            t.subject, 
-            MAX(m2.sent_at) AS last_sent_at,
-            (SELECT m3.from_address 
-             FROM messages m3 
-             WHERE m3.thread_id = t.id 
-             ORDER BY m3.sent_at NULLS LAST 
-             LIMIT 1) AS first_message_from_address,
-            (SELECT LEFT(m4.body_text, 100)
-             FROM messages m4 
-             WHERE m4.thread_id = t.id 
-             ORDER BY m4.sent_at NULLS LAST 
-             LIMIT 1) AS preview_snippet,
-            EXISTS (
-                SELECT 1 
-                FROM attachments a
-                INNER JOIN messages m5 ON a.message_id = m5.id
-                WHERE m5.thread_id = t.id 
-                AND a.is_inline = false
-            ) AS has_attachments,
-            COUNT(DISTINCT m.id) AS message_count
-        FROM threads t
-        INNER JOIN messages m ON t.id = m.thread_id
-        LEFT JOIN messages m2 ON m2.thread_id = t.id
-        WHERE t.user_id = $1 AND m.imap_folder_name = $2
-        GROUP BY t.id, t.user_id, t.stable_thread_id, t.subject
-        ORDER BY last_sent_at DESC NULLS LAST
-        LIMIT $3 OFFSET $4
-    `, userID, folderName, limit, offset)
+	return GetFilteredThreadsForFolder(ctx, pool, userID, folderName, ThreadListFilter{}, limit, offset)
+}
+
+// GetFilteredThreadsForFolder works like GetThreadsForFolder, but applies the given filter.
+func GetFilteredThreadsForFolder(ctx context.Context, pool *pgxpool.Pool, userID, folderName string, filter ThreadListFilter, limit, offset int) ([]*models.Thread, error) {
+	return getThreadList(ctx, pool, userID, "m.imap_folder_name = $5", filter, limit, offset, folderName)
+}
+
+// GetSnoozedThreads works like GetFilteredThreadsForFolder, but returns the snoozed threads, from any folder.
+// It backs the virtual snoozed folder. See models.SnoozedFolderName.
+func GetSnoozedThreads(ctx context.Context, pool *pgxpool.Pool, userID string, filter ThreadListFilter, limit, offset int) ([]*models.Thread, error) {
+	filter.ExcludeSnoozed = false
+	return getThreadList(ctx, pool, userID, snoozedCondition, filter, limit, offset)
+}
+
+// GetStarredThreads works like GetFilteredThreadsForFolder, but returns the threads with at least one starred
+// message, in any folder. It backs the virtual starred folder. See models.StarredFolderName.
+func GetStarredThreads(ctx context.Context, pool *pgxpool.Pool, userID string, filter ThreadListFilter, limit, offset int) ([]*models.Thread, error) {
+	return getThreadList(ctx, pool, userID, "m.is_starred", filter, limit, offset)
+}
+
+// GetAllMailThreads works like GetFilteredThreadsForFolder, but returns the threads with at least one message
+// outside the excluded folders, usually Trash and Spam. It backs the virtual All Mail folder.
+// See models.AllMailFolderName.
+func GetAllMailThreads(ctx context.Context, pool *pgxpool.Pool, userID string, excludedFolders []string, filter ThreadListFilter, limit, offset int) ([]*models.Thread, error) {
+	return getThreadList(ctx, pool, userID, "m.imap_folder_name <> ALL(coalesce($5::text[], '{}'))", filter, limit, offset, excludedFolders)
+}
 
+// getThreadList returns the threads that have at least one message m matching messageCondition.
+// The condition can refer to args as $5, $6, and so on.
+func getThreadList(ctx context.Context, pool *pgxpool.Pool, userID, messageCondition string, filter ThreadListFilter, limit, offset int, args ...any) ([]*models.Thread, error) {
+	query, queryArgs := threadListQuery(userID, messageCondition, filter, limit, offset, args...)
+	rows, err := pool.Query(ctx, query, queryArgs...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get threads: %w", err)
 	}
@@ -149,6 +220,11 @@ func GetThreadsForFolder(ctx context.Context, pool *pgxpool.Pool, userID, folder
 			&previewSnippet,
 			&hasAttachments,
 			&messageCount,
+			&thread.UnreadCount,
+			&thread.ImportanceScore,
+			&thread.Labels,
+			&thread.SnoozedUntil,
+			&thread.BodyCached,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan thread: %w", err)
 		}
@@ -171,27 +247,109 @@ func GetThreadsForFolder(ctx context.Context, pool *pgxpool.Pool, userID, folder
 	return threads, nil
 }
 
-// GetThreadCountForFolder returns the total count of threads for a specific folder.
-// Uses the materialized count from folder_sync_timestamps if available,
+// threadListQuery returns the query of getThreadList, and its args.
+// Threads sort by threads.last_sent_at, and those without dated messages sort as "-infinity", so they come last,
+// and the keyset comparison works for them. The thread ID breaks ties, so that pages don't skip or repeat threads
+// with the same date. Unless important threads come first, the sort key is that of idx_threads_user_last_sent_at,
+// so a page only reads its own threads from the index, however deep it is.
+func threadListQuery(userID, messageCondition string, filter ThreadListFilter, limit, offset int, args ...any) (string, []any) {
+	if filter.ExcludeSnoozed {
+		messageCondition = "(" + messageCondition + ") AND NOT " + snoozedCondition
+	}
+
+	queryArgs := append([]any{userID, limit, offset, filter.MinImportance}, args...)
+	param := func(value any) string {
+		queryArgs = append(queryArgs, value)
+		return "$" + strconv.Itoa(len(queryArgs))
+	}
+
+	sortKey := []string{"COALESCE(t.last_sent_at, '-infinity'::timestamptz)", "t.id"}
+	var afterKey []string
+	if filter.ImportantFirst > 0 {
+		sortKey = append([]string{"(t.importance_score >= " + param(filter.ImportantFirst) + "::int)"}, sortKey...)
+	}
+	keysetCondition := "TRUE"
+	if filter.After != nil {
+		if filter.ImportantFirst > 0 {
+			afterKey = append(afterKey, param(filter.After.Important)+"::bool")
+		}
+		afterKey = append(afterKey,
+			"COALESCE("+param(filter.After.LastSentAt)+"::timestamptz, '-infinity'::timestamptz)",
+			param(filter.After.ThreadID)+"::uuid")
+		keysetCondition = "(" + strings.Join(sortKey, ", ") + ") < (" + strings.Join(afterKey, ", ") + ")"
+	}
+
+	return `
+        SELECT 
+            t.id, 
+            t.user_id, 
+            t.stable_thread_id, 
+            t.subject, 
+            t.last_sent_at,
+            (SELECT m3.from_address 
+             FROM messages m3 
+             WHERE m3.thread_id = t.id 
+             ORDER BY m3.sent_at NULLS LAST 
+             LIMIT 1) AS first_message_from_address,
+            (SELECT m4.snippet
+             FROM messages m4 
+             WHERE m4.thread_id = t.id AND m4.snippet <> ''
+             ORDER BY m4.sent_at DESC NULLS LAST 
+             LIMIT 1) AS preview_snippet,
+            EXISTS (
+                SELECT 1 
+                FROM attachments a
+                INNER JOIN messages m5 ON a.message_id = m5.id
+                WHERE m5.thread_id = t.id 
+                AND a.is_inline = false
+            ) AS has_attachments,
+            t.message_count,
+            (SELECT COUNT(*) FROM messages m2 WHERE m2.thread_id = t.id AND NOT m2.is_read) AS unread_count,
+            t.importance_score,
+            ARRAY(
+                SELECT DISTINCT ml.label
+                FROM message_labels ml
+                INNER JOIN messages m6 ON ml.message_id = m6.id
+                WHERE m6.thread_id = t.id
+                ORDER BY ml.label
+            ) AS labels,
+            (SELECT s.snoozed_until FROM snoozes s WHERE s.thread_id = t.id) AS snoozed_until,
+            NOT EXISTS (
+                SELECT 1
+                FROM messages m7
+                WHERE m7.thread_id = t.id AND NOT ` + bodyCachedCondition("m7") + `
+            ) AS body_cached
+        FROM threads t
+        WHERE t.user_id = $1 AND t.importance_score >= $4::int
+            AND EXISTS (SELECT 1 FROM messages m WHERE m.thread_id = t.id AND ` + messageCondition + `)
+            AND ` + keysetCondition + `
+        ORDER BY ` + strings.Join(sortKey, " DESC, ") + ` DESC
+        LIMIT $2 OFFSET $3
+    `, queryArgs
+}
+
+// GetThreadCountForFolder returns the total count of threads for a specific folder, without the snoozed ones.
+// Uses the materialized count from folder_sync_timestamps if available and not dirty,
 // otherwise falls back to calculating it on the fly.
 func GetThreadCountForFolder(ctx context.Context, pool *pgxpool.Pool, userID, folderName string) (int, error) {
 	// Try to get the materialized count first
 	var count *int
+	var dirty bool
 	err := pool.QueryRow(ctx, `
-		SELECT thread_count
+		SELECT thread_count, thread_count_dirty
 		FROM folder_sync_timestamps
 		WHERE user_id = $1 AND folder_name = $2
-	`, userID, folderName).Scan(&count)
+	`, userID, folderName).Scan(&count, &dirty)
 
-	if err == nil && count != nil {
-		// Materialized count exists, use it
+	if err == nil && count != nil && !dirty {
+		// Materialized count exists and is up to date, use it
 		return *count, nil
 	}
 
-	// If the row doesn't exist or the count is NULL, fallback to calculating on the fly
+	// If the row doesn't exist, the count is NULL, or it's dirty, fallback to calculating on the fly
 	if !errors.Is(err, pgx.ErrNoRows) && err != nil {
 		// Some other error occurred, log it but continue to fallback
-		log.Printf("Warning: Failed to get materialized thread count: %v", err)
+		slog.WarnContext(ctx, "Failed to get materialized thread count", "error", err)
 	}
 
 	// Fallback: calculate count on the fly
@@ -200,7 +358,7 @@ func GetThreadCountForFolder(ctx context.Context, pool *pgxpool.Pool, userID, fo
         SELECT COUNT(DISTINCT t.id)
         FROM threads t
         INNER JOIN messages m ON t.id = m.thread_id
-        WHERE t.user_id = $1 AND m.imap_folder_name = $2
+        WHERE t.user_id = $1 AND m.imap_folder_name = $2 AND NOT `+snoozedCondition+`
     `, userID, folderName).Scan(&calculatedCount)
 
 	if err != nil {
@@ -210,11 +368,84 @@ func GetThreadCountForFolder(ctx context.Context, pool *pgxpool.Pool, userID, fo
 	return calculatedCount, nil
 }
 
+// GetImportantThreadCountForFolder returns the number of threads in a folder
+// with an importance score of at least minImportance, without the snoozed ones.
+func GetImportantThreadCountForFolder(ctx context.Context, pool *pgxpool.Pool, userID, folderName string, minImportance int) (int, error) {
+	var count int
+	err := pool.QueryRow(ctx, `
+		SELECT COUNT(DISTINCT t.id)
+		FROM threads t
+		INNER JOIN messages m ON t.id = m.thread_id
+		WHERE t.user_id = $1 AND m.imap_folder_name = $2 AND t.importance_score >= $3 AND NOT `+snoozedCondition+`
+	`, userID, folderName, minImportance).Scan(&count)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to get important thread count: %w", err)
+	}
+
+	return count, nil
+}
+
+// GetStarredThreadCount returns the number of threads with at least one starred message
+// and an importance score of at least minImportance.
+func GetStarredThreadCount(ctx context.Context, pool *pgxpool.Pool, userID string, minImportance int) (int, error) {
+	var count int
+	err := pool.QueryRow(ctx, `
+		SELECT COUNT(DISTINCT t.id)
+		FROM threads t
+		INNER JOIN messages m ON t.id = m.thread_id
+		WHERE t.user_id = $1 AND m.is_starred AND t.importance_score >= $2
+	`, userID, minImportance).Scan(&count)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to get starred thread count: %w", err)
+	}
+
+	return count, nil
+}
+
+// GetSnoozedThreadCount returns the number of snoozed threads with an importance score of at least minImportance.
+func GetSnoozedThreadCount(ctx context.Context, pool *pgxpool.Pool, userID string, minImportance int) (int, error) {
+	var count int
+	err := pool.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM threads t
+		WHERE t.user_id = $1 AND t.importance_score >= $2 AND `+snoozedCondition+`
+	`, userID, minImportance).Scan(&count)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to get snoozed thread count: %w", err)
+	}
+
+	return count, nil
+}
+
+// GetAllMailThreadCount returns the number of threads with at least one message outside the excluded folders
+// and an importance score of at least minImportance.
+func GetAllMailThreadCount(ctx context.Context, pool *pgxpool.Pool, userID string, excludedFolders []string, minImportance int) (int, error) {
+	var count int
+	err := pool.QueryRow(ctx, `
+		SELECT COUNT(DISTINCT t.id)
+		FROM threads t
+		INNER JOIN messages m ON t.id = m.thread_id
+		WHERE t.user_id = $1 AND m.imap_folder_name <> ALL(coalesce($2::text[], '{}')) AND t.importance_score >= $3
+	`, userID, excludedFolders, minImportance).Scan(&count)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to get all mail thread count: %w", err)
+	}
+
+	return count, nil
+}
+
 // FolderSyncInfo contains information about folder sync status.
 type FolderSyncInfo struct {
 	SyncedAt      *time.Time
 	LastSyncedUID *int64
 	ThreadCount   int
+	// HighestModSeq and UIDValidity are set if the server supports CONDSTORE. See SetFolderModSeq.
+	HighestModSeq *int64
+	UIDValidity   *int64
 }
 
 // GetFolderSyncInfo returns the sync information for the given folder.
@@ -223,10 +454,10 @@ func GetFolderSyncInfo(ctx context.Context, pool *pgxpool.Pool, userID, folderNa
 	var info FolderSyncInfo
 
 	err := pool.QueryRow(ctx, `
-		SELECT synced_at, last_synced_uid, thread_count
+		SELECT synced_at, last_synced_uid, thread_count, highest_modseq, uid_validity
 		FROM folder_sync_timestamps
-		WHERE user_id = $1 AND folder_name = $2
-	`, userID, folderName).Scan(&info.SyncedAt, &info.LastSyncedUID, &info.ThreadCount)
+		WHERE user_id = $1 AND folder_name = $2 AND synced_at IS NOT NULL
+	`, userID, folderName).Scan(&info.SyncedAt, &info.LastSyncedUID, &info.ThreadCount, &info.HighestModSeq, &info.UIDValidity)
 
 	if errors.Is(err, pgx.ErrNoRows) {
 		return nil, nil
@@ -256,7 +487,40 @@ func SetFolderSyncInfo(ctx context.Context, pool *pgxpool.Pool, userID, folderNa
 	return nil
 }
 
-// UpdateThreadCount updates the materialized thread count for a folder.
+// ResetFolderLastSyncedUID forgets the last UID we synced in the folder, so that its next sync is a full sync.
+// Unlike ClearFolderCache, it keeps the cached messages, which the full sync then only updates.
+func ResetFolderLastSyncedUID(ctx context.Context, pool *pgxpool.Pool, userID, folderName string) error {
+	_, err := pool.Exec(ctx, `
+		UPDATE folder_sync_timestamps SET last_synced_uid = NULL
+		WHERE user_id = $1 AND folder_name = $2
+	`, userID, folderName)
+
+	if err != nil {
+		return fmt.Errorf("failed to reset last synced UID: %w", err)
+	}
+
+	return nil
+}
+
+// SetFolderModSeq saves the HIGHESTMODSEQ and UIDVALIDITY of the folder, for the next CONDSTORE sync.
+// Mod-sequences fit in a BIGINT, since RFC 7162 limits them to 63 bits.
+func SetFolderModSeq(ctx context.Context, pool *pgxpool.Pool, userID, folderName string, uidValidity uint32, highestModSeq uint64) error {
+	_, err := pool.Exec(ctx, `
+		INSERT INTO folder_sync_timestamps (user_id, folder_name, synced_at, highest_modseq, uid_validity)
+		VALUES ($1, $2, now(), $3, $4)
+		ON CONFLICT (user_id, folder_name) DO UPDATE SET
+			highest_modseq = $3,
+			uid_validity = $4
+	`, userID, folderName, int64(highestModSeq), int64(uidValidity))
+
+	if err != nil {
+		return fmt.Errorf("failed to set folder mod-sequence: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateThreadCount updates the materialized thread count for a folder and clears its dirty flag.
 // This should be called in the background after syncing.
 func UpdateThreadCount(ctx context.Context, pool *pgxpool.Pool, userID, folderName string) error {
 	_, err := pool.Exec(ctx, `
@@ -265,8 +529,9 @@ func UpdateThreadCount(ctx context.Context, pool *pgxpool.Pool, userID, folderNa
 			SELECT COUNT(DISTINCT t.id)
 			FROM threads t
 			INNER JOIN messages m ON t.id = m.thread_id
-			WHERE t.user_id = $1 AND m.imap_folder_name = $2
-		)
+			WHERE t.user_id = $1 AND m.imap_folder_name = $2 AND NOT `+snoozedCondition+`
+		),
+		thread_count_dirty = FALSE
 		WHERE user_id = $1 AND folder_name = $2
 	`, userID, folderName)
 
@@ -288,6 +553,10 @@ func EnrichThreadsWithFirstMessageFromAddress(ctx context.Context, pool *pgxpool
 	threadIDMap := make(map[string]*models.Thread)
 	threadIDs := make([]string, 0, len(threads))
 	for _, thread := range threads {
+		// Search results can have threads of uncached messages, which have no ID
+		if thread.ID == "" {
+			continue
+		}
 		threadIDMap[thread.ID] = thread
 		threadIDs = append(threadIDs, thread.ID)
 	}
@@ -324,7 +593,7 @@ func EnrichThreadsWithFirstMessageFromAddress(ctx context.Context, pool *pgxpool
 }
 
 // EnrichThreadsWithPreviewAndAttachments enriches threads with preview snippet, attachment info,
-// message count, and last sent date. This is useful for search results and other cases where
+// message and unread counts, last sent date, and whether their bodies are cached. This is useful for search results and other cases where
 // threads don't have these fields populated.
 func EnrichThreadsWithPreviewAndAttachments(ctx context.Context, pool *pgxpool.Pool, threads []*models.Thread) error {
 	if len(threads) == 0 {
@@ -335,6 +604,10 @@ func EnrichThreadsWithPreviewAndAttachments(ctx context.Context, pool *pgxpool.P
 	threadIDMap := make(map[string]*models.Thread)
 	threadIDs := make([]string, 0, len(threads))
 	for _, thread := range threads {
+		// Like in EnrichThreadsWithFirstMessageFromAddress
+		if thread.ID == "" {
+			continue
+		}
 		threadIDMap[thread.ID] = thread
 		threadIDs = append(threadIDs, thread.ID)
 	}
@@ -343,10 +616,10 @@ func EnrichThreadsWithPreviewAndAttachments(ctx context.Context, pool *pgxpool.P
 	rows, err := pool.Query(ctx, `
 		SELECT 
 			t.id,
-			(SELECT LEFT(m.body_text, 100)
+			(SELECT m.snippet
 			 FROM messages m 
-			 WHERE m.thread_id = t.id 
-			 ORDER BY m.sent_at NULLS LAST 
+			 WHERE m.thread_id = t.id AND m.snippet <> ''
+			 ORDER BY m.sent_at DESC NULLS LAST 
 			 LIMIT 1) AS preview_snippet,
 			EXISTS (
 				SELECT 1 
@@ -356,7 +629,11 @@ func EnrichThreadsWithPreviewAndAttachments(ctx context.Context, pool *pgxpool.P
 				AND a.is_inline = false
 			) AS has_attachments,
 			(SELECT COUNT(*) FROM messages m3 WHERE m3.thread_id = t.id) AS message_count,
-			(SELECT MAX(m4.sent_at) FROM messages m4 WHERE m4.thread_id = t.id) AS last_sent_at
+			(SELECT COUNT(*) FROM messages m3 WHERE m3.thread_id = t.id AND NOT m3.is_read) AS unread_count,
+			t.last_sent_at,
+			NOT EXISTS (
+				SELECT 1 FROM messages m5 WHERE m5.thread_id = t.id AND NOT `+bodyCachedCondition("m5")+`
+			) AS body_cached
 		FROM threads t
 		WHERE t.id = ANY($1)
 	`, threadIDs)
@@ -370,9 +647,10 @@ func EnrichThreadsWithPreviewAndAttachments(ctx context.Context, pool *pgxpool.P
 		var threadID string
 		var previewSnippet *string
 		var hasAttachments bool
-		var messageCount int
+		var messageCount, unreadCount int
 		var lastSentAt *time.Time
-		if err := rows.Scan(&threadID, &previewSnippet, &hasAttachments, &messageCount, &lastSentAt); err != nil {
+		var bodyCached bool
+		if err := rows.Scan(&threadID, &previewSnippet, &hasAttachments, &messageCount, &unreadCount, &lastSentAt, &bodyCached); err != nil {
 			return fmt.Errorf("failed to scan preview and attachment info: %w", err)
 		}
 		if thread, exists := threadIDMap[threadID]; exists {
@@ -381,7 +659,9 @@ func EnrichThreadsWithPreviewAndAttachments(ctx context.Context, pool *pgxpool.P
 			}
 			thread.HasAttachments = hasAttachments
 			thread.MessageCount = messageCount
+			thread.UnreadCount = unreadCount
 			thread.LastSentAt = lastSentAt
+			thread.BodyCached = bodyCached
 		}
 	}
 
@@ -127,6 +127,7 @@ func TestGetThreadsForFolder(t *testing.T) {
 
 	// Create messages in different folders
 	now := time.Now()
+	later := now.Add(time.Minute)
 	msg1 := &models.Message{
 		ThreadID:        thread1.ID,
 		UserID:          userID,
@@ -134,6 +135,8 @@ func TestGetThreadsForFolder(t *testing.T) {
 		IMAPFolderName:  "INBOX",
 		MessageIDHeader: "msg-1",
 		Subject:         "Subject 1",
+		BodyText:        "First message",
+		Snippet:         "First message",
 		SentAt:          &now,
 	}
 	msg2 := &models.Message{
@@ -152,7 +155,11 @@ func TestGetThreadsForFolder(t *testing.T) {
 		IMAPFolderName:  "Sent",
 		MessageIDHeader: "msg-3",
 		Subject:         "Subject 1",
-		SentAt:          &now,
+		BodyText:        "Latest reply",
+		Snippet:         "Latest reply",
+		SentAt:          &later,
+		IsRead:          true,
+		IsStarred:       true,
 	}
 
 	err = SaveMessage(ctx, pool, msg1)
@@ -179,6 +186,29 @@ func TestGetThreadsForFolder(t *testing.T) {
 		}
 	})
 
+	t.Run("counts messages and unread messages across folders", func(t *testing.T) {
+		threads, err := GetThreadsForFolder(ctx, pool, userID, "INBOX", 10, 0)
+		if err != nil {
+			t.Fatalf("GetThreadsForFolder failed: %v", err)
+		}
+
+		var found *models.Thread
+		for _, thread := range threads {
+			if thread.StableThreadID == "thread-1" {
+				found = thread
+			}
+		}
+		if found == nil {
+			t.Fatal("Expected thread-1 in INBOX")
+		}
+		if found.MessageCount != 2 || found.UnreadCount != 1 {
+			t.Errorf("Expected 2 messages with 1 unread, got %d with %d unread", found.MessageCount, found.UnreadCount)
+		}
+		if found.PreviewSnippet != "Latest reply" {
+			t.Errorf("Expected the snippet of the latest message, got %q", found.PreviewSnippet)
+		}
+	})
+
 	t.Run("returns threads for Sent folder", func(t *testing.T) {
 		threads, err := GetThreadsForFolder(ctx, pool, userID, "Sent", 10, 0)
 		if err != nil {
@@ -200,6 +230,78 @@ func TestGetThreadsForFolder(t *testing.T) {
 			t.Errorf("Expected 1 thread with limit 1, got %d", len(threads))
 		}
 	})
+
+	t.Run("continues after a position", func(t *testing.T) {
+		first, err := GetThreadsForFolder(ctx, pool, userID, "INBOX", 1, 0)
+		if err != nil {
+			t.Fatalf("GetThreadsForFolder failed: %v", err)
+		}
+		if len(first) != 1 || first[0].ID != thread1.ID {
+			t.Fatalf("Expected thread 1 first, got %+v", first)
+		}
+
+		filter := ThreadListFilter{}
+		position := ThreadListPositionOf(first[0], filter)
+		filter.After = &position
+		rest, err := GetFilteredThreadsForFolder(ctx, pool, userID, "INBOX", filter, 10, 0)
+		if err != nil {
+			t.Fatalf("GetFilteredThreadsForFolder failed: %v", err)
+		}
+		if len(rest) != 1 || rest[0].ID != thread2.ID {
+			t.Errorf("Expected only thread 2 after thread 1, got %+v", rest)
+		}
+	})
+
+	t.Run("lists threads with starred messages from all folders", func(t *testing.T) {
+		threads, err := GetStarredThreads(ctx, pool, userID, ThreadListFilter{}, 10, 0)
+		if err != nil {
+			t.Fatalf("GetStarredThreads failed: %v", err)
+		}
+		if len(threads) != 1 || threads[0].StableThreadID != "thread-1" || threads[0].MessageCount != 2 {
+			t.Fatalf("Expected thread-1 with both of its messages, got %+v", threads)
+		}
+
+		count, err := GetStarredThreadCount(ctx, pool, userID, 0)
+		if err != nil {
+			t.Fatalf("GetStarredThreadCount failed: %v", err)
+		}
+		if count != 1 {
+			t.Errorf("Expected 1 starred thread, got %d", count)
+		}
+
+		threads, err = GetStarredThreads(ctx, pool, userID, ThreadListFilter{}, 10, 1)
+		if err != nil {
+			t.Fatalf("GetStarredThreads failed: %v", err)
+		}
+		if len(threads) != 0 {
+			t.Errorf("Expected no threads on the second page, got %d", len(threads))
+		}
+	})
+
+	t.Run("lists threads from all folders but the excluded ones", func(t *testing.T) {
+		testCases := []struct {
+			excluded []string
+			expected int
+		}{
+			{nil, 2},
+			{[]string{"Sent"}, 2},
+			{[]string{"INBOX"}, 1},
+			{[]string{"INBOX", "Sent"}, 0},
+		}
+		for _, tc := range testCases {
+			threads, err := GetAllMailThreads(ctx, pool, userID, tc.excluded, ThreadListFilter{}, 10, 0)
+			if err != nil {
+				t.Fatalf("GetAllMailThreads failed: %v", err)
+			}
+			count, err := GetAllMailThreadCount(ctx, pool, userID, tc.excluded, 0)
+			if err != nil {
+				t.Fatalf("GetAllMailThreadCount failed: %v", err)
+			}
+			if len(threads) != tc.expected || count != tc.expected {
+				t.Errorf("Expected %d threads without %v, got %d and a count of %d", tc.expected, tc.excluded, len(threads), count)
+			}
+		}
+	})
 }
 
 func TestGetThreadCountForFolder(t *testing.T) {
@@ -652,6 +754,64 @@ func TestUpdateThreadCount(t *testing.T) {
 // 2. The composite index is being used
 // 3. Materialized count accuracy with large datasets
 // 4. Correctness of pagination results
+func TestThreadLastSentAt(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "last-sent-at@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	thread := &models.Thread{UserID: userID, StableThreadID: "<last-sent-at@example.com>", Subject: "Dates"}
+	if err := SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+
+	assertLastSentAt := func(t *testing.T, expected *time.Time) {
+		t.Helper()
+		var lastSentAt *time.Time
+		if err := pool.QueryRow(ctx, "SELECT last_sent_at FROM threads WHERE id = $1", thread.ID).Scan(&lastSentAt); err != nil {
+			t.Fatalf("Failed to get last_sent_at: %v", err)
+		}
+		if (lastSentAt == nil) != (expected == nil) || (expected != nil && !lastSentAt.Equal(*expected)) {
+			t.Errorf("Expected last_sent_at %v, got %v", expected, lastSentAt)
+		}
+	}
+
+	older := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	newer := older.Add(24 * time.Hour)
+	first := &models.Message{ThreadID: thread.ID, UserID: userID, IMAPUID: 1, IMAPFolderName: "INBOX", MessageIDHeader: "<first@example.com>", SentAt: &older}
+	second := &models.Message{ThreadID: thread.ID, UserID: userID, IMAPUID: 2, IMAPFolderName: "INBOX", MessageIDHeader: "<second@example.com>"}
+
+	t.Run("takes the latest date of new messages", func(t *testing.T) {
+		assertLastSentAt(t, nil)
+		if err := SaveMessage(ctx, pool, first); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+		if err := SaveMessage(ctx, pool, second); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+		assertLastSentAt(t, &older)
+	})
+
+	t.Run("follows changed dates", func(t *testing.T) {
+		second.SentAt = &newer
+		if err := SaveMessage(ctx, pool, second); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+		assertLastSentAt(t, &newer)
+	})
+
+	t.Run("goes back when the latest message is deleted", func(t *testing.T) {
+		if _, err := DeleteMessagesByUID(ctx, pool, userID, "INBOX", []int64{2}); err != nil {
+			t.Fatalf("DeleteMessagesByUID failed: %v", err)
+		}
+		assertLastSentAt(t, &older)
+	})
+}
+
 func TestGetThreadsForFolder_DeepPagination(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping slow pagination test")
@@ -808,6 +968,89 @@ func TestGetThreadsForFolder_DeepPagination(t *testing.T) {
 		t.Logf("Page %d (OFFSET %d) completed in %v, returned %d threads (expected %d)", page, offset, duration, len(threads), expectedCount)
 	})
 
+	t.Run("keyset page 15 matches OFFSET page 15", func(t *testing.T) {
+		previous, err := GetThreadsForFolder(ctx, pool, userID, folderName, threadsPerPage, 13*threadsPerPage)
+		if err != nil {
+			t.Fatalf("GetThreadsForFolder failed: %v", err)
+		}
+		expected, err := GetThreadsForFolder(ctx, pool, userID, folderName, threadsPerPage, 14*threadsPerPage)
+		if err != nil {
+			t.Fatalf("GetThreadsForFolder failed: %v", err)
+		}
+
+		filter := ThreadListFilter{}
+		position := ThreadListPositionOf(previous[len(previous)-1], filter)
+		filter.After = &position
+		threads, err := GetFilteredThreadsForFolder(ctx, pool, userID, folderName, filter, threadsPerPage, 0)
+		if err != nil {
+			t.Fatalf("GetFilteredThreadsForFolder failed: %v", err)
+		}
+
+		if len(threads) != len(expected) {
+			t.Fatalf("Expected %d threads, got %d", len(expected), len(threads))
+		}
+		for i := range threads {
+			if threads[i].ID != expected[i].ID {
+				t.Errorf("Expected thread %d to be %s, got %s", i, expected[i].Subject, threads[i].Subject)
+			}
+		}
+	})
+
+	t.Run("keyset pages come from the last_sent_at index", func(t *testing.T) {
+		previous, err := GetThreadsForFolder(ctx, pool, userID, folderName, threadsPerPage, 13*threadsPerPage)
+		if err != nil {
+			t.Fatalf("GetThreadsForFolder failed: %v", err)
+		}
+		filter := ThreadListFilter{ExcludeSnoozed: true}
+		position := ThreadListPositionOf(previous[len(previous)-1], filter)
+		filter.After = &position
+		query, args := threadListQuery(userID, "m.imap_folder_name = $5", filter, threadsPerPage, 0, folderName)
+
+		// The tables are small, so make the alternatives look expensive, like they are for big folders
+		tx, err := pool.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		defer func() { _ = tx.Rollback(ctx) }()
+		if _, err := tx.Exec(ctx, "SET LOCAL enable_seqscan = off"); err != nil {
+			t.Fatalf("Failed to disable sequential scans: %v", err)
+		}
+		if _, err := tx.Exec(ctx, "SET LOCAL enable_sort = off"); err != nil {
+			t.Fatalf("Failed to disable sorts: %v", err)
+		}
+
+		rows, err := tx.Query(ctx, "EXPLAIN "+query, args...)
+		if err != nil {
+			t.Fatalf("EXPLAIN failed: %v", err)
+		}
+		var planLines []string
+		for rows.Next() {
+			var line string
+			if err := rows.Scan(&line); err != nil {
+				t.Fatalf("Failed to scan plan: %v", err)
+			}
+			planLines = append(planLines, line)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatalf("Failed to read plan: %v", err)
+		}
+		plan := strings.Join(planLines, "\n")
+
+		// A backward scan returns the threads in the list's order, and the keyset condition is its start
+		if !strings.Contains(plan, "Scan Backward using idx_threads_user_last_sent_at") {
+			t.Errorf("Expected the page to come from idx_threads_user_last_sent_at, got:\n%s", plan)
+		}
+		keysetInIndex := false
+		for _, line := range planLines {
+			if strings.Contains(line, "Index Cond:") && strings.Contains(line, "ROW(") {
+				keysetInIndex = true
+			}
+		}
+		if !keysetInIndex {
+			t.Errorf("Expected the keyset condition to be an index condition, got:\n%s", plan)
+		}
+	})
+
 	t.Run("index is being used for pagination query", func(t *testing.T) {
 		// Use EXPLAIN to verify the index is being used
 		rows, err := pool.Query(ctx, `
@@ -857,3 +1100,42 @@ func TestGetThreadsForFolder_DeepPagination(t *testing.T) {
 		}
 	})
 }
+
+func TestThreadListPosition(t *testing.T) {
+	lastSentAt := time.Date(2025, 3, 14, 15, 9, 26, 535000, time.UTC)
+
+	t.Run("round-trips positions", func(t *testing.T) {
+		for _, position := range []ThreadListPosition{
+			{Important: true, LastSentAt: &lastSentAt, ThreadID: "6f1c1f7e-5d43-4c1b-9b8e-1c2d3e4f5a6b"},
+			{ThreadID: "6f1c1f7e-5d43-4c1b-9b8e-1c2d3e4f5a6b"},
+		} {
+			decoded, err := DecodeThreadListPosition(position.Encode())
+			if err != nil {
+				t.Fatalf("DecodeThreadListPosition failed: %v", err)
+			}
+			if decoded.Important != position.Important || decoded.ThreadID != position.ThreadID ||
+				(decoded.LastSentAt == nil) != (position.LastSentAt == nil) ||
+				(decoded.LastSentAt != nil && !decoded.LastSentAt.Equal(*position.LastSentAt)) {
+				t.Errorf("Expected %+v, got %+v", position, decoded)
+			}
+		}
+	})
+
+	t.Run("rejects bad positions", func(t *testing.T) {
+		for _, encoded := range []string{"", "2:-:id", "1:abc:id", "1:-:", "1:-"} {
+			if _, err := DecodeThreadListPosition(encoded); !errors.Is(err, ErrInvalidThreadListPosition) {
+				t.Errorf("Expected ErrInvalidThreadListPosition for %q, got %v", encoded, err)
+			}
+		}
+	})
+
+	t.Run("marks important threads only when they come first", func(t *testing.T) {
+		thread := &models.Thread{ID: "id", ImportanceScore: 80, LastSentAt: &lastSentAt}
+		if ThreadListPositionOf(thread, ThreadListFilter{}).Important {
+			t.Error("Expected no importance without ImportantFirst")
+		}
+		if !ThreadListPositionOf(thread, ThreadListFilter{ImportantFirst: 50}).Important {
+			t.Error("Expected importance with ImportantFirst")
+		}
+	})
+}
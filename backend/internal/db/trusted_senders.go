@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// ErrTrustedSenderNotFound is returned when a trusted sender doesn't exist or belongs to another user.
+var ErrTrustedSenderNotFound = errors.New("trusted sender not found")
+
+// GetTrustedSenders returns the trusted senders of the user, sorted by address.
+func GetTrustedSenders(ctx context.Context, pool *pgxpool.Pool, userID string) ([]*models.TrustedSender, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT id, user_id, email, created_at
+		FROM trusted_senders
+		WHERE user_id = $1
+		ORDER BY email
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trusted senders: %w", err)
+	}
+	defer rows.Close()
+
+	senders := []*models.TrustedSender{}
+	for rows.Next() {
+		var sender models.TrustedSender
+		if err := rows.Scan(&sender.ID, &sender.UserID, &sender.Email, &sender.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan trusted sender: %w", err)
+		}
+		senders = append(senders, &sender)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating trusted senders: %w", err)
+	}
+
+	return senders, nil
+}
+
+// GetTrustedSenderEmails returns the lowercase addresses of the user's trusted senders.
+func GetTrustedSenderEmails(ctx context.Context, pool *pgxpool.Pool, userID string) (map[string]bool, error) {
+	senders, err := GetTrustedSenders(ctx, pool, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	emails := make(map[string]bool, len(senders))
+	for _, sender := range senders {
+		emails[sender.Email] = true
+	}
+	return emails, nil
+}
+
+// TrustSender adds the address to the user's trusted senders, in lowercase, and returns the trusted sender.
+// Trusting a sender twice is fine, it returns the existing one.
+func TrustSender(ctx context.Context, pool *pgxpool.Pool, userID, email string) (*models.TrustedSender, error) {
+	sender := models.TrustedSender{UserID: userID}
+	// The no-op update makes RETURNING work for existing rows too
+	err := pool.QueryRow(ctx, `
+		INSERT INTO trusted_senders (user_id, email)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id, email) DO UPDATE SET email = EXCLUDED.email
+		RETURNING id, email, created_at
+	`, userID, strings.ToLower(email)).Scan(&sender.ID, &sender.Email, &sender.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to trust sender: %w", err)
+	}
+	return &sender, nil
+}
+
+// DeleteTrustedSender removes a sender from the user's trusted senders.
+// Returns ErrTrustedSenderNotFound if it doesn't exist or belongs to another user.
+func DeleteTrustedSender(ctx context.Context, pool *pgxpool.Pool, userID, senderID string) error {
+	result, err := pool.Exec(ctx, `
+		DELETE FROM trusted_senders
+		WHERE id = $1 AND user_id = $2
+	`, senderID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete trusted sender: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrTrustedSenderNotFound
+	}
+	return nil
+}
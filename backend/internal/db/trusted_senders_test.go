@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestTrustedSenders(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "trusted-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	otherUserID, err := GetOrCreateUser(ctx, pool, "trusted-other@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	var trusted string
+
+	t.Run("trusts a sender with a lowercase address", func(t *testing.T) {
+		sender, err := TrustSender(ctx, pool, userID, "News@Example.com")
+		if err != nil {
+			t.Fatalf("TrustSender failed: %v", err)
+		}
+		if sender.ID == "" || sender.Email != "news@example.com" {
+			t.Errorf("Unexpected trusted sender: %+v", sender)
+		}
+		trusted = sender.ID
+	})
+
+	t.Run("returns the existing sender when trusting it again", func(t *testing.T) {
+		sender, err := TrustSender(ctx, pool, userID, "news@example.com")
+		if err != nil {
+			t.Fatalf("TrustSender failed: %v", err)
+		}
+		if sender.ID != trusted {
+			t.Errorf("Expected ID %s, got %s", trusted, sender.ID)
+		}
+	})
+
+	t.Run("returns the emails of the user only", func(t *testing.T) {
+		if _, err := TrustSender(ctx, pool, otherUserID, "other@example.com"); err != nil {
+			t.Fatalf("TrustSender failed: %v", err)
+		}
+
+		emails, err := GetTrustedSenderEmails(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetTrustedSenderEmails failed: %v", err)
+		}
+		if len(emails) != 1 || !emails["news@example.com"] {
+			t.Errorf("Expected only news@example.com, got %v", emails)
+		}
+	})
+
+	t.Run("doesn't delete other users' senders", func(t *testing.T) {
+		if err := DeleteTrustedSender(ctx, pool, otherUserID, trusted); !errors.Is(err, ErrTrustedSenderNotFound) {
+			t.Errorf("Expected ErrTrustedSenderNotFound, got %v", err)
+		}
+	})
+
+	t.Run("deletes a trusted sender", func(t *testing.T) {
+		if err := DeleteTrustedSender(ctx, pool, userID, trusted); err != nil {
+			t.Fatalf("DeleteTrustedSender failed: %v", err)
+		}
+		senders, err := GetTrustedSenders(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetTrustedSenders failed: %v", err)
+		}
+		if len(senders) != 0 {
+			t.Errorf("Expected no trusted senders, got %v", senders)
+		}
+	})
+}
@@ -25,3 +25,12 @@ func GetOrCreateUser(ctx context.Context, pool *pgxpool.Pool, email string) (str
 
 	return userID, nil
 }
+
+// GetUserEmail returns the user's login email.
+func GetUserEmail(ctx context.Context, pool *pgxpool.Pool, userID string) (string, error) {
+	var email string
+	if err := pool.QueryRow(ctx, `SELECT email FROM users WHERE id = $1`, userID).Scan(&email); err != nil {
+		return "", fmt.Errorf("failed to get user email: %w", err)
+	}
+	return email, nil
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// These match the column defaults in user_preferences.
+const (
+	DefaultUndoSendDelaySeconds     = 20
+	DefaultPaginationThreadsPerPage = 100
+)
+
+// GetUserPreferences returns the preferences for the given user.
+// Users who never saved preferences get the defaults, so this never returns a not-found error.
+func GetUserPreferences(ctx context.Context, pool *pgxpool.Pool, userID string) (*models.UserPreferences, error) {
+	prefs := models.UserPreferences{UserID: userID}
+	var ui []byte
+
+	err := pool.QueryRow(ctx, `
+		SELECT undo_send_delay_seconds, pagination_threads_per_page, ui, created_at, updated_at
+		FROM user_preferences
+		WHERE user_id = $1
+	`, userID).Scan(
+		&prefs.UndoSendDelaySeconds,
+		&prefs.PaginationThreadsPerPage,
+		&ui,
+		&prefs.CreatedAt,
+		&prefs.UpdatedAt,
+	)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		prefs.UndoSendDelaySeconds = DefaultUndoSendDelaySeconds
+		prefs.PaginationThreadsPerPage = DefaultPaginationThreadsPerPage
+		prefs.UI = map[string]json.RawMessage{}
+		return &prefs, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user preferences: %w", err)
+	}
+
+	if err := json.Unmarshal(ui, &prefs.UI); err != nil {
+		return nil, fmt.Errorf("failed to decode UI preferences: %w", err)
+	}
+	if prefs.UI == nil {
+		prefs.UI = map[string]json.RawMessage{}
+	}
+
+	return &prefs, nil
+}
+
+// SaveUserPreferences saves the preferences for the given user.
+func SaveUserPreferences(ctx context.Context, pool *pgxpool.Pool, prefs *models.UserPreferences) error {
+	ui := prefs.UI
+	if ui == nil {
+		ui = map[string]json.RawMessage{}
+	}
+	uiJSON, err := json.Marshal(ui)
+	if err != nil {
+		return fmt.Errorf("failed to encode UI preferences: %w", err)
+	}
+
+	err = pool.QueryRow(ctx, `
+		INSERT INTO user_preferences (user_id, undo_send_delay_seconds, pagination_threads_per_page, ui)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE SET
+			undo_send_delay_seconds = EXCLUDED.undo_send_delay_seconds,
+			pagination_threads_per_page = EXCLUDED.pagination_threads_per_page,
+			ui = EXCLUDED.ui,
+			updated_at = NOW()
+		RETURNING created_at, updated_at
+	`, prefs.UserID, prefs.UndoSendDelaySeconds, prefs.PaginationThreadsPerPage, string(uiJSON)).Scan(&prefs.CreatedAt, &prefs.UpdatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to save user preferences: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestUserPreferences(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "prefs-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	t.Run("returns defaults for user without preferences", func(t *testing.T) {
+		prefs, err := GetUserPreferences(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetUserPreferences failed: %v", err)
+		}
+
+		if prefs.UndoSendDelaySeconds != DefaultUndoSendDelaySeconds {
+			t.Errorf("Expected UndoSendDelaySeconds %d, got %d", DefaultUndoSendDelaySeconds, prefs.UndoSendDelaySeconds)
+		}
+		if prefs.PaginationThreadsPerPage != DefaultPaginationThreadsPerPage {
+			t.Errorf("Expected PaginationThreadsPerPage %d, got %d", DefaultPaginationThreadsPerPage, prefs.PaginationThreadsPerPage)
+		}
+		if prefs.UI == nil || len(prefs.UI) != 0 {
+			t.Errorf("Expected empty UI preferences, got %v", prefs.UI)
+		}
+	})
+
+	t.Run("saves and retrieves preferences", func(t *testing.T) {
+		prefs := &models.UserPreferences{
+			UserID:                   userID,
+			UndoSendDelaySeconds:     10,
+			PaginationThreadsPerPage: 25,
+			UI: map[string]json.RawMessage{
+				"theme":   json.RawMessage(`"dark"`),
+				"density": json.RawMessage(`{"compact":true}`),
+			},
+		}
+
+		if err := SaveUserPreferences(ctx, pool, prefs); err != nil {
+			t.Fatalf("SaveUserPreferences failed: %v", err)
+		}
+
+		retrieved, err := GetUserPreferences(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetUserPreferences failed: %v", err)
+		}
+
+		if retrieved.UndoSendDelaySeconds != 10 {
+			t.Errorf("Expected UndoSendDelaySeconds 10, got %d", retrieved.UndoSendDelaySeconds)
+		}
+		if retrieved.PaginationThreadsPerPage != 25 {
+			t.Errorf("Expected PaginationThreadsPerPage 25, got %d", retrieved.PaginationThreadsPerPage)
+		}
+		if string(retrieved.UI["theme"]) != `"dark"` {
+			t.Errorf("Expected theme \"dark\", got %s", retrieved.UI["theme"])
+		}
+		if retrieved.UpdatedAt.IsZero() {
+			t.Error("Expected UpdatedAt to be set")
+		}
+	})
+}
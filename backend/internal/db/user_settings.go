@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -27,6 +29,36 @@ func UserSettingsExist(ctx context.Context, pool *pgxpool.Pool, userID string) (
 	return exists, nil
 }
 
+// GetUsersWithSettings returns the IDs and emails of all users who have saved their settings,
+// so that we can connect to their mail servers.
+func GetUsersWithSettings(ctx context.Context, pool *pgxpool.Pool) ([]*models.User, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT u.id, u.email
+		FROM user_settings s
+		JOIN users u ON u.id = s.user_id
+		ORDER BY u.id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users with settings: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Email); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, &user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users with settings: %w", err)
+	}
+
+	return users, nil
+}
+
 // GetUserSettings returns the user settings for the given user.
 func GetUserSettings(ctx context.Context, pool *pgxpool.Pool, userID string) (*models.UserSettings, error) {
 	var settings models.UserSettings
@@ -34,28 +66,36 @@ func GetUserSettings(ctx context.Context, pool *pgxpool.Pool, userID string) (*m
 	err := pool.QueryRow(ctx, `
 		SELECT 
 			user_id,
-			undo_send_delay_seconds,
-			pagination_threads_per_page,
 			imap_server_hostname,
+			COALESCE(imap_port, 0),
+			COALESCE(imap_security, ''),
+			imap_skip_tls_verify,
 			imap_username,
 			encrypted_imap_password,
 			smtp_server_hostname,
 			smtp_username,
 			encrypted_smtp_password,
+			COALESCE(oauth_provider, ''),
+			encrypted_oauth_refresh_token,
+			oauth_token_expires_at,
 			created_at,
 			updated_at
 		FROM user_settings
 		WHERE user_id = $1
 	`, userID).Scan(
 		&settings.UserID,
-		&settings.UndoSendDelaySeconds,
-		&settings.PaginationThreadsPerPage,
 		&settings.IMAPServerHostname,
+		&settings.IMAPPort,
+		&settings.IMAPSecurity,
+		&settings.IMAPSkipTLSVerify,
 		&settings.IMAPUsername,
 		&settings.EncryptedIMAPPassword,
 		&settings.SMTPServerHostname,
 		&settings.SMTPUsername,
 		&settings.EncryptedSMTPPassword,
+		&settings.OAuthProvider,
+		&settings.EncryptedOAuthRefreshToken,
+		&settings.OAuthTokenExpiresAt,
 		&settings.CreatedAt,
 		&settings.UpdatedAt,
 	)
@@ -71,40 +111,72 @@ func GetUserSettings(ctx context.Context, pool *pgxpool.Pool, userID string) (*m
 	return &settings, nil
 }
 
-// SaveUserSettings saves the user settings for the given user.
+// GetOwnAddresses returns the IMAP and SMTP usernames of the user that look like email addresses.
+// Queries that tell the user's own messages apart add the user's login email to these.
+func GetOwnAddresses(ctx context.Context, pool *pgxpool.Pool, userID string) ([]string, error) {
+	settings, err := GetUserSettings(ctx, pool, userID)
+	if errors.Is(err, ErrUserSettingsNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get own addresses: %w", err)
+	}
+
+	var addresses []string
+	for _, username := range []string{settings.IMAPUsername, settings.SMTPUsername} {
+		if strings.Contains(username, "@") {
+			addresses = append(addresses, username)
+		}
+	}
+	return addresses, nil
+}
+
+// SaveUserSettings saves the user settings for the given user, including the OAuth token fields.
 func SaveUserSettings(ctx context.Context, pool *pgxpool.Pool, settings *models.UserSettings) error {
 	_, err := pool.Exec(ctx, `
 		INSERT INTO user_settings (
 			user_id,
-			undo_send_delay_seconds,
-			pagination_threads_per_page,
 			imap_server_hostname,
 			imap_username,
 			encrypted_imap_password,
 			smtp_server_hostname,
 			smtp_username,
-			encrypted_smtp_password
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			encrypted_smtp_password,
+			oauth_provider,
+			encrypted_oauth_refresh_token,
+			oauth_token_expires_at,
+			imap_port,
+			imap_security,
+			imap_skip_tls_verify
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10, NULLIF($11, 0), NULLIF($12, ''), $13)
 		ON CONFLICT (user_id) DO UPDATE SET
-			undo_send_delay_seconds = EXCLUDED.undo_send_delay_seconds,
-			pagination_threads_per_page = EXCLUDED.pagination_threads_per_page,
 			imap_server_hostname = EXCLUDED.imap_server_hostname,
+			imap_port = EXCLUDED.imap_port,
+			imap_security = EXCLUDED.imap_security,
+			imap_skip_tls_verify = EXCLUDED.imap_skip_tls_verify,
 			imap_username = EXCLUDED.imap_username,
 			encrypted_imap_password = EXCLUDED.encrypted_imap_password,
 			smtp_server_hostname = EXCLUDED.smtp_server_hostname,
 			smtp_username = EXCLUDED.smtp_username,
 			encrypted_smtp_password = EXCLUDED.encrypted_smtp_password,
+			oauth_provider = EXCLUDED.oauth_provider,
+			encrypted_oauth_refresh_token = EXCLUDED.encrypted_oauth_refresh_token,
+			oauth_token_expires_at = EXCLUDED.oauth_token_expires_at,
 			updated_at = NOW()
 	`,
 		settings.UserID,
-		settings.UndoSendDelaySeconds,
-		settings.PaginationThreadsPerPage,
 		settings.IMAPServerHostname,
 		settings.IMAPUsername,
 		settings.EncryptedIMAPPassword,
 		settings.SMTPServerHostname,
 		settings.SMTPUsername,
 		settings.EncryptedSMTPPassword,
+		settings.OAuthProvider,
+		settings.EncryptedOAuthRefreshToken,
+		settings.OAuthTokenExpiresAt,
+		settings.IMAPPort,
+		settings.IMAPSecurity,
+		settings.IMAPSkipTLSVerify,
 	)
 
 	if err != nil {
@@ -113,3 +185,57 @@ func SaveUserSettings(ctx context.Context, pool *pgxpool.Pool, settings *models.
 
 	return nil
 }
+
+// GetUserIDsWithExpiringOAuthTokens returns the IDs of users who connected with OAuth,
+// and whose access token expires before the given time.
+func GetUserIDsWithExpiringOAuthTokens(ctx context.Context, pool *pgxpool.Pool, before time.Time) ([]string, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT user_id
+		FROM user_settings
+		WHERE oauth_provider IS NOT NULL AND oauth_token_expires_at < $1
+		ORDER BY oauth_token_expires_at
+	`, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users with expiring OAuth tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("failed to scan user ID: %w", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users with expiring OAuth tokens: %w", err)
+	}
+
+	return userIDs, nil
+}
+
+// SaveOAuthTokens saves refreshed OAuth tokens: the encrypted passwords, the refresh token, and the expiry.
+// It only writes if the user is still connected with the same provider, so that a refresh that finishes
+// after the user switched back to passwords doesn't overwrite their new password.
+// Returns ErrUserSettingsNotFound if there's nothing to update.
+func SaveOAuthTokens(ctx context.Context, pool *pgxpool.Pool, settings *models.UserSettings) error {
+	result, err := pool.Exec(ctx, `
+		UPDATE user_settings SET
+			encrypted_imap_password = $3,
+			encrypted_smtp_password = $4,
+			encrypted_oauth_refresh_token = $5,
+			oauth_token_expires_at = $6,
+			updated_at = NOW()
+		WHERE user_id = $1 AND oauth_provider = $2
+	`, settings.UserID, settings.OAuthProvider, settings.EncryptedIMAPPassword, settings.EncryptedSMTPPassword,
+		settings.EncryptedOAuthRefreshToken, settings.OAuthTokenExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to save OAuth tokens: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrUserSettingsNotFound
+	}
+	return nil
+}
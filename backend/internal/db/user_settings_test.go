@@ -35,15 +35,13 @@ func TestUserSettingsExist(t *testing.T) {
 
 	t.Run("returns true when settings exist", func(t *testing.T) {
 		settings := &models.UserSettings{
-			UserID:                   userID,
-			UndoSendDelaySeconds:     20,
-			PaginationThreadsPerPage: 100,
-			IMAPServerHostname:       "imap.example.com",
-			IMAPUsername:             "user@example.com",
-			EncryptedIMAPPassword:    []byte("encrypted"),
-			SMTPServerHostname:       "smtp.example.com",
-			SMTPUsername:             "user@example.com",
-			EncryptedSMTPPassword:    []byte("encrypted"),
+			UserID:                userID,
+			IMAPServerHostname:    "imap.example.com",
+			IMAPUsername:          "user@example.com",
+			EncryptedIMAPPassword: []byte("encrypted"),
+			SMTPServerHostname:    "smtp.example.com",
+			SMTPUsername:          "user@example.com",
+			EncryptedSMTPPassword: []byte("encrypted"),
 		}
 
 		err := SaveUserSettings(ctx, pool, settings)
@@ -76,15 +74,13 @@ func TestSaveAndGetUserSettings(t *testing.T) {
 
 	t.Run("saves and retrieves settings", func(t *testing.T) {
 		settings := &models.UserSettings{
-			UserID:                   userID,
-			UndoSendDelaySeconds:     30,
-			PaginationThreadsPerPage: 50,
-			IMAPServerHostname:       "imap.test.com",
-			IMAPUsername:             "test_user",
-			EncryptedIMAPPassword:    []byte("encrypted_imap_pass"),
-			SMTPServerHostname:       "smtp.test.com",
-			SMTPUsername:             "test_user",
-			EncryptedSMTPPassword:    []byte("encrypted_smtp_pass"),
+			UserID:                userID,
+			IMAPServerHostname:    "imap.test.com",
+			IMAPUsername:          "test_user",
+			EncryptedIMAPPassword: []byte("encrypted_imap_pass"),
+			SMTPServerHostname:    "smtp.test.com",
+			SMTPUsername:          "test_user",
+			EncryptedSMTPPassword: []byte("encrypted_smtp_pass"),
 		}
 
 		err := SaveUserSettings(ctx, pool, settings)
@@ -100,9 +96,6 @@ func TestSaveAndGetUserSettings(t *testing.T) {
 		if retrieved.UserID != settings.UserID {
 			t.Errorf("Expected UserID %s, got %s", settings.UserID, retrieved.UserID)
 		}
-		if retrieved.UndoSendDelaySeconds != settings.UndoSendDelaySeconds {
-			t.Errorf("Expected UndoSendDelaySeconds %d, got %d", settings.UndoSendDelaySeconds, retrieved.UndoSendDelaySeconds)
-		}
 		if retrieved.IMAPServerHostname != settings.IMAPServerHostname {
 			t.Errorf("Expected IMAPServerHostname %s, got %s", settings.IMAPServerHostname, retrieved.IMAPServerHostname)
 		}
@@ -113,15 +106,16 @@ func TestSaveAndGetUserSettings(t *testing.T) {
 
 	t.Run("updates existing settings", func(t *testing.T) {
 		updatedSettings := &models.UserSettings{
-			UserID:                   userID,
-			UndoSendDelaySeconds:     60,
-			PaginationThreadsPerPage: 200,
-			IMAPServerHostname:       "imap.updated.com",
-			IMAPUsername:             "updated_user",
-			EncryptedIMAPPassword:    []byte("new_encrypted_imap"),
-			SMTPServerHostname:       "smtp.updated.com",
-			SMTPUsername:             "updated_user",
-			EncryptedSMTPPassword:    []byte("new_encrypted_smtp"),
+			UserID:                userID,
+			IMAPServerHostname:    "imap.updated.com",
+			IMAPPort:              143,
+			IMAPSecurity:          models.IMAPSecuritySTARTTLS,
+			IMAPSkipTLSVerify:     true,
+			IMAPUsername:          "updated_user",
+			EncryptedIMAPPassword: []byte("new_encrypted_imap"),
+			SMTPServerHostname:    "smtp.updated.com",
+			SMTPUsername:          "updated_user",
+			EncryptedSMTPPassword: []byte("new_encrypted_smtp"),
 		}
 
 		err := SaveUserSettings(ctx, pool, updatedSettings)
@@ -134,12 +128,12 @@ func TestSaveAndGetUserSettings(t *testing.T) {
 			t.Fatalf("GetUserSettings failed: %v", err)
 		}
 
-		if retrieved.UndoSendDelaySeconds != 60 {
-			t.Errorf("Expected updated UndoSendDelaySeconds 60, got %d", retrieved.UndoSendDelaySeconds)
-		}
 		if retrieved.IMAPServerHostname != "imap.updated.com" {
 			t.Errorf("Expected updated IMAPServerHostname, got %s", retrieved.IMAPServerHostname)
 		}
+		if retrieved.IMAPPort != 143 || retrieved.IMAPSecurity != models.IMAPSecuritySTARTTLS || !retrieved.IMAPSkipTLSVerify {
+			t.Errorf("Unexpected IMAP connection options: %d, %q, %v", retrieved.IMAPPort, retrieved.IMAPSecurity, retrieved.IMAPSkipTLSVerify)
+		}
 	})
 
 	t.Run("returns error for non-existent user", func(t *testing.T) {
@@ -163,15 +157,13 @@ func TestSaveUserSettingsUpdatesTimestamp(t *testing.T) {
 	}
 
 	settings := &models.UserSettings{
-		UserID:                   userID,
-		UndoSendDelaySeconds:     20,
-		PaginationThreadsPerPage: 100,
-		IMAPServerHostname:       "imap.example.com",
-		IMAPUsername:             "user",
-		EncryptedIMAPPassword:    []byte("pass"),
-		SMTPServerHostname:       "smtp.example.com",
-		SMTPUsername:             "user",
-		EncryptedSMTPPassword:    []byte("pass"),
+		UserID:                userID,
+		IMAPServerHostname:    "imap.example.com",
+		IMAPUsername:          "user",
+		EncryptedIMAPPassword: []byte("pass"),
+		SMTPServerHostname:    "smtp.example.com",
+		SMTPUsername:          "user",
+		EncryptedSMTPPassword: []byte("pass"),
 	}
 
 	err = SaveUserSettings(ctx, pool, settings)
@@ -189,7 +181,7 @@ func TestSaveUserSettingsUpdatesTimestamp(t *testing.T) {
 
 	time.Sleep(100 * time.Millisecond)
 
-	settings.UndoSendDelaySeconds = 30
+	settings.IMAPUsername = "changed_user"
 	err = SaveUserSettings(ctx, pool, settings)
 	if err != nil {
 		t.Fatalf("SaveUserSettings (update) failed: %v", err)
@@ -207,3 +199,84 @@ func TestSaveUserSettingsUpdatesTimestamp(t *testing.T) {
 		t.Error("Expected updated_at to be updated after second save")
 	}
 }
+
+func TestSaveOAuthTokens(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "oauth-user@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	expiresAt := time.Now().Add(time.Minute).UTC().Truncate(time.Microsecond)
+	settings := &models.UserSettings{
+		UserID:                     userID,
+		IMAPServerHostname:         "imap.gmail.com:993",
+		IMAPUsername:               "oauth-user@example.com",
+		EncryptedIMAPPassword:      []byte("access-1"),
+		SMTPServerHostname:         "smtp.gmail.com:465",
+		SMTPUsername:               "oauth-user@example.com",
+		EncryptedSMTPPassword:      []byte("access-1"),
+		OAuthProvider:              "google",
+		EncryptedOAuthRefreshToken: []byte("refresh"),
+		OAuthTokenExpiresAt:        &expiresAt,
+	}
+	if err := SaveUserSettings(ctx, pool, settings); err != nil {
+		t.Fatalf("SaveUserSettings failed: %v", err)
+	}
+
+	t.Run("finds users with expiring tokens", func(t *testing.T) {
+		userIDs, err := GetUserIDsWithExpiringOAuthTokens(ctx, pool, time.Now().Add(5*time.Minute))
+		if err != nil {
+			t.Fatalf("GetUserIDsWithExpiringOAuthTokens failed: %v", err)
+		}
+		if len(userIDs) != 1 || userIDs[0] != userID {
+			t.Errorf("Expected [%s], got %v", userID, userIDs)
+		}
+
+		userIDs, err = GetUserIDsWithExpiringOAuthTokens(ctx, pool, time.Now())
+		if err != nil {
+			t.Fatalf("GetUserIDsWithExpiringOAuthTokens failed: %v", err)
+		}
+		if len(userIDs) != 0 {
+			t.Errorf("Expected no users, got %v", userIDs)
+		}
+	})
+
+	t.Run("saves refreshed tokens", func(t *testing.T) {
+		newExpiresAt := expiresAt.Add(time.Hour)
+		settings.EncryptedIMAPPassword = []byte("access-2")
+		settings.EncryptedSMTPPassword = []byte("access-2")
+		settings.OAuthTokenExpiresAt = &newExpiresAt
+		if err := SaveOAuthTokens(ctx, pool, settings); err != nil {
+			t.Fatalf("SaveOAuthTokens failed: %v", err)
+		}
+
+		saved, err := GetUserSettings(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetUserSettings failed: %v", err)
+		}
+		if string(saved.EncryptedIMAPPassword) != "access-2" || saved.OAuthProvider != "google" ||
+			saved.OAuthTokenExpiresAt == nil || !saved.OAuthTokenExpiresAt.Equal(newExpiresAt) {
+			t.Errorf("Unexpected settings: %+v", saved)
+		}
+	})
+
+	t.Run("doesn't save tokens after the user switched to a password", func(t *testing.T) {
+		withPassword := *settings
+		withPassword.OAuthProvider = ""
+		withPassword.EncryptedOAuthRefreshToken = nil
+		withPassword.OAuthTokenExpiresAt = nil
+		if err := SaveUserSettings(ctx, pool, &withPassword); err != nil {
+			t.Fatalf("SaveUserSettings failed: %v", err)
+		}
+
+		err := SaveOAuthTokens(ctx, pool, settings)
+		if !errors.Is(err, ErrUserSettingsNotFound) {
+			t.Errorf("Expected ErrUserSettingsNotFound, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// DefaultVacationIntervalDays matches the column default in vacation_responders.
+const DefaultVacationIntervalDays = 7
+
+// GetVacationResponder returns the user's auto-responder.
+// Users who never saved one get a disabled one with the defaults, so this never returns a not-found error.
+func GetVacationResponder(ctx context.Context, pool *pgxpool.Pool, userID string) (*models.VacationResponder, error) {
+	responder := models.VacationResponder{UserID: userID}
+	err := pool.QueryRow(ctx, `
+		SELECT enabled, subject, body, starts_at, ends_at, interval_days, mode, created_at, updated_at
+		FROM vacation_responders
+		WHERE user_id = $1
+	`, userID).Scan(
+		&responder.Enabled,
+		&responder.Subject,
+		&responder.Body,
+		&responder.StartsAt,
+		&responder.EndsAt,
+		&responder.IntervalDays,
+		&responder.Mode,
+		&responder.CreatedAt,
+		&responder.UpdatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		responder.IntervalDays = DefaultVacationIntervalDays
+		responder.Mode = models.VacationModeBackend
+		return &responder, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vacation responder: %w", err)
+	}
+	return &responder, nil
+}
+
+// SaveVacationResponder saves the user's auto-responder, and sets its timestamps.
+// It forgets who got replies so far, so that everyone gets the new reply.
+func SaveVacationResponder(ctx context.Context, pool *pgxpool.Pool, responder *models.VacationResponder) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO vacation_responders (user_id, enabled, subject, body, starts_at, ends_at, interval_days, mode)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (user_id) DO UPDATE SET
+			enabled = EXCLUDED.enabled,
+			subject = EXCLUDED.subject,
+			body = EXCLUDED.body,
+			starts_at = EXCLUDED.starts_at,
+			ends_at = EXCLUDED.ends_at,
+			interval_days = EXCLUDED.interval_days,
+			mode = EXCLUDED.mode,
+			updated_at = NOW()
+		RETURNING created_at, updated_at
+	`, responder.UserID, responder.Enabled, responder.Subject, responder.Body, responder.StartsAt, responder.EndsAt,
+		responder.IntervalDays, responder.Mode,
+	).Scan(&responder.CreatedAt, &responder.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save vacation responder: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM vacation_replies WHERE user_id = $1`, responder.UserID); err != nil {
+		return fmt.Errorf("failed to delete vacation replies: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ClaimVacationReply records a reply to the sender at now, unless the sender got one in the last intervalDays days.
+// Returns whether to reply. Concurrent syncs can't both claim the same sender.
+func ClaimVacationReply(ctx context.Context, pool *pgxpool.Pool, userID, sender string, now time.Time, intervalDays int) (bool, error) {
+	result, err := pool.Exec(ctx, `
+		INSERT INTO vacation_replies (user_id, sender, replied_at)
+		VALUES ($1, lower($2), $3)
+		ON CONFLICT (user_id, sender) DO UPDATE SET replied_at = EXCLUDED.replied_at
+		WHERE vacation_replies.replied_at <= EXCLUDED.replied_at - make_interval(days => $4)
+	`, userID, sender, now, intervalDays)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim vacation reply: %w", err)
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// DeleteVacationReply forgets the reply to the sender, so that the next message from them gets one.
+// It's for replies that failed to send after ClaimVacationReply.
+func DeleteVacationReply(ctx context.Context, pool *pgxpool.Pool, userID, sender string) error {
+	_, err := pool.Exec(ctx, `
+		DELETE FROM vacation_replies
+		WHERE user_id = $1 AND sender = lower($2)
+	`, userID, sender)
+	if err != nil {
+		return fmt.Errorf("failed to delete vacation reply: %w", err)
+	}
+	return nil
+}
+
+// SetVacationResponderMode changes who sends the replies of the user's auto-responder, if they have one.
+// Unlike SaveVacationResponder, it keeps who got replies, since the replies stay the same.
+func SetVacationResponderMode(ctx context.Context, pool *pgxpool.Pool, userID, mode string) error {
+	_, err := pool.Exec(ctx, `
+		UPDATE vacation_responders SET mode = $2, updated_at = NOW()
+		WHERE user_id = $1 AND mode <> $2
+	`, userID, mode)
+	if err != nil {
+		return fmt.Errorf("failed to set vacation responder mode: %w", err)
+	}
+	return nil
+}
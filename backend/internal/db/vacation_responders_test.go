@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestVacationResponders(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+
+	userID, err := GetOrCreateUser(ctx, pool, "vacation-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	t.Run("returns a disabled responder with the defaults if there's none", func(t *testing.T) {
+		responder, err := GetVacationResponder(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetVacationResponder failed: %v", err)
+		}
+		if responder.Enabled || responder.IntervalDays != DefaultVacationIntervalDays || responder.Mode != models.VacationModeBackend {
+			t.Errorf("Unexpected responder: %+v", responder)
+		}
+	})
+
+	t.Run("saves and reads a responder", func(t *testing.T) {
+		endsAt := time.Date(2025, 8, 15, 0, 0, 0, 0, time.UTC)
+		responder := &models.VacationResponder{
+			UserID:       userID,
+			Enabled:      true,
+			Subject:      "Out of office",
+			Body:         "I'm back on August 15.",
+			EndsAt:       &endsAt,
+			IntervalDays: 3,
+			Mode:         models.VacationModeSieve,
+		}
+		if err := SaveVacationResponder(ctx, pool, responder); err != nil {
+			t.Fatalf("SaveVacationResponder failed: %v", err)
+		}
+		if responder.UpdatedAt.IsZero() {
+			t.Errorf("Expected the timestamps to be set, got %+v", responder)
+		}
+
+		retrieved, err := GetVacationResponder(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetVacationResponder failed: %v", err)
+		}
+		if !retrieved.Enabled || retrieved.Subject != "Out of office" || retrieved.StartsAt != nil ||
+			retrieved.EndsAt == nil || !retrieved.EndsAt.Equal(endsAt) || retrieved.IntervalDays != 3 || retrieved.Mode != models.VacationModeSieve {
+			t.Errorf("Unexpected responder: %+v", retrieved)
+		}
+	})
+
+	t.Run("sets the mode", func(t *testing.T) {
+		if err := SetVacationResponderMode(ctx, pool, userID, models.VacationModeBackend); err != nil {
+			t.Fatalf("SetVacationResponderMode failed: %v", err)
+		}
+		retrieved, err := GetVacationResponder(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetVacationResponder failed: %v", err)
+		}
+		if retrieved.Mode != models.VacationModeBackend || retrieved.Subject != "Out of office" {
+			t.Errorf("Unexpected responder: %+v", retrieved)
+		}
+	})
+
+	t.Run("claims a reply to each sender once per interval", func(t *testing.T) {
+		now := time.Date(2025, 8, 1, 12, 0, 0, 0, time.UTC)
+		claims := []struct {
+			sender string
+			at     time.Time
+			want   bool
+		}{
+			{"alice@example.com", now, true},
+			{"Alice@Example.com", now.Add(time.Hour), false},
+			{"bob@example.com", now.Add(time.Hour), true},
+			{"alice@example.com", now.Add(3*24*time.Hour - time.Minute), false},
+			{"alice@example.com", now.Add(3 * 24 * time.Hour), true},
+		}
+		for _, claim := range claims {
+			claimed, err := ClaimVacationReply(ctx, pool, userID, claim.sender, claim.at, 3)
+			if err != nil {
+				t.Fatalf("ClaimVacationReply failed: %v", err)
+			}
+			if claimed != claim.want {
+				t.Errorf("Expected claiming %s at %v to return %v, got %v", claim.sender, claim.at, claim.want, claimed)
+			}
+		}
+	})
+
+	t.Run("forgets a failed reply", func(t *testing.T) {
+		now := time.Date(2025, 8, 10, 12, 0, 0, 0, time.UTC)
+		if _, err := ClaimVacationReply(ctx, pool, userID, "carol@example.com", now, 3); err != nil {
+			t.Fatalf("ClaimVacationReply failed: %v", err)
+		}
+		if err := DeleteVacationReply(ctx, pool, userID, "Carol@example.com"); err != nil {
+			t.Fatalf("DeleteVacationReply failed: %v", err)
+		}
+		claimed, err := ClaimVacationReply(ctx, pool, userID, "carol@example.com", now, 3)
+		if err != nil {
+			t.Fatalf("ClaimVacationReply failed: %v", err)
+		}
+		if !claimed {
+			t.Error("Expected the sender to get a reply again")
+		}
+	})
+
+	t.Run("saving the responder forgets the replies", func(t *testing.T) {
+		responder, err := GetVacationResponder(ctx, pool, userID)
+		if err != nil {
+			t.Fatalf("GetVacationResponder failed: %v", err)
+		}
+		if err := SaveVacationResponder(ctx, pool, responder); err != nil {
+			t.Fatalf("SaveVacationResponder failed: %v", err)
+		}
+		claimed, err := ClaimVacationReply(ctx, pool, userID, "bob@example.com", time.Date(2025, 8, 1, 14, 0, 0, 0, time.UTC), 3)
+		if err != nil {
+			t.Fatalf("ClaimVacationReply failed: %v", err)
+		}
+		if !claimed {
+			t.Error("Expected the sender to get the new reply")
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrWSTokenNotFound is returned by UseWSToken when the token doesn't exist, because it was used, revoked,
+// or never issued, or when it expired.
+var ErrWSTokenNotFound = errors.New("websocket token not found")
+
+// CreateWSToken saves a WebSocket token of the user that works until expiresAt, and returns its ID.
+// It also deletes the user's expired tokens, so that unused tokens don't pile up.
+func CreateWSToken(ctx context.Context, pool *pgxpool.Pool, userID string, expiresAt time.Time) (string, error) {
+	if _, err := pool.Exec(ctx, `DELETE FROM ws_tokens WHERE user_id = $1 AND expires_at <= now()`, userID); err != nil {
+		return "", fmt.Errorf("failed to delete expired websocket tokens: %w", err)
+	}
+
+	var id string
+	err := pool.QueryRow(ctx, `
+		INSERT INTO ws_tokens (user_id, expires_at)
+		VALUES ($1, $2)
+		RETURNING id
+	`, userID, expiresAt).Scan(&id)
+	if err != nil {
+		return "", fmt.Errorf("failed to create websocket token: %w", err)
+	}
+	return id, nil
+}
+
+// UseWSToken deletes the user's token with the given ID, so that it only works once, and returns the user's email.
+// Returns ErrWSTokenNotFound if the token doesn't exist or expired.
+func UseWSToken(ctx context.Context, pool *pgxpool.Pool, userID, tokenID string) (string, error) {
+	var email string
+	err := pool.QueryRow(ctx, `
+		DELETE FROM ws_tokens t
+		USING users u
+		WHERE t.id = $1 AND t.user_id = $2 AND u.id = t.user_id AND t.expires_at > now()
+		RETURNING u.email
+	`, tokenID, userID).Scan(&email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrWSTokenNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to use websocket token: %w", err)
+	}
+	return email, nil
+}
+
+// RevokeWSTokens deletes all unused WebSocket tokens of the user. Returns how many it deleted.
+func RevokeWSTokens(ctx context.Context, pool *pgxpool.Pool, userID string) (int, error) {
+	result, err := pool.Exec(ctx, `DELETE FROM ws_tokens WHERE user_id = $1`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke websocket tokens: %w", err)
+	}
+	return int(result.RowsAffected()), nil
+}
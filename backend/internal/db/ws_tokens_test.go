@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestWSTokens(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	email := "ws-tokens-test@example.com"
+	userID, err := GetOrCreateUser(ctx, pool, email)
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+	otherUserID, err := GetOrCreateUser(ctx, pool, "ws-tokens-other@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	createToken := func(t *testing.T, expiresAt time.Time) string {
+		t.Helper()
+		id, err := CreateWSToken(ctx, pool, userID, expiresAt)
+		if err != nil {
+			t.Fatalf("CreateWSToken failed: %v", err)
+		}
+		return id
+	}
+
+	t.Run("uses a token only once", func(t *testing.T) {
+		id := createToken(t, time.Now().Add(time.Minute))
+
+		gotEmail, err := UseWSToken(ctx, pool, userID, id)
+		if err != nil || gotEmail != email {
+			t.Fatalf("Expected the user's email, got %q and %v", gotEmail, err)
+		}
+		if _, err := UseWSToken(ctx, pool, userID, id); !errors.Is(err, ErrWSTokenNotFound) {
+			t.Errorf("Expected ErrWSTokenNotFound for a used token, got %v", err)
+		}
+	})
+
+	t.Run("doesn't use expired tokens or tokens of other users", func(t *testing.T) {
+		expired := createToken(t, time.Now().Add(-time.Second))
+		if _, err := UseWSToken(ctx, pool, userID, expired); !errors.Is(err, ErrWSTokenNotFound) {
+			t.Errorf("Expected ErrWSTokenNotFound for an expired token, got %v", err)
+		}
+
+		id := createToken(t, time.Now().Add(time.Minute))
+		if _, err := UseWSToken(ctx, pool, otherUserID, id); !errors.Is(err, ErrWSTokenNotFound) {
+			t.Errorf("Expected ErrWSTokenNotFound for another user's token, got %v", err)
+		}
+	})
+
+	t.Run("revokes unused tokens", func(t *testing.T) {
+		if _, err := RevokeWSTokens(ctx, pool, userID); err != nil {
+			t.Fatalf("RevokeWSTokens failed: %v", err)
+		}
+		id := createToken(t, time.Now().Add(time.Minute))
+
+		revoked, err := RevokeWSTokens(ctx, pool, userID)
+		if err != nil || revoked != 1 {
+			t.Fatalf("Expected to revoke 1 token, got %d and %v", revoked, err)
+		}
+		if _, err := UseWSToken(ctx, pool, userID, id); !errors.Is(err, ErrWSTokenNotFound) {
+			t.Errorf("Expected ErrWSTokenNotFound for a revoked token, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,291 @@
+// Package export builds the data exports that users take their data out of V-Mail with: a zip file with their
+// cached mail as an mbox file per folder, and their settings, contacts, and labels as JSON files.
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/mail"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/logging"
+	"github.com/vdavid/vmail/backend/internal/mime"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// buildTimeout is how long building an export can take. It's under db.DataExportStaleAfter, so that an export
+// that times out is marked failed before anyone can start another.
+const buildTimeout = 30 * time.Minute
+
+// settingsFile is the content of settings.json. The secrets of the settings have no JSON fields, so they stay out.
+type settingsFile struct {
+	Settings       *models.UserSettings    `json:"settings"`
+	Preferences    *models.UserPreferences `json:"preferences"`
+	SendIdentities []*models.SendIdentity  `json:"send_identities"`
+}
+
+// labelEntry is an item of labels.json: a label, and the Message-IDs of the messages that have it.
+type labelEntry struct {
+	Label      string   `json:"label"`
+	MessageIDs []string `json:"message_ids"`
+}
+
+// Run builds the export that db.StartDataExport started, and saves it, or marks it failed.
+// It blocks, so call it in a goroutine, with a context that the request that started the export doesn't cancel.
+func Run(ctx context.Context, pool *pgxpool.Pool, userID, exportID string) {
+	ctx = logging.WithUserID(ctx, userID)
+	buildCtx, cancel := context.WithTimeout(ctx, buildTimeout)
+	defer cancel()
+
+	// Build the zip file on disk, so that big exports don't take up memory
+	file, err := os.CreateTemp("", "vmail-export-*.zip")
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create data export file", "export_id", exportID, "error", err)
+		failExport(ctx, pool, userID, exportID, "Building the export failed. Try again later.")
+		return
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+
+	if err := Write(buildCtx, pool, userID, file); err != nil {
+		slog.ErrorContext(ctx, "Failed to build data export", "export_id", exportID, "error", err)
+		exportErr := "Building the export failed. Try again later."
+		if errors.Is(err, context.DeadlineExceeded) {
+			exportErr = "Building the export took too long."
+		}
+		failExport(ctx, pool, userID, exportID, exportErr)
+		return
+	}
+
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		err = db.FinishDataExport(buildCtx, pool, userID, exportID, file, size)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to save data export", "export_id", exportID, "error", err)
+		failExport(ctx, pool, userID, exportID, "Saving the export failed. Try again later.")
+		return
+	}
+	slog.InfoContext(ctx, "Built data export", "export_id", exportID, "size_bytes", size)
+}
+
+// failExport marks the export failed with exportErr, and logs if that fails too.
+func failExport(ctx context.Context, pool *pgxpool.Pool, userID, exportID, exportErr string) {
+	if err := db.FailDataExport(ctx, pool, userID, exportID, exportErr); err != nil {
+		slog.ErrorContext(ctx, "Failed to mark data export failed", "export_id", exportID, "error", err)
+	}
+}
+
+// Write writes the zip file of the user's export to w. It has:
+//   - mail/<folder>.mbox: The cached messages of each folder, in mboxrd format, without attachments,
+//     since we don't cache them.
+//   - settings.json: The IMAP and SMTP settings without passwords, the preferences, and the send identities.
+//   - contacts.json: The contacts that recipient suggestions come from.
+//   - labels.json: The labels, each with the Message-IDs of its messages.
+func Write(ctx context.Context, pool *pgxpool.Pool, userID string, w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	labels, err := writeMail(ctx, pool, userID, zw)
+	if err != nil {
+		return err
+	}
+
+	settings, err := db.GetUserSettings(ctx, pool, userID)
+	if err != nil && !errors.Is(err, db.ErrUserSettingsNotFound) {
+		return err
+	}
+	preferences, err := db.GetUserPreferences(ctx, pool, userID)
+	if err != nil {
+		return err
+	}
+	identities, err := db.GetSendIdentities(ctx, pool, userID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(zw, "settings.json", settingsFile{
+		Settings:       settings,
+		Preferences:    preferences,
+		SendIdentities: identities,
+	}); err != nil {
+		return err
+	}
+
+	contacts, err := db.GetContacts(ctx, pool, userID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(zw, "contacts.json", contacts); err != nil {
+		return err
+	}
+
+	if err := writeJSON(zw, "labels.json", labels); err != nil {
+		return err
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to finish zip file: %w", err)
+	}
+	return nil
+}
+
+// writeMail writes the mbox file of each folder, and returns the labels of the messages for labels.json.
+func writeMail(ctx context.Context, pool *pgxpool.Pool, userID string, zw *zip.Writer) ([]labelEntry, error) {
+	labelMessages := make(map[string][]string)
+	var mbox io.Writer
+	folder := ""
+	err := db.ExportMessages(ctx, pool, userID, func(msg *models.Message) error {
+		if mbox == nil || msg.IMAPFolderName != folder {
+			folder = msg.IMAPFolderName
+			var err error
+			if mbox, err = zw.Create(mboxPath(folder)); err != nil {
+				return fmt.Errorf("failed to add mbox file: %w", err)
+			}
+		}
+		for _, label := range msg.Labels {
+			labelMessages[label] = append(labelMessages[label], msg.MessageIDHeader)
+		}
+		return writeMboxMessage(mbox, msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	labels := make([]labelEntry, 0, len(labelMessages))
+	for label, messageIDs := range labelMessages {
+		labels = append(labels, labelEntry{Label: label, MessageIDs: messageIDs})
+	}
+	slices.SortFunc(labels, func(a, b labelEntry) int { return strings.Compare(a.Label, b.Label) })
+	return labels, nil
+}
+
+// writeJSON adds a JSON file to the zip file.
+func writeJSON(zw *zip.Writer, name string, v any) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// mboxPath returns the path of a folder's mbox file in the zip file. Folders in folders, like "Work/Projects",
+// become directories. Path segments that could escape the mail directory, like "..", become "_".
+func mboxPath(folder string) string {
+	segments := strings.Split(strings.ReplaceAll(folder, `\`, "/"), "/")
+	for i, segment := range segments {
+		if segment == "" || segment == "." || segment == ".." {
+			segments[i] = "_"
+		}
+	}
+	return "mail/" + strings.Join(segments, "/") + ".mbox"
+}
+
+// writeMboxMessage writes a cached message to an mbox file with writeMboxEntry.
+// The read, starred, and label state goes in the Status, X-Status, and X-Keywords headers that mail clients read.
+func writeMboxMessage(w io.Writer, msg *models.Message) error {
+	date := time.Unix(0, 0).UTC()
+	if msg.SentAt != nil {
+		date = msg.SentAt.UTC()
+	}
+	from := parseAddress(msg.FromAddress)
+
+	encoded := mime.Message{
+		From:      from,
+		To:        parseAddresses(msg.ToAddresses),
+		Cc:        parseAddresses(msg.CCAddresses),
+		Subject:   msg.Subject,
+		Date:      date,
+		MessageID: msg.MessageIDHeader,
+		TextBody:  msg.BodyText,
+		HTMLBody:  msg.UnsafeBodyHTML,
+	}
+	if n := len(msg.ReferencedMessageIDs); n > 0 {
+		encoded.InReplyTo = msg.ReferencedMessageIDs[n-1]
+		encoded.References = strings.Join(msg.ReferencedMessageIDs, " ")
+	}
+	raw, err := encoded.Bytes()
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	var headers strings.Builder
+	if msg.IsRead {
+		headers.WriteString("Status: RO\n")
+	} else {
+		headers.WriteString("Status: O\n")
+	}
+	if msg.IsStarred {
+		headers.WriteString("X-Status: F\n")
+	}
+	if len(msg.Labels) > 0 {
+		fmt.Fprintf(&headers, "X-Keywords: %s\n", strings.Join(msg.Labels, " "))
+	}
+	return writeMboxEntry(w, from.Address, date, headers.String(), raw)
+}
+
+// writeMboxEntry writes a message to an mbox file in mboxrd format: a "From " line, the extra headers, then the
+// message with LF line endings, with ">" before the lines that look like a "From " line, after any ">", then an
+// empty line. An empty sender becomes MAILER-DAEMON.
+func writeMboxEntry(w io.Writer, sender string, date time.Time, extraHeaders string, raw []byte) error {
+	if sender == "" {
+		sender = "MAILER-DAEMON"
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From %s %s\n", sender, date.UTC().Format(time.ANSIC))
+	buf.WriteString(extraHeaders)
+	source := strings.ReplaceAll(string(raw), "\r\n", "\n")
+	for _, line := range strings.Split(strings.TrimSuffix(source, "\n"), "\n") {
+		if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+			buf.WriteByte('>')
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	return nil
+}
+
+// parseAddress parses a cached address, like "Alice <alice@example.com>". Addresses that don't parse are kept as
+// they are.
+func parseAddress(address string) mail.Address {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return mail.Address{Address: address}
+	}
+	return *parsed
+}
+
+// parseAddresses parses cached addresses with parseAddress, and skips empty ones.
+func parseAddresses(addresses []string) []mail.Address {
+	var parsed []mail.Address
+	for _, address := range addresses {
+		if address != "" {
+			parsed = append(parsed, parseAddress(address))
+		}
+	}
+	return parsed
+}
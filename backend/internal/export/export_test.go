@@ -0,0 +1,150 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+func TestWriteMboxMessage(t *testing.T) {
+	sentAt := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	msg := &models.Message{
+		MessageIDHeader:      "<reply@example.com>",
+		FromAddress:          "Alice <alice@example.com>",
+		ToAddresses:          []string{"bob@example.com"},
+		SentAt:               &sentAt,
+		Subject:              "Plans",
+		BodyText:             "Hi Bob,\nFrom now on, we meet on Mondays.\n>From the team",
+		IsRead:               true,
+		IsStarred:            true,
+		Labels:               []string{"$Label1", "Work"},
+		ReferencedMessageIDs: []string{"<root@example.com>", "<parent@example.com>"},
+	}
+
+	var buf bytes.Buffer
+	if err := writeMboxMessage(&buf, msg); err != nil {
+		t.Fatalf("writeMboxMessage failed: %v", err)
+	}
+	mbox := buf.String()
+
+	t.Run("starts with a From line", func(t *testing.T) {
+		if !strings.HasPrefix(mbox, "From alice@example.com Tue Mar  4 05:06:07 2025\n") {
+			t.Errorf("Unexpected first line: %q", strings.SplitN(mbox, "\n", 2)[0])
+		}
+	})
+
+	t.Run("keeps the flags and labels in headers", func(t *testing.T) {
+		for _, header := range []string{"Status: RO\n", "X-Status: F\n", "X-Keywords: $Label1 Work\n",
+			"In-Reply-To: <parent@example.com>\n", "References: <root@example.com> <parent@example.com>\n"} {
+			if !strings.Contains(mbox, header) {
+				t.Errorf("Expected %q in the message, got:\n%s", header, mbox)
+			}
+		}
+	})
+
+	t.Run("escapes From lines in the body", func(t *testing.T) {
+		if !strings.Contains(mbox, "\n>From now on") || !strings.Contains(mbox, "\n>>From the team") {
+			t.Errorf("Expected escaped From lines, got:\n%s", mbox)
+		}
+		if strings.Contains(mbox, "\r") {
+			t.Error("Expected LF line endings")
+		}
+	})
+
+	t.Run("ends with an empty line", func(t *testing.T) {
+		if !strings.HasSuffix(mbox, "\n\n") {
+			t.Errorf("Expected an empty line at the end, got %q", mbox[len(mbox)-10:])
+		}
+	})
+}
+
+func TestThreadWriter(t *testing.T) {
+	date := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	sources := []string{
+		"From: alice@example.com\r\nSubject: Plans\r\n\r\nFrom now on, Mondays.\r\n",
+		"From: bob@example.com\r\nSubject: Re: Plans\r\n\r\nSounds good.\r\n",
+	}
+
+	write := func(t *testing.T, format string) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		tw, err := NewThreadWriter(&buf, format)
+		if err != nil {
+			t.Fatalf("NewThreadWriter failed: %v", err)
+		}
+		for i, source := range sources {
+			if err := tw.Add([]string{"Alice <alice@example.com>", ""}[i], date, []byte(source)); err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	t.Run("writes an mbox file", func(t *testing.T) {
+		mbox := string(write(t, ThreadFormatMbox))
+		for _, part := range []string{
+			"From alice@example.com Tue Mar  4 05:06:07 2025\nFrom: alice@example.com\n",
+			"\n>From now on, Mondays.\n\n",
+			"From MAILER-DAEMON Tue Mar  4 05:06:07 2025\nFrom: bob@example.com\n",
+		} {
+			if !strings.Contains(mbox, part) {
+				t.Errorf("Expected %q in the mbox file, got:\n%s", part, mbox)
+			}
+		}
+	})
+
+	t.Run("writes a zip file with the sources as they are", func(t *testing.T) {
+		data := write(t, ThreadFormatEML)
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("Failed to read zip file: %v", err)
+		}
+		if len(zr.File) != len(sources) {
+			t.Fatalf("Expected %d files, got %d", len(sources), len(zr.File))
+		}
+		for i, f := range zr.File {
+			if want := []string{"001.eml", "002.eml"}[i]; f.Name != want {
+				t.Errorf("Expected %s, got %s", want, f.Name)
+			}
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatalf("Failed to open %s: %v", f.Name, err)
+			}
+			content, _ := io.ReadAll(rc)
+			_ = rc.Close()
+			if string(content) != sources[i] {
+				t.Errorf("Expected the source unchanged in %s, got %q", f.Name, content)
+			}
+		}
+	})
+
+	t.Run("rejects unknown formats", func(t *testing.T) {
+		if _, err := NewThreadWriter(io.Discard, "pdf"); err == nil {
+			t.Error("Expected an error")
+		}
+	})
+}
+
+func TestMboxPath(t *testing.T) {
+	tests := map[string]string{
+		"INBOX":          "mail/INBOX.mbox",
+		"Work/Projects":  "mail/Work/Projects.mbox",
+		"INBOX.Sent":     "mail/INBOX.Sent.mbox",
+		"../../etc":      "mail/_/_/etc.mbox",
+		`..\Windows`:     "mail/_/Windows.mbox",
+		"/Archive//2024": "mail/_/Archive/_/2024.mbox",
+	}
+	for folder, want := range tests {
+		if got := mboxPath(folder); got != want {
+			t.Errorf("mboxPath(%q) = %q, want %q", folder, got, want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package export
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"time"
+)
+
+// The formats of thread exports.
+const (
+	// ThreadFormatMbox is one mbox file with all messages of the thread.
+	ThreadFormatMbox = "mbox"
+	// ThreadFormatEML is a zip file with an .eml file for each message of the thread.
+	ThreadFormatEML = "eml"
+)
+
+// ThreadWriter writes the original sources of a thread's messages, one by one, so that exports of big threads
+// don't have to fit in memory. Close it after the last message.
+type ThreadWriter struct {
+	w     io.Writer
+	zw    *zip.Writer
+	count int
+}
+
+// NewThreadWriter creates a ThreadWriter that writes to w in the format, one of the ThreadFormat constants.
+func NewThreadWriter(w io.Writer, format string) (*ThreadWriter, error) {
+	switch format {
+	case ThreadFormatMbox:
+		return &ThreadWriter{w: w}, nil
+	case ThreadFormatEML:
+		return &ThreadWriter{w: w, zw: zip.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown thread export format %q", format)
+	}
+}
+
+// Add writes the source of a message. The cached From address, like "Alice <alice@example.com>", and the date are
+// for the "From " line of mbox files.
+// In zip files, the messages are named by their order, like 001.eml, so that they sort in the order of the thread.
+func (tw *ThreadWriter) Add(from string, date time.Time, source []byte) error {
+	tw.count++
+	if tw.zw == nil {
+		return writeMboxEntry(tw.w, parseAddress(from).Address, date, "", source)
+	}
+
+	f, err := tw.zw.CreateHeader(&zip.FileHeader{
+		Name:     fmt.Sprintf("%03d.eml", tw.count),
+		Method:   zip.Deflate,
+		Modified: date,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add message to zip file: %w", err)
+	}
+	if _, err := f.Write(source); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	return nil
+}
+
+// Close finishes the zip file. Mbox files need no finishing, so it does nothing for them.
+func (tw *ThreadWriter) Close() error {
+	if tw.zw == nil {
+		return nil
+	}
+	if err := tw.zw.Close(); err != nil {
+		return fmt.Errorf("failed to finish zip file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+// Package imageproxy fetches the remote images of messages for the front end, so that senders see our server instead
+// of the user's browser: their IP address, cookies, and when they open the message again.
+package imageproxy
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/netguard"
+)
+
+// fetchTimeout limits how long fetching an image takes, with redirects.
+const fetchTimeout = 10 * time.Second
+
+// cacheTTL is how long we keep fetched images. Senders can change the image behind a URL, but rarely do.
+const cacheTTL = time.Hour
+
+// userAgent is the same for everyone, so that it doesn't tell senders anything about the user.
+const userAgent = "vmail-image-proxy"
+
+// ErrInvalidURL is returned for URLs that aren't absolute HTTP or HTTPS URLs.
+var ErrInvalidURL = errors.New("invalid image URL")
+
+// ErrNotImage is returned when the URL responds with something other than a raster image.
+var ErrNotImage = errors.New("not an image")
+
+// ErrTooLarge is returned for images over the size limit.
+var ErrTooLarge = errors.New("image too large")
+
+// imageTypes are the image types we serve. SVG isn't one, since it can have scripts, which would run on our origin if
+// someone opened the proxy URL itself.
+var imageTypes = map[string]bool{
+	"image/png":                true,
+	"image/jpeg":               true,
+	"image/gif":                true,
+	"image/webp":               true,
+	"image/avif":               true,
+	"image/bmp":                true,
+	"image/x-icon":             true,
+	"image/vnd.microsoft.icon": true,
+}
+
+// IsImageType tells whether a media type is one of the raster image types that are safe to show on our origin.
+func IsImageType(mediaType string) bool {
+	return imageTypes[strings.ToLower(mediaType)]
+}
+
+// Image is a fetched image.
+type Image struct {
+	ContentType string
+	Data        []byte
+}
+
+// Proxy fetches remote images, and keeps the recent ones in memory.
+type Proxy struct {
+	httpClient    *http.Client
+	maxImageBytes int
+	cache         *cache
+}
+
+// NewProxy creates a new Proxy that uses the network. Images over maxImageBytes are refused, and the cache takes up
+// at most cacheBytes. Zero cacheBytes turns the cache off.
+// Its HTTP client only connects to public addresses, since the URLs come from senders.
+func NewProxy(maxImageBytes, cacheBytes int) *Proxy {
+	return newProxy(&http.Client{
+		Transport:     netguard.NewTransport(),
+		CheckRedirect: checkRedirect,
+	}, maxImageBytes, cacheBytes)
+}
+
+func newProxy(httpClient *http.Client, maxImageBytes, cacheBytes int) *Proxy {
+	return &Proxy{
+		httpClient:    httpClient,
+		maxImageBytes: maxImageBytes,
+		cache:         newCache(cacheBytes),
+	}
+}
+
+// checkRedirect follows up to five redirects, to HTTP or HTTPS URLs only.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("refusing to follow a redirect to %s", req.URL.Scheme)
+	}
+	if len(via) >= 5 {
+		return errors.New("too many redirects")
+	}
+	return nil
+}
+
+// Fetch returns the image at the URL, from the cache if we fetched it recently. It sends no cookies, referrer, or
+// anything else about the user. Returns ErrInvalidURL, ErrNotImage, or ErrTooLarge for URLs we won't serve.
+func (p *Proxy) Fetch(ctx context.Context, rawURL string) (*Image, error) {
+	imageURL, err := url.Parse(rawURL)
+	if err != nil || (imageURL.Scheme != "http" && imageURL.Scheme != "https") || imageURL.Host == "" {
+		return nil, ErrInvalidURL
+	}
+	key := imageURL.String()
+	if image, ok := p.cache.get(key); ok {
+		return image, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch image: status %d", resp.StatusCode)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !IsImageType(mediaType) {
+		return nil, ErrNotImage
+	}
+	if p.maxImageBytes > 0 && resp.ContentLength > int64(p.maxImageBytes) {
+		return nil, ErrTooLarge
+	}
+
+	reader := resp.Body
+	if p.maxImageBytes > 0 {
+		reader = io.NopCloser(io.LimitReader(resp.Body, int64(p.maxImageBytes)+1))
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	if p.maxImageBytes > 0 && len(data) > p.maxImageBytes {
+		return nil, ErrTooLarge
+	}
+
+	image := &Image{ContentType: mediaType, Data: data}
+	p.cache.add(key, image)
+	return image, nil
+}
+
+// cache keeps images by URL, and evicts the least recently used ones above its size, and the ones older than cacheTTL.
+type cache struct {
+	mu       sync.Mutex
+	maxBytes int
+	bytes    int
+	// order has the most recently used entries first.
+	order   *list.List
+	entries map[string]*list.Element
+	now     func() time.Time
+}
+
+type cacheEntry struct {
+	key       string
+	image     *Image
+	fetchedAt time.Time
+}
+
+func newCache(maxBytes int) *cache {
+	return &cache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+		now:      time.Now,
+	}
+}
+
+func (c *cache) get(key string) (*Image, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if c.now().Sub(entry.fetchedAt) > cacheTTL {
+		c.remove(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.image, true
+}
+
+// add adds the image, unless it's bigger than the whole cache.
+func (c *cache) add(key string, image *Image) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(image.Data) > c.maxBytes {
+		return
+	}
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, image: image, fetchedAt: c.now()})
+	c.bytes += len(image.Data)
+	for c.bytes > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *cache) remove(element *list.Element) {
+	entry := c.order.Remove(element).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= len(entry.image.Data)
+}
@@ -0,0 +1,145 @@
+package imageproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/netguard"
+)
+
+func TestFetch(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Cookie") != "" || r.Header.Get("Referer") != "" {
+			t.Error("Expected no cookies or referrer")
+		}
+		switch r.URL.Path {
+		case "/pixel.gif":
+			w.Header().Set("Content-Type", "image/gif")
+			_, _ = w.Write([]byte("GIF89a"))
+		case "/large.png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte(strings.Repeat("x", 100)))
+		case "/image.svg":
+			w.Header().Set("Content-Type", "image/svg+xml")
+			_, _ = w.Write([]byte("<svg></svg>"))
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<p>Hi</p>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	p := newProxy(server.Client(), 50, 1000)
+	ctx := context.Background()
+
+	t.Run("fetches images once", func(t *testing.T) {
+		for range 2 {
+			image, err := p.Fetch(ctx, server.URL+"/pixel.gif")
+			if err != nil {
+				t.Fatalf("Fetch failed: %v", err)
+			}
+			if image.ContentType != "image/gif" || string(image.Data) != "GIF89a" {
+				t.Errorf("Unexpected image: %q, %q", image.ContentType, image.Data)
+			}
+		}
+		if requests != 1 {
+			t.Errorf("Expected 1 request, got %d", requests)
+		}
+	})
+
+	t.Run("refuses what isn't a raster image", func(t *testing.T) {
+		for _, path := range []string{"/image.svg", "/page.html"} {
+			if _, err := p.Fetch(ctx, server.URL+path); !errors.Is(err, ErrNotImage) {
+				t.Errorf("Expected ErrNotImage for %s, got %v", path, err)
+			}
+		}
+	})
+
+	t.Run("refuses images over the limit", func(t *testing.T) {
+		if _, err := p.Fetch(ctx, server.URL+"/large.png"); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("Expected ErrTooLarge, got %v", err)
+		}
+	})
+
+	t.Run("refuses URLs that aren't HTTP", func(t *testing.T) {
+		for _, rawURL := range []string{"file:///etc/passwd", "/relative.png", "javascript:alert(1)", "https://"} {
+			if _, err := p.Fetch(ctx, rawURL); !errors.Is(err, ErrInvalidURL) {
+				t.Errorf("Expected ErrInvalidURL for %q, got %v", rawURL, err)
+			}
+		}
+	})
+
+	t.Run("fails for missing images", func(t *testing.T) {
+		if _, err := p.Fetch(ctx, server.URL+"/missing.png"); err == nil {
+			t.Error("Expected an error")
+		}
+	})
+}
+
+func TestNewProxyOnlyConnectsToPublicAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected no request to reach the local server")
+	}))
+	defer server.Close()
+
+	p := NewProxy(1000, 1000)
+	if _, err := p.Fetch(context.Background(), server.URL+"/pixel.gif"); !errors.Is(err, netguard.ErrNotPublic) {
+		t.Errorf("Expected ErrNotPublic, got %v", err)
+	}
+}
+
+func TestCache(t *testing.T) {
+	image := func(size int) *Image {
+		return &Image{ContentType: "image/png", Data: make([]byte, size)}
+	}
+
+	t.Run("evicts the least recently used images", func(t *testing.T) {
+		c := newCache(10)
+		c.add("a", image(4))
+		c.add("b", image(4))
+		c.get("a")
+		c.add("c", image(4))
+
+		if _, ok := c.get("b"); ok {
+			t.Error("Expected b to be evicted")
+		}
+		if _, ok := c.get("a"); !ok {
+			t.Error("Expected a to stay")
+		}
+		if c.bytes != 8 {
+			t.Errorf("Expected 8 bytes, got %d", c.bytes)
+		}
+	})
+
+	t.Run("skips images bigger than the cache", func(t *testing.T) {
+		c := newCache(10)
+		c.add("a", image(11))
+		if _, ok := c.get("a"); ok {
+			t.Error("Expected a not to be cached")
+		}
+	})
+
+	t.Run("expires old images", func(t *testing.T) {
+		c := newCache(10)
+		now := time.Now()
+		c.now = func() time.Time { return now }
+		c.add("a", image(4))
+		now = now.Add(cacheTTL + time.Second)
+
+		if _, ok := c.get("a"); ok {
+			t.Error("Expected a to expire")
+		}
+		if c.bytes != 0 {
+			t.Errorf("Expected 0 bytes, got %d", c.bytes)
+		}
+	})
+}
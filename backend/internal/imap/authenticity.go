@@ -0,0 +1,164 @@
+package imap
+
+import (
+	"net/mail"
+	"slices"
+	"strings"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"golang.org/x/net/publicsuffix"
+)
+
+// parseAuthenticationResults parses the Authentication-Results headers of a message from fromDomain, as they come,
+// topmost first. Anyone can add these headers, so only the topmost one with a trusted authserv-id counts: receiving
+// servers remove the headers with their own authserv-id from incoming mail, so that one came from the user's server.
+// Returns nil if there's none, or it has no DKIM, SPF, or DMARC results. See RFC 8601, section 5.
+func parseAuthenticationResults(headers, trustedIDs []string, fromDomain string) *models.Authenticity {
+	for _, header := range headers {
+		parts := strings.Split(stripHeaderComments(header), ";")
+		// The authserv-id can have a version after it
+		fields := strings.Fields(parts[0])
+		if len(fields) == 0 || !slices.ContainsFunc(trustedIDs, func(id string) bool { return strings.EqualFold(id, fields[0]) }) {
+			continue
+		}
+		return parseAuthenticationResultsHeader(fields[0], parts[1:], fromDomain)
+	}
+	return nil
+}
+
+// parseAuthenticationResultsHeader parses the results of one Authentication-Results header, after its authserv-id.
+// The message is verified if DMARC passed, or, if the server didn't check DMARC, if DKIM or SPF passed for the
+// organizational domain of the From address, which is what DMARC would check. A pass for any other domain doesn't
+// count, since senders can pass SPF and DKIM for their own domains.
+func parseAuthenticationResultsHeader(authServID string, results []string, fromDomain string) *models.Authenticity {
+	authenticity := &models.Authenticity{AuthServID: authServID}
+	found := false
+	aligned := false
+	for _, part := range results {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		method, result, ok := strings.Cut(fields[0], "=")
+		if !ok {
+			continue
+		}
+		// Methods can have a version, like "dkim/1"
+		method, _, _ = strings.Cut(strings.ToLower(method), "/")
+		result = strings.ToLower(result)
+
+		switch method {
+		case "dkim":
+			// A message can have several signatures, and one good one is enough
+			if authenticity.DKIM == "" || result == models.AuthResultPass {
+				authenticity.DKIM = result
+			}
+			if result == models.AuthResultPass && isAlignedDomain(resultDomain(fields[1:], "header.d", "header.i"), fromDomain) {
+				aligned = true
+			}
+		case "spf":
+			if authenticity.SPF == "" {
+				authenticity.SPF = result
+				if result == models.AuthResultPass && isAlignedDomain(resultDomain(fields[1:], "smtp.mailfrom"), fromDomain) {
+					aligned = true
+				}
+			}
+		case "dmarc":
+			if authenticity.DMARC == "" {
+				authenticity.DMARC = result
+			}
+		default:
+			continue
+		}
+		found = true
+	}
+	if !found {
+		return nil
+	}
+
+	if authenticity.DMARC != "" {
+		authenticity.Verified = authenticity.DMARC == models.AuthResultPass
+	} else {
+		authenticity.Verified = aligned
+	}
+	return authenticity
+}
+
+// resultDomain returns the domain in the first of the properties that a result has, like "example.com" for
+// "header.i=@example.com" or "smtp.mailfrom=a@example.com". Returns an empty string if it has none of them.
+func resultDomain(properties []string, names ...string) string {
+	for _, name := range names {
+		for _, property := range properties {
+			key, value, ok := strings.Cut(property, "=")
+			if !ok || !strings.EqualFold(key, name) {
+				continue
+			}
+			value = strings.Trim(value, `"`)
+			if i := strings.LastIndex(value, "@"); i >= 0 {
+				value = value[i+1:]
+			}
+			return value
+		}
+	}
+	return ""
+}
+
+// isAlignedDomain tells whether a domain that passed DKIM or SPF is in the organizational domain of the From address,
+// like DMARC's relaxed alignment: "mail.example.com" is aligned with "example.com", but "example.net" isn't.
+func isAlignedDomain(domain, fromDomain string) bool {
+	if domain == "" || fromDomain == "" {
+		return false
+	}
+	return strings.EqualFold(organizationalDomain(domain), organizationalDomain(fromDomain))
+}
+
+// organizationalDomain returns the registered part of a domain, like "example.co.uk" for "mail.example.co.uk".
+func organizationalDomain(domain string) string {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if registered, err := publicsuffix.EffectiveTLDPlusOne(domain); err == nil {
+		return registered
+	}
+	return domain
+}
+
+// addressDomain returns the domain of an address like "Alice <alice@example.com>", or an empty string if it has none.
+func addressDomain(address string) string {
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		address = parsed.Address
+	}
+	i := strings.LastIndex(address, "@")
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimSuffix(address[i+1:], ">")
+}
+
+// stripHeaderComments removes the parenthesized comments of a header value, which can be nested, like
+// "spf=pass (sender IP is 192.0.2.1 (mail.example.com)) smtp.mailfrom=example.com". See RFC 5322, section 3.2.2.
+func stripHeaderComments(value string) string {
+	var sb strings.Builder
+	depth := 0
+	escaped := false
+	for _, r := range value {
+		switch {
+		case escaped:
+			escaped = false
+			if depth == 0 {
+				sb.WriteRune(r)
+			}
+			continue
+		case r == '\\':
+			escaped = true
+		case r == '(':
+			depth++
+			continue
+		case r == ')' && depth > 0:
+			depth--
+			continue
+		}
+		if depth == 0 {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,123 @@
+package imap
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+func TestParseAuthenticationResults(t *testing.T) {
+	trusted := []string{"mx.example.net", "mx.google.com"}
+	testCases := []struct {
+		name       string
+		headers    []string
+		fromDomain string
+		want       *models.Authenticity
+	}{
+		{
+			"parses the results",
+			[]string{"mx.google.com;\r\n dkim=pass header.i=@example.com header.s=s1;\r\n" +
+				" spf=pass (google.com: domain of a@example.com designates 192.0.2.1 as permitted sender) smtp.mailfrom=a@example.com;\r\n" +
+				" dmarc=pass (p=REJECT sp=REJECT dis=NONE) header.from=example.com"},
+			"example.com",
+			&models.Authenticity{AuthServID: "mx.google.com", DKIM: "pass", SPF: "pass", DMARC: "pass", Verified: true},
+		},
+		{
+			"fails DMARC failures even if something else passed",
+			[]string{"mx.example.net; dkim=pass header.d=mailer.example; spf=softfail; dmarc=FAIL header.from=bank.example"},
+			"bank.example",
+			&models.Authenticity{AuthServID: "mx.example.net", DKIM: "pass", SPF: "softfail", DMARC: "fail"},
+		},
+		{
+			"verifies a DKIM pass for the From domain without DMARC",
+			[]string{"mx.example.net 1; dkim/1=fail; dkim=pass header.d=mail.example.com; spf=none"},
+			"example.com",
+			&models.Authenticity{AuthServID: "mx.example.net", DKIM: "pass", SPF: "none", Verified: true},
+		},
+		{
+			"verifies an SPF pass for the From domain without DMARC",
+			[]string{"mx.example.net; spf=pass smtp.mailfrom=bounces@example.com"},
+			"example.com",
+			&models.Authenticity{AuthServID: "mx.example.net", SPF: "pass", Verified: true},
+		},
+		{
+			"doesn't verify passes for other domains without DMARC",
+			[]string{"mx.example.net; dkim=pass header.d=spoofer.example; spf=pass smtp.mailfrom=a@spoofer.example"},
+			"bank.example",
+			&models.Authenticity{AuthServID: "mx.example.net", DKIM: "pass", SPF: "pass"},
+		},
+		{
+			"doesn't verify passes without a domain",
+			[]string{"mx.example.net; dkim=pass; spf=pass"},
+			"example.com",
+			&models.Authenticity{AuthServID: "mx.example.net", DKIM: "pass", SPF: "pass"},
+		},
+		{
+			"only trusts the topmost trusted header",
+			[]string{"mx.example.net; spf=fail", "mx.example.net; spf=pass; dmarc=pass"},
+			"example.com",
+			&models.Authenticity{AuthServID: "mx.example.net", SPF: "fail"},
+		},
+		{
+			"skips headers from untrusted servers",
+			[]string{"forged.example; dmarc=pass", "MX.example.net; dmarc=fail"},
+			"bank.example",
+			&models.Authenticity{AuthServID: "MX.example.net", DMARC: "fail"},
+		},
+		{
+			"ignores messages with only untrusted headers",
+			[]string{"x; dmarc=pass"},
+			"bank.example",
+			nil,
+		},
+		{
+			"ignores headers without results",
+			[]string{"mx.example.net; none"},
+			"example.com",
+			nil,
+		},
+		{
+			"ignores messages without the header",
+			nil,
+			"example.com",
+			nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := parseAuthenticationResults(tc.headers, trusted, tc.fromDomain)
+			if (got == nil) != (tc.want == nil) || (got != nil && *got != *tc.want) {
+				t.Errorf("Expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestAddressDomain(t *testing.T) {
+	testCases := map[string]string{
+		"Alice <alice@example.com>": "example.com",
+		"bob@mail.example.org":      "mail.example.org",
+		"undisclosed-recipients":    "",
+	}
+	for address, want := range testCases {
+		if got := addressDomain(address); got != want {
+			t.Errorf("addressDomain(%q) = %q, want %q", address, got, want)
+		}
+	}
+}
+
+func TestParseBody_AuthenticationResults(t *testing.T) {
+	raw := "Authentication-Results: mx.example.net; dmarc=fail header.from=bank.example\r\n" +
+		"Authentication-Results: forged.example; dmarc=pass header.from=bank.example\r\n" +
+		"Content-Type: text/plain\r\n\r\nHi"
+
+	msg := &models.Message{}
+	if err := parseBody(strings.NewReader(raw), msg, 0); err != nil {
+		t.Fatalf("parseBody failed: %v", err)
+	}
+	if len(msg.AuthenticationResults) != 2 || !strings.HasPrefix(msg.AuthenticationResults[0], "mx.example.net;") {
+		t.Errorf("Expected both headers, topmost first, got %q", msg.AuthenticationResults)
+	}
+}
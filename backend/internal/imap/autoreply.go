@@ -0,0 +1,65 @@
+package imap
+
+import (
+	"context"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// autoReplyHeaderFields are the header fields that tell automatic and bulk messages apart.
+// StreamMessageHeaders fetches them with headerFieldsSection.
+var autoReplyHeaderFields = []string{"Auto-Submitted", "Precedence", "List-Id", "List-Unsubscribe", "Return-Path"}
+
+// autoReplyMessages returns what auto-responders need to know about the messages that they may reply to.
+// It leaves out the messages without a sender, and the ones that shouldn't get automatic replies: automatic messages,
+// like other auto-replies and bounces, and bulk messages, like mailing lists. See RFC 3834, section 2.
+// The messages must have the headerFieldsSection.
+func autoReplyMessages(messages []*imap.Message) []models.AutoReplyMessage {
+	var result []models.AutoReplyMessage
+	for _, msg := range messages {
+		address := senderAddress(msg)
+		if address == "" || isAutomatedMessage(fetchedHeader(msg)) {
+			continue
+		}
+		result = append(result, models.AutoReplyMessage{
+			FromAddress: address,
+			Subject:     msg.Envelope.Subject,
+			MessageID:   msg.Envelope.MessageId,
+			References:  messageReferences(msg),
+			SentAt:      msg.Envelope.Date,
+		})
+	}
+	return result
+}
+
+// isAutomatedMessage returns whether the header belongs to an automatic or bulk message.
+func isAutomatedMessage(header textproto.MIMEHeader) bool {
+	autoSubmitted, _, _ := strings.Cut(header.Get("Auto-Submitted"), ";")
+	if autoSubmitted = strings.TrimSpace(autoSubmitted); autoSubmitted != "" && !strings.EqualFold(autoSubmitted, "no") {
+		return true
+	}
+	switch strings.ToLower(strings.TrimSpace(header.Get("Precedence"))) {
+	case "bulk", "list", "junk":
+		return true
+	}
+	if header.Get("List-Id") != "" || header.Get("List-Unsubscribe") != "" {
+		return true
+	}
+	// Bounces and other messages that must not cause replies have an empty return path
+	return strings.TrimSpace(header.Get("Return-Path")) == "<>"
+}
+
+// respondToNewMailInBackground gives the messages of an incremental INBOX sync to the auto-responder.
+func (s *Service) respondToNewMailInBackground(ctx context.Context, userID string, messages []models.AutoReplyMessage) {
+	if s.autoResponder == nil || len(messages) == 0 {
+		return
+	}
+	bgCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+	defer cancel()
+
+	s.autoResponder.RespondToNewMail(bgCtx, userID, messages)
+}
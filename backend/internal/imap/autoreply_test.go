@@ -0,0 +1,63 @@
+package imap
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestAutoReplyMessages(t *testing.T) {
+	sentAt := time.Date(2025, 8, 1, 9, 0, 0, 0, time.UTC)
+	newMessage := func(uid uint32, mailbox, header string) *imap.Message {
+		section := &imap.BodySectionName{BodyPartName: headerFieldsSection.BodyPartName}
+		return &imap.Message{
+			Uid: uid,
+			Envelope: &imap.Envelope{
+				From:      []*imap.Address{{MailboxName: mailbox, HostName: "Example.com"}},
+				Subject:   "Lunch?",
+				MessageId: "<lunch@example.com>",
+				Date:      sentAt,
+			},
+			Body: map[*imap.BodySectionName]imap.Literal{section: bytes.NewReader([]byte(header + "\r\n"))},
+		}
+	}
+
+	t.Run("returns what the responder needs", func(t *testing.T) {
+		messages := autoReplyMessages([]*imap.Message{newMessage(1, "Alice", "References: <a@example.com>\r\nAuto-Submitted: no\r\n")})
+		if len(messages) != 1 {
+			t.Fatalf("Expected 1 message, got %d", len(messages))
+		}
+		message := messages[0]
+		if message.FromAddress != "alice@example.com" || message.Subject != "Lunch?" || message.MessageID != "<lunch@example.com>" ||
+			!message.SentAt.Equal(sentAt) || !slices.Equal(message.References, []string{"<a@example.com>"}) {
+			t.Errorf("Unexpected message: %+v", message)
+		}
+	})
+
+	t.Run("leaves out automatic and bulk messages", func(t *testing.T) {
+		headers := []string{
+			"Auto-Submitted: auto-replied\r\n",
+			"Auto-Submitted: auto-generated; owner-email=\"x@example.com\"\r\n",
+			"Precedence: bulk\r\n",
+			"Precedence: List\r\n",
+			"List-Id: <news.example.com>\r\n",
+			"List-Unsubscribe: <mailto:leave@example.com>\r\n",
+			"Return-Path: <>\r\n",
+		}
+		for i, header := range headers {
+			if messages := autoReplyMessages([]*imap.Message{newMessage(uint32(i+1), "bob", header)}); len(messages) != 0 {
+				t.Errorf("Expected no reply to a message with %q, got %+v", header, messages)
+			}
+		}
+	})
+
+	t.Run("leaves out messages without a sender", func(t *testing.T) {
+		msg := newMessage(1, "", "")
+		if messages := autoReplyMessages([]*imap.Message{msg, {Uid: 2}}); len(messages) != 0 {
+			t.Errorf("Expected no messages, got %+v", messages)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package imap
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	imapclient "github.com/emersion/go-imap/client"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// blockedSenderDestinations maps blocked sender actions to the folders they move messages to.
+var blockedSenderDestinations = map[string]MoveDestination{
+	models.BlockedSenderActionTrash: TrashDestination,
+	models.BlockedSenderActionSpam:  SpamDestination,
+}
+
+// fileBlockedMessages moves the new INBOX messages from blocked senders to Trash or Spam,
+// and returns the other messages, which the sync caches as usual.
+// The folder must be selected. If anything fails, it logs the error and keeps the messages,
+// because showing a message from a blocked sender is better than losing it.
+func (s *Service) fileBlockedMessages(ctx context.Context, client *imapclient.Client, userID, folderName string, messages []*imap.Message, stats *saveStats) []*imap.Message {
+	if folderName != "INBOX" || len(messages) == 0 {
+		return messages
+	}
+
+	actions, err := db.GetBlockedSenderActions(ctx, s.dbPool, userID)
+	if err != nil {
+		slog.WarnContext(ctx, "IMAP Sync: Failed to get blocked senders", "error", err)
+		return messages
+	}
+	if len(actions) == 0 {
+		return messages
+	}
+
+	kept, uidsByAction := splitBlockedMessages(messages, actions)
+	for action, uids := range uidsByAction {
+		destination := blockedSenderDestinations[action]
+		destinationFolder := s.findFolderByRole(ctx, client, userID, destination.Role, destination.FallbackFolderName)
+
+		seqSet := new(imap.SeqSet)
+		seqSet.AddNum(uids...)
+		if err := client.UidMove(seqSet, destinationFolder); err != nil {
+			slog.WarnContext(ctx, "IMAP Sync: Failed to move messages from blocked senders", "count", len(uids), "destination", destinationFolder, "error", err)
+			kept = append(kept, messagesWithUIDs(messages, uids)...)
+			continue
+		}
+		if stats != nil {
+			stats.blocked += len(uids)
+		}
+	}
+	return kept
+}
+
+// splitBlockedMessages returns the messages that aren't from blocked senders,
+// and the UIDs of the ones that are, grouped by the action for their sender.
+// actions maps lowercase sender addresses to actions.
+func splitBlockedMessages(messages []*imap.Message, actions map[string]string) ([]*imap.Message, map[string][]uint32) {
+	kept := make([]*imap.Message, 0, len(messages))
+	uidsByAction := make(map[string][]uint32)
+	for _, msg := range messages {
+		action := actions[senderAddress(msg)]
+		if _, blocked := blockedSenderDestinations[action]; !blocked {
+			kept = append(kept, msg)
+			continue
+		}
+		uidsByAction[action] = append(uidsByAction[action], msg.Uid)
+	}
+	return kept, uidsByAction
+}
+
+// senderAddress returns the lowercase address of the message's first "From" address, or "" if it has none.
+func senderAddress(msg *imap.Message) string {
+	if msg.Envelope == nil || len(msg.Envelope.From) == 0 || msg.Envelope.From[0] == nil {
+		return ""
+	}
+	from := msg.Envelope.From[0]
+	if from.MailboxName == "" || from.HostName == "" {
+		return ""
+	}
+	return strings.ToLower(from.MailboxName + "@" + from.HostName)
+}
+
+// messagesWithUIDs returns the messages with the given UIDs.
+func messagesWithUIDs(messages []*imap.Message, uids []uint32) []*imap.Message {
+	wanted := make(map[uint32]bool, len(uids))
+	for _, uid := range uids {
+		wanted[uid] = true
+	}
+	var result []*imap.Message
+	for _, msg := range messages {
+		if wanted[msg.Uid] {
+			result = append(result, msg)
+		}
+	}
+	return result
+}
+
+// messageUIDs returns the UIDs of the messages.
+func messageUIDs(messages []*imap.Message) []uint32 {
+	uids := make([]uint32, len(messages))
+	for i, msg := range messages {
+		uids[i] = msg.Uid
+	}
+	return uids
+}
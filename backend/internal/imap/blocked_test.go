@@ -0,0 +1,101 @@
+package imap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestSplitBlockedMessages(t *testing.T) {
+	from := func(uid uint32, mailbox, host string) *imap.Message {
+		return &imap.Message{Uid: uid, Envelope: &imap.Envelope{From: []*imap.Address{{MailboxName: mailbox, HostName: host}}}}
+	}
+	messages := []*imap.Message{
+		from(1, "Spammer", "Example.com"),
+		from(2, "friend", "example.com"),
+		from(3, "scammer", "example.com"),
+		{Uid: 4},
+	}
+	actions := map[string]string{
+		"spammer@example.com": models.BlockedSenderActionTrash,
+		"scammer@example.com": models.BlockedSenderActionSpam,
+	}
+
+	kept, uidsByAction := splitBlockedMessages(messages, actions)
+
+	if len(kept) != 2 || kept[0].Uid != 2 || kept[1].Uid != 4 {
+		t.Errorf("Expected to keep UIDs 2 and 4, got %v", messageUIDs(kept))
+	}
+	if uids := uidsByAction[models.BlockedSenderActionTrash]; len(uids) != 1 || uids[0] != 1 {
+		t.Errorf("Expected UID 1 to go to Trash, got %v", uids)
+	}
+	if uids := uidsByAction[models.BlockedSenderActionSpam]; len(uids) != 1 || uids[0] != 3 {
+		t.Errorf("Expected UID 3 to go to Spam, got %v", uids)
+	}
+}
+
+func TestFileBlockedMessages(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	server := testutil.NewTestIMAPServer(t)
+	defer server.Close()
+	server.EnsureINBOX(t)
+
+	client, clientCleanup := server.Connect(t)
+	defer clientCleanup()
+	if err := client.Create("Trash"); err != nil {
+		t.Fatalf("Failed to create Trash folder: %v", err)
+	}
+
+	service := NewService(pool, NewPool(), getTestEncryptor(t), nil)
+	defer service.Close()
+
+	ctx := context.Background()
+	userID, err := db.GetOrCreateUser(ctx, pool, "blocked-sync-test@example.com")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	blocked := &models.BlockedSender{Email: "spammer@example.com", Action: models.BlockedSenderActionTrash}
+	if err := db.CreateBlockedSender(ctx, pool, userID, blocked); err != nil {
+		t.Fatalf("Failed to block sender: %v", err)
+	}
+
+	now := time.Now()
+	spamUID := server.AddMessage(t, "INBOX", "<spam@example.com>", "Buy now", "spammer@example.com", "me@example.com", now)
+	friendUID := server.AddMessage(t, "INBOX", "<friend@example.com>", "Lunch?", "friend@example.com", "me@example.com", now)
+
+	if _, err := client.Select("INBOX", false); err != nil {
+		t.Fatalf("Failed to select INBOX: %v", err)
+	}
+	messages, err := FetchMessageHeaders(client, []uint32{spamUID, friendUID})
+	if err != nil {
+		t.Fatalf("Failed to fetch headers: %v", err)
+	}
+
+	stats := &saveStats{}
+	kept := service.fileBlockedMessages(ctx, client, userID, "INBOX", messages, stats)
+
+	if len(kept) != 1 || kept[0].Uid != friendUID {
+		t.Errorf("Expected to keep only the friend's message, got %v", messageUIDs(kept))
+	}
+	if stats.blocked != 1 {
+		t.Errorf("Expected 1 blocked message in the stats, got %d", stats.blocked)
+	}
+
+	if _, err := client.Select("Trash", true); err != nil {
+		t.Fatalf("Failed to select Trash: %v", err)
+	}
+	uids, err := searchUIDsByMessageID(client, "<spam@example.com>")
+	if err != nil {
+		t.Fatalf("searchUIDsByMessageID failed: %v", err)
+	}
+	if len(uids) != 1 {
+		t.Errorf("Expected the blocked message in Trash, got UIDs %v", uids)
+	}
+}
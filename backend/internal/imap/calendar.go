@@ -0,0 +1,225 @@
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jhillyerd/enmime"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// The formats of iCalendar DATE and DATE-TIME values. See RFC 5545, section 3.3.4 and 3.3.5.
+const (
+	icsDateFormat     = "20060102"
+	icsDateTimeFormat = "20060102T150405"
+)
+
+// errNoCalendarEvent is returned when an iCalendar object has no VEVENT with a start.
+var errNoCalendarEvent = errors.New("no event in the calendar")
+
+// icsProperty is a content line of an iCalendar object, like "DTSTART;TZID=Europe/Berlin:20250304T100000".
+type icsProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// findCalendarPart returns the first text/calendar part of the message, or an .ics attachment that some clients send
+// with a generic type. Returns nil if there's none.
+func findCalendarPart(envelope *enmime.Envelope) *enmime.Part {
+	if envelope.Root == nil {
+		return nil
+	}
+	return envelope.Root.BreadthMatchFirst(func(part *enmime.Part) bool {
+		contentType := strings.ToLower(part.ContentType)
+		return contentType == "text/calendar" || contentType == "application/ics" ||
+			(contentType == "application/octet-stream" && strings.HasSuffix(strings.ToLower(part.FileName), ".ics"))
+	})
+}
+
+// parseCalendarEvent parses the first VEVENT of an iCalendar object, with the METHOD of the calendar.
+// Recurrence rules, alarms, and time zone definitions are ignored. TZID parameters are looked up in the IANA
+// database, and times in unknown zones, like the Windows names that Outlook uses, and floating times count as UTC.
+func parseCalendarEvent(data []byte) (*models.CalendarEvent, error) {
+	event := &models.CalendarEvent{Attendees: []models.CalendarParticipant{}}
+	var components []string
+	found := false
+
+	for _, line := range unfoldICSLines(string(data)) {
+		prop, ok := parseICSProperty(line)
+		if !ok {
+			continue
+		}
+
+		switch prop.name {
+		case "BEGIN":
+			components = append(components, strings.ToUpper(prop.value))
+			continue
+		case "END":
+			if len(components) > 0 {
+				if components[len(components)-1] == "VEVENT" {
+					found = true
+				}
+				components = components[:len(components)-1]
+			}
+			continue
+		}
+		if len(components) == 0 {
+			continue
+		}
+
+		switch components[len(components)-1] {
+		case "VCALENDAR":
+			if prop.name == "METHOD" {
+				event.Method = strings.ToUpper(prop.value)
+			}
+		case "VEVENT":
+			if !found {
+				if err := setCalendarEventProperty(event, prop); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if event.StartsAt.IsZero() {
+		return nil, errNoCalendarEvent
+	}
+	return event, nil
+}
+
+// setCalendarEventProperty sets the field of the event that the VEVENT property is for, if any.
+func setCalendarEventProperty(event *models.CalendarEvent, prop icsProperty) error {
+	switch prop.name {
+	case "UID":
+		event.UID = prop.value
+	case "SUMMARY":
+		event.Summary = unescapeICSText(prop.value)
+	case "LOCATION":
+		event.Location = unescapeICSText(prop.value)
+	case "DTSTART":
+		startsAt, allDay, err := parseICSTime(prop)
+		if err != nil {
+			return fmt.Errorf("invalid DTSTART: %w", err)
+		}
+		event.StartsAt = startsAt
+		event.AllDay = allDay
+	case "DTEND":
+		endsAt, _, err := parseICSTime(prop)
+		if err != nil {
+			return fmt.Errorf("invalid DTEND: %w", err)
+		}
+		event.EndsAt = &endsAt
+	case "ORGANIZER":
+		organizer := parseICSParticipant(prop)
+		event.Organizer = &organizer
+	case "ATTENDEE":
+		attendee := parseICSParticipant(prop)
+		attendee.Status = strings.ToLower(prop.params["PARTSTAT"])
+		if attendee.Status == "" {
+			attendee.Status = "needs-action"
+		}
+		event.Attendees = append(event.Attendees, attendee)
+	}
+	return nil
+}
+
+// unfoldICSLines splits an iCalendar object into content lines, joining the lines that continue the one before with a
+// leading space or tab. See RFC 5545, section 3.1.
+func unfoldICSLines(data string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// parseICSProperty parses a content line: a name, then parameters after semicolons, then the value after a colon.
+// Parameter values can be quoted, so that they can have colons, like in "CN=\"Smith: Alice\"".
+// Names and parameter names are made uppercase, since they're case-insensitive.
+func parseICSProperty(line string) (icsProperty, bool) {
+	end := strings.IndexAny(line, ";:")
+	if end <= 0 {
+		return icsProperty{}, false
+	}
+	prop := icsProperty{name: strings.ToUpper(line[:end]), params: map[string]string{}}
+	rest := line[end:]
+
+	for strings.HasPrefix(rest, ";") {
+		rest = rest[1:]
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			return icsProperty{}, false
+		}
+		name := strings.ToUpper(rest[:eq])
+		rest = rest[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			closing := strings.IndexByte(rest[1:], '"')
+			if closing < 0 {
+				return icsProperty{}, false
+			}
+			value, rest = rest[1:closing+1], rest[closing+2:]
+		} else {
+			valueEnd := strings.IndexAny(rest, ";:")
+			if valueEnd < 0 {
+				return icsProperty{}, false
+			}
+			value, rest = rest[:valueEnd], rest[valueEnd:]
+		}
+		prop.params[name] = value
+	}
+
+	if !strings.HasPrefix(rest, ":") {
+		return icsProperty{}, false
+	}
+	prop.value = rest[1:]
+	return prop, true
+}
+
+// parseICSTime parses a DATE or DATE-TIME value, and tells whether it's a DATE.
+func parseICSTime(prop icsProperty) (time.Time, bool, error) {
+	value := strings.TrimSpace(prop.value)
+	if strings.EqualFold(prop.params["VALUE"], "DATE") || len(value) == len(icsDateFormat) {
+		t, err := time.Parse(icsDateFormat, value)
+		return t, true, err
+	}
+
+	if utc, ok := strings.CutSuffix(value, "Z"); ok {
+		t, err := time.Parse(icsDateTimeFormat, utc)
+		return t, false, err
+	}
+
+	location := time.UTC
+	if tzid := strings.TrimPrefix(prop.params["TZID"], "/"); tzid != "" {
+		if loaded, err := time.LoadLocation(tzid); err == nil {
+			location = loaded
+		}
+	}
+	t, err := time.ParseInLocation(icsDateTimeFormat, value, location)
+	return t.UTC(), false, err
+}
+
+// parseICSParticipant parses an ORGANIZER or ATTENDEE property, like "ATTENDEE;CN=Alice:mailto:alice@example.com".
+func parseICSParticipant(prop icsProperty) models.CalendarParticipant {
+	email := prop.value
+	if len(email) >= len("mailto:") && strings.EqualFold(email[:len("mailto:")], "mailto:") {
+		email = email[len("mailto:"):]
+	}
+	return models.CalendarParticipant{
+		Email: email,
+		Name:  prop.params["CN"],
+	}
+}
+
+// unescapeICSText unescapes a TEXT value. See RFC 5545, section 3.3.11.
+func unescapeICSText(value string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
@@ -0,0 +1,139 @@
+package imap
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+const testInvite = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"METHOD:REQUEST\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:Europe/Berlin\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:meeting-1@example.com\r\n" +
+	"SUMMARY:Plans\\, budget\\; and a very long summary that the sender's client folded over\r\n" +
+	"  two lines\r\n" +
+	"LOCATION:Room 1\\nSecond floor\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20250304T100000\r\n" +
+	"DTEND;TZID=Europe/Berlin:20250304T110000\r\n" +
+	"ORGANIZER;CN=\"Smith: Alice\":mailto:alice@example.com\r\n" +
+	"ATTENDEE;CN=Bob;PARTSTAT=ACCEPTED:MAILTO:bob@example.com\r\n" +
+	"ATTENDEE:mailto:carol@example.com\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"SUMMARY:Reminder\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:meeting-2@example.com\r\n" +
+	"DTSTART:20250305T100000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseCalendarEvent(t *testing.T) {
+	t.Run("parses the first event of an invite", func(t *testing.T) {
+		event, err := parseCalendarEvent([]byte(testInvite))
+		if err != nil {
+			t.Fatalf("parseCalendarEvent failed: %v", err)
+		}
+
+		if event.UID != "meeting-1@example.com" || event.Method != "REQUEST" {
+			t.Errorf("Unexpected UID or method: %q, %q", event.UID, event.Method)
+		}
+		wantSummary := "Plans, budget; and a very long summary that the sender's client folded over two lines"
+		if event.Summary != wantSummary {
+			t.Errorf("Expected summary %q, got %q", wantSummary, event.Summary)
+		}
+		if event.Location != "Room 1\nSecond floor" {
+			t.Errorf("Unexpected location %q", event.Location)
+		}
+
+		wantStart := time.Date(2025, 3, 4, 9, 0, 0, 0, time.UTC)
+		if !event.StartsAt.Equal(wantStart) || event.EndsAt == nil || !event.EndsAt.Equal(wantStart.Add(time.Hour)) || event.AllDay {
+			t.Errorf("Expected 09:00 to 10:00 UTC, got %v to %v, all day: %v", event.StartsAt, event.EndsAt, event.AllDay)
+		}
+
+		if event.Organizer == nil || *event.Organizer != (models.CalendarParticipant{Email: "alice@example.com", Name: "Smith: Alice"}) {
+			t.Errorf("Unexpected organizer %+v", event.Organizer)
+		}
+		wantAttendees := []models.CalendarParticipant{
+			{Email: "bob@example.com", Name: "Bob", Status: "accepted"},
+			{Email: "carol@example.com", Status: "needs-action"},
+		}
+		if len(event.Attendees) != len(wantAttendees) {
+			t.Fatalf("Expected %d attendees, got %+v", len(wantAttendees), event.Attendees)
+		}
+		for i, want := range wantAttendees {
+			if event.Attendees[i] != want {
+				t.Errorf("Expected attendee %+v, got %+v", want, event.Attendees[i])
+			}
+		}
+	})
+
+	t.Run("parses all-day events", func(t *testing.T) {
+		event, err := parseCalendarEvent([]byte("BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:day\n" +
+			"DTSTART;VALUE=DATE:20250304\nDTEND;VALUE=DATE:20250305\nEND:VEVENT\nEND:VCALENDAR\n"))
+		if err != nil {
+			t.Fatalf("parseCalendarEvent failed: %v", err)
+		}
+		if !event.AllDay || !event.StartsAt.Equal(time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("Expected an all-day event on March 4, got %+v", event)
+		}
+		if event.Method != "" || event.Organizer != nil || event.Attendees == nil {
+			t.Errorf("Expected no method, no organizer, and an empty attendee list, got %+v", event)
+		}
+	})
+
+	t.Run("counts times in unknown zones as UTC", func(t *testing.T) {
+		event, err := parseCalendarEvent([]byte("BEGIN:VCALENDAR\nBEGIN:VEVENT\n" +
+			"DTSTART;TZID=W. Europe Standard Time:20250304T100000\nEND:VEVENT\nEND:VCALENDAR\n"))
+		if err != nil {
+			t.Fatalf("parseCalendarEvent failed: %v", err)
+		}
+		if !event.StartsAt.Equal(time.Date(2025, 3, 4, 10, 0, 0, 0, time.UTC)) {
+			t.Errorf("Expected 10:00 UTC, got %v", event.StartsAt)
+		}
+	})
+
+	t.Run("returns an error without an event", func(t *testing.T) {
+		for _, data := range []string{
+			"BEGIN:VCALENDAR\nBEGIN:VTODO\nDTSTART:20250304T100000Z\nEND:VTODO\nEND:VCALENDAR\n",
+			"BEGIN:VCALENDAR\nBEGIN:VEVENT\nDTSTART:tomorrow\nEND:VEVENT\nEND:VCALENDAR\n",
+			"not a calendar",
+		} {
+			if _, err := parseCalendarEvent([]byte(data)); err == nil {
+				t.Errorf("Expected an error for %q", data)
+			}
+		}
+	})
+}
+
+func TestParseBody_CalendarInvite(t *testing.T) {
+	raw := "Content-Type: multipart/alternative; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nYou're invited.\r\n" +
+		"--b\r\nContent-Type: text/calendar; method=REQUEST; charset=utf-8\r\n\r\n" + testInvite +
+		"--b--\r\n"
+
+	msg := &models.Message{}
+	if err := parseBody(strings.NewReader(raw), msg, 0); err != nil {
+		t.Fatalf("parseBody failed: %v", err)
+	}
+	if msg.CalendarEvent == nil || msg.CalendarEvent.UID != "meeting-1@example.com" {
+		t.Errorf("Expected the invite's event, got %+v", msg.CalendarEvent)
+	}
+	if !strings.Contains(msg.BodyText, "You're invited.") {
+		t.Errorf("Expected the text body, got %q", msg.BodyText)
+	}
+
+	msg = &models.Message{}
+	if err := parseBody(strings.NewReader("Content-Type: text/plain\r\n\r\nNo invite"), msg, 0); err != nil {
+		t.Fatalf("parseBody failed: %v", err)
+	}
+	if msg.CalendarEvent != nil {
+		t.Errorf("Expected no event, got %+v", msg.CalendarEvent)
+	}
+}
@@ -1,12 +1,20 @@
 package imap
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/emersion/go-imap/client"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/oauth"
 )
 
 // clientRole indicates the purpose of a client.
@@ -27,6 +35,8 @@ type threadSafeClient struct {
 	mu       sync.Mutex
 	lastUsed time.Time
 	role     clientRole
+	// qresync is true if QRESYNC is enabled on the connection. See EnableQResync.
+	qresync bool
 }
 
 // Lock acquires the mutex for thread-safe access to the underlying client.
@@ -66,35 +76,160 @@ func (c *threadSafeClient) GetRole() clientRole {
 	return c.role
 }
 
-// ConnectToIMAP connects to the IMAP server with a 5-second timeout.
-// useTLS: true for production (TLS), false for tests (non-TLS).
-func ConnectToIMAP(server string, useTLS bool) (*client.Client, error) {
+// ServerConfig is how to connect to an IMAP server.
+type ServerConfig struct {
+	// Address is "host:port".
+	Address string
+	// Security is one of the models.IMAPSecurity constants. Empty means TLS, or no security in test mode.
+	Security string
+	// SkipTLSVerify accepts any certificate, for servers with self-signed ones.
+	SkipTLSVerify bool
+}
+
+// ServerFromSettings returns how to connect to the IMAP server of the settings.
+// Without a port in the settings, it uses the port in the hostname, or else the default port of the security mode.
+func ServerFromSettings(settings *models.UserSettings) ServerConfig {
+	server := ServerConfig{
+		Address:       settings.IMAPServerHostname,
+		Security:      settings.IMAPSecurity,
+		SkipTLSVerify: settings.IMAPSkipTLSVerify,
+	}
+	host := strings.TrimSpace(settings.IMAPServerHostname)
+	switch {
+	case settings.IMAPPort > 0:
+		server.Address = net.JoinHostPort(host, strconv.Itoa(settings.IMAPPort))
+	case !hasPort(host):
+		port := "993"
+		if server.security() != models.IMAPSecurityTLS {
+			port = "143"
+		}
+		server.Address = net.JoinHostPort(host, port)
+	}
+	return server
+}
+
+// hasPort tells whether the address is "host:port".
+func hasPort(address string) bool {
+	_, port, err := net.SplitHostPort(address)
+	return err == nil && port != ""
+}
+
+// security returns the security mode, with the default for an empty one.
+func (s ServerConfig) security() string {
+	if s.Security != "" {
+		return s.Security
+	}
+	if os.Getenv("VMAIL_TEST_MODE") == "true" {
+		return models.IMAPSecurityNone
+	}
+	return models.IMAPSecurityTLS
+}
+
+// tlsConfig returns the TLS config for the server.
+func (s ServerConfig) tlsConfig() *tls.Config {
+	host, _, err := net.SplitHostPort(s.Address)
+	if err != nil {
+		host = s.Address
+	}
+	return &tls.Config{
+		ServerName: host,
+		// The user chose this for their own server, and the settings form warns about it
+		InsecureSkipVerify: s.SkipTLSVerify,
+	}
+}
+
+// ConnectToIMAP connects to the IMAP server with a 5-second timeout, secured as the server config says.
+// With STARTTLS, it fails if the server doesn't support it, rather than going on in plain text.
+func ConnectToIMAP(server ServerConfig) (*client.Client, error) {
 	dialer := &net.Dialer{
 		Timeout: 5 * time.Second,
 	}
 
-	if useTLS {
-		c, err := client.DialWithDialerTLS(dialer, server, nil)
+	switch server.security() {
+	case models.IMAPSecurityTLS:
+		c, err := client.DialWithDialerTLS(dialer, server.Address, server.tlsConfig())
 		if err != nil {
 			return nil, fmt.Errorf("failed to dial with TLS: %w", err)
 		}
 		return c, nil
-	}
 
-	// Non-TLS connection for testing
-	c, err := client.DialWithDialer(dialer, server)
-	if err != nil {
-		return nil, fmt.Errorf("failed to dial: %w", err)
-	}
+	case models.IMAPSecuritySTARTTLS:
+		c, err := client.DialWithDialer(dialer, server.Address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial: %w", err)
+		}
+		supported, err := c.SupportStartTLS()
+		if err == nil && !supported {
+			err = errors.New("the server doesn't support STARTTLS")
+		}
+		if err == nil {
+			err = c.StartTLS(server.tlsConfig())
+		}
+		if err != nil {
+			_ = c.Terminate()
+			return nil, fmt.Errorf("failed to start TLS: %w", err)
+		}
+		return c, nil
+
+	case models.IMAPSecurityNone:
+		c, err := client.DialWithDialer(dialer, server.Address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial: %w", err)
+		}
+		return c, nil
 
-	return c, nil
+	default:
+		return nil, fmt.Errorf("unknown IMAP security mode %q", server.Security)
+	}
 }
 
+// ErrAuthFailed wraps the errors of Login, which mostly mean that the server rejected the username or password.
+var ErrAuthFailed = errors.New("failed to authenticate")
+
 // Login authenticates with the IMAP server.
+// If the password is an OAuth access token, it uses XOAUTH2 instead of LOGIN. Errors wrap ErrAuthFailed.
 func Login(c *client.Client, username, password string) error {
+	if token, ok := oauth.TokenFromPassword(password); ok {
+		if err := c.Authenticate(oauth.NewXOAUTH2Client(username, token)); err != nil {
+			return fmt.Errorf("%w with XOAUTH2: %w", ErrAuthFailed, err)
+		}
+		return nil
+	}
+
 	if err := c.Login(username, password); err != nil {
-		return fmt.Errorf("failed to authenticate: %w", err)
+		return fmt.Errorf("%w: %w", ErrAuthFailed, err)
 	}
 
 	return nil
 }
+
+// CheckLogin connects and logs in to the IMAP server on a connection of its own, outside the pool,
+// so that users can check their settings before saving them. It logs out right after.
+// Returns whether any folder has a SPECIAL-USE attribute (RFC 6154). Without them, ListFolders guesses
+// the roles from the folder names. Login errors wrap ErrAuthFailed.
+func CheckLogin(ctx context.Context, server ServerConfig, username, password string) (specialUse bool, err error) {
+	c, err := ConnectToIMAP(server)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = c.Logout()
+	}()
+
+	err = runWithContext(ctx, c, func() error {
+		if err := Login(c, username, password); err != nil {
+			return err
+		}
+		mailboxes, err := listMailboxes(c, "*", false)
+		if err != nil {
+			return err
+		}
+		for _, m := range mailboxes {
+			if role := determineFolderRole(m.Name, m.Attributes); role != "inbox" && role != "other" {
+				specialUse = true
+			}
+		}
+		return nil
+	})
+	return specialUse, err
+}
@@ -0,0 +1,123 @@
+package imap
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestServerFromSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings models.UserSettings
+		want     ServerConfig
+	}{
+		{
+			name:     "keeps the port in the hostname",
+			settings: models.UserSettings{IMAPServerHostname: "imap.example.com:1993"},
+			want:     ServerConfig{Address: "imap.example.com:1993"},
+		},
+		{
+			name:     "uses the port setting",
+			settings: models.UserSettings{IMAPServerHostname: "imap.example.com", IMAPPort: 1143, IMAPSecurity: models.IMAPSecuritySTARTTLS},
+			want:     ServerConfig{Address: "imap.example.com:1143", Security: models.IMAPSecuritySTARTTLS},
+		},
+		{
+			name:     "uses the default port of TLS",
+			settings: models.UserSettings{IMAPServerHostname: "imap.example.com", IMAPSecurity: models.IMAPSecurityTLS, IMAPSkipTLSVerify: true},
+			want:     ServerConfig{Address: "imap.example.com:993", Security: models.IMAPSecurityTLS, SkipTLSVerify: true},
+		},
+		{
+			name:     "uses the default port of STARTTLS",
+			settings: models.UserSettings{IMAPServerHostname: "imap.example.com", IMAPSecurity: models.IMAPSecuritySTARTTLS},
+			want:     ServerConfig{Address: "imap.example.com:143", Security: models.IMAPSecuritySTARTTLS},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ServerFromSettings(&tt.settings); got != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestConnectToIMAP(t *testing.T) {
+	t.Setenv("VMAIL_TEST_MODE", "true")
+
+	server := testutil.NewTestIMAPServer(t)
+	defer server.Close()
+
+	t.Run("connects in plain text in test mode", func(t *testing.T) {
+		c, err := ConnectToIMAP(ServerConfig{Address: server.Address})
+		if err != nil {
+			t.Fatalf("ConnectToIMAP failed: %v", err)
+		}
+		_ = c.Logout()
+	})
+
+	t.Run("doesn't fall back to plain text if the server has no STARTTLS", func(t *testing.T) {
+		c, err := ConnectToIMAP(ServerConfig{Address: server.Address, Security: models.IMAPSecuritySTARTTLS})
+		if err == nil {
+			_ = c.Logout()
+			t.Fatal("Expected an error, got nil")
+		}
+	})
+
+	t.Run("rejects unknown security modes", func(t *testing.T) {
+		if _, err := ConnectToIMAP(ServerConfig{Address: server.Address, Security: "ssl"}); err == nil {
+			t.Error("Expected an error, got nil")
+		}
+	})
+}
+
+func TestCheckLogin(t *testing.T) {
+	t.Setenv("VMAIL_TEST_MODE", "true")
+
+	server := testutil.NewTestIMAPServer(t)
+	defer server.Close()
+	server.EnsureINBOX(t)
+
+	t.Run("logs in and tells that no folder has SPECIAL-USE", func(t *testing.T) {
+		specialUse, err := CheckLogin(t.Context(), ServerConfig{Address: server.Address}, server.Username(), server.Password())
+		if err != nil {
+			t.Fatalf("CheckLogin failed: %v", err)
+		}
+		if specialUse {
+			t.Error("Expected no SPECIAL-USE folders with only INBOX")
+		}
+	})
+
+	t.Run("tells that a folder has SPECIAL-USE", func(t *testing.T) {
+		client, cleanup := server.Connect(t)
+		defer cleanup()
+		if err := client.Create("Sent"); err != nil {
+			t.Fatalf("Failed to create Sent: %v", err)
+		}
+
+		specialUse, err := CheckLogin(t.Context(), ServerConfig{Address: server.Address}, server.Username(), server.Password())
+		if err != nil {
+			t.Fatalf("CheckLogin failed: %v", err)
+		}
+		if !specialUse {
+			t.Error("Expected Sent to have SPECIAL-USE")
+		}
+	})
+
+	t.Run("wraps ErrAuthFailed for a wrong password", func(t *testing.T) {
+		_, err := CheckLogin(t.Context(), ServerConfig{Address: server.Address}, server.Username(), "wrong")
+		if !errors.Is(err, ErrAuthFailed) {
+			t.Errorf("Expected ErrAuthFailed, got %v", err)
+		}
+	})
+
+	t.Run("fails when the server is down", func(t *testing.T) {
+		_, err := CheckLogin(t.Context(), ServerConfig{Address: "127.0.0.1:1"}, server.Username(), server.Password())
+		if err == nil || errors.Is(err, ErrAuthFailed) {
+			t.Errorf("Expected a connection error, got %v", err)
+		}
+	})
+}
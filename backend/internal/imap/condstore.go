@@ -0,0 +1,228 @@
+package imap
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/responses"
+)
+
+const (
+	// capCondstore is the capability of servers that support CONDSTORE (RFC 7162).
+	capCondstore = "CONDSTORE"
+	// capQResync is the capability of servers that support QRESYNC (RFC 7162).
+	// It implies CONDSTORE, and adds VANISHED responses for expunged messages.
+	capQResync = "QRESYNC"
+	// statusHighestModSeq is the STATUS item for the highest mod-sequence of a folder.
+	statusHighestModSeq imap.StatusItem = "HIGHESTMODSEQ"
+)
+
+// folderChanges holds what changed in a folder since a mod-sequence.
+type folderChanges struct {
+	// flags are the current flags of the messages whose flags changed, by UID.
+	flags map[uint32][]string
+	// vanishedUIDs are the UIDs of the messages that were expunged.
+	// It's only filled if QRESYNC is enabled.
+	vanishedUIDs []uint32
+}
+
+// EnableQResync enables QRESYNC on the connection, if the server supports it.
+// It must run right after logging in, because ENABLE isn't allowed once a folder is selected.
+// Returns whether QRESYNC is enabled.
+func EnableQResync(c *client.Client) (bool, error) {
+	supported, err := c.Support(capQResync)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for QRESYNC support: %w", err)
+	}
+	if !supported {
+		return false, nil
+	}
+
+	cmd := &imap.Command{
+		Name:      "ENABLE",
+		Arguments: []interface{}{imap.RawString(capQResync)},
+	}
+	status, err := c.Execute(cmd, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to enable QRESYNC: %w", err)
+	}
+	if err := status.Err(); err != nil {
+		return false, fmt.Errorf("failed to enable QRESYNC: %w", err)
+	}
+	return true, nil
+}
+
+// GetHighestModSeq returns the highest mod-sequence of the folder.
+// Returns 0 if the server doesn't support CONDSTORE, so callers can treat 0 as "unknown".
+func GetHighestModSeq(c *client.Client, folderName string) (uint64, error) {
+	supported, err := c.Support(capCondstore)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check for CONDSTORE support: %w", err)
+	}
+	if !supported {
+		return 0, nil
+	}
+
+	status, err := c.Status(folderName, []imap.StatusItem{statusHighestModSeq})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get status of folder %s: %w", folderName, err)
+	}
+
+	value, ok := status.Items[statusHighestModSeq]
+	if !ok {
+		return 0, nil
+	}
+	return parseModSeq(value)
+}
+
+// parseModSeq parses a mod-sequence from a STATUS or FETCH response.
+// FETCH responses wrap it in a list, like "MODSEQ (12345)", but STATUS responses don't.
+func parseModSeq(value interface{}) (uint64, error) {
+	if list, ok := value.([]interface{}); ok {
+		if len(list) != 1 {
+			return 0, fmt.Errorf("expected one mod-sequence, got %d", len(list))
+		}
+		value = list[0]
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return 0, fmt.Errorf("expected a mod-sequence, got %T", value)
+	}
+	modSeq, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mod-sequence %q: %w", s, err)
+	}
+	return modSeq, nil
+}
+
+// FetchChangesSince fetches the flags of the messages up to lastUID that changed after modSeq in the selected folder.
+// If qresync is true, QRESYNC must be enabled on the connection, and the result also includes the expunged UIDs.
+func FetchChangesSince(c *client.Client, lastUID uint32, modSeq uint64, qresync bool) (*folderChanges, error) {
+	if c == nil {
+		return nil, fmt.Errorf("client is nil")
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddRange(1, lastUID)
+
+	modifiers := []interface{}{imap.RawString("CHANGEDSINCE"), imap.RawString(strconv.FormatUint(modSeq, 10))}
+	if qresync {
+		modifiers = append(modifiers, imap.RawString("VANISHED"))
+	}
+
+	cmd := &imap.Command{
+		Name: "UID",
+		Arguments: []interface{}{
+			imap.RawString("FETCH"),
+			seqSet,
+			[]interface{}{imap.RawString(imap.FetchUid), imap.RawString(imap.FetchFlags)},
+			modifiers,
+		},
+	}
+
+	handler := &changesHandler{
+		lastUID: lastUID,
+		changes: &folderChanges{flags: map[uint32][]string{}},
+	}
+	status, err := c.Execute(cmd, handler)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch changes: %w", err)
+	}
+	if err := status.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch changes: %w", err)
+	}
+	return handler.changes, nil
+}
+
+// changesHandler collects the FETCH and VANISHED responses of a CHANGEDSINCE fetch.
+type changesHandler struct {
+	lastUID uint32
+	changes *folderChanges
+}
+
+// Handle implements responses.Handler.
+func (h *changesHandler) Handle(resp imap.Resp) error {
+	name, fields, ok := imap.ParseNamedResp(resp)
+	if !ok {
+		return responses.ErrUnhandled
+	}
+
+	switch name {
+	case "FETCH":
+		if len(fields) < 2 {
+			return responses.ErrUnhandled
+		}
+		items, ok := fields[1].([]interface{})
+		if !ok {
+			return responses.ErrUnhandled
+		}
+		msg := &imap.Message{}
+		if err := msg.Parse(items); err != nil {
+			return err
+		}
+		if msg.Uid == 0 {
+			// An unsolicited update without a UID, we can't tell which message it is
+			return responses.ErrUnhandled
+		}
+		h.changes.flags[msg.Uid] = msg.Flags
+		return nil
+	case "VANISHED":
+		if len(fields) == 0 {
+			return responses.ErrUnhandled
+		}
+		uids, err := parseVanishedUIDs(fields[len(fields)-1], h.lastUID)
+		if err != nil {
+			return err
+		}
+		h.changes.vanishedUIDs = append(h.changes.vanishedUIDs, uids...)
+		return nil
+	default:
+		return responses.ErrUnhandled
+	}
+}
+
+// parseVanishedUIDs parses the UID set of a VANISHED response, like "3:5,9".
+// It skips UIDs above lastUID, since servers may report UIDs we never saw.
+func parseVanishedUIDs(value interface{}, lastUID uint32) ([]uint32, error) {
+	s, err := imap.ParseString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VANISHED response: %w", err)
+	}
+	seqSet, err := imap.ParseSeqSet(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VANISHED UIDs %q: %w", s, err)
+	}
+
+	var uids []uint32
+	for _, seq := range seqSet.Set {
+		start, stop := seq.Start, seq.Stop
+		if start > stop {
+			start, stop = stop, start
+		}
+		if start == 0 {
+			// VANISHED doesn't allow "*", so this is a server bug. Skip it rather than guessing.
+			continue
+		}
+		stop = min(stop, lastUID)
+		for uid := start; uid <= stop && uid != 0; uid++ {
+			uids = append(uids, uid)
+		}
+	}
+	return uids, nil
+}
+
+// flagsToReadAndStarred returns whether the flags mark a message as read and starred.
+func flagsToReadAndStarred(flags []string) (isRead, isStarred bool) {
+	for _, flag := range flags {
+		if flag == imap.SeenFlag {
+			isRead = true
+		}
+		if flag == imap.FlaggedFlag {
+			isStarred = true
+		}
+	}
+	return isRead, isStarred
+}
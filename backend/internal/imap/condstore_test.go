@@ -0,0 +1,70 @@
+package imap
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+)
+
+func TestParseModSeq(t *testing.T) {
+	t.Run("parses STATUS values", func(t *testing.T) {
+		modSeq, err := parseModSeq("9223372036854775807")
+		if err != nil || modSeq != 9223372036854775807 {
+			t.Errorf("Expected the largest mod-sequence, got %d, %v", modSeq, err)
+		}
+	})
+
+	t.Run("parses FETCH values", func(t *testing.T) {
+		modSeq, err := parseModSeq([]interface{}{"12345"})
+		if err != nil || modSeq != 12345 {
+			t.Errorf("Expected 12345, got %d, %v", modSeq, err)
+		}
+	})
+
+	t.Run("rejects garbage", func(t *testing.T) {
+		if _, err := parseModSeq("NIL"); err == nil {
+			t.Error("Expected an error for NIL")
+		}
+	})
+}
+
+func TestParseVanishedUIDs(t *testing.T) {
+	uids, err := parseVanishedUIDs("3:5,9,12:10,40:60", 11)
+	if err != nil {
+		t.Fatalf("parseVanishedUIDs failed: %v", err)
+	}
+	expected := []uint32{3, 4, 5, 9, 10, 11}
+	if !reflect.DeepEqual(uids, expected) {
+		t.Errorf("Expected %v, got %v", expected, uids)
+	}
+}
+
+func TestChangesHandler(t *testing.T) {
+	handler := &changesHandler{lastUID: 100, changes: &folderChanges{flags: map[uint32][]string{}}}
+
+	fetch := &imap.DataResp{Fields: []interface{}{"4", "FETCH", []interface{}{
+		"UID", "42", "FLAGS", []interface{}{imap.SeenFlag}, "MODSEQ", []interface{}{"900"},
+	}}}
+	if err := handler.Handle(fetch); err != nil {
+		t.Fatalf("Expected FETCH to be handled, got %v", err)
+	}
+
+	vanished := &imap.DataResp{Fields: []interface{}{"VANISHED", []interface{}{"EARLIER"}, "7:8"}}
+	if err := handler.Handle(vanished); err != nil {
+		t.Fatalf("Expected VANISHED to be handled, got %v", err)
+	}
+
+	exists := &imap.DataResp{Fields: []interface{}{"5", "EXISTS"}}
+	if err := handler.Handle(exists); err != responses.ErrUnhandled {
+		t.Errorf("Expected EXISTS to be unhandled, got %v", err)
+	}
+
+	if !reflect.DeepEqual(handler.changes.flags, map[uint32][]string{42: {imap.SeenFlag}}) {
+		t.Errorf("Unexpected flags: %v", handler.changes.flags)
+	}
+	if !reflect.DeepEqual(handler.changes.vanishedUIDs, []uint32{7, 8}) {
+		t.Errorf("Unexpected vanished UIDs: %v", handler.changes.vanishedUIDs)
+	}
+}
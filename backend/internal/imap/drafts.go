@@ -0,0 +1,94 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/emersion/go-imap"
+	imapclient "github.com/emersion/go-imap/client"
+)
+
+// defaultDraftsFolderName is the Drafts folder we use if the server doesn't mark one with SPECIAL-USE.
+const defaultDraftsFolderName = "Drafts"
+
+// SaveDraft saves a draft to the user's Drafts folder, replacing the earlier copies with the same Message-ID.
+// The new copy is appended before the old ones are deleted, so the draft is never missing.
+func (s *Service) SaveDraft(ctx context.Context, userID string, raw []byte, messageID string) error {
+	return s.withDraftsFolder(ctx, userID, func(client *imapclient.Client, folderName string) error {
+		oldUIDs, err := searchUIDsByMessageID(client, messageID)
+		if err != nil {
+			return err
+		}
+
+		flags := []string{imap.DraftFlag, imap.SeenFlag}
+		if err := client.Append(folderName, flags, time.Now(), bytes.NewReader(raw)); err != nil {
+			return fmt.Errorf("failed to append to %s: %w", folderName, err)
+		}
+
+		return deleteUIDs(client, oldUIDs)
+	})
+}
+
+// DeleteDraft deletes the copies of a draft from the user's Drafts folder.
+func (s *Service) DeleteDraft(ctx context.Context, userID, messageID string) error {
+	return s.withDraftsFolder(ctx, userID, func(client *imapclient.Client, _ string) error {
+		uids, err := searchUIDsByMessageID(client, messageID)
+		if err != nil {
+			return err
+		}
+		return deleteUIDs(client, uids)
+	})
+}
+
+// withDraftsFolder finds and selects the user's Drafts folder, and runs fn with it.
+func (s *Service) withDraftsFolder(ctx context.Context, userID string, fn func(*imapclient.Client, string) error) error {
+	settings, imapPassword, err := s.getSettingsAndPassword(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return s.imapPool.WithClient(ctx, userID, ServerFromSettings(settings), settings.IMAPUsername, imapPassword, func(clientIface IMAPClient) error {
+		wrapper, ok := clientIface.(*ClientWrapper)
+		if !ok || wrapper.client == nil {
+			return fmt.Errorf("failed to unwrap IMAP client")
+		}
+		client := wrapper.client
+
+		folderName := s.findFolderByRole(ctx, client, userID, "drafts", defaultDraftsFolderName)
+		if _, err := client.Select(folderName, false); err != nil {
+			return fmt.Errorf("failed to select folder %s: %w", folderName, err)
+		}
+		return fn(client, folderName)
+	})
+}
+
+// searchUIDsByMessageID returns the UIDs of the messages with the given Message-ID in the selected folder.
+func searchUIDsByMessageID(client *imapclient.Client, messageID string) ([]uint32, error) {
+	criteria := imap.NewSearchCriteria()
+	criteria.Header.Add("Message-ID", messageID)
+	uids, err := client.UidSearch(criteria)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for Message-ID %s: %w", messageID, err)
+	}
+	return uids, nil
+}
+
+// deleteUIDs marks the messages as deleted in the selected folder, and expunges them.
+func deleteUIDs(client *imapclient.Client, uids []uint32) error {
+	if len(uids) == 0 {
+		return nil
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uids...)
+	item := imap.FormatFlagsOp(imap.AddFlags, true)
+	if err := client.UidStore(seqSet, item, []interface{}{imap.DeletedFlag}, nil); err != nil {
+		return fmt.Errorf("failed to mark messages as deleted: %w", err)
+	}
+	if err := client.Expunge(nil); err != nil {
+		return fmt.Errorf("failed to expunge messages: %w", err)
+	}
+	return nil
+}
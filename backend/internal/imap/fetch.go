@@ -1,21 +1,42 @@
 package imap
 
 import (
+	"errors"
 	"fmt"
+	"io"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
 )
 
 // FetchMessageHeaders fetches message headers for the given UIDs.
-// Returns envelope, body structure, flags, and UID for each message.
+// Returns envelope, body structure, flags, size, UID, and the header fields of headerFieldsSection for each message.
+// See messageReferences and autoReplyMessages.
 func FetchMessageHeaders(c *client.Client, uids []uint32) ([]*imap.Message, error) {
+	result := []*imap.Message{}
+	err := StreamMessageHeaders(c, uids, func(msg *imap.Message) error {
+		result = append(result, msg)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// streamBufferSize is how many fetched messages StreamMessageHeaders buffers while fn is busy.
+const streamBufferSize = 100
+
+// StreamMessageHeaders fetches message headers for the given UIDs, like FetchMessageHeaders,
+// but calls fn for each message as it arrives instead of collecting them, so the caller decides what to keep.
+// If fn returns an error, it skips the rest of the messages and returns the error once the fetch is done.
+func StreamMessageHeaders(c *client.Client, uids []uint32, fn func(*imap.Message) error) error {
 	if c == nil {
-		return nil, fmt.Errorf("client is nil")
+		return fmt.Errorf("client is nil")
 	}
 
 	if len(uids) == 0 {
-		return []*imap.Message{}, nil
+		return nil
 	}
 
 	seqSet := new(imap.SeqSet)
@@ -23,36 +44,98 @@ func FetchMessageHeaders(c *client.Client, uids []uint32) ([]*imap.Message, erro
 		seqSet.AddNum(uid)
 	}
 
-	// Fetch envelope, body structure, flags, and UID
+	// Fetch envelope, body structure, flags, size, UID, and the headers for threading and auto-responders
 	items := []imap.FetchItem{
 		imap.FetchEnvelope,
 		imap.FetchBodyStructure,
 		imap.FetchFlags,
+		imap.FetchRFC822Size,
 		imap.FetchUid,
+		headerFieldsSection.FetchItem(),
 	}
 
-	messages := make(chan *imap.Message, len(uids))
+	messages := make(chan *imap.Message, min(len(uids), streamBufferSize))
 	done := make(chan error, 1)
 
 	go func() {
 		done <- c.UidFetch(seqSet, items, messages)
 	}()
 
-	var result []*imap.Message
+	// Keep reading after an error, since the fetch blocks until we read all its messages
+	var fnErr error
+	for msg := range messages {
+		if fnErr == nil {
+			fnErr = fn(msg)
+		}
+	}
+
+	if err := <-done; err != nil {
+		return fmt.Errorf("failed to fetch messages: %w", err)
+	}
+
+	return fnErr
+}
+
+// FetchNewestMessageHeaders fetches the headers of the newest count messages in the selected folder.
+// messageCount is the number of messages in the folder, as returned by SELECT.
+// It fetches by sequence number, so it doesn't need to search for UIDs first, which is slow for big folders.
+// Returns envelope, flags, size, and UID for each message, in no particular order.
+func FetchNewestMessageHeaders(c *client.Client, messageCount uint32, count int) ([]*imap.Message, error) {
+	if c == nil {
+		return nil, fmt.Errorf("client is nil")
+	}
+
+	if messageCount == 0 || count <= 0 {
+		return []*imap.Message{}, nil
+	}
+
+	from := uint32(1)
+	if messageCount > uint32(count) {
+		from = messageCount - uint32(count) + 1
+	}
+	seqSet := new(imap.SeqSet)
+	seqSet.AddRange(from, messageCount)
+
+	items := []imap.FetchItem{
+		imap.FetchEnvelope,
+		imap.FetchFlags,
+		imap.FetchRFC822Size,
+		imap.FetchUid,
+	}
+
+	messages := make(chan *imap.Message, messageCount-from+1)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.Fetch(seqSet, items, messages)
+	}()
+
+	result := []*imap.Message{}
 	for msg := range messages {
 		result = append(result, msg)
 	}
 
 	if err := <-done; err != nil {
-		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+		return nil, fmt.Errorf("failed to fetch newest messages: %w", err)
 	}
 
 	return result, nil
 }
 
+// FetchLimits caps how much of a message a sync reads, so that a broken or hostile server can't make it
+// read huge messages into memory. A limit of 0 or less means no limit.
+type FetchLimits struct {
+	// MaxMessageBytes is the most we fetch of a message. Of bigger messages, we only fetch the start.
+	MaxMessageBytes int
+	// MaxPartBytes is the longest text or HTML body we keep. Longer ones are cut.
+	MaxPartBytes int
+}
+
 // FetchFullMessage fetches the full message body for the given UID.
 // First fetches headers and body structure, then fetches the actual body content.
-func FetchFullMessage(c *client.Client, uid uint32) (*imap.Message, error) {
+// If the message is bigger than maxBytes, it only fetches the first maxBytes with a partial fetch,
+// BODY[]<0.maxBytes>, which ParseMessage marks as truncated. A maxBytes of 0 or less means no limit.
+func FetchFullMessage(c *client.Client, uid uint32, maxBytes int) (*imap.Message, error) {
 	if c == nil {
 		return nil, fmt.Errorf("client is nil")
 	}
@@ -60,11 +143,12 @@ func FetchFullMessage(c *client.Client, uid uint32) (*imap.Message, error) {
 	seqSet := new(imap.SeqSet)
 	seqSet.AddNum(uid)
 
-	// Fetch envelope, body structure, flags, and UID first
+	// Fetch envelope, body structure, flags, size, and UID first
 	items := []imap.FetchItem{
 		imap.FetchEnvelope,
 		imap.FetchBodyStructure,
 		imap.FetchFlags,
+		imap.FetchRFC822Size,
 		imap.FetchUid,
 	}
 
@@ -87,6 +171,9 @@ func FetchFullMessage(c *client.Client, uid uint32) (*imap.Message, error) {
 	// Now fetch the body using the body structure
 	if msg.BodyStructure != nil {
 		section := &imap.BodySectionName{}
+		if maxBytes > 0 && msg.Size > uint32(maxBytes) {
+			section.Partial = []int{0, maxBytes}
+		}
 		bodyItem := section.FetchItem()
 		bodyItems := []imap.FetchItem{bodyItem}
 
@@ -111,6 +198,68 @@ func FetchFullMessage(c *client.Client, uid uint32) (*imap.Message, error) {
 	return msg, nil
 }
 
+// ErrMessageNotFound means that the server has no message with the UID in the folder, for example, because it was
+// moved or deleted since the last sync.
+var ErrMessageNotFound = errors.New("message not found on the server")
+
+// RawMessage is the source of a message, as the IMAP server has it.
+type RawMessage struct {
+	// Body is the RFC 822 source of the message, or only its start if Truncated is true.
+	Body io.Reader
+	// Length is how many bytes Body has.
+	Length int
+	// Truncated is true if the message was over the fetch size limit.
+	Truncated bool
+}
+
+// FetchRawMessage fetches the source of the message with the UID, with BODY.PEEK[], so it doesn't mark the message
+// as read. If the message is bigger than maxBytes, it only fetches the first maxBytes, like FetchFullMessage.
+// A maxBytes of 0 or less means no limit. Returns ErrMessageNotFound if the selected folder has no such message.
+func FetchRawMessage(c *client.Client, uid uint32, maxBytes int) (*RawMessage, error) {
+	if c == nil {
+		return nil, fmt.Errorf("client is nil")
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uid)
+
+	section := &imap.BodySectionName{Peek: true}
+	if maxBytes > 0 {
+		section.Partial = []int{0, maxBytes}
+	}
+	items := []imap.FetchItem{imap.FetchUid, imap.FetchRFC822Size, section.FetchItem()}
+
+	messages := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(seqSet, items, messages)
+	}()
+
+	var msg *imap.Message
+	for m := range messages {
+		msg = m
+	}
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("failed to fetch raw message: %w", err)
+	}
+	if msg == nil {
+		return nil, ErrMessageNotFound
+	}
+
+	// We asked for one section, but the server names it after what it sent, like BODY[]<0> for partial fetches
+	for _, literal := range msg.Body {
+		if literal == nil {
+			continue
+		}
+		return &RawMessage{
+			Body:      literal,
+			Length:    literal.Len(),
+			Truncated: maxBytes > 0 && msg.Size > uint32(maxBytes),
+		}, nil
+	}
+	return nil, fmt.Errorf("server did not return the message body")
+}
+
 // SearchUIDsSince searches for all UIDs greater than or equal to the given UID.
 // This is used for incremental sync to find only new messages.
 //
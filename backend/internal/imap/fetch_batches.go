@@ -0,0 +1,130 @@
+package imap
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/emersion/go-imap"
+	imapclient "github.com/emersion/go-imap/client"
+	"github.com/vdavid/vmail/backend/internal/websocket"
+)
+
+// FetchBatching configures how full syncs fetch the headers of big folders.
+type FetchBatching struct {
+	// BatchSize is how many UIDs a UID FETCH command asks for. Zero or less fetches all headers in one command.
+	BatchSize int
+	// Connections is how many connections of the user fetch batches at the same time, including the sync's own.
+	// The extra ones are only used if the pool has free worker slots. One or less uses only the sync's own.
+	Connections int
+}
+
+// splitUIDs splits uids into batches of at most size UIDs. A size of 0 or less gives a single batch.
+func splitUIDs(uids []uint32, size int) [][]uint32 {
+	if len(uids) == 0 {
+		return nil
+	}
+	if size <= 0 || len(uids) <= size {
+		return [][]uint32{uids}
+	}
+	batches := make([][]uint32, 0, (len(uids)+size-1)/size)
+	for start := 0; start < len(uids); start += size {
+		batches = append(batches, uids[start:min(start+size, len(uids))])
+	}
+	return batches
+}
+
+// streamFullSyncHeaders fetches the headers of the messages with uids in the selected folder, and calls fn for
+// each, like StreamMessageHeaders. It fetches in batches of s.fetchBatching.BatchSize, on client and on extra
+// connections of the user that examine the folder read-only, so that a big folder doesn't take one huge command.
+// It calls fn from one goroutine at a time, in no particular order, and publishes the progress after each batch.
+func (s *Service) streamFullSyncHeaders(ctx context.Context, client *imapclient.Client, userID, folderName string, uids []uint32, fn func(*imap.Message) error) error {
+	batches := splitUIDs(uids, s.fetchBatching.BatchSize)
+	if len(batches) <= 1 {
+		return StreamMessageHeaders(client, uids, fn)
+	}
+
+	jobs := make(chan []uint32, len(batches))
+	for _, batch := range batches {
+		jobs <- batch
+	}
+	close(jobs)
+
+	var (
+		mu       sync.Mutex // Guards fn, fetched, and firstErr
+		fetched  int
+		firstErr error
+		pending  sync.WaitGroup // Batches that aren't done yet
+	)
+	pending.Add(len(batches))
+
+	// work fetches batches on c until there are none left. After an error, it skips the rest of the batches,
+	// but still takes them, so that pending gets done even if no other connection helps.
+	work := func(c *imapclient.Client) {
+		for batch := range jobs {
+			mu.Lock()
+			failed := firstErr != nil
+			mu.Unlock()
+			if failed {
+				pending.Done()
+				continue
+			}
+
+			err := StreamMessageHeaders(c, batch, func(msg *imap.Message) error {
+				mu.Lock()
+				defer mu.Unlock()
+				return fn(msg)
+			})
+
+			mu.Lock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			fetched += len(batch)
+			if err == nil {
+				s.publishSyncEvent(ctx, userID, websocket.Event{Type: websocket.EventSyncProgress, Folder: folderName, Count: fetched, Total: len(uids)})
+			}
+			mu.Unlock()
+			pending.Done()
+		}
+	}
+
+	// Extra connections wait for free worker slots, so we stop them once all batches are done
+	extraCtx, cancelExtra := context.WithCancel(ctx)
+	defer cancelExtra()
+	var extras sync.WaitGroup
+	if extraCount := min(s.fetchBatching.Connections, len(batches)) - 1; extraCount > 0 {
+		settings, imapPassword, err := s.getSettingsAndPassword(ctx, userID)
+		if err != nil {
+			return err
+		}
+		for range extraCount {
+			extras.Add(1)
+			go func() {
+				defer extras.Done()
+				err := s.imapPool.WithClient(extraCtx, userID, ServerFromSettings(settings), settings.IMAPUsername, imapPassword, func(clientIface IMAPClient) error {
+					wrapper, ok := clientIface.(*ClientWrapper)
+					if !ok || wrapper.client == nil {
+						return fmt.Errorf("failed to unwrap IMAP client")
+					}
+					if _, err := wrapper.client.Select(folderName, true); err != nil {
+						return fmt.Errorf("failed to examine folder %s: %w", folderName, err)
+					}
+					work(wrapper.client)
+					return nil
+				})
+				if err != nil && extraCtx.Err() == nil {
+					// The other connections take the batches that this one would have
+					slog.WarnContext(ctx, "IMAP Sync: Extra connection for fetching headers failed", "folder", folderName, "error", err)
+				}
+			}()
+		}
+	}
+
+	work(client)
+	pending.Wait()
+	cancelExtra()
+	extras.Wait()
+	return firstErr
+}
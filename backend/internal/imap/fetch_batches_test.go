@@ -0,0 +1,86 @@
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestSplitUIDs(t *testing.T) {
+	uids := []uint32{1, 2, 3, 4, 5, 6, 7}
+
+	testCases := []struct {
+		name     string
+		uids     []uint32
+		size     int
+		expected [][]uint32
+	}{
+		{"no UIDs", nil, 3, nil},
+		{"no batch size", uids, 0, [][]uint32{uids}},
+		{"fewer UIDs than the batch size", uids, 10, [][]uint32{uids}},
+		{"splits into full batches and the rest", uids, 3, [][]uint32{{1, 2, 3}, {4, 5, 6}, {7}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := splitUIDs(tc.uids, tc.size)
+			if !slices.EqualFunc(got, tc.expected, slices.Equal[[]uint32]) {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestStreamFullSyncHeaders(t *testing.T) {
+	server := testutil.NewTestIMAPServer(t)
+	defer server.Close()
+
+	server.EnsureINBOX(t)
+	var uids []uint32
+	for i := range 7 {
+		uids = append(uids, server.AddMessage(t, "INBOX", fmt.Sprintf("<batch-%d@example.com>", i), "Batch", "from@example.com", "to@example.com", time.Now()))
+	}
+
+	client, cleanup := server.Connect(t)
+	defer cleanup()
+	if _, err := client.Select("INBOX", false); err != nil {
+		t.Fatalf("Failed to select INBOX: %v", err)
+	}
+
+	// Extra connections need the user's settings from the database, so these use only the sync's own
+	s := &Service{fetchBatching: FetchBatching{BatchSize: 3, Connections: 1}}
+
+	t.Run("fetches all messages in batches", func(t *testing.T) {
+		var got []uint32
+		err := s.streamFullSyncHeaders(t.Context(), client, "user", "INBOX", uids, func(msg *imap.Message) error {
+			got = append(got, msg.Uid)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to fetch headers: %v", err)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, uids) {
+			t.Errorf("Expected UIDs %v, got %v", uids, got)
+		}
+	})
+
+	t.Run("stops after an error", func(t *testing.T) {
+		calls := 0
+		err := s.streamFullSyncHeaders(t.Context(), client, "user", "INBOX", uids, func(*imap.Message) error {
+			calls++
+			return errors.New("disk full")
+		})
+		if err == nil || err.Error() != "disk full" {
+			t.Errorf("Expected the error of fn, got %v", err)
+		}
+		if calls != 1 {
+			t.Errorf("Expected fn to be called once, got %d calls", calls)
+		}
+	})
+}
@@ -1,9 +1,14 @@
 package imap
 
 import (
+	"errors"
+	"io"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/emersion/go-imap"
 	"github.com/vdavid/vmail/backend/internal/testutil"
 )
 
@@ -75,9 +80,80 @@ func TestFetchMessageHeaders(t *testing.T) {
 	})
 }
 
+func TestFetchNewestMessageHeaders(t *testing.T) {
+	t.Run("returns error for nil client", func(t *testing.T) {
+		_, err := FetchNewestMessageHeaders(nil, 3, 2)
+		if err == nil {
+			t.Error("Expected error for nil client")
+		}
+	})
+
+	t.Run("fetches only the newest messages", func(t *testing.T) {
+		server := testutil.NewTestIMAPServer(t)
+		defer server.Close()
+
+		server.EnsureINBOX(t)
+
+		now := time.Now()
+		var uids []uint32
+		for i, messageID := range []string{"<old@example.com>", "<middle@example.com>", "<new@example.com>"} {
+			uids = append(uids, server.AddMessage(t, "INBOX", messageID, "Subject", "from@example.com", "to@example.com", now.Add(time.Duration(i)*time.Minute)))
+		}
+
+		client, cleanup := server.Connect(t)
+		defer cleanup()
+
+		mbox, err := client.Select("INBOX", false)
+		if err != nil {
+			t.Fatalf("Failed to select INBOX: %v", err)
+		}
+
+		messages, err := FetchNewestMessageHeaders(client, mbox.Messages, 2)
+		if err != nil {
+			t.Fatalf("Failed to fetch newest message headers: %v", err)
+		}
+
+		if len(messages) != 2 {
+			t.Fatalf("Expected 2 messages, got %d", len(messages))
+		}
+		for _, msg := range messages {
+			if msg.Uid == uids[0] {
+				t.Error("Expected the oldest message to be left out")
+			}
+			if msg.Envelope == nil {
+				t.Error("Expected envelope, got nil")
+			}
+		}
+	})
+
+	t.Run("returns all messages when the folder is smaller than the count", func(t *testing.T) {
+		server := testutil.NewTestIMAPServer(t)
+		defer server.Close()
+
+		server.EnsureINBOX(t)
+		server.AddMessage(t, "INBOX", "<only@example.com>", "Subject", "from@example.com", "to@example.com", time.Now())
+
+		client, cleanup := server.Connect(t)
+		defer cleanup()
+
+		mbox, err := client.Select("INBOX", false)
+		if err != nil {
+			t.Fatalf("Failed to select INBOX: %v", err)
+		}
+
+		messages, err := FetchNewestMessageHeaders(client, mbox.Messages, 50)
+		if err != nil {
+			t.Fatalf("Failed to fetch newest message headers: %v", err)
+		}
+		if len(messages) != int(mbox.Messages) {
+			t.Errorf("Expected %d messages, got %d", mbox.Messages, len(messages))
+		}
+	})
+}
+
 func TestFetchFullMessage(t *testing.T) {
 	t.Run("returns error for nil client", func(t *testing.T) {
-		_, err := FetchFullMessage(nil, 1)
+		_, err := FetchFullMessage(nil, 1, 0)
 		if err == nil {
 			t.Error("Expected error for nil client")
 		}
@@ -105,7 +181,7 @@ func TestFetchFullMessage(t *testing.T) {
 		}
 
 		// Fetch full message
-		msg, err := FetchFullMessage(client, uid)
+		msg, err := FetchFullMessage(client, uid, 0)
 		if err != nil {
 			t.Fatalf("Failed to fetch full message: %v", err)
 		}
@@ -123,6 +199,40 @@ func TestFetchFullMessage(t *testing.T) {
 		}
 	})
 
+	t.Run("fetches only the start of messages over the limit", func(t *testing.T) {
+		server := testutil.NewTestIMAPServer(t)
+		defer server.Close()
+
+		server.EnsureINBOX(t)
+		uid := server.AddMessage(t, "INBOX", "<big@example.com>", "Big", "from@example.com", "to@example.com", time.Now())
+
+		client, cleanup := server.Connect(t)
+		defer cleanup()
+		if _, err := client.Select("INBOX", false); err != nil {
+			t.Fatalf("Failed to select INBOX: %v", err)
+		}
+
+		imapMsg, err := FetchFullMessage(client, uid, 50)
+		if err != nil {
+			t.Fatalf("Failed to fetch full message: %v", err)
+		}
+		body := imapMsg.GetBody(&imap.BodySectionName{Partial: []int{0}})
+		if body == nil || body.Len() != 50 {
+			t.Fatalf("Expected the first 50 bytes of the message, got %v", body)
+		}
+
+		msg, err := ParseMessage(imapMsg, "thread-id", "user-id", "INBOX")
+		if err != nil {
+			t.Fatalf("ParseMessage failed: %v", err)
+		}
+		if !msg.Truncated {
+			t.Error("Expected the message to be truncated")
+		}
+		if msg.SizeBytes <= 50 {
+			t.Errorf("Expected the full size of the message, got %d", msg.SizeBytes)
+		}
+	})
+
 	t.Run("handles message without body structure", func(t *testing.T) {
 		// This test verifies that FetchFullMessage doesn't crash when
 		// BodyStructure is nil. The function should still return the message
@@ -145,7 +255,7 @@ func TestFetchFullMessage(t *testing.T) {
 		}
 
 		// Fetch full message
-		msg, err := FetchFullMessage(client, uid)
+		msg, err := FetchFullMessage(client, uid, 0)
 		if err != nil {
 			t.Fatalf("Failed to fetch full message: %v", err)
 		}
@@ -156,3 +266,61 @@ func TestFetchFullMessage(t *testing.T) {
 		}
 	})
 }
+
+func TestFetchRawMessage(t *testing.T) {
+	server := testutil.NewTestIMAPServer(t)
+	defer server.Close()
+
+	server.EnsureINBOX(t)
+	uid := server.AddMessage(t, "INBOX", "<raw@example.com>", "Raw", "from@example.com", "to@example.com", time.Now())
+
+	client, cleanup := server.Connect(t)
+	defer cleanup()
+	if _, err := client.Select("INBOX", false); err != nil {
+		t.Fatalf("Failed to select INBOX: %v", err)
+	}
+
+	t.Run("fetches the source without marking the message as read", func(t *testing.T) {
+		seqSet := new(imap.SeqSet)
+		seqSet.AddNum(uid)
+		if err := client.UidStore(seqSet, imap.FormatFlagsOp(imap.RemoveFlags, true), []any{imap.SeenFlag}, nil); err != nil {
+			t.Fatalf("Failed to mark the message as unread: %v", err)
+		}
+
+		raw, err := FetchRawMessage(client, uid, 0)
+		if err != nil {
+			t.Fatalf("FetchRawMessage failed: %v", err)
+		}
+		source, err := io.ReadAll(raw.Body)
+		if err != nil {
+			t.Fatalf("Failed to read the source: %v", err)
+		}
+		if raw.Truncated || len(source) != raw.Length || !strings.Contains(string(source), "Subject: Raw") {
+			t.Errorf("Expected the whole source, got %+v: %q", raw, source)
+		}
+
+		messages, err := FetchMessageHeaders(client, []uint32{uid})
+		if err != nil {
+			t.Fatalf("FetchMessageHeaders failed: %v", err)
+		}
+		if len(messages) != 1 || slices.Contains(messages[0].Flags, imap.SeenFlag) {
+			t.Errorf("Expected the message to stay unread, got %+v", messages)
+		}
+	})
+
+	t.Run("fetches only the start of messages over the limit", func(t *testing.T) {
+		raw, err := FetchRawMessage(client, uid, 50)
+		if err != nil {
+			t.Fatalf("FetchRawMessage failed: %v", err)
+		}
+		if !raw.Truncated || raw.Length != 50 {
+			t.Errorf("Expected the first 50 bytes, got %+v", raw)
+		}
+	})
+
+	t.Run("returns ErrMessageNotFound for unknown UIDs", func(t *testing.T) {
+		if _, err := FetchRawMessage(client, uid+100, 0); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("Expected ErrMessageNotFound, got %v", err)
+		}
+	})
+}
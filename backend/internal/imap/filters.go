@@ -0,0 +1,210 @@
+package imap
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	imapclient "github.com/emersion/go-imap/client"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// filterActions are what the user's filters do to a batch of new messages, by UID.
+type filterActions struct {
+	markRead []uint32
+	labels   map[string][]uint32
+	// moves maps folders to the messages to move there.
+	moves map[string][]uint32
+	trash []uint32
+}
+
+// applyFilters applies the user's filters to the new INBOX messages: it marks them as read and adds labels, then
+// moves them to Trash or other folders. Returns the messages that stay in INBOX, which the sync caches as usual,
+// with their new flags. The folder must be selected. If anything fails, it logs the error and keeps the messages
+// as they are, like fileBlockedMessages.
+func (s *Service) applyFilters(ctx context.Context, client *imapclient.Client, userID, folderName string, messages []*imap.Message, stats *saveStats) []*imap.Message {
+	if folderName != "INBOX" || len(messages) == 0 {
+		return messages
+	}
+
+	filters, err := db.GetFilters(ctx, s.dbPool, userID)
+	if err != nil {
+		slog.WarnContext(ctx, "IMAP Sync: Failed to get filters", "error", err)
+		return messages
+	}
+	if len(filters) == 0 {
+		return messages
+	}
+
+	actions := planFilterActions(filters, messages)
+	if len(actions.markRead) > 0 {
+		if err := storeFlag(client, actions.markRead, imap.SeenFlag); err != nil {
+			slog.WarnContext(ctx, "IMAP Sync: Failed to mark filtered messages as read", "count", len(actions.markRead), "error", err)
+		} else {
+			addFlag(messagesWithUIDs(messages, actions.markRead), imap.SeenFlag)
+		}
+	}
+	for label, uids := range actions.labels {
+		if !allowsKeyword(client.Mailbox().PermanentFlags, label) {
+			slog.WarnContext(ctx, "IMAP Sync: INBOX doesn't allow the label of a filter", "label", label)
+			continue
+		}
+		if err := storeFlag(client, uids, label); err != nil {
+			slog.WarnContext(ctx, "IMAP Sync: Failed to label filtered messages", "count", len(uids), "label", label, "error", err)
+			continue
+		}
+		addFlag(messagesWithUIDs(messages, uids), label)
+	}
+
+	moved := make(map[uint32]bool)
+	moveTo := func(uids []uint32, destinationFolder string) {
+		seqSet := new(imap.SeqSet)
+		seqSet.AddNum(uids...)
+		if err := client.UidMove(seqSet, destinationFolder); err != nil {
+			slog.WarnContext(ctx, "IMAP Sync: Failed to move filtered messages", "count", len(uids), "destination", destinationFolder, "error", err)
+			return
+		}
+		for _, uid := range uids {
+			moved[uid] = true
+		}
+		if stats != nil {
+			stats.filtered += len(uids)
+		}
+	}
+	if len(actions.trash) > 0 {
+		moveTo(actions.trash, s.findFolderByRole(ctx, client, userID, TrashDestination.Role, TrashDestination.FallbackFolderName))
+	}
+	for destinationFolder, uids := range actions.moves {
+		moveTo(uids, destinationFolder)
+	}
+
+	return slices.DeleteFunc(messages, func(msg *imap.Message) bool { return moved[msg.Uid] })
+}
+
+// planFilterActions returns what the filters do to the messages. Every filter that matches a message applies, in
+// order: it's marked as read if any of them says so and gets all of their labels. Deleting wins over moving, and
+// the first filter that moves the message decides where to.
+func planFilterActions(filters []*models.Filter, messages []*imap.Message) filterActions {
+	actions := filterActions{labels: make(map[string][]uint32), moves: make(map[string][]uint32)}
+	for _, msg := range messages {
+		markRead, trash := false, false
+		var labels []string
+		destinationFolder := ""
+		for _, filter := range filters {
+			if !filterMatches(filter, msg) {
+				continue
+			}
+			markRead = markRead || filter.MarkRead
+			trash = trash || filter.Delete
+			if filter.AddLabel != "" && !slices.Contains(labels, filter.AddLabel) {
+				labels = append(labels, filter.AddLabel)
+			}
+			if destinationFolder == "" {
+				destinationFolder = filter.MoveTo
+			}
+		}
+
+		if markRead {
+			actions.markRead = append(actions.markRead, msg.Uid)
+		}
+		for _, label := range labels {
+			actions.labels[label] = append(actions.labels[label], msg.Uid)
+		}
+		if trash {
+			actions.trash = append(actions.trash, msg.Uid)
+		} else if destinationFolder != "" {
+			actions.moves[destinationFolder] = append(actions.moves[destinationFolder], msg.Uid)
+		}
+	}
+	return actions
+}
+
+// filterMatches returns true if the message matches all the conditions of the filter. It compares the addresses
+// in the form the cache has them, so that testing a filter on cached messages finds the same ones.
+func filterMatches(filter *models.Filter, msg *imap.Message) bool {
+	if msg.Envelope == nil {
+		return false
+	}
+	if filter.From != "" {
+		from := ""
+		if len(msg.Envelope.From) > 0 {
+			from = formatAddress(msg.Envelope.From[0])
+		}
+		if !containsFold(from, filter.From) {
+			return false
+		}
+	}
+	if filter.To != "" {
+		recipients := append(formatAddressList(msg.Envelope.To), formatAddressList(msg.Envelope.Cc)...)
+		if !slices.ContainsFunc(recipients, func(recipient string) bool { return containsFold(recipient, filter.To) }) {
+			return false
+		}
+	}
+	if filter.Subject != "" && !containsFold(msg.Envelope.Subject, filter.Subject) {
+		return false
+	}
+	if filter.HasAttachment != nil && *filter.HasAttachment != hasAttachment(msg.BodyStructure) {
+		return false
+	}
+	return true
+}
+
+// containsFold returns true if s contains substr, case-insensitively.
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// hasAttachment returns true if the message has a part that's an attachment, as opposed to the body or an inline
+// part, like a signature image. Parts with a filename and no disposition count as attachments too.
+func hasAttachment(bodyStructure *imap.BodyStructure) bool {
+	if bodyStructure == nil {
+		return false
+	}
+	found := false
+	bodyStructure.Walk(func(_ []int, part *imap.BodyStructure) bool {
+		if part.MIMEType == "multipart" {
+			return true
+		}
+		filename, _ := part.Filename()
+		if part.Disposition == "attachment" || (part.Disposition == "" && filename != "") {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// hasPartNamed returns true if the message has a part whose filename contains name, case-insensitively,
+// be it an attachment or an inline part.
+func hasPartNamed(bodyStructure *imap.BodyStructure, name string) bool {
+	if bodyStructure == nil {
+		return false
+	}
+	found := false
+	bodyStructure.Walk(func(_ []int, part *imap.BodyStructure) bool {
+		if filename, _ := part.Filename(); filename != "" && containsFold(filename, name) {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// storeFlag adds the flag to the messages with the UIDs in the selected folder.
+func storeFlag(client *imapclient.Client, uids []uint32, flag string) error {
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uids...)
+	return client.UidStore(seqSet, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{flag}, nil)
+}
+
+// addFlag adds the flag to the fetched messages that don't have it yet, so that the cache has it right away.
+func addFlag(messages []*imap.Message, flag string) {
+	for _, msg := range messages {
+		if !slices.Contains(msg.Flags, flag) {
+			msg.Flags = append(msg.Flags, flag)
+		}
+	}
+}
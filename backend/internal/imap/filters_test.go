@@ -0,0 +1,184 @@
+package imap
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestFilterMatches(t *testing.T) {
+	withAttachment := &imap.BodyStructure{MIMEType: "multipart", MIMESubType: "mixed", Parts: []*imap.BodyStructure{
+		{MIMEType: "text", MIMESubType: "plain"},
+		{MIMEType: "application", MIMESubType: "pdf", Disposition: "attachment", DispositionParams: map[string]string{"filename": "invoice.pdf"}},
+	}}
+	msg := &imap.Message{
+		Envelope: &imap.Envelope{
+			From:    []*imap.Address{{PersonalName: "Billing", MailboxName: "billing", HostName: "shop.example.com"}},
+			To:      []*imap.Address{{MailboxName: "me", HostName: "example.com"}},
+			Cc:      []*imap.Address{{MailboxName: "team", HostName: "example.com"}},
+			Subject: "Your Invoice",
+		},
+		BodyStructure: withAttachment,
+	}
+	hasAttachment := true
+	hasNoAttachment := false
+
+	testCases := []struct {
+		name   string
+		filter models.Filter
+		want   bool
+	}{
+		{"matches the sender's address", models.Filter{From: "BILLING@shop"}, true},
+		{"matches the sender's name", models.Filter{From: "billing <"}, true},
+		{"doesn't match another sender", models.Filter{From: "alice@"}, false},
+		{"matches Cc", models.Filter{To: "team@example.com"}, true},
+		{"doesn't match another recipient", models.Filter{To: "boss@"}, false},
+		{"matches the subject", models.Filter{Subject: "invoice"}, true},
+		{"matches attachments", models.Filter{HasAttachment: &hasAttachment}, true},
+		{"doesn't match a missing attachment", models.Filter{HasAttachment: &hasNoAttachment}, false},
+		{"needs all conditions", models.Filter{From: "billing", Subject: "receipt"}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := filterMatches(&tc.filter, msg); got != tc.want {
+				t.Errorf("Expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestHasAttachment(t *testing.T) {
+	testCases := []struct {
+		name          string
+		bodyStructure *imap.BodyStructure
+		want          bool
+	}{
+		{"no structure", nil, false},
+		{"plain text", &imap.BodyStructure{MIMEType: "text", MIMESubType: "plain"}, false},
+		{"inline image", &imap.BodyStructure{MIMEType: "multipart", MIMESubType: "related", Parts: []*imap.BodyStructure{
+			{MIMEType: "text", MIMESubType: "html"},
+			{MIMEType: "image", MIMESubType: "png", Disposition: "inline", DispositionParams: map[string]string{"filename": "logo.png"}},
+		}}, false},
+		{"named part without disposition", &imap.BodyStructure{MIMEType: "multipart", MIMESubType: "mixed", Parts: []*imap.BodyStructure{
+			{MIMEType: "text", MIMESubType: "plain"},
+			{MIMEType: "application", MIMESubType: "zip", Params: map[string]string{"name": "files.zip"}},
+		}}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := hasAttachment(tc.bodyStructure); got != tc.want {
+				t.Errorf("Expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestPlanFilterActions(t *testing.T) {
+	from := func(uid uint32, mailbox string) *imap.Message {
+		return &imap.Message{Uid: uid, Envelope: &imap.Envelope{From: []*imap.Address{{MailboxName: mailbox, HostName: "example.com"}}}}
+	}
+	messages := []*imap.Message{from(1, "news"), from(2, "spammer"), from(3, "friend")}
+	filters := []*models.Filter{
+		{From: "news@", MoveTo: "Newsletters", AddLabel: "news"},
+		{From: "news@", MoveTo: "Other", MarkRead: true, AddLabel: "news"},
+		{From: "spammer@", MoveTo: "Other", AddLabel: "junk"},
+		{From: "spammer@", Delete: true},
+	}
+
+	actions := planFilterActions(filters, messages)
+
+	if !slices.Equal(actions.markRead, []uint32{1}) {
+		t.Errorf("Expected to mark UID 1 as read, got %v", actions.markRead)
+	}
+	if !slices.Equal(actions.labels["news"], []uint32{1}) || !slices.Equal(actions.labels["junk"], []uint32{2}) {
+		t.Errorf("Expected to label UID 1 news and UID 2 junk, got %v", actions.labels)
+	}
+	if len(actions.moves) != 1 || !slices.Equal(actions.moves["Newsletters"], []uint32{1}) {
+		t.Errorf("Expected the first filter to move UID 1 to Newsletters, got %v", actions.moves)
+	}
+	if !slices.Equal(actions.trash, []uint32{2}) {
+		t.Errorf("Expected deleting to win for UID 2, got %v", actions.trash)
+	}
+}
+
+func TestApplyFilters(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	server := testutil.NewTestIMAPServer(t)
+	defer server.Close()
+	server.EnsureINBOX(t)
+
+	client, clientCleanup := server.Connect(t)
+	defer clientCleanup()
+	for _, folder := range []string{"Trash", "Newsletters"} {
+		if err := client.Create(folder); err != nil {
+			t.Fatalf("Failed to create %s folder: %v", folder, err)
+		}
+	}
+
+	service := NewService(pool, NewPool(), getTestEncryptor(t), nil)
+	defer service.Close()
+
+	ctx := context.Background()
+	userID, err := db.GetOrCreateUser(ctx, pool, "filters-sync-test@example.com")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	for _, filter := range []*models.Filter{
+		{From: "news@example.com", MoveTo: "Newsletters"},
+		{From: "spammer@example.com", Delete: true},
+		{Subject: "receipt", MarkRead: true},
+	} {
+		if err := db.CreateFilter(ctx, pool, userID, filter); err != nil {
+			t.Fatalf("Failed to create filter: %v", err)
+		}
+	}
+
+	now := time.Now()
+	newsUID := server.AddMessage(t, "INBOX", "<news@example.com>", "Weekly news", "news@example.com", "me@example.com", now)
+	spamUID := server.AddMessage(t, "INBOX", "<spam@example.com>", "Buy now", "spammer@example.com", "me@example.com", now)
+	receiptUID := server.AddMessage(t, "INBOX", "<receipt@example.com>", "Your receipt", "shop@example.com", "me@example.com", now)
+
+	if _, err := client.Select("INBOX", false); err != nil {
+		t.Fatalf("Failed to select INBOX: %v", err)
+	}
+	messages, err := FetchMessageHeaders(client, []uint32{newsUID, spamUID, receiptUID})
+	if err != nil {
+		t.Fatalf("Failed to fetch headers: %v", err)
+	}
+
+	stats := &saveStats{}
+	kept := service.applyFilters(ctx, client, userID, "INBOX", messages, stats)
+
+	if len(kept) != 1 || kept[0].Uid != receiptUID {
+		t.Fatalf("Expected to keep only the receipt, got %v", messageUIDs(kept))
+	}
+	if !slices.Contains(kept[0].Flags, imap.SeenFlag) {
+		t.Errorf("Expected the receipt to be marked as read, got flags %v", kept[0].Flags)
+	}
+	if stats.filtered != 2 {
+		t.Errorf("Expected 2 filtered messages in the stats, got %d", stats.filtered)
+	}
+
+	for folder, messageID := range map[string]string{"Newsletters": "<news@example.com>", "Trash": "<spam@example.com>"} {
+		if _, err := client.Select(folder, true); err != nil {
+			t.Fatalf("Failed to select %s: %v", folder, err)
+		}
+		uids, err := searchUIDsByMessageID(client, messageID)
+		if err != nil {
+			t.Fatalf("searchUIDsByMessageID failed: %v", err)
+		}
+		if len(uids) != 1 {
+			t.Errorf("Expected %s in %s, got UIDs %v", messageID, folder, uids)
+		}
+	}
+}
@@ -1,30 +1,63 @@
 package imap
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"strings"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
+	"github.com/vdavid/vmail/backend/internal/db"
 	"github.com/vdavid/vmail/backend/internal/models"
 )
 
-// ListFolders lists all folders on the IMAP server with their roles determined by SPECIAL-USE attributes (RFC 6154).
-// Returns an error if the server doesn't support SPECIAL-USE extension.
-func ListFolders(c *client.Client) ([]*models.Folder, error) {
-	if c == nil {
-		return nil, fmt.Errorf("client is nil")
+// GetFolders lists the user's folders with their roles, including the roles that the user set by hand.
+func (s *Service) GetFolders(ctx context.Context, userID string) ([]*models.Folder, error) {
+	settings, imapPassword, err := s.getSettingsAndPassword(ctx, userID)
+	if err != nil {
+		return nil, err
 	}
 
-	// Check if server supports SPECIAL-USE extension
-	caps, err := c.Capability()
+	var folders []*models.Folder
+	err = s.retry(ctx, "list folders", func() error {
+		return s.imapPool.WithClient(ctx, userID, ServerFromSettings(settings), settings.IMAPUsername, imapPassword, func(clientIface IMAPClient) error {
+			wrapper, ok := clientIface.(*ClientWrapper)
+			if !ok || wrapper.client == nil {
+				return fmt.Errorf("failed to unwrap IMAP client")
+			}
+			folders, err = s.listFoldersWithOverrides(ctx, wrapper.client, userID)
+			return err
+		})
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to check server capabilities: %w", err)
+		return nil, err
 	}
+	return folders, nil
+}
 
-	// SPECIAL-USE is required for V-Mail to identify folder roles
-	if !caps["SPECIAL-USE"] {
-		return nil, fmt.Errorf("IMAP server does not support SPECIAL-USE extension (RFC 6154), which is required for V-Mail to identify folder types")
+// listFoldersWithOverrides lists the folders and applies the user's folder role overrides.
+func (s *Service) listFoldersWithOverrides(ctx context.Context, c *client.Client, userID string) ([]*models.Folder, error) {
+	folders, err := ListFolders(c)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides, err := db.GetFolderRoleOverrides(ctx, s.dbPool, userID)
+	if err != nil {
+		// Better to use the server's roles than to fail the whole operation
+		slog.WarnContext(ctx, "IMAP: Failed to get folder role overrides, using the server's roles", "error", err)
+	}
+	ApplyFolderRoleOverrides(folders, overrides)
+	return folders, nil
+}
+
+// ListFolders lists all folders on the IMAP server with their roles determined by SPECIAL-USE attributes (RFC 6154).
+// Roles that no folder has a SPECIAL-USE attribute for are guessed from common folder names,
+// so that servers without SPECIAL-USE work, too. See guessFolderRolesByName.
+func ListFolders(c *client.Client) ([]*models.Folder, error) {
+	if c == nil {
+		return nil, fmt.Errorf("client is nil")
 	}
 
 	mailboxes := make(chan *imap.MailboxInfo, 10)
@@ -35,18 +68,22 @@ func ListFolders(c *client.Client) ([]*models.Folder, error) {
 	}()
 
 	var folders []*models.Folder
+	delimiters := make(map[string]string)
 	for m := range mailboxes {
 		role := determineFolderRole(m.Name, m.Attributes)
 		folders = append(folders, &models.Folder{
 			Name: m.Name,
 			Role: role,
 		})
+		delimiters[m.Name] = m.Delimiter
 	}
 
 	if err := <-done; err != nil {
 		return nil, fmt.Errorf("failed to list folders: %w", err)
 	}
 
+	guessFolderRolesByName(folders, delimiters)
+
 	return folders, nil
 }
 
@@ -71,9 +108,129 @@ func determineFolderRole(name string, attributes []string) string {
 			return "trash"
 		case "\\Archive":
 			return "archive"
+		case "\\Flagged":
+			return "starred"
+		case "\\All":
+			return "all"
 		}
 	}
 
 	// Default to "other" if no special role is identified
 	return "other"
 }
+
+// ApplyFolderRoleOverrides sets the roles that the user set by hand, keyed by folder name.
+// Each role belongs to only one folder, so if the user gave a role to a folder,
+// other folders lose it, for example, when they prefer another folder to the one the server marks as \Junk.
+// INBOX is always identified by name, so it keeps its role.
+func ApplyFolderRoleOverrides(folders []*models.Folder, overrides map[string]string) {
+	if len(overrides) == 0 {
+		return
+	}
+
+	overriddenRoles := make(map[string]bool, len(overrides))
+	for _, role := range overrides {
+		overriddenRoles[role] = true
+	}
+
+	for _, folder := range folders {
+		if folder.Role == "inbox" {
+			continue
+		}
+		if role, exists := overrides[folder.Name]; exists {
+			folder.Role = role
+			folder.RoleOverridden = true
+		} else if folder.Role != "other" && overriddenRoles[folder.Role] {
+			folder.Role = "other"
+		}
+	}
+}
+
+// folderNamesByRole are common names of special folders, in the order we prefer them, lowercase.
+// They cover the defaults of popular servers and clients in English and some other common languages.
+var folderNamesByRole = []struct {
+	role  string
+	names []string
+}{
+	{"sent", []string{
+		"sent", "sent items", "sent mail", "sent messages",
+		"gesendet", "gesendete elemente", "gesendete objekte",
+		"envoyés", "éléments envoyés", "messages envoyés",
+		"enviados", "elementos enviados", "itens enviados",
+		"posta inviata", "inviati",
+		"verzonden", "verzonden items",
+		"skickat", "skickade objekt", "sendt", "sendte elementer", "lähetetyt",
+		"wysłane", "odeslané", "elküldött", "отправленные",
+	}},
+	{"drafts", []string{
+		"drafts", "draft",
+		"entwürfe", "brouillons", "borradores", "rascunhos", "bozze", "concepten",
+		"utkast", "kladder", "luonnokset", "kopie robocze", "koncepty", "piszkozatok", "черновики",
+	}},
+	{"spam", []string{
+		"spam", "junk", "junk e-mail", "junk email", "junk mail", "bulk mail",
+		"spamverdacht", "courrier indésirable", "indésirables", "correo no deseado", "lixo eletrônico",
+		"posta indesiderata", "ongewenste e-mail", "skräppost", "uønsket e-post", "roskaposti",
+		"levélszemét", "спам",
+	}},
+	{"trash", []string{
+		"trash", "deleted items", "deleted messages", "deleted", "bin",
+		"papierkorb", "gelöschte elemente", "gelöschte objekte",
+		"corbeille", "éléments supprimés", "papelera", "elementos eliminados", "lixeira", "itens excluídos",
+		"cestino", "posta eliminata", "prullenbak", "verwijderde items",
+		"papperskorgen", "borttagna objekt", "papirkurv", "slettede elementer", "roskakori",
+		"kosz", "koš", "kuka", "корзина",
+	}},
+	{"archive", []string{
+		"archive", "archives", "archiv", "archivo", "arquivo", "archivio", "archief", "arkiv", "arkisto",
+		"archiwum", "archívum", "архив",
+	}},
+}
+
+// guessFolderRolesByName gives roles to folders by their names, for roles that no folder has yet.
+// For example, on a server without SPECIAL-USE, "Sent Items" becomes the Sent folder.
+// It only looks at top-level folders and the children of INBOX, like "INBOX.Sent", since deeper folders with
+// these names are usually the user's own, like "Projects/Archive".
+// delimiters maps folder names to their hierarchy delimiters.
+func guessFolderRolesByName(folders []*models.Folder, delimiters map[string]string) {
+	takenRoles := make(map[string]bool)
+	candidates := make(map[string]*models.Folder)
+	for _, folder := range folders {
+		if folder.Role != "other" {
+			takenRoles[folder.Role] = true
+			continue
+		}
+		if name, ok := specialFolderCandidateName(folder.Name, delimiters[folder.Name]); ok {
+			if _, exists := candidates[name]; !exists {
+				candidates[name] = folder
+			}
+		}
+	}
+
+	for _, entry := range folderNamesByRole {
+		if takenRoles[entry.role] {
+			continue
+		}
+		for _, name := range entry.names {
+			if folder, exists := candidates[name]; exists && folder.Role == "other" {
+				folder.Role = entry.role
+				break
+			}
+		}
+	}
+}
+
+// specialFolderCandidateName returns the lowercase name of a folder without the INBOX prefix,
+// or false if the folder is too deep to be a special folder.
+func specialFolderCandidateName(name, delimiter string) (string, bool) {
+	if delimiter != "" {
+		parts := strings.Split(name, delimiter)
+		switch {
+		case len(parts) == 2 && strings.EqualFold(parts[0], "INBOX"):
+			name = parts[1]
+		case len(parts) > 1:
+			return "", false
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(name)), true
+}
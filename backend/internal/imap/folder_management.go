@@ -0,0 +1,234 @@
+package imap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+var (
+	// ErrFolderNotFound means that the server has no folder with the name.
+	ErrFolderNotFound = errors.New("folder not found")
+	// ErrFolderExists means that the server already has a folder with the name.
+	ErrFolderExists = errors.New("folder already exists")
+)
+
+// ListFolderSubscriptions lists all folders on the server (LIST), and tells which ones the user is subscribed to
+// (LSUB). Subscribed folders that don't exist anymore aren't listed.
+func (s *Service) ListFolderSubscriptions(ctx context.Context, userID string) ([]models.FolderSubscription, error) {
+	var folders []models.FolderSubscription
+	list := func(c *client.Client) error {
+		mailboxes, err := listMailboxes(c, "*", false)
+		if err != nil {
+			return err
+		}
+		subscribed, err := listMailboxes(c, "*", true)
+		if err != nil {
+			return err
+		}
+
+		subscribedNames := make(map[string]bool, len(subscribed))
+		for _, m := range subscribed {
+			subscribedNames[m.Name] = true
+		}
+		folders = make([]models.FolderSubscription, 0, len(mailboxes))
+		for _, m := range mailboxes {
+			folders = append(folders, folderSubscription(m, subscribedNames[m.Name]))
+		}
+		return nil
+	}
+	err := s.retry(ctx, "list folders", func() error {
+		return s.withClient(ctx, userID, list)
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(folders, func(a, b models.FolderSubscription) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return folders, nil
+}
+
+// SetFolderSubscribed subscribes the user to the folder, or unsubscribes them if subscribed is false.
+// Returns ErrFolderNotFound when subscribing to a folder that doesn't exist. Unsubscribing always works,
+// since subscriptions can outlive their folders.
+func (s *Service) SetFolderSubscribed(ctx context.Context, userID, folderName string, subscribed bool) error {
+	return s.withClient(ctx, userID, func(c *client.Client) error {
+		if !subscribed {
+			return c.Unsubscribe(folderName)
+		}
+		if _, err := findMailbox(c, folderName); err != nil {
+			return err
+		}
+		return c.Subscribe(folderName)
+	})
+}
+
+// CreateFolder creates a folder on the server and subscribes the user to it, so that other mail clients show it too.
+// Returns ErrFolderExists if there's already a folder with the name.
+func (s *Service) CreateFolder(ctx context.Context, userID, folderName string) (*models.FolderSubscription, error) {
+	var folder models.FolderSubscription
+	err := s.withClient(ctx, userID, func(c *client.Client) error {
+		if _, err := findMailbox(c, folderName); err == nil {
+			return ErrFolderExists
+		} else if !errors.Is(err, ErrFolderNotFound) {
+			return err
+		}
+
+		if err := c.Create(folderName); err != nil {
+			return fmt.Errorf("failed to create folder: %w", err)
+		}
+		if err := c.Subscribe(folderName); err != nil {
+			slog.WarnContext(ctx, "IMAP: Failed to subscribe to new folder", "folder", folderName, "error", err)
+		}
+
+		// The server can normalize the name, for example, by dropping a trailing delimiter
+		created, err := findMailbox(c, folderName)
+		if err != nil {
+			return err
+		}
+		folder = folderSubscription(created, true)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// RenameFolder renames a folder on the server. The server renames its subfolders too.
+// The subscription moves with the folder. Returns the renamed folder, whose Delimiter the cache needs to rename
+// the subfolders. Returns ErrFolderNotFound if there's no folder with the old name,
+// and ErrFolderExists if there's already one with the new name.
+func (s *Service) RenameFolder(ctx context.Context, userID, oldName, newName string) (*models.FolderSubscription, error) {
+	var folder models.FolderSubscription
+	err := s.withClient(ctx, userID, func(c *client.Client) error {
+		if _, err := findMailbox(c, oldName); err != nil {
+			return err
+		}
+		if _, err := findMailbox(c, newName); err == nil {
+			return ErrFolderExists
+		} else if !errors.Is(err, ErrFolderNotFound) {
+			return err
+		}
+		subscribed, err := listMailboxes(c, oldName, true)
+		if err != nil {
+			return err
+		}
+
+		if err := c.Rename(oldName, newName); err != nil {
+			return fmt.Errorf("failed to rename folder: %w", err)
+		}
+
+		// Subscriptions are by name, so most servers leave them behind
+		if len(subscribed) > 0 {
+			if err := c.Unsubscribe(oldName); err != nil {
+				slog.WarnContext(ctx, "IMAP: Failed to unsubscribe from old folder name", "folder", oldName, "error", err)
+			}
+			if err := c.Subscribe(newName); err != nil {
+				slog.WarnContext(ctx, "IMAP: Failed to subscribe to renamed folder", "folder", newName, "error", err)
+			}
+		}
+
+		renamed, err := findMailbox(c, newName)
+		if err != nil {
+			return err
+		}
+		folder = folderSubscription(renamed, len(subscribed) > 0)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// DeleteFolder deletes a folder and its messages on the server, and unsubscribes the user from it.
+// Its subfolders stay. Returns ErrFolderNotFound if there's no folder with the name.
+func (s *Service) DeleteFolder(ctx context.Context, userID, folderName string) error {
+	return s.withClient(ctx, userID, func(c *client.Client) error {
+		if _, err := findMailbox(c, folderName); err != nil {
+			return err
+		}
+		if err := c.Delete(folderName); err != nil {
+			return fmt.Errorf("failed to delete folder: %w", err)
+		}
+		if err := c.Unsubscribe(folderName); err != nil {
+			slog.WarnContext(ctx, "IMAP: Failed to unsubscribe from deleted folder", "folder", folderName, "error", err)
+		}
+		return nil
+	})
+}
+
+// withClient calls fn with an IMAP client of the user, without selecting a folder.
+func (s *Service) withClient(ctx context.Context, userID string, fn func(*client.Client) error) error {
+	settings, imapPassword, err := s.getSettingsAndPassword(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return s.imapPool.WithClient(ctx, userID, ServerFromSettings(settings), settings.IMAPUsername, imapPassword, func(clientIface IMAPClient) error {
+		wrapper, ok := clientIface.(*ClientWrapper)
+		if !ok || wrapper.client == nil {
+			return fmt.Errorf("failed to unwrap IMAP client")
+		}
+		return fn(wrapper.client)
+	})
+}
+
+// listMailboxes lists the folders whose names match pattern, with LIST, or with LSUB if subscribed is true.
+func listMailboxes(c *client.Client, pattern string, subscribed bool) ([]*imap.MailboxInfo, error) {
+	mailboxes := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		if subscribed {
+			done <- c.Lsub("", pattern, mailboxes)
+		} else {
+			done <- c.List("", pattern, mailboxes)
+		}
+	}()
+
+	var result []*imap.MailboxInfo
+	for m := range mailboxes {
+		result = append(result, m)
+	}
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("failed to list folders: %w", err)
+	}
+	return result, nil
+}
+
+// nonExistentAttr marks LIST responses for names that only exist as subscriptions or as parents (RFC 5258).
+const nonExistentAttr = "\\NonExistent"
+
+// findMailbox returns the folder with the name, or ErrFolderNotFound.
+// The name must not have the LIST wildcards * and %.
+func findMailbox(c *client.Client, name string) (*imap.MailboxInfo, error) {
+	mailboxes, err := listMailboxes(c, name, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mailboxes {
+		if !slices.Contains(m.Attributes, nonExistentAttr) {
+			return m, nil
+		}
+	}
+	return nil, ErrFolderNotFound
+}
+
+// folderSubscription converts a LIST response to a models.FolderSubscription.
+func folderSubscription(m *imap.MailboxInfo, subscribed bool) models.FolderSubscription {
+	return models.FolderSubscription{
+		Name:       m.Name,
+		Delimiter:  m.Delimiter,
+		Subscribed: subscribed,
+		Selectable: !slices.Contains(m.Attributes, imap.NoSelectAttr),
+	}
+}
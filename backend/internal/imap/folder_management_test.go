@@ -0,0 +1,78 @@
+package imap
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestFindMailbox(t *testing.T) {
+	server := testutil.NewTestIMAPServer(t)
+	defer server.Close()
+
+	server.EnsureINBOX(t)
+	client, cleanup := server.Connect(t)
+	defer cleanup()
+	if err := client.Create("Projects"); err != nil {
+		t.Fatalf("Failed to create folder: %v", err)
+	}
+
+	t.Run("finds an existing folder", func(t *testing.T) {
+		m, err := findMailbox(client, "Projects")
+		if err != nil {
+			t.Fatalf("Expected to find the folder: %v", err)
+		}
+		if folder := folderSubscription(m, false); folder.Name != "Projects" || !folder.Selectable {
+			t.Errorf("Expected a selectable folder named Projects, got %+v", folder)
+		}
+	})
+
+	t.Run("returns ErrFolderNotFound for a missing folder", func(t *testing.T) {
+		if _, err := findMailbox(client, "Missing"); !errors.Is(err, ErrFolderNotFound) {
+			t.Errorf("Expected ErrFolderNotFound, got %v", err)
+		}
+	})
+}
+
+func TestListMailboxes(t *testing.T) {
+	server := testutil.NewTestIMAPServer(t)
+	defer server.Close()
+
+	server.EnsureINBOX(t)
+	client, cleanup := server.Connect(t)
+	defer cleanup()
+	for _, name := range []string{"Projects", "Receipts"} {
+		if err := client.Create(name); err != nil {
+			t.Fatalf("Failed to create folder: %v", err)
+		}
+	}
+	if err := client.Subscribe("Projects"); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+
+	names := func(t *testing.T, subscribed bool) map[string]bool {
+		t.Helper()
+		mailboxes, err := listMailboxes(client, "*", subscribed)
+		if err != nil {
+			t.Fatalf("listMailboxes failed: %v", err)
+		}
+		result := make(map[string]bool)
+		for _, m := range mailboxes {
+			result[m.Name] = true
+		}
+		return result
+	}
+
+	t.Run("lists all folders", func(t *testing.T) {
+		if all := names(t, false); !all["INBOX"] || !all["Projects"] || !all["Receipts"] {
+			t.Errorf("Expected INBOX, Projects, and Receipts, got %v", all)
+		}
+	})
+
+	t.Run("lists only subscribed folders", func(t *testing.T) {
+		if subscribed := names(t, true); !subscribed["Projects"] || subscribed["Receipts"] {
+			t.Errorf("Expected Projects but not Receipts, got %v", subscribed)
+		}
+	})
+}
@@ -3,6 +3,7 @@ package imap
 import (
 	"testing"
 
+	"github.com/vdavid/vmail/backend/internal/models"
 	"github.com/vdavid/vmail/backend/internal/testutil"
 )
 
@@ -17,38 +18,41 @@ func TestListFolders(t *testing.T) {
 		}
 	})
 
-	t.Run("returns error for server without SPECIAL-USE support", func(t *testing.T) {
-		// Create a test IMAP server without SPECIAL-USE extension
+	t.Run("guesses roles by name for folders without SPECIAL-USE attributes", func(t *testing.T) {
 		server := testutil.NewTestIMAPServer(t)
 		defer server.Close()
+		server.EnsureINBOX(t)
 
 		client, cleanup := server.Connect(t)
 		defer cleanup()
 
-		// The test server doesn't enable SPECIAL-USE by default
-		// We need to check if it supports it - if not, we should get an error
-		caps, err := client.Capability()
+		// The test server only adds SPECIAL-USE attributes to folders with the default English names
+		for _, folder := range []string{"Sent Items", "Papierkorb", "INBOX/Junk E-mail", "Projects", "Projects/Archive"} {
+			if err := client.Create(folder); err != nil {
+				t.Fatalf("Failed to create %s: %v", folder, err)
+			}
+		}
+
+		folders, err := ListFolders(client)
 		if err != nil {
-			t.Fatalf("Failed to check capabilities: %v", err)
+			t.Fatalf("ListFolders failed: %v", err)
 		}
 
-		// If the server doesn't support SPECIAL-USE, ListFolders should return an error
-		if !caps["SPECIAL-USE"] {
-			_, err := ListFolders(client)
-			if err == nil {
-				t.Error("Expected error for server without SPECIAL-USE support")
-			}
-			if err.Error() == "" {
-				t.Error("Expected non-empty error message")
-			}
-		} else {
-			// Server supports SPECIAL-USE, so test should pass
-			folders, err := ListFolders(client)
-			if err != nil {
-				t.Fatalf("ListFolders should succeed when SPECIAL-USE is supported: %v", err)
-			}
-			if folders == nil {
-				t.Error("Expected folders slice, got nil")
+		roles := make(map[string]string)
+		for _, folder := range folders {
+			roles[folder.Name] = folder.Role
+		}
+		expected := map[string]string{
+			"INBOX":             "inbox",
+			"Sent Items":        "sent",
+			"Papierkorb":        "trash",
+			"INBOX/Junk E-mail": "spam",
+			"Projects":          "other",
+			"Projects/Archive":  "other",
+		}
+		for name, role := range expected {
+			if roles[name] != role {
+				t.Errorf("Expected %s to have role %s, got %q", name, role, roles[name])
 			}
 		}
 	})
@@ -121,3 +125,114 @@ func TestListFolders(t *testing.T) {
 		}
 	})
 }
+
+func TestGuessFolderRolesByName(t *testing.T) {
+	t.Run("keeps roles from SPECIAL-USE", func(t *testing.T) {
+		folders := []*models.Folder{
+			{Name: "Sent Items", Role: "sent"},
+			{Name: "Sent", Role: "other"},
+			{Name: "Deleted Items", Role: "other"},
+		}
+		guessFolderRolesByName(folders, map[string]string{})
+
+		if folders[0].Role != "sent" || folders[1].Role != "other" {
+			t.Errorf("Expected Sent Items to stay the only Sent folder, got %s and %s", folders[0].Role, folders[1].Role)
+		}
+		if folders[2].Role != "trash" {
+			t.Errorf("Expected Deleted Items to become the trash, got %s", folders[2].Role)
+		}
+	})
+
+	t.Run("prefers the more common name", func(t *testing.T) {
+		folders := []*models.Folder{
+			{Name: "Junk", Role: "other"},
+			{Name: "Spam", Role: "other"},
+		}
+		guessFolderRolesByName(folders, map[string]string{"Junk": ".", "Spam": "."})
+
+		if folders[0].Role != "other" || folders[1].Role != "spam" {
+			t.Errorf("Expected Spam to be the spam folder, got Junk=%s Spam=%s", folders[0].Role, folders[1].Role)
+		}
+	})
+
+	t.Run("matches names case-insensitively and under INBOX", func(t *testing.T) {
+		folders := []*models.Folder{
+			{Name: "INBOX.ÉLÉMENTS ENVOYÉS", Role: "other"},
+			{Name: "INBOX.Clients.Archive", Role: "other"},
+			{Name: "Work.Drafts", Role: "other"},
+		}
+		delimiters := map[string]string{}
+		for _, folder := range folders {
+			delimiters[folder.Name] = "."
+		}
+		guessFolderRolesByName(folders, delimiters)
+
+		if folders[0].Role != "sent" {
+			t.Errorf("Expected the French Sent folder to be found, got %s", folders[0].Role)
+		}
+		if folders[1].Role != "other" || folders[2].Role != "other" {
+			t.Errorf("Expected deeper folders to keep no role, got %s and %s", folders[1].Role, folders[2].Role)
+		}
+	})
+}
+
+func TestApplyFolderRoleOverrides(t *testing.T) {
+	newFolders := func() []*models.Folder {
+		return []*models.Folder{
+			{Name: "INBOX", Role: "inbox"},
+			{Name: "Junk E-mail", Role: "spam"},
+			{Name: "Spam", Role: "other"},
+			{Name: "Trash", Role: "trash"},
+			{Name: "Projects", Role: "other"},
+		}
+	}
+	roles := func(folders []*models.Folder) map[string]string {
+		result := make(map[string]string, len(folders))
+		for _, folder := range folders {
+			result[folder.Name] = folder.Role
+		}
+		return result
+	}
+
+	t.Run("keeps the server's roles without overrides", func(t *testing.T) {
+		folders := newFolders()
+		ApplyFolderRoleOverrides(folders, nil)
+		if got := roles(folders); got["Junk E-mail"] != "spam" || got["Trash"] != "trash" {
+			t.Errorf("Expected the server's roles, got %v", got)
+		}
+	})
+
+	t.Run("moves a role to the overridden folder", func(t *testing.T) {
+		folders := newFolders()
+		ApplyFolderRoleOverrides(folders, map[string]string{"Spam": "spam"})
+
+		got := roles(folders)
+		if got["Spam"] != "spam" || got["Junk E-mail"] != "other" {
+			t.Errorf("Expected Spam to be the only spam folder, got %v", got)
+		}
+		if got["Trash"] != "trash" || got["INBOX"] != "inbox" {
+			t.Errorf("Expected other roles to stay, got %v", got)
+		}
+		for _, folder := range folders {
+			if folder.RoleOverridden != (folder.Name == "Spam") {
+				t.Errorf("Expected only Spam to be overridden, got %s overridden=%v", folder.Name, folder.RoleOverridden)
+			}
+		}
+	})
+
+	t.Run("removes a role with other", func(t *testing.T) {
+		folders := newFolders()
+		ApplyFolderRoleOverrides(folders, map[string]string{"Trash": "other"})
+		if got := roles(folders); got["Trash"] != "other" {
+			t.Errorf("Expected Trash to have no role, got %v", got)
+		}
+	})
+
+	t.Run("doesn't change INBOX", func(t *testing.T) {
+		folders := newFolders()
+		ApplyFolderRoleOverrides(folders, map[string]string{"INBOX": "archive"})
+		if got := roles(folders); got["INBOX"] != "inbox" {
+			t.Errorf("Expected INBOX to stay the inbox, got %v", got)
+		}
+	})
+}
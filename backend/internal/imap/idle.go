@@ -2,22 +2,25 @@ package imap
 
 import (
 	"context"
-	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"time"
 
 	idle "github.com/emersion/go-imap-idle"
 	imapclient "github.com/emersion/go-imap/client"
+	"github.com/vdavid/vmail/backend/internal/logging"
 	"github.com/vdavid/vmail/backend/internal/websocket"
 )
 
 // idleListenerSleep is the backoff duration after an error before retrying IDLE.
 const idleListenerSleep = 10 * time.Second
 
-// StartIdleListener runs an IMAP IDLE loop for a user and pushes new email events to the Hub.
+// StartIdleListener runs an IMAP IDLE loop for a user while they have WebSocket connections to the hub.
+// The syncs it triggers publish events about new messages to the service's hub.
 // It listens on the INBOX folder only.
 // This function blocks until the context is canceled.
 func (s *Service) StartIdleListener(ctx context.Context, userID string, hub *websocket.Hub) {
+	ctx = logging.WithUserID(ctx, userID)
 	for {
 		// Exit when context is canceled.
 		select {
@@ -41,7 +44,7 @@ func (s *Service) StartIdleListener(ctx context.Context, userID string, hub *web
 		// Ensure we always unlock the listener.
 		func() {
 			defer listener.Unlock()
-			s.runIdleLoop(ctx, userID, listener.GetClient(), hub)
+			s.runIdleLoop(ctx, userID, listener.GetClient())
 		}()
 
 		// Small backoff before trying again.
@@ -53,13 +56,13 @@ func (s *Service) StartIdleListener(ctx context.Context, userID string, hub *web
 func (s *Service) getListenerConnection(ctx context.Context, userID string) (ListenerClient, error) {
 	settings, imapPassword, err := s.getSettingsAndPassword(ctx, userID)
 	if err != nil {
-		log.Printf("IMAP IDLE: failed to get settings for user %s: %v", userID, err)
+		slog.WarnContext(ctx, "IMAP IDLE: Failed to get settings", "error", err)
 		return nil, err
 	}
 
-	listener, err := s.imapPool.GetListenerConnection(userID, settings.IMAPServerHostname, settings.IMAPUsername, imapPassword)
+	listener, err := s.imapPool.GetListenerConnection(userID, ServerFromSettings(settings), settings.IMAPUsername, imapPassword)
 	if err != nil {
-		log.Printf("IMAP IDLE: failed to get listener connection for user %s: %v", userID, err)
+		slog.WarnContext(ctx, "IMAP IDLE: Failed to get listener connection", "error", err)
 		return nil, err
 	}
 
@@ -67,10 +70,10 @@ func (s *Service) getListenerConnection(ctx context.Context, userID string) (Lis
 }
 
 // runIdleLoop runs the IDLE command and handles mailbox updates.
-func (s *Service) runIdleLoop(ctx context.Context, userID string, client *imapclient.Client, hub *websocket.Hub) {
+func (s *Service) runIdleLoop(ctx context.Context, userID string, client *imapclient.Client) {
 	// Select INBOX for IDLE.
 	if _, err := client.Select("INBOX", false); err != nil {
-		log.Printf("IMAP IDLE: failed to select INBOX for user %s: %v", userID, err)
+		slog.WarnContext(ctx, "IMAP IDLE: Failed to select INBOX", "error", err)
 		s.imapPool.RemoveListenerConnection(userID)
 		return
 	}
@@ -78,8 +81,10 @@ func (s *Service) runIdleLoop(ctx context.Context, userID string, client *imapcl
 	idleClient := idle.NewClient(client)
 
 	// Create a channel to receive mailbox updates.
+	// Nothing reads it after we return, so we detach it then, or the client would block on the next update.
 	updates := make(chan imapclient.Update, 10)
 	client.Updates = updates
+	defer func() { client.Updates = nil }()
 
 	// Start IDLE in a goroutine so we can listen for updates.
 	stop := make(chan struct{})
@@ -91,12 +96,15 @@ func (s *Service) runIdleLoop(ctx context.Context, userID string, client *imapcl
 	for {
 		select {
 		case <-ctx.Done():
-			// Stop idling and return.
+			// Stop idling, and wait for IDLE to end, so the caller doesn't unlock the connection in the middle of it.
 			close(stop)
+			if err := <-done; err != nil {
+				s.imapPool.RemoveListenerConnection(userID)
+			}
 			return
 		case err := <-done:
 			if err != nil {
-				log.Printf("IMAP IDLE: idle loop ended with error for user %s: %v", userID, err)
+				slog.WarnContext(ctx, "IMAP IDLE: Idle loop ended with an error", "error", err)
 				s.imapPool.RemoveListenerConnection(userID)
 			}
 			return
@@ -104,13 +112,14 @@ func (s *Service) runIdleLoop(ctx context.Context, userID string, client *imapcl
 			if update == nil {
 				continue
 			}
-			s.handleMailboxUpdate(ctx, userID, update, hub)
+			s.handleMailboxUpdate(ctx, userID, update)
 		}
 	}
 }
 
-// handleMailboxUpdate processes a mailbox update and syncs/notifies if needed.
-func (s *Service) handleMailboxUpdate(ctx context.Context, userID string, update imapclient.Update, hub *websocket.Hub) {
+// handleMailboxUpdate syncs INBOX if the update says it has messages.
+// The sync publishes a new_message event if it cached new messages.
+func (s *Service) handleMailboxUpdate(ctx context.Context, userID string, update imapclient.Update) {
 	// MailboxUpdate updates can indicate new messages.
 	mboxUpdate, ok := update.(*imapclient.MailboxUpdate)
 	if !ok || mboxUpdate.Mailbox == nil {
@@ -123,28 +132,9 @@ func (s *Service) handleMailboxUpdate(ctx context.Context, userID string, update
 	}
 
 	// Perform incremental sync for INBOX immediately.
-	if err := s.SyncThreadsForFolder(ctx, userID, "INBOX"); err != nil {
-		log.Printf("IMAP IDLE: failed to sync INBOX for user %s: %v", userID, err)
-		return
-	}
-
-	// Notify frontend via WebSocket.
-	s.sendNewEmailNotification(userID, hub)
-}
-
-// sendNewEmailNotification sends a WebSocket notification about new email.
-func (s *Service) sendNewEmailNotification(userID string, hub *websocket.Hub) {
-	msg := struct {
-		Type   string `json:"type"`
-		Folder string `json:"folder"`
-	}{
-		Type:   "new_email",
-		Folder: "INBOX",
-	}
-	payload, err := json.Marshal(msg)
-	if err != nil {
-		log.Printf("IMAP IDLE: failed to marshal new_email message: %v", err)
-		return
+	// Messages from blocked senders aren't cached, so they don't count as new.
+	_, err := s.syncThreadsForFolder(ctx, userID, "INBOX")
+	if err != nil && !errors.Is(err, ErrFolderSyncDisabled) && !errors.Is(err, ErrSyncThrottled) {
+		slog.WarnContext(ctx, "IMAP IDLE: Failed to sync INBOX", "error", err)
 	}
-	hub.Send(userID, payload)
 }
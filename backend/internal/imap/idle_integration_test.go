@@ -41,7 +41,7 @@ func TestSyncThreadsForFolder_DetectsNewEmail(t *testing.T) {
 	server.EnsureINBOX(t)
 
 	encryptor := getTestEncryptor(t)
-	service := NewService(pool, NewPool(), encryptor)
+	service := NewService(pool, NewPool(), encryptor, nil)
 	defer service.Close()
 
 	ctx := context.Background()
@@ -215,7 +215,7 @@ func TestSyncThreadsForFolder_IncrementalSync(t *testing.T) {
 	server.EnsureINBOX(t)
 
 	encryptor := getTestEncryptor(t)
-	service := NewService(pool, NewPool(), encryptor)
+	service := NewService(pool, NewPool(), encryptor, nil)
 	defer service.Close()
 
 	userID, err := db.GetOrCreateUser(ctx, pool, "incremental-sync-test@example.com")
@@ -325,7 +325,7 @@ func TestSyncThreadsForFolder_CatchesUpOnMissedEmails(t *testing.T) {
 	server.EnsureINBOX(t)
 
 	encryptor := getTestEncryptor(t)
-	service := NewService(pool, NewPool(), encryptor)
+	service := NewService(pool, NewPool(), encryptor, nil)
 	defer service.Close()
 
 	ctx := context.Background()
@@ -0,0 +1,171 @@
+package imap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	imapclient "github.com/emersion/go-imap/client"
+)
+
+// maxLabelLength is the longest label we accept. Servers have limits too, for example, Dovecot allows 200 bytes.
+const maxLabelLength = 64
+
+// nonLabelKeywords are the registered IMAP keywords that mean something to mail clients, so they aren't labels.
+// They're lowercase. See https://www.iana.org/assignments/imap-jmap-keywords.
+var nonLabelKeywords = map[string]bool{
+	"$forwarded":     true,
+	"$mdnsent":       true,
+	"$submitpending": true,
+	"$submitted":     true,
+	"$junk":          true,
+	"$notjunk":       true,
+	"$phishing":      true,
+	"junk":           true,
+	"nonjunk":        true,
+}
+
+// flagsToLabels returns the custom keywords among the flags of a message, like "$label1" or "work", in canonical form.
+// System flags like \Seen and the keywords in nonLabelKeywords aren't labels.
+func flagsToLabels(flags []string) []string {
+	var labels []string
+	for _, flag := range flags {
+		if strings.HasPrefix(flag, "\\") || nonLabelKeywords[strings.ToLower(flag)] {
+			continue
+		}
+		labels = append(labels, CanonicalLabel(flag))
+	}
+	return labels
+}
+
+// CanonicalLabel returns the lowercase form of a label. Keywords are case-insensitive, and go-imap lowercases the
+// ones it fetches, so we keep labels lowercase everywhere to avoid duplicates.
+func CanonicalLabel(label string) string {
+	return imap.CanonicalFlag(label)
+}
+
+// IsValidLabel returns true if the label can be an IMAP keyword: an atom of printable ASCII characters without
+// spaces or the special characters of IMAP, and not a system flag or a keyword that isn't a label.
+func IsValidLabel(label string) bool {
+	if label == "" || len(label) > maxLabelLength || nonLabelKeywords[strings.ToLower(label)] {
+		return false
+	}
+	for _, r := range label {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`(){%*"\]`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// StoreLabel adds the label to the messages on the server, or removes it from them if add is false.
+// Returns whether the server keeps the label of each message, in the order of messages. It's false for the messages
+// in folders that don't allow new keywords, that is, without \* or the label in their PERMANENTFLAGS.
+// Callers should keep the label in the cache only for those.
+func (s *Service) StoreLabel(ctx context.Context, userID string, messages []MessageToSync, label string, add bool) ([]bool, error) {
+	settings, imapPassword, err := s.getSettingsAndPassword(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := make([]bool, len(messages))
+	err = s.imapPool.WithClient(ctx, userID, ServerFromSettings(settings), settings.IMAPUsername, imapPassword, func(clientIface IMAPClient) error {
+		wrapper, ok := clientIface.(*ClientWrapper)
+		if !ok || wrapper.client == nil {
+			return fmt.Errorf("failed to unwrap IMAP client")
+		}
+		return storeLabelByFolder(wrapper.client, messages, label, add, stored)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// The keywords that mark messages as spam or not spam, for spam filters that learn from them, like Rspamd with
+// Dovecot's IMAPSieve. See https://www.iana.org/assignments/imap-jmap-keywords.
+const (
+	junkKeyword    = "$Junk"
+	notJunkKeyword = "$NotJunk"
+)
+
+// MarkJunk adds the $Junk keyword to the messages on the server and removes $NotJunk, or the other way around if
+// junk is false. Folders that don't allow the keywords are skipped.
+func (s *Service) MarkJunk(ctx context.Context, userID string, messages []MessageToSync, junk bool) error {
+	settings, imapPassword, err := s.getSettingsAndPassword(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return s.imapPool.WithClient(ctx, userID, ServerFromSettings(settings), settings.IMAPUsername, imapPassword, func(clientIface IMAPClient) error {
+		wrapper, ok := clientIface.(*ClientWrapper)
+		if !ok || wrapper.client == nil {
+			return fmt.Errorf("failed to unwrap IMAP client")
+		}
+		return storeJunkKeywords(wrapper.client, messages, junk)
+	})
+}
+
+// storeJunkKeywords stores the keywords of MarkJunk.
+func storeJunkKeywords(client *imapclient.Client, messages []MessageToSync, junk bool) error {
+	add, remove := junkKeyword, notJunkKeyword
+	if !junk {
+		add, remove = notJunkKeyword, junkKeyword
+	}
+	stored := make([]bool, len(messages))
+	if err := storeLabelByFolder(client, messages, remove, false, stored); err != nil {
+		return err
+	}
+	return storeLabelByFolder(client, messages, add, true, stored)
+}
+
+// storeLabelByFolder stores the label with one command per folder, and sets stored for the messages in the folders
+// that allow the label.
+func storeLabelByFolder(client *imapclient.Client, messages []MessageToSync, label string, add bool, stored []bool) error {
+	indexesByFolder := make(map[string][]int)
+	var folders []string
+	for i, msg := range messages {
+		if _, exists := indexesByFolder[msg.FolderName]; !exists {
+			folders = append(folders, msg.FolderName)
+		}
+		indexesByFolder[msg.FolderName] = append(indexesByFolder[msg.FolderName], i)
+	}
+
+	var operation imap.FlagsOp = imap.AddFlags
+	if !add {
+		operation = imap.RemoveFlags
+	}
+
+	for _, folder := range folders {
+		mbox, err := client.Select(folder, false)
+		if err != nil {
+			return fmt.Errorf("failed to select folder %s: %w", folder, err)
+		}
+		if !allowsKeyword(mbox.PermanentFlags, label) {
+			continue
+		}
+
+		seqSet := new(imap.SeqSet)
+		for _, i := range indexesByFolder[folder] {
+			seqSet.AddNum(uint32(messages[i].IMAPUID))
+		}
+		if err := client.UidStore(seqSet, imap.FormatFlagsOp(operation, true), []interface{}{label}, nil); err != nil {
+			return fmt.Errorf("failed to store label in %s: %w", folder, err)
+		}
+		for _, i := range indexesByFolder[folder] {
+			stored[i] = true
+		}
+	}
+	return nil
+}
+
+// allowsKeyword returns true if a folder with these PERMANENTFLAGS keeps the keyword on its messages.
+func allowsKeyword(permanentFlags []string, keyword string) bool {
+	for _, flag := range permanentFlags {
+		if flag == imap.TryCreateFlag || strings.EqualFold(flag, keyword) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package imap
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestFlagsToLabels(t *testing.T) {
+	labels := flagsToLabels([]string{imap.SeenFlag, "$Label1", imap.FlaggedFlag, "$Forwarded", "Work", "$NotJunk"})
+	if !slices.Equal(labels, []string{"$label1", "work"}) {
+		t.Errorf("Expected [$label1 work], got %v", labels)
+	}
+
+	if labels := flagsToLabels([]string{imap.SeenFlag}); labels != nil {
+		t.Errorf("Expected no labels, got %v", labels)
+	}
+}
+
+func TestIsValidLabel(t *testing.T) {
+	for _, label := range []string{"$Label1", "Work", "project/alpha", "a"} {
+		if !IsValidLabel(label) {
+			t.Errorf("Expected %q to be valid", label)
+		}
+	}
+	for _, label := range []string{"", "\\Seen", "two words", "$Forwarded", "junk", "quo\"te", "star*", "per%cent", "naïve", string(make([]byte, 65))} {
+		if IsValidLabel(label) {
+			t.Errorf("Expected %q to be invalid", label)
+		}
+	}
+}
+
+func TestAllowsKeyword(t *testing.T) {
+	if !allowsKeyword([]string{imap.SeenFlag, imap.TryCreateFlag}, "$Label1") {
+		t.Error("Expected \\* to allow any keyword")
+	}
+	if !allowsKeyword([]string{imap.SeenFlag, "$label1"}, "$Label1") {
+		t.Error("Expected a listed keyword to be allowed regardless of case")
+	}
+	if allowsKeyword([]string{imap.SeenFlag, imap.FlaggedFlag}, "$Label1") {
+		t.Error("Expected a keyword that isn't listed to be refused")
+	}
+}
+
+func TestStoreLabelByFolder(t *testing.T) {
+	server := testutil.NewTestIMAPServer(t)
+	defer server.Close()
+	server.EnsureINBOX(t)
+
+	client, cleanup := server.Connect(t)
+	defer cleanup()
+	if err := client.Create("Archive"); err != nil {
+		t.Fatalf("Failed to create Archive: %v", err)
+	}
+
+	now := time.Now()
+	messages := []MessageToSync{
+		{FolderName: "INBOX", IMAPUID: int64(server.AddMessage(t, "INBOX", "<inbox@example.com>", "Hello", "alice@example.com", "me@example.com", now))},
+		{FolderName: "Archive", IMAPUID: int64(server.AddMessage(t, "Archive", "<archived@example.com>", "Old", "bob@example.com", "me@example.com", now))},
+	}
+
+	// getLabels returns the labels of the message on the server
+	getLabels := func(t *testing.T, msg MessageToSync) []string {
+		t.Helper()
+		if _, err := client.Select(msg.FolderName, true); err != nil {
+			t.Fatalf("Failed to select %s: %v", msg.FolderName, err)
+		}
+		seqSet := new(imap.SeqSet)
+		seqSet.AddNum(uint32(msg.IMAPUID))
+		fetched := make(chan *imap.Message, 1)
+		if err := client.UidFetch(seqSet, []imap.FetchItem{imap.FetchFlags}, fetched); err != nil {
+			t.Fatalf("Failed to fetch flags: %v", err)
+		}
+		fetchedMsg := <-fetched
+		if fetchedMsg == nil {
+			t.Fatalf("Message %d not found in %s", msg.IMAPUID, msg.FolderName)
+		}
+		return flagsToLabels(fetchedMsg.Flags)
+	}
+
+	t.Run("adds the label in each folder", func(t *testing.T) {
+		stored := make([]bool, len(messages))
+		if err := storeLabelByFolder(client, messages, "$Label1", true, stored); err != nil {
+			t.Fatalf("storeLabelByFolder failed: %v", err)
+		}
+		if !stored[0] || !stored[1] {
+			t.Errorf("Expected the label to be stored for both messages, got %v", stored)
+		}
+		for _, msg := range messages {
+			if labels := getLabels(t, msg); !slices.Equal(labels, []string{"$label1"}) {
+				t.Errorf("Expected [$label1] in %s, got %v", msg.FolderName, labels)
+			}
+		}
+	})
+
+	t.Run("removes the label", func(t *testing.T) {
+		stored := make([]bool, len(messages))
+		if err := storeLabelByFolder(client, messages, "$Label1", false, stored); err != nil {
+			t.Fatalf("storeLabelByFolder failed: %v", err)
+		}
+		for _, msg := range messages {
+			if labels := getLabels(t, msg); len(labels) != 0 {
+				t.Errorf("Expected no labels in %s, got %v", msg.FolderName, labels)
+			}
+		}
+	})
+}
+
+func TestStoreJunkKeywords(t *testing.T) {
+	server := testutil.NewTestIMAPServer(t)
+	defer server.Close()
+	server.EnsureINBOX(t)
+
+	client, cleanup := server.Connect(t)
+	defer cleanup()
+
+	msg := MessageToSync{FolderName: "INBOX", IMAPUID: int64(server.AddMessage(t, "INBOX", "<spam@example.com>", "Win", "spammer@example.com", "me@example.com", time.Now()))}
+
+	// getFlags returns the lowercase flags of the message on the server
+	getFlags := func(t *testing.T) []string {
+		t.Helper()
+		if _, err := client.Select(msg.FolderName, true); err != nil {
+			t.Fatalf("Failed to select %s: %v", msg.FolderName, err)
+		}
+		seqSet := new(imap.SeqSet)
+		seqSet.AddNum(uint32(msg.IMAPUID))
+		fetched := make(chan *imap.Message, 1)
+		if err := client.UidFetch(seqSet, []imap.FetchItem{imap.FetchFlags}, fetched); err != nil {
+			t.Fatalf("Failed to fetch flags: %v", err)
+		}
+		fetchedMsg := <-fetched
+		if fetchedMsg == nil {
+			t.Fatalf("Message %d not found", msg.IMAPUID)
+		}
+		var flags []string
+		for _, flag := range fetchedMsg.Flags {
+			flags = append(flags, strings.ToLower(flag))
+		}
+		return flags
+	}
+
+	t.Run("marks as junk", func(t *testing.T) {
+		if err := storeJunkKeywords(client, []MessageToSync{msg}, true); err != nil {
+			t.Fatalf("storeJunkKeywords failed: %v", err)
+		}
+		if flags := getFlags(t); !slices.Contains(flags, "$junk") || slices.Contains(flags, "$notjunk") {
+			t.Errorf("Expected $junk without $notjunk, got %v", flags)
+		}
+	})
+
+	t.Run("marks as not junk", func(t *testing.T) {
+		if err := storeJunkKeywords(client, []MessageToSync{msg}, false); err != nil {
+			t.Fatalf("storeJunkKeywords failed: %v", err)
+		}
+		if flags := getFlags(t); !slices.Contains(flags, "$notjunk") || slices.Contains(flags, "$junk") {
+			t.Errorf("Expected $notjunk without $junk, got %v", flags)
+		}
+	})
+}
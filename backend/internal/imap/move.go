@@ -0,0 +1,124 @@
+package imap
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/emersion/go-imap"
+	imapclient "github.com/emersion/go-imap/client"
+)
+
+// MessageToMove represents a message to move to another folder.
+type MessageToMove struct {
+	FolderName string
+	IMAPUID    int64
+	// MessageIDHeader finds the message in the destination folder after the move.
+	MessageIDHeader string
+}
+
+// MoveDestination is the folder that MoveMessages moves messages to.
+// It's either a folder by name, or the folder with a role, for example, "archive".
+// Roles come from SPECIAL-USE, common folder names, or the user's folder role overrides.
+type MoveDestination struct {
+	FolderName string
+	// Role is used if FolderName is empty. FallbackFolderName is used if no folder has the role.
+	Role               string
+	FallbackFolderName string
+}
+
+// ArchiveDestination is the folder with the \Archive SPECIAL-USE attribute, or "Archive" if there's none.
+var ArchiveDestination = MoveDestination{Role: "archive", FallbackFolderName: "Archive"}
+
+// TrashDestination is the folder with the \Trash SPECIAL-USE attribute, or "Trash" if there's none.
+var TrashDestination = MoveDestination{Role: "trash", FallbackFolderName: "Trash"}
+
+// SpamDestination is the folder with the \Junk SPECIAL-USE attribute, or "Junk" if there's none.
+var SpamDestination = MoveDestination{Role: "spam", FallbackFolderName: "Junk"}
+
+// MoveMessages moves messages to the destination folder with UID MOVE, or with COPY, STORE, and EXPUNGE
+// if the server doesn't support MOVE.
+// Returns the name of the destination folder, and the new UIDs of the messages, in the order of messages.
+// A new UID is 0 if we couldn't find the message in the destination folder after the move.
+func (s *Service) MoveMessages(ctx context.Context, userID string, messages []MessageToMove, destination MoveDestination) (string, []int64, error) {
+	settings, imapPassword, err := s.getSettingsAndPassword(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var folderName string
+	newUIDs := make([]int64, len(messages))
+	err = s.imapPool.WithClient(ctx, userID, ServerFromSettings(settings), settings.IMAPUsername, imapPassword, func(clientIface IMAPClient) error {
+		wrapper, ok := clientIface.(*ClientWrapper)
+		if !ok || wrapper.client == nil {
+			return fmt.Errorf("failed to unwrap IMAP client")
+		}
+		client := wrapper.client
+
+		folderName = destination.FolderName
+		if folderName == "" {
+			folderName = s.findFolderByRole(ctx, client, userID, destination.Role, destination.FallbackFolderName)
+		}
+
+		if err := moveUIDsByFolder(client, messages, folderName, newUIDs); err != nil {
+			return err
+		}
+		return findMovedUIDs(client, messages, folderName, newUIDs)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return folderName, newUIDs, nil
+}
+
+// moveUIDsByFolder moves the messages with one command per source folder.
+// Messages that are already in the destination keep their UIDs.
+func moveUIDsByFolder(client *imapclient.Client, messages []MessageToMove, destination string, newUIDs []int64) error {
+	uidsByFolder := make(map[string][]uint32)
+	var folders []string
+	for i, msg := range messages {
+		if msg.FolderName == destination {
+			newUIDs[i] = msg.IMAPUID
+			continue
+		}
+		if _, exists := uidsByFolder[msg.FolderName]; !exists {
+			folders = append(folders, msg.FolderName)
+		}
+		uidsByFolder[msg.FolderName] = append(uidsByFolder[msg.FolderName], uint32(msg.IMAPUID))
+	}
+
+	for _, folder := range folders {
+		if _, err := client.Select(folder, false); err != nil {
+			return fmt.Errorf("failed to select folder %s: %w", folder, err)
+		}
+		seqSet := new(imap.SeqSet)
+		seqSet.AddNum(uidsByFolder[folder]...)
+		if err := client.UidMove(seqSet, destination); err != nil {
+			return fmt.Errorf("failed to move messages from %s to %s: %w", folder, destination, err)
+		}
+	}
+	return nil
+}
+
+// findMovedUIDs finds the new UIDs of the moved messages in the destination folder by their Message-ID.
+// go-imap doesn't give us the COPYUID response of UIDPLUS servers, and not all servers support it anyway.
+// If there are more copies, we use the newest one.
+func findMovedUIDs(client *imapclient.Client, messages []MessageToMove, destination string, newUIDs []int64) error {
+	if _, err := client.Select(destination, true); err != nil {
+		return fmt.Errorf("failed to select folder %s: %w", destination, err)
+	}
+
+	for i, msg := range messages {
+		if newUIDs[i] != 0 || msg.MessageIDHeader == "" {
+			continue
+		}
+		uids, err := searchUIDsByMessageID(client, msg.MessageIDHeader)
+		if err != nil {
+			return err
+		}
+		if len(uids) > 0 {
+			newUIDs[i] = int64(slices.Max(uids))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package imap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestMoveUIDsByFolder(t *testing.T) {
+	server := testutil.NewTestIMAPServer(t)
+	defer server.Close()
+	server.EnsureINBOX(t)
+
+	client, cleanup := server.Connect(t)
+	defer cleanup()
+	for _, folder := range []string{"Sent", "Archive"} {
+		if err := client.Create(folder); err != nil {
+			t.Fatalf("Failed to create %s: %v", folder, err)
+		}
+	}
+
+	now := time.Now()
+	inboxUID := server.AddMessage(t, "INBOX", "<inbox@example.com>", "Hello", "alice@example.com", "me@example.com", now)
+	sentUID := server.AddMessage(t, "Sent", "<sent@example.com>", "Re: Hello", "me@example.com", "alice@example.com", now)
+	archivedUID := server.AddMessage(t, "Archive", "<archived@example.com>", "Old", "bob@example.com", "me@example.com", now)
+
+	messages := []MessageToMove{
+		{FolderName: "INBOX", IMAPUID: int64(inboxUID), MessageIDHeader: "<inbox@example.com>"},
+		{FolderName: "Sent", IMAPUID: int64(sentUID), MessageIDHeader: "<sent@example.com>"},
+		{FolderName: "Archive", IMAPUID: int64(archivedUID), MessageIDHeader: "<archived@example.com>"},
+	}
+	newUIDs := make([]int64, len(messages))
+
+	if err := moveUIDsByFolder(client, messages, "Archive", newUIDs); err != nil {
+		t.Fatalf("moveUIDsByFolder failed: %v", err)
+	}
+	if err := findMovedUIDs(client, messages, "Archive", newUIDs); err != nil {
+		t.Fatalf("findMovedUIDs failed: %v", err)
+	}
+
+	if newUIDs[2] != int64(archivedUID) {
+		t.Errorf("Expected the archived message to keep UID %d, got %d", archivedUID, newUIDs[2])
+	}
+	if newUIDs[0] == 0 || newUIDs[1] == 0 || newUIDs[0] == newUIDs[1] {
+		t.Errorf("Expected new, distinct UIDs for the moved messages, got %v", newUIDs)
+	}
+
+	// The moved messages are gone from their old folders
+	for i, msg := range messages[:2] {
+		if _, err := client.Select(msg.FolderName, true); err != nil {
+			t.Fatalf("Failed to select %s: %v", msg.FolderName, err)
+		}
+		uids, err := searchUIDsByMessageID(client, msg.MessageIDHeader)
+		if err != nil {
+			t.Fatalf("searchUIDsByMessageID failed: %v", err)
+		}
+		if len(uids) != 0 {
+			t.Errorf("Expected message %d to be gone from %s, got UIDs %v", i, msg.FolderName, uids)
+		}
+	}
+}
@@ -1,41 +1,46 @@
 package imap
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/emersion/go-imap"
 	"github.com/jhillyerd/enmime"
+	"github.com/vdavid/vmail/backend/internal/linkcheck"
 	"github.com/vdavid/vmail/backend/internal/models"
 )
 
 // ParseMessage converts an IMAP message to our Message model.
 // Extracts headers, flags, and body (if available). Body parsing errors are logged but don't fail the parse.
+// If the body has an iCalendar invite, its event is parsed into CalendarEvent. The links of the body are analyzed
+// into LinkAnalysis, and the Authentication-Results header into Authenticity.
+// If the body is only the start of the message, as FetchFullMessage fetches it for big messages,
+// the message is marked as truncated.
 func ParseMessage(imapMsg *imap.Message, threadID, userID, folderName string) (*models.Message, error) {
+	return ParseMessageWithPartLimit(imapMsg, threadID, userID, folderName, 0)
+}
+
+// ParseMessageWithPartLimit is ParseMessage, but it cuts text and HTML bodies longer than maxPartBytes,
+// and marks the message as truncated if it does. A maxPartBytes of 0 or less means no limit.
+func ParseMessageWithPartLimit(imapMsg *imap.Message, threadID, userID, folderName string, maxPartBytes int) (*models.Message, error) {
 	if imapMsg == nil {
 		return nil, fmt.Errorf("imap message is nil")
 	}
 
-	// Check flags
-	isRead := false
-	isStarred := false
-	for _, flag := range imapMsg.Flags {
-		if flag == imap.SeenFlag {
-			isRead = true
-		}
-		if flag == imap.FlaggedFlag {
-			isStarred = true
-		}
-	}
+	isRead, isStarred := flagsToReadAndStarred(imapMsg.Flags)
 
 	msg := &models.Message{
 		ThreadID:       threadID,
 		UserID:         userID,
 		IMAPUID:        int64(imapMsg.Uid),
 		IMAPFolderName: folderName,
+		SizeBytes:      int64(imapMsg.Size),
 		IsRead:         isRead,
 		IsStarred:      isStarred,
+		Labels:         flagsToLabels(imapMsg.Flags),
 	}
 
 	if imapMsg.Envelope != nil {
@@ -54,13 +59,18 @@ func ParseMessage(imapMsg *imap.Message, threadID, userID, folderName string) (*
 	if imapMsg.Envelope != nil && len(imapMsg.Envelope.MessageId) > 0 {
 		msg.MessageIDHeader = imapMsg.Envelope.MessageId
 	}
+	msg.ReferencedMessageIDs = messageReferences(imapMsg)
 
 	// Parse body if available
 	if imapMsg.Body != nil && imapMsg.BodyStructure != nil {
-		section := &imap.BodySectionName{}
-		bodyReader := imapMsg.GetBody(section)
+		bodyReader := imapMsg.GetBody(&imap.BodySectionName{})
+		if bodyReader == nil {
+			// A partial fetch has the body under BODY[]<0>, whatever its length
+			bodyReader = imapMsg.GetBody(&imap.BodySectionName{Partial: []int{0}})
+			msg.Truncated = bodyReader != nil
+		}
 		if bodyReader != nil {
-			if err := parseBody(bodyReader, msg); err != nil {
+			if err := parseBody(bodyReader, msg, maxPartBytes); err != nil {
 				// Log error but don't fail - we still have headers
 				_ = err
 			}
@@ -70,8 +80,9 @@ func ParseMessage(imapMsg *imap.Message, threadID, userID, folderName string) (*
 	return msg, nil
 }
 
-// parseBody parses the email body using enmime.
-func parseBody(bodyReader io.Reader, msg *models.Message) error {
+// parseBody parses the email body using enmime. It cuts text and HTML bodies longer than maxPartBytes,
+// unless it's 0 or less.
+func parseBody(bodyReader io.Reader, msg *models.Message, maxPartBytes int) error {
 	envelope, err := enmime.ReadEnvelope(bodyReader)
 	if err != nil {
 		return fmt.Errorf("failed to parse email body: %w", err)
@@ -85,6 +96,16 @@ func parseBody(bodyReader io.Reader, msg *models.Message) error {
 	}
 	msg.UnsafeBodyHTML = htmlBody
 	msg.BodyText = envelope.Text
+	// Full fetches don't have the references section, but the whole header has References
+	if references := parseReferences(envelope.GetHeader("References"), envelope.GetHeader("In-Reply-To")); len(references) > len(msg.ReferencedMessageIDs) {
+		msg.ReferencedMessageIDs = references
+	}
+	msg.Snippet = ExtractSnippet(snippetText(envelope), envelope.HTML)
+	if maxPartBytes > 0 && (len(msg.UnsafeBodyHTML) > maxPartBytes || len(msg.BodyText) > maxPartBytes) {
+		msg.UnsafeBodyHTML = cutUTF8(msg.UnsafeBodyHTML, maxPartBytes)
+		msg.BodyText = cutUTF8(msg.BodyText, maxPartBytes)
+		msg.Truncated = true
+	}
 
 	// Parse attachments
 	for _, part := range envelope.Attachments {
@@ -103,9 +124,78 @@ func parseBody(bodyReader io.Reader, msg *models.Message) error {
 		msg.Attachments = append(msg.Attachments, *attachment)
 	}
 
+	// Invites that don't parse are still shown as a message, just without the RSVP buttons
+	if part := findCalendarPart(envelope); part != nil {
+		if event, err := parseCalendarEvent(part.Content); err == nil {
+			msg.CalendarEvent = event
+		}
+	}
+
+	msg.LinkAnalysis = linkcheck.Analyze(msg.UnsafeBodyHTML, msg.BodyText)
+	msg.AuthenticationResults = envelope.GetHeaderValues("Authentication-Results")
+
 	return nil
 }
 
+// InlinePart is a part of a message that the HTML body shows by its Content-ID, usually an image.
+type InlinePart struct {
+	ContentType string
+	Filename    string
+	Content     []byte
+}
+
+// ErrInlinePartNotFound is returned when a message has no part with the Content-ID.
+var ErrInlinePartNotFound = errors.New("inline part not found")
+
+// FindInlinePart parses the source of a message, and returns its part with the Content-ID, without angle brackets,
+// like the HTML body's "cid:" URLs have it. Returns ErrInlinePartNotFound if there's none.
+func FindInlinePart(source io.Reader, contentID string) (*InlinePart, error) {
+	envelope, err := enmime.ReadEnvelope(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message: %w", err)
+	}
+	if envelope.Root == nil {
+		return nil, ErrInlinePartNotFound
+	}
+	part := envelope.Root.BreadthMatchFirst(func(part *enmime.Part) bool {
+		return part.ContentID == contentID && part.FirstChild == nil
+	})
+	if part == nil {
+		return nil, ErrInlinePartNotFound
+	}
+	return &InlinePart{
+		ContentType: part.ContentType,
+		Filename:    part.FileName,
+		Content:     part.Content,
+	}, nil
+}
+
+// cutUTF8 cuts text to at most maxBytes bytes, without splitting a UTF-8 character.
+func cutUTF8(text string, maxBytes int) string {
+	if len(text) <= maxBytes {
+		return text
+	}
+	for maxBytes > 0 && !utf8.RuneStart(text[maxBytes]) {
+		maxBytes--
+	}
+	return text[:maxBytes]
+}
+
+// snippetText returns the text body for the snippet. If the message only has an HTML body, enmime converts it
+// to text with Markdown-like formatting, so it returns nothing to make the snippet come from the HTML instead.
+func snippetText(envelope *enmime.Envelope) string {
+	if envelope.HTML == "" || envelope.Root == nil {
+		return envelope.Text
+	}
+	textPart := envelope.Root.BreadthMatchFirst(func(part *enmime.Part) bool {
+		return part.ContentType == "text/plain" && part.Disposition != "attachment"
+	})
+	if textPart == nil {
+		return ""
+	}
+	return envelope.Text
+}
+
 // formatAddress formats an IMAP address to a string.
 func formatAddress(address *imap.Address) string {
 	if address == nil {
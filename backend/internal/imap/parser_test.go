@@ -1,11 +1,14 @@
 package imap
 
 import (
+	"errors"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/emersion/go-imap"
+	"github.com/vdavid/vmail/backend/internal/models"
 )
 
 func TestFormatAddress(t *testing.T) {
@@ -246,6 +249,48 @@ func TestParseMessage(t *testing.T) {
 		}
 	})
 
+	t.Run("extracts a snippet from an HTML-only body", func(t *testing.T) {
+		raw := "Content-Type: text/html; charset=utf-8\r\n\r\n<html><style>p {}</style><p>Hello <b>there</b></p></html>"
+		msg := &models.Message{}
+		if err := parseBody(strings.NewReader(raw), msg, 0); err != nil {
+			t.Fatalf("parseBody failed: %v", err)
+		}
+		if msg.Snippet != "Hello there" {
+			t.Errorf("Expected snippet 'Hello there', got %q", msg.Snippet)
+		}
+	})
+
+	t.Run("reads the references from the header", func(t *testing.T) {
+		raw := "References: <a@example.com>\r\n <b@example.com>\r\nIn-Reply-To: <b@example.com>\r\n" +
+			"Content-Type: text/plain\r\n\r\nHi"
+		msg := &models.Message{}
+		if err := parseBody(strings.NewReader(raw), msg, 0); err != nil {
+			t.Fatalf("parseBody failed: %v", err)
+		}
+		if !slices.Equal(msg.ReferencedMessageIDs, []string{"<a@example.com>", "<b@example.com>"}) {
+			t.Errorf("Expected [<a@example.com> <b@example.com>], got %v", msg.ReferencedMessageIDs)
+		}
+	})
+
+	t.Run("cuts bodies over the part limit", func(t *testing.T) {
+		raw := "Content-Type: text/plain; charset=utf-8\r\n\r\nhéllo there"
+		msg := &models.Message{}
+		if err := parseBody(strings.NewReader(raw), msg, 2); err != nil {
+			t.Fatalf("parseBody failed: %v", err)
+		}
+		if msg.BodyText != "h" || !msg.Truncated {
+			t.Errorf("Expected a truncated body 'h' without a split character, got %q, %v", msg.BodyText, msg.Truncated)
+		}
+
+		msg = &models.Message{}
+		if err := parseBody(strings.NewReader(raw), msg, 100); err != nil {
+			t.Fatalf("parseBody failed: %v", err)
+		}
+		if msg.BodyText != "héllo there" || msg.Truncated {
+			t.Errorf("Expected the whole body, got %q, %v", msg.BodyText, msg.Truncated)
+		}
+	})
+
 	t.Run("handles body parsing errors gracefully", func(t *testing.T) {
 		// Create a message with invalid body structure
 		imapMsg := &imap.Message{
@@ -321,3 +366,50 @@ func TestParseMessage(t *testing.T) {
 		// This is tested through integration tests
 	})
 }
+
+func TestFindInlinePart(t *testing.T) {
+	source := "MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/related; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<img src=\"cid:logo@example.com\">\r\n" +
+		"--b\r\n" +
+		"Content-Type: image/png\r\n" +
+		"Content-ID: <logo@example.com>\r\n" +
+		"Content-Disposition: inline; filename=logo.png\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"UE5HREFUQQ==\r\n" +
+		"--b--\r\n"
+
+	t.Run("finds the part by its Content-ID", func(t *testing.T) {
+		part, err := FindInlinePart(strings.NewReader(source), "logo@example.com")
+		if err != nil {
+			t.Fatalf("FindInlinePart failed: %v", err)
+		}
+		if part.ContentType != "image/png" || part.Filename != "logo.png" || string(part.Content) != "PNGDATA" {
+			t.Errorf("Unexpected part: %q, %q, %q", part.ContentType, part.Filename, part.Content)
+		}
+	})
+
+	t.Run("returns ErrInlinePartNotFound for unknown Content-IDs", func(t *testing.T) {
+		if _, err := FindInlinePart(strings.NewReader(source), "other@example.com"); !errors.Is(err, ErrInlinePartNotFound) {
+			t.Errorf("Expected ErrInlinePartNotFound, got %v", err)
+		}
+	})
+}
+
+func TestParseBody_LinkAnalysis(t *testing.T) {
+	raw := "Content-Type: text/html\r\n\r\n<a href=\"https://evil.example/login\">bank.example</a>"
+
+	msg := &models.Message{}
+	if err := parseBody(strings.NewReader(raw), msg, 0); err != nil {
+		t.Fatalf("parseBody failed: %v", err)
+	}
+	if msg.LinkAnalysis == nil || len(msg.LinkAnalysis.Warnings) != 1 ||
+		msg.LinkAnalysis.Warnings[0].Kind != models.LinkWarningMismatchedText {
+		t.Errorf("Expected a mismatched text warning, got %+v", msg.LinkAnalysis)
+	}
+}
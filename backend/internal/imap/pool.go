@@ -2,9 +2,12 @@ package imap
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
 	"sync"
 	"time"
+
+	"github.com/emersion/go-imap/client"
 )
 
 const (
@@ -12,6 +15,9 @@ const (
 	workerIdleTimeout = 10 * time.Minute
 	// healthCheckThreshold is the idle time after which we perform a health check before reuse.
 	healthCheckThreshold = 1 * time.Minute
+	// defaultOperationTimeout is how long a WithClient call can take if its context has no deadline.
+	// See SetOperationTimeout.
+	defaultOperationTimeout = 2 * time.Minute
 )
 
 // Pool manages IMAP connections per user.
@@ -29,6 +35,8 @@ type Pool struct {
 	maxWorkers    int // Maximum worker connections per user (default: 3)
 	cleanupCtx    context.Context
 	cleanupCancel context.CancelFunc
+	// operationTimeout is how long a WithClient call can take if its context has no deadline. Zero means no limit.
+	operationTimeout time.Duration
 }
 
 // NewPool creates a new IMAP connection pool with the default worker limit.
@@ -46,23 +54,63 @@ func NewPoolWithMaxWorkers(maxWorkers int) *Pool {
 		maxWorkers:    maxWorkers,
 		cleanupCtx:    ctx,
 		cleanupCancel: cancel,
+
+		operationTimeout: defaultOperationTimeout,
 	}
 	go p.startCleanupGoroutine()
 	return p
 }
 
+// SetOperationTimeout sets how long a WithClient call can take if its context has no deadline.
+// Zero means no limit. Call it before using the pool.
+func (p *Pool) SetOperationTimeout(timeout time.Duration) {
+	p.operationTimeout = timeout
+}
+
 // WithClient gets an IMAP client for a user and calls the provided function with it.
 // The client is automatically released when the function returns.
+// go-imap commands don't take a context, so if ctx ends while the function runs, we close the connection, which
+// makes the running command fail, and we drop the connection from the pool. Contexts without a deadline get the
+// pool's operation timeout, so that a server that stops answering can't hang a request forever.
+// Connections that fail with a transient error (see IsTransientError) are dropped too, so that a retry gets a new one.
 // Implements IMAPPool interface.
-func (p *Pool) WithClient(userID, server, username, password string, fn func(IMAPClient) error) error {
-	tsClient, release, err := p.getWorkerConnection(userID, server, username, password)
+func (p *Pool) WithClient(ctx context.Context, userID string, server ServerConfig, username, password string, fn func(IMAPClient) error) error {
+	if _, ok := ctx.Deadline(); !ok && p.operationTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.operationTimeout)
+		defer cancel()
+	}
+
+	tsClient, release, err := p.getWorkerConnection(ctx, userID, server, username, password)
 	if err != nil {
 		return err
 	}
-	defer release()
 
-	client := &ClientWrapper{client: tsClient.GetClient()}
-	return fn(client)
+	wrapper := &ClientWrapper{client: tsClient.GetClient(), qresync: tsClient.qresync}
+	err = runWithContext(ctx, tsClient.GetClient(), func() error { return fn(wrapper) })
+	if ctx.Err() != nil || IsTransientError(err) {
+		// Before releasing it, so that no other request picks up the dead connection
+		p.removeDeadClient(p.getOrCreateWorkerSet(userID), tsClient)
+	}
+	release()
+	return err
+}
+
+// runWithContext runs fn, which uses the client, and closes the connection if ctx ends first.
+// That's the only way to stop a go-imap command, so the client is unusable after a cancellation.
+func runWithContext(ctx context.Context, c *client.Client, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("IMAP operation cancelled: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = c.Terminate()
+	})
+	err := fn()
+	if !stop() {
+		// The connection got closed, so whatever fn returned is because of that
+		return fmt.Errorf("IMAP operation cancelled: %w", ctx.Err())
+	}
+	return err
 }
 
 // RemoveClient removes all connections (worker and listener) for a user from the pool.
@@ -104,7 +152,7 @@ func (p *Pool) Close() {
 		// Try to lock - if we can't, the listener is in use
 		if listener.TryLock() {
 			if err := listener.GetClient().Logout(); err != nil {
-				log.Printf("Failed to logout listener connection for user %s: %v", userID, err)
+				slog.Warn("Failed to logout listener connection", "user_id", userID, "error", err)
 			}
 			listener.Unlock()
 		} else {
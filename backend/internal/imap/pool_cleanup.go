@@ -37,17 +37,19 @@ func (p *Pool) cleanupIdleConnections() {
 				toRemove = append(toRemove, client)
 			}
 		}
-		// Remove idle clients
+		// Remove idle clients. Clients in use aren't idle, and waiting for them would stall the user's requests.
 		for _, client := range toRemove {
+			if !client.TryLock() {
+				continue
+			}
 			for i, c := range set.clients {
 				if c == client {
 					set.clients = append(set.clients[:i], set.clients[i+1:]...)
-					client.Lock()
 					_ = client.GetClient().Logout()
-					client.Unlock()
 					break
 				}
 			}
+			client.Unlock()
 		}
 		// Remove empty sets
 		if len(set.clients) == 0 {
@@ -1,6 +1,8 @@
 package imap
 
 import (
+	"context"
+
 	"github.com/emersion/go-imap/client"
 	"github.com/vdavid/vmail/backend/internal/models"
 )
@@ -11,7 +13,7 @@ import (
 //
 //goland:noinspection GoNameStartsWithPackageName
 type IMAPClient interface {
-	// ListFolders lists all folders on the IMAP server with their roles determined by SPECIAL-USE attributes.
+	// ListFolders lists all folders on the IMAP server with their roles from SPECIAL-USE attributes or common names.
 	ListFolders() ([]*models.Folder, error)
 }
 
@@ -24,8 +26,8 @@ type IMAPPool interface {
 	// WithClient gets an IMAP client for a user and calls the provided function with it.
 	// The client is automatically released when the function returns, ensuring worker slots
 	// are freed promptly. This is the safe way to use the pool - it's impossible to forget
-	// to release the client.
-	WithClient(userID, server, username, password string, fn func(IMAPClient) error) error
+	// to release the client. If ctx ends before the function returns, the connection is closed.
+	WithClient(ctx context.Context, userID string, server ServerConfig, username, password string, fn func(IMAPClient) error) error
 
 	// RemoveClient removes a client from the pool (useful when a connection is broken).
 	RemoveClient(userID string)
@@ -35,7 +37,7 @@ type IMAPPool interface {
 
 	// GetListenerConnection gets or creates a dedicated listener client for IDLE.
 	// Returns a locked client that must be unlocked by the caller.
-	GetListenerConnection(userID, server, username, password string) (ListenerClient, error)
+	GetListenerConnection(userID string, server ServerConfig, username, password string) (ListenerClient, error)
 
 	// RemoveListenerConnection removes a listener connection from the pool.
 	RemoveListenerConnection(userID string)
@@ -43,10 +45,11 @@ type IMAPPool interface {
 
 // ClientWrapper wraps a go-imap client.Client to implement IMAPClient interface.
 type ClientWrapper struct {
-	client *client.Client
+	client  *client.Client
+	qresync bool
 }
 
-// ListFolders lists all folders on the IMAP server with their roles determined by SPECIAL-USE attributes.
+// ListFolders lists all folders on the IMAP server with their roles from SPECIAL-USE attributes or common names.
 func (w *ClientWrapper) ListFolders() ([]*models.Folder, error) {
 	return ListFolders(w.client)
 }
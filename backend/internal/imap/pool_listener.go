@@ -2,7 +2,6 @@ package imap
 
 import (
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/emersion/go-imap"
@@ -12,7 +11,7 @@ import (
 // Listener clients are dedicated clients for IDLE command.
 // Returns a locked client that must be unlocked by the caller.
 // Thread-safe: uses double-check locking pattern.
-func (p *Pool) GetListenerConnection(userID, server, username, password string) (ListenerClient, error) {
+func (p *Pool) GetListenerConnection(userID string, server ServerConfig, username, password string) (ListenerClient, error) {
 	// First check without a lock
 	p.mu.RLock()
 	listener, exists := p.listeners[userID]
@@ -49,8 +48,7 @@ func (p *Pool) GetListenerConnection(userID, server, username, password string)
 	}
 
 	// Need to create a new listener connection
-	useTLS := os.Getenv("VMAIL_TEST_MODE") != "true"
-	c, err := ConnectToIMAP(server, useTLS)
+	c, err := ConnectToIMAP(server)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect: %w", err)
 	}
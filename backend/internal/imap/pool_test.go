@@ -1,9 +1,13 @@
 package imap
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/emersion/go-imap"
 	"github.com/vdavid/vmail/backend/internal/testutil"
@@ -118,7 +122,7 @@ func TestPool_ConcurrentAccess(t *testing.T) {
 		results := make(chan error, numGoroutines)
 		for i := 0; i < numGoroutines; i++ {
 			go func() {
-				err := pool.WithClient(userID, server.Address, server.Username(), server.Password(), func(client IMAPClient) error {
+				err := pool.WithClient(context.Background(), userID, ServerConfig{Address: server.Address}, server.Username(), server.Password(), func(client IMAPClient) error {
 					// Client is automatically released when this function returns
 					return nil
 				})
@@ -140,7 +144,7 @@ func TestPool_ConcurrentAccess(t *testing.T) {
 		// Use WithClient to get a client
 		done := make(chan bool, 1)
 		go func() {
-			err := pool.WithClient(userID, server.Address, server.Username(), server.Password(), func(client IMAPClient) error {
+			err := pool.WithClient(context.Background(), userID, ServerConfig{Address: server.Address}, server.Username(), server.Password(), func(client IMAPClient) error {
 				// Simulate using the client
 				_ = client
 				done <- true
@@ -183,7 +187,7 @@ func TestPool_EdgeCases(t *testing.T) {
 		const numUsers = 100
 		for i := 0; i < numUsers; i++ {
 			userID := fmt.Sprintf("user-%d", i)
-			err := pool.WithClient(userID, server.Address, server.Username(), server.Password(), func(client IMAPClient) error {
+			err := pool.WithClient(context.Background(), userID, ServerConfig{Address: server.Address}, server.Username(), server.Password(), func(client IMAPClient) error {
 				// Client is automatically released when this function returns
 				return nil
 			})
@@ -204,7 +208,7 @@ func TestPool_EdgeCases(t *testing.T) {
 		pool := NewPool()
 
 		// Use WithClient to get a client
-		err := pool.WithClient("close-user", server.Address, server.Username(), server.Password(), func(client IMAPClient) error {
+		err := pool.WithClient(context.Background(), "close-user", ServerConfig{Address: server.Address}, server.Username(), server.Password(), func(client IMAPClient) error {
 			// Client is automatically released when this function returns
 			return nil
 		})
@@ -223,7 +227,7 @@ func TestPool_EdgeCases(t *testing.T) {
 		defer pool.Close()
 
 		userID := "remove-in-use-user"
-		err := pool.WithClient(userID, server.Address, server.Username(), server.Password(), func(client IMAPClient) error {
+		err := pool.WithClient(context.Background(), userID, ServerConfig{Address: server.Address}, server.Username(), server.Password(), func(client IMAPClient) error {
 			// Client is automatically released when this function returns
 			return nil
 		})
@@ -251,3 +255,246 @@ func TestPool_Close(t *testing.T) {
 		pool.Close() // Should not panic
 	})
 }
+
+func TestPool_WithClientContext(t *testing.T) {
+	t.Setenv("VMAIL_TEST_MODE", "true")
+
+	server := testutil.NewTestIMAPServer(t)
+	defer server.Close()
+
+	pool := NewPool()
+	defer pool.Close()
+
+	t.Run("closes the connection when the context is cancelled", func(t *testing.T) {
+		const userID = "cancel-user"
+		ctx, cancel := context.WithCancel(context.Background())
+
+		err := pool.WithClient(ctx, userID, ServerConfig{Address: server.Address}, server.Username(), server.Password(), func(client IMAPClient) error {
+			cancel()
+			<-client.(*ClientWrapper).client.LoggedOut()
+			_, err := client.ListFolders()
+			return err
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected a cancellation error, got %v", err)
+		}
+		set := pool.getOrCreateWorkerSet(userID)
+		set.mu.Lock()
+		remaining := len(set.clients)
+		set.mu.Unlock()
+		if remaining != 0 {
+			t.Errorf("Expected the closed connection to be out of the pool, got %d connections", remaining)
+		}
+
+		// The next call gets a new connection
+		err = pool.WithClient(context.Background(), userID, ServerConfig{Address: server.Address}, server.Username(), server.Password(), func(client IMAPClient) error {
+			_, err := client.ListFolders()
+			return err
+		})
+		if err != nil {
+			t.Errorf("Expected a working connection after the cancellation, got %v", err)
+		}
+	})
+
+	t.Run("applies the operation timeout to contexts without a deadline", func(t *testing.T) {
+		pool := NewPool()
+		defer pool.Close()
+		pool.SetOperationTimeout(50 * time.Millisecond)
+
+		err := pool.WithClient(context.Background(), "timeout-user", ServerConfig{Address: server.Address}, server.Username(), server.Password(), func(client IMAPClient) error {
+			<-client.(*ClientWrapper).client.LoggedOut()
+			return nil
+		})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected a timeout error, got %v", err)
+		}
+	})
+
+	t.Run("doesn't start when the context is already done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		called := false
+		err := pool.WithClient(ctx, "done-user", ServerConfig{Address: server.Address}, server.Username(), server.Password(), func(IMAPClient) error {
+			called = true
+			return nil
+		})
+		if err == nil || called {
+			t.Errorf("Expected an error without calling the function, got %v (called: %v)", err, called)
+		}
+	})
+}
+
+func TestPool_Release(t *testing.T) {
+	t.Setenv("VMAIL_TEST_MODE", "true")
+
+	server := testutil.NewTestIMAPServer(t)
+	defer server.Close()
+
+	// assertNoLeaks checks that the user's worker set has no taken slots and no locked clients.
+	assertNoLeaks := func(t *testing.T, pool *Pool, userID string) {
+		t.Helper()
+		set := pool.getOrCreateWorkerSet(userID)
+		if taken := len(set.semaphore); taken != 0 {
+			t.Errorf("Expected all slots to be free, got %d taken", taken)
+		}
+		set.mu.Lock()
+		defer set.mu.Unlock()
+		if len(set.clients) > pool.maxWorkers {
+			t.Errorf("Expected at most %d clients, got %d", pool.maxWorkers, len(set.clients))
+		}
+		for i, client := range set.clients {
+			if !client.TryLock() {
+				t.Errorf("Expected client %d to be unlocked", i)
+				continue
+			}
+			client.Unlock()
+		}
+	}
+
+	t.Run("concurrent calls leave no slots or locks behind", func(t *testing.T) {
+		pool := NewPoolWithMaxWorkers(3)
+		defer pool.Close()
+
+		const userID = "concurrent-release-user"
+		const numGoroutines = 20
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		inUse, maxInUse := 0, 0
+		for i := range numGoroutines {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := pool.WithClient(context.Background(), userID, ServerConfig{Address: server.Address}, server.Username(), server.Password(), func(client IMAPClient) error {
+					mu.Lock()
+					inUse++
+					maxInUse = max(maxInUse, inUse)
+					mu.Unlock()
+					defer func() {
+						mu.Lock()
+						inUse--
+						mu.Unlock()
+					}()
+
+					if i%4 == 0 {
+						return errors.New("operation failed")
+					}
+					_, err := client.ListFolders()
+					return err
+				})
+				if err != nil && i%4 != 0 {
+					t.Errorf("WithClient failed: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if maxInUse > pool.maxWorkers {
+			t.Errorf("Expected at most %d connections in use at once, got %d", pool.maxWorkers, maxInUse)
+		}
+		assertNoLeaks(t, pool, userID)
+	})
+
+	t.Run("calling release twice frees the slot once", func(t *testing.T) {
+		pool := NewPoolWithMaxWorkers(1)
+		defer pool.Close()
+
+		const userID = "double-release-user"
+		_, release, err := pool.getWorkerConnection(context.Background(), userID, ServerConfig{Address: server.Address}, server.Username(), server.Password())
+		if err != nil {
+			t.Fatalf("Failed to get a connection: %v", err)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			release()
+			// A second release of an empty semaphore would block forever
+			release()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("The second release blocked")
+		}
+		assertNoLeaks(t, pool, userID)
+
+		// Someone else holds the connection now, so a stale release must not unlock it
+		tsClient, releaseAgain, err := pool.getWorkerConnection(context.Background(), userID, ServerConfig{Address: server.Address}, server.Username(), server.Password())
+		if err != nil {
+			t.Fatalf("Failed to get the connection again: %v", err)
+		}
+		release()
+		if tsClient.TryLock() {
+			t.Error("Expected the stale release to leave the connection locked")
+			tsClient.Unlock()
+		}
+		releaseAgain()
+		assertNoLeaks(t, pool, userID)
+	})
+
+	t.Run("removes a dead connection while the caller still holds it", func(t *testing.T) {
+		pool := NewPoolWithMaxWorkers(1)
+		defer pool.Close()
+
+		const userID = "held-dead-user"
+		tsClient, release, err := pool.getWorkerConnection(context.Background(), userID, ServerConfig{Address: server.Address}, server.Username(), server.Password())
+		if err != nil {
+			t.Fatalf("Failed to get a connection: %v", err)
+		}
+		set := pool.getOrCreateWorkerSet(userID)
+		done := make(chan struct{})
+		go func() {
+			pool.removeDeadClient(set, tsClient)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Removing a held connection waited for its lock")
+		}
+		release()
+
+		set.mu.Lock()
+		remaining := len(set.clients)
+		set.mu.Unlock()
+		if remaining != 0 {
+			t.Errorf("Expected the dead connection to be out of the pool, got %d connections", remaining)
+		}
+		assertNoLeaks(t, pool, userID)
+	})
+
+	t.Run("replaces a dead connection without releasing it twice", func(t *testing.T) {
+		pool := NewPoolWithMaxWorkers(1)
+		defer pool.Close()
+
+		const userID = "dead-release-user"
+		// The connection looks idle for long enough to get a health check, which finds it dead
+		var dead *ClientWrapper
+		err := pool.WithClient(context.Background(), userID, ServerConfig{Address: server.Address}, server.Username(), server.Password(), func(client IMAPClient) error {
+			dead = client.(*ClientWrapper)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to get a connection: %v", err)
+		}
+		_ = dead.client.Terminate()
+		<-dead.client.LoggedOut()
+		set := pool.getOrCreateWorkerSet(userID)
+		set.mu.Lock()
+		set.clients[0].lastUsed = time.Now().Add(-2 * healthCheckThreshold)
+		set.mu.Unlock()
+
+		err = pool.WithClient(context.Background(), userID, ServerConfig{Address: server.Address}, server.Username(), server.Password(), func(client IMAPClient) error {
+			if client.(*ClientWrapper).client == dead.client {
+				return errors.New("got the dead connection")
+			}
+			_, err := client.ListFolders()
+			return err
+		})
+		if err != nil {
+			t.Errorf("Expected a new connection, got %v", err)
+		}
+		assertNoLeaks(t, pool, userID)
+	})
+}
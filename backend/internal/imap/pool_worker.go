@@ -1,8 +1,9 @@
 package imap
 
 import (
+	"context"
 	"fmt"
-	"os"
+	"log/slog"
 	"time"
 
 	"github.com/emersion/go-imap"
@@ -41,11 +42,15 @@ func (p *Pool) getOrCreateWorkerSet(userID string) *workerClientSet {
 // getWorkerConnection gets or creates a worker client for a user.
 // Returns a locked client and a release function that must be called when done.
 // Thread-safe: uses double-check locking and proper synchronization.
-func (p *Pool) getWorkerConnection(userID, server, username, password string) (*threadSafeClient, func(), error) {
+// It gives up when ctx ends, including while it waits for a free connection or logs in.
+func (p *Pool) getWorkerConnection(ctx context.Context, userID string, server ServerConfig, username, password string) (*threadSafeClient, func(), error) {
 	set := p.getOrCreateWorkerSet(userID)
 
 	// Try to acquire an existing client
-	tsClient, release := set.acquire()
+	tsClient, release, err := set.acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
 	if tsClient != nil {
 		// Client is already locked from acquire()
 		// Check if client is healthy
@@ -54,12 +59,10 @@ func (p *Pool) getWorkerConnection(userID, server, username, password string) (*
 			// Check if we need a health check
 			lastUsed := tsClient.GetLastUsed()
 			if time.Since(lastUsed) > healthCheckThreshold {
-				if !p.checkConnectionHealth(tsClient) {
-					// Client is dead, unlock and remove it
-					tsClient.Unlock()
-					release()
-					// Remove from the set and create a new one
+				if !p.checkConnectionHealth(ctx, tsClient) {
+					// Client is dead, remove it from the set before releasing it, and create a new one
 					p.removeDeadClient(set, tsClient)
+					release()
 					// Fall through to create a new client
 				} else {
 					// Client is healthy, update timestamp
@@ -73,16 +76,17 @@ func (p *Pool) getWorkerConnection(userID, server, username, password string) (*
 			}
 		} else {
 			// Client is dead
-			tsClient.Unlock()
-			release()
 			p.removeDeadClient(set, tsClient)
+			release()
 			// Fall through to create a new client
 		}
 	}
 
 	// Need to create a new client
 	// Acquire semaphore slot
-	set.semaphore <- struct{}{}
+	if err := set.waitForSlot(ctx); err != nil {
+		return nil, nil, err
+	}
 
 	// Use a flag to track if we should release in defer
 	// We'll manually release on error paths, so defer should not release in those cases
@@ -104,11 +108,7 @@ func (p *Pool) getWorkerConnection(userID, server, username, password string) (*
 				// Return with release function
 				// Don't release in defer since we're returning a client
 				shouldReleaseInDefer = false
-				release := func() {
-					existingClient.Unlock()
-					<-set.semaphore
-				}
-				return existingClient, release, nil // Caller must call release() when done
+				return existingClient, set.releaseFunc(existingClient), nil // Caller must call release() when done
 			}
 			existingClient.mu.Unlock()
 		}
@@ -116,26 +116,32 @@ func (p *Pool) getWorkerConnection(userID, server, username, password string) (*
 	set.mu.Unlock()
 
 	// Create new client
-	useTLS := os.Getenv("VMAIL_TEST_MODE") != "true"
-	c, err := ConnectToIMAP(server, useTLS)
+	c, err := ConnectToIMAP(server)
 	if err != nil {
 		shouldReleaseInDefer = false // Don't release in defer, we'll do it manually
 		<-set.semaphore              // Release semaphore on error
 		return nil, nil, fmt.Errorf("failed to connect: %w", err)
 	}
 
-	if err := Login(c, username, password); err != nil {
+	if err := runWithContext(ctx, c, func() error { return Login(c, username, password) }); err != nil {
 		shouldReleaseInDefer = false // Don't release in defer, we'll do it manually
 		_ = c.Logout()
 		<-set.semaphore // Release semaphore on error
 		return nil, nil, fmt.Errorf("failed to login: %w", err)
 	}
 
+	// Syncs work without QRESYNC, they just can't see deletions, so a failure here isn't fatal
+	qresync, err := EnableQResync(c)
+	if err != nil {
+		slog.Warn("IMAP: Failed to enable QRESYNC", "user_id", userID, "error", err)
+	}
+
 	// Wrap in threadSafeClient
 	newClient := &threadSafeClient{
 		client:   c,
 		lastUsed: time.Now(),
 		role:     roleWorker,
+		qresync:  qresync,
 	}
 	tsClient = newClient
 
@@ -145,37 +151,30 @@ func (p *Pool) getWorkerConnection(userID, server, username, password string) (*
 
 	// Don't release in defer - the release function will handle it
 	shouldReleaseInDefer = false
-	// Create release function for the new client
-	newRelease := func() {
-		tsClient.Unlock()
-		<-set.semaphore
-	}
-	return tsClient, newRelease, nil
+	return tsClient, set.releaseFunc(tsClient), nil
 }
 
-// removeDeadClient removes a dead client from the set.
+// removeDeadClient removes a dead client from the set, and closes its connection.
+// The caller must hold the client, and release it only after this returns, so that no one else can pick it up
+// in between. Closing doesn't wait for the server, since a dead connection might never answer.
 func (p *Pool) removeDeadClient(set *workerClientSet, client *threadSafeClient) {
 	set.mu.Lock()
-	defer set.mu.Unlock()
-
 	for i, c := range set.clients {
 		if c == client {
-			// Remove from slice
 			set.clients = append(set.clients[:i], set.clients[i+1:]...)
-			// Close client
-			client.Lock()
-			_ = client.client.Logout()
-			client.Unlock()
 			break
 		}
 	}
+	set.mu.Unlock()
+
+	_ = client.client.Terminate()
 }
 
 // checkConnectionHealth performs a NOOP command to check if client is alive.
 // The client must be locked before calling this.
-func (p *Pool) checkConnectionHealth(client *threadSafeClient) bool {
+func (p *Pool) checkConnectionHealth(ctx context.Context, client *threadSafeClient) bool {
 	// The caller has already locked the client
-	if err := client.client.Noop(); err != nil {
+	if err := runWithContext(ctx, client.client, client.client.Noop); err != nil {
 		return false
 	}
 	return true
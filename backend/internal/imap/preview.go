@@ -0,0 +1,73 @@
+package imap
+
+import (
+	"context"
+	"sort"
+
+	"github.com/emersion/go-imap"
+	imapclient "github.com/emersion/go-imap/client"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// PreviewFolder fetches the envelopes of the newest messages in a folder directly from IMAP, without caching them.
+// It's a fast way to show something for folders that we haven't synced yet.
+// Each message becomes its own thread, since we don't know the thread structure yet. The threads have no ID.
+// Returns the threads, newest first, and the number of messages in the folder.
+func (s *Service) PreviewFolder(ctx context.Context, userID, folderName string, limit int) ([]*models.Thread, int, error) {
+	var threads []*models.Thread
+	var messageCount int
+
+	err := s.withClientAndSelectFolder(ctx, userID, folderName, func(client *imapclient.Client, mbox *imap.MailboxStatus) error {
+		messages, err := FetchNewestMessageHeaders(client, mbox.Messages, limit)
+		if err != nil {
+			return err
+		}
+		threads = buildPreviewThreads(messages, userID, folderName)
+		messageCount = int(mbox.Messages)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return threads, messageCount, nil
+}
+
+// buildPreviewThreads turns each message into a single-message thread, newest first.
+// Messages without a Message-ID are skipped, like in sync.
+func buildPreviewThreads(messages []*imap.Message, userID, folderName string) []*models.Thread {
+	threads := make([]*models.Thread, 0, len(messages))
+	for _, imapMsg := range messages {
+		msg, err := ParseMessage(imapMsg, "", userID, folderName)
+		if err != nil || msg.MessageIDHeader == "" {
+			continue
+		}
+		threads = append(threads, &models.Thread{
+			StableThreadID:          msg.MessageIDHeader,
+			Subject:                 msg.Subject,
+			UserID:                  userID,
+			FirstMessageFromAddress: msg.FromAddress,
+			MessageCount:            1,
+			UnreadCount:             unreadCount(msg),
+			LastSentAt:              msg.SentAt,
+		})
+	}
+
+	sort.SliceStable(threads, func(i, j int) bool {
+		a, b := threads[i].LastSentAt, threads[j].LastSentAt
+		if a == nil || b == nil {
+			return b == nil && a != nil
+		}
+		return a.After(*b)
+	})
+
+	return threads
+}
+
+// unreadCount returns 1 if the message is unread, otherwise 0.
+func unreadCount(msg *models.Message) int {
+	if msg.IsRead {
+		return 0
+	}
+	return 1
+}
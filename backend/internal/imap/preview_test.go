@@ -0,0 +1,49 @@
+package imap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestBuildPreviewThreads(t *testing.T) {
+	now := time.Now()
+	messages := []*imap.Message{
+		{Uid: 1, Flags: []string{imap.SeenFlag}, Envelope: &imap.Envelope{MessageId: "<old@example.com>", Subject: "Old", Date: now.Add(-time.Hour)}},
+		{Uid: 2, Envelope: &imap.Envelope{MessageId: "<undated@example.com>", Subject: "Undated"}},
+		{Uid: 3, Envelope: &imap.Envelope{
+			MessageId: "<new@example.com>",
+			Subject:   "New",
+			Date:      now,
+			From:      []*imap.Address{{PersonalName: "Alice", MailboxName: "alice", HostName: "example.com"}},
+		}},
+		{Uid: 4, Envelope: &imap.Envelope{Subject: "No Message-ID", Date: now}},
+	}
+
+	threads := buildPreviewThreads(messages, "user-1", "INBOX")
+
+	if len(threads) != 3 {
+		t.Fatalf("Expected 3 threads, got %d", len(threads))
+	}
+	expectedOrder := []string{"<new@example.com>", "<old@example.com>", "<undated@example.com>"}
+	for i, expected := range expectedOrder {
+		if threads[i].StableThreadID != expected {
+			t.Errorf("Expected thread %d to be %s, got %s", i, expected, threads[i].StableThreadID)
+		}
+	}
+
+	newest := threads[0]
+	if newest.ID != "" {
+		t.Errorf("Expected no ID for a preview thread, got %s", newest.ID)
+	}
+	if newest.Subject != "New" || newest.UserID != "user-1" || newest.MessageCount != 1 {
+		t.Errorf("Unexpected thread: %+v", newest)
+	}
+	if newest.FirstMessageFromAddress != "Alice <alice@example.com>" {
+		t.Errorf("Expected sender 'Alice <alice@example.com>', got %s", newest.FirstMessageFromAddress)
+	}
+	if newest.UnreadCount != 1 || threads[1].UnreadCount != 0 {
+		t.Errorf("Expected only the unseen message to be unread, got %d and %d", newest.UnreadCount, threads[1].UnreadCount)
+	}
+}
@@ -0,0 +1,101 @@
+package imap
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"time"
+)
+
+// RetryPolicy is how Retry tries an operation again after a transient error.
+type RetryPolicy struct {
+	// Attempts is how many times Retry runs the operation at most, including the first time.
+	// One or less runs it once.
+	Attempts int
+	// BaseDelay is how long Retry waits before the first retry. It doubles with each retry.
+	BaseDelay time.Duration
+	// MaxDelay is the longest Retry waits between two attempts.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is what the Service uses for syncs, searches, and listing folders.
+// It rides out a dropped connection without keeping the user waiting for long.
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, BaseDelay: 200 * time.Millisecond, MaxDelay: 2 * time.Second}
+
+// transientErrorTexts are parts of the errors of broken connections and timeouts.
+// Many of them only reach us as text, wrapped by go-imap or by our own fmt.Errorf calls without %w.
+var transientErrorTexts = []string{
+	"broken pipe",
+	"connection reset",
+	"EOF",
+	"i/o timeout",
+}
+
+// IsTransientError tells whether err is worth trying again on a new connection: a dropped or reset connection,
+// or a timeout. Errors that the server answered with, like NO responses and throttling, aren't transient,
+// since the server would answer the same. Cancelled contexts aren't either.
+func IsTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	switch classifyThrottle(err) {
+	case signalThrottled:
+		return false
+	case signalDropped:
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	text := err.Error()
+	for _, part := range transientErrorTexts {
+		if strings.Contains(text, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// Retry runs fn, and runs it again while it fails with a transient error (see IsTransientError),
+// up to policy.Attempts times in total. It waits with exponential backoff and jitter between attempts,
+// and gives up early if ctx ends. onRetry, if not nil, gets the error before each retry, for example,
+// to drop the broken connection. Returns the error of the last attempt.
+func Retry(ctx context.Context, policy RetryPolicy, fn func() error, onRetry func(err error)) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= policy.Attempts || !IsTransientError(err) {
+			return err
+		}
+
+		if onRetry != nil {
+			onRetry(err)
+		}
+
+		timer := time.NewTimer(retryDelay(policy, attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryDelay returns how long to wait after the given failed attempt, counting from 1.
+// It's between half and all of the backoff, so that clients that failed together don't retry together.
+func retryDelay(policy RetryPolicy, attempt int) time.Duration {
+	backoff := policy.MaxDelay
+	if attempt <= 16 { // Later attempts are over any sensible maximum anyway
+		backoff = min(policy.BaseDelay<<(attempt-1), policy.MaxDelay)
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + rand.N(backoff/2+1)
+}
@@ -0,0 +1,123 @@
+package imap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsTransientError(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"no error", nil, false},
+		{"EOF", fmt.Errorf("failed to fetch: %w", io.EOF), true},
+		{"connection reset", fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{"broken pipe as text", errors.New("write tcp 10.0.0.1:51443->10.0.0.2:993: write: broken pipe"), true},
+		{"network timeout", fmt.Errorf("failed to connect: %w", os.ErrDeadlineExceeded), true},
+		{"timeout as text", errors.New("dial tcp 10.0.0.2:993: i/o timeout"), true},
+		{"cancelled context", fmt.Errorf("IMAP operation cancelled: %w", context.Canceled), false},
+		{"context deadline", fmt.Errorf("IMAP operation cancelled: %w", context.DeadlineExceeded), false},
+		{"throttled", errors.New("Account exceeded command or bandwidth limits."), false},
+		{"NO response", errors.New("Mailbox doesn't exist: Archive"), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := IsTransientError(tc.err); got != tc.expected {
+				t.Errorf("Expected %v for %v, got %v", tc.expected, tc.err, got)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	policy := RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	t.Run("retries transient errors until it works", func(t *testing.T) {
+		calls, retries := 0, 0
+		err := Retry(t.Context(), policy, func() error {
+			calls++
+			if calls < 3 {
+				return io.EOF
+			}
+			return nil
+		}, func(error) { retries++ })
+		if err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		if calls != 3 || retries != 2 {
+			t.Errorf("Expected 3 calls and 2 retries, got %d calls and %d retries", calls, retries)
+		}
+	})
+
+	t.Run("returns the last error after all attempts", func(t *testing.T) {
+		calls := 0
+		err := Retry(t.Context(), policy, func() error {
+			calls++
+			return io.EOF
+		}, nil)
+		if !errors.Is(err, io.EOF) {
+			t.Errorf("Expected EOF, got %v", err)
+		}
+		if calls != 3 {
+			t.Errorf("Expected 3 calls, got %d", calls)
+		}
+	})
+
+	t.Run("doesn't retry other errors", func(t *testing.T) {
+		calls := 0
+		noResponse := errors.New("Mailbox doesn't exist: Archive")
+		err := Retry(t.Context(), policy, func() error {
+			calls++
+			return noResponse
+		}, nil)
+		if !errors.Is(err, noResponse) || calls != 1 {
+			t.Errorf("Expected the error after 1 call, got %v after %d calls", err, calls)
+		}
+	})
+
+	t.Run("stops waiting when the context ends", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		calls := 0
+		err := Retry(ctx, RetryPolicy{Attempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour}, func() error {
+			calls++
+			return io.EOF
+		}, func(error) { cancel() })
+		if !errors.Is(err, io.EOF) || calls != 1 {
+			t.Errorf("Expected EOF after 1 call, got %v after %d calls", err, calls)
+		}
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	testCases := []struct {
+		attempt int
+		backoff time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{100, time.Second},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("attempt %d", tc.attempt), func(t *testing.T) {
+			for range 20 {
+				if got := retryDelay(policy, tc.attempt); got < tc.backoff/2 || got > tc.backoff {
+					t.Fatalf("Expected a delay between %v and %v, got %v", tc.backoff/2, tc.backoff, got)
+				}
+			}
+		})
+	}
+}
@@ -4,14 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"maps"
+	"math"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/emersion/go-imap"
 	imapclient "github.com/emersion/go-imap/client"
 	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/logging"
 	"github.com/vdavid/vmail/backend/internal/models"
 )
 
@@ -57,6 +62,46 @@ func parseDateFilter(token, prefix string, criteria *imap.SearchCriteria) (bool,
 	return true, nil
 }
 
+// parseFlagFilter processes is: filters (is:unread, is:read, is:starred).
+// Returns (handled, error) where handled indicates if the token matched this filter type.
+func parseFlagFilter(token string, criteria *imap.SearchCriteria) (bool, error) {
+	value, ok := strings.CutPrefix(token, "is:")
+	if !ok {
+		return false, nil
+	}
+	switch strings.ToLower(value) {
+	case "unread":
+		criteria.WithoutFlags = append(criteria.WithoutFlags, imap.SeenFlag)
+	case "read":
+		criteria.WithFlags = append(criteria.WithFlags, imap.SeenFlag)
+	case "starred":
+		criteria.WithFlags = append(criteria.WithFlags, imap.FlaggedFlag)
+	default:
+		return false, fmt.Errorf("unknown is: value %q, expected unread, read, or starred", value)
+	}
+	return true, nil
+}
+
+// parseMessageSizeFilter processes message size filters (larger:, smaller:), like larger:5M.
+// Returns (handled, error) where handled indicates if the token matched this filter type.
+func parseMessageSizeFilter(token, prefix string, criteria *imap.SearchCriteria) (bool, error) {
+	value, ok := strings.CutPrefix(token, prefix)
+	if !ok {
+		return false, nil
+	}
+	size, err := parseSize(value)
+	// IMAP sizes are 32-bit, and a zero size would mean no filter
+	if err != nil || size < 1 || size > math.MaxUint32 {
+		return false, fmt.Errorf("invalid %s value %q, expected a size like 5M", prefix, value)
+	}
+	if prefix == "larger:" {
+		criteria.Larger = uint32(size)
+	} else {
+		criteria.Smaller = uint32(size)
+	}
+	return true, nil
+}
+
 // parseFolderFilter processes folder: or label: filters.
 // Only the first folder: or label: filter is extracted; subsequent ones are ignored.
 // Returns (handled, folder, error) where handled indicates if the token matched this filter type.
@@ -116,6 +161,25 @@ func parseFilterToken(token string, criteria *imap.SearchCriteria, folderFound *
 		return true, "", nil
 	}
 
+	// Try flag and size filters (is:, larger:, smaller:)
+	if handled, err := parseFlagFilter(token, criteria); err != nil {
+		return false, "", err
+	} else if handled {
+		return true, "", nil
+	}
+
+	if handled, err := parseMessageSizeFilter(token, "larger:", criteria); err != nil {
+		return false, "", err
+	} else if handled {
+		return true, "", nil
+	}
+
+	if handled, err := parseMessageSizeFilter(token, "smaller:", criteria); err != nil {
+		return false, "", err
+	} else if handled {
+		return true, "", nil
+	}
+
 	// Try folder filter
 	handled, folder, err := parseFolderFilter(token, folderFound)
 	if err != nil {
@@ -136,51 +200,449 @@ func parseFilterToken(token string, criteria *imap.SearchCriteria, folderFound *
 //   - subject:meeting → criteria.Header["Subject"] = "meeting"
 //   - after:2025-01-01 → criteria.Since = time.Date(...)
 //   - before:2025-12-31 → criteria.Before = time.Date(...)
+//   - is:unread, is:read, is:starred → criteria.WithoutFlags or criteria.WithFlags with \Seen or \Flagged
+//   - larger:5M, smaller:100K → criteria.Larger or criteria.Smaller, in bytes. Sizes are like for size:
 //   - folder:Inbox or label:Inbox → extract folder name (returned separately)
 //   - Plain text → criteria.Text = []string{text}
 //   - Combinations: from:george after:2025-01-01 cabbage
+//   - Negation: -from:boss or -(from:boss subject:budget) → criteria.Not
+//   - OR, in capitals: from:alice OR from:bob → criteria.Or. OR binds tighter than the implicit AND
+//   - Groups: (from:alice OR to:alice) cabbage, and from:(alice OR bob), which applies from: to each term
+//
+// folder: and label: can't be negated or combined with OR, since they pick where to search.
 func ParseSearchQuery(query string) (*imap.SearchCriteria, string, error) {
-	criteria := imap.NewSearchCriteria()
-	folder := ""
-
 	if query == "" {
-		return criteria, folder, nil
+		return imap.NewSearchCriteria(), "", nil
 	}
 
-	// Tokenize query, respecting quoted strings
-	tokens := tokenizeQuery(query)
+	p := &queryParser{tokens: tokenizeQuery(query)}
+	criteria, err := p.parseAnd(false)
+	if err != nil {
+		return nil, "", err
+	}
+	return criteria, p.folder, nil
+}
+
+// queryParser parses the tokens of a search query, with this grammar:
+//
+//	and   = or { or }
+//	or    = unary { "OR" unary }
+//	unary = "-" unary | "(" and ")" | prefix "(" and ")" | filter | word
+type queryParser struct {
+	tokens []string
+	pos    int
+	// folder is the first folder: or label: filter. folderFound is true once we've seen one.
+	folder      string
+	folderFound bool
+	// depth is how many groups and negations we're in. Folder filters are only allowed at depth 0.
+	depth int
+}
+
+// queryOperand is what a term of the query means: a plain word, or criteria to combine with the other terms.
+type queryOperand struct {
+	word     string
+	criteria *imap.SearchCriteria
+	// folder is true for folder: and label: filters, whose criteria are empty.
+	folder bool
+}
+
+// toCriteria returns the criteria of the operand, turning plain words into text searches.
+func (o queryOperand) toCriteria() *imap.SearchCriteria {
+	if o.criteria != nil {
+		return o.criteria
+	}
+	criteria := imap.NewSearchCriteria()
+	criteria.Text = []string{o.word}
+	return criteria
+}
 
-	// Track if we've seen folder: or label:
-	folderFound := false
+// peek returns the next token, or "" if there are none left.
+func (p *queryParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// parseAnd parses terms until the end of the query, or of the group if nested, and combines them.
+// Plain words are joined into one text search, like in queries without groups.
+func (p *queryParser) parseAnd(nested bool) (*imap.SearchCriteria, error) {
+	criteria := imap.NewSearchCriteria()
 	var plainTextParts []string
 
-	for _, token := range tokens {
-		handled, extractedFolder, err := parseFilterToken(token, criteria, &folderFound)
+	for p.pos < len(p.tokens) {
+		if p.peek() == ")" {
+			if !nested {
+				return nil, fmt.Errorf("unexpected ) without a (")
+			}
+			break
+		}
+		if p.peek() == "OR" {
+			return nil, fmt.Errorf("OR needs a term on both sides")
+		}
+
+		operand, err := p.parseOr()
 		if err != nil {
-			return nil, "", err
+			return nil, err
 		}
-		if handled {
-			if extractedFolder != "" {
-				folder = extractedFolder
+		if operand.criteria == nil {
+			plainTextParts = append(plainTextParts, operand.word)
+		} else {
+			mergeCriteria(criteria, operand.criteria)
+		}
+	}
+
+	if len(plainTextParts) > 0 {
+		criteria.Text = append(criteria.Text, strings.Join(plainTextParts, " "))
+	}
+	return criteria, nil
+}
+
+// parseOr parses a term, and the terms that OR joins to it.
+func (p *queryParser) parseOr() (queryOperand, error) {
+	first, err := p.parseUnary()
+	if err != nil || p.peek() != "OR" {
+		return first, err
+	}
+
+	operands := []queryOperand{first}
+	for p.peek() == "OR" {
+		p.pos++
+		if p.pos == len(p.tokens) || p.peek() == ")" || p.peek() == "OR" {
+			return queryOperand{}, fmt.Errorf("OR needs a term on both sides")
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return queryOperand{}, err
+		}
+		operands = append(operands, operand)
+	}
+	for _, operand := range operands {
+		if operand.folder {
+			return queryOperand{}, fmt.Errorf("folder: and label: can't be negated, combined with OR, or used in a group")
+		}
+	}
+
+	// IMAP's OR takes two keys, so "a OR b OR c" becomes "OR a (OR b c)"
+	or := operands[len(operands)-1].toCriteria()
+	for i := len(operands) - 2; i >= 0; i-- {
+		next := imap.NewSearchCriteria()
+		next.Or = [][2]*imap.SearchCriteria{{operands[i].toCriteria(), or}}
+		or = next
+	}
+	return queryOperand{criteria: or}, nil
+}
+
+// parseUnary parses a negated term, a group, a filter, or a plain word.
+func (p *queryParser) parseUnary() (queryOperand, error) {
+	token := p.peek()
+	p.pos++
+
+	switch {
+	case token == "-" && p.peek() == "(", len(token) > 1 && token[0] == '-':
+		if token != "-" {
+			// Parse "-from:boss" like "-" and "from:boss"
+			p.tokens[p.pos-1] = token[1:]
+			p.pos--
+		}
+		p.depth++
+		defer func() { p.depth-- }()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return queryOperand{}, err
+		}
+		negated := imap.NewSearchCriteria()
+		negated.Not = []*imap.SearchCriteria{operand.toCriteria()}
+		return queryOperand{criteria: negated}, nil
+
+	case token == "(":
+		return p.parseGroup()
+
+	case strings.HasSuffix(token, ":") && p.peek() == "(":
+		// from:(alice OR bob) means (from:alice OR from:bob)
+		if err := p.prefixGroup(token); err != nil {
+			return queryOperand{}, err
+		}
+		p.pos++
+		return p.parseGroup()
+	}
+
+	if isPostFilter(token) {
+		return queryOperand{}, fmt.Errorf("%s can't be negated, combined with OR, or used in a group", token)
+	}
+	criteria := imap.NewSearchCriteria()
+	handled, folder, err := parseFilterToken(token, criteria, &p.folderFound)
+	if err != nil {
+		return queryOperand{}, err
+	}
+	if !handled {
+		return queryOperand{word: token}, nil
+	}
+	if strings.HasPrefix(token, "folder:") || strings.HasPrefix(token, "label:") {
+		if p.depth > 0 {
+			return queryOperand{}, fmt.Errorf("folder: and label: can't be negated, combined with OR, or used in a group")
+		}
+		if folder != "" {
+			p.folder = folder
+		}
+		return queryOperand{criteria: criteria, folder: true}, nil
+	}
+	return queryOperand{criteria: criteria}, nil
+}
+
+// parseGroup parses the terms of a group up to its closing parenthesis. The opening one is already parsed.
+func (p *queryParser) parseGroup() (queryOperand, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+
+	criteria, err := p.parseAnd(true)
+	if err != nil {
+		return queryOperand{}, err
+	}
+	if p.peek() != ")" {
+		return queryOperand{}, fmt.Errorf("missing ) to close a (")
+	}
+	p.pos++
+	return queryOperand{criteria: criteria}, nil
+}
+
+// prefixGroup adds the filter prefix to the terms of the group that starts at the current token,
+// so that from:(alice -bob) parses like (from:alice -from:bob).
+func (p *queryParser) prefixGroup(prefix string) error {
+	depth := 0
+	for i := p.pos; i < len(p.tokens); i++ {
+		switch token := p.tokens[i]; {
+		case token == "(":
+			depth++
+		case token == ")":
+			depth--
+			if depth == 0 {
+				return nil
 			}
-			continue
+		case token == "OR", token == "-":
+		case token[0] == '-':
+			p.tokens[i] = "-" + prefix + token[1:]
+		default:
+			p.tokens[i] = prefix + token
 		}
+	}
+	return fmt.Errorf("missing ) to close a (")
+}
 
-		// Plain text - add to text search
-		plainTextParts = append(plainTextParts, token)
+// isPostFilter returns true for the filters that ParseThreadSizeFilter and ParseAttachmentFilter take out of the
+// query, since we filter the results for them. They only work as top-level terms.
+func isPostFilter(token string) bool {
+	lower := strings.ToLower(strings.TrimPrefix(token, "-"))
+	for _, prefix := range []string{"count:", "size:", "has:", "filename:"} {
+		if strings.HasPrefix(lower, prefix) {
+			return true
+		}
 	}
+	return false
+}
 
-	// If we have plain text parts, add them to Text criteria
-	if len(plainTextParts) > 0 {
-		criteria.Text = []string{strings.Join(plainTextParts, " ")}
+// isTopLevelTerm returns true if the token at i is a term of the implicit AND at the top level of the query,
+// as opposed to a term in a group, a negated term, or a term that OR joins to another.
+func isTopLevelTerm(tokens []string, i, depth int) bool {
+	return depth == 0 && tokens[i][0] != '-' &&
+		(i == 0 || tokens[i-1] != "OR") && (i == len(tokens)-1 || (tokens[i+1] != "OR" && tokens[i+1] != "("))
+}
+
+// mergeCriteria adds the conditions of src to dst, so that messages have to match both.
+func mergeCriteria(dst, src *imap.SearchCriteria) {
+	for key, values := range src.Header {
+		for _, value := range values {
+			dst.Header.Add(key, value)
+		}
+	}
+	dst.Text = append(dst.Text, src.Text...)
+	if src.Since.After(dst.Since) {
+		dst.Since = src.Since
+	}
+	if !src.Before.IsZero() && (dst.Before.IsZero() || src.Before.Before(dst.Before)) {
+		dst.Before = src.Before
 	}
+	dst.WithFlags = append(dst.WithFlags, src.WithFlags...)
+	dst.WithoutFlags = append(dst.WithoutFlags, src.WithoutFlags...)
+	dst.Larger = max(dst.Larger, src.Larger)
+	if src.Smaller > 0 && (dst.Smaller == 0 || src.Smaller < dst.Smaller) {
+		dst.Smaller = src.Smaller
+	}
+	dst.Not = append(dst.Not, src.Not...)
+	dst.Or = append(dst.Or, src.Or...)
+}
+
+// hasBooleanOperators returns true if the criteria have negations or ORs, which the cache search can't do.
+func hasBooleanOperators(criteria *imap.SearchCriteria) bool {
+	return len(criteria.Not) > 0 || len(criteria.Or) > 0
+}
 
-	return criteria, folder, nil
+// ParseThreadSizeFilter takes the count: and size: filters out of a query. They filter whole threads, not messages,
+// so IMAP can't search for them, and we filter the results instead.
+// Returns the filter and the rest of the query, for ParseSearchQuery.
+// Supported syntax:
+//   - count:>10 → threads with more than 10 messages. Also <, >=, <=, and count:10 for exactly 10 messages
+//   - size:>5M → threads bigger than 5 MiB in total. Sizes take K, M, and G suffixes, optionally with a B
+func ParseThreadSizeFilter(query string) (db.ThreadSizeFilter, string, error) {
+	var filter db.ThreadSizeFilter
+	var rest []string
+
+	tokens := tokenizeQuery(query)
+	depth := 0
+	for i, token := range tokens {
+		if token == "(" {
+			depth++
+		}
+		lower := strings.ToLower(token)
+		switch {
+		case !isTopLevelTerm(tokens, i, depth):
+			// ParseSearchQuery rejects these if they're filters
+			rest = append(rest, token)
+		case strings.HasPrefix(lower, "count:"):
+			op, value := cutComparison(lower[len("count:"):])
+			count, err := strconv.ParseInt(value, 10, 32)
+			if err != nil || count < 0 {
+				return db.ThreadSizeFilter{}, "", fmt.Errorf("invalid count: value %q, expected a number like >10", value)
+			}
+			minCount, maxCount := comparisonBounds(op, count)
+			filter.MinMessages = tighterBound(filter.MinMessages, toInt(minCount), false)
+			filter.MaxMessages = tighterBound(filter.MaxMessages, toInt(maxCount), true)
+		case strings.HasPrefix(lower, "size:"):
+			op, value := cutComparison(lower[len("size:"):])
+			size, err := parseSize(value)
+			if err != nil {
+				return db.ThreadSizeFilter{}, "", fmt.Errorf("invalid size: value %q, expected a size like >5M", value)
+			}
+			minSize, maxSize := comparisonBounds(op, size)
+			filter.MinBytes = tighterBound(filter.MinBytes, minSize, false)
+			filter.MaxBytes = tighterBound(filter.MaxBytes, maxSize, true)
+		default:
+			rest = append(rest, token)
+		}
+		if token == ")" {
+			depth--
+		}
+	}
+
+	return filter, strings.Join(rest, " "), nil
+}
+
+// ParseAttachmentFilter takes the has:attachment and filename: filters out of a query. IMAP can't search for
+// attachments, so we filter the results with what we cached about the attachments of their messages instead.
+// Returns the filter and the rest of the query, for ParseSearchQuery.
+// Supported syntax:
+//   - has:attachment → threads with a message that has an attachment that isn't inline, like a signature image
+//   - filename:pdf → threads with a message that has an attachment whose filename contains "pdf"
+func ParseAttachmentFilter(query string) (db.AttachmentFilter, string, error) {
+	var filter db.AttachmentFilter
+	var rest []string
+
+	tokens := tokenizeQuery(query)
+	depth := 0
+	for i, token := range tokens {
+		if token == "(" {
+			depth++
+		}
+		lower := strings.ToLower(token)
+		switch {
+		case !isTopLevelTerm(tokens, i, depth):
+			rest = append(rest, token)
+		case strings.HasPrefix(lower, "has:"):
+			if lower != "has:attachment" {
+				return db.AttachmentFilter{}, "", fmt.Errorf("unknown has: value %q, expected attachment", token[len("has:"):])
+			}
+			filter.HasAttachment = true
+		case strings.HasPrefix(lower, "filename:"):
+			value := unquote(token[len("filename:"):])
+			if value == "" {
+				return db.AttachmentFilter{}, "", fmt.Errorf("empty filename: value")
+			}
+			filter.Filenames = append(filter.Filenames, value)
+		default:
+			rest = append(rest, token)
+		}
+		if token == ")" {
+			depth--
+		}
+	}
+
+	return filter, strings.Join(rest, " "), nil
+}
+
+// cutComparison splits ">=10" into ">=" and "10". The operator is "" if there's none.
+func cutComparison(value string) (string, string) {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if rest, ok := strings.CutPrefix(value, op); ok {
+			return op, rest
+		}
+	}
+	return "", value
+}
+
+// comparisonBounds returns the inclusive bounds that "op value" means. Nil bounds don't limit.
+func comparisonBounds(op string, value int64) (*int64, *int64) {
+	switch op {
+	case ">":
+		value++
+		return &value, nil
+	case ">=":
+		return &value, nil
+	case "<":
+		value--
+		return nil, &value
+	case "<=":
+		return nil, &value
+	default:
+		return &value, &value
+	}
+}
+
+// tighterBound returns the tighter of two bounds: the lower one of upper bounds, and the higher one of lower bounds.
+func tighterBound[T int | int64](current, next *T, upper bool) *T {
+	if next == nil {
+		return current
+	}
+	if current == nil || (*next < *current) == upper {
+		return next
+	}
+	return current
+}
+
+// toInt converts a count bound to an int.
+func toInt(value *int64) *int {
+	if value == nil {
+		return nil
+	}
+	converted := int(*value)
+	return &converted
+}
+
+// parseSize parses sizes like "500", "100K", "5M", "5MB", or "1G" into bytes. Units are powers of 1024.
+func parseSize(value string) (int64, error) {
+	value = strings.TrimSuffix(strings.ToUpper(value), "B")
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 || number*float64(multiplier) > math.MaxInt64/2 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(number * float64(multiplier)), nil
 }
 
 // tokenizeQuery splits a query into tokens, respecting quoted strings.
 // Handles quoted strings (e.g., "John Doe") and combines filter prefixes with quoted values
-// (e.g., from:"John Doe" becomes a single token).
+// (e.g., from:"John Doe" becomes a single token). Parentheses outside quotes are tokens of their own.
 func tokenizeQuery(query string) []string {
 	var tokens []string
 	var current strings.Builder
@@ -209,6 +671,12 @@ func tokenizeQuery(query string) []string {
 				tokens = append(tokens, current.String())
 				current.Reset()
 			}
+		} else if (r == '(' || r == ')') && !inQuotes {
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+			tokens = append(tokens, string(r))
 		} else {
 			current.WriteRune(r)
 		}
@@ -224,8 +692,8 @@ func tokenizeQuery(query string) []string {
 	var combinedTokens []string
 	for i := 0; i < len(tokens); i++ {
 		token := tokens[i]
-		// Check if this token ends with : and next token starts with "
-		if strings.HasSuffix(token, ":") && i+1 < len(tokens) && strings.HasPrefix(tokens[i+1], `"`) {
+		// Check if this token ends with : or is a negation, and next token starts with "
+		if (strings.HasSuffix(token, ":") || token == "-") && i+1 < len(tokens) && strings.HasPrefix(tokens[i+1], `"`) {
 			combinedTokens = append(combinedTokens, token+tokens[i+1])
 			i++ // Skip next token as we've combined it
 		} else {
@@ -285,49 +753,80 @@ func parseFolderFromQuery(query string) (string, string) {
 
 // buildThreadMapFromMessages processes IMAP messages and builds a map of threads.
 // Returns a map from stable thread ID to thread, and a map from stable thread ID to latest sent_at time.
-// Messages without Message-ID headers or not found in the database are skipped with warnings.
+// The messages not found in the database get FromServer threads, see serverSearchHit. We don't cache them, since
+// the folder's sync preference decides what we cache. Messages without Message-ID headers are skipped with warnings.
 func (s *Service) buildThreadMapFromMessages(ctx context.Context, userID string, messages []*imap.Message) (map[string]*models.Thread, map[string]*time.Time, error) {
 	threadMap := make(map[string]*models.Thread)
 	threadToLatestSentAt := make(map[string]*time.Time)
 
 	for _, imapMsg := range messages {
 		if imapMsg.Envelope == nil || len(imapMsg.Envelope.MessageId) == 0 {
-			log.Printf("Warning: Message UID %d has no Message-ID, skipping", imapMsg.Uid)
+			slog.WarnContext(ctx, "Message has no Message-ID, skipping", "uid", imapMsg.Uid)
 			continue
 		}
 
 		messageID := imapMsg.Envelope.MessageId
 
 		msg, err := db.GetMessageByMessageID(ctx, s.dbPool, userID, messageID)
-		if err != nil {
-			if errors.Is(err, db.ErrMessageNotFound) {
-				log.Printf("Warning: Message with Message-ID %s not found in DB, skipping", messageID)
-				continue
+		if errors.Is(err, db.ErrMessageNotFound) {
+			// Like a message beyond the folder's sync depth
+			thread, sentAt, err := s.serverSearchHit(ctx, userID, imapMsg)
+			if err != nil {
+				return nil, nil, err
 			}
+			addSearchHit(threadMap, threadToLatestSentAt, thread, sentAt)
+			continue
+		}
+		if err != nil {
 			return nil, nil, fmt.Errorf("failed to get message from DB: %w", err)
 		}
 
 		thread, err := db.GetThreadByID(ctx, s.dbPool, msg.ThreadID)
 		if err != nil {
-			log.Printf("Warning: Failed to get thread %s: %v", msg.ThreadID, err)
+			slog.WarnContext(ctx, "Failed to get thread", "thread_id", msg.ThreadID, "error", err)
 			continue
 		}
 
-		if _, exists := threadMap[thread.StableThreadID]; !exists {
-			threadMap[thread.StableThreadID] = thread
-		}
-
-		if msg.SentAt != nil {
-			existingLatest := threadToLatestSentAt[thread.StableThreadID]
-			if existingLatest == nil || msg.SentAt.After(*existingLatest) {
-				threadToLatestSentAt[thread.StableThreadID] = msg.SentAt
-			}
-		}
+		addSearchHit(threadMap, threadToLatestSentAt, thread, msg.SentAt)
 	}
 
 	return threadMap, threadToLatestSentAt, nil
 }
 
+// serverSearchHit returns the FromServer thread of a message that the IMAP server found, but we haven't cached, and the
+// message's date. It's the cached thread that the message starts or replies to, if there's one. Otherwise, it's a
+// thread of the message alone, without an ID, since it's not in the database. The user can't open those.
+func (s *Service) serverSearchHit(ctx context.Context, userID string, imapMsg *imap.Message) (*models.Thread, *time.Time, error) {
+	msg, err := ParseMessage(imapMsg, "", userID, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	thread, err := db.GetThreadByStableID(ctx, s.dbPool, userID, msg.MessageIDHeader)
+	if errors.Is(err, db.ErrThreadNotFound) {
+		thread, err = s.findReferencedThread(ctx, userID, imapMsg)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get thread of message found on the server: %w", err)
+	}
+
+	if thread == nil {
+		thread = &models.Thread{
+			UserID:                  userID,
+			StableThreadID:          msg.MessageIDHeader,
+			Subject:                 msg.Subject,
+			FirstMessageFromAddress: msg.FromAddress,
+			MessageCount:            1,
+			LastSentAt:              msg.SentAt,
+		}
+		if !msg.IsRead {
+			thread.UnreadCount = 1
+		}
+	}
+	thread.FromServer = true
+	return thread, msg.SentAt, nil
+}
+
 // sortAndPaginateThreads sorts threads by latest sent_at (newest first) and applies pagination.
 // Threads without sent_at are sorted to the end. Returns the paginated threads and total count.
 func sortAndPaginateThreads(threadMap map[string]*models.Thread, threadToLatestSentAt map[string]*time.Time, page, limit int) ([]*models.Thread, int) {
@@ -364,13 +863,25 @@ func sortAndPaginateThreads(threadMap map[string]*models.Thread, threadToLatestS
 }
 
 // Search searches for threads matching the query in the specified folder.
-// Supports Gmail-like syntax via ParseSearchQuery (from:, to:, subject:, after:, before:, folder:, label:).
+// Supports Gmail-like syntax via ParseSearchQuery (from:, to:, subject:, after:, before:, folder:, label:),
+// and count: and size: via ParseThreadSizeFilter.
 // If no folder is specified in the query, defaults to INBOX.
+// If we have a fresh cache of the folder with all bodies, it only searches the cache.
+// Otherwise, it searches the IMAP server and the cache, and merges the results.
 // Returns threads sorted by latest sent_at (newest first), total count, and error.
 // Note: Error handling tests for getClientAndSelectFolder, UidSearch, and FetchMessageHeaders
 // require complex IMAP server mocking and are covered through integration tests.
 func (s *Service) Search(ctx context.Context, userID string, query string, page, limit int) ([]*models.Thread, int, error) {
+	ctx = logging.WithUserID(ctx, userID)
 	// Parse the query using Gmail-like syntax
+	sizeFilter, query, err := ParseThreadSizeFilter(query)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrInvalidSearchQuery, err)
+	}
+	attachmentFilter, query, err := ParseAttachmentFilter(query)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrInvalidSearchQuery, err)
+	}
 	criteria, extractedFolder, err := ParseSearchQuery(query)
 	if err != nil {
 		return nil, 0, fmt.Errorf("%w: %v", ErrInvalidSearchQuery, err)
@@ -382,18 +893,68 @@ func (s *Service) Search(ctx context.Context, userID string, query string, page,
 		folder = "INBOX"
 	}
 
-	var threads []*models.Thread
-	var totalCount int
+	threadMap := make(map[string]*models.Thread)
+	threadToLatestSentAt := make(map[string]*time.Time)
+
+	// The cache search can't negate or OR conditions, so only the IMAP server searches for those
+	cacheOnly := false
+	if !hasBooleanOperators(criteria) {
+		hits, err := db.SearchMessages(ctx, s.dbPool, userID, searchParamsFromCriteria(criteria, folder))
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, hit := range hits {
+			addSearchHit(threadMap, threadToLatestSentAt, hit.Thread, hit.LatestSentAt)
+		}
+
+		if cacheOnly, err = s.canSearchCacheOnly(ctx, userID, folder); err != nil {
+			return nil, 0, err
+		}
+	}
+	if !cacheOnly {
+		if err := s.searchIMAP(ctx, userID, folder, criteria, threadMap, threadToLatestSentAt); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if !sizeFilter.IsZero() {
+		if err := s.filterThreadsBySize(ctx, userID, threadMap, sizeFilter); err != nil {
+			return nil, 0, err
+		}
+	}
+	if !attachmentFilter.IsZero() {
+		if err := s.filterThreadsByAttachments(ctx, userID, folder, threadMap, attachmentFilter); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	threads, totalCount := sortAndPaginateThreads(threadMap, threadToLatestSentAt, page, limit)
+
+	// Enrich threads with first message's from_address for display
+	if err := db.EnrichThreadsWithFirstMessageFromAddress(ctx, s.dbPool, threads); err != nil {
+		slog.WarnContext(ctx, "Failed to enrich threads with first message from address", "error", err)
+		// Continue anyway - threads will work without the from_address
+	}
+
+	// Enrich threads with preview snippet and attachment info
+	if err := db.EnrichThreadsWithPreviewAndAttachments(ctx, s.dbPool, threads); err != nil {
+		slog.WarnContext(ctx, "Failed to enrich threads with preview and attachment info", "error", err)
+		// Continue anyway - threads will work without these fields
+	}
+
+	return threads, totalCount, nil
+}
 
-	err = s.withClientAndSelectFolder(ctx, userID, folder, func(client *imapclient.Client, _ *imap.MailboxStatus) error {
+// searchIMAP runs the search on the IMAP server, and adds the threads of the matching messages to threadMap.
+// The messages that we haven't cached get FromServer threads, see buildThreadMapFromMessages.
+func (s *Service) searchIMAP(ctx context.Context, userID, folder string, criteria *imap.SearchCriteria, threadMap map[string]*models.Thread, threadToLatestSentAt map[string]*time.Time) error {
+	search := func(client *imapclient.Client, _ *imap.MailboxStatus) error {
 		uids, err := client.UidSearch(criteria)
 		if err != nil {
 			return fmt.Errorf("failed to search IMAP: %w", err)
 		}
 
 		if len(uids) == 0 {
-			threads = nil
-			totalCount = 0
 			return nil
 		}
 
@@ -402,31 +963,212 @@ func (s *Service) Search(ctx context.Context, userID string, query string, page,
 			return fmt.Errorf("failed to fetch message headers: %w", err)
 		}
 
-		threadMap, threadToLatestSentAt, err := s.buildThreadMapFromMessages(ctx, userID, messages)
+		imapThreadMap, imapThreadToLatestSentAt, err := s.buildThreadMapFromMessages(ctx, userID, messages)
 		if err != nil {
 			return err
 		}
+		for stableThreadID, thread := range imapThreadMap {
+			addSearchHit(threadMap, threadToLatestSentAt, thread, imapThreadToLatestSentAt[stableThreadID])
+		}
+
+		return nil
+	}
+
+	// A failed attempt can only have added some of the hits, and adding them again is harmless
+	err := s.retry(ctx, "search", func() error {
+		return s.withClientAndSelectFolder(ctx, userID, folder, search)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get IMAP client: %w", err)
+	}
 
-		threads, totalCount = sortAndPaginateThreads(threadMap, threadToLatestSentAt, page, limit)
+	return nil
+}
+
+// filterThreadsBySize removes the threads that don't pass the count: and size: filters from threadMap.
+// We only know the sizes of cached threads, so the others don't pass.
+func (s *Service) filterThreadsBySize(ctx context.Context, userID string, threadMap map[string]*models.Thread, filter db.ThreadSizeFilter) error {
+	threadIDs := cachedThreadIDs(threadMap)
 
-		// Enrich threads with first message's from_address for display
-		if err := db.EnrichThreadsWithFirstMessageFromAddress(ctx, s.dbPool, threads); err != nil {
-			log.Printf("Warning: Failed to enrich threads with first message from address: %v", err)
-			// Continue anyway - threads will work without the from_address
+	passed, err := db.FilterThreadsBySize(ctx, s.dbPool, userID, threadIDs, filter)
+	if err != nil {
+		return err
+	}
+	for stableThreadID, thread := range threadMap {
+		if !passed[thread.ID] {
+			delete(threadMap, stableThreadID)
 		}
+	}
+	return nil
+}
+
+// filterThreadsByAttachments removes the threads that don't pass the has:attachment and filename: filters
+// from threadMap. Only the messages in the folder count. The cache only has the attachments of the messages whose
+// bodies we fetched, so the threads that don't pass with the cache, but have messages without bodies, get checked
+// with the BODYSTRUCTUREs of their messages on the server. Threads that aren't cached at all don't pass.
+func (s *Service) filterThreadsByAttachments(ctx context.Context, userID, folder string, threadMap map[string]*models.Thread, filter db.AttachmentFilter) error {
+	threadIDs := cachedThreadIDs(threadMap)
 
-		// Enrich threads with preview snippet and attachment info
-		if err := db.EnrichThreadsWithPreviewAndAttachments(ctx, s.dbPool, threads); err != nil {
-			log.Printf("Warning: Failed to enrich threads with preview and attachment info: %v", err)
-			// Continue anyway - threads will work without these fields
+	passed, err := db.FilterThreadsByAttachments(ctx, s.dbPool, userID, folder, threadIDs, filter)
+	if err != nil {
+		return err
+	}
+
+	failedIDs := slices.DeleteFunc(threadIDs, func(id string) bool { return passed[id] })
+	if len(failedIDs) > 0 {
+		uids, err := db.GetUIDsOfThreadsWithoutBodies(ctx, s.dbPool, userID, folder, failedIDs)
+		if err != nil {
+			return err
+		}
+		if len(uids) > 0 {
+			serverPassed, err := s.filterThreadsByBodyStructures(ctx, userID, folder, uids, filter)
+			if err != nil {
+				return err
+			}
+			maps.Copy(passed, serverPassed)
 		}
+	}
 
+	for stableThreadID, thread := range threadMap {
+		if !passed[thread.ID] {
+			delete(threadMap, stableThreadID)
+		}
+	}
+	return nil
+}
+
+// filterThreadsByBodyStructures fetches the BODYSTRUCTUREs of the messages with the UIDs in the folder, and returns
+// the IDs of the threads whose messages pass the filter together. uids maps the UIDs to their thread IDs.
+func (s *Service) filterThreadsByBodyStructures(ctx context.Context, userID, folder string, uids map[uint32]string, filter db.AttachmentFilter) (map[string]bool, error) {
+	structures := make(map[string][]*imap.BodyStructure)
+	fetch := func(client *imapclient.Client, _ *imap.MailboxStatus) error {
+		clear(structures)
+		seqSet := new(imap.SeqSet)
+		for uid := range uids {
+			seqSet.AddNum(uid)
+		}
+
+		messages := make(chan *imap.Message, streamBufferSize)
+		done := make(chan error, 1)
+		go func() {
+			done <- client.UidFetch(seqSet, []imap.FetchItem{imap.FetchUid, imap.FetchBodyStructure}, messages)
+		}()
+		for msg := range messages {
+			if threadID, ok := uids[msg.Uid]; ok && msg.BodyStructure != nil {
+				structures[threadID] = append(structures[threadID], msg.BodyStructure)
+			}
+		}
+		if err := <-done; err != nil {
+			return fmt.Errorf("failed to fetch body structures: %w", err)
+		}
 		return nil
+	}
+
+	err := s.retry(ctx, "search", func() error {
+		return s.withClientAndSelectFolder(ctx, userID, folder, fetch)
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	passed := make(map[string]bool)
+	for threadID, bodyStructures := range structures {
+		if attachmentFilterMatches(bodyStructures, filter) {
+			passed[threadID] = true
+		}
+	}
+	return passed, nil
+}
+
+// attachmentFilterMatches returns true if the messages with the body structures pass the filter together, like
+// db.FilterThreadsByAttachments: one of them has an attachment that isn't inline, if the filter needs one, and each
+// filename is in the filename of a part of one of them.
+func attachmentFilterMatches(bodyStructures []*imap.BodyStructure, filter db.AttachmentFilter) bool {
+	if filter.HasAttachment && !slices.ContainsFunc(bodyStructures, hasAttachment) {
+		return false
+	}
+	for _, filename := range filter.Filenames {
+		if !slices.ContainsFunc(bodyStructures, func(bodyStructure *imap.BodyStructure) bool {
+			return hasPartNamed(bodyStructure, filename)
+		}) {
+			return false
+		}
+	}
+	return true
+}
 
+// canSearchCacheOnly returns true if the cache of the folder is fresh and has all messages and bodies,
+// so that searching the IMAP server wouldn't find anything more. See db.IsFolderFullyCached.
+func (s *Service) canSearchCacheOnly(ctx context.Context, userID, folder string) (bool, error) {
+	shouldSync, err := s.ShouldSyncFolder(ctx, userID, folder)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to get IMAP client: %w", err)
+		return false, err
+	}
+	if shouldSync {
+		return false, nil
 	}
+	return db.IsFolderFullyCached(ctx, s.dbPool, userID, folder)
+}
 
-	return threads, totalCount, nil
+// cachedThreadIDs returns the IDs of the threads in threadMap that are in the database.
+// The others are the ones that serverSearchHit makes for uncached messages.
+func cachedThreadIDs(threadMap map[string]*models.Thread) []string {
+	threadIDs := make([]string, 0, len(threadMap))
+	for _, thread := range threadMap {
+		if thread.ID != "" {
+			threadIDs = append(threadIDs, thread.ID)
+		}
+	}
+	return threadIDs
+}
+
+// addSearchHit adds a thread to the search results, keeping the latest sent_at of its matching messages.
+// The thread keeps FromServer if any of its hits had it.
+func addSearchHit(threadMap map[string]*models.Thread, threadToLatestSentAt map[string]*time.Time, thread *models.Thread, sentAt *time.Time) {
+	if _, exists := threadMap[thread.StableThreadID]; !exists {
+		threadMap[thread.StableThreadID] = thread
+	}
+	if thread.FromServer {
+		threadMap[thread.StableThreadID].FromServer = true
+	}
+
+	if sentAt != nil {
+		existingLatest := threadToLatestSentAt[thread.StableThreadID]
+		if existingLatest == nil || sentAt.After(*existingLatest) {
+			threadToLatestSentAt[thread.StableThreadID] = sentAt
+		}
+	}
+}
+
+// searchParamsFromCriteria converts parsed IMAP search criteria to the params of a cache search.
+func searchParamsFromCriteria(criteria *imap.SearchCriteria, folder string) db.MessageSearchParams {
+	params := db.MessageSearchParams{
+		FolderName: folder,
+		Text:       strings.Join(criteria.Text, " "),
+		From:       criteria.Header.Values("From"),
+		To:         criteria.Header.Values("To"),
+		Subject:    criteria.Header.Values("Subject"),
+	}
+	if !criteria.Since.IsZero() {
+		params.Since = &criteria.Since
+	}
+	if !criteria.Before.IsZero() {
+		params.Before = &criteria.Before
+	}
+	isRead, isUnread := slices.Contains(criteria.WithFlags, imap.SeenFlag), slices.Contains(criteria.WithoutFlags, imap.SeenFlag)
+	if isRead || isUnread {
+		params.IsRead = &isRead
+	}
+	if isStarred := slices.Contains(criteria.WithFlags, imap.FlaggedFlag); isStarred {
+		params.IsStarred = &isStarred
+	}
+	if criteria.Larger > 0 {
+		larger := int64(criteria.Larger)
+		params.LargerThan = &larger
+	}
+	if criteria.Smaller > 0 {
+		smaller := int64(criteria.Smaller)
+		params.SmallerThan = &smaller
+	}
+	return params
 }
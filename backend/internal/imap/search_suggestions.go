@@ -0,0 +1,44 @@
+package imap
+
+import (
+	"strings"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// searchOperators are the operators that ParseSearchQuery, ParseThreadSizeFilter, and ParseAttachmentFilter
+// understand, in the order the search box suggests them.
+var searchOperators = []models.SearchSuggestion{
+	{Operator: "from:", Example: "from:george", Description: "Messages from a sender"},
+	{Operator: "to:", Example: "to:alice", Description: "Messages to a recipient"},
+	{Operator: "subject:", Example: "subject:meeting", Description: "Messages with words in the subject"},
+	{Operator: "after:", Example: "after:2025-01-01", Description: "Messages sent after a date"},
+	{Operator: "before:", Example: "before:2025-12-31", Description: "Messages sent before a date"},
+	{Operator: "is:", Example: "is:unread", Description: "Messages that are unread, read, or starred"},
+	{Operator: "has:", Example: "has:attachment", Description: "Messages with attachments"},
+	{Operator: "filename:", Example: "filename:pdf", Description: "Messages with an attachment whose name contains this"},
+	{Operator: "larger:", Example: "larger:5M", Description: "Messages bigger than this, in K, M, or G"},
+	{Operator: "smaller:", Example: "smaller:100K", Description: "Messages smaller than this, in K, M, or G"},
+	{Operator: "folder:", Example: "folder:Archive", Description: "Search in a folder instead of INBOX"},
+	{Operator: "label:", Example: "label:Sent", Description: "Same as folder:"},
+	{Operator: "count:", Example: "count:>10", Description: "Threads with more, fewer, or exactly this many messages"},
+	{Operator: "size:", Example: "size:>5M", Description: "Threads bigger or smaller than this in total, in K, M, or G"},
+}
+
+// SuggestSearchOperators returns the operators that the last word of the query could be the start of.
+// If the query is empty or ends with a space, it returns all of them.
+func SuggestSearchOperators(query string) []models.SearchSuggestion {
+	word := ""
+	if fields := strings.Fields(query); len(fields) > 0 && !strings.HasSuffix(query, " ") {
+		word = strings.ToLower(fields[len(fields)-1])
+	}
+
+	suggestions := []models.SearchSuggestion{}
+	for _, operator := range searchOperators {
+		// "cou" suggests count:, and so does "count:>", so that the user sees the example while typing the value
+		if strings.HasPrefix(operator.Operator, word) || strings.HasPrefix(word, operator.Operator) {
+			suggestions = append(suggestions, operator)
+		}
+	}
+	return suggestions
+}
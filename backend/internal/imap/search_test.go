@@ -3,6 +3,8 @@ package imap
 import (
 	"context"
 	"encoding/base64"
+	"errors"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -221,6 +223,40 @@ func TestParseSearchQuery(t *testing.T) {
 		}
 	})
 
+	t.Run("parses is: filters", func(t *testing.T) {
+		criteria, _, err := ParseSearchQuery("is:unread is:Starred")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(criteria.WithoutFlags) != 1 || criteria.WithoutFlags[0] != imap.SeenFlag {
+			t.Errorf("Expected to search without the \\Seen flag, got %v", criteria.WithoutFlags)
+		}
+		if len(criteria.WithFlags) != 1 || criteria.WithFlags[0] != imap.FlaggedFlag {
+			t.Errorf("Expected to search with the \\Flagged flag, got %v", criteria.WithFlags)
+		}
+	})
+
+	t.Run("parses larger: and smaller: filters", func(t *testing.T) {
+		criteria, _, err := ParseSearchQuery("larger:1.5K smaller:5MB cabbage")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if criteria.Larger != 1536 || criteria.Smaller != 5<<20 {
+			t.Errorf("Expected larger than 1536 and smaller than %d bytes, got %d and %d", 5<<20, criteria.Larger, criteria.Smaller)
+		}
+		if len(criteria.Text) != 1 || criteria.Text[0] != "cabbage" {
+			t.Errorf("Expected text 'cabbage', got %v", criteria.Text)
+		}
+	})
+
+	t.Run("returns error for bad is:, larger:, and smaller: values", func(t *testing.T) {
+		for _, query := range []string{"is:important", "larger:huge", "smaller:0", "larger:5G"} {
+			if _, _, err := ParseSearchQuery(query); err == nil {
+				t.Errorf("Expected an error for %q", query)
+			}
+		}
+	})
+
 	t.Run("folder: takes precedence over label:", func(t *testing.T) {
 		_, folder, err := ParseSearchQuery("folder:Inbox label:Sent")
 		if err != nil {
@@ -230,6 +266,246 @@ func TestParseSearchQuery(t *testing.T) {
 			t.Errorf("Expected folder 'Inbox' (folder: takes precedence), got '%s'", folder)
 		}
 	})
+
+	t.Run("negates filters and words", func(t *testing.T) {
+		criteria, _, err := ParseSearchQuery("-from:boss cabbage -spam")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(criteria.Not) != 2 || criteria.Not[0].Header.Get("From") != "boss" || !slices.Equal(criteria.Not[1].Text, []string{"spam"}) {
+			t.Errorf("Expected to negate from:boss and spam, got %+v", criteria.Not)
+		}
+		if criteria.Header.Get("From") != "" || !slices.Equal(criteria.Text, []string{"cabbage"}) {
+			t.Errorf("Expected only the text 'cabbage' outside the negations, got %+v", criteria)
+		}
+	})
+
+	t.Run("parses OR chains into nested pairs", func(t *testing.T) {
+		criteria, _, err := ParseSearchQuery("from:alice OR from:bob OR to:carol cabbage")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(criteria.Or) != 1 || !slices.Equal(criteria.Text, []string{"cabbage"}) {
+			t.Fatalf("Expected one OR and the text 'cabbage', got %+v", criteria)
+		}
+		first, rest := criteria.Or[0][0], criteria.Or[0][1]
+		if first.Header.Get("From") != "alice" || len(rest.Or) != 1 ||
+			rest.Or[0][0].Header.Get("From") != "bob" || rest.Or[0][1].Header.Get("To") != "carol" {
+			t.Errorf("Expected OR from:alice (OR from:bob to:carol), got %+v and %+v", first, rest)
+		}
+	})
+
+	t.Run("applies a filter to each term of its group", func(t *testing.T) {
+		criteria, _, err := ParseSearchQuery(`from:(alice OR "Bob Smith")`)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(criteria.Or) != 1 || criteria.Or[0][0].Header.Get("From") != "alice" || criteria.Or[0][1].Header.Get("From") != "Bob Smith" {
+			t.Errorf("Expected OR from:alice from:\"Bob Smith\", got %+v", criteria)
+		}
+	})
+
+	t.Run("negates groups", func(t *testing.T) {
+		criteria, _, err := ParseSearchQuery("-(from:boss subject:budget) is:unread")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(criteria.Not) != 1 || criteria.Not[0].Header.Get("From") != "boss" || criteria.Not[0].Header.Get("Subject") != "budget" {
+			t.Errorf("Expected to negate from:boss and subject:budget together, got %+v", criteria.Not)
+		}
+		if len(criteria.WithoutFlags) != 1 {
+			t.Errorf("Expected is:unread outside the negation, got %+v", criteria)
+		}
+	})
+
+	t.Run("returns error for bad groups and ORs", func(t *testing.T) {
+		for _, query := range []string{"(from:alice", "from:alice)", "from:(alice", "OR cabbage", "cabbage OR", "a OR OR b",
+			"-folder:Sent", "folder:Sent OR from:alice", "(label:Sent)", "-size:>5M", "count:>2 OR cabbage", "-has:attachment"} {
+			if _, _, err := ParseSearchQuery(query); err == nil {
+				t.Errorf("Expected an error for %q", query)
+			}
+		}
+	})
+}
+
+func TestParseThreadSizeFilter(t *testing.T) {
+	intPtr := func(v int) *int { return &v }
+	int64Ptr := func(v int64) *int64 { return &v }
+	equal := func(a, b *int64) bool { return (a == nil && b == nil) || (a != nil && b != nil && *a == *b) }
+
+	tests := []struct {
+		name      string
+		query     string
+		want      db.ThreadSizeFilter
+		wantQuery string
+	}{
+		{"more than", "count:>10", db.ThreadSizeFilter{MinMessages: intPtr(11)}, ""},
+		{"at most", "count:<=3", db.ThreadSizeFilter{MaxMessages: intPtr(3)}, ""},
+		{"exactly", "count:5", db.ThreadSizeFilter{MinMessages: intPtr(5), MaxMessages: intPtr(5)}, ""},
+		{"bigger than megabytes", "size:>5M", db.ThreadSizeFilter{MinBytes: int64Ptr(5<<20 + 1)}, ""},
+		{"smaller than kilobytes with B", "size:<100kb", db.ThreadSizeFilter{MaxBytes: int64Ptr(100<<10 - 1)}, ""},
+		{"fractional sizes", "size:>=1.5G", db.ThreadSizeFilter{MinBytes: int64Ptr(3 << 29)}, ""},
+		{"keeps the tighter bound", "count:>10 count:>20 count:<50 count:<40", db.ThreadSizeFilter{MinMessages: intPtr(21), MaxMessages: intPtr(39)}, ""},
+		{"keeps the rest of the query", `from:"John Doe" count:>10 cabbage`, db.ThreadSizeFilter{MinMessages: intPtr(11)}, `from:"John Doe" cabbage`},
+		{"no filters", "cabbage", db.ThreadSizeFilter{}, "cabbage"},
+		{"leaves negated and grouped filters", "-count:>10 (size:>5M OR cabbage)", db.ThreadSizeFilter{}, "-count:>10 ( size:>5M OR cabbage )"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, rest, err := ParseThreadSizeFilter(tt.query)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			toInt64 := func(v *int) *int64 {
+				if v == nil {
+					return nil
+				}
+				return int64Ptr(int64(*v))
+			}
+			if !equal(toInt64(filter.MinMessages), toInt64(tt.want.MinMessages)) || !equal(toInt64(filter.MaxMessages), toInt64(tt.want.MaxMessages)) ||
+				!equal(filter.MinBytes, tt.want.MinBytes) || !equal(filter.MaxBytes, tt.want.MaxBytes) {
+				t.Errorf("Unexpected filter for %q: %+v", tt.query, filter)
+			}
+			if rest != tt.wantQuery {
+				t.Errorf("Expected the rest of the query to be %q, got %q", tt.wantQuery, rest)
+			}
+		})
+	}
+
+	t.Run("rejects bad values", func(t *testing.T) {
+		for _, query := range []string{"count:", "count:>many", "count:-1", "size:>5X", "size:<"} {
+			if _, _, err := ParseThreadSizeFilter(query); err == nil {
+				t.Errorf("Expected an error for %q", query)
+			}
+		}
+	})
+}
+
+func TestParseAttachmentFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		want      db.AttachmentFilter
+		wantQuery string
+	}{
+		{"has attachment", "has:attachment", db.AttachmentFilter{HasAttachment: true}, ""},
+		{"case-insensitive operator", "HAS:Attachment", db.AttachmentFilter{HasAttachment: true}, ""},
+		{"filenames", `filename:pdf filename:"tax return"`, db.AttachmentFilter{Filenames: []string{"pdf", "tax return"}}, ""},
+		{"keeps the rest of the query", "from:george has:attachment cabbage", db.AttachmentFilter{HasAttachment: true}, "from:george cabbage"},
+		{"no filters", "cabbage", db.AttachmentFilter{}, "cabbage"},
+		{"leaves filters in ORs", "has:attachment OR filename:pdf", db.AttachmentFilter{}, "has:attachment OR filename:pdf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, rest, err := ParseAttachmentFilter(tt.query)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if filter.HasAttachment != tt.want.HasAttachment || !slices.Equal(filter.Filenames, tt.want.Filenames) {
+				t.Errorf("Unexpected filter for %q: %+v", tt.query, filter)
+			}
+			if rest != tt.wantQuery {
+				t.Errorf("Expected the rest of the query to be %q, got %q", tt.wantQuery, rest)
+			}
+		})
+	}
+
+	t.Run("rejects bad values", func(t *testing.T) {
+		for _, query := range []string{"has:pictures", "filename:\"\""} {
+			if _, _, err := ParseAttachmentFilter(query); err == nil {
+				t.Errorf("Expected an error for %q", query)
+			}
+		}
+	})
+}
+
+func TestSuggestSearchOperators(t *testing.T) {
+	operators := func(suggestions []models.SearchSuggestion) string {
+		names := make([]string, len(suggestions))
+		for i, suggestion := range suggestions {
+			names[i] = suggestion.Operator
+		}
+		return strings.Join(names, " ")
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"co", "count:"},
+		{"cabbage s", "subject: smaller: size:"},
+		{"is:unr", "is:"},
+		{"size:>5", "size:"},
+		{"cabbage", ""},
+	}
+	for _, tt := range tests {
+		if got := operators(SuggestSearchOperators(tt.query)); got != tt.want {
+			t.Errorf("Expected %q to suggest %q, got %q", tt.query, tt.want, got)
+		}
+	}
+
+	if got := SuggestSearchOperators("from:george "); len(got) != len(searchOperators) {
+		t.Errorf("Expected all operators after a space, got %d", len(got))
+	}
+}
+
+func TestSearchParamsFromCriteria(t *testing.T) {
+	t.Run("converts filters and text", func(t *testing.T) {
+		criteria, folder, err := ParseSearchQuery(`from:george to:alice subject:"garden plans" after:2025-01-01 before:2025-12-31 cabbage carrots`)
+		if err != nil {
+			t.Fatalf("ParseSearchQuery failed: %v", err)
+		}
+		params := searchParamsFromCriteria(criteria, "INBOX")
+
+		if params.FolderName != "INBOX" || folder != "" {
+			t.Errorf("Expected folder INBOX, got %q", params.FolderName)
+		}
+		if params.Text != "cabbage carrots" {
+			t.Errorf("Expected text 'cabbage carrots', got %q", params.Text)
+		}
+		if len(params.From) != 1 || params.From[0] != "george" || len(params.To) != 1 || params.To[0] != "alice" {
+			t.Errorf("Expected from george and to alice, got %v and %v", params.From, params.To)
+		}
+		if len(params.Subject) != 1 || params.Subject[0] != "garden plans" {
+			t.Errorf("Expected subject 'garden plans', got %v", params.Subject)
+		}
+		if params.Since == nil || params.Since.Format("2006-01-02") != "2025-01-01" {
+			t.Errorf("Expected since 2025-01-01, got %v", params.Since)
+		}
+		if params.Before == nil || params.Before.Format("2006-01-02") != "2025-12-31" {
+			t.Errorf("Expected before 2025-12-31, got %v", params.Before)
+		}
+	})
+
+	t.Run("leaves out missing filters", func(t *testing.T) {
+		criteria, _, err := ParseSearchQuery("")
+		if err != nil {
+			t.Fatalf("ParseSearchQuery failed: %v", err)
+		}
+		params := searchParamsFromCriteria(criteria, "Archive")
+
+		if params.Text != "" || len(params.From) != 0 || params.Since != nil || params.Before != nil ||
+			params.IsRead != nil || params.IsStarred != nil || params.LargerThan != nil || params.SmallerThan != nil {
+			t.Errorf("Expected no conditions, got %+v", params)
+		}
+	})
+
+	t.Run("converts flag and size filters", func(t *testing.T) {
+		criteria, _, err := ParseSearchQuery("is:unread is:starred larger:1K smaller:2K")
+		if err != nil {
+			t.Fatalf("ParseSearchQuery failed: %v", err)
+		}
+		params := searchParamsFromCriteria(criteria, "INBOX")
+
+		if params.IsRead == nil || *params.IsRead || params.IsStarred == nil || !*params.IsStarred {
+			t.Errorf("Expected unread and starred, got %v and %v", params.IsRead, params.IsStarred)
+		}
+		if params.LargerThan == nil || *params.LargerThan != 1024 || params.SmallerThan == nil || *params.SmallerThan != 2048 {
+			t.Errorf("Expected between 1024 and 2048 bytes, got %v and %v", params.LargerThan, params.SmallerThan)
+		}
+	})
 }
 
 func TestSortAndPaginateThreads(t *testing.T) {
@@ -301,7 +577,71 @@ func TestSortAndPaginateThreads(t *testing.T) {
 	})
 }
 
+func TestAddSearchHit(t *testing.T) {
+	threadMap := make(map[string]*models.Thread)
+	sentAtMap := make(map[string]*time.Time)
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	addSearchHit(threadMap, sentAtMap, &models.Thread{StableThreadID: "thread-1"}, &older)
+	addSearchHit(threadMap, sentAtMap, &models.Thread{StableThreadID: "thread-1", FromServer: true}, &newer)
+	addSearchHit(threadMap, sentAtMap, &models.Thread{StableThreadID: "thread-1"}, nil)
+
+	if len(threadMap) != 1 {
+		t.Fatalf("Expected 1 thread, got %d", len(threadMap))
+	}
+	if !threadMap["thread-1"].FromServer {
+		t.Error("Expected the thread to keep FromServer from its second hit")
+	}
+	if latest := sentAtMap["thread-1"]; latest == nil || !latest.Equal(newer) {
+		t.Errorf("Expected latest sent_at %v, got %v", newer, latest)
+	}
+}
+
+func TestAttachmentFilterMatches(t *testing.T) {
+	report := &imap.BodyStructure{MIMEType: "multipart", MIMESubType: "mixed", Parts: []*imap.BodyStructure{
+		{MIMEType: "text", MIMESubType: "plain"},
+		{MIMEType: "application", MIMESubType: "pdf", Disposition: "attachment", DispositionParams: map[string]string{"filename": "Q3 Report.PDF"}},
+	}}
+	signature := &imap.BodyStructure{MIMEType: "multipart", MIMESubType: "related", Parts: []*imap.BodyStructure{
+		{MIMEType: "text", MIMESubType: "html"},
+		{MIMEType: "image", MIMESubType: "png", Disposition: "inline", DispositionParams: map[string]string{"filename": "logo.png"}},
+	}}
+	plain := &imap.BodyStructure{MIMEType: "text", MIMESubType: "plain"}
+
+	testCases := []struct {
+		name           string
+		bodyStructures []*imap.BodyStructure
+		filter         db.AttachmentFilter
+		want           bool
+	}{
+		{"finds an attachment", []*imap.BodyStructure{plain, report}, db.AttachmentFilter{HasAttachment: true}, true},
+		{"skips inline parts", []*imap.BodyStructure{signature}, db.AttachmentFilter{HasAttachment: true}, false},
+		{"matches filenames case-insensitively", []*imap.BodyStructure{report}, db.AttachmentFilter{Filenames: []string{"report.pdf"}}, true},
+		{"matches inline parts by filename", []*imap.BodyStructure{signature}, db.AttachmentFilter{Filenames: []string{"logo"}}, true},
+		{"matches filenames across messages", []*imap.BodyStructure{report, signature}, db.AttachmentFilter{Filenames: []string{"report", "logo"}}, true},
+		{"needs every filename", []*imap.BodyStructure{report}, db.AttachmentFilter{Filenames: []string{"report", "logo"}}, false},
+		{"finds nothing without attachments", []*imap.BodyStructure{plain}, db.AttachmentFilter{HasAttachment: true, Filenames: []string{"report"}}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := attachmentFilterMatches(tc.bodyStructures, tc.filter); got != tc.want {
+				t.Errorf("Expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
 func TestTokenizeQuery(t *testing.T) {
+	t.Run("splits parentheses outside quotes", func(t *testing.T) {
+		tokens := tokenizeQuery(`-(from:(alice OR bob) subject:"(draft)")`)
+		want := []string{"-", "(", "from:", "(", "alice", "OR", "bob", ")", `subject:"(draft)"`, ")"}
+		if !slices.Equal(tokens, want) {
+			t.Errorf("Expected %v, got %v", want, tokens)
+		}
+	})
+
 	t.Run("handles unclosed quotes", func(t *testing.T) {
 		// Unclosed quote should treat the rest as part of the token
 		tokens := tokenizeQuery(`from:"John Doe`)
@@ -403,7 +743,7 @@ func TestService_buildThreadMapFromMessages(t *testing.T) {
 	defer pool.Close()
 
 	encryptor := getTestEncryptorForSearch(t)
-	service := NewService(pool, NewPool(), encryptor)
+	service := NewService(pool, NewPool(), encryptor, nil)
 	defer service.Close()
 
 	ctx := context.Background()
@@ -459,11 +799,16 @@ func TestService_buildThreadMapFromMessages(t *testing.T) {
 			t.Fatalf("Failed to save message: %v", err)
 		}
 
-		// Delete the thread to simulate GetThreadByID returning an error
-		_, err := pool.Exec(ctx, "DELETE FROM threads WHERE id = $1", thread.ID)
-		if err != nil {
-			t.Fatalf("Failed to delete thread: %v", err)
+		// Hide the threads table, so that the message is found, but GetThreadByID returns an error.
+		// Deleting the thread wouldn't do, since that deletes its messages too.
+		if _, err := pool.Exec(ctx, "ALTER TABLE threads RENAME TO threads_hidden"); err != nil {
+			t.Fatalf("Failed to hide threads: %v", err)
 		}
+		defer func() {
+			if _, err := pool.Exec(ctx, "ALTER TABLE threads_hidden RENAME TO threads"); err != nil {
+				t.Fatalf("Failed to restore threads: %v", err)
+			}
+		}()
 
 		// Now buildThreadMapFromMessages should skip this message and continue
 		imapMsg := &imap.Message{
@@ -479,31 +824,78 @@ func TestService_buildThreadMapFromMessages(t *testing.T) {
 		}
 		// The thread should not be in the map because GetThreadByID failed
 		if len(threadMap) != 0 {
-			t.Errorf("Expected empty thread map (thread was deleted), got: %v", threadMap)
+			t.Errorf("Expected empty thread map (thread was hidden), got: %v", threadMap)
 		}
 		if len(sentAtMap) != 0 {
 			t.Errorf("Expected empty sentAt map, got: %v", sentAtMap)
 		}
 	})
 
-	t.Run("skips messages not found in database", func(t *testing.T) {
-		// Message that doesn't exist in DB
+	t.Run("marks messages not found in database without caching them", func(t *testing.T) {
+		sentAt := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
 		imapMsg := &imap.Message{
 			Uid: 999,
 			Envelope: &imap.Envelope{
-				MessageId: "<non-existent@example.com>",
+				MessageId: "<pruned@example.com>",
+				Subject:   "Old news",
+				Date:      sentAt,
+				From:      []*imap.Address{{PersonalName: "Old Friend", MailboxName: "old", HostName: "example.com"}},
 			},
 		}
 
 		threadMap, sentAtMap, err := service.buildThreadMapFromMessages(ctx, userID, []*imap.Message{imapMsg})
 		if err != nil {
-			t.Errorf("Expected no error (should skip message not found), got: %v", err)
+			t.Fatalf("buildThreadMapFromMessages failed: %v", err)
 		}
-		if len(threadMap) != 0 {
-			t.Errorf("Expected empty thread map, got: %v", threadMap)
+		thread, ok := threadMap["<pruned@example.com>"]
+		if !ok {
+			t.Fatalf("Expected the thread of the uncached message, got: %v", threadMap)
 		}
-		if len(sentAtMap) != 0 {
-			t.Errorf("Expected empty sentAt map, got: %v", sentAtMap)
+		if !thread.FromServer || thread.ID != "" || thread.Subject != "Old news" || thread.MessageCount != 1 ||
+			thread.UnreadCount != 1 || thread.FirstMessageFromAddress != "Old Friend <old@example.com>" {
+			t.Errorf("Expected a thread from the server, got: %+v", thread)
+		}
+		if latest := sentAtMap["<pruned@example.com>"]; latest == nil || !latest.Equal(sentAt) {
+			t.Errorf("Expected latest sent_at %v, got: %v", sentAt, latest)
+		}
+
+		if _, err := db.GetMessageByMessageID(ctx, pool, userID, "<pruned@example.com>"); !errors.Is(err, db.ErrMessageNotFound) {
+			t.Errorf("Expected the message not to be cached, got: %v", err)
+		}
+	})
+
+	t.Run("marks the cached thread that an uncached message replies to", func(t *testing.T) {
+		thread := &models.Thread{UserID: userID, StableThreadID: "<root@example.com>", Subject: "Plans"}
+		if err := db.SaveThread(ctx, pool, thread); err != nil {
+			t.Fatalf("Failed to save thread: %v", err)
+		}
+		root := &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         2,
+			IMAPFolderName:  "INBOX",
+			MessageIDHeader: "<root@example.com>",
+			Subject:         "Plans",
+		}
+		if err := db.SaveMessage(ctx, pool, root); err != nil {
+			t.Fatalf("Failed to save message: %v", err)
+		}
+
+		reply := &imap.Message{
+			Uid: 1000,
+			Envelope: &imap.Envelope{
+				MessageId: "<reply@example.com>",
+				InReplyTo: "<root@example.com>",
+				Subject:   "Re: Plans",
+			},
+		}
+
+		threadMap, _, err := service.buildThreadMapFromMessages(ctx, userID, []*imap.Message{reply})
+		if err != nil {
+			t.Fatalf("buildThreadMapFromMessages failed: %v", err)
+		}
+		if hit := threadMap["<root@example.com>"]; hit == nil || hit.ID != thread.ID || !hit.FromServer {
+			t.Errorf("Expected the cached thread from the server, got: %v", threadMap)
 		}
 	})
 
@@ -0,0 +1,159 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	imapclient "github.com/emersion/go-imap/client"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/logging"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// defaultSentFolderName is the Sent folder we use if the server doesn't mark one with SPECIAL-USE.
+const defaultSentFolderName = "Sent"
+
+// AppendToSent saves a copy of a sent message to the user's Sent folder, marked as read,
+// with the send time as its internal date. raw must be the same bytes that we sent over SMTP,
+// so that the copy has the same Message-ID. It also caches the copy right away,
+// so that the thread shows the sent message without waiting for the next sync of the Sent folder.
+func (s *Service) AppendToSent(ctx context.Context, userID string, raw []byte, sentAt time.Time) error {
+	ctx = logging.WithUserID(ctx, userID)
+	settings, imapPassword, err := s.getSettingsAndPassword(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return s.imapPool.WithClient(ctx, userID, ServerFromSettings(settings), settings.IMAPUsername, imapPassword, func(clientIface IMAPClient) error {
+		wrapper, ok := clientIface.(*ClientWrapper)
+		if !ok || wrapper.client == nil {
+			return fmt.Errorf("failed to unwrap IMAP client")
+		}
+		client := wrapper.client
+
+		folderName := s.findFolderByRole(ctx, client, userID, "sent", defaultSentFolderName)
+		if err := client.Append(folderName, []string{imap.SeenFlag}, sentAt, bytes.NewReader(raw)); err != nil {
+			return fmt.Errorf("failed to append to %s: %w", folderName, err)
+		}
+
+		// The copy is in Sent either way, so a failure here only means that it shows up after the next sync
+		if messageID := messageIDFromRaw(raw); messageID != "" {
+			if err := s.cacheAppendedMessage(ctx, client, userID, folderName, messageID); err != nil {
+				slog.WarnContext(ctx, "IMAP: Failed to cache the sent message", "message_id", messageID, "error", err)
+			}
+		}
+		return nil
+	})
+}
+
+// findFolderByRole returns the name of the folder with the given role, for example, "sent",
+// or the fallback if there's none. It honors the roles that the user set by hand.
+func (s *Service) findFolderByRole(ctx context.Context, client *imapclient.Client, userID, role, fallback string) string {
+	folders, err := s.listFoldersWithOverrides(ctx, client, userID)
+	if err != nil {
+		slog.WarnContext(ctx, "IMAP: Failed to list folders to find a folder by role, using the fallback", "role", role, "fallback", fallback, "error", err)
+		return fallback
+	}
+
+	for _, folder := range folders {
+		if folder.Role == role {
+			return folder.Name
+		}
+	}
+	return fallback
+}
+
+// messageIDFromRaw returns the Message-ID header of a raw message, or "" if it has none.
+func messageIDFromRaw(raw []byte) string {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(msg.Header.Get("Message-ID"))
+}
+
+// cacheAppendedMessage fetches a message that we just appended to the folder, and saves it to the cache.
+// Replies go into the thread of the message they reply to, if we have it cached.
+// Other messages get their thread the same way as in an incremental sync.
+func (s *Service) cacheAppendedMessage(ctx context.Context, client *imapclient.Client, userID, folderName, messageID string) error {
+	pref, err := db.GetFolderSyncPreference(ctx, s.dbPool, userID, folderName)
+	if err != nil {
+		return err
+	}
+	if !pref.Enabled {
+		return nil
+	}
+
+	if _, err := client.Select(folderName, false); err != nil {
+		return fmt.Errorf("failed to select folder %s: %w", folderName, err)
+	}
+	uids, err := searchUIDsByMessageID(client, messageID)
+	if err != nil {
+		return err
+	}
+	if len(uids) == 0 {
+		return fmt.Errorf("appended message not found in %s", folderName)
+	}
+
+	// If the same Message-ID is in the folder more than once, the newest copy is the one we appended
+	imapMsg, err := FetchFullMessage(client, slices.Max(uids), s.fetchLimits.MaxMessageBytes)
+	if err != nil {
+		return fmt.Errorf("failed to fetch appended message: %w", err)
+	}
+
+	thread, err := s.findRepliedThread(ctx, userID, messageID, imapMsg)
+	if err != nil {
+		return err
+	}
+	if thread == nil {
+		return s.processIncrementalMessage(ctx, imapMsg, userID, folderName, nil)
+	}
+
+	msg, err := ParseMessageWithPartLimit(imapMsg, thread.ID, userID, folderName, s.fetchLimits.MaxPartBytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse message: %w", err)
+	}
+	if err := s.saveMessage(ctx, msg, nil); err != nil {
+		return fmt.Errorf("failed to save message: %w", err)
+	}
+	for _, att := range msg.Attachments {
+		att.MessageID = msg.ID
+		if err := db.SaveAttachment(ctx, s.dbPool, &att); err != nil {
+			slog.WarnContext(ctx, "Failed to save attachment", "error", err)
+		}
+	}
+	return nil
+}
+
+// findRepliedThread returns the thread of the cached message that imapMsg replies to.
+// Returns nil if imapMsg isn't a reply, we don't have the replied message cached,
+// or imapMsg itself is already cached, in which case it keeps its thread.
+func (s *Service) findRepliedThread(ctx context.Context, userID, messageID string, imapMsg *imap.Message) (*models.Thread, error) {
+	if imapMsg.Envelope == nil || imapMsg.Envelope.InReplyTo == "" {
+		return nil, nil
+	}
+	if _, err := db.GetMessageByMessageID(ctx, s.dbPool, userID, messageID); !errors.Is(err, db.ErrMessageNotFound) {
+		return nil, err
+	}
+
+	parent, err := db.GetMessageByMessageID(ctx, s.dbPool, userID, strings.TrimSpace(imapMsg.Envelope.InReplyTo))
+	if errors.Is(err, db.ErrMessageNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	thread, err := db.GetThreadByID(ctx, s.dbPool, parent.ThreadID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the replied message's thread: %w", err)
+	}
+	return thread, nil
+}
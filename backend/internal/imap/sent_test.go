@@ -0,0 +1,158 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestMessageIDFromRaw(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{
+			name: "returns the Message-ID",
+			raw:  "Message-ID: <abc@example.com>\r\nSubject: Hi\r\n\r\nBody",
+			want: "<abc@example.com>",
+		},
+		{
+			name: "returns empty for messages without a Message-ID",
+			raw:  "Subject: Hi\r\n\r\nBody",
+			want: "",
+		},
+		{
+			name: "returns empty for invalid messages",
+			raw:  "",
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := messageIDFromRaw([]byte(tt.raw)); got != tt.want {
+				t.Errorf("messageIDFromRaw() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheAppendedMessage(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	server := testutil.NewTestIMAPServer(t)
+	defer server.Close()
+	server.EnsureINBOX(t)
+
+	client, clientCleanup := server.Connect(t)
+	defer clientCleanup()
+	if err := client.Create("Sent"); err != nil {
+		t.Fatalf("Failed to create Sent folder: %v", err)
+	}
+
+	encryptor := getTestEncryptor(t)
+	service := NewService(pool, NewPool(), encryptor, nil)
+	defer service.Close()
+
+	ctx := context.Background()
+	userID, err := db.GetOrCreateUser(ctx, pool, "sent-cache-test@example.com")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	appendRaw := func(t *testing.T, messageID, inReplyTo string) {
+		t.Helper()
+		raw := "From: me@example.com\r\nTo: you@example.com\r\nSubject: Re: Hello\r\nMessage-ID: " + messageID + "\r\n"
+		if inReplyTo != "" {
+			raw += "In-Reply-To: " + inReplyTo + "\r\n"
+		}
+		raw += "Date: Mon, 02 Jan 2006 15:04:05 +0000\r\nContent-Type: text/plain\r\n\r\nThanks!\r\n"
+		if err := client.Append("Sent", []string{imap.SeenFlag}, time.Now(), bytes.NewReader([]byte(raw))); err != nil {
+			t.Fatalf("Failed to append message: %v", err)
+		}
+	}
+
+	t.Run("puts replies into the thread of the replied message", func(t *testing.T) {
+		parentID := "<parent@example.com>"
+		thread := &models.Thread{UserID: userID, StableThreadID: parentID, Subject: "Hello"}
+		if err := db.SaveThread(ctx, pool, thread); err != nil {
+			t.Fatalf("Failed to save thread: %v", err)
+		}
+		parent := &models.Message{
+			ThreadID:        thread.ID,
+			UserID:          userID,
+			IMAPUID:         1,
+			IMAPFolderName:  "INBOX",
+			MessageIDHeader: parentID,
+			FromAddress:     "you@example.com",
+			Subject:         "Hello",
+		}
+		if err := db.SaveMessage(ctx, pool, parent); err != nil {
+			t.Fatalf("Failed to save message: %v", err)
+		}
+
+		replyID := "<reply@example.com>"
+		appendRaw(t, replyID, parentID)
+		if err := service.cacheAppendedMessage(ctx, client, userID, "Sent", replyID); err != nil {
+			t.Fatalf("cacheAppendedMessage failed: %v", err)
+		}
+
+		cached, err := db.GetMessageByMessageID(ctx, pool, userID, replyID)
+		if err != nil {
+			t.Fatalf("Failed to get cached message: %v", err)
+		}
+		if cached.ThreadID != thread.ID {
+			t.Errorf("Expected thread %s, got %s", thread.ID, cached.ThreadID)
+		}
+		if cached.IMAPFolderName != "Sent" {
+			t.Errorf("Expected folder Sent, got %s", cached.IMAPFolderName)
+		}
+		if !cached.IsRead {
+			t.Error("Expected the sent message to be read")
+		}
+		if cached.BodyText == "" {
+			t.Error("Expected the sent message to have its body cached")
+		}
+	})
+
+	t.Run("starts a new thread for new messages", func(t *testing.T) {
+		messageID := "<new@example.com>"
+		appendRaw(t, messageID, "")
+		if err := service.cacheAppendedMessage(ctx, client, userID, "Sent", messageID); err != nil {
+			t.Fatalf("cacheAppendedMessage failed: %v", err)
+		}
+
+		if _, err := db.GetThreadByStableID(ctx, pool, userID, messageID); err != nil {
+			t.Errorf("Expected a thread for the new message: %v", err)
+		}
+	})
+
+	t.Run("skips folders that the user doesn't sync", func(t *testing.T) {
+		err := db.SaveFolderSyncPreference(ctx, pool, userID, &models.FolderSyncPreference{
+			FolderName: "Sent",
+			Enabled:    false,
+			Mode:       models.FolderSyncModeHeadersOnly,
+		})
+		if err != nil {
+			t.Fatalf("Failed to save folder sync preference: %v", err)
+		}
+
+		messageID := "<not-synced@example.com>"
+		appendRaw(t, messageID, "")
+		if err := service.cacheAppendedMessage(ctx, client, userID, "Sent", messageID); err != nil {
+			t.Fatalf("cacheAppendedMessage failed: %v", err)
+		}
+
+		if _, err := db.GetMessageByMessageID(ctx, pool, userID, messageID); err == nil {
+			t.Error("Expected the message not to be cached")
+		}
+	})
+}
@@ -4,8 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
+	"net"
+	"slices"
 	"time"
 
 	"github.com/emersion/go-imap"
@@ -14,9 +15,15 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/vdavid/vmail/backend/internal/crypto"
 	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/importance"
+	"github.com/vdavid/vmail/backend/internal/logging"
 	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/websocket"
 )
 
+// ErrFolderSyncDisabled is returned when syncing a folder that the user excluded from syncing.
+var ErrFolderSyncDisabled = errors.New("folder sync is disabled")
+
 // Service handles IMAP operations and caching.
 // The IMAP pool is injected so that a single shared pool can be used across
 // handlers and services, ensuring per-user connection limits are enforced
@@ -26,16 +33,83 @@ type Service struct {
 	imapPool  IMAPPool
 	encryptor *crypto.Encryptor
 	cacheTTL  time.Duration
+	// hub gets the events about syncs and changes. It can be nil.
+	hub *websocket.Hub
+	// fetchLimits caps the message bodies that syncs fetch. No limits by default.
+	fetchLimits FetchLimits
+	// fetchBatching is how full syncs split up fetching headers. One command on one connection by default.
+	fetchBatching FetchBatching
+	// retryPolicy is how syncs, searches, and listing folders retry after transient errors.
+	retryPolicy RetryPolicy
+	// notifier gets the new messages of incremental syncs. It can be nil.
+	notifier NewMailNotifier
+	// autoResponder gets the new INBOX messages of incremental syncs. It can be nil.
+	autoResponder AutoResponder
+	// trustedAuthServIDs are the authserv-ids of the Authentication-Results headers that we trust for all users,
+	// besides the host of each user's IMAP server.
+	trustedAuthServIDs []string
 }
 
-// NewService creates a new IMAP service.
-func NewService(dbPool *pgxpool.Pool, imapPool IMAPPool, encryptor *crypto.Encryptor) *Service {
+// NewService creates a new IMAP service. It publishes events about syncs and changes to hub, unless it's nil.
+func NewService(dbPool *pgxpool.Pool, imapPool IMAPPool, encryptor *crypto.Encryptor, hub *websocket.Hub) *Service {
 	return &Service{
-		dbPool:    dbPool,
-		imapPool:  imapPool,
-		encryptor: encryptor,
-		cacheTTL:  5 * time.Minute, // Default cache TTL
+		dbPool:      dbPool,
+		imapPool:    imapPool,
+		encryptor:   encryptor,
+		cacheTTL:    5 * time.Minute, // Default cache TTL
+		hub:         hub,
+		retryPolicy: DefaultRetryPolicy,
+	}
+}
+
+// SetFetchLimits sets the limits of the message bodies that syncs fetch.
+func (s *Service) SetFetchLimits(limits FetchLimits) {
+	s.fetchLimits = limits
+}
+
+// SetFetchBatching sets how full syncs split up fetching the headers of big folders.
+func (s *Service) SetFetchBatching(batching FetchBatching) {
+	s.fetchBatching = batching
+}
+
+// SetNewMailNotifier sets who to tell about new messages.
+func (s *Service) SetNewMailNotifier(notifier NewMailNotifier) {
+	s.notifier = notifier
+}
+
+// SetAutoResponder sets who replies to new INBOX messages while the user is away.
+func (s *Service) SetAutoResponder(responder AutoResponder) {
+	s.autoResponder = responder
+}
+
+// SetTrustedAuthServIDs sets the authserv-ids of the mail servers whose Authentication-Results headers we trust,
+// like "mx.google.com", for servers whose authserv-id isn't their IMAP host.
+func (s *Service) SetTrustedAuthServIDs(ids []string) {
+	s.trustedAuthServIDs = ids
+}
+
+// checkAuthenticity returns the results of the Authentication-Results header that the user's server added to the
+// message, if it has one. We trust the authserv-ids that SetTrustedAuthServIDs set, and the host of the IMAP server.
+func (s *Service) checkAuthenticity(ctx context.Context, msg *models.Message) *models.Authenticity {
+	settings, err := db.GetUserSettings(ctx, s.dbPool, msg.UserID)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to get user settings to check authenticity", "message_id", msg.ID, "error", err)
+		return nil
 	}
+	host := settings.IMAPServerHostname
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	trustedIDs := append([]string{host}, s.trustedAuthServIDs...)
+	return parseAuthenticationResults(msg.AuthenticationResults, trustedIDs, addressDomain(msg.FromAddress))
+}
+
+// retry runs fn, which uses the user's IMAP connections, with the service's retry policy.
+// The pool drops connections that fail with a transient error, so each retry gets a new one.
+func (s *Service) retry(ctx context.Context, operation string, fn func() error) error {
+	return Retry(ctx, s.retryPolicy, fn, func(err error) {
+		slog.WarnContext(ctx, "IMAP: Transient error, retrying", "operation", operation, "error", err)
+	})
 }
 
 // getSettingsAndPassword gets user settings and decrypts the IMAP password.
@@ -58,13 +132,21 @@ func (s *Service) getSettingsAndPassword(ctx context.Context, userID string) (*m
 // Thread-safe: The connection is locked during folder selection to prevent concurrent folder selections
 // from interfering with each other.
 func (s *Service) withClientAndSelectFolder(ctx context.Context, userID, folderName string, fn func(*imapclient.Client, *imap.MailboxStatus) error) error {
+	return s.withWrapperAndSelectFolder(ctx, userID, folderName, func(wrapper *ClientWrapper, mbox *imap.MailboxStatus) error {
+		return fn(wrapper.client, mbox)
+	})
+}
+
+// withWrapperAndSelectFolder is withClientAndSelectFolder, but it passes the wrapper,
+// for callers that need to know what's enabled on the connection.
+func (s *Service) withWrapperAndSelectFolder(ctx context.Context, userID, folderName string, fn func(*ClientWrapper, *imap.MailboxStatus) error) error {
 	settings, imapPassword, err := s.getSettingsAndPassword(ctx, userID)
 	if err != nil {
 		return err
 	}
 
 	// Use WithClient to ensure the client is always released
-	return s.imapPool.WithClient(userID, settings.IMAPServerHostname, settings.IMAPUsername, imapPassword, func(clientIface IMAPClient) error {
+	return s.imapPool.WithClient(ctx, userID, ServerFromSettings(settings), settings.IMAPUsername, imapPassword, func(clientIface IMAPClient) error {
 		wrapper, ok := clientIface.(*ClientWrapper)
 		if !ok || wrapper.client == nil {
 			return fmt.Errorf("failed to unwrap IMAP client")
@@ -79,27 +161,23 @@ func (s *Service) withClientAndSelectFolder(ctx context.Context, userID, folderN
 			return fmt.Errorf("failed to select folder %s: %w", folderName, err)
 		}
 
-		return fn(client, mbox)
+		return fn(wrapper, mbox)
 	})
 }
 
 // threadMaps contains the maps needed for thread processing.
 type threadMaps struct {
-	allUIDs           []uint32
-	uidToThreadRoot   map[uint32]uint32
-	rootUIDs          []uint32
-	uidToMessage      map[uint32]*imap.Message
-	rootUIDToStableID map[uint32]string
+	allUIDs         []uint32
+	uidToThreadRoot map[uint32]uint32
+	rootUIDs        []uint32
 }
 
 // buildThreadMaps builds all the maps needed for thread processing.
 func buildThreadMaps(threads []*sortthread.Thread) *threadMaps {
 	maps := &threadMaps{
-		allUIDs:           make([]uint32, 0),
-		uidToThreadRoot:   make(map[uint32]uint32),
-		rootUIDs:          make([]uint32, 0),
-		uidToMessage:      make(map[uint32]*imap.Message),
-		rootUIDToStableID: make(map[uint32]string),
+		allUIDs:         make([]uint32, 0),
+		uidToThreadRoot: make(map[uint32]uint32),
+		rootUIDs:        make([]uint32, 0),
 	}
 
 	// Recursively map all messages in a thread to their root UID
@@ -128,62 +206,25 @@ func buildThreadMaps(threads []*sortthread.Thread) *threadMaps {
 	return maps
 }
 
-// buildUIDToMessageMap builds a map of UID to message for a quick lookup.
-func buildUIDToMessageMap(messages []*imap.Message) map[uint32]*imap.Message {
-	uidToMessageMap := make(map[uint32]*imap.Message)
-	for _, msg := range messages {
-		uidToMessageMap[msg.Uid] = msg
-	}
-	return uidToMessageMap
-}
-
-// buildRootUIDToStableIDMap builds a map of root UID to stable thread ID.
-func buildRootUIDToStableIDMap(rootUIDs []uint32, uidToMessageMap map[uint32]*imap.Message) map[uint32]string {
-	rootUIDToStableID := make(map[uint32]string)
-	for _, rootUID := range rootUIDs {
-		if rootMsg, found := uidToMessageMap[rootUID]; found {
-			if rootMsg.Envelope != nil && len(rootMsg.Envelope.MessageId) > 0 {
-				rootUIDToStableID[rootUID] = rootMsg.Envelope.MessageId
-			}
-		}
-	}
-	return rootUIDToStableID
+// threadRoot is what we need from the root message of a thread to create the thread.
+type threadRoot struct {
+	stableThreadID string
+	subject        string
 }
 
-// getStableThreadID gets the stable thread ID for a root UID, with fallback.
-func getStableThreadID(rootUID uint32, rootUIDToStableID map[uint32]string, uidToMessageMap map[uint32]*imap.Message) string {
-	stableThreadID, ok := rootUIDToStableID[rootUID]
-	if !ok {
-		// Fallback: try to get from the root message if we have it
-		if rootMsg, found := uidToMessageMap[rootUID]; found {
-			if rootMsg.Envelope != nil && len(rootMsg.Envelope.MessageId) > 0 {
-				stableThreadID = rootMsg.Envelope.MessageId
-				rootUIDToStableID[rootUID] = stableThreadID
-			}
-		}
-	}
-	return stableThreadID
-}
-
-// getOrCreateThread gets an existing thread or creates a new one.
-func (s *Service) getOrCreateThread(ctx context.Context, userID, stableThreadID string, rootUID uint32, uidToMessageMap map[uint32]*imap.Message) (*models.Thread, error) {
-	threadModel, err := db.GetThreadByStableID(ctx, s.dbPool, userID, stableThreadID)
+// getOrCreateThread gets an existing thread or creates a new one with the subject of its root message.
+func (s *Service) getOrCreateThread(ctx context.Context, userID string, root threadRoot) (*models.Thread, error) {
+	threadModel, err := db.GetThreadByStableID(ctx, s.dbPool, userID, root.stableThreadID)
 	if err != nil {
 		if !errors.Is(err, db.ErrThreadNotFound) {
 			return nil, fmt.Errorf("failed to get thread: %w", err)
 		}
 
 		// Error IS ErrThreadNotFound, so we must create the thread.
-		subject := ""
-		if rootMsg, found := uidToMessageMap[rootUID]; found {
-			if rootMsg.Envelope != nil {
-				subject = rootMsg.Envelope.Subject
-			}
-		}
 		threadModel = &models.Thread{
 			UserID:         userID,
-			StableThreadID: stableThreadID,
-			Subject:        subject,
+			StableThreadID: root.stableThreadID,
+			Subject:        root.subject,
 		}
 
 		if err := db.SaveThread(ctx, s.dbPool, threadModel); err != nil {
@@ -193,24 +234,60 @@ func (s *Service) getOrCreateThread(ctx context.Context, userID, stableThreadID
 	return threadModel, nil
 }
 
-// processMessage processes a single message and saves it to the database.
-func (s *Service) processMessage(ctx context.Context, imapMsg *imap.Message, rootUID uint32, stableThreadID string, userID, folderName string, uidToMessageMap map[uint32]*imap.Message) error {
-	threadModel, err := s.getOrCreateThread(ctx, userID, stableThreadID, rootUID, uidToMessageMap)
+// saveStats counts how many message saves of a sync wrote to the database,
+// how many it skipped because the message didn't change,
+// and how many new messages it moved away because they were from blocked senders.
+type saveStats struct {
+	written int
+	skipped int
+	blocked int
+	// filtered is how many messages filters moved out of the folder.
+	filtered int
+	// added is how many of the written messages are new, as opposed to updated, for example, with their bodies.
+	added int
+	// throttleErr is the error that stopped downloading bodies, if the server throttled us or dropped the connection.
+	throttleErr error
+}
+
+// log logs the stats of a folder sync.
+func (st *saveStats) log(ctx context.Context, folderName string) {
+	slog.InfoContext(ctx, "IMAP Sync: Saved messages",
+		"folder", folderName, "written", st.written, "unchanged", st.skipped, "blocked", st.blocked, "filtered", st.filtered)
+}
+
+// saveMessage saves the message unless it's unchanged, and counts the result in the stats, which can be nil.
+// It also saves the event of the message's invite, if it has one. If that fails, it logs it, since the message is
+// still worth having without the event.
+func (s *Service) saveMessage(ctx context.Context, msg *models.Message, stats *saveStats) error {
+	written, err := db.SaveMessageIfChanged(ctx, s.dbPool, msg)
 	if err != nil {
 		return err
 	}
-
-	// Parse and save the message
-	msg, err := ParseMessage(imapMsg, threadModel.ID, userID, folderName)
-	if err != nil {
-		log.Printf("Warning: Failed to parse message UID %d: %v", imapMsg.Uid, err)
-		return nil // Continue processing other messages
+	if msg.CalendarEvent != nil {
+		if err := db.SaveCalendarEvent(ctx, s.dbPool, msg.ID, msg.CalendarEvent); err != nil {
+			slog.WarnContext(ctx, "Failed to save calendar event", "message_id", msg.ID, "error", err)
+		}
 	}
-
-	if err := db.SaveMessage(ctx, s.dbPool, msg); err != nil {
-		return fmt.Errorf("failed to save message: %w", err)
+	if msg.LinkAnalysis != nil {
+		if err := db.SaveLinkAnalysis(ctx, s.dbPool, msg.ID, msg.LinkAnalysis); err != nil {
+			slog.WarnContext(ctx, "Failed to save link analysis", "message_id", msg.ID, "error", err)
+		}
+	}
+	if len(msg.AuthenticationResults) > 0 {
+		msg.Authenticity = s.checkAuthenticity(ctx, msg)
+	}
+	if msg.Authenticity != nil {
+		if err := db.SaveAuthenticity(ctx, s.dbPool, msg.ID, msg.Authenticity); err != nil {
+			slog.WarnContext(ctx, "Failed to save authenticity", "message_id", msg.ID, "error", err)
+		}
+	}
+	if stats != nil {
+		if written {
+			stats.written++
+		} else {
+			stats.skipped++
+		}
 	}
-
 	return nil
 }
 
@@ -229,26 +306,26 @@ func (s *Service) tryIncrementalSync(ctx context.Context, client *imapclient.Cli
 	}
 
 	lastUID := uint32(*syncInfo.LastSyncedUID)
-	log.Printf("Incremental sync: fetching UIDs >= %d", lastUID+1)
+	slog.DebugContext(ctx, "Incremental sync: fetching new UIDs", "folder", folderName, "from_uid", lastUID+1)
 
 	newUIDs, err := SearchUIDsSince(client, lastUID+1)
 	if err != nil {
-		log.Printf("Warning: Failed to search for new UIDs, falling back to full sync: %v", err)
+		slog.WarnContext(ctx, "Failed to search for new UIDs, falling back to full sync", "error", err)
 		return incrementalSyncResult{}, false
 	}
 
 	if len(newUIDs) == 0 {
-		log.Printf("No new messages to sync")
+		slog.DebugContext(ctx, "No new messages to sync", "folder", folderName)
 		// Update sync timestamp even though there's nothing new
 		if err := db.SetFolderSyncInfo(ctx, s.dbPool, userID, folderName, syncInfo.LastSyncedUID); err != nil {
-			log.Printf("Warning: Failed to update folder sync timestamp: %v", err)
+			slog.WarnContext(ctx, "Failed to update folder sync timestamp", "error", err)
 		}
-		// Trigger background thread count update
-		go s.updateThreadCountInBackground(userID, folderName)
+		// Trigger a background thread count update. Nothing new to score or add to the contacts.
+		go s.updateThreadCountInBackground(ctx, userID, folderName)
 		return incrementalSyncResult{shouldReturn: true}, true
 	}
 
-	log.Printf("Found %d new messages to sync", len(newUIDs))
+	slog.InfoContext(ctx, "Found new messages to sync", "folder", folderName, "count", len(newUIDs))
 
 	// Find the highest UID
 	var highestUID uint32
@@ -267,29 +344,27 @@ func (s *Service) tryIncrementalSync(ctx context.Context, client *imapclient.Cli
 
 // fullSyncResult holds the result of performing a full sync.
 type fullSyncResult struct {
-	threadMaps   *threadMaps
+	threadMaps *threadMaps
+	// threadUIDs are the messages to save with the thread maps. With a sync depth, they're only some of them.
+	threadUIDs []uint32
+	// fallbackUIDs are the messages that the THREAD response got wrong, to thread by their references instead.
+	fallbackUIDs []uint32
 	uidsToSync   []uint32
 	highestUID   uint32
 	shouldReturn bool // true if we should return early (no messages)
 }
 
 // performFullSync performs a full sync of all threads in the folder.
-// In non-test environments, the IMAP server is required to support the THREAD
-// extension (RFC 5256). In test mode (VMAIL_TEST_MODE=true), if THREAD is not
-// supported we fall back to fetching all UIDs using SEARCH so that E2E tests
-// can run against the in-memory IMAP server.
-func (s *Service) performFullSync(ctx context.Context, client *imapclient.Client, userID, folderName string) (fullSyncResult, error) {
-	log.Printf("Full sync: fetching all threads")
+// If the server doesn't support the THREAD extension (RFC 5256), it falls back to fetching all UIDs using SEARCH,
+// and returns no thread maps, so that the caller threads the messages by their References headers.
+// See buildReferenceThreadMaps. If the THREAD response doesn't match the mailbox, only the messages
+// in the inconsistent threads fall back. See validateThreadTree.
+// If the folder has a sync depth, it only returns the messages within it to sync. See syncDepthUIDs.
+func (s *Service) performFullSync(ctx context.Context, client *imapclient.Client, userID, folderName string, pref *models.FolderSyncPreference) (fullSyncResult, error) {
+	slog.InfoContext(ctx, "Full sync: fetching all threads", "folder", folderName)
 	threads, err := RunThreadCommand(client)
 	if err != nil {
-		// In non-test environments, missing THREAD support is a hard error.
-		if os.Getenv("VMAIL_TEST_MODE") != "true" {
-			return fullSyncResult{}, fmt.Errorf("IMAP server must support THREAD extension (RFC 5256): %w", err)
-		}
-
-		// In test mode (used by E2E tests), THREAD is not supported by the
-		// in-memory test IMAP server, so we fall back to SEARCH.
-		log.Printf("THREAD command not supported in test mode, falling back to SEARCH, which is okay.")
+		slog.InfoContext(ctx, "THREAD command not supported, falling back to SEARCH", "folder", folderName, "error", err)
 		// Fetch all UIDs using SEARCH (starting from UID 1)
 		uidsToSync, err := SearchUIDsSince(client, 1)
 		if err != nil {
@@ -297,10 +372,10 @@ func (s *Service) performFullSync(ctx context.Context, client *imapclient.Client
 		}
 
 		if len(uidsToSync) == 0 {
-			log.Printf("No messages found in folder %s", folderName)
+			slog.InfoContext(ctx, "No messages found in folder", "folder", folderName)
 			// Still update sync info
 			if err := db.SetFolderSyncInfo(ctx, s.dbPool, userID, folderName, nil); err != nil {
-				log.Printf("Warning: Failed to set folder sync info: %v", err)
+				slog.WarnContext(ctx, "Failed to set folder sync info", "error", err)
 			}
 			return fullSyncResult{shouldReturn: true}, nil
 		}
@@ -313,25 +388,41 @@ func (s *Service) performFullSync(ctx context.Context, client *imapclient.Client
 			}
 		}
 
+		depthUIDs, err := syncDepthUIDs(client, pref, uidsToSync, time.Now())
+		if err != nil {
+			return fullSyncResult{}, err
+		}
+
 		// Return without threadMaps (will be nil) - messages will be processed without threading
 		return fullSyncResult{
 			threadMaps:   nil, // No thread structure available
-			uidsToSync:   uidsToSync,
+			uidsToSync:   filterUIDs(uidsToSync, depthUIDs),
 			highestUID:   highestUID,
 			shouldReturn: false,
 		}, nil
 	}
 
-	log.Printf("Found %d threads in folder %s", len(threads), folderName)
+	slog.InfoContext(ctx, "Found threads in folder", "folder", folderName, "count", len(threads))
 
-	threadMaps := buildThreadMaps(threads)
-	uidsToSync := threadMaps.allUIDs
+	mailboxUIDs, err := SearchUIDsSince(client, 1)
+	if err != nil {
+		return fullSyncResult{}, fmt.Errorf("failed to search for all UIDs: %w", err)
+	}
+	validThreads, fallbackUIDs, problems := validateThreadTree(threads, mailboxUIDs)
+	if problems.any() {
+		slog.WarnContext(ctx, "THREAD returned an inconsistent tree, threading the affected messages by their references",
+			"folder", folderName, "missing_uids", problems.missingUIDs, "duplicate_uids", problems.duplicateUIDs,
+			"unthreaded_uids", problems.unthreadedUIDs, "affected_uids", len(fallbackUIDs))
+	}
+
+	threadMaps := buildThreadMaps(validThreads)
+	uidsToSync := append(slices.Clone(threadMaps.allUIDs), fallbackUIDs...)
 
 	if len(uidsToSync) == 0 {
-		log.Printf("No messages found in folder %s", folderName)
+		slog.InfoContext(ctx, "No messages found in folder", "folder", folderName)
 		// Still update sync info
 		if err := db.SetFolderSyncInfo(ctx, s.dbPool, userID, folderName, nil); err != nil {
-			log.Printf("Warning: Failed to set folder sync info: %v", err)
+			slog.WarnContext(ctx, "Failed to set folder sync info", "error", err)
 		}
 		return fullSyncResult{shouldReturn: true}, nil
 	}
@@ -344,62 +435,230 @@ func (s *Service) performFullSync(ctx context.Context, client *imapclient.Client
 		}
 	}
 
+	depthUIDs, err := syncDepthUIDs(client, pref, mailboxUIDs, time.Now())
+	if err != nil {
+		return fullSyncResult{}, err
+	}
+	if depthUIDs != nil {
+		slog.InfoContext(ctx, "Full sync: limiting to the sync depth", "folder", folderName,
+			"messages", len(depthUIDs), "mailbox_messages", len(mailboxUIDs))
+	}
+
 	return fullSyncResult{
 		threadMaps:   threadMaps,
-		uidsToSync:   uidsToSync,
+		threadUIDs:   filterUIDs(threadMaps.allUIDs, depthUIDs),
+		fallbackUIDs: filterUIDs(fallbackUIDs, depthUIDs),
+		uidsToSync:   filterUIDs(uidsToSync, depthUIDs),
 		highestUID:   highestUID,
 		shouldReturn: false,
 	}, nil
 }
 
+// saveReferenceThreadedMessages fetches the headers of the messages with uids, threads them with
+// buildReferenceThreadMaps, and saves them with their threads. Like saveFullSyncMessages, it streams the parsed
+// messages into a spool, and only keeps their threadHeaders in memory for the threading, so memory use stays low
+// no matter how many messages the folder has. The folder must be selected.
+func (s *Service) saveReferenceThreadedMessages(ctx context.Context, client *imapclient.Client, uids []uint32, userID, folderName string, stats *saveStats) error {
+	spool := newMessageSpool("", spoolMemoryLimit)
+	defer func() {
+		if err := spool.Close(); err != nil {
+			slog.WarnContext(ctx, "IMAP Sync: Failed to remove spool file", "error", err)
+		}
+	}()
+
+	headers := make([]threadHeaders, 0, len(uids))
+	err := s.streamFullSyncHeaders(ctx, client, userID, folderName, uids, func(imapMsg *imap.Message) error {
+		headers = append(headers, threadHeadersOf(imapMsg))
+		msg, err := ParseMessage(imapMsg, "", userID, folderName)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to parse message", "folder", folderName, "uid", imapMsg.Uid, "error", err)
+			return nil // Continue processing other messages
+		}
+		return spool.Add(spoolRecord{Message: *msg})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch message headers: %w", err)
+	}
+	slog.InfoContext(ctx, "IMAP Sync: Threading messages by their references",
+		"folder", folderName, "count", len(headers), "spilled_batches", spool.SpilledBatches())
+
+	maps := buildReferenceThreadMaps(headers)
+	roots := make(map[uint32]threadRoot, len(maps.rootUIDs))
+	for _, msg := range headers {
+		if maps.uidToThreadRoot[msg.uid] == msg.uid && msg.messageID != "" {
+			roots[msg.uid] = threadRoot{stableThreadID: msg.messageID, subject: msg.subject}
+		}
+	}
+
+	return spool.Drain(func(batch []spoolRecord) error {
+		for i := range batch {
+			record := &batch[i]
+			root, ok := roots[maps.uidToThreadRoot[uint32(record.Message.IMAPUID)]]
+			if !ok {
+				slog.WarnContext(ctx, "Message has no Message-ID, skipping", "folder", folderName, "uid", record.Message.IMAPUID)
+				continue
+			}
+			threadModel, err := s.getOrCreateThread(ctx, userID, root)
+			if err != nil {
+				return err
+			}
+			record.Message.ThreadID = threadModel.ID
+			if err := s.saveMessage(ctx, &record.Message, stats); err != nil {
+				return fmt.Errorf("failed to save message: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
 // processIncrementalMessages processes messages during incremental sync.
-func (s *Service) processIncrementalMessages(ctx context.Context, messages []*imap.Message, userID, folderName string) {
+func (s *Service) processIncrementalMessages(ctx context.Context, messages []*imap.Message, userID, folderName string, stats *saveStats) {
 	for _, imapMsg := range messages {
-		if err := s.processIncrementalMessage(ctx, imapMsg, userID, folderName); err != nil {
-			log.Printf("Warning: Failed to process message UID %d: %v", imapMsg.Uid, err)
+		if err := s.processIncrementalMessage(ctx, imapMsg, userID, folderName, stats); err != nil {
+			slog.WarnContext(ctx, "Failed to process message", "folder", folderName, "uid", imapMsg.Uid, "error", err)
 			// Continue with other messages
 		}
 	}
 }
 
-// processFullSyncMessages processes messages during full sync using thread structure.
-func (s *Service) processFullSyncMessages(ctx context.Context, messages []*imap.Message, threadMaps *threadMaps, userID, folderName string) error {
-	threadMaps.uidToMessage = buildUIDToMessageMap(messages)
-	threadMaps.rootUIDToStableID = buildRootUIDToStableIDMap(threadMaps.rootUIDs, threadMaps.uidToMessage)
+// saveFullSyncMessages fetches the headers of the messages to sync, and saves them with their threads.
+// The fetch streams into a spool that spills to disk for big folders, and we write to the database once the fetch
+// is done, since a thread's root message can arrive after its replies. This way, memory use stays flat no matter
+// how many messages the folder has. The folder must be selected.
+func (s *Service) saveFullSyncMessages(ctx context.Context, client *imapclient.Client, threadMaps *threadMaps, uids []uint32, userID, folderName string, stats *saveStats) error {
+	spool := newMessageSpool("", spoolMemoryLimit)
+	defer func() {
+		if err := spool.Close(); err != nil {
+			slog.WarnContext(ctx, "IMAP Sync: Failed to remove spool file", "error", err)
+		}
+	}()
 
-	for _, imapMsg := range messages {
+	// Fetch the roots of the threads too, for their Message-IDs, even if they're beyond the sync depth
+	toSave := make(map[uint32]bool, len(uids))
+	for _, uid := range uids {
+		toSave[uid] = true
+	}
+	fetchUIDs := slices.Clone(uids)
+	otherRoots := make(map[uint32]bool)
+	for _, uid := range uids {
+		if rootUID, ok := threadMaps.uidToThreadRoot[uid]; ok && !toSave[rootUID] && !otherRoots[rootUID] {
+			otherRoots[rootUID] = true
+			fetchUIDs = append(fetchUIDs, rootUID)
+		}
+	}
+
+	roots := make(map[uint32]threadRoot, len(threadMaps.rootUIDs))
+	fetched := 0
+	err := s.streamFullSyncHeaders(ctx, client, userID, folderName, fetchUIDs, func(imapMsg *imap.Message) error {
+		fetched++
 		rootUID, ok := threadMaps.uidToThreadRoot[imapMsg.Uid]
 		if !ok {
-			log.Printf("Warning: No root thread found for UID %d", imapMsg.Uid)
-			continue
+			slog.WarnContext(ctx, "No root thread found", "folder", folderName, "uid", imapMsg.Uid)
+			return nil
 		}
-
-		stableThreadID := getStableThreadID(rootUID, threadMaps.rootUIDToStableID, threadMaps.uidToMessage)
-		if stableThreadID == "" {
-			log.Printf("Warning: No Message-ID found for root UID %d", rootUID)
-			continue
+		if imapMsg.Uid == rootUID && imapMsg.Envelope != nil && imapMsg.Envelope.MessageId != "" {
+			roots[rootUID] = threadRoot{stableThreadID: imapMsg.Envelope.MessageId, subject: imapMsg.Envelope.Subject}
+		}
+		if !toSave[imapMsg.Uid] {
+			return nil
 		}
 
-		if err := s.processMessage(ctx, imapMsg, rootUID, stableThreadID, userID, folderName, threadMaps.uidToMessage); err != nil {
-			return err
+		msg, err := ParseMessage(imapMsg, "", userID, folderName)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to parse message", "folder", folderName, "uid", imapMsg.Uid, "error", err)
+			return nil // Continue processing other messages
 		}
+		return spool.Add(spoolRecord{RootUID: rootUID, Message: *msg})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch message headers: %w", err)
 	}
+	slog.InfoContext(ctx, "IMAP Sync: Fetched message headers",
+		"folder", folderName, "count", fetched, "spilled_batches", spool.SpilledBatches())
+
+	return spool.Drain(func(batch []spoolRecord) error {
+		for i := range batch {
+			record := &batch[i]
+			root, ok := roots[record.RootUID]
+			if !ok {
+				slog.WarnContext(ctx, "No Message-ID found for root message", "folder", folderName, "uid", record.RootUID)
+				continue
+			}
 
-	return nil
+			threadModel, err := s.getOrCreateThread(ctx, userID, root)
+			if err != nil {
+				return err
+			}
+			record.Message.ThreadID = threadModel.ID
+			if err := s.saveMessage(ctx, &record.Message, stats); err != nil {
+				return fmt.Errorf("failed to save message: %w", err)
+			}
+		}
+		return nil
+	})
 }
 
 // SyncThreadsForFolder syncs threads from IMAP for a specific folder.
 // Uses incremental sync if possible (only syncs new messages since last sync).
+// Returns ErrFolderSyncDisabled if the user disabled syncing for the folder,
+// and ErrSyncThrottled while the server throttles the account. See throttle.go.
+// If the folder is in full sync mode, it also downloads the bodies of the synced messages.
 func (s *Service) SyncThreadsForFolder(ctx context.Context, userID, folderName string) error {
-	return s.withClientAndSelectFolder(ctx, userID, folderName, func(client *imapclient.Client, mbox *imap.MailboxStatus) error {
+	ctx = logging.WithUserID(ctx, userID)
+	_, err := s.syncThreadsForFolder(ctx, userID, folderName)
+	return err
+}
+
+// syncThreadsForFolder is SyncThreadsForFolder, but it also returns the stats of the sync.
+func (s *Service) syncThreadsForFolder(ctx context.Context, userID, folderName string) (*saveStats, error) {
+	pref, err := db.GetFolderSyncPreference(ctx, s.dbPool, userID, folderName)
+	if err != nil {
+		return nil, err
+	}
+	if !pref.Enabled {
+		return nil, ErrFolderSyncDisabled
+	}
+
+	throttle, err := db.GetSyncThrottle(ctx, s.dbPool, userID)
+	if err != nil {
+		return nil, err
+	}
+	if isThrottled(throttle, time.Now()) {
+		return nil, ErrSyncThrottled
+	}
+
+	s.publishSyncEvent(ctx, userID, websocket.Event{Type: websocket.EventSyncStarted, Folder: folderName})
+	if err := db.MarkFolderSyncStarted(ctx, s.dbPool, userID, folderName); err != nil {
+		slog.WarnContext(ctx, "IMAP Sync: Failed to mark folder sync started", "folder", folderName, "error", err)
+	}
+	stats := &saveStats{}
+	syncFolder := func(wrapper *ClientWrapper, mbox *imap.MailboxStatus) (err error) {
+		client := wrapper.client
 
 		// Check if we can do incremental sync
 		syncInfo, err := db.GetFolderSyncInfo(ctx, s.dbPool, userID, folderName)
 		if err != nil {
-			log.Printf("Warning: Failed to get folder sync info: %v", err)
+			slog.WarnContext(ctx, "Failed to get folder sync info", "error", err)
 			syncInfo = nil // Fall back to full sync
 		}
 
+		// Get the mod-sequence before fetching anything, so changes during the sync are picked up next time
+		highestModSeq, err := GetHighestModSeq(client, folderName)
+		if err != nil {
+			slog.WarnContext(ctx, "IMAP Sync: Failed to get HIGHESTMODSEQ", "folder", folderName, "error", err)
+		}
+		if highestModSeq > 0 {
+			defer func() {
+				if err != nil {
+					return
+				}
+				if err := db.SetFolderModSeq(ctx, s.dbPool, userID, folderName, mbox.UidValidity, highestModSeq); err != nil {
+					slog.WarnContext(ctx, "IMAP Sync: Failed to set folder mod-sequence", "folder", folderName, "error", err)
+				}
+			}()
+		}
+		s.syncChanges(ctx, client, wrapper.qresync, userID, folderName, syncInfo, mbox.UidValidity, highestModSeq)
+
 		// Try incremental sync first
 		incResult, isIncremental := s.tryIncrementalSync(ctx, client, userID, folderName, syncInfo)
 		if isIncremental {
@@ -411,20 +670,43 @@ func (s *Service) SyncThreadsForFolder(ctx context.Context, userID, folderName s
 			if err != nil {
 				return fmt.Errorf("failed to fetch message headers: %w", err)
 			}
-			log.Printf("IMAP Sync: Fetched %d message headers for user %s, folder %s", len(messages), userID, folderName)
-			s.processIncrementalMessages(ctx, messages, userID, folderName)
+			slog.InfoContext(ctx, "IMAP Sync: Fetched message headers", "folder", folderName, "count", len(messages))
+			s.publishSyncEvent(ctx, userID, websocket.Event{Type: websocket.EventSyncProgress, Folder: folderName, Count: len(messages), Total: len(incResult.uidsToSync)})
+			messages = s.fileBlockedMessages(ctx, client, userID, folderName, messages, stats)
+			messages = s.applyFilters(ctx, client, userID, folderName, messages, stats)
+			var autoReplies []models.AutoReplyMessage
+			if folderName == "INBOX" && s.autoResponder != nil {
+				// Only the messages that blocking and filters kept in INBOX
+				autoReplies = autoReplyMessages(messages)
+			}
+			s.processIncrementalMessages(ctx, messages, userID, folderName, stats)
+			stats.added = stats.written
+			if pref.Mode == models.FolderSyncModeFull {
+				s.syncBodies(ctx, client, userID, folderName, messageUIDs(messages), stats)
+			}
+			stats.log(ctx, folderName)
 
 			// Update sync info with the highest UID
 			highestUIDInt64 := int64(incResult.highestUID)
 			if err := db.SetFolderSyncInfo(ctx, s.dbPool, userID, folderName, &highestUIDInt64); err != nil {
-				log.Printf("IMAP Sync: Warning: Failed to set folder sync info for user %s, folder %s: %v", userID, folderName, err)
+				slog.WarnContext(ctx, "IMAP Sync: Failed to set folder sync info", "folder", folderName, "error", err)
 			}
-			go s.updateThreadCountInBackground(userID, folderName)
+			go s.updateThreadCountInBackground(ctx, userID, folderName)
+			newUIDs := messageUIDs(messages)
+			if stats.added > 0 {
+				go func() {
+					// Notifications only go to devices that want them for the thread's importance, so score it first
+					s.updateImportanceInBackground(ctx, userID, folderName, newUIDs)
+					s.notifyNewMailInBackground(ctx, userID, folderName, newUIDs)
+				}()
+				go s.updateContactsInBackground(ctx, userID, folderName, newUIDs)
+			}
+			go s.respondToNewMailInBackground(ctx, userID, autoReplies)
 			return nil
 		}
 
 		// Full sync path: get thread structure first
-		fullResult, err := s.performFullSync(ctx, client, userID, folderName)
+		fullResult, err := s.performFullSync(ctx, client, userID, folderName, pref)
 		if err != nil {
 			return err
 		}
@@ -432,41 +714,157 @@ func (s *Service) SyncThreadsForFolder(ctx context.Context, userID, folderName s
 			return nil
 		}
 
-		// Fetch message headers for UIDs we need to sync
-		messages, err := FetchMessageHeaders(client, fullResult.uidsToSync)
-		if err != nil {
-			return fmt.Errorf("failed to fetch message headers: %w", err)
-		}
-
-		log.Printf("IMAP Sync: Fetched %d message headers for user %s, folder %s", len(messages), userID, folderName)
-
-		// Process messages: use thread structure if available, otherwise use incremental processing
+		// Process messages: use thread structure if available, otherwise thread them by their headers
 		threadMaps := fullResult.threadMaps
 		if threadMaps == nil {
-			// THREAD command not supported - process messages without thread structure
-			// (same as incremental sync)
-			log.Printf("IMAP Sync: THREAD command not supported, processing messages incrementally for user %s, folder %s", userID, folderName)
-			s.processIncrementalMessages(ctx, messages, userID, folderName)
+			// THREAD command not supported - thread the messages by their References headers instead
+			slog.InfoContext(ctx, "IMAP Sync: THREAD command not supported", "folder", folderName)
+			if err := s.saveReferenceThreadedMessages(ctx, client, fullResult.uidsToSync, userID, folderName, stats); err != nil {
+				return err
+			}
 		} else {
 			// Process messages using thread structure
-			if err := s.processFullSyncMessages(ctx, messages, threadMaps, userID, folderName); err != nil {
+			if err := s.saveFullSyncMessages(ctx, client, threadMaps, fullResult.threadUIDs, userID, folderName, stats); err != nil {
 				return err
 			}
+			if len(fullResult.fallbackUIDs) > 0 {
+				if err := s.saveReferenceThreadedMessages(ctx, client, fullResult.fallbackUIDs, userID, folderName, stats); err != nil {
+					return err
+				}
+			}
+		}
+		stats.added = stats.written
+		if pref.Mode == models.FolderSyncModeFull {
+			s.syncBodies(ctx, client, userID, folderName, fullResult.uidsToSync, stats)
 		}
+		stats.log(ctx, folderName)
 
 		// Update sync info with the highest UID
 		highestUIDInt64 := int64(fullResult.highestUID)
 		if err := db.SetFolderSyncInfo(ctx, s.dbPool, userID, folderName, &highestUIDInt64); err != nil {
-			log.Printf("IMAP Sync: Warning: Failed to set folder sync info for user %s, folder %s: %v", userID, folderName, err)
+			slog.WarnContext(ctx, "IMAP Sync: Failed to set folder sync info", "folder", folderName, "error", err)
 		} else {
-			log.Printf("IMAP Sync: Updated sync info for user %s, folder %s (highest UID: %d)", userID, folderName, fullResult.highestUID)
+			slog.InfoContext(ctx, "IMAP Sync: Updated sync info", "folder", folderName, "highest_uid", fullResult.highestUID)
 		}
 
-		// Trigger background thread count update
-		go s.updateThreadCountInBackground(userID, folderName)
+		// Trigger background thread count, importance, and contact updates
+		go s.updateThreadCountInBackground(ctx, userID, folderName)
+		if stats.added > 0 {
+			go s.updateAllImportanceInBackground(ctx, userID)
+			go s.rebuildContactsInBackground(ctx, userID)
+		}
 
 		return nil
+	}
+	// A sync picks up where the failed attempt left off, and only the last error counts toward throttling
+	err = s.retry(ctx, "sync", func() error {
+		return s.withWrapperAndSelectFolder(ctx, userID, folderName, syncFolder)
 	})
+	if err != nil {
+		s.updateThrottle(ctx, userID, throttle, err)
+	} else {
+		s.updateThrottle(ctx, userID, throttle, stats.throttleErr)
+	}
+	s.markSyncFinished(ctx, userID, folderName, err)
+	s.publishSyncFinished(ctx, userID, folderName, stats, err)
+	return stats, err
+}
+
+// markSyncFinished records that the folder sync finished, and its error, for GET /api/v1/sync/status.
+// It doesn't use ctx's cancellation, so that a canceled sync doesn't stay marked as running.
+func (s *Service) markSyncFinished(ctx context.Context, userID, folderName string, err error) {
+	syncErr := ""
+	if err != nil {
+		syncErr = err.Error()
+	}
+	if err := db.MarkFolderSyncFinished(context.WithoutCancel(ctx), s.dbPool, userID, folderName, syncErr); err != nil {
+		slog.WarnContext(ctx, "IMAP Sync: Failed to mark folder sync finished", "folder", folderName, "error", err)
+	}
+}
+
+// publishSyncFinished tells the user's clients that a folder sync finished, and whether it cached new messages.
+func (s *Service) publishSyncFinished(ctx context.Context, userID, folderName string, stats *saveStats, err error) {
+	if err != nil {
+		s.publishSyncEvent(ctx, userID, websocket.Event{Type: websocket.EventSyncFinished, Folder: folderName, Error: "Failed to sync the folder"})
+		return
+	}
+	if stats.added > 0 {
+		s.hub.Publish(userID, websocket.Event{Type: websocket.EventNewMessage, Folder: folderName, Count: stats.added})
+	}
+	s.publishSyncEvent(ctx, userID, websocket.Event{Type: websocket.EventSyncFinished, Folder: folderName})
+}
+
+// syncChanges updates the flags of the cached messages in the selected folder, and removes the expunged ones,
+// using what changed since the mod-sequence of the last sync. It only finds expunged messages if qresync is true.
+// It does nothing if the server doesn't support CONDSTORE, or if we don't have a usable mod-sequence yet.
+// Errors are logged, since the rest of the sync still works without this.
+func (s *Service) syncChanges(ctx context.Context, client *imapclient.Client, qresync bool, userID, folderName string, syncInfo *db.FolderSyncInfo, uidValidity uint32, highestModSeq uint64) {
+	if highestModSeq == 0 || syncInfo == nil || syncInfo.LastSyncedUID == nil || *syncInfo.LastSyncedUID <= 0 ||
+		syncInfo.HighestModSeq == nil || syncInfo.UIDValidity == nil {
+		return
+	}
+	if *syncInfo.UIDValidity != int64(uidValidity) {
+		// The UIDs we have are meaningless now, so are the mod-sequences
+		slog.InfoContext(ctx, "IMAP Sync: UIDVALIDITY of folder changed, skipping changes", "folder", folderName)
+		return
+	}
+	if uint64(*syncInfo.HighestModSeq) >= highestModSeq {
+		return
+	}
+
+	changes, err := FetchChangesSince(client, uint32(*syncInfo.LastSyncedUID), uint64(*syncInfo.HighestModSeq), qresync)
+	if err != nil {
+		slog.WarnContext(ctx, "IMAP Sync: Failed to fetch changes", "folder", folderName, "error", err)
+		return
+	}
+
+	flags := make([]db.MessageFlags, 0, len(changes.flags))
+	for uid, messageFlags := range changes.flags {
+		isRead, isStarred := flagsToReadAndStarred(messageFlags)
+		flags = append(flags, db.MessageFlags{IMAPUID: int64(uid), IsRead: isRead, IsStarred: isStarred, Labels: flagsToLabels(messageFlags)})
+	}
+	updated, err := db.UpdateMessageFlags(ctx, s.dbPool, userID, folderName, flags)
+	if err != nil {
+		slog.WarnContext(ctx, "IMAP Sync: Failed to update message flags", "folder", folderName, "error", err)
+	}
+	if updated > 0 {
+		// We don't know which of the messages had different flags in the cache, so we list all that changed on the server
+		uids := make([]int64, len(flags))
+		for i, f := range flags {
+			uids[i] = f.IMAPUID
+		}
+		s.hub.Publish(userID, websocket.Event{Type: websocket.EventFlagsChanged, Folder: folderName, UIDs: uids})
+	}
+
+	vanishedUIDs := make([]int64, len(changes.vanishedUIDs))
+	for i, uid := range changes.vanishedUIDs {
+		vanishedUIDs[i] = int64(uid)
+	}
+	deleted, err := db.DeleteMessagesByUID(ctx, s.dbPool, userID, folderName, vanishedUIDs)
+	if err != nil {
+		slog.WarnContext(ctx, "IMAP Sync: Failed to delete expunged messages", "folder", folderName, "error", err)
+	}
+	if deleted > 0 {
+		s.hub.Publish(userID, websocket.Event{Type: websocket.EventMessageDeleted, Folder: folderName, UIDs: vanishedUIDs})
+	}
+
+	slog.InfoContext(ctx, "IMAP Sync: Applied changes", "folder", folderName, "flag_updates", updated, "expunged", deleted)
+}
+
+// syncBodies downloads and saves the bodies of the given messages, for folders in full sync mode.
+// The folder must be selected. Errors are logged, so that one bad message doesn't stop the sync,
+// but it stops when the server throttles us.
+func (s *Service) syncBodies(ctx context.Context, client *imapclient.Client, userID, folderName string, uids []uint32, stats *saveStats) {
+	for _, uid := range uids {
+		if err := s.syncSingleMessage(ctx, client, userID, folderName, int64(uid), stats); err != nil {
+			slog.WarnContext(ctx, "IMAP Sync: Failed to sync body", "folder", folderName, "uid", uid, "error", err)
+			// Don't keep asking a server that throttles us, the next sync fetches the rest
+			if classifyThrottle(err) != signalNone {
+				stats.throttleErr = err
+				return
+			}
+		}
+	}
 }
 
 // processIncrementalMessage processes a single message during incremental sync.
@@ -474,9 +872,9 @@ func (s *Service) SyncThreadsForFolder(ctx context.Context, userID, folderName s
 // For simplicity, we use the message's own Message-ID to match threads.
 // If the Message-ID matches a thread's stable ID, it's the root message of that thread.
 // Otherwise, we create a new thread. Full sync will correct any threading issues.
-func (s *Service) processIncrementalMessage(ctx context.Context, imapMsg *imap.Message, userID, folderName string) error {
+func (s *Service) processIncrementalMessage(ctx context.Context, imapMsg *imap.Message, userID, folderName string, stats *saveStats) error {
 	if imapMsg.Envelope == nil || len(imapMsg.Envelope.MessageId) == 0 {
-		log.Printf("Warning: Message UID %d has no Message-ID, skipping", imapMsg.Uid)
+		slog.WarnContext(ctx, "Message has no Message-ID, skipping", "folder", folderName, "uid", imapMsg.Uid)
 		return nil
 	}
 
@@ -485,8 +883,10 @@ func (s *Service) processIncrementalMessage(ctx context.Context, imapMsg *imap.M
 	// For incremental sync, we use a simplified approach:
 	// 1. Try to find a thread where this Message-ID is the stable thread ID (root message)
 	// 2. If not found, check if this message is already in the DB (might be a reply)
-	// 3. If still not found, create a new thread with this Message-ID as root
-	// Note: This is a simplification - full sync will correct threading using THREAD command
+	// 3. If not, look for the thread of a cached message that this one references in its headers
+	// 4. If still not found, create a new thread with this Message-ID as root
+	// Note: This is a simplification - a reply that arrives before the message it replies to gets its own thread.
+	// db.RepairThreads merges them later.
 
 	// First, try to find the thread by Message-ID (this works for root messages)
 	threadModel, err := db.GetThreadByStableID(ctx, s.dbPool, userID, messageID)
@@ -503,7 +903,9 @@ func (s *Service) processIncrementalMessage(ctx context.Context, imapMsg *imap.M
 			if err != nil {
 				return fmt.Errorf("failed to get existing message's thread: %w", err)
 			}
-		} else {
+		} else if threadModel, err = s.findReferencedThread(ctx, userID, imapMsg); err != nil {
+			return err
+		} else if threadModel == nil {
 			// New message - create a new thread
 			// For incremental sync, we'll use this message's Message-ID as the stable ID
 			// Full sync will correct this if it's actually a reply
@@ -522,36 +924,127 @@ func (s *Service) processIncrementalMessage(ctx context.Context, imapMsg *imap.M
 	}
 
 	// Parse and save the message
-	msg, err := ParseMessage(imapMsg, threadModel.ID, userID, folderName)
+	msg, err := ParseMessageWithPartLimit(imapMsg, threadModel.ID, userID, folderName, s.fetchLimits.MaxPartBytes)
 	if err != nil {
 		return fmt.Errorf("failed to parse message: %w", err)
 	}
 
-	if err := db.SaveMessage(ctx, s.dbPool, msg); err != nil {
+	if err := s.saveMessage(ctx, msg, stats); err != nil {
 		return fmt.Errorf("failed to save message: %w", err)
 	}
 
 	return nil
 }
 
+// findReferencedThread returns the thread of the newest cached message that imapMsg references in its References
+// or In-Reply-To header. Returns nil if it doesn't reference any cached message.
+func (s *Service) findReferencedThread(ctx context.Context, userID string, imapMsg *imap.Message) (*models.Thread, error) {
+	references := messageReferences(imapMsg)
+	for i := len(references) - 1; i >= 0; i-- {
+		referenced, err := db.GetMessageByMessageID(ctx, s.dbPool, userID, references[i])
+		if errors.Is(err, db.ErrMessageNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		thread, err := db.GetThreadByID(ctx, s.dbPool, referenced.ThreadID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the referenced message's thread: %w", err)
+		}
+		return thread, nil
+	}
+	return nil, nil
+}
+
 // updateThreadCountInBackground updates the thread count in the background.
 // Uses a 30-second timeout to avoid hanging indefinitely.
-func (s *Service) updateThreadCountInBackground(userID, folderName string) {
-	// Use a new context with timeout to avoid hanging
-	bgCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (s *Service) updateThreadCountInBackground(ctx context.Context, userID, folderName string) {
+	// Use a new context with timeout to avoid hanging, but keep the log fields of the sync
+	bgCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
 	defer cancel()
 
 	if err := db.UpdateThreadCount(bgCtx, s.dbPool, userID, folderName); err != nil {
-		log.Printf("Warning: Failed to update thread count in background for folder %s: %v", folderName, err)
+		slog.WarnContext(bgCtx, "Failed to update thread count in background", "folder", folderName, "error", err)
 	} else {
-		log.Printf("Updated thread count for folder %s", folderName)
+		slog.DebugContext(bgCtx, "Updated thread count", "folder", folderName)
+	}
+}
+
+// updateImportanceInBackground recalculates the importance scores that the new messages with the UIDs can change
+// in the background. They can change the scores of older threads too, for example, by adding to a sender's frequency.
+func (s *Service) updateImportanceInBackground(ctx context.Context, userID, folderName string, uids []uint32) {
+	bgCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+
+	if err := importance.UpdateScoresForMessages(bgCtx, s.dbPool, userID, folderName, uids); err != nil {
+		slog.WarnContext(bgCtx, "Failed to update importance scores in background", "error", err)
+	}
+}
+
+// updateAllImportanceInBackground recalculates the importance scores of all the user's threads in the background.
+// For full syncs, which can add a whole folder, so rescoring everything is about as much work as finding what changed.
+func (s *Service) updateAllImportanceInBackground(ctx context.Context, userID string) {
+	bgCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+
+	if err := importance.UpdateScores(bgCtx, s.dbPool, userID); err != nil {
+		slog.WarnContext(bgCtx, "Failed to update importance scores in background", "error", err)
+	}
+}
+
+// notifyNewMailInBackground tells the notifier about the new messages of an incremental sync. The notifier decides
+// which folders notify. Full syncs don't notify, since they're the first sync of a folder, or the UIDs changed,
+// so all messages look new.
+func (s *Service) notifyNewMailInBackground(ctx context.Context, userID, folderName string, uids []uint32) {
+	if s.notifier == nil || len(uids) == 0 {
+		return
+	}
+	bgCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+
+	s.notifier.NotifyNewMail(bgCtx, userID, folderName, uids)
+}
+
+// updateContactsInBackground adds the addresses of the new messages with the UIDs to the user's contacts in the
+// background, so that the compose form can suggest the people in them.
+func (s *Service) updateContactsInBackground(ctx context.Context, userID, folderName string, uids []uint32) {
+	bgCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+
+	ownAddresses, err := db.GetOwnAddresses(bgCtx, s.dbPool, userID)
+	if err != nil {
+		slog.WarnContext(bgCtx, "Failed to get own addresses for contacts", "error", err)
+		return
+	}
+	if err := db.AddContactsFromMessages(bgCtx, s.dbPool, userID, ownAddresses, folderName, uids); err != nil {
+		slog.WarnContext(bgCtx, "Failed to update contacts in background", "error", err)
+	}
+}
+
+// rebuildContactsInBackground rebuilds the user's contacts from all their cached messages in the background.
+// For full syncs, which can replace the messages of a folder, so the counts of the old ones must go.
+func (s *Service) rebuildContactsInBackground(ctx context.Context, userID string) {
+	bgCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+
+	ownAddresses, err := db.GetOwnAddresses(bgCtx, s.dbPool, userID)
+	if err != nil {
+		slog.WarnContext(bgCtx, "Failed to get own addresses for contacts", "error", err)
+		return
+	}
+	if err := db.RebuildContacts(bgCtx, s.dbPool, userID, ownAddresses); err != nil {
+		slog.WarnContext(bgCtx, "Failed to rebuild contacts in background", "error", err)
 	}
 }
 
 // SyncFullMessage syncs the full message body from IMAP.
 func (s *Service) SyncFullMessage(ctx context.Context, userID, folderName string, imapUID int64) error {
-	return s.withClientAndSelectFolder(ctx, userID, folderName, func(client *imapclient.Client, _ *imap.MailboxStatus) error {
-		return s.syncSingleMessage(ctx, client, userID, folderName, imapUID)
+	ctx = logging.WithUserID(ctx, userID)
+	return s.retry(ctx, "sync message", func() error {
+		return s.withClientAndSelectFolder(ctx, userID, folderName, func(client *imapclient.Client, _ *imap.MailboxStatus) error {
+			return s.syncSingleMessage(ctx, client, userID, folderName, imapUID, nil)
+		})
 	})
 }
 
@@ -563,6 +1056,7 @@ func (s *Service) SyncFullMessages(ctx context.Context, userID string, messages
 	if len(messages) == 0 {
 		return nil
 	}
+	ctx = logging.WithUserID(ctx, userID)
 
 	// Group messages by folder to minimize folder SELECT operations
 	folderToUIDs := make(map[string][]int64)
@@ -579,34 +1073,36 @@ func (s *Service) SyncFullMessages(ctx context.Context, userID string, messages
 	// Sync messages grouped by folder
 	for folderName, uids := range folderToUIDs {
 		// Use WithClient to ensure the client is always released
-		err := s.imapPool.WithClient(userID, settings.IMAPServerHostname, settings.IMAPUsername, imapPassword, func(clientIface IMAPClient) error {
-			wrapper, ok := clientIface.(*ClientWrapper)
-			if !ok || wrapper.client == nil {
-				log.Printf("Warning: Failed to unwrap IMAP client for folder %s", folderName)
-				return nil // Continue with next folder
-			}
+		err := s.retry(ctx, "sync messages", func() error {
+			return s.imapPool.WithClient(ctx, userID, ServerFromSettings(settings), settings.IMAPUsername, imapPassword, func(clientIface IMAPClient) error {
+				wrapper, ok := clientIface.(*ClientWrapper)
+				if !ok || wrapper.client == nil {
+					slog.WarnContext(ctx, "Failed to unwrap IMAP client", "folder", folderName)
+					return nil // Continue with next folder
+				}
 
-			client := wrapper.client
+				client := wrapper.client
 
-			// Select the folder once for all messages in this folder
-			if _, err := client.Select(folderName, false); err != nil {
-				log.Printf("Warning: Failed to select folder %s: %v", folderName, err)
-				return nil // Continue with next folder
-			}
+				// Select the folder once for all messages in this folder
+				if _, err := client.Select(folderName, false); err != nil {
+					slog.WarnContext(ctx, "Failed to select folder", "folder", folderName, "error", err)
+					return nil // Continue with next folder
+				}
 
-			// Sync each message in this folder
-			for _, imapUID := range uids {
-				if err := s.syncSingleMessage(ctx, client, userID, folderName, imapUID); err != nil {
-					log.Printf("Warning: Failed to sync message UID %d in folder %s: %v", imapUID, folderName, err)
-					// Continue with other messages
+				// Sync each message in this folder
+				for _, imapUID := range uids {
+					if err := s.syncSingleMessage(ctx, client, userID, folderName, imapUID, nil); err != nil {
+						slog.WarnContext(ctx, "Failed to sync message", "folder", folderName, "uid", imapUID, "error", err)
+						// Continue with other messages
+					}
 				}
-			}
 
-			return nil
+				return nil
+			})
 		})
 
 		if err != nil {
-			log.Printf("Warning: Failed to get IMAP client for folder %s: %v", folderName, err)
+			slog.WarnContext(ctx, "Failed to get IMAP client", "folder", folderName, "error", err)
 			// Continue with next folder
 		}
 	}
@@ -614,10 +1110,29 @@ func (s *Service) SyncFullMessages(ctx context.Context, userID string, messages
 	return nil
 }
 
+// FetchRawMessage fetches the source of a message from the IMAP server, without caching it.
+// Returns ErrMessageNotFound if the server no longer has the message.
+func (s *Service) FetchRawMessage(ctx context.Context, userID, folderName string, imapUID int64) (*RawMessage, error) {
+	ctx = logging.WithUserID(ctx, userID)
+	var raw *RawMessage
+	err := s.retry(ctx, "fetch raw message", func() error {
+		return s.withClientAndSelectFolder(ctx, userID, folderName, func(client *imapclient.Client, _ *imap.MailboxStatus) error {
+			var err error
+			raw, err = FetchRawMessage(client, uint32(imapUID), s.fetchLimits.MaxMessageBytes)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
 // syncSingleMessage syncs a single message body (helper for batch sync).
-func (s *Service) syncSingleMessage(ctx context.Context, client *imapclient.Client, userID, folderName string, imapUID int64) error {
+// The stats can be nil.
+func (s *Service) syncSingleMessage(ctx context.Context, client *imapclient.Client, userID, folderName string, imapUID int64, stats *saveStats) error {
 	// Fetch the full message
-	imapMsg, err := FetchFullMessage(client, uint32(imapUID))
+	imapMsg, err := FetchFullMessage(client, uint32(imapUID), s.fetchLimits.MaxMessageBytes)
 	if err != nil {
 		return fmt.Errorf("failed to fetch full message: %w", err)
 	}
@@ -629,7 +1144,7 @@ func (s *Service) syncSingleMessage(ctx context.Context, client *imapclient.Clie
 	}
 
 	// Parse body and update message
-	parsedMsg, err := ParseMessage(imapMsg, msg.ThreadID, userID, folderName)
+	parsedMsg, err := ParseMessageWithPartLimit(imapMsg, msg.ThreadID, userID, folderName, s.fetchLimits.MaxPartBytes)
 	if err != nil {
 		return fmt.Errorf("failed to parse message: %w", err)
 	}
@@ -637,9 +1152,17 @@ func (s *Service) syncSingleMessage(ctx context.Context, client *imapclient.Clie
 	// Update message with body
 	msg.UnsafeBodyHTML = parsedMsg.UnsafeBodyHTML
 	msg.BodyText = parsedMsg.BodyText
+	msg.Snippet = parsedMsg.Snippet
+	msg.Truncated = parsedMsg.Truncated
+	msg.CalendarEvent = parsedMsg.CalendarEvent
+	msg.LinkAnalysis = parsedMsg.LinkAnalysis
+	msg.AuthenticationResults = parsedMsg.AuthenticationResults
+	if msg.Truncated {
+		slog.WarnContext(ctx, "Truncated message over the fetch limits", "folder", folderName, "uid", imapUID, "size", imapMsg.Size)
+	}
 
 	// Save message with body
-	if err := db.SaveMessage(ctx, s.dbPool, msg); err != nil {
+	if err := s.saveMessage(ctx, msg, stats); err != nil {
 		return fmt.Errorf("failed to save message: %w", err)
 	}
 
@@ -647,7 +1170,7 @@ func (s *Service) syncSingleMessage(ctx context.Context, client *imapclient.Clie
 	for _, att := range parsedMsg.Attachments {
 		att.MessageID = msg.ID
 		if err := db.SaveAttachment(ctx, s.dbPool, &att); err != nil {
-			log.Printf("Warning: Failed to save attachment: %v", err)
+			slog.WarnContext(ctx, "Failed to save attachment", "error", err)
 		}
 	}
 
@@ -655,7 +1178,24 @@ func (s *Service) syncSingleMessage(ctx context.Context, client *imapclient.Clie
 }
 
 // ShouldSyncFolder checks if we should sync the folder based on cache TTL.
+// It's always false for folders the user disabled syncing for, and while the server throttles the account.
 func (s *Service) ShouldSyncFolder(ctx context.Context, userID, folderName string) (bool, error) {
+	pref, err := db.GetFolderSyncPreference(ctx, s.dbPool, userID, folderName)
+	if err != nil {
+		return false, err
+	}
+	if !pref.Enabled {
+		return false, nil
+	}
+
+	throttle, err := db.GetSyncThrottle(ctx, s.dbPool, userID)
+	if err != nil {
+		return false, err
+	}
+	if isThrottled(throttle, time.Now()) {
+		return false, nil
+	}
+
 	syncInfo, err := db.GetFolderSyncInfo(ctx, s.dbPool, userID, folderName)
 	if err != nil {
 		return false, err
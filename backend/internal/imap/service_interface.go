@@ -2,6 +2,7 @@ package imap
 
 import (
 	"context"
+	"time"
 
 	"github.com/vdavid/vmail/backend/internal/models"
 	"github.com/vdavid/vmail/backend/internal/websocket"
@@ -20,11 +21,18 @@ type MessageToSync struct {
 //goland:noinspection GoNameStartsWithPackageName
 type IMAPService interface {
 	// ShouldSyncFolder checks if we should sync the folder based on cache TTL.
+	// It's always false for folders the user disabled syncing for, and while the server throttles the account.
 	ShouldSyncFolder(ctx context.Context, userID, folderName string) (bool, error)
 
 	// SyncThreadsForFolder syncs threads from IMAP for a specific folder.
+	// Returns ErrFolderSyncDisabled if the user disabled syncing for the folder,
+	// and ErrSyncThrottled while the server throttles the account.
 	SyncThreadsForFolder(ctx context.Context, userID, folderName string) error
 
+	// PreviewFolder fetches the newest messages of a folder directly from IMAP, without caching them.
+	// Returns single-message threads, newest first, and the number of messages in the folder.
+	PreviewFolder(ctx context.Context, userID, folderName string, limit int) ([]*models.Thread, int, error)
+
 	// SyncFullMessage syncs the full message body from IMAP.
 	SyncFullMessage(ctx context.Context, userID, folderName string, imapUID int64) error
 
@@ -32,6 +40,30 @@ type IMAPService interface {
 	// Messages are grouped by folder and synced efficiently.
 	SyncFullMessages(ctx context.Context, userID string, messages []MessageToSync) error
 
+	// AppendToSent saves a copy of a sent message to the user's Sent folder, marked as read.
+	AppendToSent(ctx context.Context, userID string, raw []byte, sentAt time.Time) error
+
+	// SaveDraft saves a draft to the user's Drafts folder, replacing the earlier copies with the same Message-ID.
+	SaveDraft(ctx context.Context, userID string, raw []byte, messageID string) error
+
+	// DeleteDraft deletes the copies of a draft from the user's Drafts folder.
+	DeleteDraft(ctx context.Context, userID, messageID string) error
+
+	// MoveMessages moves messages to another folder. Returns the name of the destination folder, and the new UIDs
+	// of the messages, in order. A new UID is 0 if the message couldn't be found in the destination after the move.
+	MoveMessages(ctx context.Context, userID string, messages []MessageToMove, destination MoveDestination) (string, []int64, error)
+
+	// StoreLabel adds a label to messages on the server, or removes it if add is false. Returns whether the server
+	// keeps the label of each message, in order. It's false in folders that don't allow new keywords.
+	StoreLabel(ctx context.Context, userID string, messages []MessageToSync, label string, add bool) ([]bool, error)
+
+	// MarkJunk adds the $Junk keyword to messages on the server and removes $NotJunk, or the other way around if junk
+	// is false, so that spam filters can learn from them.
+	MarkJunk(ctx context.Context, userID string, messages []MessageToSync, junk bool) error
+
+	// GetFolders lists the user's folders with their roles, including the roles that the user set by hand.
+	GetFolders(ctx context.Context, userID string) ([]*models.Folder, error)
+
 	// Search searches for threads matching the query.
 	// Returns threads, total count, and error.
 	Search(ctx context.Context, userID string, query string, page, limit int) ([]*models.Thread, int, error)
@@ -46,3 +78,51 @@ type IMAPService interface {
 
 // Ensure Service implements IMAPService interface
 var _ IMAPService = (*Service)(nil)
+
+// FolderManager manages the folders on the IMAP server. It's separate from IMAPService, since only the folder
+// management endpoints need it. The callers update the cache after the changes.
+type FolderManager interface {
+	// ListFolderSubscriptions lists all folders on the server, and tells which ones the user is subscribed to.
+	ListFolderSubscriptions(ctx context.Context, userID string) ([]models.FolderSubscription, error)
+
+	// SetFolderSubscribed subscribes the user to the folder, or unsubscribes them if subscribed is false.
+	// Returns ErrFolderNotFound when subscribing to a folder that doesn't exist.
+	SetFolderSubscribed(ctx context.Context, userID, folderName string, subscribed bool) error
+
+	// CreateFolder creates a folder and subscribes the user to it. Returns ErrFolderExists if it already exists.
+	CreateFolder(ctx context.Context, userID, folderName string) (*models.FolderSubscription, error)
+
+	// RenameFolder renames a folder and its subfolders. Returns ErrFolderNotFound if the folder doesn't exist,
+	// and ErrFolderExists if one with the new name does.
+	RenameFolder(ctx context.Context, userID, oldName, newName string) (*models.FolderSubscription, error)
+
+	// DeleteFolder deletes a folder and its messages. Returns ErrFolderNotFound if the folder doesn't exist.
+	DeleteFolder(ctx context.Context, userID, folderName string) error
+}
+
+// Ensure Service implements FolderManager interface
+var _ FolderManager = (*Service)(nil)
+
+// RawMessageFetcher fetches the source of messages from the IMAP server. It's separate from IMAPService, since only
+// the raw message endpoint needs it.
+type RawMessageFetcher interface {
+	// FetchRawMessage fetches the RFC 822 source of a message, without marking it as read.
+	// Returns ErrMessageNotFound if the server no longer has the message.
+	FetchRawMessage(ctx context.Context, userID, folderName string, imapUID int64) (*RawMessage, error)
+}
+
+// Ensure Service implements RawMessageFetcher interface
+var _ RawMessageFetcher = (*Service)(nil)
+
+// NewMailNotifier tells users about new mail outside the app, like the push package's Notifier.
+type NewMailNotifier interface {
+	// NotifyNewMail notifies the user about the new messages with the UIDs in the folder, if they want to know.
+	NotifyNewMail(ctx context.Context, userID, folderName string, uids []uint32)
+}
+
+// AutoResponder replies to new mail while users are away, like the vacation package's Responder.
+type AutoResponder interface {
+	// RespondToNewMail replies to the new INBOX messages, if the user has an auto-responder that's on.
+	// The messages are only the ones that may get automatic replies, see autoReplyMessages.
+	RespondToNewMail(ctx context.Context, userID string, messages []models.AutoReplyMessage)
+}
@@ -106,7 +106,7 @@ func TestTryIncrementalSync(t *testing.T) {
 	defer clientCleanup()
 
 	encryptor := getTestEncryptor(t)
-	service := NewService(pool, NewPool(), encryptor)
+	service := NewService(pool, NewPool(), encryptor, nil)
 	defer service.Close()
 
 	userID, err := db.GetOrCreateUser(ctx, pool, "incremental-test@example.com")
@@ -272,7 +272,7 @@ func TestProcessIncrementalMessage(t *testing.T) {
 	}
 
 	encryptor := getTestEncryptor(t)
-	service := NewService(pool, NewPool(), encryptor)
+	service := NewService(pool, NewPool(), encryptor, nil)
 	defer service.Close()
 
 	userID, err := db.GetOrCreateUser(ctx, pool, "process-test@example.com")
@@ -304,7 +304,7 @@ func TestProcessIncrementalMessage(t *testing.T) {
 			Flags: []string{imap.SeenFlag},
 		}
 
-		err := service.processIncrementalMessage(ctx, imapMsg, userID, folderName)
+		err := service.processIncrementalMessage(ctx, imapMsg, userID, folderName, nil)
 		if err != nil {
 			t.Fatalf("processIncrementalMessage failed: %v", err)
 		}
@@ -358,7 +358,7 @@ func TestProcessIncrementalMessage(t *testing.T) {
 			Flags: []string{imap.SeenFlag},
 		}
 
-		err = service.processIncrementalMessage(ctx, imapMsg, userID, folderName)
+		err = service.processIncrementalMessage(ctx, imapMsg, userID, folderName, nil)
 		if err != nil {
 			t.Fatalf("processIncrementalMessage failed: %v", err)
 		}
@@ -420,7 +420,7 @@ func TestProcessIncrementalMessage(t *testing.T) {
 			Flags: []string{imap.SeenFlag},
 		}
 
-		err = service.processIncrementalMessage(ctx, imapMsg, userID, folderName)
+		err = service.processIncrementalMessage(ctx, imapMsg, userID, folderName, nil)
 		if err != nil {
 			t.Fatalf("processIncrementalMessage failed: %v", err)
 		}
@@ -435,6 +435,37 @@ func TestProcessIncrementalMessage(t *testing.T) {
 		}
 	})
 
+	t.Run("matches existing thread by the message it replies to", func(t *testing.T) {
+		thread, err := db.GetThreadByStableID(ctx, pool, userID, "<root@test>")
+		if err != nil {
+			t.Fatalf("Failed to get thread: %v", err)
+		}
+
+		// A new reply to "<reply@test>", which the previous test cached
+		imapMsg := &imap.Message{
+			Uid: 5,
+			Envelope: &imap.Envelope{
+				MessageId: "<reply-to-reply@test>",
+				InReplyTo: "<reply@test>",
+				Subject:   "Re: Root Thread",
+				Date:      time.Now(),
+			},
+		}
+
+		err = service.processIncrementalMessage(ctx, imapMsg, userID, folderName, nil)
+		if err != nil {
+			t.Fatalf("processIncrementalMessage failed: %v", err)
+		}
+
+		msg, err := db.GetMessageByMessageID(ctx, pool, userID, "<reply-to-reply@test>")
+		if err != nil {
+			t.Fatalf("Failed to get message: %v", err)
+		}
+		if msg.ThreadID != thread.ID {
+			t.Errorf("Message should be in the replied thread %s, got %s", thread.ID, msg.ThreadID)
+		}
+	})
+
 	t.Run("skips message without Message-ID", func(t *testing.T) {
 		imapMsg := &imap.Message{
 			Uid: 3,
@@ -446,7 +477,7 @@ func TestProcessIncrementalMessage(t *testing.T) {
 			Flags: []string{imap.SeenFlag},
 		}
 
-		err := service.processIncrementalMessage(ctx, imapMsg, userID, folderName)
+		err := service.processIncrementalMessage(ctx, imapMsg, userID, folderName, nil)
 		if err != nil {
 			t.Fatalf("processIncrementalMessage should not fail for message without Message-ID: %v", err)
 		}
@@ -3,11 +3,14 @@ package imap
 import (
 	"context"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/vdavid/vmail/backend/internal/crypto"
 	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
 	"github.com/vdavid/vmail/backend/internal/testutil"
 )
 
@@ -33,7 +36,7 @@ func TestShouldSyncFolder(t *testing.T) {
 	}
 
 	encryptor := getTestEncryptor(t)
-	service := NewService(pool, NewPool(), encryptor)
+	service := NewService(pool, NewPool(), encryptor, nil)
 	defer service.Close()
 
 	userID, err := db.GetOrCreateUser(ctx, pool, "sync-test@example.com")
@@ -87,6 +90,25 @@ func TestShouldSyncFolder(t *testing.T) {
 			t.Error("Expected ShouldSyncFolder to return true when cache is stale (older than 5 minutes)")
 		}
 	})
+
+	t.Run("returns false when sync is disabled for the folder", func(t *testing.T) {
+		pref := &models.FolderSyncPreference{FolderName: folderName, Enabled: false, Mode: models.FolderSyncModeHeadersOnly}
+		if err := db.SaveFolderSyncPreference(ctx, pool, userID, pref); err != nil {
+			t.Fatalf("SaveFolderSyncPreference failed: %v", err)
+		}
+
+		shouldSync, err := service.ShouldSyncFolder(ctx, userID, folderName)
+		if err != nil {
+			t.Fatalf("ShouldSyncFolder failed: %v", err)
+		}
+		if shouldSync {
+			t.Error("Expected ShouldSyncFolder to return false for a disabled folder")
+		}
+
+		if err := service.SyncThreadsForFolder(ctx, userID, folderName); !errors.Is(err, ErrFolderSyncDisabled) {
+			t.Errorf("Expected ErrFolderSyncDisabled, got %v", err)
+		}
+	})
 }
 
 func getTestEncryptor(t *testing.T) *crypto.Encryptor {
@@ -199,7 +221,7 @@ func TestService_updateThreadCountInBackground(t *testing.T) {
 	defer pool.Close()
 
 	encryptor := getTestEncryptor(t)
-	service := NewService(pool, NewPool(), encryptor)
+	service := NewService(pool, NewPool(), encryptor, nil)
 	defer service.Close()
 
 	ctx := context.Background()
@@ -217,7 +239,7 @@ func TestService_updateThreadCountInBackground(t *testing.T) {
 		invalidUserID := "00000000-0000-0000-0000-000000000000"
 
 		// The function should log a warning but not crash
-		service.updateThreadCountInBackground(invalidUserID, "NonExistentFolder")
+		service.updateThreadCountInBackground(context.Background(), invalidUserID, "NonExistentFolder")
 
 		// Give the goroutine time to complete
 		time.Sleep(200 * time.Millisecond)
@@ -229,7 +251,7 @@ func TestService_updateThreadCountInBackground(t *testing.T) {
 
 	t.Run("succeeds with valid database connection", func(t *testing.T) {
 		// Test that the function works correctly with a valid connection
-		service.updateThreadCountInBackground(userID, folderName)
+		service.updateThreadCountInBackground(context.Background(), userID, folderName)
 
 		// Give the goroutine time to complete
 		time.Sleep(100 * time.Millisecond)
@@ -238,3 +260,27 @@ func TestService_updateThreadCountInBackground(t *testing.T) {
 		// If there's a panic, the test will fail
 	})
 }
+
+// fakeNewMailNotifier records the folders and UIDs it's told about.
+type fakeNewMailNotifier struct {
+	calls []string
+}
+
+func (n *fakeNewMailNotifier) NotifyNewMail(_ context.Context, userID, folderName string, uids []uint32) {
+	n.calls = append(n.calls, fmt.Sprintf("%s %s %v", userID, folderName, uids))
+}
+
+func TestService_notifyNewMailInBackground(t *testing.T) {
+	notifier := &fakeNewMailNotifier{}
+	service := &Service{}
+	service.SetNewMailNotifier(notifier)
+	ctx := context.Background()
+
+	service.notifyNewMailInBackground(ctx, "user", "INBOX", []uint32{1, 2})
+	service.notifyNewMailInBackground(ctx, "user", "Archive", []uint32{3})
+	service.notifyNewMailInBackground(ctx, "user", "INBOX", nil)
+
+	if len(notifier.calls) != 2 || notifier.calls[0] != "user INBOX [1 2]" || notifier.calls[1] != "user Archive [3]" {
+		t.Errorf("Expected the folders with new messages, got %v", notifier.calls)
+	}
+}
@@ -0,0 +1,49 @@
+package imap
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// SnippetLength is the most characters we keep of a message's body as its snippet.
+const SnippetLength = 200
+
+var (
+	// invisibleHTMLPattern matches the elements whose content never shows up as text.
+	invisibleHTMLPattern = regexp.MustCompile(`(?is)<(head|style|script|title)\b.*?</(head|style|script|title)\s*>|<!--.*?-->`)
+	// blockHTMLPattern matches the tags that start a new line, so that their text doesn't run together.
+	blockHTMLPattern = regexp.MustCompile(`(?i)<(br|p|div|li|tr|td|h[1-6])\b[^>]*>`)
+	htmlTagPattern   = regexp.MustCompile(`<[^>]*>`)
+)
+
+// ExtractSnippet returns the start of the message's text for list views, with whitespace collapsed.
+// It prefers the text body, and strips the tags of the HTML body if there's no text body.
+func ExtractSnippet(bodyText, bodyHTML string) string {
+	text := bodyText
+	if strings.TrimSpace(text) == "" {
+		text = stripHTML(bodyHTML)
+	}
+
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= SnippetLength {
+		return text
+	}
+
+	// Cut at the last space, unless that throws away most of the snippet
+	runes := []rune(text)[:SnippetLength]
+	cut := string(runes)
+	if space := strings.LastIndexByte(cut, ' '); space > len(cut)/2 {
+		cut = cut[:space]
+	}
+	return cut
+}
+
+// stripHTML turns HTML into plain text. It's only meant for snippets, so it doesn't keep any formatting.
+func stripHTML(body string) string {
+	body = invisibleHTMLPattern.ReplaceAllString(body, " ")
+	body = blockHTMLPattern.ReplaceAllString(body, " ")
+	body = htmlTagPattern.ReplaceAllString(body, "")
+	return html.UnescapeString(body)
+}
@@ -0,0 +1,49 @@
+package imap
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestExtractSnippet(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		html     string
+		expected string
+	}{
+		{"collapses whitespace in the text body", "  Hi Alice,\n\n  see you   soon!\n", "", "Hi Alice, see you soon!"},
+		{"prefers the text body", "Plain text", "<p>HTML</p>", "Plain text"},
+		{"strips HTML tags", "", "<p>Hi <b>Alice</b>,</p><p>see you</p>", "Hi Alice, see you"},
+		{"keeps words on separate lines apart", "", "One<br>two<div>three</div>", "One two three"},
+		{"drops styles, scripts, and comments", "", "<head><title>T</title><style>p { color: red; }</style></head><!-- x --><script>alert(1)</script><p>Body</p>", "Body"},
+		{"decodes entities", "", "<p>Tom &amp; Jerry&nbsp;&lt;3</p>", "Tom & Jerry <3"},
+		{"returns nothing for empty bodies", "", "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ExtractSnippet(tc.text, tc.html); got != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+
+	t.Run("cuts long bodies at a word", func(t *testing.T) {
+		snippet := ExtractSnippet(strings.Repeat("wörd ", 100), "")
+		if utf8.RuneCountInString(snippet) > SnippetLength {
+			t.Errorf("Expected at most %d characters, got %d", SnippetLength, utf8.RuneCountInString(snippet))
+		}
+		if !strings.HasSuffix(snippet, "wörd") {
+			t.Errorf("Expected the snippet to end with a whole word, got %q", snippet)
+		}
+	})
+
+	t.Run("cuts long words", func(t *testing.T) {
+		snippet := ExtractSnippet(strings.Repeat("x", 500), "")
+		if len(snippet) != SnippetLength {
+			t.Errorf("Expected %d characters, got %d", SnippetLength, len(snippet))
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package imap
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// spoolMemoryLimit is how many parsed messages a sync keeps in memory before it spills them to disk.
+// A parsed header is about a kilobyte, so this keeps the spool at around a megabyte per sync.
+const spoolMemoryLimit = 1000
+
+// spoolRecord is a parsed message header that waits to be written to the database.
+// The message doesn't have a thread ID yet, since its thread root might come later in the fetch.
+type spoolRecord struct {
+	RootUID uint32
+	Message models.Message
+}
+
+// messageSpool buffers the parsed messages of a sync between the IMAP fetch and the database writer.
+// It keeps up to memoryLimit records in memory, and writes each full buffer to a temporary file as one batch,
+// so memory use stays flat no matter how big the folder is. Drain returns the records in the order they came in.
+// It's not safe for concurrent use.
+type messageSpool struct {
+	dir         string
+	memoryLimit int
+	buffer      []spoolRecord
+
+	file    *os.File
+	writer  *bufio.Writer
+	encoder *gob.Encoder
+	batches int
+}
+
+// newMessageSpool creates a spool that spills to a temporary file in dir, or in the default temporary directory
+// if dir is empty. The file is only created once the first batch spills.
+func newMessageSpool(dir string, memoryLimit int) *messageSpool {
+	if memoryLimit < 1 {
+		memoryLimit = 1
+	}
+	return &messageSpool{
+		dir:         dir,
+		memoryLimit: memoryLimit,
+		buffer:      make([]spoolRecord, 0, memoryLimit),
+	}
+}
+
+// Add adds a record, and spills the buffer to disk if it's full.
+func (sp *messageSpool) Add(record spoolRecord) error {
+	sp.buffer = append(sp.buffer, record)
+	if len(sp.buffer) < sp.memoryLimit {
+		return nil
+	}
+	return sp.spill()
+}
+
+// spill writes the buffer to the spool file as one batch, and empties the buffer.
+func (sp *messageSpool) spill() error {
+	if sp.file == nil {
+		file, err := os.CreateTemp(sp.dir, "vmail-sync-*.spool")
+		if err != nil {
+			return fmt.Errorf("failed to create spool file: %w", err)
+		}
+		sp.file = file
+		sp.writer = bufio.NewWriter(file)
+		sp.encoder = gob.NewEncoder(sp.writer)
+	}
+
+	if err := sp.encoder.Encode(sp.buffer); err != nil {
+		return fmt.Errorf("failed to write spool batch: %w", err)
+	}
+	sp.batches++
+	sp.buffer = sp.buffer[:0]
+	return nil
+}
+
+// SpilledBatches returns how many batches went to disk.
+func (sp *messageSpool) SpilledBatches() int {
+	return sp.batches
+}
+
+// Drain calls fn with the records in batches of at most memoryLimit, in the order they were added:
+// first the batches from disk, then the ones still in memory. It stops at the first error from fn.
+// Don't add records after calling Drain.
+func (sp *messageSpool) Drain(fn func([]spoolRecord) error) error {
+	if sp.file != nil {
+		if err := sp.writer.Flush(); err != nil {
+			return fmt.Errorf("failed to flush spool file: %w", err)
+		}
+		if _, err := sp.file.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to rewind spool file: %w", err)
+		}
+		decoder := gob.NewDecoder(bufio.NewReader(sp.file))
+		for i := 0; i < sp.batches; i++ {
+			var batch []spoolRecord
+			if err := decoder.Decode(&batch); err != nil {
+				return fmt.Errorf("failed to read spool batch: %w", err)
+			}
+			if err := fn(batch); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(sp.buffer) == 0 {
+		return nil
+	}
+	return fn(sp.buffer)
+}
+
+// Close removes the spool file, if there is one.
+func (sp *messageSpool) Close() error {
+	if sp.file == nil {
+		return nil
+	}
+	name := sp.file.Name()
+	closeErr := sp.file.Close()
+	removeErr := os.Remove(name)
+	sp.file = nil
+	return errors.Join(closeErr, removeErr)
+}
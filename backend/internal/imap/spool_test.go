@@ -0,0 +1,101 @@
+package imap
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+func TestMessageSpool(t *testing.T) {
+	addRecords := func(t *testing.T, spool *messageSpool, count int) {
+		t.Helper()
+		for i := 1; i <= count; i++ {
+			record := spoolRecord{RootUID: uint32(i), Message: models.Message{IMAPUID: int64(i), Subject: "Subject", ToAddresses: []string{"a@example.com"}}}
+			if err := spool.Add(record); err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+		}
+	}
+	drainUIDs := func(t *testing.T, spool *messageSpool) ([]int64, int) {
+		t.Helper()
+		var uids []int64
+		batches := 0
+		err := spool.Drain(func(batch []spoolRecord) error {
+			batches++
+			for _, record := range batch {
+				uids = append(uids, record.Message.IMAPUID)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Drain failed: %v", err)
+		}
+		return uids, batches
+	}
+
+	t.Run("keeps small syncs in memory", func(t *testing.T) {
+		dir := t.TempDir()
+		spool := newMessageSpool(dir, 10)
+		defer func() { _ = spool.Close() }()
+		addRecords(t, spool, 5)
+
+		if spool.SpilledBatches() != 0 {
+			t.Errorf("Expected no spilled batches, got %d", spool.SpilledBatches())
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("Expected no spool file, got %d files", len(entries))
+		}
+		uids, _ := drainUIDs(t, spool)
+		if len(uids) != 5 {
+			t.Errorf("Expected 5 records, got %d", len(uids))
+		}
+	})
+
+	t.Run("spills to disk and returns records in order", func(t *testing.T) {
+		dir := t.TempDir()
+		spool := newMessageSpool(dir, 3)
+		addRecords(t, spool, 8)
+
+		if spool.SpilledBatches() != 2 {
+			t.Errorf("Expected 2 spilled batches, got %d", spool.SpilledBatches())
+		}
+		uids, batches := drainUIDs(t, spool)
+		if batches != 3 {
+			t.Errorf("Expected 3 batches, got %d", batches)
+		}
+		for i, uid := range uids {
+			if uid != int64(i+1) {
+				t.Fatalf("Expected UIDs 1-8 in order, got %v", uids)
+			}
+		}
+		if len(uids) != 8 {
+			t.Errorf("Expected 8 records, got %d", len(uids))
+		}
+
+		if err := spool.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		if matches, _ := filepath.Glob(filepath.Join(dir, "*.spool")); len(matches) != 0 {
+			t.Errorf("Expected the spool file to be removed, got %v", matches)
+		}
+	})
+
+	t.Run("stops at the first error", func(t *testing.T) {
+		spool := newMessageSpool(t.TempDir(), 2)
+		defer func() { _ = spool.Close() }()
+		addRecords(t, spool, 5)
+
+		errStop := errors.New("stop")
+		calls := 0
+		err := spool.Drain(func([]spoolRecord) error {
+			calls++
+			return errStop
+		})
+		if !errors.Is(err, errStop) || calls != 1 {
+			t.Errorf("Expected one call and errStop, got %d calls and %v", calls, err)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package imap
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// syncDepthUIDs returns the UIDs of the messages within the folder's sync depth: the ones that arrived in the last
+// SyncDays days, and the newest SyncMaxMessages of mailboxUIDs. Returns nil if the folder has no limit.
+// The server decides the days by the internal date, so it can differ from the retention cleaner by a day or so,
+// which goes by the Date header. The folder must be selected.
+func syncDepthUIDs(c *client.Client, pref *models.FolderSyncPreference, mailboxUIDs []uint32, now time.Time) (map[uint32]bool, error) {
+	if pref.SyncDays == nil && pref.SyncMaxMessages == nil {
+		return nil, nil
+	}
+
+	uids := mailboxUIDs
+	if pref.SyncMaxMessages != nil {
+		uids = newestUIDs(uids, *pref.SyncMaxMessages)
+	}
+	allowed := make(map[uint32]bool, len(uids))
+	for _, uid := range uids {
+		allowed[uid] = true
+	}
+
+	if pref.SyncDays != nil {
+		criteria := imap.NewSearchCriteria()
+		criteria.Since = now.AddDate(0, 0, -*pref.SyncDays)
+		recentUIDs, err := c.UidSearch(criteria)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search for recent UIDs: %w", err)
+		}
+		recent := make(map[uint32]bool, len(recentUIDs))
+		for _, uid := range recentUIDs {
+			recent[uid] = true
+		}
+		for uid := range allowed {
+			if !recent[uid] {
+				delete(allowed, uid)
+			}
+		}
+	}
+
+	return allowed, nil
+}
+
+// newestUIDs returns the n highest UIDs, in ascending order. UIDs grow with arrival, so they're the newest messages.
+func newestUIDs(uids []uint32, n int) []uint32 {
+	sorted := slices.Clone(uids)
+	slices.Sort(sorted)
+	if len(sorted) > n {
+		sorted = sorted[len(sorted)-n:]
+	}
+	return sorted
+}
+
+// filterUIDs returns the UIDs in the allowed set, in their original order. A nil set allows all UIDs.
+func filterUIDs(uids []uint32, allowed map[uint32]bool) []uint32 {
+	if allowed == nil {
+		return uids
+	}
+	filtered := make([]uint32, 0, len(uids))
+	for _, uid := range uids {
+		if allowed[uid] {
+			filtered = append(filtered, uid)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,29 @@
+package imap
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNewestUIDs(t *testing.T) {
+	uids := []uint32{5, 1, 9, 3}
+	if got := newestUIDs(uids, 2); !slices.Equal(got, []uint32{5, 9}) {
+		t.Errorf("Expected [5 9], got %v", got)
+	}
+	if got := newestUIDs(uids, 10); !slices.Equal(got, []uint32{1, 3, 5, 9}) {
+		t.Errorf("Expected all UIDs, got %v", got)
+	}
+	if !slices.Equal(uids, []uint32{5, 1, 9, 3}) {
+		t.Errorf("Expected the input to stay unchanged, got %v", uids)
+	}
+}
+
+func TestFilterUIDs(t *testing.T) {
+	uids := []uint32{5, 1, 9, 3}
+	if got := filterUIDs(uids, map[uint32]bool{9: true, 1: true}); !slices.Equal(got, []uint32{1, 9}) {
+		t.Errorf("Expected [1 9], got %v", got)
+	}
+	if got := filterUIDs(uids, nil); !slices.Equal(got, uids) {
+		t.Errorf("Expected a nil set to keep all UIDs, got %v", got)
+	}
+}
@@ -0,0 +1,27 @@
+package imap
+
+import (
+	"context"
+
+	"github.com/vdavid/vmail/backend/internal/websocket"
+)
+
+type syncJobIDKey struct{}
+
+// WithSyncJobID returns a context for a sync that runs as a job, so that the sync events it publishes carry the
+// job ID. Clients that started the job, for example, with POST /api/v1/sync, use it to find its events.
+func WithSyncJobID(ctx context.Context, jobID string) context.Context {
+	return context.WithValue(ctx, syncJobIDKey{}, jobID)
+}
+
+// syncJobIDFromContext returns the sync job ID of the context, or "" if the sync doesn't run as a job.
+func syncJobIDFromContext(ctx context.Context) string {
+	jobID, _ := ctx.Value(syncJobIDKey{}).(string)
+	return jobID
+}
+
+// publishSyncEvent publishes a sync event to the user's clients, with the sync job ID of the context.
+func (s *Service) publishSyncEvent(ctx context.Context, userID string, event websocket.Event) {
+	event.JobID = syncJobIDFromContext(ctx)
+	s.hub.Publish(userID, event)
+}
@@ -1,7 +1,15 @@
 package imap
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io"
+	"maps"
+	"net/textproto"
+	"regexp"
+	"slices"
+	"strings"
 
 	"github.com/emersion/go-imap"
 	sortthread "github.com/emersion/go-imap-sortthread"
@@ -29,3 +37,226 @@ func RunThreadCommand(c *client.Client) ([]*sortthread.Thread, error) {
 
 	return threads, nil
 }
+
+// threadTreeProblems counts the inconsistencies in a THREAD response.
+type threadTreeProblems struct {
+	// missingUIDs are in the tree, but not in the mailbox.
+	missingUIDs int
+	// duplicateUIDs are in the tree more than once.
+	duplicateUIDs int
+	// unthreadedUIDs are in the mailbox, but not in the tree.
+	unthreadedUIDs int
+}
+
+// any tells whether the tree had any problems.
+func (p threadTreeProblems) any() bool {
+	return p.missingUIDs > 0 || p.duplicateUIDs > 0 || p.unthreadedUIDs > 0
+}
+
+// validateThreadTree checks a THREAD response against the UIDs in the mailbox, since some servers return trees
+// with UIDs that aren't in the mailbox, or with the same UID in more than one thread.
+// Returns the threads without problems, and the mailbox UIDs that the other threads have, plus the ones
+// missing from the tree, sorted. The caller should thread those by their references. See buildReferenceThreadMaps.
+func validateThreadTree(threads []*sortthread.Thread, mailboxUIDs []uint32) ([]*sortthread.Thread, []uint32, threadTreeProblems) {
+	var problems threadTreeProblems
+	inMailbox := make(map[uint32]bool, len(mailboxUIDs))
+	for _, uid := range mailboxUIDs {
+		inMailbox[uid] = true
+	}
+
+	// Collect the UIDs of each thread, and count how often each UID appears in the whole tree
+	threadUIDs := make([][]uint32, len(threads))
+	counts := make(map[uint32]int)
+	var walk func(thread *sortthread.Thread, uids *[]uint32)
+	walk = func(thread *sortthread.Thread, uids *[]uint32) {
+		if thread == nil {
+			return
+		}
+		*uids = append(*uids, thread.Id)
+		counts[thread.Id]++
+		for _, child := range thread.Children {
+			walk(child, uids)
+		}
+	}
+	for i, thread := range threads {
+		walk(thread, &threadUIDs[i])
+	}
+	for uid, count := range counts {
+		if !inMailbox[uid] {
+			problems.missingUIDs++
+		}
+		if count > 1 {
+			problems.duplicateUIDs++
+		}
+	}
+
+	var valid []*sortthread.Thread
+	affected := make(map[uint32]bool)
+	for i, thread := range threads {
+		ok := thread != nil && !slices.ContainsFunc(threadUIDs[i], func(uid uint32) bool {
+			return !inMailbox[uid] || counts[uid] > 1
+		})
+		if ok {
+			valid = append(valid, thread)
+			continue
+		}
+		for _, uid := range threadUIDs[i] {
+			if inMailbox[uid] {
+				affected[uid] = true
+			}
+		}
+	}
+	for _, uid := range mailboxUIDs {
+		if counts[uid] == 0 {
+			problems.unthreadedUIDs++
+			affected[uid] = true
+		}
+	}
+
+	affectedUIDs := slices.Sorted(maps.Keys(affected))
+	return valid, affectedUIDs, problems
+}
+
+// headerFieldsSection is the part of the header that threading without the THREAD command needs, and that tells
+// auto-responders which messages not to reply to, see autoReplyMessages. StreamMessageHeaders fetches it.
+// The envelope has In-Reply-To, but not References.
+var headerFieldsSection = &imap.BodySectionName{
+	BodyPartName: imap.BodyPartName{
+		Specifier: imap.HeaderSpecifier,
+		Fields:    append([]string{"References", "In-Reply-To"}, autoReplyHeaderFields...),
+	},
+	Peek: true,
+}
+
+// messageIDPattern matches the Message-IDs in a References or In-Reply-To header.
+var messageIDPattern = regexp.MustCompile(`<[^<>\s]+>`)
+
+// messageReferences returns the Message-IDs that the message replies to, from its References and In-Reply-To
+// headers, oldest first. The last one is the message it directly replies to.
+// Reading them doesn't use up the fetched section, so it can be called more than once.
+func messageReferences(imapMsg *imap.Message) []string {
+	header := fetchedHeader(imapMsg)
+	inReplyTo := header.Get("In-Reply-To")
+	if inReplyTo == "" && imapMsg.Envelope != nil {
+		inReplyTo = imapMsg.Envelope.InReplyTo
+	}
+	return parseReferences(header.Get("References"), inReplyTo)
+}
+
+// fetchedHeader reads the headerFieldsSection of the message, and puts it back, so that it can be read again.
+// Returns an empty header if the message doesn't have the section.
+func fetchedHeader(imapMsg *imap.Message) textproto.MIMEHeader {
+	responseSection := *headerFieldsSection
+	responseSection.Peek = false // The section in the response doesn't have the PEEK
+	for section, body := range imapMsg.Body {
+		if body == nil || !responseSection.Equal(section) {
+			continue
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return textproto.MIMEHeader{}
+		}
+		imapMsg.Body[section] = bytes.NewReader(data)
+		// The section ends with the blank line after the header, so reading it doesn't fail on well-formed responses
+		header, _ := textproto.NewReader(bufio.NewReader(bytes.NewReader(data))).ReadMIMEHeader()
+		return header
+	}
+	return textproto.MIMEHeader{}
+}
+
+// parseReferences returns the Message-IDs in the References and In-Reply-To header values, oldest first,
+// with the one from In-Reply-To last.
+func parseReferences(references, inReplyTo string) []string {
+	ids := messageIDPattern.FindAllString(references, -1)
+	// In-Reply-To should be the last reference, but some clients only set In-Reply-To
+	if parents := messageIDPattern.FindAllString(inReplyTo, -1); len(parents) > 0 {
+		parent := parents[0]
+		if len(ids) == 0 || ids[len(ids)-1] != parent {
+			ids = append(slices.DeleteFunc(ids, func(id string) bool { return id == parent }), parent)
+		}
+	}
+	return ids
+}
+
+// threadHeaders is what threading by references needs of a message. It's much smaller than the message,
+// so that threading a big folder doesn't keep all its headers in memory.
+type threadHeaders struct {
+	uid       uint32
+	messageID string
+	subject   string
+	// references are the Message-IDs that the message replies to. See messageReferences.
+	references []string
+}
+
+// threadHeadersOf returns the threading headers of a fetched message.
+// Messages without a Message-ID get an empty messageID and no references.
+func threadHeadersOf(imapMsg *imap.Message) threadHeaders {
+	headers := threadHeaders{uid: imapMsg.Uid}
+	if imapMsg.Envelope == nil || strings.TrimSpace(imapMsg.Envelope.MessageId) == "" {
+		return headers
+	}
+	headers.messageID = strings.TrimSpace(imapMsg.Envelope.MessageId)
+	headers.subject = imapMsg.Envelope.Subject
+	headers.references = messageReferences(imapMsg)
+	return headers
+}
+
+// buildReferenceThreadMaps threads messages by their References and In-Reply-To headers, for servers without the
+// THREAD command. Messages that reference each other, or a common message, end up in the same thread, even if the
+// common message isn't in the folder. The root of each thread is its message with the fewest references, which is
+// the oldest one in a well-behaved thread, like the THREAD command picks it. Messages without a Message-ID are
+// threads of their own.
+func buildReferenceThreadMaps(messages []threadHeaders) *threadMaps {
+	maps := &threadMaps{
+		allUIDs:         make([]uint32, 0, len(messages)),
+		uidToThreadRoot: make(map[uint32]uint32, len(messages)),
+		rootUIDs:        make([]uint32, 0),
+	}
+
+	// Union-find over Message-IDs
+	parents := make(map[string]string)
+	find := func(id string) string {
+		if _, ok := parents[id]; !ok {
+			parents[id] = id
+		}
+		for parents[id] != id {
+			parents[id] = parents[parents[id]]
+			id = parents[id]
+		}
+		return id
+	}
+
+	keys := make([]string, len(messages))
+	for i, msg := range messages {
+		keys[i] = fmt.Sprintf("uid:%d", msg.uid)
+		if msg.messageID == "" {
+			continue
+		}
+		keys[i] = msg.messageID
+		for _, reference := range msg.references {
+			parents[find(reference)] = find(keys[i])
+		}
+	}
+
+	// Pick the root of each thread: the fewest references, then the lowest UID
+	rootIndexes := make(map[string]int)
+	for i, msg := range messages {
+		group := find(keys[i])
+		rootIndex, ok := rootIndexes[group]
+		if !ok || len(msg.references) < len(messages[rootIndex].references) ||
+			(len(msg.references) == len(messages[rootIndex].references) && msg.uid < messages[rootIndex].uid) {
+			rootIndexes[group] = i
+		}
+	}
+
+	for i, msg := range messages {
+		rootUID := messages[rootIndexes[find(keys[i])]].uid
+		maps.allUIDs = append(maps.allUIDs, msg.uid)
+		maps.uidToThreadRoot[msg.uid] = rootUID
+		if rootUID == msg.uid {
+			maps.rootUIDs = append(maps.rootUIDs, rootUID)
+		}
+	}
+
+	return maps
+}
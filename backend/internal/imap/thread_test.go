@@ -1,9 +1,15 @@
 package imap
 
 import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/emersion/go-imap"
+	sortthread "github.com/emersion/go-imap-sortthread"
 	"github.com/vdavid/vmail/backend/internal/testutil"
 )
 
@@ -146,3 +152,173 @@ func TestRunThreadCommand(t *testing.T) {
 		}
 	})
 }
+
+func TestBuildReferenceThreadMaps(t *testing.T) {
+	server := testutil.NewTestIMAPServer(t)
+	defer server.Close()
+	server.EnsureINBOX(t)
+
+	client, cleanup := server.Connect(t)
+	defer cleanup()
+
+	// The root of the second thread isn't in the folder, so its replies only share a reference
+	headers := []string{
+		"Message-ID: <root@example.com>",
+		"Message-ID: <reply@example.com>\r\nIn-Reply-To: <root@example.com>\r\nReferences: <root@example.com>",
+		"Message-ID: <other@example.com>",
+		"Message-ID: <reply2@example.com>\r\nIn-Reply-To: <reply@example.com>\r\nReferences: <root@example.com>\r\n <reply@example.com>",
+		"Message-ID: <missing-a@example.com>\r\nReferences: <missing-root@example.com>",
+		"Message-ID: <missing-b@example.com>\r\nIn-Reply-To: <missing-root@example.com>",
+	}
+	for i, header := range headers {
+		raw := fmt.Sprintf("%s\r\nSubject: Message %d\r\nFrom: from@example.com\r\n\r\nBody\r\n", header, i)
+		if err := client.Append("INBOX", nil, time.Now(), strings.NewReader(raw)); err != nil {
+			t.Fatalf("Failed to append message: %v", err)
+		}
+	}
+	if _, err := client.Select("INBOX", false); err != nil {
+		t.Fatalf("Failed to select INBOX: %v", err)
+	}
+	uids, err := SearchUIDsSince(client, 1)
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	messages, err := FetchMessageHeaders(client, uids)
+	if err != nil {
+		t.Fatalf("Failed to fetch message headers: %v", err)
+	}
+
+	uidsByMessageID := make(map[string]uint32)
+	for _, msg := range messages {
+		uidsByMessageID[msg.Envelope.MessageId] = msg.Uid
+		if msg.Envelope.MessageId == "<reply2@example.com>" {
+			if references := messageReferences(msg); !slices.Equal(references, []string{"<root@example.com>", "<reply@example.com>"}) {
+				t.Errorf("Expected the references of reply2 in order, got %v", references)
+			}
+		}
+	}
+
+	fetched := make([]threadHeaders, len(messages))
+	for i, msg := range messages {
+		fetched[i] = threadHeadersOf(msg)
+	}
+	maps := buildReferenceThreadMaps(fetched)
+	rootOf := func(messageID string) uint32 {
+		return maps.uidToThreadRoot[uidsByMessageID[messageID]]
+	}
+	for _, messageID := range []string{"<root@example.com>", "<reply@example.com>", "<reply2@example.com>"} {
+		if rootOf(messageID) != uidsByMessageID["<root@example.com>"] {
+			t.Errorf("Expected %s to be in the thread of <root@example.com>", messageID)
+		}
+	}
+	if rootOf("<other@example.com>") != uidsByMessageID["<other@example.com>"] {
+		t.Error("Expected <other@example.com> to be a thread of its own")
+	}
+	if rootOf("<missing-a@example.com>") != uidsByMessageID["<missing-a@example.com>"] ||
+		rootOf("<missing-b@example.com>") != uidsByMessageID["<missing-a@example.com>"] {
+		t.Error("Expected the messages with a missing root to be in one thread, rooted at the first of them")
+	}
+	// The memory backend's INBOX has a sample message, which is a thread of its own
+	expectedThreads := 3 + len(messages) - len(headers)
+	if len(maps.rootUIDs) != expectedThreads || len(maps.allUIDs) != len(messages) {
+		t.Errorf("Expected %d threads of %d messages, got %v and %v", expectedThreads, len(messages), maps.rootUIDs, maps.allUIDs)
+	}
+}
+
+func TestMessageReferences(t *testing.T) {
+	t.Run("falls back to the envelope's In-Reply-To", func(t *testing.T) {
+		msg := &imap.Message{Envelope: &imap.Envelope{InReplyTo: "<parent@example.com>"}}
+		if references := messageReferences(msg); !slices.Equal(references, []string{"<parent@example.com>"}) {
+			t.Errorf("Expected [<parent@example.com>], got %v", references)
+		}
+	})
+
+	t.Run("can read the fetched section more than once", func(t *testing.T) {
+		section := &imap.BodySectionName{BodyPartName: headerFieldsSection.BodyPartName}
+		msg := &imap.Message{Body: map[*imap.BodySectionName]imap.Literal{
+			section: bytes.NewReader([]byte("References: <a@example.com> <b@example.com>\r\n\r\n")),
+		}}
+		for range 2 {
+			if references := messageReferences(msg); !slices.Equal(references, []string{"<a@example.com>", "<b@example.com>"}) {
+				t.Errorf("Expected [<a@example.com> <b@example.com>], got %v", references)
+			}
+		}
+	})
+
+	t.Run("returns nothing for messages that aren't replies", func(t *testing.T) {
+		if references := messageReferences(&imap.Message{Envelope: &imap.Envelope{}}); len(references) != 0 {
+			t.Errorf("Expected no references, got %v", references)
+		}
+	})
+}
+
+func TestValidateThreadTree(t *testing.T) {
+	// thread builds a thread tree node, like go-imap-sortthread parses it
+	thread := func(id uint32, children ...*sortthread.Thread) *sortthread.Thread {
+		return &sortthread.Thread{Id: id, Children: children}
+	}
+	roots := func(threads []*sortthread.Thread) []uint32 {
+		var ids []uint32
+		for _, th := range threads {
+			ids = append(ids, th.Id)
+		}
+		return ids
+	}
+
+	t.Run("keeps consistent trees", func(t *testing.T) {
+		// (1 2 (3)(4))(5)
+		threads := []*sortthread.Thread{thread(1, thread(2, thread(3), thread(4))), thread(5)}
+		valid, affected, problems := validateThreadTree(threads, []uint32{1, 2, 3, 4, 5})
+		if !slices.Equal(roots(valid), []uint32{1, 5}) || len(affected) != 0 || problems.any() {
+			t.Errorf("Expected both threads to be valid, got %v, %v, %+v", roots(valid), affected, problems)
+		}
+	})
+
+	t.Run("falls back for threads with UIDs missing from the mailbox", func(t *testing.T) {
+		// (1 9 (2))(5), where 9 isn't in the mailbox, for example, because it was expunged
+		threads := []*sortthread.Thread{thread(1, thread(9, thread(2))), thread(5)}
+		valid, affected, problems := validateThreadTree(threads, []uint32{1, 2, 5})
+		if !slices.Equal(roots(valid), []uint32{5}) {
+			t.Errorf("Expected only thread 5 to be valid, got %v", roots(valid))
+		}
+		if !slices.Equal(affected, []uint32{1, 2}) {
+			t.Errorf("Expected UIDs 1 and 2 to fall back, got %v", affected)
+		}
+		if problems.missingUIDs != 1 || problems.duplicateUIDs != 0 || problems.unthreadedUIDs != 0 {
+			t.Errorf("Expected 1 missing UID, got %+v", problems)
+		}
+	})
+
+	t.Run("falls back for all threads that share a UID", func(t *testing.T) {
+		// (1 2)(3 2)(4)
+		threads := []*sortthread.Thread{thread(1, thread(2)), thread(3, thread(2)), thread(4)}
+		valid, affected, problems := validateThreadTree(threads, []uint32{1, 2, 3, 4})
+		if !slices.Equal(roots(valid), []uint32{4}) {
+			t.Errorf("Expected only thread 4 to be valid, got %v", roots(valid))
+		}
+		if !slices.Equal(affected, []uint32{1, 2, 3}) {
+			t.Errorf("Expected UIDs 1, 2, and 3 to fall back, got %v", affected)
+		}
+		if problems.duplicateUIDs != 1 {
+			t.Errorf("Expected 1 duplicate UID, got %+v", problems)
+		}
+	})
+
+	t.Run("falls back for UIDs repeated within a thread", func(t *testing.T) {
+		// (1 2 1)
+		valid, affected, _ := validateThreadTree([]*sortthread.Thread{thread(1, thread(2, thread(1)))}, []uint32{1, 2})
+		if len(valid) != 0 || !slices.Equal(affected, []uint32{1, 2}) {
+			t.Errorf("Expected the thread to fall back, got %v, %v", roots(valid), affected)
+		}
+	})
+
+	t.Run("falls back for mailbox UIDs missing from the tree", func(t *testing.T) {
+		valid, affected, problems := validateThreadTree([]*sortthread.Thread{thread(1), nil}, []uint32{1, 7, 8})
+		if !slices.Equal(roots(valid), []uint32{1}) || !slices.Equal(affected, []uint32{7, 8}) {
+			t.Errorf("Expected UIDs 7 and 8 to fall back, got %v, %v", roots(valid), affected)
+		}
+		if problems.unthreadedUIDs != 2 {
+			t.Errorf("Expected 2 unthreaded UIDs, got %+v", problems)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package imap
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// ErrSyncThrottled is returned when syncing an account that we hold syncs back for,
+// because its server throttled us or kept dropping the connection. See db.GetSyncThrottle.
+var ErrSyncThrottled = errors.New("sync is throttled by the mail server")
+
+const (
+	// throttleBaseBackoff is how long we wait after the first throttled sync. It doubles with each strike.
+	throttleBaseBackoff = time.Minute
+	// throttleMaxBackoff is the longest we wait between syncs of a throttled account.
+	throttleMaxBackoff = time.Hour
+	// dropStrikesToThrottle is how many syncs in a row have to lose their connection before we back off.
+	// A single dropped connection is usually just the network.
+	dropStrikesToThrottle = 3
+)
+
+// throttleSignal is what a sync error tells about the server throttling us.
+type throttleSignal int
+
+const (
+	// signalNone means the error isn't about throttling.
+	signalNone throttleSignal = iota
+	// signalThrottled means the server said it's throttling us.
+	signalThrottled
+	// signalDropped means the connection dropped, which some servers do instead of saying it.
+	signalDropped
+)
+
+// throttleResponses are parts of the NO responses that servers send when they throttle a client, in lowercase.
+// go-imap only keeps the text of the response, so we can't look at response codes like [THROTTLED] or [LIMIT].
+var throttleResponses = []string{
+	"bandwidth limits",                  // Gmail: "Account exceeded command or bandwidth limits."
+	"throttl",                           // Outlook: "Request is throttled.", and [THROTTLED] in the text
+	"too many simultaneous connections", // Gmail, at login
+	"too many concurrent",               // Dovecot: "Too many concurrent connections"
+	"server unavailable",                // Outlook: "Server Unavailable. 15"
+	"rate limit",
+	"try again later",
+}
+
+// suggestedBackoffPattern matches the backoff that Outlook suggests, like "Suggested Backoff Time: 59000 milliseconds".
+var suggestedBackoffPattern = regexp.MustCompile(`(?i)suggested backoff time: (\d+) milliseconds`)
+
+// classifyThrottle tells whether err means that the server throttled us or dropped the connection.
+func classifyThrottle(err error) throttleSignal {
+	if err == nil {
+		return signalNone
+	}
+
+	text := strings.ToLower(err.Error())
+	for _, response := range throttleResponses {
+		if strings.Contains(text, response) {
+			return signalThrottled
+		}
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		strings.Contains(text, "connection closed") {
+		return signalDropped
+	}
+
+	return signalNone
+}
+
+// throttleBackoff returns how long to hold syncs back after the given number of strikes in a row.
+// If the server suggested a longer backoff in err, it uses that.
+func throttleBackoff(strikes int, err error) time.Duration {
+	backoff := throttleMaxBackoff
+	if strikes <= 6 { // Later strikes are over the maximum anyway
+		backoff = min(throttleBaseBackoff<<max(strikes-1, 0), throttleMaxBackoff)
+	}
+
+	if match := suggestedBackoffPattern.FindStringSubmatch(err.Error()); match != nil {
+		if ms, parseErr := strconv.Atoi(match[1]); parseErr == nil {
+			backoff = max(backoff, min(time.Duration(ms)*time.Millisecond, throttleMaxBackoff))
+		}
+	}
+
+	return backoff
+}
+
+// nextThrottle returns the throttling state after a sync that failed with err, or nil if err isn't about throttling.
+// The server saying it throttles us holds syncs back right away. Dropped connections only do after a few in a row.
+func nextThrottle(throttle *models.SyncThrottle, err error, now time.Time) *models.SyncThrottle {
+	signal := classifyThrottle(err)
+	if signal == signalNone {
+		return nil
+	}
+
+	next := &models.SyncThrottle{Strikes: throttle.Strikes + 1, Reason: err.Error()}
+	if signal == signalThrottled || next.Strikes >= dropStrikesToThrottle {
+		until := now.Add(throttleBackoff(next.Strikes, err))
+		next.ThrottledUntil = &until
+	}
+	return next
+}
+
+// isThrottled tells whether we hold syncs of the account back at now.
+func isThrottled(throttle *models.SyncThrottle, now time.Time) bool {
+	return throttle.ThrottledUntil != nil && now.Before(*throttle.ThrottledUntil)
+}
+
+// updateThrottle records the result of a sync in the throttling state of the account.
+// A throttled sync adds a strike, and a successful one clears the strikes.
+// throttle is the state from before the sync.
+func (s *Service) updateThrottle(ctx context.Context, userID string, throttle *models.SyncThrottle, err error) {
+	if err == nil {
+		if throttle.Strikes == 0 {
+			return
+		}
+		if err := db.ClearSyncThrottle(ctx, s.dbPool, userID); err != nil {
+			slog.WarnContext(ctx, "IMAP Sync: Failed to clear sync throttle", "error", err)
+		}
+		return
+	}
+
+	next := nextThrottle(throttle, err, time.Now())
+	if next == nil {
+		return
+	}
+	if next.ThrottledUntil != nil {
+		slog.WarnContext(ctx, "IMAP Sync: Server is throttling syncs, backing off",
+			"strikes", next.Strikes, "until", *next.ThrottledUntil, "reason", next.Reason)
+	}
+	if err := db.SaveSyncThrottle(ctx, s.dbPool, userID, next); err != nil {
+		slog.WarnContext(ctx, "IMAP Sync: Failed to save sync throttle", "error", err)
+	}
+}
@@ -0,0 +1,88 @@
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+func TestClassifyThrottle(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected throttleSignal
+	}{
+		{nil, signalNone},
+		{errors.New("Account exceeded command or bandwidth limits."), signalThrottled},
+		{errors.New("Request is throttled. Suggested Backoff Time: 59000 milliseconds"), signalThrottled},
+		{fmt.Errorf("failed to connect: %w", errors.New("[ALERT] Too many simultaneous connections. (Failure)")), signalThrottled},
+		{errors.New("Server Unavailable. 15"), signalThrottled},
+		{fmt.Errorf("failed to fetch messages: %w", io.EOF), signalDropped},
+		{fmt.Errorf("failed to fetch message body: %w", syscall.ECONNRESET), signalDropped},
+		{errors.New("imap: connection closed during command execution"), signalDropped},
+		{errors.New("Mailbox doesn't exist: Archive"), signalNone},
+	}
+	for _, tt := range tests {
+		if got := classifyThrottle(tt.err); got != tt.expected {
+			t.Errorf("classifyThrottle(%v) = %d, expected %d", tt.err, got, tt.expected)
+		}
+	}
+}
+
+func TestThrottleBackoff(t *testing.T) {
+	err := errors.New("Account exceeded command or bandwidth limits.")
+	for strikes, expected := range map[int]time.Duration{
+		1:  time.Minute,
+		2:  2 * time.Minute,
+		4:  8 * time.Minute,
+		7:  time.Hour,
+		40: time.Hour,
+	} {
+		if got := throttleBackoff(strikes, err); got != expected {
+			t.Errorf("throttleBackoff(%d) = %v, expected %v", strikes, got, expected)
+		}
+	}
+
+	suggested := errors.New("Request is throttled. Suggested Backoff Time: 300000 milliseconds")
+	if got := throttleBackoff(1, suggested); got != 5*time.Minute {
+		t.Errorf("Expected the suggested backoff of 5 minutes, got %v", got)
+	}
+}
+
+func TestNextThrottle(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("ignores other errors", func(t *testing.T) {
+		if next := nextThrottle(&models.SyncThrottle{}, errors.New("Mailbox doesn't exist"), now); next != nil {
+			t.Errorf("Expected no throttle, got %+v", next)
+		}
+	})
+
+	t.Run("backs off right away when the server says so", func(t *testing.T) {
+		next := nextThrottle(&models.SyncThrottle{Strikes: 1}, errors.New("Account exceeded command or bandwidth limits."), now)
+		if next == nil || next.Strikes != 2 || next.ThrottledUntil == nil || !next.ThrottledUntil.Equal(now.Add(2*time.Minute)) {
+			t.Errorf("Expected 2 strikes and a 2-minute backoff, got %+v", next)
+		}
+		if !isThrottled(next, now) || isThrottled(next, now.Add(2*time.Minute)) {
+			t.Error("Expected the account to be throttled only until the end of the backoff")
+		}
+	})
+
+	t.Run("backs off after a few dropped connections", func(t *testing.T) {
+		throttle := &models.SyncThrottle{}
+		for range dropStrikesToThrottle - 1 {
+			throttle = nextThrottle(throttle, io.EOF, now)
+			if throttle.ThrottledUntil != nil {
+				t.Fatalf("Expected no backoff after %d dropped connections", throttle.Strikes)
+			}
+		}
+		throttle = nextThrottle(throttle, io.EOF, now)
+		if throttle.ThrottledUntil == nil {
+			t.Errorf("Expected a backoff after %d dropped connections", throttle.Strikes)
+		}
+	})
+}
@@ -1,7 +1,9 @@
 package imap
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"sync"
 )
 
@@ -16,9 +18,12 @@ type workerClientSet struct {
 // acquire gets a client from the set, blocking if at max capacity.
 // Returns the client (locked) and a release function that must be called when done.
 // If no client is available, returns nil and the caller should create a new one.
-func (s *workerClientSet) acquire() (*threadSafeClient, func()) {
+// Returns an error if ctx ends while it waits for a slot.
+func (s *workerClientSet) acquire(ctx context.Context) (*threadSafeClient, func(), error) {
 	// Block until a slot is available
-	s.semaphore <- struct{}{}
+	if err := s.waitForSlot(ctx); err != nil {
+		return nil, nil, err
+	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -26,19 +31,39 @@ func (s *workerClientSet) acquire() (*threadSafeClient, func()) {
 	// Find an available client (not in use)
 	for _, client := range s.clients {
 		// Client is available if we can acquire its lock immediately
+		// The caller updates lastUsed after its health check, which needs the old value
 		if client.mu.TryLock() {
-			client.UpdateLastUsed()
 			// Keep it locked - caller will unlock when done
-			return client, func() {
-				client.Unlock()
-				<-s.semaphore // Release semaphore slot
-			}
+			return client, s.releaseFunc(client), nil
 		}
 	}
 
 	// No available client - caller will need to create one
-	<-s.semaphore         // Release semaphore slot temporarily
-	return nil, func() {} // No-op release function
+	<-s.semaphore              // Release semaphore slot temporarily
+	return nil, func() {}, nil // No-op release function
+}
+
+// releaseFunc returns the function that unlocks the client and frees its semaphore slot.
+// Calling it more than once does nothing, so a second call can't unlock a client that someone else holds
+// or free a slot that isn't ours.
+func (s *workerClientSet) releaseFunc(client *threadSafeClient) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			client.Unlock()
+			<-s.semaphore
+		})
+	}
+}
+
+// waitForSlot takes a semaphore slot, waiting until one is free or ctx ends.
+func (s *workerClientSet) waitForSlot(ctx context.Context) error {
+	select {
+	case s.semaphore <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to get an IMAP connection: %w", ctx.Err())
+	}
 }
 
 // addClient adds a new client to the set.
@@ -49,24 +74,20 @@ func (s *workerClientSet) addClient(client *threadSafeClient) {
 }
 
 // close closes all clients in the set.
-// If a client is currently locked (in use), it will be skipped.
-// The auto-release goroutine will handle closing it when it sees the pool is closed.
+// Clients that are in use get logged out too, which makes their running commands fail.
+// Their holders still call their release functions, which only unlock them.
 func (s *workerClientSet) close() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	for _, client := range s.clients {
-		// Try to lock - if we can't, the client is in use and will be closed
-		// by the auto-release goroutine when it sees cleanupCtx.Done()
 		if client.TryLock() {
 			if err := client.client.Logout(); err != nil {
-				log.Printf("Failed to logout worker client: %v", err)
+				slog.Warn("Failed to logout worker client", "error", err)
 			}
 			client.Unlock()
 		} else {
-			// Client is locked (in use) - skip it
-			// The auto-release goroutine will see cleanupCtx.Done() and won't release,
-			// but we should still try to close the underlying connection
+			// Client is locked (in use), so we can't wait for it
 			// Note: This is not thread-safe, but we're shutting down so it's acceptable
 			_ = client.client.Logout()
 		}
@@ -0,0 +1,103 @@
+// Package importance scores how important each thread probably is to the user,
+// so that we can show a priority inbox.
+package importance
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// Threshold is the lowest score that we consider important.
+const Threshold = 50
+
+// MaxScore is the highest possible score.
+const MaxScore = 100
+
+// How much each signal adds to the score.
+const (
+	repliedToSenderWeight = 35
+	directToUserWeight    = 20
+	ccToUserWeight        = 10
+	senderStarredWeight   = 15
+	threadStarredWeight   = 10
+
+	// senderFrequencyWeight is added for each earlier message from the sender, up to maxSenderFrequencyBonus.
+	senderFrequencyWeight   = 2
+	maxSenderFrequencyBonus = 20
+)
+
+// Score turns the signals of a thread into a score from 0 to MaxScore.
+// Someone the user has replied to before, writing to the user directly, scores just above Threshold.
+// A first message from a stranger through a mailing list scores 0.
+func Score(signals models.ThreadImportanceSignals) int {
+	score := 0
+
+	if signals.UserRepliedToSender {
+		score += repliedToSenderWeight
+	}
+	if signals.DirectToUser {
+		score += directToUserWeight
+	} else if signals.CCToUser {
+		score += ccToUserWeight
+	}
+	if signals.SenderStarred {
+		score += senderStarredWeight
+	}
+	if signals.ThreadStarred {
+		score += threadStarredWeight
+	}
+	if signals.SenderMessageCount > 1 {
+		score += min((signals.SenderMessageCount-1)*senderFrequencyWeight, maxSenderFrequencyBonus)
+	}
+
+	return min(score, MaxScore)
+}
+
+// IsImportant tells whether a score is high enough for the thread to be important.
+func IsImportant(score int) bool {
+	return score >= Threshold
+}
+
+// UpdateScores recalculates and saves the importance score of every cached thread of the user.
+func UpdateScores(ctx context.Context, pool *pgxpool.Pool, userID string) error {
+	ownAddresses, err := db.GetOwnAddresses(ctx, pool, userID)
+	if err != nil {
+		return err
+	}
+
+	signals, err := db.GetThreadImportanceSignals(ctx, pool, userID, ownAddresses)
+	if err != nil {
+		return err
+	}
+
+	return saveScores(ctx, pool, userID, signals)
+}
+
+// UpdateScoresForMessages recalculates and saves the importance scores that new messages can change: the scores of
+// their threads, and of the threads from their senders, whose frequency they add to.
+// Messages that the user sent also change the threads from the people they went to, who the user now replied to.
+func UpdateScoresForMessages(ctx context.Context, pool *pgxpool.Pool, userID, folderName string, uids []uint32) error {
+	ownAddresses, err := db.GetOwnAddresses(ctx, pool, userID)
+	if err != nil {
+		return err
+	}
+
+	signals, err := db.GetThreadImportanceSignalsForMessages(ctx, pool, userID, ownAddresses, folderName, uids)
+	if err != nil {
+		return err
+	}
+
+	return saveScores(ctx, pool, userID, signals)
+}
+
+func saveScores(ctx context.Context, pool *pgxpool.Pool, userID string, signals []models.ThreadImportanceSignals) error {
+	scores := make(map[string]int, len(signals))
+	for _, s := range signals {
+		scores[s.ThreadID] = Score(s)
+	}
+
+	return db.SaveThreadImportanceScores(ctx, pool, userID, scores)
+}
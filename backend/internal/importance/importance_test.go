@@ -0,0 +1,57 @@
+package importance
+
+import (
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+func TestScore(t *testing.T) {
+	testCases := []struct {
+		name     string
+		signals  models.ThreadImportanceSignals
+		expected int
+	}{
+		{"scores a first mailing list message as zero", models.ThreadImportanceSignals{SenderMessageCount: 1}, 0},
+		{"scores a direct message from a stranger", models.ThreadImportanceSignals{SenderMessageCount: 1, DirectToUser: true}, 20},
+		{"scores CC lower than direct", models.ThreadImportanceSignals{SenderMessageCount: 1, CCToUser: true}, 10},
+		{"doesn't count CC on top of direct", models.ThreadImportanceSignals{SenderMessageCount: 1, DirectToUser: true, CCToUser: true}, 20},
+		{"adds sender frequency", models.ThreadImportanceSignals{SenderMessageCount: 4}, 6},
+		{"caps sender frequency", models.ThreadImportanceSignals{SenderMessageCount: 500}, 20},
+		{"adds starred history", models.ThreadImportanceSignals{SenderMessageCount: 1, SenderStarred: true, ThreadStarred: true}, 25},
+		{
+			"scores a direct message from someone the user replied to as important",
+			models.ThreadImportanceSignals{SenderMessageCount: 1, UserRepliedToSender: true, DirectToUser: true},
+			55,
+		},
+		{
+			"caps the score",
+			models.ThreadImportanceSignals{
+				SenderMessageCount:  100,
+				UserRepliedToSender: true,
+				DirectToUser:        true,
+				SenderStarred:       true,
+				ThreadStarred:       true,
+			},
+			MaxScore,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			score := Score(tc.signals)
+			if score != tc.expected {
+				t.Errorf("Expected score %d, got %d", tc.expected, score)
+			}
+		})
+	}
+}
+
+func TestIsImportant(t *testing.T) {
+	if IsImportant(Threshold - 1) {
+		t.Error("Expected a score below the threshold not to be important")
+	}
+	if !IsImportant(Threshold) {
+		t.Error("Expected a score at the threshold to be important")
+	}
+}
@@ -0,0 +1,233 @@
+// Package linkcheck looks for signs of phishing in the links of message bodies, so that the thread view can warn
+// before the user clicks: link texts that show another domain than the link goes to, internationalized domains that
+// look like ASCII ones, and top-level domains that are popular with phishing.
+package linkcheck
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"golang.org/x/net/html"
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// MaxScore is the highest possible score.
+const MaxScore = 100
+
+// How much each kind of warning adds to the score. Each kind counts once, however many links have it.
+var warningWeights = map[string]int{
+	models.LinkWarningMismatchedText:  45,
+	models.LinkWarningLookalikeDomain: 45,
+	models.LinkWarningSuspiciousTLD:   20,
+}
+
+// maxWarnings limits the warnings of a message. Newsletters can have hundreds of links, and a few warnings tell
+// enough.
+const maxWarnings = 20
+
+// suspiciousTLDs are the top-level domains with the most phishing per registered domain, from public abuse reports.
+// Plenty of them have legit sites too, so they weigh the least.
+var suspiciousTLDs = map[string]bool{
+	"buzz": true, "cam": true, "cf": true, "click": true, "ga": true, "gq": true, "icu": true, "loan": true,
+	"ml": true, "mov": true, "rest": true, "tk": true, "top": true, "work": true, "xyz": true, "zip": true,
+}
+
+// confusables are the Cyrillic and Greek letters that look like Latin ones in most fonts.
+// See https://www.unicode.org/Public/security/latest/confusables.txt.
+const confusables = "аеорсухіјѕԁӏԛԝһкмтвнАВЕКМНОРСТУХІЈЅαορνικτυχΑΒΕΖΗΙΚΜΝΟΡΤΥΧ"
+
+// plainURLPattern matches the URLs of text bodies.
+var plainURLPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"']+`)
+
+// domainTextPattern matches link texts that look like a URL or a domain, like "https://bank.example/login",
+// "www.bank.example", or "bank.example". Texts like "Log in" don't show a domain, so they can't mismatch.
+var domainTextPattern = regexp.MustCompile(`(?i)^(https?://)?([\p{L}\p{N}-]+\.)+\p{L}{2,}(:\d+)?([/?#]\S*)?$`)
+
+// link is a link of a body, with its text if it's an HTML link.
+type link struct {
+	href string
+	text string
+}
+
+// Analyze looks at the links of an HTML body, or of the text body if there's no HTML, and returns the warnings
+// with a score from 0 to MaxScore.
+func Analyze(htmlBody, textBody string) *models.LinkAnalysis {
+	var links []link
+	if htmlBody != "" {
+		links = extractHTMLLinks(htmlBody)
+	} else {
+		for _, href := range plainURLPattern.FindAllString(textBody, -1) {
+			links = append(links, link{href: strings.TrimRight(href, ".,;:!?)")})
+		}
+	}
+
+	analysis := &models.LinkAnalysis{Warnings: []models.LinkWarning{}}
+	seen := map[string]bool{}
+	kinds := map[string]bool{}
+	for _, l := range links {
+		for _, warning := range checkLink(l) {
+			key := warning.Kind + " " + warning.URL
+			if seen[key] || len(analysis.Warnings) >= maxWarnings {
+				continue
+			}
+			seen[key] = true
+			kinds[warning.Kind] = true
+			analysis.Warnings = append(analysis.Warnings, warning)
+		}
+	}
+	for kind := range kinds {
+		analysis.SuspiciousScore += warningWeights[kind]
+	}
+	analysis.SuspiciousScore = min(analysis.SuspiciousScore, MaxScore)
+	return analysis
+}
+
+// extractHTMLLinks returns the links of the HTML with their visible text.
+func extractHTMLLinks(body string) []link {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	var links []link
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode && node.Data == "a" {
+			for _, attr := range node.Attr {
+				if attr.Key == "href" {
+					links = append(links, link{href: strings.TrimSpace(attr.Val), text: nodeText(node)})
+					break
+				}
+			}
+			return
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	return links
+}
+
+// nodeText returns the text in the node, with whitespace collapsed. Texts of elements are joined as they are, so
+// that "<b>bank.example</b>/login" stays one URL.
+func nodeText(node *html.Node) string {
+	var sb strings.Builder
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.TextNode {
+			sb.WriteString(node.Data)
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(node)
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
+
+// checkLink returns the warnings of a link. Links that aren't HTTP or HTTPS, like "mailto:", have none.
+func checkLink(l link) []models.LinkWarning {
+	target, err := url.Parse(l.href)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Hostname() == "" {
+		return nil
+	}
+	host := strings.TrimSuffix(strings.ToLower(target.Hostname()), ".")
+	asciiHost, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		asciiHost = host
+	}
+	unicodeHost, err := idna.Lookup.ToUnicode(asciiHost)
+	if err != nil {
+		unicodeHost = host
+	}
+
+	var warnings []models.LinkWarning
+	warning := func(kind string) {
+		warnings = append(warnings, models.LinkWarning{Kind: kind, URL: l.href, Domain: asciiHost})
+	}
+
+	if textHost := hostInText(l.text); textHost != "" && registrableDomain(textHost) != registrableDomain(asciiHost) {
+		warnings = append(warnings, models.LinkWarning{
+			Kind:   models.LinkWarningMismatchedText,
+			URL:    l.href,
+			Domain: asciiHost,
+			Text:   l.text,
+		})
+	}
+	if isLookalike(unicodeHost) {
+		warning(models.LinkWarningLookalikeDomain)
+	}
+	if suspiciousTLDs[asciiHost[strings.LastIndexByte(asciiHost, '.')+1:]] {
+		warning(models.LinkWarningSuspiciousTLD)
+	}
+	return warnings
+}
+
+// hostInText returns the host, in ASCII, that a link text shows, or "" if the text doesn't look like a URL or a
+// domain.
+func hostInText(text string) string {
+	if !domainTextPattern.MatchString(text) {
+		return ""
+	}
+	if !strings.Contains(strings.ToLower(text), "://") {
+		text = "http://" + text
+	}
+	parsed, err := url.Parse(text)
+	if err != nil {
+		return ""
+	}
+	host, err := idna.Lookup.ToASCII(strings.TrimSuffix(strings.ToLower(parsed.Hostname()), "."))
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// registrableDomain returns the part of the host that someone registered, like "example.co.uk" for
+// "mail.example.co.uk", so that links to subdomains of the shown domain don't count as mismatched.
+func registrableDomain(host string) string {
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
+// isLookalike tells whether a label of the host is made of ASCII letters and letters that look like them, with at
+// least one of the latter, like "аррӏе" in Cyrillic. Domains in other scripts, like "яндекс", or with accents,
+// like "müller", aren't lookalikes.
+func isLookalike(host string) bool {
+	for _, label := range strings.Split(host, ".") {
+		if isASCII(label) {
+			continue
+		}
+		lookalike := true
+		for _, r := range label {
+			if r < utf8.RuneSelf {
+				continue
+			}
+			if !strings.ContainsRune(confusables, r) {
+				lookalike = false
+				break
+			}
+		}
+		if lookalike {
+			return true
+		}
+	}
+	return false
+}
+
+// isASCII tells whether s only has ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
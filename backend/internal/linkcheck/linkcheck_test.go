@@ -0,0 +1,109 @@
+package linkcheck
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+func TestAnalyze(t *testing.T) {
+	testCases := []struct {
+		name      string
+		html      string
+		text      string
+		wantKinds []string
+		wantScore int
+	}{
+		{
+			"passes plain links",
+			`<a href="https://example.com/a">Read more</a> <a href="https://www.example.com/b">example.com</a>`,
+			"",
+			nil,
+			0,
+		},
+		{
+			"passes subdomains of the shown domain",
+			`<a href="https://login.bank.co.uk/">www.bank.co.uk</a>`,
+			"",
+			nil,
+			0,
+		},
+		{
+			"flags text that shows another domain",
+			`<a href="https://evil.example/login"><b>https://bank.example</b>/login</a>`,
+			"",
+			[]string{models.LinkWarningMismatchedText},
+			45,
+		},
+		{
+			"flags lookalike domains",
+			`<a href="https://xn--80ak6aa92e.com/">Sign in</a>`,
+			"",
+			[]string{models.LinkWarningLookalikeDomain},
+			45,
+		},
+		{
+			"passes internationalized domains in other scripts",
+			`<a href="https://müller.de/">Shop</a> <a href="https://яндекс.рф/">Search</a>`,
+			"",
+			nil,
+			0,
+		},
+		{
+			"flags suspicious TLDs",
+			`<a href="https://prize.example.zip/claim">Claim</a>`,
+			"",
+			[]string{models.LinkWarningSuspiciousTLD},
+			20,
+		},
+		{
+			"ignores links that aren't HTTP",
+			`<a href="mailto:help@example.zip">example.com</a>`,
+			"",
+			nil,
+			0,
+		},
+		{
+			"checks the text body without HTML",
+			"",
+			"Log in at https://аррӏе.com/login, or else.",
+			[]string{models.LinkWarningLookalikeDomain},
+			45,
+		},
+		{
+			"adds up kinds",
+			`<a href="https://xn--80ak6aa92e.top/">apple.com</a>`,
+			"",
+			[]string{models.LinkWarningMismatchedText, models.LinkWarningLookalikeDomain, models.LinkWarningSuspiciousTLD},
+			MaxScore,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			analysis := Analyze(tc.html, tc.text)
+			var kinds []string
+			for _, warning := range analysis.Warnings {
+				kinds = append(kinds, warning.Kind)
+			}
+			if !slices.Equal(kinds, tc.wantKinds) || analysis.SuspiciousScore != tc.wantScore {
+				t.Errorf("Expected %v with score %d, got %v with score %d", tc.wantKinds, tc.wantScore, kinds, analysis.SuspiciousScore)
+			}
+		})
+	}
+}
+
+func TestAnalyze_Warnings(t *testing.T) {
+	analysis := Analyze(`<a href="https://evil.example/">bank.example</a><a href="https://evil.example/">bank.example</a>`, "")
+
+	want := models.LinkWarning{
+		Kind:   models.LinkWarningMismatchedText,
+		URL:    "https://evil.example/",
+		Domain: "evil.example",
+		Text:   "bank.example",
+	}
+	if len(analysis.Warnings) != 1 || analysis.Warnings[0] != want {
+		t.Errorf("Expected one %+v, got %+v", want, analysis.Warnings)
+	}
+}
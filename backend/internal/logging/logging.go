@@ -0,0 +1,128 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+// The supported log formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Setup makes a structured logger the default for slog and the log package, with the given level
+// ("debug", "info", "warn", or "error") and format ("text" or "json"). Its lines carry the request ID and the
+// user ID from the context, so pass the context to slog's ...Context functions.
+func Setup(w io.Writer, level, format string) error {
+	handler, err := NewHandler(w, level, format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// NewHandler creates the handler that Setup installs, without installing it.
+// An empty level means "info", and an empty format means "text".
+func NewHandler(w io.Writer, level, format string) (slog.Handler, error) {
+	var slogLevel slog.Level
+	if level != "" {
+		if err := slogLevel.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+		}
+	}
+
+	options := &slog.HandlerOptions{Level: slogLevel}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case FormatText, "":
+		handler = slog.NewTextHandler(w, options)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf("invalid log format %q, must be %q or %q", format, FormatText, FormatJSON)
+	}
+	return &contextHandler{Handler: handler}, nil
+}
+
+// contextHandler adds the request ID and user ID from the context to each log line.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if f := fieldsFromContext(ctx); f != nil {
+		if f.requestID != "" {
+			record.AddAttrs(slog.String("request_id", f.requestID))
+		}
+		if userID := f.userID.Load(); userID != nil {
+			record.AddAttrs(slog.String("user_id", *userID))
+		}
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+type contextKey struct{}
+
+// fields are the log fields of a context. The user ID is only known once the handler looked the user up,
+// so it can be set later, and the other log lines of the request get it too.
+type fields struct {
+	requestID string
+	userID    atomic.Pointer[string]
+}
+
+func fieldsFromContext(ctx context.Context) *fields {
+	if ctx == nil {
+		return nil
+	}
+	f, _ := ctx.Value(contextKey{}).(*fields)
+	return f
+}
+
+// WithRequestID returns a context whose log lines carry the request ID.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, &fields{requestID: requestID})
+}
+
+// RequestIDFromContext returns the request ID of the context, or "" if there's none.
+func RequestIDFromContext(ctx context.Context) string {
+	if f := fieldsFromContext(ctx); f != nil {
+		return f.requestID
+	}
+	return ""
+}
+
+// WithUserID returns a context whose log lines carry the user ID.
+// In a request without a user ID yet, it adds the user ID to the request's context in place, so that the log lines
+// of the handler that called it also carry it.
+func WithUserID(ctx context.Context, userID string) context.Context {
+	f := fieldsFromContext(ctx)
+	if f == nil {
+		f = &fields{}
+		f.userID.Store(&userID)
+		return context.WithValue(ctx, contextKey{}, f)
+	}
+	if f.userID.CompareAndSwap(nil, &userID) {
+		return ctx
+	}
+	if current := f.userID.Load(); *current == userID {
+		return ctx
+	}
+	// Another user, for example, a background job that a request started
+	other := &fields{requestID: f.requestID}
+	other.userID.Store(&userID)
+	return context.WithValue(ctx, contextKey{}, other)
+}
@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureLogs makes a JSON logger that writes to a buffer the default, until the test ends.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	previous := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	var buf bytes.Buffer
+	if err := Setup(&buf, "info", FormatJSON); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	return &buf
+}
+
+// lastLine returns the fields of the last JSON log line.
+func lastLine(t *testing.T, buf *bytes.Buffer) map[string]any {
+	t.Helper()
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var line map[string]any
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &line); err != nil {
+		t.Fatalf("Failed to unmarshal log line: %v", err)
+	}
+	return line
+}
+
+func TestSetup(t *testing.T) {
+	t.Run("rejects unknown levels and formats", func(t *testing.T) {
+		if err := Setup(&bytes.Buffer{}, "loud", FormatText); err == nil {
+			t.Error("Expected an error for the level")
+		}
+		if err := Setup(&bytes.Buffer{}, "info", "xml"); err == nil {
+			t.Error("Expected an error for the format")
+		}
+	})
+
+	t.Run("adds the request ID and user ID to log lines", func(t *testing.T) {
+		buf := captureLogs(t)
+		ctx := WithUserID(WithRequestID(context.Background(), "abc123"), "user-1")
+		slog.InfoContext(ctx, "Hello", "folder", "INBOX")
+
+		line := lastLine(t, buf)
+		if line["request_id"] != "abc123" || line["user_id"] != "user-1" || line["folder"] != "INBOX" {
+			t.Errorf("Unexpected log line: %v", line)
+		}
+	})
+
+	t.Run("skips the level below the configured one", func(t *testing.T) {
+		buf := captureLogs(t)
+		slog.Debug("Details")
+		if buf.Len() != 0 {
+			t.Errorf("Expected no log lines, got %q", buf.String())
+		}
+	})
+}
+
+func TestWithUserID(t *testing.T) {
+	t.Run("adds the user to the request in place", func(t *testing.T) {
+		ctx := WithRequestID(context.Background(), "abc123")
+		WithUserID(ctx, "user-1")
+		if f := fieldsFromContext(ctx); f.userID.Load() == nil || *f.userID.Load() != "user-1" {
+			t.Error("Expected the request's context to get the user ID")
+		}
+	})
+
+	t.Run("keeps the request ID for another user", func(t *testing.T) {
+		ctx := WithUserID(WithRequestID(context.Background(), "abc123"), "user-1")
+		other := WithUserID(ctx, "user-2")
+		if *fieldsFromContext(ctx).userID.Load() != "user-1" {
+			t.Error("Expected the original context to keep its user")
+		}
+		if f := fieldsFromContext(other); f.requestID != "abc123" || *f.userID.Load() != "user-2" {
+			t.Errorf("Expected the request ID with the other user, got %q", f.requestID)
+		}
+	})
+
+	t.Run("works without a request", func(t *testing.T) {
+		ctx := WithUserID(context.Background(), "user-1")
+		if RequestIDFromContext(ctx) != "" || *fieldsFromContext(ctx).userID.Load() != "user-1" {
+			t.Error("Expected only a user ID")
+		}
+	})
+}
+
+func TestRequestID(t *testing.T) {
+	serve := func(header string) (string, string) {
+		var seen string
+		handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = RequestIDFromContext(r.Context())
+		}))
+		req := httptest.NewRequest("GET", "/api/v1/threads", nil)
+		if header != "" {
+			req.Header.Set(RequestIDHeader, header)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return seen, rr.Header().Get(RequestIDHeader)
+	}
+
+	t.Run("generates an ID", func(t *testing.T) {
+		seen, returned := serve("")
+		if len(seen) != 16 || seen != returned {
+			t.Errorf("Expected a 16-character ID in the context and the response, got %q and %q", seen, returned)
+		}
+	})
+
+	t.Run("keeps the ID from a proxy", func(t *testing.T) {
+		if seen, returned := serve("proxy-id.1"); seen != "proxy-id.1" || returned != "proxy-id.1" {
+			t.Errorf("Expected the proxy's ID, got %q and %q", seen, returned)
+		}
+	})
+
+	t.Run("replaces unsafe IDs", func(t *testing.T) {
+		if seen, _ := serve("bad id\nwith a newline"); seen == "bad id\nwith a newline" || len(seen) != 16 {
+			t.Errorf("Expected a generated ID, got %q", seen)
+		}
+	})
+}
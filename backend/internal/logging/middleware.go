@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader is the header that carries the request ID, in requests from proxies and in responses.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest request ID we take from a proxy.
+const maxRequestIDLength = 64
+
+// RequestID is middleware that gives each request an ID, so that its log lines can be found together.
+// It keeps the ID from the X-Request-ID header if a proxy set a reasonable one, and generates one otherwise.
+// The response carries the ID in the same header, so users can quote it in bug reports.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !isValidRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), requestID)))
+	})
+}
+
+// isValidRequestID returns true if the ID is short and only has characters that are safe in logs.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns 16 random hex characters.
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,194 @@
+package maintenance
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Window is the time when heavy jobs, like pruning, are allowed to run. Jobs check it before each run,
+// and skip the run if it's outside the window.
+// A nil Window allows everything, so jobs run any time if the operator didn't configure a window.
+type Window struct {
+	schedule *schedule
+	duration time.Duration
+	location *time.Location
+	// force allows heavy jobs outside the window, for emergencies.
+	force bool
+}
+
+// NewWindow creates a maintenance window that opens at the times spec matches, and stays open for duration.
+// spec is a cron expression with five fields: minute, hour, day of month, month, and day of week.
+// It's evaluated in location, so a window at "0 2 * * *" opens at 2 AM local time, also across DST changes.
+// On days when a DST change skips the opening time, the window doesn't open.
+// If force is true, the window allows heavy jobs any time.
+func NewWindow(spec string, duration time.Duration, location *time.Location, force bool) (*Window, error) {
+	s, err := parseSchedule(spec)
+	if err != nil {
+		return nil, err
+	}
+	if duration < time.Minute {
+		return nil, fmt.Errorf("maintenance window must be at least a minute long, got %v", duration)
+	}
+	if duration > 7*24*time.Hour {
+		return nil, fmt.Errorf("maintenance window must be at most a week long, got %v", duration)
+	}
+	if location == nil {
+		location = time.UTC
+	}
+	return &Window{schedule: s, duration: duration, location: location, force: force}, nil
+}
+
+// Allows returns true if heavy jobs can run at now.
+func (w *Window) Allows(now time.Time) bool {
+	if w == nil || w.force {
+		return true
+	}
+	// The window is open if it opened at any minute within the last duration
+	start := now.Truncate(time.Minute)
+	for opened := start; now.Sub(opened) < w.duration; opened = opened.Add(-time.Minute) {
+		if w.schedule.matches(opened.In(w.location)) {
+			return true
+		}
+	}
+	return false
+}
+
+// String describes the window for logs.
+func (w *Window) String() string {
+	if w == nil {
+		return "always"
+	}
+	description := fmt.Sprintf("%q for %v (%s)", w.schedule.spec, w.duration, w.location)
+	if w.force {
+		description += ", forced open"
+	}
+	return description
+}
+
+// schedule is a parsed cron expression. Each field is a bit set of the values it matches.
+type schedule struct {
+	spec                         string
+	minutes, hours, days, months uint64
+	weekdays                     uint64
+	anyDayOfMonth, anyDayOfWeek  bool
+}
+
+// cronField describes the range of a cron field.
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = [5]cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// parseSchedule parses a five-field cron expression. Fields can be "*", values, ranges like "1-5",
+// lists like "1,3,5", and steps like "*/15" or "0-30/10". Sunday is 0 or 7.
+func parseSchedule(spec string) (*schedule, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("invalid maintenance schedule %q: expected 5 fields, got %d", spec, len(parts))
+	}
+
+	var bits [5]uint64
+	for i, part := range parts {
+		b, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance schedule %q: %w", spec, err)
+		}
+		bits[i] = b
+	}
+
+	// Sunday is both 0 and 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	return &schedule{
+		spec:          spec,
+		minutes:       bits[0],
+		hours:         bits[1],
+		days:          bits[2],
+		months:        bits[3],
+		weekdays:      bits[4],
+		anyDayOfMonth: parts[2] == "*",
+		anyDayOfWeek:  parts[4] == "*",
+	}, nil
+}
+
+// parseCronField parses one field of a cron expression into a bit set.
+func parseCronField(part string, field cronField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(part, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s", stepPart, field.name)
+			}
+		}
+
+		low, high := field.min, field.max
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = parseCronValue(lowPart, field); err != nil {
+				return 0, err
+			}
+			high = low
+			if isRange {
+				if high, err = parseCronValue(highPart, field); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				high = field.max
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid range %q in %s", rangePart, field.name)
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+// parseCronValue parses a number in a cron field and checks its range.
+func parseCronValue(value string, field cronField) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < field.min || n > field.max {
+		return 0, fmt.Errorf("invalid %s %q, must be %d-%d", field.name, value, field.min, field.max)
+	}
+	return n, nil
+}
+
+// matches returns true if the schedule opens the window at t, in t's location.
+// Like cron, if both the day of month and the day of week are restricted, either of them matching is enough.
+func (s *schedule) matches(t time.Time) bool {
+	if s.minutes&(1<<t.Minute()) == 0 || s.hours&(1<<t.Hour()) == 0 || s.months&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	dayMatches := s.days&(1<<t.Day()) != 0
+	weekdayMatches := s.weekdays&(1<<int(t.Weekday())) != 0
+	switch {
+	case s.anyDayOfMonth && s.anyDayOfWeek:
+		return true
+	case s.anyDayOfMonth:
+		return weekdayMatches
+	case s.anyDayOfWeek:
+		return dayMatches
+	default:
+		return dayMatches || weekdayMatches
+	}
+}
@@ -0,0 +1,129 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewWindow(t *testing.T) {
+	testCases := []struct {
+		name     string
+		spec     string
+		duration time.Duration
+	}{
+		{"rejects too few fields", "0 2 * *", time.Hour},
+		{"rejects values out of range", "0 24 * * *", time.Hour},
+		{"rejects reversed ranges", "0 5-2 * * *", time.Hour},
+		{"rejects zero steps", "*/0 * * * *", time.Hour},
+		{"rejects short windows", "0 2 * * *", time.Second},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewWindow(tc.spec, tc.duration, time.UTC, false); err == nil {
+				t.Errorf("Expected an error for %q", tc.spec)
+			}
+		})
+	}
+}
+
+func TestWindowAllows(t *testing.T) {
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	if err != nil {
+		t.Skipf("No timezone data: %v", err)
+	}
+
+	newWindow := func(t *testing.T, spec string, duration time.Duration, force bool) *Window {
+		t.Helper()
+		w, err := NewWindow(spec, duration, stockholm, force)
+		if err != nil {
+			t.Fatalf("NewWindow failed: %v", err)
+		}
+		return w
+	}
+
+	t.Run("allows everything without a window", func(t *testing.T) {
+		var w *Window
+		if !w.Allows(time.Now()) {
+			t.Error("Expected a nil window to allow jobs")
+		}
+	})
+
+	t.Run("opens in the local timezone", func(t *testing.T) {
+		w := newWindow(t, "0 2 * * *", 3*time.Hour, false)
+		testCases := []struct {
+			at      time.Time
+			allowed bool
+		}{
+			{time.Date(2026, 1, 15, 1, 59, 0, 0, stockholm), false},
+			{time.Date(2026, 1, 15, 2, 0, 0, 0, stockholm), true},
+			{time.Date(2026, 1, 15, 4, 59, 59, 0, stockholm), true},
+			{time.Date(2026, 1, 15, 5, 0, 0, 0, stockholm), false},
+			// 2 AM in UTC is 3 AM in Stockholm in winter, which is in the window
+			{time.Date(2026, 1, 15, 2, 0, 0, 0, time.UTC), true},
+			{time.Date(2026, 1, 15, 4, 0, 0, 0, time.UTC), false},
+		}
+		for _, tc := range testCases {
+			if got := w.Allows(tc.at); got != tc.allowed {
+				t.Errorf("Allows(%v) = %v, expected %v", tc.at, got, tc.allowed)
+			}
+		}
+	})
+
+	t.Run("follows DST changes", func(t *testing.T) {
+		w := newWindow(t, "30 3 * * *", time.Hour, false)
+		// Stockholm moves to summer time on March 29, 2026, so 3:30 local is 1:30 UTC from then on
+		if !w.Allows(time.Date(2026, 3, 28, 2, 45, 0, 0, time.UTC)) {
+			t.Error("Expected the window to be open at 3:45 winter time")
+		}
+		if !w.Allows(time.Date(2026, 3, 30, 1, 45, 0, 0, time.UTC)) {
+			t.Error("Expected the window to be open at 3:45 summer time")
+		}
+		if w.Allows(time.Date(2026, 3, 30, 2, 45, 0, 0, time.UTC)) {
+			t.Error("Expected the window to be closed at 4:45 summer time")
+		}
+	})
+
+	t.Run("stays open across midnight", func(t *testing.T) {
+		w := newWindow(t, "0 23 * * *", 2*time.Hour, false)
+		if !w.Allows(time.Date(2026, 1, 16, 0, 30, 0, 0, stockholm)) {
+			t.Error("Expected the window to be open after midnight")
+		}
+	})
+
+	t.Run("matches days of the week", func(t *testing.T) {
+		w := newWindow(t, "0 1 * * 6,7", time.Hour, false)
+		saturday := time.Date(2026, 1, 17, 1, 30, 0, 0, stockholm)
+		sunday := time.Date(2026, 1, 18, 1, 30, 0, 0, stockholm)
+		monday := time.Date(2026, 1, 19, 1, 30, 0, 0, stockholm)
+		if !w.Allows(saturday) || !w.Allows(sunday) || w.Allows(monday) {
+			t.Errorf("Expected only the weekend, got %v, %v, %v", w.Allows(saturday), w.Allows(sunday), w.Allows(monday))
+		}
+	})
+
+	t.Run("matches either day field if both are set", func(t *testing.T) {
+		w := newWindow(t, "0 1 1 * 1", time.Hour, false)
+		first := time.Date(2026, 1, 1, 1, 30, 0, 0, stockholm) // A Thursday
+		monday := time.Date(2026, 1, 5, 1, 30, 0, 0, stockholm)
+		tuesday := time.Date(2026, 1, 6, 1, 30, 0, 0, stockholm)
+		if !w.Allows(first) || !w.Allows(monday) || w.Allows(tuesday) {
+			t.Errorf("Expected the 1st and Mondays, got %v, %v, %v", w.Allows(first), w.Allows(monday), w.Allows(tuesday))
+		}
+	})
+
+	t.Run("supports steps", func(t *testing.T) {
+		w := newWindow(t, "*/15 * * * *", 5*time.Minute, false)
+		if !w.Allows(time.Date(2026, 1, 15, 10, 47, 0, 0, stockholm)) {
+			t.Error("Expected the window to be open at 10:47")
+		}
+		if w.Allows(time.Date(2026, 1, 15, 10, 50, 0, 0, stockholm)) {
+			t.Error("Expected the window to be closed at 10:50")
+		}
+	})
+
+	t.Run("allows everything when forced", func(t *testing.T) {
+		w := newWindow(t, "0 2 * * *", time.Hour, true)
+		if !w.Allows(time.Date(2026, 1, 15, 12, 0, 0, 0, stockholm)) {
+			t.Error("Expected a forced window to allow jobs")
+		}
+	})
+}
@@ -0,0 +1,254 @@
+// Package migrate applies the SQL migrations of the database, and rolls them back.
+// It keeps the version in the schema_migrations table like golang-migrate does, so that databases that were
+// migrated with the golang-migrate CLI carry on from where they are.
+package migrate
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// advisoryLockID keeps two processes, like two servers starting at once, from migrating at the same time.
+const advisoryLockID = 0x766d61696c // "vmail"
+
+// ErrDirty is returned when a migration failed halfway with golang-migrate, and someone needs to fix the
+// schema by hand and then set the version with Force.
+var ErrDirty = errors.New("the database is dirty, fix it by hand and then force the version")
+
+// fileNamePattern matches migration files, like "000001_init_schema.up.sql".
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is a version of the schema, with the SQL that goes to it from the previous version, and back.
+type Migration struct {
+	Version uint64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Load reads the migrations from the .sql files in the root of fsys, sorted by version.
+// Every migration needs an up file. Down files are optional, but Down fails without them.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[uint64]*Migration)
+	for _, entry := range entries {
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("invalid migration version in %s", entry.Name())
+		}
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return migrations, nil
+}
+
+// Pending returns the migrations after version.
+func Pending(migrations []Migration, version uint64) []Migration {
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// Version returns the version of the database, 0 if no migration ran yet, and whether it's dirty.
+func Version(ctx context.Context, pool *pgxpool.Pool) (uint64, bool, error) {
+	var version uint64
+	var dirty bool
+	err := withLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		var err error
+		version, dirty, err = readVersion(ctx, conn)
+		return err
+	})
+	return version, dirty, err
+}
+
+// Up applies the pending migrations in order, each in a transaction of its own with the new version,
+// so that a failing migration leaves the database at the version before it. Returns how many it applied.
+func Up(ctx context.Context, pool *pgxpool.Pool, migrations []Migration) (int, error) {
+	applied := 0
+	err := withLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		version, dirty, err := readVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("%w: version %d", ErrDirty, version)
+		}
+
+		for _, m := range Pending(migrations, version) {
+			if err := apply(ctx, conn, m.Up, m.Version); err != nil {
+				return fmt.Errorf("failed to apply migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			slog.InfoContext(ctx, "Applied migration", "version", m.Version, "name", m.Name)
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back the last steps migrations, newest first, each in a transaction of its own.
+// Returns how many it rolled back, which is fewer than steps if it reached the start.
+func Down(ctx context.Context, pool *pgxpool.Pool, migrations []Migration, steps int) (int, error) {
+	rolledBack := 0
+	err := withLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		version, dirty, err := readVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("%w: version %d", ErrDirty, version)
+		}
+
+		for ; rolledBack < steps && version > 0; rolledBack++ {
+			i := slices.IndexFunc(migrations, func(m Migration) bool { return m.Version == version })
+			if i < 0 {
+				return fmt.Errorf("the database is at version %d, which isn't one of the migrations", version)
+			}
+			m := migrations[i]
+			if m.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down file", m.Version, m.Name)
+			}
+
+			var previous uint64
+			if i > 0 {
+				previous = migrations[i-1].Version
+			}
+			if err := apply(ctx, conn, m.Down, previous); err != nil {
+				return fmt.Errorf("failed to roll back migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			slog.InfoContext(ctx, "Rolled back migration", "version", m.Version, "name", m.Name)
+			version = previous
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// Force sets the version without running any migration, and clears the dirty flag. It's for databases that
+// someone fixed by hand, and for databases that were migrated before we kept the version. 0 means none.
+func Force(ctx context.Context, pool *pgxpool.Pool, version uint64) error {
+	return withLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		return apply(ctx, conn, "", version)
+	})
+}
+
+// withLock runs fn on a connection that holds the migration lock, and creates the version table if needed.
+func withLock(ctx context.Context, pool *pgxpool.Pool, fn func(*pgxpool.Conn) error) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, advisoryLockID); err != nil {
+		return fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	defer func() {
+		// The lock belongs to the session, so it must go before the connection goes back to the pool
+		if _, err := conn.Exec(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, advisoryLockID); err != nil {
+			slog.ErrorContext(ctx, "Failed to unlock migrations", "error", err)
+		}
+	}()
+
+	// The same table as golang-migrate's, with one row, or none before the first migration
+	if _, err := conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT NOT NULL PRIMARY KEY,
+			dirty BOOLEAN NOT NULL
+		)
+	`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	return fn(conn)
+}
+
+// readVersion returns the version in schema_migrations, and whether it's dirty.
+func readVersion(ctx context.Context, conn *pgxpool.Conn) (uint64, bool, error) {
+	var version int64
+	var dirty bool
+	err := conn.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return uint64(version), dirty, nil
+}
+
+// apply runs the SQL and sets the version in one transaction.
+func apply(ctx context.Context, conn *pgxpool.Conn, sql string, version uint64) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if sql != "" {
+		if _, err := tx.Exec(ctx, sql); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM schema_migrations`); err != nil {
+		return fmt.Errorf("failed to clear schema version: %w", err)
+	}
+	if version > 0 {
+		if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, FALSE)`, int64(version)); err != nil {
+			return fmt.Errorf("failed to save schema version: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
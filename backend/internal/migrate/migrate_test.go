@@ -0,0 +1,84 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/vdavid/vmail/backend/migrations"
+)
+
+func TestLoad(t *testing.T) {
+	t.Run("sorts by version and pairs up and down files", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"000010_add_column.up.sql":     {Data: []byte("ALTER TABLE a ADD COLUMN b INT;")},
+			"000010_add_column.down.sql":   {Data: []byte("ALTER TABLE a DROP COLUMN b;")},
+			"000002_create_table.up.sql":   {Data: []byte("CREATE TABLE a ();")},
+			"000002_create_table.down.sql": {Data: []byte("DROP TABLE a;")},
+			"000003_no_down.up.sql":        {Data: []byte("SELECT 1;")},
+			"README.md":                    {Data: []byte("Not a migration")},
+		}
+
+		loaded, err := Load(fsys)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if len(loaded) != 3 {
+			t.Fatalf("Expected 3 migrations, got %d", len(loaded))
+		}
+		if loaded[0].Version != 2 || loaded[1].Version != 3 || loaded[2].Version != 10 {
+			t.Errorf("Unexpected order: %d, %d, %d", loaded[0].Version, loaded[1].Version, loaded[2].Version)
+		}
+		if loaded[0].Name != "create_table" || loaded[0].Down != "DROP TABLE a;" {
+			t.Errorf("Unexpected first migration: %+v", loaded[0])
+		}
+		if loaded[1].Down != "" {
+			t.Errorf("Expected no down migration, got %q", loaded[1].Down)
+		}
+	})
+
+	t.Run("fails without an up file", func(t *testing.T) {
+		fsys := fstest.MapFS{"000001_only_down.down.sql": {Data: []byte("SELECT 1;")}}
+		if _, err := Load(fsys); err == nil {
+			t.Error("Expected an error, got nil")
+		}
+	})
+
+	t.Run("fails for two names with the same version", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"000001_one.up.sql":   {Data: []byte("SELECT 1;")},
+			"000001_other.up.sql": {Data: []byte("SELECT 2;")},
+		}
+		if _, err := Load(fsys); err == nil {
+			t.Error("Expected an error, got nil")
+		}
+	})
+
+	t.Run("loads the embedded migrations", func(t *testing.T) {
+		loaded, err := Load(migrations.FS)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		for i, m := range loaded {
+			if m.Version != uint64(i+1) {
+				t.Errorf("Expected version %d, got %d_%s", i+1, m.Version, m.Name)
+			}
+			if m.Down == "" {
+				t.Errorf("Expected %d_%s to have a down file", m.Version, m.Name)
+			}
+		}
+	})
+}
+
+func TestPending(t *testing.T) {
+	all := []Migration{{Version: 1}, {Version: 2}, {Version: 5}}
+
+	if pending := Pending(all, 0); len(pending) != 3 {
+		t.Errorf("Expected all 3 to be pending, got %d", len(pending))
+	}
+	if pending := Pending(all, 2); len(pending) != 1 || pending[0].Version != 5 {
+		t.Errorf("Expected only version 5 to be pending, got %v", pending)
+	}
+	if pending := Pending(all, 5); len(pending) != 0 {
+		t.Errorf("Expected nothing to be pending, got %v", pending)
+	}
+}
@@ -0,0 +1,173 @@
+// Package mime builds RFC 5322 messages with MIME bodies: text and HTML alternatives, inline images,
+// and attachments. It folds and encodes the headers, and picks 7bit, quoted-printable, or base64 for the bodies.
+package mime
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	gomime "mime"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Message is an email to encode. It has no Bcc, because Bcc recipients must not show up in the message.
+type Message struct {
+	From      mail.Address
+	To        []mail.Address
+	Cc        []mail.Address
+	Subject   string
+	Date      time.Time
+	MessageID string
+	// InReplyTo is the Message-ID of the message this one replies to, and References is the space-separated
+	// Message-IDs of the thread. Both are with angle brackets.
+	InReplyTo  string
+	References string
+	TextBody   string
+	HTMLBody   string
+	// Attachments with a ContentID are inline images of HTMLBody.
+	Attachments []Attachment
+	// Calendar is an iCalendar object, like the reply to an invite. It goes in a text/calendar part, as an alternative
+	// to the bodies, with CalendarMethod, like "REPLY", as its method parameter. See RFC 6047.
+	Calendar       string
+	CalendarMethod string
+	// AutoSubmitted marks automatic messages, like "auto-replied" for auto-responder replies, so that other
+	// responders don't reply to them. Empty leaves out the header. See RFC 3834, section 5.
+	AutoSubmitted string
+}
+
+// Attachment is a file attached to a message.
+type Attachment struct {
+	Filename string
+	// ContentType defaults to application/octet-stream.
+	ContentType string
+	// ContentID makes the attachment an inline image, which HTMLBody refers to as "cid:" + ContentID.
+	// It's without angle brackets. Without an HTML body, inline images are regular attachments.
+	ContentID string
+	Data      []byte
+}
+
+// Bytes encodes the message. See Encode.
+func (m *Message) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := m.Encode(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Encode writes the message to w, with CRLF line endings.
+// The body is the text, the HTML, or both in multipart/alternative. Inline images go with the HTML in
+// multipart/related, and attachments go with the body in multipart/mixed. Without any body, it has an empty text part.
+func (m *Message) Encode(w io.Writer) error {
+	return m.encode(w, randomBoundary)
+}
+
+func (m *Message) encode(w io.Writer, newBoundary func() (string, error)) error {
+	root, err := m.rootPart()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	writeHeader(&buf, "Date", m.Date.Format(time.RFC1123Z))
+	writeHeader(&buf, "From", m.From.String())
+	if len(m.To) > 0 {
+		writeHeader(&buf, "To", formatAddressList(m.To))
+	}
+	if len(m.Cc) > 0 {
+		writeHeader(&buf, "Cc", formatAddressList(m.Cc))
+	}
+	if m.MessageID != "" {
+		writeHeader(&buf, "Message-ID", m.MessageID)
+	}
+	if m.InReplyTo != "" {
+		writeHeader(&buf, "In-Reply-To", m.InReplyTo)
+	}
+	if m.References != "" {
+		writeHeader(&buf, "References", m.References)
+	}
+	writeHeader(&buf, "Subject", gomime.QEncoding.Encode("utf-8", m.Subject))
+	if m.AutoSubmitted != "" {
+		writeHeader(&buf, "Auto-Submitted", m.AutoSubmitted)
+	}
+	writeHeader(&buf, "MIME-Version", "1.0")
+	if err := root.write(&buf, newBoundary); err != nil {
+		return err
+	}
+	buf.WriteString("\r\n")
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// rootPart arranges the bodies, the calendar, and the attachments in multiparts.
+func (m *Message) rootPart() (*part, error) {
+	var body *part
+	switch {
+	case m.TextBody != "" && m.HTMLBody != "":
+		body = multipart("alternative", textPart("plain", m.TextBody), textPart("html", m.HTMLBody))
+	case m.HTMLBody != "":
+		body = textPart("html", m.HTMLBody)
+	default:
+		body = textPart("plain", m.TextBody)
+	}
+
+	var inline, attached []*part
+	for _, attachment := range m.Attachments {
+		isInline := attachment.ContentID != "" && m.HTMLBody != ""
+		p, err := attachmentPart(attachment, isInline)
+		if err != nil {
+			return nil, err
+		}
+		if isInline {
+			inline = append(inline, p)
+		} else {
+			attached = append(attached, p)
+		}
+	}
+
+	if len(inline) > 0 {
+		if body.subtype == "alternative" {
+			html := body.children[1]
+			body.children[1] = multipart("related", append([]*part{html}, inline...)...)
+		} else {
+			body = multipart("related", append([]*part{body}, inline...)...)
+		}
+	}
+	if m.Calendar != "" {
+		calendar := textPart("calendar", m.Calendar)
+		calendar.contentType = gomime.FormatMediaType("text/calendar",
+			map[string]string{"charset": "utf-8", "method": m.CalendarMethod})
+		if body.subtype == "alternative" {
+			body.children = append(body.children, calendar)
+		} else {
+			body = multipart("alternative", body, calendar)
+		}
+	}
+	if len(attached) > 0 {
+		body = multipart("mixed", append([]*part{body}, attached...)...)
+	}
+	return body, nil
+}
+
+// randomBoundary returns a multipart boundary that no body contains, for all practical purposes.
+func randomBoundary() (string, error) {
+	random := make([]byte, 15)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate boundary: %w", err)
+	}
+	return "vmail-" + hex.EncodeToString(random), nil
+}
+
+// formatAddressList formats addresses for an address header. Names with non-ASCII characters are encoded.
+func formatAddressList(addresses []mail.Address) string {
+	formatted := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		formatted = append(formatted, address.String())
+	}
+	return strings.Join(formatted, ", ")
+}
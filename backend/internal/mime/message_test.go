@@ -0,0 +1,181 @@
+package mime
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhillyerd/enmime"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// sequentialBoundaries returns boundaries that are the same on every run, so that the output matches the golden files.
+func sequentialBoundaries() func() (string, error) {
+	n := 0
+	return func() (string, error) {
+		n++
+		return fmt.Sprintf("boundary-%d", n), nil
+	}
+}
+
+func testMessage() Message {
+	return Message{
+		From:      mail.Address{Name: "Me", Address: "me@example.com"},
+		To:        []mail.Address{{Name: "Alice", Address: "alice@example.com"}},
+		Subject:   "Hello",
+		Date:      time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		MessageID: "<message@example.com>",
+	}
+}
+
+func TestMessageEncode(t *testing.T) {
+	pixel := []byte("\x89PNG\r\n\x1a\n fake image data")
+
+	tests := []struct {
+		name   string
+		modify func(m *Message)
+	}{
+		{"text", func(m *Message) {
+			m.TextBody = "Hi Alice,\nsee you tomorrow.\n"
+		}},
+		{"html", func(m *Message) {
+			m.HTMLBody = "<p>Hi Alice</p>"
+		}},
+		{"empty", func(m *Message) {
+			m.To = nil
+		}},
+		{"alternative", func(m *Message) {
+			m.Cc = []mail.Address{{Address: "bob@example.com"}, {Name: "Carol Smith", Address: "carol@example.com"}}
+			m.InReplyTo = "<original@example.com>"
+			m.References = "<first@example.com> <original@example.com>"
+			m.TextBody = "Hi Alice"
+			m.HTMLBody = "<p>Hi Alice</p>"
+		}},
+		{"encoding", func(m *Message) {
+			m.To = []mail.Address{{Name: "Zoë Ångström", Address: "zoe@example.com"}}
+			m.Subject = "Grüße aus München, and a subject that is long enough to need more than one encoded word"
+			m.TextBody = "Grüße!\n" + strings.Repeat("A long line without breaks. ", 40) + "\nTrailing space \n"
+			m.HTMLBody = "<p>Grüße!</p>"
+		}},
+		{"folding", func(m *Message) {
+			for i := range 6 {
+				m.To = append(m.To, mail.Address{Name: fmt.Sprintf("Recipient %d", i), Address: fmt.Sprintf("recipient%d@example.com", i)})
+			}
+			m.Subject = "A plain ASCII subject that goes on and on, well past the recommended line length of 78"
+			m.TextBody = "Hi all"
+		}},
+		{"inline_images", func(m *Message) {
+			m.HTMLBody = `<p>Look:</p><img src="cid:pixel@example.com">`
+			m.Attachments = []Attachment{{Filename: "pixel.png", ContentType: "image/png", ContentID: "pixel@example.com", Data: pixel}}
+		}},
+		{"attachments", func(m *Message) {
+			m.TextBody = "Files attached"
+			m.HTMLBody = `<p>Files attached</p><img src="cid:pixel@example.com">`
+			m.Attachments = []Attachment{
+				{Filename: "pixel.png", ContentType: "image/png", ContentID: "pixel@example.com", Data: pixel},
+				{Filename: "notes.txt", ContentType: "text/plain; charset=utf-8", Data: []byte("some notes")},
+				{Filename: "Übersicht 2025.bin", Data: bytes.Repeat([]byte{0, 1, 2, 250}, 40)},
+			}
+		}},
+		{"calendar", func(m *Message) {
+			m.Subject = "Accepted: Plans"
+			m.TextBody = "Me accepted the invite."
+			m.CalendarMethod = "REPLY"
+			m.Calendar = "BEGIN:VCALENDAR\nMETHOD:REPLY\nBEGIN:VEVENT\nUID:plans@example.com\nEND:VEVENT\nEND:VCALENDAR\n"
+		}},
+		{"attachment_without_html", func(m *Message) {
+			m.TextBody = "No HTML, so the image is a regular attachment"
+			m.Attachments = []Attachment{{Filename: "pixel.png", ContentType: "image/png", ContentID: "pixel@example.com", Data: pixel}}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMessage()
+			tt.modify(&m)
+
+			var buf bytes.Buffer
+			if err := m.encode(&buf, sequentialBoundaries()); err != nil {
+				t.Fatalf("encode failed: %v", err)
+			}
+			got := buf.Bytes()
+
+			golden := filepath.Join("testdata", tt.name+".eml")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatalf("Failed to update golden file: %v", err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("Failed to read golden file, run with -update to create it: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("Output doesn't match %s, run with -update if the change is intended. Got:\n%s", golden, got)
+			}
+
+			for i, line := range strings.Split(string(got), "\r\n") {
+				if strings.ContainsAny(line, "\r\n") {
+					t.Errorf("Line %d has a bare CR or LF: %q", i+1, line)
+				}
+				if len(line) > maxLineLength {
+					t.Errorf("Line %d is %d characters long", i+1, len(line))
+				}
+			}
+		})
+	}
+}
+
+func TestMessageEncodeRoundTrip(t *testing.T) {
+	m := testMessage()
+	m.Subject = "Grüße aus München"
+	m.TextBody = "Grüße!\n" + strings.Repeat("x", 1200)
+	m.HTMLBody = `<p>Grüße!</p><img src="cid:pixel@example.com">`
+	m.Attachments = []Attachment{
+		{Filename: "pixel.png", ContentType: "image/png", ContentID: "pixel@example.com", Data: []byte("image")},
+		{Filename: "Übersicht.txt", ContentType: "text/plain", Data: []byte("notes")},
+	}
+
+	raw, err := m.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	envelope, err := enmime.ReadEnvelope(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Failed to parse the message: %v", err)
+	}
+
+	if envelope.GetHeader("Subject") != m.Subject {
+		t.Errorf("Expected subject %q, got %q", m.Subject, envelope.GetHeader("Subject"))
+	}
+	if strings.ReplaceAll(envelope.Text, "\r\n", "\n") != m.TextBody {
+		t.Errorf("Expected the text body to survive encoding, got %q", envelope.Text)
+	}
+	if envelope.HTML != m.HTMLBody {
+		t.Errorf("Expected HTML body %q, got %q", m.HTMLBody, envelope.HTML)
+	}
+	if len(envelope.Inlines) != 1 || envelope.Inlines[0].ContentID != "pixel@example.com" {
+		t.Errorf("Expected the inline image, got %d inlines", len(envelope.Inlines))
+	}
+	if len(envelope.Attachments) != 1 || envelope.Attachments[0].FileName != "Übersicht.txt" {
+		t.Fatalf("Expected the Übersicht.txt attachment, got %d attachments", len(envelope.Attachments))
+	}
+	if string(envelope.Attachments[0].Content) != "notes" {
+		t.Errorf("Expected attachment content 'notes', got %q", envelope.Attachments[0].Content)
+	}
+}
+
+func TestMessageEncodeInvalidContentType(t *testing.T) {
+	m := testMessage()
+	m.Attachments = []Attachment{{Filename: "a.bin", ContentType: "not a type"}}
+	if _, err := m.Bytes(); err == nil {
+		t.Error("Expected an error for an invalid content type")
+	}
+}
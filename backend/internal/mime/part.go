@@ -0,0 +1,175 @@
+package mime
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	gomime "mime"
+	"mime/quotedprintable"
+	"strings"
+)
+
+const (
+	// headerLineLength is how long we keep header lines where there's a space to fold at. RFC 5322 recommends 78.
+	headerLineLength = 78
+	// maxLineLength is the longest line that can stay 7bit. It's the RFC 5322 limit without the CRLF.
+	maxLineLength = 998
+	// base64LineLength is the length of base64 lines, the most that RFC 2045 allows.
+	base64LineLength = 76
+)
+
+// part is a MIME entity: either a leaf with an encoded body, or a multipart with children.
+type part struct {
+	// contentType is the full Content-Type of leaves, and subtype is the subtype of multiparts, like "mixed".
+	contentType string
+	subtype     string
+	encoding    string
+	// header is the other header fields of leaves, like Content-Disposition, in order.
+	header   [][2]string
+	body     []byte
+	children []*part
+}
+
+func multipart(subtype string, children ...*part) *part {
+	return &part{subtype: subtype, children: children}
+}
+
+// textPart returns a UTF-8 text part. Bodies with only ASCII and short lines stay 7bit, and others are
+// quoted-printable, which keeps them readable and within the line length limit.
+func textPart(subtype, text string) *part {
+	p := &part{contentType: "text/" + subtype + "; charset=utf-8"}
+	if is7bit(text) {
+		p.encoding = "7bit"
+		p.body = []byte(normalizeNewlines(text))
+		return p
+	}
+
+	var buf bytes.Buffer
+	writer := quotedprintable.NewWriter(&buf)
+	_, _ = writer.Write([]byte(text))
+	_ = writer.Close()
+	p.encoding = "quoted-printable"
+	p.body = buf.Bytes()
+	return p
+}
+
+// attachmentPart returns a base64 part for the attachment, with an inline or an attachment disposition.
+func attachmentPart(attachment Attachment, inline bool) (*part, error) {
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	mediaType, params, err := gomime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type %q of attachment %q: %w", contentType, attachment.Filename, err)
+	}
+
+	disposition := "attachment"
+	if inline {
+		disposition = "inline"
+	}
+	dispositionParams := map[string]string{}
+	if attachment.Filename != "" {
+		// Some clients only look at the name parameter of the Content-Type
+		params["name"] = attachment.Filename
+		dispositionParams["filename"] = attachment.Filename
+	}
+
+	p := &part{
+		contentType: gomime.FormatMediaType(mediaType, params),
+		encoding:    "base64",
+		header:      [][2]string{{"Content-Disposition", gomime.FormatMediaType(disposition, dispositionParams)}},
+		body:        encodeBase64(attachment.Data),
+	}
+	if inline {
+		p.header = append(p.header, [2]string{"Content-ID", "<" + attachment.ContentID + ">"})
+	}
+	return p, nil
+}
+
+// write writes the part's header and body to buf, without a CRLF at the end.
+func (p *part) write(buf *bytes.Buffer, newBoundary func() (string, error)) error {
+	if p.subtype == "" {
+		writeHeader(buf, "Content-Type", p.contentType)
+		writeHeader(buf, "Content-Transfer-Encoding", p.encoding)
+		for _, field := range p.header {
+			writeHeader(buf, field[0], field[1])
+		}
+		buf.WriteString("\r\n")
+		buf.Write(p.body)
+		return nil
+	}
+
+	boundary, err := newBoundary()
+	if err != nil {
+		return err
+	}
+	writeHeader(buf, "Content-Type", gomime.FormatMediaType("multipart/"+p.subtype, map[string]string{"boundary": boundary}))
+	buf.WriteString("\r\n")
+	for _, child := range p.children {
+		buf.WriteString("--" + boundary + "\r\n")
+		if err := child.write(buf, newBoundary); err != nil {
+			return err
+		}
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("--" + boundary + "--")
+	return nil
+}
+
+// writeHeader writes a header field. It folds the value at spaces, so that lines stay within headerLineLength
+// where the value allows it. Line breaks in the value become spaces, so that they can't start new fields.
+func writeHeader(buf *bytes.Buffer, name, value string) {
+	value = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(value)
+
+	buf.WriteString(name + ":")
+	lineLength := len(name) + 1
+	for i, word := range strings.Split(value, " ") {
+		if i > 0 && lineLength+1+len(word) > headerLineLength {
+			buf.WriteString("\r\n")
+			lineLength = 0
+		}
+		buf.WriteString(" " + word)
+		lineLength += 1 + len(word)
+	}
+	buf.WriteString("\r\n")
+}
+
+// is7bit tells whether the text can go without a transfer encoding: only ASCII without NUL, and no line longer
+// than maxLineLength.
+func is7bit(text string) bool {
+	lineLength := 0
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '\n' || c == '\r':
+			lineLength = 0
+		case c == 0 || c >= 0x80:
+			return false
+		default:
+			lineLength++
+			if lineLength > maxLineLength {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// normalizeNewlines turns LF and lone CR line breaks into CRLF.
+func normalizeNewlines(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	return strings.ReplaceAll(text, "\n", "\r\n")
+}
+
+// encodeBase64 encodes data in base64 lines of base64LineLength, separated by CRLF.
+func encodeBase64(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var buf bytes.Buffer
+	for len(encoded) > base64LineLength {
+		buf.WriteString(encoded[:base64LineLength] + "\r\n")
+		encoded = encoded[base64LineLength:]
+	}
+	buf.WriteString(encoded)
+	return buf.Bytes()
+}
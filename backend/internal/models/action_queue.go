@@ -0,0 +1,32 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Action types in the action queue.
+const (
+	// ActionTypeSendEmail sends a message from the outbox. Its payload is an OutboxEmail.
+	ActionTypeSendEmail = "send_email"
+)
+
+// QueuedAction is an action in the action queue that runs at ProcessAt.
+type QueuedAction struct {
+	ID         string
+	UserID     string
+	ActionType string
+	Payload    json.RawMessage
+	// Attempts is how many times the action failed so far, and LastError is the error of the last failed attempt.
+	Attempts  int
+	LastError string
+	CreatedAt time.Time
+	ProcessAt time.Time
+}
+
+// OutboxEmail is the payload of a send_email action.
+type OutboxEmail struct {
+	// LoginEmail is the sender address if the SMTP username isn't an email address.
+	LoginEmail string        `json:"login_email"`
+	Email      OutgoingEmail `json:"email"`
+}
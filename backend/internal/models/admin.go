@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// AdminUser is a user as the admin API shows them, with how much of their mail we keep.
+type AdminUser struct {
+	ID        string         `json:"id"`
+	Email     string         `json:"email"`
+	CreatedAt time.Time      `json:"created_at"`
+	Stats     AdminUserStats `json:"stats"`
+}
+
+// AdminUserStats is how much of a user's mail we keep in the cache, and when we last synced it.
+type AdminUserStats struct {
+	ThreadCount  int `json:"thread_count"`
+	MessageCount int `json:"message_count"`
+	// BodyBytes is the size of the cached message bodies, as stored, so compressed bodies count compressed.
+	BodyBytes int64 `json:"body_bytes"`
+	// UploadBytes is the size of the attachment uploads that haven't been sent or expired yet.
+	UploadBytes int64 `json:"upload_bytes"`
+	// LastSyncedAt is when the last folder sync finished, or nil if none did yet.
+	LastSyncedAt *time.Time `json:"last_synced_at"`
+}
+
+// AdminUserDetail is a user with the sync state of each of their folders, for the admin API.
+type AdminUserDetail struct {
+	AdminUser
+	Folders []*FolderSyncStatus `json:"folders"`
+}
@@ -2,10 +2,120 @@ package models
 
 import "time"
 
-// Folder represents an IMAP folder with its role determined by SPECIAL-USE attributes (RFC 6154).
+// Folder represents an IMAP folder with its role determined by SPECIAL-USE attributes (RFC 6154),
+// guessed from its name, or set by the user. See FolderRoleOverride.
 type Folder struct {
 	Name string `json:"name"`
-	Role string `json:"role"` // "inbox", "sent", "drafts", "spam", "trash", "archive", "other"
+	Role string `json:"role"` // "inbox", "starred", "sent", "drafts", "spam", "trash", "archive", "all", "other"
+	// RoleOverridden is true if the user set the role by hand.
+	RoleOverridden bool `json:"role_overridden"`
+	// Virtual is true for folders that only exist in our cache, like the starred folder. See StarredFolderName.
+	Virtual bool `json:"virtual"`
+}
+
+// Names of the virtual folders. We only list a virtual folder if the server has no real folder for it.
+const (
+	// StarredFolderName lists the threads with starred (\Flagged) messages from all folders,
+	// like Gmail's "[Gmail]/Starred".
+	StarredFolderName = "starred"
+	// AllMailFolderName lists the threads from all folders but Trash and Spam, like Gmail's "[Gmail]/All Mail".
+	AllMailFolderName = "all"
+	// SnoozedFolderName lists the snoozed threads, which the other folders leave out until their snooze ends.
+	SnoozedFolderName = "snoozed"
+)
+
+// FolderRoleOverride is a folder role that the user set by hand.
+// A nil Role means that the folder uses the role we detect.
+type FolderRoleOverride struct {
+	FolderName string  `json:"folder_name"`
+	Role       *string `json:"role"`
+}
+
+// FolderSubscription is a folder on the IMAP server, and whether the user is subscribed to it.
+// Other mail clients usually only show subscribed folders.
+type FolderSubscription struct {
+	Name string `json:"name"`
+	// Delimiter separates the levels of the folder hierarchy in names, like "/" in "Projects/2025".
+	// Empty if the server has no hierarchy.
+	Delimiter  string `json:"delimiter"`
+	Subscribed bool   `json:"subscribed"`
+	// Selectable is false for folders that can only hold other folders, not messages (\Noselect).
+	Selectable bool `json:"selectable"`
+}
+
+// FolderSubscriptionsResponse is the response of GET /api/v1/folders/subscriptions.
+type FolderSubscriptionsResponse struct {
+	Folders []FolderSubscription `json:"folders"`
+}
+
+// FolderRequest is the body for creating a folder, or for renaming one, with its new name.
+type FolderRequest struct {
+	Name string `json:"name"`
+}
+
+// Folder sync modes. See FolderSyncPreference.
+const (
+	// FolderSyncModeHeadersOnly caches headers on sync, and bodies only when the user opens a thread.
+	FolderSyncModeHeadersOnly = "headers_only"
+	// FolderSyncModeFull also downloads the bodies of new messages on sync.
+	FolderSyncModeFull = "full"
+)
+
+// FolderSyncPreference describes how we sync an IMAP folder for a user.
+type FolderSyncPreference struct {
+	FolderName string `json:"folder_name"`
+	Enabled    bool   `json:"enabled"`
+	Mode       string `json:"mode"` // FolderSyncModeHeadersOnly or FolderSyncModeFull
+	// SyncDays and SyncMaxMessages limit how far back we sync and keep the folder's messages: those sent in the
+	// last SyncDays days, and the newest SyncMaxMessages. Nil means no limit. See db.PruneFolderCaches.
+	SyncDays        *int      `json:"sync_days"`
+	SyncMaxMessages *int      `json:"sync_max_messages"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// SyncThrottle is the throttling state of a user's IMAP account. See the sync_throttles table.
+type SyncThrottle struct {
+	// Strikes is how many syncs in a row were throttled or lost their connection.
+	Strikes int
+	// ThrottledUntil is when we sync the account again. Nil if we don't hold syncs back.
+	ThrottledUntil *time.Time
+	// Reason is the server's response or the error that made us back off.
+	Reason string
+}
+
+// SyncStatus is the response of GET /api/v1/sync/status.
+type SyncStatus struct {
+	// Throttled is true if we hold syncs back, because the IMAP server throttled us or kept dropping the connection.
+	Throttled      bool       `json:"throttled"`
+	ThrottledUntil *time.Time `json:"throttled_until,omitempty"`
+	// Reason is the server's response or the error that made us back off.
+	Reason string `json:"reason,omitempty"`
+	// Folders are the folders we've started syncing, sorted by name.
+	Folders []*FolderSyncStatus `json:"folders"`
+}
+
+// SyncJobResponse is the response of POST /api/v1/sync.
+type SyncJobResponse struct {
+	// JobID is in the sync events of the job, so that clients can follow its progress.
+	JobID  string `json:"job_id"`
+	Folder string `json:"folder"`
+	// Started is false if the folder was already syncing, so the job is the running sync.
+	Started bool `json:"started"`
+}
+
+// FolderSyncStatus is the sync state of one folder, in SyncStatus.
+type FolderSyncStatus struct {
+	FolderName string `json:"folder_name"`
+	// SyncedAt is when the last sync finished, or nil if the first one is still running.
+	SyncedAt      *time.Time `json:"synced_at"`
+	LastSyncedUID *int64     `json:"last_synced_uid"`
+	ThreadCount   int        `json:"thread_count"`
+	// Syncing is true while a sync of the folder runs. SyncStartedAt is when it started.
+	Syncing       bool       `json:"syncing"`
+	SyncStartedAt *time.Time `json:"sync_started_at,omitempty"`
+	// LastError is why the last sync failed, or empty if it succeeded.
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
 }
 
 // Thread represents an email thread containing multiple messages.
@@ -21,8 +131,74 @@ type Thread struct {
 	PreviewSnippet          string     `json:"preview_snippet,omitempty"`
 	HasAttachments          bool       `json:"has_attachments"`
 	MessageCount            int        `json:"message_count,omitempty"`
+	UnreadCount             int        `json:"unread_count"`
 	LastSentAt              *time.Time `json:"last_sent_at,omitempty"`
-	Messages                []Message  `json:"messages,omitempty"`
+	// ImportanceScore is from 0 to 100. See the importance package for how we calculate it.
+	ImportanceScore int       `json:"importance_score"`
+	IsImportant     bool      `json:"is_important"`
+	Messages        []Message `json:"messages,omitempty"`
+	// Drafts are the user's unsent replies to messages of the thread. Only the thread view sets them.
+	Drafts []*Draft `json:"drafts,omitempty"`
+	// Segment is set if Messages are only a part of the thread, because it's a mega-thread.
+	// MessageCount and UnreadCount still count the whole thread.
+	Segment *ThreadSegment `json:"segment,omitempty"`
+	// Labels are the labels of all messages in the thread, sorted.
+	Labels []string `json:"labels,omitempty"`
+	// SnoozedUntil is when the thread comes back to its folders, if the user snoozed it. See the snoozes table.
+	SnoozedUntil *time.Time `json:"snoozed_until,omitempty"`
+	// BodyCached is true if we have the bodies of all messages in the thread, so opening it doesn't have to wait for
+	// the IMAP server. Thread lists and search results set it. See db.EvictMessageBodies.
+	BodyCached bool `json:"body_cached"`
+	// FromServer is true in search results if only the IMAP server found some of the thread's matching messages,
+	// since we hadn't cached them, for example, because they're beyond the folder's sync depth.
+	FromServer bool `json:"from_server,omitempty"`
+}
+
+// ThreadSegment describes the part of a mega-thread that the thread view returned: its messages from a date range.
+type ThreadSegment struct {
+	MessageCount int        `json:"message_count"`
+	OldestSentAt *time.Time `json:"oldest_sent_at"`
+	NewestSentAt *time.Time `json:"newest_sent_at"`
+	// OlderCursor points to the segment before this one, or is nil if this segment starts the thread.
+	OlderCursor *string `json:"older_cursor"`
+}
+
+// ThreadImportanceSignals holds what we know about a thread when scoring its importance.
+// The sender is the sender of the latest message in the thread that the user didn't send.
+type ThreadImportanceSignals struct {
+	ThreadID string
+	// SenderMessageCount is how many cached messages we have from this sender.
+	SenderMessageCount int
+	// UserRepliedToSender is true if the user ever sent a message to this sender.
+	UserRepliedToSender bool
+	// SenderStarred is true if the user ever starred a message from this sender.
+	SenderStarred bool
+	// DirectToUser is true if the user is in the To header, CCToUser if only in CC.
+	// If both are false, the message probably came through a mailing list.
+	DirectToUser  bool
+	CCToUser      bool
+	ThreadStarred bool
+}
+
+// NewMailMessage is what a push notification needs to know about a new message.
+type NewMailMessage struct {
+	StableThreadID  string
+	FromAddress     string
+	Subject         string
+	ImportanceScore int
+}
+
+// AutoReplyMessage is what an auto-responder needs to know about a new message to reply to it.
+type AutoReplyMessage struct {
+	// FromAddress is the bare address to reply to.
+	FromAddress string
+	Subject     string
+	// MessageID is the Message-ID of the message, with angle brackets, or empty if it has none.
+	MessageID string
+	// References are the Message-IDs that the message replies to, oldest first.
+	References []string
+	// SentAt is the Date header of the message, or zero if it has none.
+	SentAt time.Time
 }
 
 // Message represents a single email message.
@@ -43,11 +219,122 @@ type Message struct {
 	Subject         string       `json:"subject"`
 	UnsafeBodyHTML  string       `json:"unsafe_body_html"`
 	BodyText        string       `json:"body_text"`
+	Snippet         string       `json:"snippet,omitempty"`
 	IsRead          bool         `json:"is_read"`
 	IsStarred       bool         `json:"is_starred"`
 	Attachments     []Attachment `json:"attachments,omitempty"`
+	// PlusAliasLabel is the label of the user's plus alias that the message was sent to, if any.
+	// Only the thread view sets it.
+	PlusAliasLabel string `json:"plus_alias_label,omitempty"`
+	// RemoteImagesAllowed is true if the sender is trusted, so the front end shows remote images right away.
+	// Only the thread view sets it.
+	RemoteImagesAllowed bool `json:"remote_images_allowed"`
+	// SizeBytes is the size of the whole message on the IMAP server, or 0 if we don't know it.
+	SizeBytes int64 `json:"size_bytes,omitempty"`
+	// Labels are the custom IMAP keywords of the message, like "$Label1". See the message_labels table.
+	Labels []string `json:"labels,omitempty"`
+	// Truncated is true if the body is cut short, because the message or one of its parts was over the
+	// fetch size limits. See imap.FetchLimits.
+	Truncated bool `json:"truncated,omitempty"`
+	// BodyCached is true if we have the body. If it's false, the thread view fetches it from the IMAP server.
+	BodyCached bool `json:"body_cached"`
+	// CalendarEvent is the event of the message's iCalendar invite, if it has one. Only the thread view sets it.
+	CalendarEvent *CalendarEvent `json:"calendar_event,omitempty"`
+	// LinkAnalysis is how suspicious the links of the body look. Only the thread view sets it, for bodies we've
+	// analyzed. See the linkcheck package.
+	LinkAnalysis *LinkAnalysis `json:"link_analysis,omitempty"`
+	// Authenticity is what the user's server found when it checked the sender. Only the thread view sets it, for
+	// messages whose server added an Authentication-Results header.
+	Authenticity *Authenticity `json:"authenticity,omitempty"`
+	// AuthenticationResults are the raw Authentication-Results headers of the body, topmost first. Saving the message
+	// turns the one from the user's server into Authenticity.
+	AuthenticationResults []string `json:"-"`
+	// ReferencedMessageIDs are the Message-IDs from the References and In-Reply-To headers, oldest first.
+	// See db.RepairThreads.
+	ReferencedMessageIDs []string `json:"-"`
 }
 
+// CalendarEvent is an event from the text/calendar part of a message, like a meeting invite.
+type CalendarEvent struct {
+	UID string `json:"uid"`
+	// Method is the iTIP method of the invite, like "REQUEST", "CANCEL", or "REPLY". Empty if it had none.
+	Method   string     `json:"method,omitempty"`
+	Summary  string     `json:"summary"`
+	Location string     `json:"location,omitempty"`
+	StartsAt time.Time  `json:"starts_at"`
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+	// AllDay is true if the event has dates without times. Then StartsAt and EndsAt are midnight UTC.
+	AllDay    bool                  `json:"all_day"`
+	Organizer *CalendarParticipant  `json:"organizer,omitempty"`
+	Attendees []CalendarParticipant `json:"attendees"`
+}
+
+// The answers to a calendar invite, as the lowercase PARTSTAT values of CalendarParticipant.Status.
+const (
+	CalendarStatusAccepted  = "accepted"
+	CalendarStatusTentative = "tentative"
+	CalendarStatusDeclined  = "declined"
+)
+
+// RSVPRequest is the body of POST /api/v1/messages/{id}/rsvp.
+type RSVPRequest struct {
+	// Response is "accept", "tentative", or "decline".
+	Response string `json:"response"`
+}
+
+// CalendarParticipant is the organizer or an attendee of a CalendarEvent.
+type CalendarParticipant struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+	// Status is the attendee's answer, in lowercase, like "accepted", "declined", "tentative", or "needs-action".
+	// Organizers have none.
+	Status string `json:"status,omitempty"`
+}
+
+// LinkAnalysis is how suspicious the links of a message body look, for phishing warnings.
+type LinkAnalysis struct {
+	// SuspiciousScore goes from 0, for nothing suspicious, to 100.
+	SuspiciousScore int           `json:"suspicious_score"`
+	Warnings        []LinkWarning `json:"warnings"`
+}
+
+// The kinds of LinkWarning.
+const (
+	// LinkWarningMismatchedText is a link whose text shows another domain than the one it goes to.
+	LinkWarningMismatchedText = "mismatched_text"
+	// LinkWarningLookalikeDomain is a link to an internationalized domain that looks like an ASCII one.
+	LinkWarningLookalikeDomain = "lookalike_domain"
+	// LinkWarningSuspiciousTLD is a link to a top-level domain that's popular with phishing.
+	LinkWarningSuspiciousTLD = "suspicious_tld"
+)
+
+// LinkWarning is a link of a message body that looks like phishing.
+type LinkWarning struct {
+	// Kind is one of the LinkWarning constants.
+	Kind string `json:"kind"`
+	URL  string `json:"url"`
+	// Domain is the domain of the URL. Internationalized ones are in punycode, which shows them for what they are.
+	Domain string `json:"domain"`
+	// Text is the text of the link, for mismatched text.
+	Text string `json:"text,omitempty"`
+}
+
+// Authenticity is the result of the DKIM, SPF, and DMARC checks that the user's server did when it received a
+// message, from the Authentication-Results header. Results are in lowercase, like "pass", "fail", "softfail", or
+// "none", and empty for checks the server didn't report.
+type Authenticity struct {
+	// AuthServID is the server that did the checks, like "mx.google.com".
+	AuthServID string `json:"authserv_id"`
+	DKIM       string `json:"dkim,omitempty"`
+	SPF        string `json:"spf,omitempty"`
+	DMARC      string `json:"dmarc,omitempty"`
+	// Verified is true if DMARC passed, or, without DMARC, if DKIM or SPF passed for the domain of the From address.
+	Verified bool `json:"verified"`
+}
+
+// AuthResultPass is the result of a DKIM, SPF, or DMARC check that passed.
+const AuthResultPass = "pass"
+
 // Attachment represents an email attachment.
 // If IsInline is true, the attachment is meant to be shown inside the email body
 // (e.g., a signature image). The ContentID is used to match inline attachments
@@ -66,11 +353,262 @@ type Attachment struct {
 type ThreadsResponse struct {
 	Threads    []*Thread      `json:"threads"`
 	Pagination PaginationInfo `json:"pagination"`
+	// Groups is only set for split-inbox requests.
+	Groups *ThreadGroups `json:"groups,omitempty"`
+	// Partial is true if the threads are a quick preview of a folder that we're still syncing.
+	Partial bool `json:"partial,omitempty"`
+	// Syncing is true if the folder is still syncing in the background. The client gets a
+	// "sync_complete" WebSocket message when the sync finishes.
+	Syncing bool `json:"syncing,omitempty"`
+}
+
+// ThreadGroups holds the sizes of the two groups in a split inbox.
+type ThreadGroups struct {
+	ImportantCount int `json:"important_count"`
+	OtherCount     int `json:"other_count"`
+}
+
+// SyncDelta is what changed in a user's cached mailbox since a cursor, for clients that were offline.
+// See GET /api/v1/sync/delta.
+type SyncDelta struct {
+	// Cursor is where the next delta starts. Clients should pass it back as "since".
+	Cursor string `json:"cursor"`
+	// Reset is true if the cursor was too old, too much changed to list, or the user's cache was cleared.
+	// The client should then refetch its folders and thread lists, and continue from Cursor.
+	Reset   bool           `json:"reset"`
+	Threads []*ThreadDelta `json:"threads"`
+	// DeletedThreadIDs are the stable IDs of the threads that have no messages left.
+	DeletedThreadIDs []string        `json:"deleted_thread_ids"`
+	Folders          []*FolderCounts `json:"folders"`
+}
+
+// ThreadDelta is the current state of a thread that changed. Folders lists every folder
+// that has a message of the thread, so clients can drop the thread from the other lists.
+type ThreadDelta struct {
+	StableThreadID string     `json:"stable_thread_id"`
+	Subject        string     `json:"subject"`
+	Folders        []string   `json:"folders"`
+	MessageCount   int        `json:"message_count"`
+	UnreadCount    int        `json:"unread_count"`
+	IsStarred      bool       `json:"is_starred"`
+	LastSentAt     *time.Time `json:"last_sent_at,omitempty"`
+}
+
+// FolderCounts are the current thread counts of a folder.
+type FolderCounts struct {
+	Name              string `json:"name"`
+	ThreadCount       int    `json:"thread_count"`
+	UnreadThreadCount int    `json:"unread_thread_count"`
 }
 
 // PaginationInfo contains pagination metadata for list responses.
+// Build it with pagination.NewInfo so that all list endpoints fill it in the same way.
 type PaginationInfo struct {
 	TotalCount int `json:"total_count"`
-	Page       int `json:"page"`
-	PerPage    int `json:"per_page"`
+	// TotalEstimated is true if TotalCount is an approximation rather than an exact count.
+	TotalEstimated bool `json:"total_estimated"`
+	Page           int  `json:"page"`
+	PerPage        int  `json:"per_page"`
+	// NextCursor points to the next page. It's nil on the last page.
+	NextCursor *string `json:"next_cursor"`
+}
+
+// OutgoingEmail is a message that the user wants to send.
+// Addresses can be "Name <mailbox@host>" or just "mailbox@host".
+type OutgoingEmail struct {
+	To          []string             `json:"to"`
+	Cc          []string             `json:"cc"`
+	Bcc         []string             `json:"bcc"`
+	Subject     string               `json:"subject"`
+	TextBody    string               `json:"text_body"`
+	HTMLBody    string               `json:"html_body"`
+	Attachments []OutgoingAttachment `json:"attachments"`
+	// InReplyTo is the Message-ID of the message that this one replies to, if any.
+	InReplyTo string `json:"in_reply_to,omitempty"`
+	// References are the Message-IDs of the thread, oldest first, ending with InReplyTo. Empty means just InReplyTo.
+	References []string `json:"references,omitempty"`
+	// IdentityID is the ID of the SendIdentity to send as. Empty means the user's main address.
+	IdentityID string `json:"identity_id,omitempty"`
+	// SendAt schedules the message for a later time. Nil means after the user's undo send delay.
+	// Only the send endpoint reads it.
+	SendAt *time.Time `json:"send_at,omitempty"`
+	// Calendar is an iCalendar object to send along with the bodies, with CalendarMethod, like "REPLY".
+	// Only RSVPs set it. See mime.Message.
+	Calendar       string `json:"-"`
+	CalendarMethod string `json:"-"`
+	// AutoSubmitted is the Auto-Submitted header, like "auto-replied". Only auto-responders set it.
+	AutoSubmitted string `json:"-"`
+}
+
+// OutgoingAttachment is a file attached to an OutgoingEmail.
+type OutgoingAttachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	// Data is base64-encoded in JSON.
+	Data []byte `json:"data"`
+	// ContentID makes the attachment an inline image, which the HTML body refers to as "cid:" + ContentID.
+	// It's without angle brackets.
+	ContentID string `json:"content_id,omitempty"`
+	// UploadID is the ID of an AttachmentUpload to attach instead of sending the file inline.
+	// The upload fills in the other fields.
+	UploadID string `json:"upload_id,omitempty"`
+}
+
+// AttachmentUpload is a file that the user uploaded while writing a message. See the attachment_uploads table.
+type AttachmentUpload struct {
+	ID          string `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	SizeBytes   int64  `json:"size_bytes"`
+	// Data is only loaded when we build the message.
+	Data      []byte    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is when we delete the upload, unless a draft references it.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Reply template modes. See ReplyTemplate.
+const (
+	ReplyModeReply    = "reply"
+	ReplyModeReplyAll = "reply_all"
+	ReplyModeForward  = "forward"
+)
+
+// ReplyTemplate is what the compose window starts with when the user replies to or forwards a message.
+type ReplyTemplate struct {
+	Mode string `json:"mode"`
+	// MessageID is the ID of the message that the template replies to or forwards.
+	MessageID string   `json:"message_id"`
+	To        []string `json:"to"`
+	Cc        []string `json:"cc"`
+	Subject   string   `json:"subject"`
+	// TextBody and UnsafeHTMLBody end with the quoted or forwarded message. UnsafeHTMLBody has the original HTML in it,
+	// so sanitize it like UnsafeBodyHTML before showing it.
+	TextBody       string `json:"text_body"`
+	UnsafeHTMLBody string `json:"unsafe_html_body"`
+	// InReplyTo and References go into the OutgoingEmail as they are. Forwards have neither.
+	InReplyTo  string   `json:"in_reply_to,omitempty"`
+	References []string `json:"references"`
+	// Attachments are the attachments of a forwarded message, which the user can download and attach again.
+	// Replies have none.
+	Attachments []Attachment `json:"attachments"`
+}
+
+// Draft is a message that the user is still writing.
+// We cache it in Postgres, and keep a copy in the IMAP Drafts folder.
+type Draft struct {
+	ID string `json:"id"`
+	// InReplyToMessageID is the Message-ID of the message that the draft replies to, if any.
+	InReplyToMessageID string   `json:"in_reply_to_message_id,omitempty"`
+	To                 []string `json:"to"`
+	Cc                 []string `json:"cc"`
+	Bcc                []string `json:"bcc"`
+	Subject            string   `json:"subject"`
+	BodyHTML           string   `json:"body_html"`
+	BodyText           string   `json:"body_text"`
+	// AttachmentUploadIDs are the IDs of the AttachmentUploads attached to the draft, in order.
+	AttachmentUploadIDs []string `json:"attachment_upload_ids"`
+	// MessageIDHeader is the Message-ID of the copy in the IMAP Drafts folder.
+	MessageIDHeader string    `json:"message_id_header"`
+	CreatedAt       time.Time `json:"created_at"`
+	LastSavedAt     time.Time `json:"last_saved_at"`
+}
+
+// MoveThreadRequest is the request body of the thread move, archive, and trash endpoints.
+type MoveThreadRequest struct {
+	// Folder is the destination folder. It's only used, and required, by the move endpoint.
+	Folder string `json:"folder,omitempty"`
+	// FromFolder limits the move to the thread's messages in this folder, for example, "INBOX".
+	// If it's empty, all messages of the thread are moved.
+	FromFolder string `json:"from_folder,omitempty"`
+}
+
+// ReportSpamRequest is the optional request body of the report spam and not spam endpoints.
+type ReportSpamRequest struct {
+	// Keywords tells whether to mark the messages with the $Junk or $NotJunk keywords, for spam filters that learn
+	// from them. Nil means true.
+	Keywords *bool `json:"keywords,omitempty"`
+}
+
+// MoveThreadResponse is the response body after moving a thread.
+type MoveThreadResponse struct {
+	Folder     string `json:"folder"`
+	MovedCount int    `json:"moved_count"`
+}
+
+// ThreadLabelRequest is the request body for adding a label to a thread.
+type ThreadLabelRequest struct {
+	Label string `json:"label"`
+}
+
+// ThreadLabelsResponse is the response body after adding or removing a label.
+type ThreadLabelsResponse struct {
+	// Labels are the thread's labels after the change.
+	Labels []string `json:"labels"`
+	// SavedToServer is false if a folder of the thread doesn't allow new keywords,
+	// so we only keep the label in the cache for its messages there.
+	SavedToServer bool `json:"saved_to_server"`
+}
+
+// SnoozeRequest is the request body for snoozing a thread.
+type SnoozeRequest struct {
+	// Until is when the thread comes back to its folders, in RFC 3339 format. It must be in the future.
+	Until time.Time `json:"until"`
+}
+
+// SnoozeResponse is the response body after snoozing a thread.
+type SnoozeResponse struct {
+	SnoozedUntil time.Time `json:"snoozed_until"`
+}
+
+// DraftsResponse is the response body of the drafts list.
+type DraftsResponse struct {
+	Drafts []*Draft `json:"drafts"`
+}
+
+// QueuedEmailResponse is the response body after queueing a message in the outbox.
+// The user can cancel it with the ID until SendAt.
+type QueuedEmailResponse struct {
+	ID     string    `json:"id"`
+	SendAt time.Time `json:"send_at"`
+}
+
+// ScheduledEmail is a message in the outbox, without its bodies and attachments.
+type ScheduledEmail struct {
+	ID string `json:"id"`
+	// SendAt is when the dispatcher sends the message. After a failed attempt, it's the time of the next one.
+	SendAt          time.Time `json:"send_at"`
+	CreatedAt       time.Time `json:"created_at"`
+	To              []string  `json:"to"`
+	Cc              []string  `json:"cc"`
+	Bcc             []string  `json:"bcc"`
+	Subject         string    `json:"subject"`
+	AttachmentCount int       `json:"attachment_count"`
+	// Attempts is how many times sending failed so far, and LastError is the error of the last failed attempt.
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// ScheduledEmailsResponse is the response body of the outbox list.
+type ScheduledEmailsResponse struct {
+	Messages []ScheduledEmail `json:"messages"`
+}
+
+// SendEmailResponse is the response body after sending a message.
+type SendEmailResponse struct {
+	MessageID string `json:"message_id"`
+	// SavedToSent is false if we sent the message but couldn't save a copy to the Sent folder.
+	SavedToSent bool `json:"saved_to_sent"`
+}
+
+// SearchSuggestion is a search operator that the search box can suggest while the user types.
+type SearchSuggestion struct {
+	Operator    string `json:"operator"`
+	Example     string `json:"example"`
+	Description string `json:"description"`
+}
+
+// SearchSuggestionsResponse is the response body of the search suggestions.
+type SearchSuggestionsResponse struct {
+	Suggestions []SearchSuggestion `json:"suggestions"`
 }
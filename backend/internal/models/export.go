@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// The states of a data export.
+const (
+	DataExportStatusRunning = "running"
+	DataExportStatusDone    = "done"
+	DataExportStatusFailed  = "failed"
+)
+
+// DataExport is the state of a user's data export, which they start with POST /api/v1/export.
+type DataExport struct {
+	ID string `json:"id"`
+	// Status is one of the DataExportStatus constants.
+	Status string `json:"status"`
+	// Error is why the export failed, or empty if it didn't.
+	Error string `json:"error,omitempty"`
+	// SizeBytes is the size of the zip file, or 0 until it's done.
+	SizeBytes  int64      `json:"size_bytes,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// ExpiresAt is when we delete the zip file of a done export.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -14,49 +15,539 @@ type User struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
-// UserSettings holds user-specific application settings and encrypted credentials.
+// UserSettings holds the user's connection settings and encrypted credentials.
 // This table has a 1:1 relationship with the users table, following a clear
 // separation of concerns: users handles identity, while user_settings handles
-// application data including IMAP/SMTP credentials (which are encrypted using AES-GCM).
+// IMAP/SMTP credentials (which are encrypted using AES-GCM).
+// UI preferences live in UserPreferences instead.
+// If OAuthProvider is set, the encrypted passwords hold the current OAuth access token. See the oauth package.
 type UserSettings struct {
-	UserID                   string    `json:"user_id"`
-	UndoSendDelaySeconds     int       `json:"undo_send_delay_seconds"`
-	PaginationThreadsPerPage int       `json:"pagination_threads_per_page"`
-	IMAPServerHostname       string    `json:"imap_server_hostname"`
-	IMAPUsername             string    `json:"imap_username"`
-	EncryptedIMAPPassword    []byte    `json:"-"`
-	SMTPServerHostname       string    `json:"smtp_server_hostname"`
-	SMTPUsername             string    `json:"smtp_username"`
-	EncryptedSMTPPassword    []byte    `json:"-"`
-	CreatedAt                time.Time `json:"created_at"`
-	UpdatedAt                time.Time `json:"updated_at"`
+	UserID             string `json:"user_id"`
+	IMAPServerHostname string `json:"imap_server_hostname"`
+	// IMAPPort is the port of the IMAP server, or 0 for the port in IMAPServerHostname, or else the default
+	// port of IMAPSecurity.
+	IMAPPort int `json:"imap_port"`
+	// IMAPSecurity is one of the IMAPSecurity constants, or empty for TLS (no security in test mode).
+	IMAPSecurity string `json:"imap_security"`
+	// IMAPSkipTLSVerify accepts any certificate from the IMAP server, for servers with self-signed certificates.
+	IMAPSkipTLSVerify          bool       `json:"imap_skip_tls_verify"`
+	IMAPUsername               string     `json:"imap_username"`
+	EncryptedIMAPPassword      []byte     `json:"-"`
+	SMTPServerHostname         string     `json:"smtp_server_hostname"`
+	SMTPUsername               string     `json:"smtp_username"`
+	EncryptedSMTPPassword      []byte     `json:"-"`
+	OAuthProvider              string     `json:"oauth_provider"`
+	EncryptedOAuthRefreshToken []byte     `json:"-"`
+	OAuthTokenExpiresAt        *time.Time `json:"-"`
+	CreatedAt                  time.Time  `json:"created_at"`
+	UpdatedAt                  time.Time  `json:"updated_at"`
 }
 
+// The ways to secure the connection to an IMAP server.
+const (
+	// IMAPSecurityTLS uses TLS from the start, usually on port 993.
+	IMAPSecurityTLS = "tls"
+	// IMAPSecuritySTARTTLS connects without TLS and upgrades with STARTTLS, usually on port 143.
+	IMAPSecuritySTARTTLS = "starttls"
+	// IMAPSecurityNone sends everything, passwords too, in plain text. It's for local development only.
+	IMAPSecurityNone = "none"
+)
+
 // UserSettingsRequest represents the request payload for saving user settings.
 type UserSettingsRequest struct {
-	UndoSendDelaySeconds     int    `json:"undo_send_delay_seconds"`
-	PaginationThreadsPerPage int    `json:"pagination_threads_per_page"`
-	IMAPServerHostname       string `json:"imap_server_hostname"`
-	IMAPUsername             string `json:"imap_username"`
-	IMAPPassword             string `json:"imap_password"`
-	SMTPServerHostname       string `json:"smtp_server_hostname"`
-	SMTPUsername             string `json:"smtp_username"`
-	SMTPPassword             string `json:"smtp_password"`
+	IMAPServerHostname string `json:"imap_server_hostname"`
+	IMAPPort           int    `json:"imap_port"`
+	IMAPSecurity       string `json:"imap_security"`
+	IMAPSkipTLSVerify  bool   `json:"imap_skip_tls_verify"`
+	IMAPUsername       string `json:"imap_username"`
+	IMAPPassword       string `json:"imap_password"`
+	SMTPServerHostname string `json:"smtp_server_hostname"`
+	SMTPUsername       string `json:"smtp_username"`
+	SMTPPassword       string `json:"smtp_password"`
 }
 
 // UserSettingsResponse represents the response payload for user settings (passwords are never included).
 type UserSettingsResponse struct {
+	IMAPServerHostname string `json:"imap_server_hostname"`
+	IMAPPort           int    `json:"imap_port"`
+	IMAPSecurity       string `json:"imap_security"`
+	IMAPSkipTLSVerify  bool   `json:"imap_skip_tls_verify"`
+	IMAPUsername       string `json:"imap_username"`
+	IMAPPasswordSet    bool   `json:"imap_password_set"`
+	SMTPServerHostname string `json:"smtp_server_hostname"`
+	SMTPUsername       string `json:"smtp_username"`
+	SMTPPasswordSet    bool   `json:"smtp_password_set"`
+	// OAuthProvider is set if the user connected with OAuth instead of passwords, for example, "google".
+	OAuthProvider string `json:"oauth_provider,omitempty"`
+}
+
+// The problems that a connection check can find, in the order of the steps of connecting.
+const (
+	// ConnectionProblemDNS means that the server hostname doesn't resolve.
+	ConnectionProblemDNS = "dns"
+	// ConnectionProblemConnect means that the server didn't accept the connection, or didn't answer in time.
+	ConnectionProblemConnect = "connect"
+	// ConnectionProblemTLS means that the TLS handshake failed, for example, because of an invalid certificate.
+	ConnectionProblemTLS = "tls"
+	// ConnectionProblemAuth means that the server rejected the username or password.
+	ConnectionProblemAuth = "auth"
+	// ConnectionProblemServer means that the server failed in some other way after we connected.
+	ConnectionProblemServer = "server"
+)
+
+// ConnectionCheck is the result of checking that a mail server accepts the user's settings.
+type ConnectionCheck struct {
+	OK bool `json:"ok"`
+	// Problem is one of the ConnectionProblem constants if OK is false.
+	Problem string `json:"problem,omitempty"`
+	// Error is the error of the failed step, for the details.
+	Error string `json:"error,omitempty"`
+	// Warnings are about things that work, but not as well as they could, like a server without SPECIAL-USE.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// SettingsTestResponse represents the response payload for testing user settings without saving them.
+type SettingsTestResponse struct {
+	IMAP ConnectionCheck `json:"imap"`
+	SMTP ConnectionCheck `json:"smtp"`
+}
+
+// AutodiscoverResponse represents the servers we found for an email address, to prefill the settings.
+// Source is "provider" for the built-in providers, "autoconfig" for Mozilla autoconfig files, or "srv" for
+// DNS SRV records.
+type AutodiscoverResponse struct {
+	IMAPServerHostname string `json:"imap_server_hostname"`
+	IMAPUsername       string `json:"imap_username"`
+	SMTPServerHostname string `json:"smtp_server_hostname"`
+	SMTPUsername       string `json:"smtp_username"`
+	Source             string `json:"source"`
+}
+
+// OAuthProviderResponse describes an OAuth provider that users can connect with.
+// The front end sends the user to AuthURL with the client ID and scope, and posts the code it gets back.
+type OAuthProviderResponse struct {
+	Name     string `json:"name"`
+	AuthURL  string `json:"auth_url"`
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+}
+
+// OAuthConnectRequest represents the request payload for connecting with OAuth.
+// Email is only needed if the user has no settings yet. It becomes the IMAP and SMTP username.
+type OAuthConnectRequest struct {
+	Provider     string `json:"provider"`
+	Code         string `json:"code"`
+	RedirectURI  string `json:"redirect_uri"`
+	CodeVerifier string `json:"code_verifier"`
+	Email        string `json:"email"`
+}
+
+// SendIdentity is an address that the user can send as, besides their main one, for example, an alias.
+// If SMTPServerHostname is empty, it sends through the SMTP server in the user's settings.
+type SendIdentity struct {
+	ID                    string `json:"id"`
+	UserID                string `json:"-"`
+	Name                  string `json:"name"`
+	Email                 string `json:"email"`
+	SMTPServerHostname    string `json:"smtp_server_hostname"`
+	SMTPUsername          string `json:"smtp_username"`
+	EncryptedSMTPPassword []byte `json:"-"`
+	// Signature is plain text that we add to the end of messages sent as this identity.
+	Signature string    `json:"signature"`
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SendIdentityRequest represents the request payload for creating or updating a send identity.
+// SMTPPassword can be empty on update to keep the existing one.
+type SendIdentityRequest struct {
+	Name               string `json:"name"`
+	Email              string `json:"email"`
+	SMTPServerHostname string `json:"smtp_server_hostname"`
+	SMTPUsername       string `json:"smtp_username"`
+	SMTPPassword       string `json:"smtp_password"`
+	Signature          string `json:"signature"`
+}
+
+// SendIdentityResponse represents a send identity in responses (passwords are never included).
+type SendIdentityResponse struct {
+	ID                 string `json:"id"`
+	Name               string `json:"name"`
+	Email              string `json:"email"`
+	SMTPServerHostname string `json:"smtp_server_hostname"`
+	SMTPUsername       string `json:"smtp_username"`
+	SMTPPasswordSet    bool   `json:"smtp_password_set"`
+	Signature          string `json:"signature"`
+}
+
+// PlusAlias is a plus-addressed variant of the user's address, for example, "user+shop-x7q@example.com".
+// Users generate one for each place they give their address to, so that mail sent to it tells them who leaked it.
+type PlusAlias struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"-"`
+	Address   string    `json:"address"`
+	Label     string    `json:"label"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Blocked sender actions. See BlockedSender.
+const (
+	// BlockedSenderActionTrash moves new messages from the sender to Trash.
+	BlockedSenderActionTrash = "trash"
+	// BlockedSenderActionSpam moves new messages from the sender to Spam.
+	BlockedSenderActionSpam = "spam"
+)
+
+// BlockedSender is a sender whose new INBOX messages we move away during sync.
+type BlockedSender struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"-"`
+	Email     string    `json:"email"`
+	Action    string    `json:"action"` // BlockedSenderActionTrash or BlockedSenderActionSpam
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// BlockedSenderRequest represents the request payload for blocking a sender or changing the action.
+// An empty action means BlockedSenderActionTrash.
+type BlockedSenderRequest struct {
+	Email  string `json:"email"`
+	Action string `json:"action"`
+}
+
+// Filter is a rule that files new INBOX messages during sync, like "move newsletters to Newsletters".
+// A message matches if it matches all the conditions that are set. At least one condition and one action are set.
+type Filter struct {
+	ID     string `json:"id"`
+	UserID string `json:"-"`
+	Name   string `json:"name"`
+	// From matches messages whose From header contains it, To matches messages with a To or Cc address that contains
+	// it, and Subject matches messages whose subject contains it, all case-insensitively. Empty ones match all.
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	// HasAttachment matches messages with attachments if true, without them if false, and all messages if nil.
+	HasAttachment *bool `json:"has_attachment"`
+	MarkRead      bool  `json:"mark_read"`
+	// AddLabel is the label to add, or empty for none.
+	AddLabel string `json:"add_label"`
+	// MoveTo is the folder to move messages to, or empty to keep them in INBOX.
+	MoveTo string `json:"move_to"`
+	// Delete moves messages to Trash. It can't be set with MoveTo.
+	Delete    bool      `json:"delete"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FilterRequest represents the request payload for creating, replacing, or testing a filter.
+type FilterRequest struct {
+	Name          string `json:"name"`
+	From          string `json:"from"`
+	To            string `json:"to"`
+	Subject       string `json:"subject"`
+	HasAttachment *bool  `json:"has_attachment"`
+	MarkRead      bool   `json:"mark_read"`
+	AddLabel      string `json:"add_label"`
+	MoveTo        string `json:"move_to"`
+	Delete        bool   `json:"delete"`
+}
+
+// FilterMatch is a cached message that a filter matches, for testing filters before saving them.
+type FilterMatch struct {
+	MessageID string     `json:"message_id"`
+	ThreadID  string     `json:"thread_id"` // The stable thread ID
+	From      string     `json:"from"`
+	Subject   string     `json:"subject"`
+	SentAt    *time.Time `json:"sent_at"`
+}
+
+// FilterTestResponse lists the cached INBOX messages that a filter matches, the newest first.
+// Truncated is true if it matches more than the response has.
+type FilterTestResponse struct {
+	Matches   []FilterMatch `json:"matches"`
+	Truncated bool          `json:"truncated"`
+}
+
+// SieveScript is a Sieve script of the user on their ManageSieve server. The server runs the active one on delivery.
+type SieveScript struct {
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
+	// Content is only set when getting or saving a single script.
+	Content string `json:"content,omitempty"`
+}
+
+// SieveScriptRequest represents the request payload for saving a Sieve script.
+type SieveScriptRequest struct {
+	Content string `json:"content"`
+}
+
+// SieveFiltersSync is the result of syncing the filters with the user's Sieve script.
+type SieveFiltersSync struct {
+	// Script is the active script with the filters' block.
+	Script SieveScript `json:"script"`
+	// Imported is whether the block changed on the server, and its rules replaced the filters.
+	Imported bool `json:"imported"`
+}
+
+// SieveFilterBlock is where V-Mail last wrote the user's filters to their Sieve scripts, see the sieve package.
+type SieveFilterBlock struct {
+	UserID     string
+	ScriptName string
+	// Hash is the hash of the block that V-Mail wrote, see sieve.BlockHash.
+	Hash      string
+	UpdatedAt time.Time
+}
+
+// The ways an auto-responder sends its replies.
+const (
+	// VacationModeBackend replies during sync, see the vacation package.
+	VacationModeBackend = "backend"
+	// VacationModeSieve has the mail server reply on delivery, with the Sieve "vacation" extension.
+	VacationModeSieve = "sieve"
+)
+
+// VacationResponder is the user's auto-responder, which replies to new mail while they're away.
+// Each sender gets at most one reply in IntervalDays days.
+type VacationResponder struct {
+	UserID  string `json:"-"`
+	Enabled bool   `json:"enabled"`
+	// Subject is the subject of the replies. Empty means "Auto: " and the subject of the message.
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	// StartsAt and EndsAt limit when it replies. Nil means no limit on that side.
+	StartsAt     *time.Time `json:"starts_at"`
+	EndsAt       *time.Time `json:"ends_at"`
+	IntervalDays int        `json:"interval_days"`
+	// Mode is one of the VacationMode constants. The API sets it, see api.VacationHandler.
+	Mode      string    `json:"mode"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// VacationResponderRequest represents the request payload for saving the auto-responder.
+type VacationResponderRequest struct {
+	Enabled      bool       `json:"enabled"`
+	Subject      string     `json:"subject"`
+	Body         string     `json:"body"`
+	StartsAt     *time.Time `json:"starts_at"`
+	EndsAt       *time.Time `json:"ends_at"`
+	IntervalDays int        `json:"interval_days"`
+}
+
+// TrustedSender is a sender whose messages show remote images without asking.
+type TrustedSender struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"-"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TrustSenderRequest represents the optional request payload for trusting the sender of a thread.
+// An empty email means the sender of the first message.
+type TrustSenderRequest struct {
+	Email string `json:"email"`
+}
+
+// Device platforms. See Device.
+const (
+	// DevicePlatformWeb gets push notifications through Web Push.
+	DevicePlatformWeb = "web"
+	// DevicePlatformAndroid gets push notifications through FCM.
+	DevicePlatformAndroid = "android"
+	// DevicePlatformIOS gets push notifications through APNs.
+	DevicePlatformIOS = "ios"
+)
+
+// Device is a client device of the user, like a browser or a phone, that can get push notifications.
+type Device struct {
+	ID       string `json:"id"`
+	UserID   string `json:"-"`
+	Name     string `json:"name"`
+	Platform string `json:"platform"` // DevicePlatformWeb, DevicePlatformAndroid, or DevicePlatformIOS
+	// PushEndpoint is the Web Push endpoint URL, or the FCM or APNs token. Empty if the device doesn't get push.
+	// We never send it back, since anyone with it can send notifications to the device.
+	PushEndpoint string `json:"-"`
+	// PushP256dh and PushAuth are the keys for encrypting Web Push payloads. Only set for DevicePlatformWeb.
+	PushP256dh           []byte    `json:"-"`
+	PushAuth             []byte    `json:"-"`
+	HasPush              bool      `json:"has_push"`
+	NotificationsEnabled bool      `json:"notifications_enabled"`
+	ImportantOnly        bool      `json:"important_only"`
+	LastSeenAt           time.Time `json:"last_seen_at"`
+	CreatedAt            time.Time `json:"created_at"`
+	UpdatedAt            time.Time `json:"updated_at"`
+}
+
+// VAPIDPublicKeyResponse is the public key that browsers subscribe to Web Push with, as applicationServerKey.
+type VAPIDPublicKeyResponse struct {
+	PublicKey string `json:"public_key"`
+}
+
+// Notification rule actions. See NotificationRule.
+const (
+	// NotificationRuleNotify makes the matching messages notify. Once a user has one, only the matching messages do.
+	NotificationRuleNotify = "notify"
+	// NotificationRuleMute keeps the matching messages from notifying, even if a NotificationRuleNotify rule matches.
+	NotificationRuleMute = "mute"
+)
+
+// NotificationRule decides which new messages the user gets push notifications about, like "only from my boss",
+// or "nothing at night". Without NotificationRuleNotify rules, only new messages in INBOX notify.
+type NotificationRule struct {
+	ID     string `json:"id"`
+	UserID string `json:"-"`
+	Name   string `json:"name"`
+	Action string `json:"action"` // NotificationRuleNotify or NotificationRuleMute
+	// From and Subject match messages whose From header or subject contain them, and Folder matches messages in
+	// that folder, all case-insensitively. Empty ones match all messages.
+	From    string `json:"from"`
+	Subject string `json:"subject"`
+	Folder  string `json:"folder"`
+	// Hours limits the rule to a time of day. Nil means all day.
+	Hours     *NotificationRuleHours `json:"hours"`
+	CreatedAt time.Time              `json:"created_at"`
+	UpdatedAt time.Time              `json:"updated_at"`
+}
+
+// NotificationRuleHours is the time of day that a notification rule applies in, like 22:00 to 07:00 for quiet hours.
+// If Start is after End, it goes overnight.
+type NotificationRuleHours struct {
+	Start    string `json:"start"`     // Like "22:00"
+	End      string `json:"end"`       // Like "07:00"
+	TimeZone string `json:"time_zone"` // An IANA time zone, like "Europe/Berlin"
+}
+
+// NotificationRuleRequest represents the request payload for creating or replacing a notification rule.
+type NotificationRuleRequest struct {
+	Name    string                 `json:"name"`
+	Action  string                 `json:"action"`
+	From    string                 `json:"from"`
+	Subject string                 `json:"subject"`
+	Folder  string                 `json:"folder"`
+	Hours   *NotificationRuleHours `json:"hours"`
+}
+
+// APIKey is a key that clients without an Authelia session, like CLI tools, use in the Authorization header.
+type APIKey struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Prefix is the start of the key, so that users can tell their keys apart. We only store a hash of the key.
+	Prefix string `json:"prefix"`
+	// ExpiresAt is nil for keys that work until revoked.
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// APIKeyRequest is the request body of POST /api/v1/api-keys.
+type APIKeyRequest struct {
+	Name string `json:"name"`
+	// ExpiresInDays makes the key stop working after that many days. Omit it for a key that works until revoked.
+	ExpiresInDays *int `json:"expires_in_days"`
+}
+
+// CreatedAPIKey is the response of POST /api/v1/api-keys. It's the only time the key itself is in a response.
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// PushSubscription is a Web Push subscription, in the shape of the browser's PushSubscription.toJSON().
+type PushSubscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		// P256dh and Auth are base64url-encoded, with or without padding.
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// DeviceRequest represents the request payload for registering a device.
+// Web devices send a PushSubscription, Android and iOS devices send a PushToken. Both are optional.
+// Nil notification preferences mean the defaults: notifications on, for all threads.
+type DeviceRequest struct {
+	Name                 string            `json:"name"`
+	Platform             string            `json:"platform"`
+	PushSubscription     *PushSubscription `json:"push_subscription"`
+	PushToken            string            `json:"push_token"`
+	NotificationsEnabled *bool             `json:"notifications_enabled"`
+	ImportantOnly        *bool             `json:"important_only"`
+}
+
+// DeviceUpdateRequest represents the request payload for updating a device. Nil fields stay untouched.
+// Devices send a new PushSubscription or PushToken when theirs changes.
+type DeviceUpdateRequest struct {
+	Name                 *string           `json:"name"`
+	PushSubscription     *PushSubscription `json:"push_subscription"`
+	PushToken            *string           `json:"push_token"`
+	NotificationsEnabled *bool             `json:"notifications_enabled"`
+	ImportantOnly        *bool             `json:"important_only"`
+}
+
+// UserPreferences holds the user's UI preferences.
+// This table has a 1:1 relationship with the users table. It's separate from user_settings
+// so that UI tweaks never touch the code that handles credentials.
+type UserPreferences struct {
+	UserID                   string `json:"-"`
 	UndoSendDelaySeconds     int    `json:"undo_send_delay_seconds"`
 	PaginationThreadsPerPage int    `json:"pagination_threads_per_page"`
-	IMAPServerHostname       string `json:"imap_server_hostname"`
-	IMAPUsername             string `json:"imap_username"`
-	IMAPPasswordSet          bool   `json:"imap_password_set"`
-	SMTPServerHostname       string `json:"smtp_server_hostname"`
-	SMTPUsername             string `json:"smtp_username"`
-	SMTPPasswordSet          bool   `json:"smtp_password_set"`
+	// UI is a free-form bag for preferences that the backend doesn't need to understand.
+	// It's always a JSON object.
+	UI        map[string]json.RawMessage `json:"ui"`
+	CreatedAt time.Time                  `json:"-"`
+	UpdatedAt time.Time                  `json:"updated_at"`
+}
+
+// ErrorResponse is the response body for errors that the front end handles by their code,
+// for example, "too_many_requests".
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// ValidationErrorResponse is the response body for requests with invalid fields.
+// Fields maps each invalid field's JSON name to a human-readable error message.
+type ValidationErrorResponse struct {
+	Error  string            `json:"error"`
+	Fields map[string]string `json:"fields"`
 }
 
 // AuthStatusResponse represents the authentication and setup status of a user.
 type AuthStatusResponse struct {
 	IsSetupComplete bool `json:"isSetupComplete"`
 }
+
+// WSTokenResponse is the response of POST /api/v1/ws/token.
+type WSTokenResponse struct {
+	// Token goes in the token query parameter of /api/v1/ws. It works once, until ExpiresAt.
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// AccountDeletionTokenResponse is the response of POST /api/v1/account/deletion-token.
+type AccountDeletionTokenResponse struct {
+	// Token goes in the confirmation_token field of DELETE /api/v1/account. It works until ExpiresAt.
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// AccountDeletionRequest is the request body of DELETE /api/v1/account.
+type AccountDeletionRequest struct {
+	ConfirmationToken string `json:"confirmation_token"`
+}
+
+// Contact is someone the user has mail with, for autocomplete in the compose form.
+type Contact struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+	// MessageCount is how many cached messages have the address in From, To, or Cc.
+	MessageCount int `json:"message_count"`
+	// SentCount is how many of those the user sent to the address.
+	SentCount  int        `json:"sent_count"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+}
+
+// ContactsResponse is the response body of the contact autocomplete.
+type ContactsResponse struct {
+	Contacts []*Contact `json:"contacts"`
+}
@@ -0,0 +1,70 @@
+// Package netguard keeps HTTP clients that connect to URLs from users or senders away from our own network, such as
+// localhost, the database, or a cloud provider's metadata service.
+package netguard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// dialTimeout limits how long connecting to a host takes.
+const dialTimeout = 5 * time.Second
+
+// ErrNotPublic is returned when a host resolves to a loopback, private, or similar address.
+var ErrNotPublic = errors.New("refusing to connect to a non-public address")
+
+// reservedNetworks are ranges that net.IP.IsGlobalUnicast and net.IP.IsPrivate count as public, but that don't reach
+// the internet: "this network" (RFC 791), which Linux routes to localhost, and carrier-grade NAT (RFC 6598), which
+// some clouds use inside their networks.
+var reservedNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"),
+}
+
+// IsPublicIP tells whether ip is a global unicast address that isn't private or reserved.
+func IsPublicIP(ip net.IP) bool {
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewTransport returns an HTTP transport that only connects to public addresses, and fails with ErrNotPublic for
+// others. It checks the address it connects to, after DNS, so hosts can't get around it by resolving to a private
+// address. It doesn't use proxies from the environment, since they would connect for us.
+func NewTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: dialTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip) {
+				return fmt.Errorf("%w: %s", ErrNotPublic, host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
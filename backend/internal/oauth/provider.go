@@ -0,0 +1,172 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/config"
+)
+
+// tokenRequestTimeout limits how long we wait for the provider's token endpoint.
+const tokenRequestTimeout = 15 * time.Second
+
+// ErrTokenRejected is returned when the provider rejects a code or refresh token,
+// for example, because the user revoked access. Retrying doesn't help, the user needs to connect again.
+var ErrTokenRejected = errors.New("the OAuth provider rejected the token")
+
+// Provider is an OAuth provider that users can connect their mail accounts with.
+type Provider struct {
+	Name         string
+	AuthURL      string
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	// Scope is what we ask for: IMAP and SMTP access, and a refresh token.
+	Scope string
+	// IMAPServerHostname and SMTPServerHostname are the servers for new users who connect with this provider.
+	IMAPServerHostname string
+	SMTPServerHostname string
+
+	httpClient *http.Client
+}
+
+// Token is an access token with its refresh token.
+type Token struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+// NewProviders returns the providers that have a client ID in the config, by name.
+func NewProviders(cfg *config.Config) map[string]*Provider {
+	providers := make(map[string]*Provider)
+	httpClient := &http.Client{Timeout: tokenRequestTimeout}
+
+	if cfg.OAuthGoogleClientID != "" {
+		providers["google"] = &Provider{
+			Name:               "google",
+			AuthURL:            "https://accounts.google.com/o/oauth2/v2/auth",
+			TokenURL:           "https://oauth2.googleapis.com/token",
+			ClientID:           cfg.OAuthGoogleClientID,
+			ClientSecret:       cfg.OAuthGoogleClientSecret,
+			Scope:              "https://mail.google.com/",
+			IMAPServerHostname: "imap.gmail.com:993",
+			SMTPServerHostname: "smtp.gmail.com:465",
+			httpClient:         httpClient,
+		}
+	}
+
+	if cfg.OAuthMicrosoftClientID != "" {
+		providers["microsoft"] = &Provider{
+			Name:               "microsoft",
+			AuthURL:            "https://login.microsoftonline.com/common/oauth2/v2.0/authorize",
+			TokenURL:           "https://login.microsoftonline.com/common/oauth2/v2.0/token",
+			ClientID:           cfg.OAuthMicrosoftClientID,
+			ClientSecret:       cfg.OAuthMicrosoftClientSecret,
+			Scope:              "offline_access https://outlook.office.com/IMAP.AccessAsUser.All https://outlook.office.com/SMTP.Send",
+			IMAPServerHostname: "outlook.office365.com:993",
+			SMTPServerHostname: "smtp.office365.com:587",
+			httpClient:         httpClient,
+		}
+	}
+
+	return providers
+}
+
+// Exchange trades the authorization code from the provider's redirect for tokens.
+// codeVerifier is the PKCE verifier, if the front end used one.
+func (p *Provider) Exchange(ctx context.Context, code, redirectURI, codeVerifier string) (*Token, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {redirectURI},
+	}
+	if codeVerifier != "" {
+		form.Set("code_verifier", codeVerifier)
+	}
+
+	token, err := p.requestToken(ctx, form)
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken == "" {
+		// Google only gives one with access_type=offline
+		return nil, fmt.Errorf("%w: no refresh token in the response", ErrTokenRejected)
+	}
+	return token, nil
+}
+
+// Refresh gets a new access token with the refresh token.
+// Some providers don't rotate refresh tokens, so the old one stays if there's no new one.
+func (p *Provider) Refresh(ctx context.Context, refreshToken string) (*Token, error) {
+	token, err := p.requestToken(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = refreshToken
+	}
+	return token, nil
+}
+
+// tokenResponse is the JSON response of token endpoints, see RFC 6749.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// requestToken posts the form to the token endpoint with the client credentials, and parses the response.
+func (p *Provider) requestToken(ctx context.Context, form url.Values) (*Token, error) {
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	httpClient := p.httpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request token from %s: %w", p.Name, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	var body tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode token response from %s (status %d): %w", p.Name, resp.StatusCode, err)
+	}
+
+	// 400 and 401 mean the code or the refresh token is bad. Anything else might go away on retry.
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("%w: %s: %s", ErrTokenRejected, body.Error, body.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || body.AccessToken == "" {
+		return nil, fmt.Errorf("token request to %s failed with status %d: %s", p.Name, resp.StatusCode, body.Error)
+	}
+
+	return &Token{
+		AccessToken:  body.AccessToken,
+		RefreshToken: body.RefreshToken,
+		ExpiresAt:    time.Now().Add(time.Duration(body.ExpiresIn) * time.Second),
+	}, nil
+}
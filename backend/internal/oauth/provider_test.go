@@ -0,0 +1,96 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/config"
+)
+
+// newTestProvider returns a provider whose token endpoint is the given handler.
+func newTestProvider(t *testing.T, handler http.HandlerFunc) *Provider {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return &Provider{Name: "test", TokenURL: server.URL, ClientID: "client-id", ClientSecret: "client-secret"}
+}
+
+func TestNewProviders(t *testing.T) {
+	providers := NewProviders(&config.Config{OAuthGoogleClientID: "google-id"})
+	if len(providers) != 1 || providers["google"] == nil {
+		t.Fatalf("Expected only Google, got %v", providers)
+	}
+	if providers["google"].ClientID != "google-id" {
+		t.Errorf("Expected client ID google-id, got %s", providers["google"].ClientID)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("Failed to parse form: %v", err)
+		}
+		if r.Form.Get("grant_type") != "authorization_code" || r.Form.Get("code") != "the-code" ||
+			r.Form.Get("code_verifier") != "the-verifier" || r.Form.Get("client_secret") != "client-secret" {
+			t.Errorf("Unexpected form: %v", r.Form)
+		}
+		_, _ = w.Write([]byte(`{"access_token": "access", "refresh_token": "refresh", "expires_in": 3600}`))
+	})
+
+	token, err := provider.Exchange(context.Background(), "the-code", "https://vmail.example.com/oauth", "the-verifier")
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if token.AccessToken != "access" || token.RefreshToken != "refresh" {
+		t.Errorf("Unexpected token: %+v", token)
+	}
+	if time.Until(token.ExpiresAt) < 59*time.Minute {
+		t.Errorf("Expected the token to expire in an hour, got %v", token.ExpiresAt)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	t.Run("keeps the refresh token if there's no new one", func(t *testing.T) {
+		provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.FormValue("refresh_token") != "old-refresh" {
+				t.Errorf("Expected the old refresh token, got %q", r.FormValue("refresh_token"))
+			}
+			_, _ = w.Write([]byte(`{"access_token": "new-access", "expires_in": 3600}`))
+		})
+
+		token, err := provider.Refresh(context.Background(), "old-refresh")
+		if err != nil {
+			t.Fatalf("Refresh failed: %v", err)
+		}
+		if token.AccessToken != "new-access" || token.RefreshToken != "old-refresh" {
+			t.Errorf("Unexpected token: %+v", token)
+		}
+	})
+
+	t.Run("reports revoked tokens", func(t *testing.T) {
+		provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "invalid_grant", "error_description": "Token has been revoked."}`))
+		})
+
+		_, err := provider.Refresh(context.Background(), "revoked")
+		if !errors.Is(err, ErrTokenRejected) {
+			t.Errorf("Expected ErrTokenRejected, got %v", err)
+		}
+	})
+
+	t.Run("doesn't treat server errors as rejections", func(t *testing.T) {
+		provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"error": "temporarily_unavailable"}`))
+		})
+
+		_, err := provider.Refresh(context.Background(), "refresh")
+		if err == nil || errors.Is(err, ErrTokenRejected) {
+			t.Errorf("Expected a retryable error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/crypto"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+const (
+	// refreshInterval is how often the refresher looks for tokens that expire soon.
+	refreshInterval = time.Minute
+	// refreshMargin is how long before they expire we refresh tokens.
+	// It's more than refreshInterval, so tokens never expire between two rounds.
+	refreshMargin = 5 * time.Minute
+)
+
+// Refresher keeps the access tokens of OAuth users fresh.
+type Refresher struct {
+	pool      *pgxpool.Pool
+	encryptor *crypto.Encryptor
+	providers map[string]*Provider
+}
+
+// NewRefresher creates a new token refresher for the given providers.
+func NewRefresher(pool *pgxpool.Pool, encryptor *crypto.Encryptor, providers map[string]*Provider) *Refresher {
+	return &Refresher{
+		pool:      pool,
+		encryptor: encryptor,
+		providers: providers,
+	}
+}
+
+// Run refreshes tokens that are about to expire until the context is cancelled. Run it in a goroutine.
+// It starts with a round right away, in case tokens expired while the server was down.
+func (r *Refresher) Run(ctx context.Context) {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		r.RefreshExpiring(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RefreshExpiring refreshes the tokens of all users whose tokens expire within refreshMargin.
+// Failures are logged, and retried in the next round.
+func (r *Refresher) RefreshExpiring(ctx context.Context) {
+	userIDs, err := db.GetUserIDsWithExpiringOAuthTokens(ctx, r.pool, time.Now().Add(refreshMargin))
+	if err != nil {
+		slog.ErrorContext(ctx, "OAuth: Failed to get users with expiring tokens", "error", err)
+		return
+	}
+
+	for _, userID := range userIDs {
+		if err := r.RefreshUser(ctx, userID); err != nil {
+			slog.WarnContext(ctx, "OAuth: Failed to refresh token", "user_id", userID, "error", err)
+		}
+	}
+}
+
+// RefreshUser gets a new access token for the user, and saves it.
+func (r *Refresher) RefreshUser(ctx context.Context, userID string) error {
+	settings, err := db.GetUserSettings(ctx, r.pool, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user settings: %w", err)
+	}
+
+	provider, ok := r.providers[settings.OAuthProvider]
+	if !ok {
+		return fmt.Errorf("provider %q isn't configured", settings.OAuthProvider)
+	}
+
+	refreshToken, err := r.encryptor.Decrypt(settings.EncryptedOAuthRefreshToken)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt refresh token: %w", err)
+	}
+
+	token, err := provider.Refresh(ctx, refreshToken)
+	if err != nil {
+		return err
+	}
+
+	if err := ApplyToken(r.encryptor, settings, token); err != nil {
+		return err
+	}
+	return db.SaveOAuthTokens(ctx, r.pool, settings)
+}
+
+// ApplyToken encrypts the token into the settings. The access token goes in place of the IMAP password,
+// and in place of the SMTP password if that's a token too, or empty.
+// Users can keep an app password for SMTP, for example, if their provider only allows OAuth for IMAP.
+func ApplyToken(encryptor *crypto.Encryptor, settings *models.UserSettings, token *Token) error {
+	if token == nil || token.AccessToken == "" {
+		return errors.New("no access token")
+	}
+
+	usesTokenForSMTP := len(settings.EncryptedSMTPPassword) == 0
+	if !usesTokenForSMTP {
+		smtpPassword, err := encryptor.Decrypt(settings.EncryptedSMTPPassword)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt SMTP password: %w", err)
+		}
+		_, usesTokenForSMTP = TokenFromPassword(smtpPassword)
+	}
+
+	encryptedPassword, err := encryptor.Encrypt(PasswordFromToken(token.AccessToken))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt access token: %w", err)
+	}
+	encryptedRefreshToken, err := encryptor.Encrypt(token.RefreshToken)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt refresh token: %w", err)
+	}
+
+	settings.EncryptedIMAPPassword = encryptedPassword
+	if usesTokenForSMTP {
+		settings.EncryptedSMTPPassword = encryptedPassword
+	}
+	settings.EncryptedOAuthRefreshToken = encryptedRefreshToken
+	expiresAt := token.ExpiresAt
+	settings.OAuthTokenExpiresAt = &expiresAt
+	return nil
+}
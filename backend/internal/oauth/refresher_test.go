@@ -0,0 +1,130 @@
+package oauth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/crypto"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestRefresher(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	encryptor, err := crypto.NewEncryptor(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatalf("NewEncryptor failed: %v", err)
+	}
+
+	provider := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") == "revoked" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "invalid_grant"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token": "new-access", "refresh_token": "new-refresh", "expires_in": 3600}`))
+	})
+	refresher := NewRefresher(pool, encryptor, map[string]*Provider{"test": provider})
+
+	// saveSettings saves OAuth settings that expire in expiresIn, with the SMTP password if it's not empty.
+	saveSettings := func(t *testing.T, email, refreshToken, smtpPassword string, expiresIn time.Duration) string {
+		t.Helper()
+		userID, err := db.GetOrCreateUser(ctx, pool, email)
+		if err != nil {
+			t.Fatalf("GetOrCreateUser failed: %v", err)
+		}
+		settings := &models.UserSettings{
+			UserID:             userID,
+			IMAPServerHostname: "imap.example.com",
+			IMAPUsername:       email,
+			SMTPServerHostname: "smtp.example.com",
+			SMTPUsername:       email,
+			OAuthProvider:      "test",
+		}
+		if smtpPassword != "" {
+			settings.EncryptedSMTPPassword = mustEncrypt(t, encryptor, smtpPassword)
+		}
+		if err := ApplyToken(encryptor, settings, &Token{AccessToken: "old-access", RefreshToken: refreshToken, ExpiresAt: time.Now().Add(expiresIn)}); err != nil {
+			t.Fatalf("ApplyToken failed: %v", err)
+		}
+		if err := db.SaveUserSettings(ctx, pool, settings); err != nil {
+			t.Fatalf("SaveUserSettings failed: %v", err)
+		}
+		return userID
+	}
+
+	expiringID := saveSettings(t, "refresher-expiring@example.com", "old-refresh", "", time.Minute)
+	appPasswordID := saveSettings(t, "refresher-app-password@example.com", "old-refresh", "app-password", time.Minute)
+	freshID := saveSettings(t, "refresher-fresh@example.com", "old-refresh", "", time.Hour)
+
+	refresher.RefreshExpiring(ctx)
+
+	t.Run("re-encrypts the new tokens of expiring users", func(t *testing.T) {
+		settings := getSettings(t, pool, expiringID)
+		assertDecrypts(t, encryptor, settings.EncryptedIMAPPassword, PasswordFromToken("new-access"))
+		assertDecrypts(t, encryptor, settings.EncryptedSMTPPassword, PasswordFromToken("new-access"))
+		assertDecrypts(t, encryptor, settings.EncryptedOAuthRefreshToken, "new-refresh")
+		if settings.OAuthTokenExpiresAt == nil || time.Until(*settings.OAuthTokenExpiresAt) < 59*time.Minute {
+			t.Errorf("Expected the token to expire in an hour, got %v", settings.OAuthTokenExpiresAt)
+		}
+	})
+
+	t.Run("keeps SMTP app passwords", func(t *testing.T) {
+		settings := getSettings(t, pool, appPasswordID)
+		assertDecrypts(t, encryptor, settings.EncryptedIMAPPassword, PasswordFromToken("new-access"))
+		assertDecrypts(t, encryptor, settings.EncryptedSMTPPassword, "app-password")
+	})
+
+	t.Run("leaves tokens that don't expire soon", func(t *testing.T) {
+		settings := getSettings(t, pool, freshID)
+		assertDecrypts(t, encryptor, settings.EncryptedIMAPPassword, PasswordFromToken("old-access"))
+		assertDecrypts(t, encryptor, settings.EncryptedOAuthRefreshToken, "old-refresh")
+	})
+
+	t.Run("reports revoked refresh tokens and keeps the old ones", func(t *testing.T) {
+		revokedID := saveSettings(t, "refresher-revoked@example.com", "revoked", "", time.Minute)
+		if err := refresher.RefreshUser(ctx, revokedID); !errors.Is(err, ErrTokenRejected) {
+			t.Errorf("Expected ErrTokenRejected, got %v", err)
+		}
+		settings := getSettings(t, pool, revokedID)
+		assertDecrypts(t, encryptor, settings.EncryptedOAuthRefreshToken, "revoked")
+	})
+}
+
+func mustEncrypt(t *testing.T, encryptor *crypto.Encryptor, plaintext string) []byte {
+	t.Helper()
+	ciphertext, err := encryptor.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	return ciphertext
+}
+
+func getSettings(t *testing.T, pool *pgxpool.Pool, userID string) *models.UserSettings {
+	t.Helper()
+	settings, err := db.GetUserSettings(context.Background(), pool, userID)
+	if err != nil {
+		t.Fatalf("GetUserSettings failed: %v", err)
+	}
+	return settings
+}
+
+func assertDecrypts(t *testing.T, encryptor *crypto.Encryptor, ciphertext []byte, want string) {
+	t.Helper()
+	got, err := encryptor.Decrypt(ciphertext)
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
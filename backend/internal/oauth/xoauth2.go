@@ -0,0 +1,56 @@
+package oauth
+
+import (
+	"strings"
+
+	"github.com/emersion/go-sasl"
+)
+
+// tokenPasswordPrefix marks passwords that are OAuth access tokens.
+// IMAP and SMTP passwords can't contain NUL bytes, so no real password starts with it.
+const tokenPasswordPrefix = "\x00xoauth2\x00"
+
+// PasswordFromToken wraps an access token so that it can take the place of a password.
+// IMAP and SMTP logins then use XOAUTH2 with the token instead of a password login.
+func PasswordFromToken(accessToken string) string {
+	return tokenPasswordPrefix + accessToken
+}
+
+// TokenFromPassword returns the access token in a password from PasswordFromToken,
+// and false if the password is a real password.
+func TokenFromPassword(password string) (string, bool) {
+	return strings.CutPrefix(password, tokenPasswordPrefix)
+}
+
+// xoauth2Client implements the XOAUTH2 SASL mechanism of Google and Microsoft.
+// See https://developers.google.com/gmail/imap/xoauth2-protocol.
+type xoauth2Client struct {
+	username    string
+	accessToken string
+}
+
+// NewXOAUTH2Client returns a SASL client that logs in as username with the access token.
+func NewXOAUTH2Client(username, accessToken string) sasl.Client {
+	return &xoauth2Client{username: username, accessToken: accessToken}
+}
+
+// Start returns the initial response with the username and the token.
+func (c *xoauth2Client) Start() (string, []byte, error) {
+	ir := "user=" + c.username + "\x01auth=Bearer " + c.accessToken + "\x01\x01"
+	return "XOAUTH2", []byte(ir), nil
+}
+
+// Next answers the server's challenge, which is only sent on failure and holds a JSON error.
+// The protocol wants an empty response, after which the server fails the login with the actual error.
+func (c *xoauth2Client) Next([]byte) ([]byte, error) {
+	return []byte{}, nil
+}
+
+// AuthClient returns the SASL client for logging in with the password: XOAUTH2 if it's an access token,
+// and PLAIN otherwise.
+func AuthClient(username, password string) sasl.Client {
+	if token, ok := TokenFromPassword(password); ok {
+		return NewXOAUTH2Client(username, token)
+	}
+	return sasl.NewPlainClient("", username, password)
+}
@@ -0,0 +1,43 @@
+package oauth
+
+import (
+	"testing"
+)
+
+func TestTokenFromPassword(t *testing.T) {
+	t.Run("unwraps tokens", func(t *testing.T) {
+		token, ok := TokenFromPassword(PasswordFromToken("ya29.token"))
+		if !ok || token != "ya29.token" {
+			t.Errorf("Expected ya29.token, got %q (ok: %v)", token, ok)
+		}
+	})
+
+	t.Run("leaves real passwords alone", func(t *testing.T) {
+		if _, ok := TokenFromPassword("xoauth2 hunter2"); ok {
+			t.Error("Expected a real password not to be a token")
+		}
+	})
+}
+
+func TestXOAUTH2Client(t *testing.T) {
+	mech, ir, err := NewXOAUTH2Client("me@example.com", "ya29.token").Start()
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if mech != "XOAUTH2" {
+		t.Errorf("Expected mechanism XOAUTH2, got %s", mech)
+	}
+	expected := "user=me@example.com\x01auth=Bearer ya29.token\x01\x01"
+	if string(ir) != expected {
+		t.Errorf("Expected initial response %q, got %q", expected, ir)
+	}
+}
+
+func TestAuthClient(t *testing.T) {
+	if mech, _, _ := AuthClient("me@example.com", PasswordFromToken("ya29.token")).Start(); mech != "XOAUTH2" {
+		t.Errorf("Expected XOAUTH2 for a token, got %s", mech)
+	}
+	if mech, _, _ := AuthClient("me@example.com", "hunter2").Start(); mech != "PLAIN" {
+		t.Errorf("Expected PLAIN for a password, got %s", mech)
+	}
+}
@@ -0,0 +1,198 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/logging"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/smtp"
+	ws "github.com/vdavid/vmail/backend/internal/websocket"
+)
+
+const (
+	// pollInterval is how often the dispatcher looks for due messages.
+	// It's how late a message can be sent after its undo window ends.
+	pollInterval = time.Second
+	// maxSendAttempts is how many times we try to send a message before giving up.
+	maxSendAttempts = 5
+	// retryBaseDelay is how long we wait after the first failed attempt. It doubles after each attempt.
+	retryBaseDelay = 30 * time.Second
+	// claimTimeout is how long sending a message may take. If the server stops while sending, another dispatcher
+	// tries again after this, so the message may go out twice, but it doesn't get lost.
+	claimTimeout = 10 * time.Minute
+)
+
+// SentFolderAppender saves copies of sent messages. imap.IMAPService implements it.
+type SentFolderAppender interface {
+	AppendToSent(ctx context.Context, userID string, raw []byte, sentAt time.Time) error
+}
+
+// Queue adds a message to the outbox, to be sent at sendAt. Returns the ID that cancels it.
+// loginEmail is the sender address if the SMTP username isn't an email address.
+func Queue(ctx context.Context, pool *pgxpool.Pool, userID, loginEmail string, email *models.OutgoingEmail, sendAt time.Time) (string, error) {
+	payload, err := json.Marshal(models.OutboxEmail{LoginEmail: loginEmail, Email: *email})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode outbox email: %w", err)
+	}
+	return db.QueueAction(ctx, pool, userID, models.ActionTypeSendEmail, payload, sendAt)
+}
+
+// Cancel removes a message from the outbox. Returns false if there's no such message,
+// for example, because it's already sent.
+func Cancel(ctx context.Context, pool *pgxpool.Pool, userID, id string) (bool, error) {
+	return db.CancelQueuedAction(ctx, pool, userID, models.ActionTypeSendEmail, id)
+}
+
+// List returns the user's messages in the outbox, the soonest first. This includes the ones that wait for the undo
+// send delay, and the ones scheduled for later.
+func List(ctx context.Context, pool *pgxpool.Pool, userID string) ([]models.ScheduledEmail, error) {
+	actions, err := db.GetQueuedActions(ctx, pool, userID, models.ActionTypeSendEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduled := make([]models.ScheduledEmail, 0, len(actions))
+	for _, action := range actions {
+		var payload models.OutboxEmail
+		if err := json.Unmarshal(action.Payload, &payload); err != nil {
+			return nil, fmt.Errorf("failed to decode outbox email %s: %w", action.ID, err)
+		}
+		scheduled = append(scheduled, models.ScheduledEmail{
+			ID:              action.ID,
+			SendAt:          action.ProcessAt,
+			CreatedAt:       action.CreatedAt,
+			To:              nonNil(payload.Email.To),
+			Cc:              nonNil(payload.Email.Cc),
+			Bcc:             nonNil(payload.Email.Bcc),
+			Subject:         payload.Email.Subject,
+			AttachmentCount: len(payload.Email.Attachments),
+			Attempts:        action.Attempts,
+			LastError:       action.LastError,
+		})
+	}
+	return scheduled, nil
+}
+
+// nonNil returns an empty slice for nil, so that it's [] in JSON.
+func nonNil(addresses []string) []string {
+	if addresses == nil {
+		return []string{}
+	}
+	return addresses
+}
+
+// Dispatcher sends messages from the outbox once they're due.
+type Dispatcher struct {
+	pool        *pgxpool.Pool
+	smtpService *smtp.Service
+	sentFolder  SentFolderAppender
+	hub         *ws.Hub
+}
+
+// NewDispatcher creates a new outbox dispatcher.
+// The hub is used to tell the user's clients whether their messages went out.
+func NewDispatcher(pool *pgxpool.Pool, smtpService *smtp.Service, sentFolder SentFolderAppender, hub *ws.Hub) *Dispatcher {
+	return &Dispatcher{
+		pool:        pool,
+		smtpService: smtpService,
+		sentFolder:  sentFolder,
+		hub:         hub,
+	}
+}
+
+// Run dispatches due messages until the context is cancelled. Run it in a goroutine.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.DispatchDue(ctx)
+		}
+	}
+}
+
+// DispatchDue sends all messages that are due, one by one. Returns how many it handled,
+// including the ones that failed and were rescheduled.
+func (d *Dispatcher) DispatchDue(ctx context.Context) int {
+	count := 0
+	for {
+		handled, err := d.dispatchNext(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "Outbox: Failed to dispatch message", "error", err)
+			return count
+		}
+		if !handled {
+			return count
+		}
+		count++
+	}
+}
+
+// dispatchNext sends the message that's been due for the longest. Returns false if there was none.
+// It claims the message before sending it, so cancelling it fails from then on, and the SMTP server doesn't keep a
+// transaction open.
+func (d *Dispatcher) dispatchNext(ctx context.Context) (bool, error) {
+	action, err := db.ClaimNextDueAction(ctx, d.pool, models.ActionTypeSendEmail, time.Now().Add(claimTimeout))
+	if err != nil || action == nil {
+		return false, err
+	}
+	ctx = logging.WithUserID(ctx, action.UserID)
+
+	msg, sendErr := d.send(ctx, action)
+	gaveUp := false
+	if sendErr == nil {
+		err = db.DeleteAction(ctx, d.pool, action.ID)
+	} else if action.Attempts+1 >= maxSendAttempts {
+		slog.ErrorContext(ctx, "Outbox: Giving up on message", "action_id", action.ID, "attempts", action.Attempts+1, "error", sendErr)
+		gaveUp = true
+		err = db.DeleteAction(ctx, d.pool, action.ID)
+	} else {
+		slog.WarnContext(ctx, "Outbox: Failed to send message, retrying later", "action_id", action.ID, "error", sendErr)
+		err = db.RescheduleFailedAction(ctx, d.pool, action.ID, time.Now().Add(retryDelay(action.Attempts)), sendErr.Error())
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to finish message %s: %w", action.ID, err)
+	}
+
+	// Finishing can fail after the SMTP server took the message, so we save it and tell the user either way
+	switch {
+	case sendErr == nil:
+		d.saveToSent(ctx, action.UserID, msg)
+		d.hub.Publish(action.UserID, ws.Event{Type: ws.EventMessageSent, ID: action.ID, MessageID: msg.MessageID})
+	case gaveUp:
+		d.hub.Publish(action.UserID, ws.Event{Type: ws.EventSendFailed, ID: action.ID, Error: sendErr.Error()})
+	}
+	return true, err
+}
+
+// send sends the message of a send_email action.
+func (d *Dispatcher) send(ctx context.Context, action *models.QueuedAction) (*smtp.BuiltMessage, error) {
+	var payload models.OutboxEmail
+	if err := json.Unmarshal(action.Payload, &payload); err != nil {
+		return nil, fmt.Errorf("failed to decode outbox email: %w", err)
+	}
+	return d.smtpService.SendEmail(ctx, action.UserID, payload.LoginEmail, &payload.Email)
+}
+
+// saveToSent appends the sent message to the Sent folder.
+// The message is already sent, so we only log failures.
+func (d *Dispatcher) saveToSent(ctx context.Context, userID string, msg *smtp.BuiltMessage) {
+	if err := d.sentFolder.AppendToSent(ctx, userID, msg.Raw, msg.Date); err != nil {
+		slog.WarnContext(ctx, "Outbox: Failed to save sent message to the Sent folder", "message_id", msg.MessageID, "error", err)
+	}
+}
+
+// retryDelay returns how long to wait after a message failed for the (attempts+1)th time.
+func retryDelay(attempts int) time.Duration {
+	return retryBaseDelay << attempts
+}
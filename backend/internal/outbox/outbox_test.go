@@ -0,0 +1,153 @@
+package outbox
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/crypto"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/smtp"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+type mockSentFolder struct {
+	appended [][]byte
+}
+
+func (m *mockSentFolder) AppendToSent(_ context.Context, _ string, raw []byte, _ time.Time) error {
+	m.appended = append(m.appended, raw)
+	return nil
+}
+
+func TestDispatcher_DispatchDue(t *testing.T) {
+	t.Setenv("VMAIL_TEST_MODE", "true")
+
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	encryptor := getTestEncryptor(t)
+	smtpServer := testutil.NewTestSMTPServer(t)
+	defer smtpServer.Close()
+
+	ctx := context.Background()
+	userID := setupTestUser(t, pool, encryptor, "outbox-test@example.com", smtpServer.Address)
+	email := &models.OutgoingEmail{To: []string{"alice@example.com"}, Subject: "Hello"}
+
+	t.Run("sends due messages and keeps the others", func(t *testing.T) {
+		smtpServer.ClearMessages()
+		sentFolder := &mockSentFolder{}
+		dispatcher := NewDispatcher(pool, smtp.NewService(pool, encryptor), sentFolder, nil)
+
+		dueID, err := Queue(ctx, pool, userID, "outbox-test@example.com", email, time.Now().Add(-time.Second))
+		if err != nil {
+			t.Fatalf("Failed to queue message: %v", err)
+		}
+		laterID, err := Queue(ctx, pool, userID, "outbox-test@example.com", email, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("Failed to queue message: %v", err)
+		}
+
+		if count := dispatcher.DispatchDue(ctx); count != 1 {
+			t.Errorf("Expected 1 dispatched message, got %d", count)
+		}
+		if messages := smtpServer.GetMessages(); len(messages) != 1 {
+			t.Errorf("Expected 1 sent message, got %d", len(messages))
+		}
+		if len(sentFolder.appended) != 1 {
+			t.Errorf("Expected 1 message saved to Sent, got %d", len(sentFolder.appended))
+		}
+
+		// The sent message is gone, the later one can still be cancelled
+		if cancelled, _ := Cancel(ctx, pool, userID, dueID); cancelled {
+			t.Error("Expected the sent message to be gone from the outbox")
+		}
+		if cancelled, _ := Cancel(ctx, pool, userID, laterID); !cancelled {
+			t.Error("Expected the later message to still be in the outbox")
+		}
+	})
+
+	t.Run("reschedules messages that fail", func(t *testing.T) {
+		otherUserID := setupTestUser(t, pool, encryptor, "outbox-unreachable@example.com", "127.0.0.1:1")
+		dispatcher := NewDispatcher(pool, smtp.NewService(pool, encryptor), &mockSentFolder{}, nil)
+
+		id, err := Queue(ctx, pool, otherUserID, "outbox-unreachable@example.com", email, time.Now().Add(-time.Second))
+		if err != nil {
+			t.Fatalf("Failed to queue message: %v", err)
+		}
+
+		if count := dispatcher.DispatchDue(ctx); count != 1 {
+			t.Errorf("Expected 1 dispatched message, got %d", count)
+		}
+
+		var attempts int
+		var processAt time.Time
+		err = pool.QueryRow(ctx, `SELECT attempts, process_at FROM action_queue WHERE id = $1`, id).Scan(&attempts, &processAt)
+		if err != nil {
+			t.Fatalf("Expected the message to stay in the outbox: %v", err)
+		}
+		if attempts != 1 {
+			t.Errorf("Expected 1 attempt, got %d", attempts)
+		}
+		if !processAt.After(time.Now()) {
+			t.Errorf("Expected a retry in the future, got %v", processAt)
+		}
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempts, want := range []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute} {
+		t.Run(fmt.Sprintf("%d attempts", attempts), func(t *testing.T) {
+			if got := retryDelay(attempts); got != want {
+				t.Errorf("retryDelay(%d) = %v, want %v", attempts, got, want)
+			}
+		})
+	}
+}
+
+func setupTestUser(t *testing.T, pool *pgxpool.Pool, encryptor *crypto.Encryptor, email, smtpServer string) string {
+	t.Helper()
+	ctx := context.Background()
+
+	userID, err := db.GetOrCreateUser(ctx, pool, email)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	encryptedPassword, err := encryptor.Encrypt("smtp_pass")
+	if err != nil {
+		t.Fatalf("Failed to encrypt password: %v", err)
+	}
+	settings := &models.UserSettings{
+		UserID:                userID,
+		IMAPServerHostname:    "imap.test.com",
+		IMAPUsername:          "user",
+		EncryptedIMAPPassword: encryptedPassword,
+		SMTPServerHostname:    smtpServer,
+		SMTPUsername:          "user",
+		EncryptedSMTPPassword: encryptedPassword,
+	}
+	if err := db.SaveUserSettings(ctx, pool, settings); err != nil {
+		t.Fatalf("Failed to save settings: %v", err)
+	}
+	return userID
+}
+
+func getTestEncryptor(t *testing.T) *crypto.Encryptor {
+	t.Helper()
+
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	encryptor, err := crypto.NewEncryptor(base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		t.Fatalf("Failed to create encryptor: %v", err)
+	}
+	return encryptor
+}
@@ -0,0 +1,193 @@
+// Package pagination parses pagination parameters and builds pagination metadata
+// so that every list endpoint behaves the same way.
+package pagination
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+const (
+	// DefaultLimit is the page size used when neither the request nor the user's settings specify one.
+	DefaultLimit = 100
+
+	// MaxLimit is the largest page size we serve. Larger limits are clamped to this.
+	MaxLimit = 500
+
+	// MaxPage is the last page number we serve. Larger pages are clamped to this, so that offsets can't overflow.
+	// No one has this many pages, so such a page is empty anyway.
+	MaxPage = 1_000_000
+)
+
+// ErrInvalidCursor is returned when the cursor query parameter can't be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursorPrefix versions the cursor format so we can change it later without misreading old cursors.
+const cursorPrefix = "o1:"
+
+// keysetCursorPrefix marks cursors that also point after the last item of the previous page.
+// Lists that support it use the position instead of the offset, since OFFSET gets slow deep into a list.
+const keysetCursorPrefix = "k1:"
+
+// Params holds the pagination parameters of a list request.
+type Params struct {
+	// Page is the 1-based page number, at most MaxPage.
+	Page int
+	// Limit is the page size, always between 1 and MaxLimit.
+	Limit int
+	// After is the position of the last item of the previous page, if the cursor has one.
+	// Its format is up to the list. Lists that don't support keyset pagination can ignore it and use Offset.
+	After string
+}
+
+// Offset returns the number of items to skip.
+func (p Params) Offset() int {
+	return (p.Page - 1) * p.Limit
+}
+
+// Parse reads the "page", "limit", and "cursor" query parameters.
+// Missing or invalid page and limit values fall back to page 1 and defaultLimit. Pages past MaxPage become MaxPage.
+// If a cursor is given, it takes precedence over the page. Cursors are opaque to clients:
+// they should pass back the "next_cursor" of the previous response, with the same limit.
+// Returns ErrInvalidCursor if the cursor is malformed.
+func Parse(query url.Values, defaultLimit int) (Params, error) {
+	params := Params{
+		Page:  1,
+		Limit: ClampLimit(defaultLimit),
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if parsed, err := strconv.Atoi(limitStr); err == nil && parsed > 0 {
+			params.Limit = ClampLimit(parsed)
+		}
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		offset, after, err := decodeAnyCursor(cursor)
+		if err != nil {
+			return params, err
+		}
+		params.After = after
+		// Snap to the page that contains the offset, so that page-based and cursor-based
+		// callers always see the same page boundaries.
+		params.Page = min(offset/params.Limit, MaxPage-1) + 1
+		return params, nil
+	}
+
+	if pageStr := query.Get("page"); pageStr != "" {
+		if parsed, err := strconv.Atoi(pageStr); err == nil && parsed > 0 {
+			params.Page = min(parsed, MaxPage)
+		}
+	}
+
+	return params, nil
+}
+
+// ClampLimit keeps a page size between 1 and MaxLimit. Non-positive values become DefaultLimit.
+func ClampLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultLimit
+	}
+	if limit > MaxLimit {
+		return MaxLimit
+	}
+	return limit
+}
+
+// EncodeCursor creates an opaque cursor that points to the given offset.
+func EncodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorPrefix + strconv.Itoa(offset)))
+}
+
+// DecodeCursor returns the offset a cursor points to.
+func DecodeCursor(cursor string) (int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+
+	offsetStr, ok := strings.CutPrefix(string(decoded), cursorPrefix)
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown format", ErrInvalidCursor)
+	}
+
+	return parseOffset(offsetStr)
+}
+
+// EncodeKeysetCursor creates an opaque cursor that points to the given offset, and after the given position.
+// The offset keeps page numbers right, and lets lists without keyset pagination follow the cursor too.
+func EncodeKeysetCursor(offset int, after string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(keysetCursorPrefix + strconv.Itoa(offset) + ":" + after))
+}
+
+// decodeAnyCursor returns the offset and the position that an offset or keyset cursor points to.
+// The position is empty for offset cursors.
+func decodeAnyCursor(cursor string) (offset int, after string, err error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+
+	rest, ok := strings.CutPrefix(string(decoded), keysetCursorPrefix)
+	if !ok {
+		offset, err := DecodeCursor(cursor)
+		return offset, "", err
+	}
+	offsetStr, after, ok := strings.Cut(rest, ":")
+	if !ok || after == "" {
+		return 0, "", fmt.Errorf("%w: missing position", ErrInvalidCursor)
+	}
+	offset, err = parseOffset(offsetStr)
+	return offset, after, err
+}
+
+// parseOffset parses the offset of a cursor.
+func parseOffset(offsetStr string) (int, error) {
+	offset, err := strconv.Atoi(offsetStr)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("%w: bad offset", ErrInvalidCursor)
+	}
+	return offset, nil
+}
+
+// NewInfo builds the pagination metadata for a response.
+// returnedCount is the number of items in this page. totalCount is the number of items across all pages.
+// Set totalEstimated if totalCount is only an approximation. In that case, we assume there are
+// more items as long as the page is full.
+func NewInfo(params Params, returnedCount, totalCount int, totalEstimated bool) models.PaginationInfo {
+	info := models.PaginationInfo{
+		TotalCount:     totalCount,
+		TotalEstimated: totalEstimated,
+		Page:           params.Page,
+		PerPage:        params.Limit,
+	}
+
+	nextOffset := params.Offset() + returnedCount
+	hasMore := nextOffset < totalCount
+	if totalEstimated {
+		hasMore = returnedCount >= params.Limit
+	}
+	if hasMore && returnedCount > 0 {
+		cursor := EncodeCursor(nextOffset)
+		info.NextCursor = &cursor
+	}
+
+	return info
+}
+
+// NewKeysetInfo works like NewInfo, but the next cursor also points after lastPosition, the position of the last
+// item in this page. See Params.After.
+func NewKeysetInfo(params Params, returnedCount, totalCount int, totalEstimated bool, lastPosition string) models.PaginationInfo {
+	info := NewInfo(params, returnedCount, totalCount, totalEstimated)
+	if info.NextCursor != nil {
+		cursor := EncodeKeysetCursor(params.Offset()+returnedCount, lastPosition)
+		info.NextCursor = &cursor
+	}
+	return info
+}
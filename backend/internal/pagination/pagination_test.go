@@ -0,0 +1,161 @@
+package pagination
+
+import (
+	"errors"
+	"math"
+	"net/url"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name          string
+		query         string
+		expectedPage  int
+		expectedLimit int
+	}{
+		{"uses defaults", "", 1, 50},
+		{"reads page and limit", "page=3&limit=20", 3, 20},
+		{"ignores invalid page", "page=0", 1, 50},
+		{"ignores non-numeric limit", "limit=abc", 1, 50},
+		{"clamps large limit", "limit=100000", 1, MaxLimit},
+		{"clamps large page", "page=9223372036854775807&limit=500", MaxPage, MaxLimit},
+		{"clamps large cursor", "limit=1&cursor=" + EncodeCursor(math.MaxInt), MaxPage, 1},
+		{"cursor takes precedence over page", "page=7&limit=20&cursor=" + EncodeCursor(40), 3, 20},
+		{"snaps cursor to page boundary", "limit=20&cursor=" + EncodeCursor(45), 3, 20},
+		{"reads the offset of keyset cursors", "limit=20&cursor=" + EncodeKeysetCursor(40, "1:2:abc"), 3, 20},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tc.query)
+			if err != nil {
+				t.Fatalf("Failed to parse query: %v", err)
+			}
+
+			params, err := Parse(query, 50)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			if params.Page != tc.expectedPage {
+				t.Errorf("Expected page %d, got %d", tc.expectedPage, params.Page)
+			}
+			if params.Limit != tc.expectedLimit {
+				t.Errorf("Expected limit %d, got %d", tc.expectedLimit, params.Limit)
+			}
+		})
+	}
+
+	t.Run("reads the position of keyset cursors", func(t *testing.T) {
+		params, err := Parse(url.Values{"cursor": {EncodeKeysetCursor(40, "1:2:abc")}}, 20)
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if params.After != "1:2:abc" {
+			t.Errorf("Expected position 1:2:abc, got %q", params.After)
+		}
+
+		params, err = Parse(url.Values{"cursor": {EncodeCursor(40)}}, 20)
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if params.After != "" {
+			t.Errorf("Expected no position for offset cursors, got %q", params.After)
+		}
+	})
+
+	t.Run("returns error for invalid cursor", func(t *testing.T) {
+		for _, cursor := range []string{"not base64!", EncodeCursor(-1), "eHl6", EncodeKeysetCursor(40, ""), EncodeKeysetCursor(-1, "x")} {
+			_, err := Parse(url.Values{"cursor": {cursor}}, 50)
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("Expected ErrInvalidCursor for %q, got %v", cursor, err)
+			}
+		}
+	})
+}
+
+func TestClampLimit(t *testing.T) {
+	testCases := []struct {
+		limit    int
+		expected int
+	}{
+		{0, DefaultLimit},
+		{-5, DefaultLimit},
+		{1, 1},
+		{MaxLimit, MaxLimit},
+		{MaxLimit + 1, MaxLimit},
+	}
+
+	for _, tc := range testCases {
+		if got := ClampLimit(tc.limit); got != tc.expected {
+			t.Errorf("ClampLimit(%d): expected %d, got %d", tc.limit, tc.expected, got)
+		}
+	}
+}
+
+func TestNewInfo(t *testing.T) {
+	t.Run("sets next cursor when there are more items", func(t *testing.T) {
+		info := NewInfo(Params{Page: 2, Limit: 10}, 10, 35, false)
+
+		if info.NextCursor == nil {
+			t.Fatal("Expected next cursor")
+		}
+		offset, err := DecodeCursor(*info.NextCursor)
+		if err != nil {
+			t.Fatalf("DecodeCursor failed: %v", err)
+		}
+		if offset != 20 {
+			t.Errorf("Expected next offset 20, got %d", offset)
+		}
+		if info.TotalCount != 35 || info.Page != 2 || info.PerPage != 10 {
+			t.Errorf("Unexpected info: %+v", info)
+		}
+	})
+
+	t.Run("has no next cursor on the last page", func(t *testing.T) {
+		info := NewInfo(Params{Page: 4, Limit: 10}, 5, 35, false)
+
+		if info.NextCursor != nil {
+			t.Errorf("Expected no next cursor, got %q", *info.NextCursor)
+		}
+	})
+
+	t.Run("uses page fullness when the total is estimated", func(t *testing.T) {
+		full := NewInfo(Params{Page: 1, Limit: 10}, 10, 10, true)
+		if full.NextCursor == nil {
+			t.Error("Expected next cursor for a full page with an estimated total")
+		}
+		if !full.TotalEstimated {
+			t.Error("Expected total_estimated to be true")
+		}
+
+		partial := NewInfo(Params{Page: 1, Limit: 10}, 3, 100, true)
+		if partial.NextCursor != nil {
+			t.Error("Expected no next cursor for a partial page")
+		}
+	})
+}
+
+func TestNewKeysetInfo(t *testing.T) {
+	t.Run("points the next cursor after the last item", func(t *testing.T) {
+		info := NewKeysetInfo(Params{Page: 2, Limit: 10}, 10, 35, false, "0:123:abc")
+		if info.NextCursor == nil {
+			t.Fatal("Expected next cursor")
+		}
+
+		params, err := Parse(url.Values{"cursor": {*info.NextCursor}, "limit": {"10"}}, 50)
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if params.Page != 3 || params.After != "0:123:abc" {
+			t.Errorf("Expected page 3 after 0:123:abc, got %+v", params)
+		}
+	})
+
+	t.Run("has no next cursor on the last page", func(t *testing.T) {
+		info := NewKeysetInfo(Params{Page: 4, Limit: 10}, 5, 35, false, "0:123:abc")
+		if info.NextCursor != nil {
+			t.Errorf("Expected no next cursor, got %q", *info.NextCursor)
+		}
+	})
+}
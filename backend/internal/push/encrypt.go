@@ -0,0 +1,118 @@
+package push
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// recordSize is the record size in the header of encrypted payloads. Payloads are always one record.
+	recordSize = 4096
+
+	// paddingBlockSize rounds payloads up, so the push provider can't tell the length of the subject.
+	paddingBlockSize = 256
+
+	authSecretLength = 16
+	saltLength       = 16
+	keyLength        = 16
+	nonceLength      = 12
+
+	// headerLength is the salt, the record size, the key ID length, and an uncompressed P-256 key.
+	headerLength = saltLength + 4 + 1 + 65
+)
+
+// ErrPayloadTooLarge is returned when a payload doesn't fit in one push message.
+var ErrPayloadTooLarge = errors.New("payload too large")
+
+// encrypt encrypts the payload for the device with the "aes128gcm" content encoding of Web Push (RFC 8291).
+// Each call uses a new key pair and salt.
+func encrypt(keys DeviceKeys, payload []byte) ([]byte, error) {
+	if len(keys.Auth) != authSecretLength {
+		return nil, fmt.Errorf("%w: auth secret must be %d bytes", ErrInvalidDeviceKeys, authSecretLength)
+	}
+	devicePublicKey, err := ecdh.P256().NewPublicKey(keys.P256dh)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDeviceKeys, err)
+	}
+
+	// The payload, the delimiter of the last record, and the padding
+	paddedLength := (len(payload)/paddingBlockSize + 1) * paddingBlockSize
+	if headerLength+paddedLength+16 > recordSize {
+		return nil, ErrPayloadTooLarge
+	}
+	plaintext := make([]byte, paddedLength)
+	copy(plaintext, payload)
+	plaintext[len(payload)] = 0x02
+
+	serverPrivateKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	serverPublicKey := serverPrivateKey.PublicKey().Bytes()
+
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	sharedSecret, err := serverPrivateKey.ECDH(devicePublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDeviceKeys, err)
+	}
+	contentKey, nonce, err := deriveContentKey(sharedSecret, keys.Auth, keys.P256dh, serverPublicKey, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	body := make([]byte, 0, headerLength+paddedLength+gcm.Overhead())
+	body = append(body, salt...)
+	body = binary.BigEndian.AppendUint32(body, recordSize)
+	body = append(body, byte(len(serverPublicKey)))
+	body = append(body, serverPublicKey...)
+	return gcm.Seal(body, nonce, plaintext, nil), nil
+}
+
+// deriveContentKey derives the content encryption key and the nonce from the shared secret of the server's and
+// the device's keys. Devices derive the same ones with their private key to decrypt the payload.
+func deriveContentKey(sharedSecret, authSecret, devicePublicKey, serverPublicKey, salt []byte) ([]byte, []byte, error) {
+	keyInfo := "WebPush: info\x00" + string(devicePublicKey) + string(serverPublicKey)
+
+	authPRK, err := hkdf.Extract(sha256.New, sharedSecret, authSecret)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	ikm, err := hkdf.Expand(sha256.New, authPRK, keyInfo, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	contentKey, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", keyLength)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", nonceLength)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+
+	return contentKey, nonce, nil
+}
@@ -0,0 +1,138 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/mail"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/importance"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// ConnectionCounter tells how many WebSocket connections a user has. The WebSocket hub implements it.
+type ConnectionCounter interface {
+	ActiveConnections(userID string) int
+}
+
+// Notifier sends push notifications about new mail to the user's devices.
+type Notifier struct {
+	pool        *pgxpool.Pool
+	sender      *Sender
+	connections ConnectionCounter
+}
+
+// NewNotifier creates a new Notifier. Users with a connection in connections get no push notifications,
+// since the open app already shows the new mail.
+func NewNotifier(pool *pgxpool.Pool, sender *Sender, connections ConnectionCounter) *Notifier {
+	return &Notifier{
+		pool:        pool,
+		sender:      sender,
+		connections: connections,
+	}
+}
+
+// threadNotification is a notification about the new messages of a thread.
+type threadNotification struct {
+	notification Notification
+	important    bool
+}
+
+// NotifyNewMail sends a notification about each thread with new unread messages among the UIDs in the folder to
+// the user's Web Push devices, unless the user has the app open. The user's notification rules pick the messages
+// that notify. Errors are logged, since nobody waits for this. Devices whose subscription is gone get their push cleared.
+func (n *Notifier) NotifyNewMail(ctx context.Context, userID, folderName string, uids []uint32) {
+	if len(uids) == 0 || n.connections.ActiveConnections(userID) > 0 {
+		return
+	}
+
+	rules, err := db.GetNotificationRules(ctx, n.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Notifier: Failed to get notification rules", "error", err)
+		return
+	}
+
+	int64UIDs := make([]int64, len(uids))
+	for i, uid := range uids {
+		int64UIDs[i] = int64(uid)
+	}
+	messages, err := db.GetUnreadNewMail(ctx, n.pool, userID, folderName, int64UIDs)
+	if err != nil {
+		slog.ErrorContext(ctx, "Notifier: Failed to get new mail", "error", err)
+		return
+	}
+	now := time.Now()
+	messages = slices.DeleteFunc(messages, func(message models.NewMailMessage) bool {
+		return !shouldNotify(rules, message, folderName, now)
+	})
+
+	goneDevices := map[string]bool{}
+	for _, thread := range groupNewMailByThread(messages) {
+		devices, err := db.GetNotificationDevices(ctx, n.pool, userID, thread.important)
+		if err != nil {
+			slog.ErrorContext(ctx, "Notifier: Failed to get devices", "error", err)
+			return
+		}
+		for _, device := range devices {
+			if device.Platform != models.DevicePlatformWeb || goneDevices[device.ID] {
+				continue
+			}
+			if err := n.send(ctx, device, thread.notification); errors.Is(err, ErrSubscriptionGone) {
+				goneDevices[device.ID] = true
+				if err := db.ClearDevicePush(ctx, n.pool, device.ID); err != nil {
+					slog.ErrorContext(ctx, "Notifier: Failed to clear device push", "error", err)
+				}
+			} else if err != nil {
+				slog.WarnContext(ctx, "Notifier: Failed to send push notification", "device_id", device.ID, "error", err)
+			}
+		}
+	}
+}
+
+// send encrypts the notification for the device, and sends it.
+func (n *Notifier) send(ctx context.Context, device *models.Device, notification Notification) error {
+	message, err := BuildMessage(DeviceKeys{P256dh: device.PushP256dh, Auth: device.PushAuth}, notification)
+	if err != nil {
+		return err
+	}
+	return n.sender.Send(ctx, device.PushEndpoint, message)
+}
+
+// groupNewMailByThread makes one notification for each thread, in the order of their first new message.
+// The notification shows the latest message, and counts all of them.
+func groupNewMailByThread(messages []models.NewMailMessage) []threadNotification {
+	var threads []threadNotification
+	indexes := map[string]int{}
+	for _, message := range messages {
+		fromName, fromAddress := splitFromAddress(message.FromAddress)
+		notification := NewNotification(message.StableThreadID, fromName, fromAddress, message.Subject)
+
+		i, ok := indexes[message.StableThreadID]
+		if !ok {
+			indexes[message.StableThreadID] = len(threads)
+			threads = append(threads, threadNotification{
+				notification: notification,
+				important:    importance.IsImportant(message.ImportanceScore),
+			})
+			continue
+		}
+		count := max(threads[i].notification.Count, 1) + 1
+		threads[i].notification = notification
+		threads[i].notification.Count = count
+	}
+	return threads
+}
+
+// splitFromAddress splits a From address like "Alice <alice@example.com>" into the name and the address.
+// Addresses that don't parse are returned as they are.
+func splitFromAddress(from string) (string, string) {
+	address, err := mail.ParseAddress(from)
+	if err != nil {
+		return "", from
+	}
+	return address.Name, address.Address
+}
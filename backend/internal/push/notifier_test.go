@@ -0,0 +1,29 @@
+package push
+
+import (
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/importance"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+func TestGroupNewMailByThread(t *testing.T) {
+	threads := groupNewMailByThread([]models.NewMailMessage{
+		{StableThreadID: "<lunch@example.com>", FromAddress: "Alice <alice@example.com>", Subject: "Lunch?"},
+		{StableThreadID: "<report@example.com>", FromAddress: "boss@example.com", Subject: "Report", ImportanceScore: importance.Threshold},
+		{StableThreadID: "<lunch@example.com>", FromAddress: "\"Bob\" <bob@example.com>", Subject: "Re: Lunch?"},
+	})
+
+	if len(threads) != 2 {
+		t.Fatalf("Expected 2 threads, got %+v", threads)
+	}
+	lunch := threads[0]
+	if lunch.notification.StableThreadID != "<lunch@example.com>" || lunch.notification.Sender != "Bob" ||
+		lunch.notification.Snippet != "Re: Lunch?" || lunch.notification.Count != 2 || lunch.important {
+		t.Errorf("Unexpected notification about the lunch thread: %+v", lunch)
+	}
+	report := threads[1]
+	if report.notification.Sender != "boss@example.com" || report.notification.Count != 0 || !report.important {
+		t.Errorf("Unexpected notification about the report thread: %+v", report)
+	}
+}
@@ -0,0 +1,106 @@
+// Package push sends push notifications about new mail through Web Push. Payloads only have what the notification
+// shows, and they're encrypted for each device, so the push provider can't read them.
+package push
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MaxSenderLength is the most characters of the sender we send.
+	MaxSenderLength = 40
+
+	// MaxSnippetLength is the most characters of the subject we send.
+	MaxSnippetLength = 80
+
+	// collapseKeyLength is the length of collapse keys. Web Push allows at most 32 characters in the Topic header.
+	collapseKeyLength = 32
+)
+
+// ErrInvalidDeviceKeys is returned when a device's keys can't be used to encrypt a payload.
+var ErrInvalidDeviceKeys = errors.New("invalid device keys")
+
+// Notification is what a push notification about new mail shows.
+type Notification struct {
+	// StableThreadID lets the client open the thread when the user taps the notification.
+	StableThreadID string `json:"thread_id"`
+	// Sender is the name of the sender, or their address if they have no name.
+	Sender string `json:"sender"`
+	// Snippet is the start of the subject.
+	Snippet string `json:"snippet"`
+	// Count is how many new messages the notification is about. Zero means one.
+	Count int `json:"count,omitempty"`
+}
+
+// DeviceKeys are the keys a device gave us when it subscribed to push notifications.
+type DeviceKeys struct {
+	// P256dh is the device's P-256 public key, in uncompressed form.
+	P256dh []byte
+	// Auth is the device's 16-byte authentication secret.
+	Auth []byte
+}
+
+// Message is a push message, ready to send to the device's push provider.
+type Message struct {
+	// CollapseKey goes in the Topic header, so the device only shows the latest notification of each thread.
+	CollapseKey string
+	// Body is the encrypted payload. Send it with "Content-Encoding: aes128gcm".
+	Body []byte
+}
+
+// NewNotification creates a notification about a new message, with only as much of the sender and the
+// subject as the notification shows.
+func NewNotification(stableThreadID, fromName, fromAddress, subject string) Notification {
+	sender := strings.TrimSpace(fromName)
+	if sender == "" {
+		sender = strings.TrimSpace(fromAddress)
+	}
+	return Notification{
+		StableThreadID: stableThreadID,
+		Sender:         truncate(sender, MaxSenderLength),
+		Snippet:        truncate(strings.Join(strings.Fields(subject), " "), MaxSnippetLength),
+	}
+}
+
+// BuildMessage encrypts the notification for the device, and adds a collapse key for its thread.
+func BuildMessage(keys DeviceKeys, notification Notification) (*Message, error) {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	body, err := encrypt(keys, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		CollapseKey: CollapseKey(keys, notification.StableThreadID),
+		Body:        body,
+	}, nil
+}
+
+// CollapseKey returns the collapse key of a thread for a device.
+// The push provider sees it, so it's a keyed hash: it doesn't reveal the thread ID, and it's different for
+// each device, so the provider can't tell that two users got mail in the same thread.
+func CollapseKey(keys DeviceKeys, stableThreadID string) string {
+	mac := hmac.New(sha256.New, keys.Auth)
+	mac.Write([]byte(stableThreadID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:collapseKeyLength]
+}
+
+// truncate shortens the text to at most maxLength characters, with an ellipsis if it cut anything.
+func truncate(text string, maxLength int) string {
+	if utf8.RuneCountInString(text) <= maxLength {
+		return text
+	}
+	runes := []rune(text)
+	return strings.TrimSpace(string(runes[:maxLength-1])) + "…"
+}
@@ -0,0 +1,168 @@
+package push
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// newTestDevice creates the keys of a device, and returns its private key to decrypt with.
+func newTestDevice(t *testing.T) (DeviceKeys, *ecdh.PrivateKey) {
+	t.Helper()
+	privateKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	auth := make([]byte, 16)
+	if _, err := rand.Read(auth); err != nil {
+		t.Fatalf("Failed to generate auth secret: %v", err)
+	}
+	return DeviceKeys{P256dh: privateKey.PublicKey().Bytes(), Auth: auth}, privateKey
+}
+
+// decrypt decrypts a payload the way a browser does.
+func decrypt(t *testing.T, keys DeviceKeys, privateKey *ecdh.PrivateKey, body []byte) []byte {
+	t.Helper()
+	salt := body[:saltLength]
+	if rs := binary.BigEndian.Uint32(body[saltLength:]); rs != recordSize {
+		t.Fatalf("Expected record size %d, got %d", recordSize, rs)
+	}
+	keyIDLength := int(body[saltLength+4])
+	serverPublicKeyBytes := body[saltLength+5 : saltLength+5+keyIDLength]
+	serverPublicKey, err := ecdh.P256().NewPublicKey(serverPublicKeyBytes)
+	if err != nil {
+		t.Fatalf("Failed to parse server key: %v", err)
+	}
+	sharedSecret, err := privateKey.ECDH(serverPublicKey)
+	if err != nil {
+		t.Fatalf("ECDH failed: %v", err)
+	}
+	contentKey, nonce, err := deriveContentKey(sharedSecret, keys.Auth, keys.P256dh, serverPublicKeyBytes, salt)
+	if err != nil {
+		t.Fatalf("deriveContentKey failed: %v", err)
+	}
+	block, _ := aes.NewCipher(contentKey)
+	gcm, _ := cipher.NewGCM(block)
+	plaintext, err := gcm.Open(nil, nonce, body[saltLength+5+keyIDLength:], nil)
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+
+	end := bytes.LastIndexByte(plaintext, 0x02)
+	if end < 0 || len(bytes.Trim(plaintext[end+1:], "\x00")) != 0 {
+		t.Fatalf("Expected a delimiter and zero padding, got %q", plaintext)
+	}
+	return plaintext[:end]
+}
+
+func TestNewNotification(t *testing.T) {
+	t.Run("uses the name of the sender", func(t *testing.T) {
+		notification := NewNotification("<a@example.com>", "Alice", "alice@example.com", "Lunch?")
+		if notification.Sender != "Alice" || notification.Snippet != "Lunch?" || notification.StableThreadID != "<a@example.com>" {
+			t.Errorf("Unexpected notification: %+v", notification)
+		}
+	})
+
+	t.Run("falls back to the address", func(t *testing.T) {
+		notification := NewNotification("<a@example.com>", " ", "alice@example.com", "Lunch?")
+		if notification.Sender != "alice@example.com" {
+			t.Errorf("Expected the address, got %q", notification.Sender)
+		}
+	})
+
+	t.Run("shortens long subjects", func(t *testing.T) {
+		subject := strings.Repeat("ű", MaxSnippetLength+20)
+		notification := NewNotification("<a@example.com>", "Alice", "", subject+"\n  secret")
+		if utf8.RuneCountInString(notification.Snippet) != MaxSnippetLength || !strings.HasSuffix(notification.Snippet, "…") {
+			t.Errorf("Expected %d characters ending with an ellipsis, got %q", MaxSnippetLength, notification.Snippet)
+		}
+		if strings.Contains(notification.Snippet, "secret") {
+			t.Error("Expected the end of the subject to be cut")
+		}
+	})
+}
+
+func TestBuildMessage(t *testing.T) {
+	keys, privateKey := newTestDevice(t)
+	notification := NewNotification("<a@example.com>", "Alice", "alice@example.com", "Lunch?")
+
+	t.Run("encrypts the notification for the device", func(t *testing.T) {
+		message, err := BuildMessage(keys, notification)
+		if err != nil {
+			t.Fatalf("BuildMessage failed: %v", err)
+		}
+		if bytes.Contains(message.Body, []byte("Lunch")) || bytes.Contains(message.Body, []byte("Alice")) {
+			t.Error("Expected the body to be encrypted")
+		}
+
+		var decrypted Notification
+		if err := json.Unmarshal(decrypt(t, keys, privateKey, message.Body), &decrypted); err != nil {
+			t.Fatalf("Failed to unmarshal payload: %v", err)
+		}
+		if decrypted != notification {
+			t.Errorf("Expected %+v, got %+v", notification, decrypted)
+		}
+	})
+
+	t.Run("pads payloads to the same length", func(t *testing.T) {
+		short, err := BuildMessage(keys, NewNotification("<a@example.com>", "A", "", "Hi"))
+		if err != nil {
+			t.Fatalf("BuildMessage failed: %v", err)
+		}
+		long, err := BuildMessage(keys, NewNotification("<a@example.com>", "Alice Example", "", "About the meeting tomorrow"))
+		if err != nil {
+			t.Fatalf("BuildMessage failed: %v", err)
+		}
+		if len(short.Body) != len(long.Body) {
+			t.Errorf("Expected the same length, got %d and %d", len(short.Body), len(long.Body))
+		}
+	})
+
+	t.Run("rejects invalid keys", func(t *testing.T) {
+		_, err := BuildMessage(DeviceKeys{P256dh: []byte("bad"), Auth: keys.Auth}, notification)
+		if !errors.Is(err, ErrInvalidDeviceKeys) {
+			t.Errorf("Expected ErrInvalidDeviceKeys, got %v", err)
+		}
+		_, err = BuildMessage(DeviceKeys{P256dh: keys.P256dh, Auth: []byte("short")}, notification)
+		if !errors.Is(err, ErrInvalidDeviceKeys) {
+			t.Errorf("Expected ErrInvalidDeviceKeys, got %v", err)
+		}
+	})
+
+	t.Run("rejects payloads that don't fit", func(t *testing.T) {
+		notification := Notification{StableThreadID: strings.Repeat("x", recordSize)}
+		if _, err := BuildMessage(keys, notification); !errors.Is(err, ErrPayloadTooLarge) {
+			t.Errorf("Expected ErrPayloadTooLarge, got %v", err)
+		}
+	})
+}
+
+func TestCollapseKey(t *testing.T) {
+	keys, _ := newTestDevice(t)
+	otherKeys, _ := newTestDevice(t)
+
+	key := CollapseKey(keys, "<a@example.com>")
+	if len(key) != collapseKeyLength {
+		t.Errorf("Expected %d characters, got %q", collapseKeyLength, key)
+	}
+	if strings.Contains(key, "example") {
+		t.Errorf("Expected the key not to reveal the thread ID, got %q", key)
+	}
+	if CollapseKey(keys, "<a@example.com>") != key {
+		t.Error("Expected the same key for the same thread")
+	}
+	if CollapseKey(keys, "<b@example.com>") == key {
+		t.Error("Expected different keys for different threads")
+	}
+	if CollapseKey(otherKeys, "<a@example.com>") == key {
+		t.Error("Expected different keys for different devices")
+	}
+}
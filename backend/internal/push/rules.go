@@ -0,0 +1,83 @@
+package push
+
+import (
+	"strings"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// defaultNotifyFolder is the folder whose new messages notify when the user has no NotificationRuleNotify rules.
+const defaultNotifyFolder = "INBOX"
+
+// shouldNotify tells whether a new message in the folder should notify now, by the user's rules.
+// A matching NotificationRuleMute rule always wins. Once the user has a NotificationRuleNotify rule, only the
+// messages that match one notify. Without any, only the messages in INBOX do.
+func shouldNotify(rules []*models.NotificationRule, message models.NewMailMessage, folderName string, now time.Time) bool {
+	hasNotifyRules := false
+	matchesNotifyRule := false
+	for _, rule := range rules {
+		if rule.Action == models.NotificationRuleNotify {
+			hasNotifyRules = true
+		}
+		if !ruleMatches(rule, message, folderName, now) {
+			continue
+		}
+		if rule.Action == models.NotificationRuleMute {
+			return false
+		}
+		matchesNotifyRule = true
+	}
+	if hasNotifyRules {
+		return matchesNotifyRule
+	}
+	return strings.EqualFold(folderName, defaultNotifyFolder)
+}
+
+// ruleMatches tells whether all conditions of the rule match the message now.
+func ruleMatches(rule *models.NotificationRule, message models.NewMailMessage, folderName string, now time.Time) bool {
+	if rule.From != "" && !strings.Contains(strings.ToLower(message.FromAddress), strings.ToLower(rule.From)) {
+		return false
+	}
+	if rule.Subject != "" && !strings.Contains(strings.ToLower(message.Subject), strings.ToLower(rule.Subject)) {
+		return false
+	}
+	if rule.Folder != "" && !strings.EqualFold(rule.Folder, folderName) {
+		return false
+	}
+	return rule.Hours == nil || inHours(rule.Hours, now)
+}
+
+// inHours tells whether the time of day is in the hours. Hours that end before they start go overnight, and hours that
+// end when they start last all day. Unknown time zones count as UTC.
+func inHours(hours *models.NotificationRuleHours, now time.Time) bool {
+	start, startOK := parseTimeOfDay(hours.Start)
+	end, endOK := parseTimeOfDay(hours.End)
+	if !startOK || !endOK {
+		return false
+	}
+	location, err := time.LoadLocation(hours.TimeZone)
+	if err != nil {
+		location = time.UTC
+	}
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+
+	switch {
+	case start == end:
+		return true
+	case start < end:
+		return minute >= start && minute < end
+	default:
+		return minute >= start || minute < end
+	}
+}
+
+// parseTimeOfDay parses a time of day like "22:00" into minutes since midnight.
+func parseTimeOfDay(value string) (int, bool) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
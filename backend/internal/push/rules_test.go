@@ -0,0 +1,84 @@
+package push
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+func TestShouldNotify(t *testing.T) {
+	fromBoss := models.NewMailMessage{FromAddress: "The Boss <Boss@example.com>", Subject: "Quarterly report"}
+	fromShop := models.NewMailMessage{FromAddress: "deals@shop.example", Subject: "50% off"}
+	// 23:30 in Berlin, in winter
+	night := time.Date(2025, 1, 15, 22, 30, 0, 0, time.UTC)
+	day := time.Date(2025, 1, 15, 11, 0, 0, 0, time.UTC)
+
+	notifyBoss := &models.NotificationRule{Action: models.NotificationRuleNotify, From: "boss@example.com"}
+	notifyLists := &models.NotificationRule{Action: models.NotificationRuleNotify, Folder: "lists"}
+	muteDeals := &models.NotificationRule{Action: models.NotificationRuleMute, Subject: "% OFF"}
+	quietHours := &models.NotificationRule{
+		Action: models.NotificationRuleMute,
+		Hours:  &models.NotificationRuleHours{Start: "22:00", End: "07:00", TimeZone: "Europe/Berlin"},
+	}
+
+	testCases := []struct {
+		name    string
+		rules   []*models.NotificationRule
+		message models.NewMailMessage
+		folder  string
+		now     time.Time
+		want    bool
+	}{
+		{"notifies about INBOX without rules", nil, fromShop, "INBOX", day, true},
+		{"doesn't notify about other folders without rules", nil, fromShop, "Lists", day, false},
+		{"notifies about what a notify rule matches", []*models.NotificationRule{notifyBoss}, fromBoss, "INBOX", day, true},
+		{"doesn't notify about what no notify rule matches", []*models.NotificationRule{notifyBoss}, fromShop, "INBOX", day, false},
+		{"notifies about other folders that a rule matches", []*models.NotificationRule{notifyBoss, notifyLists}, fromShop, "Lists", day, true},
+		{"mutes what a mute rule matches", []*models.NotificationRule{muteDeals}, fromShop, "INBOX", day, false},
+		{"keeps notifying about what mute rules don't match", []*models.NotificationRule{muteDeals}, fromBoss, "INBOX", day, true},
+		{"mute rules win over notify rules", []*models.NotificationRule{notifyBoss, quietHours}, fromBoss, "INBOX", night, false},
+		{"quiet hours end in the morning", []*models.NotificationRule{notifyBoss, quietHours}, fromBoss, "INBOX", day, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := shouldNotify(tc.rules, tc.message, tc.folder, tc.now); got != tc.want {
+				t.Errorf("Expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestInHours(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2025, 6, 1, hour, minute, 0, 0, time.UTC)
+	}
+	workHours := &models.NotificationRuleHours{Start: "09:00", End: "17:00", TimeZone: "UTC"}
+	overnight := &models.NotificationRuleHours{Start: "22:00", End: "07:00", TimeZone: "UTC"}
+	allDay := &models.NotificationRuleHours{Start: "00:00", End: "00:00", TimeZone: "UTC"}
+
+	testCases := []struct {
+		name  string
+		hours *models.NotificationRuleHours
+		now   time.Time
+		want  bool
+	}{
+		{"starts at the start", workHours, at(9, 0), true},
+		{"ends before the end", workHours, at(17, 0), false},
+		{"excludes the night from work hours", workHours, at(23, 0), false},
+		{"goes overnight", overnight, at(23, 0), true},
+		{"goes past midnight", overnight, at(6, 59), true},
+		{"excludes the day from overnight hours", overnight, at(12, 0), false},
+		{"lasts all day if it ends when it starts", allDay, at(12, 0), true},
+		{"uses the time zone", &models.NotificationRuleHours{Start: "09:00", End: "17:00", TimeZone: "Asia/Tokyo"}, at(1, 0), true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := inHours(tc.hours, tc.now); got != tc.want {
+				t.Errorf("Expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/netguard"
+)
+
+// messageTTL is how long push services keep a message for a device that's offline. After a day, the notification is
+// old news, and the user sees the mail when they open the app anyway.
+const messageTTL = 24 * time.Hour
+
+// sendTimeout limits how long sending one push message takes.
+const sendTimeout = 10 * time.Second
+
+// ErrSubscriptionGone is returned when the push service says the subscription expired or the user revoked it, so
+// we should stop sending to it.
+var ErrSubscriptionGone = errors.New("push subscription is gone")
+
+// Sender sends push messages to the push services of browsers.
+type Sender struct {
+	httpClient *http.Client
+	vapid      *VAPID
+	now        func() time.Time
+}
+
+// NewSender creates a new Sender that identifies itself with the VAPID keys.
+// Its HTTP client only connects to public addresses, since the endpoints come from users.
+func NewSender(vapid *VAPID) *Sender {
+	return newSender(&http.Client{
+		Transport: netguard.NewTransport(),
+		// Push services answer directly, and a redirect could send the payload somewhere else
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}, vapid)
+}
+
+// newSender creates a new Sender with the HTTP client.
+func newSender(httpClient *http.Client, vapid *VAPID) *Sender {
+	return &Sender{
+		httpClient: httpClient,
+		vapid:      vapid,
+		now:        time.Now,
+	}
+}
+
+// Send sends the message to the Web Push endpoint of a device. See RFC 8030.
+// Returns ErrSubscriptionGone if the push service doesn't know the subscription anymore.
+func (s *Sender) Send(ctx context.Context, endpoint string, message *Message) error {
+	authorization, err := s.vapid.authorization(endpoint, s.now())
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(message.Body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(messageTTL.Seconds())))
+	req.Header.Set("Topic", message.CollapseKey)
+	req.Header.Set("Urgency", "normal")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send push message: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrSubscriptionGone
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return fmt.Errorf("push service responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/netguard"
+)
+
+func TestSenderSend(t *testing.T) {
+	message := &Message{CollapseKey: "collapse-key", Body: []byte("encrypted")}
+
+	t.Run("posts the message with the Web Push headers", func(t *testing.T) {
+		var got *http.Request
+		var body []byte
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r
+			body, _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer server.Close()
+
+		vapid := newTestVAPID(t)
+		s := newSender(server.Client(), vapid)
+		if err := s.Send(context.Background(), server.URL+"/send/abc", message); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+
+		if got.Method != http.MethodPost || got.URL.Path != "/send/abc" || string(body) != "encrypted" {
+			t.Errorf("Unexpected request: %s %s, body %q", got.Method, got.URL.Path, body)
+		}
+		for header, want := range map[string]string{
+			"Content-Encoding": "aes128gcm",
+			"Content-Type":     "application/octet-stream",
+			"TTL":              "86400",
+			"Topic":            "collapse-key",
+			"Urgency":          "normal",
+		} {
+			if value := got.Header.Get(header); value != want {
+				t.Errorf("Expected %s %q, got %q", header, want, value)
+			}
+		}
+		if authorization := got.Header.Get("Authorization"); !strings.HasPrefix(authorization, "vapid t=") ||
+			!strings.HasSuffix(authorization, ", k="+vapid.PublicKey()) {
+			t.Errorf("Unexpected Authorization: %s", authorization)
+		}
+	})
+
+	t.Run("returns ErrSubscriptionGone for expired subscriptions", func(t *testing.T) {
+		for _, status := range []int{http.StatusNotFound, http.StatusGone} {
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+			}))
+			s := newSender(server.Client(), newTestVAPID(t))
+			if err := s.Send(context.Background(), server.URL, message); !errors.Is(err, ErrSubscriptionGone) {
+				t.Errorf("Expected ErrSubscriptionGone for %d, got %v", status, err)
+			}
+			server.Close()
+		}
+	})
+
+	t.Run("fails for other errors", func(t *testing.T) {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer server.Close()
+
+		s := newSender(server.Client(), newTestVAPID(t))
+		err := s.Send(context.Background(), server.URL, message)
+		if err == nil || errors.Is(err, ErrSubscriptionGone) {
+			t.Errorf("Expected an error, got %v", err)
+		}
+	})
+}
+
+func TestNewSenderOnlyConnectsToPublicAddresses(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected no request to reach the local server")
+	}))
+	defer server.Close()
+
+	s := NewSender(newTestVAPID(t))
+	if err := s.Send(context.Background(), server.URL, &Message{}); !errors.Is(err, netguard.ErrNotPublic) {
+		t.Errorf("Expected ErrNotPublic, got %v", err)
+	}
+}
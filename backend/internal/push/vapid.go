@@ -0,0 +1,97 @@
+package push
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// vapidTokenLifetime is how long the tokens we send to push services are valid. RFC 8292 allows at most 24 hours.
+const vapidTokenLifetime = 12 * time.Hour
+
+// ErrInvalidVAPIDKey is returned when the VAPID private key isn't a base64url-encoded P-256 private key.
+var ErrInvalidVAPIDKey = errors.New("invalid VAPID private key")
+
+// VAPID identifies our server to push services, so that only we can send to the subscriptions that browsers made
+// with our public key. See RFC 8292.
+type VAPID struct {
+	privateKey *ecdsa.PrivateKey
+	// publicKey is the uncompressed public key, base64url-encoded, like browsers take it as applicationServerKey.
+	publicKey string
+	// subject is how push services can reach us, a "mailto:" or "https:" URL.
+	subject string
+}
+
+// NewVAPID creates a VAPID from a base64url-encoded 32-byte P-256 private key, like the ones that web-push libraries
+// generate, and a "mailto:" or "https:" subject.
+func NewVAPID(privateKey, subject string) (*VAPID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(privateKey, "="))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidVAPIDKey, err)
+	}
+	key, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidVAPIDKey, err)
+	}
+	publicKey, err := key.PublicKey.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidVAPIDKey, err)
+	}
+	if !strings.HasPrefix(subject, "mailto:") && !strings.HasPrefix(subject, "https:") {
+		return nil, fmt.Errorf("VAPID subject must be a mailto: or https: URL, got %q", subject)
+	}
+	return &VAPID{
+		privateKey: key,
+		publicKey:  base64.RawURLEncoding.EncodeToString(publicKey),
+		subject:    subject,
+	}, nil
+}
+
+// PublicKey returns the public key, base64url-encoded, for browsers to subscribe with.
+func (v *VAPID) PublicKey() string {
+	return v.publicKey
+}
+
+// authorization returns the Authorization header for a push message to the endpoint: a token signed for the origin
+// of the endpoint, valid for vapidTokenLifetime from now, and the public key to check it with.
+func (v *VAPID) authorization(endpoint string, now time.Time) (string, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint: %w", err)
+	}
+
+	header, err := json.Marshal(map[string]string{"typ": "JWT", "alg": "ES256"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"aud": endpointURL.Scheme + "://" + endpointURL.Host,
+		"exp": now.Add(vapidTokenLifetime).Unix(),
+		"sub": v.subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	// ES256 signatures are r and s as 32 bytes each, not ASN.1
+	hash := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, v.privateKey, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	token := unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+	return "vapid t=" + token + ", k=" + v.publicKey, nil
+}
@@ -0,0 +1,117 @@
+package push
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestVAPID creates a VAPID with a new key.
+func newTestVAPID(t *testing.T) *VAPID {
+	t.Helper()
+	privateKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	vapid, err := NewVAPID(base64.RawURLEncoding.EncodeToString(privateKey.Bytes()), "mailto:admin@example.com")
+	if err != nil {
+		t.Fatalf("NewVAPID failed: %v", err)
+	}
+	return vapid
+}
+
+func TestNewVAPID(t *testing.T) {
+	t.Run("derives the public key", func(t *testing.T) {
+		privateKey, err := ecdh.P256().GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+		vapid, err := NewVAPID(base64.RawURLEncoding.EncodeToString(privateKey.Bytes()), "https://mail.example.com")
+		if err != nil {
+			t.Fatalf("NewVAPID failed: %v", err)
+		}
+		if want := base64.RawURLEncoding.EncodeToString(privateKey.PublicKey().Bytes()); vapid.PublicKey() != want {
+			t.Errorf("Expected public key %s, got %s", want, vapid.PublicKey())
+		}
+	})
+
+	t.Run("rejects invalid keys", func(t *testing.T) {
+		for _, key := range []string{"", "not base64!", base64.RawURLEncoding.EncodeToString([]byte("too short"))} {
+			if _, err := NewVAPID(key, "mailto:admin@example.com"); !errors.Is(err, ErrInvalidVAPIDKey) {
+				t.Errorf("Expected ErrInvalidVAPIDKey for %q, got %v", key, err)
+			}
+		}
+	})
+
+	t.Run("rejects subjects that aren't mailto or https", func(t *testing.T) {
+		privateKey, err := ecdh.P256().GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+		if _, err := NewVAPID(base64.RawURLEncoding.EncodeToString(privateKey.Bytes()), "admin@example.com"); err == nil {
+			t.Error("Expected an error for a subject without a scheme")
+		}
+	})
+}
+
+func TestVAPIDAuthorization(t *testing.T) {
+	vapid := newTestVAPID(t)
+	now := time.Date(2025, 3, 4, 10, 0, 0, 0, time.UTC)
+
+	authorization, err := vapid.authorization("https://push.example.com/send/abc?x=1", now)
+	if err != nil {
+		t.Fatalf("authorization failed: %v", err)
+	}
+	token, publicKey, ok := strings.Cut(strings.TrimPrefix(authorization, "vapid t="), ", k=")
+	if !ok || publicKey != vapid.PublicKey() {
+		t.Fatalf("Unexpected authorization: %s", authorization)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("Expected a JWT with 3 parts, got %s", token)
+	}
+	var claims struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+		Sub string `json:"sub"`
+	}
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("Failed to decode claims: %v", err)
+	}
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		t.Fatalf("Failed to parse claims: %v", err)
+	}
+	if claims.Aud != "https://push.example.com" || claims.Exp != now.Add(vapidTokenLifetime).Unix() || claims.Sub != "mailto:admin@example.com" {
+		t.Errorf("Unexpected claims: %+v", claims)
+	}
+
+	// Check the signature like a push service does, with the public key from the header
+	publicKeyBytes, err := base64.RawURLEncoding.DecodeString(publicKey)
+	if err != nil {
+		t.Fatalf("Failed to decode public key: %v", err)
+	}
+	ecdsaPublicKey, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), publicKeyBytes)
+	if err != nil {
+		t.Fatalf("Failed to parse public key: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(signature) != 64 {
+		t.Fatalf("Expected a 64-byte signature, got %d bytes, %v", len(signature), err)
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(ecdsaPublicKey, hash[:], r, s) {
+		t.Error("Expected a valid signature")
+	}
+}
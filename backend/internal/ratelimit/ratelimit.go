@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket. Each request takes a token, and tokens come back at a steady rate,
+// up to a burst. A new bucket is full.
+type Bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// NewBucket returns a full bucket.
+func NewBucket(burst int, now time.Time) Bucket {
+	return Bucket{Tokens: float64(burst), UpdatedAt: now}
+}
+
+// Take refills the bucket with rate tokens per second for the time since it was last updated, up to burst,
+// and takes a token if there's one. Returns true if it took a token, and otherwise how long until the next one.
+func (b *Bucket) Take(rate float64, burst int, now time.Time) (bool, time.Duration) {
+	if elapsed := now.Sub(b.UpdatedAt).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(float64(burst), b.Tokens+elapsed*rate)
+	}
+	// Clocks can go backwards a bit, so we never move UpdatedAt back
+	if now.After(b.UpdatedAt) {
+		b.UpdatedAt = now
+	}
+
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.Tokens) / rate * float64(time.Second))
+}
+
+// Store keeps the buckets of rate-limited keys, for example, users.
+type Store interface {
+	// Take takes a token from the key's bucket, like Bucket.Take. Keys without a bucket start with a full one.
+	Take(ctx context.Context, key string, rate float64, burst int, now time.Time) (bool, time.Duration, error)
+}
+
+// memoryEvictionInterval is how often MemoryStore looks for buckets to evict.
+const memoryEvictionInterval = time.Minute
+
+// MemoryStore keeps the buckets in memory. The limits are per process, so with more than one backend instance,
+// use a shared store, like db.RateLimitStore.
+// Buckets that refilled are evicted, since a new bucket is full too, so idle keys don't take memory forever.
+type MemoryStore struct {
+	mu          sync.Mutex
+	buckets     map[string]*memoryBucket
+	lastEvicted time.Time
+}
+
+// memoryBucket is a bucket of MemoryStore, with when it'll be full again.
+type memoryBucket struct {
+	Bucket
+	fullAt time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*memoryBucket)}
+}
+
+// Take takes a token from the key's bucket. It never fails.
+// At most once per memoryEvictionInterval, it evicts the buckets that are full by now.
+func (s *MemoryStore) Take(_ context.Context, key string, rate float64, burst int, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastEvicted) >= memoryEvictionInterval {
+		s.evictFull(now)
+	}
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{Bucket: NewBucket(burst, now)}
+		s.buckets[key] = bucket
+	}
+	allowed, retryAfter := bucket.Take(rate, burst, now)
+	bucket.fullAt = bucket.UpdatedAt.Add(time.Duration((float64(burst) - bucket.Tokens) / rate * float64(time.Second)))
+	return allowed, retryAfter, nil
+}
+
+// evictFull deletes the buckets that are full at now. Must be called with s.mu held.
+func (s *MemoryStore) evictFull(now time.Time) {
+	for key, bucket := range s.buckets {
+		if !now.Before(bucket.fullAt) {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastEvicted = now
+}
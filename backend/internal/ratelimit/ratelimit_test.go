@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBucketTake(t *testing.T) {
+	start := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	t.Run("allows a burst, then waits for refills", func(t *testing.T) {
+		bucket := NewBucket(3, start)
+		for i := 0; i < 3; i++ {
+			if allowed, _ := bucket.Take(1, 3, start); !allowed {
+				t.Fatalf("Expected request %d to be allowed", i+1)
+			}
+		}
+		allowed, retryAfter := bucket.Take(1, 3, start)
+		if allowed || retryAfter != time.Second {
+			t.Errorf("Expected a denial with 1s retry, got %v, %v", allowed, retryAfter)
+		}
+		if allowed, _ := bucket.Take(1, 3, start.Add(time.Second)); !allowed {
+			t.Error("Expected a request to be allowed after a refill")
+		}
+	})
+
+	t.Run("doesn't refill over the burst", func(t *testing.T) {
+		bucket := NewBucket(2, start)
+		bucket.Take(1, 2, start.Add(time.Hour))
+		if bucket.Tokens != 1 {
+			t.Errorf("Expected 1 token, got %v", bucket.Tokens)
+		}
+	})
+
+	t.Run("survives the clock going back", func(t *testing.T) {
+		bucket := NewBucket(1, start)
+		bucket.Take(1, 1, start)
+		if allowed, _ := bucket.Take(1, 1, start.Add(-time.Minute)); allowed {
+			t.Error("Expected no token after the clock went back")
+		}
+		if !bucket.UpdatedAt.Equal(start) {
+			t.Errorf("Expected UpdatedAt to stay %v, got %v", start, bucket.UpdatedAt)
+		}
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryStore()
+
+	if allowed, _, _ := store.Take(ctx, "alice", 1, 1, now); !allowed {
+		t.Error("Expected the first request of alice to be allowed")
+	}
+	if allowed, _, _ := store.Take(ctx, "alice", 1, 1, now); allowed {
+		t.Error("Expected the second request of alice to be denied")
+	}
+	if allowed, _, _ := store.Take(ctx, "bob", 1, 1, now); !allowed {
+		t.Error("Expected bob to have his own bucket")
+	}
+}
+
+func TestMemoryStoreEviction(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+
+	// With 10 tokens a minute, an empty bucket of 20 is full again after 2 minutes
+	rate := 10.0 / 60
+	for i := 0; i < 20; i++ {
+		store.Take(ctx, "alice", rate, 20, start)
+	}
+	store.Take(ctx, "bob", rate, 20, start)
+
+	store.Take(ctx, "carol", rate, 20, start.Add(time.Minute))
+	if _, ok := store.buckets["bob"]; ok {
+		t.Error("Expected bob's bucket to be evicted once it was full again")
+	}
+	if _, ok := store.buckets["alice"]; !ok {
+		t.Error("Expected alice's bucket to be kept while it refills")
+	}
+
+	if allowed, _, _ := store.Take(ctx, "alice", rate, 20, start.Add(time.Minute)); !allowed {
+		t.Error("Expected alice to have refilled tokens")
+	}
+	store.Take(ctx, "carol", rate, 20, start.Add(5*time.Minute))
+	if _, ok := store.buckets["alice"]; ok {
+		t.Error("Expected alice's bucket to be evicted once it was full again")
+	}
+}
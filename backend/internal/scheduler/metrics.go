@@ -0,0 +1,60 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+)
+
+// Metrics collects how long background folder syncs take. It's safe for concurrent use.
+type Metrics struct {
+	mu            sync.Mutex
+	syncs         int
+	failures      int
+	totalDuration time.Duration
+	maxDuration   time.Duration
+	lastDuration  time.Duration
+}
+
+// MetricsSnapshot is the state of Metrics at one point in time.
+type MetricsSnapshot struct {
+	// Syncs is the number of folder syncs, including the failed ones.
+	Syncs    int
+	Failures int
+	// AverageDuration, MaxDuration, and LastDuration are about single folder syncs.
+	AverageDuration time.Duration
+	MaxDuration     time.Duration
+	LastDuration    time.Duration
+}
+
+// Record adds a folder sync that took the given duration. err is the sync's error, if any.
+func (m *Metrics) Record(duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.syncs++
+	if err != nil {
+		m.failures++
+	}
+	m.totalDuration += duration
+	m.lastDuration = duration
+	if duration > m.maxDuration {
+		m.maxDuration = duration
+	}
+}
+
+// Snapshot returns the current metrics.
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := MetricsSnapshot{
+		Syncs:        m.syncs,
+		Failures:     m.failures,
+		MaxDuration:  m.maxDuration,
+		LastDuration: m.lastDuration,
+	}
+	if m.syncs > 0 {
+		snapshot.AverageDuration = m.totalDuration / time.Duration(m.syncs)
+	}
+	return snapshot
+}
@@ -0,0 +1,265 @@
+package scheduler
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/activity"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/logging"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+const (
+	// checkInterval is how often the scheduler looks for users whose sync is due.
+	checkInterval = 30 * time.Second
+	// userSyncTimeout is the longest we let the background sync of one user's folders run.
+	userSyncTimeout = 5 * time.Minute
+	// metricsLogInterval is how often the scheduler logs its sync duration metrics.
+	metricsLogInterval = 15 * time.Minute
+)
+
+// FolderSyncer syncs a folder into the cache. imap.IMAPService implements it.
+type FolderSyncer interface {
+	SyncThreadsForFolder(ctx context.Context, userID, folderName string) error
+}
+
+// Scheduler periodically syncs INBOX and the folders that users enabled syncing for,
+// so that the cache stays fresh even when nobody is looking at it.
+// Each user gets a fixed offset within the interval, so that the syncs of different users are spread out.
+// With an activity tracker, active users sync more often and dormant ones less often. See SetActivity.
+type Scheduler struct {
+	pool     *pgxpool.Pool
+	syncer   FolderSyncer
+	interval time.Duration
+	metrics  *Metrics
+
+	// activity is nil if all users sync every interval.
+	activity        *activity.Tracker
+	activeInterval  time.Duration
+	dormantInterval time.Duration
+
+	// slots limits how many users we sync at the same time.
+	// The IMAP pool limits the connections per user, and we sync each user's folders one by one.
+	slots chan struct{}
+
+	mu       sync.Mutex
+	nextSync map[string]time.Time
+	lastSync map[string]time.Time
+	running  map[string]bool
+}
+
+// NewScheduler creates a new sync scheduler. It syncs each user's folders every interval,
+// and at most maxConcurrentUsers users at the same time.
+func NewScheduler(pool *pgxpool.Pool, syncer FolderSyncer, interval time.Duration, maxConcurrentUsers int) *Scheduler {
+	if maxConcurrentUsers < 1 {
+		maxConcurrentUsers = 1
+	}
+	return &Scheduler{
+		pool:     pool,
+		syncer:   syncer,
+		interval: interval,
+		metrics:  &Metrics{},
+		slots:    make(chan struct{}, maxConcurrentUsers),
+		nextSync: make(map[string]time.Time),
+		lastSync: make(map[string]time.Time),
+		running:  make(map[string]bool),
+	}
+}
+
+// SetActivity makes the scheduler sync users by their activity level: active users every activeInterval,
+// idle users every interval, and dormant users every dormantInterval. A zero interval means the default one.
+// Call it before Run.
+func (s *Scheduler) SetActivity(tracker *activity.Tracker, activeInterval, dormantInterval time.Duration) {
+	s.activity = tracker
+	s.activeInterval = activeInterval
+	s.dormantInterval = dormantInterval
+}
+
+// Metrics returns a snapshot of the sync duration metrics.
+func (s *Scheduler) Metrics() MetricsSnapshot {
+	return s.metrics.Snapshot()
+}
+
+// Run syncs folders until the context is cancelled. Run it in a goroutine.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	metricsTicker := time.NewTicker(metricsLogInterval)
+	defer metricsTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.startDueSyncs(ctx)
+		case <-metricsTicker.C:
+			m := s.Metrics()
+			slog.InfoContext(ctx, "Scheduler: Folder sync metrics", "syncs", m.Syncs, "failed", m.Failures,
+				"average", m.AverageDuration, "slowest", m.MaxDuration, "last", m.LastDuration)
+			if s.activity != nil {
+				a := s.activity.Snapshot()
+				slog.InfoContext(ctx, "Scheduler: User activity", "active", a.Active, "idle", a.Idle, "dormant", a.Dormant,
+					"promotions", a.Promotions, "demotions", a.Demotions)
+			}
+		}
+	}
+}
+
+// startDueSyncs starts syncing the users whose sync is due, as far as there are free slots.
+// Users that don't get a slot stay due, so they go first next time.
+func (s *Scheduler) startDueSyncs(ctx context.Context) {
+	users, err := db.GetUsersWithSettings(ctx, s.pool)
+	if err != nil {
+		slog.ErrorContext(ctx, "Scheduler: Failed to get users", "error", err)
+		return
+	}
+
+	now := time.Now()
+	for _, userID := range s.dueUsers(now, users) {
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			return
+		}
+		s.markRunning(userID, now)
+		go func() {
+			defer func() { <-s.slots }()
+			defer s.markDone(userID)
+			s.syncUser(ctx, userID)
+		}()
+	}
+}
+
+// dueUsers returns the IDs of the users whose sync is due at now, and forgets the users who aren't in users anymore.
+// New users get their first sync after their offset within the interval.
+func (s *Scheduler) dueUsers(now time.Time, users []*models.User) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	known := make(map[string]bool, len(users))
+	var due []string
+	for _, user := range users {
+		known[user.ID] = true
+		if _, ok := s.nextSync[user.ID]; !ok {
+			s.nextSync[user.ID] = now.Add(s.jitter(user.ID))
+			continue
+		}
+		if !s.running[user.ID] && !now.Before(s.dueAt(user)) {
+			due = append(due, user.ID)
+		}
+	}
+
+	for userID := range s.nextSync {
+		if !known[userID] {
+			delete(s.nextSync, userID)
+			delete(s.lastSync, userID)
+		}
+	}
+
+	return due
+}
+
+// dueAt returns when the user's next sync is due. With an activity tracker, it's the interval of the user's
+// current level after their last sync, so that users who become active don't wait for a sync planned for dormant
+// ones. The caller must hold the lock.
+func (s *Scheduler) dueAt(user *models.User) time.Time {
+	last, ok := s.lastSync[user.ID]
+	if s.activity == nil || !ok {
+		return s.nextSync[user.ID]
+	}
+	return last.Add(s.intervalFor(s.activity.Level(user.Email)))
+}
+
+// intervalFor returns how often users at the level sync.
+func (s *Scheduler) intervalFor(level activity.Level) time.Duration {
+	switch {
+	case level == activity.LevelActive && s.activeInterval > 0:
+		return s.activeInterval
+	case level == activity.LevelDormant && s.dormantInterval > 0:
+		return s.dormantInterval
+	default:
+		return s.interval
+	}
+}
+
+// jitter returns the user's fixed offset within the interval.
+func (s *Scheduler) jitter(userID string) time.Duration {
+	if s.interval <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(userID))
+	return time.Duration(h.Sum64() % uint64(s.interval))
+}
+
+// markRunning records that the user's sync started at now, and schedules the next one.
+func (s *Scheduler) markRunning(userID string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running[userID] = true
+	s.nextSync[userID] = now.Add(s.interval)
+	s.lastSync[userID] = now
+}
+
+// markDone records that the user's sync finished.
+func (s *Scheduler) markDone(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, userID)
+}
+
+// syncUser syncs the user's background sync folders one by one, and records how long each took.
+// It skips users whose server throttles them, and stops when a folder sync gets them throttled.
+func (s *Scheduler) syncUser(ctx context.Context, userID string) {
+	ctx, cancel := context.WithTimeout(logging.WithUserID(ctx, userID), userSyncTimeout)
+	defer cancel()
+
+	if s.isThrottled(ctx, userID) {
+		return
+	}
+
+	folderNames, err := db.GetBackgroundSyncFolders(ctx, s.pool, userID)
+	if err != nil {
+		slog.WarnContext(ctx, "Scheduler: Failed to get folders to sync", "error", err)
+		return
+	}
+
+	start := time.Now()
+	for _, folderName := range folderNames {
+		if ctx.Err() != nil {
+			slog.WarnContext(ctx, "Scheduler: Sync timed out", "next_folder", folderName)
+			return
+		}
+		folderStart := time.Now()
+		err := s.syncer.SyncThreadsForFolder(ctx, userID, folderName)
+		duration := time.Since(folderStart)
+		s.metrics.Record(duration, err)
+		if err != nil {
+			slog.WarnContext(ctx, "Scheduler: Failed to sync folder", "folder", folderName, "duration", duration, "error", err)
+			if s.isThrottled(ctx, userID) {
+				return
+			}
+		}
+	}
+	slog.InfoContext(ctx, "Scheduler: Synced folders", "count", len(folderNames), "duration", time.Since(start))
+}
+
+// isThrottled tells whether the user's server throttles their syncs, so we should leave it alone.
+func (s *Scheduler) isThrottled(ctx context.Context, userID string) bool {
+	throttle, err := db.GetSyncThrottle(ctx, s.pool, userID)
+	if err != nil {
+		slog.WarnContext(ctx, "Scheduler: Failed to get sync throttle", "error", err)
+		return false
+	}
+	if throttle.ThrottledUntil == nil || !time.Now().Before(*throttle.ThrottledUntil) {
+		return false
+	}
+	slog.InfoContext(ctx, "Scheduler: Skipping throttled account", "until", *throttle.ThrottledUntil, "reason", throttle.Reason)
+	return true
+}
@@ -0,0 +1,194 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/activity"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+type mockSyncer struct {
+	mu     sync.Mutex
+	synced []string
+	err    error
+}
+
+func (m *mockSyncer) SyncThreadsForFolder(_ context.Context, userID, folderName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.synced = append(m.synced, userID+"/"+folderName)
+	return m.err
+}
+
+// users returns users with the given IDs, and emails made of them
+func users(userIDs ...string) []*models.User {
+	result := make([]*models.User, len(userIDs))
+	for i, userID := range userIDs {
+		result[i] = &models.User{ID: userID, Email: userID + "@example.com"}
+	}
+	return result
+}
+
+func TestScheduler_DueUsers(t *testing.T) {
+	interval := 5 * time.Minute
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("new users are due after their offset", func(t *testing.T) {
+		s := NewScheduler(nil, &mockSyncer{}, interval, 2)
+
+		if due := s.dueUsers(now, users("user-1")); len(due) != 0 {
+			t.Errorf("Expected no due users on first sight, got %v", due)
+		}
+		if due := s.dueUsers(now.Add(interval), users("user-1")); len(due) != 1 {
+			t.Errorf("Expected user-1 to be due after the interval, got %v", due)
+		}
+	})
+
+	t.Run("running users aren't due", func(t *testing.T) {
+		s := NewScheduler(nil, &mockSyncer{}, interval, 2)
+		s.dueUsers(now, users("user-1"))
+		s.markRunning("user-1", now)
+
+		if due := s.dueUsers(now.Add(2*interval), users("user-1")); len(due) != 0 {
+			t.Errorf("Expected no due users while syncing, got %v", due)
+		}
+
+		s.markDone("user-1")
+		if due := s.dueUsers(now.Add(2*interval), users("user-1")); len(due) != 1 {
+			t.Errorf("Expected user-1 to be due after the sync finished, got %v", due)
+		}
+	})
+
+	t.Run("forgets users without settings", func(t *testing.T) {
+		s := NewScheduler(nil, &mockSyncer{}, interval, 2)
+		s.dueUsers(now, users("user-1", "user-2"))
+		s.dueUsers(now, users("user-2"))
+
+		if _, ok := s.nextSync["user-1"]; ok {
+			t.Error("Expected user-1 to be forgotten")
+		}
+	})
+
+	t.Run("syncs users by their activity level", func(t *testing.T) {
+		s := NewScheduler(nil, &mockSyncer{}, interval, 2)
+		tracker := activity.NewTracker()
+		s.SetActivity(tracker, time.Minute, time.Hour)
+		s.dueUsers(now, users("active", "idle"))
+		s.markRunning("active", now)
+		s.markDone("active")
+		s.markRunning("idle", now)
+		s.markDone("idle")
+
+		// Two requests make a user active
+		tracker.Touch("active@example.com")
+		tracker.Touch("active@example.com")
+
+		due := s.dueUsers(now.Add(time.Minute), users("active", "idle"))
+		if len(due) != 1 || due[0] != "active" {
+			t.Errorf("Expected only the active user to be due after a minute, got %v", due)
+		}
+		if due := s.dueUsers(now.Add(interval), users("active", "idle")); len(due) != 2 {
+			t.Errorf("Expected both users to be due after the interval, got %v", due)
+		}
+	})
+}
+
+func TestScheduler_IntervalFor(t *testing.T) {
+	s := NewScheduler(nil, &mockSyncer{}, 5*time.Minute, 1)
+	s.SetActivity(activity.NewTracker(), time.Minute, 0)
+
+	if got := s.intervalFor(activity.LevelActive); got != time.Minute {
+		t.Errorf("Expected 1m for active users, got %v", got)
+	}
+	if got := s.intervalFor(activity.LevelIdle); got != 5*time.Minute {
+		t.Errorf("Expected 5m for idle users, got %v", got)
+	}
+	if got := s.intervalFor(activity.LevelDormant); got != 5*time.Minute {
+		t.Errorf("Expected the default interval for dormant users without their own, got %v", got)
+	}
+}
+
+func TestScheduler_Jitter(t *testing.T) {
+	interval := 5 * time.Minute
+	s := NewScheduler(nil, &mockSyncer{}, interval, 1)
+
+	for _, userID := range []string{"user-1", "user-2", "user-3"} {
+		jitter := s.jitter(userID)
+		if jitter < 0 || jitter >= interval {
+			t.Errorf("Expected jitter for %s within [0, %v), got %v", userID, interval, jitter)
+		}
+		if s.jitter(userID) != jitter {
+			t.Errorf("Expected the same jitter for %s every time", userID)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := &Metrics{}
+	if snapshot := m.Snapshot(); snapshot.Syncs != 0 || snapshot.AverageDuration != 0 {
+		t.Errorf("Expected empty metrics, got %+v", snapshot)
+	}
+
+	m.Record(100*time.Millisecond, nil)
+	m.Record(300*time.Millisecond, errors.New("boom"))
+	m.Record(200*time.Millisecond, nil)
+
+	snapshot := m.Snapshot()
+	if snapshot.Syncs != 3 || snapshot.Failures != 1 {
+		t.Errorf("Expected 3 syncs and 1 failure, got %d and %d", snapshot.Syncs, snapshot.Failures)
+	}
+	if snapshot.AverageDuration != 200*time.Millisecond {
+		t.Errorf("Expected average 200ms, got %v", snapshot.AverageDuration)
+	}
+	if snapshot.MaxDuration != 300*time.Millisecond {
+		t.Errorf("Expected max 300ms, got %v", snapshot.MaxDuration)
+	}
+	if snapshot.LastDuration != 200*time.Millisecond {
+		t.Errorf("Expected last 200ms, got %v", snapshot.LastDuration)
+	}
+}
+
+func TestScheduler_SyncUser(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	userID, err := db.GetOrCreateUser(ctx, pool, "scheduler-test@example.com")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	pref := &models.FolderSyncPreference{FolderName: "Work", Enabled: true, Mode: models.FolderSyncModeHeadersOnly}
+	if err := db.SaveFolderSyncPreference(ctx, pool, userID, pref); err != nil {
+		t.Fatalf("Failed to save folder sync preference: %v", err)
+	}
+
+	syncer := &mockSyncer{err: errors.New("connection refused")}
+	s := NewScheduler(pool, syncer, time.Minute, 1)
+	s.syncUser(ctx, userID)
+
+	expected := userID + "/INBOX," + userID + "/Work"
+	if got := strings.Join(syncer.synced, ","); got != expected {
+		t.Errorf("Expected syncs %s, got %s", expected, got)
+	}
+	if metrics := s.Metrics(); metrics.Syncs != 2 || metrics.Failures != 2 {
+		t.Errorf("Expected 2 failed syncs in the metrics, got %+v", metrics)
+	}
+
+	// Throttled accounts are skipped
+	until := time.Now().Add(time.Hour)
+	throttle := &models.SyncThrottle{Strikes: 1, ThrottledUntil: &until, Reason: "Account exceeded command or bandwidth limits."}
+	if err := db.SaveSyncThrottle(ctx, pool, userID, throttle); err != nil {
+		t.Fatalf("Failed to save sync throttle: %v", err)
+	}
+	s.syncUser(ctx, userID)
+	if len(syncer.synced) != 2 {
+		t.Errorf("Expected no syncs for a throttled account, got %v", syncer.synced)
+	}
+}
@@ -0,0 +1,278 @@
+package sieve
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The lines that the V-Mail block starts and ends with, see FiltersToBlock. They only count at the start of a line,
+// so the comments with the names of filters, which are indented, never look like them.
+const (
+	blockBegin = "# BEGIN vmail filters"
+	blockEnd   = "# END vmail filters"
+)
+
+// legacyScriptHeader starts the scripts that V-Mail generated before it kept the filters in a block.
+const legacyScriptHeader = "# Generated by V-Mail from your filters."
+
+// FindBlock returns the V-Mail block of the script, from the start of its first line to the end of its last one,
+// and whether the script has one. See FiltersToBlock.
+func FindBlock(script string) (string, bool) {
+	start, end, ok := blockBounds(script)
+	if !ok {
+		return "", false
+	}
+	return script[start:end], true
+}
+
+// SetBlock returns the script with the block in place of its V-Mail block, and the rest of it untouched.
+// If the script has no block yet, the block goes after the script's "require" commands, since those must come
+// first, so the block's rules run before the script's own ones. Scripts that V-Mail generated before it kept the
+// filters in a block, and empty scripts, are replaced with the block.
+func SetBlock(script, block string) string {
+	if start, end, ok := blockBounds(script); ok {
+		return script[:start] + block + script[end:]
+	}
+	if strings.HasPrefix(script, legacyScriptHeader) || strings.TrimSpace(script) == "" {
+		return block
+	}
+	at := requiresEnd(script)
+	before, after := script[:at], strings.TrimLeft(script[at:], "\r\n")
+	if before != "" {
+		before += "\n"
+	}
+	return before + block + "\n" + after
+}
+
+// BlockHash returns the SHA-256 hash of a block in hex, for noticing when the block changes on the server.
+// Line breaks don't count, since servers may store LF ones as CRLF.
+func BlockHash(block string) string {
+	sum := sha256.Sum256([]byte(strings.ReplaceAll(block, "\r\n", "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// blockBounds returns where the V-Mail block of the script starts, where it ends after the line break of its last
+// line, and whether the script has one.
+func blockBounds(script string) (int, int, bool) {
+	// Scripts with errors get the block only if it's before them
+	tokens, _ := lex(script)
+	start := -1
+	for _, t := range tokens {
+		if t.kind != tokenComment || !atLineStart(script, t.start) {
+			continue
+		}
+		switch {
+		case start < 0 && t.text == blockBegin:
+			start = t.start
+		case start >= 0 && t.text == blockEnd:
+			return start, lineEnd(script, t.end), true
+		}
+	}
+	return 0, 0, false
+}
+
+// requiresEnd returns where the "require" commands at the start of the script end, after the line break of the last
+// one, or 0 if the script doesn't start with any. Comments before and between them count as part of them.
+func requiresEnd(script string) int {
+	tokens, _ := lex(script)
+	end := 0
+	inRequire := false
+	for _, t := range tokens {
+		switch {
+		case inRequire:
+			if t.kind == tokenSpecial && t.text == ";" {
+				inRequire = false
+				end = lineEnd(script, t.end)
+			}
+		case t.kind == tokenComment:
+		case t.kind == tokenIdentifier && t.text == "require":
+			inRequire = true
+		default:
+			return end
+		}
+	}
+	return end
+}
+
+// atLineStart returns whether the byte at i starts a line.
+func atLineStart(script string, i int) bool {
+	return i == 0 || script[i-1] == '\n'
+}
+
+// lineEnd returns where the line ends after i, after its line break, if only spaces and a comment follow i on it.
+// Otherwise, it returns i.
+func lineEnd(script string, i int) int {
+	j := i
+	for j < len(script) && (script[j] == ' ' || script[j] == '\t' || script[j] == '\r') {
+		j++
+	}
+	if j < len(script) && script[j] == '#' {
+		j += strings.IndexByte(script[j:], '\n') + 1
+		if j == 0 {
+			return len(script)
+		}
+		return j
+	}
+	if j == len(script) {
+		return j
+	}
+	if script[j] == '\n' {
+		return j + 1
+	}
+	return i
+}
+
+// scriptTokenKind is the kind of a token of a Sieve script.
+type scriptTokenKind int
+
+const (
+	tokenEnd scriptTokenKind = iota
+	tokenIdentifier
+	tokenTag
+	tokenString
+	tokenNumber
+	tokenComment
+	// tokenSpecial is one of []{}(),;
+	tokenSpecial
+)
+
+// scriptToken is a lexical token of a Sieve script. See RFC 5228, section 8.1.
+type scriptToken struct {
+	kind scriptTokenKind
+	// text is the identifier or the tag with its colon in lowercase, the decoded string, the number with its
+	// quantifier, the comment without its line break, or the special character.
+	text string
+	// start and end are the byte offsets of the token in the script.
+	start, end int
+}
+
+// lex splits a Sieve script into tokens, without the bracketed comments. If the script is invalid, it returns the
+// tokens before the error too.
+func lex(script string) ([]scriptToken, error) {
+	var tokens []scriptToken
+	for i := 0; i < len(script); {
+		c := script[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '#':
+			end := len(script)
+			if n := strings.IndexByte(script[i:], '\n'); n >= 0 {
+				end = i + n
+			}
+			tokens = append(tokens, scriptToken{kind: tokenComment, text: strings.TrimRight(script[i:end], " \t\r"), start: i, end: end})
+			i = end
+		case strings.HasPrefix(script[i:], "/*"):
+			n := strings.Index(script[i+2:], "*/")
+			if n < 0 {
+				return tokens, errors.New("unterminated comment")
+			}
+			i += 2 + n + 2
+		case c == '"':
+			s, end, err := lexQuoted(script, i)
+			if err != nil {
+				return tokens, err
+			}
+			tokens = append(tokens, scriptToken{kind: tokenString, text: s, start: i, end: end})
+			i = end
+		case len(script) >= i+5 && strings.EqualFold(script[i:i+5], "text:"):
+			s, end, err := lexMultiLine(script, i)
+			if err != nil {
+				return tokens, err
+			}
+			tokens = append(tokens, scriptToken{kind: tokenString, text: s, start: i, end: end})
+			i = end
+		case c == ':':
+			end := i + 1 + identifierLength(script[i+1:])
+			if end == i+1 {
+				return tokens, fmt.Errorf("invalid tag at offset %d", i)
+			}
+			tokens = append(tokens, scriptToken{kind: tokenTag, text: strings.ToLower(script[i:end]), start: i, end: end})
+			i = end
+		case c >= '0' && c <= '9':
+			end := i
+			for end < len(script) && script[end] >= '0' && script[end] <= '9' {
+				end++
+			}
+			if end < len(script) && strings.IndexByte("KMGkmg", script[end]) >= 0 {
+				end++
+			}
+			tokens = append(tokens, scriptToken{kind: tokenNumber, text: script[i:end], start: i, end: end})
+			i = end
+		case identifierLength(script[i:]) > 0:
+			end := i + identifierLength(script[i:])
+			tokens = append(tokens, scriptToken{kind: tokenIdentifier, text: strings.ToLower(script[i:end]), start: i, end: end})
+			i = end
+		case strings.IndexByte("[](){},;", c) >= 0:
+			tokens = append(tokens, scriptToken{kind: tokenSpecial, text: string(c), start: i, end: i + 1})
+			i++
+		default:
+			return tokens, fmt.Errorf("unexpected character %q at offset %d", c, i)
+		}
+	}
+	return tokens, nil
+}
+
+// identifierLength returns the length of the identifier at the start of s, or 0 if s doesn't start with one.
+func identifierLength(s string) int {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		isLetter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !isLetter && (i == 0 || c < '0' || c > '9') {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// lexQuoted decodes the quoted string at start, and returns it with where it ends.
+// A backslash escapes any character, though only `\"` and `\\` are meant to be used. See RFC 5228, section 2.4.2.
+func lexQuoted(script string, start int) (string, int, error) {
+	var s strings.Builder
+	for i := start + 1; i < len(script); i++ {
+		if script[i] == '"' {
+			return s.String(), i + 1, nil
+		}
+		if script[i] == '\\' && i+1 < len(script) {
+			i++
+		}
+		s.WriteByte(script[i])
+	}
+	return "", 0, fmt.Errorf("unterminated string at offset %d", start)
+}
+
+// lexMultiLine decodes the "text:" string at start, and returns it with where it ends. The string is the lines up to
+// one with only a dot, and lines that start with a dot have an extra one. See RFC 5228, section 2.4.2.
+func lexMultiLine(script string, start int) (string, int, error) {
+	i := start + len("text:")
+	for i < len(script) && (script[i] == ' ' || script[i] == '\t') {
+		i++
+	}
+	if n := strings.IndexByte(script[i:], '\n'); n >= 0 && strings.HasPrefix(script[i:], "#") {
+		i += n
+	} else if strings.HasPrefix(script[i:], "\r") {
+		i++
+	}
+	if i >= len(script) || script[i] != '\n' {
+		return "", 0, fmt.Errorf("invalid multi-line string at offset %d", start)
+	}
+	i++
+
+	var s strings.Builder
+	for i < len(script) {
+		lineLength := strings.IndexByte(script[i:], '\n') + 1
+		if lineLength == 0 {
+			break
+		}
+		line := script[i : i+lineLength]
+		i += lineLength
+		if strings.TrimRight(line, "\r\n") == "." {
+			return s.String(), i, nil
+		}
+		s.WriteString(strings.TrimPrefix(line, "."))
+	}
+	return "", 0, fmt.Errorf("unterminated multi-line string at offset %d", start)
+}
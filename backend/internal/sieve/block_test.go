@@ -0,0 +1,101 @@
+package sieve
+
+import (
+	"strings"
+	"testing"
+)
+
+const testBlock = `# BEGIN vmail filters
+if header :contains "from" "news@example.com" {
+    fileinto "Newsletters";
+}
+# END vmail filters
+`
+
+func TestFindBlock(t *testing.T) {
+	t.Run("finds the block between the other rules", func(t *testing.T) {
+		script := "require [\"fileinto\"];\n\n" + testBlock + "\nif size :over 1M {\n    discard;\n}\n"
+		block, ok := FindBlock(script)
+		if !ok || block != testBlock {
+			t.Errorf("Expected the block, got %q, %v", block, ok)
+		}
+	})
+
+	t.Run("finds the block with CRLF line breaks", func(t *testing.T) {
+		script := "# BEGIN vmail filters\r\nkeep;\r\n# END vmail filters\r\nstop;\r\n"
+		block, ok := FindBlock(script)
+		if !ok || block != "# BEGIN vmail filters\r\nkeep;\r\n# END vmail filters\r\n" {
+			t.Errorf("Expected the block, got %q, %v", block, ok)
+		}
+	})
+
+	t.Run("ignores markers that aren't comments at the start of a line", func(t *testing.T) {
+		scripts := []string{
+			"if true {\n    # BEGIN vmail filters\n}\n# END vmail filters\n",
+			"vacation \"Away\n# BEGIN vmail filters\n\";\n# END vmail filters\n",
+			"vacation text:\n# BEGIN vmail filters\n.\n;\n# END vmail filters\n",
+			"/*\n# BEGIN vmail filters\n*/\n# END vmail filters\n",
+			"# BEGIN vmail filters\nkeep;\n",
+		}
+		for _, script := range scripts {
+			if block, ok := FindBlock(script); ok {
+				t.Errorf("Expected no block in %q, got %q", script, block)
+			}
+		}
+	})
+}
+
+func TestSetBlock(t *testing.T) {
+	testCases := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{
+			name:   "replaces the block and keeps the rest",
+			script: "require \"reject\";\n# BEGIN vmail filters\nkeep;\n# END vmail filters\nreject \"No\";\n",
+			want:   "require \"reject\";\n" + testBlock + "reject \"No\";\n",
+		},
+		{
+			name:   "adds the block after the requires",
+			script: "# My rules\nrequire \"reject\"; # For spam\nrequire [\"body\"];\n\nif body :contains \"casino\" {\n    reject \"No\";\n}\n",
+			want: "# My rules\nrequire \"reject\"; # For spam\nrequire [\"body\"];\n\n" + testBlock +
+				"\nif body :contains \"casino\" {\n    reject \"No\";\n}\n",
+		},
+		{
+			name:   "adds the block at the start without requires",
+			script: "keep;\n",
+			want:   testBlock + "\nkeep;\n",
+		},
+		{
+			name:   "replaces an empty script",
+			script: "\r\n",
+			want:   testBlock,
+		},
+		{
+			name:   "replaces a script that V-Mail generated before blocks",
+			script: "# Generated by V-Mail from your filters. Edit the filters in V-Mail, since changes here get overwritten.\nkeep;\n",
+			want:   testBlock,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := SetBlock(tc.script, testBlock); got != tc.want {
+				t.Errorf("Unexpected script:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBlockHash(t *testing.T) {
+	if BlockHash(testBlock) != BlockHash(testBlock) {
+		t.Error("Expected the same hash for the same block")
+	}
+	if BlockHash(testBlock) == BlockHash(testBlock+"\n") {
+		t.Error("Expected another hash for another block")
+	}
+	if BlockHash(testBlock) != BlockHash(strings.ReplaceAll(testBlock, "\n", "\r\n")) {
+		t.Error("Expected the same hash with CRLF line breaks")
+	}
+}
@@ -0,0 +1,446 @@
+// Package sieve manages the Sieve scripts of users on mail servers that support ManageSieve (RFC 5804), and
+// translates V-Mail filters to Sieve (RFC 5228), so that the server can run them on delivery.
+package sieve
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/oauth"
+)
+
+// DefaultPort is the port of ManageSieve servers. See RFC 5804, section 1.8.
+const DefaultPort = "4190"
+
+// commandTimeout limits how long a command, including dialing, may take.
+const commandTimeout = 15 * time.Second
+
+// maxLiteralLength is the longest string we accept from the server. Servers limit scripts to much less, for example,
+// Dovecot to 1 MB by default.
+const maxLiteralLength = 10 << 20
+
+// ErrAuthFailed wraps the errors of servers that reject the username or password.
+var ErrAuthFailed = errors.New("failed to authenticate")
+
+// ErrScriptNotFound is returned when a script doesn't exist.
+var ErrScriptNotFound = errors.New("script not found")
+
+// Error is a NO or BYE response of the server, like `NO (QUOTA/MAXSIZE) "Script is too big"`.
+type Error struct {
+	// Code is the response code, like "NONEXISTENT", or empty if there's none.
+	Code string
+	// Message is the human-readable text of the response, which can be empty.
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("ManageSieve server error (%s): %s", e.Code, e.Message)
+	}
+	return "ManageSieve server error: " + e.Message
+}
+
+// Server is how to connect to a ManageSieve server.
+type Server struct {
+	// Address is "host:port".
+	Address string
+	// UseTLS upgrades the connection with STARTTLS, and fails if the server doesn't support it.
+	UseTLS bool
+	// SkipTLSVerify accepts any certificate, for servers with self-signed ones.
+	SkipTLSVerify bool
+}
+
+// ServerFromSettings returns the ManageSieve server of the user's IMAP server: the same host on DefaultPort.
+// It uses STARTTLS unless the IMAP server is set to no security, or to the default in test mode.
+func ServerFromSettings(settings *models.UserSettings) Server {
+	host := strings.TrimSpace(settings.IMAPServerHostname)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	plain := settings.IMAPSecurity == models.IMAPSecurityNone ||
+		(settings.IMAPSecurity == "" && os.Getenv("VMAIL_TEST_MODE") == "true")
+	return Server{
+		Address:       net.JoinHostPort(host, DefaultPort),
+		UseTLS:        !plain,
+		SkipTLSVerify: settings.IMAPSkipTLSVerify,
+	}
+}
+
+// token is an item of a line from the server: an atom like OK, or a string, which can be quoted or a literal.
+type token struct {
+	value  string
+	string bool
+}
+
+// Client is a connection to a ManageSieve server. It's not safe for concurrent use.
+type Client struct {
+	conn net.Conn
+	r    *bufio.Reader
+	// capabilities maps the capabilities of the server, like "SIEVE" or "STARTTLS", to their values.
+	capabilities map[string]string
+}
+
+// Dial connects to the ManageSieve server, reads its capabilities, and upgrades the connection with STARTTLS if
+// server.UseTLS is set.
+func Dial(ctx context.Context, server Server) (*Client, error) {
+	dialer := &net.Dialer{Timeout: commandTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", server.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial: %w", err)
+	}
+
+	c := newClient(conn)
+	if err := c.readCapabilities(); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to read the greeting: %w", err)
+	}
+
+	if server.UseTLS {
+		if err := c.startTLS(server); err != nil {
+			_ = c.conn.Close()
+			return nil, fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// newClient wraps a connection whose greeting hasn't been read yet.
+func newClient(conn net.Conn) *Client {
+	return &Client{conn: conn, r: bufio.NewReader(conn)}
+}
+
+// startTLS upgrades the connection, and reads the capabilities again, since they can change with TLS.
+func (c *Client) startTLS(server Server) error {
+	if _, ok := c.capabilities["STARTTLS"]; !ok {
+		return errors.New("the server doesn't support STARTTLS")
+	}
+	if err := c.command(nil, "STARTTLS"); err != nil {
+		return err
+	}
+
+	host, _, err := net.SplitHostPort(server.Address)
+	if err != nil {
+		host = server.Address
+	}
+	tlsConn := tls.Client(c.conn, &tls.Config{
+		ServerName: host,
+		// The user chose this for their own server, and the settings form warns about it
+		InsecureSkipVerify: server.SkipTLSVerify,
+	})
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	c.conn = tlsConn
+	c.r = bufio.NewReader(tlsConn)
+	return c.readCapabilities()
+}
+
+// readCapabilities reads the capabilities that the server sends after connecting, STARTTLS, and AUTHENTICATE.
+func (c *Client) readCapabilities() error {
+	if err := c.conn.SetDeadline(time.Now().Add(commandTimeout)); err != nil {
+		return err
+	}
+	capabilities := make(map[string]string)
+	err := c.readResponse(func(line []token) error {
+		value := ""
+		if len(line) > 1 {
+			value = line[1].value
+		}
+		capabilities[strings.ToUpper(line[0].value)] = value
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.capabilities = capabilities
+	return nil
+}
+
+// Extensions returns the Sieve extensions that the server supports, like "fileinto", in lowercase.
+func (c *Client) Extensions() []string {
+	return strings.Fields(strings.ToLower(c.capabilities["SIEVE"]))
+}
+
+// Authenticate logs in with SASL: XOAUTH2 if the password is an OAuth access token, and PLAIN otherwise.
+// Errors of servers that reject the login wrap ErrAuthFailed.
+func (c *Client) Authenticate(username, password string) error {
+	saslClient := oauth.AuthClient(username, password)
+	mechanism, initialResponse, err := saslClient.Start()
+	if err != nil {
+		return err
+	}
+
+	if err := c.conn.SetDeadline(time.Now().Add(commandTimeout)); err != nil {
+		return err
+	}
+	if err := c.write("AUTHENTICATE", quoted(mechanism), quoted(base64.StdEncoding.EncodeToString(initialResponse))); err != nil {
+		return err
+	}
+	err = c.readResponse(func(line []token) error {
+		// A challenge: answer it, which for XOAUTH2 means an empty response to get the actual error
+		challenge, err := base64.StdEncoding.DecodeString(line[0].value)
+		if err != nil {
+			return fmt.Errorf("invalid challenge: %w", err)
+		}
+		response, err := saslClient.Next(challenge)
+		if err != nil {
+			return err
+		}
+		return c.write(quoted(base64.StdEncoding.EncodeToString(response)))
+	})
+	var serverErr *Error
+	if errors.As(err, &serverErr) {
+		return fmt.Errorf("%w: %w", ErrAuthFailed, err)
+	}
+	return err
+}
+
+// ListScripts returns the user's scripts, and which one is active, if any.
+func (c *Client) ListScripts() ([]models.SieveScript, error) {
+	scripts := []models.SieveScript{}
+	err := c.command(func(line []token) error {
+		if !line[0].string {
+			return fmt.Errorf("unexpected %q in the list of scripts", line[0].value)
+		}
+		scripts = append(scripts, models.SieveScript{
+			Name:   line[0].value,
+			Active: len(line) > 1 && strings.EqualFold(line[1].value, "ACTIVE"),
+		})
+		return nil
+	}, "LISTSCRIPTS")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scripts: %w", err)
+	}
+	return scripts, nil
+}
+
+// GetScript returns the content of a script. Returns ErrScriptNotFound if there's no such script.
+func (c *Client) GetScript(name string) (string, error) {
+	content := ""
+	err := c.command(func(line []token) error {
+		content = line[0].value
+		return nil
+	}, "GETSCRIPT", encodeString(name))
+	if err != nil {
+		return "", fmt.Errorf("failed to get script: %w", err)
+	}
+	return content, nil
+}
+
+// PutScript creates or replaces a script. The server checks the script first, and returns an *Error with the
+// problem if it's invalid, for example, if it uses an extension that the server doesn't support.
+func (c *Client) PutScript(name, content string) error {
+	if err := c.command(nil, "PUTSCRIPT", encodeString(name), literal(content)); err != nil {
+		return fmt.Errorf("failed to put script: %w", err)
+	}
+	return nil
+}
+
+// SetActive makes the script the active one, which the server runs on delivery. An empty name deactivates all
+// scripts. Returns ErrScriptNotFound if there's no such script.
+func (c *Client) SetActive(name string) error {
+	if err := c.command(nil, "SETACTIVE", encodeString(name)); err != nil {
+		return fmt.Errorf("failed to activate script: %w", err)
+	}
+	return nil
+}
+
+// Logout ends the session and closes the connection.
+func (c *Client) Logout() error {
+	err := c.command(nil, "LOGOUT")
+	if closeErr := c.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Close closes the connection without logging out.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// command sends a command and reads its response. onData gets the lines of data before the OK.
+// NO responses return an *Error, which wraps ErrScriptNotFound for the NONEXISTENT code.
+func (c *Client) command(onData func([]token) error, args ...string) error {
+	if err := c.conn.SetDeadline(time.Now().Add(commandTimeout)); err != nil {
+		return err
+	}
+	if err := c.write(args...); err != nil {
+		return err
+	}
+	return c.readResponse(onData)
+}
+
+// write sends a line with the already encoded arguments.
+func (c *Client) write(args ...string) error {
+	_, err := io.WriteString(c.conn, strings.Join(args, " ")+"\r\n")
+	return err
+}
+
+// readResponse reads lines until the OK, NO, or BYE that ends the response, and passes the others to onData.
+// Without onData, lines of data are an error.
+func (c *Client) readResponse(onData func([]token) error) error {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if len(line) == 0 {
+			continue
+		}
+
+		if first := line[0]; !first.string {
+			switch status := strings.ToUpper(first.value); status {
+			case "OK":
+				return nil
+			case "NO", "BYE":
+				return responseError(line[1:])
+			}
+		}
+
+		if onData == nil {
+			return fmt.Errorf("unexpected response %q", line[0].value)
+		}
+		if err := onData(line); err != nil {
+			return err
+		}
+	}
+}
+
+// responseError returns the error of a NO or BYE response with the given tokens after the status.
+func responseError(rest []token) error {
+	e := &Error{}
+	if len(rest) > 0 && !rest[0].string && strings.HasPrefix(rest[0].value, "(") {
+		e.Code = strings.ToUpper(strings.Trim(rest[0].value, "()"))
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		e.Message = rest[len(rest)-1].value
+	}
+	if e.Code == "NONEXISTENT" {
+		return fmt.Errorf("%w: %w", ErrScriptNotFound, e)
+	}
+	return e
+}
+
+// readLine reads the tokens of a line, with literals that can span several lines.
+// Parenthesized response codes, like "(QUOTA/MAXSIZE)" or `(SASL "...")`, are a single atom.
+func (c *Client) readLine() ([]token, error) {
+	var line []token
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case b == ' ':
+		case b == '\r':
+		case b == '\n':
+			return line, nil
+		case b == '"':
+			value, err := c.readQuoted()
+			if err != nil {
+				return nil, err
+			}
+			line = append(line, token{value: value, string: true})
+		case b == '{':
+			value, err := c.readLiteral()
+			if err != nil {
+				return nil, err
+			}
+			line = append(line, token{value: value, string: true})
+		case b == '(':
+			code, err := c.r.ReadString(')')
+			if err != nil {
+				return nil, err
+			}
+			line = append(line, token{value: "(" + code})
+		default:
+			atom := []byte{b}
+			for {
+				next, err := c.r.Peek(1)
+				if err != nil {
+					return nil, err
+				}
+				if next[0] == ' ' || next[0] == '\r' || next[0] == '\n' {
+					break
+				}
+				atom = append(atom, next[0])
+				_, _ = c.r.ReadByte()
+			}
+			line = append(line, token{value: string(atom)})
+		}
+	}
+}
+
+// readQuoted reads the rest of a quoted string, after the opening quote.
+func (c *Client) readQuoted() (string, error) {
+	var value strings.Builder
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch b {
+		case '"':
+			return value.String(), nil
+		case '\\':
+			if b, err = c.r.ReadByte(); err != nil {
+				return "", err
+			}
+		case '\r', '\n':
+			return "", errors.New("unterminated quoted string")
+		}
+		value.WriteByte(b)
+	}
+}
+
+// readLiteral reads the rest of a literal, like "{5}\r\nhello" after the opening brace.
+func (c *Client) readLiteral() (string, error) {
+	header, err := c.r.ReadString('}')
+	if err != nil {
+		return "", err
+	}
+	length, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSuffix(header, "}"), "+"))
+	if err != nil || length < 0 || length > maxLiteralLength {
+		return "", fmt.Errorf("invalid literal length %q", header)
+	}
+	if crlf, err := c.r.ReadString('\n'); err != nil || strings.TrimRight(crlf, "\r\n") != "" {
+		return "", errors.New("invalid literal")
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(c.r, value); err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// encodeString encodes a string argument: quoted if it can be, and a literal otherwise.
+func encodeString(s string) string {
+	if strings.ContainsAny(s, "\r\n") || len(s) > 1024 {
+		return literal(s)
+	}
+	return quoted(s)
+}
+
+// quoted encodes a string argument as a quoted string. It can't contain line breaks.
+func quoted(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// literal encodes a string argument as a non-synchronizing literal, which the server reads without answering first.
+func literal(s string) string {
+	return "{" + strconv.Itoa(len(s)) + "+}\r\n" + s
+}
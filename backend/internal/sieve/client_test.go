@@ -0,0 +1,170 @@
+package sieve
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/oauth"
+)
+
+// exchange is a command that the fake server expects, and the response it sends back.
+type exchange struct {
+	command  string
+	response string
+}
+
+// newFakeServer returns a client connected to a fake ManageSieve server, which sends the greeting, and then
+// expects the exchanges in order.
+func newFakeServer(t *testing.T, greeting string, exchanges ...exchange) *Client {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		_ = clientConn.Close()
+	})
+
+	go func() {
+		defer func() {
+			_ = serverConn.Close()
+		}()
+		if _, err := io.WriteString(serverConn, greeting); err != nil {
+			return
+		}
+		for _, ex := range exchanges {
+			got := make([]byte, len(ex.command))
+			if _, err := io.ReadFull(serverConn, got); err != nil {
+				return
+			}
+			if string(got) != ex.command {
+				t.Errorf("Expected command %q, got %q", ex.command, got)
+				return
+			}
+			if _, err := io.WriteString(serverConn, ex.response); err != nil {
+				return
+			}
+		}
+	}()
+
+	c := newClient(clientConn)
+	if err := c.readCapabilities(); err != nil {
+		t.Fatalf("readCapabilities failed: %v", err)
+	}
+	return c
+}
+
+const testGreeting = "\"IMPLEMENTATION\" \"Test\"\r\n\"SIEVE\" \"fileinto imap4flags MIME\"\r\n\"SASL\" \"PLAIN\"\r\n\"STARTTLS\"\r\nOK \"Ready\"\r\n"
+
+func TestClient(t *testing.T) {
+	plain := base64.StdEncoding.EncodeToString([]byte("\x00me@example.com\x00secret"))
+	c := newFakeServer(t, testGreeting,
+		exchange{"AUTHENTICATE \"PLAIN\" \"" + plain + "\"\r\n", "OK \"Logged in\"\r\n"},
+		exchange{"LISTSCRIPTS\r\n", "\"vmail-filters\" ACTIVE\r\n\"old\"\r\n{7}\r\nw\"e\"ird\r\nOK\r\n"},
+		exchange{"GETSCRIPT \"old\"\r\n", "{17}\r\nkeep;\r\n# \"quoted\"\r\nOK\r\n"},
+		exchange{"GETSCRIPT \"gone\"\r\n", "NO (NONEXISTENT) \"There is no script by that name\"\r\n"},
+		exchange{"PUTSCRIPT \"new\" {6+}\r\nkeep;\n\r\n", "NO \"line 1: error: unknown command\"\r\n"},
+		exchange{"SETACTIVE \"new \\\"one\\\"\"\r\n", "OK\r\n"},
+		exchange{"LOGOUT\r\n", "OK \"Bye\"\r\n"},
+	)
+
+	if got := c.Extensions(); !slices.Equal(got, []string{"fileinto", "imap4flags", "mime"}) {
+		t.Errorf("Unexpected extensions: %v", got)
+	}
+	if err := c.Authenticate("me@example.com", "secret"); err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+
+	scripts, err := c.ListScripts()
+	if err != nil {
+		t.Fatalf("ListScripts failed: %v", err)
+	}
+	want := []models.SieveScript{{Name: "vmail-filters", Active: true}, {Name: "old"}, {Name: `w"e"ird`}}
+	if !slices.Equal(scripts, want) {
+		t.Errorf("Expected %+v, got %+v", want, scripts)
+	}
+
+	content, err := c.GetScript("old")
+	if err != nil {
+		t.Fatalf("GetScript failed: %v", err)
+	}
+	if content != "keep;\r\n# \"quoted\"" {
+		t.Errorf("Unexpected content: %q", content)
+	}
+
+	if _, err := c.GetScript("gone"); !errors.Is(err, ErrScriptNotFound) {
+		t.Errorf("Expected ErrScriptNotFound, got %v", err)
+	}
+
+	var serverErr *Error
+	if err := c.PutScript("new", "keep;\n"); !errors.As(err, &serverErr) || serverErr.Message != "line 1: error: unknown command" {
+		t.Errorf("Expected the server's error, got %v", err)
+	}
+
+	if err := c.SetActive(`new "one"`); err != nil {
+		t.Errorf("SetActive failed: %v", err)
+	}
+	if err := c.Logout(); err != nil {
+		t.Errorf("Logout failed: %v", err)
+	}
+}
+
+func TestClientAuthenticateFailures(t *testing.T) {
+	t.Run("rejected password", func(t *testing.T) {
+		plain := base64.StdEncoding.EncodeToString([]byte("\x00me@example.com\x00wrong"))
+		c := newFakeServer(t, testGreeting,
+			exchange{"AUTHENTICATE \"PLAIN\" \"" + plain + "\"\r\n", "NO (AUTH-TOO-WEAK) \"Authentication failed\"\r\n"},
+		)
+		if err := c.Authenticate("me@example.com", "wrong"); !errors.Is(err, ErrAuthFailed) {
+			t.Errorf("Expected ErrAuthFailed, got %v", err)
+		}
+	})
+
+	t.Run("rejected access token", func(t *testing.T) {
+		initial := base64.StdEncoding.EncodeToString([]byte("user=me@example.com\x01auth=Bearer expired\x01\x01"))
+		challenge := base64.StdEncoding.EncodeToString([]byte(`{"status":"401"}`))
+		c := newFakeServer(t, testGreeting,
+			exchange{"AUTHENTICATE \"XOAUTH2\" \"" + initial + "\"\r\n", "\"" + challenge + "\"\r\n"},
+			exchange{"\"\"\r\n", "NO \"Invalid token\"\r\n"},
+		)
+		if err := c.Authenticate("me@example.com", oauth.PasswordFromToken("expired")); !errors.Is(err, ErrAuthFailed) {
+			t.Errorf("Expected ErrAuthFailed, got %v", err)
+		}
+	})
+}
+
+func TestServerFromSettings(t *testing.T) {
+	t.Setenv("VMAIL_TEST_MODE", "")
+
+	testCases := []struct {
+		name     string
+		settings models.UserSettings
+		want     Server
+	}{
+		{
+			"uses the IMAP host with STARTTLS",
+			models.UserSettings{IMAPServerHostname: "imap.example.com"},
+			Server{Address: "imap.example.com:4190", UseTLS: true},
+		},
+		{
+			"drops the IMAP port",
+			models.UserSettings{IMAPServerHostname: "imap.example.com:993", IMAPSkipTLSVerify: true},
+			Server{Address: "imap.example.com:4190", UseTLS: true, SkipTLSVerify: true},
+		},
+		{
+			"stays plain for plain IMAP",
+			models.UserSettings{IMAPServerHostname: "localhost", IMAPSecurity: models.IMAPSecurityNone},
+			Server{Address: "localhost:4190"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ServerFromSettings(&tc.settings); got != tc.want {
+				t.Errorf("Expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
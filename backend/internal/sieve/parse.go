@@ -0,0 +1,394 @@
+package sieve
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// ErrUnreadableBlock is returned when the V-Mail block of a script has something that filters can't express, like a
+// test or an action that V-Mail doesn't have.
+var ErrUnreadableBlock = errors.New("the V-Mail block of the Sieve script has rules that filters can't express")
+
+// BlockToFilters parses the V-Mail block of a script back to filters, so that edits made on the server come back to
+// V-Mail. See FindBlock. It reads what FiltersToBlock writes, with any of the conditions and actions that filters
+// have, in any order, and with single "if" commands that flag and file in one. Filing into trashFolder, or into the
+// "\Trash" special-use folder, deletes. The auto-responder is left out, since it's not a filter.
+// The filters come in an order that FiltersToBlock translates to the same behavior: the ones that only flag first,
+// then the others in the order that they file messages in.
+// Returns ErrUnreadableBlock if the block has anything else, for example, a second chain of filing rules, which
+// would file copies of messages.
+func BlockToFilters(block, trashFolder string) ([]*models.Filter, error) {
+	tokens, err := lex(block)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnreadableBlock, err)
+	}
+	p := &parser{tokens: tokens, trashFolder: trashFolder}
+
+	var flagFilters, fileFilters []*models.Filter
+	fileChains := 0
+	for p.peek().kind != tokenEnd {
+		t := p.next()
+		switch {
+		case t.kind == tokenIdentifier && t.text == "require":
+			if err := p.skipToSemicolon(); err != nil {
+				return nil, err
+			}
+		case t.kind == tokenIdentifier && t.text == "vacation":
+			if err := p.skipToSemicolon(); err != nil {
+				return nil, err
+			}
+		case t.kind == tokenIdentifier && t.text == "if":
+			rules, err := p.parseIf()
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case rules[0].vacation && len(rules) == 1:
+			case len(rules) == 1 && !rules[0].files:
+				flagFilters = append(flagFilters, rules[0].filter)
+			default:
+				// Flagging in a branch of a chain only flags the messages that the branch files
+				fileChains++
+				for _, r := range rules {
+					if r.vacation || !r.files || (len(rules) > 1 && hasFlagActions(r.filter)) || fileChains > 1 {
+						return nil, fmt.Errorf("%w: rules that file messages must be branches of one if/elsif chain, "+
+							"and only file them", ErrUnreadableBlock)
+					}
+					fileFilters = append(fileFilters, r.filter)
+				}
+			}
+		default:
+			return nil, p.unexpected(t)
+		}
+	}
+
+	// FiltersToBlock splits the filters that flag and file to two rules, so they come back as one
+	var filters []*models.Filter
+	for _, fileFilter := range fileFilters {
+		i := slices.IndexFunc(flagFilters, func(flagFilter *models.Filter) bool {
+			return !hasFlagActions(fileFilter) && sameConditions(flagFilter, fileFilter) && flagFilter.Name == fileFilter.Name
+		})
+		if i >= 0 {
+			flagFilters[i].MoveTo, flagFilters[i].Delete = fileFilter.MoveTo, fileFilter.Delete
+			fileFilter = flagFilters[i]
+			flagFilters = slices.Delete(flagFilters, i, i+1)
+		}
+		filters = append(filters, fileFilter)
+	}
+	return append(flagFilters, filters...), nil
+}
+
+// rule is a branch of an "if" command: the filter of its test and its actions.
+type rule struct {
+	filter *models.Filter
+	// files is whether the rule moves or deletes messages.
+	files bool
+	// vacation is whether the rule is the auto-responder.
+	vacation bool
+}
+
+// parser parses the tokens of a V-Mail block, see BlockToFilters.
+type parser struct {
+	tokens      []scriptToken
+	pos         int
+	trashFolder string
+}
+
+// parseIf parses an "if" command after "if", with its "elsif" branches.
+func (p *parser) parseIf() ([]rule, error) {
+	var rules []rule
+	for {
+		filter := &models.Filter{}
+		dated, err := p.parseTest(filter)
+		if err != nil {
+			return nil, err
+		}
+		r, err := p.parseBlock(filter)
+		if err != nil {
+			return nil, err
+		}
+		// Only the auto-responder has dates
+		if dated && !r.vacation {
+			return nil, fmt.Errorf("%w: filters can't test dates", ErrUnreadableBlock)
+		}
+		rules = append(rules, r)
+
+		if t := p.peek(); t.kind != tokenIdentifier || t.text != "elsif" {
+			return rules, nil
+		}
+		p.next()
+	}
+}
+
+// parseTest parses a test, and sets the conditions of the filter to it. Returns whether the test checks the date,
+// which the auto-responder does.
+func (p *parser) parseTest(filter *models.Filter) (bool, error) {
+	t := p.next()
+	if t.kind != tokenIdentifier {
+		return false, p.unexpected(t)
+	}
+	switch t.text {
+	case "allof":
+		if err := p.expect("("); err != nil {
+			return false, err
+		}
+		dated := false
+		for {
+			testDated, err := p.parseTest(filter)
+			if err != nil {
+				return false, err
+			}
+			dated = dated || testDated
+			if t := p.next(); t.kind != tokenSpecial || (t.text != "," && t.text != ")") {
+				return false, p.unexpected(t)
+			} else if t.text == ")" {
+				return dated, nil
+			}
+		}
+	case "not":
+		if t := p.next(); t.kind != tokenIdentifier || t.text != "header" {
+			return false, p.unexpected(t)
+		}
+		return false, p.parseHeaderTest(filter, true)
+	case "header":
+		return false, p.parseHeaderTest(filter, false)
+	case "currentdate":
+		for t := p.peek(); t.kind == tokenTag || t.kind == tokenString; t = p.peek() {
+			p.next()
+		}
+		return true, nil
+	}
+	return false, p.unexpected(t)
+}
+
+// parseHeaderTest parses a "header" test after "header", and sets the condition of the filter to it.
+// The test can only be negated if it's the attachment test.
+func (p *parser) parseHeaderTest(filter *models.Filter, negated bool) error {
+	var tags []string
+	for p.peek().kind == tokenTag {
+		t := p.next()
+		if !slices.Contains([]string{":contains", ":mime", ":anychild"}, t.text) {
+			return p.unexpected(t)
+		}
+		tags = append(tags, t.text)
+	}
+	slices.Sort(tags)
+	headers, err := p.parseStringList()
+	if err != nil {
+		return err
+	}
+	for i := range headers {
+		headers[i] = strings.ToLower(headers[i])
+	}
+	slices.Sort(headers)
+	keys, err := p.parseStringList()
+	if err != nil {
+		return err
+	}
+	if len(keys) != 1 || keys[0] == "" {
+		return fmt.Errorf("%w: header tests must have one non-empty key", ErrUnreadableBlock)
+	}
+	key := keys[0]
+
+	var condition *string
+	switch {
+	case slices.Equal(tags, []string{":anychild", ":contains", ":mime"}) && slices.Equal(headers, []string{"content-disposition"}) &&
+		strings.EqualFold(key, "attachment") && filter.HasAttachment == nil:
+		hasAttachment := !negated
+		filter.HasAttachment = &hasAttachment
+		return nil
+	case negated || !slices.Equal(tags, []string{":contains"}):
+	case slices.Equal(headers, []string{"from"}):
+		condition = &filter.From
+	case slices.Equal(headers, []string{"cc", "to"}):
+		condition = &filter.To
+	case slices.Equal(headers, []string{"subject"}):
+		condition = &filter.Subject
+	}
+	if condition == nil || *condition != "" {
+		return fmt.Errorf("%w: filters can't test %s like that", ErrUnreadableBlock, strings.Join(headers, " and "))
+	}
+	*condition = key
+	return nil
+}
+
+// parseBlock parses the block of an "if" branch with the filter of its test, and sets the actions of the filter.
+// The first comment in the block is the name of the filter.
+func (p *parser) parseBlock(filter *models.Filter) (rule, error) {
+	if err := p.expect("{"); err != nil {
+		return rule{}, err
+	}
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenComment {
+		filter.Name = strings.Join(strings.Fields(strings.TrimPrefix(p.tokens[p.pos].text, "#")), " ")
+	}
+
+	r := rule{filter: filter}
+	for {
+		t := p.next()
+		if t.kind == tokenSpecial && t.text == "}" {
+			break
+		}
+		if t.kind != tokenIdentifier {
+			return rule{}, p.unexpected(t)
+		}
+		switch t.text {
+		case "addflag":
+			flags, err := p.parseStringList()
+			if err != nil {
+				return rule{}, err
+			}
+			for _, flag := range flags {
+				switch {
+				case strings.EqualFold(flag, `\Seen`):
+					filter.MarkRead = true
+				case filter.AddLabel == "" && flag != "":
+					filter.AddLabel = flag
+				default:
+					return rule{}, fmt.Errorf("%w: filters add one label", ErrUnreadableBlock)
+				}
+			}
+		case "fileinto":
+			if r.files {
+				return rule{}, fmt.Errorf("%w: filters file messages into one folder", ErrUnreadableBlock)
+			}
+			if err := p.parseFileInto(filter); err != nil {
+				return rule{}, err
+			}
+			r.files = true
+		case "vacation":
+			if err := p.skipToSemicolon(); err != nil {
+				return rule{}, err
+			}
+			r.vacation = true
+			continue
+		default:
+			return rule{}, p.unexpected(t)
+		}
+		if err := p.expect(";"); err != nil {
+			return rule{}, err
+		}
+	}
+
+	if r.vacation && (r.files || hasFlagActions(filter)) {
+		return rule{}, fmt.Errorf("%w: the auto-responder must be in a rule of its own", ErrUnreadableBlock)
+	}
+	if !r.vacation && !r.files && !hasFlagActions(filter) {
+		return rule{}, fmt.Errorf("%w: rules must have an action", ErrUnreadableBlock)
+	}
+	return r, nil
+}
+
+// parseFileInto parses the arguments of a "fileinto" action, and sets the filter to move or delete the messages.
+func (p *parser) parseFileInto(filter *models.Filter) error {
+	toTrash := false
+	if t := p.peek(); t.kind == tokenTag {
+		p.next()
+		if t.text != ":specialuse" {
+			return p.unexpected(t)
+		}
+		specialUse := p.next()
+		if specialUse.kind != tokenString || !strings.EqualFold(specialUse.text, `\Trash`) {
+			return fmt.Errorf("%w: filters only file into special-use folders to delete", ErrUnreadableBlock)
+		}
+		toTrash = true
+	}
+	folder := p.next()
+	if folder.kind != tokenString || folder.text == "" {
+		return p.unexpected(folder)
+	}
+	if toTrash || folder.text == p.trashFolder {
+		filter.Delete = true
+	} else {
+		filter.MoveTo = folder.text
+	}
+	return nil
+}
+
+// parseStringList parses a string, or a list of them in brackets.
+func (p *parser) parseStringList() ([]string, error) {
+	t := p.next()
+	if t.kind == tokenString {
+		return []string{t.text}, nil
+	}
+	if t.kind != tokenSpecial || t.text != "[" {
+		return nil, p.unexpected(t)
+	}
+	var list []string
+	for {
+		s := p.next()
+		if s.kind != tokenString {
+			return nil, p.unexpected(s)
+		}
+		list = append(list, s.text)
+		if t := p.next(); t.kind != tokenSpecial || (t.text != "," && t.text != "]") {
+			return nil, p.unexpected(t)
+		} else if t.text == "]" {
+			return list, nil
+		}
+	}
+}
+
+// skipToSemicolon skips the arguments of a command, with its semicolon.
+func (p *parser) skipToSemicolon() error {
+	for {
+		t := p.next()
+		switch {
+		case t.kind == tokenEnd || (t.kind == tokenSpecial && (t.text == "{" || t.text == "}")):
+			return p.unexpected(t)
+		case t.kind == tokenSpecial && t.text == ";":
+			return nil
+		}
+	}
+}
+
+// expect consumes the next token, which must be the special character.
+func (p *parser) expect(special string) error {
+	if t := p.next(); t.kind != tokenSpecial || t.text != special {
+		return p.unexpected(t)
+	}
+	return nil
+}
+
+// peek returns the next token that isn't a comment, or a tokenEnd one after the last one.
+func (p *parser) peek() scriptToken {
+	for p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenComment {
+		p.pos++
+	}
+	if p.pos == len(p.tokens) {
+		return scriptToken{kind: tokenEnd}
+	}
+	return p.tokens[p.pos]
+}
+
+// next consumes the next token that isn't a comment, see peek.
+func (p *parser) next() scriptToken {
+	t := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return t
+}
+
+// unexpected returns the ErrUnreadableBlock error for a token that the parser didn't expect.
+func (p *parser) unexpected(t scriptToken) error {
+	if t.kind == tokenEnd {
+		return fmt.Errorf("%w: unexpected end of the block", ErrUnreadableBlock)
+	}
+	return fmt.Errorf("%w: unexpected %q at offset %d", ErrUnreadableBlock, t.text, t.start)
+}
+
+// hasFlagActions returns whether the filter marks messages as read or labels them.
+func hasFlagActions(filter *models.Filter) bool {
+	return filter.MarkRead || filter.AddLabel != ""
+}
+
+// sameConditions returns whether the filters match the same messages.
+func sameConditions(a, b *models.Filter) bool {
+	sameAttachment := (a.HasAttachment == nil && b.HasAttachment == nil) ||
+		(a.HasAttachment != nil && b.HasAttachment != nil && *a.HasAttachment == *b.HasAttachment)
+	return a.From == b.From && a.To == b.To && a.Subject == b.Subject && sameAttachment
+}
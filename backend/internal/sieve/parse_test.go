@@ -0,0 +1,135 @@
+package sieve
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+func TestBlockToFilters(t *testing.T) {
+	hasAttachment := true
+	hasNoAttachment := false
+
+	t.Run("reads back what FiltersToBlock writes", func(t *testing.T) {
+		endsAt := time.Date(2025, 8, 15, 0, 0, 0, 0, time.UTC)
+		opts := ScriptOptions{
+			TrashFolder: "Trash",
+			Extensions:  []string{"date", "fileinto", "imap4flags", "mime", "relational", "vacation"},
+			Vacation:    &models.VacationResponder{Enabled: true, Body: "Away", IntervalDays: 7, EndsAt: &endsAt},
+		}
+		// In the order that BlockToFilters returns them: only flagging first, then filing
+		filters := []*models.Filter{
+			{Name: "Invoices", Subject: "invoice", HasAttachment: &hasAttachment, AddLabel: "invoices"},
+			{From: `"Spammer" \ Inc`, HasAttachment: &hasNoAttachment, Delete: true},
+			{Name: "Newsletters", From: "news@example.com", MarkRead: true, MoveTo: "Newsletters"},
+			{To: "team@example.com", Subject: "standup", MoveTo: "Team"},
+		}
+
+		block, err := FiltersToBlock(filters, opts)
+		if err != nil {
+			t.Fatalf("FiltersToBlock failed: %v", err)
+		}
+		got, err := BlockToFilters(block, "Trash")
+		if err != nil {
+			t.Fatalf("BlockToFilters failed: %v", err)
+		}
+		if !reflect.DeepEqual(got, filters) {
+			t.Errorf("Unexpected filters:\n%+v\nwant:\n%+v", got, filters)
+		}
+
+		again, err := FiltersToBlock(got, opts)
+		if err != nil {
+			t.Fatalf("FiltersToBlock failed: %v", err)
+		}
+		if again != block {
+			t.Errorf("Expected the same block again, got:\n%s\nwant:\n%s", again, block)
+		}
+	})
+
+	t.Run("reads rules edited on the server", func(t *testing.T) {
+		block := `# BEGIN vmail filters
+require ["fileinto", "imap4flags", "special-use"];
+
+IF Header :Contains "Subject" "Receipt" {
+    # Receipts, from the shop
+    AddFlag ["\\seen", "receipts"];
+}
+
+if header :contains ["Cc", "To"] "list@example.com" { fileinto :specialuse "\\Trash" "Bin"; }
+elsif header :contains "from" "boss@example.com" {
+    fileinto "Important";
+}
+# END vmail filters
+`
+		got, err := BlockToFilters(block, "Trash")
+		if err != nil {
+			t.Fatalf("BlockToFilters failed: %v", err)
+		}
+		want := []*models.Filter{
+			{Name: "Receipts, from the shop", Subject: "Receipt", MarkRead: true, AddLabel: "receipts"},
+			{To: "list@example.com", Delete: true},
+			{From: "boss@example.com", MoveTo: "Important"},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Unexpected filters:\n%+v\nwant:\n%+v", got, want)
+		}
+	})
+
+	t.Run("reads a rule that flags and files", func(t *testing.T) {
+		block := blockBegin + "\nif header :contains \"from\" \"a\" { addflag \"x\"; fileinto \"A\"; }\n" + blockEnd + "\n"
+		got, err := BlockToFilters(block, "Trash")
+		if err != nil {
+			t.Fatalf("BlockToFilters failed: %v", err)
+		}
+		want := []*models.Filter{{From: "a", AddLabel: "x", MoveTo: "A"}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Unexpected filters:\n%+v\nwant:\n%+v", got, want)
+		}
+	})
+
+	t.Run("rejects what filters can't express", func(t *testing.T) {
+		rules := map[string]string{
+			"another test":          `if anyof (header :contains "from" "a") { fileinto "A"; }`,
+			"another match type":    `if header :is "from" "a" { fileinto "A"; }`,
+			"another header":        `if header :contains "reply-to" "a" { fileinto "A"; }`,
+			"a negated condition":   `if not header :contains "from" "a" { fileinto "A"; }`,
+			"two from conditions":   `if allof (header :contains "from" "a", header :contains "from" "b") { fileinto "A"; }`,
+			"two keys":              `if header :contains "from" ["a", "b"] { fileinto "A"; }`,
+			"an empty key":          `if header :contains "from" "" { fileinto "A"; }`,
+			"a date test":           `if currentdate :value "ge" "date" "2025-01-01" { fileinto "A"; }`,
+			"another action":        `if header :contains "from" "a" { discard; }`,
+			"two labels":            `if header :contains "from" "a" { addflag ["x", "y"]; }`,
+			"two folders":           `if header :contains "from" "a" { fileinto "A"; fileinto "B"; }`,
+			"another special-use":   `if header :contains "from" "a" { fileinto :specialuse "\\Junk" "Spam"; }`,
+			"no action":             `if header :contains "from" "a" { }`,
+			"an else branch":        `if header :contains "from" "a" { fileinto "A"; } else { fileinto "B"; }`,
+			"flags in a chain":      `if header :contains "from" "a" { addflag "x"; fileinto "A"; } elsif header :contains "from" "b" { fileinto "B"; }`,
+			"two chains":            "if header :contains \"from\" \"a\" { fileinto \"A\"; }\nif header :contains \"from\" \"b\" { fileinto \"B\"; }",
+			"flags in the vacation": `if header :contains "from" "a" { addflag "x"; vacation "Away"; }`,
+			"another command":       `stop;`,
+			"a syntax error":        `if header :contains "from" "a { fileinto "A"; }`,
+		}
+		for name, rule := range rules {
+			t.Run(name, func(t *testing.T) {
+				_, err := BlockToFilters(blockBegin+"\n"+rule+"\n"+blockEnd+"\n", "Trash")
+				if !errors.Is(err, ErrUnreadableBlock) {
+					t.Errorf("Expected ErrUnreadableBlock, got %v", err)
+				}
+			})
+		}
+	})
+
+	t.Run("reads an empty block", func(t *testing.T) {
+		block, err := FiltersToBlock(nil, ScriptOptions{})
+		if err != nil {
+			t.Fatalf("FiltersToBlock failed: %v", err)
+		}
+		got, err := BlockToFilters(block, "Trash")
+		if err != nil || len(got) != 0 {
+			t.Errorf("Expected no filters, got %v, %v", got, err)
+		}
+	})
+}
@@ -0,0 +1,212 @@
+package sieve
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// FiltersScriptName is the name of the script that gets the V-Mail block of filters if no script is active.
+const FiltersScriptName = "vmail-filters"
+
+// ErrUnsupportedExtension is returned when a filter or the auto-responder needs a Sieve extension that the server
+// doesn't support.
+var ErrUnsupportedExtension = errors.New("the server doesn't support a Sieve extension that the script needs")
+
+// ScriptOptions are what FiltersToBlock needs to know about the server.
+type ScriptOptions struct {
+	// TrashFolder is where filters that delete messages file them, if the server doesn't support "special-use".
+	TrashFolder string
+	// Extensions are the Sieve extensions that the server supports, see Client.Extensions.
+	Extensions []string
+	// Vacation is the user's auto-responder, which the script runs too if it's enabled. Nil leaves it out.
+	Vacation *models.VacationResponder
+}
+
+// FiltersToBlock translates the filters to the V-Mail block of a Sieve script, with the same behavior as
+// imap.Service's filtering: every filter that matches applies, so messages get all of their flags, deleting wins over
+// moving, and the oldest filter that moves a message decides where to. The filters must be the oldest first, like
+// db.GetFilters returns them. If opts.Vacation is enabled, the block ends with its "vacation" action, which replies to
+// the messages whatever the filters did with them. The block is a script by itself too, and SetBlock puts it in others.
+// Returns ErrUnsupportedExtension if the block needs an extension that the server doesn't support.
+func FiltersToBlock(filters []*models.Filter, opts ScriptOptions) (string, error) {
+	var required []string
+	require := func(extension string) error {
+		if !slices.Contains(opts.Extensions, extension) {
+			return fmt.Errorf("%w: %s", ErrUnsupportedExtension, extension)
+		}
+		if !slices.Contains(required, extension) {
+			required = append(required, extension)
+		}
+		return nil
+	}
+
+	var flagRules, deleteRules, moveRules []string
+	for _, filter := range filters {
+		test, err := filterTest(filter, require)
+		if err != nil {
+			return "", err
+		}
+		comment := ""
+		if filter.Name != "" {
+			comment = "# " + strings.Join(strings.Fields(filter.Name), " ")
+		}
+
+		var flagActions []string
+		if filter.MarkRead {
+			flagActions = append(flagActions, "addflag "+quote(`\Seen`)+";")
+		}
+		if filter.AddLabel != "" {
+			flagActions = append(flagActions, "addflag "+quote(filter.AddLabel)+";")
+		}
+		if len(flagActions) > 0 {
+			if err := require("imap4flags"); err != nil {
+				return "", err
+			}
+			flagRules = append(flagRules, "if "+test+" "+block(comment, flagActions...)+"\n")
+		}
+
+		switch {
+		case filter.Delete:
+			if err := require("fileinto"); err != nil {
+				return "", err
+			}
+			fileinto := "fileinto " + quote(opts.TrashFolder) + ";"
+			if slices.Contains(opts.Extensions, "special-use") {
+				_ = require("special-use")
+				fileinto = "fileinto :specialuse " + quote(`\Trash`) + " " + quote(opts.TrashFolder) + ";"
+			}
+			deleteRules = append(deleteRules, test+" "+block(comment, fileinto))
+		case filter.MoveTo != "":
+			if err := require("fileinto"); err != nil {
+				return "", err
+			}
+			moveRules = append(moveRules, test+" "+block(comment, "fileinto "+quote(filter.MoveTo)+";"))
+		}
+	}
+
+	var vacation string
+	if opts.Vacation != nil && opts.Vacation.Enabled {
+		var err error
+		if vacation, err = vacationCommand(opts.Vacation, require); err != nil {
+			return "", err
+		}
+	}
+
+	var script strings.Builder
+	script.WriteString(blockBegin + "\n")
+	script.WriteString("# V-Mail syncs this block with your filters, so edits here show up in V-Mail too. Keep your own rules outside of it.\n")
+	if len(required) > 0 {
+		slices.Sort(required)
+		quoted := make([]string, len(required))
+		for i, extension := range required {
+			quoted[i] = quote(extension)
+		}
+		script.WriteString("require [" + strings.Join(quoted, ", ") + "];\n")
+	}
+	for _, rule := range flagRules {
+		script.WriteString("\n" + rule)
+	}
+	// Flags first, since fileinto files messages with the flags set so far. Then one chain, so that a message is
+	// filed only once: deleting first, then moving in the order of the filters.
+	if fileRules := append(deleteRules, moveRules...); len(fileRules) > 0 {
+		script.WriteString("\nif " + strings.Join(fileRules, " elsif ") + "\n")
+	}
+	if vacation != "" {
+		script.WriteString("\n" + vacation + "\n")
+	}
+	script.WriteString("\n" + blockEnd + "\n")
+	return script.String(), nil
+}
+
+// vacationCommand returns the auto-responder's "vacation" action, in an "if" of its dates if it has any.
+// It calls require for the extensions that it needs. See RFC 5230.
+func vacationCommand(responder *models.VacationResponder, require func(string) error) (string, error) {
+	if err := require("vacation"); err != nil {
+		return "", err
+	}
+	action := "vacation :days " + strconv.Itoa(responder.IntervalDays)
+	// Without a subject, the server uses the one of the message with "Auto: ", like vacation.Responder
+	if responder.Subject != "" {
+		action += " :subject " + quote(responder.Subject)
+	}
+	action += " " + quote(responder.Body) + ";"
+
+	var tests []string
+	if responder.StartsAt != nil {
+		tests = append(tests, currentDateTest("ge", *responder.StartsAt))
+	}
+	if responder.EndsAt != nil {
+		tests = append(tests, currentDateTest("lt", *responder.EndsAt))
+	}
+	if len(tests) == 0 {
+		return "# Auto-responder\n" + action, nil
+	}
+	for _, extension := range []string{"date", "relational"} {
+		if err := require(extension); err != nil {
+			return "", err
+		}
+	}
+	test := tests[0]
+	if len(tests) > 1 {
+		test = "allof (" + strings.Join(tests, ", ") + ")"
+	}
+	return "if " + test + " " + block("# Auto-responder", action), nil
+}
+
+// currentDateTest returns a test that compares the current time to t, like "ge" for "at or after t".
+// It compares the times in UTC as text, which works, since ISO 8601 times in the same zone sort like text.
+// The current time has a zone suffix after the seconds, which sorts it after t at the same second, so "lt" is exact.
+// See RFC 5260, section 5.
+func currentDateTest(comparison string, t time.Time) string {
+	return "currentdate :zone " + quote("+0000") + " :value " + quote(comparison) + " " + quote("iso8601") + " " +
+		quote(t.UTC().Format("2006-01-02T15:04:05"))
+}
+
+// filterTest returns the Sieve test of the filter's conditions, like `header :contains "subject" "invoice"`.
+// It calls require for the extensions that the test needs.
+func filterTest(filter *models.Filter, require func(string) error) (string, error) {
+	var tests []string
+	if filter.From != "" {
+		tests = append(tests, "header :contains "+quote("from")+" "+quote(filter.From))
+	}
+	if filter.To != "" {
+		tests = append(tests, "header :contains ["+quote("to")+", "+quote("cc")+"] "+quote(filter.To))
+	}
+	if filter.Subject != "" {
+		tests = append(tests, "header :contains "+quote("subject")+" "+quote(filter.Subject))
+	}
+	if filter.HasAttachment != nil {
+		if err := require("mime"); err != nil {
+			return "", err
+		}
+		test := "header :mime :anychild :contains " + quote("content-disposition") + " " + quote("attachment")
+		if !*filter.HasAttachment {
+			test = "not " + test
+		}
+		tests = append(tests, test)
+	}
+
+	if len(tests) == 1 {
+		return tests[0], nil
+	}
+	return "allof (" + strings.Join(tests, ", ") + ")", nil
+}
+
+// block returns a block of commands, which starts with the comment if it's not empty.
+func block(comment string, commands ...string) string {
+	if comment != "" {
+		commands = append([]string{comment}, commands...)
+	}
+	return "{\n    " + strings.Join(commands, "\n    ") + "\n}"
+}
+
+// quote encodes a Sieve quoted string. See RFC 5228, section 2.4.2.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,189 @@
+package sieve
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+func TestFiltersToBlock(t *testing.T) {
+	hasAttachment := true
+	filters := []*models.Filter{
+		{Name: "Invoices", Subject: "invoice", HasAttachment: &hasAttachment, MarkRead: true, AddLabel: "invoices"},
+		{Name: "Newsletters", From: "news@example.com", MoveTo: "Newsletters"},
+		{From: `"Spammer"`, Delete: true},
+		{To: "team@example.com", MoveTo: "Team"},
+	}
+	opts := ScriptOptions{TrashFolder: "Trash", Extensions: []string{"fileinto", "imap4flags", "mime"}}
+
+	script, err := FiltersToBlock(filters, opts)
+	if err != nil {
+		t.Fatalf("FiltersToBlock failed: %v", err)
+	}
+
+	want := `# BEGIN vmail filters
+# V-Mail syncs this block with your filters, so edits here show up in V-Mail too. Keep your own rules outside of it.
+require ["fileinto", "imap4flags", "mime"];
+
+if allof (header :contains "subject" "invoice", header :mime :anychild :contains "content-disposition" "attachment") {
+    # Invoices
+    addflag "\\Seen";
+    addflag "invoices";
+}
+
+if header :contains "from" "\"Spammer\"" {
+    fileinto "Trash";
+} elsif header :contains "from" "news@example.com" {
+    # Newsletters
+    fileinto "Newsletters";
+} elsif header :contains ["to", "cc"] "team@example.com" {
+    fileinto "Team";
+}
+
+# END vmail filters
+`
+	if script != want {
+		t.Errorf("Unexpected script:\n%s\nwant:\n%s", script, want)
+	}
+}
+
+func TestFiltersToBlockWithSpecialUse(t *testing.T) {
+	hasNoAttachment := false
+	filters := []*models.Filter{{From: "spammer@example.com", HasAttachment: &hasNoAttachment, Delete: true}}
+	opts := ScriptOptions{TrashFolder: "Deleted Items", Extensions: []string{"fileinto", "mime", "special-use"}}
+
+	script, err := FiltersToBlock(filters, opts)
+	if err != nil {
+		t.Fatalf("FiltersToBlock failed: %v", err)
+	}
+
+	want := `# BEGIN vmail filters
+# V-Mail syncs this block with your filters, so edits here show up in V-Mail too. Keep your own rules outside of it.
+require ["fileinto", "mime", "special-use"];
+
+if allof (header :contains "from" "spammer@example.com", not header :mime :anychild :contains "content-disposition" "attachment") {
+    fileinto :specialuse "\\Trash" "Deleted Items";
+}
+
+# END vmail filters
+`
+	if script != want {
+		t.Errorf("Unexpected script:\n%s\nwant:\n%s", script, want)
+	}
+}
+
+func TestFiltersToBlockWithoutExtensions(t *testing.T) {
+	testCases := []struct {
+		name       string
+		filter     models.Filter
+		extensions []string
+	}{
+		{"moving needs fileinto", models.Filter{Subject: "x", MoveTo: "Archive"}, []string{"imap4flags", "mime"}},
+		{"deleting needs fileinto", models.Filter{Subject: "x", Delete: true}, []string{"special-use"}},
+		{"labels need imap4flags", models.Filter{Subject: "x", AddLabel: "work"}, []string{"fileinto"}},
+		{"attachments need mime", models.Filter{HasAttachment: new(bool), MarkRead: true}, []string{"imap4flags"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := FiltersToBlock([]*models.Filter{&tc.filter}, ScriptOptions{TrashFolder: "Trash", Extensions: tc.extensions})
+			if !errors.Is(err, ErrUnsupportedExtension) {
+				t.Errorf("Expected ErrUnsupportedExtension, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFiltersToBlockWithoutFilters(t *testing.T) {
+	script, err := FiltersToBlock(nil, ScriptOptions{})
+	if err != nil {
+		t.Fatalf("FiltersToBlock failed: %v", err)
+	}
+	if script != "# BEGIN vmail filters\n# V-Mail syncs this block with your filters, so edits here show up in V-Mail too. Keep your own rules outside of it.\n\n# END vmail filters\n" {
+		t.Errorf("Expected only the comment, got %q", script)
+	}
+}
+
+func TestFiltersToBlockWithVacation(t *testing.T) {
+	filters := []*models.Filter{{From: "news@example.com", MoveTo: "Newsletters"}}
+	startsAt := time.Date(2025, 8, 1, 0, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	endsAt := time.Date(2025, 8, 15, 0, 0, 0, 0, time.UTC)
+	vacation := &models.VacationResponder{
+		Enabled:      true,
+		Subject:      "Out of office",
+		Body:         "I'm back on August 15.\nFor urgent matters, call \"Bob\".",
+		StartsAt:     &startsAt,
+		EndsAt:       &endsAt,
+		IntervalDays: 7,
+	}
+	opts := ScriptOptions{TrashFolder: "Trash", Extensions: []string{"date", "fileinto", "relational", "vacation"}, Vacation: vacation}
+
+	script, err := FiltersToBlock(filters, opts)
+	if err != nil {
+		t.Fatalf("FiltersToBlock failed: %v", err)
+	}
+
+	want := `# BEGIN vmail filters
+# V-Mail syncs this block with your filters, so edits here show up in V-Mail too. Keep your own rules outside of it.
+require ["date", "fileinto", "relational", "vacation"];
+
+if header :contains "from" "news@example.com" {
+    fileinto "Newsletters";
+}
+
+if allof (currentdate :zone "+0000" :value "ge" "iso8601" "2025-07-31T22:00:00", currentdate :zone "+0000" :value "lt" "iso8601" "2025-08-15T00:00:00") {
+    # Auto-responder
+    vacation :days 7 :subject "Out of office" "I'm back on August 15.
+For urgent matters, call \"Bob\".";
+}
+
+# END vmail filters
+`
+	if script != want {
+		t.Errorf("Unexpected script:\n%s\nwant:\n%s", script, want)
+	}
+
+	t.Run("replies all the time without dates", func(t *testing.T) {
+		always := &models.VacationResponder{Enabled: true, Body: "Away", IntervalDays: 3}
+		script, err := FiltersToBlock(nil, ScriptOptions{Extensions: []string{"vacation"}, Vacation: always})
+		if err != nil {
+			t.Fatalf("FiltersToBlock failed: %v", err)
+		}
+		want := `# BEGIN vmail filters
+# V-Mail syncs this block with your filters, so edits here show up in V-Mail too. Keep your own rules outside of it.
+require ["vacation"];
+
+# Auto-responder
+vacation :days 3 "Away";
+
+# END vmail filters
+`
+		if script != want {
+			t.Errorf("Unexpected script:\n%s\nwant:\n%s", script, want)
+		}
+	})
+
+	t.Run("leaves out a disabled responder", func(t *testing.T) {
+		disabled := *vacation
+		disabled.Enabled = false
+		script, err := FiltersToBlock(nil, ScriptOptions{Vacation: &disabled})
+		if err != nil {
+			t.Fatalf("FiltersToBlock failed: %v", err)
+		}
+		if strings.Contains(script, "vacation") {
+			t.Errorf("Expected no vacation action, got:\n%s", script)
+		}
+	})
+
+	t.Run("needs the extensions", func(t *testing.T) {
+		for _, extensions := range [][]string{{"date", "relational"}, {"vacation", "date"}} {
+			_, err := FiltersToBlock(nil, ScriptOptions{Extensions: extensions, Vacation: vacation})
+			if !errors.Is(err, ErrUnsupportedExtension) {
+				t.Errorf("Expected ErrUnsupportedExtension with %v, got %v", extensions, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package smtp
+
+import (
+	"net/mail"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// icsLineLength is the longest content line of iCalendar objects, in bytes, without the CRLF. See RFC 5545, section 3.1.
+const icsLineLength = 75
+
+// BuildCalendarReply builds the iTIP REPLY that answers the invite for the attendee, with the status as its PARTSTAT.
+// The status is one of the models.CalendarStatus constants. See RFC 5546, section 3.2.3.
+func BuildCalendarReply(event *models.CalendarEvent, attendee mail.Address, status string, now time.Time) string {
+	var b strings.Builder
+	writeLine := func(line string) {
+		b.WriteString(foldICSLine(line))
+		b.WriteString("\r\n")
+	}
+
+	writeLine("BEGIN:VCALENDAR")
+	writeLine("PRODID:-//V-Mail//RSVP//EN")
+	writeLine("VERSION:2.0")
+	writeLine("METHOD:REPLY")
+	writeLine("BEGIN:VEVENT")
+	writeLine("UID:" + event.UID)
+	writeLine("DTSTAMP:" + formatICSTime(now, false))
+	if event.AllDay {
+		writeLine("DTSTART;VALUE=DATE:" + formatICSTime(event.StartsAt, true))
+	} else {
+		writeLine("DTSTART:" + formatICSTime(event.StartsAt, false))
+	}
+	if event.EndsAt != nil {
+		if event.AllDay {
+			writeLine("DTEND;VALUE=DATE:" + formatICSTime(*event.EndsAt, true))
+		} else {
+			writeLine("DTEND:" + formatICSTime(*event.EndsAt, false))
+		}
+	}
+	if event.Summary != "" {
+		writeLine("SUMMARY:" + escapeICSText(event.Summary))
+	}
+	if event.Organizer != nil {
+		writeLine("ORGANIZER" + icsNameParam(event.Organizer.Name) + ":mailto:" + event.Organizer.Email)
+	}
+	writeLine("ATTENDEE;PARTSTAT=" + strings.ToUpper(status) + icsNameParam(attendee.Name) + ":mailto:" + attendee.Address)
+	writeLine("END:VEVENT")
+	writeLine("END:VCALENDAR")
+	return b.String()
+}
+
+// formatICSTime formats a UTC DATE-TIME value, or a DATE value if date is true.
+func formatICSTime(t time.Time, date bool) string {
+	if date {
+		return t.UTC().Format("20060102")
+	}
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// icsNameParam returns the CN parameter for the name, or nothing if it's empty. The name is quoted, so that it can
+// have colons and semicolons, and it loses its quotes and line breaks, which quoted values can't have.
+func icsNameParam(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '"' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" {
+		return ""
+	}
+	return `;CN="` + name + `"`
+}
+
+// escapeICSText escapes a TEXT value. See RFC 5545, section 3.3.11.
+func escapeICSText(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(value)
+}
+
+// foldICSLine folds a content line into lines of at most icsLineLength bytes, without splitting UTF-8 characters.
+// Each continuation line starts with a space.
+func foldICSLine(line string) string {
+	var b strings.Builder
+	limit := icsLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// The space counts toward the length of continuation lines
+		limit = icsLineLength - 1
+	}
+	b.WriteString(line)
+	return b.String()
+}
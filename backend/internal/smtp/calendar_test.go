@@ -0,0 +1,77 @@
+package smtp
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+func TestBuildCalendarReply(t *testing.T) {
+	startsAt := time.Date(2025, 3, 4, 9, 0, 0, 0, time.UTC)
+	endsAt := startsAt.Add(time.Hour)
+	event := &models.CalendarEvent{
+		UID:       "meeting@example.com",
+		Method:    "REQUEST",
+		Summary:   "Plans, budget; and a summary long enough that the line has to be folded over two lines",
+		StartsAt:  startsAt,
+		EndsAt:    &endsAt,
+		Organizer: &models.CalendarParticipant{Email: "alice@example.com", Name: "Smith: Alice"},
+	}
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("answers for the attendee", func(t *testing.T) {
+		reply := BuildCalendarReply(event, mail.Address{Name: `Bob "B"`, Address: "bob@example.com"}, models.CalendarStatusAccepted, now)
+
+		for _, line := range []string{
+			"METHOD:REPLY\r\n",
+			"UID:meeting@example.com\r\n",
+			"DTSTAMP:20250301T120000Z\r\n",
+			"DTSTART:20250304T090000Z\r\n",
+			"DTEND:20250304T100000Z\r\n",
+			`ORGANIZER;CN="Smith: Alice":mailto:alice@example.com` + "\r\n",
+			`ATTENDEE;PARTSTAT=ACCEPTED;CN="Bob B":mailto:bob@example.com` + "\r\n",
+		} {
+			if !strings.Contains(reply, line) {
+				t.Errorf("Expected %q in the reply, got:\n%s", line, reply)
+			}
+		}
+
+		unfolded := strings.ReplaceAll(reply, "\r\n ", "")
+		if !strings.Contains(unfolded, `SUMMARY:Plans\, budget\; and a summary long enough`) {
+			t.Errorf("Expected an escaped summary, got:\n%s", unfolded)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(reply, "\r\n"), "\r\n") {
+			if len(line) > icsLineLength {
+				t.Errorf("Line is %d bytes long: %q", len(line), line)
+			}
+		}
+	})
+
+	t.Run("keeps all-day events as dates", func(t *testing.T) {
+		allDay := &models.CalendarEvent{UID: "day", StartsAt: startsAt.Truncate(24 * time.Hour), AllDay: true}
+		reply := BuildCalendarReply(allDay, mail.Address{Address: "bob@example.com"}, models.CalendarStatusDeclined, now)
+		if !strings.Contains(reply, "DTSTART;VALUE=DATE:20250304\r\n") || strings.Contains(reply, "ORGANIZER") {
+			t.Errorf("Expected a date and no organizer, got:\n%s", reply)
+		}
+		if !strings.Contains(reply, "ATTENDEE;PARTSTAT=DECLINED:mailto:bob@example.com\r\n") {
+			t.Errorf("Expected a declining attendee without a name, got:\n%s", reply)
+		}
+	})
+}
+
+func TestFoldICSLine(t *testing.T) {
+	line := "SUMMARY:" + strings.Repeat("é", 80)
+	folded := foldICSLine(line)
+	if strings.ReplaceAll(folded, "\r\n ", "") != line {
+		t.Errorf("Expected unfolding to restore the line, got %q", folded)
+	}
+	for _, part := range strings.Split(folded, "\r\n") {
+		if len(part) > icsLineLength || !utf8.ValidString(part) {
+			t.Errorf("Invalid folded line %q", part)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package smtp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	gosmtp "github.com/emersion/go-smtp"
+	"github.com/vdavid/vmail/backend/internal/oauth"
+)
+
+// dialTimeout limits how long we wait for the SMTP server to accept the connection.
+const dialTimeout = 10 * time.Second
+
+// implicitTLSPort is the submission port that uses TLS from the start. Other ports upgrade with STARTTLS.
+const implicitTLSPort = "465"
+
+// ErrAuthFailed wraps the errors of servers that reject the username or password.
+var ErrAuthFailed = errors.New("failed to authenticate")
+
+// Send connects to the SMTP server, authenticates, and sends the message from the given bare address.
+// server is "host:port". See dial for how it secures the connection. Pool.Send reuses connections instead.
+func Send(server, username, password, from string, msg *BuiltMessage) error {
+	c, err := connect(server, username, password, os.Getenv("VMAIL_TEST_MODE") != "true")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	if err := sendMessage(c, from, msg); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// sendMessage sends the message from the given bare address on an authenticated connection.
+func sendMessage(c *gosmtp.Client, from string, msg *BuiltMessage) error {
+	if err := c.SendMail(from, msg.Recipients, bytes.NewReader(msg.Raw)); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return nil
+}
+
+// Verify connects to the SMTP server, authenticates, and checks that it accepts the given bare address as the sender,
+// without sending anything. Some servers only reject senders that the user doesn't own when the message is sent,
+// so passing doesn't guarantee that sending works.
+func Verify(server, username, password, from string) error {
+	c, err := connect(server, username, password, os.Getenv("VMAIL_TEST_MODE") != "true")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	if err := c.Mail(from, nil); err != nil {
+		return fmt.Errorf("server rejected sender %s: %w", from, err)
+	}
+	if err := c.Reset(); err != nil {
+		return fmt.Errorf("failed to reset: %w", err)
+	}
+
+	return c.Quit()
+}
+
+// CheckLogin connects to the SMTP server and authenticates, without sending anything,
+// so that users can check their settings before saving them. Authentication errors wrap ErrAuthFailed.
+func CheckLogin(server, username, password string) error {
+	c, err := connect(server, username, password, os.Getenv("VMAIL_TEST_MODE") != "true")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	return c.Quit()
+}
+
+// connect dials the SMTP server and authenticates.
+func connect(server, username, password string, useTLS bool) (*gosmtp.Client, error) {
+	c, err := dial(server, useTLS)
+	if err != nil {
+		return nil, err
+	}
+
+	// Test servers may not offer AUTH over a plain connection
+	if ok, _ := c.Extension("AUTH"); ok || useTLS {
+		if err := c.Auth(oauth.AuthClient(username, password)); err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("%w: %w", ErrAuthFailed, err)
+		}
+	}
+	return c, nil
+}
+
+// dial connects to the SMTP server with a timeout. With useTLS, it uses implicit TLS on port 465,
+// and requires STARTTLS on other ports, like 587. Without it, for testing, the connection is plain.
+func dial(server string, useTLS bool) (*gosmtp.Client, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+
+	if useTLS {
+		if host, port, err := net.SplitHostPort(server); err == nil && port != implicitTLSPort {
+			conn, err := dialer.Dial("tcp", server)
+			if err != nil {
+				return nil, fmt.Errorf("failed to dial: %w", err)
+			}
+			c, err := gosmtp.NewClientStartTLS(conn, &tls.Config{ServerName: host})
+			if err != nil {
+				return nil, fmt.Errorf("failed to start TLS: %w", err)
+			}
+			return c, nil
+		}
+
+		conn, err := tls.DialWithDialer(dialer, "tcp", server, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial with TLS: %w", err)
+		}
+		return gosmtp.NewClient(conn), nil
+	}
+
+	// Non-TLS connection for testing
+	conn, err := dialer.Dial("tcp", server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial: %w", err)
+	}
+	return gosmtp.NewClient(conn), nil
+}
@@ -0,0 +1,87 @@
+package smtp
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestSend(t *testing.T) {
+	t.Setenv("VMAIL_TEST_MODE", "true")
+
+	server := testutil.NewTestSMTPServer(t)
+	defer server.Close()
+
+	msg, err := BuildMessage(mail.Address{Address: "me@example.com"}, &models.OutgoingEmail{
+		To:       []string{"alice@example.com"},
+		Bcc:      []string{"secret@example.com"},
+		Subject:  "Hello",
+		TextBody: "Hi there",
+	}, time.Now())
+	if err != nil {
+		t.Fatalf("BuildMessage failed: %v", err)
+	}
+
+	if err := Send(server.Address, server.Username(), server.Password(), "me@example.com", msg); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	messages := server.GetMessages()
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(messages))
+	}
+	if messages[0].From != "me@example.com" {
+		t.Errorf("Expected sender me@example.com, got %s", messages[0].From)
+	}
+	if strings.Join(messages[0].To, ",") != "alice@example.com,secret@example.com" {
+		t.Errorf("Expected recipients alice@example.com and secret@example.com, got %v", messages[0].To)
+	}
+	if !strings.Contains(string(messages[0].Data), "Subject: Hello") {
+		t.Error("Expected the message to have the subject")
+	}
+}
+
+func TestSendFailsWhenServerIsDown(t *testing.T) {
+	t.Setenv("VMAIL_TEST_MODE", "true")
+
+	msg := &BuiltMessage{Raw: []byte("Subject: Hello\r\n\r\nHi"), Recipients: []string{"alice@example.com"}}
+	if err := Send("127.0.0.1:1", "user", "pass", "me@example.com", msg); err == nil {
+		t.Error("Expected an error when the server is unreachable")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	t.Setenv("VMAIL_TEST_MODE", "true")
+
+	server := testutil.NewTestSMTPServer(t)
+	defer server.Close()
+
+	if err := Verify(server.Address, server.Username(), server.Password(), "alias@example.com"); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+	if len(server.GetMessages()) != 0 {
+		t.Error("Expected Verify not to send anything")
+	}
+	if err := Verify("127.0.0.1:1", "user", "pass", "alias@example.com"); err == nil {
+		t.Error("Expected an error when the server is unreachable")
+	}
+}
+
+func TestCheckLogin(t *testing.T) {
+	t.Setenv("VMAIL_TEST_MODE", "true")
+
+	server := testutil.NewTestSMTPServer(t)
+	defer server.Close()
+
+	if err := CheckLogin(server.Address, server.Username(), server.Password()); err != nil {
+		t.Errorf("CheckLogin failed: %v", err)
+	}
+	if err := CheckLogin("127.0.0.1:1", "user", "pass"); err == nil || errors.Is(err, ErrAuthFailed) {
+		t.Errorf("Expected a connection error, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+// Package smtp builds outgoing messages and sends them through the user's SMTP server.
+package smtp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/mime"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// ErrNoRecipients is returned when a message has no To, Cc, or Bcc addresses.
+var ErrNoRecipients = errors.New("at least one recipient is required")
+
+// BuiltMessage is an RFC 5322 message, ready to send.
+type BuiltMessage struct {
+	// Raw is the whole message, including headers. It has no Bcc header.
+	Raw       []byte
+	MessageID string
+	// Recipients are the bare addresses of all To, Cc, and Bcc recipients.
+	Recipients []string
+	Date       time.Time
+}
+
+// ParseAddressList parses addresses like "Name <mailbox@host>" or "mailbox@host".
+func ParseAddressList(addresses []string) ([]mail.Address, error) {
+	parsed := make([]mail.Address, 0, len(addresses))
+	for _, address := range addresses {
+		a, err := mail.ParseAddress(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q", address)
+		}
+		parsed = append(parsed, *a)
+	}
+	return parsed, nil
+}
+
+// BuildMessage builds the message to send from the given address.
+// It has a text and an HTML part if both bodies are set, and the attachments. See mime.Message.
+func BuildMessage(from mail.Address, email *models.OutgoingEmail, date time.Time) (*BuiltMessage, error) {
+	messageID, err := NewMessageID(from.Address)
+	if err != nil {
+		return nil, err
+	}
+	return buildMessage(from, email, date, messageID, true)
+}
+
+// BuildDraft builds a draft for the IMAP Drafts folder. Unlike BuildMessage, it takes the Message-ID,
+// so that every saved version of the draft has the same one, and it allows drafts without recipients.
+func BuildDraft(from mail.Address, email *models.OutgoingEmail, date time.Time, messageID string) (*BuiltMessage, error) {
+	return buildMessage(from, email, date, messageID, false)
+}
+
+func buildMessage(from mail.Address, email *models.OutgoingEmail, date time.Time, messageID string, requireRecipients bool) (*BuiltMessage, error) {
+	to, err := ParseAddressList(email.To)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := ParseAddressList(email.Cc)
+	if err != nil {
+		return nil, err
+	}
+	bcc, err := ParseAddressList(email.Bcc)
+	if err != nil {
+		return nil, err
+	}
+	noRecipients := len(to)+len(cc)+len(bcc) == 0
+	if requireRecipients && noRecipients {
+		return nil, ErrNoRecipients
+	}
+
+	message := &mime.Message{
+		From:           from,
+		To:             to,
+		Cc:             cc,
+		Subject:        email.Subject,
+		Date:           date,
+		MessageID:      messageID,
+		InReplyTo:      email.InReplyTo,
+		References:     strings.Join(email.References, " "),
+		TextBody:       email.TextBody,
+		HTMLBody:       email.HTMLBody,
+		Calendar:       email.Calendar,
+		CalendarMethod: email.CalendarMethod,
+		AutoSubmitted:  email.AutoSubmitted,
+	}
+	if message.References == "" {
+		message.References = email.InReplyTo
+	}
+	for _, attachment := range email.Attachments {
+		message.Attachments = append(message.Attachments, mime.Attachment{
+			Filename:    attachment.Filename,
+			ContentType: attachment.ContentType,
+			ContentID:   attachment.ContentID,
+			Data:        attachment.Data,
+		})
+	}
+	raw, err := message.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build message: %w", err)
+	}
+
+	var recipients []string
+	for _, list := range [][]mail.Address{to, cc, bcc} {
+		for _, a := range list {
+			recipients = append(recipients, a.Address)
+		}
+	}
+
+	return &BuiltMessage{
+		Raw:        raw,
+		MessageID:  messageID,
+		Recipients: recipients,
+		Date:       date,
+	}, nil
+}
+
+// NewMessageID generates a unique Message-ID on the domain of the sender.
+func NewMessageID(fromAddress string) (string, error) {
+	domain := "vmail.local"
+	if at := strings.LastIndex(fromAddress, "@"); at >= 0 && at < len(fromAddress)-1 {
+		domain = fromAddress[at+1:]
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate Message-ID: %w", err)
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(random), domain), nil
+}
@@ -0,0 +1,136 @@
+package smtp
+
+import (
+	"bytes"
+	"errors"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhillyerd/enmime"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+func TestBuildMessage(t *testing.T) {
+	from := mail.Address{Name: "Me", Address: "me@example.com"}
+	date := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	t.Run("builds a message with text, HTML, and attachments", func(t *testing.T) {
+		email := &models.OutgoingEmail{
+			To:       []string{"Alice <alice@example.com>"},
+			Cc:       []string{"bob@example.com"},
+			Bcc:      []string{"secret@example.com"},
+			Subject:  "Hello",
+			TextBody: "Hi there",
+			HTMLBody: "<p>Hi there</p>",
+			Attachments: []models.OutgoingAttachment{
+				{Filename: "notes.txt", ContentType: "text/plain", Data: []byte("some notes")},
+			},
+		}
+
+		msg, err := BuildMessage(from, email, date)
+		if err != nil {
+			t.Fatalf("BuildMessage failed: %v", err)
+		}
+
+		if !strings.HasPrefix(msg.MessageID, "<") || !strings.HasSuffix(msg.MessageID, "@example.com>") {
+			t.Errorf("Expected a Message-ID on the sender's domain, got %s", msg.MessageID)
+		}
+		expectedRecipients := []string{"alice@example.com", "bob@example.com", "secret@example.com"}
+		if strings.Join(msg.Recipients, ",") != strings.Join(expectedRecipients, ",") {
+			t.Errorf("Expected recipients %v, got %v", expectedRecipients, msg.Recipients)
+		}
+
+		envelope, err := enmime.ReadEnvelope(bytes.NewReader(msg.Raw))
+		if err != nil {
+			t.Fatalf("Failed to parse built message: %v", err)
+		}
+		if envelope.GetHeader("Subject") != "Hello" {
+			t.Errorf("Expected subject 'Hello', got %s", envelope.GetHeader("Subject"))
+		}
+		if envelope.GetHeader("Message-Id") != msg.MessageID {
+			t.Errorf("Expected Message-ID header %s, got %s", msg.MessageID, envelope.GetHeader("Message-Id"))
+		}
+		if envelope.GetHeader("Bcc") != "" {
+			t.Error("Expected no Bcc header")
+		}
+		if !strings.Contains(envelope.GetHeader("To"), "alice@example.com") {
+			t.Errorf("Expected To header with alice@example.com, got %s", envelope.GetHeader("To"))
+		}
+		if envelope.Text != "Hi there" {
+			t.Errorf("Expected text body 'Hi there', got %q", envelope.Text)
+		}
+		if !strings.Contains(envelope.HTML, "<p>Hi there</p>") {
+			t.Errorf("Expected HTML body, got %q", envelope.HTML)
+		}
+		if len(envelope.Attachments) != 1 || envelope.Attachments[0].FileName != "notes.txt" {
+			t.Fatalf("Expected the notes.txt attachment, got %d attachments", len(envelope.Attachments))
+		}
+		if string(envelope.Attachments[0].Content) != "some notes" {
+			t.Errorf("Expected attachment content 'some notes', got %q", envelope.Attachments[0].Content)
+		}
+		if envelope.GetHeader("Auto-Submitted") != "" {
+			t.Error("Expected no Auto-Submitted header")
+		}
+	})
+
+	t.Run("marks automatic replies", func(t *testing.T) {
+		email := &models.OutgoingEmail{To: []string{"alice@example.com"}, Subject: "Auto: Hello", TextBody: "I'm away", AutoSubmitted: "auto-replied"}
+		msg, err := BuildMessage(from, email, date)
+		if err != nil {
+			t.Fatalf("BuildMessage failed: %v", err)
+		}
+		envelope, err := enmime.ReadEnvelope(bytes.NewReader(msg.Raw))
+		if err != nil {
+			t.Fatalf("Failed to parse built message: %v", err)
+		}
+		if envelope.GetHeader("Auto-Submitted") != "auto-replied" {
+			t.Errorf("Expected Auto-Submitted 'auto-replied', got %q", envelope.GetHeader("Auto-Submitted"))
+		}
+	})
+
+	t.Run("returns an error for invalid addresses", func(t *testing.T) {
+		_, err := BuildMessage(from, &models.OutgoingEmail{To: []string{"not an address"}}, date)
+		if err == nil {
+			t.Error("Expected an error for an invalid address")
+		}
+	})
+
+	t.Run("returns ErrNoRecipients without recipients", func(t *testing.T) {
+		_, err := BuildMessage(from, &models.OutgoingEmail{Subject: "Nobody"}, date)
+		if !errors.Is(err, ErrNoRecipients) {
+			t.Errorf("Expected ErrNoRecipients, got %v", err)
+		}
+	})
+}
+
+func TestBuildDraft(t *testing.T) {
+	from := mail.Address{Address: "me@example.com"}
+	date := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	msg, err := BuildDraft(from, &models.OutgoingEmail{Subject: "Unfinished", InReplyTo: "<original@example.com>"}, date, "<draft@example.com>")
+	if err != nil {
+		t.Fatalf("Expected drafts without recipients to build, got %v", err)
+	}
+	if msg.MessageID != "<draft@example.com>" {
+		t.Errorf("Expected the given Message-ID, got %s", msg.MessageID)
+	}
+
+	envelope, err := enmime.ReadEnvelope(bytes.NewReader(msg.Raw))
+	if err != nil {
+		t.Fatalf("Failed to parse built draft: %v", err)
+	}
+	if envelope.GetHeader("Message-Id") != "<draft@example.com>" {
+		t.Errorf("Expected Message-ID header <draft@example.com>, got %s", envelope.GetHeader("Message-Id"))
+	}
+	if envelope.GetHeader("In-Reply-To") != "<original@example.com>" {
+		t.Errorf("Expected In-Reply-To <original@example.com>, got %s", envelope.GetHeader("In-Reply-To"))
+	}
+	if envelope.GetHeader("References") != "<original@example.com>" {
+		t.Errorf("Expected References <original@example.com>, got %s", envelope.GetHeader("References"))
+	}
+	if envelope.GetHeader("To") != "" {
+		t.Errorf("Expected no To header, got %s", envelope.GetHeader("To"))
+	}
+}
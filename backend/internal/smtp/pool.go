@@ -0,0 +1,205 @@
+package smtp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	gosmtp "github.com/emersion/go-smtp"
+)
+
+const (
+	// connIdleTimeout is the maximum time a connection can be idle in the pool before being closed.
+	// Servers drop idle SMTP connections after a few minutes (RFC 5321 suggests 5), so we close them before that.
+	connIdleTimeout = 2 * time.Minute
+	// healthCheckThreshold is the idle time after which we check a connection with NOOP before reuse.
+	healthCheckThreshold = 30 * time.Second
+)
+
+// Pool reuses SMTP connections, so that sending many messages doesn't connect, negotiate TLS, and authenticate
+// for each of them. It works like imap.Pool: each user and account has a set of connections, limited by
+// a semaphore, and a background goroutine closes the ones that have been idle for too long.
+//
+// Thread safety: a connection is only used by one caller at a time. Connections wait in the pool while idle.
+type Pool struct {
+	sets           map[string]*connSet // connection key -> connection set. See connKey.
+	mu             sync.Mutex
+	maxConnections int
+	cleanupCtx     context.Context
+	cleanupCancel  context.CancelFunc
+}
+
+// connSet holds the idle connections for one user and account.
+type connSet struct {
+	mu   sync.Mutex
+	idle []*pooledConn
+	// semaphore limits how many connections the set has, idle or in use.
+	semaphore chan struct{}
+}
+
+// pooledConn is an authenticated SMTP connection.
+type pooledConn struct {
+	client   *gosmtp.Client
+	lastUsed time.Time
+}
+
+// NewPool creates a new SMTP connection pool with the default connection limit.
+func NewPool() *Pool {
+	return NewPoolWithMaxConnections(2)
+}
+
+// NewPoolWithMaxConnections creates a new SMTP connection pool with a configurable
+// maximum number of connections per user and account.
+func NewPoolWithMaxConnections(maxConnections int) *Pool {
+	if maxConnections < 1 {
+		maxConnections = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{
+		sets:           make(map[string]*connSet),
+		maxConnections: maxConnections,
+		cleanupCtx:     ctx,
+		cleanupCancel:  cancel,
+	}
+	go p.runCleanup()
+	return p
+}
+
+// connKey returns the key of the user's connections to the account. It has a hash of the password,
+// so that changed credentials, like a refreshed OAuth access token, get new connections.
+func connKey(userID, server, username, password string) string {
+	hash := sha256.Sum256([]byte(password))
+	return strings.Join([]string{userID, server, username, hex.EncodeToString(hash[:])}, "\x00")
+}
+
+// Send sends the message from the given bare address through a pooled connection. See WithClient.
+func (p *Pool) Send(userID, server, username, password, from string, msg *BuiltMessage) error {
+	return p.WithClient(userID, server, username, password, func(c *gosmtp.Client) error {
+		return sendMessage(c, from, msg)
+	})
+}
+
+// WithClient gets an authenticated connection to the server and calls fn with it.
+// It reuses an idle connection if there's one, and checks it with NOOP if it's been idle for a while.
+// If all the connections of the user and account are in use, it waits for one.
+// After fn, the connection goes back to the pool. If fn fails, it resets the transaction first,
+// and closes the connection if that fails too.
+func (p *Pool) WithClient(userID, server, username, password string, fn func(*gosmtp.Client) error) error {
+	set := p.getOrCreateSet(connKey(userID, server, username, password))
+	set.semaphore <- struct{}{}
+	defer func() { <-set.semaphore }()
+
+	conn := set.takeHealthy()
+	if conn == nil {
+		c, err := connect(server, username, password, os.Getenv("VMAIL_TEST_MODE") != "true")
+		if err != nil {
+			return err
+		}
+		conn = &pooledConn{client: c}
+	}
+
+	if err := fn(conn.client); err != nil {
+		if resetErr := conn.client.Reset(); resetErr != nil {
+			_ = conn.client.Close()
+			return err
+		}
+		set.put(conn)
+		return err
+	}
+	set.put(conn)
+	return nil
+}
+
+// getOrCreateSet gets or creates the connection set for the key.
+func (p *Pool) getOrCreateSet(key string) *connSet {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	set, exists := p.sets[key]
+	if !exists {
+		set = &connSet{semaphore: make(chan struct{}, p.maxConnections)}
+		p.sets[key] = set
+	}
+	return set
+}
+
+// takeHealthy takes the most recently used idle connection that still works, or returns nil if there's none.
+// It closes the dead connections it finds.
+func (s *connSet) takeHealthy() *pooledConn {
+	for {
+		s.mu.Lock()
+		if len(s.idle) == 0 {
+			s.mu.Unlock()
+			return nil
+		}
+		conn := s.idle[len(s.idle)-1]
+		s.idle = s.idle[:len(s.idle)-1]
+		s.mu.Unlock()
+
+		if time.Since(conn.lastUsed) <= healthCheckThreshold {
+			return conn
+		}
+		if err := conn.client.Noop(); err == nil {
+			return conn
+		}
+		_ = conn.client.Close()
+	}
+}
+
+// put returns a connection to the idle ones.
+func (s *connSet) put(conn *pooledConn) {
+	conn.lastUsed = time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idle = append(s.idle, conn)
+}
+
+// runCleanup periodically closes idle connections until the pool is closed.
+func (p *Pool) runCleanup() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.cleanupCtx.Done():
+			return
+		case <-ticker.C:
+			p.closeIdleConnections(time.Now().Add(-connIdleTimeout))
+		}
+	}
+}
+
+// closeIdleConnections closes the idle connections last used before the given time,
+// and forgets the sets that have no connections left.
+func (p *Pool) closeIdleConnections(before time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, set := range p.sets {
+		set.mu.Lock()
+		kept := set.idle[:0]
+		for _, conn := range set.idle {
+			if conn.lastUsed.Before(before) {
+				_ = conn.client.Quit()
+				continue
+			}
+			kept = append(kept, conn)
+		}
+		set.idle = kept
+		// Connections in use hold a semaphore slot, so an empty set without slots taken is unused
+		if len(set.idle) == 0 && len(set.semaphore) == 0 {
+			delete(p.sets, key)
+		}
+		set.mu.Unlock()
+	}
+}
+
+// Close closes all idle connections in the pool and stops the cleanup goroutine.
+// Connections in use are closed when they come back, by the next cleanup.
+func (p *Pool) Close() {
+	p.cleanupCancel()
+	p.closeIdleConnections(time.Now().Add(time.Hour))
+}
@@ -0,0 +1,108 @@
+package smtp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	gosmtp "github.com/emersion/go-smtp"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+)
+
+func TestPool(t *testing.T) {
+	t.Setenv("VMAIL_TEST_MODE", "true")
+
+	server := testutil.NewTestSMTPServer(t)
+	defer server.Close()
+
+	// clientOf returns the connection that WithClient hands out
+	clientOf := func(t *testing.T, pool *Pool, password string) *gosmtp.Client {
+		t.Helper()
+		var client *gosmtp.Client
+		err := pool.WithClient("user-1", server.Address, server.Username(), password, func(c *gosmtp.Client) error {
+			client = c
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("WithClient failed: %v", err)
+		}
+		return client
+	}
+
+	t.Run("sends messages over one connection", func(t *testing.T) {
+		pool := NewPool()
+		defer pool.Close()
+		server.ClearMessages()
+
+		msg := &BuiltMessage{Raw: []byte("Subject: Hello\r\n\r\nHi"), Recipients: []string{"alice@example.com"}}
+		for range 2 {
+			if err := pool.Send("user-1", server.Address, server.Username(), server.Password(), "me@example.com", msg); err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
+		}
+
+		if messages := server.GetMessages(); len(messages) != 2 {
+			t.Errorf("Expected 2 messages, got %d", len(messages))
+		}
+		if first, second := clientOf(t, pool, server.Password()), clientOf(t, pool, server.Password()); first != second {
+			t.Error("Expected the connection to be reused")
+		}
+	})
+
+	t.Run("uses new connections for new credentials", func(t *testing.T) {
+		pool := NewPool()
+		defer pool.Close()
+
+		if clientOf(t, pool, server.Password()) == clientOf(t, pool, "refreshed-token") {
+			t.Error("Expected a new connection for a new password")
+		}
+	})
+
+	t.Run("keeps the connection after a failed transaction", func(t *testing.T) {
+		pool := NewPool()
+		defer pool.Close()
+
+		first := clientOf(t, pool, server.Password())
+		errRejected := errors.New("rejected")
+		err := pool.WithClient("user-1", server.Address, server.Username(), server.Password(), func(c *gosmtp.Client) error {
+			if err := c.Mail("me@example.com", nil); err != nil {
+				t.Fatalf("Mail failed: %v", err)
+			}
+			return errRejected
+		})
+		if !errors.Is(err, errRejected) {
+			t.Fatalf("Expected the error of fn, got %v", err)
+		}
+		if clientOf(t, pool, server.Password()) != first {
+			t.Error("Expected the connection to be reused after a reset")
+		}
+	})
+
+	t.Run("replaces dead connections", func(t *testing.T) {
+		pool := NewPool()
+		defer pool.Close()
+
+		first := clientOf(t, pool, server.Password())
+		_ = first.Close()
+		set := pool.getOrCreateSet(connKey("user-1", server.Address, server.Username(), server.Password()))
+		set.idle[0].lastUsed = time.Now().Add(-healthCheckThreshold - time.Second)
+
+		if clientOf(t, pool, server.Password()) == first {
+			t.Error("Expected a new connection instead of the dead one")
+		}
+	})
+
+	t.Run("closes idle connections", func(t *testing.T) {
+		pool := NewPool()
+		defer pool.Close()
+
+		clientOf(t, pool, server.Password())
+		pool.closeIdleConnections(time.Now().Add(time.Second))
+
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		if len(pool.sets) != 0 {
+			t.Errorf("Expected no connection sets, got %d", len(pool.sets))
+		}
+	})
+}
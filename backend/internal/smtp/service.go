@@ -0,0 +1,160 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/crypto"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+// Service sends messages with the user's SMTP settings.
+type Service struct {
+	dbPool    *pgxpool.Pool
+	encryptor *crypto.Encryptor
+	// pool reuses connections, if set. Without it, each message gets a new connection.
+	pool *Pool
+}
+
+// NewService creates a new SMTP service.
+func NewService(dbPool *pgxpool.Pool, encryptor *crypto.Encryptor) *Service {
+	return &Service{
+		dbPool:    dbPool,
+		encryptor: encryptor,
+	}
+}
+
+// SetPool makes the service send through the pool's connections.
+func (s *Service) SetPool(pool *Pool) {
+	s.pool = pool
+}
+
+// ErrVerificationFailed is returned by VerifySendIdentity when the SMTP server doesn't let the user send as the identity.
+var ErrVerificationFailed = errors.New("SMTP verification failed")
+
+// sender is who a message is sent as, and the SMTP server it goes through.
+type sender struct {
+	from     mail.Address
+	server   string
+	username string
+	password string
+	// signature is added to the end of the message, if not empty.
+	signature string
+}
+
+// SendEmail builds the message and sends it through the user's SMTP server.
+// If the email has an IdentityID, it sends as that identity, through the identity's SMTP server if it has one.
+// loginEmail is used as the sender address if the SMTP username isn't an email address.
+// Returns the sent message, so that the caller can save a copy to the Sent folder.
+func (s *Service) SendEmail(ctx context.Context, userID, loginEmail string, email *models.OutgoingEmail) (*BuiltMessage, error) {
+	var identity *models.SendIdentity
+	if email.IdentityID != "" {
+		var err error
+		identity, err = db.GetSendIdentity(ctx, s.dbPool, userID, email.IdentityID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get send identity: %w", err)
+		}
+	}
+
+	snd, err := s.getSender(ctx, userID, loginEmail, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := BuildMessage(snd.from, withSignature(email, snd.signature), time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if s.pool != nil {
+		err = s.pool.Send(userID, snd.server, snd.username, snd.password, snd.from.Address, msg)
+	} else {
+		err = Send(snd.server, snd.username, snd.password, snd.from.Address, msg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// VerifySendIdentity checks that the identity's SMTP server, or the user's if the identity has none,
+// accepts the user's credentials and the identity's address as the sender. See Verify.
+// It always uses a new connection, so that it checks the credentials even if the pool has a connection.
+func (s *Service) VerifySendIdentity(ctx context.Context, userID string, identity *models.SendIdentity) error {
+	snd, err := s.getSender(ctx, userID, "", identity)
+	if err != nil {
+		return err
+	}
+	if err := Verify(snd.server, snd.username, snd.password, snd.from.Address); err != nil {
+		return fmt.Errorf("%w: %w", ErrVerificationFailed, err)
+	}
+	return nil
+}
+
+// getSender returns who to send as, and through which SMTP server. identity can be nil for the user's main address.
+func (s *Service) getSender(ctx context.Context, userID, loginEmail string, identity *models.SendIdentity) (*sender, error) {
+	settings, err := db.GetUserSettings(ctx, s.dbPool, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user settings: %w", err)
+	}
+
+	snd := &sender{
+		from:     SenderAddress(settings.SMTPUsername, loginEmail),
+		server:   settings.SMTPServerHostname,
+		username: settings.SMTPUsername,
+	}
+	encryptedPassword := settings.EncryptedSMTPPassword
+
+	if identity != nil {
+		snd.from = mail.Address{Name: identity.Name, Address: identity.Email}
+		snd.signature = identity.Signature
+		if identity.SMTPServerHostname != "" {
+			snd.server = identity.SMTPServerHostname
+			snd.username = identity.SMTPUsername
+			encryptedPassword = identity.EncryptedSMTPPassword
+		}
+	}
+
+	snd.password, err = s.encryptor.Decrypt(encryptedPassword)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt SMTP password: %w", err)
+	}
+
+	return snd, nil
+}
+
+// withSignature returns a copy of the email with the signature added to the end of its bodies,
+// after the usual "-- " separator. It returns the email itself if the signature is empty.
+func withSignature(email *models.OutgoingEmail, signature string) *models.OutgoingEmail {
+	if signature == "" {
+		return email
+	}
+
+	signed := *email
+	// Like BuildMessage, leave out the text part of HTML-only messages
+	if email.TextBody != "" || email.HTMLBody == "" {
+		signed.TextBody = email.TextBody + "\n\n-- \n" + signature
+	}
+	if email.HTMLBody != "" {
+		escaped := strings.ReplaceAll(html.EscapeString(signature), "\n", "<br>")
+		signed.HTMLBody = email.HTMLBody + "<br><br>-- <br>" + escaped
+	}
+	return &signed
+}
+
+// SenderAddress returns the address that the user sends from:
+// the SMTP username if it's an email address, and the login email otherwise.
+func SenderAddress(smtpUsername, loginEmail string) mail.Address {
+	if strings.Contains(smtpUsername, "@") {
+		return mail.Address{Address: smtpUsername}
+	}
+	return mail.Address{Address: loginEmail}
+}
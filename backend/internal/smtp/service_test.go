@@ -0,0 +1,38 @@
+package smtp
+
+import (
+	"testing"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+func TestWithSignature(t *testing.T) {
+	t.Run("returns the email itself without a signature", func(t *testing.T) {
+		email := &models.OutgoingEmail{TextBody: "Hi"}
+		if withSignature(email, "") != email {
+			t.Error("Expected the same email")
+		}
+	})
+
+	t.Run("adds the signature to both bodies", func(t *testing.T) {
+		email := &models.OutgoingEmail{TextBody: "Hi", HTMLBody: "<p>Hi</p>"}
+		signed := withSignature(email, "Ann <ann@example.com>\nSupport")
+
+		if signed.TextBody != "Hi\n\n-- \nAnn <ann@example.com>\nSupport" {
+			t.Errorf("Unexpected text body: %q", signed.TextBody)
+		}
+		if signed.HTMLBody != "<p>Hi</p><br><br>-- <br>Ann &lt;ann@example.com&gt;<br>Support" {
+			t.Errorf("Unexpected HTML body: %q", signed.HTMLBody)
+		}
+		if email.TextBody != "Hi" {
+			t.Error("Expected the original email to stay untouched")
+		}
+	})
+
+	t.Run("doesn't add a text part to HTML-only messages", func(t *testing.T) {
+		signed := withSignature(&models.OutgoingEmail{HTMLBody: "<p>Hi</p>"}, "Ann")
+		if signed.TextBody != "" {
+			t.Errorf("Expected no text body, got %q", signed.TextBody)
+		}
+	})
+}
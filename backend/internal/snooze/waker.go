@@ -0,0 +1,61 @@
+package snooze
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/logging"
+	ws "github.com/vdavid/vmail/backend/internal/websocket"
+)
+
+// pollInterval is how often the waker looks for snoozes that ended.
+// It's how late a thread can come back to its folders.
+const pollInterval = 15 * time.Second
+
+// Waker brings snoozed threads back to their folders when their snooze ends.
+type Waker struct {
+	pool *pgxpool.Pool
+	hub  *ws.Hub
+}
+
+// NewWaker creates a new snooze waker.
+// The hub is used to tell the user's clients that a thread is back, so they can refetch their lists.
+func NewWaker(pool *pgxpool.Pool, hub *ws.Hub) *Waker {
+	return &Waker{pool: pool, hub: hub}
+}
+
+// Run wakes snoozed threads until the context is cancelled. Run it in a goroutine.
+func (w *Waker) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.WakeDue(ctx, time.Now())
+		}
+	}
+}
+
+// WakeDue wakes the threads whose snooze ended by now, and tells their users' clients about each.
+// Returns how many it woke.
+func (w *Waker) WakeDue(ctx context.Context, now time.Time) int {
+	woken, err := db.WakeDueSnoozes(ctx, w.pool, now)
+	if err != nil {
+		slog.ErrorContext(ctx, "Snooze: Failed to wake snoozed threads", "error", err)
+	}
+
+	for _, snooze := range woken {
+		userCtx := logging.WithUserID(ctx, snooze.UserID)
+		slog.DebugContext(userCtx, "Snooze: Woke thread", "thread_id", snooze.StableThreadID)
+		for _, folder := range snooze.FolderNames {
+			w.hub.Publish(snooze.UserID, ws.Event{Type: ws.EventThreadUnsnoozed, ThreadID: snooze.StableThreadID, Folder: folder})
+		}
+	}
+	return len(woken)
+}
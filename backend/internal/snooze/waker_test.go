@@ -0,0 +1,93 @@
+package snooze
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/testutil"
+	ws "github.com/vdavid/vmail/backend/internal/websocket"
+)
+
+func TestWaker_WakeDue(t *testing.T) {
+	pool := testutil.NewTestDB(t)
+	defer pool.Close()
+
+	ctx := context.Background()
+	userID, err := db.GetOrCreateUser(ctx, pool, "waker-test@example.com")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser failed: %v", err)
+	}
+
+	now := time.Now()
+	thread := &models.Thread{UserID: userID, StableThreadID: "<woken@example.com>", Subject: "Later"}
+	if err := db.SaveThread(ctx, pool, thread); err != nil {
+		t.Fatalf("SaveThread failed: %v", err)
+	}
+	for i, folder := range []string{"INBOX", "Archive"} {
+		msg := &models.Message{ThreadID: thread.ID, UserID: userID, IMAPUID: int64(i + 1), IMAPFolderName: folder, SentAt: &now}
+		if err := db.SaveMessage(ctx, pool, msg); err != nil {
+			t.Fatalf("SaveMessage failed: %v", err)
+		}
+	}
+	until := now.Add(time.Hour)
+	if err := db.SnoozeThread(ctx, pool, userID, thread.ID, until); err != nil {
+		t.Fatalf("SnoozeThread failed: %v", err)
+	}
+
+	hub := ws.NewHub(10)
+	registered := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade failed: %v", err)
+			return
+		}
+		hub.Register(userID, conn)
+		registered <- struct{}{}
+	}))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	<-registered
+
+	waker := NewWaker(pool, hub)
+
+	t.Run("leaves snoozes that haven't ended", func(t *testing.T) {
+		if woken := waker.WakeDue(ctx, now); woken != 0 {
+			t.Errorf("Expected no woken threads, got %d", woken)
+		}
+	})
+
+	t.Run("publishes an event for each folder of a woken thread", func(t *testing.T) {
+		if woken := waker.WakeDue(ctx, until.Add(time.Second)); woken != 1 {
+			t.Fatalf("Expected 1 woken thread, got %d", woken)
+		}
+
+		var folders []string
+		for range 2 {
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			var event ws.Event
+			if err := conn.ReadJSON(&event); err != nil {
+				t.Fatalf("ReadJSON failed: %v", err)
+			}
+			if event.Type != ws.EventThreadUnsnoozed || event.ThreadID != thread.StableThreadID {
+				t.Errorf("Expected a thread_unsnoozed event for the thread, got %+v", event)
+			}
+			folders = append(folders, event.Folder)
+		}
+		slices.Sort(folders)
+		if !slices.Equal(folders, []string{"Archive", "INBOX"}) {
+			t.Errorf("Expected events for Archive and INBOX, got %v", folders)
+		}
+	})
+}
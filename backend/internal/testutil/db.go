@@ -2,11 +2,6 @@ package testutil
 
 import (
 	"context"
-	"fmt"
-	"os"
-	"path/filepath"
-	"sort"
-	"strings"
 	"testing"
 	"time"
 
@@ -14,6 +9,8 @@ import (
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/postgres"
 	"github.com/testcontainers/testcontainers-go/wait"
+	"github.com/vdavid/vmail/backend/internal/migrate"
+	"github.com/vdavid/vmail/backend/migrations"
 )
 
 // NewTestDB creates a new Postgres test container, runs migrations, and returns a connection pool.
@@ -78,80 +75,15 @@ func NewTestDB(t *testing.T) *pgxpool.Pool {
 	return pool
 }
 
-// RunMigrations reads all migration files and executes them in order.
+// RunMigrations applies the embedded migrations, like the server does with VMAIL_RUN_MIGRATIONS.
 // This is exported so it can be used by the E2E test server.
 func RunMigrations(ctx context.Context, pool *pgxpool.Pool) error {
-	// Read all migration files
-	migrations, err := readMigrations()
+	all, err := migrate.Load(migrations.FS)
 	if err != nil {
-		return fmt.Errorf("failed to read migrations: %w", err)
+		return err
 	}
-
-	// Execute migrations in order
-	for _, migration := range migrations {
-		if _, err := pool.Exec(ctx, migration.SQL); err != nil {
-			return fmt.Errorf("failed to execute migration %s: %w", migration.Name, err)
-		}
+	if _, err := migrate.Up(ctx, pool, all); err != nil {
+		return err
 	}
-
 	return nil
 }
-
-type migration struct {
-	Name string
-	SQL  string
-}
-
-// readMigrations reads all .up.sql files from the migrations directory and returns them sorted by filename
-func readMigrations() ([]migration, error) {
-	var migrations []migration
-
-	// Get the "migrations" directory path relative to the backend root
-	// Try multiple possible locations based on where the test is run from
-	possiblePaths := []string{
-		"migrations",          // From the backend directory
-		"backend/migrations",  // From the project root
-		"../migrations",       // From internal/testutil
-		"../../migrations",    // From internal/testutil
-		"../../../migrations", // From deeper packages
-	}
-
-	var migrationsDir string
-	for _, path := range possiblePaths {
-		if _, err := os.Stat(path); err == nil {
-			migrationsDir = path
-			break
-		}
-	}
-
-	if migrationsDir == "" {
-		return nil, fmt.Errorf("migrations directory not found. Tried: %v", possiblePaths)
-	}
-
-	entries, err := os.ReadDir(migrationsDir)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read migrations directory: %w", err)
-	}
-
-	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".up.sql") {
-			path := filepath.Join(migrationsDir, entry.Name())
-			content, err := os.ReadFile(path)
-			if err != nil {
-				return nil, fmt.Errorf("failed to read migration file %s: %w", path, err)
-			}
-
-			migrations = append(migrations, migration{
-				Name: entry.Name(),
-				SQL:  string(content),
-			})
-		}
-	}
-
-	// Sort migrations by filename to ensure the correct order
-	sort.Slice(migrations, func(i, j int) bool {
-		return migrations[i].Name < migrations[j].Name
-	})
-
-	return migrations, nil
-}
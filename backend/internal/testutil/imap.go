@@ -130,6 +130,21 @@ func (m *specialUseMailbox) Info() (*imap.MailboxInfo, error) {
 	return info, nil
 }
 
+// Ensure specialUseMailbox implements backend.MoveMailbox interface
+var _ backend.MoveMailbox = (*specialUseMailbox)(nil)
+
+// MoveMessages implements MOVE, which the server advertises, but the memory backend doesn't support.
+// It copies the messages, and then deletes them, so it also expunges other messages marked as deleted.
+func (m *specialUseMailbox) MoveMessages(uid bool, seqSet *imap.SeqSet, dest string) error {
+	if err := m.Mailbox.CopyMessages(uid, seqSet, dest); err != nil {
+		return err
+	}
+	if err := m.Mailbox.UpdateMessagesFlags(uid, seqSet, imap.AddFlags, []string{imap.DeletedFlag}); err != nil {
+		return err
+	}
+	return m.Mailbox.Expunge()
+}
+
 // TestIMAPServer represents a test IMAP server instance.
 type TestIMAPServer struct {
 	Server   *server.Server
@@ -150,11 +165,18 @@ func NewTestIMAPServer(t *testing.T) *TestIMAPServer {
 	t.Helper()
 
 	// Create an in-memory backend
-	be := memory.New()
+	memoryBackend := memory.New()
+
+	// Wrap it with SPECIAL-USE and MOVE support, like the E2E server
+	be := &specialUseBackend{
+		Backend:       memoryBackend,
+		memoryBackend: memoryBackend,
+	}
 
 	// Create server
 	s := server.New(be)
 	s.AllowInsecureAuth = true
+	s.Enable(&specialUseExtension{})
 
 	// Start server on random port
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
@@ -165,7 +187,9 @@ func NewTestIMAPServer(t *testing.T) *TestIMAPServer {
 	addr := listener.Addr().String()
 
 	// Start server in goroutine
+	served := make(chan struct{})
 	go func() {
+		defer close(served)
 		if err := s.Serve(listener); err != nil {
 			t.Logf("IMAP server error: %v", err)
 		}
@@ -180,6 +204,8 @@ func NewTestIMAPServer(t *testing.T) *TestIMAPServer {
 			t.Logf("Failed to close IMAP server: %v", err)
 			return
 		}
+		// Serve logs its error when it stops, which it must do before the test ends
+		<-served
 	}
 
 	// Memory backend creates a default user with these credentials
@@ -189,7 +215,7 @@ func NewTestIMAPServer(t *testing.T) *TestIMAPServer {
 	return &TestIMAPServer{
 		Server:   s,
 		Address:  addr,
-		Backend:  be,
+		Backend:  memoryBackend,
 		cleanup:  cleanup,
 		username: username,
 		password: password,
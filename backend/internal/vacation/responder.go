@@ -0,0 +1,163 @@
+// Package vacation replies to new mail while users are away, for mail servers that can't do it with Sieve.
+package vacation
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vdavid/vmail/backend/internal/db"
+	"github.com/vdavid/vmail/backend/internal/models"
+	"github.com/vdavid/vmail/backend/internal/smtp"
+)
+
+// maxMessageAge is how old a message can be to get a reply. Syncs also see old messages that the user moves back
+// to INBOX, which are new to INBOX, but not new mail.
+const maxMessageAge = 24 * time.Hour
+
+// MailSender sends messages through the user's SMTP server. smtp.Service implements it.
+type MailSender interface {
+	SendEmail(ctx context.Context, userID, loginEmail string, email *models.OutgoingEmail) (*smtp.BuiltMessage, error)
+}
+
+// Responder sends the replies of the auto-responders in models.VacationModeBackend.
+// It gets the new INBOX messages of syncs, see imap.Service.SetAutoResponder.
+type Responder struct {
+	pool   *pgxpool.Pool
+	sender MailSender
+}
+
+// NewResponder creates a new Responder.
+func NewResponder(pool *pgxpool.Pool, sender MailSender) *Responder {
+	return &Responder{
+		pool:   pool,
+		sender: sender,
+	}
+}
+
+// RespondToNewMail replies to the messages if the user's auto-responder is on, and the backend sends its replies.
+// Each sender gets at most one reply per the responder's interval. Errors are logged, since nobody waits for this.
+// Replies that fail to send are forgotten, so that the sender's next message gets one.
+func (r *Responder) RespondToNewMail(ctx context.Context, userID string, messages []models.AutoReplyMessage) {
+	responder, err := db.GetVacationResponder(ctx, r.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Vacation: Failed to get the auto-responder", "error", err)
+		return
+	}
+	now := time.Now()
+	if responder.Mode != models.VacationModeBackend || !isActive(responder, now) {
+		return
+	}
+
+	loginEmail, err := db.GetUserEmail(ctx, r.pool, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Vacation: Failed to get the user's email", "error", err)
+		return
+	}
+	ownAddresses, err := r.getOwnAddresses(ctx, userID, loginEmail)
+	if err != nil {
+		slog.ErrorContext(ctx, "Vacation: Failed to get the user's addresses", "error", err)
+		return
+	}
+
+	for _, message := range messages {
+		if !shouldReply(responder, message, ownAddresses, now) {
+			continue
+		}
+		claimed, err := db.ClaimVacationReply(ctx, r.pool, userID, message.FromAddress, now, responder.IntervalDays)
+		if err != nil {
+			slog.ErrorContext(ctx, "Vacation: Failed to claim the reply", "error", err)
+			return
+		}
+		if !claimed {
+			continue
+		}
+		if _, err := r.sender.SendEmail(ctx, userID, loginEmail, buildReply(responder, message)); err != nil {
+			slog.WarnContext(ctx, "Vacation: Failed to send the reply", "error", err)
+			if err := db.DeleteVacationReply(ctx, r.pool, userID, message.FromAddress); err != nil {
+				slog.ErrorContext(ctx, "Vacation: Failed to forget the failed reply", "error", err)
+			}
+		}
+	}
+}
+
+// getOwnAddresses returns the lowercase addresses that the user sends from, which never get replies.
+func (r *Responder) getOwnAddresses(ctx context.Context, userID, loginEmail string) ([]string, error) {
+	addresses := []string{strings.ToLower(loginEmail)}
+	settings, err := db.GetUserSettings(ctx, r.pool, userID)
+	if err != nil {
+		return nil, err
+	}
+	addresses = append(addresses, strings.ToLower(smtp.SenderAddress(settings.SMTPUsername, loginEmail).Address))
+
+	identities, err := db.GetSendIdentities(ctx, r.pool, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, identity := range identities {
+		addresses = append(addresses, strings.ToLower(identity.Email))
+	}
+	return addresses, nil
+}
+
+// isActive returns whether the auto-responder replies at the time.
+func isActive(responder *models.VacationResponder, t time.Time) bool {
+	if !responder.Enabled {
+		return false
+	}
+	if responder.StartsAt != nil && t.Before(*responder.StartsAt) {
+		return false
+	}
+	return responder.EndsAt == nil || t.Before(*responder.EndsAt)
+}
+
+// shouldReply returns whether the message gets a reply, if its sender didn't get one lately.
+// The user's own messages don't, and neither do messages from automatic senders like "noreply@", or messages
+// sent before the auto-responder started or over maxMessageAge ago. Messages without a date might be new, so they do.
+func shouldReply(responder *models.VacationResponder, message models.AutoReplyMessage, ownAddresses []string, now time.Time) bool {
+	address := strings.ToLower(message.FromAddress)
+	if slices.Contains(ownAddresses, address) || isAutomatedSender(address) {
+		return false
+	}
+	if message.SentAt.IsZero() {
+		return true
+	}
+	if responder.StartsAt != nil && message.SentAt.Before(*responder.StartsAt) {
+		return false
+	}
+	return message.SentAt.After(now.Add(-maxMessageAge))
+}
+
+// isAutomatedSender returns whether the lowercase address belongs to a program rather than a person, like
+// "mailer-daemon@" or the "-request" address of a mailing list. See RFC 3834, section 2.
+func isAutomatedSender(address string) bool {
+	localPart, _, _ := strings.Cut(address, "@")
+	switch localPart {
+	case "mailer-daemon", "postmaster", "noreply", "no-reply", "donotreply", "do-not-reply":
+		return true
+	}
+	return strings.HasPrefix(localPart, "owner-") || strings.HasSuffix(localPart, "-request")
+}
+
+// buildReply builds the automatic reply to the message. Without a subject, the responder uses "Auto: " and the
+// subject of the message, like the Sieve "vacation" extension. See RFC 5230, section 4.4.
+func buildReply(responder *models.VacationResponder, message models.AutoReplyMessage) *models.OutgoingEmail {
+	subject := responder.Subject
+	if subject == "" {
+		subject = "Auto: " + message.Subject
+	}
+	reply := &models.OutgoingEmail{
+		To:            []string{message.FromAddress},
+		Subject:       subject,
+		TextBody:      responder.Body,
+		InReplyTo:     message.MessageID,
+		AutoSubmitted: "auto-replied",
+	}
+	if message.MessageID != "" {
+		reply.References = append(slices.Clone(message.References), message.MessageID)
+	}
+	return reply
+}
@@ -0,0 +1,106 @@
+package vacation
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/vdavid/vmail/backend/internal/models"
+)
+
+func TestIsActive(t *testing.T) {
+	now := time.Date(2025, 8, 5, 12, 0, 0, 0, time.UTC)
+	before := now.Add(-time.Hour)
+	after := now.Add(time.Hour)
+
+	tests := []struct {
+		name      string
+		responder models.VacationResponder
+		want      bool
+	}{
+		{"disabled", models.VacationResponder{}, false},
+		{"enabled without dates", models.VacationResponder{Enabled: true}, true},
+		{"within the dates", models.VacationResponder{Enabled: true, StartsAt: &before, EndsAt: &after}, true},
+		{"not started yet", models.VacationResponder{Enabled: true, StartsAt: &after}, false},
+		{"ended", models.VacationResponder{Enabled: true, EndsAt: &before}, false},
+		{"ends right now", models.VacationResponder{Enabled: true, EndsAt: &now}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isActive(&tt.responder, now); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestShouldReply(t *testing.T) {
+	now := time.Date(2025, 8, 5, 12, 0, 0, 0, time.UTC)
+	startsAt := now.Add(-2 * time.Hour)
+	responder := &models.VacationResponder{Enabled: true, StartsAt: &startsAt}
+	own := []string{"me@example.com"}
+
+	tests := []struct {
+		name    string
+		message models.AutoReplyMessage
+		want    bool
+	}{
+		{"new message", models.AutoReplyMessage{FromAddress: "alice@example.com", SentAt: now.Add(-time.Hour)}, true},
+		{"message without a date", models.AutoReplyMessage{FromAddress: "alice@example.com"}, true},
+		{"own message", models.AutoReplyMessage{FromAddress: "Me@Example.com", SentAt: now}, false},
+		{"message from before the start", models.AutoReplyMessage{FromAddress: "alice@example.com", SentAt: startsAt.Add(-time.Minute)}, false},
+		{"no-reply sender", models.AutoReplyMessage{FromAddress: "noreply@shop.example.com", SentAt: now}, false},
+		{"mailer daemon", models.AutoReplyMessage{FromAddress: "MAILER-DAEMON@example.com", SentAt: now}, false},
+		{"list owner", models.AutoReplyMessage{FromAddress: "owner-news@example.com", SentAt: now}, false},
+		{"list request address", models.AutoReplyMessage{FromAddress: "news-request@example.com", SentAt: now}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shouldReply(responder, tt.message, own, now); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("old message moved back to INBOX", func(t *testing.T) {
+		message := models.AutoReplyMessage{FromAddress: "alice@example.com", SentAt: now.Add(-maxMessageAge - time.Minute)}
+		if shouldReply(&models.VacationResponder{Enabled: true}, message, own, now) {
+			t.Error("Expected no reply to an old message")
+		}
+	})
+}
+
+func TestBuildReply(t *testing.T) {
+	message := models.AutoReplyMessage{
+		FromAddress: "alice@example.com",
+		Subject:     "Lunch?",
+		MessageID:   "<lunch@example.com>",
+		References:  []string{"<plans@example.com>"},
+	}
+
+	t.Run("replies in the thread, marked as automatic", func(t *testing.T) {
+		reply := buildReply(&models.VacationResponder{Subject: "Out of office", Body: "Back on Monday."}, message)
+		if !slices.Equal(reply.To, []string{"alice@example.com"}) || reply.Subject != "Out of office" || reply.TextBody != "Back on Monday." {
+			t.Errorf("Unexpected reply: %+v", reply)
+		}
+		if reply.InReplyTo != "<lunch@example.com>" || !slices.Equal(reply.References, []string{"<plans@example.com>", "<lunch@example.com>"}) {
+			t.Errorf("Expected the reply to be in the thread, got %q and %v", reply.InReplyTo, reply.References)
+		}
+		if reply.AutoSubmitted != "auto-replied" {
+			t.Errorf("Expected Auto-Submitted 'auto-replied', got %q", reply.AutoSubmitted)
+		}
+	})
+
+	t.Run("uses the subject of the message without a subject", func(t *testing.T) {
+		if reply := buildReply(&models.VacationResponder{Body: "Away"}, message); reply.Subject != "Auto: Lunch?" {
+			t.Errorf("Expected 'Auto: Lunch?', got %q", reply.Subject)
+		}
+	})
+
+	t.Run("has no references without a Message-ID", func(t *testing.T) {
+		reply := buildReply(&models.VacationResponder{Body: "Away"}, models.AutoReplyMessage{FromAddress: "bob@example.com"})
+		if reply.InReplyTo != "" || len(reply.References) != 0 {
+			t.Errorf("Expected no references, got %q and %v", reply.InReplyTo, reply.References)
+		}
+	})
+}
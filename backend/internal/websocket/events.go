@@ -0,0 +1,68 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log/slog"
+)
+
+// The types of the events we send to clients. Clients should ignore types they don't know.
+const (
+	// EventNewMessage means that a sync cached new messages in Folder. Count is how many.
+	EventNewMessage = "new_message"
+	// EventThreadUpdated means that the messages of a thread changed, for example, because the user moved them.
+	// ThreadID is its stable ID, and Folder is where the messages are now.
+	EventThreadUpdated = "thread_updated"
+	// EventThreadUnsnoozed means that the snooze of a thread ended, so it's back in Folder. ThreadID is its stable ID.
+	// A thread in several folders gets an event for each.
+	EventThreadUnsnoozed = "thread_unsnoozed"
+	// EventFlagsChanged means that the read or starred flags of the messages with UIDs in Folder changed on the server.
+	EventFlagsChanged = "flags_changed"
+	// EventMessageDeleted means that the messages with UIDs were expunged from Folder on the server.
+	EventMessageDeleted = "message_deleted"
+	// EventSyncStarted and EventSyncFinished bracket a sync of Folder. EventSyncFinished has Error if the sync failed.
+	// They have JobID, like EventSyncProgress, if the sync runs as a job.
+	EventSyncStarted  = "sync_started"
+	EventSyncFinished = "sync_finished"
+	// EventSyncProgress means that a sync of Folder fetched the headers of Count of the Total messages it syncs.
+	EventSyncProgress = "sync_progress"
+	// EventSyncComplete means that a sync that outlasted a threads request has finished, so the client can refetch
+	// Folder. It's sent after failed syncs too.
+	EventSyncComplete = "sync_complete"
+	// EventFoldersChanged means that the user created, renamed, deleted, or (un)subscribed to Folder,
+	// so clients should refetch the folder list.
+	EventFoldersChanged = "folders_changed"
+	// EventMessageSent means that the outbox sent the message with ID. MessageID is its Message-ID header.
+	EventMessageSent = "message_sent"
+	// EventSendFailed means that the outbox gave up on the message with ID, after it failed with Error too many times.
+	EventSendFailed = "send_failed"
+)
+
+// Event is a message we send to the clients of a user when something changes, so they can update incrementally.
+// Only the fields that the event type describes are set.
+type Event struct {
+	Type     string  `json:"type"`
+	Folder   string  `json:"folder,omitempty"`
+	ThreadID string  `json:"thread_id,omitempty"`
+	UIDs     []int64 `json:"uids,omitempty"`
+	Count    int     `json:"count,omitempty"`
+	Total    int     `json:"total,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	JobID    string  `json:"job_id,omitempty"`
+	// ID is the outbox ID of a message, and MessageID its Message-ID header.
+	ID        string `json:"id,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// Publish sends an event to all active clients of the user. It does nothing if the hub is nil,
+// so services can publish without checking whether they have a hub.
+func (h *Hub) Publish(userID string, event Event) {
+	if h == nil {
+		return
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("websocket: Failed to marshal event", "type", event.Type, "error", err)
+		return
+	}
+	h.Send(userID, payload)
+}
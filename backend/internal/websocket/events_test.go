@@ -0,0 +1,53 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHubPublish(t *testing.T) {
+	t.Run("does nothing without a hub", func(t *testing.T) {
+		var hub *Hub
+		hub.Publish("user-1", Event{Type: EventSyncStarted, Folder: "INBOX"})
+	})
+
+	t.Run("sends the event as JSON to the user's clients", func(t *testing.T) {
+		hub := NewHub(10)
+		registered := make(chan struct{})
+		upgrader := websocket.Upgrader{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				t.Errorf("Failed to upgrade: %v", err)
+				return
+			}
+			hub.Register("user-1", conn)
+			close(registered)
+		}))
+		defer server.Close()
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer func() { _ = conn.Close() }()
+		<-registered
+
+		hub.Publish("user-2", Event{Type: EventNewMessage, Folder: "INBOX", Count: 1})
+		hub.Publish("user-1", Event{Type: EventFlagsChanged, Folder: "INBOX", UIDs: []int64{3, 5}})
+
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed to read message: %v", err)
+		}
+		expected := `{"type":"flags_changed","folder":"INBOX","uids":[3,5]}`
+		if string(message) != expected {
+			t.Errorf("Expected %s, got %s", expected, message)
+		}
+	})
+}
@@ -1,7 +1,7 @@
 package websocket
 
 import (
-	"log"
+	"log/slog"
 	"sync"
 	"time"
 
@@ -50,7 +50,7 @@ func (h *Hub) Register(userID string, conn *websocket.Conn) *Client {
 	}
 
 	if len(userClients) >= h.maxPerUser {
-		log.Printf("websocket: user %s exceeded max connections (%d), closing new connection", userID, h.maxPerUser)
+		slog.Warn("websocket: User exceeded max connections, closing new connection", "user_id", userID, "max", h.maxPerUser)
 		_ = conn.WriteControl(
 			websocket.CloseMessage,
 			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too many connections for this user"),
@@ -105,7 +105,7 @@ func (h *Hub) Send(userID string, msg []byte) {
 
 	for client := range userClients {
 		if err := client.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-			log.Printf("websocket: failed to write message for user %s: %v", userID, err)
+			slog.Warn("websocket: Failed to write message", "user_id", userID, "error", err)
 			// Best-effort cleanup: unregister this client.
 			go h.Unregister(userID, client)
 		}
@@ -119,3 +119,22 @@ func (h *Hub) ActiveConnections(userID string) int {
 
 	return len(h.clients[userID])
 }
+
+// CloseUser closes all connections of the user, with a close message that tells the clients why, for example,
+// because the account is gone. Returns how many connections it closed.
+func (h *Hub) CloseUser(userID, reason string) int {
+	h.mu.Lock()
+	userClients := h.clients[userID]
+	delete(h.clients, userID)
+	h.mu.Unlock()
+
+	for client := range userClients {
+		_ = client.conn.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason),
+			time.Now().Add(time.Second),
+		)
+		_ = client.conn.Close()
+	}
+	return len(userClients)
+}
@@ -0,0 +1,53 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHub_CloseUser(t *testing.T) {
+	hub := NewHub(10)
+	registered := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade failed: %v", err)
+			return
+		}
+		hub.Register(r.URL.Query().Get("user"), conn)
+		registered <- struct{}{}
+	}))
+	defer server.Close()
+
+	connect := func(userID string) *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:]+"?user="+userID, nil)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		<-registered
+		return conn
+	}
+	first, second, other := connect("user-1"), connect("user-1"), connect("user-2")
+	defer func() { _ = other.Close() }()
+
+	if closed := hub.CloseUser("user-1", "account deleted"); closed != 2 {
+		t.Errorf("Expected 2 closed connections, got %d", closed)
+	}
+
+	for _, conn := range []*websocket.Conn{first, second} {
+		_, _, err := conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseNormalClosure || closeErr.Text != "account deleted" {
+			t.Errorf("Expected a normal close with the reason, got %v", err)
+		}
+	}
+	if hub.ActiveConnections("user-1") != 0 || hub.ActiveConnections("user-2") != 1 {
+		t.Errorf("Expected only the other user's connection, got %d and %d",
+			hub.ActiveConnections("user-1"), hub.ActiveConnections("user-2"))
+	}
+}
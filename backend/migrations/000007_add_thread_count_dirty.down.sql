@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_folder_sync_timestamps_thread_count_dirty;
+
+ALTER TABLE "folder_sync_timestamps"
+DROP COLUMN IF EXISTS "thread_count_dirty";
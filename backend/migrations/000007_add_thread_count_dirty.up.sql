@@ -0,0 +1,10 @@
+-- Track folders whose materialized thread count is out of date
+ALTER TABLE "folder_sync_timestamps"
+ADD COLUMN "thread_count_dirty" BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN "folder_sync_timestamps"."thread_count_dirty" IS 'True if messages in this folder changed since "thread_count" was last calculated. The background thread count updater recalculates dirty folders and clears the flag.';
+
+-- Partial index so the updater can find dirty folders without scanning the whole table
+CREATE INDEX idx_folder_sync_timestamps_thread_count_dirty
+ON "folder_sync_timestamps" ("user_id", "folder_name")
+WHERE "thread_count_dirty";
@@ -0,0 +1,12 @@
+-- Move preferences back to "user_settings" and drop the "user_preferences" table.
+ALTER TABLE "user_settings"
+    ADD COLUMN "undo_send_delay_seconds" INT NOT NULL DEFAULT 20,
+    ADD COLUMN "pagination_threads_per_page" INT NOT NULL DEFAULT 100;
+
+UPDATE "user_settings" s
+SET "undo_send_delay_seconds"     = p."undo_send_delay_seconds",
+    "pagination_threads_per_page" = p."pagination_threads_per_page"
+FROM "user_preferences" p
+WHERE p."user_id" = s."user_id";
+
+DROP TABLE IF EXISTS "user_preferences";
@@ -0,0 +1,31 @@
+-- Stores the user's UI preferences.
+-- This is a 1:1 relationship with the "users" table.
+-- We keep it separate from "user_settings" so that UI tweaks never touch the code that handles credentials.
+CREATE TABLE "user_preferences"
+(
+    "user_id"                     UUID PRIMARY KEY REFERENCES "users" ("id") ON DELETE CASCADE,
+
+    "undo_send_delay_seconds"     INT         NOT NULL DEFAULT 20,
+    "pagination_threads_per_page" INT         NOT NULL DEFAULT 100,
+
+    -- A free-form bag for UI preferences that the backend doesn't need to understand.
+    -- The front end can add new keys here without a migration.
+    "ui"                          JSONB       NOT NULL DEFAULT '{}'::jsonb,
+
+    "created_at"                  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    "updated_at"                  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+COMMENT ON TABLE "user_preferences" IS 'Stores the user''s UI preferences. This is a 1:1 relationship with the "users" table. We keep it separate from "user_settings" so that UI tweaks never touch the code that handles credentials.';
+COMMENT ON COLUMN "user_preferences"."undo_send_delay_seconds" IS 'How long sent emails wait in the outbox before actually being sent, so the user can undo sending.';
+COMMENT ON COLUMN "user_preferences"."pagination_threads_per_page" IS 'The default number of threads per page in list views.';
+COMMENT ON COLUMN "user_preferences"."ui" IS 'A free-form bag for UI preferences that the backend doesn''t need to understand. The front end can add new keys here without a migration.';
+
+-- Move the existing preferences over from "user_settings"
+INSERT INTO "user_preferences" ("user_id", "undo_send_delay_seconds", "pagination_threads_per_page")
+SELECT "user_id", "undo_send_delay_seconds", "pagination_threads_per_page"
+FROM "user_settings";
+
+ALTER TABLE "user_settings"
+    DROP COLUMN IF EXISTS "undo_send_delay_seconds",
+    DROP COLUMN IF EXISTS "pagination_threads_per_page";
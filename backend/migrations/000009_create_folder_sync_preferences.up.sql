@@ -0,0 +1,23 @@
+-- Stores how we sync each IMAP folder for each user.
+-- Folders without a row here use the defaults: enabled, headers only.
+CREATE TABLE "folder_sync_preferences"
+(
+    "user_id"     UUID        NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "folder_name" TEXT        NOT NULL,
+
+    -- If false, we don't sync or cache this folder at all.
+    "enabled"     BOOLEAN     NOT NULL DEFAULT TRUE,
+
+    -- "headers_only": we cache headers on sync, and bodies only when the user opens a thread.
+    -- "full": we also download the bodies of new messages on sync.
+    "mode"        TEXT        NOT NULL DEFAULT 'headers_only' CHECK ("mode" IN ('headers_only', 'full')),
+
+    "created_at"  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    "updated_at"  TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY ("user_id", "folder_name")
+);
+
+COMMENT ON TABLE "folder_sync_preferences" IS 'Stores how we sync each IMAP folder for each user. Folders without a row here use the defaults: enabled, headers only.';
+COMMENT ON COLUMN "folder_sync_preferences"."enabled" IS 'If false, we don''t sync or cache this folder at all.';
+COMMENT ON COLUMN "folder_sync_preferences"."mode" IS '"headers_only": we cache headers on sync, and bodies only when the user opens a thread. "full": we also download the bodies of new messages on sync.';
@@ -0,0 +1,2 @@
+ALTER TABLE "threads"
+DROP COLUMN IF EXISTS "importance_score";
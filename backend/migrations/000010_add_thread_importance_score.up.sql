@@ -0,0 +1,6 @@
+-- Add an importance score to threads for the priority inbox.
+-- It's recalculated in the background after each folder sync.
+ALTER TABLE "threads"
+ADD COLUMN "importance_score" SMALLINT NOT NULL DEFAULT 0 CHECK ("importance_score" BETWEEN 0 AND 100);
+
+COMMENT ON COLUMN "threads"."importance_score" IS 'How important this thread probably is to the user, from 0 to 100. Recalculated after each folder sync.';
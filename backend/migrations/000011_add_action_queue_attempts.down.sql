@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_action_queue_type_process_at;
+
+ALTER TABLE "action_queue"
+DROP COLUMN IF EXISTS "last_error",
+DROP COLUMN IF EXISTS "attempts";
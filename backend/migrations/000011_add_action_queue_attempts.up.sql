@@ -0,0 +1,10 @@
+-- Track failed attempts in the action queue, so that the outbox can retry sending with a backoff.
+ALTER TABLE "action_queue"
+ADD COLUMN "attempts"   INT  NOT NULL DEFAULT 0,
+ADD COLUMN "last_error" TEXT;
+
+-- The workers look for due actions of one type
+CREATE INDEX idx_action_queue_type_process_at ON "action_queue" ("action_type", "process_at");
+
+COMMENT ON COLUMN "action_queue"."attempts" IS 'How many times the action failed. Failed actions are retried later, until they run out of attempts.';
+COMMENT ON COLUMN "action_queue"."last_error" IS 'The error of the last failed attempt, for debugging.';
@@ -0,0 +1,5 @@
+-- Store a hash of each message's body, so that resyncs can skip rewriting unchanged bodies.
+ALTER TABLE "messages"
+ADD COLUMN "content_hash" BYTEA;
+
+COMMENT ON COLUMN "messages"."content_hash" IS 'SHA-256 of the body ("unsafe_body_html" and "body_text"). NULL if we haven''t fetched the body yet. Resyncs only rewrite the body if the hash changed.';
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_drafts_user_id_in_reply_to;
+DROP INDEX IF EXISTS idx_drafts_user_id_last_saved_at;
+
+ALTER TABLE "drafts"
+DROP COLUMN IF EXISTS "created_at",
+DROP COLUMN IF EXISTS "message_id_header",
+DROP COLUMN IF EXISTS "body_text";
@@ -0,0 +1,10 @@
+-- Add what the drafts API needs on top of the original drafts table.
+ALTER TABLE "drafts"
+ADD COLUMN "body_text"         TEXT        NOT NULL DEFAULT '',
+ADD COLUMN "message_id_header" TEXT,
+ADD COLUMN "created_at"        TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX idx_drafts_user_id_last_saved_at ON "drafts" ("user_id", "last_saved_at" DESC);
+CREATE INDEX idx_drafts_user_id_in_reply_to ON "drafts" ("user_id", "in_reply_to_message_id");
+
+COMMENT ON COLUMN "drafts"."message_id_header" IS 'The "Message-ID" of the copy in the IMAP Drafts folder. It stays the same across saves, so that we can find and replace the old copy.';
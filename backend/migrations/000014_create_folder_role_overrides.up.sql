@@ -0,0 +1,21 @@
+-- Stores the folder roles that users set by hand, for example, when the server doesn't mark their spam folder
+-- with SPECIAL-USE, or marks one they don't use. Folders without a row here use the role from SPECIAL-USE.
+CREATE TABLE "folder_role_overrides"
+(
+    "user_id"     UUID        NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "folder_name" TEXT        NOT NULL,
+
+    -- "other" means the folder has no role, even if the server says it does.
+    "role"        TEXT        NOT NULL CHECK ("role" IN ('sent', 'drafts', 'spam', 'trash', 'archive', 'other')),
+
+    "created_at"  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    "updated_at"  TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY ("user_id", "folder_name")
+);
+
+-- Each role can belong to only one folder
+CREATE UNIQUE INDEX idx_folder_role_overrides_user_id_role ON "folder_role_overrides" ("user_id", "role") WHERE "role" <> 'other';
+
+COMMENT ON TABLE "folder_role_overrides" IS 'Stores the folder roles that users set by hand. Folders without a row here use the role from SPECIAL-USE.';
+COMMENT ON COLUMN "folder_role_overrides"."role" IS '"other" means the folder has no role, even if the server says it does.';
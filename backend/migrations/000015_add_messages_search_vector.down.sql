@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_messages_search_vector;
+DROP TRIGGER IF EXISTS messages_search_vector_update ON "messages";
+DROP FUNCTION IF EXISTS messages_search_vector_update();
+DROP FUNCTION IF EXISTS messages_search_vector(TEXT, TEXT, TEXT[], TEXT[], TEXT);
+
+ALTER TABLE "messages"
+DROP COLUMN IF EXISTS "search_vector";
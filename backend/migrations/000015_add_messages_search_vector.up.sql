@@ -0,0 +1,37 @@
+-- Full-text search over the cached messages.
+-- We use the "simple" configuration, since mail comes in many languages, and stemming for the wrong language
+-- does more harm than good.
+ALTER TABLE "messages"
+ADD COLUMN "search_vector" TSVECTOR;
+
+COMMENT ON COLUMN "messages"."search_vector" IS 'Full-text search vector of the subject, sender, recipients, and text body. Kept up to date by the messages_search_vector_update trigger.';
+
+CREATE FUNCTION messages_search_vector(subject TEXT, from_address TEXT, to_addresses TEXT[], cc_addresses TEXT[],
+                                       body_text TEXT) RETURNS TSVECTOR AS
+$$
+SELECT setweight(to_tsvector('simple', COALESCE(subject, '')), 'A') ||
+       setweight(to_tsvector('simple', COALESCE(from_address, '') || ' ' ||
+                                       COALESCE(array_to_string(to_addresses, ' '), '') || ' ' ||
+                                       COALESCE(array_to_string(cc_addresses, ' '), '')), 'B') ||
+       setweight(to_tsvector('simple', COALESCE(body_text, '')), 'C')
+$$ LANGUAGE SQL;
+
+CREATE FUNCTION messages_search_vector_update() RETURNS TRIGGER AS
+$$
+BEGIN
+    NEW.search_vector := messages_search_vector(NEW.subject, NEW.from_address, NEW.to_addresses, NEW.cc_addresses,
+                                                NEW.body_text);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER messages_search_vector_update
+    BEFORE INSERT OR UPDATE OF subject, from_address, to_addresses, cc_addresses, body_text
+    ON "messages"
+    FOR EACH ROW
+EXECUTE FUNCTION messages_search_vector_update();
+
+UPDATE "messages"
+SET search_vector = messages_search_vector(subject, from_address, to_addresses, cc_addresses, body_text);
+
+CREATE INDEX idx_messages_search_vector ON "messages" USING GIN ("search_vector");
@@ -0,0 +1,28 @@
+-- Stores the addresses that users can send as, besides their main one, for example, an alias or a shared mailbox.
+-- Identities without an SMTP server send through the SMTP server in "user_settings".
+CREATE TABLE "send_identities"
+(
+    "id"                      UUID PRIMARY KEY     DEFAULT gen_random_uuid(),
+
+    "user_id"                 UUID        NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+
+    "name"                    TEXT        NOT NULL DEFAULT '',
+    "email"                   TEXT        NOT NULL,
+
+    -- All three are NULL for identities that use the SMTP server in "user_settings".
+    "smtp_server_hostname"    TEXT,
+    "smtp_username"           TEXT,
+    "encrypted_smtp_password" BYTEA,
+
+    -- Plain text, added to the end of messages sent as this identity.
+    "signature"               TEXT        NOT NULL DEFAULT '',
+
+    "created_at"              TIMESTAMPTZ NOT NULL DEFAULT now(),
+    "updated_at"              TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    UNIQUE ("user_id", "email")
+);
+
+COMMENT ON TABLE "send_identities" IS 'Stores the addresses that users can send as, besides their main one. Identities without an SMTP server send through the SMTP server in "user_settings".';
+COMMENT ON COLUMN "send_identities"."smtp_server_hostname" IS 'NULL for identities that use the SMTP server in "user_settings". So are "smtp_username" and "encrypted_smtp_password".';
+COMMENT ON COLUMN "send_identities"."signature" IS 'Plain text, added to the end of messages sent as this identity.';
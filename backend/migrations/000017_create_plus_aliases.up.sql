@@ -0,0 +1,20 @@
+-- Stores the plus-addressed variants of users' addresses that they generated for a single purpose,
+-- for example, "user+shop-x7q@example.com" for signing up to a shop.
+-- Mail sent to an alias tells which label it belongs to, so users can see who leaked or shared their address.
+CREATE TABLE "plus_aliases"
+(
+    "id"         UUID PRIMARY KEY     DEFAULT gen_random_uuid(),
+
+    "user_id"    UUID        NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+
+    -- Lowercase, so that we can match it against recipient addresses.
+    "address"    TEXT        NOT NULL,
+    "label"      TEXT        NOT NULL,
+
+    "created_at" TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    UNIQUE ("user_id", "address")
+);
+
+COMMENT ON TABLE "plus_aliases" IS 'Stores the plus-addressed variants of users'' addresses that they generated for a single purpose, for example, "user+shop-x7q@example.com".';
+COMMENT ON COLUMN "plus_aliases"."address" IS 'Lowercase, so that we can match it against recipient addresses.';
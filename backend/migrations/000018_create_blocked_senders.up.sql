@@ -0,0 +1,20 @@
+-- Stores the senders that users blocked. New INBOX messages from them go to Trash or Spam during sync.
+CREATE TABLE "blocked_senders"
+(
+    "id"         UUID PRIMARY KEY     DEFAULT gen_random_uuid(),
+
+    "user_id"    UUID        NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+
+    -- Lowercase, so that we can match it against the "From" address of new messages.
+    "email"      TEXT        NOT NULL,
+    "action"     TEXT        NOT NULL DEFAULT 'trash' CHECK ("action" IN ('trash', 'spam')),
+
+    "created_at" TIMESTAMPTZ NOT NULL DEFAULT now(),
+    "updated_at" TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    UNIQUE ("user_id", "email")
+);
+
+COMMENT ON TABLE "blocked_senders" IS 'Stores the senders that users blocked. New INBOX messages from them go to Trash or Spam during sync.';
+COMMENT ON COLUMN "blocked_senders"."email" IS 'Lowercase, so that we can match it against the "From" address of new messages.';
+COMMENT ON COLUMN "blocked_senders"."action" IS 'Where new messages from the sender go: "trash" or "spam".';
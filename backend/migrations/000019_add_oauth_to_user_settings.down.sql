@@ -0,0 +1,4 @@
+ALTER TABLE "user_settings"
+DROP COLUMN IF EXISTS "oauth_provider",
+DROP COLUMN IF EXISTS "encrypted_oauth_refresh_token",
+DROP COLUMN IF EXISTS "oauth_token_expires_at";
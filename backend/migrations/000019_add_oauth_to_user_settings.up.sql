@@ -0,0 +1,12 @@
+-- Lets users connect with OAuth instead of passwords, for example, Gmail users who can't use app passwords.
+-- The access token takes the place of the IMAP and SMTP passwords, so everything that logs in keeps working.
+ALTER TABLE "user_settings"
+ADD COLUMN "oauth_provider"                TEXT,
+ADD COLUMN "encrypted_oauth_refresh_token" BYTEA,
+ADD COLUMN "oauth_token_expires_at"        TIMESTAMPTZ;
+
+CREATE INDEX idx_user_settings_oauth_token_expires_at ON "user_settings" ("oauth_token_expires_at")
+    WHERE "oauth_provider" IS NOT NULL;
+
+COMMENT ON COLUMN "user_settings"."oauth_provider" IS 'NULL for password logins. Otherwise, for example, "google", and the encrypted passwords hold the current access token.';
+COMMENT ON COLUMN "user_settings"."encrypted_oauth_refresh_token" IS 'Gets a new access token before "oauth_token_expires_at".';
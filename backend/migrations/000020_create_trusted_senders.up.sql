@@ -0,0 +1,17 @@
+-- Stores the senders whose messages show remote images without asking.
+CREATE TABLE "trusted_senders"
+(
+    "id"         UUID PRIMARY KEY     DEFAULT gen_random_uuid(),
+
+    "user_id"    UUID        NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+
+    -- Lowercase, so that we can match it against the "From" address of messages.
+    "email"      TEXT        NOT NULL,
+
+    "created_at" TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    UNIQUE ("user_id", "email")
+);
+
+COMMENT ON TABLE "trusted_senders" IS 'Stores the senders whose messages show remote images without asking.';
+COMMENT ON COLUMN "trusted_senders"."email" IS 'Lowercase, so that we can match it against the "From" address of messages.';
@@ -0,0 +1,3 @@
+ALTER TABLE "folder_sync_timestamps"
+DROP COLUMN IF EXISTS "highest_modseq",
+DROP COLUMN IF EXISTS "uid_validity";
@@ -0,0 +1,7 @@
+-- Add CONDSTORE state to folder_sync_timestamps, so that incremental syncs pick up flag changes and deletions.
+ALTER TABLE "folder_sync_timestamps"
+ADD COLUMN "highest_modseq" BIGINT,
+ADD COLUMN "uid_validity" BIGINT;
+
+COMMENT ON COLUMN "folder_sync_timestamps"."highest_modseq" IS 'The HIGHESTMODSEQ of the folder at our last sync, if the server supports CONDSTORE. We fetch the changes since then.';
+COMMENT ON COLUMN "folder_sync_timestamps"."uid_validity" IS 'The UIDVALIDITY of the folder when we saved "highest_modseq". If it changed, the mod-sequence is meaningless.';
@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS threads_log_sync_change ON "threads";
+DROP FUNCTION IF EXISTS threads_log_sync_change();
+DROP TRIGGER IF EXISTS messages_log_sync_change_on_update ON "messages";
+DROP TRIGGER IF EXISTS messages_log_sync_change ON "messages";
+DROP FUNCTION IF EXISTS messages_log_sync_change();
+DROP TABLE IF EXISTS "sync_changes";
@@ -0,0 +1,77 @@
+-- Logs which threads changed, so that clients that were offline can catch up with GET /api/v1/sync/delta.
+-- Triggers on "messages" and "threads" fill it, so every code path that changes the cache is covered.
+CREATE TABLE "sync_changes"
+(
+    "id"               BIGSERIAL PRIMARY KEY,
+
+    -- No foreign key, since deleting a user deletes their messages, which logs changes for a user that's gone.
+    -- The pruner removes those rows with the rest.
+    "user_id"          UUID        NOT NULL,
+
+    "thread_id"        UUID        NOT NULL,
+
+    -- Only set when the thread itself was deleted, since we can't look it up anymore.
+    "stable_thread_id" TEXT,
+
+    -- The folder of the changed message. NULL when the thread itself was deleted.
+    "folder_name"      TEXT,
+
+    -- The ID of the transaction that made the change. Cursors are transaction snapshots,
+    -- so that changes from transactions that commit late aren't skipped.
+    "xid"              XID8        NOT NULL DEFAULT pg_current_xact_id(),
+
+    "created_at"       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_sync_changes_user_id_xid ON "sync_changes" ("user_id", "xid");
+CREATE INDEX idx_sync_changes_created_at ON "sync_changes" ("created_at");
+
+COMMENT ON TABLE "sync_changes" IS 'Logs which threads changed, so that clients that were offline can catch up with GET /api/v1/sync/delta. Triggers on "messages" and "threads" fill it.';
+COMMENT ON COLUMN "sync_changes"."user_id" IS 'No foreign key, since deleting a user deletes their messages, which logs changes for a user that''s gone. The pruner removes those rows with the rest.';
+COMMENT ON COLUMN "sync_changes"."stable_thread_id" IS 'Only set when the thread itself was deleted, since we can''t look it up anymore.';
+COMMENT ON COLUMN "sync_changes"."folder_name" IS 'The folder of the changed message. NULL when the thread itself was deleted.';
+COMMENT ON COLUMN "sync_changes"."xid" IS 'The ID of the transaction that made the change. Cursors are transaction snapshots, so that changes from transactions that commit late aren''t skipped.';
+
+CREATE FUNCTION messages_log_sync_change() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        INSERT INTO sync_changes (user_id, thread_id, folder_name) VALUES (OLD.user_id, OLD.thread_id, OLD.imap_folder_name);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        INSERT INTO sync_changes (user_id, thread_id, folder_name) VALUES (NEW.user_id, NEW.thread_id, NEW.imap_folder_name);
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER messages_log_sync_change
+    AFTER INSERT OR DELETE
+    ON "messages"
+    FOR EACH ROW
+EXECUTE FUNCTION messages_log_sync_change();
+
+-- Body updates don't change anything that the delta reports, so only log the columns it does
+CREATE TRIGGER messages_log_sync_change_on_update
+    AFTER UPDATE OF thread_id, imap_folder_name, is_read, is_starred
+    ON "messages"
+    FOR EACH ROW
+    WHEN (OLD.thread_id IS DISTINCT FROM NEW.thread_id
+        OR OLD.imap_folder_name IS DISTINCT FROM NEW.imap_folder_name
+        OR OLD.is_read IS DISTINCT FROM NEW.is_read
+        OR OLD.is_starred IS DISTINCT FROM NEW.is_starred)
+EXECUTE FUNCTION messages_log_sync_change();
+
+CREATE FUNCTION threads_log_sync_change() RETURNS TRIGGER AS
+$$
+BEGIN
+    INSERT INTO sync_changes (user_id, thread_id, stable_thread_id) VALUES (OLD.user_id, OLD.id, OLD.stable_thread_id);
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER threads_log_sync_change
+    AFTER DELETE
+    ON "threads"
+    FOR EACH ROW
+EXECUTE FUNCTION threads_log_sync_change();
@@ -0,0 +1,41 @@
+-- Stores the client devices of users, for targeting push notifications.
+CREATE TABLE "devices"
+(
+    "id"                    UUID PRIMARY KEY     DEFAULT gen_random_uuid(),
+
+    "user_id"               UUID        NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+
+    -- Shown in the device list, so that users can tell their devices apart. For example, "Firefox on my laptop".
+    "name"                  TEXT        NOT NULL,
+    "platform"              TEXT        NOT NULL CHECK ("platform" IN ('web', 'android', 'ios')),
+
+    -- The Web Push endpoint URL for "web", or the FCM or APNs token for "android" and "ios".
+    -- NULL if the device doesn't get push notifications.
+    "push_endpoint"         TEXT,
+
+    -- The device's P-256 public key and auth secret for encrypting Web Push payloads. NULL unless the platform is "web".
+    "push_p256dh"           BYTEA,
+    "push_auth"             BYTEA,
+
+    "notifications_enabled" BOOLEAN     NOT NULL DEFAULT TRUE,
+    "important_only"        BOOLEAN     NOT NULL DEFAULT FALSE,
+
+    -- Updated when the device registers again, so that users can spot devices they don't use anymore.
+    "last_seen_at"          TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    "created_at"            TIMESTAMPTZ NOT NULL DEFAULT now(),
+    "updated_at"            TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    -- NULLs are distinct, so any number of devices can be without push
+    UNIQUE ("user_id", "push_endpoint")
+);
+
+COMMENT ON TABLE "devices" IS 'Stores the client devices of users, for targeting push notifications.';
+COMMENT ON COLUMN "devices"."name" IS 'Shown in the device list, so that users can tell their devices apart. For example, "Firefox on my laptop".';
+COMMENT ON COLUMN "devices"."platform" IS '"web", "android", or "ios".';
+COMMENT ON COLUMN "devices"."push_endpoint" IS 'The Web Push endpoint URL for "web", or the FCM or APNs token for "android" and "ios". NULL if the device doesn''t get push notifications.';
+COMMENT ON COLUMN "devices"."push_p256dh" IS 'The device''s P-256 public key for encrypting Web Push payloads. NULL unless the platform is "web".';
+COMMENT ON COLUMN "devices"."push_auth" IS 'The device''s auth secret for encrypting Web Push payloads. NULL unless the platform is "web".';
+COMMENT ON COLUMN "devices"."notifications_enabled" IS 'False if the user turned off notifications for this device. We keep the push endpoint, so they can turn them back on.';
+COMMENT ON COLUMN "devices"."important_only" IS 'True if the device only gets notifications about important threads. See the importance package.';
+COMMENT ON COLUMN "devices"."last_seen_at" IS 'Updated when the device registers again, so that users can spot devices they don''t use anymore.';
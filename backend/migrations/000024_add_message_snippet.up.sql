@@ -0,0 +1,10 @@
+-- Store the start of each message's text, so that thread lists don't need to read the bodies.
+ALTER TABLE "messages"
+ADD COLUMN "snippet" TEXT;
+
+-- Messages with only an HTML body get their snippet when their body changes, or on the next full resync
+UPDATE "messages"
+SET "snippet" = LEFT(regexp_replace(btrim("body_text"), '\s+', ' ', 'g'), 200)
+WHERE "body_text" <> '';
+
+COMMENT ON COLUMN "messages"."snippet" IS 'The first 200 characters of the text body, or of the HTML body without tags if there''s no text body, with whitespace collapsed. NULL if we haven''t fetched the body yet.';
@@ -0,0 +1,12 @@
+-- Token buckets of the API rate limiter, when it's set to share its limits between backend instances.
+CREATE TABLE "rate_limit_buckets"
+(
+    "key"        TEXT PRIMARY KEY,
+    "tokens"     DOUBLE PRECISION NOT NULL,
+    "updated_at" TIMESTAMPTZ      NOT NULL
+);
+
+COMMENT ON TABLE "rate_limit_buckets" IS 'Token buckets of the API rate limiter. Only used if VMAIL_RATE_LIMIT_STORE is "postgres".';
+COMMENT ON COLUMN "rate_limit_buckets"."key" IS 'What the bucket limits, for example, "user:alice@example.com".';
+COMMENT ON COLUMN "rate_limit_buckets"."tokens" IS 'How many requests the bucket had left at updated_at. It refills over time.';
+COMMENT ON COLUMN "rate_limit_buckets"."updated_at" IS 'When we last took a token from the bucket or refilled it.';
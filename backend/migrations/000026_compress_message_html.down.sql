@@ -0,0 +1,13 @@
+-- Postgres can't decompress zstd, so forget the bodies that were compressed. Opening the thread fetches them again.
+UPDATE "messages"
+SET "unsafe_body_html" = '',
+    "body_text"        = '',
+    "content_hash"     = NULL
+WHERE "unsafe_body_html_zstd" IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_messages_uncompressed_html;
+
+ALTER TABLE "messages"
+DROP COLUMN IF EXISTS "unsafe_body_html_zstd";
+
+COMMENT ON COLUMN "messages"."unsafe_body_html" IS 'The raw, unsanitized HTML from the email. The front end *must* sanitize this with DOMPurify before rendering it.';
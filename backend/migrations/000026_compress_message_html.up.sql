@@ -0,0 +1,14 @@
+-- Store the HTML bodies of messages compressed with zstd. HTML bodies are most of the size of the messages table.
+-- The backend writes new bodies compressed, and compresses the old ones in the background, in the maintenance window.
+ALTER TABLE "messages"
+ADD COLUMN "unsafe_body_html_zstd" BYTEA;
+
+-- The data is already compressed, so don't let Postgres try to compress it again
+ALTER TABLE "messages"
+ALTER COLUMN "unsafe_body_html_zstd" SET STORAGE EXTERNAL;
+
+-- Lets the background job find the bodies it still has to compress. It shrinks to nothing as the job goes.
+CREATE INDEX idx_messages_uncompressed_html ON "messages" ("id") WHERE "unsafe_body_html" <> '';
+
+COMMENT ON COLUMN "messages"."unsafe_body_html" IS 'The raw, unsanitized HTML from the email. The front end *must* sanitize this with DOMPurify before rendering it. NULL if the body is in "unsafe_body_html_zstd".';
+COMMENT ON COLUMN "messages"."unsafe_body_html_zstd" IS 'The "unsafe_body_html", compressed with zstd. NULL if the message has no HTML body, or if we haven''t compressed it yet.';
@@ -0,0 +1,3 @@
+-- Lets GET /thread read a segment of a big thread, newest first, without sorting all of its messages.
+-- Messages without a date sort last, like in the thread view.
+CREATE INDEX idx_messages_thread_segment ON "messages" ("thread_id", (COALESCE("sent_at", 'infinity'::timestamptz)), "id");
@@ -0,0 +1,9 @@
+DROP TRIGGER IF EXISTS messages_thread_size_update ON "messages";
+DROP FUNCTION IF EXISTS messages_thread_size_update();
+
+ALTER TABLE "threads"
+DROP COLUMN IF EXISTS "message_count",
+DROP COLUMN IF EXISTS "size_bytes";
+
+ALTER TABLE "messages"
+DROP COLUMN IF EXISTS "size_bytes";
@@ -0,0 +1,43 @@
+-- Sizes of messages and threads, for the count: and size: search operators.
+-- The thread columns are denormalized, so that searches can filter threads without counting their messages.
+ALTER TABLE "messages"
+ADD COLUMN "size_bytes" BIGINT NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN "messages"."size_bytes" IS 'The RFC822.SIZE of the message on the IMAP server, or 0 if we don''t know it yet.';
+
+ALTER TABLE "threads"
+ADD COLUMN "message_count" INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN "size_bytes"    BIGINT  NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN "threads"."message_count" IS 'How many cached messages the thread has. Kept up to date by the messages_thread_size_update trigger.';
+COMMENT ON COLUMN "threads"."size_bytes" IS 'The total size_bytes of the thread''s cached messages. Kept up to date by the messages_thread_size_update trigger.';
+
+CREATE FUNCTION messages_thread_size_update() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        UPDATE "threads"
+        SET message_count = message_count - 1,
+            size_bytes    = size_bytes - OLD.size_bytes
+        WHERE id = OLD.thread_id;
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        UPDATE "threads"
+        SET message_count = message_count + 1,
+            size_bytes    = size_bytes + NEW.size_bytes
+        WHERE id = NEW.thread_id;
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER messages_thread_size_update
+    AFTER INSERT OR DELETE OR UPDATE OF thread_id, size_bytes
+    ON "messages"
+    FOR EACH ROW
+EXECUTE FUNCTION messages_thread_size_update();
+
+UPDATE "threads" t
+SET message_count = counts.message_count
+FROM (SELECT thread_id, COUNT(*) AS message_count FROM "messages" GROUP BY thread_id) counts
+WHERE counts.thread_id = t.id;
@@ -0,0 +1,20 @@
+-- The people the user has mail with, for autocomplete in the compose form.
+-- We rebuild a user's contacts from their cached messages after each sync.
+CREATE TABLE "contacts"
+(
+    "user_id"       UUID    NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "email"         TEXT    NOT NULL,
+    "name"          TEXT    NOT NULL DEFAULT '',
+    "message_count" INTEGER NOT NULL DEFAULT 0,
+    "sent_count"    INTEGER NOT NULL DEFAULT 0,
+    "last_seen_at"  TIMESTAMPTZ,
+
+    PRIMARY KEY ("user_id", "email")
+);
+
+COMMENT ON TABLE "contacts" IS 'The addresses in the From, To, and Cc headers of the user''s cached messages, except the user''s own.';
+COMMENT ON COLUMN "contacts"."email" IS 'The lowercase bare address, like "alice@example.com".';
+COMMENT ON COLUMN "contacts"."name" IS 'The display name of the address in the newest message that has one, or empty.';
+COMMENT ON COLUMN "contacts"."message_count" IS 'How many cached messages have the address in From, To, or Cc.';
+COMMENT ON COLUMN "contacts"."sent_count" IS 'How many cached messages the user sent to the address. These count more in the ranking.';
+COMMENT ON COLUMN "contacts"."last_seen_at" IS 'The sent date of the newest cached message with the address.';
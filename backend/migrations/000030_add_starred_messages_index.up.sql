@@ -0,0 +1,3 @@
+-- Lets GET /threads list the virtual starred folder without reading all of the user's messages.
+-- Few messages are starred, so a partial index keeps it small.
+CREATE INDEX idx_messages_starred ON "messages" ("user_id", "thread_id") WHERE "is_starred";
@@ -0,0 +1,14 @@
+-- Labels on messages, which are custom IMAP keywords like "$Label1" or "Work".
+-- The sync replaces a message's labels with the keywords on the server, except the local ones.
+CREATE TABLE "message_labels"
+(
+    "message_id" UUID    NOT NULL REFERENCES "messages" ("id") ON DELETE CASCADE,
+    "label"      TEXT    NOT NULL,
+    "local"      BOOLEAN NOT NULL DEFAULT FALSE,
+
+    PRIMARY KEY ("message_id", "label")
+);
+
+COMMENT ON TABLE "message_labels" IS 'The custom IMAP keywords of cached messages, without system flags like \Seen.';
+COMMENT ON COLUMN "message_labels"."label" IS 'The keyword as the server has it, like "$Label1".';
+COMMENT ON COLUMN "message_labels"."local" IS 'True if the folder doesn''t allow new keywords (no \* in PERMANENTFLAGS), so we only keep the label in the cache.';
@@ -0,0 +1,5 @@
+-- Whether we cut the body of a message because it was over the fetch size limits.
+ALTER TABLE "messages"
+ADD COLUMN "truncated" BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN "messages"."truncated" IS 'True if the cached body is cut short, because the message or one of its parts was over the VMAIL_IMAP_MAX_MESSAGE_BYTES or VMAIL_IMAP_MAX_PART_BYTES limit.';
@@ -0,0 +1,19 @@
+-- Whether the IMAP server of a user's account is throttling us. When the server says so, or keeps dropping the
+-- connection during syncs, we stop syncing the account for a while, and back off longer each time it happens again.
+CREATE TABLE "sync_throttles"
+(
+    "user_id"         UUID        NOT NULL PRIMARY KEY REFERENCES "users" ("id") ON DELETE CASCADE,
+
+    -- How many syncs in a row were throttled or lost their connection. A successful sync deletes the row.
+    "strikes"         INTEGER     NOT NULL DEFAULT 0,
+
+    -- We don't sync the account before this. NULL while dropped connections are below the threshold.
+    "throttled_until" TIMESTAMPTZ,
+
+    -- The server's response or the error that made us back off.
+    "reason"          TEXT        NOT NULL DEFAULT '',
+
+    "updated_at"      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+COMMENT ON TABLE "sync_throttles" IS 'The throttling state of users whose IMAP server throttled or dropped recent syncs.';
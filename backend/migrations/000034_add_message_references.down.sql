@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_messages_user_id_message_id_header;
+
+ALTER TABLE "messages"
+DROP COLUMN IF EXISTS "referenced_message_ids";
@@ -0,0 +1,9 @@
+-- The Message-IDs that each message replies to, so that the thread repair job can merge threads that
+-- incremental syncs split.
+ALTER TABLE "messages"
+ADD COLUMN "referenced_message_ids" TEXT[] NOT NULL DEFAULT '{}';
+
+COMMENT ON COLUMN "messages"."referenced_message_ids" IS 'The Message-IDs in the References and In-Reply-To headers, oldest first. Empty if the message doesn''t reply to anything, or if we synced it before we kept them.';
+
+-- Finds the messages that others reference, for the thread repair job and for incremental syncs
+CREATE INDEX idx_messages_user_id_message_id_header ON "messages" ("user_id", "message_id_header");
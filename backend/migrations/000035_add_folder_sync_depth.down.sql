@@ -0,0 +1,3 @@
+ALTER TABLE "folder_sync_preferences"
+DROP COLUMN IF EXISTS "sync_max_messages",
+DROP COLUMN IF EXISTS "sync_days";
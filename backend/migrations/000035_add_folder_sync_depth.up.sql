@@ -0,0 +1,7 @@
+-- How far back we sync and keep the messages of a folder. NULL means no limit. If both are set, both apply.
+ALTER TABLE "folder_sync_preferences"
+ADD COLUMN "sync_days" INTEGER CHECK ("sync_days" > 0),
+ADD COLUMN "sync_max_messages" INTEGER CHECK ("sync_max_messages" > 0);
+
+COMMENT ON COLUMN "folder_sync_preferences"."sync_days" IS 'We only sync and keep the messages of the folder sent in this many days. NULL for no limit. The retention cleaner deletes the older cached messages.';
+COMMENT ON COLUMN "folder_sync_preferences"."sync_max_messages" IS 'We only sync and keep this many of the newest messages of the folder. NULL for no limit. The retention cleaner deletes the older cached messages.';
@@ -0,0 +1,4 @@
+ALTER TABLE "drafts"
+DROP COLUMN IF EXISTS "attachment_upload_ids";
+
+DROP TABLE IF EXISTS "attachment_uploads";
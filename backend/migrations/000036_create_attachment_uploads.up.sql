@@ -0,0 +1,25 @@
+-- Files that the user uploaded while writing a message, before sending it or saving it as a draft.
+-- Send requests and drafts reference them by ID, so the compose form doesn't have to send them inline.
+CREATE TABLE "attachment_uploads"
+(
+    "id"           UUID PRIMARY KEY     DEFAULT gen_random_uuid(),
+    "user_id"      UUID        NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "filename"     TEXT        NOT NULL,
+    "content_type" TEXT        NOT NULL,
+    "size_bytes"   BIGINT      NOT NULL,
+    "data"         BYTEA       NOT NULL,
+    "created_at"   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_attachment_uploads_user_id ON "attachment_uploads" ("user_id");
+CREATE INDEX idx_attachment_uploads_created_at ON "attachment_uploads" ("created_at");
+
+COMMENT ON TABLE "attachment_uploads" IS 'Files uploaded with POST /api/v1/attachments/upload. They expire a day after the upload, unless a draft still references them.';
+COMMENT ON COLUMN "attachment_uploads"."content_type" IS 'The media type the client sent, or the one we detected from the content if it sent none.';
+COMMENT ON COLUMN "attachment_uploads"."size_bytes" IS 'The size of "data". Counts toward the user''s upload quota.';
+
+-- The uploads attached to a draft, in order.
+ALTER TABLE "drafts"
+ADD COLUMN "attachment_upload_ids" UUID[] NOT NULL DEFAULT '{}';
+
+COMMENT ON COLUMN "drafts"."attachment_upload_ids" IS 'The IDs of the "attachment_uploads" attached to the draft, in order. They don''t expire while a draft references them.';
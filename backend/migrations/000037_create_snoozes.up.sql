@@ -0,0 +1,15 @@
+-- Threads that the user snoozed. They're hidden from their folders until "snoozed_until", when the snooze waker
+-- deletes the row, and they show up again.
+CREATE TABLE "snoozes"
+(
+    "thread_id"     UUID PRIMARY KEY REFERENCES "threads" ("id") ON DELETE CASCADE,
+    "user_id"       UUID        NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "snoozed_until" TIMESTAMPTZ NOT NULL,
+    "created_at"    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_snoozes_user_id ON "snoozes" ("user_id");
+CREATE INDEX idx_snoozes_snoozed_until ON "snoozes" ("snoozed_until");
+
+COMMENT ON TABLE "snoozes" IS 'Snoozed threads. Folder thread lists and counts leave them out, and the virtual "snoozed" folder lists them.';
+COMMENT ON COLUMN "snoozes"."snoozed_until" IS 'When the thread comes back to its folders.';
@@ -0,0 +1,4 @@
+ALTER TABLE "user_settings"
+DROP COLUMN IF EXISTS "imap_skip_tls_verify",
+DROP COLUMN IF EXISTS "imap_security",
+DROP COLUMN IF EXISTS "imap_port";
@@ -0,0 +1,9 @@
+-- How to connect to the IMAP server. NULLs keep the old behavior: the port in "imap_server_hostname", and TLS.
+ALTER TABLE "user_settings"
+ADD COLUMN "imap_port" INTEGER CHECK ("imap_port" BETWEEN 1 AND 65535),
+ADD COLUMN "imap_security" TEXT CHECK ("imap_security" IN ('tls', 'starttls', 'none')),
+ADD COLUMN "imap_skip_tls_verify" BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN "user_settings"."imap_port" IS 'The port of the IMAP server. NULL for the port in "imap_server_hostname", or else the default port of "imap_security".';
+COMMENT ON COLUMN "user_settings"."imap_security" IS '"tls" for TLS from the start, "starttls" to upgrade with STARTTLS, or "none" for plain text, for local development only. NULL for TLS.';
+COMMENT ON COLUMN "user_settings"."imap_skip_tls_verify" IS 'Accept any certificate from the IMAP server, for servers with self-signed certificates.';
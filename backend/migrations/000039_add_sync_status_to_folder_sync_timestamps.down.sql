@@ -0,0 +1,10 @@
+-- Folders whose first sync never finished have no timestamp to keep
+DELETE FROM "folder_sync_timestamps" WHERE "synced_at" IS NULL;
+
+ALTER TABLE "folder_sync_timestamps"
+DROP COLUMN IF EXISTS "last_error_at",
+DROP COLUMN IF EXISTS "last_error",
+DROP COLUMN IF EXISTS "sync_started_at",
+ALTER COLUMN "synced_at" SET NOT NULL;
+
+COMMENT ON COLUMN "folder_sync_timestamps"."synced_at" IS NULL;
@@ -0,0 +1,12 @@
+-- Add the state of the current and the last sync to folder_sync_timestamps, for GET /api/v1/sync/status.
+-- A folder that's syncing for the first time has a row without "synced_at", so "synced_at" becomes nullable.
+ALTER TABLE "folder_sync_timestamps"
+ALTER COLUMN "synced_at" DROP NOT NULL,
+ADD COLUMN "sync_started_at" TIMESTAMPTZ,
+ADD COLUMN "last_error" TEXT,
+ADD COLUMN "last_error_at" TIMESTAMPTZ;
+
+COMMENT ON COLUMN "folder_sync_timestamps"."synced_at" IS 'When we last finished syncing the folder. NULL while the first sync is running.';
+COMMENT ON COLUMN "folder_sync_timestamps"."sync_started_at" IS 'When the running sync of the folder started, or NULL if none is running. A crashed server can leave it set, so old values mean nothing.';
+COMMENT ON COLUMN "folder_sync_timestamps"."last_error" IS 'Why the last sync of the folder failed, or NULL if it succeeded.';
+COMMENT ON COLUMN "folder_sync_timestamps"."last_error_at" IS 'When the last sync of the folder failed, or NULL if it succeeded.';
@@ -0,0 +1,14 @@
+-- Short-lived tokens for connecting to /api/v1/ws, issued by POST /api/v1/ws/token.
+-- Connecting uses up the token, and DELETE /api/v1/ws/token revokes the unused ones, so a row means a usable token.
+CREATE TABLE "ws_tokens"
+(
+    "id"         UUID PRIMARY KEY     DEFAULT gen_random_uuid(),
+    "user_id"    UUID        NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "expires_at" TIMESTAMPTZ NOT NULL,
+    "created_at" TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_ws_tokens_user_id ON "ws_tokens" ("user_id");
+
+COMMENT ON TABLE "ws_tokens" IS 'WebSocket tokens that were issued but not used or revoked yet. The token itself is signed, and names its row.';
+COMMENT ON COLUMN "ws_tokens"."expires_at" IS 'When the token stops working, even if unused. Issuing a token deletes the expired ones of the user.';
@@ -0,0 +1,21 @@
+-- API keys that let non-browser clients, like CLI tools and mobile apps, use the API without an Authelia session.
+CREATE TABLE "api_keys"
+(
+    "id"           UUID PRIMARY KEY     DEFAULT gen_random_uuid(),
+    "user_id"      UUID        NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "name"         TEXT        NOT NULL,
+    "key_prefix"   TEXT        NOT NULL,
+    "key_hash"     BYTEA       NOT NULL UNIQUE,
+    "expires_at"   TIMESTAMPTZ,
+    "last_used_at" TIMESTAMPTZ,
+    "created_at"   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_api_keys_user_id ON "api_keys" ("user_id");
+
+COMMENT ON TABLE "api_keys" IS 'API keys for clients without an Authelia session. Revoking a key deletes its row.';
+COMMENT ON COLUMN "api_keys"."name" IS 'Shown in the key list, so that users can tell their keys apart. For example, "Backup script".';
+COMMENT ON COLUMN "api_keys"."key_prefix" IS 'The start of the key, like "vmk_Ab3dE5gH", shown in the key list. It can''t authenticate by itself.';
+COMMENT ON COLUMN "api_keys"."key_hash" IS 'The SHA-256 hash of the key. We show the key once, when creating it, and never store it.';
+COMMENT ON COLUMN "api_keys"."expires_at" IS 'When the key stops working, or NULL if it works until revoked.';
+COMMENT ON COLUMN "api_keys"."last_used_at" IS 'When a request last used the key, to the minute, or NULL if none did yet.';
@@ -0,0 +1,21 @@
+-- The data exports that users take their data out of V-Mail with. Each user has at most one, the last they started.
+CREATE TABLE "data_exports"
+(
+    "user_id"     UUID PRIMARY KEY REFERENCES "users" ("id") ON DELETE CASCADE,
+    "id"          UUID        NOT NULL DEFAULT gen_random_uuid(),
+    "status"      TEXT        NOT NULL DEFAULT 'running',
+    "error"       TEXT,
+    "data"        BYTEA,
+    "size_bytes"  BIGINT,
+    "started_at"  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    "finished_at" TIMESTAMPTZ
+);
+
+CREATE INDEX idx_data_exports_finished_at ON "data_exports" ("finished_at");
+
+COMMENT ON TABLE "data_exports" IS 'Exports built in the background by POST /api/v1/export, and downloaded with GET /api/v1/export. Starting a new export replaces the old one.';
+COMMENT ON COLUMN "data_exports"."id" IS 'Changes with each new export, so that a build that was replaced can''t save its result over the new one.';
+COMMENT ON COLUMN "data_exports"."status" IS '"running", "done", or "failed".';
+COMMENT ON COLUMN "data_exports"."error" IS 'Why the export failed. NULL unless the status is "failed".';
+COMMENT ON COLUMN "data_exports"."data" IS 'The zip file. NULL unless the status is "done".';
+COMMENT ON COLUMN "data_exports"."finished_at" IS 'When the export finished or failed. Finished exports are deleted a week later.';
@@ -0,0 +1,5 @@
+-- When each message body was last read, so that the body cache evicts the bodies that no one read for the longest.
+ALTER TABLE "messages"
+ADD COLUMN "body_accessed_at" TIMESTAMPTZ;
+
+COMMENT ON COLUMN "messages"."body_accessed_at" IS 'When the user last opened the thread of the message. NULL if they never did since we cached the body, in which case the body counts as used when the message was sent. Bodies over the VMAIL_BODY_CACHE_QUOTA_BYTES of the user are evicted least recently used first.';
@@ -0,0 +1,23 @@
+-- The calendar invites that came in messages, parsed from their text/calendar parts.
+CREATE TABLE "calendar_events"
+(
+    "message_id" UUID PRIMARY KEY REFERENCES "messages" ("id") ON DELETE CASCADE,
+    "uid"        TEXT        NOT NULL,
+    "method"     TEXT        NOT NULL DEFAULT '',
+    "summary"    TEXT        NOT NULL DEFAULT '',
+    "location"   TEXT        NOT NULL DEFAULT '',
+    "starts_at"  TIMESTAMPTZ NOT NULL,
+    "ends_at"    TIMESTAMPTZ,
+    "all_day"    BOOLEAN     NOT NULL DEFAULT FALSE,
+    "organizer"  JSONB,
+    "attendees"  JSONB       NOT NULL DEFAULT '[]',
+    "created_at" TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+COMMENT ON TABLE "calendar_events" IS 'The event of each message with an iCalendar invite, for the RSVP buttons of the thread view. Only the first VEVENT of the invite is kept.';
+COMMENT ON COLUMN "calendar_events"."uid" IS 'The UID of the event. Invites, updates, and replies about the same event share it.';
+COMMENT ON COLUMN "calendar_events"."method" IS 'The iTIP METHOD of the invite, like "REQUEST", "CANCEL", or "REPLY". Empty if it had none.';
+COMMENT ON COLUMN "calendar_events"."ends_at" IS 'NULL if the event had no DTEND.';
+COMMENT ON COLUMN "calendar_events"."all_day" IS 'True if the start is a date without a time. Then starts_at is midnight UTC of that date.';
+COMMENT ON COLUMN "calendar_events"."organizer" IS 'The organizer, as {"email": "...", "name": "..."}. NULL if the invite had none.';
+COMMENT ON COLUMN "calendar_events"."attendees" IS 'The attendees, as [{"email": "...", "name": "...", "status": "accepted"}].';
@@ -0,0 +1,12 @@
+-- The phishing analysis of the links of each message body.
+CREATE TABLE "message_link_analyses"
+(
+    "message_id"       UUID PRIMARY KEY REFERENCES "messages" ("id") ON DELETE CASCADE,
+    "suspicious_score" SMALLINT    NOT NULL DEFAULT 0,
+    "warnings"         JSONB       NOT NULL DEFAULT '[]',
+    "created_at"       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+COMMENT ON TABLE "message_link_analyses" IS 'How suspicious the links of each message body look, for the phishing warnings of the thread view. Messages whose bodies we never fetched have none.';
+COMMENT ON COLUMN "message_link_analyses"."suspicious_score" IS 'From 0, for nothing suspicious, to 100. See the linkcheck package.';
+COMMENT ON COLUMN "message_link_analyses"."warnings" IS 'The suspicious links, as [{"kind": "mismatched_text", "url": "...", "domain": "...", "text": "..."}].';
@@ -0,0 +1,16 @@
+-- The sender checks that the user's server did for each message, from its Authentication-Results header.
+CREATE TABLE "message_authenticity"
+(
+    "message_id"  UUID PRIMARY KEY REFERENCES "messages" ("id") ON DELETE CASCADE,
+    "authserv_id" TEXT        NOT NULL DEFAULT '',
+    "dkim"        TEXT        NOT NULL DEFAULT '',
+    "spf"         TEXT        NOT NULL DEFAULT '',
+    "dmarc"       TEXT        NOT NULL DEFAULT '',
+    "verified"    BOOLEAN     NOT NULL DEFAULT FALSE,
+    "created_at"  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+COMMENT ON TABLE "message_authenticity" IS 'The DKIM, SPF, and DMARC results of each message, from the topmost Authentication-Results header, for the "unverified sender" warnings of the thread view. Messages without the header have none.';
+COMMENT ON COLUMN "message_authenticity"."authserv_id" IS 'The server that did the checks, like "mx.google.com".';
+COMMENT ON COLUMN "message_authenticity"."dkim" IS 'The DKIM result in lowercase, like "pass" or "fail". "pass" if any signature passed. Empty if the server did not report it.';
+COMMENT ON COLUMN "message_authenticity"."verified" IS 'True if DMARC passed, or, without a DMARC result, if DKIM or SPF passed.';
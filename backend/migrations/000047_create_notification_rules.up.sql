@@ -0,0 +1,37 @@
+-- Decides which new messages users get push notifications about, like "only from my boss" or quiet hours.
+CREATE TABLE "notification_rules"
+(
+    "id"          UUID PRIMARY KEY     DEFAULT gen_random_uuid(),
+    "user_id"     UUID        NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "name"        TEXT        NOT NULL DEFAULT '',
+
+    -- "notify" rules make an allowlist: once a user has one, only the messages that match one notify.
+    -- "mute" rules win over "notify" rules.
+    "action"      TEXT        NOT NULL CHECK ("action" IN ('notify', 'mute')),
+
+    -- The conditions. Empty ones match every message.
+    "sender"      TEXT        NOT NULL DEFAULT '',
+    "subject"     TEXT        NOT NULL DEFAULT '',
+    "folder"      TEXT        NOT NULL DEFAULT '',
+
+    -- The time of day the rule applies in, like 22:00 to 07:00 for quiet hours. NULL means all day.
+    "hours_start" TEXT CHECK ("hours_start" ~ '^([01][0-9]|2[0-3]):[0-5][0-9]$'),
+    "hours_end"   TEXT CHECK ("hours_end" ~ '^([01][0-9]|2[0-3]):[0-5][0-9]$'),
+    "time_zone"   TEXT,
+
+    "created_at"  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    "updated_at"  TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CHECK (("hours_start" IS NULL) = ("hours_end" IS NULL) AND ("hours_start" IS NULL) = ("time_zone" IS NULL))
+);
+
+CREATE INDEX idx_notification_rules_user_id ON "notification_rules" ("user_id");
+
+COMMENT ON TABLE "notification_rules" IS 'Decides which new messages users get push notifications about, like "only from my boss" or quiet hours.';
+COMMENT ON COLUMN "notification_rules"."action" IS '"notify" or "mute". Once a user has a "notify" rule, only the messages that match one notify. "mute" rules win over "notify" rules. Without "notify" rules, only INBOX notifies.';
+COMMENT ON COLUMN "notification_rules"."sender" IS 'Matches messages whose From header contains this, case-insensitively. Empty matches all.';
+COMMENT ON COLUMN "notification_rules"."subject" IS 'Matches messages whose subject contains this, case-insensitively. Empty matches all.';
+COMMENT ON COLUMN "notification_rules"."folder" IS 'Matches messages in this folder, case-insensitively. Empty matches all.';
+COMMENT ON COLUMN "notification_rules"."hours_start" IS 'The time of day, as HH:MM in time_zone, that the rule starts applying at. If it''s after hours_end, the rule applies overnight. NULL means all day.';
+COMMENT ON COLUMN "notification_rules"."hours_end" IS 'The time of day, as HH:MM in time_zone, that the rule stops applying at.';
+COMMENT ON COLUMN "notification_rules"."time_zone" IS 'The IANA time zone of hours_start and hours_end, like "Europe/Berlin".';
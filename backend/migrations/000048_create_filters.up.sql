@@ -0,0 +1,39 @@
+-- Stores the mail filters of users. During sync, new INBOX messages that match a filter get its actions.
+CREATE TABLE "filters"
+(
+    "id"             UUID PRIMARY KEY     DEFAULT gen_random_uuid(),
+    "user_id"        UUID        NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "name"           TEXT        NOT NULL DEFAULT '',
+
+    -- The conditions. Empty ones match every message, and a message must match all the others.
+    "sender"         TEXT        NOT NULL DEFAULT '',
+    "recipient"      TEXT        NOT NULL DEFAULT '',
+    "subject"        TEXT        NOT NULL DEFAULT '',
+    -- NULL matches messages with and without attachments.
+    "has_attachment" BOOLEAN,
+
+    -- The actions.
+    "mark_read"      BOOLEAN     NOT NULL DEFAULT FALSE,
+    "add_label"      TEXT        NOT NULL DEFAULT '',
+    "move_to"        TEXT        NOT NULL DEFAULT '',
+    "delete"         BOOLEAN     NOT NULL DEFAULT FALSE,
+
+    "created_at"     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    "updated_at"     TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CHECK ("sender" <> '' OR "recipient" <> '' OR "subject" <> '' OR "has_attachment" IS NOT NULL),
+    CHECK ("mark_read" OR "add_label" <> '' OR "move_to" <> '' OR "delete"),
+    CHECK (NOT "delete" OR "move_to" = '')
+);
+
+CREATE INDEX idx_filters_user_id ON "filters" ("user_id");
+
+COMMENT ON TABLE "filters" IS 'Stores the mail filters of users. During sync, new INBOX messages that match a filter get its actions.';
+COMMENT ON COLUMN "filters"."sender" IS 'Matches messages whose From header contains this, case-insensitively. Empty matches all.';
+COMMENT ON COLUMN "filters"."recipient" IS 'Matches messages with a To or Cc address that contains this, case-insensitively. Empty matches all.';
+COMMENT ON COLUMN "filters"."subject" IS 'Matches messages whose subject contains this, case-insensitively. Empty matches all.';
+COMMENT ON COLUMN "filters"."has_attachment" IS 'Matches messages with attachments if true, without them if false, and all if NULL.';
+COMMENT ON COLUMN "filters"."mark_read" IS 'Marks matching messages as read.';
+COMMENT ON COLUMN "filters"."add_label" IS 'Adds this IMAP keyword to matching messages. Empty adds none.';
+COMMENT ON COLUMN "filters"."move_to" IS 'Moves matching messages to this folder. Empty keeps them in INBOX. If several filters match, the oldest one wins.';
+COMMENT ON COLUMN "filters"."delete" IS 'Moves matching messages to Trash. Wins over move_to of other filters.';
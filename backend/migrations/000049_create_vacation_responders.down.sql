@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "vacation_replies";
+DROP TABLE IF EXISTS "vacation_responders";
@@ -0,0 +1,36 @@
+-- Stores the auto-responders of users, which reply to new mail while they're away.
+CREATE TABLE "vacation_responders"
+(
+    "user_id"       UUID PRIMARY KEY REFERENCES "users" ("id") ON DELETE CASCADE,
+    "enabled"       BOOLEAN     NOT NULL DEFAULT FALSE,
+    "subject"       TEXT        NOT NULL DEFAULT '',
+    "body"          TEXT        NOT NULL DEFAULT '',
+    -- NULL means no limit on that side.
+    "starts_at"     TIMESTAMPTZ,
+    "ends_at"       TIMESTAMPTZ,
+    "interval_days" INTEGER     NOT NULL DEFAULT 7 CHECK ("interval_days" BETWEEN 1 AND 365),
+    "mode"          TEXT        NOT NULL DEFAULT 'backend' CHECK ("mode" IN ('backend', 'sieve')),
+    "created_at"    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    "updated_at"    TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CHECK ("starts_at" IS NULL OR "ends_at" IS NULL OR "starts_at" < "ends_at")
+);
+
+COMMENT ON TABLE "vacation_responders" IS 'Stores the auto-responders of users, which reply to new mail while they''re away.';
+COMMENT ON COLUMN "vacation_responders"."subject" IS 'The subject of the replies. Empty means "Auto: " and the subject of the message.';
+COMMENT ON COLUMN "vacation_responders"."starts_at" IS 'Replies start at this time. NULL means right away.';
+COMMENT ON COLUMN "vacation_responders"."ends_at" IS 'Replies stop at this time. NULL means never.';
+COMMENT ON COLUMN "vacation_responders"."interval_days" IS 'Each sender gets at most one reply in this many days.';
+COMMENT ON COLUMN "vacation_responders"."mode" IS 'Who sends the replies: "sieve" if the mail server does it with the Sieve vacation extension, "backend" if the sync does.';
+
+-- Stores who the backend auto-responder replied to, and when, so that each sender gets at most one reply per interval.
+CREATE TABLE "vacation_replies"
+(
+    "user_id"    UUID        NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "sender"     TEXT        NOT NULL,
+    "replied_at" TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY ("user_id", "sender")
+);
+
+COMMENT ON TABLE "vacation_replies" IS 'Stores who the backend auto-responder replied to, and when, so that each sender gets at most one reply per interval.';
+COMMENT ON COLUMN "vacation_replies"."sender" IS 'The lowercase address that got the reply.';
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_threads_user_last_sent_at;
+
+DROP TRIGGER IF EXISTS messages_thread_last_sent_at_update ON "messages";
+DROP FUNCTION IF EXISTS messages_thread_last_sent_at_update();
+
+ALTER TABLE "threads"
+DROP COLUMN IF EXISTS "last_sent_at";
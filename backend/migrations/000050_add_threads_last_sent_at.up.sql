@@ -0,0 +1,42 @@
+-- The date of each thread's latest message, for sorting thread lists.
+-- The column is denormalized, so that thread lists can page through the index instead of sorting all threads.
+ALTER TABLE "threads"
+ADD COLUMN "last_sent_at" TIMESTAMPTZ;
+
+COMMENT ON COLUMN "threads"."last_sent_at" IS 'The latest sent_at of the thread''s cached messages, or NULL if none has a date. Kept up to date by the messages_thread_last_sent_at_update trigger.';
+
+CREATE FUNCTION messages_thread_last_sent_at_update() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        UPDATE "threads"
+        SET last_sent_at = (SELECT MAX(sent_at) FROM "messages" WHERE thread_id = OLD.thread_id)
+        WHERE id = OLD.thread_id;
+    END IF;
+    IF TG_OP = 'UPDATE' AND NEW.thread_id <> OLD.thread_id THEN
+        UPDATE "threads"
+        SET last_sent_at = (SELECT MAX(sent_at) FROM "messages" WHERE thread_id = NEW.thread_id)
+        WHERE id = NEW.thread_id;
+    END IF;
+    IF TG_OP = 'INSERT' AND NEW.sent_at IS NOT NULL THEN
+        UPDATE "threads"
+        SET last_sent_at = NEW.sent_at
+        WHERE id = NEW.thread_id AND (last_sent_at IS NULL OR last_sent_at < NEW.sent_at);
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER messages_thread_last_sent_at_update
+    AFTER INSERT OR DELETE OR UPDATE OF thread_id, sent_at
+    ON "messages"
+    FOR EACH ROW
+EXECUTE FUNCTION messages_thread_last_sent_at_update();
+
+UPDATE "threads" t
+SET last_sent_at = latest.last_sent_at
+FROM (SELECT thread_id, MAX(sent_at) AS last_sent_at FROM "messages" GROUP BY thread_id) latest
+WHERE latest.thread_id = t.id;
+
+-- Threads without dated messages sort last, as "-infinity"
+CREATE INDEX idx_threads_user_last_sent_at ON "threads" ("user_id", (COALESCE("last_sent_at", '-infinity'::timestamptz)), "id");
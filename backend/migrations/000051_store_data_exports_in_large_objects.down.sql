@@ -0,0 +1,11 @@
+DROP TRIGGER IF EXISTS data_exports_file_unlink ON "data_exports";
+DROP FUNCTION IF EXISTS data_exports_file_unlink();
+
+SELECT lo_unlink("file_oid") FROM "data_exports" WHERE "file_oid" IS NOT NULL;
+DELETE FROM "data_exports" WHERE "status" = 'done';
+
+ALTER TABLE "data_exports"
+DROP COLUMN IF EXISTS "file_oid",
+ADD COLUMN "data" BYTEA;
+
+COMMENT ON COLUMN "data_exports"."data" IS 'The zip file. NULL unless the status is "done".';
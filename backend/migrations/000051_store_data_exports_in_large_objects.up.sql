@@ -0,0 +1,26 @@
+-- Data exports keep their zip file in a large object instead of a BYTEA column, so that saving and downloading it can
+-- stream it instead of holding all of it in memory.
+-- The finished exports of the old column are dropped. Users can start a new export.
+DELETE FROM "data_exports" WHERE "status" = 'done';
+
+ALTER TABLE "data_exports"
+DROP COLUMN "data",
+ADD COLUMN "file_oid" OID;
+
+COMMENT ON COLUMN "data_exports"."file_oid" IS 'The large object with the zip file. NULL unless the status is "done". The data_exports_file_unlink trigger deletes it with the export.';
+
+CREATE FUNCTION data_exports_file_unlink() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF OLD.file_oid IS NOT NULL AND (TG_OP = 'DELETE' OR NEW.file_oid IS DISTINCT FROM OLD.file_oid) THEN
+        PERFORM lo_unlink(OLD.file_oid);
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER data_exports_file_unlink
+    AFTER DELETE OR UPDATE OF file_oid
+    ON "data_exports"
+    FOR EACH ROW
+EXECUTE FUNCTION data_exports_file_unlink();
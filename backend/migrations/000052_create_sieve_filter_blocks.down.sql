@@ -0,0 +1 @@
+DROP TABLE IF EXISTS "sieve_filter_blocks";
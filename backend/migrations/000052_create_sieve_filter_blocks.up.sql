@@ -0,0 +1,12 @@
+-- Stores where V-Mail last wrote the user's filters to their Sieve scripts, as a block of the active script.
+CREATE TABLE "sieve_filter_blocks"
+(
+    "user_id"     UUID PRIMARY KEY     REFERENCES "users" ("id") ON DELETE CASCADE,
+    "script_name" TEXT        NOT NULL,
+    "block_hash"  TEXT        NOT NULL,
+    "updated_at"  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+COMMENT ON TABLE "sieve_filter_blocks" IS 'Stores where V-Mail last wrote the user''s filters to their Sieve scripts, as a block of the active script.';
+COMMENT ON COLUMN "sieve_filter_blocks"."script_name" IS 'The name of the script on the ManageSieve server that has the block.';
+COMMENT ON COLUMN "sieve_filter_blocks"."block_hash" IS 'The SHA-256 of the block that V-Mail wrote, in hex, so that the next sync notices edits made on the server.';
@@ -0,0 +1,6 @@
+-- Track which actions a worker is running, so that it can run them outside a transaction and cancelling them fails
+-- right away instead of waiting for it.
+ALTER TABLE "action_queue"
+ADD COLUMN "status" TEXT NOT NULL DEFAULT 'queued' CHECK ("status" IN ('queued', 'running'));
+
+COMMENT ON COLUMN "action_queue"."status" IS '"queued" until a worker claims the action, then "running". A running action''s process_at is when its claim runs out, after which another worker may run it again, in case the first one stopped.';
@@ -0,0 +1,4 @@
+DELETE FROM "sync_changes" WHERE "thread_id" IS NULL;
+
+ALTER TABLE "sync_changes"
+ALTER COLUMN "thread_id" SET NOT NULL;
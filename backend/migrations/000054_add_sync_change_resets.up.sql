@@ -0,0 +1,6 @@
+-- Clearing a user's mail cache replaces their change log with one row without a thread, which tells clients whose
+-- cursor is older to refetch everything.
+ALTER TABLE "sync_changes"
+ALTER COLUMN "thread_id" DROP NOT NULL;
+
+COMMENT ON COLUMN "sync_changes"."thread_id" IS 'NULL for a reset: the whole cache of the user changed, so deltas across it return reset.';
@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL migrations of the database, so that the binaries can run them.
+// The files follow the golang-migrate naming: "000001_name.up.sql" and "000001_name.down.sql".
+package migrations
+
+import "embed"
+
+// FS has the .sql files of this directory.
+//
+//go:embed *.sql
+var FS embed.FS
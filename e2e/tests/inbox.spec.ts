@@ -1,6 +1,11 @@
 import { test, expect } from '@playwright/test'
 
-import { clickFirstEmail, setupInboxForNavigation, setupInboxTest } from '../utils/helpers'
+import {
+    callTestEndpoint,
+    clickFirstEmail,
+    setupInboxForNavigation,
+    setupInboxTest,
+} from '../utils/helpers'
 
 /**
  * Test 2: Existing User Read-Only Flow
@@ -215,6 +220,36 @@ test.describe('Existing User Read-Only Flow', () => {
         expect(updatedSubjects).not.toEqual(initialSubjects)
     })
 
+    test('removes emails that were deleted on the server', async ({ page }) => {
+        const result = await setupInboxTest(page)
+        if (!result) {
+            // Skip if redirected to settings
+            return
+        }
+
+        const messageId = `<e2e-delete-${Date.now()}@vmail.local>`
+        const subject = 'E2E Deleted Elsewhere'
+        expect(
+            await callTestEndpoint(page, '/test/add-imap-message', {
+                subject,
+                from: 'sender@example.com',
+                to: 'username@example.com',
+                message_id: messageId,
+                text: 'This message has an attachment.',
+                attachments: [
+                    { filename: 'notes.txt', content_type: 'text/plain', data: btoa('Notes') },
+                ],
+            }),
+        ).toBe(204)
+        const subjectLocator = page.locator('[data-testid="email-subject"]', { hasText: subject })
+        await expect(subjectLocator).toBeVisible({ timeout: 15000 })
+
+        expect(
+            await callTestEndpoint(page, '/test/delete-imap-message', { message_id: messageId }),
+        ).toBe(204)
+        await expect(subjectLocator).toHaveCount(0, { timeout: 15000 })
+    })
+
     test('clicking email navigates to thread with correct URL and displays body', async ({
         page,
     }) => {
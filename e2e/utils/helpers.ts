@@ -208,3 +208,24 @@ export async function setupInboxWithRedirectCheck(page: Page): Promise<boolean>
     return true
 }
 
+
+/**
+ * Calls a test-only backend endpoint, like `/test/add-imap-message`, from the page context,
+ * so that the request goes through the route interceptors. Returns the response status.
+ * See docs/testing.md for the endpoints.
+ */
+export async function callTestEndpoint(page: Page, path: string, body: object): Promise<number> {
+    return page.evaluate(
+        async ({ path, body }) => {
+            const res = await fetch(path, {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                },
+                body: JSON.stringify(body),
+            })
+            return res.status
+        },
+        { path, body },
+    )
+}
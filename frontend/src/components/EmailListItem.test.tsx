@@ -70,6 +70,42 @@ describe('EmailListItem', () => {
         expect(senderElement).not.toHaveTextContent('1')
     })
 
+    it('shows threads with unread messages as unread', () => {
+        const thread: Thread = {
+            id: '1',
+            stable_thread_id: 'thread-1',
+            subject: 'Test Thread',
+            user_id: 'user-1',
+            first_message_from_address: 'sender@example.com',
+            has_attachments: false,
+            message_count: 3,
+            unread_count: 1,
+            last_sent_at: '2025-01-15T10:00:00Z',
+        }
+
+        render(<EmailListItem thread={thread} />, { wrapper: createWrapper() })
+
+        expect(screen.getByTestId('email-subject')).toHaveClass('font-semibold')
+    })
+
+    it('shows threads without unread messages as read', () => {
+        const thread: Thread = {
+            id: '1',
+            stable_thread_id: 'thread-1',
+            subject: 'Test Thread',
+            user_id: 'user-1',
+            first_message_from_address: 'sender@example.com',
+            has_attachments: false,
+            message_count: 3,
+            unread_count: 0,
+            last_sent_at: '2025-01-15T10:00:00Z',
+        }
+
+        render(<EmailListItem thread={thread} />, { wrapper: createWrapper() })
+
+        expect(screen.getByTestId('email-subject')).not.toHaveClass('font-semibold')
+    })
+
     it('displays formatted date from last_sent_at (time if today)', () => {
         const thread: Thread = {
             id: '1',
@@ -261,6 +297,48 @@ describe('EmailListItem', () => {
         expect(attachmentMarker).not.toBeInTheDocument()
     })
 
+    it('marks threads that only the mail server found', () => {
+        const thread: Thread = {
+            id: '1',
+            stable_thread_id: 'thread-1',
+            subject: 'Test Thread',
+            user_id: 'user-1',
+            first_message_from_address: 'sender@example.com',
+            has_attachments: false,
+            message_count: 1,
+            last_sent_at: '2025-01-15T10:00:00Z',
+            from_server: true,
+        }
+
+        render(<EmailListItem thread={thread} />, { wrapper: createWrapper() })
+
+        const marker = screen.getByTitle('Found on the mail server, not in the local cache')
+        expect(marker).toHaveTextContent('Server')
+    })
+
+    it("doesn't open threads of uncached messages that the mail server found", () => {
+        const thread: Thread = {
+            id: '',
+            stable_thread_id: '<pruned@example.com>',
+            subject: 'Old news',
+            user_id: 'user-1',
+            first_message_from_address: 'sender@example.com',
+            has_attachments: false,
+            message_count: 1,
+            last_sent_at: '2025-01-15T10:00:00Z',
+            from_server: true,
+        }
+
+        render(<EmailListItem thread={thread} />, { wrapper: createWrapper() })
+
+        expect(
+            screen.getByTitle("Found on the mail server. It's not cached, so it can't be opened here"),
+        ).toHaveTextContent('Server')
+        const link = screen.getByRole('link')
+        expect(link).toHaveAttribute('aria-disabled', 'true')
+        expect(link.dispatchEvent(new MouseEvent('click', { bubbles: true, cancelable: true }))).toBe(false)
+    })
+
     it('displays sender name with exactly 20 characters fully', () => {
         const name20Chars = 'A'.repeat(20)
         const thread: Thread = {
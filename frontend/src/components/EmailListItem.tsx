@@ -47,7 +47,7 @@ function getPreviewSnippet(
     if (snippetText.length === 0) {
         return ''
     }
-    // Backend already limits to 100 chars, but we'll truncate further if needed for display
+    // Backend limits snippets to 200 chars, but we truncate further for display
     return snippetText.length > 100 ? snippetText.slice(0, 100) + '...' : snippetText
 }
 
@@ -75,6 +75,15 @@ function truncateSenderName(name: string): string {
     return name
 }
 
+function isThreadUnread(thread: Thread): boolean {
+    // List views have unread_count, the thread view has the messages
+    if (thread.unread_count !== undefined) {
+        return thread.unread_count > 0
+    }
+    const firstMessage = thread.messages?.[0]
+    return firstMessage ? !firstMessage.is_read : false
+}
+
 function getLinkClassName(isSelected: boolean, isUnread: boolean): string {
     const baseClasses =
         'grid grid-cols-[24px_32px_200px_1fr_24px_80px] items-center gap-2 px-4 py-2 sm:px-6 hover:bg-white/5 focus-visible:outline-2 focus-visible:outline-blue-400'
@@ -110,7 +119,7 @@ export default function EmailListItem({ thread, isSelected }: EmailListItemProps
     const senderName = truncateSenderName(extractSenderName(fromAddress))
     const subject = thread.subject || '(No subject)'
     const threadCount = thread.message_count || 1
-    const isUnread = firstMessage ? !firstMessage.is_read : false
+    const isUnread = isThreadUnread(thread)
     const isStarred = firstMessage?.is_starred || false
     const previewSnippet = getPreviewSnippet(thread.preview_snippet, firstMessage?.body_text)
     // Use last_sent_at from thread (for list views) or fallback to first message sent_at (for detail views)
@@ -128,6 +137,13 @@ export default function EmailListItem({ thread, isSelected }: EmailListItemProps
             onKeyDown={(e) => {
                 handleKeyDown(e, isSelected ?? false)
             }}
+            onClick={(e) => {
+                // Threads of uncached search hits have no ID, and the thread view can't open them
+                if (!thread.id) {
+                    e.preventDefault()
+                }
+            }}
+            aria-disabled={!thread.id || undefined}
             tabIndex={isSelected ? -1 : 0}
         >
             {/* Checkbox column */}
@@ -172,6 +188,18 @@ export default function EmailListItem({ thread, isSelected }: EmailListItemProps
                     <span data-testid='email-subject' className={subjectClassName}>
                         {subject}
                     </span>
+                    {thread.from_server && (
+                        <span
+                            className='shrink-0 rounded bg-slate-700 px-1 text-xs text-slate-300'
+                            title={
+                                thread.id
+                                    ? 'Found on the mail server, not in the local cache'
+                                    : "Found on the mail server. It's not cached, so it can't be opened here"
+                            }
+                        >
+                            Server
+                        </span>
+                    )}
                     {previewSnippet && (
                         <>
                             <span className='opacity-60 text-slate-400'> - </span>
@@ -32,6 +32,8 @@ describe('EmailListPagination', () => {
                     total_count: 300,
                     page: 1,
                     per_page: 100,
+                    total_estimated: false,
+                    next_cursor: null,
                 }}
             />,
             { wrapper: createWrapper() },
@@ -47,6 +49,8 @@ describe('EmailListPagination', () => {
                     total_count: 50,
                     page: 1,
                     per_page: 100,
+                    total_estimated: false,
+                    next_cursor: null,
                 }}
             />,
             { wrapper: createWrapper() },
@@ -62,6 +66,8 @@ describe('EmailListPagination', () => {
                     total_count: 300,
                     page: 2,
                     per_page: 100,
+                    total_estimated: false,
+                    next_cursor: null,
                 }}
             />,
             { wrapper: createWrapper(['/?folder=INBOX&page=2']) },
@@ -78,6 +84,8 @@ describe('EmailListPagination', () => {
                     total_count: 300,
                     page: 1,
                     per_page: 100,
+                    total_estimated: false,
+                    next_cursor: null,
                 }}
             />,
             { wrapper: createWrapper(['/?folder=INBOX&page=1']) },
@@ -94,6 +102,8 @@ describe('EmailListPagination', () => {
                     total_count: 300,
                     page: 3,
                     per_page: 100,
+                    total_estimated: false,
+                    next_cursor: null,
                 }}
             />,
             { wrapper: createWrapper(['/?folder=INBOX&page=3']) },
@@ -119,6 +129,8 @@ describe('EmailListPagination', () => {
                             total_count: 300,
                             page: 1,
                             per_page: 100,
+                            total_estimated: false,
+                            next_cursor: null,
                         }}
                     />
                 </MemoryRouter>
@@ -149,6 +161,8 @@ describe('EmailListPagination', () => {
                             total_count: 300,
                             page: 2,
                             per_page: 100,
+                            total_estimated: false,
+                            next_cursor: null,
                         }}
                     />
                 </MemoryRouter>
@@ -2,17 +2,28 @@
    We sanitize the content so it should be safe. */
 import DOMPurify from 'dompurify'
 
-import type { Message as MessageType } from '../lib/api'
+import type { LinkWarning, Message as MessageType } from '../lib/api'
+import { rewriteInlineImages } from '../lib/inlineImages'
+import { blockRemoteImages, proxyRemoteImages } from '../lib/remoteImages'
 
 interface MessageProps {
     message: MessageType
+    /** Called when the user wants to always see remote images from the sender. */
+    onTrustSender?: (email: string) => void
 }
 
-export default function Message({ message }: MessageProps) {
+export default function Message({ message, onTrustSender }: MessageProps) {
     // Sanitize the HTML content before rendering
     const sanitizedHTML = message.unsafe_body_html
         ? DOMPurify.sanitize(message.unsafe_body_html)
         : ''
+    // Remote images tell the sender when we opened the message, so only trusted senders get them,
+    // and even then through our image proxy, so that the sender doesn't see the user's IP address
+    const { html: htmlWithRemoteImagesHandled, blockedImageCount } = message.remote_images_allowed
+        ? { html: proxyRemoteImages(sanitizedHTML), blockedImageCount: 0 }
+        : blockRemoteImages(sanitizedHTML)
+    // Inline images come from our own server, so this goes after handling the remote ones
+    const html = rewriteInlineImages(htmlWithRemoteImagesHandled, message.id)
 
     const formatDate = (dateString: string | null) => {
         if (!dateString) return ''
@@ -21,6 +32,7 @@ export default function Message({ message }: MessageProps) {
     }
 
     const attachments = message.attachments?.filter((att) => !att.is_inline) ?? []
+    const linkWarnings = message.link_analysis?.warnings ?? []
 
     return (
         <article className='rounded-3xl border border-white/5 bg-slate-950/50 p-5 text-slate-100 shadow-[0_25px_50px_-12px_rgba(15,23,42,0.8)]'>
@@ -66,10 +78,43 @@ export default function Message({ message }: MessageProps) {
                     </ul>
                 </div>
             )}
-            {sanitizedHTML ? (
+            {message.authenticity && !message.authenticity.verified && (
+                <div className='mt-4 rounded-2xl border border-amber-400/30 bg-amber-400/10 px-4 py-2 text-xs text-amber-100'>
+                    <span className='font-semibold'>Unverified sender.</span> Your mail server couldn't
+                    verify that this message is from {message.from_address}.
+                </div>
+            )}
+            {linkWarnings.length > 0 && (
+                <div className='mt-4 rounded-2xl border border-amber-400/30 bg-amber-400/10 px-4 py-2 text-xs text-amber-100'>
+                    <p className='font-semibold'>Be careful with the links in this message.</p>
+                    <ul className='mt-1 space-y-1'>
+                        {linkWarnings.map((warning) => (
+                            <li key={`${warning.kind} ${warning.url}`}>
+                                {describeLinkWarning(warning)}
+                            </li>
+                        ))}
+                    </ul>
+                </div>
+            )}
+            {blockedImageCount > 0 && (
+                <div className='mt-4 flex items-center justify-between gap-3 rounded-2xl bg-white/5 px-4 py-2 text-xs text-slate-300'>
+                    <span>Remote images are hidden to protect your privacy.</span>
+                    {onTrustSender && (
+                        <button
+                            onClick={() => {
+                                onTrustSender(message.from_address)
+                            }}
+                            className='font-semibold text-white transition hover:text-slate-200'
+                        >
+                            Always show images from this sender
+                        </button>
+                    )}
+                </div>
+            )}
+            {html ? (
                 <div
                     className='prose prose-sm max-w-none text-slate-100'
-                    dangerouslySetInnerHTML={{ __html: sanitizedHTML }}
+                    dangerouslySetInnerHTML={{ __html: html }}
                 />
             ) : (
                 message.body_text && (
@@ -82,6 +127,17 @@ export default function Message({ message }: MessageProps) {
     )
 }
 
+function describeLinkWarning(warning: LinkWarning): string {
+    switch (warning.kind) {
+        case 'mismatched_text':
+            return `A link shows "${warning.text ?? ''}", but goes to ${warning.domain}.`
+        case 'lookalike_domain':
+            return `A link goes to ${warning.domain}, which imitates another domain with lookalike letters.`
+        case 'suspicious_tld':
+            return `A link goes to ${warning.domain}, on a domain ending that's popular with phishing.`
+    }
+}
+
 function formatFileSize(bytes: number): string {
     if (bytes < 1024) return `${String(bytes)} B`
     if (bytes < 1024 * 1024) return `${(bytes / 1024).toFixed(1)} KB`
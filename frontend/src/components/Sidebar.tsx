@@ -11,12 +11,26 @@ interface SidebarProps {
 
 const ROLE_ORDER: Record<Folder['role'], number> = {
     inbox: 0,
-    sent: 1,
-    drafts: 2,
-    spam: 3,
-    trash: 4,
-    archive: 5,
-    other: 6,
+    starred: 1,
+    snoozed: 2,
+    sent: 3,
+    drafts: 4,
+    spam: 5,
+    trash: 6,
+    archive: 7,
+    all: 8,
+    other: 9,
+}
+
+/** Virtual folders have short names for URLs, like "all", but they should look like the other folders. */
+const VIRTUAL_FOLDER_LABELS: Partial<Record<Folder['role'], string>> = {
+    starred: 'Starred',
+    snoozed: 'Snoozed',
+    all: 'All Mail',
+}
+
+function getFolderLabel(folder: Folder): string {
+    return (folder.virtual && VIRTUAL_FOLDER_LABELS[folder.role]) || folder.name
 }
 
 export default function Sidebar({ isMobileOpen = false, onClose }: SidebarProps) {
@@ -112,9 +126,9 @@ export default function Sidebar({ isMobileOpen = false, onClose }: SidebarProps)
                                     }`}
                                     aria-hidden='true'
                                 >
-                                    {folder.name.slice(0, 2).toUpperCase()}
+                                    {getFolderLabel(folder).slice(0, 2).toUpperCase()}
                                 </span>
-                                <span className='truncate'>{folder.name}</span>
+                                <span className='truncate'>{getFolderLabel(folder)}</span>
                             </Link>
                         )
                     })
@@ -6,7 +6,7 @@ import { BrowserRouter } from 'react-router-dom'
 import { afterEach, beforeEach, describe, expect, it, vi } from 'vitest'
 
 import * as api from '../lib/api'
-import type { UserSettings } from '../lib/api'
+import type { UserPreferences } from '../lib/api'
 import { useUIStore } from '../store/ui.store'
 
 import { useKeyboardShortcuts } from './useKeyboardShortcuts'
@@ -30,7 +30,7 @@ vi.mock('../lib/api', async (importOriginal) => {
     return {
         ...actual,
         api: {
-            getSettings: vi.fn(),
+            getPreferences: vi.fn(),
             getThreads: vi.fn(),
         },
     }
@@ -59,9 +59,9 @@ type MockThread = {
 }
 
 const setupMockThreadsAndQueryClient = (mockThreads: MockThread[]) => {
-    // Mock settings first
+    // Mock preferences first
     // eslint-disable-next-line @typescript-eslint/unbound-method
-    vi.mocked(api.api.getSettings).mockResolvedValue(createMockSettings())
+    vi.mocked(api.api.getPreferences).mockResolvedValue(createMockPreferences())
 
     // Mock threads response with the correct format
     // eslint-disable-next-line @typescript-eslint/unbound-method
@@ -71,6 +71,8 @@ const setupMockThreadsAndQueryClient = (mockThreads: MockThread[]) => {
             total_count: mockThreads.length,
             page: 1,
             per_page: 100,
+            total_estimated: false,
+            next_cursor: null,
         },
     })
 
@@ -81,16 +83,11 @@ const setupMockThreadsAndQueryClient = (mockThreads: MockThread[]) => {
     })
 }
 
-function createMockSettings(overrides: Partial<UserSettings> = {}): UserSettings {
+function createMockPreferences(overrides: Partial<UserPreferences> = {}): UserPreferences {
     return {
-        imap_server_hostname: 'imap.example.com',
-        imap_username: 'user@example.com',
-        imap_password: 'password',
-        smtp_server_hostname: 'smtp.example.com',
-        smtp_username: 'user@example.com',
-        smtp_password: 'password',
         undo_send_delay_seconds: 20,
         pagination_threads_per_page: 100,
+        ui: {},
         ...overrides,
     }
 }
@@ -109,6 +106,8 @@ const waitForQueryToResolve = async (queryClient: QueryClient, mockThreads: Mock
                     total_count: mockThreads.length,
                     page: 1,
                     per_page: 100,
+                    total_estimated: false,
+                    next_cursor: null,
                 },
             })
         }
@@ -144,7 +143,7 @@ describe('useKeyboardShortcuts', () => {
     it('adds and removes event listeners on mount/unmount', () => {
         // Mock settings to avoid query warning
         // eslint-disable-next-line @typescript-eslint/unbound-method
-        vi.mocked(api.api.getSettings).mockResolvedValue(createMockSettings())
+        vi.mocked(api.api.getPreferences).mockResolvedValue(createMockPreferences())
 
         const addEventListenerSpy = vi.spyOn(window, 'addEventListener')
         const removeEventListenerSpy = vi.spyOn(window, 'removeEventListener')
@@ -217,7 +216,7 @@ describe('useKeyboardShortcuts', () => {
     it('decrements selected index when "k" is pressed', () => {
         // Mock settings to avoid query warning
         // eslint-disable-next-line @typescript-eslint/unbound-method
-        vi.mocked(api.api.getSettings).mockResolvedValue(createMockSettings())
+        vi.mocked(api.api.getPreferences).mockResolvedValue(createMockPreferences())
 
         useUIStore.setState({ selectedThreadIndex: 1 })
 
@@ -238,7 +237,7 @@ describe('useKeyboardShortcuts', () => {
     it('decrements selected index when ArrowUp is pressed', () => {
         // Mock settings to avoid query warning
         // eslint-disable-next-line @typescript-eslint/unbound-method
-        vi.mocked(api.api.getSettings).mockResolvedValue(createMockSettings())
+        vi.mocked(api.api.getPreferences).mockResolvedValue(createMockPreferences())
 
         useUIStore.setState({ selectedThreadIndex: 1 })
 
@@ -315,7 +314,7 @@ describe('useKeyboardShortcuts', () => {
     it('does not handle shortcuts when typing in input fields', () => {
         // Mock settings to avoid query warning
         // eslint-disable-next-line @typescript-eslint/unbound-method
-        vi.mocked(api.api.getSettings).mockResolvedValue(createMockSettings())
+        vi.mocked(api.api.getPreferences).mockResolvedValue(createMockPreferences())
 
         renderHook(
             () => {
@@ -18,19 +18,19 @@ export function useKeyboardShortcuts() {
         setSelectedThreadIndex,
     } = useUIStore()
 
-    // Get user settings to determine pagination limit
-    const { data: settings } = useQuery({
-        queryKey: ['settings'],
-        queryFn: () => api.getSettings(),
+    // Get user preferences to determine pagination limit
+    const { data: preferences } = useQuery({
+        queryKey: ['preferences'],
+        queryFn: () => api.getPreferences(),
     })
 
-    const limit = settings?.pagination_threads_per_page ?? 100
+    const limit = preferences?.pagination_threads_per_page ?? 100
 
     // Get threads for navigation
     const { data: threadsResponse } = useQuery({
         queryKey: ['threads', folder, 1, limit],
         queryFn: () => api.getThreads(folder, 1, limit),
-        enabled: location.pathname === '/' && !!settings,
+        enabled: location.pathname === '/' && !!preferences,
     })
 
     const threads = threadsResponse?.threads ?? null
@@ -2,10 +2,21 @@ import { QueryClient, QueryClientProvider } from '@tanstack/react-query'
 import { act, render } from '@testing-library/react'
 import { describe, expect, it, vi, beforeEach, afterEach } from 'vitest'
 
+import * as api from '../lib/api'
 import { useConnectionStore } from '../store/connection.store'
 
 import { useWebSocket } from './useWebSocket'
 
+vi.mock('../lib/api', async (importOriginal) => {
+    const actual = await importOriginal<typeof import('../lib/api')>()
+    return {
+        ...actual,
+        api: {
+            getWebSocketToken: vi.fn(),
+        },
+    }
+})
+
 class MockSocket {
     static instances: MockSocket[] = []
     onopen: (() => void) | null = null
@@ -34,12 +45,16 @@ function TestComponent() {
     return null
 }
 
-function renderWithClient(queryClient: QueryClient) {
-    return render(
-        <QueryClientProvider client={queryClient}>
-            <TestComponent />
-        </QueryClientProvider>,
-    )
+/** Renders the hook, and waits for it to get its token and open the socket. */
+async function renderWithClient(queryClient: QueryClient) {
+    await act(async () => {
+        render(
+            <QueryClientProvider client={queryClient}>
+                <TestComponent />
+            </QueryClientProvider>,
+        )
+        await Promise.resolve()
+    })
 }
 
 describe('useWebSocket', () => {
@@ -51,14 +66,27 @@ describe('useWebSocket', () => {
             forceReconnectToken: 0,
         })
         vi.stubGlobal('WebSocket', MockSocket as unknown as typeof WebSocket)
+        vi.mocked(api.api.getWebSocketToken).mockResolvedValue({
+            token: 'ws-token',
+            expires_at: '2025-01-01T00:01:00Z',
+        })
     })
 
     afterEach(() => {
         MockSocket.instances = []
         vi.unstubAllGlobals()
+        vi.clearAllMocks()
     })
 
-    it('invalidates threads query when new_email message is received', () => {
+    it('connects with a token from the API', async () => {
+        await renderWithClient(new QueryClient())
+
+        expect(api.api.getWebSocketToken).toHaveBeenCalledTimes(1)
+        expect(MockSocket.instances).toHaveLength(1)
+        expect(MockSocket.instances[0].url).toMatch(/\/api\/v1\/ws\?token=ws-token$/)
+    })
+
+    it('invalidates threads query when new_message event is received', async () => {
         const queryClient = new QueryClient({
             defaultOptions: {
                 queries: { retry: false },
@@ -68,7 +96,7 @@ describe('useWebSocket', () => {
             .spyOn(queryClient, 'invalidateQueries')
             .mockResolvedValue(undefined)
 
-        renderWithClient(queryClient)
+        await renderWithClient(queryClient)
 
         // Grab the created mock socket instance.
         const socket = MockSocket.instances[0]
@@ -76,7 +104,7 @@ describe('useWebSocket', () => {
 
         act(() => {
             const event = new MessageEvent('message', {
-                data: JSON.stringify({ type: 'new_email', folder: 'INBOX' }),
+                data: JSON.stringify({ type: 'new_message', folder: 'INBOX', count: 1 }),
             })
             socket.onmessage?.(event)
         })
@@ -86,4 +114,55 @@ describe('useWebSocket', () => {
             exact: false,
         })
     })
+
+    it('invalidates the thread and all thread lists when thread_updated event is received', async () => {
+        const queryClient = new QueryClient({
+            defaultOptions: {
+                queries: { retry: false },
+            },
+        })
+        const invalidateSpy = vi
+            .spyOn(queryClient, 'invalidateQueries')
+            .mockResolvedValue(undefined)
+
+        await renderWithClient(queryClient)
+
+        const socket = MockSocket.instances[0]
+        expect(socket).toBeDefined()
+
+        act(() => {
+            const event = new MessageEvent('message', {
+                data: JSON.stringify({
+                    type: 'thread_updated',
+                    thread_id: '<root@example.com>',
+                    folder: 'Archive',
+                }),
+            })
+            socket.onmessage?.(event)
+        })
+
+        expect(invalidateSpy).toHaveBeenCalledWith({
+            queryKey: ['thread', '<root@example.com>'],
+            exact: false,
+        })
+        expect(invalidateSpy).toHaveBeenCalledWith({ queryKey: ['threads'], exact: false })
+    })
+
+    it('ignores sync_started events', async () => {
+        const queryClient = new QueryClient()
+        const invalidateSpy = vi
+            .spyOn(queryClient, 'invalidateQueries')
+            .mockResolvedValue(undefined)
+
+        await renderWithClient(queryClient)
+
+        act(() => {
+            const event = new MessageEvent('message', {
+                data: JSON.stringify({ type: 'sync_started', folder: 'INBOX' }),
+            })
+            MockSocket.instances[0].onmessage?.(event)
+        })
+
+        expect(invalidateSpy).not.toHaveBeenCalled()
+    })
 })
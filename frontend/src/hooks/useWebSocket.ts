@@ -1,8 +1,29 @@
 import { useQueryClient } from '@tanstack/react-query'
 import { useEffect, useRef } from 'react'
 
+import { api } from '../lib/api'
 import { useConnectionStore } from '../store/connection.store'
 
+/** WebSocket event types that mean the thread list of `folder` changed. */
+const folderEventTypes = new Set([
+    'new_message',
+    'flags_changed',
+    'message_deleted',
+    'sync_finished',
+    'sync_complete',
+])
+
+/** Returns the URL of the WebSocket with the token from `api.getWebSocketToken`. */
+function webSocketUrl(token: string) {
+    const wsEnvUrl = import.meta.env.VITE_WS_URL as string | undefined
+    const baseUrl =
+        wsEnvUrl && wsEnvUrl.length > 0
+            ? wsEnvUrl
+            : `${window.location.origin.replace(/^http/, 'ws')}/api/v1/ws`
+    const separator = baseUrl.includes('?') ? '&' : '?'
+    return `${baseUrl}${separator}token=${encodeURIComponent(token)}`
+}
+
 export function useWebSocket() {
     const queryClient = useQueryClient()
     const { setStatus, setLastError, forceReconnectToken } = useConnectionStore()
@@ -39,83 +60,101 @@ export function useWebSocket() {
 
         setStatus('connecting')
 
-        // Get the token (currently hardcoded as "token", same as used in API calls).
-        // TODO: When Authelia is implemented, this should get the actual JWT token.
-        const token = 'token'
-
-        const wsEnvUrl = import.meta.env.VITE_WS_URL as string | undefined
-        let wsUrl: string
-        if (wsEnvUrl && wsEnvUrl.length > 0) {
-            wsUrl = wsEnvUrl
-        } else {
-            const baseUrl = `${window.location.origin.replace(/^http/, 'ws')}/api/v1/ws`
-            // Append token as query parameter since WebSocket connections can't set headers.
-            wsUrl = `${baseUrl}?token=${encodeURIComponent(token)}`
-        }
-
-        // Connect
-        const socket = new WebSocket(wsUrl)
-        const socketInstance = socket
-        socketRef.current = socket
-        socketCreationTimeRef.current = Date.now()
-
-        socket.onopen = () => {
-            // Only update state if this is still the current socket
-            if (socketRef.current === socketInstance) {
-                setStatus('connected')
-                setLastError(null)
-            } else {
-                // Connection opened but socket ref changed (StrictMode)
-                socket.close()
+        // Browsers can't set headers on WebSockets, so we get a short-lived token for the URL first
+        let cancelled = false
+        let openedSocket: WebSocket | null = null
+        const connect = (token: string) => {
+            const socket = new WebSocket(webSocketUrl(token))
+            const socketInstance = socket
+            openedSocket = socket
+            socketRef.current = socket
+            socketCreationTimeRef.current = Date.now()
+
+            socket.onopen = () => {
+                // Only update state if this is still the current socket
+                if (socketRef.current === socketInstance) {
+                    setStatus('connected')
+                    setLastError(null)
+                } else {
+                    // Connection opened but socket ref changed (StrictMode)
+                    socket.close()
+                }
             }
-        }
 
-        socket.onerror = (error) => {
-            // eslint-disable-next-line no-console -- We do want to log this in production too
-            console.error('WebSocket: Error occurred', error, 'readyState:', socket.readyState)
-            if (socketRef.current === socketInstance) {
-                setStatus('disconnected')
-                setLastError('WebSocket error')
+            socket.onerror = (error) => {
+                // eslint-disable-next-line no-console -- We do want to log this in production too
+                console.error('WebSocket: Error occurred', error, 'readyState:', socket.readyState)
+                if (socketRef.current === socketInstance) {
+                    setStatus('disconnected')
+                    setLastError('WebSocket error')
+                }
             }
-        }
 
-        socket.onclose = () => {
-            if (socketRef.current === socketInstance) {
-                socketRef.current = null
-                setStatus('disconnected')
+            socket.onclose = () => {
+                if (socketRef.current === socketInstance) {
+                    socketRef.current = null
+                    setStatus('disconnected')
+                }
             }
-        }
 
-        socket.onmessage = (event) => {
-            if (socketRef.current !== socketInstance) {
-                return
-            }
-            try {
-                const data = JSON.parse(event.data as string) as { type?: string; folder?: string }
-                if (data.type === 'new_email' && data.folder) {
-                    // Invalidate all queries that start with ['threads', folder]
-                    // This will match ['threads', folder, page, limit] for any page/limit
-                    queryClientRef.current
-                        .invalidateQueries({
-                            queryKey: ['threads', data.folder],
-                            exact: false, // Match all queries that start with this key
-                        })
-                        .catch((err: unknown) => {
-                            // eslint-disable-next-line no-console -- Weird error, better log it
-                            console.error('WebSocket: Failed to invalidate queries', err)
-                        })
+            socket.onmessage = (event) => {
+                if (socketRef.current !== socketInstance) {
+                    return
+                }
+                try {
+                    const data = JSON.parse(event.data as string) as {
+                        type?: string
+                        folder?: string
+                        thread_id?: string
+                    }
+                    const invalidate = (queryKey: string[]) => {
+                        // exact: false matches all queries that start with the key, like ['threads', folder, page, limit]
+                        queryClientRef.current
+                            .invalidateQueries({ queryKey, exact: false })
+                            .catch((err: unknown) => {
+                                // eslint-disable-next-line no-console -- Weird error, better log it
+                                console.error('WebSocket: Failed to invalidate queries', err)
+                            })
+                    }
+                    // sync_complete means that a folder sync that outlasted a threads request has finished
+                    if (data.type && folderEventTypes.has(data.type) && data.folder) {
+                        invalidate(['threads', data.folder])
+                    } else if ((data.type === 'thread_updated' || data.type === 'thread_unsnoozed') && data.thread_id) {
+                        // The thread might have moved between folders or come back from a snooze, so every list can be stale
+                        invalidate(['thread', data.thread_id])
+                        invalidate(['threads'])
+                    } else if (data.type === 'folders_changed') {
+                        invalidate(['folders'])
+                    }
+                } catch (err) {
+                    // eslint-disable-next-line no-console -- We actually want to log this
+                    console.error('WebSocket: Failed to parse message', err, event.data)
                 }
-            } catch (err) {
-                // eslint-disable-next-line no-console -- We actually want to log this
-                console.error('WebSocket: Failed to parse message', err, event.data)
             }
         }
 
+        api.getWebSocketToken()
+            .then(({ token }) => {
+                if (!cancelled) {
+                    connect(token)
+                }
+            })
+            .catch((err: unknown) => {
+                // eslint-disable-next-line no-console -- We do want to log this in production too
+                console.error('WebSocket: Failed to get token', err)
+                if (!cancelled) {
+                    setStatus('disconnected')
+                    setLastError('WebSocket error')
+                }
+            })
+
         return () => {
             // Cleanup method
+            cancelled = true
 
             // Only clean up if this is still the current socket
-            if (socketRef.current !== socketInstance) {
+            const socket = openedSocket
+            if (!socket || socketRef.current !== socket) {
                 return
             }
 
@@ -12,6 +12,22 @@ export function encodeThreadIdForUrl(threadId: string): string {
     return base64.replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/g, '')
 }
 
+/**
+ * Returns the URL of the inline part of a message with the Content-ID, without angle brackets.
+ * HTML bodies point their "cid:" images here.
+ */
+export function getInlinePartUrl(messageId: string, contentId: string): string {
+    const id = encodeURIComponent(messageId)
+    return `${API_BASE_URL}/messages/${id}/inline/${encodeURIComponent(contentId)}`
+}
+
+/**
+ * Returns the URL that loads the remote image through our image proxy, so that the sender doesn't see the user's IP.
+ */
+export function getImageProxyUrl(imageUrl: string): string {
+    return `${API_BASE_URL}/image-proxy?url=${encodeURIComponent(imageUrl)}`
+}
+
 /**
  * Decodes a URL-safe base64 thread ID back to the original Message-ID.
  */
@@ -42,6 +58,12 @@ export interface AuthStatus {
 
 export interface UserSettings {
     imap_server_hostname: string
+    /** 0 or missing for the port in the hostname, or else the default port of imap_security. */
+    imap_port?: number
+    /** "tls" (the default), "starttls", or "none", which is for local development only. */
+    imap_security?: '' | 'tls' | 'starttls' | 'none'
+    /** Accept any certificate, for servers with self-signed ones. */
+    imap_skip_tls_verify?: boolean
     imap_username: string
     imap_password: string
     imap_password_set?: boolean
@@ -49,13 +71,242 @@ export interface UserSettings {
     smtp_username: string
     smtp_password: string
     smtp_password_set?: boolean
+    /** Set if the user connected with OAuth instead of passwords, for example, "google". */
+    oauth_provider?: string
+}
+
+/** The result of logging in to a server. problem is "dns", "connect", "tls", "auth", or "server". */
+export interface ConnectionCheck {
+    ok: boolean
+    problem?: string
+    error?: string
+    warnings?: string[]
+}
+
+/** The servers we found for an email address. source is "provider", "autoconfig", or "srv". */
+export interface AutodiscoveredSettings {
+    imap_server_hostname: string
+    imap_username: string
+    smtp_server_hostname: string
+    smtp_username: string
+    source: string
+}
+
+export interface SettingsTestResult {
+    imap: ConnectionCheck
+    smtp: ConnectionCheck
+}
+
+/** An OAuth provider the user can connect with. Send them to auth_url with the client ID and scope. */
+export interface OAuthProvider {
+    name: string
+    auth_url: string
+    client_id: string
+    scope: string
+}
+
+/** The code from the provider's redirect. email is only needed for initial setup. */
+export interface OAuthConnectInput {
+    provider: string
+    code: string
+    redirect_uri: string
+    code_verifier?: string
+    email?: string
+}
+
+/** An address the user can send as, besides their main one. */
+export interface SendIdentity {
+    id: string
+    name: string
+    email: string
+    /** Empty if the identity sends through the main SMTP server. */
+    smtp_server_hostname: string
+    smtp_username: string
+    smtp_password_set: boolean
+    signature: string
+}
+
+/** Leave smtp_password empty on update to keep the existing one. */
+export interface SendIdentityInput {
+    name: string
+    email: string
+    smtp_server_hostname?: string
+    smtp_username?: string
+    smtp_password?: string
+    signature?: string
+}
+
+/** Someone the user has exchanged mail with, suggested while typing a recipient. */
+export interface Contact {
+    email: string
+    name: string
+    message_count: number
+    sent_count: number
+    last_seen_at: string | null
+}
+
+/** A plus-addressed variant of the user's address, like user+shop-x7q@example.com. */
+export interface PlusAlias {
+    id: string
+    address: string
+    label: string
+    created_at: string
+}
+
+/** A sender whose new messages go to Trash or Spam instead of INBOX. */
+export interface BlockedSender {
+    id: string
+    email: string
+    action: 'trash' | 'spam'
+    created_at: string
+    updated_at: string
+}
+
+/** A sender whose messages show remote images right away. */
+export interface TrustedSender {
+    id: string
+    email: string
+    created_at: string
+}
+
+export interface UserPreferences {
     undo_send_delay_seconds: number
     pagination_threads_per_page: number
+    ui: Record<string, unknown>
+    updated_at?: string
+}
+
+// Send null to reset a preference to its default. Keys in "ui" are merged, and null keys are removed.
+export type UserPreferencesPatch = {
+    undo_send_delay_seconds?: number | null
+    pagination_threads_per_page?: number | null
+    ui?: Record<string, unknown> | null
 }
 
 export interface Folder {
     name: string
-    role: 'inbox' | 'sent' | 'drafts' | 'spam' | 'trash' | 'archive' | 'other'
+    role: 'inbox' | 'starred' | 'snoozed' | 'sent' | 'drafts' | 'spam' | 'trash' | 'archive' | 'all' | 'other'
+    /** True if the user set the role by hand. */
+    role_overridden: boolean
+    /** True for folders that aren't on the server, like Snoozed, and Starred and All Mail for servers without them. */
+    virtual: boolean
+}
+
+export interface FolderRoleOverride {
+    folder_name: string
+    /** null means the folder uses the role from the server. */
+    role: Exclude<Folder['role'], 'inbox' | 'starred' | 'all'> | null
+}
+
+/** A folder on the server, from the folder management endpoints. */
+export interface FolderSubscription {
+    name: string
+    /** The hierarchy delimiter, like "/" in "Projects/2026". Empty if the server has no hierarchy. */
+    delimiter: string
+    subscribed: boolean
+    /** False for folders that only hold other folders. */
+    selectable: boolean
+}
+
+export interface FolderSyncPreference {
+    folder_name: string
+    enabled: boolean
+    mode: 'headers_only' | 'full'
+    /** How many days back we sync and keep the folder. null means no limit. */
+    sync_days: number | null
+    /** How many of the newest messages we sync and keep. null means no limit. */
+    sync_max_messages: number | null
+    updated_at?: string
+}
+
+export interface OutgoingAttachment {
+    filename?: string
+    content_type?: string
+    /** Base64-encoded file content. */
+    data?: string
+    /** The ID of an AttachmentUpload to attach instead of sending the file inline. */
+    upload_id?: string
+    /** Makes the attachment an inline image that html_body shows with cid:content_id. No angle brackets. */
+    content_id?: string
+}
+
+/** A file staged with uploadAttachment. It's deleted at expires_at unless a draft references it. */
+export interface AttachmentUpload {
+    id: string
+    filename: string
+    content_type: string
+    size_bytes: number
+    created_at: string
+    expires_at: string
+}
+
+export interface OutgoingEmail {
+    to: string[]
+    cc?: string[]
+    bcc?: string[]
+    subject: string
+    text_body?: string
+    html_body?: string
+    attachments?: OutgoingAttachment[]
+    in_reply_to?: string
+    /** The Message-IDs of the thread, oldest first, ending with in_reply_to. */
+    references?: string[]
+    /** The SendIdentity to send as. Leave it out to send from the main address. */
+    identity_id?: string
+    /** Schedules the message for later, in RFC 3339 format. Leave it out to send after the undo send delay. */
+    send_at?: string
+}
+
+export interface SendEmailResponse {
+    message_id: string
+    saved_to_sent: boolean
+}
+
+/** The response when the message waits in the outbox. Cancel it with the ID until send_at. */
+export interface QueuedEmailResponse {
+    id: string
+    send_at: string
+}
+
+/** A message in the outbox, without its bodies and attachments. */
+export interface ScheduledEmail {
+    id: string
+    /** After a failed attempt, this is the time of the next one. */
+    send_at: string
+    created_at: string
+    to: string[]
+    cc: string[]
+    bcc: string[]
+    subject: string
+    attachment_count: number
+    attempts: number
+    last_error?: string
+}
+
+export interface MoveThreadResponse {
+    folder: string
+    moved_count: number
+}
+
+/** The fields of a draft that the user edits. */
+export interface DraftInput {
+    to?: string[]
+    cc?: string[]
+    bcc?: string[]
+    subject?: string
+    body_text?: string
+    body_html?: string
+    in_reply_to_message_id?: string
+    /** The IDs of the AttachmentUploads attached to the draft. */
+    attachment_upload_ids?: string[]
+}
+
+export interface Draft extends Required<Omit<DraftInput, 'in_reply_to_message_id'>> {
+    id: string
+    in_reply_to_message_id?: string
+    message_id_header: string
+    created_at: string
+    last_saved_at: string
 }
 
 export interface Message {
@@ -75,6 +326,69 @@ export interface Message {
     is_read: boolean
     is_starred: boolean
     attachments?: Attachment[]
+    /** The label of the plus alias the message was sent to, if any. */
+    plus_alias_label?: string
+    /** True if the sender is trusted, so remote images show right away. */
+    remote_images_allowed?: boolean
+    /** The message's IMAP keywords, like "$label1", in lowercase. */
+    labels?: string[]
+    /** True if the body is cut short, because the message was over the fetch size limits. */
+    truncated?: boolean
+    /** False if the backend doesn't have the body, for example, because the body cache evicted it. */
+    body_cached?: boolean
+    /** The event of the message's calendar invite, if it has one. */
+    calendar_event?: CalendarEvent
+    /** How suspicious the links of the body look. Missing if we haven't analyzed the body. */
+    link_analysis?: LinkAnalysis
+    /** The sender checks of the user's server. Missing if it didn't report any. */
+    authenticity?: Authenticity
+}
+
+export interface CalendarParticipant {
+    email: string
+    name?: string
+    /** The attendee's answer, like "accepted", "declined", "tentative", or "needs-action". Organizers have none. */
+    status?: string
+}
+
+export interface CalendarEvent {
+    uid: string
+    /** The iTIP method, like "REQUEST", "CANCEL", or "REPLY". */
+    method?: string
+    summary: string
+    location?: string
+    starts_at: string
+    ends_at?: string
+    /** True if the event has dates without times, at midnight UTC. */
+    all_day: boolean
+    organizer?: CalendarParticipant
+    attendees: CalendarParticipant[]
+}
+
+export interface LinkWarning {
+    kind: 'mismatched_text' | 'lookalike_domain' | 'suspicious_tld'
+    url: string
+    /** The domain the link goes to, in punycode if it's internationalized. */
+    domain: string
+    /** The text of the link, for mismatched text. */
+    text?: string
+}
+
+export interface Authenticity {
+    /** The server that did the checks, like "mx.google.com". */
+    authserv_id: string
+    /** The results, like "pass", "fail", or "softfail". Missing for checks the server didn't report. */
+    dkim?: string
+    spf?: string
+    dmarc?: string
+    /** True if DMARC passed, or, without DMARC, if DKIM or SPF passed. */
+    verified: boolean
+}
+
+export interface LinkAnalysis {
+    /** From 0, for nothing suspicious, to 100. */
+    suspicious_score: number
+    warnings: LinkWarning[]
 }
 
 export interface Attachment {
@@ -87,6 +401,24 @@ export interface Attachment {
     content_id?: string
 }
 
+export type ReplyMode = 'reply' | 'reply_all' | 'forward'
+
+/** What the compose window starts with when the user replies to or forwards a message. */
+export interface ReplyTemplate {
+    mode: ReplyMode
+    message_id: string
+    to: string[]
+    cc: string[]
+    subject: string
+    text_body: string
+    /** Has the original HTML in it, so sanitize it before showing it. */
+    unsafe_html_body: string
+    in_reply_to?: string
+    references: string[]
+    /** The attachments of a forwarded message. */
+    attachments: Attachment[]
+}
+
 export interface Thread {
     id: string
     stable_thread_id: string
@@ -96,19 +428,131 @@ export interface Thread {
     preview_snippet?: string
     has_attachments: boolean
     message_count?: number
+    unread_count?: number
     last_sent_at?: string
+    importance_score?: number
+    is_important?: boolean
+    /** The labels of all messages in the thread. */
+    labels?: string[]
+    /** Set if the thread is snoozed: it's hidden from its folders until then. */
+    snoozed_until?: string
+    /** False if some bodies aren't cached, so opening the thread waits for the IMAP server. */
+    body_cached?: boolean
+    /**
+     * True in search results if only the IMAP server found some of its matching messages.
+     * If none of the thread is cached, its id is empty, and it can't be opened.
+     */
+    from_server?: boolean
     messages?: Message[]
+    drafts?: Draft[]
+    // Only set for mega-threads, whose messages come in segments, newest first
+    segment?: ThreadSegment
+}
+
+export interface SnoozeResponse {
+    snoozed_until: string
+}
+
+export interface ThreadLabelsResponse {
+    labels: string[]
+    /** False if some messages are in folders that don't allow new keywords, so only V-Mail knows their label. */
+    saved_to_server: boolean
+}
+
+/** Whether the backend holds syncs back because the mail server throttles the account. */
+export interface SyncStatus {
+    throttled: boolean
+    /** When syncing starts again, if throttled. */
+    throttled_until?: string
+    /** The server's response or the error that made the backend back off. */
+    reason?: string
+    /** The folders the backend started syncing, sorted by name. */
+    folders: FolderSyncStatus[]
+}
+
+/** A folder sync that runs in the background. */
+export interface SyncJob {
+    job_id: string
+    folder: string
+    /** False if the folder was already syncing, so this is the running sync. */
+    started: boolean
+}
+
+/** An export of the user's data, which the backend builds in the background. */
+export interface DataExport {
+    id: string
+    status: 'running' | 'done' | 'failed'
+    /** Why the export failed, if it did. */
+    error?: string
+    /** The size of the zip file, once it's done. */
+    size_bytes?: number
+    started_at: string
+    finished_at?: string
+    /** When the backend deletes the zip file of a done export. */
+    expires_at?: string
+}
+
+/** A token that confirms deleting the account. */
+export interface AccountDeletionToken {
+    token: string
+    expires_at: string
+}
+
+/** A token for connecting to the WebSocket. It works once, until `expires_at`. */
+export interface WebSocketToken {
+    token: string
+    expires_at: string
+}
+
+/** The state of one folder's sync. */
+export interface FolderSyncStatus {
+    folder_name: string
+    /** When the last sync finished, or null while the first one runs. */
+    synced_at: string | null
+    last_synced_uid: number | null
+    thread_count: number
+    syncing: boolean
+    sync_started_at?: string
+    /** Why the last sync failed, if it did. */
+    last_error?: string
+    last_error_at?: string
+}
+
+export interface ThreadSegment {
+    message_count: number
+    oldest_sent_at: string | null
+    newest_sent_at: string | null
+    older_cursor: string | null
 }
 
 export interface Pagination {
     total_count: number
+    total_estimated: boolean
     page: number
     per_page: number
+    next_cursor: string | null
+}
+
+export interface ThreadGroups {
+    important_count: number
+    other_count: number
 }
 
 export interface ThreadsResponse {
     threads: Thread[] | null
     pagination: Pagination
+    groups?: ThreadGroups
+    /** True if this is a quick preview of a folder that the server is still syncing. */
+    partial?: boolean
+    /** True if the server is still syncing the folder. A "sync_complete" WebSocket message follows when it's done. */
+    syncing?: boolean
+}
+
+export interface ThreadListOptions {
+    /** Only return important threads. */
+    important?: boolean
+    /** List important threads first, and return the group sizes. */
+    split?: boolean
 }
 
 function getAuthHeaders() {
@@ -143,8 +587,14 @@ export const api = {
         return (await response.json()) as Promise<UserSettings>
     },
 
-    async saveSettings(settings: UserSettings): Promise<void> {
-        const response = await fetch(`${API_BASE_URL}/settings`, {
+    // Changing the IMAP server or username clears the cached mail, so the backend responds with 409
+    // unless confirmIdentityChange is set.
+    async saveSettings(
+        settings: UserSettings,
+        options: { confirmIdentityChange?: boolean } = {},
+    ): Promise<void> {
+        const query = options.confirmIdentityChange ? '?confirm_identity_change=true' : ''
+        const response = await fetch(`${API_BASE_URL}/settings${query}`, {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
@@ -154,74 +604,877 @@ export const api = {
             body: JSON.stringify(settings),
         })
         if (!response.ok) {
-            throw new Error('Failed to save settings')
+            // Preserve status code information so caller can ask for confirmation on 409
+            const error = new Error('Failed to save settings')
+            ;(error as Error & { status?: number }).status = response.status
+            throw error
         }
     },
 
-    async getFolders(): Promise<Folder[]> {
-        const response = await fetch(`${API_BASE_URL}/folders`, {
+    /** Logs in to the servers in the settings without saving them. Empty passwords use the saved ones. */
+    async testSettings(settings: UserSettings): Promise<SettingsTestResult> {
+        const response = await fetch(`${API_BASE_URL}/settings/test`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                ...getAuthHeaders(),
+            },
             credentials: 'include',
-            headers: getAuthHeaders(),
+            body: JSON.stringify(settings),
         })
         if (!response.ok) {
+            // 400 has the reason, like a missing password
             const errorText = await response.text()
-            // Try to extract a meaningful error message from the response
-            const errorMessage =
-                errorText && errorText.length > 0 && errorText.length < 200
-                    ? errorText
-                    : 'Failed to fetch folders'
-            throw new Error(errorMessage)
+            throw new Error(errorText.trim() || 'Failed to test settings')
         }
-        return (await response.json()) as Promise<Folder[]>
+        return (await response.json()) as Promise<SettingsTestResult>
     },
 
-    async getThreads(folder: string, page: number = 1, limit?: number): Promise<ThreadsResponse> {
-        const params = new URLSearchParams({
-            folder,
-            page: page.toString(),
+    /** Finds the servers of the email address. Returns null if we couldn't find them. */
+    async autodiscoverSettings(email: string): Promise<AutodiscoveredSettings | null> {
+        const response = await fetch(
+            `${API_BASE_URL}/settings/autodiscover?email=${encodeURIComponent(email)}`,
+            {
+                credentials: 'include',
+                headers: getAuthHeaders(),
+            },
+        )
+        if (response.status === 404) {
+            return null
+        }
+        if (!response.ok) {
+            throw new Error('Failed to find the server settings')
+        }
+        return (await response.json()) as Promise<AutodiscoveredSettings>
+    },
+
+    async getOAuthProviders(): Promise<OAuthProvider[]> {
+        const response = await fetch(`${API_BASE_URL}/settings/oauth`, {
+            credentials: 'include',
+            headers: getAuthHeaders(),
         })
-        if (limit !== undefined) {
-            params.append('limit', limit.toString())
+        if (!response.ok) {
+            throw new Error('Failed to fetch OAuth providers')
         }
-        const response = await fetch(`${API_BASE_URL}/threads?${params.toString()}`, {
+        return (await response.json()) as Promise<OAuthProvider[]>
+    },
+
+    /** Exchanges the code from the provider for tokens, which then replace the passwords. */
+    async connectOAuth(input: OAuthConnectInput): Promise<UserSettings> {
+        const response = await fetch(`${API_BASE_URL}/settings/oauth`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                ...getAuthHeaders(),
+            },
+            credentials: 'include',
+            body: JSON.stringify(input),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to connect with OAuth')
+        }
+        return (await response.json()) as Promise<UserSettings>
+    },
+
+    async getIdentities(): Promise<SendIdentity[]> {
+        const response = await fetch(`${API_BASE_URL}/settings/identities`, {
             credentials: 'include',
             headers: getAuthHeaders(),
         })
         if (!response.ok) {
-            throw new Error('Failed to fetch threads')
+            throw new Error('Failed to fetch identities')
         }
-        return (await response.json()) as Promise<ThreadsResponse>
+        return (await response.json()) as Promise<SendIdentity[]>
     },
 
-    async getThread(threadId: string): Promise<Thread> {
-        // threadId is expected to be the raw Message-ID (with angle brackets)
-        // We need to encode it for the URL path
-        const encodedId = encodeURIComponent(threadId)
-        const response = await fetch(`${API_BASE_URL}/thread/${encodedId}`, {
+    /**
+     * Creates an identity, or updates it if id is given.
+     * The backend checks with the SMTP server first, and responds with 400 if it rejects the identity.
+     */
+    async saveIdentity(identity: SendIdentityInput, id?: string): Promise<SendIdentity> {
+        const url = id
+            ? `${API_BASE_URL}/settings/identities/${encodeURIComponent(id)}`
+            : `${API_BASE_URL}/settings/identities`
+        const response = await fetch(url, {
+            method: id ? 'PUT' : 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                ...getAuthHeaders(),
+            },
+            credentials: 'include',
+            body: JSON.stringify(identity),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to save identity')
+        }
+        return (await response.json()) as Promise<SendIdentity>
+    },
+
+    async deleteIdentity(id: string): Promise<void> {
+        const response = await fetch(
+            `${API_BASE_URL}/settings/identities/${encodeURIComponent(id)}`,
+            {
+                method: 'DELETE',
+                headers: getAuthHeaders(),
+                credentials: 'include',
+            },
+        )
+        if (!response.ok) {
+            throw new Error('Failed to delete identity')
+        }
+    },
+
+    /** Returns the contacts whose address or name starts with the query, best first. */
+    async getContacts(query: string, limit = 10): Promise<Contact[]> {
+        const response = await fetch(
+            `${API_BASE_URL}/contacts?query=${encodeURIComponent(query)}&limit=${limit}`,
+            {
+                credentials: 'include',
+                headers: getAuthHeaders(),
+            },
+        )
+        if (!response.ok) {
+            throw new Error('Failed to fetch contacts')
+        }
+        const data = (await response.json()) as { contacts: Contact[] }
+        return data.contacts
+    },
+
+    async getAliases(): Promise<PlusAlias[]> {
+        const response = await fetch(`${API_BASE_URL}/aliases`, {
             credentials: 'include',
             headers: getAuthHeaders(),
         })
         if (!response.ok) {
-            throw new Error('Failed to fetch thread')
+            throw new Error('Failed to fetch aliases')
         }
-        return (await response.json()) as Promise<Thread>
+        return (await response.json()) as Promise<PlusAlias[]>
     },
 
-    async search(query: string, page: number = 1, limit?: number): Promise<ThreadsResponse> {
-        const params = new URLSearchParams({
-            q: query,
-            page: page.toString(),
+    /** Generates and saves a new alias. The label becomes lowercase letters, digits, and dashes. */
+    async generateAlias(label: string): Promise<PlusAlias> {
+        const response = await fetch(
+            `${API_BASE_URL}/aliases/generate?label=${encodeURIComponent(label)}`,
+            {
+                credentials: 'include',
+                headers: getAuthHeaders(),
+            },
+        )
+        if (!response.ok) {
+            throw new Error('Failed to generate alias')
+        }
+        return (await response.json()) as Promise<PlusAlias>
+    },
+
+    async getBlockedSenders(): Promise<BlockedSender[]> {
+        const response = await fetch(`${API_BASE_URL}/blocked-senders`, {
+            credentials: 'include',
+            headers: getAuthHeaders(),
         })
-        if (limit !== undefined) {
-            params.append('limit', limit.toString())
+        if (!response.ok) {
+            throw new Error('Failed to fetch blocked senders')
         }
-        const response = await fetch(`${API_BASE_URL}/search?${params.toString()}`, {
+        return (await response.json()) as Promise<BlockedSender[]>
+    },
+
+    /** Blocks a sender, or updates the blocked sender if id is given. */
+    async saveBlockedSender(
+        sender: Pick<BlockedSender, 'email' | 'action'>,
+        id?: string,
+    ): Promise<BlockedSender> {
+        const url = id
+            ? `${API_BASE_URL}/blocked-senders/${encodeURIComponent(id)}`
+            : `${API_BASE_URL}/blocked-senders`
+        const response = await fetch(url, {
+            method: id ? 'PUT' : 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                ...getAuthHeaders(),
+            },
+            credentials: 'include',
+            body: JSON.stringify(sender),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to save blocked sender')
+        }
+        return (await response.json()) as Promise<BlockedSender>
+    },
+
+    /** Unblocks a sender. Messages that were already moved stay in Trash or Spam. */
+    async deleteBlockedSender(id: string): Promise<void> {
+        const response = await fetch(`${API_BASE_URL}/blocked-senders/${encodeURIComponent(id)}`, {
+            method: 'DELETE',
+            headers: getAuthHeaders(),
+            credentials: 'include',
+        })
+        if (!response.ok) {
+            throw new Error('Failed to unblock sender')
+        }
+    },
+
+    async getTrustedSenders(): Promise<TrustedSender[]> {
+        const response = await fetch(`${API_BASE_URL}/trusted-senders`, {
             credentials: 'include',
             headers: getAuthHeaders(),
         })
         if (!response.ok) {
-            throw new Error('Failed to search')
+            throw new Error('Failed to fetch trusted senders')
+        }
+        return (await response.json()) as Promise<TrustedSender[]>
+    },
+
+    /** Stops showing remote images from the sender right away. */
+    async deleteTrustedSender(id: string): Promise<void> {
+        const response = await fetch(`${API_BASE_URL}/trusted-senders/${encodeURIComponent(id)}`, {
+            method: 'DELETE',
+            headers: getAuthHeaders(),
+            credentials: 'include',
+        })
+        if (!response.ok) {
+            throw new Error('Failed to remove trusted sender')
+        }
+    },
+
+    async getPreferences(): Promise<UserPreferences> {
+        const response = await fetch(`${API_BASE_URL}/preferences`, {
+            credentials: 'include',
+            headers: getAuthHeaders(),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to fetch preferences')
+        }
+        return (await response.json()) as Promise<UserPreferences>
+    },
+
+    async patchPreferences(patch: UserPreferencesPatch): Promise<UserPreferences> {
+        const response = await fetch(`${API_BASE_URL}/preferences`, {
+            method: 'PATCH',
+            headers: {
+                'Content-Type': 'application/json',
+                ...getAuthHeaders(),
+            },
+            credentials: 'include',
+            body: JSON.stringify(patch),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to save preferences')
+        }
+        return (await response.json()) as Promise<UserPreferences>
+    },
+
+    async getFolders(): Promise<Folder[]> {
+        const response = await fetch(`${API_BASE_URL}/folders`, {
+            credentials: 'include',
+            headers: getAuthHeaders(),
+        })
+        if (!response.ok) {
+            const errorText = await response.text()
+            // Try to extract a meaningful error message from the response
+            const errorMessage =
+                errorText && errorText.length > 0 && errorText.length < 200
+                    ? errorText
+                    : 'Failed to fetch folders'
+            throw new Error(errorMessage)
+        }
+        return (await response.json()) as Promise<Folder[]>
+    },
+
+    async getFolderSync(folder: string): Promise<FolderSyncPreference> {
+        const response = await fetch(`${API_BASE_URL}/folders/${encodeURIComponent(folder)}/sync`, {
+            credentials: 'include',
+            headers: getAuthHeaders(),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to fetch folder sync preference')
+        }
+        return (await response.json()) as Promise<FolderSyncPreference>
+    },
+
+    async patchFolderSync(
+        folder: string,
+        patch: { enabled?: boolean | null; mode?: FolderSyncPreference['mode'] | null },
+    ): Promise<FolderSyncPreference> {
+        const response = await fetch(`${API_BASE_URL}/folders/${encodeURIComponent(folder)}/sync`, {
+            method: 'PATCH',
+            headers: {
+                'Content-Type': 'application/json',
+                ...getAuthHeaders(),
+            },
+            credentials: 'include',
+            body: JSON.stringify(patch),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to save folder sync preference')
+        }
+        return (await response.json()) as Promise<FolderSyncPreference>
+    },
+
+    /** Sets the role of a folder, or with null, goes back to the role from the server. */
+    async setFolderRole(
+        folder: string,
+        role: FolderRoleOverride['role'],
+    ): Promise<FolderRoleOverride> {
+        const response = await fetch(`${API_BASE_URL}/folders/${encodeURIComponent(folder)}/role`, {
+            method: 'PATCH',
+            headers: {
+                'Content-Type': 'application/json',
+                ...getAuthHeaders(),
+            },
+            credentials: 'include',
+            body: JSON.stringify({ role }),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to save folder role')
+        }
+        return (await response.json()) as Promise<FolderRoleOverride>
+    },
+
+    /** Lists all folders on the server, with the user's subscriptions. */
+    async getFolderSubscriptions(): Promise<FolderSubscription[]> {
+        const response = await fetch(`${API_BASE_URL}/folders/subscriptions`, {
+            credentials: 'include',
+            headers: getAuthHeaders(),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to fetch folders')
+        }
+        const data = (await response.json()) as { folders: FolderSubscription[] }
+        return data.folders
+    },
+
+    async setFolderSubscribed(folder: string, subscribed: boolean): Promise<void> {
+        const response = await fetch(`${API_BASE_URL}/folders/${encodeURIComponent(folder)}/subscription`, {
+            method: subscribed ? 'PUT' : 'DELETE',
+            credentials: 'include',
+            headers: getAuthHeaders(),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to change folder subscription')
+        }
+    },
+
+    async createFolder(name: string): Promise<FolderSubscription> {
+        const response = await fetch(`${API_BASE_URL}/folders`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                ...getAuthHeaders(),
+            },
+            credentials: 'include',
+            body: JSON.stringify({ name }),
+        })
+        if (!response.ok) {
+            throw new Error(
+                response.status === 409 ? 'A folder with this name already exists' : 'Failed to create folder',
+            )
+        }
+        return (await response.json()) as Promise<FolderSubscription>
+    },
+
+    /** Renames a folder and its subfolders. */
+    async renameFolder(folder: string, name: string): Promise<FolderSubscription> {
+        const response = await fetch(`${API_BASE_URL}/folders/${encodeURIComponent(folder)}`, {
+            method: 'PATCH',
+            headers: {
+                'Content-Type': 'application/json',
+                ...getAuthHeaders(),
+            },
+            credentials: 'include',
+            body: JSON.stringify({ name }),
+        })
+        if (!response.ok) {
+            throw new Error(
+                response.status === 409 ? 'A folder with this name already exists' : 'Failed to rename folder',
+            )
+        }
+        return (await response.json()) as Promise<FolderSubscription>
+    },
+
+    /** Deletes a folder and its messages. Its subfolders stay. */
+    async deleteFolder(folder: string): Promise<void> {
+        const response = await fetch(`${API_BASE_URL}/folders/${encodeURIComponent(folder)}`, {
+            method: 'DELETE',
+            credentials: 'include',
+            headers: getAuthHeaders(),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to delete folder')
+        }
+    },
+
+    async getThreads(
+        folder: string,
+        page: number = 1,
+        limit?: number,
+        options: ThreadListOptions = {},
+    ): Promise<ThreadsResponse> {
+        const params = new URLSearchParams({
+            folder,
+            page: page.toString(),
+        })
+        if (limit !== undefined) {
+            params.append('limit', limit.toString())
+        }
+        if (options.important) {
+            params.append('important', 'true')
+        }
+        if (options.split) {
+            params.append('split', 'true')
+        }
+        const response = await fetch(`${API_BASE_URL}/threads?${params.toString()}`, {
+            credentials: 'include',
+            headers: getAuthHeaders(),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to fetch threads')
+        }
+        return (await response.json()) as Promise<ThreadsResponse>
+    },
+
+    async getThread(threadId: string, segmentCursor?: string): Promise<Thread> {
+        // threadId is expected to be the raw Message-ID (with angle brackets)
+        // We need to encode it for the URL path
+        const encodedId = encodeURIComponent(threadId)
+        const query = segmentCursor ? `?segment=${encodeURIComponent(segmentCursor)}` : ''
+        const response = await fetch(`${API_BASE_URL}/thread/${encodedId}${query}`, {
+            credentials: 'include',
+            headers: getAuthHeaders(),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to fetch thread')
+        }
+        return (await response.json()) as Promise<Thread>
+    },
+
+    /** Gets the recipients, subject, and quoted body of a reply to or forward of a message, the newest by default. */
+    async getReplyTemplate(threadId: string, mode: ReplyMode, messageId?: string): Promise<ReplyTemplate> {
+        const params = new URLSearchParams({ mode })
+        if (messageId) {
+            params.set('message_id', messageId)
+        }
+        const response = await fetch(
+            `${API_BASE_URL}/thread/${encodeURIComponent(threadId)}/reply-template?${params.toString()}`,
+            {
+                credentials: 'include',
+                headers: getAuthHeaders(),
+            },
+        )
+        if (!response.ok) {
+            throw new Error('Failed to fetch reply template')
+        }
+        return (await response.json()) as Promise<ReplyTemplate>
+    },
+
+    /**
+     * Trusts a sender of the thread, the first one unless email is given, and returns the thread
+     * with remote images allowed for their messages.
+     */
+    async trustSender(threadId: string, email?: string): Promise<Thread> {
+        const encodedId = encodeURIComponent(threadId)
+        const response = await fetch(`${API_BASE_URL}/thread/${encodedId}/trust-sender`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                ...getAuthHeaders(),
+            },
+            credentials: 'include',
+            body: JSON.stringify(email ? { email } : {}),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to trust sender')
+        }
+        return (await response.json()) as Promise<Thread>
+    },
+
+    /** Gets the recipients, subject, and quoted body of a reply to or forward of a message, the newest by default. */
+    async getReplyTemplate(threadId: string, mode: ReplyMode, messageId?: string): Promise<ReplyTemplate> {
+        const params = new URLSearchParams({ mode })
+        if (messageId) {
+            params.set('message_id', messageId)
+        }
+        const response = await fetch(
+            `${API_BASE_URL}/thread/${encodeURIComponent(threadId)}/reply-template?${params.toString()}`,
+            {
+                credentials: 'include',
+                headers: getAuthHeaders(),
+            },
+        )
+        if (!response.ok) {
+            throw new Error('Failed to fetch reply template')
+        }
+        return (await response.json()) as Promise<ReplyTemplate>
+    },
+
+    async addThreadLabel(threadId: string, label: string): Promise<ThreadLabelsResponse> {
+        const encodedId = encodeURIComponent(threadId)
+        const response = await fetch(`${API_BASE_URL}/thread/${encodedId}/labels`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                ...getAuthHeaders(),
+            },
+            credentials: 'include',
+            body: JSON.stringify({ label }),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to add label')
+        }
+        return (await response.json()) as Promise<ThreadLabelsResponse>
+    },
+
+    async removeThreadLabel(threadId: string, label: string): Promise<ThreadLabelsResponse> {
+        const encodedId = encodeURIComponent(threadId)
+        const response = await fetch(`${API_BASE_URL}/thread/${encodedId}/labels/${encodeURIComponent(label)}`, {
+            method: 'DELETE',
+            headers: getAuthHeaders(),
+            credentials: 'include',
+        })
+        if (!response.ok) {
+            throw new Error('Failed to remove label')
+        }
+        return (await response.json()) as Promise<ThreadLabelsResponse>
+    },
+
+    async snoozeThread(threadId: string, until: Date): Promise<SnoozeResponse> {
+        const encodedId = encodeURIComponent(threadId)
+        const response = await fetch(`${API_BASE_URL}/thread/${encodedId}/snooze`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                ...getAuthHeaders(),
+            },
+            credentials: 'include',
+            body: JSON.stringify({ until: until.toISOString() }),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to snooze thread')
+        }
+        return (await response.json()) as Promise<SnoozeResponse>
+    },
+
+    async unsnoozeThread(threadId: string): Promise<void> {
+        const encodedId = encodeURIComponent(threadId)
+        const response = await fetch(`${API_BASE_URL}/thread/${encodedId}/snooze`, {
+            method: 'DELETE',
+            headers: getAuthHeaders(),
+            credentials: 'include',
+        })
+        if (!response.ok) {
+            throw new Error('Failed to unsnooze thread')
+        }
+    },
+
+    async search(query: string, page: number = 1, limit?: number): Promise<ThreadsResponse> {
+        const params = new URLSearchParams({
+            q: query,
+            page: page.toString(),
+        })
+        if (limit !== undefined) {
+            params.append('limit', limit.toString())
+        }
+        const response = await fetch(`${API_BASE_URL}/search?${params.toString()}`, {
+            credentials: 'include',
+            headers: getAuthHeaders(),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to search')
+        }
+        return (await response.json()) as Promise<ThreadsResponse>
+    },
+
+    /** Sends the message, or queues it if the user has an undo send delay. */
+    async sendMessage(email: OutgoingEmail): Promise<SendEmailResponse | QueuedEmailResponse> {
+        const response = await fetch(`${API_BASE_URL}/messages/send`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                ...getAuthHeaders(),
+            },
+            credentials: 'include',
+            body: JSON.stringify(email),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to send message')
+        }
+        return (await response.json()) as Promise<SendEmailResponse | QueuedEmailResponse>
+    },
+
+    async getScheduledMessages(): Promise<ScheduledEmail[]> {
+        const response = await fetch(`${API_BASE_URL}/messages/scheduled`, {
+            headers: getAuthHeaders(),
+            credentials: 'include',
+        })
+        if (!response.ok) {
+            throw new Error('Failed to fetch scheduled messages')
+        }
+        const data = (await response.json()) as { messages: ScheduledEmail[] }
+        return data.messages
+    },
+
+    async cancelMessage(id: string): Promise<void> {
+        const response = await fetch(`${API_BASE_URL}/messages/${encodeURIComponent(id)}/cancel`, {
+            method: 'POST',
+            headers: getAuthHeaders(),
+            credentials: 'include',
+        })
+        if (!response.ok) {
+            throw new Error('Failed to cancel message')
+        }
+    },
+
+    /**
+     * Moves a thread's messages to a folder, or to Archive or Trash.
+     * With fromFolder, only the messages in that folder move, for example, to archive from the inbox.
+     */
+    async moveThread(
+        threadId: string,
+        destination: { folder: string } | 'archive' | 'trash',
+        fromFolder?: string,
+    ): Promise<MoveThreadResponse> {
+        const action = typeof destination === 'string' ? destination : 'move'
+        const folder = typeof destination === 'string' ? undefined : destination.folder
+        const response = await fetch(
+            `${API_BASE_URL}/thread/${encodeURIComponent(threadId)}/${action}`,
+            {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                    ...getAuthHeaders(),
+                },
+                credentials: 'include',
+                body: JSON.stringify({ folder, from_folder: fromFolder }),
+            },
+        )
+        if (!response.ok) {
+            throw new Error('Failed to move thread')
+        }
+        return (await response.json()) as Promise<MoveThreadResponse>
+    },
+
+    /**
+     * Reports a thread as spam, moving it to Spam, or with spam=false, moves its messages in Spam back to the inbox.
+     * Marks the messages with $Junk or $NotJunk, so that the server's spam filter learns from them.
+     */
+    async reportSpam(threadId: string, spam: boolean): Promise<MoveThreadResponse> {
+        const action = spam ? 'report-spam' : 'not-spam'
+        const response = await fetch(
+            `${API_BASE_URL}/thread/${encodeURIComponent(threadId)}/${action}`,
+            {
+                method: 'POST',
+                credentials: 'include',
+                headers: getAuthHeaders(),
+            },
+        )
+        if (!response.ok) {
+            throw new Error(spam ? 'Failed to report spam' : 'Failed to report not spam')
+        }
+        return (await response.json()) as Promise<MoveThreadResponse>
+    },
+
+    async getDrafts(): Promise<Draft[]> {
+        const response = await fetch(`${API_BASE_URL}/drafts`, {
+            credentials: 'include',
+            headers: getAuthHeaders(),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to fetch drafts')
+        }
+        const data = (await response.json()) as { drafts: Draft[] }
+        return data.drafts
+    },
+
+    /** Creates a new draft if there's no ID yet, and updates the draft otherwise. */
+    async saveDraft(draft: DraftInput, id?: string): Promise<Draft> {
+        const url = id ? `${API_BASE_URL}/drafts/${encodeURIComponent(id)}` : `${API_BASE_URL}/drafts`
+        const response = await fetch(url, {
+            method: id ? 'PUT' : 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                ...getAuthHeaders(),
+            },
+            credentials: 'include',
+            body: JSON.stringify(draft),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to save draft')
+        }
+        return (await response.json()) as Promise<Draft>
+    },
+
+    /** Stages a file to attach to a message or a draft by its ID. */
+    async uploadAttachment(file: File): Promise<AttachmentUpload> {
+        const body = new FormData()
+        body.append('file', file)
+        const response = await fetch(`${API_BASE_URL}/attachments/upload`, {
+            method: 'POST',
+            headers: getAuthHeaders(),
+            credentials: 'include',
+            body,
+        })
+        if (!response.ok) {
+            throw new Error('Failed to upload attachment')
+        }
+        return (await response.json()) as Promise<AttachmentUpload>
+    },
+
+    async getSyncStatus(): Promise<SyncStatus> {
+        const response = await fetch(`${API_BASE_URL}/sync/status`, {
+            credentials: 'include',
+            headers: getAuthHeaders(),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to fetch sync status')
+        }
+        return (await response.json()) as Promise<SyncStatus>
+    },
+
+    /** Syncs a folder right away. Its sync WebSocket events carry the returned `job_id`. */
+    async startSync(folder: string): Promise<SyncJob> {
+        const response = await fetch(`${API_BASE_URL}/sync?folder=${encodeURIComponent(folder)}`, {
+            method: 'POST',
+            credentials: 'include',
+            headers: getAuthHeaders(),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to start sync')
+        }
+        return (await response.json()) as Promise<SyncJob>
+    },
+
+    /** Gets a token for the `token` query parameter of the WebSocket, since browsers can't set its headers. */
+    async getWebSocketToken(): Promise<WebSocketToken> {
+        const response = await fetch(`${API_BASE_URL}/ws/token`, {
+            method: 'POST',
+            credentials: 'include',
+            headers: getAuthHeaders(),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to get WebSocket token')
+        }
+        return (await response.json()) as Promise<WebSocketToken>
+    },
+
+    /** Starts building an export of the user's data, or returns the one being built. */
+    async startDataExport(): Promise<DataExport> {
+        const response = await fetch(`${API_BASE_URL}/export`, {
+            method: 'POST',
+            credentials: 'include',
+            headers: getAuthHeaders(),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to start data export')
+        }
+        return (await response.json()) as Promise<DataExport>
+    },
+
+    /** Gets the state of the user's last export, or null if they have none. */
+    async getDataExportStatus(): Promise<DataExport | null> {
+        const response = await fetch(`${API_BASE_URL}/export/status`, {
+            credentials: 'include',
+            headers: getAuthHeaders(),
+        })
+        if (response.status === 404) {
+            return null
+        }
+        if (!response.ok) {
+            throw new Error('Failed to get data export status')
+        }
+        return (await response.json()) as Promise<DataExport>
+    },
+
+    /** Gets the original source of a message from the mail server, for "show original". */
+    async getRawMessage(messageId: string): Promise<string> {
+        const response = await fetch(`${API_BASE_URL}/messages/${encodeURIComponent(messageId)}/raw`, {
+            credentials: 'include',
+            headers: getAuthHeaders(),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to get message source')
+        }
+        return response.text()
+    },
+
+    /** Answers the calendar invite of a message, and returns the event with the answer. */
+    async respondToInvite(messageId: string, answer: 'accept' | 'tentative' | 'decline'): Promise<CalendarEvent> {
+        const response = await fetch(`${API_BASE_URL}/messages/${encodeURIComponent(messageId)}/rsvp`, {
+            method: 'POST',
+            credentials: 'include',
+            headers: {
+                'Content-Type': 'application/json',
+                ...getAuthHeaders(),
+            },
+            body: JSON.stringify({ response: answer }),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to answer the invite')
+        }
+        return (await response.json()) as Promise<CalendarEvent>
+    },
+
+    /** Downloads the original sources of a thread's messages, as an mbox file or a zip file of .eml files. */
+    async exportThread(threadId: string, format: 'mbox' | 'eml' = 'mbox'): Promise<Blob> {
+        const response = await fetch(
+            `${API_BASE_URL}/thread/${encodeURIComponent(threadId)}/export?format=${format}`,
+            {
+                credentials: 'include',
+                headers: getAuthHeaders(),
+            },
+        )
+        if (!response.ok) {
+            throw new Error('Failed to export thread')
+        }
+        return response.blob()
+    },
+
+    /** Downloads the zip file of the user's last export, once it's done. */
+    async downloadDataExport(): Promise<Blob> {
+        const response = await fetch(`${API_BASE_URL}/export`, {
+            credentials: 'include',
+            headers: getAuthHeaders(),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to download data export')
+        }
+        return response.blob()
+    },
+
+    /** Gets a token that confirms deleting the account. It works for a few minutes. */
+    async getAccountDeletionToken(): Promise<AccountDeletionToken> {
+        const response = await fetch(`${API_BASE_URL}/account/deletion-token`, {
+            method: 'POST',
+            credentials: 'include',
+            headers: getAuthHeaders(),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to get account deletion token')
+        }
+        return (await response.json()) as Promise<AccountDeletionToken>
+    },
+
+    /** Deletes the user's account and all their data. Get the token with getAccountDeletionToken. */
+    async deleteAccount(confirmationToken: string): Promise<void> {
+        const response = await fetch(`${API_BASE_URL}/account`, {
+            method: 'DELETE',
+            credentials: 'include',
+            headers: {
+                'Content-Type': 'application/json',
+                ...getAuthHeaders(),
+            },
+            body: JSON.stringify({ confirmation_token: confirmationToken }),
+        })
+        if (!response.ok) {
+            throw new Error('Failed to delete account')
+        }
+    },
+
+    async deleteDraft(id: string): Promise<void> {
+        const response = await fetch(`${API_BASE_URL}/drafts/${encodeURIComponent(id)}`, {
+            method: 'DELETE',
+            headers: getAuthHeaders(),
+            credentials: 'include',
+        })
+        if (!response.ok) {
+            throw new Error('Failed to delete draft')
         }
-        return (await response.json()) as Promise<ThreadsResponse>
     },
 }
@@ -0,0 +1,20 @@
+import { describe, it, expect } from 'vitest'
+
+import { rewriteInlineImages } from './inlineImages'
+
+describe('rewriteInlineImages', () => {
+    it('points cid: images to the inline parts of the message', () => {
+        const html = rewriteInlineImages(
+            '<img src="cid:logo%40example.com"><img src="https://example.com/a.png">',
+            'msg-1',
+        )
+
+        expect(html).toBe(
+            '<img src="/api/v1/messages/msg-1/inline/logo%40example.com"><img src="https://example.com/a.png">',
+        )
+    })
+
+    it('leaves HTML without cid: images alone', () => {
+        expect(rewriteInlineImages('<p>Hi</p>', 'msg-1')).toBe('<p>Hi</p>')
+    })
+})
@@ -0,0 +1,27 @@
+import { getInlinePartUrl } from './api'
+
+/**
+ * Points the "cid:" images of sanitized HTML to the inline parts of the message, which the backend serves.
+ * Content-IDs in cid: URLs can be percent-encoded (RFC 2392), so they're decoded first.
+ */
+export function rewriteInlineImages(html: string, messageId: string): string {
+    if (!/cid:/i.test(html)) {
+        return html
+    }
+    const doc = new DOMParser().parseFromString(html, 'text/html')
+    for (const img of doc.querySelectorAll('img')) {
+        const match = /^cid:(.+)$/i.exec(img.getAttribute('src')?.trim() ?? '')
+        if (match) {
+            img.setAttribute('src', getInlinePartUrl(messageId, decodeContentId(match[1])))
+        }
+    }
+    return doc.body.innerHTML
+}
+
+function decodeContentId(contentId: string): string {
+    try {
+        return decodeURIComponent(contentId)
+    } catch {
+        return contentId
+    }
+}
@@ -0,0 +1,170 @@
+import { describe, it, expect } from 'vitest'
+
+import { blockRemoteImages, proxyRemoteImages } from './remoteImages'
+
+describe('blockRemoteImages', () => {
+    it('drops the src of remote images only', () => {
+        const { html, blockedImageCount } = blockRemoteImages(
+            '<img src="https://tracker.example.com/pixel.gif"><img src="cid:logo"><img src="data:image/png;base64,AA==">',
+        )
+
+        expect(blockedImageCount).toBe(1)
+        expect(html).toBe('<img><img src="cid:logo"><img src="data:image/png;base64,AA==">')
+    })
+
+    it('drops remote URLs from style attributes', () => {
+        const { html, blockedImageCount } = blockRemoteImages(
+            `<div style="background: url('https://tracker.example.com/bg.png') no-repeat">Hi</div>`,
+        )
+
+        expect(blockedImageCount).toBe(1)
+        expect(html).toBe('<div style="background: none no-repeat">Hi</div>')
+    })
+
+    it('drops remote URLs from style elements', () => {
+        const { html, blockedImageCount } = blockRemoteImages(
+            '<p>Hi</p><style>p { background-image: url(//tracker.example.com/bg.png) }</style>',
+        )
+
+        expect(blockedImageCount).toBe(1)
+        expect(html).not.toContain('tracker.example.com')
+    })
+
+    it('drops remote background attributes', () => {
+        const { html, blockedImageCount } = blockRemoteImages(
+            '<table background="https://tracker.example.com/bg.png"><tbody><tr><td>Hi</td></tr></tbody></table>',
+        )
+
+        expect(blockedImageCount).toBe(1)
+        expect(html).toBe('<table><tbody><tr><td>Hi</td></tr></tbody></table>')
+    })
+
+    it('drops the srcset of picture sources', () => {
+        const { html, blockedImageCount } = blockRemoteImages(
+            '<picture><source srcset="https://tracker.example.com/a.webp"><img src="cid:logo"></picture>',
+        )
+
+        expect(blockedImageCount).toBe(1)
+        expect(html).toBe('<picture><source><img src="cid:logo"></picture>')
+    })
+
+    it('drops remote video posters', () => {
+        const { html, blockedImageCount } = blockRemoteImages(
+            '<video poster="https://tracker.example.com/poster.png"></video>',
+        )
+
+        expect(blockedImageCount).toBe(1)
+        expect(html).toBe('<video></video>')
+    })
+
+    it.each([
+        ['video', '<video src="https://tracker.example.com/v.mp4"></video>', '<video></video>'],
+        ['audio', '<audio src="https://tracker.example.com/a.mp3"></audio>', '<audio></audio>'],
+        [
+            'media source',
+            '<video><source src="https://tracker.example.com/v.mp4"></video>',
+            '<video><source></video>',
+        ],
+        ['image input', '<input type="image" src="https://tracker.example.com/b.png">', '<input type="image">'],
+    ])('drops the src of %s elements', (_name, input, expected) => {
+        const { html, blockedImageCount } = blockRemoteImages(input)
+
+        expect(blockedImageCount).toBe(1)
+        expect(html).toBe(expected)
+    })
+
+    it('drops CSS @import rules', () => {
+        const { html, blockedImageCount } = blockRemoteImages(
+            '<p>Hi</p><style>@import "https://tracker.example.com/a.css"; p { color: red }</style>',
+        )
+
+        expect(blockedImageCount).toBe(1)
+        expect(html).not.toContain('tracker.example.com')
+        expect(html).toContain('p { color: red }')
+    })
+
+    it('drops image-set() with plain strings', () => {
+        const { html, blockedImageCount } = blockRemoteImages(
+            `<div style='background-image: image-set("https://tracker.example.com/a.png" 1x, "https://tracker.example.com/b.png" 2x)'>Hi</div>`,
+        )
+
+        expect(blockedImageCount).toBe(1)
+        expect(html).toBe('<div style="background-image: none">Hi</div>')
+    })
+
+    it('drops CSS with escapes', () => {
+        const { html, blockedImageCount } = blockRemoteImages(
+            '<div style="background: u\\72l(https://tracker.example.com/bg.png)">Hi</div>',
+        )
+
+        expect(blockedImageCount).toBe(1)
+        expect(html).toBe('<div>Hi</div>')
+    })
+
+    it('drops URLs split by CSS comments', () => {
+        const { html } = blockRemoteImages(
+            '<div style="background: url(/**/\'https://tracker.example.com/bg.png\')">Hi</div>',
+        )
+
+        expect(html).not.toContain('tracker.example.com')
+    })
+
+    it('drops remote SVG image links', () => {
+        const { html, blockedImageCount } = blockRemoteImages(
+            '<svg><filter id="f"><feImage href="https://tracker.example.com/a.png"></feImage></filter></svg>',
+        )
+
+        expect(blockedImageCount).toBe(1)
+        expect(html).not.toContain('tracker.example.com')
+    })
+
+    it('keeps embedded images in styles', () => {
+        const html = '<div style="background: url(data:image/png;base64,AA==)">Hi</div>'
+
+        expect(blockRemoteImages(html)).toEqual({ html, blockedImageCount: 0 })
+    })
+
+    it('leaves HTML without images alone', () => {
+        expect(blockRemoteImages('<p>Hi</p>')).toEqual({ html: '<p>Hi</p>', blockedImageCount: 0 })
+    })
+})
+
+describe('proxyRemoteImages', () => {
+    it('loads remote images through the image proxy', () => {
+        const html = proxyRemoteImages(
+            '<img src="https://example.com/a.png?x=1" srcset="https://example.com/a@2x.png 2x"><img src="cid:logo">',
+        )
+
+        expect(html).toBe(
+            '<img src="/api/v1/image-proxy?url=https%3A%2F%2Fexample.com%2Fa.png%3Fx%3D1"><img src="cid:logo">',
+        )
+    })
+
+    it('loads protocol-relative images through the image proxy over HTTPS', () => {
+        expect(proxyRemoteImages('<img src="//example.com/a.png">')).toBe(
+            '<img src="/api/v1/image-proxy?url=https%3A%2F%2Fexample.com%2Fa.png">',
+        )
+    })
+
+    it('loads image-set() strings through the image proxy, and drops media sources', () => {
+        const html = proxyRemoteImages(
+            `<div style='background-image: image-set("https://example.com/a.png" 1x)'></div><video src="https://example.com/v.mp4"></video>`,
+        )
+
+        expect(html).toBe(
+            `<div style="background-image: image-set(&quot;/api/v1/image-proxy?url=https%3A%2F%2Fexample.com%2Fa.png&quot; 1x)"></div>` +
+                '<video></video>',
+        )
+    })
+
+    it('loads CSS and poster images through the image proxy', () => {
+        const html = proxyRemoteImages(
+            '<div style="background: url(https://example.com/bg.png)"></div><video poster="https://example.com/p.png"></video>',
+        )
+
+        expect(html).toBe(
+            '<div style="background: url(&quot;/api/v1/image-proxy?url=https%3A%2F%2Fexample.com%2Fbg.png&quot;)"></div>' +
+                '<video poster="/api/v1/image-proxy?url=https%3A%2F%2Fexample.com%2Fp.png"></video>',
+        )
+    })
+})
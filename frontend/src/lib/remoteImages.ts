@@ -0,0 +1,162 @@
+import DOMPurify from 'dompurify'
+
+import { getImageProxyUrl } from './api'
+
+const svgNamespace = 'http://www.w3.org/2000/svg'
+const cssCommentPattern = /\/\*[\s\S]*?\*\//g
+const cssImportPattern = /@import\b[^;]*;?/gi
+const cssImageSetPattern = /(?:-webkit-)?image-set\(/gi
+const cssStringPattern = /(['"])(.*?)\1/g
+const cssUrlPattern = /url\(\s*(['"]?)(.*?)\1\s*\)/gi
+
+/**
+ * Drops the images of sanitized HTML that would load from the network: img src and srcset, picture sources, video
+ * posters, background attributes, SVG image links, and CSS url() and image-set() in style attributes and elements.
+ * Inline (cid:) and embedded (data:) images stay. Returns the new HTML and how many elements it blocked images of.
+ * Media sources and CSS @import rules are always dropped, see rewriteImageUrls.
+ */
+export function blockRemoteImages(html: string): { html: string; blockedImageCount: number } {
+    const { html: blockedHtml, rewrittenElementCount } = rewriteImageUrls(html, (url) =>
+        url && !/^(data|cid):/i.test(url) ? '' : url,
+    )
+    return { html: blockedHtml, blockedImageCount: rewrittenElementCount }
+}
+
+/**
+ * Loads the remote images of sanitized HTML through our image proxy, for senders whose images the user allowed.
+ * Covers the same images as blockRemoteImages. srcset is dropped, since it would load the images directly.
+ */
+export function proxyRemoteImages(html: string): string {
+    return rewriteImageUrls(html, (url) => {
+        // Protocol-relative URLs would load from the sender's server directly
+        const absoluteUrl = url.startsWith('//') ? `https:${url}` : url
+        return /^https?:/i.test(absoluteUrl) ? getImageProxyUrl(absoluteUrl) : url
+    }).html
+}
+
+/**
+ * Runs the image URLs of the HTML through rewrite while DOMPurify sanitizes it, since its hooks see every attribute.
+ * rewrite returns the URL to load instead, or an empty string to load nothing.
+ * Whatever the proxy can't serve goes in both modes: the src of audio, video, and the like, CSS @import rules, and
+ * CSS with escapes, since escapes like "u\72l(" can spell a URL that the patterns here don't see.
+ * Returns the new HTML and how many elements had an image URL rewritten or dropped.
+ */
+function rewriteImageUrls(
+    html: string,
+    rewrite: (url: string) => string,
+): { html: string; rewrittenElementCount: number } {
+    const rewrittenElements = new Set<Node>()
+    const rewriteCss = (css: string, node: Node) => {
+        const onRewrite = () => {
+            rewrittenElements.add(node)
+        }
+        if (css.includes('\\')) {
+            onRewrite()
+            return ''
+        }
+        const withoutImports = css.replace(cssCommentPattern, '').replace(cssImportPattern, () => {
+            onRewrite()
+            return ''
+        })
+        return rewriteCssUrls(rewriteCssImageSets(withoutImports, rewrite, onRewrite), rewrite, onRewrite)
+    }
+
+    DOMPurify.addHook('uponSanitizeElement', (node, data) => {
+        if (data.tagName === 'style' && node.textContent) {
+            node.textContent = rewriteCss(node.textContent, node)
+        }
+    })
+    DOMPurify.addHook('uponSanitizeAttribute', (node, data) => {
+        const tagName = node.nodeName.toLowerCase()
+        const isSvgLink =
+            (data.attrName === 'href' || data.attrName === 'xlink:href') &&
+            node.namespaceURI === svgNamespace &&
+            tagName !== 'a' &&
+            !data.attrValue.trim().startsWith('#')
+        if (data.attrName === 'style') {
+            const css = rewriteCss(data.attrValue, node)
+            data.attrValue = css
+            data.keepAttr = css.trim() !== ''
+        } else if (data.attrName === 'srcset' && (tagName === 'img' || tagName === 'source')) {
+            data.keepAttr = false
+            rewrittenElements.add(node)
+        } else if (data.attrName === 'src' && tagName !== 'img') {
+            // Like <video>, <audio>, <source>, and <input type="image">, which the image proxy can't serve
+            data.keepAttr = false
+            rewrittenElements.add(node)
+        } else if (
+            (data.attrName === 'src' && tagName === 'img') ||
+            (data.attrName === 'poster' && tagName === 'video') ||
+            data.attrName === 'background' ||
+            isSvgLink
+        ) {
+            const url = data.attrValue.trim()
+            const rewritten = rewrite(url)
+            if (rewritten !== url) {
+                data.attrValue = rewritten
+                data.keepAttr = rewritten !== ''
+                rewrittenElements.add(node)
+            }
+        }
+    })
+    try {
+        return { html: DOMPurify.sanitize(html), rewrittenElementCount: rewrittenElements.size }
+    } finally {
+        DOMPurify.removeHook('uponSanitizeAttribute')
+        DOMPurify.removeHook('uponSanitizeElement')
+    }
+}
+
+/** Rewrites the URLs in the url() functions of CSS. Dropped ones become "none". */
+function rewriteCssUrls(css: string, rewrite: (url: string) => string, onRewrite: () => void): string {
+    return css.replace(cssUrlPattern, (match, _quote, url: string) => {
+        const rewritten = rewrite(url.trim())
+        if (rewritten === url.trim()) {
+            return match
+        }
+        onRewrite()
+        return rewritten ? `url("${rewritten}")` : 'none'
+    })
+}
+
+/**
+ * Rewrites the strings in the image-set() functions of CSS, which are URLs like in url(). If any of them is dropped,
+ * the whole image-set() becomes "none". The url() functions in them are left to rewriteCssUrls.
+ */
+function rewriteCssImageSets(css: string, rewrite: (url: string) => string, onRewrite: () => void): string {
+    let result = ''
+    let last = 0
+    for (const match of css.matchAll(cssImageSetPattern)) {
+        if (match.index < last) {
+            continue
+        }
+        const start = match.index + match[0].length
+        const end = findClosingParenthesis(css, start)
+        let dropped = false
+        const args = css.slice(start, end).replace(cssStringPattern, (string, quote: string, url: string) => {
+            const rewritten = rewrite(url.trim())
+            if (rewritten === url.trim()) {
+                return string
+            }
+            onRewrite()
+            dropped ||= rewritten === ''
+            return `${quote}${rewritten}${quote}`
+        })
+        result += css.slice(last, match.index) + (dropped ? 'none' : `${match[0]}${args})`)
+        last = end + 1
+    }
+    return result + css.slice(last)
+}
+
+/** Returns the index of the parenthesis that closes the one before start, or the end of the CSS if there's none. */
+function findClosingParenthesis(css: string, start: number): number {
+    let depth = 1
+    for (let i = start; i < css.length; i++) {
+        if (css[i] === '(') {
+            depth++
+        } else if (css[i] === ')' && --depth === 0) {
+            return i
+        }
+    }
+    return css.length
+}
@@ -12,13 +12,13 @@ export default function InboxPage() {
     const page = parseInt(searchParams.get('page') || '1', 10)
     const selectedThreadIndex = useUIStore((state) => state.selectedThreadIndex)
 
-    // Get user settings to determine pagination limit
-    const { data: settings } = useQuery({
-        queryKey: ['settings'],
-        queryFn: () => api.getSettings(),
+    // Get user preferences to determine pagination limit
+    const { data: preferences } = useQuery({
+        queryKey: ['preferences'],
+        queryFn: () => api.getPreferences(),
     })
 
-    const limit = settings?.pagination_threads_per_page ?? 100
+    const limit = preferences?.pagination_threads_per_page ?? 100
 
     const {
         data: threadsResponse,
@@ -27,7 +27,9 @@ export default function InboxPage() {
     } = useQuery({
         queryKey: ['threads', folder, page, limit],
         queryFn: () => api.getThreads(folder, page, limit),
-        enabled: !!settings, // Wait for settings to load before fetching threads
+        enabled: !!preferences, // Wait for preferences to load before fetching threads
+        // A partial response is a quick preview while the server syncs the folder, so check back soon
+        refetchInterval: (query) => (query.state.data?.partial ? 3000 : false),
     })
 
     if (isLoading) {
@@ -62,6 +64,9 @@ export default function InboxPage() {
                     <span className='rounded-full border border-white/10 px-3 py-1 text-xs uppercase tracking-wide text-slate-300'>
                         Compact view
                     </span>
+                    {(threadsResponse?.partial || threadsResponse?.syncing) && (
+                        <span className='text-xs text-slate-400'>Syncing folder...</span>
+                    )}
                 </div>
             </div>
             <div className='flex-1 overflow-y-auto divide-y divide-white/5'>
@@ -70,7 +75,7 @@ export default function InboxPage() {
                 threadsResponse.threads.length > 0 ? (
                     threadsResponse.threads.map((thread, index) => (
                         <EmailListItem
-                            key={thread.id}
+                            key={thread.id || thread.stable_thread_id}
                             thread={thread}
                             isSelected={selectedThreadIndex === index}
                         />
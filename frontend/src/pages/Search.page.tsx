@@ -91,13 +91,13 @@ export default function SearchPage() {
     const page = parseInt(searchParams.get('page') || '1', 10)
     const selectedThreadIndex = useUIStore((state) => state.selectedThreadIndex)
 
-    // Get user settings to determine pagination limit
-    const { data: settings } = useQuery({
-        queryKey: ['settings'],
-        queryFn: () => api.getSettings(),
+    // Get user preferences to determine pagination limit
+    const { data: preferences } = useQuery({
+        queryKey: ['preferences'],
+        queryFn: () => api.getPreferences(),
     })
 
-    const limit = settings?.pagination_threads_per_page ?? 100
+    const limit = preferences?.pagination_threads_per_page ?? 100
 
     const {
         data: threadsResponse,
@@ -106,7 +106,7 @@ export default function SearchPage() {
     } = useQuery({
         queryKey: ['search', query, page, limit],
         queryFn: () => api.search(query, page, limit),
-        enabled: !!settings, // Wait for settings (empty query is allowed)
+        enabled: !!preferences, // Wait for preferences (empty query is allowed)
     })
 
     if (isLoading) {
@@ -5,7 +5,7 @@ import { MemoryRouter } from 'react-router-dom'
 import { describe, it, expect, vi, beforeEach } from 'vitest'
 
 import * as apiModule from '../lib/api'
-import type { UserSettings } from '../lib/api'
+import type { UserPreferences, UserSettings } from '../lib/api'
 
 import SettingsPage from './Settings.page'
 
@@ -13,6 +13,8 @@ vi.mock('../lib/api', () => ({
     api: {
         getSettings: vi.fn(),
         saveSettings: vi.fn(),
+        getPreferences: vi.fn(),
+        patchPreferences: vi.fn(),
     },
 }))
 
@@ -23,8 +25,12 @@ const mockSettings: UserSettings = {
     smtp_server_hostname: 'smtp.example.com:587',
     smtp_username: 'user@example.com',
     smtp_password: 'password123',
+}
+
+const mockPreferences: UserPreferences = {
     undo_send_delay_seconds: 20,
     pagination_threads_per_page: 100,
+    ui: {},
 }
 
 describe('SettingsPage', () => {
@@ -39,6 +45,10 @@ describe('SettingsPage', () => {
             },
         })
         vi.clearAllMocks()
+        // eslint-disable-next-line @typescript-eslint/unbound-method
+        vi.mocked(apiModule.api.getPreferences).mockResolvedValue(mockPreferences)
+        // eslint-disable-next-line @typescript-eslint/unbound-method
+        vi.mocked(apiModule.api.patchPreferences).mockResolvedValue(mockPreferences)
     })
 
     const renderSettingsPage = () => {
@@ -199,4 +209,33 @@ describe('SettingsPage', () => {
             { timeout: 5000 },
         )
     })
+
+    it('should retry with confirmation when the IMAP account changes', async () => {
+        const conflict = Object.assign(new Error('Failed to save settings'), { status: 409 })
+        // eslint-disable-next-line @typescript-eslint/unbound-method
+        vi.mocked(apiModule.api.getSettings).mockResolvedValue(mockSettings)
+        // eslint-disable-next-line @typescript-eslint/unbound-method
+        vi.mocked(apiModule.api.saveSettings)
+            .mockRejectedValueOnce(conflict)
+            .mockResolvedValueOnce()
+        const confirmSpy = vi.spyOn(window, 'confirm').mockReturnValue(true)
+
+        const user = userEvent.setup()
+        renderSettingsPage()
+
+        await updatePasswordsAndSubmit(user)
+
+        await waitFor(
+            () => {
+                expect(screen.getByText('Settings saved successfully')).toBeInTheDocument()
+            },
+            { timeout: 5000 },
+        )
+        expect(confirmSpy).toHaveBeenCalled()
+        // eslint-disable-next-line @typescript-eslint/unbound-method
+        expect(apiModule.api.saveSettings).toHaveBeenLastCalledWith(expect.anything(), {
+            confirmIdentityChange: true,
+        })
+        confirmSpy.mockRestore()
+    })
 })
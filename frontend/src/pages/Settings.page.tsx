@@ -3,7 +3,7 @@ import * as React from 'react'
 import { useEffect, useRef, useState } from 'react'
 import { useNavigate } from 'react-router-dom'
 
-import { api, type UserSettings } from '../lib/api'
+import { api, type UserPreferencesPatch, type UserSettings } from '../lib/api'
 
 const defaultSettings: UserSettings = {
     imap_server_hostname: '',
@@ -12,6 +12,9 @@ const defaultSettings: UserSettings = {
     smtp_server_hostname: '',
     smtp_username: '',
     smtp_password: '',
+}
+
+const defaultPreferences = {
     undo_send_delay_seconds: 20,
     pagination_threads_per_page: 100,
 }
@@ -24,6 +27,7 @@ export default function SettingsPage() {
     const navigate = useNavigate()
     const [saveMessage, setSaveMessage] = useState<string | null>(null)
     const initializedRef = useRef(false)
+    const preferencesInitializedRef = useRef(false)
     const wasNewUserRef = useRef(false)
 
     const { data, isLoading, isError, error } = useQuery<UserSettings>({
@@ -32,6 +36,12 @@ export default function SettingsPage() {
         retry: false,
     })
 
+    // Preferences always exist (the backend returns defaults), so they don't affect onboarding
+    const { data: preferences } = useQuery({
+        queryKey: ['preferences'],
+        queryFn: () => api.getPreferences(),
+    })
+
     const [formData, setFormData] = useState<UserSettings>(defaultSettings)
 
     // Store raw string values for number inputs to allow for an empty state.
@@ -41,8 +51,8 @@ export default function SettingsPage() {
         undo_send_delay_seconds: string
         pagination_threads_per_page: string
     }>({
-        undo_send_delay_seconds: '20',
-        pagination_threads_per_page: '100',
+        undo_send_delay_seconds: String(defaultPreferences.undo_send_delay_seconds),
+        pagination_threads_per_page: String(defaultPreferences.pagination_threads_per_page),
     })
 
     // Initialize form data from query data when it first loads
@@ -56,10 +66,6 @@ export default function SettingsPage() {
                 imap_password: '',
                 smtp_password: '',
             })
-            setNumberInputs({
-                undo_send_delay_seconds: String(data.undo_send_delay_seconds),
-                pagination_threads_per_page: String(data.pagination_threads_per_page),
-            })
         } else if (isError && !initializedRef.current) {
             initializedRef.current = true
 
@@ -70,20 +76,52 @@ export default function SettingsPage() {
             wasNewUserRef.current = fetchError.status === 404 // Only true for 404 (settings not found)
 
             setFormData(defaultSettings)
+        }
+    }, [data, isError, error])
+
+    // Initialize number inputs from preferences when they first load
+    useEffect(() => {
+        if (preferences && !preferencesInitializedRef.current) {
+            preferencesInitializedRef.current = true
+            // eslint-disable-next-line react-hooks/set-state-in-effect -- Synchronizing external query state with form state
             setNumberInputs({
-                undo_send_delay_seconds: String(defaultSettings.undo_send_delay_seconds),
-                pagination_threads_per_page: String(defaultSettings.pagination_threads_per_page),
+                undo_send_delay_seconds: String(preferences.undo_send_delay_seconds),
+                pagination_threads_per_page: String(preferences.pagination_threads_per_page),
             })
         }
-    }, [data, isError, error])
+    }, [preferences])
 
     const saveMutation = useMutation({
-        mutationFn: (settings: UserSettings) => api.saveSettings(settings),
+        mutationFn: async ({
+            settings,
+            preferencesPatch,
+        }: {
+            settings: UserSettings
+            preferencesPatch: UserPreferencesPatch
+        }) => {
+            try {
+                await api.saveSettings(settings)
+            } catch (e) {
+                if ((e as Error & { status?: number }).status !== 409) {
+                    throw e
+                }
+                const confirmed = window.confirm(
+                    'You changed your IMAP server or username. V-Mail will clear the cached emails of the old account. Continue?',
+                )
+                if (!confirmed) {
+                    throw new Error('Settings not saved')
+                }
+                await api.saveSettings(settings, { confirmIdentityChange: true })
+            }
+            await api.patchPreferences(preferencesPatch)
+        },
         onSuccess: () => {
             void queryClient.invalidateQueries({ queryKey: ['settings'] })
+            void queryClient.invalidateQueries({ queryKey: ['preferences'] })
             void queryClient.invalidateQueries({ queryKey: ['authStatus'] })
             // Invalidate threads queries so they refetch with new pagination limit
             void queryClient.invalidateQueries({ queryKey: ['threads'] })
+            void queryClient.invalidateQueries({ queryKey: ['folders'] })
             setSaveMessage('Settings saved successfully')
 
             // If this was a new user completing onboarding, redirect to inbox
@@ -114,14 +152,6 @@ export default function SettingsPage() {
                 ...prev,
                 [name]: value,
             }))
-            // Only update formData if the value is a valid number
-            const numValue = value === '' ? 0 : parseInt(value, 10)
-            if (!isNaN(numValue)) {
-                setFormData((prev) => ({
-                    ...prev,
-                    [name]: numValue,
-                }))
-            }
         } else {
             setFormData((prev) => ({
                 ...prev,
@@ -138,22 +168,17 @@ export default function SettingsPage() {
             ...prev,
             [name]: String(finalValue),
         }))
-        setFormData((prev) => ({
-            ...prev,
-            [name]: finalValue,
-        }))
     }
 
     const handleSubmit = (e: React.FormEvent) => {
         e.preventDefault()
         // Ensure number fields are integers before submitting
-        const submitData = {
-            ...formData,
+        const preferencesPatch: UserPreferencesPatch = {
             undo_send_delay_seconds: parseInt(numberInputs.undo_send_delay_seconds, 10) || 0,
             pagination_threads_per_page:
                 parseInt(numberInputs.pagination_threads_per_page, 10) || 0,
         }
-        saveMutation.mutate(submitData)
+        saveMutation.mutate({ settings: formData, preferencesPatch })
     }
 
     if (isLoading) {
@@ -1,4 +1,4 @@
-import { useQuery } from '@tanstack/react-query'
+import { useQuery, useQueryClient } from '@tanstack/react-query'
 import { useParams, useNavigate } from 'react-router-dom'
 
 import Message from '../components/Message'
@@ -33,6 +33,7 @@ function ErrorState({ message, onBack }: ErrorStateProps) {
 export default function ThreadPage() {
     const { threadId: encodedThreadId } = useParams<{ threadId: string }>()
     const navigate = useNavigate()
+    const queryClient = useQueryClient()
 
     // Decode the base64 URL-safe thread ID to get the raw Message-ID
     let rawThreadId: string | null = null
@@ -65,6 +66,14 @@ export default function ThreadPage() {
         void navigate('/')
     }
 
+    // The response is the thread with images allowed, so we can show it without refetching
+    const handleTrustSender = (email: string) => {
+        if (!rawThreadId) return
+        void api.trustSender(rawThreadId, email).then((updatedThread) => {
+            queryClient.setQueryData(['thread', rawThreadId], updatedThread)
+        })
+    }
+
     if (isLoading) {
         return LoadingState
     }
@@ -104,7 +113,11 @@ export default function ThreadPage() {
                 {thread.messages && thread.messages.length > 0 ? (
                     <div className='flex flex-col gap-4'>
                         {thread.messages.map((message) => (
-                            <Message key={message.id} message={message} />
+                            <Message
+                                key={message.id}
+                                message={message}
+                                onTrustSender={handleTrustSender}
+                            />
                         ))}
                     </div>
                 ) : (
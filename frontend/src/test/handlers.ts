@@ -14,8 +14,14 @@ export const handlers = [
             drafts_folder_name: 'Drafts',
             trash_folder_name: 'Trash',
             spam_folder_name: 'Spam',
+        })
+    }),
+
+    http.get('/api/v1/preferences', () => {
+        return HttpResponse.json({
             undo_send_delay_seconds: 20,
             pagination_threads_per_page: 100,
+            ui: {},
         })
     }),
 
@@ -96,6 +102,8 @@ export const handlers = [
                     total_count: threads.length,
                     page: page,
                     per_page: limit,
+                    total_estimated: false,
+                    next_cursor: null,
                 },
             })
         }
@@ -106,6 +114,8 @@ export const handlers = [
                 total_count: 0,
                 page: 1,
                 per_page: limit,
+                total_estimated: false,
+                next_cursor: null,
             },
         })
     }),
@@ -274,6 +284,8 @@ export const handlers = [
                     total_count: 0,
                     page: page,
                     per_page: limit,
+                    total_estimated: false,
+                    next_cursor: null,
                 },
             })
         }
@@ -284,6 +296,8 @@ export const handlers = [
                 total_count: allThreads.length,
                 page: page,
                 per_page: limit,
+                total_estimated: false,
+                next_cursor: null,
             },
         })
     }),
@@ -40,6 +40,7 @@ export default defineConfig({
                 VMAIL_TEST_MODE: 'true',
                 PORT: '11765', // Use different port for E2E tests
                 VMAIL_IMAP_MAX_WORKERS: '50', // Increase max workers for faster tests
+                VMAIL_RATE_LIMIT_PER_MINUTE: '0', // All tests share one user, so don't rate-limit them
             },
         },
         {
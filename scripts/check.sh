@@ -9,6 +9,10 @@ NC='\033[0m' # No Color
 
 # Global state
 FAILED=0
+# Set by --race. Runs backend tests with the Go race detector.
+BACKEND_TEST_RACE=false
+# The number of Go packages to test at the same time. Override with VMAIL_CHECK_TEST_JOBS.
+BACKEND_TEST_JOBS="${VMAIL_CHECK_TEST_JOBS:-$(getconf _NPROCESSORS_ONLN 2>/dev/null || echo 4)}"
 
 # Check if a command exists
 command_exists() {
@@ -245,15 +249,68 @@ run_backend_nilaway() {
     fi
 }
 
+# Runs a single package's tests and stores the output and exit code in the given directory.
+# Used by run_backend_tests to run packages in parallel.
+run_backend_package_tests() {
+    local pkg="$1"
+    local out_dir="$2"
+    local out_file="$out_dir/$(echo "$pkg" | tr '/' '_')"
+    local race_flag=""
+    if [ "$BACKEND_TEST_RACE" = true ]; then
+        race_flag="-race"
+    fi
+
+    if go test $race_flag "$pkg" > "$out_file.log" 2>&1; then
+        echo 0 > "$out_file.status"
+    else
+        echo 1 > "$out_file.status"
+    fi
+    echo "$pkg" > "$out_file.pkg"
+}
+
 run_backend_tests() {
-    echo -n "  • tests... "
-    if ! go test ./... > /dev/null 2>&1; then
-        echo -e "${RED}FAILED${NC}"
-        go test ./...
+    if [ "$BACKEND_TEST_RACE" = true ]; then
+        echo -n "  • tests (race detector, $BACKEND_TEST_JOBS parallel)... "
+    else
+        echo -n "  • tests ($BACKEND_TEST_JOBS parallel)... "
+    fi
+
+    local out_dir
+    out_dir=$(mktemp -d)
+
+    # Run each package in its own `go test` process so we can cap the parallelism and
+    # collect every failure instead of stopping at the first failing package.
+    local packages
+    packages=$(go list ./...)
+    for pkg in $packages; do
+        while [ "$(jobs -rp | wc -l)" -ge "$BACKEND_TEST_JOBS" ]; do
+            sleep 0.1
+        done
+        run_backend_package_tests "$pkg" "$out_dir" &
+    done
+    wait
+
+    local failed_packages=()
+    for status_file in "$out_dir"/*.status; do
+        [ -f "$status_file" ] || continue
+        if [ "$(cat "$status_file")" != "0" ]; then
+            failed_packages+=("${status_file%.status}")
+        fi
+    done
+
+    if [ ${#failed_packages[@]} -gt 0 ]; then
+        echo -e "${RED}FAILED${NC} (${#failed_packages[@]} packages)"
+        for base in "${failed_packages[@]}"; do
+            echo ""
+            echo -e "    ${RED}✗ $(cat "$base.pkg")${NC}"
+            sed 's/^/      /' "$base.log"
+        done
         FAILED=1
     else
         echo -e "${GREEN}OK${NC}"
     fi
+
+    rm -rf "$out_dir"
 }
 
 # Frontend check functions
@@ -350,13 +407,15 @@ OPTIONS:
     --backend         Alias for --backend-only
     --frontend        Alias for --frontend-only
     --check NAME      Run a single check by name (for CI)
+    --race            Run backend tests with the Go race detector. Combine with any other option.
     -h, --help        Show this help message
 
 If no options are provided, runs all checks (backend and frontend).
 
 Available check names:
   Backend: gofmt, go-mod-tidy, govulncheck, go-vet, staticcheck, 
-           ineffassign, misspell, gocyclo, nilaway, backend-tests
+           ineffassign, misspell, gocyclo, nilaway, backend-tests,
+           backend-tests-race
   Frontend: prettier, eslint, frontend-tests, e2e-tests
 
 EXAMPLES:
@@ -365,6 +424,10 @@ EXAMPLES:
     $0 --frontend         # Run only frontend checks (alias)
     $0 --check gofmt      # Run only gofmt check
     $0 --check govulncheck # Run only vulnerability check
+    $0 --backend --race   # Run backend checks, with race detection for tests
+
+ENVIRONMENT:
+    VMAIL_CHECK_TEST_JOBS  Number of Go packages to test in parallel (default: number of CPUs)
 EOF
 }
 
@@ -374,7 +437,7 @@ run_single_check() {
     
     # Setup for backend checks
     case "$check_name" in
-        gofmt|go-mod-tidy|govulncheck|go-vet|staticcheck|ineffassign|misspell|gocyclo|nilaway|backend-tests)
+        gofmt|go-mod-tidy|govulncheck|go-vet|staticcheck|ineffassign|misspell|gocyclo|nilaway|backend-tests|backend-tests-race)
             # Backend check - need to be in backend directory
             cd backend
             export GOTOOLCHAIN=auto
@@ -440,6 +503,10 @@ run_single_check() {
         backend-tests)
             run_backend_tests
             ;;
+        backend-tests-race)
+            BACKEND_TEST_RACE=true
+            run_backend_tests
+            ;;
         frontend-tests)
             run_frontend_tests
             ;;
@@ -465,37 +532,41 @@ main() {
     local run_frontend=true
     local single_check=""
     
-    case "${1:-}" in
-        --backend-only|--backend)
-            run_frontend=false
-            ;;
-        --frontend-only|--frontend)
-            run_backend=false
-            ;;
-        --check)
-            if [ -z "${2:-}" ]; then
-                echo "Error: --check requires a check name" >&2
-                echo "Run $0 --help to see available checks" >&2
+    while [ $# -gt 0 ]; do
+        case "$1" in
+            --backend-only|--backend)
+                run_frontend=false
+                ;;
+            --frontend-only|--frontend)
+                run_backend=false
+                ;;
+            --check)
+                if [ -z "${2:-}" ]; then
+                    echo "Error: --check requires a check name" >&2
+                    echo "Run $0 --help to see available checks" >&2
+                    exit 1
+                fi
+                single_check="$2"
+                run_backend=false
+                run_frontend=false
+                shift
+                ;;
+            --race)
+                BACKEND_TEST_RACE=true
+                ;;
+            -h|--help)
+                show_usage
+                exit 0
+                ;;
+            *)
+                echo "Error: Unknown option: $1" >&2
+                echo ""
+                show_usage
                 exit 1
-            fi
-            single_check="$2"
-            run_backend=false
-            run_frontend=false
-            ;;
-        -h|--help)
-            show_usage
-            exit 0
-            ;;
-        "")
-            # No arguments, run everything
-            ;;
-        *)
-            echo "Error: Unknown option: $1" >&2
-            echo ""
-            show_usage
-            exit 1
-            ;;
-    esac
+                ;;
+        esac
+        shift
+    done
     
     # If running a single check, do that and exit
     if [ -n "$single_check" ]; then
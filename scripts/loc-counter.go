@@ -3,29 +3,81 @@ package main
 import (
 	"bufio"
 	"encoding/csv"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
 	"time"
 )
 
+// lineCounts holds the line counts for a single commit.
+// It's also the format of the per-commit cache, so keep the JSON tags stable.
+type lineCounts struct {
+	Total   int `json:"total"`
+	TS      int `json:"ts"`
+	GoTotal int `json:"go"`
+	GoProd  int `json:"go_prod"`
+	GoTest  int `json:"go_test"`
+	TSProd  int `json:"ts_prod"`
+	TSTest  int `json:"ts_test"`
+	Docs    int `json:"docs"`
+	Other   int `json:"other"`
+}
+
 type fileStats struct {
-	total    int
-	ts       int
-	goTotal  int
-	goProd   int
-	goTest   int
-	tsProd   int
-	tsTest   int
-	docs     int
-	other    int
+	lineCounts
 	comments []string
 }
 
+// dailyRow is a single day in the output.
+type dailyRow struct {
+	Date string `json:"date"`
+	lineCounts
+	Comments string `json:"comments"`
+}
+
+// packageRow holds the line counts for a single Go package or TypeScript directory.
+type packageRow struct {
+	Path     string `json:"path"`
+	Language string `json:"language"`
+	Prod     int    `json:"prod"`
+	Test     int    `json:"test"`
+	Total    int    `json:"total"`
+}
+
+type options struct {
+	byPackage bool
+	format    string
+	cachePath string
+}
+
 func main() {
+	opts, err := parseFlags()
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	if opts.byPackage {
+		rows, err := countLinesByPackage("HEAD")
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error counting lines by package: %v\n", err)
+			os.Exit(1)
+		}
+		if err := writePackageRows(rows, opts.format); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	cache := loadCache(opts.cachePath)
+
 	// Get all commits on the main branch
 	commits, err := getCommits()
 	if err != nil {
@@ -53,17 +105,9 @@ func main() {
 	// Generate all consecutive dates
 	allConsecutiveDates := generateConsecutiveDates(firstDate, lastDate)
 
-	// Output CSV header
-	writer := csv.NewWriter(os.Stdout)
-	defer writer.Flush()
-	err = writer.Write([]string{"date", "total", "ts", "go", "go prod", "go test", "ts prod", "ts test", "docs", "other", "comments"})
-	if err != nil {
-		_, err = fmt.Fprintf(os.Stderr, "Error writing CSV header: %v\n", err)
-		return
-	}
-
 	// Track previous stats for filling gaps
 	var prevStats *fileStats
+	var rows []dailyRow
 
 	// Process each date
 	for _, date := range allConsecutiveDates {
@@ -72,32 +116,17 @@ func main() {
 		var comments string
 
 		if hasCommit {
-			// Count lines for this commit
+			// Count lines for this commit, using the cache if we've seen it before
 			var err error
-			stats, err = countLinesForCommit(commit.hash, commit.messages)
+			stats, err = countLinesForCommitCached(cache, commit.hash, commit.messages)
 			if err != nil {
-				_, err := fmt.Fprintf(os.Stderr, "Error counting lines for commit %s: %v\n", commit.hash, err)
-				if err != nil {
-					return
-				}
+				_, _ = fmt.Fprintf(os.Stderr, "Error counting lines for commit %s: %v\n", commit.hash, err)
 				// Use previous stats if counting fails
-				if prevStats != nil {
-					// Create a copy of previous stats
-					stats = &fileStats{
-						total:   prevStats.total,
-						ts:      prevStats.ts,
-						goTotal: prevStats.goTotal,
-						goProd:  prevStats.goProd,
-						goTest:  prevStats.goTest,
-						tsProd:  prevStats.tsProd,
-						tsTest:  prevStats.tsTest,
-						docs:    prevStats.docs,
-						other:   prevStats.other,
-					}
-					comments = "-"
-				} else {
+				if prevStats == nil {
 					continue
 				}
+				stats = &fileStats{lineCounts: prevStats.lineCounts}
+				comments = "-"
 			} else {
 				// Format comments (first line of each commit message, joined with semicolon)
 				comments = strings.Join(stats.comments, "; ")
@@ -109,40 +138,162 @@ func main() {
 				// No previous stats available, skip this day
 				continue
 			}
-			// Create a copy of previous stats
-			stats = &fileStats{
-				total:   prevStats.total,
-				ts:      prevStats.ts,
-				goTotal: prevStats.goTotal,
-				goProd:  prevStats.goProd,
-				goTest:  prevStats.goTest,
-				tsProd:  prevStats.tsProd,
-				tsTest:  prevStats.tsTest,
-				docs:    prevStats.docs,
-				other:   prevStats.other,
-			}
+			stats = &fileStats{lineCounts: prevStats.lineCounts}
 			comments = "-"
 		}
 
-		// Write CSV row
+		rows = append(rows, dailyRow{Date: date, lineCounts: stats.lineCounts, Comments: comments})
+	}
+
+	if err := saveCache(opts.cachePath, cache); err != nil {
+		// A failed cache write only makes the next run slower, so don't fail the run
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: failed to save cache: %v\n", err)
+	}
+
+	if err := writeDailyRows(rows, opts.format); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func parseFlags() (options, error) {
+	var opts options
+	var noCache bool
+	flag.BoolVar(&opts.byPackage, "by-package", false, "Report lines per Go package and TypeScript directory at HEAD instead of the daily history")
+	flag.StringVar(&opts.format, "format", "csv", "Output format: csv or json")
+	flag.StringVar(&opts.cachePath, "cache", defaultCachePath(), "Path to the per-commit cache file")
+	flag.BoolVar(&noCache, "no-cache", false, "Don't read or write the per-commit cache")
+	flag.Parse()
+
+	if opts.format != "csv" && opts.format != "json" {
+		return opts, fmt.Errorf("unknown format %q, expected csv or json", opts.format)
+	}
+	if noCache {
+		opts.cachePath = ""
+	}
+	return opts, nil
+}
+
+// defaultCachePath returns the cache file in the user's cache directory, or an empty string
+// (no caching) if there isn't one.
+func defaultCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "vmail", "loc-counter.json")
+}
+
+// loadCache reads the per-commit cache. Commits are immutable, so entries never go stale.
+// A missing or broken cache file just means we start from scratch.
+func loadCache(cachePath string) map[string]lineCounts {
+	cache := make(map[string]lineCounts)
+	if cachePath == "" {
+		return cache
+	}
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		return cache
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: ignoring unreadable cache file %s: %v\n", cachePath, err)
+		return make(map[string]lineCounts)
+	}
+	return cache
+}
+
+func saveCache(cachePath string, cache map[string]lineCounts) error {
+	if cachePath == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0o755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache: %w", err)
+	}
+	// Write to a temp file first so an interrupted run can't leave a half-written cache
+	tmpPath := cachePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write cache: %w", err)
+	}
+	return os.Rename(tmpPath, cachePath)
+}
+
+func countLinesForCommitCached(cache map[string]lineCounts, commitHash string, messages []string) (*fileStats, error) {
+	if counts, ok := cache[commitHash]; ok {
+		return &fileStats{lineCounts: counts, comments: messages}, nil
+	}
+	stats, err := countLinesForCommit(commitHash, messages)
+	if err != nil {
+		return nil, err
+	}
+	cache[commitHash] = stats.lineCounts
+	return stats, nil
+}
+
+func writeDailyRows(rows []dailyRow, format string) error {
+	if format == "json" {
+		return writeJSON(rows)
+	}
+
+	writer := csv.NewWriter(os.Stdout)
+	err := writer.Write([]string{"date", "total", "ts", "go", "go prod", "go test", "ts prod", "ts test", "docs", "other", "comments"})
+	if err != nil {
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
+	for _, row := range rows {
 		err = writer.Write([]string{
-			date,
-			fmt.Sprintf("%d", stats.total),
-			fmt.Sprintf("%d", stats.ts),
-			fmt.Sprintf("%d", stats.goTotal),
-			fmt.Sprintf("%d", stats.goProd),
-			fmt.Sprintf("%d", stats.goTest),
-			fmt.Sprintf("%d", stats.tsProd),
-			fmt.Sprintf("%d", stats.tsTest),
-			fmt.Sprintf("%d", stats.docs),
-			fmt.Sprintf("%d", stats.other),
-			comments,
+			row.Date,
+			fmt.Sprintf("%d", row.Total),
+			fmt.Sprintf("%d", row.TS),
+			fmt.Sprintf("%d", row.GoTotal),
+			fmt.Sprintf("%d", row.GoProd),
+			fmt.Sprintf("%d", row.GoTest),
+			fmt.Sprintf("%d", row.TSProd),
+			fmt.Sprintf("%d", row.TSTest),
+			fmt.Sprintf("%d", row.Docs),
+			fmt.Sprintf("%d", row.Other),
+			row.Comments,
 		})
 		if err != nil {
-			_, _ = fmt.Fprintf(os.Stderr, "Error writing CSV row: %v\n", err)
-			return
+			return fmt.Errorf("failed to write CSV row: %w", err)
 		}
 	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writePackageRows(rows []packageRow, format string) error {
+	if format == "json" {
+		return writeJSON(rows)
+	}
+
+	writer := csv.NewWriter(os.Stdout)
+	if err := writer.Write([]string{"path", "language", "prod", "test", "total"}); err != nil {
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
+	for _, row := range rows {
+		err := writer.Write([]string{
+			row.Path,
+			row.Language,
+			fmt.Sprintf("%d", row.Prod),
+			fmt.Sprintf("%d", row.Test),
+			fmt.Sprintf("%d", row.Total),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write CSV row: %w", err)
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
 }
 
 type commit struct {
@@ -224,7 +375,7 @@ func countLinesForCommit(commitHash string, messages []string) (*fileStats, erro
 			continue
 		}
 
-		stats.total += lines
+		stats.Total += lines
 
 		// Categorize the file
 		ext := strings.ToLower(filepath.Ext(file))
@@ -233,33 +384,91 @@ func countLinesForCommit(commitHash string, messages []string) (*fileStats, erro
 		// Check path-based categorization first
 		switch {
 		case isGoTestPath(file):
-			stats.goTest += lines
-			stats.goTotal += lines
+			stats.GoTest += lines
+			stats.GoTotal += lines
 		case isTSTestPath(file):
-			stats.tsTest += lines
-			stats.ts += lines
+			stats.TSTest += lines
+			stats.TS += lines
 		case strings.HasSuffix(base, "_test.go"):
-			stats.goTest += lines
-			stats.goTotal += lines
+			stats.GoTest += lines
+			stats.GoTotal += lines
 		case ext == ".go":
-			stats.goProd += lines
-			stats.goTotal += lines
-		case strings.HasSuffix(base, ".test.ts") || strings.HasSuffix(base, ".test.tsx"):
-			stats.tsTest += lines
-			stats.ts += lines
+			stats.GoProd += lines
+			stats.GoTotal += lines
+		case isTSTestFile(base):
+			stats.TSTest += lines
+			stats.TS += lines
 		case ext == ".ts" || ext == ".tsx":
-			stats.tsProd += lines
-			stats.ts += lines
+			stats.TSProd += lines
+			stats.TS += lines
 		case ext == ".md" || base == "LICENSE":
-			stats.docs += lines
+			stats.Docs += lines
 		default:
-			stats.other += lines
+			stats.Other += lines
 		}
 	}
 
 	return stats, nil
 }
 
+// countLinesByPackage counts Go and TypeScript lines at the given commit, grouped by directory.
+// For Go, a directory is a package. Test code lives in the same directory, so it's split into prod and test.
+func countLinesByPackage(commitHash string) ([]packageRow, error) {
+	files, err := getFilesAtCommit(commitHash)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsByKey := make(map[string]*packageRow)
+	for _, file := range files {
+		ext := strings.ToLower(filepath.Ext(file))
+		var language string
+		switch ext {
+		case ".go":
+			language = "go"
+		case ".ts", ".tsx":
+			language = "ts"
+		default:
+			continue
+		}
+
+		lines, err := countFileLines(commitHash, file)
+		if err != nil {
+			continue
+		}
+
+		// Git paths always use forward slashes, so use "path" rather than "path/filepath"
+		dir := path.Dir(file)
+		key := language + ":" + dir
+		row, ok := rowsByKey[key]
+		if !ok {
+			row = &packageRow{Path: dir, Language: language}
+			rowsByKey[key] = row
+		}
+
+		base := path.Base(file)
+		isTest := strings.HasSuffix(base, "_test.go") || isTSTestFile(base) || isGoTestPath(file) || isTSTestPath(file)
+		if isTest {
+			row.Test += lines
+		} else {
+			row.Prod += lines
+		}
+		row.Total += lines
+	}
+
+	rows := make([]packageRow, 0, len(rowsByKey))
+	for _, row := range rowsByKey {
+		rows = append(rows, *row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Language != rows[j].Language {
+			return rows[i].Language < rows[j].Language
+		}
+		return rows[i].Path < rows[j].Path
+	})
+	return rows, nil
+}
+
 func getFilesAtCommit(commitHash string) ([]string, error) {
 	cmd := exec.Command("git", "ls-tree", "-r", "--name-only", commitHash)
 	output, err := cmd.Output()
@@ -311,6 +520,11 @@ func isTSTestPath(file string) bool {
 		strings.HasPrefix(file, "frontend/src/test/")
 }
 
+// isTSTestFile checks if a file name is a TypeScript test file
+func isTSTestFile(base string) bool {
+	return strings.HasSuffix(base, ".test.ts") || strings.HasSuffix(base, ".test.tsx")
+}
+
 func countFileLines(commitHash, filepath string) (int, error) {
 	cmd := exec.Command("git", "show", fmt.Sprintf("%s:%s", commitHash, filepath))
 	output, err := cmd.Output()